@@ -1,6 +1,7 @@
 package api
 
 import (
+	"log"
 	"net/http"
 
 	"github.com/nats-io/nats.go"
@@ -50,6 +51,12 @@ func (auth Auth) ServeHTTP(res http.ResponseWriter, req *http.Request) {
 		}
 	}
 
+	if token == "" {
+		log.Printf("Failed sign in for %v from %v\n", email, remoteIP(req))
+	} else {
+		log.Printf("Sign in: %v from %v\n", email, remoteIP(req))
+	}
+
 	encode(res, data.Auth{
 		Token: token,
 		Email: email,
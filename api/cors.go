@@ -0,0 +1,54 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+)
+
+// corsMethods and corsHeaders are allowed in cross origin requests, and
+// corsExposed are the response headers scripts can read
+const (
+	corsMethods = "GET, POST, PUT, DELETE, OPTIONS"
+	corsHeaders = "Authorization, Content-Type, If-Match, If-None-Match"
+	corsExposed = "ETag, Retry-After, X-History-Every, X-History-Limit"
+)
+
+// NewCORSHandler allows browsers to make cross origin requests from the
+// listed origins, for example https://dashboard.example.com. The origin *
+// allows all origins. Requests are authorized with the Authorization header,
+// so credentials (cookies) are not allowed.
+func NewCORSHandler(origins []string, next http.Handler) http.Handler {
+	if len(origins) < 1 {
+		return next
+	}
+
+	allowed := make(map[string]bool, len(origins))
+	for _, o := range origins {
+		allowed[strings.TrimSuffix(o, "/")] = true
+	}
+
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		origin := req.Header.Get("Origin")
+		if origin == "" || !(allowed["*"] || allowed[origin]) {
+			next.ServeHTTP(res, req)
+			return
+		}
+
+		h := res.Header()
+		h.Add("Vary", "Origin")
+		h.Set("Access-Control-Allow-Origin", origin)
+		h.Set("Access-Control-Expose-Headers", corsExposed)
+
+		if req.Method == http.MethodOptions &&
+			req.Header.Get("Access-Control-Request-Method") != "" {
+			// preflight request
+			h.Set("Access-Control-Allow-Methods", corsMethods)
+			h.Set("Access-Control-Allow-Headers", corsHeaders)
+			h.Set("Access-Control-Max-Age", "600")
+			res.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		next.ServeHTTP(res, req)
+	})
+}
@@ -0,0 +1,48 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCORS(t *testing.T) {
+	h := NewCORSHandler([]string{"https://dash.example.com"}, http.HandlerFunc(
+		func(res http.ResponseWriter, req *http.Request) {
+			res.Write([]byte("ok"))
+		}))
+
+	do := func(method, origin string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/v1/nodes", nil)
+		if origin != "" {
+			req.Header.Set("Origin", origin)
+		}
+		if method == http.MethodOptions {
+			req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+		}
+		res := httptest.NewRecorder()
+		h.ServeHTTP(res, req)
+		return res
+	}
+
+	res := do(http.MethodGet, "https://dash.example.com")
+	if res.Header().Get("Access-Control-Allow-Origin") != "https://dash.example.com" ||
+		res.Body.String() != "ok" {
+		t.Error("allowed origin not allowed: ", res.Header())
+	}
+
+	res = do(http.MethodOptions, "https://dash.example.com")
+	if res.Code != http.StatusNoContent || res.Header().Get("Access-Control-Allow-Methods") == "" {
+		t.Error("preflight not handled: ", res.Code, res.Header())
+	}
+
+	res = do(http.MethodGet, "https://evil.example.com")
+	if res.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Error("other origin allowed")
+	}
+
+	res = do(http.MethodGet, "")
+	if res.Header().Get("Access-Control-Allow-Origin") != "" || res.Body.String() != "ok" {
+		t.Error("same origin request changed")
+	}
+}
@@ -0,0 +1,35 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/simpleiot/simpleiot/client"
+)
+
+// Health handles /healthz requests. It returns 200 if NATS is connected
+// and the store is responsive, otherwise 503 with the reason.
+type Health struct {
+	nc *nats.Conn
+}
+
+// NewHealthHandler returns a new health handler
+func NewHealthHandler(nc *nats.Conn) http.Handler {
+	return &Health{nc: nc}
+}
+
+func (h *Health) ServeHTTP(res http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		http.Error(res, "invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	err := client.HealthCheck(h.nc, 2*time.Second)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	res.Write([]byte("OK\n"))
+}
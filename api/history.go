@@ -0,0 +1,337 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/simpleiot/simpleiot/client"
+	"github.com/simpleiot/simpleiot/data"
+)
+
+// HistoryLimits limits the history queries made through the HTTP API, so a
+// browser zooming a chart can't overload the history databases. 0 disables
+// a limit.
+type HistoryLimits struct {
+	// Rate is the number of queries per second allowed for each user, with
+	// bursts of up to Burst queries. Requests that use the auth token are
+	// not rate limited.
+	Rate  float64
+	Burst int
+	// MaxRange is the longest time range that can be queried
+	MaxRange time.Duration
+	// MaxPoints is the max number of points returned by a query. Aggregated
+	// queries use a longer window if needed so they return at most
+	// MaxPoints points.
+	MaxPoints int
+	// MaxConcurrent is the number of queries that can run at the same time.
+	// More queries are rejected until one finishes.
+	MaxConcurrent int
+	// CacheTime is how long query results are cached
+	CacheTime time.Duration
+}
+
+// DefaultHistoryLimits returns the default history query limits
+func DefaultHistoryLimits() HistoryLimits {
+	return HistoryLimits{
+		Rate:          5,
+		Burst:         20,
+		MaxRange:      366 * 24 * time.Hour,
+		MaxPoints:     5000,
+		MaxConcurrent: 8,
+		CacheTime:     10 * time.Second,
+	}
+}
+
+// historyCacheMax is the max number of cached query results
+var historyCacheMax = 1000
+
+type historyCacheEntry struct {
+	points  data.Points
+	expires time.Time
+}
+
+// historyCall is a query in progress. Identical queries wait for it instead
+// of querying again.
+type historyCall struct {
+	done   chan struct{}
+	points data.Points
+	err    error
+}
+
+// historyProxy runs history queries for the HTTP API. It enforces the
+// HistoryLimits, caches results, and runs identical queries that arrive at
+// the same time only once.
+type historyProxy struct {
+	limits   HistoryLimits
+	query    func(client.HistoryQuery) (data.Points, error)
+	limiters *keyLimiters
+
+	lock    sync.Mutex
+	cache   map[client.HistoryQuery]historyCacheEntry
+	calls   map[client.HistoryQuery]*historyCall
+	running int
+}
+
+func newHistoryProxy(limits HistoryLimits, nc *nats.Conn) *historyProxy {
+	return &historyProxy{
+		limits: limits,
+		query: func(q client.HistoryQuery) (data.Points, error) {
+			return client.QueryHistory(nc, q)
+		},
+		limiters: newKeyLimiters(limits.Rate, limits.Burst),
+		cache:    make(map[client.HistoryQuery]historyCacheEntry),
+		calls:    make(map[client.HistoryQuery]*historyCall),
+	}
+}
+
+// errHistoryLimit is returned when a query is rejected by a limit. Status
+// is the HTTP status and RetryAfter is in seconds, if set.
+type errHistoryLimit struct {
+	msg        string
+	status     int
+	retryAfter int
+}
+
+func (e errHistoryLimit) Error() string {
+	return e.msg
+}
+
+// allow checks the rate limit of a user
+func (hp *historyProxy) allow(userID string, now time.Time) error {
+	if d := hp.limiters.allow(userID, now); d > 0 {
+		return errHistoryLimit{
+			msg:        "too many history queries",
+			status:     http.StatusTooManyRequests,
+			retryAfter: int(math.Ceil(d.Seconds())),
+		}
+	}
+
+	return nil
+}
+
+// normalize enforces the range and resolution limits. The start and end of
+// aggregated queries are aligned to the window, so queries for the same
+// window can be cached.
+func (hp *historyProxy) normalize(q client.HistoryQuery) (client.HistoryQuery, error) {
+	if !q.Start.Before(q.End) {
+		return q, errHistoryLimit{msg: "start must be before end",
+			status: http.StatusBadRequest}
+	}
+
+	r := q.End.Sub(q.Start)
+
+	if hp.limits.MaxRange > 0 && r > hp.limits.MaxRange {
+		return q, errHistoryLimit{
+			msg:    fmt.Sprintf("history range is longer than the max of %v", hp.limits.MaxRange),
+			status: http.StatusBadRequest,
+		}
+	}
+
+	if hp.limits.MaxPoints > 0 {
+		if q.Limit <= 0 || q.Limit > hp.limits.MaxPoints {
+			q.Limit = hp.limits.MaxPoints
+		}
+
+		if q.Every > 0 {
+			min := r.Seconds() / float64(hp.limits.MaxPoints)
+			if q.Every < min {
+				q.Every = math.Ceil(min)
+			}
+		}
+	}
+
+	if q.Every > 0 {
+		every := time.Duration(q.Every * float64(time.Second))
+		if every > 0 {
+			q.Start = q.Start.Truncate(every)
+			if end := q.End.Truncate(every); !end.Equal(q.End) {
+				q.End = end.Add(every)
+			}
+		}
+	}
+
+	q.Start = q.Start.UTC()
+	q.End = q.End.UTC()
+
+	return q, nil
+}
+
+// run returns the result of a query from the cache, from an identical query
+// in progress, or by running it
+func (hp *historyProxy) run(q client.HistoryQuery, now time.Time) (data.Points, error) {
+	hp.lock.Lock()
+
+	if e, ok := hp.cache[q]; ok {
+		if now.Before(e.expires) {
+			hp.lock.Unlock()
+			return e.points, nil
+		}
+		delete(hp.cache, q)
+	}
+
+	if c, ok := hp.calls[q]; ok {
+		hp.lock.Unlock()
+		<-c.done
+		return c.points, c.err
+	}
+
+	if hp.limits.MaxConcurrent > 0 && hp.running >= hp.limits.MaxConcurrent {
+		hp.lock.Unlock()
+		return nil, errHistoryLimit{
+			msg:        "history databases are busy",
+			status:     http.StatusServiceUnavailable,
+			retryAfter: 1,
+		}
+	}
+
+	c := &historyCall{done: make(chan struct{})}
+	hp.calls[q] = c
+	hp.running++
+	hp.lock.Unlock()
+
+	c.points, c.err = hp.query(q)
+
+	hp.lock.Lock()
+	hp.running--
+	delete(hp.calls, q)
+	if c.err == nil && hp.limits.CacheTime > 0 {
+		hp.cacheAdd(q, c.points, now.Add(hp.limits.CacheTime))
+	}
+	hp.lock.Unlock()
+
+	close(c.done)
+
+	return c.points, c.err
+}
+
+// cacheAdd adds a query result to the cache. Must be called with the lock
+// held.
+func (hp *historyProxy) cacheAdd(q client.HistoryQuery, points data.Points, expires time.Time) {
+	if len(hp.cache) >= historyCacheMax {
+		now := time.Now()
+		for k, e := range hp.cache {
+			if now.After(e.expires) {
+				delete(hp.cache, k)
+			}
+		}
+
+		// remove any entry if all are current
+		for k := range hp.cache {
+			if len(hp.cache) < historyCacheMax {
+				break
+			}
+			delete(hp.cache, k)
+		}
+	}
+
+	hp.cache[q] = historyCacheEntry{points: points, expires: expires}
+}
+
+// Query runs a history query for a user with the limits applied. The
+// query that was run is returned, as the limits may change the window and
+// limit.
+func (hp *historyProxy) Query(userID string, q client.HistoryQuery) (client.HistoryQuery, data.Points, error) {
+	now := time.Now()
+
+	q, err := hp.normalize(q)
+	if err != nil {
+		return q, nil, err
+	}
+
+	if userID != "" {
+		err := hp.allow(userID, now)
+		if err != nil {
+			return q, nil, err
+		}
+	}
+
+	points, err := hp.run(q, now)
+	return q, points, err
+}
+
+// processHistoryQuery queries point history. start and end are RFC3339
+// times, the default is the last 24 hours. every aggregates values into
+// windows of every seconds. Queries are limited by the HistoryLimits.
+func (h *Nodes) processHistoryQuery(res http.ResponseWriter, req *http.Request, id, userID string) {
+	v := req.URL.Query()
+
+	q := client.HistoryQuery{NodeID: id, Type: v.Get("type"), Key: v.Get("key"),
+		End: time.Now().Truncate(time.Second)}
+
+	var err error
+
+	if e := v.Get("end"); e != "" {
+		q.End, err = time.Parse(time.RFC3339, e)
+		if err != nil {
+			http.Error(res, "invalid end: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	q.Start = q.End.Add(-24 * time.Hour)
+
+	if s := v.Get("start"); s != "" {
+		q.Start, err = time.Parse(time.RFC3339, s)
+		if err != nil {
+			http.Error(res, "invalid start: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	if l := v.Get("limit"); l != "" {
+		q.Limit, err = strconv.Atoi(l)
+		if err != nil {
+			http.Error(res, "invalid limit: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	if e := v.Get("every"); e != "" {
+		q.Every, err = strconv.ParseFloat(e, 64)
+		if err != nil || q.Every < 0 {
+			http.Error(res, "invalid every: "+e, http.StatusBadRequest)
+			return
+		}
+	}
+
+	if q.Type == "" {
+		http.Error(res, "type must be set", http.StatusBadRequest)
+		return
+	}
+
+	q, points, err := h.history.Query(userID, q)
+	if err != nil {
+		status := http.StatusBadRequest
+		if err == client.ErrNoHistory {
+			status = http.StatusNotFound
+		} else if l, ok := err.(errHistoryLimit); ok {
+			status = l.status
+			if l.retryAfter > 0 {
+				res.Header().Set("Retry-After", strconv.Itoa(l.retryAfter))
+			}
+		}
+		http.Error(res, err.Error(), status)
+		return
+	}
+
+	// the window and limit may have been changed by the limits
+	if q.Every > 0 {
+		res.Header().Set("X-History-Every", strconv.FormatFloat(q.Every, 'f', -1, 64))
+	}
+	if q.Limit > 0 {
+		res.Header().Set("X-History-Limit", strconv.Itoa(q.Limit))
+	}
+
+	if len(points) > 0 {
+		en := json.NewEncoder(res)
+		en.Encode(points)
+	} else {
+		res.Write([]byte("[]"))
+	}
+}
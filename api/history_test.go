@@ -0,0 +1,139 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/simpleiot/simpleiot/client"
+	"github.com/simpleiot/simpleiot/data"
+)
+
+func TestHistoryProxy(t *testing.T) {
+	var lock sync.Mutex
+	var queries []client.HistoryQuery
+	block := make(chan struct{})
+	blocking := false
+
+	hp := newHistoryProxy(HistoryLimits{Rate: 1, Burst: 3, MaxRange: 48 * time.Hour,
+		MaxPoints: 100, MaxConcurrent: 1, CacheTime: time.Minute}, nil)
+
+	hp.query = func(q client.HistoryQuery) (data.Points, error) {
+		lock.Lock()
+		queries = append(queries, q)
+		b := blocking
+		lock.Unlock()
+		if b {
+			<-block
+		}
+		return data.Points{{Type: q.Type, Value: 1}}, nil
+	}
+
+	end := time.Date(2026, 5, 2, 12, 0, 30, 0, time.UTC)
+	q := client.HistoryQuery{NodeID: "n1", Type: "value", Start: end.Add(-24 * time.Hour),
+		End: end, Every: 1}
+
+	ran, points, err := hp.Query("u1", q)
+	if err != nil || len(points) != 1 {
+		t.Fatal("Error querying: ", err)
+	}
+
+	// 24h / 100 points is 864s
+	if ran.Every != 864 || ran.Limit != 100 {
+		t.Errorf("resolution not enforced: %+v", ran)
+	}
+
+	if !ran.Start.Equal(q.Start.Truncate(864*time.Second)) || !ran.End.After(end) {
+		t.Errorf("range not aligned to window: %v - %v", ran.Start, ran.End)
+	}
+
+	// same window is cached
+	q2 := q
+	q2.End = end.Add(time.Second)
+	q2.Start = q2.Start.Add(time.Second)
+	_, _, err = hp.Query("u1", q2)
+	if err != nil {
+		t.Fatal("Error querying: ", err)
+	}
+
+	if len(queries) != 1 {
+		t.Error("query was not cached: ", len(queries))
+	}
+
+	_, _, err = hp.Query("u1", client.HistoryQuery{NodeID: "n1", Type: "value",
+		Start: end.Add(-72 * time.Hour), End: end})
+	var l errHistoryLimit
+	if !errors.As(err, &l) || l.status != http.StatusBadRequest {
+		t.Error("range limit not enforced: ", err)
+	}
+
+	// burst of 3 is used up
+	_, _, err = hp.Query("u1", q)
+	if err != nil {
+		t.Fatal("Error querying: ", err)
+	}
+
+	_, _, err = hp.Query("u1", q)
+	if !errors.As(err, &l) || l.status != http.StatusTooManyRequests || l.retryAfter < 1 {
+		t.Error("rate limit not enforced: ", err)
+	}
+
+	// other users and the auth token are not limited by u1
+	if _, _, err := hp.Query("u2", q); err != nil {
+		t.Error("u2 was rate limited: ", err)
+	}
+
+	if _, _, err := hp.Query("", q); err != nil {
+		t.Error("auth token was rate limited: ", err)
+	}
+
+	// identical queries in progress are run once, others are rejected
+	// while the max are running
+	lock.Lock()
+	blocking = true
+	queries = nil
+	lock.Unlock()
+
+	raw := client.HistoryQuery{NodeID: "n2", Type: "value", Start: end.Add(-time.Hour),
+		End: end}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, _, err := hp.Query("", raw); err != nil {
+				t.Error("Error querying: ", err)
+			}
+		}()
+	}
+
+	for start := time.Now(); ; {
+		lock.Lock()
+		n := len(queries)
+		lock.Unlock()
+		if n > 0 {
+			break
+		}
+		if time.Since(start) > time.Second {
+			t.Fatal("query did not run")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	other := raw
+	other.NodeID = "n3"
+	_, _, err = hp.Query("", other)
+	if !errors.As(err, &l) || l.status != http.StatusServiceUnavailable {
+		t.Error("concurrency limit not enforced: ", err)
+	}
+
+	close(block)
+	wg.Wait()
+
+	if len(queries) != 1 {
+		t.Error("identical queries were not combined: ", len(queries))
+	}
+}
@@ -0,0 +1,84 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/nats-io/nats.go"
+	"github.com/simpleiot/simpleiot/client"
+	"github.com/simpleiot/simpleiot/data"
+)
+
+// Locations handles /v1/locations requests. It returns the location and
+// status of all nodes with a latitude and longitude that the user has
+// access to.
+type Locations struct {
+	check     RequestValidator
+	nc        *nats.Conn
+	authToken string
+}
+
+// NewLocationsHandler returns a new locations handler
+func NewLocationsHandler(v RequestValidator, authToken string,
+	nc *nats.Conn) http.Handler {
+	return &Locations{v, nc, authToken}
+}
+
+// getAllNodes returns all nodes in the tree below root
+func (h *Locations) getAllNodes() ([]data.NodeEdge, error) {
+	root, err := client.GetNode(h.nc, "root", "")
+	if err != nil {
+		return nil, err
+	}
+
+	if len(root) < 1 {
+		return nil, nil
+	}
+
+	children, err := client.GetNodeChildren(h.nc, root[0].ID, "", false, true)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(root, children...), nil
+}
+
+func (h *Locations) ServeHTTP(res http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(res, "invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var nodes []data.NodeEdge
+	var err error
+
+	if h.authToken != "" && req.Header.Get("Authorization") == h.authToken {
+		nodes, err = h.getAllNodes()
+	} else {
+		validUser, userID := h.check.Valid(req)
+		if !validUser {
+			http.Error(res, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		nodes, err = client.GetNodesForUser(h.nc, userID)
+	}
+
+	if err != nil {
+		log.Println("Error getting nodes for locations: ", err)
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	ret := []data.NodeLocation{}
+
+	for _, n := range data.RemoveDuplicateNodesID(nodes) {
+		if l, ok := n.Location(); ok {
+			ret = append(ret, l)
+		}
+	}
+
+	en := json.NewEncoder(res)
+	en.Encode(ret)
+}
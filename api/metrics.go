@@ -0,0 +1,52 @@
+package api
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Metrics handles /metrics requests with metrics in the Prometheus text
+// format. If an auth token is configured, it must be sent in the
+// Authorization header, either by itself or as a bearer token.
+type Metrics struct {
+	write     func(io.Writer) error
+	authToken string
+}
+
+// NewMetricsHandler returns a new metrics handler. write is called to write
+// the metrics for each request.
+func NewMetricsHandler(write func(io.Writer) error, authToken string) http.Handler {
+	return &Metrics{write: write, authToken: authToken}
+}
+
+func (h *Metrics) ServeHTTP(res http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		http.Error(res, "invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if h.authToken != "" {
+		auth := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+		if auth != h.authToken {
+			http.Error(res, "invalid token", http.StatusUnauthorized)
+			return
+		}
+	}
+
+	if h.write == nil {
+		http.Error(res, "Not Found", http.StatusNotFound)
+		return
+	}
+
+	var buf bytes.Buffer
+	err := h.write(&buf)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	res.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	res.Write(buf.Bytes())
+}
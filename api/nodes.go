@@ -1,10 +1,17 @@
 package api
 
 import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"io/ioutil"
 	"log"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/nats-io/nats.go"
@@ -26,22 +33,50 @@ type NodeCopy struct {
 	Duplicate bool
 }
 
+// NodeEdgePoints is a data structure used in the /node/:id/edge api call
+type NodeEdgePoints struct {
+	Parent string
+	Points data.Points
+}
+
+// NodeReorder is a data structure used in the /node/:id/reorder api call
+type NodeReorder struct {
+	Children []string
+}
+
 // NodeDelete is a data structure used with /node/:id DELETE call
 type NodeDelete struct {
 	Parent string
 }
 
+// NodePurge is a data structure used with the /node/:id/purge api call.
+// Confirm must be set to the node ID.
+type NodePurge struct {
+	Parent  string
+	Confirm string
+}
+
+// NodeErase is a data structure used with the /node/:id/erase api call.
+// Confirm must be set to the node ID. Wait is how long in seconds to wait for
+// more sources to respond.
+type NodeErase struct {
+	Confirm string
+	Wait    float64
+}
+
 // Nodes handles node requests
 type Nodes struct {
 	check     RequestValidator
 	nc        *nats.Conn
 	authToken string
+	history   *historyProxy
 }
 
-// NewNodesHandler returns a new node handler
+// NewNodesHandler returns a new node handler. History queries are limited by
+// history.
 func NewNodesHandler(v RequestValidator, authToken string,
-	nc *nats.Conn) http.Handler {
-	return &Nodes{v, nc, authToken}
+	nc *nats.Conn, history HistoryLimits) http.Handler {
+	return &Nodes{v, nc, authToken, newHistoryProxy(history, nc)}
 }
 
 // Top level handler for http requests in the coap-server process
@@ -75,6 +110,11 @@ func (h *Nodes) ServeHTTP(res http.ResponseWriter, req *http.Request) {
 				return
 			}
 
+			if tags := req.URL.Query()["tag"]; len(tags) > 0 {
+				h.processTagQuery(res, tags, userID)
+				return
+			}
+
 			nodes, err := client.GetNodesForUser(h.nc, userID)
 			if err != nil {
 				log.Println("Error getting nodes for user: ", err)
@@ -84,9 +124,18 @@ func (h *Nodes) ServeHTTP(res http.ResponseWriter, req *http.Request) {
 				http.Error(res, err.Error(), http.StatusNotFound)
 				return
 			}
+
+			tag := nodesETag(nodes)
+			res.Header().Set("ETag", tag)
+			res.Header().Set("Cache-Control", "no-cache")
+			if notModified(req, tag) {
+				res.WriteHeader(http.StatusNotModified)
+				return
+			}
+
 			if len(nodes) > 0 {
 				en := json.NewEncoder(res)
-				en.Encode(nodes)
+				en.Encode(outputNodes(nodes))
 			} else {
 				res.Write([]byte("[]"))
 			}
@@ -115,8 +164,17 @@ func (h *Nodes) ServeHTTP(res http.ResponseWriter, req *http.Request) {
 			if err != nil {
 				http.Error(res, err.Error(), http.StatusNotFound)
 			} else {
+				if len(node) == 1 {
+					tag := etag(node[0].Points)
+					res.Header().Set("ETag", tag)
+					res.Header().Set("Cache-Control", "no-cache")
+					if notModified(req, tag) {
+						res.WriteHeader(http.StatusNotModified)
+						return
+					}
+				}
 				en := json.NewEncoder(res)
-				en.Encode(node)
+				en.Encode(outputNodes(node))
 			}
 		case http.MethodDelete:
 			var nodeDelete NodeDelete
@@ -148,6 +206,84 @@ func (h *Nodes) ServeHTTP(res http.ResponseWriter, req *http.Request) {
 		http.Error(res, "only POST allowed", http.StatusMethodNotAllowed)
 		return
 
+	case "recent":
+		if req.Method != http.MethodGet {
+			http.Error(res, "only GET allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		points, err := client.GetRecentPoints(h.nc, id, req.URL.Query().Get("type"),
+			req.URL.Query().Get("key"))
+		if err != nil {
+			http.Error(res, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		if len(points) > 0 {
+			en := json.NewEncoder(res)
+			en.Encode(points)
+		} else {
+			res.Write([]byte("[]"))
+		}
+
+	case "changes":
+		if req.Method != http.MethodGet {
+			http.Error(res, "only GET allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		v := req.URL.Query()
+		q := client.ChangesQuery{Type: v.Get("type"), Key: v.Get("key")}
+
+		if l := v.Get("limit"); l != "" {
+			var err error
+			q.Limit, err = strconv.Atoi(l)
+			if err != nil {
+				http.Error(res, "invalid limit: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+
+		changes, err := client.GetNodeChanges(h.nc, id, q)
+		if err != nil {
+			http.Error(res, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		if len(changes) > 0 {
+			encode(res, changes)
+		} else {
+			res.Write([]byte("[]"))
+		}
+
+	case "display":
+		if req.Method != http.MethodGet {
+			http.Error(res, "only GET allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		h.processDisplay(res, req, id, userID)
+
+	case "history":
+		switch req.Method {
+		case http.MethodPost:
+			h.processHistory(res, req, id, userID)
+		case http.MethodGet:
+			h.processHistoryQuery(res, req, id, userID)
+		default:
+			http.Error(res, "only GET and POST allowed", http.StatusMethodNotAllowed)
+		}
+		return
+
+	case "track":
+		if req.Method != http.MethodGet {
+			http.Error(res, "only GET allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		h.processTrackQuery(res, req, id)
+		return
+
 	case "parents":
 		switch req.Method {
 		case http.MethodPost:
@@ -201,6 +337,155 @@ func (h *Nodes) ServeHTTP(res http.ResponseWriter, req *http.Request) {
 			http.Error(res, "invalid method", http.StatusMethodNotAllowed)
 		}
 
+	case "edge":
+		if req.Method != http.MethodPost {
+			http.Error(res, "only POST allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var edge NodeEdgePoints
+		if err := decode(req.Body, &edge); err != nil {
+			http.Error(res, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if edge.Parent == "" {
+			http.Error(res, "parent must be set", http.StatusBadRequest)
+			return
+		}
+
+		for i := range edge.Points {
+			edge.Points[i].Origin = userID
+		}
+
+		err := client.SendEdgePoints(h.nc, id, edge.Parent, edge.Points, true)
+		if err != nil {
+			http.Error(res, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		encode(res, data.StandardResponse{Success: true, ID: id})
+
+	case "reorder":
+		if req.Method != http.MethodPost {
+			http.Error(res, "only POST allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var reorder NodeReorder
+		if err := decode(req.Body, &reorder); err != nil {
+			http.Error(res, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		err := client.ReorderChildren(h.nc, id, reorder.Children, userID)
+		if err != nil {
+			http.Error(res, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		encode(res, data.StandardResponse{Success: true, ID: id})
+
+	case "children":
+		if req.Method != http.MethodGet {
+			http.Error(res, "only GET allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		h.processChildren(res, req, id)
+
+	case "trash":
+		if req.Method != http.MethodGet {
+			http.Error(res, "only GET allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		nodes, err := client.GetTrash(h.nc, id)
+		if err != nil {
+			http.Error(res, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		if len(nodes) > 0 {
+			encode(res, outputNodes(nodes))
+		} else {
+			res.Write([]byte("[]"))
+		}
+
+	case "undelete":
+		if req.Method != http.MethodPost {
+			http.Error(res, "only POST allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var nodeDelete NodeDelete
+		if err := decode(req.Body, &nodeDelete); err != nil {
+			http.Error(res, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		err := client.UndeleteNode(h.nc, id, nodeDelete.Parent, userID)
+		if err != nil {
+			http.Error(res, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		encode(res, data.StandardResponse{Success: true, ID: id})
+
+	case "purge":
+		if req.Method != http.MethodPost {
+			http.Error(res, "only POST allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var nodePurge NodePurge
+		if err := decode(req.Body, &nodePurge); err != nil {
+			http.Error(res, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		err := client.PurgeNode(h.nc, id, nodePurge.Parent, nodePurge.Confirm)
+		if err != nil {
+			http.Error(res, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		encode(res, data.StandardResponse{Success: true, ID: id})
+
+	case "erase":
+		if req.Method != http.MethodPost {
+			http.Error(res, "only POST allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var nodeErase NodeErase
+		if err := decode(req.Body, &nodeErase); err != nil {
+			http.Error(res, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if nodeErase.Confirm != id {
+			http.Error(res, "confirm must be set to the node ID", http.StatusBadRequest)
+			return
+		}
+
+		reports, err := client.EraseNode(h.nc, client.EraseRequest{NodeID: id,
+			Wait: nodeErase.Wait})
+		ret := client.EraseResult{Reports: reports}
+		if err != nil {
+			if len(reports) == 0 {
+				http.Error(res, err.Error(), http.StatusBadRequest)
+				return
+			}
+			ret.Error = err.Error()
+			res.WriteHeader(http.StatusInternalServerError)
+		}
+
+		encode(res, ret)
+
+	case "share":
+		h.processShare(res, req, id, userID)
+
 	case "not":
 		switch req.Method {
 		case http.MethodPost:
@@ -281,8 +566,143 @@ func (h *Nodes) processPoints(res http.ResponseWriter, req *http.Request, id, us
 		//points[i].Time = time.Now()
 	}
 
-	err = client.SendNodePoints(h.nc, id, points, true)
+	// the store checks the version in its write transaction, so an edit
+	// that lands between the check and the write is not lost
+	ctx := context.Background()
+	match := req.Header.Get("If-Match")
+	if match != "" {
+		ctx = client.WithIfVersion(ctx, strings.Trim(match, `"`))
+	}
+
+	err = client.SendNodePointsCtx(ctx, h.nc, id, points, true)
+
+	if err != nil {
+		if match != "" && isNak(err, data.ErrVersionMismatch) {
+			nodes, err := client.GetNode(h.nc, id, "none")
+			if err == nil && len(nodes) > 0 {
+				res.Header().Set("ETag", etag(nodes[0].Points))
+			}
+			http.Error(res, "node was modified", http.StatusConflict)
+			return
+		}
+
+		if match != "" && isNak(err, data.ErrDocumentNotFound) {
+			http.Error(res, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	en := json.NewEncoder(res)
+	en.Encode(data.StandardResponse{Success: true, ID: id})
+}
+
+// outputNodes prepares nodes returned by the API. Secret points are masked,
+// as secrets are write only, so clients can set them but not read them
+// back. Points without an origin were generated by the node, so their
+// origin is set to the node ID to show the source of every point.
+func outputNodes(nodes []data.NodeEdge) []data.NodeEdge {
+	for i := range nodes {
+		nodes[i].Points = nodes[i].Points.MaskSecrets().FillOrigin(nodes[i].ID)
+		nodes[i].EdgePoints = nodes[i].EdgePoints.MaskSecrets()
+	}
+	return nodes
+}
+
+// isNak returns true if err is the store rejecting a request with target
+func isNak(err, target error) bool {
+	var nak *client.NakError
+	return errors.As(err, &nak) && nak.Msg == target.Error()
+}
+
+// etag returns the HTTP entity tag for a node with the given points
+func etag(points data.Points) string {
+	return `"` + points.Version() + `"`
+}
+
+// nodesETag returns the HTTP entity tag for a list of nodes, derived from
+// the node and edge point versions of each node
+func nodesETag(nodes []data.NodeEdge) string {
+	h := md5.New()
+	for _, n := range nodes {
+		for _, s := range []string{n.ID, n.Parent, n.Points.Version(),
+			n.EdgePoints.Version()} {
+			h.Write([]byte(s))
+			h.Write([]byte{0})
+		}
+	}
+	return `"` + hex.EncodeToString(h.Sum(nil)) + `"`
+}
+
+// notModified returns true if the If-None-Match header of the request
+// matches tag
+func notModified(req *http.Request, tag string) bool {
+	match := req.Header.Get("If-None-Match")
+	if match == "" {
+		return false
+	}
+
+	for _, m := range strings.Split(match, ",") {
+		m = strings.TrimPrefix(strings.TrimSpace(m), "W/")
+		if m == "*" || m == tag {
+			return true
+		}
+	}
+
+	return false
+}
+
+// processChildren returns the children of a node. A request with an
+// If-None-Match header that matches the ETag of the children gets a 304
+// (Not Modified) response.
+func (h *Nodes) processChildren(res http.ResponseWriter, req *http.Request, id string) {
+	query := req.URL.Query()
+	typ := query.Get("type")
+	includeDel := query.Get("deleted") == "true"
+	recursive := query.Get("recursive") == "true"
+
+	children, err := client.GetNodeChildren(h.nc, id, typ, includeDel, recursive)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	tag := nodesETag(children)
+	res.Header().Set("ETag", tag)
+	res.Header().Set("Cache-Control", "no-cache")
 
+	if notModified(req, tag) {
+		res.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	if len(children) > 0 {
+		encode(res, outputNodes(children))
+	} else {
+		res.Write([]byte("[]"))
+	}
+}
+
+// processHistory backfills historical points for a node. The points are
+// written to history only and are not processed by rules.
+func (h *Nodes) processHistory(res http.ResponseWriter, req *http.Request, id, userID string) {
+	decoder := json.NewDecoder(req.Body)
+	var points data.Points
+	err := decoder.Decode(&points)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	for i := range points {
+		if points[i].Origin == "" {
+			points[i].Origin = userID
+		}
+	}
+
+	err = client.SendHistoryPoints(h.nc, id, points, nil)
 	if err != nil {
 		http.Error(res, err.Error(), http.StatusBadRequest)
 		return
@@ -291,3 +711,164 @@ func (h *Nodes) processPoints(res http.ResponseWriter, req *http.Request, id, us
 	en := json.NewEncoder(res)
 	en.Encode(data.StandardResponse{Success: true, ID: id})
 }
+
+// processTrackQuery queries the positions and trips of a tracker node. start
+// and end are RFC3339 times, the default is the last 24 hours.
+func (h *Nodes) processTrackQuery(res http.ResponseWriter, req *http.Request, id string) {
+	v := req.URL.Query()
+	end := time.Now()
+
+	var err error
+
+	if e := v.Get("end"); e != "" {
+		end, err = time.Parse(time.RFC3339, e)
+		if err != nil {
+			http.Error(res, "invalid end: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	start := end.Add(-24 * time.Hour)
+
+	if s := v.Get("start"); s != "" {
+		start, err = time.Parse(time.RFC3339, s)
+		if err != nil {
+			http.Error(res, "invalid start: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	ret, err := client.QueryTrack(h.nc, id, start, end)
+	if err != nil {
+		status := http.StatusBadRequest
+		if err == nats.ErrNoResponders {
+			status = http.StatusNotFound
+		}
+		http.Error(res, err.Error(), status)
+		return
+	}
+
+	en := json.NewEncoder(res)
+	en.Encode(ret)
+}
+
+// processTagQuery returns the nodes the user has access to that have all of
+// the tags. Each tag is a name or name=value.
+func (h *Nodes) processTagQuery(res http.ResponseWriter, tags []string, userID string) {
+	query := make(map[string]string)
+	for _, t := range tags {
+		name, value := t, ""
+		if i := strings.Index(t, "="); i >= 0 {
+			name, value = t[:i], t[i+1:]
+		}
+		if name == "" {
+			http.Error(res, "invalid tag: "+t, http.StatusBadRequest)
+			return
+		}
+		query[name] = value
+	}
+
+	nodes, err := client.GetNodesByTag(h.nc, query)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	userNodes, err := client.GetNodesForUser(h.nc, userID)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	access := make(map[string]bool)
+	for _, n := range userNodes {
+		access[n.ID] = true
+	}
+
+	var ret []data.NodeEdge
+	for _, n := range nodes {
+		if access[n.ID] {
+			ret = append(ret, n)
+		}
+	}
+
+	if len(ret) > 0 {
+		en := json.NewEncoder(res)
+		en.Encode(outputNodes(ret))
+	} else {
+		res.Write([]byte("[]"))
+	}
+}
+
+// displayFormat returns the display format for a request. The format is set
+// by the locale and unitSystem points of the user node, and can be
+// overridden with the locale and units query parameters.
+func (h *Nodes) displayFormat(req *http.Request, userID string) data.DisplayFormat {
+	ret := data.DefaultDisplayFormat
+
+	if userID != "" {
+		users, err := client.GetNode(h.nc, userID, "none")
+		if err == nil && len(users) > 0 {
+			ret = users[0].ToNode().DisplayFormat()
+		}
+	}
+
+	v := req.URL.Query()
+	if l := v.Get("locale"); l != "" {
+		ret.Locale = l
+	}
+
+	if u := v.Get("units"); u != "" {
+		ret.UnitSystem = u
+	}
+
+	return ret
+}
+
+// processDisplay returns the points of a node converted and formatted for
+// the requesting user. Optional type and key query parameters select points
+// and decimals sets the maximum number of decimals (default is 2).
+func (h *Nodes) processDisplay(res http.ResponseWriter, req *http.Request, id, userID string) {
+	v := req.URL.Query()
+
+	decimals := 2
+	if d := v.Get("decimals"); d != "" {
+		var err error
+		decimals, err = strconv.Atoi(d)
+		if err != nil || decimals < 0 {
+			http.Error(res, "invalid decimals", http.StatusBadRequest)
+			return
+		}
+	}
+
+	nodes, err := client.GetNode(h.nc, id, "none")
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	if len(nodes) < 1 {
+		http.Error(res, data.ErrDocumentNotFound.Error(), http.StatusNotFound)
+		return
+	}
+
+	format := h.displayFormat(req, userID)
+	units, _ := nodes[0].Points.Text(data.PointTypeUnits, "")
+	typ, key := v.Get("type"), v.Get("key")
+
+	ret := []data.DisplayValue{}
+	for _, p := range nodes[0].Points {
+		if typ != "" && p.Type != typ {
+			continue
+		}
+
+		if v.Has("key") && p.Key != key {
+			continue
+		}
+
+		ret = append(ret, format.Display(p, units, decimals))
+	}
+
+	en := json.NewEncoder(res)
+	en.Encode(ret)
+}
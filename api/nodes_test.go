@@ -0,0 +1,83 @@
+package api
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/simpleiot/simpleiot/data"
+)
+
+func TestNotModified(t *testing.T) {
+	now := time.Now()
+	children := []data.NodeEdge{
+		{ID: "a", Points: data.Points{{Type: "value", Value: 1, Time: now}}},
+		{ID: "b", Points: data.Points{{Type: "value", Value: 2, Time: now}}},
+	}
+
+	tag := nodesETag(children)
+
+	if nodesETag(children) != tag {
+		t.Fatal("tag changed for same nodes")
+	}
+
+	children[1].Points[0].Value = 3
+	if nodesETag(children) == tag {
+		t.Fatal("tag did not change with child point")
+	}
+
+	children[1].Points[0].Value = 2
+	children[1].EdgePoints = data.Points{{Type: data.PointTypeTombstone, Value: 1}}
+	if nodesETag(children) == tag {
+		t.Fatal("tag did not change with child edge point")
+	}
+
+	if nodesETag(children[:1]) == tag {
+		t.Fatal("tag did not change when child was removed")
+	}
+
+	tests := []struct {
+		match string
+		exp   bool
+	}{
+		{"", false},
+		{tag, true},
+		{"W/" + tag, true},
+		{`"other", ` + tag, true},
+		{"*", true},
+		{`"other"`, false},
+	}
+
+	for _, test := range tests {
+		req := httptest.NewRequest("GET", "/", nil)
+		if test.match != "" {
+			req.Header.Set("If-None-Match", test.match)
+		}
+		if notModified(req, tag) != test.exp {
+			t.Errorf("If-None-Match %v: expected %v", test.match, test.exp)
+		}
+	}
+}
+
+func TestOutputNodes(t *testing.T) {
+	nodes := outputNodes([]data.NodeEdge{{ID: "n1", Points: data.Points{
+		{Type: data.PointTypeValue, Value: 1},
+		{Type: data.PointTypeDescription, Text: "desc", Origin: "user"},
+		{Type: data.PointTypePassword, Text: "secret"},
+	}}})
+
+	p, _ := nodes[0].Points.Find(data.PointTypeValue, "")
+	if p.Origin != "n1" {
+		t.Error("origin not populated with node ID: ", p)
+	}
+
+	p, _ = nodes[0].Points.Find(data.PointTypeDescription, "")
+	if p.Origin != "user" {
+		t.Error("origin changed: ", p)
+	}
+
+	p, _ = nodes[0].Points.Find(data.PointTypePassword, "")
+	if p.Text != data.SecretMask {
+		t.Error("secret was not masked: ", p)
+	}
+}
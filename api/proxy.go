@@ -0,0 +1,129 @@
+package api
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// ParseTrustedProxies parses a list of IP addresses and CIDR networks of
+// trusted reverse proxies
+func ParseTrustedProxies(proxies []string) ([]*net.IPNet, error) {
+	var ret []*net.IPNet
+
+	for _, p := range proxies {
+		if !strings.Contains(p, "/") {
+			ip := net.ParseIP(p)
+			if ip == nil {
+				return nil, fmt.Errorf("not a valid IP address: %q", p)
+			}
+
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 8 * net.IPv4len
+			}
+
+			ret = append(ret, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, n, err := net.ParseCIDR(p)
+		if err != nil {
+			return nil, fmt.Errorf("not a valid network: %q", p)
+		}
+
+		ret = append(ret, n)
+	}
+
+	return ret, nil
+}
+
+func trusted(proxies []*net.IPNet, addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+
+	for _, p := range proxies {
+		if p.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// forwardedFor returns the client IP of a request. If the request is from a
+// trusted proxy, the X-Forwarded-For header is read from right to left, and
+// the first address that is not a trusted proxy is the client.
+func forwardedFor(proxies []*net.IPNet, req *http.Request) string {
+	ip := remoteIP(req)
+	if !trusted(proxies, ip) {
+		return ip
+	}
+
+	var hops []string
+	for _, h := range req.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(h, ",")...)
+	}
+
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if net.ParseIP(hop) == nil {
+			// the header was not set by a proxy we trust
+			break
+		}
+
+		ip = hop
+		if !trusted(proxies, hop) {
+			break
+		}
+	}
+
+	return ip
+}
+
+// NewForwardedHandler sets the RemoteAddr of requests from trusted proxies
+// to the client IP in the X-Forwarded-For header, so logs and rate limits
+// use the address of the client instead of the proxy.
+func NewForwardedHandler(proxies []*net.IPNet, next http.Handler) http.Handler {
+	if len(proxies) < 1 {
+		return next
+	}
+
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		ip := forwardedFor(proxies, req)
+		if ip != remoteIP(req) {
+			_, port, _ := net.SplitHostPort(req.RemoteAddr)
+			req.RemoteAddr = net.JoinHostPort(ip, port)
+		}
+
+		next.ServeHTTP(res, req)
+	})
+}
+
+// NewBasePathHandler strips the base path from requests, so SIOT can be
+// served at a subpath by a reverse proxy, for example /siot. Requests
+// without the base path are served as is, for proxies that strip it
+// themselves.
+func NewBasePathHandler(basePath string, next http.Handler) http.Handler {
+	basePath = strings.TrimSuffix(basePath, "/")
+	if basePath == "" {
+		return next
+	}
+
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		switch {
+		case req.URL.Path == basePath:
+			http.Redirect(res, req, basePath+"/", http.StatusMovedPermanently)
+			return
+		case strings.HasPrefix(req.URL.Path, basePath+"/"):
+			req.URL.Path = strings.TrimPrefix(req.URL.Path, basePath)
+			req.URL.RawPath = ""
+		}
+
+		next.ServeHTTP(res, req)
+	})
+}
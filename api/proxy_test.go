@@ -0,0 +1,89 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestForwardedFor(t *testing.T) {
+	proxies, err := ParseTrustedProxies([]string{"10.0.0.1", "172.16.0.0/12"})
+	if err != nil {
+		t.Fatal("Error parsing proxies: ", err)
+	}
+
+	if _, err := ParseTrustedProxies([]string{"10.0.0"}); err == nil {
+		t.Error("invalid proxy address accepted")
+	}
+
+	tests := []struct {
+		remote string
+		xff    string
+		exp    string
+	}{
+		// not from a trusted proxy, header is ignored
+		{"192.168.1.5", "1.2.3.4", "192.168.1.5"},
+		{"10.0.0.1", "", "10.0.0.1"},
+		{"10.0.0.1", "1.2.3.4", "1.2.3.4"},
+		// the client can set the header, only trusted hops are skipped
+		{"10.0.0.1", "6.6.6.6, 1.2.3.4, 172.16.3.4", "1.2.3.4"},
+		{"10.0.0.1", "garbage, 172.16.3.4", "172.16.3.4"},
+	}
+
+	for _, test := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = test.remote + ":1234"
+		if test.xff != "" {
+			req.Header.Set("X-Forwarded-For", test.xff)
+		}
+
+		var got string
+		NewForwardedHandler(proxies, http.HandlerFunc(
+			func(res http.ResponseWriter, req *http.Request) {
+				got = remoteIP(req)
+			})).ServeHTTP(httptest.NewRecorder(), req)
+
+		if got != test.exp {
+			t.Errorf("%v, %v: expected %v, got %v", test.remote, test.xff, test.exp, got)
+		}
+	}
+}
+
+func TestBasePath(t *testing.T) {
+	index := []byte("<html>\n  <head>\n  </head>\n</html>")
+
+	app := &App{
+		IndexHandler: NewIndexHandler(func(string) []byte { return index }, "/siot"),
+		V1ApiHandler: http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+			res.Write([]byte("v1 " + req.URL.Path))
+		}),
+	}
+
+	h := NewBasePathHandler("/siot", app)
+
+	do := func(path string) *httptest.ResponseRecorder {
+		res := httptest.NewRecorder()
+		h.ServeHTTP(res, httptest.NewRequest(http.MethodGet, path, nil))
+		return res
+	}
+
+	if res := do("/siot/v1/nodes"); res.Body.String() != "v1 /nodes" {
+		t.Error("base path not stripped: ", res.Body.String())
+	}
+
+	// proxies that strip the base path
+	if res := do("/v1/nodes"); res.Body.String() != "v1 /nodes" {
+		t.Error("request without base path not served: ", res.Body.String())
+	}
+
+	if res := do("/siot"); res.Code != http.StatusMovedPermanently ||
+		res.Header().Get("Location") != "/siot/" {
+		t.Error("base path not redirected: ", res.Code)
+	}
+
+	res := do("/siot/")
+	if !strings.Contains(res.Body.String(), `<base href="/siot/" />`) {
+		t.Error("base not set in index: ", res.Body.String())
+	}
+}
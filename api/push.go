@@ -0,0 +1,97 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/nats-io/nats.go"
+	"github.com/simpleiot/simpleiot/client"
+	"github.com/simpleiot/simpleiot/data"
+)
+
+// PushKey is returned by GET /v1/push. The frontend subscribes to push
+// notifications with the public VAPID key of the server.
+type PushKey struct {
+	PublicKey string `json:"publicKey"`
+}
+
+// Push handles /v1/push requests. Browsers register their Web Push
+// subscription with the user that is signed in, and remove it when the user
+// signs out.
+type Push struct {
+	check RequestValidator
+	nc    *nats.Conn
+}
+
+// NewPushHandler returns a new push subscription handler
+func NewPushHandler(v RequestValidator, nc *nats.Conn) http.Handler {
+	return &Push{v, nc}
+}
+
+func (h *Push) ServeHTTP(res http.ResponseWriter, req *http.Request) {
+	// subscriptions belong to users, so the auth token can't be used here
+	validUser, userID := h.check.Valid(req)
+	if !validUser || userID == "" {
+		http.Error(res, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	switch req.Method {
+	case http.MethodGet:
+		nodes, err := client.GetNode(h.nc, "root", "")
+		if err != nil || len(nodes) < 1 {
+			http.Error(res, "Error getting root node", http.StatusInternalServerError)
+			return
+		}
+
+		key, _ := nodes[0].Points.Text(data.PointTypeVapidPublicKey, "")
+		if key == "" {
+			http.Error(res, "web push is not available", http.StatusNotFound)
+			return
+		}
+
+		encode(res, PushKey{PublicKey: key})
+
+	case http.MethodPost, http.MethodDelete:
+		var sub data.PushSubscription
+		if err := decode(req.Body, &sub); err != nil {
+			http.Error(res, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		var p data.Point
+
+		if req.Method == http.MethodPost {
+			if err := sub.Validate(); err != nil {
+				http.Error(res, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			var err error
+			p, err = sub.ToPoint()
+			if err != nil {
+				http.Error(res, err.Error(), http.StatusBadRequest)
+				return
+			}
+		} else {
+			if sub.Endpoint == "" {
+				http.Error(res, "endpoint must be set", http.StatusBadRequest)
+				return
+			}
+
+			p = data.Point{Type: data.PointTypePushSubscription, Key: sub.Key(), Tombstone: 1}
+		}
+
+		p.Origin = userID
+
+		err := client.SendNodePoint(h.nc, userID, p, true)
+		if err != nil {
+			http.Error(res, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		encode(res, data.StandardResponse{Success: true, ID: userID})
+
+	default:
+		http.Error(res, "invalid method", http.StatusMethodNotAllowed)
+	}
+}
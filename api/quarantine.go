@@ -0,0 +1,90 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/nats-io/nats.go"
+	"github.com/simpleiot/simpleiot/client"
+)
+
+// Quarantine handles /v1/quarantine requests. Quarantined points can be
+// from any node, so requests must be authenticated with the auth token.
+type Quarantine struct {
+	nc        *nats.Conn
+	authToken string
+}
+
+// NewQuarantineHandler returns a new quarantine handler
+func NewQuarantineHandler(authToken string, nc *nats.Conn) http.Handler {
+	return &Quarantine{nc, authToken}
+}
+
+func (h *Quarantine) ServeHTTP(res http.ResponseWriter, req *http.Request) {
+	if h.authToken == "" || req.Header.Get("Authorization") != h.authToken {
+		http.Error(res, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var head string
+	head, req.URL.Path = ShiftPath(req.URL.Path)
+
+	switch head {
+	case "":
+		if req.Method != http.MethodGet {
+			http.Error(res, "only GET allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		q := client.QuarantineQuery{NodeID: req.URL.Query().Get("node")}
+
+		if l := req.URL.Query().Get("limit"); l != "" {
+			var err error
+			q.Limit, err = strconv.Atoi(l)
+			if err != nil {
+				http.Error(res, "invalid limit: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+
+		points, err := client.GetQuarantine(h.nc, q)
+		if err != nil {
+			http.Error(res, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if len(points) > 0 {
+			encode(res, points)
+		} else {
+			res.Write([]byte("[]"))
+		}
+
+	case "admit", "discard":
+		if req.Method != http.MethodPost {
+			http.Error(res, "only POST allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var r client.QuarantineRequest
+		if err := decode(req.Body, &r); err != nil {
+			http.Error(res, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		f := client.AdmitQuarantine
+		if head == "discard" {
+			f = client.DiscardQuarantine
+		}
+
+		count, err := f(h.nc, r.IDs)
+		if err != nil {
+			http.Error(res, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		encode(res, client.QuarantineResult{Count: count})
+
+	default:
+		http.Error(res, "Not Found", http.StatusNotFound)
+	}
+}
@@ -0,0 +1,271 @@
+package api
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// RateLimits limits the requests made to the /v1 HTTP API, so a misbehaving
+// integration can't overload a small gateway. 0 disables a limit.
+type RateLimits struct {
+	// TokenRate is the number of requests per second allowed for each
+	// authenticated user, or the auth token, with bursts of up to TokenBurst
+	// requests
+	TokenRate  float64
+	TokenBurst int
+	// IPRate is the number of requests per second allowed for each client
+	// IP address, with bursts of up to IPBurst requests
+	IPRate  float64
+	IPBurst int
+	// MaxBodySize is the max size of a request body in bytes
+	MaxBodySize int64
+}
+
+// DefaultRateLimits returns the default HTTP API rate limits
+func DefaultRateLimits() RateLimits {
+	return RateLimits{
+		TokenRate:   20,
+		TokenBurst:  100,
+		IPRate:      50,
+		IPBurst:     200,
+		MaxBodySize: 10 << 20,
+	}
+}
+
+// limiterMax is the max number of rate limiters kept. Limiters that were not
+// used for a minute are removed first, then the least recently used.
+var limiterMax = 1000
+
+type keyLimiter struct {
+	limiter *rate.Limiter
+	used    time.Time
+}
+
+// keyLimiters rate limits requests with a limiter for each key, for example
+// a user or IP address
+type keyLimiters struct {
+	rate  float64
+	burst int
+
+	lock     sync.Mutex
+	limiters map[string]*keyLimiter
+}
+
+func newKeyLimiters(r float64, burst int) *keyLimiters {
+	if burst < 1 {
+		burst = 1
+	}
+
+	return &keyLimiters{
+		rate:     r,
+		burst:    burst,
+		limiters: make(map[string]*keyLimiter),
+	}
+}
+
+// allow returns 0 if a request for key is allowed, otherwise how long to
+// wait before retrying
+func (kl *keyLimiters) allow(key string, now time.Time) time.Duration {
+	if kl.rate <= 0 {
+		return 0
+	}
+
+	kl.lock.Lock()
+	defer kl.lock.Unlock()
+
+	l, ok := kl.limiters[key]
+	if !ok {
+		if len(kl.limiters) >= limiterMax {
+			// limiters that were not used for a while are full again
+			for k, l := range kl.limiters {
+				if now.Sub(l.used) > time.Minute {
+					delete(kl.limiters, k)
+				}
+			}
+		}
+
+		for len(kl.limiters) >= limiterMax {
+			kl.removeOldest()
+		}
+
+		l = &keyLimiter{limiter: rate.NewLimiter(rate.Limit(kl.rate), kl.burst)}
+		kl.limiters[key] = l
+	}
+
+	l.used = now
+
+	r := l.limiter.ReserveN(now, 1)
+	if d := r.DelayFrom(now); d > 0 {
+		r.CancelAt(now)
+		return d
+	}
+
+	return 0
+}
+
+// removeOldest removes the least recently used limiter
+func (kl *keyLimiters) removeOldest() {
+	var oldest string
+	var oldestUsed time.Time
+	for k, l := range kl.limiters {
+		if oldest == "" || l.used.Before(oldestUsed) {
+			oldest, oldestUsed = k, l.used
+		}
+	}
+	delete(kl.limiters, oldest)
+}
+
+// RateLimiter is HTTP middleware that enforces RateLimits. Requests over a
+// rate limit get a 429 (Too Many Requests) response with a Retry-After
+// header, and requests with a body over MaxBodySize get a 413 response.
+type RateLimiter struct {
+	limits   RateLimits
+	users    *keyLimiters
+	ips      *keyLimiters
+	clientIP func(*http.Request) string
+	user     func(*http.Request) string
+
+	lock     sync.Mutex
+	requests int64
+	rejected map[string]int64
+}
+
+// NewRateLimiter returns a new rate limiter. clientIP returns the IP address
+// of the client that made a request, and user returns the authenticated user
+// of a request, or "" if the request is not authenticated. Requests that are
+// not authenticated are only limited by IP address, so that clients can't get
+// around the limits by sending made up tokens.
+func NewRateLimiter(limits RateLimits, clientIP, user func(*http.Request) string) *RateLimiter {
+	return &RateLimiter{
+		limits:   limits,
+		users:    newKeyLimiters(limits.TokenRate, limits.TokenBurst),
+		ips:      newKeyLimiters(limits.IPRate, limits.IPBurst),
+		clientIP: clientIP,
+		user:     user,
+		rejected: make(map[string]int64),
+	}
+}
+
+// remoteIP returns the IP address of the connection a request was made on
+func remoteIP(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
+
+// requestUser returns a function that returns the user a request is
+// authenticated as. Requests with the auth token are limited as one user.
+func requestUser(auth Authorizer, authToken string) func(*http.Request) string {
+	return func(req *http.Request) string {
+		if authToken != "" && req.Header.Get("Authorization") == authToken {
+			return "authToken"
+		}
+
+		if auth == nil {
+			return ""
+		}
+
+		valid, userID := auth.Valid(req)
+		if !valid {
+			return ""
+		}
+
+		return userID
+	}
+}
+
+// rateLimitReasons are the reasons requests are rejected, used as the
+// reason label of the metrics
+var rateLimitReasons = []string{"ip", "token", "size"}
+
+func (rl *RateLimiter) reject(res http.ResponseWriter, reason string, retry time.Duration) {
+	rl.lock.Lock()
+	rl.rejected[reason]++
+	rl.lock.Unlock()
+
+	if reason == "size" {
+		http.Error(res, "request body too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	res.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retry.Seconds()))))
+	http.Error(res, "too many requests", http.StatusTooManyRequests)
+}
+
+// Handler wraps an HTTP handler with the rate limits
+func (rl *RateLimiter) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		now := time.Now()
+
+		rl.lock.Lock()
+		rl.requests++
+		rl.lock.Unlock()
+
+		if d := rl.ips.allow(rl.clientIP(req), now); d > 0 {
+			rl.reject(res, "ip", d)
+			return
+		}
+
+		if user := rl.user(req); user != "" {
+			if d := rl.users.allow(user, now); d > 0 {
+				rl.reject(res, "token", d)
+				return
+			}
+		}
+
+		if max := rl.limits.MaxBodySize; max > 0 && req.Body != nil {
+			if req.ContentLength > max {
+				rl.reject(res, "size", 0)
+				return
+			}
+			req.Body = http.MaxBytesReader(res, req.Body, max)
+		}
+
+		next.ServeHTTP(res, req)
+	})
+}
+
+// WritePrometheus writes the request and rejected request counts in the
+// Prometheus text format
+func (rl *RateLimiter) WritePrometheus(w io.Writer) error {
+	rl.lock.Lock()
+	requests := rl.requests
+	rejected := make(map[string]int64, len(rl.rejected))
+	for k, v := range rl.rejected {
+		rejected[k] = v
+	}
+	rl.lock.Unlock()
+
+	_, err := fmt.Fprintf(w, "# HELP siot_http_requests_total Requests made to the HTTP API.\n"+
+		"# TYPE siot_http_requests_total counter\nsiot_http_requests_total %v\n", requests)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "# HELP siot_http_requests_rejected_total "+
+		"Requests rejected by the HTTP API rate limits.\n"+
+		"# TYPE siot_http_requests_rejected_total counter\n")
+	if err != nil {
+		return err
+	}
+
+	for _, r := range rateLimitReasons {
+		_, err := fmt.Fprintf(w, "siot_http_requests_rejected_total{reason=\"%v\"} %v\n",
+			r, rejected[r])
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
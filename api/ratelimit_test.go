@@ -0,0 +1,121 @@
+package api
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	// tokens starting with user- are valid
+	user := func(req *http.Request) string {
+		token := req.Header.Get("Authorization")
+		if !strings.HasPrefix(token, "user-") {
+			return ""
+		}
+		return token
+	}
+
+	rl := NewRateLimiter(RateLimits{TokenRate: 1, TokenBurst: 2, IPRate: 1, IPBurst: 4,
+		MaxBodySize: 10}, remoteIP, user)
+
+	h := rl.Handler(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		if _, err := io.ReadAll(req.Body); err != nil {
+			http.Error(res, err.Error(), http.StatusBadRequest)
+		}
+	}))
+
+	do := func(ip, token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+		req.RemoteAddr = ip + ":1234"
+		if token != "" {
+			req.Header.Set("Authorization", token)
+		}
+		res := httptest.NewRecorder()
+		h.ServeHTTP(res, req)
+		return res
+	}
+
+	// the token burst is used up first
+	for i := 0; i < 2; i++ {
+		if res := do("10.0.0.1", "user-1", ""); res.Code != http.StatusOK {
+			t.Fatal("request in token burst rejected: ", res.Code)
+		}
+	}
+
+	res := do("10.0.0.1", "user-1", "")
+	if res.Code != http.StatusTooManyRequests || res.Header().Get("Retry-After") == "" {
+		t.Fatal("request over token limit not rejected: ", res.Code)
+	}
+
+	// other users and IPs have their own limits
+	if res := do("10.0.0.1", "user-2", ""); res.Code != http.StatusOK {
+		t.Fatal("request from other user rejected: ", res.Code)
+	}
+
+	if res := do("10.0.0.1", "", ""); res.Code != http.StatusTooManyRequests {
+		t.Fatal("request over ip limit not rejected: ", res.Code)
+	}
+
+	if res := do("10.0.0.2", "", ""); res.Code != http.StatusOK {
+		t.Fatal("request from other ip rejected: ", res.Code)
+	}
+
+	if res := do("10.0.0.3", "", "01234567890"); res.Code != http.StatusRequestEntityTooLarge {
+		t.Fatal("request with large body not rejected: ", res.Code)
+	}
+
+	// invalid tokens do not get their own limits
+	for i := 0; i < 4; i++ {
+		if res := do("10.0.0.4", fmt.Sprintf("forged-%v", i), ""); res.Code != http.StatusOK {
+			t.Fatal("request in ip burst rejected: ", res.Code)
+		}
+	}
+
+	if res := do("10.0.0.4", "forged-4", ""); res.Code != http.StatusTooManyRequests {
+		t.Fatal("request with invalid token over ip limit not rejected: ", res.Code)
+	}
+
+	var b bytes.Buffer
+	if err := rl.WritePrometheus(&b); err != nil {
+		t.Fatal("Error writing metrics: ", err)
+	}
+
+	for _, exp := range []string{
+		"siot_http_requests_total 12",
+		`siot_http_requests_rejected_total{reason="token"} 1`,
+		`siot_http_requests_rejected_total{reason="ip"} 2`,
+		`siot_http_requests_rejected_total{reason="size"} 1`,
+	} {
+		if !strings.Contains(b.String(), exp) {
+			t.Errorf("metrics do not contain %v:\n%v", exp, b.String())
+		}
+	}
+}
+
+func TestKeyLimitersMax(t *testing.T) {
+	defer func(max int) { limiterMax = max }(limiterMax)
+	limiterMax = 3
+
+	kl := newKeyLimiters(1, 1)
+	now := time.Now()
+
+	for i := 0; i < 10; i++ {
+		kl.allow(fmt.Sprintf("key-%v", i), now.Add(time.Duration(i)*time.Second))
+	}
+
+	if len(kl.limiters) != limiterMax {
+		t.Fatalf("expected %v limiters, got %v", limiterMax, len(kl.limiters))
+	}
+
+	for i := 7; i < 10; i++ {
+		if _, ok := kl.limiters[fmt.Sprintf("key-%v", i)]; !ok {
+			t.Errorf("recently used key-%v was removed", i)
+		}
+	}
+}
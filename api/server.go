@@ -2,12 +2,17 @@ package api
 
 import (
 	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"html"
 	"io"
 	"log"
 	"net"
 	"net/http"
 	"net/url"
+	"strings"
 
 	"github.com/koding/websocketproxy"
 	"github.com/nats-io/nats.go"
@@ -16,6 +21,7 @@ import (
 // IndexHandler is used to serve the index page
 type IndexHandler struct {
 	getAsset func(string) []byte
+	basePath string
 }
 
 func (h *IndexHandler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
@@ -23,14 +29,20 @@ func (h *IndexHandler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 	if f == nil {
 		rw.WriteHeader(http.StatusNotFound)
 	} else {
+		// the frontend uses URLs relative to the base, so it works when
+		// served at a subpath and for nested routes
+		base := fmt.Sprintf("<head>\n    <base href=\"%v/\" />",
+			html.EscapeString(h.basePath))
+		f = bytes.Replace(f, []byte("<head>"), []byte(base), 1)
 		var reader = bytes.NewBuffer(f)
 		io.Copy(rw, reader)
 	}
 }
 
-// NewIndexHandler returns a new Index handler
-func NewIndexHandler(getAsset func(string) []byte) http.Handler {
-	return &IndexHandler{getAsset: getAsset}
+// NewIndexHandler returns a new Index handler. basePath is the path SIOT is
+// served at by a reverse proxy, or blank.
+func NewIndexHandler(getAsset func(string) []byte, basePath string) http.Handler {
+	return &IndexHandler{getAsset: getAsset, basePath: strings.TrimSuffix(basePath, "/")}
 }
 
 // App is a struct that implements http.Handler interface
@@ -39,6 +51,8 @@ type App struct {
 	IndexHandler   http.Handler
 	V1ApiHandler   http.Handler
 	WebsocketProxy http.Handler
+	HealthHandler  http.Handler
+	MetricsHandler http.Handler
 }
 
 // Top level handler for http requests in the coap-server process
@@ -55,6 +69,14 @@ func (h *App) ServeHTTP(res http.ResponseWriter, req *http.Request) {
 		}
 	case "/orgs", "/users", "/devices", "/sign-in", "/groups", "/msg":
 		h.IndexHandler.ServeHTTP(res, req)
+	case "/healthz":
+		h.HealthHandler.ServeHTTP(res, req)
+	case "/metrics":
+		h.MetricsHandler.ServeHTTP(res, req)
+	case "/sw.js":
+		// the service worker is served from the root so its scope
+		// includes the whole app
+		h.PublicHandler.ServeHTTP(res, req)
 
 	default:
 		head, req.URL.Path = ShiftPath(req.URL.Path)
@@ -77,6 +99,19 @@ func NewAppHandler(args ServerArgs) http.Handler {
 		v1 = NewHTTPLogger("v1").Handler(v1)
 	}
 
+	limiter := NewRateLimiter(args.RateLimits, remoteIP,
+		requestUser(args.JwtAuth, args.AuthToken))
+	v1 = NewCORSHandler(args.CORSOrigins, limiter.Handler(v1))
+
+	metrics := func(w io.Writer) error {
+		if args.Metrics != nil {
+			if err := args.Metrics(w); err != nil {
+				return err
+			}
+		}
+		return limiter.WritePrometheus(w)
+	}
+
 	var wsProxy http.Handler
 
 	if args.NatsWSPort > 0 {
@@ -89,12 +124,21 @@ func NewAppHandler(args ServerArgs) http.Handler {
 		}
 	}
 
-	return &App{
+	proxies, err := ParseTrustedProxies(args.TrustedProxies)
+	if err != nil {
+		log.Println("Error parsing trusted proxies: ", err)
+	}
+
+	app := &App{
 		PublicHandler:  http.FileServer(args.Filesystem),
-		IndexHandler:   NewIndexHandler(args.GetAsset),
+		IndexHandler:   NewIndexHandler(args.GetAsset, args.BasePath),
 		V1ApiHandler:   v1,
 		WebsocketProxy: wsProxy,
+		HealthHandler:  NewHealthHandler(args.Nc),
+		MetricsHandler: NewMetricsHandler(metrics, args.AuthToken),
 	}
+
+	return NewForwardedHandler(proxies, NewBasePathHandler(args.BasePath, app))
 }
 
 // ServerArgs can be used to pass arguments to the server subsystem
@@ -107,27 +151,46 @@ type ServerArgs struct {
 	AuthToken  string
 	NatsWSPort int
 	Nc         *nats.Conn
+	// TLSConfig enables HTTPS if set
+	TLSConfig *tls.Config
+	// Metrics writes the metrics served at /metrics
+	Metrics func(io.Writer) error
+	// HistoryLimits limits history queries
+	HistoryLimits HistoryLimits
+	// RateLimits limits requests to the /v1 API
+	RateLimits RateLimits
+	// BasePath is the path SIOT is served at by a reverse proxy, for
+	// example /siot
+	BasePath string
+	// CORSOrigins are the origins allowed to make cross origin requests to
+	// the /v1 API
+	CORSOrigins []string
+	// TrustedProxies are the IP addresses and networks of reverse proxies
+	// that set the X-Forwarded-For header
+	TrustedProxies []string
 }
 
 // Server represents the HTTP API server
 type Server struct {
-	args   ServerArgs
-	ln     net.Listener
-	chStop chan struct{}
+	args      ServerArgs
+	ln        net.Listener
+	chStop    chan struct{}
+	chStarted chan struct{}
 }
 
 // NewServer ..
 func NewServer(args ServerArgs) *Server {
 	return &Server{
-		args:   args,
-		chStop: make(chan struct{}),
+		args:      args,
+		chStop:    make(chan struct{}),
+		chStarted: make(chan struct{}),
 	}
 }
 
 // Start the api server
 func (s *Server) Start() error {
 	log.Println("Starting http server, debug: ", s.args.Debug)
-	log.Println("Starting portal on port: ", s.args.Port)
+	log.Printf("Starting portal on port: %v, TLS: %v\n", s.args.Port, s.args.TLSConfig != nil)
 	address := fmt.Sprintf(":%s", s.args.Port)
 
 	var err error
@@ -136,6 +199,12 @@ func (s *Server) Start() error {
 		return fmt.Errorf("Error starting api server: %v", err)
 	}
 
+	if s.args.TLSConfig != nil {
+		s.ln = tls.NewListener(s.ln, s.args.TLSConfig)
+	}
+
+	close(s.chStarted)
+
 	chError := make(chan error)
 
 	go func() {
@@ -152,6 +221,16 @@ func (s *Server) Start() error {
 	return err
 }
 
+// WaitStart waits until the server is listening
+func (s *Server) WaitStart(ctx context.Context) error {
+	select {
+	case <-s.chStarted:
+		return nil
+	case <-ctx.Done():
+		return errors.New("HTTP API wait timeout or canceled")
+	}
+}
+
 // Stop HTTP API
 func (s *Server) Stop(_ error) {
 	close(s.chStop)
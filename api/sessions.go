@@ -0,0 +1,257 @@
+package api
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/uuid"
+	"github.com/simpleiot/simpleiot/data"
+)
+
+// sessionLifetime is how long a session token is valid
+const sessionLifetime = 24 * time.Hour
+
+// Session is a signed in user. Each session has its own token, so sessions
+// can be revoked individually.
+type Session struct {
+	ID       string    `json:"id"`
+	UserID   string    `json:"userID"`
+	Created  time.Time `json:"created"`
+	Expires  time.Time `json:"expires"`
+	LastUsed time.Time `json:"lastUsed"`
+	// Current is set if this is the session of the request
+	Current bool `json:"current,omitempty"`
+}
+
+// Sessions is an Authorizer that keeps track of the tokens it issues. A
+// token is only valid while its session is in the store, so tokens can be
+// revoked before they expire. Sessions are kept in memory, as the signing
+// key is generated when SIOT starts.
+type Sessions struct {
+	key      Key
+	lock     sync.Mutex
+	sessions map[string]*Session
+}
+
+// NewSessions returns a session store that signs tokens with key
+func NewSessions(key Key) *Sessions {
+	return &Sessions{
+		key:      key,
+		sessions: make(map[string]*Session),
+	}
+}
+
+// NewToken creates a session for a user and returns its token
+func (s *Sessions) NewToken(userID string) (string, error) {
+	now := time.Now()
+	session := &Session{
+		ID:       uuid.New().String(),
+		UserID:   userID,
+		Created:  now,
+		Expires:  now.Add(sessionLifetime),
+		LastUsed: now,
+	}
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.StandardClaims{
+		ExpiresAt: session.Expires.Unix(),
+		IssuedAt:  now.Unix(),
+		Issuer:    "simpleiot",
+		Id:        session.ID,
+		Subject:   userID,
+	}).SignedString(s.key.bytes)
+	if err != nil {
+		return "", err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.prune(now)
+	s.sessions[session.ID] = session
+
+	return token, nil
+}
+
+// Valid returns whether the request bears the token of an active session,
+// and the ID of the session user
+func (s *Sessions) Valid(req *http.Request) (bool, string) {
+	session, ok := s.session(req)
+	if !ok {
+		return false, ""
+	}
+
+	return true, session.UserID
+}
+
+// session returns the session of the request token
+func (s *Sessions) session(req *http.Request) (Session, bool) {
+	fields := strings.Fields(req.Header.Get("Authorization"))
+	if len(fields) < 2 || fields[0] != "Bearer" {
+		return Session{}, false
+	}
+
+	var claims jwt.StandardClaims
+	token, err := jwt.ParseWithClaims(fields[1], &claims, s.key.keyFunc)
+	if err != nil || !token.Valid || token.Method.Alg() != "HS256" {
+		return Session{}, false
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	session, ok := s.sessions[claims.Id]
+	if !ok || session.UserID != claims.Subject {
+		return Session{}, false
+	}
+
+	session.LastUsed = time.Now()
+
+	return *session, true
+}
+
+// List returns the active sessions of a user, oldest first. If userID is
+// blank, the sessions of all users are returned.
+func (s *Sessions) List(userID string) []Session {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.prune(time.Now())
+
+	ret := []Session{}
+	for _, session := range s.sessions {
+		if userID == "" || session.UserID == userID {
+			ret = append(ret, *session)
+		}
+	}
+
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Created.Before(ret[j].Created)
+	})
+
+	return ret
+}
+
+// Revoke ends a session. False is returned if the session does not exist.
+func (s *Sessions) Revoke(id string) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	_, ok := s.sessions[id]
+	delete(s.sessions, id)
+	return ok
+}
+
+// RevokeUser ends all sessions of a user and returns how many were ended
+func (s *Sessions) RevokeUser(userID string) int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	count := 0
+	for id, session := range s.sessions {
+		if session.UserID == userID {
+			delete(s.sessions, id)
+			count++
+		}
+	}
+
+	return count
+}
+
+// prune removes expired sessions. Must be called with the lock held.
+func (s *Sessions) prune(now time.Time) {
+	for id, session := range s.sessions {
+		if now.After(session.Expires) {
+			delete(s.sessions, id)
+		}
+	}
+}
+
+// SessionsHandler handles /v1/sessions requests. Users can list and revoke
+// their own sessions. Requests authenticated with the auth token can manage
+// the sessions of any user.
+type SessionsHandler struct {
+	sessions  *Sessions
+	authToken string
+}
+
+// NewSessionsHandler returns a new sessions handler. sessions is nil if
+// authentication is disabled.
+func NewSessionsHandler(sessions *Sessions, authToken string) http.Handler {
+	return &SessionsHandler{sessions, authToken}
+}
+
+func (h *SessionsHandler) ServeHTTP(res http.ResponseWriter, req *http.Request) {
+	if h.sessions == nil {
+		http.Error(res, "authentication is disabled", http.StatusNotFound)
+		return
+	}
+
+	var id string
+	id, req.URL.Path = ShiftPath(req.URL.Path)
+
+	admin := h.authToken != "" && req.Header.Get("Authorization") == h.authToken
+
+	var current Session
+	userID := req.URL.Query().Get("user")
+
+	if !admin {
+		var ok bool
+		current, ok = h.sessions.session(req)
+		if !ok {
+			http.Error(res, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		userID = current.UserID
+	}
+
+	switch req.Method {
+	case http.MethodGet:
+		if id != "" {
+			http.Error(res, "invalid method", http.StatusMethodNotAllowed)
+			return
+		}
+
+		sessions := h.sessions.List(userID)
+		for i := range sessions {
+			sessions[i].Current = sessions[i].ID == current.ID
+		}
+		encode(res, sessions)
+
+	case http.MethodDelete:
+		switch id {
+		case "":
+			// sign out everywhere
+			if userID == "" {
+				http.Error(res, "user must be set", http.StatusBadRequest)
+				return
+			}
+			h.sessions.RevokeUser(userID)
+		case "current":
+			if admin {
+				http.Error(res, "no current session", http.StatusBadRequest)
+				return
+			}
+			h.sessions.Revoke(current.ID)
+		default:
+			found := false
+			for _, s := range h.sessions.List(userID) {
+				if s.ID == id {
+					found = h.sessions.Revoke(id)
+				}
+			}
+			if !found {
+				http.Error(res, "session not found", http.StatusNotFound)
+				return
+			}
+		}
+
+		encode(res, data.StandardResponse{Success: true, ID: id})
+
+	default:
+		http.Error(res, "invalid method", http.StatusMethodNotAllowed)
+	}
+}
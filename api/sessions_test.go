@@ -0,0 +1,83 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSessions(t *testing.T) {
+	key, err := NewKey(20)
+	if err != nil {
+		t.Fatal("Error creating key: ", err)
+	}
+
+	sessions := NewSessions(key)
+	h := NewSessionsHandler(sessions, "device-token")
+
+	var tokens []string
+	for _, user := range []string{"u1", "u1", "u2"} {
+		token, err := sessions.NewToken(user)
+		if err != nil {
+			t.Fatal("Error creating token: ", err)
+		}
+		tokens = append(tokens, token)
+	}
+
+	do := func(method, url, auth string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, nil)
+		req.Header.Set("Authorization", auth)
+		res := httptest.NewRecorder()
+		h.ServeHTTP(res, req)
+		return res
+	}
+
+	valid := func(token string) bool {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		ok, _ := sessions.Valid(req)
+		return ok
+	}
+
+	res := do(http.MethodGet, "/", "Bearer "+tokens[0])
+	var list []Session
+	if err := json.NewDecoder(res.Body).Decode(&list); err != nil {
+		t.Fatal("Error decoding sessions: ", err)
+	}
+
+	if len(list) != 2 || !list[0].Current || list[1].Current {
+		t.Fatal("unexpected sessions: ", list)
+	}
+
+	// users can't revoke sessions of other users
+	u2 := sessions.List("u2")
+	res = do(http.MethodDelete, "/"+u2[0].ID, "Bearer "+tokens[0])
+	if res.Code != http.StatusNotFound || !valid(tokens[2]) {
+		t.Fatal("revoked session of other user: ", res.Code)
+	}
+
+	res = do(http.MethodDelete, "/current", "Bearer "+tokens[0])
+	if res.Code != http.StatusOK || valid(tokens[0]) || !valid(tokens[1]) {
+		t.Fatal("current session not revoked: ", res.Code)
+	}
+
+	res = do(http.MethodGet, "/", "Bearer "+tokens[0])
+	if res.Code != http.StatusUnauthorized {
+		t.Fatal("revoked token accepted: ", res.Code)
+	}
+
+	// the auth token can sign out any user
+	res = do(http.MethodDelete, "/?user=u2", "device-token")
+	if res.Code != http.StatusOK || valid(tokens[2]) || !valid(tokens[1]) {
+		t.Fatal("sessions of u2 not revoked: ", res.Code)
+	}
+
+	if n := sessions.RevokeUser("u1"); n != 1 || valid(tokens[1]) {
+		t.Fatal("sessions of u1 not revoked: ", n)
+	}
+
+	if valid("not a token") {
+		t.Fatal("invalid token accepted")
+	}
+}
@@ -0,0 +1,255 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/uuid"
+	"github.com/nats-io/nats.go"
+	"github.com/simpleiot/simpleiot/client"
+	"github.com/simpleiot/simpleiot/data"
+)
+
+// shareDefaultDays is how long a share link is valid if no time is given
+const shareDefaultDays = 30
+
+var errShareInvalid = errors.New("invalid or expired share link")
+
+// NodeShare is a data structure used with the /node/:id/share api call.
+// Days is how long the link is valid, the default is 30 days.
+type NodeShare struct {
+	Description string
+	Days        float64
+}
+
+// ShareLink is returned when a share link is created. The link is
+// /v1/share/<Token>, and it is revoked by deleting the share node ID.
+type ShareLink struct {
+	ID      string    `json:"id"`
+	Token   string    `json:"token"`
+	Expires time.Time `json:"expires"`
+}
+
+// shareNodeTypes are the node types returned with the descendants of a
+// shared node. Other nodes, like users, may contain personal information or
+// configuration, so they and their descendants are not shared.
+var shareNodeTypes = map[string]bool{
+	data.NodeTypeDevice:          true,
+	data.NodeTypeGroup:           true,
+	data.NodeTypeVariable:        true,
+	data.NodeTypeModbus:          true,
+	data.NodeTypeModbusIO:        true,
+	data.NodeTypeOneWire:         true,
+	data.NodeTypeOneWireIO:       true,
+	data.NodeTypeSerialDev:       true,
+	data.NodeTypeSignalGenerator: true,
+	data.NodeTypeSimulator:       true,
+	data.NodeTypeSystemMonitor:   true,
+	data.NodeTypeRuntimeStats:    true,
+	data.NodeTypeIntegrator:      true,
+	data.NodeTypeTracker:         true,
+	data.NodeTypeWeather:         true,
+	data.NodeTypeMeter:           true,
+	data.NodeTypeMbusMeter:       true,
+	data.NodeTypeKnxGroup:        true,
+	data.NodeTypeMqttDevice:      true,
+	data.NodeTypeCoapDevice:      true,
+	data.NodeTypeUdpSource:       true,
+	data.NodeTypeNetworkTarget:   true,
+}
+
+// shareDescendants returns the descendants of the shared node id that can be
+// shared. A node is only returned if its type is in shareNodeTypes and its
+// parent is returned.
+func shareDescendants(id string, descendants []data.NodeEdge) []data.NodeEdge {
+	shared := map[string]bool{id: true}
+	done := make([]bool, len(descendants))
+	var ret []data.NodeEdge
+
+	// descendants are not sorted, so repeat until no more nodes are added
+	for added := true; added; {
+		added = false
+		for i, n := range descendants {
+			if done[i] || !shared[n.Parent] || !shareNodeTypes[n.Type] {
+				continue
+			}
+			done[i] = true
+			shared[n.ID] = true
+			ret = append(ret, n)
+			added = true
+		}
+	}
+
+	return ret
+}
+
+// newShareToken returns a share token for a node, signed with the key of
+// the share node
+func newShareToken(nodeID, shareID string, key []byte, now, expires time.Time) (string, error) {
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.StandardClaims{
+		ExpiresAt: expires.Unix(),
+		IssuedAt:  now.Unix(),
+		Issuer:    "simpleiot",
+		Id:        shareID,
+		Subject:   nodeID,
+	}).SignedString(key)
+}
+
+// parseShareToken checks a share token and returns the ID of the shared
+// node. getShare returns the share node named by the token, and its parent
+// must be the shared node.
+func parseShareToken(token string, getShare func(shareID, nodeID string) (data.NodeEdge, error)) (string, error) {
+	var claims jwt.StandardClaims
+	_, _, err := new(jwt.Parser).ParseUnverified(token, &claims)
+	if err != nil || claims.Id == "" || claims.Subject == "" {
+		return "", errShareInvalid
+	}
+
+	share, err := getShare(claims.Id, claims.Subject)
+	if err != nil || share.Type != data.NodeTypeShare || share.Parent != claims.Subject {
+		return "", errShareInvalid
+	}
+
+	if tombstone, _ := share.IsTombstone(); tombstone {
+		return "", errShareInvalid
+	}
+
+	keyText, _ := share.Points.Text(data.PointTypeSecretKey, "")
+	key, err := base64.StdEncoding.DecodeString(keyText)
+	if err != nil || len(key) == 0 {
+		return "", errShareInvalid
+	}
+
+	t, err := jwt.ParseWithClaims(token, &jwt.StandardClaims{}, func(*jwt.Token) (interface{}, error) {
+		return key, nil
+	})
+	if err != nil || !t.Valid || t.Method.Alg() != "HS256" {
+		return "", errShareInvalid
+	}
+
+	return claims.Subject, nil
+}
+
+// processShare creates a share link for a node. A share node with the
+// signing key of the link is added below the node.
+func (h *Nodes) processShare(res http.ResponseWriter, req *http.Request, id, userID string) {
+	if req.Method != http.MethodPost {
+		http.Error(res, "only POST allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var share NodeShare
+	if err := decode(req.Body, &share); err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if share.Days < 0 {
+		http.Error(res, "days must not be negative", http.StatusBadRequest)
+		return
+	}
+
+	if share.Days == 0 {
+		share.Days = shareDefaultDays
+	}
+
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	now := time.Now()
+	expires := now.Add(time.Duration(share.Days * float64(24*time.Hour)))
+	shareID := uuid.New().String()
+
+	token, err := newShareToken(id, shareID, key, now, expires)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	err = client.SendNode(h.nc, data.NodeEdge{
+		ID:     shareID,
+		Type:   data.NodeTypeShare,
+		Parent: id,
+		Points: data.Points{
+			{Type: data.PointTypeDescription, Text: share.Description, Origin: userID},
+			{Type: data.PointTypeExpires, Value: float64(expires.Unix()), Origin: userID},
+			{Type: data.PointTypeSecretKey, Text: base64.StdEncoding.EncodeToString(key),
+				Origin: userID},
+		},
+	}, userID)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	encode(res, ShareLink{ID: shareID, Token: token, Expires: expires.Truncate(time.Second)})
+}
+
+// Share handles /v1/share requests. The token of a share link grants read
+// only access to a node and its descendants, without a user account:
+//   - /v1/share/<token> returns the shared node
+//   - /v1/share/<token>/nodes returns the shared node and its descendants
+//     that can be shared (see shareNodeTypes)
+type Share struct {
+	nc *nats.Conn
+}
+
+// NewShareHandler returns a new share link handler
+func NewShareHandler(nc *nats.Conn) http.Handler {
+	return &Share{nc}
+}
+
+func (h *Share) ServeHTTP(res http.ResponseWriter, req *http.Request) {
+	var token, head string
+	token, req.URL.Path = ShiftPath(req.URL.Path)
+	head, req.URL.Path = ShiftPath(req.URL.Path)
+
+	if req.Method != http.MethodGet {
+		http.Error(res, "only GET allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id, err := parseShareToken(token, func(shareID, nodeID string) (data.NodeEdge, error) {
+		nodes, err := client.GetNode(h.nc, shareID, nodeID)
+		if err != nil {
+			return data.NodeEdge{}, err
+		}
+		if len(nodes) < 1 {
+			return data.NodeEdge{}, data.ErrDocumentNotFound
+		}
+		return nodes[0], nil
+	})
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	node, err := client.GetNode(h.nc, id, "none")
+	if err != nil || len(node) < 1 {
+		http.Error(res, fmt.Sprintf("Error getting shared node: %v", err), http.StatusNotFound)
+		return
+	}
+
+	switch head {
+	case "":
+		encode(res, outputNodes(node))
+	case "nodes":
+		children, err := client.GetNodeChildren(h.nc, id, "", false, true)
+		if err != nil {
+			http.Error(res, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		encode(res, outputNodes(append(node, shareDescendants(id, children)...)))
+	default:
+		http.Error(res, "Not Found", http.StatusNotFound)
+	}
+}
@@ -0,0 +1,105 @@
+package api
+
+import (
+	"encoding/base64"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/simpleiot/simpleiot/data"
+)
+
+func TestShareToken(t *testing.T) {
+	key := []byte("0123456789abcdef0123456789abcdef")
+
+	share := data.NodeEdge{
+		ID:     "share-1",
+		Type:   data.NodeTypeShare,
+		Parent: "node-1",
+		Points: data.Points{
+			{Type: data.PointTypeSecretKey,
+				Text: base64.StdEncoding.EncodeToString(key)},
+		},
+	}
+
+	getShare := func(shareID, nodeID string) (data.NodeEdge, error) {
+		if shareID != share.ID || nodeID != share.Parent {
+			return data.NodeEdge{}, data.ErrDocumentNotFound
+		}
+		return share, nil
+	}
+
+	now := time.Now()
+
+	token, err := newShareToken("node-1", "share-1", key, now, now.Add(time.Hour))
+	if err != nil {
+		t.Fatal("Error creating token: ", err)
+	}
+
+	id, err := parseShareToken(token, getShare)
+	if err != nil || id != "node-1" {
+		t.Fatalf("valid token not accepted: %v, %v", id, err)
+	}
+
+	if _, err := parseShareToken(token+"x", getShare); err == nil {
+		t.Fatal("token with bad signature accepted")
+	}
+
+	if _, err := parseShareToken("garbage", getShare); err == nil {
+		t.Fatal("garbage token accepted")
+	}
+
+	expired, err := newShareToken("node-1", "share-1", key, now.Add(-2*time.Hour),
+		now.Add(-time.Hour))
+	if err != nil {
+		t.Fatal("Error creating token: ", err)
+	}
+
+	if _, err := parseShareToken(expired, getShare); err == nil {
+		t.Fatal("expired token accepted")
+	}
+
+	// a token for another node signed with the share key
+	other, err := newShareToken("node-2", "share-1", key, now, now.Add(time.Hour))
+	if err != nil {
+		t.Fatal("Error creating token: ", err)
+	}
+
+	if _, err := parseShareToken(other, getShare); err == nil {
+		t.Fatal("token for another node accepted")
+	}
+
+	// deleting the share node revokes the link
+	share.EdgePoints = data.Points{{Type: data.PointTypeTombstone, Value: 1}}
+
+	if _, err := parseShareToken(token, getShare); err == nil {
+		t.Fatal("revoked token accepted")
+	}
+}
+
+func TestShareDescendants(t *testing.T) {
+	descendants := []data.NodeEdge{
+		// children are listed before their parents to check ordering
+		{ID: "var-1", Type: data.NodeTypeVariable, Parent: "group-1"},
+		{ID: "group-1", Type: data.NodeTypeGroup, Parent: "device-1"},
+		{ID: "user-1", Type: data.NodeTypeUser, Parent: "device-1"},
+		{ID: "user-group", Type: data.NodeTypeGroup, Parent: "user-1"},
+		{ID: "share-1", Type: data.NodeTypeShare, Parent: "device-1"},
+		{ID: "rule-1", Type: data.NodeTypeRule, Parent: "device-1"},
+	}
+
+	ret := shareDescendants("device-1", descendants)
+
+	var ids []string
+	for _, n := range ret {
+		ids = append(ids, n.ID)
+	}
+
+	sort.Strings(ids)
+
+	exp := []string{"group-1", "var-1"}
+	if !reflect.DeepEqual(ids, exp) {
+		t.Errorf("expected %v, got %v", exp, ids)
+	}
+}
@@ -0,0 +1,47 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/simpleiot/simpleiot/client"
+)
+
+// UI handles /v1/ui requests. /v1/ui/nodes returns the node UI descriptors
+// registered by clients, which the frontend uses to build edit forms for
+// node types without a custom view.
+type UI struct {
+	check     RequestValidator
+	authToken string
+}
+
+// NewUIHandler returns a new UI handler
+func NewUIHandler(v RequestValidator, authToken string) http.Handler {
+	return &UI{v, authToken}
+}
+
+func (h *UI) ServeHTTP(res http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(res, "invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if h.authToken == "" || req.Header.Get("Authorization") != h.authToken {
+		validUser, _ := h.check.Valid(req)
+		if !validUser {
+			http.Error(res, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+	}
+
+	var head string
+	head, req.URL.Path = ShiftPath(req.URL.Path)
+
+	switch head {
+	case "nodes":
+		en := json.NewEncoder(res)
+		en.Encode(client.NodeUIs())
+	default:
+		http.Error(res, "Not Found", http.StatusNotFound)
+	}
+}
@@ -6,11 +6,17 @@ import (
 
 // V1 handles v1 api requests
 type V1 struct {
-	GroupsHandler http.Handler
-	UsersHandler  http.Handler
-	NodesHandler  http.Handler
-	AuthHandler   http.Handler
-	MsgHandler    http.Handler
+	GroupsHandler     http.Handler
+	UsersHandler      http.Handler
+	NodesHandler      http.Handler
+	AuthHandler       http.Handler
+	MsgHandler        http.Handler
+	LocationsHandler  http.Handler
+	UIHandler         http.Handler
+	SessionsHandler   http.Handler
+	PushHandler       http.Handler
+	QuarantineHandler http.Handler
+	ShareHandler      http.Handler
 }
 
 // Top level handler for http requests in the coap-server process
@@ -24,6 +30,18 @@ func (h *V1) ServeHTTP(res http.ResponseWriter, req *http.Request) {
 		h.NodesHandler.ServeHTTP(res, req)
 	case "auth":
 		h.AuthHandler.ServeHTTP(res, req)
+	case "locations":
+		h.LocationsHandler.ServeHTTP(res, req)
+	case "ui":
+		h.UIHandler.ServeHTTP(res, req)
+	case "sessions":
+		h.SessionsHandler.ServeHTTP(res, req)
+	case "push":
+		h.PushHandler.ServeHTTP(res, req)
+	case "quarantine":
+		h.QuarantineHandler.ServeHTTP(res, req)
+	case "share":
+		h.ShareHandler.ServeHTTP(res, req)
 	default:
 		http.Error(res, "Not Found", http.StatusNotFound)
 	}
@@ -31,9 +49,19 @@ func (h *V1) ServeHTTP(res http.ResponseWriter, req *http.Request) {
 
 // NewV1Handler returns a handle for V1 API
 func NewV1Handler(args ServerArgs) http.Handler {
+	// sessions can only be managed if tokens are issued by a session store
+	sessions, _ := args.JwtAuth.(*Sessions)
+
 	return &V1{
 		NodesHandler: NewNodesHandler(args.JwtAuth,
-			args.AuthToken, args.Nc),
+			args.AuthToken, args.Nc, args.HistoryLimits),
 		AuthHandler: NewAuthHandler(args.Nc),
+		LocationsHandler: NewLocationsHandler(args.JwtAuth,
+			args.AuthToken, args.Nc),
+		UIHandler:         NewUIHandler(args.JwtAuth, args.AuthToken),
+		SessionsHandler:   NewSessionsHandler(sessions, args.AuthToken),
+		PushHandler:       NewPushHandler(args.JwtAuth, args.Nc),
+		QuarantineHandler: NewQuarantineHandler(args.AuthToken, args.Nc),
+		ShareHandler:      NewShareHandler(args.Nc),
 	}
 }
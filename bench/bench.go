@@ -0,0 +1,394 @@
+package bench
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/simpleiot/simpleiot/client"
+	"github.com/simpleiot/simpleiot/data"
+	"github.com/simpleiot/simpleiot/server"
+)
+
+// Origin is the origin of nodes and points sent by the scenarios
+const Origin = "bench"
+
+// Env is the SIOT instance scenarios are run against
+type Env struct {
+	Nc   *nats.Conn
+	Root data.NodeEdge
+}
+
+// Scenario is a performance scenario
+type Scenario struct {
+	Name string
+	// Setup creates the nodes used by the scenario and returns the
+	// function that is benchmarked.
+	Setup func(env Env) (func(b *testing.B), error)
+}
+
+// Scenarios are the SIOT performance scenarios
+var Scenarios = []Scenario{
+	{"NodePoints", setupNodePoints},
+	{"NodePointsBatch", setupNodePointsBatch},
+	{"DescendentsDeep", setupDescendents(50, 1)},
+	{"DescendentsWide", setupDescendents(1, 500)},
+	{"RuleFanout", setupRuleFanout(20)},
+	{"PbEncodePoints", setupPbEncodePoints},
+	{"PbDecodePoints", setupPbDecodePoints},
+	{"PbEncodeNodes", setupPbEncodeNodes},
+}
+
+// DefaultOptions returns the server options used to run scenarios. The
+// ports are different than the test server ports so that benchmarks can
+// run while other tests are running.
+func DefaultOptions() server.Options {
+	return server.Options{
+		StoreFile:    "bench.sqlite",
+		NatsPort:     4970,
+		HTTPPort:     "8970",
+		NatsHTTPPort: 8971,
+		NatsWSPort:   8972,
+		NatsServer:   "nats://localhost:4970",
+	}
+}
+
+// Start starts a SIOT instance for running scenarios. The store file is
+// deleted before the instance starts and after it is stopped.
+func Start(o server.Options) (Env, func(), error) {
+	nc, root, stop, err := server.TestServerOptions(o)
+	if err != nil {
+		if stop != nil {
+			stop()
+		}
+		return Env{}, nil, err
+	}
+
+	return Env{Nc: nc, Root: root}, stop, nil
+}
+
+// Run starts a SIOT instance and runs the scenarios that match the pattern
+// (all scenarios if the pattern is blank). Results are written to w in the
+// Go benchmark format.
+func Run(o server.Options, pattern string, w io.Writer) error {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return fmt.Errorf("Error parsing pattern: %v", err)
+	}
+
+	env, stop, err := Start(o)
+	if err != nil {
+		return fmt.Errorf("Error starting SIOT: %v", err)
+	}
+	defer stop()
+
+	for _, s := range Scenarios {
+		if !re.MatchString(s.Name) {
+			continue
+		}
+
+		fn, err := s.Setup(env)
+		if err != nil {
+			return fmt.Errorf("%v setup: %v", s.Name, err)
+		}
+
+		r := testing.Benchmark(fn)
+		if r.N == 0 {
+			return fmt.Errorf("%v failed", s.Name)
+		}
+
+		fmt.Fprintf(w, "Benchmark%v\t%v\t%v\n", s.Name, r.String(), r.MemString())
+	}
+
+	return nil
+}
+
+// group creates a group node under the root node to hold the nodes of a
+// scenario
+func group(env Env, name string) (string, error) {
+	id := "bench-" + name
+	err := client.SendNode(env.Nc, data.NodeEdge{ID: id, Type: data.NodeTypeGroup,
+		Parent: env.Root.ID, Points: data.Points{{Type: data.PointTypeDescription,
+			Text: name, Origin: Origin}}}, Origin)
+	return id, err
+}
+
+func setupNodePoints(env Env) (func(b *testing.B), error) {
+	parent, err := group(env, "nodePoints")
+	if err != nil {
+		return nil, err
+	}
+
+	v := client.Variable{ID: parent + "-var", Parent: parent, Description: "var"}
+	if err := client.SendNodeType(env.Nc, v, Origin); err != nil {
+		return nil, err
+	}
+
+	return func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			err := client.SendNodePoint(env.Nc, v.ID, data.Point{Type: data.PointTypeValue,
+				Value: float64(i), Origin: Origin}, true)
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	}, nil
+}
+
+func setupNodePointsBatch(env Env) (func(b *testing.B), error) {
+	parent, err := group(env, "nodePointsBatch")
+	if err != nil {
+		return nil, err
+	}
+
+	v := client.Variable{ID: parent + "-var", Parent: parent, Description: "var"}
+	if err := client.SendNodeType(env.Nc, v, Origin); err != nil {
+		return nil, err
+	}
+
+	return func(b *testing.B) {
+		b.ReportAllocs()
+		pts := make(data.Points, 20)
+		for i := 0; i < b.N; i++ {
+			for j := range pts {
+				pts[j] = data.Point{Type: data.PointTypeValue, Key: fmt.Sprint(j),
+					Value: float64(i), Origin: Origin}
+			}
+			err := client.SendNodePoints(env.Nc, v.ID, pts, true)
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	}, nil
+}
+
+// descendents returns the number of descendents of a node
+func descendents(nc *nats.Conn, id string) (int, error) {
+	children, err := client.GetNodeChildren(nc, id, "", false, false)
+	if err != nil {
+		return 0, err
+	}
+
+	count := len(children)
+	for _, c := range children {
+		n, err := descendents(nc, c.ID)
+		if err != nil {
+			return 0, err
+		}
+		count += n
+	}
+
+	return count, nil
+}
+
+// setupDescendents creates a tree that is depth levels deep with width
+// children at each level and benchmarks walking it
+func setupDescendents(depth, width int) func(env Env) (func(b *testing.B), error) {
+	return func(env Env) (func(b *testing.B), error) {
+		top, err := group(env, fmt.Sprintf("descendents-%v-%v", depth, width))
+		if err != nil {
+			return nil, err
+		}
+
+		parents := []string{top}
+		count := 0
+		for d := 0; d < depth; d++ {
+			var next []string
+			for _, p := range parents {
+				for w := 0; w < width; w++ {
+					id := fmt.Sprintf("%v-%v", p, w)
+					err := client.SendNode(env.Nc, data.NodeEdge{ID: id,
+						Type: data.NodeTypeGroup, Parent: p}, Origin)
+					if err != nil {
+						return nil, err
+					}
+					next = append(next, id)
+					count++
+				}
+			}
+			parents = next
+		}
+
+		return func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				n, err := descendents(env.Nc, top)
+				if err != nil {
+					b.Fatal(err)
+				}
+				if n != count {
+					b.Fatalf("expected %v descendents, got %v", count, n)
+				}
+			}
+		}, nil
+	}
+}
+
+// setupRuleFanout creates rules that all watch the same variable and
+// benchmarks how long it takes for all of them to respond to a change
+func setupRuleFanout(rules int) func(env Env) (func(b *testing.B), error) {
+	return func(env Env) (func(b *testing.B), error) {
+		parent, err := group(env, "ruleFanout")
+		if err != nil {
+			return nil, err
+		}
+
+		v := client.Variable{ID: parent + "-var", Parent: parent, Description: "var"}
+		if err := client.SendNodeType(env.Nc, v, Origin); err != nil {
+			return nil, err
+		}
+
+		ruleIDs := make(map[string]bool)
+
+		for i := 0; i < rules; i++ {
+			// the rule client manager only runs rules that are children
+			// of the root node
+			r := client.Rule{ID: fmt.Sprintf("%v-rule-%v", parent, i), Parent: env.Root.ID,
+				Description: fmt.Sprint("rule ", i)}
+			if err := client.SendNodeType(env.Nc, r, Origin); err != nil {
+				return nil, err
+			}
+
+			c := client.Condition{
+				ID:            r.ID + "-cond",
+				Parent:        r.ID,
+				ConditionType: data.PointValuePointValue,
+				PointType:     data.PointTypeValue,
+				ValueType:     data.PointValueOnOff,
+				NodeID:        v.ID,
+				Operator:      data.PointValueEqual,
+				Value:         1,
+			}
+			if err := client.SendNodeType(env.Nc, c, Origin); err != nil {
+				return nil, err
+			}
+
+			ruleIDs[r.ID] = true
+		}
+
+		// rule clients are started after the config debounce period
+		time.Sleep(client.DefaultConfigDebounce + 500*time.Millisecond)
+
+		chActive := make(chan struct{}, rules)
+		sub, err := env.Nc.Subscribe(fmt.Sprintf("up.%v.*.points", env.Root.ID), func(msg *nats.Msg) {
+			chunks := strings.Split(msg.Subject, ".")
+			if len(chunks) != 4 || !ruleIDs[chunks[2]] {
+				return
+			}
+
+			points, err := data.PbDecodePoints(msg.Data)
+			if err != nil {
+				return
+			}
+
+			for _, p := range points {
+				if p.Type == data.PointTypeActive {
+					chActive <- struct{}{}
+				}
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		var value float64
+
+		step := func() error {
+			value = 1 - value
+			err := client.SendNodePoint(env.Nc, v.ID, data.Point{Type: data.PointTypeValue,
+				Value: value, Origin: Origin}, true)
+			if err != nil {
+				return err
+			}
+
+			timeout := time.After(10 * time.Second)
+			for i := 0; i < rules; i++ {
+				select {
+				case <-chActive:
+				case <-timeout:
+					return errors.New("timeout waiting for rules")
+				}
+			}
+			return nil
+		}
+
+		// make sure all rules are running
+		if err := step(); err != nil {
+			sub.Unsubscribe()
+			return nil, err
+		}
+
+		return func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if err := step(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}, nil
+	}
+}
+
+func benchPoints() data.Points {
+	now := time.Now()
+	pts := make(data.Points, 100)
+	for i := range pts {
+		pts[i] = data.Point{Time: now, Type: data.PointTypeValue, Key: fmt.Sprint(i),
+			Value: float64(i), Origin: Origin}
+	}
+	return pts
+}
+
+func setupPbEncodePoints(_ Env) (func(b *testing.B), error) {
+	pts := benchPoints()
+
+	return func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := pts.ToPb(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}, nil
+}
+
+func setupPbDecodePoints(_ Env) (func(b *testing.B), error) {
+	pts := benchPoints()
+	d, err := pts.ToPb()
+	if err != nil {
+		return nil, err
+	}
+
+	return func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := data.PbDecodePoints(d); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}, nil
+}
+
+func setupPbEncodeNodes(_ Env) (func(b *testing.B), error) {
+	pts := benchPoints()[:10]
+	nodes := make(data.Nodes, 100)
+	for i := range nodes {
+		nodes[i] = data.NodeEdge{ID: fmt.Sprint("node-", i), Type: data.NodeTypeVariable,
+			Parent: "parent", Points: pts}
+	}
+
+	return func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := nodes.ToPb(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}, nil
+}
@@ -0,0 +1,24 @@
+package bench_test
+
+import (
+	"testing"
+
+	"github.com/simpleiot/simpleiot/bench"
+)
+
+func BenchmarkScenarios(b *testing.B) {
+	env, stop, err := bench.Start(bench.DefaultOptions())
+	if err != nil {
+		b.Fatal("Error starting SIOT: ", err)
+	}
+	defer stop()
+
+	for _, s := range bench.Scenarios {
+		fn, err := s.Setup(env)
+		if err != nil {
+			b.Fatalf("%v setup: %v", s.Name, err)
+		}
+
+		b.Run(s.Name, fn)
+	}
+}
@@ -0,0 +1,10 @@
+// Package bench contains performance scenarios for SIOT. Each scenario sets
+// up the nodes it needs in a running SIOT instance and returns a Go benchmark
+// function. The scenarios are run by the Go benchmarks in this package:
+//
+//	go test -run=^$ -bench=. -benchmem ./bench
+//
+// and by the `siot bench` command so that performance can be checked on
+// target hardware. To detect regressions, save the benchmark output before
+// and after a change and compare them with benchstat.
+package bench
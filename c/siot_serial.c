@@ -0,0 +1,731 @@
+/*
+ * siot_serial - Simple IoT serial (MCU) client library
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+#include "siot_serial.h"
+
+#include <string.h>
+
+/* protobuf wire types */
+#define WT_VARINT 0
+#define WT_FIXED64 1
+#define WT_LEN 2
+#define WT_FIXED32 5
+
+/* Serial message fields */
+#define SERIAL_SUBJECT 1
+#define SERIAL_POINTS 2
+
+/* Point message fields */
+#define POINT_TYPE 2
+#define POINT_VALUE 4
+#define POINT_TIME 5
+#define POINT_TEXT 8
+#define POINT_KEY 11
+#define POINT_TOMBSTONE 12
+#define POINT_INDEX 13
+
+/* Timestamp message fields */
+#define TIMESTAMP_SECONDS 1
+#define TIMESTAMP_NANOS 2
+
+uint16_t siot_crc16(const uint8_t *data, size_t len) {
+  uint16_t crc = 0;
+  size_t i;
+  int b;
+
+  for (i = 0; i < len; i++) {
+    crc ^= data[i];
+    for (b = 0; b < 8; b++) {
+      if (crc & 1) {
+        crc = (crc >> 1) ^ 0x8408;
+      } else {
+        crc >>= 1;
+      }
+    }
+  }
+
+  return crc;
+}
+
+int siot_cobs_encode(const uint8_t *data, size_t len, uint8_t *out,
+                     size_t out_size) {
+  size_t code_i = 0;
+  size_t o = 1;
+  uint8_t code = 1;
+  size_t i;
+
+  if (out_size < len + len / 254 + 1) {
+    return SIOT_ERR_SPACE;
+  }
+
+  for (i = 0; i < len; i++) {
+    if (data[i] == 0) {
+      out[code_i] = code;
+      code_i = o++;
+      code = 1;
+      continue;
+    }
+
+    out[o++] = data[i];
+    code++;
+
+    if (code == 0xff) {
+      out[code_i] = code;
+      code_i = o++;
+      code = 1;
+    }
+  }
+
+  out[code_i] = code;
+
+  return (int)o;
+}
+
+int siot_cobs_decode(const uint8_t *frame, size_t len, uint8_t *out,
+                     size_t out_size) {
+  size_t i = 0;
+  size_t o = 0;
+  uint8_t code;
+  uint8_t j;
+
+  while (i < len) {
+    code = frame[i++];
+    if (code == 0) {
+      return SIOT_ERR_MALFORMED;
+    }
+
+    for (j = 1; j < code; j++) {
+      if (i >= len || frame[i] == 0) {
+        return SIOT_ERR_MALFORMED;
+      }
+      if (o >= out_size) {
+        return SIOT_ERR_SPACE;
+      }
+      out[o++] = frame[i++];
+    }
+
+    if (code != 0xff && i < len) {
+      if (o >= out_size) {
+        return SIOT_ERR_SPACE;
+      }
+      out[o++] = 0;
+    }
+  }
+
+  return (int)o;
+}
+
+/* writer appends protobuf data to a buffer, or only counts bytes if buf is
+ * NULL */
+typedef struct {
+  uint8_t *buf;
+  size_t len;
+  size_t size;
+  bool overflow;
+} writer_t;
+
+static void put_byte(writer_t *w, uint8_t b) {
+  if (w->buf == NULL) {
+    w->len++;
+    return;
+  }
+  if (w->len >= w->size) {
+    w->overflow = true;
+    return;
+  }
+  w->buf[w->len++] = b;
+}
+
+static void put_varint(writer_t *w, uint64_t v) {
+  while (v >= 0x80) {
+    put_byte(w, (uint8_t)(v | 0x80));
+    v >>= 7;
+  }
+  put_byte(w, (uint8_t)v);
+}
+
+static void put_tag(writer_t *w, uint32_t field, uint8_t wire_type) {
+  put_varint(w, ((uint64_t)field << 3) | wire_type);
+}
+
+static void put_fixed32(writer_t *w, uint32_t field, float f) {
+  uint32_t v;
+  int i;
+
+  memcpy(&v, &f, sizeof(v));
+  put_tag(w, field, WT_FIXED32);
+  for (i = 0; i < 4; i++) {
+    put_byte(w, (uint8_t)(v >> (8 * i)));
+  }
+}
+
+static void put_string(writer_t *w, uint32_t field, const char *s) {
+  size_t len = strlen(s);
+  size_t i;
+
+  put_tag(w, field, WT_LEN);
+  put_varint(w, len);
+  for (i = 0; i < len; i++) {
+    put_byte(w, (uint8_t)s[i]);
+  }
+}
+
+static size_t varint_len(uint64_t v) {
+  size_t ret = 1;
+  while (v >= 0x80) {
+    v >>= 7;
+    ret++;
+  }
+  return ret;
+}
+
+static size_t timestamp_len(const siot_point_t *p) {
+  size_t ret = 0;
+  if (p->time_sec != 0) {
+    ret += 1 + varint_len((uint64_t)p->time_sec);
+  }
+  if (p->time_nsec != 0) {
+    ret += 1 + varint_len((uint64_t)(int64_t)p->time_nsec);
+  }
+  return ret;
+}
+
+/* fields are written in field number order, with default values omitted,
+ * the same as the Go protobuf encoder */
+static void put_point_fields(writer_t *w, const siot_point_t *p) {
+  if (p->type[0] != 0) {
+    put_string(w, POINT_TYPE, p->type);
+  }
+  if (p->value != 0) {
+    put_fixed32(w, POINT_VALUE, p->value);
+  }
+
+  /* the time is always sent */
+  put_tag(w, POINT_TIME, WT_LEN);
+  put_varint(w, timestamp_len(p));
+  if (p->time_sec != 0) {
+    put_tag(w, TIMESTAMP_SECONDS, WT_VARINT);
+    put_varint(w, (uint64_t)p->time_sec);
+  }
+  if (p->time_nsec != 0) {
+    put_tag(w, TIMESTAMP_NANOS, WT_VARINT);
+    put_varint(w, (uint64_t)(int64_t)p->time_nsec);
+  }
+
+  if (p->text[0] != 0) {
+    put_string(w, POINT_TEXT, p->text);
+  }
+  if (p->key[0] != 0) {
+    put_string(w, POINT_KEY, p->key);
+  }
+  if (p->tombstone != 0) {
+    put_tag(w, POINT_TOMBSTONE, WT_VARINT);
+    put_varint(w, (uint64_t)(int64_t)p->tombstone);
+  }
+  if (p->index != 0) {
+    put_fixed32(w, POINT_INDEX, p->index);
+  }
+}
+
+int siot_packet_encode(const siot_packet_t *p, uint8_t *out, size_t out_size) {
+  writer_t w = {out, 0, out_size, false};
+  writer_t count;
+  uint16_t crc;
+  size_t i;
+
+  if (p->num_points > SIOT_MAX_POINTS) {
+    return SIOT_ERR_TOO_MANY;
+  }
+
+  put_byte(&w, p->seq);
+
+  if (p->subject[0] != 0) {
+    put_string(&w, SERIAL_SUBJECT, p->subject);
+  }
+
+  for (i = 0; i < p->num_points; i++) {
+    /* the point length is needed before the point is written */
+    count.buf = NULL;
+    count.len = 0;
+    count.size = 0;
+    count.overflow = false;
+    put_point_fields(&count, &p->points[i]);
+
+    put_tag(&w, SERIAL_POINTS, WT_LEN);
+    put_varint(&w, count.len);
+    put_point_fields(&w, &p->points[i]);
+  }
+
+  crc = siot_crc16(out, w.overflow ? 0 : w.len);
+  put_byte(&w, (uint8_t)crc);
+  put_byte(&w, (uint8_t)(crc >> 8));
+
+  if (w.overflow) {
+    return SIOT_ERR_SPACE;
+  }
+
+  return (int)w.len;
+}
+
+/* reader reads protobuf data from a buffer */
+typedef struct {
+  const uint8_t *buf;
+  size_t len;
+  size_t pos;
+} reader_t;
+
+static int get_varint(reader_t *r, uint64_t *v) {
+  int shift = 0;
+  uint8_t b;
+
+  *v = 0;
+  do {
+    if (r->pos >= r->len || shift > 63) {
+      return SIOT_ERR_MALFORMED;
+    }
+    b = r->buf[r->pos++];
+    *v |= (uint64_t)(b & 0x7f) << shift;
+    shift += 7;
+  } while (b & 0x80);
+
+  return SIOT_OK;
+}
+
+static int get_fixed32(reader_t *r, float *f) {
+  uint32_t v = 0;
+  int i;
+
+  if (r->len - r->pos < 4) {
+    return SIOT_ERR_MALFORMED;
+  }
+  for (i = 0; i < 4; i++) {
+    v |= (uint32_t)r->buf[r->pos++] << (8 * i);
+  }
+  memcpy(f, &v, sizeof(v));
+
+  return SIOT_OK;
+}
+
+/* get_len reads a length delimited field into sub */
+static int get_len(reader_t *r, reader_t *sub) {
+  uint64_t len;
+  int err = get_varint(r, &len);
+  if (err) {
+    return err;
+  }
+  if (len > r->len - r->pos) {
+    return SIOT_ERR_MALFORMED;
+  }
+  sub->buf = r->buf + r->pos;
+  sub->len = (size_t)len;
+  sub->pos = 0;
+  r->pos += (size_t)len;
+  return SIOT_OK;
+}
+
+static int get_string(reader_t *r, char *s, size_t size) {
+  reader_t sub;
+  int err = get_len(r, &sub);
+  if (err) {
+    return err;
+  }
+  if (sub.len >= size) {
+    return SIOT_ERR_TOO_MANY;
+  }
+  memcpy(s, sub.buf, sub.len);
+  s[sub.len] = 0;
+  return SIOT_OK;
+}
+
+static int skip_field(reader_t *r, uint8_t wire_type) {
+  uint64_t v;
+  reader_t sub;
+
+  switch (wire_type) {
+  case WT_VARINT:
+    return get_varint(r, &v);
+  case WT_FIXED64:
+    if (r->len - r->pos < 8) {
+      return SIOT_ERR_MALFORMED;
+    }
+    r->pos += 8;
+    return SIOT_OK;
+  case WT_LEN:
+    return get_len(r, &sub);
+  case WT_FIXED32:
+    if (r->len - r->pos < 4) {
+      return SIOT_ERR_MALFORMED;
+    }
+    r->pos += 4;
+    return SIOT_OK;
+  default:
+    return SIOT_ERR_MALFORMED;
+  }
+}
+
+static int decode_timestamp(reader_t *r, siot_point_t *p) {
+  uint64_t tag, v;
+  int err;
+
+  while (r->pos < r->len) {
+    err = get_varint(r, &tag);
+    if (err) {
+      return err;
+    }
+
+    if (tag == ((TIMESTAMP_SECONDS << 3) | WT_VARINT)) {
+      err = get_varint(r, &v);
+      p->time_sec = (int64_t)v;
+    } else if (tag == ((TIMESTAMP_NANOS << 3) | WT_VARINT)) {
+      err = get_varint(r, &v);
+      p->time_nsec = (int32_t)v;
+    } else {
+      err = skip_field(r, (uint8_t)(tag & 7));
+    }
+
+    if (err) {
+      return err;
+    }
+  }
+
+  return SIOT_OK;
+}
+
+static int decode_point(reader_t *r, siot_point_t *p) {
+  uint64_t tag, v;
+  reader_t sub;
+  int err;
+
+  memset(p, 0, sizeof(*p));
+
+  while (r->pos < r->len) {
+    err = get_varint(r, &tag);
+    if (err) {
+      return err;
+    }
+
+    switch (tag) {
+    case (POINT_TYPE << 3) | WT_LEN:
+      err = get_string(r, p->type, sizeof(p->type));
+      break;
+    case (POINT_VALUE << 3) | WT_FIXED32:
+      err = get_fixed32(r, &p->value);
+      break;
+    case (POINT_TIME << 3) | WT_LEN:
+      err = get_len(r, &sub);
+      if (!err) {
+        err = decode_timestamp(&sub, p);
+      }
+      break;
+    case (POINT_TEXT << 3) | WT_LEN:
+      err = get_string(r, p->text, sizeof(p->text));
+      break;
+    case (POINT_KEY << 3) | WT_LEN:
+      err = get_string(r, p->key, sizeof(p->key));
+      break;
+    case (POINT_TOMBSTONE << 3) | WT_VARINT:
+      err = get_varint(r, &v);
+      p->tombstone = (int32_t)v;
+      break;
+    case (POINT_INDEX << 3) | WT_FIXED32:
+      err = get_fixed32(r, &p->index);
+      break;
+    default:
+      /* data, origin, meta, quality, and future fields */
+      err = skip_field(r, (uint8_t)(tag & 7));
+    }
+
+    if (err) {
+      return err;
+    }
+  }
+
+  return SIOT_OK;
+}
+
+int siot_packet_decode(const uint8_t *data, size_t len, siot_packet_t *p) {
+  reader_t r;
+  reader_t sub;
+  uint64_t tag;
+  uint16_t crc;
+  int err;
+
+  memset(p, 0, sizeof(*p));
+
+  if (len < 3) {
+    return SIOT_ERR_MALFORMED;
+  }
+
+  crc = (uint16_t)(data[len - 2] | (data[len - 1] << 8));
+  if (crc != siot_crc16(data, len - 2)) {
+    return SIOT_ERR_CRC;
+  }
+
+  p->seq = data[0];
+
+  r.buf = data + 1;
+  r.len = len - 3;
+  r.pos = 0;
+
+  while (r.pos < r.len) {
+    err = get_varint(&r, &tag);
+    if (err) {
+      return err;
+    }
+
+    if (tag == ((SERIAL_SUBJECT << 3) | WT_LEN)) {
+      err = get_string(&r, p->subject, sizeof(p->subject));
+    } else if (tag == ((SERIAL_POINTS << 3) | WT_LEN)) {
+      if (p->num_points >= SIOT_MAX_POINTS) {
+        return SIOT_ERR_TOO_MANY;
+      }
+      err = get_len(&r, &sub);
+      if (!err) {
+        err = decode_point(&sub, &p->points[p->num_points++]);
+      }
+    } else {
+      err = skip_field(&r, (uint8_t)(tag & 7));
+    }
+
+    if (err) {
+      return err;
+    }
+  }
+
+  return SIOT_OK;
+}
+
+int siot_frame_encode(const siot_packet_t *p, uint8_t *out, size_t out_size) {
+  uint8_t packet[SIOT_MAX_PACKET];
+  int len, ret;
+
+  len = siot_packet_encode(p, packet, sizeof(packet));
+  if (len < 0) {
+    return len;
+  }
+
+  if (out_size < 1) {
+    return SIOT_ERR_SPACE;
+  }
+
+  ret = siot_cobs_encode(packet, (size_t)len, out, out_size - 1);
+  if (ret < 0) {
+    return ret;
+  }
+
+  out[ret++] = 0;
+
+  return ret;
+}
+
+void siot_rx_init(siot_rx_t *rx) {
+  rx->len = 0;
+  rx->overflow = false;
+}
+
+int siot_rx_byte(siot_rx_t *rx, uint8_t b, siot_packet_t *packet) {
+  uint8_t decoded[SIOT_MAX_PACKET];
+  int len, err;
+
+  if (b != 0) {
+    if (rx->len >= sizeof(rx->buf)) {
+      rx->overflow = true;
+    } else {
+      rx->buf[rx->len++] = b;
+    }
+    return 0;
+  }
+
+  /* end of frame */
+  if (rx->len == 0) {
+    return 0;
+  }
+
+  if (rx->overflow) {
+    siot_rx_init(rx);
+    return SIOT_ERR_SPACE;
+  }
+
+  len = siot_cobs_decode(rx->buf, rx->len, decoded, sizeof(decoded));
+  siot_rx_init(rx);
+  if (len < 0) {
+    return len;
+  }
+
+  err = siot_packet_decode(decoded, (size_t)len, packet);
+  if (err) {
+    return err;
+  }
+
+  return 1;
+}
+
+void siot_point_init(siot_point_t *p, const char *type, float value,
+                     int64_t time_sec, int32_t time_nsec) {
+  memset(p, 0, sizeof(*p));
+  strncpy(p->type, type, sizeof(p->type) - 1);
+  p->value = value;
+  p->time_sec = time_sec;
+  p->time_nsec = time_nsec;
+}
+
+void siot_time_fix(siot_point_t *points, size_t n, int64_t offset_sec,
+                   int64_t now_sec) {
+  size_t i;
+
+  for (i = 0; i < n; i++) {
+    if (points[i].time_sec < SIOT_VALID_TIME) {
+      points[i].time_sec += offset_sec;
+    }
+    if (points[i].time_sec > now_sec) {
+      points[i].time_sec = now_sec;
+      points[i].time_nsec = 0;
+    }
+  }
+}
+
+void siot_link_init(siot_link_t *l,
+                    int (*write)(void *ctx, const uint8_t *data, size_t len),
+                    void (*on_packet)(void *ctx, const siot_packet_t *p),
+                    void *ctx) {
+  memset(l, 0, sizeof(*l));
+  l->write = write;
+  l->on_packet = on_packet;
+  l->ctx = ctx;
+  l->ack_timeout_ms = 250;
+  l->max_retries = 3;
+  siot_rx_init(&l->rx_state);
+}
+
+static void link_write(siot_link_t *l, const uint8_t *data, size_t len) {
+  if (l->write(l->ctx, data, len) < 0) {
+    l->errors++;
+  }
+}
+
+static void link_ack(siot_link_t *l, uint8_t seq) {
+  siot_packet_t *ack = &l->rx_packet;
+  uint8_t frame[16];
+  int len;
+
+  /* the received packet has already been handled, so rx_packet is reused
+   * for the ack */
+  ack->seq = seq;
+  ack->subject[0] = 0;
+  ack->num_points = 0;
+
+  len = siot_frame_encode(ack, frame, sizeof(frame));
+  if (len > 0) {
+    link_write(l, frame, (size_t)len);
+  }
+}
+
+int siot_link_send(siot_link_t *l, siot_packet_t *p, uint32_t now_ms) {
+  int len;
+
+  if (l->waiting) {
+    return SIOT_ERR_BUSY;
+  }
+
+  /* sequence numbers are not shared with SIOT, so 0 is fine */
+  p->seq = l->seq++;
+
+  len = siot_frame_encode(p, l->frame, sizeof(l->frame));
+  if (len < 0) {
+    return len;
+  }
+
+  l->frame_len = (size_t)len;
+  l->tx++;
+
+  link_write(l, l->frame, l->frame_len);
+
+  /* high rate data is not acked */
+  if (strcmp(p->subject, SIOT_SUBJECT_HIGH_RATE) != 0) {
+    l->waiting = true;
+    l->retries = 0;
+    l->sent_ms = now_ms;
+  }
+
+  return SIOT_OK;
+}
+
+void siot_link_rx(siot_link_t *l, const uint8_t *data, size_t len,
+                  uint32_t now_ms) {
+  siot_packet_t *p = &l->rx_packet;
+  size_t i, j;
+  int ret;
+
+  (void)now_ms;
+
+  for (i = 0; i < len; i++) {
+    ret = siot_rx_byte(&l->rx_state, data[i], p);
+    if (ret < 0) {
+      l->errors++;
+      continue;
+    }
+    if (ret == 0) {
+      continue;
+    }
+
+    if (p->num_points == 0) {
+      /* ack */
+      if (l->waiting && p->seq == (uint8_t)(l->seq - 1)) {
+        l->waiting = false;
+        l->online = true;
+      }
+      continue;
+    }
+
+    l->rx++;
+
+    if (p->subject[0] == 0 && l->on_time != NULL) {
+      for (j = 0; j < p->num_points; j++) {
+        if (strcmp(p->points[j].type, SIOT_POINT_TYPE_CURRENT_TIME) == 0) {
+          l->on_time(l->ctx, p->points[j].time_sec, p->points[j].time_nsec);
+        }
+      }
+    }
+
+    if (l->on_packet != NULL) {
+      l->on_packet(l->ctx, p);
+    }
+
+    link_ack(l, p->seq);
+  }
+}
+
+void siot_link_poll(siot_link_t *l, uint32_t now_ms) {
+  if (!l->waiting || (uint32_t)(now_ms - l->sent_ms) < l->ack_timeout_ms) {
+    return;
+  }
+
+  if (l->retries >= l->max_retries) {
+    /* drop the packet */
+    l->waiting = false;
+    l->online = false;
+    l->errors++;
+    return;
+  }
+
+  l->retries++;
+  l->sent_ms = now_ms;
+  link_write(l, l->frame, l->frame_len);
+}
+
+bool siot_link_busy(const siot_link_t *l) { return l->waiting; }
+
+int siot_link_request_time(siot_link_t *l, int64_t mcu_sec, uint32_t now_ms) {
+  /* rx_packet is only used while receiving, and packets are large for a MCU
+   * stack */
+  siot_packet_t *p = &l->rx_packet;
+
+  memset(p, 0, sizeof(*p));
+  siot_point_init(&p->points[0], SIOT_POINT_TYPE_CURRENT_TIME, 0, mcu_sec, 0);
+  p->num_points = 1;
+
+  return siot_link_send(l, p, now_ms);
+}
@@ -0,0 +1,209 @@
+/*
+ * siot_serial - Simple IoT serial (MCU) client library
+ *
+ * Implements the MCU side of the SIOT serial protocol (see
+ * docs/ref/serial.md): COBS framing, CRC-16/KERMIT packet checks, protobuf
+ * encoding of points, acks and retries, and time sync. The library does not
+ * allocate memory and only depends on the C99 standard library.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+#ifndef SIOT_SERIAL_H
+#define SIOT_SERIAL_H
+
+#include <stdbool.h>
+#include <stddef.h>
+#include <stdint.h>
+
+#ifdef __cplusplus
+extern "C" {
+#endif
+
+/* Sizes can be overridden at compile time to fit the MCU. Strings longer
+ * than the buffers are a decode error. */
+#ifndef SIOT_TYPE_LEN
+#define SIOT_TYPE_LEN 32
+#endif
+
+#ifndef SIOT_KEY_LEN
+#define SIOT_KEY_LEN 40
+#endif
+
+#ifndef SIOT_TEXT_LEN
+#define SIOT_TEXT_LEN 64
+#endif
+
+#ifndef SIOT_SUBJECT_LEN
+#define SIOT_SUBJECT_LEN 64
+#endif
+
+#ifndef SIOT_MAX_POINTS
+#define SIOT_MAX_POINTS 8
+#endif
+
+/* maximum size of a decoded packet (sequence, protobuf, and CRC) */
+#ifndef SIOT_MAX_PACKET
+#define SIOT_MAX_PACKET 512
+#endif
+
+/* maximum size of a COBS encoded frame, including the 0 delimiter */
+#define SIOT_MAX_FRAME (SIOT_MAX_PACKET + SIOT_MAX_PACKET / 254 + 2)
+
+/* time sync: points with a time before this (2020-01-01) were created before
+ * the MCU had a valid time */
+#define SIOT_VALID_TIME 1577836800
+
+/* point type used for time sync */
+#define SIOT_POINT_TYPE_CURRENT_TIME "currentTime"
+
+/* subject used for high rate data */
+#define SIOT_SUBJECT_HIGH_RATE "phr"
+
+/* error codes, all functions that return int return a negative value on
+ * error */
+#define SIOT_OK 0
+#define SIOT_ERR_SPACE -1     /* output buffer too small */
+#define SIOT_ERR_CRC -2       /* CRC check failed */
+#define SIOT_ERR_MALFORMED -3 /* packet or protobuf is not valid */
+#define SIOT_ERR_TOO_MANY -4  /* too many points, or a string is too long */
+#define SIOT_ERR_BUSY -5      /* a packet is waiting for an ack */
+
+/* siot_point_t is a SIOT point (see docs/ref/data.md). Strings are NUL
+ * terminated. The time is seconds and nanoseconds since the Unix epoch. */
+typedef struct {
+  char type[SIOT_TYPE_LEN];
+  char key[SIOT_KEY_LEN];
+  char text[SIOT_TEXT_LEN];
+  float value;
+  float index;
+  int32_t tombstone;
+  int64_t time_sec;
+  int32_t time_nsec;
+} siot_point_t;
+
+/* siot_packet_t is a decoded serial packet. A packet without points is an
+ * ack. The subject is blank for points for the MCU root node. */
+typedef struct {
+  uint8_t seq;
+  char subject[SIOT_SUBJECT_LEN];
+  siot_point_t points[SIOT_MAX_POINTS];
+  size_t num_points;
+} siot_packet_t;
+
+/* siot_crc16 returns the CRC-16/KERMIT of data */
+uint16_t siot_crc16(const uint8_t *data, size_t len);
+
+/* siot_cobs_encode COBS encodes data into out and returns the encoded
+ * length, or SIOT_ERR_SPACE. The 0 delimiter is not added. */
+int siot_cobs_encode(const uint8_t *data, size_t len, uint8_t *out,
+                     size_t out_size);
+
+/* siot_cobs_decode decodes a COBS frame (without the 0 delimiter) into out
+ * and returns the decoded length, or an error. */
+int siot_cobs_decode(const uint8_t *frame, size_t len, uint8_t *out,
+                     size_t out_size);
+
+/* siot_packet_encode encodes a packet (sequence, Serial protobuf, and CRC)
+ * and returns the length, or an error. */
+int siot_packet_encode(const siot_packet_t *p, uint8_t *out, size_t out_size);
+
+/* siot_packet_decode decodes and checks a packet. Unknown protobuf fields
+ * are skipped. */
+int siot_packet_decode(const uint8_t *data, size_t len, siot_packet_t *p);
+
+/* siot_frame_encode encodes a packet as a COBS frame with a 0 delimiter,
+ * ready to be written to the serial port. */
+int siot_frame_encode(const siot_packet_t *p, uint8_t *out, size_t out_size);
+
+/* siot_rx_t collects received bytes into frames */
+typedef struct {
+  uint8_t buf[SIOT_MAX_FRAME];
+  size_t len;
+  bool overflow;
+} siot_rx_t;
+
+void siot_rx_init(siot_rx_t *rx);
+
+/* siot_rx_byte adds a received byte. When a complete frame is received, it
+ * is decoded into packet and 1 is returned. 0 is returned if more data is
+ * needed, and an error if a frame could not be decoded. */
+int siot_rx_byte(siot_rx_t *rx, uint8_t b, siot_packet_t *packet);
+
+/* siot_point_init clears a point and sets the type, value, and time */
+void siot_point_init(siot_point_t *p, const char *type, float value,
+                     int64_t time_sec, int32_t time_nsec);
+
+/* siot_time_fix applies a time sync to points, as described in the serial
+ * protocol spec: offset_sec is added to points with a time before
+ * SIOT_VALID_TIME, and points with a time after now_sec are set to now_sec.
+ */
+void siot_time_fix(siot_point_t *points, size_t n, int64_t offset_sec,
+                   int64_t now_sec);
+
+/* siot_link_t sends packets with acks and retries, acks received packets,
+ * and handles time sync. All times are in milliseconds from a monotonic
+ * clock supplied by the application. */
+typedef struct siot_link siot_link_t;
+
+struct siot_link {
+  /* write is called to write a frame to the serial port */
+  int (*write)(void *ctx, const uint8_t *data, size_t len);
+  /* on_packet is called for each packet with points that is received */
+  void (*on_packet)(void *ctx, const siot_packet_t *p);
+  /* on_time is called when SIOT sends the current time, and can be NULL */
+  void (*on_time)(void *ctx, int64_t sec, int32_t nsec);
+  void *ctx;
+
+  uint32_t ack_timeout_ms; /* default 250 */
+  uint8_t max_retries;     /* default 3 */
+
+  /* true if the last packet sent was acked; false after max_retries */
+  bool online;
+
+  /* statistics */
+  uint32_t tx;
+  uint32_t rx;
+  uint32_t errors;
+
+  /* internal state */
+  uint8_t seq;
+  bool waiting;
+  uint8_t retries;
+  uint32_t sent_ms;
+  uint8_t frame[SIOT_MAX_FRAME];
+  size_t frame_len;
+  siot_rx_t rx_state;
+  siot_packet_t rx_packet;
+};
+
+void siot_link_init(siot_link_t *l,
+                    int (*write)(void *ctx, const uint8_t *data, size_t len),
+                    void (*on_packet)(void *ctx, const siot_packet_t *p),
+                    void *ctx);
+
+/* siot_link_send sends a packet. The sequence number is assigned by the
+ * link. SIOT_ERR_BUSY is returned if the previous packet has not been acked
+ * or dropped yet. High rate (phr) packets are not acked or retried. */
+int siot_link_send(siot_link_t *l, siot_packet_t *p, uint32_t now_ms);
+
+/* siot_link_rx processes data received from the serial port */
+void siot_link_rx(siot_link_t *l, const uint8_t *data, size_t len,
+                  uint32_t now_ms);
+
+/* siot_link_poll must be called periodically to retry packets */
+void siot_link_poll(siot_link_t *l, uint32_t now_ms);
+
+/* siot_link_busy returns true if a packet is waiting for an ack */
+bool siot_link_busy(const siot_link_t *l);
+
+/* siot_link_request_time asks SIOT for the current time. The MCU time is
+ * sent with the request. SIOT responds with a currentTime point, which is
+ * passed to on_time. */
+int siot_link_request_time(siot_link_t *l, int64_t mcu_sec, uint32_t now_ms);
+
+#ifdef __cplusplus
+}
+#endif
+
+#endif /* SIOT_SERIAL_H */
@@ -0,0 +1,340 @@
+/*
+ * Tests for siot_serial. The test vectors were generated with the Go
+ * client.SerialEncode function and dim13/cobs encoder used by SIOT.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+#include "../siot_serial.h"
+
+#include <stdio.h>
+#include <stdlib.h>
+#include <string.h>
+
+static int failures;
+
+#define CHECK(cond)                                                          \
+  do {                                                                       \
+    if (!(cond)) {                                                           \
+      printf("%s:%d: check failed: %s\n", __FILE__, __LINE__, #cond);        \
+      failures++;                                                            \
+    }                                                                        \
+  } while (0)
+
+static const uint8_t ack_packet[] = {0x0a, 0x5a, 0xaf};
+
+static const uint8_t ack_frame[] = {0x04, 0x0a, 0x5a, 0xaf, 0x00};
+
+/* uptime point, value 5, time 0 */
+static const uint8_t uptime_packet[] = {
+    0x0a, 0x12, 0x0f, 0x12, 0x06, 0x75, 0x70, 0x74, 0x69, 0x6d,
+    0x65, 0x25, 0x00, 0x00, 0xa0, 0x40, 0x2a, 0x00, 0x9a, 0x0f};
+
+static const uint8_t uptime_frame[] = {
+    0x0d, 0x0a, 0x12, 0x0f, 0x12, 0x06, 0x75, 0x70, 0x74, 0x69, 0x6d,
+    0x65, 0x25, 0x01, 0x04, 0xa0, 0x40, 0x2a, 0x03, 0x9a, 0x0f, 0x00};
+
+/* subject phr, all point fields set */
+static const uint8_t full_packet[] = {
+    0x03, 0x0a, 0x03, 0x70, 0x68, 0x72, 0x12, 0x26, 0x12, 0x04, 0x74, 0x65,
+    0x6d, 0x70, 0x25, 0x00, 0x00, 0xc0, 0xbf, 0x2a, 0x0b, 0x08, 0x80, 0xe2,
+    0xcf, 0xaa, 0x06, 0x10, 0x95, 0x9a, 0xef, 0x3a, 0x42, 0x02, 0x68, 0x69,
+    0x5a, 0x01, 0x61, 0x60, 0x01, 0x6d, 0x00, 0x00, 0x00, 0x40, 0x97, 0xe8};
+
+/* two points */
+static const uint8_t two_packet[] = {
+    0xc8, 0x12, 0x1a, 0x12, 0x0b, 0x70, 0x75, 0x6d, 0x70, 0x53, 0x65, 0x74,
+    0x74, 0x69, 0x6e, 0x67, 0x25, 0x00, 0x00, 0x80, 0x3f, 0x2a, 0x06, 0x08,
+    0x80, 0xc2, 0xaf, 0xf0, 0x05, 0x12, 0x18, 0x12, 0x0b, 0x64, 0x65, 0x73,
+    0x63, 0x72, 0x69, 0x70, 0x74, 0x69, 0x6f, 0x6e, 0x2a, 0x06, 0x08, 0x81,
+    0xc2, 0xaf, 0xf0, 0x05, 0x42, 0x01, 0x78, 0x54, 0x3b};
+
+static void test_crc(void) {
+  const char *check = "123456789";
+  CHECK(siot_crc16((const uint8_t *)check, strlen(check)) == 0x2189);
+}
+
+static void test_cobs(void) {
+  uint8_t data[600];
+  uint8_t enc[700];
+  uint8_t dec[600];
+  size_t sizes[] = {0, 1, 253, 254, 255, 508, 600};
+  size_t s, i;
+  int len;
+
+  for (s = 0; s < sizeof(sizes) / sizeof(sizes[0]); s++) {
+    for (i = 0; i < sizes[s]; i++) {
+      /* include zeros, and long runs without zeros */
+      data[i] = (uint8_t)(i % 300 == 17 ? 0 : (i % 255) + 1);
+    }
+
+    len = siot_cobs_encode(data, sizes[s], enc, sizeof(enc));
+    CHECK(len > 0 && (size_t)len <= sizes[s] + sizes[s] / 254 + 1);
+    CHECK(memchr(enc, 0, (size_t)len) == NULL);
+
+    len = siot_cobs_decode(enc, (size_t)len, dec, sizeof(dec));
+    CHECK(len == (int)sizes[s]);
+    CHECK(memcmp(data, dec, sizes[s]) == 0);
+  }
+
+  CHECK(siot_cobs_encode(data, 10, enc, 10) == SIOT_ERR_SPACE);
+}
+
+static void test_encode(void) {
+  siot_packet_t p;
+  uint8_t out[SIOT_MAX_PACKET];
+  uint8_t frame[SIOT_MAX_FRAME];
+  int len;
+
+  memset(&p, 0, sizeof(p));
+  p.seq = 10;
+  len = siot_packet_encode(&p, out, sizeof(out));
+  CHECK(len == sizeof(ack_packet));
+  CHECK(memcmp(out, ack_packet, sizeof(ack_packet)) == 0);
+
+  len = siot_frame_encode(&p, frame, sizeof(frame));
+  CHECK(len == sizeof(ack_frame));
+  CHECK(memcmp(frame, ack_frame, sizeof(ack_frame)) == 0);
+
+  siot_point_init(&p.points[0], "uptime", 5, 0, 0);
+  p.num_points = 1;
+  len = siot_packet_encode(&p, out, sizeof(out));
+  CHECK(len == sizeof(uptime_packet));
+  CHECK(memcmp(out, uptime_packet, sizeof(uptime_packet)) == 0);
+
+  len = siot_frame_encode(&p, frame, sizeof(frame));
+  CHECK(len == sizeof(uptime_frame));
+  CHECK(memcmp(frame, uptime_frame, sizeof(uptime_frame)) == 0);
+
+  memset(&p, 0, sizeof(p));
+  p.seq = 3;
+  strcpy(p.subject, SIOT_SUBJECT_HIGH_RATE);
+  siot_point_init(&p.points[0], "temp", -1.5f, 1700000000, 123456789);
+  strcpy(p.points[0].key, "a");
+  strcpy(p.points[0].text, "hi");
+  p.points[0].index = 2;
+  p.points[0].tombstone = 1;
+  p.num_points = 1;
+  len = siot_packet_encode(&p, out, sizeof(out));
+  CHECK(len == sizeof(full_packet));
+  CHECK(memcmp(out, full_packet, sizeof(full_packet)) == 0);
+
+  CHECK(siot_packet_encode(&p, out, 20) == SIOT_ERR_SPACE);
+}
+
+static void test_decode(void) {
+  siot_packet_t p;
+  uint8_t bad[sizeof(full_packet)];
+
+  CHECK(siot_packet_decode(ack_packet, sizeof(ack_packet), &p) == SIOT_OK);
+  CHECK(p.seq == 10);
+  CHECK(p.num_points == 0);
+
+  CHECK(siot_packet_decode(full_packet, sizeof(full_packet), &p) == SIOT_OK);
+  CHECK(p.seq == 3);
+  CHECK(strcmp(p.subject, "phr") == 0);
+  CHECK(p.num_points == 1);
+  CHECK(strcmp(p.points[0].type, "temp") == 0);
+  CHECK(strcmp(p.points[0].key, "a") == 0);
+  CHECK(strcmp(p.points[0].text, "hi") == 0);
+  CHECK(p.points[0].value == -1.5f);
+  CHECK(p.points[0].index == 2);
+  CHECK(p.points[0].tombstone == 1);
+  CHECK(p.points[0].time_sec == 1700000000);
+  CHECK(p.points[0].time_nsec == 123456789);
+
+  CHECK(siot_packet_decode(two_packet, sizeof(two_packet), &p) == SIOT_OK);
+  CHECK(p.seq == 200);
+  CHECK(p.subject[0] == 0);
+  CHECK(p.num_points == 2);
+  CHECK(strcmp(p.points[0].type, "pumpSetting") == 0);
+  CHECK(p.points[0].value == 1);
+  CHECK(p.points[0].time_sec == 1577836800);
+  CHECK(strcmp(p.points[1].type, "description") == 0);
+  CHECK(strcmp(p.points[1].text, "x") == 0);
+
+  memcpy(bad, full_packet, sizeof(bad));
+  bad[5] ^= 1;
+  CHECK(siot_packet_decode(bad, sizeof(bad), &p) == SIOT_ERR_CRC);
+  CHECK(siot_packet_decode(bad, 2, &p) == SIOT_ERR_MALFORMED);
+}
+
+static void test_rx(void) {
+  siot_rx_t rx;
+  siot_packet_t p;
+  size_t i;
+  int ret = 0;
+
+  siot_rx_init(&rx);
+
+  /* SIOT sends a 0 before each frame */
+  CHECK(siot_rx_byte(&rx, 0, &p) == 0);
+
+  for (i = 0; i < sizeof(uptime_frame); i++) {
+    ret = siot_rx_byte(&rx, uptime_frame[i], &p);
+    if (i < sizeof(uptime_frame) - 1) {
+      CHECK(ret == 0);
+    }
+  }
+
+  CHECK(ret == 1);
+  CHECK(p.num_points == 1);
+  CHECK(strcmp(p.points[0].type, "uptime") == 0);
+  CHECK(p.points[0].value == 5);
+
+  /* garbage is reported and the next frame is received */
+  CHECK(siot_rx_byte(&rx, 0x05, &p) == 0);
+  CHECK(siot_rx_byte(&rx, 0x00, &p) < 0);
+  for (i = 0; i < sizeof(ack_frame); i++) {
+    ret = siot_rx_byte(&rx, ack_frame[i], &p);
+  }
+  CHECK(ret == 1);
+  CHECK(p.seq == 10 && p.num_points == 0);
+}
+
+static void test_time_fix(void) {
+  siot_point_t pts[3];
+
+  siot_point_init(&pts[0], "a", 0, 100, 0);
+  siot_point_init(&pts[1], "b", 0, 1700000000, 0);
+  siot_point_init(&pts[2], "c", 0, 1800000000, 5);
+
+  siot_time_fix(pts, 3, 1700000000 - 200, 1700000100);
+  CHECK(pts[0].time_sec == 1700000000 - 100);
+  CHECK(pts[1].time_sec == 1700000000);
+  CHECK(pts[2].time_sec == 1700000100 && pts[2].time_nsec == 0);
+}
+
+/* test link, SIOT side */
+typedef struct {
+  uint8_t written[4][SIOT_MAX_FRAME];
+  size_t written_len[4];
+  int writes;
+  int packets;
+  int64_t time_sec;
+} test_ctx_t;
+
+static int test_write(void *ctx, const uint8_t *data, size_t len) {
+  test_ctx_t *c = ctx;
+  if (c->writes < 4) {
+    memcpy(c->written[c->writes], data, len);
+    c->written_len[c->writes] = len;
+  }
+  c->writes++;
+  return (int)len;
+}
+
+static void test_on_packet(void *ctx, const siot_packet_t *p) {
+  test_ctx_t *c = ctx;
+  (void)p;
+  c->packets++;
+}
+
+static void test_on_time(void *ctx, int64_t sec, int32_t nsec) {
+  test_ctx_t *c = ctx;
+  (void)nsec;
+  c->time_sec = sec;
+}
+
+static void test_link(void) {
+  static siot_link_t l;
+  test_ctx_t c;
+  siot_packet_t p, rx;
+  uint8_t frame[SIOT_MAX_FRAME];
+  int len;
+
+  memset(&c, 0, sizeof(c));
+  siot_link_init(&l, test_write, test_on_packet, &c);
+  l.on_time = test_on_time;
+
+  memset(&p, 0, sizeof(p));
+  siot_point_init(&p.points[0], "uptime", 5, 0, 0);
+  p.num_points = 1;
+
+  CHECK(siot_link_send(&l, &p, 1000) == SIOT_OK);
+  CHECK(c.writes == 1);
+  CHECK(siot_link_busy(&l));
+  CHECK(siot_link_send(&l, &p, 1000) == SIOT_ERR_BUSY);
+
+  /* retry after the ack timeout */
+  siot_link_poll(&l, 1100);
+  CHECK(c.writes == 1);
+  siot_link_poll(&l, 1300);
+  CHECK(c.writes == 2);
+  CHECK(c.written_len[0] == c.written_len[1]);
+  CHECK(memcmp(c.written[0], c.written[1], c.written_len[0]) == 0);
+
+  /* ack with the wrong sequence is ignored */
+  memset(&rx, 0, sizeof(rx));
+  rx.seq = p.seq + 1;
+  len = siot_frame_encode(&rx, frame, sizeof(frame));
+  siot_link_rx(&l, frame, (size_t)len, 1350);
+  CHECK(siot_link_busy(&l));
+
+  rx.seq = p.seq;
+  len = siot_frame_encode(&rx, frame, sizeof(frame));
+  siot_link_rx(&l, frame, (size_t)len, 1400);
+  CHECK(!siot_link_busy(&l));
+  CHECK(l.online);
+
+  /* packets are dropped after max retries */
+  CHECK(siot_link_send(&l, &p, 2000) == SIOT_OK);
+  siot_link_poll(&l, 2250);
+  siot_link_poll(&l, 2500);
+  siot_link_poll(&l, 2750);
+  CHECK(c.writes == 6);
+  siot_link_poll(&l, 3000);
+  CHECK(c.writes == 6);
+  CHECK(!siot_link_busy(&l));
+  CHECK(!l.online);
+
+  /* high rate data is not acked */
+  strcpy(p.subject, SIOT_SUBJECT_HIGH_RATE);
+  CHECK(siot_link_send(&l, &p, 3000) == SIOT_OK);
+  CHECK(!siot_link_busy(&l));
+  p.subject[0] = 0;
+
+  /* received points are passed to the application and acked */
+  c.writes = 0;
+  memset(&rx, 0, sizeof(rx));
+  rx.seq = 77;
+  siot_point_init(&rx.points[0], SIOT_POINT_TYPE_CURRENT_TIME, 0, 1700000000,
+                  0);
+  rx.num_points = 1;
+  len = siot_frame_encode(&rx, frame, sizeof(frame));
+  siot_link_rx(&l, frame, (size_t)len, 4000);
+  CHECK(c.packets == 1);
+  CHECK(c.time_sec == 1700000000);
+  CHECK(c.writes == 1);
+  len = siot_cobs_decode(c.written[0], c.written_len[0] - 1, frame,
+                         sizeof(frame));
+  CHECK(siot_packet_decode(frame, (size_t)len, &rx) == SIOT_OK);
+  CHECK(rx.seq == 77 && rx.num_points == 0);
+
+  /* time request */
+  c.writes = 0;
+  CHECK(siot_link_request_time(&l, 0, 5000) == SIOT_OK);
+  len = siot_cobs_decode(c.written[0], c.written_len[0] - 1, frame,
+                         sizeof(frame));
+  CHECK(siot_packet_decode(frame, (size_t)len, &rx) == SIOT_OK);
+  CHECK(rx.num_points == 1);
+  CHECK(strcmp(rx.points[0].type, SIOT_POINT_TYPE_CURRENT_TIME) == 0);
+}
+
+int main(void) {
+  test_crc();
+  test_cobs();
+  test_encode();
+  test_decode();
+  test_rx();
+  test_time_fix();
+  test_link();
+
+  if (failures) {
+    printf("FAIL: %d checks failed\n", failures);
+    return EXIT_FAILURE;
+  }
+
+  printf("PASS\n");
+  return EXIT_SUCCESS;
+}
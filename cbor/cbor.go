@@ -0,0 +1,403 @@
+// Package cbor contains a small CBOR (RFC 8949) encoder and decoder for
+// the simple payloads sent by constrained devices.
+package cbor
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+)
+
+// maxDepth limits the nesting of arrays and maps
+const maxDepth = 16
+
+// ErrTruncated is returned if the data ends before an item is complete
+var ErrTruncated = errors.New("cbor: data truncated")
+
+type decoder struct {
+	b []byte
+	i int
+}
+
+func (d *decoder) byte() (byte, error) {
+	if d.i >= len(d.b) {
+		return 0, ErrTruncated
+	}
+	d.i++
+	return d.b[d.i-1], nil
+}
+
+func (d *decoder) bytes(n uint64) ([]byte, error) {
+	if n > uint64(len(d.b)-d.i) {
+		return nil, ErrTruncated
+	}
+	ret := d.b[d.i : d.i+int(n)]
+	d.i += int(n)
+	return ret, nil
+}
+
+// argument decodes the argument of an item head. indefinite is set for
+// indefinite length items.
+func (d *decoder) argument(info byte) (uint64, bool, error) {
+	switch {
+	case info < 24:
+		return uint64(info), false, nil
+	case info == 31:
+		return 0, true, nil
+	case info > 27:
+		return 0, false, fmt.Errorf("cbor: invalid additional info: %v", info)
+	}
+
+	b, err := d.bytes(1 << (info - 24))
+	if err != nil {
+		return 0, false, err
+	}
+
+	switch len(b) {
+	case 1:
+		return uint64(b[0]), false, nil
+	case 2:
+		return uint64(binary.BigEndian.Uint16(b)), false, nil
+	case 4:
+		return uint64(binary.BigEndian.Uint32(b)), false, nil
+	default:
+		return binary.BigEndian.Uint64(b), false, nil
+	}
+}
+
+// isBreak consumes a break code if it is next
+func (d *decoder) isBreak() (bool, error) {
+	if d.i >= len(d.b) {
+		return false, ErrTruncated
+	}
+	if d.b[d.i] == 0xff {
+		d.i++
+		return true, nil
+	}
+	return false, nil
+}
+
+func (d *decoder) str(major byte, n uint64, indefinite bool) ([]byte, error) {
+	if !indefinite {
+		return d.bytes(n)
+	}
+
+	var ret []byte
+	for {
+		brk, err := d.isBreak()
+		if err != nil {
+			return nil, err
+		}
+		if brk {
+			return ret, nil
+		}
+
+		h, err := d.byte()
+		if err != nil {
+			return nil, err
+		}
+		if h>>5 != major {
+			return nil, errors.New("cbor: invalid chunk in indefinite length string")
+		}
+
+		n, ind, err := d.argument(h & 0x1f)
+		if err != nil {
+			return nil, err
+		}
+		if ind {
+			return nil, errors.New("cbor: nested indefinite length string")
+		}
+
+		chunk, err := d.bytes(n)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, chunk...)
+	}
+}
+
+func mapKey(k interface{}) (string, error) {
+	switch x := k.(type) {
+	case string:
+		return x, nil
+	case int64:
+		return strconv.FormatInt(x, 10), nil
+	case uint64:
+		return strconv.FormatUint(x, 10), nil
+	default:
+		return "", fmt.Errorf("cbor: unsupported map key type: %T", k)
+	}
+}
+
+func (d *decoder) item(depth int) (interface{}, error) {
+	if depth > maxDepth {
+		return nil, errors.New("cbor: nesting too deep")
+	}
+
+	h, err := d.byte()
+	if err != nil {
+		return nil, err
+	}
+
+	major, info := h>>5, h&0x1f
+
+	if major == 7 {
+		return d.simple(info)
+	}
+
+	n, indefinite, err := d.argument(info)
+	if err != nil {
+		return nil, err
+	}
+
+	if indefinite && (major < 2 || major == 6) {
+		return nil, errors.New("cbor: invalid indefinite length item")
+	}
+
+	switch major {
+	case 0:
+		if n > math.MaxInt64 {
+			return n, nil
+		}
+		return int64(n), nil
+	case 1:
+		if n > math.MaxInt64 {
+			return nil, errors.New("cbor: negative integer overflow")
+		}
+		return -1 - int64(n), nil
+	case 2:
+		b, err := d.str(major, n, indefinite)
+		if err != nil {
+			return nil, err
+		}
+		return append([]byte{}, b...), nil
+	case 3:
+		b, err := d.str(major, n, indefinite)
+		return string(b), err
+	case 4:
+		var ret []interface{}
+		for i := uint64(0); indefinite || i < n; i++ {
+			if indefinite {
+				brk, err := d.isBreak()
+				if err != nil {
+					return nil, err
+				}
+				if brk {
+					break
+				}
+			}
+
+			v, err := d.item(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			ret = append(ret, v)
+		}
+		return ret, nil
+	case 5:
+		ret := make(map[string]interface{})
+		for i := uint64(0); indefinite || i < n; i++ {
+			if indefinite {
+				brk, err := d.isBreak()
+				if err != nil {
+					return nil, err
+				}
+				if brk {
+					break
+				}
+			}
+
+			k, err := d.item(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+
+			key, err := mapKey(k)
+			if err != nil {
+				return nil, err
+			}
+
+			v, err := d.item(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			ret[key] = v
+		}
+		return ret, nil
+	default:
+		// tags are ignored and the tagged item is returned
+		return d.item(depth + 1)
+	}
+}
+
+func (d *decoder) simple(info byte) (interface{}, error) {
+	switch info {
+	case 20:
+		return false, nil
+	case 21:
+		return true, nil
+	case 22, 23:
+		return nil, nil
+	case 25:
+		b, err := d.bytes(2)
+		if err != nil {
+			return nil, err
+		}
+		return halfToFloat(binary.BigEndian.Uint16(b)), nil
+	case 26:
+		b, err := d.bytes(4)
+		if err != nil {
+			return nil, err
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b))), nil
+	case 27:
+		b, err := d.bytes(8)
+		if err != nil {
+			return nil, err
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), nil
+	default:
+		return nil, fmt.Errorf("cbor: unsupported simple value: %v", info)
+	}
+}
+
+func halfToFloat(h uint16) float64 {
+	exp := int(h>>10) & 0x1f
+	mant := float64(h & 0x3ff)
+
+	var v float64
+	switch exp {
+	case 0:
+		v = math.Ldexp(mant, -24)
+	case 31:
+		if mant == 0 {
+			v = math.Inf(1)
+		} else {
+			v = math.NaN()
+		}
+	default:
+		v = math.Ldexp(mant+1024, exp-25)
+	}
+
+	if h&0x8000 != 0 {
+		return -v
+	}
+	return v
+}
+
+// Decode decodes a CBOR item. Integers are returned as int64 (or uint64 if
+// they don't fit), floats as float64, text as string, byte strings as
+// []byte, arrays as []interface{}, and maps as map[string]interface{}.
+// Integer map keys are converted to strings. Tags are ignored.
+func Decode(b []byte) (interface{}, error) {
+	d := decoder{b: b}
+	v, err := d.item(0)
+	if err != nil {
+		return nil, err
+	}
+
+	if d.i != len(b) {
+		return nil, errors.New("cbor: extra data after item")
+	}
+
+	return v, nil
+}
+
+func appendHead(b []byte, major byte, n uint64) []byte {
+	switch {
+	case n < 24:
+		return append(b, major<<5|byte(n))
+	case n <= math.MaxUint8:
+		return append(b, major<<5|24, byte(n))
+	case n <= math.MaxUint16:
+		return append(b, major<<5|25, byte(n>>8), byte(n))
+	case n <= math.MaxUint32:
+		return append(b, major<<5|26, byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+	default:
+		b = append(b, major<<5|27)
+		for i := 7; i >= 0; i-- {
+			b = append(b, byte(n>>(8*i)))
+		}
+		return b
+	}
+}
+
+func appendItem(b []byte, v interface{}, depth int) ([]byte, error) {
+	if depth > maxDepth {
+		return nil, errors.New("cbor: nesting too deep")
+	}
+
+	switch x := v.(type) {
+	case nil:
+		return append(b, 0xf6), nil
+	case bool:
+		if x {
+			return append(b, 0xf5), nil
+		}
+		return append(b, 0xf4), nil
+	case int:
+		return appendItem(b, int64(x), depth)
+	case int64:
+		if x < 0 {
+			return appendHead(b, 1, uint64(-1-x)), nil
+		}
+		return appendHead(b, 0, uint64(x)), nil
+	case uint64:
+		return appendHead(b, 0, x), nil
+	case float64:
+		// integers are smaller and easier for devices to decode
+		if x == math.Trunc(x) && math.Abs(x) < 1<<53 {
+			return appendItem(b, int64(x), depth)
+		}
+		b = append(b, 0xfb)
+		bits := math.Float64bits(x)
+		for i := 7; i >= 0; i-- {
+			b = append(b, byte(bits>>(8*i)))
+		}
+		return b, nil
+	case string:
+		b = appendHead(b, 3, uint64(len(x)))
+		return append(b, x...), nil
+	case []byte:
+		b = appendHead(b, 2, uint64(len(x)))
+		return append(b, x...), nil
+	case []interface{}:
+		b = appendHead(b, 4, uint64(len(x)))
+		var err error
+		for _, e := range x {
+			b, err = appendItem(b, e, depth+1)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	case map[string]interface{}:
+		keys := make([]string, 0, len(x))
+		for k := range x {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		b = appendHead(b, 5, uint64(len(x)))
+		var err error
+		for _, k := range keys {
+			b, _ = appendItem(b, k, depth+1)
+			b, err = appendItem(b, x[k], depth+1)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	default:
+		return nil, fmt.Errorf("cbor: unsupported type: %T", v)
+	}
+}
+
+// Encode encodes a value of one of the types returned by Decode. Map keys
+// are sorted and integral floats are encoded as integers.
+func Encode(v interface{}) ([]byte, error) {
+	return appendItem(nil, v, 0)
+}
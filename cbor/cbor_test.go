@@ -0,0 +1,99 @@
+package cbor
+
+import (
+	"bytes"
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestDecode(t *testing.T) {
+	tests := []struct {
+		in  []byte
+		exp interface{}
+	}{
+		{[]byte{0x00}, int64(0)},
+		{[]byte{0x18, 0x64}, int64(100)},
+		{[]byte{0x39, 0x03, 0xe7}, int64(-1000)},
+		{[]byte{0xf9, 0x3e, 0x00}, 1.5},
+		{[]byte{0xfa, 0x47, 0xc3, 0x50, 0x00}, 100000.0},
+		{[]byte{0xfb, 0x40, 0x35, 0x80, 0, 0, 0, 0, 0}, 21.5},
+		{[]byte{0xf5}, true},
+		{[]byte{0xf6}, nil},
+		{[]byte{0x64, 0x49, 0x45, 0x54, 0x46}, "IETF"},
+		{[]byte{0x7f, 0x62, 0x61, 0x62, 0x61, 0x63, 0xff}, "abc"},
+		{[]byte{0x83, 0x01, 0x02, 0x03}, []interface{}{int64(1), int64(2), int64(3)}},
+		{[]byte{0xa2, 0x61, 0x61, 0x01, 0x01, 0x61, 0x62},
+			map[string]interface{}{"a": int64(1), "1": "b"}},
+		{[]byte{0xbf, 0x61, 0x74, 0xf9, 0x3c, 0x00, 0xff},
+			map[string]interface{}{"t": 1.0}},
+		// tag 1 (epoch time)
+		{[]byte{0xc1, 0x1a, 0x51, 0x4b, 0x67, 0xb0}, int64(1363896240)},
+	}
+
+	for _, test := range tests {
+		v, err := Decode(test.in)
+		if err != nil {
+			t.Errorf("%x: %v", test.in, err)
+			continue
+		}
+		if !reflect.DeepEqual(v, test.exp) {
+			t.Errorf("%x: got %#v, exp %#v", test.in, v, test.exp)
+		}
+	}
+
+	for _, in := range [][]byte{
+		{},
+		{0x19, 0x01},
+		{0x62, 0x61},
+		{0x83, 0x01, 0x02},
+		{0x01, 0x02},
+		{0x1f},
+		bytes.Repeat([]byte{0x81}, 100),
+	} {
+		if _, err := Decode(in); err == nil {
+			t.Errorf("%x: expected error", in)
+		}
+	}
+}
+
+func TestEncode(t *testing.T) {
+	v := map[string]interface{}{
+		"temperature": 21.5,
+		"battery":     float64(97),
+		"on":          true,
+		"name":        "sensor",
+		"values":      []interface{}{int64(-1000), uint64(1 << 40)},
+	}
+
+	b, err := Encode(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := Decode(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exp := map[string]interface{}{
+		"temperature": 21.5,
+		"battery":     int64(97),
+		"on":          true,
+		"name":        "sensor",
+		"values":      []interface{}{int64(-1000), int64(1 << 40)},
+	}
+
+	if !reflect.DeepEqual(got, exp) {
+		t.Errorf("got %#v", got)
+	}
+
+	b, _ = Encode(math.Inf(-1))
+	if f, _ := Decode(b); f != math.Inf(-1) {
+		t.Errorf("got %v", f)
+	}
+
+	if _, err := Encode(struct{}{}); err == nil {
+		t.Error("expected error for unsupported type")
+	}
+}
@@ -0,0 +1,584 @@
+package client
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/simpleiot/simpleiot/data"
+)
+
+// backup files are named siot-<time>.sqlite.gz so that they sort by time
+const (
+	backupPrefix     = "siot-"
+	backupSuffix     = ".sqlite.gz"
+	backupTimeFormat = "20060102T150405Z"
+)
+
+// Backup config. A backup node writes a snapshot of the store every day at
+// Start (HH:MM in Timezone, default 02:00 UTC) and when the backupNow point
+// is set, compresses it with gzip, and copies it to the destination. The
+// newest MaxBackups backups are kept (default 7).
+type Backup struct {
+	ID          string `node:"id"`
+	Parent      string `node:"parent"`
+	Description string `point:"description"`
+	Start       string `point:"start"`
+	Timezone    string `point:"timezone"`
+	// Destination is local (default), sftp, or s3
+	Destination string `point:"destination"`
+	// Directory is the local directory (default backups/<node id>), the
+	// SFTP directory, or the S3 key prefix
+	Directory string `point:"directory"`
+	// URI is the SFTP server (host[:port]) or the S3 endpoint URL
+	URI string `point:"uri"`
+	// Username, Password, and HostKey are used for SFTP. If HostKey (in
+	// authorized keys format) is not set, the server is not verified.
+	Username string `point:"username"`
+	Password string `point:"password"`
+	HostKey  string `point:"hostKey"`
+	// Bucket, Region, AccessKey, and SecretKey are used for S3
+	Bucket         string  `point:"bucket"`
+	Region         string  `point:"region"`
+	AccessKey      string  `point:"accessKey"`
+	SecretKey      string  `point:"secretKey"`
+	MaxBackups     int     `point:"maxBackups"`
+	BackupNow      bool    `point:"backupNow"`
+	LastBackup     string  `point:"lastBackup"`
+	LastBackupSize float64 `point:"lastBackupSize"`
+	BackupError    string  `point:"backupError"`
+	Disable        bool    `point:"disable"`
+}
+
+// backupTarget stores backup files
+type backupTarget interface {
+	// put copies a local file to the target
+	put(name, file string) error
+	// list returns the names of the files in the target
+	list() ([]string, error)
+	remove(name string) error
+	close() error
+}
+
+type backupLocal struct {
+	dir string
+}
+
+func (b *backupLocal) put(name, file string) error {
+	err := os.MkdirAll(b.dir, 0755)
+	if err != nil {
+		return err
+	}
+
+	in, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	// written to a temp file so a failed copy does not look like a backup
+	tmp := filepath.Join(b.dir, name+".tmp")
+	out, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(out, in)
+	if err != nil {
+		out.Close()
+		os.Remove(tmp)
+		return err
+	}
+
+	err = out.Close()
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	return os.Rename(tmp, filepath.Join(b.dir, name))
+}
+
+func (b *backupLocal) list() ([]string, error) {
+	entries, err := os.ReadDir(b.dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var ret []string
+	for _, e := range entries {
+		ret = append(ret, e.Name())
+	}
+
+	return ret, nil
+}
+
+func (b *backupLocal) remove(name string) error {
+	return os.Remove(filepath.Join(b.dir, name))
+}
+
+func (b *backupLocal) close() error {
+	return nil
+}
+
+type backupSftp struct {
+	c   *sftpClient
+	dir string
+}
+
+func (b *backupSftp) put(name, file string) error {
+	in, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	tmp := sftpJoin(b.dir, name+".tmp")
+	err = b.c.put(tmp, in)
+	if err != nil {
+		b.c.remove(tmp)
+		return err
+	}
+
+	return b.c.rename(tmp, sftpJoin(b.dir, name))
+}
+
+func (b *backupSftp) list() ([]string, error) {
+	dir := b.dir
+	if dir == "" {
+		dir = "."
+	}
+	return b.c.list(dir)
+}
+
+func (b *backupSftp) remove(name string) error {
+	return b.c.remove(sftpJoin(b.dir, name))
+}
+
+func (b *backupSftp) close() error {
+	return b.c.close()
+}
+
+type backupS3 struct {
+	c      *s3Client
+	prefix string
+}
+
+func (b *backupS3) put(name, file string) error {
+	d, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+
+	return b.c.put(b.prefix+name, d)
+}
+
+func (b *backupS3) list() ([]string, error) {
+	keys, err := b.c.list(b.prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	var ret []string
+	for _, k := range keys {
+		name := strings.TrimPrefix(k, b.prefix)
+		// objects in "sub directories" are not backups
+		if !strings.Contains(name, "/") {
+			ret = append(ret, name)
+		}
+	}
+
+	return ret, nil
+}
+
+func (b *backupS3) remove(name string) error {
+	return b.c.delete(b.prefix + name)
+}
+
+func (b *backupS3) close() error {
+	return nil
+}
+
+// newBackupTarget connects to the destination of a backup node
+func newBackupTarget(config Backup) (backupTarget, error) {
+	switch config.Destination {
+	case "", data.PointValueLocal:
+		dir := config.Directory
+		if dir == "" {
+			dir = filepath.Join("backups", config.ID)
+		}
+		return &backupLocal{dir: dir}, nil
+
+	case data.PointValueSFTP:
+		if config.URI == "" {
+			return nil, errors.New("SFTP server must be set")
+		}
+
+		c, err := newSftpClient(config.URI, config.Username, config.Password, config.HostKey)
+		if err != nil {
+			return nil, fmt.Errorf("Error connecting to SFTP server: %w", err)
+		}
+
+		return &backupSftp{c: c, dir: config.Directory}, nil
+
+	case data.PointValueS3:
+		c, err := newS3Client(config.URI, config.Bucket, config.Region,
+			config.AccessKey, config.SecretKey)
+		if err != nil {
+			return nil, err
+		}
+
+		prefix := strings.Trim(config.Directory, "/")
+		if prefix != "" {
+			prefix += "/"
+		}
+
+		return &backupS3{c: c, prefix: prefix}, nil
+
+	default:
+		return nil, fmt.Errorf("unsupported backup destination: %v", config.Destination)
+	}
+}
+
+// rotateBackups removes the oldest backups in a target so that at most max
+// remain. Files that are not backups are not touched. It returns the number
+// of backups removed.
+func rotateBackups(t backupTarget, max int) (int, error) {
+	names, err := t.list()
+	if err != nil {
+		return 0, err
+	}
+
+	var backups []string
+	for _, n := range names {
+		if strings.HasPrefix(n, backupPrefix) && strings.HasSuffix(n, backupSuffix) {
+			backups = append(backups, n)
+		}
+	}
+
+	if len(backups) <= max {
+		return 0, nil
+	}
+
+	sort.Strings(backups)
+
+	removed := 0
+	for _, n := range backups[:len(backups)-max] {
+		err := t.remove(n)
+		if err != nil {
+			return removed, err
+		}
+		removed++
+	}
+
+	return removed, nil
+}
+
+// backupNext returns the next time after now a backup is scheduled. start is
+// the time of day (HH:MM) in loc.
+func backupNext(start string, loc *time.Location, now time.Time) (time.Time, error) {
+	if start == "" {
+		start = "02:00"
+	}
+
+	matches := reHourMin.FindStringSubmatch(start)
+	if len(matches) < 3 {
+		return time.Time{}, fmt.Errorf("invalid start time: %v", start)
+	}
+
+	hour, _ := strconv.Atoi(matches[1])
+	min, _ := strconv.Atoi(matches[2])
+	if hour > 23 || min > 59 {
+		return time.Time{}, fmt.Errorf("invalid start time: %v", start)
+	}
+
+	n := now.In(loc)
+	ret := time.Date(n.Year(), n.Month(), n.Day(), hour, min, 0, 0, loc)
+	if !ret.After(now) {
+		ret = time.Date(n.Year(), n.Month(), n.Day()+1, hour, min, 0, 0, loc)
+	}
+
+	return ret, nil
+}
+
+// gzipFile compresses a file
+func gzipFile(in, out string) error {
+	src, err := os.Open(in)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.Create(out)
+	if err != nil {
+		return err
+	}
+
+	zw := gzip.NewWriter(dst)
+
+	_, err = io.Copy(zw, src)
+	if err == nil {
+		err = zw.Close()
+	}
+
+	if err != nil {
+		dst.Close()
+		return err
+	}
+
+	return dst.Close()
+}
+
+// backupResult is the result of a backup run
+type backupResult struct {
+	time time.Time
+	name string
+	size int64
+	err  error
+}
+
+// BackupClient backs up the store for backup nodes
+type BackupClient struct {
+	nc            *nats.Conn
+	config        Backup
+	stop          chan struct{}
+	newPoints     chan NewPoints
+	newEdgePoints chan NewPoints
+}
+
+// NewBackupClient ...
+func NewBackupClient(nc *nats.Conn, config Backup) Client {
+	return &BackupClient{
+		nc:            nc,
+		config:        config,
+		stop:          make(chan struct{}),
+		newPoints:     make(chan NewPoints),
+		newEdgePoints: make(chan NewPoints),
+	}
+}
+
+// runBackup writes a store snapshot, copies it to the destination, and
+// removes old backups
+func runBackup(nc *nats.Conn, config Backup, now time.Time) backupResult {
+	ret := backupResult{time: now,
+		name: backupPrefix + now.UTC().Format(backupTimeFormat) + backupSuffix}
+
+	tmp, err := os.MkdirTemp("", "siot-backup")
+	if err != nil {
+		ret.err = err
+		return ret
+	}
+	defer os.RemoveAll(tmp)
+
+	snapshot := filepath.Join(tmp, "siot.sqlite")
+
+	_, err = StoreBackup(nc, snapshot)
+	if err != nil {
+		ret.err = fmt.Errorf("Error backing up store: %w", err)
+		return ret
+	}
+
+	file := filepath.Join(tmp, ret.name)
+	err = gzipFile(snapshot, file)
+	if err != nil {
+		ret.err = fmt.Errorf("Error compressing backup: %w", err)
+		return ret
+	}
+
+	info, err := os.Stat(file)
+	if err != nil {
+		ret.err = err
+		return ret
+	}
+	ret.size = info.Size()
+
+	t, err := newBackupTarget(config)
+	if err != nil {
+		ret.err = err
+		return ret
+	}
+	defer t.close()
+
+	err = t.put(ret.name, file)
+	if err != nil {
+		ret.err = fmt.Errorf("Error copying backup: %w", err)
+		return ret
+	}
+
+	max := config.MaxBackups
+	if max <= 0 {
+		max = 7
+	}
+
+	_, err = rotateBackups(t, max)
+	if err != nil {
+		// the backup was made, so this is only logged
+		log.Printf("Backup %v: error removing old backups: %v\n", config.Description, err)
+	}
+
+	return ret
+}
+
+// Start runs the main logic for this client and blocks until stopped
+func (bc *BackupClient) Start() error {
+	log.Println("Starting backup client: ", bc.config.Description)
+
+	timer := time.NewTimer(time.Hour)
+	timer.Stop()
+
+	// backups can take a while, so they run in the background
+	var running bool
+	results := make(chan backupResult)
+
+	backup := func() {
+		if bc.config.Disable || running {
+			return
+		}
+
+		running = true
+		go func(config Backup) {
+			results <- runBackup(bc.nc, config, time.Now())
+		}(bc.config)
+	}
+
+	backupNow := func() {
+		backup()
+
+		err := SendNodePoint(bc.nc, bc.config.ID, data.Point{
+			Type: data.PointTypeBackupNow, Value: 0}, false)
+		if err != nil {
+			log.Println("Backup error clearing backupNow: ", err)
+		}
+	}
+
+	setup := func() {
+		timer.Stop()
+
+		if bc.config.Disable {
+			return
+		}
+
+		loc := time.UTC
+		if bc.config.Timezone != "" {
+			var err error
+			loc, err = time.LoadLocation(bc.config.Timezone)
+			if err != nil {
+				log.Printf("Backup %v: invalid timezone: %v\n", bc.config.Description, err)
+				return
+			}
+		}
+
+		next, err := backupNext(bc.config.Start, loc, time.Now())
+		if err != nil {
+			log.Printf("Backup %v: %v\n", bc.config.Description, err)
+			return
+		}
+
+		timer.Reset(time.Until(next))
+	}
+
+	setup()
+
+	// backupNow may have been set before the client started
+	if bc.config.BackupNow {
+		backupNow()
+	}
+
+done:
+	for {
+		select {
+		case <-bc.stop:
+			log.Println("Stopping backup client: ", bc.config.Description)
+			break done
+		case <-timer.C:
+			backup()
+			setup()
+		case r := <-results:
+			running = false
+
+			var pts data.Points
+			if r.err != nil {
+				log.Printf("Backup %v: %v\n", bc.config.Description, r.err)
+				pts = data.Points{{Time: r.time, Type: data.PointTypeBackupError,
+					Text: r.err.Error()}}
+			} else {
+				pts = data.Points{
+					{Time: r.time, Type: data.PointTypeLastBackup, Text: r.name},
+					{Time: r.time, Type: data.PointTypeLastBackupSize,
+						Value: float64(r.size)},
+					{Time: r.time, Type: data.PointTypeBackupError},
+				}
+			}
+
+			err := SendNodePoints(bc.nc, bc.config.ID, pts, false)
+			if err != nil {
+				log.Println("Backup error sending status points: ", err)
+			}
+
+		case pts := <-bc.newPoints:
+			err := data.MergePoints(pts.ID, pts.Points, &bc.config)
+			if err != nil {
+				log.Println("error merging new points: ", err)
+			}
+
+			for _, p := range pts.Points {
+				switch p.Type {
+				case data.PointTypeStart, data.PointTypeTimezone,
+					data.PointTypeDisable:
+					setup()
+				case data.PointTypeBackupNow:
+					if p.Value == 0 {
+						continue
+					}
+
+					backupNow()
+				}
+			}
+
+		case pts := <-bc.newEdgePoints:
+			err := data.MergeEdgePoints(pts.ID, pts.Parent, pts.Points, &bc.config)
+			if err != nil {
+				log.Println("error merging new points: ", err)
+			}
+		}
+	}
+
+	// clean up
+	timer.Stop()
+
+	// wait for a backup in progress so the temp files are removed
+	if running {
+		<-results
+	}
+
+	return nil
+}
+
+// Stop sends a signal to the Start function to exit
+func (bc *BackupClient) Stop(err error) {
+	close(bc.stop)
+}
+
+// Points is called by the Manager when new points for this
+// node are received.
+func (bc *BackupClient) Points(nodeID string, points []data.Point) {
+	bc.newPoints <- NewPoints{nodeID, "", points}
+}
+
+// EdgePoints is called by the Manager when new edge points for this
+// node are received.
+func (bc *BackupClient) EdgePoints(nodeID, parentID string, points []data.Point) {
+	bc.newEdgePoints <- NewPoints{nodeID, parentID, points}
+}
@@ -0,0 +1,75 @@
+package client_test
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/simpleiot/simpleiot/client"
+	"github.com/simpleiot/simpleiot/server"
+)
+
+func TestBackup(t *testing.T) {
+	nc, root, stop, err := server.TestServer()
+	if err != nil {
+		t.Fatal("Error starting test server: ", err)
+	}
+	defer stop()
+
+	dir := t.TempDir()
+
+	// backupNow makes a backup when the client starts
+	b := client.Backup{ID: "backup", Parent: root.ID, Description: "backup",
+		Directory: dir, BackupNow: true}
+
+	if err := client.SendNodeType(nc, b, "test"); err != nil {
+		t.Fatal(err)
+	}
+
+	var backups []client.Backup
+
+	for i := 0; i < 100; i++ {
+		time.Sleep(100 * time.Millisecond)
+
+		backups, err = client.GetNodeType[client.Backup](nc, b.ID, root.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(backups) > 0 && (backups[0].LastBackup != "" || backups[0].BackupError != "") {
+			break
+		}
+	}
+
+	if len(backups) < 1 || backups[0].LastBackup == "" {
+		t.Fatalf("backup was not made: %+v", backups)
+	}
+
+	if backups[0].BackupError != "" {
+		t.Fatal("backup error: ", backups[0].BackupError)
+	}
+
+	f, err := os.Open(filepath.Join(dir, backups[0].LastBackup))
+	if err != nil {
+		t.Fatal("Error opening backup: ", err)
+	}
+	defer f.Close()
+
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal("Error decompressing backup: ", err)
+	}
+
+	hdr := make([]byte, 16)
+	_, err = io.ReadFull(zr, hdr)
+	if err != nil {
+		t.Fatal("Error reading backup: ", err)
+	}
+
+	if string(hdr) != "SQLite format 3\x00" {
+		t.Error("backup is not a SQLite database")
+	}
+}
@@ -0,0 +1,110 @@
+package client
+
+import (
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/simpleiot/simpleiot/data"
+)
+
+func TestBackupNext(t *testing.T) {
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip("timezone data not available: ", err)
+	}
+
+	now := time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		start string
+		loc   *time.Location
+		exp   time.Time
+	}{
+		{"", time.UTC, time.Date(2026, 5, 2, 2, 0, 0, 0, time.UTC)},
+		{"13:30", time.UTC, time.Date(2026, 5, 1, 13, 30, 0, 0, time.UTC)},
+		{"12:00", time.UTC, time.Date(2026, 5, 2, 12, 0, 0, 0, time.UTC)},
+		// 8:00 EDT was 12:00 UTC
+		{"09:00", loc, time.Date(2026, 5, 1, 13, 0, 0, 0, time.UTC)},
+		{"07:00", loc, time.Date(2026, 5, 2, 11, 0, 0, 0, time.UTC)},
+	}
+
+	for _, test := range tests {
+		next, err := backupNext(test.start, test.loc, now)
+		if err != nil {
+			t.Fatalf("%v: %v", test.start, err)
+		}
+
+		if !next.Equal(test.exp) {
+			t.Errorf("%v %v: expected %v, got %v", test.start, test.loc, test.exp, next)
+		}
+	}
+
+	if _, err := backupNext("25:00", time.UTC, now); err == nil {
+		t.Error("expected error for invalid start")
+	}
+}
+
+func TestRotateBackups(t *testing.T) {
+	s3 := &fakeS3{objects: make(map[string][]byte)}
+	server := httptest.NewServer(s3)
+	defer server.Close()
+
+	src := filepath.Join(t.TempDir(), "backup")
+	err := os.WriteFile(src, []byte("backup"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	configs := []Backup{
+		{ID: "local", Destination: data.PointValueLocal, Directory: t.TempDir()},
+		{ID: "s3", Destination: data.PointValueS3, URI: server.URL, Bucket: "backups",
+			Directory: "siot", AccessKey: "key", SecretKey: "secret"},
+	}
+
+	names := []string{
+		"siot-20260503T020000Z.sqlite.gz",
+		"siot-20260501T020000Z.sqlite.gz",
+		"siot-20260502T020000Z.sqlite.gz",
+		"notes.txt",
+	}
+
+	for _, config := range configs {
+		target, err := newBackupTarget(config)
+		if err != nil {
+			t.Fatalf("%v: error creating target: %v", config.ID, err)
+		}
+
+		for _, n := range names {
+			err := target.put(n, src)
+			if err != nil {
+				t.Fatalf("%v: error copying backup: %v", config.ID, err)
+			}
+		}
+
+		removed, err := rotateBackups(target, 2)
+		if err != nil {
+			t.Fatalf("%v: error rotating backups: %v", config.ID, err)
+		}
+
+		if removed != 1 {
+			t.Errorf("%v: expected 1 backup removed, got %v", config.ID, removed)
+		}
+
+		remaining, err := target.list()
+		if err != nil {
+			t.Fatalf("%v: error listing backups: %v", config.ID, err)
+		}
+
+		exp := []string{"notes.txt", "siot-20260502T020000Z.sqlite.gz",
+			"siot-20260503T020000Z.sqlite.gz"}
+		if !reflect.DeepEqual(remaining, exp) {
+			t.Errorf("%v: wrong backups remaining: %v", config.ID, remaining)
+		}
+
+		target.close()
+	}
+}
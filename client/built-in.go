@@ -40,6 +40,11 @@ func (bic *BuiltInClients) Start() error {
 	rootID = nodes[0].ID
 	_ = rootID
 
+	// node types that are handled by the store or read by other clients
+	RegisterNodeUI(DistListNodeUI())
+	RegisterNodeUI(CalendarNodeUI())
+	RegisterNodeUI(ZoneNodeUI())
+
 	sc := NewManager(bic.nc, rootID, NewSerialDevClient)
 	g.Add(sc.Start, sc.Stop)
 
@@ -52,6 +57,96 @@ func (bic *BuiltInClients) Start() error {
 	sg := NewManager(bic.nc, rootID, NewSignalGeneratorClient)
 	g.Add(sg.Start, sg.Stop)
 
+	sim := NewManager(bic.nc, rootID, NewSimulatorClient)
+	g.Add(sim.Start, sim.Stop)
+
+	sm := NewManager(bic.nc, rootID, NewSystemMonitorClient)
+	g.Add(sm.Start, sm.Stop)
+
+	hc := NewManager(bic.nc, rootID, NewHostControlClient)
+	g.Add(hc.Start, hc.Stop)
+
+	netc := NewManager(bic.nc, rootID, NewNetworkConfigClient)
+	g.Add(netc.Start, netc.Stop)
+
+	mc := NewManager(bic.nc, rootID, NewModemClient)
+	g.Add(mc.Start, mc.Stop)
+
+	cam := NewManager(bic.nc, rootID, NewCameraClient)
+	g.Add(cam.Start, cam.Stop)
+
+	diag := NewManager(bic.nc, rootID, NewDiagnosticsClient)
+	g.Add(diag.Start, diag.Stop)
+
+	ca := NewManager(bic.nc, rootID, NewCertAuthorityClient)
+	g.Add(ca.Start, ca.Stop)
+
+	wasm := NewManager(bic.nc, rootID, NewWasmProcessorClient)
+	g.Add(wasm.Start, wasm.Stop)
+
+	script := NewManager(bic.nc, rootID, NewScriptClient)
+	g.Add(script.Start, script.Stop)
+
+	rs := NewManager(bic.nc, rootID, NewRuntimeStatsClient)
+	g.Add(rs.Start, rs.Stop)
+
+	integ := NewManager(bic.nc, rootID, NewIntegratorClient)
+	g.Add(integ.Start, integ.Stop)
+
+	weather := NewManager(bic.nc, rootID, NewWeatherClient)
+	g.Add(weather.Start, weather.Stop)
+
+	cal := NewManager(bic.nc, rootID, NewCalendarClient)
+	g.Add(cal.Start, cal.Stop)
+
+	tracker := NewManager(bic.nc, rootID, NewTrackerClient)
+	g.Add(tracker.Start, tracker.Stop)
+
+	shed := NewManager(bic.nc, rootID, NewLoadShedClient)
+	g.Add(shed.Start, shed.Stop)
+
+	smc := NewManager(bic.nc, rootID, NewStateMachineClient)
+	g.Add(smc.Start, smc.Stop)
+
+	health := NewManager(bic.nc, rootID, NewHealthMonitorClient)
+	g.Add(health.Start, health.Stop)
+
+	meter := NewManager(bic.nc, rootID, NewMeterReaderClient)
+	g.Add(meter.Start, meter.Stop)
+
+	mb := NewManager(bic.nc, rootID, NewMbusClient)
+	g.Add(mb.Start, mb.Stop)
+
+	knxm := NewManager(bic.nc, rootID, NewKnxClient)
+	g.Add(knxm.Start, knxm.Stop)
+
+	mqttBridge := NewManager(bic.nc, rootID, NewMqttBridgeClient)
+	g.Add(mqttBridge.Start, mqttBridge.Stop)
+
+	coapServer := NewManager(bic.nc, rootID, NewCoapServerClient)
+	g.Add(coapServer.Start, coapServer.Stop)
+
+	udpIngest := NewManager(bic.nc, rootID, NewUdpIngestClient)
+	g.Add(udpIngest.Start, udpIngest.Stop)
+
+	syslog := NewManager(bic.nc, rootID, NewSyslogClient)
+	g.Add(syslog.Start, syslog.Stop)
+
+	netMon := NewManager(bic.nc, rootID, NewNetworkMonitorClient)
+	g.Add(netMon.Start, netMon.Stop)
+
+	wanMon := NewManager(bic.nc, rootID, NewWanMonitorClient)
+	g.Add(wanMon.Start, wanMon.Stop)
+
+	lanInv := NewManager(bic.nc, rootID, NewLanInventoryClient)
+	g.Add(lanInv.Start, lanInv.Stop)
+
+	backup := NewManager(bic.nc, rootID, NewBackupClient)
+	g.Add(backup.Start, backup.Stop)
+
+	external := NewExternalRegistry(bic.nc)
+	g.Add(external.Start, external.Stop)
+
 	g.Add(func() error {
 		<-bic.stop
 		return nil
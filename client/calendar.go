@@ -0,0 +1,408 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/simpleiot/simpleiot/data"
+	"github.com/simpleiot/simpleiot/ical"
+)
+
+// calendarDays is how far ahead recurring events are expanded to dates
+var calendarDays = 366
+
+// calendarCheckPeriod is how often the active point of a calendar with a
+// feed is updated
+var calendarCheckPeriod = 5 * time.Second
+
+// calendarFeedMaxSize limits the size of a downloaded calendar feed
+const calendarFeedMaxSize = 10 << 20
+
+// Calendar is a list of dates, such as holidays, that schedule conditions
+// can reference. Dates are stored as date points keyed by the date
+// (2006-01-02) with the name of the date in the text.
+//
+// If URI is set, the calendar subscribes to an iCalendar feed, such as a
+// meeting room booking calendar. The dates of all day events in the feed
+// replace the dates of the calendar, and the active point is set while an
+// event is in progress, so rules can use it like any other point.
+type Calendar struct {
+	ID          string `node:"id"`
+	Parent      string `node:"parent"`
+	Description string `point:"description"`
+	URI         string `point:"uri"`
+	// Timezone is used for all day events and times without a timezone.
+	// Default is UTC.
+	Timezone string `point:"timezone"`
+	// SamplePeriod is the time between feed downloads in seconds. Default
+	// is 15 minutes.
+	SamplePeriod float64 `point:"samplePeriod"`
+	Disable      bool    `point:"disable"`
+	Active       bool    `point:"active"`
+}
+
+// CalendarNodeUI returns the edit form descriptor for calendar nodes. Dates
+// are keyed points, so they are not in the form and are added with
+// ImportICal (the siot ical-import command), a feed, or the API.
+func CalendarNodeUI() NodeUI {
+	return NodeUI{
+		Type:    data.NodeTypeCalendar,
+		Label:   "Calendar",
+		Parents: []string{data.NodeTypeDevice, data.NodeTypeGroup},
+		Fields: []NodeUIField{
+			{Point: data.PointTypeDescription, Label: "Description", Input: NodeUIInputText},
+			{Point: data.PointTypeURI, Label: "iCal feed URL", Input: NodeUIInputText},
+			{Point: data.PointTypeTimezone, Label: "Timezone", Input: NodeUIInputText},
+			{Point: data.PointTypeSamplePeriod, Label: "Feed update period (s)",
+				Input: NodeUIInputNumber},
+			{Point: data.PointTypeDisable, Label: "Disable feed", Input: NodeUIInputCheckbox},
+			{Point: data.PointTypeActive, Label: "Event in progress", Input: NodeUIInputCheckbox},
+		},
+	}
+}
+
+// calendarDates returns the dates of a calendar node and their names
+func calendarDates(points data.Points) map[string]string {
+	ret := make(map[string]string)
+	for _, p := range points {
+		if p.Type != data.PointTypeDate || p.Tombstone != 0 {
+			continue
+		}
+		if _, err := time.Parse("2006-01-02", p.Key); err != nil {
+			continue
+		}
+		ret[p.Key] = p.Text
+	}
+	return ret
+}
+
+// GetCalendarDates returns the dates of a calendar node and their names
+func GetCalendarDates(nc *nats.Conn, id string) (map[string]string, error) {
+	nodes, err := GetNode(nc, id, "all")
+	if err != nil {
+		return nil, err
+	}
+
+	if len(nodes) < 1 {
+		return nil, data.ErrDocumentNotFound
+	}
+
+	return calendarDates(nodes[0].Points), nil
+}
+
+// expandEvents returns events with recurring events replaced by their
+// occurrences until to
+func expandEvents(events []ical.Event, from, to time.Time) []ical.Event {
+	var ret []ical.Event
+	for _, e := range events {
+		if e.RRule == "" {
+			ret = append(ret, e)
+			continue
+		}
+		ret = append(ret, e.Occurrences(from, to)...)
+	}
+	return ret
+}
+
+// eventDates returns the dates of events and their names. Events on the
+// same date are combined.
+func eventDates(events []ical.Event, loc *time.Location) map[string]string {
+	names := make(map[string][]string)
+	for _, e := range events {
+		for _, d := range e.Dates(loc) {
+			names[d] = append(names[d], e.Summary)
+		}
+	}
+
+	ret := make(map[string]string, len(names))
+	for d, n := range names {
+		ret[d] = strings.Join(n, ", ")
+	}
+
+	return ret
+}
+
+// datePoints returns the points that change the dates of a calendar from
+// current to dates. Dates that are not in dates are deleted if remove is set.
+func datePoints(current, dates map[string]string, remove bool, now time.Time) data.Points {
+	var ret data.Points
+
+	for d, name := range dates {
+		if cur, ok := current[d]; !ok || cur != name {
+			ret = append(ret, data.Point{Time: now, Type: data.PointTypeDate, Key: d,
+				Text: name})
+		}
+	}
+
+	if remove {
+		for d := range current {
+			if _, ok := dates[d]; !ok {
+				ret = append(ret, data.Point{Time: now, Type: data.PointTypeDate, Key: d,
+					Tombstone: 1})
+			}
+		}
+	}
+
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Key < ret[j].Key
+	})
+
+	return ret
+}
+
+// ImportICal adds the dates of the events in an iCalendar file, such as a
+// public holiday list, to a calendar node. Events that span several days add
+// all of their dates, recurring events add their dates for the next year,
+// and events on the same date are combined. Times are converted to dates in
+// loc. The number of dates added is returned.
+func ImportICal(nc *nats.Conn, calendarID string, r io.Reader, loc *time.Location) (int, error) {
+	events, err := ical.Parse(r, loc)
+	if err != nil {
+		return 0, fmt.Errorf("Error parsing iCal: %v", err)
+	}
+
+	now := time.Now()
+	events = expandEvents(events, now.AddDate(0, 0, -1), now.AddDate(0, 0, calendarDays))
+
+	points := datePoints(nil, eventDates(events, loc), false, now)
+
+	if len(points) == 0 {
+		return 0, nil
+	}
+
+	err = SendNodePoints(nc, calendarID, points, true)
+	if err != nil {
+		return 0, err
+	}
+
+	return len(points), nil
+}
+
+// fetchICal downloads and parses an iCalendar feed. webcal:// URLs, which
+// many calendar applications use for subscriptions, are fetched with https.
+func fetchICal(hc *http.Client, uri string, loc *time.Location) ([]ical.Event, error) {
+	if strings.HasPrefix(uri, "webcal://") {
+		uri = "https://" + strings.TrimPrefix(uri, "webcal://")
+	}
+
+	req, err := http.NewRequest(http.MethodGet, uri, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("User-Agent", "simpleiot")
+	req.Header.Set("Accept", "text/calendar")
+
+	resp, err := hc.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("%v: %v", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	return ical.Parse(io.LimitReader(resp.Body, calendarFeedMaxSize), loc)
+}
+
+// CalendarClient updates calendar nodes that have an iCalendar feed
+type CalendarClient struct {
+	nc            *nats.Conn
+	config        Calendar
+	stop          chan struct{}
+	newPoints     chan NewPoints
+	newEdgePoints chan NewPoints
+	httpClient    *http.Client
+
+	// occurrences of the feed events around the current time
+	events []ical.Event
+}
+
+// NewCalendarClient ...
+func NewCalendarClient(nc *nats.Conn, config Calendar) Client {
+	return &CalendarClient{
+		nc:            nc,
+		config:        config,
+		stop:          make(chan struct{}),
+		newPoints:     make(chan NewPoints),
+		newEdgePoints: make(chan NewPoints),
+		httpClient:    &http.Client{Timeout: 20 * time.Second},
+	}
+}
+
+func (cc *CalendarClient) location() *time.Location {
+	if cc.config.Timezone == "" {
+		return time.UTC
+	}
+
+	loc, err := time.LoadLocation(cc.config.Timezone)
+	if err != nil {
+		log.Printf("Calendar %v: invalid timezone %v, using UTC\n",
+			cc.config.Description, cc.config.Timezone)
+		return time.UTC
+	}
+
+	return loc
+}
+
+// update downloads the feed and replaces the dates of the calendar with the
+// dates of the all day events
+func (cc *CalendarClient) update() error {
+	if cc.config.URI == "" {
+		return errors.New("feed URL not set")
+	}
+
+	loc := cc.location()
+
+	events, err := fetchICal(cc.httpClient, cc.config.URI, loc)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	cc.events = ical.Expand(events, now.AddDate(0, 0, -1), now.AddDate(0, 0, calendarDays))
+
+	var allDay []ical.Event
+	for _, e := range cc.events {
+		if e.AllDay {
+			allDay = append(allDay, e)
+		}
+	}
+
+	current, err := GetCalendarDates(cc.nc, cc.config.ID)
+	if err != nil {
+		return fmt.Errorf("Error getting calendar dates: %v", err)
+	}
+
+	points := datePoints(current, eventDates(allDay, loc), true, now)
+	if len(points) > 0 {
+		err = SendNodePoints(cc.nc, cc.config.ID, points, true)
+		if err != nil {
+			return err
+		}
+	}
+
+	return cc.check(now)
+}
+
+// check sets the active point of the calendar if an event is in progress
+func (cc *CalendarClient) check(now time.Time) error {
+	active := false
+	for _, e := range cc.events {
+		if !now.Before(e.Start) && now.Before(e.End) {
+			active = true
+			break
+		}
+	}
+
+	if active == cc.config.Active {
+		return nil
+	}
+
+	cc.config.Active = active
+
+	return SendNodePoint(cc.nc, cc.config.ID, data.Point{Time: now,
+		Type: data.PointTypeActive, Value: data.BoolToFloat(active)}, false)
+}
+
+// Start runs the main logic for this client and blocks until stopped
+func (cc *CalendarClient) Start() error {
+	feedTicker := time.NewTicker(time.Hour)
+	feedTicker.Stop()
+
+	checkTicker := time.NewTicker(calendarCheckPeriod)
+	checkTicker.Stop()
+
+	update := func() {
+		err := cc.update()
+		if err != nil {
+			log.Printf("Calendar %v: %v\n", cc.config.Description, err)
+		}
+	}
+
+	setup := func() {
+		feedTicker.Stop()
+		checkTicker.Stop()
+		cc.events = nil
+
+		if cc.config.URI == "" || cc.config.Disable {
+			return
+		}
+
+		period := 15 * time.Minute
+		if cc.config.SamplePeriod > 0 {
+			period = time.Duration(cc.config.SamplePeriod * float64(time.Second))
+		}
+
+		feedTicker.Reset(period)
+		checkTicker.Reset(calendarCheckPeriod)
+		update()
+	}
+
+	setup()
+
+done:
+	for {
+		select {
+		case <-cc.stop:
+			break done
+		case <-feedTicker.C:
+			update()
+		case now := <-checkTicker.C:
+			err := cc.check(now)
+			if err != nil {
+				log.Printf("Calendar %v: error sending active: %v\n",
+					cc.config.Description, err)
+			}
+		case pts := <-cc.newPoints:
+			err := data.MergePoints(pts.ID, pts.Points, &cc.config)
+			if err != nil {
+				log.Println("error merging new points: ", err)
+			}
+
+			for _, p := range pts.Points {
+				switch p.Type {
+				case data.PointTypeURI, data.PointTypeTimezone,
+					data.PointTypeSamplePeriod, data.PointTypeDisable:
+					setup()
+				}
+			}
+
+		case pts := <-cc.newEdgePoints:
+			err := data.MergeEdgePoints(pts.ID, pts.Parent, pts.Points, &cc.config)
+			if err != nil {
+				log.Println("error merging new points: ", err)
+			}
+		}
+	}
+
+	feedTicker.Stop()
+	checkTicker.Stop()
+	return nil
+}
+
+// Stop sends a signal to the Start function to exit
+func (cc *CalendarClient) Stop(err error) {
+	close(cc.stop)
+}
+
+// Points is called by the Manager when new points for this
+// node are received.
+func (cc *CalendarClient) Points(nodeID string, points []data.Point) {
+	cc.newPoints <- NewPoints{nodeID, "", points}
+}
+
+// EdgePoints is called by the Manager when new edge points for this
+// node are received.
+func (cc *CalendarClient) EdgePoints(nodeID, parentID string, points []data.Point) {
+	cc.newEdgePoints <- NewPoints{nodeID, parentID, points}
+}
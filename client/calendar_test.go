@@ -0,0 +1,257 @@
+package client_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/simpleiot/simpleiot/client"
+	"github.com/simpleiot/simpleiot/data"
+	"github.com/simpleiot/simpleiot/server"
+)
+
+const testHolidays = `BEGIN:VCALENDAR
+VERSION:2.0
+BEGIN:VEVENT
+UID:christmas
+DTSTART;VALUE=DATE:20241225
+DTEND;VALUE=DATE:20241227
+SUMMARY:Christmas
+END:VEVENT
+BEGIN:VEVENT
+UID:party
+DTSTART:20241225T180000
+DTEND:20241225T200000
+SUMMARY:Party
+END:VEVENT
+END:VCALENDAR
+`
+
+func TestImportICal(t *testing.T) {
+	nc, root, stop, err := server.TestServer()
+
+	if err != nil {
+		t.Fatal("Error starting test server: ", err)
+	}
+
+	defer stop()
+
+	cal := client.Calendar{
+		ID:          "ID-calendar",
+		Parent:      root.ID,
+		Description: "holidays",
+	}
+
+	err = client.SendNodeType(nc, cal, "test")
+	if err != nil {
+		t.Fatal("Error sending node: ", err)
+	}
+
+	count, err := client.ImportICal(nc, cal.ID, strings.NewReader(testHolidays), time.UTC)
+	if err != nil {
+		t.Fatal("Error importing: ", err)
+	}
+
+	if count != 2 {
+		t.Fatal("expected 2 dates, got: ", count)
+	}
+
+	dates, err := client.GetCalendarDates(nc, cal.ID)
+	if err != nil {
+		t.Fatal("Error getting dates: ", err)
+	}
+
+	exp := map[string]string{
+		"2024-12-25": "Christmas, Party",
+		"2024-12-26": "Christmas",
+	}
+
+	if len(dates) != len(exp) {
+		t.Fatalf("expected %v, got %v", exp, dates)
+	}
+
+	for d, name := range exp {
+		if dates[d] != name {
+			t.Errorf("date %v: expected %v, got %v", d, name, dates[d])
+		}
+	}
+}
+
+// TestRuleScheduleCalendar tests a schedule condition that is only active on
+// the dates of a calendar
+func TestRuleScheduleCalendar(t *testing.T) {
+	nc, root, stop, err := server.TestServer()
+
+	if err != nil {
+		t.Fatal("Error starting test server: ", err)
+	}
+
+	defer stop()
+
+	cal := client.Calendar{
+		ID:          "ID-calendar",
+		Parent:      root.ID,
+		Description: "bookings",
+	}
+
+	err = client.SendNodeType(nc, cal, "test")
+	if err != nil {
+		t.Fatal("Error sending node: ", err)
+	}
+
+	today := time.Now().UTC().Format("2006-01-02")
+	err = client.SendNodePoint(nc, cal.ID, data.Point{Type: data.PointTypeDate,
+		Key: today, Text: "booked"}, true)
+	if err != nil {
+		t.Fatal("Error sending date: ", err)
+	}
+
+	vout := client.Variable{
+		ID:          "ID-varout",
+		Parent:      root.ID,
+		Description: "var out",
+	}
+
+	err = client.SendNodeType(nc, vout, "test")
+	if err != nil {
+		t.Fatal("Error sending node: ", err)
+	}
+
+	r := client.Rule{
+		ID:          "ID-rule",
+		Parent:      root.ID,
+		Description: "test rule",
+	}
+
+	err = client.SendNodeType(nc, r, "test")
+	if err != nil {
+		t.Fatal("Error sending node: ", err)
+	}
+
+	c := client.Condition{
+		ID:            "ID-condition",
+		Parent:        r.ID,
+		Description:   "booked",
+		ConditionType: data.PointValueSchedule,
+		StartTime:     "0:00",
+		EndTime:       "0:00",
+		CalendarID:    cal.ID,
+		CalendarMode:  data.PointValueInclude,
+	}
+
+	err = client.SendNodeType(nc, c, "test")
+	if err != nil {
+		t.Fatal("Error sending node: ", err)
+	}
+
+	a := client.Action{
+		ID:          "ID-action",
+		Parent:      r.ID,
+		Description: "action active",
+		Action:      data.PointValueSetValue,
+		PointType:   data.PointTypeValue,
+		NodeID:      vout.ID,
+		Value:       1,
+	}
+
+	err = client.SendNodeType(nc, a, "test")
+	if err != nil {
+		t.Fatal("Error sending node: ", err)
+	}
+
+	voutGet, voutStop, err := client.NodeWatcher[client.Variable](nc, vout.ID, vout.Parent)
+
+	if err != nil {
+		t.Fatal("Error setting up watcher")
+	}
+
+	defer voutStop()
+
+	// schedules are checked every 5s
+	start := time.Now()
+	for voutGet().Value != 1 {
+		if time.Since(start) > client.DefaultConfigDebounce+8*time.Second {
+			t.Fatal("Timeout waiting for schedule to set vout")
+		}
+		<-time.After(time.Millisecond * 50)
+	}
+}
+
+func TestCalendarFeed(t *testing.T) {
+	now := time.Now().UTC()
+	tomorrow := now.AddDate(0, 0, 1)
+
+	feed := fmt.Sprintf(`BEGIN:VCALENDAR
+BEGIN:VEVENT
+UID:booking
+DTSTART:%v
+DTEND:%v
+SUMMARY:Team meeting
+END:VEVENT
+BEGIN:VEVENT
+UID:holiday
+DTSTART;VALUE=DATE:%v
+SUMMARY:Holiday
+END:VEVENT
+END:VCALENDAR
+`, now.Add(-time.Hour).Format("20060102T150405Z"),
+		now.Add(time.Hour).Format("20060102T150405Z"), tomorrow.Format("20060102"))
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/calendar")
+		fmt.Fprint(w, feed)
+	}))
+	defer ts.Close()
+
+	nc, root, stop, err := server.TestServer()
+
+	if err != nil {
+		t.Fatal("Error starting test server: ", err)
+	}
+
+	defer stop()
+
+	// dates that are not in the feed are removed
+	cal := data.NodeEdge{
+		ID:     "ID-calendar",
+		Type:   data.NodeTypeCalendar,
+		Parent: root.ID,
+		Points: data.Points{
+			{Type: data.PointTypeDescription, Text: "room"},
+			{Type: data.PointTypeURI, Text: ts.URL},
+			{Type: data.PointTypeDate, Key: "2020-01-01", Text: "old"},
+		},
+	}
+
+	err = client.SendNode(nc, cal, "test")
+	if err != nil {
+		t.Fatal("Error sending node: ", err)
+	}
+
+	calGet, calStop, err := client.NodeWatcher[client.Calendar](nc, cal.ID, cal.Parent)
+	if err != nil {
+		t.Fatal("Error setting up watcher")
+	}
+
+	defer calStop()
+
+	start := time.Now()
+	for !calGet().Active {
+		if time.Since(start) > client.DefaultConfigDebounce+5*time.Second {
+			t.Fatal("Timeout waiting for calendar to be active")
+		}
+		<-time.After(time.Millisecond * 50)
+	}
+
+	dates, err := client.GetCalendarDates(nc, cal.ID)
+	if err != nil {
+		t.Fatal("Error getting dates: ", err)
+	}
+
+	if len(dates) != 1 || dates[tomorrow.Format("2006-01-02")] != "Holiday" {
+		t.Error("wrong dates: ", dates)
+	}
+}
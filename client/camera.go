@@ -0,0 +1,319 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/simpleiot/simpleiot/data"
+)
+
+// snapshotTimeFormat is used for snapshot file names so that they sort by
+// capture time
+const snapshotTimeFormat = "20060102T150405.000Z"
+
+// Camera config. A camera node captures JPEG snapshots from a V4L2 device
+// or an RTSP stream using ffmpeg. Snapshots are taken every sample period
+// and when the capture point is set, for example by a rule setValue action.
+// Snapshots are stored in file child nodes of the camera, which are reused
+// as a ring buffer so the newest maxSnapshots snapshots are kept.
+type Camera struct {
+	ID           string  `node:"id"`
+	Parent       string  `node:"parent"`
+	Description  string  `point:"description"`
+	Device       string  `point:"device"`
+	URI          string  `point:"uri"`
+	Resolution   string  `point:"resolution"`
+	SamplePeriod float64 `point:"samplePeriod"`
+	MaxSnapshots int     `point:"maxSnapshots"`
+	Capture      bool    `point:"capture"`
+	LastSnapshot string  `point:"lastSnapshot"`
+	Disable      bool    `point:"disable"`
+}
+
+// CameraClient for camera nodes
+type CameraClient struct {
+	nc            *nats.Conn
+	config        Camera
+	stop          chan struct{}
+	newPoints     chan NewPoints
+	newEdgePoints chan NewPoints
+}
+
+// NewCameraClient ...
+func NewCameraClient(nc *nats.Conn, config Camera) Client {
+	return &CameraClient{
+		nc:            nc,
+		config:        config,
+		stop:          make(chan struct{}),
+		newPoints:     make(chan NewPoints),
+		newEdgePoints: make(chan NewPoints),
+	}
+}
+
+// cameraMaxSnapshots returns the number of snapshots that are kept
+func cameraMaxSnapshots(config Camera) int {
+	if config.MaxSnapshots <= 0 {
+		return 10
+	}
+	return config.MaxSnapshots
+}
+
+// cameraSnapshotID returns the ID of the file node for a snapshot slot
+func cameraSnapshotID(cameraID string, slot int) string {
+	return fmt.Sprintf("%v-snapshot-%v", cameraID, slot)
+}
+
+// cameraNextSlot returns the snapshot slot after the last snapshot
+func cameraNextSlot(config Camera) int {
+	n, err := strconv.Atoi(strings.TrimPrefix(config.LastSnapshot, config.ID+"-snapshot-"))
+	if err != nil || !strings.HasPrefix(config.LastSnapshot, config.ID+"-snapshot-") {
+		return 0
+	}
+	return (n + 1) % cameraMaxSnapshots(config)
+}
+
+// cameraCaptureTimeout is how long ffmpeg can run for one snapshot. Periodic
+// snapshots must finish before the next one is due.
+func cameraCaptureTimeout(config Camera) time.Duration {
+	if config.SamplePeriod <= 0 {
+		return 30 * time.Second
+	}
+
+	ret := time.Duration(config.SamplePeriod * float64(time.Second))
+	if ret < 5*time.Second {
+		// leave time to connect to RTSP streams
+		ret = 5 * time.Second
+	}
+	return ret
+}
+
+// cameraCaptureArgs returns the ffmpeg command line to capture one snapshot
+// to file. If URI is set, the snapshot is pulled from an RTSP stream,
+// otherwise from a V4L2 device (default /dev/video0).
+func cameraCaptureArgs(config Camera, file string) []string {
+	ret := []string{"ffmpeg", "-y", "-loglevel", "error"}
+
+	if config.URI != "" {
+		if strings.HasPrefix(config.URI, "rtsp://") {
+			ret = append(ret, "-rtsp_transport", "tcp")
+		}
+		ret = append(ret, "-i", config.URI)
+	} else {
+		device := config.Device
+		if device == "" {
+			device = "/dev/video0"
+		}
+		ret = append(ret, "-f", "v4l2")
+		if config.Resolution != "" {
+			ret = append(ret, "-video_size", config.Resolution)
+		}
+		ret = append(ret, "-i", device)
+	}
+
+	return append(ret, "-frames:v", "1", "-q:v", "2", file)
+}
+
+// pruneFiles removes the files matching pattern that sort first so that at
+// most max remain. It returns the number of files removed.
+func pruneFiles(pattern string, max int) (int, error) {
+	files, err := filepath.Glob(pattern)
+	if err != nil {
+		return 0, err
+	}
+
+	if len(files) <= max {
+		return 0, nil
+	}
+
+	sort.Strings(files)
+
+	removed := 0
+	for _, f := range files[:len(files)-max] {
+		err := os.Remove(f)
+		if err != nil {
+			return removed, err
+		}
+		removed++
+	}
+
+	return removed, nil
+}
+
+// capture takes a snapshot and writes it to the file node of slot
+func (cc *CameraClient) capture(ctx context.Context, config Camera, slot int) error {
+	f, err := os.CreateTemp("", "siot-camera-*.jpg")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+
+	err = runHostCommand(ctx, cameraCaptureArgs(config, f.Name()))
+	if err != nil {
+		return fmt.Errorf("Error capturing snapshot: %v", err)
+	}
+
+	d, err := os.ReadFile(f.Name())
+	if err != nil {
+		return fmt.Errorf("Error reading snapshot: %v", err)
+	}
+
+	now := time.Now()
+	file := NewFile(cameraSnapshotID(config.ID, slot), config.ID,
+		now.UTC().Format(snapshotTimeFormat)+".jpg", d, true)
+
+	// leave room for the other points and the message overhead
+	if int64(len(file.Data)) > cc.nc.MaxPayload()-4096 {
+		return fmt.Errorf("snapshot is too large (%v bytes), try a lower resolution", len(d))
+	}
+
+	err = SendNodeType(cc.nc, file, "")
+	if err != nil {
+		return fmt.Errorf("Error sending snapshot: %v", err)
+	}
+
+	return SendNodePoint(cc.nc, config.ID, data.Point{Time: now,
+		Type: data.PointTypeLastSnapshot, Text: file.ID}, false)
+}
+
+// Start runs the main logic for this client and blocks until stopped
+func (cc *CameraClient) Start() error {
+	log.Println("Starting camera client: ", cc.config.Description)
+
+	t := time.NewTicker(time.Hour)
+	t.Stop()
+
+	setup := func() {
+		t.Stop()
+
+		if cc.config.Disable || cc.config.SamplePeriod <= 0 {
+			return
+		}
+
+		t.Reset(time.Duration(cc.config.SamplePeriod * float64(time.Second)))
+	}
+
+	// snapshots are captured in a goroutine so points are handled while
+	// ffmpeg runs, and only one capture runs at a time
+	captureDone := make(chan error)
+	var cancelCapture context.CancelFunc
+	// clearCapture is set if the running capture was triggered by the
+	// capture point
+	clearCapture := false
+	slot := cameraNextSlot(cc.config)
+
+	capture := func(triggered bool) {
+		if cc.config.Disable {
+			return
+		}
+
+		if cancelCapture != nil {
+			log.Printf("Camera %v: capture in progress, skipping snapshot\n",
+				cc.config.Description)
+			return
+		}
+
+		var ctx context.Context
+		ctx, cancelCapture = context.WithTimeout(context.Background(),
+			cameraCaptureTimeout(cc.config))
+		clearCapture = triggered
+
+		config := cc.config
+		s := slot
+		slot = (slot + 1) % cameraMaxSnapshots(config)
+
+		go func() {
+			captureDone <- cc.capture(ctx, config, s)
+		}()
+	}
+
+	setup()
+
+done:
+	for {
+		select {
+		case <-cc.stop:
+			log.Println("Stopping camera client: ", cc.config.Description)
+			break done
+		case <-t.C:
+			capture(false)
+		case err := <-captureDone:
+			cancelCapture()
+			cancelCapture = nil
+
+			if err != nil {
+				log.Printf("Camera %v: %v\n", cc.config.Description, err)
+			}
+
+			if clearCapture {
+				err := SendNodePoint(cc.nc, cc.config.ID, data.Point{
+					Type: data.PointTypeCapture, Value: 0}, false)
+				if err != nil {
+					log.Println("Camera error clearing capture: ", err)
+				}
+			}
+		case pts := <-cc.newPoints:
+			err := data.MergePoints(pts.ID, pts.Points, &cc.config)
+			if err != nil {
+				log.Println("error merging new points: ", err)
+			}
+
+			// points are debounced, so several capture points can
+			// arrive together and only result in one snapshot
+			triggered := false
+			for _, p := range pts.Points {
+				switch p.Type {
+				case data.PointTypeSamplePeriod, data.PointTypeDisable:
+					setup()
+				case data.PointTypeMaxSnapshots:
+					slot %= cameraMaxSnapshots(cc.config)
+				case data.PointTypeCapture:
+					triggered = p.Value != 0
+				}
+			}
+
+			if triggered {
+				capture(true)
+			}
+
+		case pts := <-cc.newEdgePoints:
+			err := data.MergeEdgePoints(pts.ID, pts.Parent, pts.Points, &cc.config)
+			if err != nil {
+				log.Println("error merging new points: ", err)
+			}
+		}
+	}
+
+	// clean up
+	t.Stop()
+	if cancelCapture != nil {
+		cancelCapture()
+		<-captureDone
+	}
+	return nil
+}
+
+// Stop sends a signal to the Start function to exit
+func (cc *CameraClient) Stop(err error) {
+	close(cc.stop)
+}
+
+// Points is called by the Manager when new points for this
+// node are received.
+func (cc *CameraClient) Points(nodeID string, points []data.Point) {
+	cc.newPoints <- NewPoints{nodeID, "", points}
+}
+
+// EdgePoints is called by the Manager when new edge points for this
+// node are received.
+func (cc *CameraClient) EdgePoints(nodeID, parentID string, points []data.Point) {
+	cc.newEdgePoints <- NewPoints{nodeID, parentID, points}
+}
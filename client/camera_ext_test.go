@@ -0,0 +1,86 @@
+package client_test
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/simpleiot/simpleiot/client"
+	"github.com/simpleiot/simpleiot/data"
+	"github.com/simpleiot/simpleiot/server"
+)
+
+func TestCameraCapture(t *testing.T) {
+	jpg := []byte{0xff, 0xd8, 0xff, 0xe0, 0x00, 0xff, 0xd9}
+
+	// ffmpeg writes the snapshot to the last argument
+	restore := client.SetRunHostCommand(func(ctx context.Context, args []string) error {
+		return os.WriteFile(args[len(args)-1], jpg, 0644)
+	})
+	defer restore()
+
+	nc, root, stop, err := server.TestServer()
+	if err != nil {
+		t.Fatal("Error starting test server: ", err)
+	}
+	defer stop()
+
+	cam := client.Camera{ID: "cam", Parent: root.ID, Description: "cam",
+		MaxSnapshots: 2}
+
+	if err := client.SendNodeType(nc, cam, "test"); err != nil {
+		t.Fatal(err)
+	}
+
+	// the capture point is sent until the client is running and has
+	// written both snapshot slots
+	for i := 0; i < 50; i++ {
+		err := client.SendNodePoint(nc, cam.ID, data.Point{
+			Type: data.PointTypeCapture, Value: 1, Origin: "test"}, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		time.Sleep(200 * time.Millisecond)
+
+		files, err := client.GetNodeChildrenType[client.File](nc, cam.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(files) < 2 {
+			continue
+		}
+
+		if len(files) > 2 {
+			t.Fatal("expected 2 snapshots, got: ", len(files))
+		}
+
+		for _, f := range files {
+			d, err := f.Contents()
+			if err != nil {
+				t.Fatal("error decoding snapshot: ", err)
+			}
+
+			if !bytes.Equal(d, jpg) {
+				t.Error("wrong snapshot contents")
+			}
+		}
+
+		cams, err := client.GetNodeType[client.Camera](nc, cam.ID, root.ID)
+		if err != nil || len(cams) < 1 {
+			t.Fatal("error getting camera: ", err)
+		}
+
+		last := cams[0].LastSnapshot
+		if last != files[0].ID && last != files[1].ID {
+			t.Error("last snapshot is not a file node: ", last)
+		}
+
+		return
+	}
+
+	t.Fatal("snapshots were not captured")
+}
@@ -0,0 +1,105 @@
+package client
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCameraCaptureArgs(t *testing.T) {
+	args := cameraCaptureArgs(Camera{Resolution: "640x480"}, "snap.jpg")
+	exp := []string{"ffmpeg", "-y", "-loglevel", "error", "-f", "v4l2",
+		"-video_size", "640x480", "-i", "/dev/video0", "-frames:v", "1",
+		"-q:v", "2", "snap.jpg"}
+
+	if !reflect.DeepEqual(args, exp) {
+		t.Errorf("v4l2 args: expected %v, got %v", exp, args)
+	}
+
+	args = cameraCaptureArgs(Camera{URI: "rtsp://cam/stream", Device: "/dev/video1"},
+		"snap.jpg")
+	exp = []string{"ffmpeg", "-y", "-loglevel", "error", "-rtsp_transport", "tcp",
+		"-i", "rtsp://cam/stream", "-frames:v", "1", "-q:v", "2", "snap.jpg"}
+
+	if !reflect.DeepEqual(args, exp) {
+		t.Errorf("rtsp args: expected %v, got %v", exp, args)
+	}
+}
+
+func TestPruneFiles(t *testing.T) {
+	dir := t.TempDir()
+
+	files := []string{"20221020T100000.000Z.jpg", "20221020T090000.000Z.jpg",
+		"20221020T110000.000Z.jpg", "notes.txt"}
+
+	for _, f := range files {
+		err := os.WriteFile(filepath.Join(dir, f), nil, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	removed, err := pruneFiles(filepath.Join(dir, "*.jpg"), 2)
+	if err != nil {
+		t.Fatal("prune error: ", err)
+	}
+
+	if removed != 1 {
+		t.Error("expected 1 snapshot removed, got: ", removed)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "20221020T090000.000Z.jpg")); err == nil {
+		t.Error("oldest snapshot was not removed")
+	}
+
+	for _, f := range []string{"20221020T100000.000Z.jpg", "20221020T110000.000Z.jpg",
+		"notes.txt"} {
+		if _, err := os.Stat(filepath.Join(dir, f)); err != nil {
+			t.Error("file should not be removed: ", f)
+		}
+	}
+}
+
+func TestCameraSnapshotSlots(t *testing.T) {
+	c := Camera{ID: "cam", MaxSnapshots: 3}
+
+	if slot := cameraNextSlot(c); slot != 0 {
+		t.Error("expected slot 0 without a last snapshot, got: ", slot)
+	}
+
+	c.LastSnapshot = cameraSnapshotID(c.ID, 1)
+	if slot := cameraNextSlot(c); slot != 2 {
+		t.Error("expected slot 2, got: ", slot)
+	}
+
+	c.LastSnapshot = cameraSnapshotID(c.ID, 2)
+	if slot := cameraNextSlot(c); slot != 0 {
+		t.Error("expected slots to wrap to 0, got: ", slot)
+	}
+
+	c.LastSnapshot = "other-snapshot-1"
+	if slot := cameraNextSlot(c); slot != 0 {
+		t.Error("expected slot 0 for a snapshot of another camera, got: ", slot)
+	}
+}
+
+func TestCameraCaptureTimeout(t *testing.T) {
+	tests := []struct {
+		period float64
+		exp    time.Duration
+	}{
+		{0, 30 * time.Second},
+		{1, 5 * time.Second},
+		{60, time.Minute},
+	}
+
+	for _, test := range tests {
+		timeout := cameraCaptureTimeout(Camera{SamplePeriod: test.period})
+		if timeout != test.exp {
+			t.Errorf("sample period %v: expected %v, got %v", test.period,
+				test.exp, timeout)
+		}
+	}
+}
@@ -0,0 +1,499 @@
+package client
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/simpleiot/simpleiot/data"
+)
+
+// CertAuthority config. A certificate authority node issues client
+// certificates to devices so that they can connect with mutual TLS. Devices
+// send a certificate signing request to cert.issue (see RequestCert), and
+// the CA signs it if the device node exists and its certificate has not been
+// revoked. Setting the certRevoked point of a device node revokes its
+// certificates. The CA key and certificate are stored in directory.
+type CertAuthority struct {
+	ID          string  `node:"id"`
+	Parent      string  `node:"parent"`
+	Description string  `point:"description"`
+	Directory   string  `point:"directory"`
+	CertDays    float64 `point:"certDays"`
+	Disable     bool    `point:"disable"`
+}
+
+// certAuthorityDir returns the directory the CA files are stored in
+func certAuthorityDir(config CertAuthority) string {
+	if config.Directory != "" {
+		return config.Directory
+	}
+	return filepath.Join("ca", config.ID)
+}
+
+// CertAuthorityClient is a client for certificate authority nodes
+type CertAuthorityClient struct {
+	nc            *nats.Conn
+	config        CertAuthority
+	stop          chan struct{}
+	newPoints     chan NewPoints
+	newEdgePoints chan NewPoints
+	chIssue       chan *nats.Msg
+	chRevoke      chan NewPoints
+	cert          *x509.Certificate
+	certPEM       []byte
+	key           crypto.Signer
+	// revoked maps revoked serial numbers to device IDs
+	revoked map[string]string
+}
+
+// NewCertAuthorityClient ...
+func NewCertAuthorityClient(nc *nats.Conn, config CertAuthority) Client {
+	return &CertAuthorityClient{
+		nc:            nc,
+		config:        config,
+		stop:          make(chan struct{}),
+		newPoints:     make(chan NewPoints),
+		newEdgePoints: make(chan NewPoints),
+		chIssue:       make(chan *nats.Msg),
+		chRevoke:      make(chan NewPoints),
+		revoked:       make(map[string]string),
+	}
+}
+
+// loadCA reads the CA key and certificate, creating them if they do not exist
+func (cac *CertAuthorityClient) loadCA() error {
+	dir := certAuthorityDir(cac.config)
+	certFile := filepath.Join(dir, "ca.pem")
+	keyFile := filepath.Join(dir, "ca-key.pem")
+
+	_, err := os.Stat(certFile)
+	if errors.Is(err, os.ErrNotExist) {
+		err = createCA(dir, certFile, keyFile, cac.config.Description)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	cac.certPEM, err = os.ReadFile(certFile)
+	if err != nil {
+		return err
+	}
+
+	keyPEM, err := os.ReadFile(keyFile)
+	if err != nil {
+		return err
+	}
+
+	block, _ := pem.Decode(cac.certPEM)
+	if block == nil {
+		return errors.New("CA cert is not valid")
+	}
+
+	cac.cert, err = x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return err
+	}
+
+	block, _ = pem.Decode(keyPEM)
+	if block == nil {
+		return errors.New("CA key is not valid")
+	}
+
+	cac.key, err = x509.ParseECPrivateKey(block.Bytes)
+	return err
+}
+
+func createCA(dir, certFile, keyFile, name string) error {
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return fmt.Errorf("Error creating CA directory: %v", err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+
+	serial, err := newSerial()
+	if err != nil {
+		return err
+	}
+
+	if name == "" {
+		name = "SIOT device CA"
+	}
+
+	now := time.Now()
+
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             now.Add(-5 * time.Minute),
+		NotAfter:              now.AddDate(10, 0, 0),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return err
+	}
+
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+
+	err = os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY",
+		Bytes: keyDer}), 0600)
+	if err != nil {
+		return err
+	}
+
+	log.Println("Created device CA: ", certFile)
+
+	return os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE",
+		Bytes: der}), 0644)
+}
+
+func newSerial() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
+
+func (cac *CertAuthorityClient) revokedFile() string {
+	return filepath.Join(certAuthorityDir(cac.config), "revoked.json")
+}
+
+func (cac *CertAuthorityClient) loadRevoked() error {
+	d, err := os.ReadFile(cac.revokedFile())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(d, &cac.revoked)
+}
+
+// saveRevoked writes the revoked serial numbers and publishes them so that
+// the NATS server rejects connections that use them
+func (cac *CertAuthorityClient) saveRevoked() error {
+	d, err := json.Marshal(cac.revoked)
+	if err != nil {
+		return err
+	}
+
+	err = os.WriteFile(cac.revokedFile(), d, 0600)
+	if err != nil {
+		return err
+	}
+
+	return cac.publishRevoked()
+}
+
+func (cac *CertAuthorityClient) publishRevoked() error {
+	var r CertRevoked
+	for s := range cac.revoked {
+		r.Serials = append(r.Serials, s)
+	}
+
+	d, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	return cac.nc.Publish(SubjectCertRevoked(cac.config.ID), d)
+}
+
+// issue signs a certificate request
+func (cac *CertAuthorityClient) issue(req CertRequest) (CertResult, error) {
+	if cac.config.Disable {
+		return CertResult{}, errors.New("certificate authority is disabled")
+	}
+
+	if req.DeviceID == "" || strings.ContainsAny(req.DeviceID, ".*> ") {
+		return CertResult{}, errors.New("invalid device ID")
+	}
+
+	nodes, err := GetNode(cac.nc, req.DeviceID, "all")
+	if err != nil {
+		return CertResult{}, fmt.Errorf("Error getting device node: %v", err)
+	}
+
+	if len(nodes) < 1 {
+		return CertResult{}, errors.New("unknown device")
+	}
+
+	if revoked, _ := nodes[0].Points.ValueBool(data.PointTypeCertRevoked, ""); revoked {
+		return CertResult{}, errors.New("device certificate is revoked")
+	}
+
+	block, _ := pem.Decode([]byte(req.CSR))
+	if block == nil || block.Type != "CERTIFICATE REQUEST" {
+		return CertResult{}, errors.New("no certificate request found")
+	}
+
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		return CertResult{}, err
+	}
+
+	if err := csr.CheckSignature(); err != nil {
+		return CertResult{}, fmt.Errorf("certificate request signature: %v", err)
+	}
+
+	serial, err := newSerial()
+	if err != nil {
+		return CertResult{}, err
+	}
+
+	days := cac.config.CertDays
+	if days <= 0 {
+		days = 90
+	}
+
+	now := time.Now()
+
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: req.DeviceID},
+		NotBefore:    now.Add(-5 * time.Minute),
+		NotAfter:     now.Add(time.Duration(days * 24 * float64(time.Hour))),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, cac.cert, csr.PublicKey, cac.key)
+	if err != nil {
+		return CertResult{}, err
+	}
+
+	ret := CertResult{
+		Cert:   string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		CA:     string(cac.certPEM),
+		Serial: serial.Text(16),
+	}
+
+	log.Printf("Cert authority %v: issued cert %v to device %v\n", cac.config.Description,
+		ret.Serial, req.DeviceID)
+
+	err = SendNodePoints(cac.nc, req.DeviceID, data.Points{
+		{Time: now, Type: data.PointTypeCertSerial, Text: ret.Serial},
+		{Time: now, Type: data.PointTypeCertStatus, Text: data.PointValueValid},
+		CertExpiresPoint("device", tmpl.NotAfter, now),
+	}, false)
+	if err != nil {
+		log.Println("Cert authority: error sending device cert points: ", err)
+	}
+
+	return ret, nil
+}
+
+func (cac *CertAuthorityClient) handleIssue(msg *nats.Msg) {
+	var req CertRequest
+	var res CertResult
+
+	err := json.Unmarshal(msg.Data, &req)
+	if err == nil {
+		res, err = cac.issue(req)
+	}
+
+	if err != nil {
+		res = CertResult{Error: err.Error()}
+	}
+
+	d, err := json.Marshal(res)
+	if err != nil {
+		log.Println("Cert authority: error encoding response: ", err)
+		return
+	}
+
+	err = cac.nc.Publish(msg.Reply, d)
+	if err != nil {
+		log.Println("Cert authority: error responding to request: ", err)
+	}
+}
+
+// revoke updates the revoked certificates of a device
+func (cac *CertAuthorityClient) revoke(deviceID string, revoke bool) {
+	changed := false
+
+	if revoke {
+		nodes, err := GetNode(cac.nc, deviceID, "all")
+		if err != nil || len(nodes) < 1 {
+			log.Println("Cert authority: error getting revoked device node: ", err)
+			return
+		}
+
+		serial, _ := nodes[0].Points.Text(data.PointTypeCertSerial, "")
+		if serial == "" {
+			return
+		}
+
+		if cac.revoked[serial] == "" {
+			cac.revoked[serial] = deviceID
+			changed = true
+		}
+	} else {
+		for s, id := range cac.revoked {
+			if id == deviceID {
+				delete(cac.revoked, s)
+				changed = true
+			}
+		}
+	}
+
+	if !changed {
+		return
+	}
+
+	status := data.PointValueValid
+	if revoke {
+		status = data.PointValueRevoked
+	}
+
+	log.Printf("Cert authority %v: device %v cert %v\n", cac.config.Description,
+		deviceID, status)
+
+	err := cac.saveRevoked()
+	if err != nil {
+		log.Println("Cert authority: error saving revoked certs: ", err)
+	}
+
+	err = SendNodePoint(cac.nc, deviceID, data.Point{Type: data.PointTypeCertStatus,
+		Text: status}, false)
+	if err != nil {
+		log.Println("Cert authority: error sending cert status: ", err)
+	}
+}
+
+// Start runs the main logic for this client and blocks until stopped
+func (cac *CertAuthorityClient) Start() error {
+	log.Println("Starting cert authority client: ", cac.config.Description)
+
+	err := cac.loadCA()
+	if err != nil {
+		return fmt.Errorf("Error loading CA: %v", err)
+	}
+
+	err = cac.loadRevoked()
+	if err != nil {
+		log.Println("Cert authority: error loading revoked certs: ", err)
+	}
+
+	err = cac.publishRevoked()
+	if err != nil {
+		log.Println("Cert authority: error publishing revoked certs: ", err)
+	}
+
+	if tlsCA := string(cac.certPEM); tlsCA != "" {
+		err := SendNodePoint(cac.nc, cac.config.ID, data.Point{Type: data.PointTypeTLSCA,
+			Text: tlsCA}, false)
+		if err != nil {
+			log.Println("Cert authority: error sending CA cert: ", err)
+		}
+	}
+
+	issueSub, err := cac.nc.Subscribe(SubjectCertIssue, func(msg *nats.Msg) {
+		select {
+		case cac.chIssue <- msg:
+		case <-cac.stop:
+		}
+	})
+	if err != nil {
+		return fmt.Errorf("Cert authority error subscribing: %v", err)
+	}
+
+	// watch for revoked devices anywhere below the parent node
+	upSub, err := cac.nc.Subscribe(fmt.Sprintf("up.%v.*.points", cac.config.Parent),
+		func(msg *nats.Msg) {
+			chunks := strings.Split(msg.Subject, ".")
+			if len(chunks) != 4 {
+				return
+			}
+
+			points, err := data.PbDecodePoints(msg.Data)
+			if err != nil {
+				return
+			}
+
+			for _, p := range points {
+				if p.Type == data.PointTypeCertRevoked {
+					select {
+					case cac.chRevoke <- NewPoints{chunks[2], "", data.Points{p}}:
+					case <-cac.stop:
+					}
+				}
+			}
+		})
+	if err != nil {
+		issueSub.Unsubscribe()
+		return fmt.Errorf("Cert authority error subscribing: %v", err)
+	}
+
+done:
+	for {
+		select {
+		case <-cac.stop:
+			log.Println("Stopping cert authority client: ", cac.config.Description)
+			break done
+		case msg := <-cac.chIssue:
+			cac.handleIssue(msg)
+		case pts := <-cac.chRevoke:
+			for _, p := range pts.Points {
+				cac.revoke(pts.ID, p.Value != 0)
+			}
+		case pts := <-cac.newPoints:
+			err := data.MergePoints(pts.ID, pts.Points, &cac.config)
+			if err != nil {
+				log.Println("error merging new points: ", err)
+			}
+		case pts := <-cac.newEdgePoints:
+			err := data.MergeEdgePoints(pts.ID, pts.Parent, pts.Points, &cac.config)
+			if err != nil {
+				log.Println("error merging new points: ", err)
+			}
+		}
+	}
+
+	issueSub.Unsubscribe()
+	upSub.Unsubscribe()
+
+	return nil
+}
+
+// Stop sends a signal to the Start function to exit
+func (cac *CertAuthorityClient) Stop(err error) {
+	close(cac.stop)
+}
+
+// Points is called by the Manager when new points for this
+// node are received.
+func (cac *CertAuthorityClient) Points(nodeID string, points []data.Point) {
+	cac.newPoints <- NewPoints{nodeID, "", points}
+}
+
+// EdgePoints is called by the Manager when new edge points for this
+// node are received.
+func (cac *CertAuthorityClient) EdgePoints(nodeID, parentID string, points []data.Point) {
+	cac.newEdgePoints <- NewPoints{nodeID, parentID, points}
+}
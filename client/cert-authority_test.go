@@ -0,0 +1,152 @@
+package client_test
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/simpleiot/simpleiot/client"
+	"github.com/simpleiot/simpleiot/data"
+	"github.com/simpleiot/simpleiot/server"
+)
+
+func TestCertAuthority(t *testing.T) {
+	nc, root, stop, err := server.TestServer()
+	if err != nil {
+		t.Fatal("Error starting test server: ", err)
+	}
+	defer stop()
+
+	ca := client.CertAuthority{
+		ID:          "ID-ca",
+		Parent:      root.ID,
+		Description: "ca",
+		Directory:   t.TempDir(),
+		CertDays:    30,
+	}
+
+	err = client.SendNodeType(nc, ca, "test")
+	if err != nil {
+		t.Fatal("Error sending node: ", err)
+	}
+
+	dev := data.NodeEdge{ID: "ID-dev", Type: data.NodeTypeDevice, Parent: root.ID}
+	err = client.SendNode(nc, dev, "test")
+	if err != nil {
+		t.Fatal("Error sending device node: ", err)
+	}
+
+	chRevoked := make(chan client.CertRevoked, 10)
+	sub, err := nc.Subscribe(client.SubjectCertRevoked(ca.ID), func(msg *nats.Msg) {
+		var r client.CertRevoked
+		if err := json.Unmarshal(msg.Data, &r); err == nil {
+			chRevoked <- r
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Unsubscribe()
+
+	_, csr, err := client.NewCertKey(dev.ID)
+	if err != nil {
+		t.Fatal("Error creating key: ", err)
+	}
+
+	// wait for the CA client to start
+	var res client.CertResult
+	start := time.Now()
+	for {
+		res, err = client.RequestCert(nc, dev.ID, csr)
+		if err == nil {
+			break
+		}
+		if time.Since(start) > 5*time.Second {
+			t.Fatal("Error requesting cert: ", err)
+		}
+		<-time.After(100 * time.Millisecond)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM([]byte(res.CA)) {
+		t.Fatal("CA cert not valid")
+	}
+
+	block, _ := pem.Decode([]byte(res.Cert))
+	if block == nil {
+		t.Fatal("cert not valid")
+	}
+
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal("Error parsing cert: ", err)
+	}
+
+	_, err = cert.Verify(x509.VerifyOptions{Roots: pool,
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}})
+	if err != nil {
+		t.Fatal("cert was not signed by CA: ", err)
+	}
+
+	if cert.Subject.CommonName != dev.ID || client.CertSerial(cert) != res.Serial {
+		t.Fatal("unexpected cert: ", cert.Subject, res.Serial)
+	}
+
+	getDev := func() data.NodeEdge {
+		nodes, err := client.GetNode(nc, dev.ID, root.ID)
+		if err != nil || len(nodes) < 1 {
+			t.Fatal("Error getting device node: ", err)
+		}
+		return nodes[0]
+	}
+
+	n := getDev()
+	if serial, _ := n.Points.Text(data.PointTypeCertSerial, ""); serial != res.Serial {
+		t.Fatal("device cert serial not set: ", serial)
+	}
+
+	if _, err := client.RequestCert(nc, "unknown", csr); err == nil {
+		t.Fatal("expected error for unknown device")
+	}
+
+	// revoke the device
+	err = client.SendNodePoint(nc, dev.ID, data.Point{Type: data.PointTypeCertRevoked,
+		Value: 1, Origin: "test"}, true)
+	if err != nil {
+		t.Fatal("Error revoking device: ", err)
+	}
+
+	timeout := time.After(5 * time.Second)
+	for found := false; !found; {
+		select {
+		case r := <-chRevoked:
+			for _, s := range r.Serials {
+				if s == res.Serial {
+					found = true
+				}
+			}
+		case <-timeout:
+			t.Fatal("timeout waiting for revoked cert")
+		}
+	}
+
+	start = time.Now()
+	for {
+		n := getDev()
+		status, _ := n.Points.Text(data.PointTypeCertStatus, "")
+		if status == data.PointValueRevoked {
+			break
+		}
+		if time.Since(start) > 2*time.Second {
+			t.Fatal("device cert status not revoked: ", status)
+		}
+		<-time.After(50 * time.Millisecond)
+	}
+
+	if _, err := client.RequestCert(nc, dev.ID, csr); err == nil {
+		t.Fatal("expected error for revoked device")
+	}
+}
@@ -0,0 +1,134 @@
+package client
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// SubjectCertIssue is the NATS subject used to request a device certificate
+// from a certificate authority node
+const SubjectCertIssue = "cert.issue"
+
+// SubjectCertRevoked constructs the NATS subject a certificate authority
+// publishes its revoked certificate serial numbers on
+func SubjectCertRevoked(caID string) string {
+	return fmt.Sprintf("cert.%v.revoked", caID)
+}
+
+// CertRequest is a request for a device certificate. The device generates
+// the key, so only the certificate signing request (CSR) is sent.
+type CertRequest struct {
+	DeviceID string `json:"deviceID"`
+	CSR      string `json:"csr"`
+}
+
+// CertResult is the response to a certificate request. Cert and CA are in
+// PEM format.
+type CertResult struct {
+	Cert   string `json:"cert"`
+	CA     string `json:"ca"`
+	Serial string `json:"serial"`
+	Error  string `json:"error,omitempty"`
+}
+
+// CertRevoked is published by a certificate authority when the list of
+// revoked certificate serial numbers changes
+type CertRevoked struct {
+	Serials []string `json:"serials"`
+}
+
+// NewCertKey generates a device key and a certificate signing request for
+// it. Both are returned in PEM format.
+func NewCertKey(deviceID string) ([]byte, []byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject: pkix.Name{CommonName: deviceID},
+	}, key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}),
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csr}), nil
+}
+
+// CertSerial returns the serial number of a certificate in the format used
+// by certificate authority nodes
+func CertSerial(c *x509.Certificate) string {
+	return c.SerialNumber.Text(16)
+}
+
+// CertNeedsRenewal returns true if cert (a file name or PEM data) can not be
+// read, or if less than a third of its lifetime is left
+func CertNeedsRenewal(cert string, now time.Time) bool {
+	d, err := readPEM(cert)
+	if err != nil {
+		return true
+	}
+
+	block, _ := pem.Decode(d)
+	if block == nil {
+		return true
+	}
+
+	c, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return true
+	}
+
+	lifetime := c.NotAfter.Sub(c.NotBefore)
+	return c.NotAfter.Sub(now) < lifetime/3
+}
+
+// RequestCert requests a device certificate from the certificate authority
+// node reachable through nc
+func RequestCert(nc *nats.Conn, deviceID string, csr []byte) (CertResult, error) {
+	return RequestCertCtx(context.Background(), nc, deviceID, csr)
+}
+
+// RequestCertCtx is RequestCert with a context that can be used to cancel the
+// request
+func RequestCertCtx(ctx context.Context, nc *nats.Conn, deviceID string,
+	csr []byte) (CertResult, error) {
+	reqData, err := json.Marshal(CertRequest{DeviceID: deviceID, CSR: string(csr)})
+	if err != nil {
+		return CertResult{}, err
+	}
+
+	msg, err := request(ctx, nc, SubjectCertIssue, reqData, time.Second*20)
+	if err != nil {
+		return CertResult{}, err
+	}
+
+	var res CertResult
+	err = json.Unmarshal(msg.Data, &res)
+	if err != nil {
+		return CertResult{}, err
+	}
+
+	if res.Error != "" {
+		return res, errors.New(res.Error)
+	}
+
+	return res, nil
+}
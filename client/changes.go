@@ -0,0 +1,61 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/simpleiot/simpleiot/data"
+)
+
+// ChangesQuery is used to query the point change history of a node
+type ChangesQuery struct {
+	// Type and Key optionally select a point
+	Type string `json:"type,omitempty"`
+	Key  string `json:"key,omitempty"`
+	// Limit is the max number of changes returned, 0 for no limit
+	Limit int `json:"limit,omitempty"`
+}
+
+// ChangesResult is the response to a changes query
+type ChangesResult struct {
+	Changes []data.PointChange `json:"changes"`
+	Error   string             `json:"error,omitempty"`
+}
+
+// GetNodeChanges returns the recorded point changes for a node, newest
+// first. The store records changes to points that have the origin set
+// (changes made by users, rules, etc.) and keeps a limited number of changes
+// for each node.
+func GetNodeChanges(nc *nats.Conn, id string, q ChangesQuery) ([]data.PointChange, error) {
+	return GetNodeChangesCtx(context.Background(), nc, id, q)
+}
+
+// GetNodeChangesCtx is GetNodeChanges with a context that can be used to
+// cancel the request
+func GetNodeChangesCtx(ctx context.Context, nc *nats.Conn, id string,
+	q ChangesQuery) ([]data.PointChange, error) {
+	reqData, err := json.Marshal(q)
+	if err != nil {
+		return nil, err
+	}
+
+	msg, err := request(ctx, nc, SubjectNodeChanges(id), reqData, time.Second*20)
+	if err != nil {
+		return nil, err
+	}
+
+	var res ChangesResult
+	err = json.Unmarshal(msg.Data, &res)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.Error != "" {
+		return nil, errors.New(res.Error)
+	}
+
+	return res.Changes, nil
+}
@@ -3,6 +3,7 @@ package client
 import (
 	"fmt"
 	"log"
+	"runtime/debug"
 	"strings"
 	"sync"
 	"time"
@@ -11,6 +12,15 @@ import (
 	"github.com/simpleiot/simpleiot/data"
 )
 
+// client supervision
+const (
+	// clients that fail are restarted with an exponential backoff up to
+	// this delay
+	clientRestartMaxDelay = time.Minute
+	// the backoff is reset if a client ran this long before it failed
+	clientRestartResetTime = 10 * time.Minute
+)
+
 func mapKey(node data.NodeEdge) string {
 	return node.Parent + "-" + node.ID
 }
@@ -21,53 +31,35 @@ type clientState[T any] struct {
 	nec       data.NodeEdgeChildren
 	construct func(*nats.Conn, T) Client
 
+	debounce time.Duration
+
 	// subscription to listen for new points
-	upSub  *nats.Subscription
-	client Client
+	upSub    *nats.Subscription
+	client   Client
+	dispatch *pointDispatcher
+	lock     sync.Mutex
 
 	stopOnce sync.Once
 	chStop   chan struct{}
 }
 
 func newClientState[T any](nc *nats.Conn, construct func(*nats.Conn, T) Client,
-	n data.NodeEdge) *clientState[T] {
+	n data.NodeEdge, debounce time.Duration) *clientState[T] {
 
 	ret := &clientState[T]{
 		node:      n,
 		nc:        nc,
 		construct: construct,
+		debounce:  debounce,
 		chStop:    make(chan struct{}),
 	}
 
 	return ret
 }
 
-func (cs *clientState[T]) start() (err error) {
-	c, err := GetNodeChildren(cs.nc, cs.node.ID, "", false, false)
-	if err != nil {
-		err = fmt.Errorf("Error getting children: %v", err)
-		return
-	}
-
-	ncc := make([]data.NodeEdgeChildren, len(c))
-
-	for i, nci := range c {
-		ncc[i] = data.NodeEdgeChildren{NodeEdge: nci, Children: nil}
-	}
-
-	cs.nec = data.NodeEdgeChildren{NodeEdge: cs.node, Children: ncc}
-
-	var config T
-
-	err = data.Decode(cs.nec, &config)
-	if err != nil {
-		err = fmt.Errorf("Error decoding node: %v", err)
-		return
-	}
-
-	cs.client = cs.construct(cs.nc, config)
-
-	// Set up subscriptions
+// subscribe subscribes to points for the client node and its children. The
+// subscription handler waits on lock until the client is constructed.
+func (cs *clientState[T]) subscribe() (err error) {
 	subject := fmt.Sprintf("up.%v.>", cs.node.ID)
 
 	cs.upSub, err = cs.nc.Subscribe(subject, func(msg *nats.Msg) {
@@ -83,32 +75,57 @@ func (cs *clientState[T]) start() (err error) {
 			}
 		}
 
+		// the lock is not held while points are sent, so that a client
+		// that failed can be replaced
+		cs.lock.Lock()
+		dispatch := cs.dispatch
+		cs.lock.Unlock()
+
+		if dispatch == nil {
+			// client setup failed or the client is being restarted
+			return
+		}
+
 		// find node ID for points
 		chunks := strings.Split(msg.Subject, ".")
 		if len(chunks) == 4 {
 			// node points
 			for _, p := range points {
 				if p.Type == data.PointTypeNodeType {
+					dispatch.childrenChanged()
+					return
+				}
+
+				if chunks[2] == cs.node.ID && p.Type == data.PointTypeRestart &&
+					p.Value != 0 {
+					log.Printf("Restarting client %v %v\n", cs.node.Type, cs.node.ID)
+					err := SendNodePoint(cs.nc, cs.node.ID,
+						data.Point{Time: time.Now(), Type: data.PointTypeRestart}, false)
+					if err != nil {
+						log.Println("Error clearing restart point: ", err)
+					}
+					// the manager creates a new client state on the
+					// next scan
 					cs.stop(nil)
 					return
 				}
 			}
 
 			// send node points to client
-			cs.client.Points(chunks[2], points)
+			dispatch.points(chunks[2], "", false, points)
 
 		} else if len(chunks) == 5 {
 			// edge points
 			for _, p := range points {
 				if p.Type == data.PointTypeTombstone {
 					// a node was deleted, stop client and restart
-					cs.stop(nil)
+					dispatch.childrenChanged()
 					return
 				}
 			}
 
 			// send edge points to client
-			cs.client.EdgePoints(chunks[2], chunks[3], points)
+			dispatch.points(chunks[2], chunks[3], true, points)
 		} else {
 			log.Println("up subject malformed: ", msg.Subject)
 			return
@@ -116,34 +133,166 @@ func (cs *clientState[T]) start() (err error) {
 
 	})
 
+	return err
+}
+
+// config fetches the node children and decodes the client config
+func (cs *clientState[T]) config() (T, error) {
+	var config T
+
+	c, err := GetNodeChildren(cs.nc, cs.node.ID, "", false, false)
 	if err != nil {
-		return
+		return config, fmt.Errorf("Error getting children: %v", err)
 	}
 
-	chClientStopped := make(chan struct{})
+	ncc := make([]data.NodeEdgeChildren, len(c))
 
-	go func() {
-		// the following blocks until client exits
-		err := cs.client.Start()
-		if err != nil {
-			log.Printf("Client Start %v %v returned error: %v\n",
-				cs.node.Type, cs.node.ID, err)
+	for i, nci := range c {
+		ncc[i] = data.NodeEdgeChildren{NodeEdge: nci, Children: nil}
+	}
+
+	cs.nec = data.NodeEdgeChildren{NodeEdge: cs.node, Children: ncc}
+
+	err = data.Decode(cs.nec, &config)
+	if err != nil {
+		return config, fmt.Errorf("Error decoding node: %v", err)
+	}
+
+	return config, nil
+}
+
+// setup subscribes to points and constructs the client
+func (cs *clientState[T]) setup() error {
+	// Set up subscriptions before fetching the node children so that
+	// children added while the client is being set up are not missed.
+	cs.lock.Lock()
+	defer cs.lock.Unlock()
+
+	err := cs.subscribe()
+	if err != nil {
+		return err
+	}
+
+	config, err := cs.config()
+	if err != nil {
+		cs.upSub.Unsubscribe()
+		return err
+	}
+
+	cs.client = cs.construct(cs.nc, config)
+	cs.dispatch = newPointDispatcher(cs.client, cs.debounce, func() {
+		// the manager creates a new client state on the next scan
+		cs.stop(nil)
+	})
+	return nil
+}
+
+// runClient runs a client and returns the error returned by Start, or an
+// error if Start panics
+func runClient(c Client) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Client panic: %v\n%s", r, debug.Stack())
+			err = fmt.Errorf("panic: %v", r)
 		}
-		close(chClientStopped)
 	}()
 
-	<-cs.chStop
-	cs.upSub.Unsubscribe()
-	cs.client.Stop(nil)
+	return c.Start()
+}
 
-	select {
-	case <-chClientStopped:
-		// everything is OK
-	case <-time.After(5 * time.Second):
-		log.Println("Timeout stopping client: ", cs.node.Type, cs.node.ID)
+func (cs *clientState[T]) start() (err error) {
+	err = cs.setup()
+	if err != nil {
+		return err
 	}
 
-	return nil
+	restarts, _ := cs.node.Points.Value(data.PointTypeRestartCount, "")
+	attempts := 0
+
+	for {
+		cs.lock.Lock()
+		client := cs.client
+		cs.lock.Unlock()
+
+		chClientStopped := make(chan error, 1)
+		started := time.Now()
+
+		go func() {
+			// the following blocks until client exits
+			chClientStopped <- runClient(client)
+		}()
+
+		select {
+		case <-cs.chStop:
+			cs.upSub.Unsubscribe()
+			cs.lock.Lock()
+			cs.dispatch.stop()
+			cs.lock.Unlock()
+			client.Stop(nil)
+
+			select {
+			case <-chClientStopped:
+				// everything is OK
+			case <-time.After(5 * time.Second):
+				log.Println("Timeout stopping client: ", cs.node.Type, cs.node.ID)
+			}
+
+			return nil
+
+		case err := <-chClientStopped:
+			if err == nil {
+				// the client exited on its own, wait until it is
+				// stopped or restarted
+				<-cs.chStop
+				cs.upSub.Unsubscribe()
+				cs.lock.Lock()
+				cs.dispatch.stop()
+				cs.lock.Unlock()
+				return nil
+			}
+
+			log.Printf("Client Start %v %v returned error: %v\n",
+				cs.node.Type, cs.node.ID, err)
+
+			// a handler may be blocked sending points to the failed
+			// client, so a new subscription is used for the new client
+			cs.lock.Lock()
+			cs.dispatch.stop()
+			cs.client = nil
+			cs.dispatch = nil
+			cs.lock.Unlock()
+			cs.upSub.Unsubscribe()
+
+			if time.Since(started) > clientRestartResetTime {
+				attempts = 0
+			}
+
+			delay := ExpBackoff(attempts, clientRestartMaxDelay)
+			attempts++
+			restarts++
+
+			err = SendNodePoints(cs.nc, cs.node.ID, data.Points{
+				{Time: time.Now(), Type: data.PointTypeRestartCount, Value: restarts},
+				{Time: time.Now(), Type: data.PointTypeLastError, Text: err.Error()},
+			}, false)
+			if err != nil {
+				log.Println("Error sending client restart points: ", err)
+			}
+
+			select {
+			case <-cs.chStop:
+				return nil
+			case <-time.After(delay):
+			}
+
+			log.Printf("Restarting client %v %v\n", cs.node.Type, cs.node.ID)
+
+			err = cs.setup()
+			if err != nil {
+				return err
+			}
+		}
+	}
 }
 
 func (cs *clientState[T]) stop(err error) {
@@ -0,0 +1,27 @@
+package client
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/simpleiot/simpleiot/data"
+)
+
+type panicClient struct{}
+
+func (pc panicClient) Start() error {
+	panic("client bug")
+}
+
+func (pc panicClient) Stop(err error) {}
+
+func (pc panicClient) Points(nodeID string, points []data.Point) {}
+
+func (pc panicClient) EdgePoints(nodeID, parentID string, points []data.Point) {}
+
+func TestRunClientPanic(t *testing.T) {
+	err := runClient(panicClient{})
+	if err == nil || !strings.Contains(err.Error(), "client bug") {
+		t.Error("expected panic error, got: ", err)
+	}
+}
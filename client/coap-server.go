@@ -0,0 +1,610 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	coap "github.com/go-ocf/go-coap"
+	"github.com/go-ocf/go-coap/codes"
+	"github.com/nats-io/nats.go"
+	"github.com/pion/dtls/v2"
+	"github.com/simpleiot/simpleiot/cbor"
+	"github.com/simpleiot/simpleiot/data"
+)
+
+// CoapServer config. A CoAP server listens for CoAP requests secured with
+// DTLS-PSK on port (default 5684). Each device connects with the identity and
+// pre-shared key of a CoAP device node, and posts its values to the /points
+// resource.
+type CoapServer struct {
+	ID          string       `node:"id"`
+	Parent      string       `node:"parent"`
+	Description string       `point:"description"`
+	Port        int          `point:"port"`
+	Disable     bool         `point:"disable"`
+	Devices     []CoapDevice `child:"coapDevice"`
+}
+
+// CoapDevice is a device that can connect to a CoAP server. Values posted
+// by the device are written as points to this node.
+type CoapDevice struct {
+	ID          string `node:"id"`
+	Parent      string `node:"parent"`
+	Description string `point:"description"`
+	Identity    string `point:"identity"`
+	PSK         string `point:"psk"`
+	Disable     bool   `point:"disable"`
+}
+
+// coap timing and limits
+const (
+	coapHandshakeTimeout = 10 * time.Second
+	// sessions of devices that do not send anything are closed
+	coapIdleTimeout = 10 * time.Minute
+	coapMaxMessage  = 1152
+)
+
+// coapPoints converts a CBOR or JSON payload to points. The payload is a map
+// of point type to value, for example {"temperature": 21.5}. Values can be
+// numbers, bools, or text, or a map of point key to value for keyed points.
+func coapPoints(payload []byte, format coap.MediaType, now time.Time) (data.Points, error) {
+	var v interface{}
+	var err error
+
+	switch format {
+	case coap.AppCBOR:
+		v, err = cbor.Decode(payload)
+	case coap.AppJSON:
+		d := json.NewDecoder(bytes.NewReader(payload))
+		d.UseNumber()
+		err = d.Decode(&v)
+	default:
+		return nil, fmt.Errorf("unsupported content format: %v", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("payload must be a map")
+	}
+
+	var ret data.Points
+
+	add := func(typ, key string, v interface{}) error {
+		p := data.Point{Time: now, Type: typ, Key: key}
+
+		switch x := v.(type) {
+		case int64:
+			p.Value = float64(x)
+		case uint64:
+			p.Value = float64(x)
+		case float64:
+			p.Value = x
+		case json.Number:
+			p.Value, err = x.Float64()
+			if err != nil {
+				return err
+			}
+		case bool:
+			p.Value = data.BoolToFloat(x)
+		case string:
+			p.Text = x
+		default:
+			return fmt.Errorf("unsupported value for %v: %T", typ, v)
+		}
+
+		ret = append(ret, p)
+		return nil
+	}
+
+	for typ, v := range m {
+		if keyed, ok := v.(map[string]interface{}); ok {
+			for key, kv := range keyed {
+				if err := add(typ, key, kv); err != nil {
+					return nil, err
+				}
+			}
+			continue
+		}
+
+		if err := add(typ, "", v); err != nil {
+			return nil, err
+		}
+	}
+
+	sort.Sort(ret)
+
+	return ret, nil
+}
+
+// coapPayload converts points to a CBOR payload in the form accepted by
+// coapPoints. Credentials are not included.
+func coapPayload(points data.Points) ([]byte, error) {
+	m := make(map[string]interface{})
+
+	for _, p := range points {
+		if p.Tombstone != 0 || p.Type == data.PointTypePSK ||
+			p.Type == data.PointTypeIdentity {
+			continue
+		}
+
+		var v interface{} = p.Value
+		if p.Text != "" {
+			v = p.Text
+		}
+
+		if p.Key == "" || p.Key == "0" {
+			m[p.Type] = v
+			continue
+		}
+
+		keyed, ok := m[p.Type].(map[string]interface{})
+		if !ok {
+			keyed = make(map[string]interface{})
+			m[p.Type] = keyed
+		}
+		keyed[p.Key] = v
+	}
+
+	return cbor.Encode(m)
+}
+
+// coapSession is a DTLS session with a device
+type coapSession struct {
+	conn   net.Conn
+	device CoapDevice
+
+	// lock protects writes and the fields below
+	lock       sync.Mutex
+	messageID  uint16
+	observeSeq uint32
+	// observers are tokens of GET requests with the observe option
+	observers map[string][]byte
+	// posted are the times of points posted by the device, indexed by type
+	// and key, so they are not sent back to the device
+	posted map[string]time.Time
+}
+
+func (s *coapSession) write(m coap.Message) error {
+	var buf bytes.Buffer
+	err := m.MarshalBinary(&buf)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.conn.Write(buf.Bytes())
+	return err
+}
+
+// notify sends points to the observers of the session
+func (s *coapSession) notify(points data.Points) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if len(s.observers) == 0 {
+		return
+	}
+
+	var send data.Points
+	for _, p := range points {
+		if t, ok := s.posted[p.Type+"."+p.Key]; !ok || !t.Equal(p.Time) {
+			send = append(send, p)
+		}
+	}
+
+	if len(send) == 0 {
+		return
+	}
+
+	payload, err := coapPayload(send)
+	if err != nil {
+		log.Println("CoAP error encoding notification: ", err)
+		return
+	}
+
+	for _, token := range s.observers {
+		s.messageID++
+		s.observeSeq = (s.observeSeq + 1) & 0xffffff
+		m := coap.NewDgramMessage(coap.MessageParams{
+			Type:      coap.NonConfirmable,
+			Code:      codes.Content,
+			MessageID: s.messageID,
+			Token:     token,
+			Payload:   payload,
+		})
+		m.SetOption(coap.Observe, s.observeSeq)
+		m.SetOption(coap.ContentFormat, coap.AppCBOR)
+
+		err := s.write(m)
+		if err != nil {
+			log.Printf("CoAP %v: error sending notification: %v\n",
+				s.device.Description, err)
+		}
+	}
+}
+
+// coapListener accepts DTLS sessions from devices
+type coapListener struct {
+	nc  *nats.Conn
+	mux *udpMux
+
+	// lock protects the fields below
+	lock sync.Mutex
+	// devices are indexed by identity
+	devices  map[string]CoapDevice
+	sessions map[*coapSession]bool
+}
+
+func newCoapListener(nc *nats.Conn, port int, devices []CoapDevice) (*coapListener, error) {
+	cl := &coapListener{
+		nc:       nc,
+		sessions: make(map[*coapSession]bool),
+	}
+
+	cl.setDevices(devices)
+
+	var err error
+	cl.mux, err = listenUDPMux(port)
+	if err != nil {
+		return nil, err
+	}
+
+	go cl.accept()
+
+	return cl, nil
+}
+
+func (cl *coapListener) setDevices(devices []CoapDevice) {
+	cl.lock.Lock()
+	defer cl.lock.Unlock()
+
+	cl.devices = make(map[string]CoapDevice)
+	for _, d := range devices {
+		if d.Identity != "" && d.PSK != "" && !d.Disable {
+			cl.devices[d.Identity] = d
+		}
+	}
+}
+
+func (cl *coapListener) accept() {
+	for {
+		peer, err := cl.mux.Accept()
+		if err != nil {
+			return
+		}
+
+		go cl.handshake(peer)
+	}
+}
+
+// handshake does the DTLS handshake with a new peer and then serves the
+// session. Each handshake has its own config so the PSK identity of the
+// session is known.
+func (cl *coapListener) handshake(peer *udpPeer) {
+	var lock sync.Mutex
+	var device CoapDevice
+
+	config := &dtls.Config{
+		PSK: func(identity []byte) ([]byte, error) {
+			cl.lock.Lock()
+			d, ok := cl.devices[string(identity)]
+			cl.lock.Unlock()
+
+			if !ok {
+				return nil, fmt.Errorf("unknown identity: %q", identity)
+			}
+
+			lock.Lock()
+			device = d
+			lock.Unlock()
+			return []byte(d.PSK), nil
+		},
+		PSKIdentityHint: []byte("siot"),
+		CipherSuites: []dtls.CipherSuiteID{dtls.TLS_PSK_WITH_AES_128_CCM_8,
+			dtls.TLS_PSK_WITH_AES_128_CCM},
+		ConnectTimeout: dtls.ConnectTimeoutOption(coapHandshakeTimeout),
+	}
+
+	// make sure peers that stop responding during the handshake are removed
+	guard := time.AfterFunc(coapHandshakeTimeout+time.Second, func() {
+		peer.Close()
+	})
+
+	conn, err := dtls.Server(peer, config)
+	guard.Stop()
+	if err != nil {
+		log.Printf("CoAP handshake error from %v: %v\n", peer.RemoteAddr(), err)
+		peer.Close()
+		return
+	}
+
+	lock.Lock()
+	d := device
+	lock.Unlock()
+
+	s := &coapSession{conn: conn, device: d,
+		observers: make(map[string][]byte), posted: make(map[string]time.Time)}
+
+	cl.lock.Lock()
+	cl.sessions[s] = true
+	cl.lock.Unlock()
+
+	cl.serve(s)
+}
+
+// serve handles requests from a session until it is closed
+func (cl *coapListener) serve(s *coapSession) {
+	defer func() {
+		cl.lock.Lock()
+		delete(cl.sessions, s)
+		cl.lock.Unlock()
+		s.conn.Close()
+	}()
+
+	buf := make([]byte, coapMaxMessage)
+
+	for {
+		err := s.conn.SetReadDeadline(time.Now().Add(coapIdleTimeout))
+		if err != nil {
+			return
+		}
+
+		n, err := s.conn.Read(buf)
+		if err != nil {
+			return
+		}
+
+		req, err := coap.ParseDgramMessage(buf[:n])
+		if err != nil {
+			continue
+		}
+
+		switch req.Type() {
+		case coap.Reset:
+			// the device is no longer interested in notifications
+			s.lock.Lock()
+			s.observers = make(map[string][]byte)
+			s.lock.Unlock()
+			continue
+		case coap.Acknowledgement:
+			continue
+		}
+
+		resp := cl.handle(s, req)
+
+		s.lock.Lock()
+		if req.Type() == coap.Confirmable {
+			resp.SetType(coap.Acknowledgement)
+			resp.SetMessageID(req.MessageID())
+		} else {
+			resp.SetType(coap.NonConfirmable)
+			s.messageID++
+			resp.SetMessageID(s.messageID)
+		}
+		err = s.write(resp)
+		s.lock.Unlock()
+
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (cl *coapListener) handle(s *coapSession, req *coap.DgramMessage) *coap.DgramMessage {
+	resp := coap.NewDgramMessage(coap.MessageParams{Token: req.Token()})
+
+	if req.PathString() != "points" {
+		resp.SetCode(codes.NotFound)
+		return resp
+	}
+
+	switch req.Code() {
+	case codes.POST, codes.PUT:
+		format := coap.AppCBOR
+		if f, ok := req.Option(coap.ContentFormat).(coap.MediaType); ok {
+			format = f
+		}
+
+		pts, err := coapPoints(req.Payload(), format, time.Now())
+		if err != nil {
+			resp.SetCode(codes.BadRequest)
+			resp.SetPayload([]byte(err.Error()))
+			return resp
+		}
+
+		s.lock.Lock()
+		for _, p := range pts {
+			s.posted[p.Type+"."+p.Key] = p.Time
+		}
+		s.lock.Unlock()
+
+		err = SendNodePoints(cl.nc, s.device.ID, pts, true)
+		if err != nil {
+			log.Println("CoAP error sending points: ", err)
+			resp.SetCode(codes.InternalServerError)
+			return resp
+		}
+
+		resp.SetCode(codes.Changed)
+
+	case codes.GET:
+		nodes, err := GetNode(cl.nc, s.device.ID, s.device.Parent)
+		if err != nil || len(nodes) < 1 {
+			resp.SetCode(codes.InternalServerError)
+			return resp
+		}
+
+		payload, err := coapPayload(nodes[0].Points)
+		if err != nil {
+			resp.SetCode(codes.InternalServerError)
+			return resp
+		}
+
+		s.lock.Lock()
+		switch req.Option(coap.Observe) {
+		case uint32(0):
+			s.observers[string(req.Token())] = req.Token()
+			resp.SetOption(coap.Observe, s.observeSeq)
+		case uint32(1):
+			delete(s.observers, string(req.Token()))
+		}
+		s.lock.Unlock()
+
+		resp.SetCode(codes.Content)
+		resp.SetOption(coap.ContentFormat, coap.AppCBOR)
+		resp.SetPayload(payload)
+
+	default:
+		resp.SetCode(codes.MethodNotAllowed)
+	}
+
+	return resp
+}
+
+// notify sends points for a device to sessions observing it
+func (cl *coapListener) notify(nodeID string, points data.Points) {
+	cl.lock.Lock()
+	var sessions []*coapSession
+	for s := range cl.sessions {
+		if s.device.ID == nodeID {
+			sessions = append(sessions, s)
+		}
+	}
+	cl.lock.Unlock()
+
+	for _, s := range sessions {
+		s.notify(points)
+	}
+}
+
+func (cl *coapListener) close() {
+	err := cl.mux.Close()
+	if err != nil {
+		log.Println("CoAP error closing listener: ", err)
+	}
+
+	cl.lock.Lock()
+	defer cl.lock.Unlock()
+	for s := range cl.sessions {
+		s.conn.Close()
+	}
+}
+
+// CoapServerClient is a SIOT client used to accept values from constrained
+// devices over CoAP
+type CoapServerClient struct {
+	nc            *nats.Conn
+	config        CoapServer
+	stop          chan struct{}
+	newPoints     chan NewPoints
+	newEdgePoints chan NewPoints
+	listener      *coapListener
+}
+
+// NewCoapServerClient ...
+func NewCoapServerClient(nc *nats.Conn, config CoapServer) Client {
+	return &CoapServerClient{
+		nc:            nc,
+		config:        config,
+		stop:          make(chan struct{}),
+		newPoints:     make(chan NewPoints),
+		newEdgePoints: make(chan NewPoints),
+	}
+}
+
+func (csc *CoapServerClient) listen() {
+	if csc.listener != nil {
+		csc.listener.close()
+		csc.listener = nil
+	}
+
+	if csc.config.Disable {
+		log.Printf("CoAP server %v: disabled\n", csc.config.Description)
+		return
+	}
+
+	port := csc.config.Port
+	if port <= 0 {
+		port = 5684
+	}
+
+	var err error
+	csc.listener, err = newCoapListener(csc.nc, port, csc.config.Devices)
+	if err != nil {
+		log.Printf("CoAP server %v: error listening: %v\n", csc.config.Description, err)
+	}
+}
+
+// Start runs the main logic for this client and blocks until stopped
+func (csc *CoapServerClient) Start() error {
+	log.Println("Starting CoAP server client: ", csc.config.Description)
+
+	csc.listen()
+
+done:
+	for {
+		select {
+		case <-csc.stop:
+			log.Println("Stopping CoAP server client: ", csc.config.Description)
+			break done
+		case pts := <-csc.newPoints:
+			err := data.MergePoints(pts.ID, pts.Points, &csc.config)
+			if err != nil {
+				log.Println("error merging new points: ", err)
+			}
+
+			if pts.ID == csc.config.ID {
+				for _, p := range pts.Points {
+					switch p.Type {
+					case data.PointTypePort, data.PointTypeDisable:
+						csc.listen()
+					}
+				}
+				continue
+			}
+
+			if csc.listener != nil {
+				csc.listener.setDevices(csc.config.Devices)
+				csc.listener.notify(pts.ID, pts.Points)
+			}
+
+		case pts := <-csc.newEdgePoints:
+			err := data.MergeEdgePoints(pts.ID, pts.Parent, pts.Points, &csc.config)
+			if err != nil {
+				log.Println("error merging new points: ", err)
+			}
+		}
+	}
+
+	// clean up
+	if csc.listener != nil {
+		csc.listener.close()
+	}
+	return nil
+}
+
+// Stop sends a signal to the Start function to exit
+func (csc *CoapServerClient) Stop(err error) {
+	close(csc.stop)
+}
+
+// Points is called by the Manager when new points for this
+// node are received.
+func (csc *CoapServerClient) Points(nodeID string, points []data.Point) {
+	csc.newPoints <- NewPoints{nodeID, "", points}
+}
+
+// EdgePoints is called by the Manager when new edge points for this
+// node are received.
+func (csc *CoapServerClient) EdgePoints(nodeID, parentID string, points []data.Point) {
+	csc.newEdgePoints <- NewPoints{nodeID, parentID, points}
+}
@@ -0,0 +1,124 @@
+package client_test
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	coap "github.com/go-ocf/go-coap"
+	"github.com/go-ocf/go-coap/codes"
+	"github.com/pion/dtls/v2"
+	"github.com/simpleiot/simpleiot/cbor"
+	"github.com/simpleiot/simpleiot/client"
+	"github.com/simpleiot/simpleiot/server"
+)
+
+func freeUDPPort(t *testing.T) int {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).Port
+}
+
+func TestCoapServer(t *testing.T) {
+	nc, root, stop, err := server.TestServer()
+	if err != nil {
+		t.Fatal("Error starting test server: ", err)
+	}
+	defer stop()
+
+	port := freeUDPPort(t)
+
+	cs := client.CoapServer{ID: "coap", Parent: root.ID, Description: "coap",
+		Port: port}
+	dev := client.CoapDevice{ID: "coap-dev", Parent: cs.ID,
+		Description: "sensor", Identity: "sensor1", PSK: "secret"}
+
+	if err := client.SendNodeType(nc, cs, "test"); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.SendNodeType(nc, dev, "test"); err != nil {
+		t.Fatal(err)
+	}
+
+	m := client.NewManager(nc, root.ID, client.NewCoapServerClient)
+	go func() {
+		_ = m.Start()
+	}()
+	defer m.Stop(nil)
+
+	dial := func(identity, psk string) (*dtls.Conn, error) {
+		return dtls.Dial("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port},
+			&dtls.Config{
+				PSK: func([]byte) ([]byte, error) {
+					return []byte(psk), nil
+				},
+				PSKIdentityHint: []byte(identity),
+				CipherSuites:    []dtls.CipherSuiteID{dtls.TLS_PSK_WITH_AES_128_CCM_8},
+				ConnectTimeout:  dtls.ConnectTimeoutOption(2 * time.Second),
+			})
+	}
+
+	var conn *dtls.Conn
+	// wait for the client to start listening
+	for i := 0; i < 20; i++ {
+		conn, err = dial("sensor1", "secret")
+		if err == nil {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatal("error connecting: ", err)
+	}
+	defer conn.Close()
+
+	payload, _ := cbor.Encode(map[string]interface{}{"temperature": 21.5})
+	req := coap.NewDgramMessage(coap.MessageParams{Type: coap.Confirmable,
+		Code: codes.POST, MessageID: 1, Token: []byte{1}, Payload: payload})
+	req.SetPathString("points")
+	req.SetOption(coap.ContentFormat, coap.AppCBOR)
+
+	var buf bytes.Buffer
+	if err := req.MarshalBinary(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Write(buf.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+
+	rbuf := make([]byte, 1152)
+	if err := conn.SetReadDeadline(time.Now().Add(2 * time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	n, err := conn.Read(rbuf)
+	if err != nil {
+		t.Fatal("error reading response: ", err)
+	}
+
+	resp, err := coap.ParseDgramMessage(rbuf[:n])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.Code() != codes.Changed || resp.Type() != coap.Acknowledgement ||
+		resp.MessageID() != 1 {
+		t.Fatalf("wrong response: %v %v", resp.Code(), resp.Type())
+	}
+
+	nodes, err := client.GetNode(nc, dev.ID, cs.ID)
+	if err != nil || len(nodes) < 1 {
+		t.Fatal("error getting device node: ", err)
+	}
+
+	if v, _ := nodes[0].Points.Value("temperature", ""); v != 21.5 {
+		t.Error("temperature not written: ", nodes[0].Points)
+	}
+
+	if _, err := dial("sensor1", "wrong"); err == nil {
+		t.Error("connection with wrong key should fail")
+	}
+}
@@ -0,0 +1,88 @@
+package client
+
+import (
+	"testing"
+	"time"
+
+	coap "github.com/go-ocf/go-coap"
+	"github.com/simpleiot/simpleiot/cbor"
+	"github.com/simpleiot/simpleiot/data"
+)
+
+func TestCoapPoints(t *testing.T) {
+	payload, _ := cbor.Encode(map[string]interface{}{
+		"temperature": 21.5,
+		"battery":     int64(3),
+		"door":        true,
+		"state":       "sleeping",
+		"voltage":     map[string]interface{}{"1": 3.3, "2": 5.0},
+	})
+
+	pts, err := coapPoints(payload, coap.AppCBOR, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	check := func(typ, key string, value float64, text string) {
+		p, ok := pts.Find(typ, key)
+		if !ok {
+			t.Errorf("point %v:%v not found", typ, key)
+			return
+		}
+		if p.Value != value || p.Text != text {
+			t.Errorf("point %v:%v: got %v/%v", typ, key, p.Value, p.Text)
+		}
+	}
+
+	check("temperature", "", 21.5, "")
+	check("battery", "", 3, "")
+	check("door", "", 1, "")
+	check("state", "", 0, "sleeping")
+	check("voltage", "1", 3.3, "")
+	check("voltage", "2", 5, "")
+
+	pts, err = coapPoints([]byte(`{"temperature":22.25}`), coap.AppJSON, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if v, _ := pts.Value("temperature", ""); v != 22.25 {
+		t.Error("wrong JSON value: ", v)
+	}
+
+	for _, in := range [][]byte{[]byte(`[1,2]`), []byte(`{"a":[1]}`)} {
+		if _, err := coapPoints(in, coap.AppJSON, time.Now()); err == nil {
+			t.Errorf("%s: expected error", in)
+		}
+	}
+}
+
+func TestCoapPayload(t *testing.T) {
+	b, err := coapPayload(data.Points{
+		{Type: "setpoint", Value: 20},
+		{Type: "voltage", Key: "1", Value: 3.5},
+		{Type: data.PointTypeDescription, Text: "sensor"},
+		{Type: data.PointTypePSK, Text: "secret"},
+		{Type: "deleted", Value: 1, Tombstone: 1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pts, err := coapPoints(b, coap.AppCBOR, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(pts) != 3 {
+		t.Fatalf("expected 3 points, got %v", pts)
+	}
+
+	if v, _ := pts.Value("voltage", "1"); v != 3.5 {
+		t.Error("wrong keyed value: ", v)
+	}
+
+	if _, ok := pts.Find(data.PointTypePSK, ""); ok {
+		t.Error("psk should not be sent to devices")
+	}
+}
@@ -0,0 +1,221 @@
+package client
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// udp mux limits
+const (
+	udpMuxMaxPeers  = 1000
+	udpMuxQueueSize = 16
+)
+
+// udpMux splits datagrams received on a UDP socket into a connection for
+// each remote address, so DTLS handshakes and sessions can run in their
+// own goroutines
+type udpMux struct {
+	conn   *net.UDPConn
+	accept chan *udpPeer
+	done   chan struct{}
+
+	lock  sync.Mutex
+	peers map[string]*udpPeer
+}
+
+func listenUDPMux(port int) (*udpMux, error) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{Port: port})
+	if err != nil {
+		return nil, err
+	}
+
+	m := &udpMux{
+		conn:   conn,
+		accept: make(chan *udpPeer, udpMuxQueueSize),
+		done:   make(chan struct{}),
+		peers:  make(map[string]*udpPeer),
+	}
+
+	go m.run()
+
+	return m, nil
+}
+
+func (m *udpMux) run() {
+	defer close(m.done)
+
+	buf := make([]byte, 2048)
+
+	for {
+		n, addr, err := m.conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+
+		key := addr.String()
+
+		m.lock.Lock()
+		p, ok := m.peers[key]
+		if !ok {
+			if len(m.peers) >= udpMuxMaxPeers {
+				m.lock.Unlock()
+				continue
+			}
+
+			p = &udpPeer{
+				mux:        m,
+				addr:       addr,
+				in:         make(chan []byte, udpMuxQueueSize),
+				done:       make(chan struct{}),
+				deadlineCh: make(chan struct{}),
+			}
+
+			select {
+			case m.accept <- p:
+				m.peers[key] = p
+			default:
+				// too many peers waiting to be accepted
+				m.lock.Unlock()
+				continue
+			}
+		}
+		m.lock.Unlock()
+
+		d := make([]byte, n)
+		copy(d, buf[:n])
+
+		select {
+		case p.in <- d:
+		default:
+			// drop datagrams if the peer is not reading
+		}
+	}
+}
+
+// Accept returns the next new peer
+func (m *udpMux) Accept() (*udpPeer, error) {
+	select {
+	case p := <-m.accept:
+		return p, nil
+	case <-m.done:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close closes the socket. Peers must be closed separately.
+func (m *udpMux) Close() error {
+	return m.conn.Close()
+}
+
+// udpTimeout is returned by reads when the deadline expires
+type udpTimeout struct{}
+
+func (udpTimeout) Error() string   { return "i/o timeout" }
+func (udpTimeout) Timeout() bool   { return true }
+func (udpTimeout) Temporary() bool { return true }
+
+// udpPeer is a net.Conn for datagrams to and from one remote address
+type udpPeer struct {
+	mux  *udpMux
+	addr *net.UDPAddr
+	in   chan []byte
+	done chan struct{}
+	once sync.Once
+
+	// lock protects the deadline. deadlineCh is closed when the deadline
+	// changes to wake up blocked reads.
+	lock       sync.Mutex
+	deadline   time.Time
+	deadlineCh chan struct{}
+}
+
+func (p *udpPeer) Read(b []byte) (int, error) {
+	for {
+		p.lock.Lock()
+		deadline, changed := p.deadline, p.deadlineCh
+		p.lock.Unlock()
+
+		timeout := make(<-chan time.Time)
+		var timer *time.Timer
+		if !deadline.IsZero() {
+			d := time.Until(deadline)
+			if d <= 0 {
+				return 0, udpTimeout{}
+			}
+			timer = time.NewTimer(d)
+			timeout = timer.C
+		}
+
+		n, err, again := 0, error(nil), false
+		select {
+		case d := <-p.in:
+			n = copy(b, d)
+		case <-p.done:
+			err = net.ErrClosed
+		case <-p.mux.done:
+			err = net.ErrClosed
+		case <-timeout:
+			err = udpTimeout{}
+		case <-changed:
+			again = true
+		}
+
+		if timer != nil {
+			timer.Stop()
+		}
+
+		if !again {
+			return n, err
+		}
+	}
+}
+
+func (p *udpPeer) Write(b []byte) (int, error) {
+	select {
+	case <-p.done:
+		return 0, net.ErrClosed
+	default:
+	}
+
+	return p.mux.conn.WriteToUDP(b, p.addr)
+}
+
+// Close removes the peer from the mux. Later datagrams from the address
+// are accepted as a new peer.
+func (p *udpPeer) Close() error {
+	p.once.Do(func() {
+		close(p.done)
+		p.mux.lock.Lock()
+		if p.mux.peers[p.addr.String()] == p {
+			delete(p.mux.peers, p.addr.String())
+		}
+		p.mux.lock.Unlock()
+	})
+	return nil
+}
+
+func (p *udpPeer) LocalAddr() net.Addr {
+	return p.mux.conn.LocalAddr()
+}
+
+func (p *udpPeer) RemoteAddr() net.Addr {
+	return p.addr
+}
+
+func (p *udpPeer) SetDeadline(t time.Time) error {
+	return p.SetReadDeadline(t)
+}
+
+func (p *udpPeer) SetReadDeadline(t time.Time) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.deadline = t
+	close(p.deadlineCh)
+	p.deadlineCh = make(chan struct{})
+	return nil
+}
+
+func (p *udpPeer) SetWriteDeadline(t time.Time) error {
+	return nil
+}
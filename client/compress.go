@@ -0,0 +1,95 @@
+package client
+
+import (
+	"fmt"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/nats-io/nats.go"
+)
+
+// NATS headers used to negotiate compression of large payloads. A requester
+// that can decode compressed responses sets Accept-Encoding, and a responder
+// that compresses a response sets Content-Encoding.
+const (
+	HeaderAcceptEncoding  = "Accept-Encoding"
+	HeaderContentEncoding = "Content-Encoding"
+	EncodingZstd          = "zstd"
+)
+
+// CompressMinSize is the smallest payload that is compressed. Smaller
+// payloads do not compress well enough to be worth the CPU time.
+var CompressMinSize = 4 * 1024
+
+// MaxDecompressSize limits the size of decompressed payloads
+var MaxDecompressSize = 64 * 1024 * 1024
+
+var zstdEncoder, _ = zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+
+var zstdDecoder, _ = zstd.NewReader(nil, zstd.WithDecoderConcurrency(1),
+	zstd.WithDecoderMaxMemory(uint64(MaxDecompressSize)))
+
+// AcceptsCompression returns true if the sender of msg can decode compressed
+// payloads
+func AcceptsCompression(msg *nats.Msg) bool {
+	return msg.Header != nil && msg.Header.Get(HeaderAcceptEncoding) == EncodingZstd
+}
+
+// acceptCompression marks msg as accepting compressed responses if the
+// connection supports headers
+func acceptCompression(nc *nats.Conn, msg *nats.Msg) {
+	if !nc.HeadersSupported() {
+		return
+	}
+
+	if msg.Header == nil {
+		msg.Header = nats.Header{}
+	}
+
+	msg.Header.Set(HeaderAcceptEncoding, EncodingZstd)
+}
+
+// CompressMsg returns a message for subject. The payload is compressed if
+// accept is true and the payload is at least CompressMinSize bytes.
+func CompressMsg(subject string, d []byte, accept bool) *nats.Msg {
+	msg := nats.NewMsg(subject)
+
+	if !accept || len(d) < CompressMinSize {
+		msg.Data = d
+		return msg
+	}
+
+	msg.Data = zstdEncoder.EncodeAll(d, make([]byte, 0, len(d)/2))
+	msg.Header.Set(HeaderContentEncoding, EncodingZstd)
+
+	return msg
+}
+
+// Respond sends a response to a request, compressing the payload if the
+// requester accepts compression
+func Respond(nc *nats.Conn, req *nats.Msg, d []byte) error {
+	return nc.PublishMsg(CompressMsg(req.Reply, d, AcceptsCompression(req)))
+}
+
+// DecompressMsg decompresses the payload of msg in place if it is
+// compressed. All messages that may have been sent with compression must be
+// passed through this before the payload is decoded.
+func DecompressMsg(msg *nats.Msg) error {
+	if msg.Header == nil {
+		return nil
+	}
+
+	switch enc := msg.Header.Get(HeaderContentEncoding); enc {
+	case "":
+		return nil
+	case EncodingZstd:
+		d, err := zstdDecoder.DecodeAll(msg.Data, nil)
+		if err != nil {
+			return fmt.Errorf("Error decompressing %v: %w", msg.Subject, err)
+		}
+		msg.Data = d
+		msg.Header.Del(HeaderContentEncoding)
+		return nil
+	default:
+		return fmt.Errorf("%v: unsupported encoding: %v", msg.Subject, enc)
+	}
+}
@@ -0,0 +1,144 @@
+package client_test
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/simpleiot/simpleiot/client"
+	"github.com/simpleiot/simpleiot/data"
+	"github.com/simpleiot/simpleiot/server"
+)
+
+func TestCompressMsg(t *testing.T) {
+	small := []byte("small")
+	msg := client.CompressMsg("test", small, true)
+	if msg.Header.Get(client.HeaderContentEncoding) != "" || !bytes.Equal(msg.Data, small) {
+		t.Fatal("small payload should not be compressed")
+	}
+
+	large := bytes.Repeat([]byte("simpleiot "), client.CompressMinSize)
+
+	msg = client.CompressMsg("test", large, false)
+	if msg.Header.Get(client.HeaderContentEncoding) != "" {
+		t.Fatal("payload should not be compressed if not accepted")
+	}
+
+	msg = client.CompressMsg("test", large, true)
+	if msg.Header.Get(client.HeaderContentEncoding) != client.EncodingZstd {
+		t.Fatal("large payload was not compressed")
+	}
+
+	if len(msg.Data) >= len(large) {
+		t.Fatal("compressed payload is not smaller: ", len(msg.Data))
+	}
+
+	err := client.DecompressMsg(msg)
+	if err != nil {
+		t.Fatal("Error decompressing: ", err)
+	}
+
+	if !bytes.Equal(msg.Data, large) {
+		t.Fatal("decompressed payload does not match")
+	}
+
+	msg = nats.NewMsg("test")
+	msg.Data = []byte("garbage")
+	msg.Header.Set(client.HeaderContentEncoding, "gzip")
+	if client.DecompressMsg(msg) == nil {
+		t.Fatal("expected error for unsupported encoding")
+	}
+}
+
+func TestCompressNodeChildren(t *testing.T) {
+	nc, root, stop, err := server.TestServer()
+	if err != nil {
+		t.Fatal("Error starting test server: ", err)
+	}
+	defer stop()
+
+	// a few nodes with large descriptions, so the response is larger than
+	// CompressMinSize
+	count := 3
+	desc := strings.Repeat("variable ", client.CompressMinSize/count/8)
+
+	for i := 0; i < count; i++ {
+		v := client.Variable{ID: fmt.Sprint("var-", i), Parent: root.ID,
+			Description: fmt.Sprint(desc, i)}
+		err := client.SendNodeType(nc, v, "test")
+		if err != nil {
+			t.Fatal("Error sending node: ", err)
+		}
+	}
+
+	// make sure the store compresses the response when asked to
+	req := nats.NewMsg(fmt.Sprintf("node.%v.children", root.ID))
+	req.Header.Set(client.HeaderAcceptEncoding, client.EncodingZstd)
+	resp, err := nc.RequestMsg(req, 5*time.Second)
+	if err != nil {
+		t.Fatal("Error requesting children: ", err)
+	}
+
+	if resp.Header.Get(client.HeaderContentEncoding) != client.EncodingZstd {
+		t.Fatal("children response was not compressed")
+	}
+
+	// and the client helpers decompress it
+	children, err := client.GetNodeChildren(nc, root.ID, data.NodeTypeVariable, false, false)
+	if err != nil {
+		t.Fatal("Error getting children: ", err)
+	}
+
+	if len(children) != count {
+		t.Fatalf("expected %v children, got %v", count, len(children))
+	}
+}
+
+func TestCompressFile(t *testing.T) {
+	nc, _, stop, err := server.TestServer()
+	if err != nil {
+		t.Fatal("Error starting test server: ", err)
+	}
+	defer stop()
+
+	dir := t.TempDir()
+	done := make(chan string, 1)
+
+	err = client.ListenForFile(nc, dir, "dev1", func(path string) {
+		done <- path
+	})
+	if err != nil {
+		t.Fatal("Error listening for file: ", err)
+	}
+
+	// several chunks so that later chunks are compressed
+	file := bytes.Repeat([]byte("simpleiot file transfer "), 10000)
+
+	err = client.SendFile(nc, "dev1", bytes.NewReader(file), "test.txt", func(int) {})
+	if err != nil {
+		t.Fatal("Error sending file: ", err)
+	}
+
+	select {
+	case p := <-done:
+		if p != filepath.Join(dir, "test.txt") {
+			t.Fatal("unexpected file path: ", p)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for file")
+	}
+
+	rx, err := os.ReadFile(filepath.Join(dir, "test.txt"))
+	if err != nil {
+		t.Fatal("Error reading file: ", err)
+	}
+
+	if !bytes.Equal(rx, file) {
+		t.Fatal("received file does not match")
+	}
+}
@@ -0,0 +1,331 @@
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// builtinArchiveInterval is how often day files are archived
+var builtinArchiveInterval = time.Hour
+
+// builtinArchiveCacheLen is the number of archived day files cached in
+// memory for queries
+var builtinArchiveCacheLen = 16
+
+// builtinArchived describes the archive object of a day file. The object
+// is the data of local files that were already removed (the first Base
+// bytes), followed by the Size bytes of the local file that were uploaded.
+type builtinArchived struct {
+	Base int64 `json:"base"`
+	Size int64 `json:"size"`
+}
+
+// builtinArchive uploads sealed day files of a builtinWriter to S3
+// compatible storage and fetches them for queries. Day files are sealed
+// the day after they are for, and are removed from local storage when they
+// are older than the archive after days. Day files that get more data
+// later, for example backfilled history, are uploaded again.
+type builtinArchive struct {
+	s3    *s3Client
+	after int
+	file  string
+
+	// archived is protected by the builtinWriter lock
+	archived map[string]map[string]*builtinArchived
+
+	cacheLock sync.Mutex
+	cache     map[string][]byte
+	cacheKeys []string
+}
+
+func newBuiltinArchive(config Db, dir string) (*builtinArchive, error) {
+	s3, err := newS3Client(config.ArchiveURI, config.ArchiveBucket, config.ArchiveRegion,
+		config.ArchiveAccessKey, config.ArchiveSecretKey)
+	if err != nil {
+		return nil, err
+	}
+
+	after := config.ArchiveAfterDays
+	if after <= 0 {
+		after = 7
+	}
+
+	a := &builtinArchive{
+		s3:       s3,
+		after:    after,
+		file:     filepath.Join(dir, "archive.json"),
+		archived: make(map[string]map[string]*builtinArchived),
+		cache:    make(map[string][]byte),
+	}
+
+	d, err := os.ReadFile(a.file)
+	if err == nil {
+		err = json.Unmarshal(d, &a.archived)
+		if err != nil {
+			return nil, fmt.Errorf("Error reading archive state: %w", err)
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	return a, nil
+}
+
+func archiveKey(nodeID, day string) string {
+	return nodeID + "/" + day + ".gor"
+}
+
+// save writes the archive state. Must be called with the builtinWriter lock
+// held.
+func (a *builtinArchive) save() error {
+	d, err := json.Marshal(a.archived)
+	if err != nil {
+		return err
+	}
+
+	tmp := a.file + ".tmp"
+	err = os.WriteFile(tmp, d, 0644)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, a.file)
+}
+
+// get returns the archive state of a day file. Must be called with the
+// builtinWriter lock held.
+func (a *builtinArchive) get(nodeID, day string) (builtinArchived, bool) {
+	r, ok := a.archived[nodeID][day]
+	if !ok {
+		return builtinArchived{}, false
+	}
+	return *r, true
+}
+
+// set stores the archive state of a day file. Must be called with the
+// builtinWriter lock held.
+func (a *builtinArchive) set(nodeID, day string, r builtinArchived) {
+	if a.archived[nodeID] == nil {
+		a.archived[nodeID] = make(map[string]*builtinArchived)
+	}
+	a.archived[nodeID][day] = &r
+}
+
+// fetch downloads an archive object, or returns it from the cache
+func (a *builtinArchive) fetch(key string) ([]byte, error) {
+	a.cacheLock.Lock()
+	d, ok := a.cache[key]
+	a.cacheLock.Unlock()
+
+	if ok {
+		return d, nil
+	}
+
+	d, err := a.s3.get(key)
+	if err != nil {
+		return nil, err
+	}
+
+	a.cacheLock.Lock()
+	defer a.cacheLock.Unlock()
+
+	if _, ok := a.cache[key]; !ok {
+		a.cacheKeys = append(a.cacheKeys, key)
+	}
+	a.cache[key] = d
+
+	for len(a.cacheKeys) > builtinArchiveCacheLen {
+		delete(a.cache, a.cacheKeys[0])
+		a.cacheKeys = a.cacheKeys[1:]
+	}
+
+	return d, nil
+}
+
+// uncache removes an object from the cache after it changed
+func (a *builtinArchive) uncache(key string) {
+	a.cacheLock.Lock()
+	defer a.cacheLock.Unlock()
+	delete(a.cache, key)
+}
+
+// readArchived calls fn for each block of a day that is only in the archive
+func (w *builtinWriter) readArchived(nodeID, day string, fn func(typ, key string, block []byte)) error {
+	if w.archive == nil {
+		return nil
+	}
+
+	w.lock.Lock()
+	r, ok := w.archive.get(nodeID, day)
+	w.lock.Unlock()
+
+	if !ok || r.Base == 0 {
+		return nil
+	}
+
+	d, err := w.archive.fetch(archiveKey(nodeID, day))
+	if err != nil {
+		return fmt.Errorf("Error fetching archived history %v: %w",
+			archiveKey(nodeID, day), err)
+	}
+
+	if int64(len(d)) < r.Base {
+		return fmt.Errorf("archived history %v is shorter than expected",
+			archiveKey(nodeID, day))
+	}
+
+	return readBlocks(bufio.NewReader(bytes.NewReader(d[:r.Base])), fn)
+}
+
+// archiveDay uploads a day file, and removes it from local storage if it
+// is older than cold
+func (w *builtinWriter) archiveDay(nodeID, day, cold string) error {
+	a := w.archive
+	key := archiveKey(nodeID, day)
+
+	w.lock.Lock()
+	r, _ := a.get(nodeID, day)
+	local, err := os.ReadFile(w.dayFile(nodeID, day))
+	w.lock.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	if r.Size == int64(len(local)) && day >= cold {
+		// already archived
+		return nil
+	}
+
+	if r.Size != int64(len(local)) {
+		body := local
+
+		if r.Base > 0 {
+			old, err := a.s3.get(key)
+			if err != nil {
+				return err
+			}
+
+			if int64(len(old)) < r.Base {
+				return fmt.Errorf("archived history %v is shorter than expected", key)
+			}
+
+			body = append(old[:r.Base:r.Base], local...)
+		}
+
+		err := a.s3.put(key, body)
+		if err != nil {
+			return err
+		}
+
+		a.uncache(key)
+		r.Size = int64(len(local))
+	}
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if day < cold {
+		// remove the local file only if no data was added since it was
+		// read, otherwise it is uploaded again in the next pass
+		info, err := os.Stat(w.dayFile(nodeID, day))
+		if err == nil && info.Size() == r.Size {
+			err := os.Remove(w.dayFile(nodeID, day))
+			if err != nil {
+				return err
+			}
+
+			r.Base += r.Size
+			r.Size = 0
+		}
+	}
+
+	a.set(nodeID, day, r)
+	return a.save()
+}
+
+// archiveAll archives sealed day files. It returns early if the writer is
+// stopped.
+func (w *builtinWriter) archiveAll(now time.Time) {
+	sealed := now.UTC().AddDate(0, 0, -1).Format(builtinDayFormat)
+	cold := now.UTC().AddDate(0, 0, -w.archive.after).Format(builtinDayFormat)
+
+	files, err := filepath.Glob(filepath.Join(w.dir, "*", "*.gor"))
+	if err != nil {
+		log.Println("Db: error listing history files: ", err)
+		return
+	}
+
+	var errs []string
+
+	for _, f := range files {
+		select {
+		case <-w.stop:
+			return
+		default:
+		}
+
+		day := strings.TrimSuffix(filepath.Base(f), ".gor")
+		if day >= sealed {
+			continue
+		}
+
+		err := w.archiveDay(filepath.Base(filepath.Dir(f)), day, cold)
+		if err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+
+	if len(errs) > 0 {
+		log.Printf("Db: error archiving %v history files: %v\n", len(errs), errs[0])
+	}
+}
+
+// deleteArchived removes the archived history of a node. Must be called
+// with the builtinWriter lock held.
+func (w *builtinWriter) deleteArchived(nodeID string, before string) error {
+	if w.archive == nil {
+		return nil
+	}
+
+	var errs []string
+	for day := range w.archive.archived[nodeID] {
+		if before != "" && day >= before {
+			continue
+		}
+
+		key := archiveKey(nodeID, day)
+		err := w.archive.s3.delete(key)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+
+		w.archive.uncache(key)
+		delete(w.archive.archived[nodeID], day)
+	}
+
+	if len(w.archive.archived[nodeID]) == 0 {
+		delete(w.archive.archived, nodeID)
+	}
+
+	err := w.archive.save()
+	if err != nil {
+		errs = append(errs, err.Error())
+	}
+
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+
+	return nil
+}
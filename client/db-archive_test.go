@@ -0,0 +1,190 @@
+package client
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
+	"github.com/simpleiot/simpleiot/data"
+)
+
+// fakeS3 is an in memory S3 service. Objects are stored by URL path
+// (/bucket/key).
+type fakeS3 struct {
+	lock    sync.Mutex
+	objects map[string][]byte
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	sum := sha256.Sum256(body)
+
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=key/") ||
+		r.Header.Get("x-amz-content-sha256") != hex.EncodeToString(sum[:]) {
+		http.Error(w, "invalid signature", http.StatusForbidden)
+		return
+	}
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	switch r.Method {
+	case http.MethodPut:
+		f.objects[r.URL.Path] = body
+	case http.MethodGet:
+		if r.URL.Query().Get("list-type") == "2" {
+			var res struct {
+				XMLName  xml.Name `xml:"ListBucketResult"`
+				Contents []struct {
+					Key string
+				}
+			}
+
+			prefix := r.URL.Path + "/" + r.URL.Query().Get("prefix")
+			for k := range f.objects {
+				if strings.HasPrefix(k, prefix) {
+					res.Contents = append(res.Contents, struct{ Key string }{
+						strings.TrimPrefix(k, r.URL.Path+"/")})
+				}
+			}
+
+			sort.Slice(res.Contents, func(i, j int) bool {
+				return res.Contents[i].Key < res.Contents[j].Key
+			})
+
+			xml.NewEncoder(w).Encode(res)
+			return
+		}
+
+		d, ok := f.objects[r.URL.Path]
+		if !ok {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		w.Write(d)
+	case http.MethodDelete:
+		delete(f.objects, r.URL.Path)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (f *fakeS3) count() int {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return len(f.objects)
+}
+
+func TestBuiltinArchive(t *testing.T) {
+	s3 := &fakeS3{objects: make(map[string][]byte)}
+	server := httptest.NewServer(s3)
+	defer server.Close()
+
+	dir := t.TempDir()
+	config := Db{ID: "db", DbType: data.PointValueBuiltin, Directory: dir,
+		ArchiveURI: server.URL, ArchiveBucket: "history", ArchiveAccessKey: "key",
+		ArchiveSecretKey: "secret"}
+
+	schema, err := newDbSchema(config)
+	if err != nil {
+		t.Fatal("Error creating schema: ", err)
+	}
+
+	start := time.Date(2026, 5, 1, 23, 0, 0, 0, time.UTC)
+
+	// write writes an hour of 1 minute points on each side of midnight and
+	// closes the writer, so the points are in day files
+	write := func(offset time.Duration) {
+		t.Helper()
+
+		w, err := newDbWriter(config)
+		if err != nil {
+			t.Fatal("Error creating writer: ", err)
+		}
+
+		for i := 0; i < 120; i++ {
+			p := data.Point{Time: start.Add(time.Duration(i)*time.Minute + offset),
+				Type: data.PointTypeValue, Value: float64(i)}
+			w.WritePoint(influxdb2.NewPoint("points", schema.pointTags("n1", p),
+				schema.pointFields("n1", p), p.Time))
+		}
+
+		w.Close()
+	}
+
+	// open opens a writer and waits for the archive pass at start to move
+	// the day files to the archive
+	open := func() *builtinWriter {
+		t.Helper()
+
+		w, err := newDbWriter(config)
+		if err != nil {
+			t.Fatal("Error creating writer: ", err)
+		}
+
+		for i := 0; i < 100; i++ {
+			files, _ := filepath.Glob(filepath.Join(dir, "n1", "*.gor"))
+			if len(files) == 0 {
+				return w.(*builtinWriter)
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+
+		w.Close()
+		t.Fatal("day files were not archived")
+		return nil
+	}
+
+	q := HistoryQuery{NodeID: "n1", Type: data.PointTypeValue,
+		Start: start, End: start.Add(2 * time.Hour)}
+
+	write(0)
+	w := open()
+
+	if s3.count() != 2 {
+		t.Fatal("expected 2 archived day files, got: ", s3.count())
+	}
+
+	points, err := w.Query("points", "value", q)
+	if err != nil {
+		t.Fatal("Error querying: ", err)
+	}
+
+	if len(points) != 120 || points[0].Value != 0 || points[119].Value != 119 {
+		t.Fatal("wrong archived points: ", points)
+	}
+
+	w.Close()
+
+	// backfilled history is added to the archived day files
+	write(30 * time.Second)
+	w = open()
+	defer w.Close()
+
+	points, err = w.Query("points", "value", q)
+	if err != nil {
+		t.Fatal("Error querying: ", err)
+	}
+
+	if len(points) != 240 || points[1].Value != 0 || !points[1].Time.Equal(start.Add(30*time.Second)) {
+		t.Fatal("wrong backfilled points: ", points)
+	}
+
+	err = w.Delete([]string{"n1"})
+	if err != nil {
+		t.Fatal("Error deleting: ", err)
+	}
+
+	if s3.count() != 0 {
+		t.Error("archived history was not deleted")
+	}
+}
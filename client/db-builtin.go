@@ -0,0 +1,495 @@
+package client
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/influxdata/influxdb-client-go/v2/api/write"
+	"github.com/simpleiot/simpleiot/data"
+	"github.com/simpleiot/simpleiot/gorilla"
+)
+
+// builtinFlushInterval is how often samples buffered in memory are written
+// to disk. Samples that were not written are lost if the process exits
+// without closing the writer.
+var builtinFlushInterval = 10 * time.Minute
+
+// builtinBlockMax is the max number of samples in a block. Full blocks are
+// written to disk right away.
+var builtinBlockMax = 720
+
+// builtinDayFormat names the day files
+const builtinDayFormat = "2006-01-02"
+
+// builtinSeries identifies the samples of a point
+type builtinSeries struct {
+	nodeID string
+	typ    string
+	key    string
+}
+
+// builtinBlock is the block of samples being collected for a series
+type builtinBlock struct {
+	day string
+	enc *gorilla.Encoder
+}
+
+// builtinWriter stores point history in Gorilla compressed files, for
+// gateways that do not run a time series database. Each node has a
+// directory with a file for each day. The files are a sequence of blocks,
+// each with the point type, key, and compressed samples of a point.
+// Samples are collected in memory and written as blocks when a block is
+// full, at builtinFlushInterval, and when the writer is closed. Only point
+// values are stored.
+type builtinWriter struct {
+	dir       string
+	retention int
+	archive   *builtinArchive
+
+	lock   sync.Mutex
+	blocks map[builtinSeries]*builtinBlock
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// builtinDir returns the directory history is stored in
+func builtinDir(config Db) string {
+	if config.Directory != "" {
+		return config.Directory
+	}
+	return filepath.Join("history", config.ID)
+}
+
+func newBuiltinWriter(config Db) (*builtinWriter, error) {
+	dir := builtinDir(config)
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &builtinWriter{
+		dir:       dir,
+		retention: config.RetentionDays,
+		blocks:    make(map[builtinSeries]*builtinBlock),
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+
+	if config.ArchiveURI != "" {
+		w.archive, err = newBuiltinArchive(config, dir)
+		if err != nil {
+			return nil, fmt.Errorf("Error setting up history archive: %w", err)
+		}
+	}
+
+	go w.run()
+
+	return w, nil
+}
+
+func (w *builtinWriter) run() {
+	defer close(w.done)
+
+	flush := time.NewTicker(builtinFlushInterval)
+	defer flush.Stop()
+
+	archive := time.NewTicker(builtinArchiveInterval)
+	defer archive.Stop()
+
+	w.prune(time.Now())
+	lastPrune := time.Now()
+
+	if w.archive != nil {
+		w.archiveAll(time.Now())
+	}
+
+	for {
+		select {
+		case <-w.stop:
+			return
+		case now := <-archive.C:
+			if w.archive != nil {
+				w.archiveAll(now)
+			}
+		case now := <-flush.C:
+			w.lock.Lock()
+			w.flushAll()
+			w.lock.Unlock()
+
+			if now.Sub(lastPrune) > 24*time.Hour {
+				w.prune(now)
+				lastPrune = now
+			}
+		}
+	}
+}
+
+// builtinValidID returns true if a node ID can be used as a directory name
+func builtinValidID(id string) bool {
+	return id != "" && id != "." && id != ".." && !strings.ContainsAny(id, `/\`)
+}
+
+func (w *builtinWriter) nodeDir(nodeID string) string {
+	return filepath.Join(w.dir, nodeID)
+}
+
+func (w *builtinWriter) dayFile(nodeID, day string) string {
+	return filepath.Join(w.nodeDir(nodeID), day+".gor")
+}
+
+// flush writes the block of a series to its day file. Must be called with
+// the lock held.
+func (w *builtinWriter) flush(s builtinSeries, b *builtinBlock) error {
+	if b.enc.Count() == 0 {
+		return nil
+	}
+
+	err := os.MkdirAll(w.nodeDir(s.nodeID), 0755)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(w.dayFile(s.nodeID, b.day), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+
+	var rec []byte
+	buf := make([]byte, binary.MaxVarintLen64)
+	for _, field := range [][]byte{[]byte(s.typ), []byte(s.key), b.enc.Bytes()} {
+		n := binary.PutUvarint(buf, uint64(len(field)))
+		rec = append(rec, buf[:n]...)
+		rec = append(rec, field...)
+	}
+
+	_, err = f.Write(rec)
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+// flushAll writes all blocks to disk. Must be called with the lock held.
+func (w *builtinWriter) flushAll() {
+	for s, b := range w.blocks {
+		err := w.flush(s, b)
+		if err != nil {
+			log.Printf("Db: error writing history of %v: %v\n", s.nodeID, err)
+		}
+		delete(w.blocks, s)
+	}
+}
+
+func (w *builtinWriter) WritePoint(p *write.Point) {
+	var s builtinSeries
+
+	for _, t := range p.TagList() {
+		switch t.Key {
+		case "nodeID":
+			s.nodeID = t.Value
+		case "type":
+			s.typ = t.Value
+		case "key":
+			s.key = t.Value
+		}
+	}
+
+	var value float64
+	var ok bool
+	for _, f := range p.FieldList() {
+		if value, ok = f.Value.(float64); ok {
+			break
+		}
+	}
+
+	if !builtinValidID(s.nodeID) || !ok {
+		return
+	}
+
+	day := p.Time().UTC().Format(builtinDayFormat)
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	b := w.blocks[s]
+	if b != nil && (b.day != day || b.enc.Count() >= builtinBlockMax) {
+		err := w.flush(s, b)
+		if err != nil {
+			log.Printf("Db: error writing history of %v: %v\n", s.nodeID, err)
+		}
+		b = nil
+	}
+
+	if b == nil {
+		b = &builtinBlock{day: day, enc: gorilla.NewEncoder()}
+		w.blocks[s] = b
+	}
+
+	b.enc.Encode(p.Time(), value)
+}
+
+// readDay calls fn for each block in a day file
+func (w *builtinWriter) readDay(nodeID, day string, fn func(typ, key string, block []byte)) error {
+	f, err := os.Open(w.dayFile(nodeID, day))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+
+	err = readBlocks(bufio.NewReader(f), fn)
+	if err != nil {
+		return fmt.Errorf("%v: %w", w.dayFile(nodeID, day), err)
+	}
+
+	return nil
+}
+
+// readBlocks calls fn for each block in the contents of a day file
+func readBlocks(r *bufio.Reader, fn func(typ, key string, block []byte)) error {
+	readField := func() ([]byte, error) {
+		n, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, err
+		}
+
+		// blocks are small, so a large length is a corrupt file
+		if n > 1<<24 {
+			return nil, errors.New("invalid block length")
+		}
+
+		ret := make([]byte, n)
+		_, err = io.ReadFull(r, ret)
+		return ret, err
+	}
+
+	for {
+		typ, err := readField()
+		if err == io.EOF {
+			return nil
+		}
+
+		var key, block []byte
+		if err == nil {
+			key, err = readField()
+		}
+		if err == nil {
+			block, err = readField()
+		}
+
+		if err != nil {
+			// the rest of the file is skipped, for example a block
+			// that was not completely written
+			return err
+		}
+
+		fn(string(typ), string(key), block)
+	}
+}
+
+func (w *builtinWriter) Query(measurement, field string, q HistoryQuery) (data.Points, error) {
+	if !builtinValidID(q.NodeID) {
+		return nil, fmt.Errorf("invalid node ID: %v", q.NodeID)
+	}
+
+	var ret data.Points
+
+	add := func(key string, samples []gorilla.Sample) {
+		for _, s := range samples {
+			if s.Time.Before(q.Start) || !s.Time.Before(q.End) {
+				continue
+			}
+			ret = append(ret, data.Point{Time: s.Time, Type: q.Type, Key: key,
+				Value: s.Value})
+		}
+	}
+
+	match := func(typ, key string) bool {
+		return typ == q.Type && (q.Key == "" || key == q.Key)
+	}
+
+	// samples that are not written yet
+	w.lock.Lock()
+	for s, b := range w.blocks {
+		if s.nodeID != q.NodeID || !match(s.typ, s.key) {
+			continue
+		}
+
+		samples, err := gorilla.Decode(b.enc.Bytes())
+		if err == nil {
+			add(s.key, samples)
+		}
+	}
+	w.lock.Unlock()
+
+	var errs []string
+
+	readBlock := func(typ, key string, block []byte) {
+		if !match(typ, key) {
+			return
+		}
+
+		samples, err := gorilla.Decode(block)
+		if err != nil {
+			errs = append(errs, err.Error())
+			return
+		}
+
+		add(key, samples)
+	}
+
+	day := q.Start.UTC().Truncate(24 * time.Hour)
+	for ; day.Before(q.End); day = day.Add(24 * time.Hour) {
+		// older data of the day may only be in the archive
+		err := w.readArchived(q.NodeID, day.Format(builtinDayFormat), readBlock)
+		if err != nil {
+			errs = append(errs, err.Error())
+		}
+
+		err = w.readDay(q.NodeID, day.Format(builtinDayFormat), readBlock)
+		if err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+
+	if len(errs) > 0 {
+		log.Printf("Db: errors reading history of %v: %v\n", q.NodeID, strings.Join(errs, "; "))
+	}
+
+	sort.Sort(ret)
+
+	if q.Every > 0 {
+		ret = aggregateHistory(ret, time.Duration(q.Every*float64(time.Second)))
+	}
+
+	if q.Limit > 0 && len(ret) > q.Limit {
+		ret = ret[:q.Limit]
+	}
+
+	return ret, nil
+}
+
+// aggregateHistory returns the mean value of the points of each key in
+// windows of every. The points must be sorted by time and the time of each
+// returned point is the start of the window.
+func aggregateHistory(points data.Points, every time.Duration) data.Points {
+	if every <= 0 {
+		return points
+	}
+
+	type window struct {
+		start time.Time
+		key   string
+	}
+
+	sums := make(map[window]float64)
+	counts := make(map[window]int)
+	var order []window
+
+	for _, p := range points {
+		w := window{p.Time.Truncate(every), p.Key}
+		if counts[w] == 0 {
+			order = append(order, w)
+		}
+		sums[w] += p.Value
+		counts[w]++
+	}
+
+	ret := make(data.Points, 0, len(order))
+	for _, w := range order {
+		ret = append(ret, data.Point{Time: w.start, Type: points[0].Type, Key: w.key,
+			Value: sums[w] / float64(counts[w])})
+	}
+
+	return ret
+}
+
+func (w *builtinWriter) Delete(nodeIDs []string) error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	for _, id := range nodeIDs {
+		for s := range w.blocks {
+			if s.nodeID == id {
+				delete(w.blocks, s)
+			}
+		}
+
+		if !builtinValidID(id) {
+			continue
+		}
+
+		err := os.RemoveAll(w.nodeDir(id))
+		if err != nil {
+			return err
+		}
+
+		err = w.deleteArchived(id, "")
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// prune removes day files and archived history older than the retention
+func (w *builtinWriter) prune(now time.Time) {
+	if w.retention <= 0 {
+		return
+	}
+
+	cutoff := now.UTC().AddDate(0, 0, -w.retention).Format(builtinDayFormat)
+
+	files, err := filepath.Glob(filepath.Join(w.dir, "*", "*.gor"))
+	if err != nil {
+		log.Println("Db: error listing history files: ", err)
+		return
+	}
+
+	for _, f := range files {
+		// day files sort by name
+		if strings.TrimSuffix(filepath.Base(f), ".gor") < cutoff {
+			err := os.Remove(f)
+			if err != nil {
+				log.Println("Db: error pruning history: ", err)
+			}
+		}
+	}
+
+	if w.archive != nil {
+		w.lock.Lock()
+		defer w.lock.Unlock()
+
+		for nodeID := range w.archive.archived {
+			err := w.deleteArchived(nodeID, cutoff)
+			if err != nil {
+				log.Println("Db: error pruning archived history: ", err)
+			}
+		}
+	}
+}
+
+func (w *builtinWriter) Close() {
+	close(w.stop)
+	<-w.done
+
+	w.lock.Lock()
+	w.flushAll()
+	w.lock.Unlock()
+}
@@ -0,0 +1,114 @@
+package client
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
+	"github.com/simpleiot/simpleiot/data"
+)
+
+func TestBuiltinWriter(t *testing.T) {
+	dir := t.TempDir()
+	config := Db{ID: "db", DbType: data.PointValueBuiltin, Directory: dir}
+
+	w, err := newDbWriter(config)
+	if err != nil {
+		t.Fatal("Error creating writer: ", err)
+	}
+
+	schema, err := newDbSchema(config)
+	if err != nil {
+		t.Fatal("Error creating schema: ", err)
+	}
+
+	// an hour of 1 minute points on each side of midnight, for 2 keys
+	start := time.Date(2026, 5, 1, 23, 0, 0, 0, time.UTC)
+	for i := 0; i < 120; i++ {
+		for _, key := range []string{"a", "b"} {
+			p := data.Point{Time: start.Add(time.Duration(i) * time.Minute),
+				Type: data.PointTypeValue, Key: key, Value: float64(i)}
+			w.WritePoint(influxdb2.NewPoint("points", schema.pointTags("n1", p),
+				schema.pointFields("n1", p), p.Time))
+		}
+	}
+
+	q := HistoryQuery{NodeID: "n1", Type: data.PointTypeValue, Key: "a",
+		Start: start.Add(30 * time.Minute), End: start.Add(90 * time.Minute)}
+
+	check := func(desc string) {
+		t.Helper()
+
+		points, err := w.Query("points", "value", q)
+		if err != nil {
+			t.Fatalf("%v: error querying: %v", desc, err)
+		}
+
+		if len(points) != 60 || points[0].Value != 30 || points[59].Value != 89 ||
+			!points[0].Time.Equal(q.Start) || points[0].Key != "a" {
+			t.Fatalf("%v: wrong points: %v", desc, points)
+		}
+	}
+
+	// points buffered in memory
+	check("memory")
+
+	w.Close()
+
+	files, _ := filepath.Glob(filepath.Join(dir, "n1", "*.gor"))
+	if len(files) != 2 {
+		t.Fatal("expected 2 day files, got: ", files)
+	}
+
+	w, err = newDbWriter(config)
+	if err != nil {
+		t.Fatal("Error creating writer: ", err)
+	}
+
+	check("disk")
+
+	q.Key = ""
+	q.Every = 600
+	points, err := w.Query("points", "value", q)
+	if err != nil {
+		t.Fatal("Error querying: ", err)
+	}
+
+	// 6 windows of 10 minutes for 2 keys
+	if len(points) != 12 || points[0].Value != 34.5 || !points[0].Time.Equal(q.Start) {
+		t.Error("wrong aggregated points: ", points)
+	}
+
+	err = w.Delete([]string{"n1"})
+	if err != nil {
+		t.Fatal("Error deleting: ", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "n1")); !os.IsNotExist(err) {
+		t.Error("history was not deleted")
+	}
+
+	// stop the background flush and prune before changing the retention
+	w.Close()
+
+	bw := w.(*builtinWriter)
+	bw.retention = 1
+	p := data.Point{Time: start, Type: data.PointTypeValue, Value: 1}
+	w.WritePoint(influxdb2.NewPoint("points", schema.pointTags("n2", p),
+		schema.pointFields("n2", p), p.Time))
+	bw.lock.Lock()
+	bw.flushAll()
+	bw.lock.Unlock()
+
+	bw.prune(start.Add(12 * time.Hour))
+	if files, _ := filepath.Glob(filepath.Join(dir, "n2", "*.gor")); len(files) != 1 {
+		t.Error("file within retention was pruned")
+	}
+
+	bw.prune(start.Add(48 * time.Hour))
+	if files, _ := filepath.Glob(filepath.Join(dir, "n2", "*.gor")); len(files) != 0 {
+		t.Error("file was not pruned: ", files)
+	}
+}
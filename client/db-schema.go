@@ -0,0 +1,184 @@
+package client
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/simpleiot/simpleiot/data"
+)
+
+// dbNodeInfoTimeout is how long node info used in measurement templates is
+// cached before it is fetched again
+var dbNodeInfoTimeout = 10 * time.Minute
+
+// DbPointInfo is the data available in Db measurement templates
+type DbPointInfo struct {
+	NodeID      string
+	NodeType    string
+	Description string
+	Type        string
+	Key         string
+}
+
+// dbSchema maps SIOT points to an Influx schema
+type dbSchema struct {
+	measurement *template.Template
+	// constant measurement name if the template has no actions
+	measurementConst string
+	needNode         bool
+	tags             map[string]string
+	fieldNames       map[string]string
+}
+
+// parseKeyValues parses a comma separated list of key=value pairs
+func parseKeyValues(s string) (map[string]string, error) {
+	ret := make(map[string]string)
+
+	for _, kv := range strings.Split(s, ",") {
+		kv = strings.TrimSpace(kv)
+		if kv == "" {
+			continue
+		}
+
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			return nil, fmt.Errorf("invalid key=value: %v", kv)
+		}
+
+		ret[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	}
+
+	return ret, nil
+}
+
+// newDbSchema creates a schema from the Db node config
+func newDbSchema(config Db) (*dbSchema, error) {
+	ret := &dbSchema{measurementConst: "points"}
+
+	if config.Measurement != "" {
+		if !strings.Contains(config.Measurement, "{{") {
+			ret.measurementConst = config.Measurement
+		} else {
+			t, err := template.New("measurement").Option("missingkey=error").
+				Parse(config.Measurement)
+			if err != nil {
+				return nil, fmt.Errorf("Error parsing measurement template: %v", err)
+			}
+			ret.measurement = t
+			ret.needNode = strings.Contains(config.Measurement, ".NodeType") ||
+				strings.Contains(config.Measurement, ".Description")
+		}
+	}
+
+	var err error
+	ret.tags, err = parseKeyValues(config.Tags)
+	if err != nil {
+		return nil, fmt.Errorf("Error parsing tags: %v", err)
+	}
+
+	ret.fieldNames, err = parseKeyValues(config.FieldNames)
+	if err != nil {
+		return nil, fmt.Errorf("Error parsing field names: %v", err)
+	}
+
+	return ret, nil
+}
+
+// measurementName returns the measurement name for a point
+func (s *dbSchema) measurementName(info DbPointInfo) (string, error) {
+	if s.measurement == nil {
+		return s.measurementConst, nil
+	}
+
+	var b bytes.Buffer
+	err := s.measurement.Execute(&b, info)
+	if err != nil {
+		return "", err
+	}
+
+	if b.Len() == 0 {
+		return s.measurementConst, nil
+	}
+
+	return b.String(), nil
+}
+
+// pointTags returns the Influx tags for a point. The standard nodeID, type,
+// key, index, and quality tags are always included and take precedence over
+// configured tags.
+func (s *dbSchema) pointTags(nodeID string, p data.Point) map[string]string {
+	ret := make(map[string]string, len(s.tags)+5)
+
+	for k, v := range s.tags {
+		ret[k] = v
+	}
+
+	ret["nodeID"] = nodeID
+	ret["key"] = p.Key
+	ret["type"] = p.Type
+	ret["index"] = strconv.FormatFloat(p.Index, 'f', -1, 64)
+	ret["quality"] = p.Quality
+
+	return ret
+}
+
+// valueField returns the name of the value field for a point type
+func (s *dbSchema) valueField(typ string) string {
+	if n, ok := s.fieldNames[typ]; ok && n != "" {
+		return n
+	}
+	return "value"
+}
+
+// pointFields returns the Influx fields for a point. The value field can be
+// renamed per point type. The origin field is the point origin, or the node
+// ID if the point was generated by the node.
+func (s *dbSchema) pointFields(nodeID string, p data.Point) map[string]interface{} {
+	return map[string]interface{}{
+		s.valueField(p.Type): p.Value,
+		"text":               p.Text,
+		"origin":             p.OriginOrNode(nodeID),
+	}
+}
+
+// dbNodeCache caches node info used in measurement templates
+type dbNodeCache struct {
+	nc    *nats.Conn
+	nodes map[string]dbNodeCacheEntry
+}
+
+type dbNodeCacheEntry struct {
+	nodeType    string
+	description string
+	fetched     time.Time
+}
+
+func newDbNodeCache(nc *nats.Conn) *dbNodeCache {
+	return &dbNodeCache{nc: nc, nodes: make(map[string]dbNodeCacheEntry)}
+}
+
+func (c *dbNodeCache) info(nodeID string, p data.Point) DbPointInfo {
+	e, ok := c.nodes[nodeID]
+	if !ok || time.Since(e.fetched) > dbNodeInfoTimeout {
+		e = dbNodeCacheEntry{fetched: time.Now()}
+		nodes, err := GetNode(c.nc, nodeID, "none")
+		if err == nil && len(nodes) > 0 {
+			e.nodeType = nodes[0].Type
+			e.description = nodes[0].Desc()
+		}
+		c.nodes[nodeID] = e
+	}
+
+	return DbPointInfo{
+		NodeID:      nodeID,
+		NodeType:    e.nodeType,
+		Description: e.description,
+		Type:        p.Type,
+		Key:         p.Key,
+	}
+}
@@ -0,0 +1,70 @@
+package client
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/simpleiot/simpleiot/data"
+)
+
+func TestDbSchema(t *testing.T) {
+	s, err := newDbSchema(Db{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, _ := s.measurementName(DbPointInfo{Type: "temp"})
+	if m != "points" {
+		t.Error("default measurement should be points, got: ", m)
+	}
+
+	s, err = newDbSchema(Db{
+		Measurement: "{{.NodeType}}_{{.Type}}",
+		Tags:        "site=north, customer=acme, nodeID=bad",
+		FieldNames:  "temp=temperature",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !s.needNode {
+		t.Error("template uses node type, so node info is needed")
+	}
+
+	m, err = s.measurementName(DbPointInfo{NodeType: "device", Type: "temp"})
+	if err != nil || m != "device_temp" {
+		t.Errorf("measurement, expected device_temp, got %v, err: %v", m, err)
+	}
+
+	p := data.Point{Type: "temp", Value: 21.5}
+
+	tags := s.pointTags("n1", p)
+	expTags := map[string]string{"site": "north", "customer": "acme", "nodeID": "n1",
+		"type": "temp", "key": "", "index": "0", "quality": ""}
+	if !reflect.DeepEqual(tags, expTags) {
+		t.Errorf("tags, expected %v, got %v", expTags, tags)
+	}
+
+	fields := s.pointFields("n1", p)
+	if v, ok := fields["temperature"]; !ok || v != 21.5 {
+		t.Error("value field was not renamed: ", fields)
+	}
+
+	if fields["origin"] != "n1" {
+		t.Error("origin should default to node ID: ", fields)
+	}
+
+	if _, ok := s.pointFields("n1", data.Point{Type: "humidity"})["value"]; !ok {
+		t.Error("unmapped point type should use value field")
+	}
+
+	_, err = newDbSchema(Db{Tags: "site"})
+	if err == nil {
+		t.Error("expected error for bad tags")
+	}
+
+	_, err = newDbSchema(Db{Measurement: "{{.Type"})
+	if err == nil {
+		t.Error("expected error for bad template")
+	}
+}
@@ -0,0 +1,568 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
+	"github.com/influxdata/influxdb-client-go/v2/api"
+	"github.com/influxdata/influxdb-client-go/v2/api/write"
+	"github.com/simpleiot/simpleiot/data"
+)
+
+// dbWriter writes points to and queries points from a time series database
+type dbWriter interface {
+	WritePoint(p *write.Point)
+	// Query returns the points for a history query. field is the name of
+	// the value field for the point type.
+	Query(measurement, field string, q HistoryQuery) (data.Points, error)
+	// Delete removes all points of the nodes
+	Delete(nodeIDs []string) error
+	// Close flushes any buffered points and closes the writer
+	Close()
+}
+
+// dbQueryTimeout is the max time a history query can take
+var dbQueryTimeout = 10 * time.Second
+
+// newDbWriter creates a writer for the database type configured in the Db
+// node
+func newDbWriter(config Db) (dbWriter, error) {
+	switch config.DbType {
+	case "", data.PointValueInflux2:
+		return newInfluxV2Writer(config), nil
+	case data.PointValueInflux1, data.PointValueVictoriaMetrics:
+		return newInfluxV1Writer(config)
+	case data.PointValueBuiltin:
+		return newBuiltinWriter(config)
+	default:
+		return nil, fmt.Errorf("unsupported db type: %v", config.DbType)
+	}
+}
+
+// influxV2Writer writes to InfluxDB 2.x using the Influx client library
+type influxV2Writer struct {
+	client   influxdb2.Client
+	writeAPI api.WriteAPI
+	org      string
+	bucket   string
+}
+
+func newInfluxV2Writer(config Db) *influxV2Writer {
+	// you can set things like retries, batching, precision, etc in client options.
+	client := influxdb2.NewClientWithOptions(config.URI,
+		config.AuthToken, influxdb2.DefaultOptions())
+	writeAPI := client.WriteAPI(config.Org, config.Bucket)
+
+	influxErrors := writeAPI.Errors()
+
+	go func() {
+		for {
+			select {
+			case err, ok := <-influxErrors:
+				if err != nil {
+					log.Println("Influx write error: ", err)
+				}
+
+				if !ok {
+					log.Println("Influxdb write api closed")
+					return
+				}
+			}
+		}
+	}()
+
+	return &influxV2Writer{client: client, writeAPI: writeAPI,
+		org: config.Org, bucket: config.Bucket}
+}
+
+func (w *influxV2Writer) WritePoint(p *write.Point) {
+	w.writeAPI.WritePoint(p)
+}
+
+// fluxString quotes a string for use in a Flux query
+func fluxString(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "${", `\${`)
+	return `"` + r.Replace(s) + `"`
+}
+
+// historyEveryMs returns the aggregation window of a history query in
+// milliseconds
+func historyEveryMs(every float64) int64 {
+	ret := int64(every * 1000)
+	if ret < 1 {
+		ret = 1
+	}
+	return ret
+}
+
+// fluxQuery returns the Flux query for a history query
+func fluxQuery(bucket, measurement, field string, q HistoryQuery) string {
+	filter := fmt.Sprintf(`r._measurement == %v and r.nodeID == %v and r.type == %v`,
+		fluxString(measurement), fluxString(q.NodeID), fluxString(q.Type))
+
+	if q.Key != "" {
+		filter += fmt.Sprintf(` and r.key == %v`, fluxString(q.Key))
+	}
+
+	var aggregate string
+
+	if q.Every > 0 {
+		filter += fmt.Sprintf(` and r._field == %v`, fluxString(field))
+		aggregate = fmt.Sprintf(`
+  |> aggregateWindow(every: %vms, fn: mean, timeSrc: "_start", createEmpty: false)`,
+			historyEveryMs(q.Every))
+	} else {
+		filter += fmt.Sprintf(` and (r._field == %v or r._field == "text" or r._field == "origin")`,
+			fluxString(field))
+	}
+
+	ret := fmt.Sprintf(`from(bucket: %v)
+  |> range(start: %v, stop: %v)
+  |> filter(fn: (r) => %v)%v
+  |> pivot(rowKey: ["_time"], columnKey: ["_field"], valueColumn: "_value")
+  |> group()
+  |> sort(columns: ["_time"])`,
+		fluxString(bucket), q.Start.UTC().Format(time.RFC3339Nano),
+		q.End.UTC().Format(time.RFC3339Nano), filter, aggregate)
+
+	if q.Limit > 0 {
+		ret += fmt.Sprintf("\n  |> limit(n: %v)", q.Limit)
+	}
+
+	return ret
+}
+
+func (w *influxV2Writer) Query(measurement, field string, q HistoryQuery) (data.Points, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbQueryTimeout)
+	defer cancel()
+
+	result, err := w.client.QueryAPI(w.org).Query(ctx,
+		fluxQuery(w.bucket, measurement, field, q))
+	if err != nil {
+		return nil, err
+	}
+	defer result.Close()
+
+	var ret data.Points
+
+	for result.Next() {
+		r := result.Record()
+		p := data.Point{Time: r.Time(), Type: q.Type}
+
+		if v, ok := r.ValueByKey(field).(float64); ok {
+			p.Value = v
+		}
+		if v, ok := r.ValueByKey("text").(string); ok {
+			p.Text = v
+		}
+		if v, ok := r.ValueByKey("origin").(string); ok {
+			p.Origin = v
+		}
+		if v, ok := r.ValueByKey("key").(string); ok {
+			p.Key = v
+		}
+		if v, ok := r.ValueByKey("index").(string); ok {
+			p.Index, _ = strconv.ParseFloat(v, 64)
+		}
+
+		ret = append(ret, p)
+	}
+
+	return ret, result.Err()
+}
+
+func (w *influxV2Writer) Delete(nodeIDs []string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbQueryTimeout)
+	defer cancel()
+
+	for _, id := range nodeIDs {
+		err := w.client.DeleteAPI().DeleteWithName(ctx, w.org, w.bucket,
+			time.Unix(0, 0), time.Now().Add(time.Hour), "nodeID="+fluxString(id))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (w *influxV2Writer) Close() {
+	w.client.Close()
+}
+
+// influxV1BatchSize and influxV1FlushInterval control how points are batched
+// by influxV1Writer
+var influxV1BatchSize = 1000
+var influxV1FlushInterval = time.Second
+
+// influxV1Writer writes line protocol to the InfluxDB 1.x /write endpoint.
+// VictoriaMetrics supports the same endpoint.
+type influxV1Writer struct {
+	url        string
+	queryURL   string
+	baseURL    string
+	database   string
+	dbType     string
+	username   string
+	password   string
+	httpClient *http.Client
+	points     chan *write.Point
+	stop       chan struct{}
+	wg         sync.WaitGroup
+}
+
+func newInfluxV1Writer(config Db) (*influxV1Writer, error) {
+	u, err := url.Parse(config.URI)
+	if err != nil {
+		return nil, fmt.Errorf("Error parsing URI: %v", err)
+	}
+
+	base := strings.TrimSuffix(u.Path, "/")
+	u.Path = base
+	baseURL := u.String()
+	u.Path = base + "/query"
+	queryURL := u.String()
+	u.Path = base + "/write"
+
+	q := u.Query()
+	if config.Database != "" {
+		q.Set("db", config.Database)
+	}
+	if config.RetentionPolicy != "" {
+		q.Set("rp", config.RetentionPolicy)
+	}
+	q.Set("precision", "ns")
+	u.RawQuery = q.Encode()
+
+	w := &influxV1Writer{
+		url:        u.String(),
+		queryURL:   queryURL,
+		baseURL:    baseURL,
+		database:   config.Database,
+		dbType:     config.DbType,
+		username:   config.Username,
+		password:   config.Password,
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+		points:     make(chan *write.Point, influxV1BatchSize),
+		stop:       make(chan struct{}),
+	}
+
+	w.wg.Add(1)
+	go w.run()
+
+	return w, nil
+}
+
+func (w *influxV1Writer) WritePoint(p *write.Point) {
+	w.points <- p
+}
+
+func (w *influxV1Writer) Close() {
+	close(w.stop)
+	w.wg.Wait()
+}
+
+func (w *influxV1Writer) run() {
+	defer w.wg.Done()
+
+	var batch strings.Builder
+	count := 0
+
+	flush := func() {
+		if count == 0 {
+			return
+		}
+
+		err := w.write(batch.String())
+		if err != nil {
+			log.Printf("Influx write error, %v points dropped: %v\n", count, err)
+		}
+
+		batch.Reset()
+		count = 0
+	}
+
+	t := time.NewTicker(influxV1FlushInterval)
+	defer t.Stop()
+
+	for {
+		select {
+		case p := <-w.points:
+			write.PointToLineProtocolBuffer(p, &batch, time.Nanosecond)
+			count++
+			if count >= influxV1BatchSize {
+				flush()
+			}
+		case <-t.C:
+			flush()
+		case <-w.stop:
+			for {
+				select {
+				case p := <-w.points:
+					write.PointToLineProtocolBuffer(p, &batch, time.Nanosecond)
+					count++
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+func (w *influxV1Writer) write(lines string) error {
+	req, err := http.NewRequest(http.MethodPost, w.url, bytes.NewBufferString(lines))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+
+	if w.username != "" {
+		req.SetBasicAuth(w.username, w.password)
+	}
+
+	resp, err := w.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%v: %v", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	return nil
+}
+
+// influxQLIdent quotes an identifier for use in an InfluxQL query
+func influxQLIdent(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+	return `"` + r.Replace(s) + `"`
+}
+
+// influxQLString quotes a string for use in an InfluxQL query
+func influxQLString(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `'`, `\'`)
+	return `'` + r.Replace(s) + `'`
+}
+
+// influxQLQuery returns the InfluxQL query for a history query
+func influxQLQuery(measurement, field string, q HistoryQuery) string {
+	sel := fmt.Sprintf(`%v, "text", "origin", "key", "index"`, influxQLIdent(field))
+	if q.Every > 0 {
+		sel = fmt.Sprintf(`mean(%v) AS %v`, influxQLIdent(field), influxQLIdent(field))
+	}
+
+	ret := fmt.Sprintf(`SELECT %v FROM %v WHERE "nodeID" = %v AND "type" = %v`,
+		sel, influxQLIdent(measurement), influxQLString(q.NodeID),
+		influxQLString(q.Type))
+
+	if q.Key != "" {
+		ret += fmt.Sprintf(` AND "key" = %v`, influxQLString(q.Key))
+	}
+
+	ret += fmt.Sprintf(` AND time >= %v AND time < %v`, q.Start.UnixNano(), q.End.UnixNano())
+
+	if q.Every > 0 {
+		ret += fmt.Sprintf(` GROUP BY time(%vms), "key" fill(none)`, historyEveryMs(q.Every))
+	}
+
+	ret += " ORDER BY time ASC"
+
+	if q.Limit > 0 {
+		ret += fmt.Sprintf(" LIMIT %v", q.Limit)
+	}
+
+	return ret
+}
+
+// influxQLResponse is the response from the Influx 1.x /query endpoint
+type influxQLResponse struct {
+	Results []struct {
+		Series []struct {
+			Tags    map[string]string `json:"tags"`
+			Columns []string          `json:"columns"`
+			Values  [][]interface{}   `json:"values"`
+		} `json:"series"`
+		Error string `json:"error"`
+	} `json:"results"`
+	Error string `json:"error"`
+}
+
+func (w *influxV1Writer) Query(measurement, field string, q HistoryQuery) (data.Points, error) {
+	if w.dbType == data.PointValueVictoriaMetrics {
+		// VictoriaMetrics accepts Influx writes, but does not support
+		// InfluxQL queries
+		return nil, fmt.Errorf("history queries are not supported for %v",
+			data.PointValueVictoriaMetrics)
+	}
+
+	u, err := url.Parse(w.queryURL)
+	if err != nil {
+		return nil, err
+	}
+
+	v := u.Query()
+	v.Set("db", w.database)
+	v.Set("epoch", "ns")
+	v.Set("q", influxQLQuery(measurement, field, q))
+	u.RawQuery = v.Encode()
+
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if w.username != "" {
+		req.SetBasicAuth(w.username, w.password)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dbQueryTimeout)
+	defer cancel()
+
+	resp, err := w.httpClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var res influxQLResponse
+	dec := json.NewDecoder(resp.Body)
+	dec.UseNumber()
+	err = dec.Decode(&res)
+	if err != nil {
+		return nil, fmt.Errorf("%v: error decoding response: %v", resp.Status, err)
+	}
+
+	if res.Error != "" {
+		return nil, fmt.Errorf("%v: %v", resp.Status, res.Error)
+	}
+
+	var ret data.Points
+
+	for _, r := range res.Results {
+		if r.Error != "" {
+			return nil, fmt.Errorf("%v: %v", resp.Status, r.Error)
+		}
+
+		for _, s := range r.Series {
+			for _, row := range s.Values {
+				// aggregated queries are grouped by key
+				p := data.Point{Type: q.Type, Key: s.Tags["key"]}
+				for i, c := range s.Columns {
+					if i >= len(row) || row[i] == nil {
+						continue
+					}
+
+					switch c {
+					case "time":
+						if n, ok := row[i].(json.Number); ok {
+							ns, _ := n.Int64()
+							p.Time = time.Unix(0, ns)
+						}
+					case field:
+						if n, ok := row[i].(json.Number); ok {
+							p.Value, _ = n.Float64()
+						}
+					case "text":
+						p.Text, _ = row[i].(string)
+					case "origin":
+						p.Origin, _ = row[i].(string)
+					case "key":
+						p.Key, _ = row[i].(string)
+					case "index":
+						if s, ok := row[i].(string); ok {
+							p.Index, _ = strconv.ParseFloat(s, 64)
+						}
+					}
+				}
+				ret = append(ret, p)
+			}
+		}
+	}
+
+	return ret, nil
+}
+
+// post sends a form to the database and returns an error if the request
+// failed
+func (w *influxV1Writer) post(u string, form url.Values) error {
+	req, err := http.NewRequest(http.MethodPost, u, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	if w.username != "" {
+		req.SetBasicAuth(w.username, w.password)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dbQueryTimeout)
+	defer cancel()
+
+	resp, err := w.httpClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%v: %v", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	// Influx 1.x returns errors in the response body
+	var res influxQLResponse
+	if json.Unmarshal(body, &res) == nil {
+		if res.Error != "" {
+			return errors.New(res.Error)
+		}
+		for _, r := range res.Results {
+			if r.Error != "" {
+				return errors.New(r.Error)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Delete drops the series of the nodes. VictoriaMetrics does not support
+// InfluxQL, so its delete API is used.
+func (w *influxV1Writer) Delete(nodeIDs []string) error {
+	for _, id := range nodeIDs {
+		var err error
+
+		if w.dbType == data.PointValueVictoriaMetrics {
+			err = w.post(w.baseURL+"/api/v1/admin/tsdb/delete_series", url.Values{
+				"match[]": {`{nodeID=` + strconv.Quote(id) + `}`}})
+		} else {
+			err = w.post(w.queryURL, url.Values{
+				"db": {w.database},
+				"q":  {`DROP SERIES WHERE "nodeID" = ` + influxQLString(id)},
+			})
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
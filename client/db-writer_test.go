@@ -0,0 +1,171 @@
+package client
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
+	"github.com/simpleiot/simpleiot/data"
+)
+
+func TestInfluxV1Writer(t *testing.T) {
+	type request struct {
+		query    string
+		user     string
+		password string
+		body     string
+	}
+
+	requests := make(chan request, 10)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/write" {
+			t.Error("wrong path: ", r.URL.Path)
+		}
+		user, pass, _ := r.BasicAuth()
+		body, _ := io.ReadAll(r.Body)
+		requests <- request{r.URL.RawQuery, user, pass, string(body)}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer ts.Close()
+
+	w, err := newDbWriter(Db{DbType: data.PointValueInflux1, URI: ts.URL,
+		Username: "siot", Password: "secret", Database: "siot", RetentionPolicy: "autogen"})
+	if err != nil {
+		t.Fatal("Error creating writer: ", err)
+	}
+
+	now := time.Unix(1666000000, 0)
+	w.WritePoint(influxdb2.NewPoint("points", map[string]string{"nodeID": "n1"},
+		map[string]interface{}{"value": 1.5}, now))
+	w.WritePoint(influxdb2.NewPoint("points", map[string]string{"nodeID": "n2"},
+		map[string]interface{}{"value": 2.0}, now))
+
+	// close flushes points
+	w.Close()
+
+	select {
+	case r := <-requests:
+		if r.query != "db=siot&precision=ns&rp=autogen" {
+			t.Error("wrong query: ", r.query)
+		}
+
+		if r.user != "siot" || r.password != "secret" {
+			t.Error("wrong auth: ", r.user, r.password)
+		}
+
+		lines := strings.Split(strings.TrimSpace(r.body), "\n")
+		if len(lines) != 2 || lines[0] != "points,nodeID=n1 value=1.5 1666000000000000000" {
+			t.Error("wrong body: ", r.body)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for write")
+	}
+
+	_, err = newDbWriter(Db{DbType: "sqlserver"})
+	if err == nil {
+		t.Error("expected error for unsupported db type")
+	}
+}
+
+func TestInfluxV1Query(t *testing.T) {
+	var query string
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/query" {
+			t.Error("wrong path: ", r.URL.Path)
+		}
+		query = r.URL.Query().Get("q")
+		w.Write([]byte(`{"results":[{"series":[{"name":"points",
+			"columns":["time","temperature","text","key","index"],
+			"values":[[1666000000000000001,21.5,"","0","2"],[1666000000000000002,null,"hi","0","0"]]}]}]}`))
+	}))
+	defer ts.Close()
+
+	w, err := newDbWriter(Db{DbType: data.PointValueInflux1, URI: ts.URL, Database: "siot"})
+	if err != nil {
+		t.Fatal("Error creating writer: ", err)
+	}
+	defer w.Close()
+
+	start := time.Unix(1666000000, 0)
+	points, err := w.Query("points", "temperature", HistoryQuery{NodeID: "n'1",
+		Type: "temp", Start: start, End: start.Add(time.Second), Limit: 10})
+	if err != nil {
+		t.Fatal("Error querying: ", err)
+	}
+
+	exp := `SELECT "temperature", "text", "origin", "key", "index" FROM "points" WHERE "nodeID" = 'n\'1' AND "type" = 'temp' AND time >= 1666000000000000000 AND time < 1666000001000000000 ORDER BY time ASC LIMIT 10`
+	if query != exp {
+		t.Errorf("wrong query:\n%v\nexpected:\n%v", query, exp)
+	}
+
+	if len(points) != 2 {
+		t.Fatal("expected 2 points, got: ", points)
+	}
+
+	if points[0].Value != 21.5 || points[0].Index != 2 || points[0].Key != "0" ||
+		points[0].Time.UnixNano() != 1666000000000000001 {
+		t.Error("wrong first point: ", points[0])
+	}
+
+	if points[1].Text != "hi" || points[1].Value != 0 {
+		t.Error("wrong second point: ", points[1])
+	}
+
+	vm, err := newDbWriter(Db{DbType: data.PointValueVictoriaMetrics, URI: ts.URL})
+	if err != nil {
+		t.Fatal("Error creating writer: ", err)
+	}
+	defer vm.Close()
+
+	_, err = vm.Query("points", "value", HistoryQuery{NodeID: "n1", Type: "temp"})
+	if err == nil {
+		t.Error("expected error for victoriaMetrics query")
+	}
+}
+
+func TestInfluxV1QueryEvery(t *testing.T) {
+	var query string
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.Query().Get("q")
+		w.Write([]byte(`{"results":[{"series":[{"name":"points","tags":{"key":"a"},
+			"columns":["time","value"],
+			"values":[[1666000000000000000,1.5],[1666000060000000000,2.5]]}]}]}`))
+	}))
+	defer ts.Close()
+
+	w, err := newDbWriter(Db{DbType: data.PointValueInflux1, URI: ts.URL, Database: "siot"})
+	if err != nil {
+		t.Fatal("Error creating writer: ", err)
+	}
+	defer w.Close()
+
+	start := time.Unix(1666000000, 0)
+	points, err := w.Query("points", "value", HistoryQuery{NodeID: "n1",
+		Type: "temp", Start: start, End: start.Add(2 * time.Minute), Every: 60})
+	if err != nil {
+		t.Fatal("Error querying: ", err)
+	}
+
+	exp := `SELECT mean("value") AS "value" FROM "points" WHERE "nodeID" = 'n1' AND "type" = 'temp' AND time >= 1666000000000000000 AND time < 1666000120000000000 GROUP BY time(60000ms), "key" fill(none) ORDER BY time ASC`
+	if query != exp {
+		t.Errorf("wrong query:\n%v\nexpected:\n%v", query, exp)
+	}
+
+	if len(points) != 2 || points[1].Value != 2.5 || points[1].Key != "a" {
+		t.Error("wrong points: ", points)
+	}
+
+	flux := fluxQuery("siot", "points", "value", HistoryQuery{NodeID: "n1",
+		Type: "temp", Start: start, End: start.Add(2 * time.Minute), Every: 0.5})
+	if !strings.Contains(flux, `aggregateWindow(every: 500ms, fn: mean`) ||
+		strings.Contains(flux, `"text"`) {
+		t.Error("wrong flux query: ", flux)
+	}
+}
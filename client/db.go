@@ -1,13 +1,12 @@
 package client
 
 import (
+	"encoding/json"
 	"fmt"
 	"log"
-	"strconv"
 	"strings"
 
 	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
-	"github.com/influxdata/influxdb-client-go/v2/api"
 	"github.com/nats-io/nats.go"
 	"github.com/simpleiot/simpleiot/data"
 )
@@ -21,6 +20,40 @@ type Db struct {
 	Org         string `point:"org"`
 	Bucket      string `point:"bucket"`
 	AuthToken   string `point:"authToken"`
+	// DbType is influx2 (default), influx1, victoriaMetrics, or builtin.
+	// influx1 and victoriaMetrics use the Influx 1.x /write endpoint with
+	// the username, password, database, and retention policy fields below.
+	DbType          string `point:"dbType"`
+	Username        string `point:"username"`
+	Password        string `point:"password"`
+	Database        string `point:"database"`
+	RetentionPolicy string `point:"retentionPolicy"`
+	// Directory is where the builtin database stores history. Default is
+	// history/<id>.
+	Directory string `point:"directory"`
+	// RetentionDays is the number of days of history the builtin database
+	// keeps. 0 keeps all history.
+	RetentionDays int `point:"retentionDays"`
+	// ArchiveURI is the URL of S3 compatible storage, for example
+	// https://s3.us-east-1.amazonaws.com or http://minio:9000. If set, the
+	// builtin database uploads history to the archive bucket and removes
+	// it from local storage after ArchiveAfterDays (default is 7).
+	ArchiveURI       string `point:"archiveURI"`
+	ArchiveBucket    string `point:"archiveBucket"`
+	ArchiveRegion    string `point:"archiveRegion"`
+	ArchiveAccessKey string `point:"archiveAccessKey"`
+	ArchiveSecretKey string `point:"archiveSecretKey"`
+	ArchiveAfterDays int    `point:"archiveAfterDays"`
+	// Measurement is the Influx measurement name. It can be a Go template
+	// using the DbPointInfo fields, for example "{{.NodeType}}". Default
+	// is "points".
+	Measurement string `point:"measurement"`
+	// Tags are extra tags added to every point, for example
+	// "site=north,customer=acme"
+	Tags string `point:"tags"`
+	// FieldNames maps point types to value field names, for example
+	// "temp=temperature". Default is "value".
+	FieldNames string `point:"fieldNames"`
 }
 
 // DbClient is a SIOT database client
@@ -31,10 +64,14 @@ type DbClient struct {
 	newPoints     chan NewPoints
 	newEdgePoints chan NewPoints
 	newDbPoints   chan NewPoints
+	newQueries    chan dbHistoryQuery
+	newErase      chan dbEraseRequest
 	upSub         *nats.Subscription
 	upSubHr       *nats.Subscription
-	client        influxdb2.Client
-	writeAPI      api.WriteAPI
+	upSubHist     *nats.Subscription
+	querySub      *nats.Subscription
+	eraseSub      *nats.Subscription
+	writer        dbWriter
 }
 
 // NewDbClient ...
@@ -46,9 +83,23 @@ func NewDbClient(nc *nats.Conn, config Db) Client {
 		newPoints:     make(chan NewPoints),
 		newEdgePoints: make(chan NewPoints),
 		newDbPoints:   make(chan NewPoints),
+		newQueries:    make(chan dbHistoryQuery),
+		newErase:      make(chan dbEraseRequest),
 	}
 }
 
+// dbHistoryQuery is a history query received over NATS
+type dbHistoryQuery struct {
+	query HistoryQuery
+	reply string
+}
+
+// dbEraseRequest is an erase request received over NATS
+type dbEraseRequest struct {
+	req   EraseRequest
+	reply string
+}
+
 // Start runs the main logic for this client and blocks until stopped
 func (dbc *DbClient) Start() error {
 	log.Println("Starting db client: ", dbc.config.Description)
@@ -74,6 +125,33 @@ func (dbc *DbClient) Start() error {
 		dbc.newDbPoints <- NewPoints{chunks[2], "", points}
 	})
 
+	if err != nil {
+		return fmt.Errorf("Db error subscribing to upsub: %v", err)
+	}
+
+	// historical points backfilled by devices
+	subjectHist := fmt.Sprintf("histup.%v.*.points", dbc.config.Parent)
+
+	dbc.upSubHist, err = dbc.nc.Subscribe(subjectHist, func(msg *nats.Msg) {
+		points, err := data.PbDecodePoints(msg.Data)
+		if err != nil {
+			log.Println("Error decoding points in db upSubHist: ", err)
+			return
+		}
+
+		chunks := strings.Split(msg.Subject, ".")
+		if len(chunks) != 4 {
+			log.Println("db client history sub, malformed subject: ", msg.Subject)
+			return
+		}
+
+		dbc.newDbPoints <- NewPoints{chunks[2], "", points}
+	})
+
+	if err != nil {
+		return fmt.Errorf("Db error subscribing to history: %v", err)
+	}
+
 	subjectHR := fmt.Sprintf("phrup.%v.*", dbc.config.Parent)
 
 	dbc.upSubHr, err = dbc.nc.Subscribe(subjectHR, func(msg *nats.Msg) {
@@ -97,34 +175,66 @@ func (dbc *DbClient) Start() error {
 		return fmt.Errorf("Rule error subscribing to upsub: %v", err)
 	}
 
+	dbc.querySub, err = dbc.nc.Subscribe(SubjectNodeHistoryQuery("*"), func(msg *nats.Msg) {
+		var q HistoryQuery
+		err := json.Unmarshal(msg.Data, &q)
+		if err != nil {
+			log.Println("Error decoding db history query: ", err)
+			return
+		}
+
+		dbc.newQueries <- dbHistoryQuery{q, msg.Reply}
+	})
+
+	if err != nil {
+		return fmt.Errorf("Db error subscribing to history queries: %v", err)
+	}
+
+	dbc.eraseSub, err = dbc.nc.Subscribe(SubjectErase("*"), func(msg *nats.Msg) {
+		var req EraseRequest
+		err := json.Unmarshal(msg.Data, &req)
+		if err != nil {
+			log.Println("Error decoding db erase request: ", err)
+			return
+		}
+
+		dbc.newErase <- dbEraseRequest{req, msg.Reply}
+	})
+
+	if err != nil {
+		return fmt.Errorf("Db error subscribing to erase requests: %v", err)
+	}
+
 	setupAPI := func() {
 		log.Println("Setting up Influx API")
-		// you can set things like retries, batching, precision, etc in client options.
-		dbc.client = influxdb2.NewClientWithOptions(dbc.config.URI,
-			dbc.config.AuthToken, influxdb2.DefaultOptions())
-		dbc.writeAPI = dbc.client.WriteAPI(dbc.config.Org, dbc.config.Bucket)
-
-		influxErrors := dbc.writeAPI.Errors()
-
-		go func() {
-			for {
-				select {
-				case err, ok := <-influxErrors:
-					if err != nil {
-						log.Println("Influx write error: ", err)
-					}
-
-					if !ok {
-						log.Println("Influxdb write api closed")
-						return
-					}
-				}
-			}
-		}()
+		if dbc.writer != nil {
+			dbc.writer.Close()
+			dbc.writer = nil
+		}
+
+		var err error
+		dbc.writer, err = newDbWriter(dbc.config)
+		if err != nil {
+			log.Printf("Db %v: %v\n", dbc.config.Description, err)
+		}
 	}
 
 	setupAPI()
 
+	nodeCache := newDbNodeCache(dbc.nc)
+	var schema *dbSchema
+
+	setupSchema := func() {
+		var err error
+		schema, err = newDbSchema(dbc.config)
+		if err != nil {
+			log.Printf("Db %v: %v, using default schema\n", dbc.config.Description, err)
+			schema, _ = newDbSchema(Db{})
+		}
+	}
+
+	setupSchema()
+
 done:
 	for {
 		select {
@@ -142,10 +252,18 @@ done:
 				case data.PointTypeURI,
 					data.PointTypeOrg,
 					data.PointTypeBucket,
-					data.PointTypeAuthToken:
+					data.PointTypeAuthToken,
+					data.PointTypeDbType,
+					data.PointTypeUsername,
+					data.PointTypePassword,
+					data.PointTypeDatabase,
+					data.PointTypeRetentionPolicy:
 					// we need to restart the influx write API
-					dbc.client.Close()
 					setupAPI()
+				case data.PointTypeMeasurement,
+					data.PointTypeTags,
+					data.PointTypeFieldNames:
+					setupSchema()
 				}
 			}
 
@@ -154,27 +272,97 @@ done:
 			if err != nil {
 				log.Println("error merging new points: ", err)
 			}
+		case q := <-dbc.newQueries:
+			if dbc.writer == nil || q.reply == "" {
+				continue
+			}
+
+			info := DbPointInfo{NodeID: q.query.NodeID, Type: q.query.Type,
+				Key: q.query.Key}
+			if schema.needNode {
+				info = nodeCache.info(q.query.NodeID,
+					data.Point{Type: q.query.Type, Key: q.query.Key})
+			}
+
+			measurement, err := schema.measurementName(info)
+			if err != nil {
+				RespondHistory(dbc.nc, q.reply, nil, err)
+				continue
+			}
+
+			// run the query outside the loop so a slow database does
+			// not block writes
+			writer := dbc.writer
+			field := schema.valueField(q.query.Type)
+			go func() {
+				points, err := writer.Query(measurement, field, q.query)
+				err = RespondHistory(dbc.nc, q.reply, points, err)
+				if err != nil {
+					log.Println("Db error responding to history query: ", err)
+				}
+			}()
+
+		case e := <-dbc.newErase:
+			if e.reply == "" {
+				continue
+			}
+
+			ids := e.req.NodeIDs
+			if len(ids) == 0 {
+				ids = []string{e.req.NodeID}
+			}
+
+			report := EraseReport{Source: "db " + dbc.config.Description, NodeIDs: ids}
+			writer := dbc.writer
+			go func() {
+				if writer == nil {
+					report.Error = "database is not configured"
+				} else if err := writer.Delete(ids); err != nil {
+					report.Error = err.Error()
+				}
+
+				err := RespondErase(dbc.nc, e.reply, []EraseReport{report})
+				if err != nil {
+					log.Println("Db error responding to erase request: ", err)
+				}
+			}()
+
 		case pts := <-dbc.newDbPoints:
+			if dbc.writer == nil {
+				continue
+			}
+
 			for _, point := range pts.Points {
-				p := influxdb2.NewPoint("points",
-					map[string]string{
-						"nodeID": pts.ID,
-						"key":    point.Key,
-						"type":   point.Type,
-						"index":  strconv.FormatFloat(point.Index, 'f', -1, 64),
-					},
-					map[string]interface{}{
-						"value": point.Value,
-						"text":  point.Text,
-					},
+				info := DbPointInfo{NodeID: pts.ID, Type: point.Type, Key: point.Key}
+				if schema.needNode {
+					info = nodeCache.info(pts.ID, point)
+				}
+
+				measurement, err := schema.measurementName(info)
+				if err != nil {
+					log.Printf("Db %v: error in measurement template: %v\n",
+						dbc.config.Description, err)
+					continue
+				}
+
+				p := influxdb2.NewPoint(measurement,
+					schema.pointTags(pts.ID, point),
+					schema.pointFields(pts.ID, point),
 					point.Time)
-				dbc.writeAPI.WritePoint(p)
+				dbc.writer.WritePoint(p)
 			}
 		}
 	}
 
 	// clean up
-	dbc.client.Close()
+	dbc.upSub.Unsubscribe()
+	dbc.upSubHr.Unsubscribe()
+	dbc.upSubHist.Unsubscribe()
+	dbc.querySub.Unsubscribe()
+	dbc.eraseSub.Unsubscribe()
+	if dbc.writer != nil {
+		dbc.writer.Close()
+	}
 	return nil
 }
 
@@ -0,0 +1,350 @@
+package client
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/simpleiot/simpleiot/data"
+)
+
+// Diagnostics config. A diagnostics node publishes Go runtime statistics for
+// the SIOT process and captures CPU and heap profiles on request so that
+// performance problems can be diagnosed on remote gateways. Setting
+// cpuProfile starts a CPU profile that runs until cpuProfile is cleared or
+// profileDuration expires. Setting heapProfile captures a heap profile.
+// Profiles are written to directory, and the newest maxProfiles are kept.
+// If uploadDevice is set, profiles are also sent with the NATS file API
+// (see SendFile) to that device ID.
+type Diagnostics struct {
+	ID              string  `node:"id"`
+	Parent          string  `node:"parent"`
+	Description     string  `point:"description"`
+	SamplePeriod    float64 `point:"samplePeriod"`
+	Directory       string  `point:"directory"`
+	MaxProfiles     int     `point:"maxProfiles"`
+	ProfileDuration float64 `point:"profileDuration"`
+	CPUProfile      bool    `point:"cpuProfile"`
+	HeapProfile     bool    `point:"heapProfile"`
+	UploadDevice    string  `point:"uploadDevice"`
+	LastProfile     string  `point:"lastProfile"`
+	Disable         bool    `point:"disable"`
+}
+
+// diagnosticsDir returns the directory profiles are stored in
+func diagnosticsDir(config Diagnostics) string {
+	if config.Directory != "" {
+		return config.Directory
+	}
+	return filepath.Join("profiles", config.ID)
+}
+
+// profileFile returns the file name for a profile of the given kind
+func profileFile(dir, kind string, t time.Time) string {
+	return filepath.Join(dir, t.UTC().Format(snapshotTimeFormat)+"-"+kind+".pprof")
+}
+
+// runtimePoints returns Go runtime statistics as points
+func runtimePoints(now time.Time) data.Points {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+
+	return data.Points{
+		{Time: now, Type: data.PointTypeGoroutines, Value: float64(runtime.NumGoroutine())},
+		{Time: now, Type: data.PointTypeHeapAlloc, Value: float64(m.HeapAlloc)},
+		{Time: now, Type: data.PointTypeHeapSys, Value: float64(m.HeapSys)},
+		{Time: now, Type: data.PointTypeNumGC, Value: float64(m.NumGC)},
+	}
+}
+
+// DiagnosticsClient for diagnostics nodes
+type DiagnosticsClient struct {
+	nc            *nats.Conn
+	config        Diagnostics
+	stop          chan struct{}
+	newPoints     chan NewPoints
+	newEdgePoints chan NewPoints
+	// cpuFile is set while a CPU profile is running
+	cpuFile *os.File
+}
+
+// NewDiagnosticsClient ...
+func NewDiagnosticsClient(nc *nats.Conn, config Diagnostics) Client {
+	return &DiagnosticsClient{
+		nc:            nc,
+		config:        config,
+		stop:          make(chan struct{}),
+		newPoints:     make(chan NewPoints),
+		newEdgePoints: make(chan NewPoints),
+	}
+}
+
+func (dc *DiagnosticsClient) createProfile(kind string) (*os.File, error) {
+	dir := diagnosticsDir(dc.config)
+
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, fmt.Errorf("Error creating profile directory: %v", err)
+	}
+
+	return os.Create(profileFile(dir, kind, time.Now()))
+}
+
+// profileDone removes old profiles, publishes the profile path, and
+// uploads the profile if configured
+func (dc *DiagnosticsClient) profileDone(file string) {
+	max := dc.config.MaxProfiles
+	if max <= 0 {
+		max = 10
+	}
+
+	_, err := pruneFiles(filepath.Join(diagnosticsDir(dc.config), "*.pprof"), max)
+	if err != nil {
+		log.Printf("Diagnostics %v: error removing old profiles: %v\n",
+			dc.config.Description, err)
+	}
+
+	err = SendNodePoint(dc.nc, dc.config.ID, data.Point{Type: data.PointTypeLastProfile,
+		Text: file}, false)
+	if err != nil {
+		log.Println("Diagnostics error sending last profile: ", err)
+	}
+
+	if dc.config.UploadDevice == "" {
+		return
+	}
+
+	go func(device string) {
+		f, err := os.Open(file)
+		if err != nil {
+			log.Println("Diagnostics error opening profile for upload: ", err)
+			return
+		}
+		defer f.Close()
+
+		err = SendFile(dc.nc, device, f, filepath.Base(file), func(int) {})
+		if err != nil {
+			log.Printf("Diagnostics error uploading %v to %v: %v\n", file, device, err)
+		}
+	}(dc.config.UploadDevice)
+}
+
+func (dc *DiagnosticsClient) startCPUProfile() error {
+	if dc.cpuFile != nil {
+		return nil
+	}
+
+	f, err := dc.createProfile("cpu")
+	if err != nil {
+		return err
+	}
+
+	err = pprof.StartCPUProfile(f)
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return fmt.Errorf("Error starting CPU profile: %v", err)
+	}
+
+	log.Printf("Diagnostics %v: CPU profile started\n", dc.config.Description)
+
+	dc.cpuFile = f
+	return nil
+}
+
+func (dc *DiagnosticsClient) stopCPUProfile() {
+	if dc.cpuFile == nil {
+		return
+	}
+
+	pprof.StopCPUProfile()
+
+	err := dc.cpuFile.Close()
+	if err != nil {
+		log.Println("Diagnostics error closing CPU profile: ", err)
+	}
+
+	log.Printf("Diagnostics %v: CPU profile stopped\n", dc.config.Description)
+
+	dc.profileDone(dc.cpuFile.Name())
+	dc.cpuFile = nil
+}
+
+func (dc *DiagnosticsClient) heapProfile() error {
+	f, err := dc.createProfile("heap")
+	if err != nil {
+		return err
+	}
+
+	// get up to date statistics
+	runtime.GC()
+
+	err = pprof.WriteHeapProfile(f)
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("Error writing heap profile: %v", err)
+	}
+
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+
+	dc.profileDone(f.Name())
+	return nil
+}
+
+// clear sets a profile request point back to 0
+func (dc *DiagnosticsClient) clear(typ string) {
+	err := SendNodePoint(dc.nc, dc.config.ID, data.Point{Type: typ, Value: 0}, false)
+	if err != nil {
+		log.Printf("Diagnostics error clearing %v: %v\n", typ, err)
+	}
+}
+
+// Start runs the main logic for this client and blocks until stopped
+func (dc *DiagnosticsClient) Start() error {
+	log.Println("Starting diagnostics client: ", dc.config.Description)
+
+	t := time.NewTicker(time.Hour)
+	t.Stop()
+
+	cpuTimer := time.NewTimer(time.Hour)
+	cpuTimer.Stop()
+
+	sample := func() {
+		if dc.config.Disable {
+			return
+		}
+
+		err := SendNodePoints(dc.nc, dc.config.ID, runtimePoints(time.Now()), false)
+		if err != nil {
+			log.Println("Diagnostics error sending points: ", err)
+		}
+	}
+
+	setup := func() {
+		t.Stop()
+
+		if dc.config.Disable {
+			return
+		}
+
+		period := dc.config.SamplePeriod
+		if period <= 0 {
+			period = 60
+		}
+
+		t.Reset(time.Duration(period * float64(time.Second)))
+	}
+
+	cpuProfile := func(start bool) {
+		if !start || dc.config.Disable {
+			cpuTimer.Stop()
+			dc.stopCPUProfile()
+			return
+		}
+
+		err := dc.startCPUProfile()
+		if err != nil {
+			log.Printf("Diagnostics %v: %v\n", dc.config.Description, err)
+			dc.clear(data.PointTypeCPUProfile)
+			return
+		}
+
+		duration := dc.config.ProfileDuration
+		if duration <= 0 {
+			duration = 30
+		}
+
+		cpuTimer.Reset(time.Duration(duration * float64(time.Second)))
+	}
+
+	setup()
+	sample()
+
+	// cpuProfile is left set if the client was stopped while profiling
+	if dc.config.CPUProfile {
+		dc.clear(data.PointTypeCPUProfile)
+	}
+
+done:
+	for {
+		select {
+		case <-dc.stop:
+			log.Println("Stopping diagnostics client: ", dc.config.Description)
+			break done
+		case <-t.C:
+			sample()
+		case <-cpuTimer.C:
+			dc.stopCPUProfile()
+			dc.clear(data.PointTypeCPUProfile)
+		case pts := <-dc.newPoints:
+			err := data.MergePoints(pts.ID, pts.Points, &dc.config)
+			if err != nil {
+				log.Println("error merging new points: ", err)
+			}
+
+			for _, p := range pts.Points {
+				switch p.Type {
+				case data.PointTypeSamplePeriod:
+					setup()
+				case data.PointTypeDisable:
+					setup()
+					if dc.config.Disable {
+						cpuProfile(false)
+					}
+				case data.PointTypeCPUProfile:
+					cpuProfile(p.Value != 0)
+				case data.PointTypeHeapProfile:
+					if p.Value == 0 {
+						continue
+					}
+
+					if !dc.config.Disable {
+						err := dc.heapProfile()
+						if err != nil {
+							log.Printf("Diagnostics %v: %v\n", dc.config.Description, err)
+						}
+					}
+
+					dc.clear(data.PointTypeHeapProfile)
+				}
+			}
+
+		case pts := <-dc.newEdgePoints:
+			err := data.MergeEdgePoints(pts.ID, pts.Parent, pts.Points, &dc.config)
+			if err != nil {
+				log.Println("error merging new points: ", err)
+			}
+		}
+	}
+
+	// clean up
+	t.Stop()
+	cpuTimer.Stop()
+	dc.stopCPUProfile()
+	return nil
+}
+
+// Stop sends a signal to the Start function to exit
+func (dc *DiagnosticsClient) Stop(err error) {
+	close(dc.stop)
+}
+
+// Points is called by the Manager when new points for this
+// node are received.
+func (dc *DiagnosticsClient) Points(nodeID string, points []data.Point) {
+	dc.newPoints <- NewPoints{nodeID, "", points}
+}
+
+// EdgePoints is called by the Manager when new edge points for this
+// node are received.
+func (dc *DiagnosticsClient) EdgePoints(nodeID, parentID string, points []data.Point) {
+	dc.newEdgePoints <- NewPoints{nodeID, parentID, points}
+}
@@ -0,0 +1,78 @@
+package client_test
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/simpleiot/simpleiot/client"
+	"github.com/simpleiot/simpleiot/data"
+	"github.com/simpleiot/simpleiot/server"
+)
+
+func TestDiagnostics(t *testing.T) {
+	nc, root, stop, err := server.TestServer()
+	if err != nil {
+		t.Fatal("Error starting test server: ", err)
+	}
+	defer stop()
+
+	diag := client.Diagnostics{
+		ID:          "ID-diag",
+		Parent:      root.ID,
+		Description: "diag",
+		Directory:   t.TempDir(),
+	}
+
+	err = client.SendNodeType(nc, diag, "test")
+	if err != nil {
+		t.Fatal("Error sending node: ", err)
+	}
+
+	get := func() data.NodeEdge {
+		nodes, err := client.GetNode(nc, diag.ID, root.ID)
+		if err != nil || len(nodes) < 1 {
+			t.Fatal("Error getting node: ", err)
+		}
+		return nodes[0]
+	}
+
+	start := time.Now()
+	for {
+		n := get()
+		if v, _ := n.Points.Value(data.PointTypeGoroutines, ""); v > 0 {
+			break
+		}
+		if time.Since(start) > 2*time.Second {
+			t.Fatal("Timeout waiting for runtime points")
+		}
+		<-time.After(50 * time.Millisecond)
+	}
+
+	err = client.SendNodePoint(nc, diag.ID, data.Point{Type: data.PointTypeHeapProfile,
+		Value: 1, Origin: "test"}, true)
+	if err != nil {
+		t.Fatal("Error sending point: ", err)
+	}
+
+	start = time.Now()
+	for {
+		n := get()
+		file, _ := n.Points.Text(data.PointTypeLastProfile, "")
+		requested, _ := n.Points.Value(data.PointTypeHeapProfile, "")
+		if file != "" && requested == 0 {
+			if !strings.HasSuffix(file, "-heap.pprof") {
+				t.Fatal("wrong profile file: ", file)
+			}
+			if _, err := os.Stat(file); err != nil {
+				t.Fatal("profile not written: ", err)
+			}
+			break
+		}
+		if time.Since(start) > 2*time.Second {
+			t.Fatal("Timeout waiting for heap profile")
+		}
+		<-time.After(50 * time.Millisecond)
+	}
+}
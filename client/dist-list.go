@@ -0,0 +1,44 @@
+package client
+
+import (
+	"fmt"
+
+	"github.com/simpleiot/simpleiot/data"
+)
+
+// distListEntries is the number of email addresses and phone numbers in
+// the distribution list edit form
+const distListEntries = 5
+
+// DistListNodeUI returns the edit form descriptor for distribution list
+// nodes. Notifications to distribution lists are sent by the store, so there
+// is no client config type to generate the form from.
+func DistListNodeUI() NodeUI {
+	ui := NodeUI{
+		Type:    data.NodeTypeDistList,
+		Label:   "Distribution list",
+		Parents: []string{data.NodeTypeDevice, data.NodeTypeGroup},
+		Fields: []NodeUIField{
+			{Point: data.PointTypeDescription, Label: "Description", Input: NodeUIInputText},
+			{Point: data.PointTypeNotifySeverity, Label: "Notify for",
+				Input: NodeUIInputOption, Options: []NodeUIOption{
+					{Value: data.PointValueInfo, Label: "All"},
+					{Value: data.PointValueWarning, Label: "Warning and critical"},
+					{Value: data.PointValueCritical, Label: "Critical only"},
+				}},
+		},
+	}
+
+	for _, typ := range []string{data.PointTypeEmail, data.PointTypePhone} {
+		for i := 0; i < distListEntries; i++ {
+			ui.Fields = append(ui.Fields, NodeUIField{
+				Point: typ,
+				Key:   fmt.Sprint(i),
+				Label: fmt.Sprintf("%v %v", nodeUILabel(typ), i+1),
+				Input: NodeUIInputText,
+			})
+		}
+	}
+
+	return ui
+}
@@ -1,6 +1,7 @@
 package client
 
 import (
+	"crypto/tls"
 	"fmt"
 	"log"
 	"net"
@@ -14,6 +15,7 @@ type EdgeOptions struct {
 	URI          string
 	AuthToken    string
 	NoEcho       bool
+	TLS          TLSOptions
 	Disconnected func()
 	Reconnected  func()
 	Closed       func()
@@ -28,6 +30,15 @@ func EdgeConnect(eo EdgeOptions) (*nats.Conn, error) {
 		authEnabled = "yes"
 	}
 
+	var tlsConfig *tls.Config
+	if eo.TLS.Enabled() {
+		var err error
+		tlsConfig, err = eo.TLS.Config()
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	natsErrHandler := func(nc *nats.Conn, sub *nats.Subscription, natsErr error) {
 		fmt.Printf("error: %v\n", natsErr)
 		switch natsErr {
@@ -69,6 +80,10 @@ func EdgeConnect(eo EdgeOptions) (*nats.Conn, error) {
 			o.NoEcho = true
 		}
 
+		if tlsConfig != nil {
+			nats.Secure(tlsConfig)(o)
+		}
+
 		nats.ErrorHandler(natsErrHandler)(o)
 
 		return nil
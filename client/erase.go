@@ -0,0 +1,171 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// eraseTimeout is how long EraseNode waits for the store, and for the first
+// response from other sources
+var eraseTimeout = 20 * time.Second
+
+// eraseGatherTime is how long EraseNode waits for more responses after a
+// response is received if the request does not set Wait
+var eraseGatherTime = 2 * time.Second
+
+// Erase request directions. Upstream connections set the direction when
+// they forward an erase request so it is not forwarded back.
+const (
+	// EraseUp requests are only forwarded to upstream instances
+	EraseUp = "up"
+	// EraseDown requests are only forwarded to downstream instances
+	EraseDown = "down"
+)
+
+// EraseRequest is used to erase all data of a node
+type EraseRequest struct {
+	NodeID string `json:"nodeID"`
+	// NodeIDs are the node and its descendants that were erased from the
+	// store. This is set by EraseNode for history sources.
+	NodeIDs []string `json:"nodeIDs,omitempty"`
+	// Direction is set by upstream connections
+	Direction string `json:"direction,omitempty"`
+	// Wait is how long in seconds to wait for more responses after a
+	// response is received. Default is 2s.
+	Wait float64 `json:"wait,omitempty"`
+}
+
+// EraseReport is the result of erasing a node from one source. Anonymized
+// is the number of points and changes of other nodes that had an erased node
+// as the origin.
+type EraseReport struct {
+	Source     string   `json:"source"`
+	NodeIDs    []string `json:"nodeIDs,omitempty"`
+	Nodes      int64    `json:"nodes"`
+	Edges      int64    `json:"edges"`
+	Points     int64    `json:"points"`
+	Changes    int64    `json:"changes"`
+	Quarantine int64    `json:"quarantine"`
+	Anonymized int64    `json:"anonymized"`
+	Error      string   `json:"error,omitempty"`
+}
+
+// EraseResult is the response to an erase request
+type EraseResult struct {
+	Reports []EraseReport `json:"reports"`
+	Error   string        `json:"error,omitempty"`
+}
+
+// RespondErase sends the reports of an erase request
+func RespondErase(nc *nats.Conn, reply string, reports []EraseReport) error {
+	d, err := json.Marshal(EraseResult{Reports: reports})
+	if err != nil {
+		return err
+	}
+
+	return nc.Publish(reply, d)
+}
+
+// EraseNode permanently removes all data of a node and its descendants, for
+// example to honor a request to erase the data of a user or a device. The
+// node is deleted and removed from the store with its points, edges, change
+// history, and quarantined points. The request is then sent to the other
+// sources that store data: history databases, and upstream or downstream
+// instances that sync the node, which erase it the same way. A report is
+// returned for each source. If any source failed, the reports are returned
+// with an error, and the request can be sent again.
+func EraseNode(nc *nats.Conn, req EraseRequest) ([]EraseReport, error) {
+	if req.NodeID == "" {
+		return nil, errors.New("erase request must include node ID")
+	}
+
+	reqData, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	msg, err := request(context.Background(), nc, SubjectNodeErase(req.NodeID),
+		reqData, eraseTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("Error erasing node from store: %w", err)
+	}
+
+	var storeRes EraseResult
+	err = json.Unmarshal(msg.Data, &storeRes)
+	if err != nil {
+		return nil, err
+	}
+
+	if storeRes.Error != "" {
+		return storeRes.Reports, errors.New(storeRes.Error)
+	}
+
+	reports := storeRes.Reports
+
+	req.NodeIDs = []string{req.NodeID}
+	if len(reports) > 0 && len(reports[0].NodeIDs) > 0 {
+		req.NodeIDs = reports[0].NodeIDs
+	}
+
+	reqData, err = json.Marshal(req)
+	if err != nil {
+		return reports, err
+	}
+
+	inbox := nats.NewInbox()
+	sub, err := nc.SubscribeSync(inbox)
+	if err != nil {
+		return reports, err
+	}
+	defer sub.Unsubscribe()
+
+	err = nc.PublishRequest(SubjectErase(req.NodeID), inbox, reqData)
+	if err != nil {
+		return reports, err
+	}
+
+	gather := eraseGatherTime
+	if req.Wait > 0 {
+		gather = time.Duration(req.Wait * float64(time.Second))
+	}
+
+	timeout := eraseTimeout
+
+	for {
+		msg, err := sub.NextMsg(timeout)
+		if err != nil || len(msg.Data) == 0 {
+			// timeout, or no responders
+			break
+		}
+
+		timeout = gather
+
+		var res EraseResult
+		err = json.Unmarshal(msg.Data, &res)
+		if err != nil {
+			reports = append(reports, EraseReport{Error: err.Error()})
+			continue
+		}
+
+		reports = append(reports, res.Reports...)
+	}
+
+	var errs []string
+	for _, r := range reports {
+		if r.Error != "" {
+			errs = append(errs, r.Source+": "+r.Error)
+		}
+	}
+
+	if len(errs) > 0 {
+		return reports, fmt.Errorf("erase incomplete: %v", strings.Join(errs, "; "))
+	}
+
+	return reports, nil
+}
@@ -0,0 +1,31 @@
+package client
+
+import (
+	"context"
+	"time"
+)
+
+// SetRunHostCommand replaces the function that runs host commands so
+// external tests do not run them on the test machine. It returns a function
+// that restores the original.
+func SetRunHostCommand(f func(ctx context.Context, args []string) error) func() {
+	orig := runHostCommand
+	runHostCommand = f
+	return func() {
+		runHostCommand = orig
+	}
+}
+
+// SetHostConfirmTimeout sets how long a user has to confirm a host command.
+// It returns a function that restores the original.
+func SetHostConfirmTimeout(d time.Duration) func() {
+	orig := hostConfirmTimeout
+	hostConfirmTimeout = d
+	return func() {
+		hostConfirmTimeout = orig
+	}
+}
+
+// WasmTestModule is a WASM module that writes the point double with twice
+// the value of each point written to the parent
+var WasmTestModule = wasmTestModule
@@ -0,0 +1,413 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/simpleiot/simpleiot/data"
+)
+
+// External clients run in a separate OS process and connect to the SIOT NATS
+// server. They register the node type they handle on the client.register
+// subject, and renew the registration every ExternalRegisterPeriod. The
+// registration request is a list of points:
+//
+//   - nodeType: (Text) node type handled by the client (required)
+//   - description: (Text) name of the client
+//   - versionApp: (Text) client version
+//
+// The response is empty on success, or an error message. Registrations can be
+// listed with a request on client.list.
+const (
+	// ExternalRegisterPeriod is how often external clients renew their
+	// registration
+	ExternalRegisterPeriod = 30 * time.Second
+	// registrations expire if they are not renewed within this time
+	externalRegisterTimeout = 3 * ExternalRegisterPeriod
+)
+
+// ExternalClientPublish are the subjects external clients are allowed to
+// publish to when they connect with client credentials
+var ExternalClientPublish = []string{
+	"node.>",
+	"phr.>",
+	"history.*.points",
+	SubjectClientRegister(),
+	SubjectFilterSubscribe(),
+}
+
+// ExternalClientSubscribe are the subjects external clients are allowed to
+// subscribe to when they connect with client credentials
+var ExternalClientSubscribe = []string{
+	"up.>",
+	"node.*.points",
+	"node.*.*.points",
+	"node.*.create",
+	"_INBOX.>",
+}
+
+// ExternalRegistration describes a client running in an external process
+type ExternalRegistration struct {
+	NodeType    string
+	Description string
+	Version     string
+	// LastSeen is the time the registration was last renewed
+	LastSeen time.Time
+}
+
+func (er ExternalRegistration) points() data.Points {
+	now := time.Now()
+	return data.Points{
+		{Time: now, Type: data.PointTypeNodeType, Text: er.NodeType},
+		{Time: now, Type: data.PointTypeDescription, Text: er.Description},
+		{Time: now, Type: data.PointTypeVersionApp, Text: er.Version},
+	}
+}
+
+func externalRegistrationFromPoints(pts data.Points) ExternalRegistration {
+	var ret ExternalRegistration
+	for _, p := range pts {
+		switch p.Type {
+		case data.PointTypeNodeType:
+			ret.NodeType = p.Text
+			ret.LastSeen = p.Time
+		case data.PointTypeDescription:
+			ret.Description = p.Text
+		case data.PointTypeVersionApp:
+			ret.Version = p.Text
+		}
+	}
+	return ret
+}
+
+// RegisterExternal registers a client running in an external process
+func RegisterExternal(nc *nats.Conn, reg ExternalRegistration) error {
+	if reg.NodeType == "" {
+		return errors.New("node type must be set")
+	}
+
+	pts := reg.points()
+	d, err := pts.ToPb()
+	if err != nil {
+		return err
+	}
+
+	return requestAck(context.Background(), nc, SubjectClientRegister(), d,
+		time.Second*20)
+}
+
+// ListExternal returns the external clients that are registered
+func ListExternal(nc *nats.Conn) ([]ExternalRegistration, error) {
+	msg, err := request(context.Background(), nc, SubjectClientList(), nil, time.Second*20)
+	if err != nil {
+		return nil, err
+	}
+
+	pts, err := data.PbDecodePoints(msg.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	// each registration is encoded as points with the node type as key
+	byType := make(map[string]data.Points)
+	var types []string
+	for _, p := range pts {
+		if _, ok := byType[p.Key]; !ok {
+			types = append(types, p.Key)
+		}
+		byType[p.Key] = append(byType[p.Key], p)
+	}
+
+	ret := make([]ExternalRegistration, 0, len(types))
+	for _, t := range types {
+		ret = append(ret, externalRegistrationFromPoints(byType[t]))
+	}
+
+	return ret, nil
+}
+
+// ExternalRegistry tracks the external clients that are registered with
+// this SIOT instance
+type ExternalRegistry struct {
+	nc       *nats.Conn
+	lock     sync.Mutex
+	regs     map[string]ExternalRegistration
+	subs     []*nats.Subscription
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// NewExternalRegistry creates a new external client registry
+func NewExternalRegistry(nc *nats.Conn) *ExternalRegistry {
+	return &ExternalRegistry{
+		nc:   nc,
+		regs: make(map[string]ExternalRegistration),
+		stop: make(chan struct{}),
+	}
+}
+
+func (er *ExternalRegistry) register(reg ExternalRegistration) {
+	er.lock.Lock()
+	defer er.lock.Unlock()
+
+	if _, ok := er.regs[reg.NodeType]; !ok {
+		log.Printf("External client registered: %v (%v %v)\n",
+			reg.NodeType, reg.Description, reg.Version)
+	}
+
+	er.regs[reg.NodeType] = reg
+}
+
+// prune removes registrations that have not been renewed
+func (er *ExternalRegistry) prune(now time.Time) {
+	er.lock.Lock()
+	defer er.lock.Unlock()
+
+	for k, r := range er.regs {
+		if now.Sub(r.LastSeen) > externalRegisterTimeout {
+			log.Println("External client registration expired: ", k)
+			delete(er.regs, k)
+		}
+	}
+}
+
+func (er *ExternalRegistry) list() []ExternalRegistration {
+	er.lock.Lock()
+	defer er.lock.Unlock()
+
+	ret := make([]ExternalRegistration, 0, len(er.regs))
+	for _, r := range er.regs {
+		ret = append(ret, r)
+	}
+
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].NodeType < ret[j].NodeType
+	})
+
+	return ret
+}
+
+func (er *ExternalRegistry) handleRegister(msg *nats.Msg) {
+	pts, err := data.PbDecodePoints(msg.Data)
+	if err != nil {
+		msg.Respond([]byte(fmt.Sprintf("Error decoding points: %v", err)))
+		return
+	}
+
+	reg := externalRegistrationFromPoints(pts)
+	if reg.NodeType == "" {
+		msg.Respond([]byte("node type must be set"))
+		return
+	}
+
+	// use the local time so that clock differences do not expire
+	// registrations
+	reg.LastSeen = time.Now()
+	er.register(reg)
+	msg.Respond(nil)
+}
+
+func (er *ExternalRegistry) handleList(msg *nats.Msg) {
+	var pts data.Points
+	for _, r := range er.list() {
+		for _, p := range r.points() {
+			p.Key = r.NodeType
+			if p.Type == data.PointTypeNodeType {
+				p.Time = r.LastSeen
+			}
+			pts = append(pts, p)
+		}
+	}
+
+	d, err := pts.ToPb()
+	if err != nil {
+		log.Println("Error encoding external client list: ", err)
+		return
+	}
+
+	msg.Respond(d)
+}
+
+// Start the registry. This function blocks until stopped.
+func (er *ExternalRegistry) Start() error {
+	sub, err := er.nc.Subscribe(SubjectClientRegister(), er.handleRegister)
+	if err != nil {
+		return err
+	}
+	er.subs = append(er.subs, sub)
+
+	sub, err = er.nc.Subscribe(SubjectClientList(), er.handleList)
+	if err != nil {
+		return err
+	}
+	er.subs = append(er.subs, sub)
+
+	t := time.NewTicker(ExternalRegisterPeriod)
+
+done:
+	for {
+		select {
+		case <-er.stop:
+			break done
+		case <-t.C:
+			er.prune(time.Now())
+		}
+	}
+
+	// clean up
+	t.Stop()
+	for _, s := range er.subs {
+		s.Unsubscribe()
+	}
+	return nil
+}
+
+// Stop the registry
+func (er *ExternalRegistry) Stop(_ error) {
+	er.stopOnce.Do(func() { close(er.stop) })
+}
+
+// ExternalOptions are used to run clients in an external process
+type ExternalOptions struct {
+	// NATS server URI, defaults to nats://localhost:4222
+	Server string
+	// client credentials, see the auth.clients server config
+	User     string
+	Password string
+	// auth token, gives the process full access
+	Token string
+	// Parent node clients look for nodes under, defaults to the root node
+	Parent      string
+	Description string
+	Version     string
+}
+
+// ExternalOptionsFromEnv returns options from the SIOT_NATS_SERVER,
+// SIOT_CLIENT_USER, SIOT_CLIENT_PASSWORD, and SIOT_AUTH_TOKEN environment
+// variables
+func ExternalOptionsFromEnv() ExternalOptions {
+	return ExternalOptions{
+		Server:   os.Getenv("SIOT_NATS_SERVER"),
+		User:     os.Getenv("SIOT_CLIENT_USER"),
+		Password: os.Getenv("SIOT_CLIENT_PASSWORD"),
+		Token:    os.Getenv("SIOT_AUTH_TOKEN"),
+	}
+}
+
+// ConnectExternal connects to a SIOT NATS server for an external client
+func ConnectExternal(o ExternalOptions) (*nats.Conn, error) {
+	server := o.Server
+	if server == "" {
+		server = nats.DefaultURL
+	}
+
+	opts := []nats.Option{
+		nats.Timeout(10 * time.Second),
+		nats.RetryOnFailedConnect(true),
+		nats.MaxReconnects(-1),
+		nats.ReconnectWait(time.Second),
+		nats.ErrorHandler(func(_ *nats.Conn,
+			sub *nats.Subscription, err error) {
+			log.Printf("NATS client error, sub: %v, err: %s\n", sub.Subject, err)
+		}),
+	}
+
+	if o.User != "" {
+		opts = append(opts, nats.UserInfo(o.User, o.Password))
+	} else if o.Token != "" {
+		opts = append(opts, nats.Token(o.Token))
+	}
+
+	return nats.Connect(server, opts...)
+}
+
+// RunExternal runs clients for a node type in an external process. It
+// connects to the SIOT NATS server, registers the node type, and runs a client
+// manager for the node type until the process receives SIGINT or SIGTERM.
+func RunExternal[T any](o ExternalOptions, construct func(*nats.Conn, T) Client) error {
+	var x T
+	nodeType := reflect.TypeOf(x).Name()
+	nodeType = strings.ToLower(nodeType[0:1]) + nodeType[1:]
+
+	nc, err := ConnectExternal(o)
+	if err != nil {
+		return fmt.Errorf("Error connecting to NATS: %v", err)
+	}
+	defer nc.Close()
+
+	reg := ExternalRegistration{
+		NodeType:    nodeType,
+		Description: o.Description,
+		Version:     o.Version,
+	}
+
+	// the SIOT server may still be starting
+	for attempt := 0; ; attempt++ {
+		err = RegisterExternal(nc, reg)
+		if err == nil {
+			break
+		}
+
+		if attempt >= 10 {
+			return fmt.Errorf("Error registering client: %v", err)
+		}
+
+		log.Println("Error registering client, retrying: ", err)
+		time.Sleep(ExpBackoff(attempt, 10*time.Second))
+	}
+
+	parent := o.Parent
+	if parent == "" {
+		nodes, err := GetNode(nc, "root", "")
+		if err != nil {
+			return fmt.Errorf("Error getting root node: %v", err)
+		}
+
+		if len(nodes) < 1 {
+			return errors.New("no root node")
+		}
+
+		parent = nodes[0].ID
+	}
+
+	m := NewManager(nc, parent, construct)
+
+	chManager := make(chan error, 1)
+	go func() {
+		chManager <- m.Start()
+	}()
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sig)
+
+	t := time.NewTicker(ExternalRegisterPeriod)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-t.C:
+			err := RegisterExternal(nc, reg)
+			if err != nil {
+				log.Println("Error renewing client registration: ", err)
+			}
+		case s := <-sig:
+			log.Printf("Received %v, stopping %v clients\n", s, nodeType)
+			m.Stop(nil)
+			return <-chManager
+		case err := <-chManager:
+			return err
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package client_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/simpleiot/simpleiot/client"
+	"github.com/simpleiot/simpleiot/server"
+)
+
+func TestExternalRegister(t *testing.T) {
+	nc, _, stop, err := server.TestServer()
+
+	if err != nil {
+		t.Fatal("Error starting test server: ", err)
+	}
+
+	defer stop()
+
+	err = client.RegisterExternal(nc, client.ExternalRegistration{})
+	if err == nil {
+		t.Fatal("Expected error registering without a node type")
+	}
+
+	// the registry is started with the built in clients, which may not be
+	// running yet
+	start := time.Now()
+	for {
+		err = client.RegisterExternal(nc, client.ExternalRegistration{
+			NodeType:    "myDevice",
+			Description: "my device client",
+			Version:     "v1.2.0",
+		})
+		if err == nil {
+			break
+		}
+		if time.Since(start) > 2*time.Second {
+			t.Fatal("Error registering: ", err)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	regs, err := client.ListExternal(nc)
+	if err != nil {
+		t.Fatal("Error listing external clients: ", err)
+	}
+
+	if len(regs) != 1 {
+		t.Fatal("Expected 1 registration, got: ", len(regs))
+	}
+
+	r := regs[0]
+	if r.NodeType != "myDevice" || r.Description != "my device client" ||
+		r.Version != "v1.2.0" || r.LastSeen.IsZero() {
+		t.Errorf("Registration not correct: %+v", r)
+	}
+}
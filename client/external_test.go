@@ -0,0 +1,22 @@
+package client
+
+import (
+	"testing"
+	"time"
+)
+
+func TestExternalRegistryPrune(t *testing.T) {
+	er := NewExternalRegistry(nil)
+
+	now := time.Now()
+	er.register(ExternalRegistration{NodeType: "a", LastSeen: now})
+	er.register(ExternalRegistration{NodeType: "b",
+		LastSeen: now.Add(-externalRegisterTimeout - time.Second)})
+
+	er.prune(now)
+
+	regs := er.list()
+	if len(regs) != 1 || regs[0].NodeType != "a" {
+		t.Errorf("Expected only registration a, got: %+v", regs)
+	}
+}
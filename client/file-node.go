@@ -0,0 +1,37 @@
+package client
+
+import (
+	"encoding/base64"
+)
+
+// File is a file stored in a node, for example a camera snapshot or a WASM
+// module. Storing files in nodes makes them available through the node APIs
+// and syncs them upstream like other nodes. Binary files are base64 encoded
+// in the data point. A file must fit in a NATS message (1MB by default).
+type File struct {
+	ID          string `node:"id"`
+	Parent      string `node:"parent"`
+	Description string `point:"description"`
+	Name        string `point:"name"`
+	Data        string `point:"data"`
+	Binary      bool   `point:"binary"`
+}
+
+// NewFile returns a file node with contents
+func NewFile(id, parent, name string, contents []byte, binary bool) File {
+	f := File{ID: id, Parent: parent, Description: name, Name: name, Binary: binary}
+	if binary {
+		f.Data = base64.StdEncoding.EncodeToString(contents)
+	} else {
+		f.Data = string(contents)
+	}
+	return f
+}
+
+// Contents returns the contents of the file
+func (f File) Contents() ([]byte, error) {
+	if f.Binary {
+		return base64.StdEncoding.DecodeString(f.Data)
+	}
+	return []byte(f.Data), nil
+}
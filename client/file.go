@@ -24,13 +24,17 @@ type fileDownload struct {
 }
 
 // ListenForFile listens for a file sent from server. dir is the directly to place
-// downloaded files.
+// downloaded files. Replies tell the sender that compressed chunks are
+// accepted.
 func ListenForFile(nc *nats.Conn, dir, deviceID string, callback func(path string)) error {
 	dl := fileDownload{}
 	_, err := nc.Subscribe(fmt.Sprintf("device.%v.file", deviceID), func(m *nats.Msg) {
 		chunk := &pb.FileChunk{}
 
-		err := proto.Unmarshal(m.Data, chunk)
+		err := DecompressMsg(m)
+		if err == nil {
+			err = proto.Unmarshal(m.Data, chunk)
+		}
 
 		if err != nil {
 			log.Println("Error decoding file chunk: ", err)
@@ -79,7 +83,10 @@ func ListenForFile(nc *nats.Conn, dir, deviceID string, callback func(path strin
 			callback(filePath)
 		}
 
-		err = nc.Publish(m.Reply, []byte("OK"))
+		reply := nats.NewMsg(m.Reply)
+		reply.Data = []byte("OK")
+		acceptCompression(nc, reply)
+		err = nc.PublishMsg(reply)
 		if err != nil {
 			log.Println("Error replying to file download: ", err)
 		}
@@ -89,9 +96,12 @@ func ListenForFile(nc *nats.Conn, dir, deviceID string, callback func(path strin
 }
 
 // SendFile can be used to send a file to a device. Callback provides bytes transfered.
+// Chunks are compressed once the device has replied that it accepts
+// compressed chunks.
 func SendFile(nc *nats.Conn, deviceID string, reader io.Reader, name string, callback func(int)) error {
 	done := false
 	seq := int32(0)
+	compress := false
 
 	bytesTx := 0
 
@@ -127,7 +137,7 @@ func SendFile(nc *nats.Conn, deviceID string, reader io.Reader, name string, cal
 
 		retry := 0
 		for ; retry < 3; retry++ {
-			msg, err := nc.Request(subject, out, time.Minute)
+			msg, err := nc.RequestMsg(CompressMsg(subject, out, compress), time.Minute)
 
 			if err != nil {
 				log.Println("Error sending file, retrying: ", retry, err)
@@ -141,6 +151,8 @@ func SendFile(nc *nats.Conn, deviceID string, reader io.Reader, name string, cal
 				continue
 			}
 
+			compress = AcceptsCompression(msg)
+
 			// we must have sent OK, break out of loop
 			break
 		}
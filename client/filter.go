@@ -0,0 +1,153 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nats-io/nats.go"
+	"github.com/simpleiot/simpleiot/data"
+)
+
+// Filtered subscriptions are created with a request on filter.subscribe. The
+// store then sends node points that match the filter to
+// <subject>.<node ID>. The request contains the following points:
+//
+//   - id: (Text) subscription ID chosen by the subscriber (required)
+//   - subject: (Text) subject prefix the points are sent to (required)
+//   - nodeID: (Text) only send points for this node and its descendants
+//   - pointType: (Text) only send points of this type, can be repeated
+//   - minChange: (Value) only send a point if the value changed this much
+//   - tombstone: (Value) set to 1 to cancel the subscription
+//
+// Subscriptions must be renewed every FilterRenewPeriod by sending the
+// request again.
+const (
+	// FilterRenewPeriod is how often filtered subscriptions are renewed
+	FilterRenewPeriod = 30 * time.Second
+	// FilterRenewTimeout is how long the store keeps a subscription that
+	// is not renewed
+	FilterRenewTimeout = 3 * FilterRenewPeriod
+)
+
+// PointFilter describes the node points sent to a filtered subscription
+type PointFilter struct {
+	// ID of the subscription, set by SubscribeFiltered
+	ID string
+	// Root limits points to this node and its descendants. If blank, points
+	// for all nodes are sent. "root" can be used for the root node.
+	Root string
+	// PointTypes limits points to these types. If blank, all types are sent.
+	PointTypes []string
+	// MinChange is the minimum change in value before a point is sent
+	// again. Points with different text are always sent.
+	MinChange float64
+}
+
+func (pf PointFilter) points() data.Points {
+	now := time.Now()
+	pts := data.Points{
+		{Time: now, Type: data.PointTypeID, Text: pf.ID},
+		{Time: now, Type: data.PointTypeNodeID, Text: pf.Root},
+		{Time: now, Type: data.PointTypeMinChange, Value: pf.MinChange},
+	}
+
+	for i, t := range pf.PointTypes {
+		pts = append(pts, data.Point{Time: now, Type: data.PointTypePointType,
+			Key: fmt.Sprint(i), Text: t})
+	}
+
+	return pts
+}
+
+// PointFilterFromPoints decodes a filter from subscription request points
+func PointFilterFromPoints(pts data.Points) PointFilter {
+	var ret PointFilter
+	for _, p := range pts {
+		switch p.Type {
+		case data.PointTypeID:
+			ret.ID = p.Text
+		case data.PointTypeNodeID:
+			ret.Root = p.Text
+		case data.PointTypePointType:
+			ret.PointTypes = append(ret.PointTypes, p.Text)
+		case data.PointTypeMinChange:
+			ret.MinChange = p.Value
+		}
+	}
+	return ret
+}
+
+// SubscribeFiltered subscribes to node points that match a filter. Filtering
+// is done by the store, so only matching points are sent over NATS. The
+// subscription is renewed until stop() is called.
+func SubscribeFiltered(nc *nats.Conn, filter PointFilter,
+	callback func(nodeID string, points data.Points)) (stop func(), err error) {
+	filter.ID = uuid.New().String()
+	inbox := nats.NewInbox()
+
+	sub, err := nc.Subscribe(inbox+".*", func(msg *nats.Msg) {
+		points, err := data.PbDecodePoints(msg.Data)
+		if err != nil {
+			log.Println("Error decoding filtered points: ", err)
+			return
+		}
+
+		callback(strings.TrimPrefix(msg.Subject, inbox+"."), points)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	send := func(extra ...data.Point) error {
+		pts := append(filter.points(),
+			data.Point{Time: time.Now(), Type: data.PointTypeSubject, Text: inbox})
+		pts = append(pts, extra...)
+		d, err := pts.ToPb()
+		if err != nil {
+			return err
+		}
+		return requestAck(context.Background(), nc, SubjectFilterSubscribe(), d,
+			time.Second*20)
+	}
+
+	err = send()
+	if err != nil {
+		sub.Unsubscribe()
+		return nil, fmt.Errorf("Error creating filtered subscription: %w", err)
+	}
+
+	chStop := make(chan struct{})
+	var stopOnce sync.Once
+
+	go func() {
+		t := time.NewTicker(FilterRenewPeriod)
+		defer t.Stop()
+		for {
+			select {
+			case <-chStop:
+				return
+			case <-t.C:
+				if err := send(); err != nil {
+					log.Println("Error renewing filtered subscription: ", err)
+				}
+			}
+		}
+	}()
+
+	return func() {
+		stopOnce.Do(func() {
+			close(chStop)
+			err := send(data.Point{Time: time.Now(), Type: data.PointTypeTombstone,
+				Value: 1})
+			if err != nil {
+				log.Println("Error canceling filtered subscription: ", err)
+			}
+			sub.Unsubscribe()
+		})
+	}, nil
+}
@@ -0,0 +1,98 @@
+package client_test
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/simpleiot/simpleiot/client"
+	"github.com/simpleiot/simpleiot/data"
+	"github.com/simpleiot/simpleiot/server"
+)
+
+func TestSubscribeFiltered(t *testing.T) {
+	nc, root, stop, err := server.TestServer()
+	if err != nil {
+		t.Fatal("Error starting test server: ", err)
+	}
+	defer stop()
+
+	nodes := []data.NodeEdge{
+		{ID: "ID-group", Type: "testGroup", Parent: root.ID},
+		{ID: "ID-in", Type: "testIO", Parent: "ID-group"},
+		{ID: "ID-out", Type: "testIO", Parent: root.ID},
+	}
+
+	for _, n := range nodes {
+		if err := client.SendNode(nc, n, "test"); err != nil {
+			t.Fatal("Error sending node: ", err)
+		}
+	}
+
+	var lock sync.Mutex
+	received := make(map[string]data.Points)
+
+	stopSub, err := client.SubscribeFiltered(nc, client.PointFilter{
+		Root:       "ID-group",
+		PointTypes: []string{data.PointTypeValue},
+		MinChange:  1,
+	}, func(nodeID string, points data.Points) {
+		lock.Lock()
+		defer lock.Unlock()
+		received[nodeID] = append(received[nodeID], points...)
+	})
+	if err != nil {
+		t.Fatal("Error subscribing: ", err)
+	}
+
+	send := func(id string, pts ...data.Point) {
+		err := client.SendNodePoints(nc, id, pts, true)
+		if err != nil {
+			t.Fatal("Error sending points: ", err)
+		}
+	}
+
+	send("ID-in", data.Point{Type: data.PointTypeValue, Value: 1})
+	send("ID-in", data.Point{Type: data.PointTypeValue, Value: 1.3})
+	send("ID-in", data.Point{Type: data.PointTypeDescription, Text: "x"})
+	send("ID-out", data.Point{Type: data.PointTypeValue, Value: 5})
+	send("ID-in", data.Point{Type: data.PointTypeValue, Value: 3})
+
+	start := time.Now()
+	for {
+		lock.Lock()
+		n := len(received["ID-in"])
+		lock.Unlock()
+		if n >= 2 {
+			break
+		}
+		if time.Since(start) > time.Second {
+			t.Fatal("Timeout waiting for filtered points")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+
+	lock.Lock()
+	if len(received) != 1 {
+		t.Fatal("points received for nodes outside of filter: ", received)
+	}
+
+	pts := received["ID-in"]
+	if len(pts) != 2 || pts[0].Value != 1 || pts[1].Value != 3 {
+		t.Fatal("wrong points received: ", pts)
+	}
+	lock.Unlock()
+
+	// no points are sent after the subscription is stopped
+	stopSub()
+	send("ID-in", data.Point{Type: data.PointTypeValue, Value: 10})
+	time.Sleep(50 * time.Millisecond)
+
+	lock.Lock()
+	defer lock.Unlock()
+	if len(received["ID-in"]) != 2 {
+		t.Fatal("points received after stop: ", received["ID-in"])
+	}
+}
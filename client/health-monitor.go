@@ -0,0 +1,404 @@
+package client
+
+import (
+	"log"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/simpleiot/simpleiot/data"
+)
+
+// HealthMonitor config. A health monitor node periodically computes a
+// health score for every device below its parent node and rolls the
+// results up as healthy/warning/critical device counts on each group node
+// (and the parent node). The thresholds are configurable.
+type HealthMonitor struct {
+	ID          string `node:"id"`
+	Parent      string `node:"parent"`
+	Description string `point:"description"`
+	// SamplePeriod in seconds, default is 60
+	SamplePeriod float64 `point:"samplePeriod"`
+	// OfflineTime in seconds, default is 15m
+	OfflineTime float64 `point:"offlineTime"`
+	// BatteryLow in percent, default is 20
+	BatteryLow float64 `point:"batteryLow"`
+	// DiskHigh in percent, default is 90
+	DiskHigh float64 `point:"diskHigh"`
+	Disable  bool    `point:"disable"`
+}
+
+// health status thresholds
+const (
+	healthWarningScore  = 80
+	healthCriticalScore = 50
+)
+
+// healthPointTypes are written by the health monitor and are not counted as
+// device communication
+var healthPointTypes = map[string]bool{
+	data.PointTypeHealthScore:   true,
+	data.PointTypeHealthStatus:  true,
+	data.PointTypeHealthReasons: true,
+	data.PointTypeHealthyCount:  true,
+	data.PointTypeWarningCount:  true,
+	data.PointTypeCriticalCount: true,
+}
+
+func (hm HealthMonitor) withDefaults() HealthMonitor {
+	if hm.SamplePeriod <= 0 {
+		hm.SamplePeriod = 60
+	}
+	if hm.OfflineTime <= 0 {
+		hm.OfflineTime = 15 * 60
+	}
+	if hm.BatteryLow <= 0 {
+		hm.BatteryLow = 20
+	}
+	if hm.DiskHigh <= 0 {
+		hm.DiskHigh = 90
+	}
+	return hm
+}
+
+// healthStatus returns the status for a health score
+func healthStatus(score float64) string {
+	switch {
+	case score >= healthWarningScore:
+		return data.PointValueHealthy
+	case score >= healthCriticalScore:
+		return data.PointValueWarning
+	default:
+		return data.PointValueCritical
+	}
+}
+
+// deviceHealth computes a health score from 0 to 100 for a device. points
+// contains the device points and the points of its child nodes, for
+// example a system monitor. The reasons for a reduced score are returned.
+func deviceHealth(config HealthMonitor, points data.Points, now time.Time) (float64, []string) {
+	score := 100.0
+	var reasons []string
+
+	penalty := func(p float64, reason string) {
+		score -= p
+		reasons = append(reasons, reason)
+	}
+
+	var last time.Time
+	var battery, disk float64
+	haveBattery := false
+	hasErrors := false
+
+	for _, p := range points {
+		if healthPointTypes[p.Type] {
+			continue
+		}
+
+		if p.Time.After(last) {
+			last = p.Time
+		}
+
+		switch p.Type {
+		case data.PointTypeSysState:
+			if p.Text == data.PointValueSysStateOffline ||
+				p.Text == data.PointValueSysStatePowerOff {
+				penalty(100, "sysState "+p.Text)
+			}
+		case data.PointTypeErrorCount, data.PointTypeErrorCountEOF,
+			data.PointTypeErrorCountCRC:
+			if p.Value > 0 {
+				hasErrors = true
+			}
+		case data.PointTypeSwUpdateError:
+			if p.Text != "" {
+				hasErrors = true
+			}
+		case data.PointTypeBattery:
+			if !haveBattery || p.Value < battery {
+				battery = p.Value
+			}
+			haveBattery = true
+		case data.PointTypeDiskUsed:
+			if p.Value > disk {
+				disk = p.Value
+			}
+		}
+	}
+
+	offline := time.Duration(config.OfflineTime * float64(time.Second))
+	age := now.Sub(last)
+
+	switch {
+	case age > offline:
+		penalty(100, "offline")
+	case age > offline/2:
+		penalty(30, "comms late")
+	}
+
+	if hasErrors {
+		penalty(20, "errors")
+	}
+
+	if haveBattery {
+		switch {
+		case battery < config.BatteryLow/2:
+			penalty(60, "battery critical")
+		case battery < config.BatteryLow:
+			penalty(40, "battery low")
+		}
+	}
+
+	switch {
+	case disk > (100+config.DiskHigh)/2:
+		penalty(50, "disk full")
+	case disk > config.DiskHigh:
+		penalty(30, "disk high")
+	}
+
+	if score < 0 {
+		score = 0
+	}
+
+	return score, reasons
+}
+
+// healthCounts are the device counts rolled up on group nodes
+type healthCounts struct {
+	healthy, warning, critical int
+}
+
+func (hc *healthCounts) add(status string) {
+	switch status {
+	case data.PointValueHealthy:
+		hc.healthy++
+	case data.PointValueWarning:
+		hc.warning++
+	default:
+		hc.critical++
+	}
+}
+
+func (hc *healthCounts) addCounts(c healthCounts) {
+	hc.healthy += c.healthy
+	hc.warning += c.warning
+	hc.critical += c.critical
+}
+
+// deviceHealthResult is the health of one device
+type deviceHealthResult struct {
+	score   float64
+	status  string
+	reasons []string
+}
+
+// fleetHealth computes the health of all devices below root and the
+// device counts for root and each group node below it
+func fleetHealth(config HealthMonitor, root string, nodes []data.NodeEdge,
+	now time.Time) (map[string]deviceHealthResult, map[string]healthCounts) {
+	children := make(map[string][]data.NodeEdge)
+	for _, n := range nodes {
+		children[n.Parent] = append(children[n.Parent], n)
+	}
+
+	devices := make(map[string]deviceHealthResult)
+	groups := make(map[string]healthCounts)
+
+	var walk func(id string) healthCounts
+	walk = func(id string) healthCounts {
+		var counts healthCounts
+
+		for _, n := range children[id] {
+			if n.Type == data.NodeTypeDevice {
+				points := append(data.Points{}, n.Points...)
+				for _, c := range children[n.ID] {
+					if c.Type != data.NodeTypeDevice &&
+						c.Type != data.NodeTypeHealthMonitor {
+						points = append(points, c.Points...)
+					}
+				}
+
+				score, reasons := deviceHealth(config, points, now)
+				status := healthStatus(score)
+				devices[n.ID] = deviceHealthResult{score, status, reasons}
+				counts.add(status)
+			}
+
+			c := walk(n.ID)
+			counts.addCounts(c)
+
+			if n.Type == data.NodeTypeGroup {
+				groups[n.ID] = c
+			}
+		}
+
+		return counts
+	}
+
+	groups[root] = walk(root)
+
+	return devices, groups
+}
+
+// HealthMonitorClient computes device health for health monitor nodes
+type HealthMonitorClient struct {
+	nc            *nats.Conn
+	config        HealthMonitor
+	stop          chan struct{}
+	newPoints     chan NewPoints
+	newEdgePoints chan NewPoints
+	// last sent values so points are only sent when they change
+	lastDevice map[string]deviceHealthResult
+	lastGroup  map[string]healthCounts
+}
+
+// NewHealthMonitorClient ...
+func NewHealthMonitorClient(nc *nats.Conn, config HealthMonitor) Client {
+	return &HealthMonitorClient{
+		nc:            nc,
+		config:        config,
+		stop:          make(chan struct{}),
+		newPoints:     make(chan NewPoints),
+		newEdgePoints: make(chan NewPoints),
+		lastDevice:    make(map[string]deviceHealthResult),
+		lastGroup:     make(map[string]healthCounts),
+	}
+}
+
+func (hmc *HealthMonitorClient) update() error {
+	nodes, err := GetNodeChildren(hmc.nc, hmc.config.Parent, "", false, true)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	devices, groups := fleetHealth(hmc.config.withDefaults(), hmc.config.Parent,
+		nodes, now)
+
+	for id, d := range devices {
+		reasons := strings.Join(d.reasons, ", ")
+		last, ok := hmc.lastDevice[id]
+		if ok && last.score == d.score && strings.Join(last.reasons, ", ") == reasons {
+			continue
+		}
+
+		err := SendNodePoints(hmc.nc, id, data.Points{
+			{Time: now, Type: data.PointTypeHealthScore, Value: d.score},
+			{Time: now, Type: data.PointTypeHealthStatus, Text: d.status},
+			{Time: now, Type: data.PointTypeHealthReasons, Text: reasons},
+		}, false)
+		if err != nil {
+			return err
+		}
+
+		hmc.lastDevice[id] = d
+	}
+
+	for id, c := range groups {
+		if last, ok := hmc.lastGroup[id]; ok && last == c {
+			continue
+		}
+
+		err := SendNodePoints(hmc.nc, id, data.Points{
+			{Time: now, Type: data.PointTypeHealthyCount, Value: float64(c.healthy)},
+			{Time: now, Type: data.PointTypeWarningCount, Value: float64(c.warning)},
+			{Time: now, Type: data.PointTypeCriticalCount, Value: float64(c.critical)},
+		}, false)
+		if err != nil {
+			return err
+		}
+
+		hmc.lastGroup[id] = c
+	}
+
+	return nil
+}
+
+// Start runs the main logic for this client and blocks until stopped
+func (hmc *HealthMonitorClient) Start() error {
+	log.Println("Starting health monitor client: ", hmc.config.Description)
+
+	t := time.NewTicker(time.Hour)
+	t.Stop()
+
+	update := func() {
+		if hmc.config.Disable {
+			return
+		}
+
+		err := hmc.update()
+		if err != nil {
+			log.Printf("Health monitor %v: %v\n", hmc.config.Description, err)
+		}
+	}
+
+	setup := func() {
+		t.Stop()
+
+		if hmc.config.Disable {
+			return
+		}
+
+		// resend all points with the new settings
+		hmc.lastDevice = make(map[string]deviceHealthResult)
+		hmc.lastGroup = make(map[string]healthCounts)
+
+		config := hmc.config.withDefaults()
+		t.Reset(time.Duration(config.SamplePeriod * float64(time.Second)))
+		update()
+	}
+
+	setup()
+
+done:
+	for {
+		select {
+		case <-hmc.stop:
+			log.Println("Stopping health monitor client: ", hmc.config.Description)
+			break done
+		case <-t.C:
+			update()
+		case pts := <-hmc.newPoints:
+			err := data.MergePoints(pts.ID, pts.Points, &hmc.config)
+			if err != nil {
+				log.Println("error merging new points: ", err)
+			}
+
+			for _, p := range pts.Points {
+				switch p.Type {
+				case data.PointTypeSamplePeriod, data.PointTypeOfflineTime,
+					data.PointTypeBatteryLow, data.PointTypeDiskHigh,
+					data.PointTypeDisable:
+					setup()
+				}
+			}
+
+		case pts := <-hmc.newEdgePoints:
+			err := data.MergeEdgePoints(pts.ID, pts.Parent, pts.Points, &hmc.config)
+			if err != nil {
+				log.Println("error merging new points: ", err)
+			}
+		}
+	}
+
+	// clean up
+	t.Stop()
+	return nil
+}
+
+// Stop sends a signal to the Start function to exit
+func (hmc *HealthMonitorClient) Stop(err error) {
+	close(hmc.stop)
+}
+
+// Points is called by the Manager when new points for this
+// node are received.
+func (hmc *HealthMonitorClient) Points(nodeID string, points []data.Point) {
+	hmc.newPoints <- NewPoints{nodeID, "", points}
+}
+
+// EdgePoints is called by the Manager when new edge points for this
+// node are received.
+func (hmc *HealthMonitorClient) EdgePoints(nodeID, parentID string, points []data.Point) {
+	hmc.newEdgePoints <- NewPoints{nodeID, parentID, points}
+}
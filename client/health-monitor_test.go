@@ -0,0 +1,60 @@
+package client
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/simpleiot/simpleiot/data"
+)
+
+func TestFleetHealth(t *testing.T) {
+	now := time.Now()
+	recent := now.Add(-time.Minute)
+	config := HealthMonitor{}.withDefaults()
+
+	nodes := []data.NodeEdge{
+		{ID: "g1", Parent: "root", Type: data.NodeTypeGroup},
+		{ID: "g2", Parent: "g1", Type: data.NodeTypeGroup},
+		// healthy
+		{ID: "d1", Parent: "g1", Type: data.NodeTypeDevice, Points: data.Points{
+			{Time: recent, Type: data.PointTypeDescription, Text: "ok"},
+			{Time: recent, Type: data.PointTypeBattery, Value: 80}}},
+		// disk high on a system monitor child -> warning
+		{ID: "d2", Parent: "g2", Type: data.NodeTypeDevice, Points: data.Points{
+			{Time: recent, Type: data.PointTypeDescription, Text: "disk"}}},
+		{ID: "sm", Parent: "d2", Type: data.NodeTypeSystemMonitor, Points: data.Points{
+			{Time: recent, Type: data.PointTypeDiskUsed, Key: "/", Value: 92}}},
+		// offline -> critical, health points do not count as comms
+		{ID: "d3", Parent: "g2", Type: data.NodeTypeDevice, Points: data.Points{
+			{Time: now.Add(-time.Hour), Type: data.PointTypeDescription, Text: "old"},
+			{Time: now, Type: data.PointTypeHealthScore, Value: 100}}},
+		// device directly below root
+		{ID: "d4", Parent: "root", Type: data.NodeTypeDevice, Points: data.Points{
+			{Time: recent, Type: data.PointTypeErrorCount, Value: 3},
+			{Time: recent, Type: data.PointTypeBattery, Value: 15}}},
+	}
+
+	devices, groups := fleetHealth(config, "root", nodes, now)
+
+	expDevices := map[string]deviceHealthResult{
+		"d1": {100, data.PointValueHealthy, nil},
+		"d2": {70, data.PointValueWarning, []string{"disk high"}},
+		"d3": {0, data.PointValueCritical, []string{"offline"}},
+		"d4": {40, data.PointValueCritical, []string{"errors", "battery low"}},
+	}
+
+	if !reflect.DeepEqual(devices, expDevices) {
+		t.Errorf("wrong device health:\n%+v\nexpected:\n%+v", devices, expDevices)
+	}
+
+	expGroups := map[string]healthCounts{
+		"root": {1, 1, 2},
+		"g1":   {1, 1, 1},
+		"g2":   {0, 1, 1},
+	}
+
+	if !reflect.DeepEqual(groups, expGroups) {
+		t.Errorf("wrong group counts:\n%+v\nexpected:\n%+v", groups, expGroups)
+	}
+}
@@ -0,0 +1,39 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/simpleiot/simpleiot/data"
+)
+
+// HealthCheck verifies that the NATS connection is connected and that the
+// store responds to a request for the root node within timeout. A nil
+// error is returned if everything is healthy.
+func HealthCheck(nc *nats.Conn, timeout time.Duration) error {
+	if nc == nil {
+		return errors.New("no NATS connection")
+	}
+
+	if nc.Status() != nats.CONNECTED {
+		return fmt.Errorf("NATS not connected: %v", nc.Status())
+	}
+
+	nodeMsg, err := nc.Request("node.root", []byte("none"), timeout)
+	if err != nil {
+		return fmt.Errorf("store not responding: %v", err)
+	}
+
+	nodes, err := data.PbDecodeNodesRequest(nodeMsg.Data)
+	if err != nil {
+		return fmt.Errorf("store returned error: %v", err)
+	}
+
+	if len(nodes) < 1 {
+		return errors.New("store returned no root node")
+	}
+
+	return nil
+}
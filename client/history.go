@@ -0,0 +1,215 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/simpleiot/simpleiot/data"
+)
+
+// historyChunkSize is the number of points sent in each history backfill
+// request. It is well under data.MaxDecodePoints so that large points still
+// fit in data.MaxDecodeSize.
+var historyChunkSize = 1000
+
+// historyTimeout is how long to wait for each history chunk to be acked
+var historyTimeout = 10 * time.Second
+
+// SendHistoryPoints backfills historical points for a node, for example when
+// a device flushes data it buffered while offline. Points are written to
+// the history databases (Influx, etc) with their original timestamps, but
+// are not written to the store or processed by rules. Points must have the
+// time set. Large batches are split into chunks and each chunk is acked.
+// progress is optional and is called after each chunk with the number of
+// points sent so far.
+func SendHistoryPoints(nc *nats.Conn, nodeID string, points data.Points,
+	progress func(sent, total int)) error {
+	for _, p := range points {
+		if p.Time.IsZero() {
+			return errors.New("history points must have time set")
+		}
+	}
+
+	subject := SubjectNodeHistoryPoints(nodeID)
+
+	for start := 0; start < len(points); start += historyChunkSize {
+		end := start + historyChunkSize
+		if end > len(points) {
+			end = len(points)
+		}
+
+		chunk := points[start:end]
+		d, err := chunk.ToPb()
+		if err != nil {
+			return err
+		}
+
+		err = requestAck(withNewMsgID(context.Background()), nc, subject, d, historyTimeout)
+		if err != nil {
+			return fmt.Errorf("Error sending history points %v-%v: %w",
+				start, end, err)
+		}
+
+		if progress != nil {
+			progress(end, len(points))
+		}
+	}
+
+	return nil
+}
+
+// HistoryQuery is used to query point history for a node
+type HistoryQuery struct {
+	NodeID string    `json:"nodeID"`
+	Type   string    `json:"type"`
+	Key    string    `json:"key,omitempty"`
+	Start  time.Time `json:"start"`
+	End    time.Time `json:"end"`
+	// Limit is the max number of points returned, 0 for no limit
+	Limit int `json:"limit,omitempty"`
+	// Every aggregates values into windows of Every seconds, and returns
+	// the mean value of each window with the time of the window start. Text
+	// and origin are not returned for aggregated points. 0 returns the raw
+	// points.
+	Every float64 `json:"every,omitempty"`
+	// Wait is how long in seconds to wait for more responses after a
+	// response is received. Default is 1s.
+	Wait float64 `json:"wait,omitempty"`
+}
+
+// HistoryResult is the response to a history query
+type HistoryResult struct {
+	Points data.Points `json:"points"`
+	Error  string      `json:"error,omitempty"`
+}
+
+// historyGatherTime is how long QueryHistory waits for more responses after
+// a response is received if the query does not set Wait
+var historyGatherTime = time.Second
+
+// ErrNoHistory is returned by QueryHistory if no history source responded
+var ErrNoHistory = errors.New("no history source responded")
+
+// QueryHistory queries point history for a node. History may be stored by
+// several sources -- for example a database client on this instance and
+// on a downstream gateway that is connected through an upstream
+// connection. The query is sent to all sources and the results are merged
+// and sorted by time.
+func QueryHistory(nc *nats.Conn, q HistoryQuery) (data.Points, error) {
+	if q.NodeID == "" || q.Type == "" {
+		return nil, errors.New("history query must include node ID and type")
+	}
+
+	if q.End.IsZero() {
+		q.End = time.Now()
+	}
+
+	if !q.Start.Before(q.End) {
+		return nil, errors.New("history query start must be before end")
+	}
+
+	reqData, err := json.Marshal(q)
+	if err != nil {
+		return nil, err
+	}
+
+	inbox := nats.NewInbox()
+	sub, err := nc.SubscribeSync(inbox)
+	if err != nil {
+		return nil, err
+	}
+	defer sub.Unsubscribe()
+
+	err = nc.PublishRequest(SubjectNodeHistoryQuery(q.NodeID), inbox, reqData)
+	if err != nil {
+		return nil, err
+	}
+
+	gather := historyGatherTime
+	if q.Wait > 0 {
+		gather = time.Duration(q.Wait * float64(time.Second))
+	}
+
+	var ret data.Points
+	var errs []string
+	responses := 0
+	timeout := historyTimeout
+
+	for {
+		msg, err := sub.NextMsg(timeout)
+		if err != nil {
+			break
+		}
+
+		responses++
+		timeout = gather
+
+		var res HistoryResult
+		err = json.Unmarshal(msg.Data, &res)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+
+		if res.Error != "" {
+			errs = append(errs, res.Error)
+			continue
+		}
+
+		ret = append(ret, res.Points...)
+	}
+
+	if responses == 0 {
+		return nil, ErrNoHistory
+	}
+
+	if len(errs) == responses {
+		return nil, errors.New(errs[0])
+	}
+
+	return mergeHistory(ret, q.Limit), nil
+}
+
+// mergeHistory sorts points by time, removes points with the same time and
+// value that were returned by more than one source, and limits the number
+// of points.
+func mergeHistory(points data.Points, limit int) data.Points {
+	sort.Sort(points)
+
+	ret := make(data.Points, 0, len(points))
+	for _, p := range points {
+		if len(ret) > 0 {
+			last := ret[len(ret)-1]
+			if last.Time.Equal(p.Time) && last.Value == p.Value && last.Text == p.Text {
+				continue
+			}
+		}
+		ret = append(ret, p)
+	}
+
+	if limit > 0 && len(ret) > limit {
+		ret = ret[:limit]
+	}
+
+	return ret
+}
+
+// RespondHistory sends a response to a history query
+func RespondHistory(nc *nats.Conn, reply string, points data.Points, err error) error {
+	res := HistoryResult{Points: points}
+	if err != nil {
+		res.Error = err.Error()
+	}
+
+	d, err := json.Marshal(res)
+	if err != nil {
+		return err
+	}
+
+	return nc.Publish(reply, d)
+}
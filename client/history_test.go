@@ -0,0 +1,163 @@
+package client_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/simpleiot/simpleiot/client"
+	"github.com/simpleiot/simpleiot/data"
+	"github.com/simpleiot/simpleiot/server"
+)
+
+func TestSendHistoryPoints(t *testing.T) {
+	nc, root, stop, err := server.TestServer()
+	if err != nil {
+		t.Fatal("Error starting test server: ", err)
+	}
+
+	defer stop()
+
+	v := client.Variable{
+		ID:          "ID-var",
+		Parent:      root.ID,
+		Description: "var",
+	}
+
+	err = client.SendNodeType(nc, v, "test")
+	if err != nil {
+		t.Fatal("Error sending node: ", err)
+	}
+
+	received := make(chan data.Points, 10)
+
+	sub, err := nc.Subscribe("histup."+root.ID+"."+v.ID+".points", func(msg *nats.Msg) {
+		points, err := data.PbDecodePoints(msg.Data)
+		if err != nil {
+			t.Error("Error decoding history points: ", err)
+			return
+		}
+		received <- points
+	})
+	if err != nil {
+		t.Fatal("Error subscribing: ", err)
+	}
+	defer sub.Unsubscribe()
+
+	start := time.Now().Add(-7 * 24 * time.Hour)
+	var points data.Points
+	for i := 0; i < 2500; i++ {
+		points = append(points, data.Point{Time: start.Add(time.Duration(i) * time.Minute),
+			Type: "temp", Value: float64(i), Origin: "test"})
+	}
+
+	progress := 0
+	err = client.SendHistoryPoints(nc, v.ID, points, func(sent, total int) {
+		progress = sent
+		if total != len(points) {
+			t.Error("wrong total: ", total)
+		}
+	})
+	if err != nil {
+		t.Fatal("Error sending history points: ", err)
+	}
+
+	if progress != len(points) {
+		t.Error("progress not reported, got: ", progress)
+	}
+
+	count := 0
+	for count < len(points) {
+		select {
+		case pts := <-received:
+			count += len(pts)
+		case <-time.After(time.Second):
+			t.Fatal("timeout waiting for history points, got: ", count)
+		}
+	}
+
+	// history points should not be written to the store
+	nodes, err := client.GetNode(nc, v.ID, root.ID)
+	if err != nil || len(nodes) < 1 {
+		t.Fatal("Error getting node: ", err)
+	}
+
+	if _, ok := nodes[0].Points.Find("temp", ""); ok {
+		t.Error("history points should not be written to the store")
+	}
+
+	err = client.SendHistoryPoints(nc, v.ID, data.Points{{Type: data.PointTypeValue}}, nil)
+	if err == nil {
+		t.Error("expected error for points without time")
+	}
+}
+
+func TestQueryHistory(t *testing.T) {
+	nc, _, stop, err := server.TestServer()
+	if err != nil {
+		t.Fatal("Error starting test server: ", err)
+	}
+
+	defer stop()
+
+	start := time.Now().Add(-time.Hour)
+	pt := func(min int, v float64) data.Point {
+		return data.Point{Time: start.Add(time.Duration(min) * time.Minute),
+			Type: "temp", Value: v}
+	}
+
+	// two sources, for example a local db and a gateway, with one
+	// overlapping point
+	responder := func(points data.Points) *nats.Subscription {
+		sub, err := nc.Subscribe(client.SubjectNodeHistoryQuery("ID-node"), func(msg *nats.Msg) {
+			err := client.RespondHistory(nc, msg.Reply, points, nil)
+			if err != nil {
+				t.Error("Error responding: ", err)
+			}
+		})
+		if err != nil {
+			t.Fatal("Error subscribing: ", err)
+		}
+		return sub
+	}
+
+	sub1 := responder(data.Points{pt(3, 3), pt(1, 1)})
+	defer sub1.Unsubscribe()
+	sub2 := responder(data.Points{pt(2, 2), pt(3, 3)})
+	defer sub2.Unsubscribe()
+
+	q := client.HistoryQuery{NodeID: "ID-node", Type: "temp", Start: start,
+		Wait: 0.1}
+
+	points, err := client.QueryHistory(nc, q)
+	if err != nil {
+		t.Fatal("Error querying history: ", err)
+	}
+
+	if len(points) != 3 {
+		t.Fatal("expected 3 points, got: ", points)
+	}
+
+	for i, p := range points {
+		if p.Value != float64(i+1) {
+			t.Error("points not sorted/merged: ", points)
+			break
+		}
+	}
+
+	q.Limit = 2
+	points, err = client.QueryHistory(nc, q)
+	if err != nil {
+		t.Fatal("Error querying history: ", err)
+	}
+
+	if len(points) != 2 {
+		t.Error("limit not applied: ", points)
+	}
+
+	_, err = client.QueryHistory(nc, client.HistoryQuery{NodeID: "ID-node",
+		Type: "temp", Start: time.Now().Add(time.Hour)})
+	if err == nil {
+		t.Error("expected error for start after end")
+	}
+}
@@ -0,0 +1,271 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os/exec"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/simpleiot/simpleiot/data"
+)
+
+// hostConfirmTimeout is how long a user has to confirm a host command. It
+// is a variable so that it can be shortened in tests.
+var hostConfirmTimeout = 60 * time.Second
+
+// HostControl config. A host control node allows authorized users to reboot
+// or shut down the host, restart the SIOT service, or restart the network
+// by setting the command point. Commands must be confirmed by the same user
+// setting the confirm point within 60s before they are run.
+type HostControl struct {
+	ID             string `node:"id"`
+	Parent         string `node:"parent"`
+	Description    string `point:"description"`
+	Command        string `point:"command"`
+	Confirm        bool   `point:"confirm"`
+	CommandStatus  string `point:"commandStatus"`
+	LastCommand    string `point:"lastCommand"`
+	ServiceName    string `point:"serviceName"`
+	NetworkService string `point:"networkService"`
+	Disable        bool   `point:"disable"`
+}
+
+// HostControlClient for host control nodes
+type HostControlClient struct {
+	nc            *nats.Conn
+	config        HostControl
+	stop          chan struct{}
+	newPoints     chan NewPoints
+	newEdgePoints chan NewPoints
+}
+
+// NewHostControlClient ...
+func NewHostControlClient(nc *nats.Conn, config HostControl) Client {
+	return &HostControlClient{
+		nc:            nc,
+		config:        config,
+		stop:          make(chan struct{}),
+		newPoints:     make(chan NewPoints),
+		newEdgePoints: make(chan NewPoints),
+	}
+}
+
+// hostCommandArgs returns the host command line for a command
+func hostCommandArgs(config HostControl, command string) ([]string, error) {
+	switch command {
+	case data.PointValueReboot:
+		return []string{"reboot"}, nil
+	case data.PointValueShutdown:
+		return []string{"poweroff"}, nil
+	case data.PointValueRestartService:
+		service := config.ServiceName
+		if service == "" {
+			service = "simpleiot"
+		}
+		return []string{"systemctl", "restart", service}, nil
+	case data.PointValueRestartNetwork:
+		service := config.NetworkService
+		if service == "" {
+			service = "NetworkManager"
+		}
+		return []string{"systemctl", "restart", service}, nil
+	default:
+		return nil, fmt.Errorf("Unknown host command: %v", command)
+	}
+}
+
+// runHostCommand runs a host command. The command is killed if ctx is done
+// before it exits. It is a variable so that it can be replaced in tests.
+var runHostCommand = func(ctx context.Context, args []string) error {
+	out, err := exec.CommandContext(ctx, args[0], args[1:]...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%v: %s", err, out)
+	}
+	return nil
+}
+
+// originUser returns the email of the user node with ID id, or an error if
+// id is not a user. This is used to only accept host commands from users, as
+// points from clients, rules, and sync have other origins.
+func originUser(nc *nats.Conn, id string) (string, error) {
+	if id == "" {
+		return "", errors.New("command does not have an origin")
+	}
+
+	nodes, err := GetNode(nc, id, "all")
+	if err != nil {
+		return "", err
+	}
+
+	for _, n := range nodes {
+		if n.Type == data.NodeTypeUser {
+			email, _ := n.Points.Text(data.PointTypeEmail, "")
+			return email, nil
+		}
+	}
+
+	return "", errors.New("command origin is not a user")
+}
+
+func (hcc *HostControlClient) sendStatus(pts ...data.Point) {
+	now := time.Now()
+	for i := range pts {
+		pts[i].Time = now
+	}
+
+	err := SendNodePoints(hcc.nc, hcc.config.ID, pts, false)
+	if err != nil {
+		log.Println("Host control error sending points: ", err)
+	}
+}
+
+// Start runs the main logic for this client and blocks until stopped
+func (hcc *HostControlClient) Start() error {
+	log.Println("Starting host control client: ", hcc.config.Description)
+
+	var pendingCmd, pendingUser, pendingEmail string
+	var pendingTime time.Time
+
+	clearPending := func(status string) {
+		pendingCmd = ""
+		hcc.sendStatus(
+			data.Point{Type: data.PointTypeCommand, Text: ""},
+			data.Point{Type: data.PointTypeConfirm, Value: 0},
+			data.Point{Type: data.PointTypeCommandStatus, Text: status},
+		)
+	}
+
+	handleCommand := func(p data.Point) {
+		if p.Text == "" {
+			return
+		}
+
+		if hcc.config.Disable {
+			log.Printf("Host control %v: disabled, ignoring command %v\n",
+				hcc.config.Description, p.Text)
+			clearPending("disabled")
+			return
+		}
+
+		email, err := originUser(hcc.nc, p.Origin)
+		if err != nil {
+			log.Printf("Host control: unauthorized command %v from %v: %v\n",
+				p.Text, p.Origin, err)
+			clearPending("unauthorized")
+			return
+		}
+
+		if _, err := hostCommandArgs(hcc.config, p.Text); err != nil {
+			clearPending(err.Error())
+			return
+		}
+
+		pendingCmd = p.Text
+		pendingUser = p.Origin
+		pendingEmail = email
+		pendingTime = time.Now()
+
+		log.Printf("Host control: %v requested %v, waiting for confirmation\n",
+			email, pendingCmd)
+		hcc.sendStatus(data.Point{Type: data.PointTypeCommandStatus,
+			Text: "confirm " + pendingCmd})
+	}
+
+	handleConfirm := func(p data.Point) {
+		if p.Value == 0 || pendingCmd == "" {
+			return
+		}
+
+		if p.Origin != pendingUser {
+			log.Printf("Host control: %v confirmation must come from %v\n",
+				pendingCmd, pendingEmail)
+			return
+		}
+
+		if time.Since(pendingTime) > hostConfirmTimeout {
+			clearPending("confirmation timeout")
+			return
+		}
+
+		cmd := pendingCmd
+		args, _ := hostCommandArgs(hcc.config, cmd)
+
+		log.Printf("Host control AUDIT: %v confirmed %v, running: %v\n",
+			pendingEmail, cmd, args)
+
+		hcc.sendStatus(data.Point{Type: data.PointTypeLastCommand,
+			Text: fmt.Sprintf("%v by %v at %v", cmd, pendingEmail,
+				time.Now().Format(time.RFC3339))})
+
+		status := "running " + cmd
+		clearPending(status)
+
+		err := runHostCommand(context.Background(), args)
+		if err != nil {
+			log.Printf("Host control: error running %v: %v\n", cmd, err)
+			hcc.sendStatus(data.Point{Type: data.PointTypeCommandStatus,
+				Text: "error: " + err.Error()})
+		}
+	}
+
+	t := time.NewTicker(10 * time.Second)
+
+done:
+	for {
+		select {
+		case <-hcc.stop:
+			log.Println("Stopping host control client: ", hcc.config.Description)
+			break done
+		case <-t.C:
+			if pendingCmd != "" && time.Since(pendingTime) > hostConfirmTimeout {
+				log.Printf("Host control: %v from %v was not confirmed\n",
+					pendingCmd, pendingEmail)
+				clearPending("confirmation timeout")
+			}
+		case pts := <-hcc.newPoints:
+			err := data.MergePoints(pts.ID, pts.Points, &hcc.config)
+			if err != nil {
+				log.Println("error merging new points: ", err)
+			}
+
+			for _, p := range pts.Points {
+				switch p.Type {
+				case data.PointTypeCommand:
+					handleCommand(p)
+				case data.PointTypeConfirm:
+					handleConfirm(p)
+				}
+			}
+
+		case pts := <-hcc.newEdgePoints:
+			err := data.MergeEdgePoints(pts.ID, pts.Parent, pts.Points, &hcc.config)
+			if err != nil {
+				log.Println("error merging new points: ", err)
+			}
+		}
+	}
+
+	// clean up
+	t.Stop()
+	return nil
+}
+
+// Stop sends a signal to the Start function to exit
+func (hcc *HostControlClient) Stop(err error) {
+	close(hcc.stop)
+}
+
+// Points is called by the Manager when new points for this
+// node are received.
+func (hcc *HostControlClient) Points(nodeID string, points []data.Point) {
+	hcc.newPoints <- NewPoints{nodeID, "", points}
+}
+
+// EdgePoints is called by the Manager when new edge points for this
+// node are received.
+func (hcc *HostControlClient) EdgePoints(nodeID, parentID string, points []data.Point) {
+	hcc.newEdgePoints <- NewPoints{nodeID, parentID, points}
+}
@@ -0,0 +1,130 @@
+package client_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/simpleiot/simpleiot/client"
+	"github.com/simpleiot/simpleiot/data"
+	"github.com/simpleiot/simpleiot/server"
+)
+
+func TestHostControl(t *testing.T) {
+	cmds := make(chan string, 10)
+	restore := client.SetRunHostCommand(func(ctx context.Context, args []string) error {
+		cmds <- strings.Join(args, " ")
+		return nil
+	})
+	defer restore()
+
+	restoreTimeout := client.SetHostConfirmTimeout(3 * time.Second)
+	defer restoreTimeout()
+
+	nc, root, stop, err := server.TestServer()
+	if err != nil {
+		t.Fatal("Error starting test server: ", err)
+	}
+	defer stop()
+
+	users, err := client.GetNodeChildrenType[client.User](nc, root.ID)
+	if err != nil || len(users) < 1 {
+		t.Fatal("Error getting admin user: ", err)
+	}
+	user := users[0].ID
+
+	hc := client.HostControl{ID: "host-control", Parent: root.ID,
+		Description: "host control"}
+	if err := client.SendNodeType(nc, hc, "test"); err != nil {
+		t.Fatal(err)
+	}
+
+	getNode, stopWatcher, err := client.NodeWatcher[client.HostControl](nc, hc.ID, hc.Parent)
+	if err != nil {
+		t.Fatal("Error setting up node watcher: ", err)
+	}
+	defer stopWatcher()
+
+	// wait for the client to start
+	time.Sleep(500 * time.Millisecond)
+
+	send := func(p data.Point) {
+		t.Helper()
+		err := client.SendNodePoint(nc, hc.ID, p, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	command := func(origin string) {
+		t.Helper()
+		send(data.Point{Type: data.PointTypeCommand, Text: data.PointValueReboot,
+			Origin: origin})
+	}
+
+	confirm := func(origin string) {
+		t.Helper()
+		send(data.Point{Type: data.PointTypeConfirm, Value: 1, Origin: origin})
+	}
+
+	waitStatus := func(status string) {
+		t.Helper()
+		start := time.Now()
+		for getNode().CommandStatus != status {
+			if time.Since(start) > client.DefaultConfigDebounce+2*time.Second {
+				t.Fatalf("Timeout waiting for status %q, got %q", status,
+					getNode().CommandStatus)
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+	}
+
+	noCommand := func() {
+		t.Helper()
+		select {
+		case cmd := <-cmds:
+			t.Fatal("command ran: ", cmd)
+		case <-time.After(client.DefaultConfigDebounce + 500*time.Millisecond):
+		}
+	}
+
+	// commands from other nodes, like rules, are rejected
+	command("rule")
+	waitStatus("unauthorized")
+	noCommand()
+
+	// commands must be confirmed within the timeout
+	command(user)
+	waitStatus("confirm reboot")
+	time.Sleep(3500 * time.Millisecond)
+	confirm(user)
+	waitStatus("confirmation timeout")
+	noCommand()
+
+	// confirmations from other nodes are ignored
+	command(user)
+	waitStatus("confirm reboot")
+	confirm("rule")
+	noCommand()
+
+	if getNode().CommandStatus != "confirm reboot" {
+		t.Fatal("confirmation from a rule changed status: ", getNode().CommandStatus)
+	}
+
+	confirm(user)
+	waitStatus("running reboot")
+
+	select {
+	case cmd := <-cmds:
+		if cmd != "reboot" {
+			t.Error("wrong command: ", cmd)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("confirmed command did not run")
+	}
+
+	if !strings.HasPrefix(getNode().LastCommand, "reboot by ") {
+		t.Error("last command not recorded: ", getNode().LastCommand)
+	}
+}
@@ -0,0 +1,38 @@
+package client
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/simpleiot/simpleiot/data"
+)
+
+func TestHostCommandArgs(t *testing.T) {
+	config := HostControl{NetworkService: "systemd-networkd"}
+
+	tests := []struct {
+		cmd string
+		exp []string
+	}{
+		{data.PointValueReboot, []string{"reboot"}},
+		{data.PointValueShutdown, []string{"poweroff"}},
+		{data.PointValueRestartService, []string{"systemctl", "restart", "simpleiot"}},
+		{data.PointValueRestartNetwork, []string{"systemctl", "restart", "systemd-networkd"}},
+	}
+
+	for _, test := range tests {
+		args, err := hostCommandArgs(config, test.cmd)
+		if err != nil {
+			t.Errorf("%v: got error: %v", test.cmd, err)
+		}
+
+		if !reflect.DeepEqual(args, test.exp) {
+			t.Errorf("%v: expected %v, got %v", test.cmd, test.exp, args)
+		}
+	}
+
+	_, err := hostCommandArgs(config, "rm -rf /")
+	if err == nil {
+		t.Error("Expected error for unknown command")
+	}
+}
@@ -0,0 +1,52 @@
+package client
+
+import (
+	"context"
+
+	"github.com/nats-io/nats.go"
+)
+
+// HeaderIfVersion is the NATS header that carries the node version (see
+// data.Points.Version) a point message is conditional on. The store checks
+// the version in the write transaction and rejects the points with
+// data.ErrVersionMismatch if the node was modified since.
+const HeaderIfVersion = "Siot-If-Version"
+
+type ifVersionKey struct{}
+
+// WithIfVersion returns a context that makes the points sent with it
+// (SendNodePointsCtx, etc) conditional on the node version. The points must
+// be sent with ack true to get the mismatch error.
+func WithIfVersion(ctx context.Context, version string) context.Context {
+	return context.WithValue(ctx, ifVersionKey{}, version)
+}
+
+// IfVersion returns the version set with WithIfVersion, or ""
+func IfVersion(ctx context.Context) string {
+	v, _ := ctx.Value(ifVersionKey{}).(string)
+	return v
+}
+
+// MsgIfVersion returns the version a received message is conditional on, or
+// ""
+func MsgIfVersion(msg *nats.Msg) string {
+	if msg.Header == nil {
+		return ""
+	}
+	return msg.Header.Get(HeaderIfVersion)
+}
+
+// setIfVersion sets the version header of msg from ctx if the connection
+// supports headers
+func setIfVersion(ctx context.Context, nc *nats.Conn, msg *nats.Msg) {
+	v := IfVersion(ctx)
+	if v == "" || !nc.HeadersSupported() {
+		return
+	}
+
+	if msg.Header == nil {
+		msg.Header = nats.Header{}
+	}
+
+	msg.Header.Set(HeaderIfVersion, v)
+}
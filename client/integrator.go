@@ -0,0 +1,340 @@
+package client
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/simpleiot/simpleiot/data"
+)
+
+// integratorPublishPeriod is how often the current energy values are
+// published while the client is running
+var integratorPublishPeriod = time.Minute
+
+// Integrator config. An integrator node integrates a power point (kW) on
+// its parent node into energy (kWh). Energy and cost are accumulated for
+// the current day and month, and daily and monthly totals are published
+// when the day or month ends (local time). The cost is calculated from the
+// tariff point (price per kWh) in effect when the energy was used, so a
+// rule or schedule can change the tariff for time-of-use pricing.
+type Integrator struct {
+	ID          string `node:"id"`
+	Parent      string `node:"parent"`
+	Description string `point:"description"`
+	PointType   string `point:"pointType"`
+	PointKey    string `point:"pointKey"`
+	// Scale converts the power point to kW, for example 0.001 for W.
+	// Default is 1.
+	Scale   float64 `point:"scale"`
+	Tariff  float64 `point:"tariff"`
+	Disable bool    `point:"disable"`
+	// the following values are written by the client. The totals can be
+	// set by the user, for example to a utility meter reading.
+	EnergyToday float64 `point:"energyToday"`
+	EnergyMonth float64 `point:"energyMonth"`
+	EnergyTotal float64 `point:"energyTotal"`
+	CostToday   float64 `point:"costToday"`
+	CostMonth   float64 `point:"costMonth"`
+	CostTotal   float64 `point:"costTotal"`
+}
+
+// integratorPeriod contains the energy and cost for a day or month
+type integratorPeriod struct {
+	start   time.Time
+	monthly bool
+	energy  float64
+	cost    float64
+}
+
+// integratorAccum integrates power samples into energy
+type integratorAccum struct {
+	last        time.Time
+	power       float64
+	tariff      float64
+	day         time.Time
+	energyToday float64
+	energyMonth float64
+	energyTotal float64
+	costToday   float64
+	costMonth   float64
+	costTotal   float64
+}
+
+func newIntegratorAccum(power, tariff float64, now time.Time) *integratorAccum {
+	return &integratorAccum{last: now, power: power, tariff: tariff,
+		day: startOfDay(now)}
+}
+
+// integrate adds the energy for a linear power change from the last sample
+// to power at t
+func (ia *integratorAccum) integrate(power float64, t time.Time) {
+	e := (ia.power + power) / 2 * t.Sub(ia.last).Hours()
+	c := e * ia.tariff
+
+	ia.energyToday += e
+	ia.energyMonth += e
+	ia.energyTotal += e
+	ia.costToday += c
+	ia.costMonth += c
+	ia.costTotal += c
+
+	ia.power = power
+	ia.last = t
+}
+
+// update processes a new power sample (kW) at time t and returns the totals
+// for any days and months that ended before t
+func (ia *integratorAccum) update(power float64, t time.Time) []integratorPeriod {
+	if t.Before(ia.last) {
+		t = ia.last
+	}
+
+	var ret []integratorPeriod
+
+	for {
+		next := ia.day.AddDate(0, 0, 1)
+		if t.Before(next) {
+			break
+		}
+
+		// interpolate the power at midnight
+		p := ia.power + (power-ia.power)*float64(next.Sub(ia.last))/
+			float64(t.Sub(ia.last))
+		ia.integrate(p, next)
+
+		ret = append(ret, integratorPeriod{start: ia.day,
+			energy: ia.energyToday, cost: ia.costToday})
+		ia.energyToday = 0
+		ia.costToday = 0
+
+		if next.Month() != ia.day.Month() {
+			month := time.Date(ia.day.Year(), ia.day.Month(), 1, 0, 0, 0, 0,
+				ia.day.Location())
+			ret = append(ret, integratorPeriod{start: month, monthly: true,
+				energy: ia.energyMonth, cost: ia.costMonth})
+			ia.energyMonth = 0
+			ia.costMonth = 0
+		}
+
+		ia.day = next
+	}
+
+	ia.integrate(power, t)
+
+	return ret
+}
+
+// IntegratorClient integrates power into energy for integrator nodes
+type IntegratorClient struct {
+	nc            *nats.Conn
+	config        Integrator
+	stop          chan struct{}
+	newPoints     chan NewPoints
+	newEdgePoints chan NewPoints
+	parentPoints  chan []data.Point
+}
+
+// NewIntegratorClient ...
+func NewIntegratorClient(nc *nats.Conn, config Integrator) Client {
+	return &IntegratorClient{
+		nc:            nc,
+		config:        config,
+		stop:          make(chan struct{}),
+		newPoints:     make(chan NewPoints),
+		newEdgePoints: make(chan NewPoints),
+		parentPoints:  make(chan []data.Point),
+	}
+}
+
+func (ic *IntegratorClient) scale(v float64) float64 {
+	if ic.config.Scale == 0 {
+		return v
+	}
+	return v * ic.config.Scale
+}
+
+// load creates the accumulator from the current parent and integrator node
+// points. Day and month values are only restored if they were written in
+// the current day or month.
+func (ic *IntegratorClient) load(now time.Time) *integratorAccum {
+	power := 0.0
+
+	nodes, err := GetNode(ic.nc, ic.config.Parent, "none")
+	if err == nil && len(nodes) > 0 {
+		p, ok := nodes[0].Points.Find(ic.config.PointType, ic.config.PointKey)
+		if ok {
+			power = ic.scale(p.Value)
+		}
+	}
+
+	ia := newIntegratorAccum(power, ic.config.Tariff, now)
+	ia.energyTotal = ic.config.EnergyTotal
+	ia.costTotal = ic.config.CostTotal
+
+	nodes, err = GetNode(ic.nc, ic.config.ID, "none")
+	if err == nil && len(nodes) > 0 {
+		p, ok := nodes[0].Points.Find(data.PointTypeEnergyToday, "")
+		if ok && !p.Time.Before(ia.day) {
+			ia.energyToday = ic.config.EnergyToday
+			ia.costToday = ic.config.CostToday
+		}
+
+		month := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+		p, ok = nodes[0].Points.Find(data.PointTypeEnergyMonth, "")
+		if ok && !p.Time.Before(month) {
+			ia.energyMonth = ic.config.EnergyMonth
+			ia.costMonth = ic.config.CostMonth
+		}
+	}
+
+	return ia
+}
+
+func (ic *IntegratorClient) publish(ia *integratorAccum, periods []integratorPeriod) {
+	var pts data.Points
+
+	for _, p := range periods {
+		typEnergy, typCost := data.PointTypeEnergyDaily, data.PointTypeCostDaily
+		if p.monthly {
+			typEnergy, typCost = data.PointTypeEnergyMonthly, data.PointTypeCostMonthly
+		}
+
+		pts = append(pts,
+			data.Point{Time: p.start, Type: typEnergy, Value: p.energy},
+			data.Point{Time: p.start, Type: typCost, Value: p.cost},
+		)
+	}
+
+	pts = append(pts,
+		data.Point{Time: ia.last, Type: data.PointTypeEnergyToday, Value: ia.energyToday},
+		data.Point{Time: ia.last, Type: data.PointTypeEnergyMonth, Value: ia.energyMonth},
+		data.Point{Time: ia.last, Type: data.PointTypeEnergyTotal, Value: ia.energyTotal},
+		data.Point{Time: ia.last, Type: data.PointTypeCostToday, Value: ia.costToday},
+		data.Point{Time: ia.last, Type: data.PointTypeCostMonth, Value: ia.costMonth},
+		data.Point{Time: ia.last, Type: data.PointTypeCostTotal, Value: ia.costTotal},
+	)
+
+	for i := range pts {
+		pts[i].Origin = ic.config.ID
+	}
+
+	err := SendNodePoints(ic.nc, ic.config.ID, pts, false)
+	if err != nil {
+		log.Printf("Integrator %v: error sending points: %v\n",
+			ic.config.Description, err)
+	}
+}
+
+// Start runs the main logic for this client and blocks until stopped
+func (ic *IntegratorClient) Start() error {
+	log.Println("Starting integrator client: ", ic.config.Description)
+
+	sub, err := ic.nc.Subscribe(SubjectNodePoints(ic.config.Parent), func(msg *nats.Msg) {
+		points, err := data.PbDecodePoints(msg.Data)
+		if err != nil {
+			log.Println("Integrator error decoding parent points: ", err)
+			return
+		}
+
+		ic.parentPoints <- points
+	})
+
+	if err != nil {
+		return fmt.Errorf("Integrator error subscribing to parent points: %v", err)
+	}
+
+	ia := ic.load(time.Now())
+
+	t := time.NewTicker(integratorPublishPeriod)
+
+	update := func(power float64, ts time.Time) {
+		if ic.config.Disable {
+			return
+		}
+
+		periods := ia.update(power, ts)
+		ic.publish(ia, periods)
+	}
+
+	update(ia.power, time.Now())
+
+done:
+	for {
+		select {
+		case <-ic.stop:
+			log.Println("Stopping integrator client: ", ic.config.Description)
+			break done
+		case <-t.C:
+			update(ia.power, time.Now())
+		case pts := <-ic.parentPoints:
+			for _, p := range pts {
+				if p.Type != ic.config.PointType || p.Key != ic.config.PointKey {
+					continue
+				}
+
+				ts := p.Time
+				if ts.IsZero() {
+					ts = time.Now()
+				}
+
+				update(ic.scale(p.Value), ts)
+			}
+		case pts := <-ic.newPoints:
+			err := data.MergePoints(pts.ID, pts.Points, &ic.config)
+			if err != nil {
+				log.Println("error merging new points: ", err)
+			}
+
+			for _, p := range pts.Points {
+				// points written by this client are ignored
+				if p.Origin == ic.config.ID {
+					continue
+				}
+
+				switch p.Type {
+				case data.PointTypeTariff:
+					// energy used so far is charged at the old tariff
+					update(ia.power, time.Now())
+					ia.tariff = p.Value
+				case data.PointTypeEnergyTotal:
+					ia.energyTotal = p.Value
+				case data.PointTypeCostTotal:
+					ia.costTotal = p.Value
+				case data.PointTypePointType, data.PointTypePointKey,
+					data.PointTypeScale, data.PointTypeDisable:
+					ia = ic.load(time.Now())
+				}
+			}
+
+		case pts := <-ic.newEdgePoints:
+			err := data.MergeEdgePoints(pts.ID, pts.Parent, pts.Points, &ic.config)
+			if err != nil {
+				log.Println("error merging new points: ", err)
+			}
+		}
+	}
+
+	// clean up
+	t.Stop()
+	sub.Unsubscribe()
+	return nil
+}
+
+// Stop sends a signal to the Start function to exit
+func (ic *IntegratorClient) Stop(err error) {
+	close(ic.stop)
+}
+
+// Points is called by the Manager when new points for this
+// node are received.
+func (ic *IntegratorClient) Points(nodeID string, points []data.Point) {
+	ic.newPoints <- NewPoints{nodeID, "", points}
+}
+
+// EdgePoints is called by the Manager when new edge points for this
+// node are received.
+func (ic *IntegratorClient) EdgePoints(nodeID, parentID string, points []data.Point) {
+	ic.newEdgePoints <- NewPoints{nodeID, parentID, points}
+}
@@ -0,0 +1,57 @@
+package client
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestIntegratorAccum(t *testing.T) {
+	start := time.Date(2022, 10, 31, 22, 0, 0, 0, time.UTC)
+	ia := newIntegratorAccum(2, 0.1, start)
+
+	near := func(a, b float64) bool {
+		return math.Abs(a-b) < 1e-9
+	}
+
+	// constant 2kW for 1h
+	if periods := ia.update(2, start.Add(time.Hour)); len(periods) != 0 {
+		t.Fatal("unexpected rollover: ", periods)
+	}
+
+	if !near(ia.energyToday, 2) || !near(ia.costToday, 0.2) {
+		t.Errorf("wrong energy after 1h: %v, cost: %v", ia.energyToday, ia.costToday)
+	}
+
+	// ramp from 2kW to 6kW over 2h across midnight, which is also the end
+	// of the month. The power at midnight is 4kW.
+	ia.tariff = 0.2
+	periods := ia.update(6, start.Add(3*time.Hour))
+	if len(periods) != 2 {
+		t.Fatal("expected day and month periods, got: ", periods)
+	}
+
+	day, month := periods[0], periods[1]
+	if day.monthly || !day.start.Equal(time.Date(2022, 10, 31, 0, 0, 0, 0, time.UTC)) {
+		t.Error("wrong day period: ", day)
+	}
+
+	// 2kWh at 0.1 + 3kWh at 0.2
+	if !near(day.energy, 5) || !near(day.cost, 0.8) {
+		t.Errorf("wrong day totals, energy: %v, cost: %v", day.energy, day.cost)
+	}
+
+	if !month.monthly || !month.start.Equal(time.Date(2022, 10, 1, 0, 0, 0, 0, time.UTC)) ||
+		!near(month.energy, 5) {
+		t.Error("wrong month period: ", month)
+	}
+
+	if !near(ia.energyToday, 5) || !near(ia.energyMonth, 5) || !near(ia.energyTotal, 10) {
+		t.Errorf("wrong energy after midnight, today: %v, month: %v, total: %v",
+			ia.energyToday, ia.energyMonth, ia.energyTotal)
+	}
+
+	if !near(ia.costTotal, 1.8) {
+		t.Error("wrong total cost: ", ia.costTotal)
+	}
+}
@@ -0,0 +1,299 @@
+package client
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/simpleiot/simpleiot/data"
+	"github.com/simpleiot/simpleiot/knx"
+)
+
+// Knx config. A KNX node connects to a KNX installation through a
+// KNXnet/IP tunneling gateway (uri, host:port). Group address nodes map
+// group addresses to points.
+type Knx struct {
+	ID          string     `node:"id"`
+	Parent      string     `node:"parent"`
+	Description string     `point:"description"`
+	URI         string     `point:"uri"`
+	Disable     bool       `point:"disable"`
+	Groups      []KnxGroup `child:"knxGroup"`
+}
+
+// KnxGroup is a KNX group address (for example 1/2/3). Telegrams sent to
+// the group on the bus update the value point, which is decoded with the
+// datapoint type (dpt, for example 9.001). Setting the valueSet point
+// writes the value to the group unless the group is read only.
+type KnxGroup struct {
+	ID          string  `node:"id"`
+	Parent      string  `node:"parent"`
+	Description string  `point:"description"`
+	Address     string  `point:"address"`
+	DPT         string  `point:"dpt"`
+	Value       float64 `point:"value"`
+	ValueSet    float64 `point:"valueSet"`
+	ReadOnly    bool    `point:"readOnly"`
+	Disable     bool    `point:"disable"`
+}
+
+// knx timing
+const (
+	knxTimeout   = 3 * time.Second
+	knxHeartbeat = 60 * time.Second
+	knxRetry     = 10 * time.Second
+)
+
+// knxGroupValue decodes a group telegram for a group. ok is false if the
+// telegram is not a value for the group.
+func knxGroupValue(g KnxGroup, ev knx.GroupEvent) (float64, bool, error) {
+	if g.Disable || (ev.APCI != knx.APCIGroupValueWrite &&
+		ev.APCI != knx.APCIGroupValueResponse) {
+		return 0, false, nil
+	}
+
+	ga, err := knx.ParseGroupAddress(g.Address)
+	if err != nil || ga != ev.Destination {
+		return 0, false, nil
+	}
+
+	v, err := knx.DecodeDPT(g.DPT, ev.Data)
+	if err != nil {
+		return 0, false, err
+	}
+
+	return v, true, nil
+}
+
+// KnxClient is a SIOT client used to communicate with KNX devices
+type KnxClient struct {
+	nc            *nats.Conn
+	config        Knx
+	stop          chan struct{}
+	newPoints     chan NewPoints
+	newEdgePoints chan NewPoints
+	tunnel        *knx.Tunnel
+}
+
+// NewKnxClient ...
+func NewKnxClient(nc *nats.Conn, config Knx) Client {
+	return &KnxClient{
+		nc:            nc,
+		config:        config,
+		stop:          make(chan struct{}),
+		newPoints:     make(chan NewPoints),
+		newEdgePoints: make(chan NewPoints),
+	}
+}
+
+func (kc *KnxClient) connect() error {
+	if kc.config.URI == "" {
+		return errors.New("uri must be set")
+	}
+
+	t, err := knx.Dial(kc.config.URI, knxTimeout)
+	if err != nil {
+		return err
+	}
+
+	kc.tunnel = t
+	log.Printf("KNX %v: connected to %v\n", kc.config.Description, kc.config.URI)
+
+	// read the current value of all groups
+	for _, g := range kc.config.Groups {
+		if g.Disable {
+			continue
+		}
+
+		ga, err := knx.ParseGroupAddress(g.Address)
+		if err != nil {
+			log.Printf("KNX %v: %v\n", g.Description, err)
+			continue
+		}
+
+		err = t.Read(ga)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (kc *KnxClient) disconnect() {
+	if kc.tunnel != nil {
+		kc.tunnel.Close()
+		kc.tunnel = nil
+	}
+}
+
+func (kc *KnxClient) handleEvent(ev knx.GroupEvent) {
+	for i := range kc.config.Groups {
+		g := &kc.config.Groups[i]
+
+		v, ok, err := knxGroupValue(*g, ev)
+		if err != nil {
+			log.Printf("KNX %v: error decoding %v: %v\n", g.Description,
+				ev.Destination, err)
+			continue
+		}
+
+		if !ok {
+			continue
+		}
+
+		g.Value = v
+		err = SendNodePoint(kc.nc, g.ID, data.Point{Type: data.PointTypeValue,
+			Value: v}, false)
+		if err != nil {
+			log.Println("KNX error sending point: ", err)
+		}
+	}
+}
+
+func (kc *KnxClient) write(g KnxGroup) error {
+	if kc.tunnel == nil {
+		return errors.New("not connected")
+	}
+
+	ga, err := knx.ParseGroupAddress(g.Address)
+	if err != nil {
+		return err
+	}
+
+	d, err := knx.EncodeDPT(g.DPT, g.ValueSet)
+	if err != nil {
+		return err
+	}
+
+	return kc.tunnel.Write(ga, d)
+}
+
+// Start runs the main logic for this client and blocks until stopped
+func (kc *KnxClient) Start() error {
+	log.Println("Starting KNX client: ", kc.config.Description)
+
+	heartbeat := time.NewTicker(knxHeartbeat)
+	retry := time.NewTimer(0)
+
+	// events is nil until connected and is closed when the connection is
+	// lost
+	var events <-chan knx.GroupEvent
+
+	reconnect := func() {
+		kc.disconnect()
+		events = nil
+		retry.Stop()
+
+		if kc.config.Disable {
+			log.Printf("KNX %v: disabled\n", kc.config.Description)
+			return
+		}
+
+		err := kc.connect()
+		if err != nil {
+			log.Printf("KNX %v: error connecting: %v\n", kc.config.Description, err)
+			kc.disconnect()
+			retry.Reset(knxRetry)
+			return
+		}
+
+		events = kc.tunnel.Events()
+	}
+
+done:
+	for {
+		select {
+		case <-kc.stop:
+			log.Println("Stopping KNX client: ", kc.config.Description)
+			break done
+		case <-retry.C:
+			reconnect()
+		case ev, ok := <-events:
+			if ok {
+				kc.handleEvent(ev)
+				continue
+			}
+
+			<-kc.tunnel.Done()
+			log.Printf("KNX %v: connection lost: %v\n", kc.config.Description,
+				kc.tunnel.Err())
+			kc.disconnect()
+			events = nil
+			retry.Reset(knxRetry)
+		case <-heartbeat.C:
+			if kc.tunnel == nil {
+				continue
+			}
+
+			err := kc.tunnel.Heartbeat()
+			if err != nil {
+				log.Printf("KNX %v: heartbeat error: %v\n", kc.config.Description, err)
+				reconnect()
+			}
+		case pts := <-kc.newPoints:
+			err := data.MergePoints(pts.ID, pts.Points, &kc.config)
+			if err != nil {
+				log.Println("error merging new points: ", err)
+			}
+
+			if pts.ID == kc.config.ID {
+				for _, p := range pts.Points {
+					switch p.Type {
+					case data.PointTypeURI, data.PointTypeDisable:
+						reconnect()
+					}
+				}
+				continue
+			}
+
+			for _, p := range pts.Points {
+				if p.Type != data.PointTypeValueSet {
+					continue
+				}
+
+				for _, g := range kc.config.Groups {
+					if g.ID != pts.ID || g.ReadOnly || g.Disable {
+						continue
+					}
+
+					err := kc.write(g)
+					if err != nil {
+						log.Printf("KNX %v: error writing %v: %v\n",
+							g.Description, g.Address, err)
+					}
+				}
+			}
+
+		case pts := <-kc.newEdgePoints:
+			err := data.MergeEdgePoints(pts.ID, pts.Parent, pts.Points, &kc.config)
+			if err != nil {
+				log.Println("error merging new points: ", err)
+			}
+		}
+	}
+
+	// clean up
+	heartbeat.Stop()
+	retry.Stop()
+	kc.disconnect()
+	return nil
+}
+
+// Stop sends a signal to the Start function to exit
+func (kc *KnxClient) Stop(err error) {
+	close(kc.stop)
+}
+
+// Points is called by the Manager when new points for this
+// node are received.
+func (kc *KnxClient) Points(nodeID string, points []data.Point) {
+	kc.newPoints <- NewPoints{nodeID, "", points}
+}
+
+// EdgePoints is called by the Manager when new edge points for this
+// node are received.
+func (kc *KnxClient) EdgePoints(nodeID, parentID string, points []data.Point) {
+	kc.newEdgePoints <- NewPoints{nodeID, parentID, points}
+}
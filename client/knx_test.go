@@ -0,0 +1,37 @@
+package client
+
+import (
+	"testing"
+
+	"github.com/simpleiot/simpleiot/knx"
+)
+
+func TestKnxGroupValue(t *testing.T) {
+	g := KnxGroup{Address: "1/2/3", DPT: "9.001"}
+	ga, _ := knx.ParseGroupAddress("1/2/3")
+	d, _ := knx.EncodeDPT("9.001", 21.5)
+
+	v, ok, err := knxGroupValue(g, knx.GroupEvent{APCI: knx.APCIGroupValueWrite,
+		Destination: ga, Data: d})
+	if err != nil || !ok || v != 21.5 {
+		t.Errorf("write: got %v %v %v", v, ok, err)
+	}
+
+	_, ok, _ = knxGroupValue(g, knx.GroupEvent{APCI: knx.APCIGroupValueResponse,
+		Destination: ga + 1, Data: d})
+	if ok {
+		t.Error("telegram for another group should be ignored")
+	}
+
+	_, ok, _ = knxGroupValue(g, knx.GroupEvent{APCI: knx.APCIGroupValueRead,
+		Destination: ga})
+	if ok {
+		t.Error("group reads should be ignored")
+	}
+
+	_, _, err = knxGroupValue(g, knx.GroupEvent{APCI: knx.APCIGroupValueWrite,
+		Destination: ga, Data: []byte{1}})
+	if err == nil {
+		t.Error("expected error decoding short data")
+	}
+}
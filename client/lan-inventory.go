@@ -0,0 +1,516 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nats-io/nats.go"
+	"github.com/simpleiot/simpleiot/data"
+	"github.com/simpleiot/simpleiot/system"
+	"golang.org/x/net/dns/dnsmessage"
+	"golang.org/x/net/ipv4"
+)
+
+// LanInventory config. A LAN inventory node scans the IPv4 subnet of
+// interface (default the first interface that is up and has an IPv4
+// address) every samplePeriod seconds (default 300) and maintains a LAN host
+// child node for each device found, identified by MAC address.
+type LanInventory struct {
+	ID           string    `node:"id"`
+	Parent       string    `node:"parent"`
+	Description  string    `point:"description"`
+	Interface    string    `point:"interface"`
+	SamplePeriod float64   `point:"samplePeriod"`
+	Disable      bool      `point:"disable"`
+	Hosts        []LanHost `child:"lanHost"`
+}
+
+// LanHost is a device found by a LAN inventory scan. The IP address,
+// hostname, and online points are updated after each scan.
+type LanHost struct {
+	ID          string `node:"id"`
+	Parent      string `node:"parent"`
+	Description string `point:"description"`
+	MAC         string `point:"mac"`
+	IPAddress   string `point:"ipAddress"`
+	Hostname    string `point:"hostname"`
+	Online      bool   `point:"online"`
+}
+
+// LAN scan timing and limits
+const (
+	// the kernel takes several seconds to mark stale ARP entries as failed
+	lanProbeWait   = 8 * time.Second
+	lanNameTimeout = 2 * time.Second
+	// subnets larger than this are limited to the /24 of the interface
+	lanMaxPrefix = 22
+)
+
+// lanSeen is a host found by a scan
+type lanSeen struct {
+	mac      string
+	ip       string
+	hostname string
+}
+
+// lanSubnet returns the interface and IPv4 subnet to scan
+func lanSubnet(name string) (*net.Interface, *net.IPNet, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for i := range ifaces {
+		iface := &ifaces[i]
+
+		if name != "" && iface.Name != name {
+			continue
+		}
+
+		if name == "" && (iface.Flags&net.FlagUp == 0 ||
+			iface.Flags&net.FlagLoopback != 0) {
+			continue
+		}
+
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+
+		for _, a := range addrs {
+			ipnet, ok := a.(*net.IPNet)
+			if !ok || ipnet.IP.To4() == nil {
+				continue
+			}
+			return iface, ipnet, nil
+		}
+	}
+
+	if name != "" {
+		return nil, nil, fmt.Errorf("interface %v does not have an IPv4 address", name)
+	}
+
+	return nil, nil, errors.New("no interface with an IPv4 address found")
+}
+
+// lanAddresses returns the host addresses in a subnet, except ip itself
+func lanAddresses(ipnet *net.IPNet) []net.IP {
+	ip := ipnet.IP.To4()
+	mask := ipnet.Mask
+
+	if ones, bits := mask.Size(); bits != 32 || ones < lanMaxPrefix {
+		mask = net.CIDRMask(24, 32)
+	}
+
+	ones, _ := mask.Size()
+	if ones > 30 {
+		return nil
+	}
+
+	network := ip.Mask(mask)
+	count := 1 << (32 - ones)
+
+	var ret []net.IP
+
+	// the network and broadcast addresses are skipped
+	for i := 1; i < count-1; i++ {
+		a := make(net.IP, 4)
+		copy(a, network)
+		n := uint32(a[0])<<24 | uint32(a[1])<<16 | uint32(a[2])<<8 | uint32(a[3])
+		n += uint32(i)
+		a[0], a[1], a[2], a[3] = byte(n>>24), byte(n>>16), byte(n>>8), byte(n)
+
+		if !a.Equal(ip) {
+			ret = append(ret, a)
+		}
+	}
+
+	return ret
+}
+
+// lanProbe sends a UDP datagram to the discard port of each address so that
+// the kernel resolves the MAC addresses with ARP
+func lanProbe(ips []net.IP) error {
+	conn, err := net.ListenUDP("udp4", nil)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	for _, ip := range ips {
+		// errors for single hosts (for example no route) are ignored
+		_, _ = conn.WriteToUDP([]byte{0}, &net.UDPAddr{IP: ip, Port: 9})
+		time.Sleep(time.Millisecond)
+	}
+
+	return nil
+}
+
+// lanReverseName returns the in-addr.arpa name for an IPv4 address
+func lanReverseName(ip string) string {
+	p := strings.Split(ip, ".")
+	if len(p) != 4 {
+		return ""
+	}
+	return fmt.Sprintf("%v.%v.%v.%v.in-addr.arpa.", p[3], p[2], p[1], p[0])
+}
+
+// lanMDNSNames looks up the names of hosts with mDNS reverse (PTR) queries.
+// The queries ask for unicast responses.
+func lanMDNSNames(iface *net.Interface, ips []string, timeout time.Duration) map[string]string {
+	ret := make(map[string]string)
+
+	conn, err := net.ListenUDP("udp4", nil)
+	if err != nil {
+		return ret
+	}
+	defer conn.Close()
+
+	if iface != nil {
+		_ = ipv4.NewPacketConn(conn).SetMulticastInterface(iface)
+	}
+
+	names := make(map[string]string)
+	dst := &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353}
+
+	for _, ip := range ips {
+		rn := lanReverseName(ip)
+		name, err := dnsmessage.NewName(rn)
+		if err != nil {
+			continue
+		}
+		names[rn] = ip
+
+		msg := dnsmessage.Message{Questions: []dnsmessage.Question{{
+			Name: name, Type: dnsmessage.TypePTR,
+			// the top bit of the class requests a unicast response
+			Class: dnsmessage.ClassINET | 0x8000,
+		}}}
+
+		b, err := msg.Pack()
+		if err != nil {
+			continue
+		}
+
+		if _, err := conn.WriteToUDP(b, dst); err != nil {
+			return ret
+		}
+	}
+
+	_ = conn.SetReadDeadline(time.Now().Add(timeout))
+
+	buf := make([]byte, 9000)
+
+	for len(ret) < len(ips) {
+		n, _, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			break
+		}
+
+		var msg dnsmessage.Message
+		if err := msg.Unpack(buf[:n]); err != nil {
+			continue
+		}
+
+		for _, a := range msg.Answers {
+			ptr, ok := a.Body.(*dnsmessage.PTRResource)
+			if !ok {
+				continue
+			}
+
+			ip, ok := names[strings.ToLower(a.Header.Name.String())]
+			if !ok {
+				continue
+			}
+
+			ret[ip] = strings.TrimSuffix(ptr.PTR.String(), ".")
+		}
+	}
+
+	return ret
+}
+
+// lanScan scans the subnet of an interface and returns the hosts found
+func lanScan(ifaceName string) ([]lanSeen, error) {
+	iface, ipnet, err := lanSubnet(ifaceName)
+	if err != nil {
+		return nil, err
+	}
+
+	err = lanProbe(lanAddresses(ipnet))
+	if err != nil {
+		return nil, err
+	}
+
+	time.Sleep(lanProbeWait)
+
+	entries, err := system.ReadARPTable()
+	if err != nil {
+		return nil, err
+	}
+
+	// not nil, so an empty scan is not handled as a failed scan
+	ret := []lanSeen{}
+	var ips []string
+
+	for _, e := range entries {
+		ip := net.ParseIP(e.IP)
+		if e.Interface != iface.Name || ip == nil || !ipnet.Contains(ip) {
+			continue
+		}
+		ret = append(ret, lanSeen{mac: strings.ToLower(e.MAC), ip: e.IP})
+		ips = append(ips, e.IP)
+	}
+
+	names := lanMDNSNames(iface, ips, lanNameTimeout)
+
+	for i := range ret {
+		if n, ok := names[ret[i].ip]; ok {
+			ret[i].hostname = n
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		addrs, err := net.DefaultResolver.LookupAddr(ctx, ret[i].ip)
+		cancel()
+		if err == nil && len(addrs) > 0 {
+			ret[i].hostname = strings.TrimSuffix(addrs[0], ".")
+		}
+	}
+
+	return ret, nil
+}
+
+// lanUpdate compares scan results with the known hosts. It returns the new
+// hosts, and the points that changed for known hosts by node ID. Known hosts
+// that were not found are set offline. Hostnames are kept if a scan does
+// not find one.
+func lanUpdate(hosts []LanHost, seen []lanSeen, parent string,
+	now time.Time) ([]LanHost, map[string]data.Points) {
+	var added []LanHost
+	changes := make(map[string]data.Points)
+
+	found := make(map[string]lanSeen)
+	for _, s := range seen {
+		found[s.mac] = s
+	}
+
+	known := make(map[string]bool)
+
+	for _, h := range hosts {
+		mac := strings.ToLower(h.MAC)
+		known[mac] = true
+
+		s, ok := found[mac]
+
+		var pts data.Points
+		if ok != h.Online {
+			pts = append(pts, data.Point{Time: now, Type: data.PointTypeOnline,
+				Value: data.BoolToFloat(ok)})
+		}
+		if ok && s.ip != h.IPAddress {
+			pts = append(pts, data.Point{Time: now, Type: data.PointTypeIPAddress,
+				Text: s.ip})
+		}
+		if ok && s.hostname != "" && s.hostname != h.Hostname {
+			pts = append(pts, data.Point{Time: now, Type: data.PointTypeHostname,
+				Text: s.hostname})
+		}
+
+		if len(pts) > 0 {
+			changes[h.ID] = pts
+		}
+	}
+
+	for _, s := range seen {
+		if known[s.mac] {
+			continue
+		}
+		known[s.mac] = true
+
+		desc := s.hostname
+		if desc == "" {
+			desc = s.ip
+		}
+
+		added = append(added, LanHost{
+			ID:          uuid.New().String(),
+			Parent:      parent,
+			Description: desc,
+			MAC:         s.mac,
+			IPAddress:   s.ip,
+			Hostname:    s.hostname,
+			Online:      true,
+		})
+	}
+
+	return added, changes
+}
+
+// LanInventoryClient is a SIOT client used to find devices on the LAN
+type LanInventoryClient struct {
+	nc            *nats.Conn
+	config        LanInventory
+	stop          chan struct{}
+	newPoints     chan NewPoints
+	newEdgePoints chan NewPoints
+	results       chan []lanSeen
+}
+
+// NewLanInventoryClient ...
+func NewLanInventoryClient(nc *nats.Conn, config LanInventory) Client {
+	return &LanInventoryClient{
+		nc:            nc,
+		config:        config,
+		stop:          make(chan struct{}),
+		newPoints:     make(chan NewPoints),
+		newEdgePoints: make(chan NewPoints),
+		results:       make(chan []lanSeen),
+	}
+}
+
+// update creates nodes for new hosts and sends changed points
+func (lic *LanInventoryClient) update(seen []lanSeen) {
+	added, changes := lanUpdate(lic.config.Hosts, seen, lic.config.ID, time.Now())
+
+	for id, pts := range changes {
+		err := SendNodePoints(lic.nc, id, pts, false)
+		if err != nil {
+			log.Println("LAN inventory error sending points: ", err)
+			continue
+		}
+
+		// points sent without an origin are not sent back to this
+		// client, so the config is updated here
+		err = data.MergePoints(id, pts, &lic.config)
+		if err != nil {
+			log.Println("LAN inventory error merging points: ", err)
+		}
+	}
+
+	for _, h := range added {
+		// nodes sent without an origin do not restart this client, so the
+		// host is added to the config here
+		err := SendNodeType(lic.nc, h, "")
+		if err != nil {
+			log.Println("LAN inventory error creating host node: ", err)
+			continue
+		}
+
+		lic.config.Hosts = append(lic.config.Hosts, h)
+	}
+}
+
+// Start runs the main logic for this client and blocks until stopped
+func (lic *LanInventoryClient) Start() error {
+	log.Println("Starting LAN inventory client: ", lic.config.Description)
+
+	t := time.NewTicker(time.Hour)
+	t.Stop()
+
+	scanning := false
+
+	scan := func() {
+		if scanning {
+			return
+		}
+		scanning = true
+
+		go func(iface string) {
+			seen, err := lanScan(iface)
+			if err != nil {
+				log.Printf("LAN inventory %v: scan error: %v\n", lic.config.Description, err)
+				// hosts are not set offline if the scan failed
+				seen = nil
+			}
+
+			select {
+			case lic.results <- seen:
+			case <-lic.stop:
+			}
+		}(lic.config.Interface)
+	}
+
+	setup := func() {
+		t.Stop()
+
+		if lic.config.Disable {
+			log.Printf("LAN inventory %v: disabled\n", lic.config.Description)
+			return
+		}
+
+		period := lic.config.SamplePeriod
+		if period <= 0 {
+			period = 300
+		}
+
+		t.Reset(time.Duration(period * float64(time.Second)))
+		scan()
+	}
+
+	setup()
+
+done:
+	for {
+		select {
+		case <-lic.stop:
+			log.Println("Stopping LAN inventory client: ", lic.config.Description)
+			break done
+		case <-t.C:
+			scan()
+		case seen := <-lic.results:
+			scanning = false
+			if seen != nil && !lic.config.Disable {
+				lic.update(seen)
+			}
+		case pts := <-lic.newPoints:
+			err := data.MergePoints(pts.ID, pts.Points, &lic.config)
+			if err != nil {
+				log.Println("error merging new points: ", err)
+			}
+
+			if pts.ID == lic.config.ID {
+				for _, p := range pts.Points {
+					switch p.Type {
+					case data.PointTypeSamplePeriod, data.PointTypeInterface,
+						data.PointTypeDisable:
+						setup()
+					}
+				}
+			}
+
+		case pts := <-lic.newEdgePoints:
+			err := data.MergeEdgePoints(pts.ID, pts.Parent, pts.Points, &lic.config)
+			if err != nil {
+				log.Println("error merging new points: ", err)
+			}
+		}
+	}
+
+	// clean up
+	t.Stop()
+	return nil
+}
+
+// Stop sends a signal to the Start function to exit
+func (lic *LanInventoryClient) Stop(err error) {
+	close(lic.stop)
+}
+
+// Points is called by the Manager when new points for this
+// node are received.
+func (lic *LanInventoryClient) Points(nodeID string, points []data.Point) {
+	lic.newPoints <- NewPoints{nodeID, "", points}
+}
+
+// EdgePoints is called by the Manager when new edge points for this
+// node are received.
+func (lic *LanInventoryClient) EdgePoints(nodeID, parentID string, points []data.Point) {
+	lic.newEdgePoints <- NewPoints{nodeID, parentID, points}
+}
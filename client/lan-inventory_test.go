@@ -0,0 +1,76 @@
+package client
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/simpleiot/simpleiot/data"
+)
+
+func TestLanAddresses(t *testing.T) {
+	_, ipnet, _ := net.ParseCIDR("192.168.1.0/29")
+	ipnet.IP = net.ParseIP("192.168.1.3")
+
+	addrs := lanAddresses(ipnet)
+
+	exp := []string{"192.168.1.1", "192.168.1.2", "192.168.1.4", "192.168.1.5",
+		"192.168.1.6"}
+
+	if len(addrs) != len(exp) {
+		t.Fatalf("expected %v, got %v", exp, addrs)
+	}
+
+	for i := range exp {
+		if addrs[i].String() != exp[i] {
+			t.Errorf("expected %v, got %v", exp[i], addrs[i])
+		}
+	}
+
+	// large subnets are limited to a /24
+	_, ipnet, _ = net.ParseCIDR("10.0.0.0/8")
+	ipnet.IP = net.ParseIP("10.1.2.3")
+	addrs = lanAddresses(ipnet)
+	if len(addrs) != 253 || addrs[0].String() != "10.1.2.1" {
+		t.Errorf("wrong addresses for large subnet: %v %v", len(addrs), addrs[0])
+	}
+}
+
+func TestLanReverseName(t *testing.T) {
+	if n := lanReverseName("192.168.1.20"); n != "20.1.168.192.in-addr.arpa." {
+		t.Error("wrong reverse name: ", n)
+	}
+}
+
+func TestLanUpdate(t *testing.T) {
+	hosts := []LanHost{
+		{ID: "h1", MAC: "aa:bb:cc:00:00:01", IPAddress: "192.168.1.10",
+			Hostname: "plc.local", Online: true},
+		{ID: "h2", MAC: "aa:bb:cc:00:00:02", IPAddress: "192.168.1.11", Online: true},
+	}
+
+	seen := []lanSeen{
+		{mac: "aa:bb:cc:00:00:01", ip: "192.168.1.12"},
+		{mac: "aa:bb:cc:00:00:03", ip: "192.168.1.13", hostname: "camera.local"},
+	}
+
+	added, changes := lanUpdate(hosts, seen, "inv", time.Now())
+	h1, h2 := changes["h1"], changes["h2"]
+
+	if len(added) != 1 || added[0].MAC != "aa:bb:cc:00:00:03" ||
+		added[0].Description != "camera.local" || added[0].Parent != "inv" {
+		t.Error("wrong added hosts: ", added)
+	}
+
+	if ip, _ := h1.Text(data.PointTypeIPAddress, ""); ip != "192.168.1.12" {
+		t.Error("IP address change not found: ", h1)
+	}
+
+	if _, ok := h1.Find(data.PointTypeHostname, ""); ok {
+		t.Error("hostname should be kept if it was not found")
+	}
+
+	if v, ok := h2.Value(data.PointTypeOnline, ""); !ok || v != 0 {
+		t.Error("h2 should be offline: ", h2)
+	}
+}
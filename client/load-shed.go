@@ -0,0 +1,327 @@
+package client
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/simpleiot/simpleiot/data"
+)
+
+// LoadShed config. A load shed node monitors a total power point and sheds
+// loads in priority order when the power exceeds limit. Shed loads are
+// restored, most important first, when the power drops below restoreLimit.
+// One load is shed or restored every delay seconds so the power can settle
+// between steps.
+type LoadShed struct {
+	ID          string `node:"id"`
+	Parent      string `node:"parent"`
+	Description string `point:"description"`
+	// NodeID is the node with the power point, default is the parent node
+	NodeID    string  `point:"nodeID"`
+	PointType string  `point:"pointType"`
+	PointKey  string  `point:"pointKey"`
+	Limit     float64 `point:"limit"`
+	// RestoreLimit defaults to 90% of limit
+	RestoreLimit float64    `point:"restoreLimit"`
+	Delay        float64    `point:"delay"`
+	Disable      bool       `point:"disable"`
+	LoadsShed    int        `point:"loadsShed"`
+	Loads        []ShedLoad `child:"shedLoad"`
+}
+
+// ShedLoad is a load that can be shed by a load shed node. The load is shed
+// by writing 0 to the point and restored by writing 1 (reversed if invert
+// is set). Loads with a lower priority are shed first. If power is set to
+// the expected load power, the load is only restored if the total power
+// will stay below the restore limit.
+type ShedLoad struct {
+	ID          string  `node:"id"`
+	Parent      string  `node:"parent"`
+	Description string  `point:"description"`
+	NodeID      string  `point:"nodeID"`
+	PointType   string  `point:"pointType"`
+	PointKey    string  `point:"pointKey"`
+	Priority    int     `point:"priority"`
+	Power       float64 `point:"power"`
+	Invert      bool    `point:"invert"`
+	Disable     bool    `point:"disable"`
+	Shed        bool    `point:"shed"`
+}
+
+// loadShedStep returns the index of the load to shed or restore for the
+// current power, or -1 if nothing needs to be done
+func loadShedStep(config LoadShed, power float64) (int, bool) {
+	if config.Limit <= 0 {
+		return -1, false
+	}
+
+	restoreLimit := config.RestoreLimit
+	if restoreLimit <= 0 {
+		restoreLimit = config.Limit * 0.9
+	}
+
+	if power > config.Limit {
+		// shed the least important load that is not shed
+		best := -1
+		for i, l := range config.Loads {
+			if l.Disable || l.Shed {
+				continue
+			}
+			if best < 0 || l.Priority < config.Loads[best].Priority {
+				best = i
+			}
+		}
+		return best, true
+	}
+
+	if power < restoreLimit {
+		// restore the most important load that is shed
+		best := -1
+		for i, l := range config.Loads {
+			if !l.Shed {
+				continue
+			}
+			if best < 0 || l.Priority > config.Loads[best].Priority {
+				best = i
+			}
+		}
+
+		if best >= 0 && power+config.Loads[best].Power >= restoreLimit {
+			return -1, false
+		}
+
+		return best, false
+	}
+
+	return -1, false
+}
+
+// LoadShedClient controls load shed nodes
+type LoadShedClient struct {
+	nc            *nats.Conn
+	config        LoadShed
+	stop          chan struct{}
+	newPoints     chan NewPoints
+	newEdgePoints chan NewPoints
+	powerPoints   chan []data.Point
+}
+
+// NewLoadShedClient ...
+func NewLoadShedClient(nc *nats.Conn, config LoadShed) Client {
+	return &LoadShedClient{
+		nc:            nc,
+		config:        config,
+		stop:          make(chan struct{}),
+		newPoints:     make(chan NewPoints),
+		newEdgePoints: make(chan NewPoints),
+		powerPoints:   make(chan []data.Point),
+	}
+}
+
+func (lsc *LoadShedClient) powerNode() string {
+	if lsc.config.NodeID != "" {
+		return lsc.config.NodeID
+	}
+	return lsc.config.Parent
+}
+
+// setLoad sheds or restores a load
+func (lsc *LoadShedClient) setLoad(i int, shed bool) error {
+	l := &lsc.config.Loads[i]
+
+	value := 1.0
+	if shed != l.Invert {
+		value = 0
+	}
+
+	err := SendNodePoint(lsc.nc, l.NodeID, data.Point{Type: l.PointType,
+		Key: l.PointKey, Value: value, Origin: lsc.config.ID}, true)
+	if err != nil {
+		return fmt.Errorf("Error setting load %v: %v", l.Description, err)
+	}
+
+	l.Shed = shed
+
+	err = SendNodePoint(lsc.nc, l.ID, data.Point{Type: data.PointTypeShed,
+		Value: data.BoolToFloat(shed)}, true)
+	if err != nil {
+		return err
+	}
+
+	count := 0
+	for _, l := range lsc.config.Loads {
+		if l.Shed {
+			count++
+		}
+	}
+
+	lsc.config.LoadsShed = count
+
+	return SendNodePoint(lsc.nc, lsc.config.ID, data.Point{
+		Type: data.PointTypeLoadsShed, Value: float64(count)}, false)
+}
+
+// Start runs the main logic for this client and blocks until stopped
+func (lsc *LoadShedClient) Start() error {
+	log.Println("Starting load shed client: ", lsc.config.Description)
+
+	subscribe := func() (*nats.Subscription, error) {
+		return lsc.nc.Subscribe(SubjectNodePoints(lsc.powerNode()), func(msg *nats.Msg) {
+			points, err := data.PbDecodePoints(msg.Data)
+			if err != nil {
+				log.Println("Load shed error decoding power points: ", err)
+				return
+			}
+
+			lsc.powerPoints <- points
+		})
+	}
+
+	sub, err := subscribe()
+	if err != nil {
+		return fmt.Errorf("Load shed error subscribing to power points: %v", err)
+	}
+
+	var power float64
+	havePower := false
+	var lastStep time.Time
+
+	readPower := func() {
+		havePower = false
+		nodes, err := GetNode(lsc.nc, lsc.powerNode(), "none")
+		if err == nil && len(nodes) > 0 {
+			power, havePower = nodes[0].Points.Value(lsc.config.PointType,
+				lsc.config.PointKey)
+		}
+	}
+
+	readPower()
+
+	t := time.NewTicker(time.Second)
+
+	evaluate := func() {
+		if lsc.config.Disable || !havePower {
+			return
+		}
+
+		delay := time.Duration(lsc.config.Delay * float64(time.Second))
+		if time.Since(lastStep) < delay {
+			return
+		}
+
+		i, shed := loadShedStep(lsc.config, power)
+		if i < 0 {
+			return
+		}
+
+		l := lsc.config.Loads[i]
+		if shed {
+			log.Printf("Load shed %v: power %v > %v, shedding %v\n",
+				lsc.config.Description, power, lsc.config.Limit, l.Description)
+		} else {
+			log.Printf("Load shed %v: restoring %v\n", lsc.config.Description,
+				l.Description)
+		}
+
+		err := lsc.setLoad(i, shed)
+		if err != nil {
+			log.Printf("Load shed %v: %v\n", lsc.config.Description, err)
+		}
+
+		lastStep = time.Now()
+	}
+
+	// restoreAll restores all shed loads, for example when the
+	// controller is disabled
+	restoreAll := func() {
+		for i, l := range lsc.config.Loads {
+			if !l.Shed {
+				continue
+			}
+
+			err := lsc.setLoad(i, false)
+			if err != nil {
+				log.Printf("Load shed %v: %v\n", lsc.config.Description, err)
+			}
+		}
+	}
+
+done:
+	for {
+		select {
+		case <-lsc.stop:
+			log.Println("Stopping load shed client: ", lsc.config.Description)
+			break done
+		case <-t.C:
+			evaluate()
+		case pts := <-lsc.powerPoints:
+			for _, p := range pts {
+				if p.Type == lsc.config.PointType && p.Key == lsc.config.PointKey {
+					power = p.Value
+					havePower = true
+				}
+			}
+			evaluate()
+		case pts := <-lsc.newPoints:
+			err := data.MergePoints(pts.ID, pts.Points, &lsc.config)
+			if err != nil {
+				log.Println("error merging new points: ", err)
+			}
+
+			if pts.ID != lsc.config.ID {
+				continue
+			}
+
+			for _, p := range pts.Points {
+				switch p.Type {
+				case data.PointTypeNodeID:
+					sub.Unsubscribe()
+					sub, err = subscribe()
+					if err != nil {
+						log.Printf("Load shed %v: error subscribing to power points: %v\n",
+							lsc.config.Description, err)
+					}
+					readPower()
+				case data.PointTypePointType, data.PointTypePointKey:
+					readPower()
+				case data.PointTypeDisable:
+					if lsc.config.Disable {
+						restoreAll()
+					}
+				}
+			}
+
+		case pts := <-lsc.newEdgePoints:
+			err := data.MergeEdgePoints(pts.ID, pts.Parent, pts.Points, &lsc.config)
+			if err != nil {
+				log.Println("error merging new points: ", err)
+			}
+		}
+	}
+
+	// clean up
+	t.Stop()
+	if sub != nil {
+		sub.Unsubscribe()
+	}
+	return nil
+}
+
+// Stop sends a signal to the Start function to exit
+func (lsc *LoadShedClient) Stop(err error) {
+	close(lsc.stop)
+}
+
+// Points is called by the Manager when new points for this
+// node are received.
+func (lsc *LoadShedClient) Points(nodeID string, points []data.Point) {
+	lsc.newPoints <- NewPoints{nodeID, "", points}
+}
+
+// EdgePoints is called by the Manager when new edge points for this
+// node are received.
+func (lsc *LoadShedClient) EdgePoints(nodeID, parentID string, points []data.Point) {
+	lsc.newEdgePoints <- NewPoints{nodeID, parentID, points}
+}
@@ -0,0 +1,48 @@
+package client
+
+import "testing"
+
+func TestLoadShedStep(t *testing.T) {
+	config := LoadShed{Limit: 100, RestoreLimit: 80, Loads: []ShedLoad{
+		{Description: "hvac", Priority: 5, Power: 20},
+		{Description: "ev charger", Priority: 1, Power: 30},
+		{Description: "water heater", Priority: 3, Power: 10},
+		{Description: "disabled", Priority: 0, Disable: true},
+	}}
+
+	step := func(power float64, expIndex int, expShed bool) {
+		t.Helper()
+		i, shed := loadShedStep(config, power)
+		if i != expIndex || (i >= 0 && shed != expShed) {
+			t.Fatalf("power %v: expected %v/%v, got %v/%v", power, expIndex,
+				expShed, i, shed)
+		}
+		if i >= 0 {
+			config.Loads[i].Shed = shed
+		}
+	}
+
+	// loads are shed lowest priority first
+	step(120, 1, true)
+	step(110, 2, true)
+	// within hysteresis band, nothing changes
+	step(90, -1, false)
+	// most important shed load is restored first, but only if it fits
+	step(75, -1, false)
+	step(65, 2, false)
+	step(65, -1, false)
+	step(45, 1, false)
+	step(45, -1, false)
+
+	// everything shed
+	step(150, 1, true)
+	step(150, 2, true)
+	step(150, 0, true)
+	step(150, -1, false)
+
+	// default restore limit is 90% of limit, loads without power set are
+	// always restored
+	config = LoadShed{Limit: 100, Loads: []ShedLoad{{Shed: true}}}
+	step(91, -1, false)
+	step(89, 0, false)
+}
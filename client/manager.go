@@ -18,6 +18,7 @@ type Manager[T any] struct {
 	root      string
 	nodeType  string
 	construct func(*nats.Conn, T) Client
+	debounce  time.Duration
 
 	// synchronization fields
 	stop       chan struct{}
@@ -40,13 +41,20 @@ func NewManager[T any](nc *nats.Conn, root string,
 	nodeType := reflect.TypeOf(x).Name()
 	nodeType = strings.ToLower(nodeType[0:1]) + nodeType[1:]
 
+	// generate edit forms for the node types of this client
+	for _, ui := range nodeUIsFromType(reflect.TypeOf(x), nodeType,
+		[]string{data.NodeTypeDevice, data.NodeTypeGroup}) {
+		registerGeneratedNodeUI(ui)
+	}
+
 	return &Manager[T]{
 		nc:           nc,
 		root:         root,
 		nodeType:     nodeType,
 		construct:    construct,
+		debounce:     DefaultConfigDebounce,
 		stop:         make(chan struct{}),
-		chScan:       make(chan struct{}),
+		chScan:       make(chan struct{}, 1),
 		chAction:     make(chan func()),
 		chDeleteCS:   make(chan string),
 		clientStates: make(map[string]*clientState[T]),
@@ -68,9 +76,16 @@ func (m *Manager[T]) Start() error {
 			return
 		}
 
+		// only nodes of the type this manager handles can change the
+		// scan result. A pending scan picks up all nodes that were
+		// added before it runs, so scans are not queued up.
 		for _, p := range points {
-			if p.Type == data.PointTypeNodeType {
-				m.chScan <- struct{}{}
+			if p.Type == data.PointTypeNodeType && p.Text == m.nodeType {
+				select {
+				case m.chScan <- struct{}{}:
+				default:
+				}
+				break
 			}
 		}
 	})
@@ -144,6 +159,15 @@ done:
 	return nil
 }
 
+// SetConfigDebounce sets how long point changes for a client are collected
+// before they are sent to the client (default DefaultConfigDebounce). This
+// avoids reloading clients for every point of a UI edit. If debounce is 0,
+// points are sent to clients as they arrive. This must be called before
+// Start.
+func (m *Manager[T]) SetConfigDebounce(debounce time.Duration) {
+	m.debounce = debounce
+}
+
 // Stop manager. This also stops all registered clients and causes Start to exit.
 func (m *Manager[T]) Stop(err error) {
 	m.stop <- struct{}{}
@@ -171,7 +195,7 @@ func (m *Manager[T]) scan() error {
 			continue
 		}
 
-		cs := newClientState(m.nc, m.construct, n)
+		cs := newClientState(m.nc, m.construct, n, m.debounce)
 
 		m.clientStates[key] = cs
 
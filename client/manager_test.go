@@ -1,6 +1,7 @@
 package client_test
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"testing"
@@ -112,6 +113,8 @@ func TestManager(t *testing.T) {
 	// Create a new manager for nodes of type "testNode". The manager looks for new nodes under the
 	// root and if it finds any, it instantiates a new client, and sends point updates to it
 	m := client.NewManager(nc, root.ID, newTestNodeClientWrapper)
+	// send points to the client as they arrive
+	m.SetConfigDebounce(0)
 
 	managerStopped := make(chan struct{})
 
@@ -414,6 +417,8 @@ func TestManagerChildren(t *testing.T) {
 	// Create a new manager for nodes of type "testNode". The manager looks for new nodes under the
 	// root and if it finds any, it instantiates a new client, and sends point updates to it
 	m := client.NewManager(nc, root.ID, newTestXClientWrapper)
+	// send points to the client as they arrive
+	m.SetConfigDebounce(0)
 
 	managerStopped := make(chan struct{})
 
@@ -543,3 +548,121 @@ func TestManagerChildren(t *testing.T) {
 		t.Fatal("failed to remove child node")
 	}
 }
+
+type testFail struct {
+	ID          string `node:"id"`
+	Parent      string `node:"parent"`
+	Description string `point:"description"`
+}
+
+// testFailClient returns an error from Start if fail is set
+type testFailClient struct {
+	fail bool
+	stop chan struct{}
+}
+
+func (tfc *testFailClient) Start() error {
+	if tfc.fail {
+		return errors.New("test failure")
+	}
+	<-tfc.stop
+	return nil
+}
+
+func (tfc *testFailClient) Stop(err error) {
+	close(tfc.stop)
+}
+
+func (tfc *testFailClient) Points(nodeID string, points []data.Point) {}
+
+func (tfc *testFailClient) EdgePoints(nodeID, parentID string, points []data.Point) {}
+
+func TestManagerSupervision(t *testing.T) {
+	nc, root, stop, err := server.TestServer()
+
+	if err != nil {
+		t.Fatal("Error starting test server: ", err)
+	}
+
+	defer stop()
+
+	testConfig := testFail{"ID-testFail", root.ID, "failing node"}
+
+	err = client.SendNodeType(nc, testConfig, "test")
+	if err != nil {
+		t.Fatal("Error sending node: ", err)
+	}
+
+	starts := make(chan int, 10)
+	count := 0
+
+	// the first client fails
+	newClient := func(nc *nats.Conn, config testFail) client.Client {
+		count++
+		starts <- count
+		return &testFailClient{fail: count == 1, stop: make(chan struct{})}
+	}
+
+	m := client.NewManager(nc, root.ID, newClient)
+
+	managerStopped := make(chan struct{})
+
+	go func() {
+		_ = m.Start()
+		close(managerStopped)
+	}()
+
+	waitStart := func(exp int) {
+		select {
+		case c := <-starts:
+			if c != exp {
+				t.Fatalf("expected start %v, got %v", exp, c)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout waiting for client start ", exp)
+		}
+	}
+
+	waitStart(1)
+	// restarted after the backoff
+	waitStart(2)
+
+	nodes, err := client.GetNode(nc, testConfig.ID, root.ID)
+	if err != nil || len(nodes) < 1 {
+		t.Fatal("error getting node: ", err)
+	}
+
+	if c, _ := nodes[0].Points.Value(data.PointTypeRestartCount, ""); c != 1 {
+		t.Error("wrong restart count: ", c)
+	}
+
+	if e, _ := nodes[0].Points.Text(data.PointTypeLastError, ""); e != "test failure" {
+		t.Error("wrong last error: ", e)
+	}
+
+	// manual restart
+	err = client.SendNodePoint(nc, testConfig.ID,
+		data.Point{Type: data.PointTypeRestart, Value: 1, Origin: "test"}, true)
+	if err != nil {
+		t.Fatal("Error sending restart point: ", err)
+	}
+
+	waitStart(3)
+
+	nodes, err = client.GetNode(nc, testConfig.ID, root.ID)
+	if err != nil || len(nodes) < 1 {
+		t.Fatal("error getting node: ", err)
+	}
+
+	if r, _ := nodes[0].Points.Value(data.PointTypeRestart, ""); r != 0 {
+		t.Error("restart point was not cleared")
+	}
+
+	m.Stop(nil)
+
+	select {
+	case <-managerStopped:
+	case <-time.After(time.Second * 10):
+		t.Fatal("manager did not stop")
+	}
+}
@@ -0,0 +1,363 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nats-io/nats.go"
+	"github.com/simpleiot/simpleiot/data"
+	"github.com/simpleiot/simpleiot/mbus"
+	"go.bug.st/serial"
+)
+
+// Mbus config. An M-Bus node reads wired M-Bus meters every samplePeriod
+// seconds through a serial level converter (port) or a TCP connection
+// (uri). Setting the scan point scans the bus for meters and creates a
+// meter node for each new address found.
+type Mbus struct {
+	ID           string      `node:"id"`
+	Parent       string      `node:"parent"`
+	Description  string      `point:"description"`
+	Port         string      `point:"port"`
+	Baud         int         `point:"baud"`
+	URI          string      `point:"uri"`
+	SamplePeriod float64     `point:"samplePeriod"`
+	Scan         bool        `point:"scan"`
+	Disable      bool        `point:"disable"`
+	Meters       []MbusMeter `child:"mbusMeter"`
+}
+
+// MbusMeter is a meter on an M-Bus. Data records are published as points
+// on the meter node.
+type MbusMeter struct {
+	ID          string `node:"id"`
+	Parent      string `node:"parent"`
+	Description string `point:"description"`
+	Address     int    `point:"address"`
+	Disable     bool   `point:"disable"`
+	ErrorCount  int    `point:"errorCount"`
+}
+
+// mbusTimeout is how long to wait for a meter to respond
+const mbusTimeout = 500 * time.Millisecond
+
+// mbusPoints converts a telegram into points. The point type is the record
+// quantity (energy, volume, flowTemperature, etc) and the units are set in
+// the point meta. Historic (storage), tariff, and subunit values are keyed,
+// for example s1 or t2. Minimum, maximum, and error values are skipped.
+func mbusPoints(tg mbus.Telegram, now time.Time) data.Points {
+	ret := data.Points{
+		{Time: now, Type: data.PointTypeMeterID, Text: fmt.Sprintf("%08d", tg.ID)},
+		{Time: now, Type: data.PointTypeManufacturer, Text: tg.Manufacturer},
+		{Time: now, Type: data.PointTypeMedium, Text: mbus.MediumName(tg.Medium)},
+	}
+
+	seen := make(map[string]bool)
+
+	for _, r := range tg.Records {
+		if r.Function != mbus.FunctionInstantaneous || r.Quantity == "manufacturer" {
+			continue
+		}
+
+		key := ""
+		if r.Storage > 0 {
+			key += fmt.Sprintf("s%v", r.Storage)
+		}
+		if r.Tariff > 0 {
+			key += fmt.Sprintf("t%v", r.Tariff)
+		}
+		if r.Subunit > 0 {
+			key += fmt.Sprintf("u%v", r.Subunit)
+		}
+
+		// some meters send the same value in several units, use the first
+		if seen[r.Quantity+"."+key] {
+			continue
+		}
+		seen[r.Quantity+"."+key] = true
+
+		p := data.Point{Time: now, Type: r.Quantity, Key: key, Value: r.Value,
+			Text: r.Text}
+		if r.Unit != "" {
+			p.Meta = map[string]string{"units": r.Unit}
+		}
+
+		ret = append(ret, p)
+	}
+
+	return ret
+}
+
+// MbusClient is a SIOT client used to read M-Bus meters
+type MbusClient struct {
+	nc            *nats.Conn
+	config        Mbus
+	stop          chan struct{}
+	newPoints     chan NewPoints
+	newEdgePoints chan NewPoints
+}
+
+// NewMbusClient ...
+func NewMbusClient(nc *nats.Conn, config Mbus) Client {
+	return &MbusClient{
+		nc:            nc,
+		config:        config,
+		stop:          make(chan struct{}),
+		newPoints:     make(chan NewPoints),
+		newEdgePoints: make(chan NewPoints),
+	}
+}
+
+// open opens the serial or TCP port to the bus
+func (mbc *MbusClient) open() (io.ReadWriteCloser, error) {
+	if mbc.config.URI != "" {
+		return net.DialTimeout("tcp", mbc.config.URI, 5*time.Second)
+	}
+
+	if mbc.config.Port == "" {
+		return nil, errors.New("port or uri must be set")
+	}
+
+	baud := mbc.config.Baud
+	if baud <= 0 {
+		baud = 2400
+	}
+
+	port, err := serial.Open(mbc.config.Port, &serial.Mode{
+		BaudRate: baud,
+		DataBits: 8,
+		Parity:   serial.EvenParity,
+		StopBits: serial.OneStopBit,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = port.SetReadTimeout(50 * time.Millisecond)
+	if err != nil {
+		port.Close()
+		return nil, err
+	}
+
+	return port, nil
+}
+
+// tcpTimeouts sets read deadlines on TCP connections so reads return when
+// meters do not respond. Serial ports use a read timeout.
+type tcpTimeouts struct {
+	net.Conn
+}
+
+func (t tcpTimeouts) Read(p []byte) (int, error) {
+	err := t.Conn.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := t.Conn.Read(p)
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return n, nil
+	}
+	return n, err
+}
+
+func (mbc *MbusClient) client() (*mbus.Client, io.Closer, error) {
+	port, err := mbc.open()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var rw io.ReadWriter = port
+	if conn, ok := port.(net.Conn); ok {
+		rw = tcpTimeouts{conn}
+	}
+
+	return mbus.NewClient(rw, mbusTimeout), port, nil
+}
+
+func (mbc *MbusClient) readMeters() {
+	c, port, err := mbc.client()
+	if err != nil {
+		log.Printf("M-Bus %v: error opening port: %v\n", mbc.config.Description, err)
+		return
+	}
+	defer port.Close()
+
+	for i := range mbc.config.Meters {
+		m := &mbc.config.Meters[i]
+		if m.Disable {
+			continue
+		}
+
+		tg, err := c.Read(byte(m.Address))
+		if err == nil {
+			err = SendNodePoints(mbc.nc, m.ID, mbusPoints(tg, time.Now()), false)
+			if err != nil {
+				log.Println("M-Bus error sending points: ", err)
+			}
+			continue
+		}
+
+		log.Printf("M-Bus %v: error reading meter %v: %v\n",
+			mbc.config.Description, m.Address, err)
+
+		m.ErrorCount++
+		err = SendNodePoint(mbc.nc, m.ID, data.Point{
+			Type: data.PointTypeErrorCount, Value: float64(m.ErrorCount)}, false)
+		if err != nil {
+			log.Println("M-Bus error sending points: ", err)
+		}
+	}
+}
+
+// scan scans the bus for meters and creates nodes for new addresses
+func (mbc *MbusClient) scan() {
+	log.Printf("M-Bus %v: scanning bus\n", mbc.config.Description)
+
+	c, port, err := mbc.client()
+	if err != nil {
+		log.Printf("M-Bus %v: error opening port: %v\n", mbc.config.Description, err)
+		return
+	}
+
+	found := c.Scan(0, 250, func() bool {
+		select {
+		case <-mbc.stop:
+			return true
+		default:
+			return false
+		}
+	})
+
+	port.Close()
+
+	known := make(map[int]bool)
+	for _, m := range mbc.config.Meters {
+		known[m.Address] = true
+	}
+
+	for _, a := range found {
+		if known[int(a)] {
+			continue
+		}
+
+		m := MbusMeter{
+			ID:          uuid.New().String(),
+			Parent:      mbc.config.ID,
+			Description: fmt.Sprintf("Meter %v", a),
+			Address:     int(a),
+		}
+
+		// nodes sent without an origin do not restart this client, so the
+		// meter is added to the config here
+		err := SendNodeType(mbc.nc, m, "")
+		if err != nil {
+			log.Println("M-Bus error creating meter node: ", err)
+			continue
+		}
+
+		mbc.config.Meters = append(mbc.config.Meters, m)
+	}
+
+	log.Printf("M-Bus %v: scan found %v meters\n", mbc.config.Description, len(found))
+}
+
+// Start runs the main logic for this client and blocks until stopped
+func (mbc *MbusClient) Start() error {
+	log.Println("Starting M-Bus client: ", mbc.config.Description)
+
+	t := time.NewTicker(time.Hour)
+	t.Stop()
+
+	setup := func() {
+		t.Stop()
+
+		if mbc.config.Disable {
+			log.Printf("M-Bus %v: disabled\n", mbc.config.Description)
+			return
+		}
+
+		period := mbc.config.SamplePeriod
+		if period <= 0 {
+			period = 60
+		}
+
+		t.Reset(time.Duration(period * float64(time.Second)))
+	}
+
+	setup()
+
+done:
+	for {
+		select {
+		case <-mbc.stop:
+			log.Println("Stopping M-Bus client: ", mbc.config.Description)
+			break done
+		case <-t.C:
+			mbc.readMeters()
+		case pts := <-mbc.newPoints:
+			err := data.MergePoints(pts.ID, pts.Points, &mbc.config)
+			if err != nil {
+				log.Println("error merging new points: ", err)
+			}
+
+			if pts.ID != mbc.config.ID {
+				continue
+			}
+
+			for _, p := range pts.Points {
+				switch p.Type {
+				case data.PointTypeSamplePeriod, data.PointTypeDisable:
+					setup()
+				case data.PointTypeScan:
+					if !mbc.config.Scan {
+						continue
+					}
+
+					mbc.scan()
+					mbc.readMeters()
+
+					mbc.config.Scan = false
+					err := SendNodePoint(mbc.nc, mbc.config.ID, data.Point{
+						Type: data.PointTypeScan, Value: 0}, false)
+					if err != nil {
+						log.Println("M-Bus error clearing scan: ", err)
+					}
+				}
+			}
+
+		case pts := <-mbc.newEdgePoints:
+			err := data.MergeEdgePoints(pts.ID, pts.Parent, pts.Points, &mbc.config)
+			if err != nil {
+				log.Println("error merging new points: ", err)
+			}
+		}
+	}
+
+	// clean up
+	t.Stop()
+	return nil
+}
+
+// Stop sends a signal to the Start function to exit
+func (mbc *MbusClient) Stop(err error) {
+	close(mbc.stop)
+}
+
+// Points is called by the Manager when new points for this
+// node are received.
+func (mbc *MbusClient) Points(nodeID string, points []data.Point) {
+	mbc.newPoints <- NewPoints{nodeID, "", points}
+}
+
+// EdgePoints is called by the Manager when new edge points for this
+// node are received.
+func (mbc *MbusClient) EdgePoints(nodeID, parentID string, points []data.Point) {
+	mbc.newEdgePoints <- NewPoints{nodeID, parentID, points}
+}
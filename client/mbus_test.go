@@ -0,0 +1,59 @@
+package client
+
+import (
+	"testing"
+	"time"
+
+	"github.com/simpleiot/simpleiot/data"
+	"github.com/simpleiot/simpleiot/mbus"
+)
+
+func TestMbusPoints(t *testing.T) {
+	tg := mbus.Telegram{
+		ID:           1234,
+		Manufacturer: "KAM",
+		Medium:       0x04,
+		Records: []mbus.Record{
+			{Quantity: "energy", Unit: "kWh", Value: 1234},
+			{Quantity: "energy", Unit: "kJ", Value: 4442400},
+			{Quantity: "energy", Unit: "kWh", Storage: 1, Value: 1000},
+			{Quantity: "energy", Unit: "kWh", Tariff: 2, Value: 34},
+			{Quantity: "power", Unit: "kW", Function: mbus.FunctionMaximum, Value: 12},
+			{Quantity: "date", Storage: 1, Text: "2022-07-31"},
+		},
+	}
+
+	pts := mbusPoints(tg, time.Now())
+
+	check := func(typ, key string, value float64, units string) {
+		p, ok := pts.Find(typ, key)
+		if !ok {
+			t.Errorf("point %v:%v not found", typ, key)
+			return
+		}
+		if p.Value != value || p.Meta["units"] != units {
+			t.Errorf("point %v:%v: expected %v %v, got %v %v", typ, key, value,
+				units, p.Value, p.Meta["units"])
+		}
+	}
+
+	check("energy", "", 1234, "kWh")
+	check("energy", "s1", 1000, "kWh")
+	check("energy", "t2", 34, "kWh")
+
+	if _, ok := pts.Find("power", ""); ok {
+		t.Error("maximum values should be skipped")
+	}
+
+	if d, _ := pts.Text("date", "s1"); d != "2022-07-31" {
+		t.Error("wrong date: ", d)
+	}
+
+	if id, _ := pts.Text(data.PointTypeMeterID, ""); id != "00001234" {
+		t.Error("wrong meter ID: ", id)
+	}
+
+	if m, _ := pts.Text(data.PointTypeMedium, ""); m != "heat" {
+		t.Error("wrong medium: ", m)
+	}
+}
@@ -0,0 +1,290 @@
+package client
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/simpleiot/simpleiot/data"
+	"github.com/simpleiot/simpleiot/iec62056"
+	"go.bug.st/serial"
+)
+
+// MeterReader config. A meter reader node reads utility meters with the IEC
+// 62056-21 protocol every samplePeriod seconds, through a serial optical
+// head (port) or a TCP connection (uri). Each meter child node is read by
+// address. If there are no meter nodes, the one meter connected is read and
+// its values are published on the meter reader node.
+type MeterReader struct {
+	ID           string  `node:"id"`
+	Parent       string  `node:"parent"`
+	Description  string  `point:"description"`
+	Port         string  `point:"port"`
+	URI          string  `point:"uri"`
+	SamplePeriod float64 `point:"samplePeriod"`
+	Disable      bool    `point:"disable"`
+	ErrorCount   int     `point:"errorCount"`
+	Meters       []Meter `child:"meter"`
+}
+
+// Meter is a meter read by a meter reader. The address is only needed if
+// more than one meter is connected.
+type Meter struct {
+	ID          string `node:"id"`
+	Parent      string `node:"parent"`
+	Description string `point:"description"`
+	Address     string `point:"address"`
+	Disable     bool   `point:"disable"`
+	ErrorCount  int    `point:"errorCount"`
+}
+
+// meterTimeout is how long to wait for a meter response
+const meterTimeout = 5 * time.Second
+
+// meterPhasePoints maps OBIS codes of per phase values to points
+var meterPhasePoints = map[string]struct {
+	typ   string
+	phase string
+}{
+	"32.7.0": {data.PointTypeVoltage, "1"},
+	"52.7.0": {data.PointTypeVoltage, "2"},
+	"72.7.0": {data.PointTypeVoltage, "3"},
+	"31.7.0": {data.PointTypeCurrent, "1"},
+	"51.7.0": {data.PointTypeCurrent, "2"},
+	"71.7.0": {data.PointTypeCurrent, "3"},
+}
+
+// meterPoints converts a meter readout to points. Common registers are
+// published as named points, and every numeric register is also published
+// as an obis point with the OBIS code as the key.
+func meterPoints(id iec62056.Identification, ds []iec62056.DataSet, now time.Time) data.Points {
+	ret := data.Points{
+		{Time: now, Type: data.PointTypeManufacturer, Text: id.Manufacturer},
+		{Time: now, Type: data.PointTypeMeterID, Text: id.ID},
+	}
+
+	seen := make(map[string]bool)
+
+	for _, d := range ds {
+		obis := d.OBIS()
+
+		// only the first value of a line is a reading, others are
+		// typically time stamps
+		if seen[d.Address] {
+			continue
+		}
+		seen[d.Address] = true
+
+		v, err := d.Float()
+		if err != nil {
+			continue
+		}
+
+		p := data.Point{Time: now, Value: v}
+
+		switch obis {
+		case "1.8.0":
+			p.Type = data.PointTypeEnergyImport
+		case "2.8.0":
+			p.Type = data.PointTypeEnergyExport
+		case "1.6.0":
+			p.Type = data.PointTypeDemand
+		case "16.7.0", "1.7.0":
+			p.Type = data.PointTypePower
+		default:
+			if pp, ok := meterPhasePoints[obis]; ok {
+				p.Type, p.Key = pp.typ, pp.phase
+			}
+		}
+
+		if p.Type != "" {
+			ret = append(ret, p)
+		}
+
+		ret = append(ret, data.Point{Time: now, Type: data.PointTypeOBIS,
+			Key: d.Address, Value: v, Text: d.Unit})
+	}
+
+	return ret
+}
+
+// MeterReaderClient is a SIOT client used to read IEC 62056 meters
+type MeterReaderClient struct {
+	nc            *nats.Conn
+	config        MeterReader
+	stop          chan struct{}
+	newPoints     chan NewPoints
+	newEdgePoints chan NewPoints
+}
+
+// NewMeterReaderClient ...
+func NewMeterReaderClient(nc *nats.Conn, config MeterReader) Client {
+	return &MeterReaderClient{
+		nc:            nc,
+		config:        config,
+		stop:          make(chan struct{}),
+		newPoints:     make(chan NewPoints),
+		newEdgePoints: make(chan NewPoints),
+	}
+}
+
+// open opens the serial or TCP port to the meters. setBaud is nil for TCP.
+func (mrc *MeterReaderClient) open() (io.ReadWriteCloser, func(int) error, error) {
+	if mrc.config.URI != "" {
+		conn, err := net.DialTimeout("tcp", mrc.config.URI, meterTimeout)
+		if err != nil {
+			return nil, nil, err
+		}
+		err = conn.SetDeadline(time.Now().Add(meterTimeout * 4))
+		return conn, nil, err
+	}
+
+	if mrc.config.Port == "" {
+		return nil, nil, errors.New("port or uri must be set")
+	}
+
+	// mode C starts at 300 baud, 7E1
+	mode := &serial.Mode{
+		BaudRate: 300,
+		DataBits: 7,
+		Parity:   serial.EvenParity,
+		StopBits: serial.OneStopBit,
+	}
+
+	port, err := serial.Open(mrc.config.Port, mode)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = port.SetReadTimeout(100 * time.Millisecond)
+	if err != nil {
+		port.Close()
+		return nil, nil, err
+	}
+
+	return port, func(baud int) error {
+		mode.BaudRate = baud
+		return port.SetMode(mode)
+	}, nil
+}
+
+// read reads one meter and sends the values to nodeID
+func (mrc *MeterReaderClient) read(nodeID, address string, errorCount *int) {
+	err := func() error {
+		port, setBaud, err := mrc.open()
+		if err != nil {
+			return err
+		}
+		defer port.Close()
+
+		id, ds, err := iec62056.Read(port, address, meterTimeout, setBaud)
+		if err != nil {
+			return err
+		}
+
+		return SendNodePoints(mrc.nc, nodeID, meterPoints(id, ds, time.Now()), false)
+	}()
+
+	if err != nil {
+		log.Printf("Meter reader %v: error reading meter %v: %v\n",
+			mrc.config.Description, address, err)
+		*errorCount++
+		err := SendNodePoint(mrc.nc, nodeID, data.Point{
+			Type: data.PointTypeErrorCount, Value: float64(*errorCount)}, false)
+		if err != nil {
+			log.Println("Meter reader error sending points: ", err)
+		}
+	}
+}
+
+// Start runs the main logic for this client and blocks until stopped
+func (mrc *MeterReaderClient) Start() error {
+	log.Println("Starting meter reader client: ", mrc.config.Description)
+
+	t := time.NewTicker(time.Hour)
+	t.Stop()
+
+	setup := func() {
+		t.Stop()
+
+		if mrc.config.Disable {
+			log.Printf("Meter reader %v: disabled\n", mrc.config.Description)
+			return
+		}
+
+		period := mrc.config.SamplePeriod
+		if period <= 0 {
+			period = 60
+		}
+
+		t.Reset(time.Duration(period * float64(time.Second)))
+	}
+
+	setup()
+
+done:
+	for {
+		select {
+		case <-mrc.stop:
+			log.Println("Stopping meter reader client: ", mrc.config.Description)
+			break done
+		case <-t.C:
+			if len(mrc.config.Meters) <= 0 {
+				mrc.read(mrc.config.ID, "", &mrc.config.ErrorCount)
+				continue
+			}
+
+			for i := range mrc.config.Meters {
+				m := &mrc.config.Meters[i]
+				if m.Disable {
+					continue
+				}
+				mrc.read(m.ID, m.Address, &m.ErrorCount)
+			}
+		case pts := <-mrc.newPoints:
+			err := data.MergePoints(pts.ID, pts.Points, &mrc.config)
+			if err != nil {
+				log.Println("error merging new points: ", err)
+			}
+
+			for _, p := range pts.Points {
+				switch p.Type {
+				case data.PointTypeSamplePeriod, data.PointTypeDisable:
+					if pts.ID == mrc.config.ID {
+						setup()
+					}
+				}
+			}
+
+		case pts := <-mrc.newEdgePoints:
+			err := data.MergeEdgePoints(pts.ID, pts.Parent, pts.Points, &mrc.config)
+			if err != nil {
+				log.Println("error merging new points: ", err)
+			}
+		}
+	}
+
+	// clean up
+	t.Stop()
+	return nil
+}
+
+// Stop sends a signal to the Start function to exit
+func (mrc *MeterReaderClient) Stop(err error) {
+	close(mrc.stop)
+}
+
+// Points is called by the Manager when new points for this
+// node are received.
+func (mrc *MeterReaderClient) Points(nodeID string, points []data.Point) {
+	mrc.newPoints <- NewPoints{nodeID, "", points}
+}
+
+// EdgePoints is called by the Manager when new edge points for this
+// node are received.
+func (mrc *MeterReaderClient) EdgePoints(nodeID, parentID string, points []data.Point) {
+	mrc.newEdgePoints <- NewPoints{nodeID, parentID, points}
+}
@@ -0,0 +1,51 @@
+package client
+
+import (
+	"testing"
+	"time"
+
+	"github.com/simpleiot/simpleiot/data"
+	"github.com/simpleiot/simpleiot/iec62056"
+)
+
+func TestMeterPoints(t *testing.T) {
+	ds, err := iec62056.ParseDataBlock(`0.0.0(12345678)
+1-0:1.8.0*255(001234.5*kWh)
+2.8.0(000010.0*kWh)
+1.6.0(02.28*kW)(2207251415)
+52.7.0(231.5*V)
+F.F(00)
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pts := meterPoints(iec62056.Identification{Manufacturer: "ISK", ID: "MT174"},
+		ds, time.Now())
+
+	check := func(typ, key string, value float64) {
+		p, ok := pts.Find(typ, key)
+		if !ok {
+			t.Errorf("point %v:%v not found", typ, key)
+			return
+		}
+		if p.Value != value {
+			t.Errorf("point %v:%v: expected %v, got %v", typ, key, value, p.Value)
+		}
+	}
+
+	check(data.PointTypeEnergyImport, "", 1234.5)
+	check(data.PointTypeEnergyExport, "", 10)
+	check(data.PointTypeDemand, "", 2.28)
+	check(data.PointTypeVoltage, "2", 231.5)
+	check(data.PointTypeOBIS, "1-0:1.8.0*255", 1234.5)
+	check(data.PointTypeOBIS, "F.F", 0)
+
+	if p, _ := pts.Find(data.PointTypeOBIS, "1.6.0"); p.Value != 2.28 {
+		t.Error("demand time stamp should not replace demand: ", p.Value)
+	}
+
+	if id, _ := pts.Text(data.PointTypeMeterID, ""); id != "MT174" {
+		t.Error("wrong meter ID: ", id)
+	}
+}
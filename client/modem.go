@@ -0,0 +1,273 @@
+package client
+
+import (
+	"context"
+	"log"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/simpleiot/simpleiot/data"
+	"github.com/simpleiot/simpleiot/system"
+)
+
+// Modem config. A modem node monitors a cellular modem through
+// ModemManager (mmcli) and publishes signal, carrier, SIM status, and data
+// usage as points. Setting the command point to "reset" resets the modem.
+type Modem struct {
+	ID             string  `node:"id"`
+	Parent         string  `node:"parent"`
+	Description    string  `point:"description"`
+	Interface      string  `point:"interface"`
+	SamplePeriod   float64 `point:"samplePeriod"`
+	Command        string  `point:"command"`
+	SignalStrength float64 `point:"signalStrength"`
+	RSSI           float64 `point:"rssi"`
+	RSRP           float64 `point:"rsrp"`
+	RSRQ           float64 `point:"rsrq"`
+	Carrier        string  `point:"carrier"`
+	ModemState     string  `point:"modemState"`
+	SimStatus      string  `point:"simStatus"`
+	Disable        bool    `point:"disable"`
+}
+
+// ModemClient for modem nodes
+type ModemClient struct {
+	nc            *nats.Conn
+	config        Modem
+	stop          chan struct{}
+	newPoints     chan NewPoints
+	newEdgePoints chan NewPoints
+}
+
+// NewModemClient ...
+func NewModemClient(nc *nats.Conn, config Modem) Client {
+	return &ModemClient{
+		nc:            nc,
+		config:        config,
+		stop:          make(chan struct{}),
+		newPoints:     make(chan NewPoints),
+		newEdgePoints: make(chan NewPoints),
+	}
+}
+
+// parseMmcli parses the key/value output of `mmcli -K`. Values of "--"
+// mean the value is not set and are skipped.
+func parseMmcli(out string) map[string]string {
+	ret := make(map[string]string)
+
+	for _, line := range strings.Split(out, "\n") {
+		i := strings.Index(line, ":")
+		if i < 0 {
+			continue
+		}
+
+		key := strings.TrimSpace(line[:i])
+		value := strings.TrimSpace(line[i+1:])
+
+		if key == "" || value == "--" || value == "" {
+			continue
+		}
+
+		ret[key] = value
+	}
+
+	return ret
+}
+
+// modemPoints converts mmcli modem and signal info into points
+func modemPoints(modem, signal map[string]string, now time.Time) data.Points {
+	var ret data.Points
+
+	text := func(typ, key string) {
+		if v, ok := modem[key]; ok {
+			ret = append(ret, data.Point{Time: now, Type: typ, Text: v})
+		}
+	}
+
+	value := func(info map[string]string, typ string, keys ...string) {
+		for _, key := range keys {
+			v, ok := info[key]
+			if !ok {
+				continue
+			}
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				continue
+			}
+			ret = append(ret, data.Point{Time: now, Type: typ, Value: f})
+			return
+		}
+	}
+
+	text(data.PointTypeModemState, "modem.generic.state")
+	text(data.PointTypeCarrier, "modem.3gpp.operator-name")
+
+	simStatus := "present"
+	if reason, ok := modem["modem.generic.state-failed-reason"]; ok {
+		simStatus = reason
+	} else if _, ok := modem["modem.generic.sim"]; !ok {
+		simStatus = "missing"
+	}
+	ret = append(ret, data.Point{Time: now, Type: data.PointTypeSimStatus,
+		Text: simStatus})
+
+	value(modem, data.PointTypeSignalStrength, "modem.generic.signal-quality.value")
+	value(signal, data.PointTypeRSSI, "modem.signal.lte.rssi",
+		"modem.signal.umts.rssi", "modem.signal.gsm.rssi")
+	value(signal, data.PointTypeRSRP, "modem.signal.lte.rsrp")
+	value(signal, data.PointTypeRSRQ, "modem.signal.lte.rsrq")
+
+	return ret
+}
+
+func (mc *ModemClient) read() data.Points {
+	now := time.Now()
+
+	out, err := exec.Command("mmcli", "-m", "any", "-K").Output()
+	if err != nil {
+		log.Printf("Modem %v: error reading modem: %v\n", mc.config.Description, err)
+		return data.Points{{Time: now, Type: data.PointTypeModemState,
+			Text: "not found"}}
+	}
+
+	modem := parseMmcli(string(out))
+
+	// signal info requires signal polling to be enabled with
+	// mmcli --signal-setup, so this may be empty
+	var signal map[string]string
+	out, err = exec.Command("mmcli", "-m", "any", "--signal-get", "-K").Output()
+	if err == nil {
+		signal = parseMmcli(string(out))
+	}
+
+	ret := modemPoints(modem, signal, now)
+
+	if mc.config.Interface != "" {
+		stats, err := system.ReadNetStats()
+		if err == nil {
+			for _, s := range stats {
+				if s.Interface != mc.config.Interface {
+					continue
+				}
+				ret = append(ret,
+					data.Point{Time: now, Type: data.PointTypeNetRxBytes,
+						Key: s.Interface, Value: float64(s.RxBytes)},
+					data.Point{Time: now, Type: data.PointTypeNetTxBytes,
+						Key: s.Interface, Value: float64(s.TxBytes)})
+			}
+		}
+	}
+
+	return ret
+}
+
+// Start runs the main logic for this client and blocks until stopped
+func (mc *ModemClient) Start() error {
+	log.Println("Starting modem client: ", mc.config.Description)
+
+	t := time.NewTicker(time.Hour)
+	t.Stop()
+
+	setup := func() {
+		t.Stop()
+
+		if mc.config.Disable {
+			log.Printf("Modem %v: disabled\n", mc.config.Description)
+			return
+		}
+
+		period := mc.config.SamplePeriod
+		if period <= 0 {
+			period = 60
+		}
+
+		t.Reset(time.Duration(period * float64(time.Second)))
+	}
+
+	setup()
+
+done:
+	for {
+		select {
+		case <-mc.stop:
+			log.Println("Stopping modem client: ", mc.config.Description)
+			break done
+		case <-t.C:
+			err := SendNodePoints(mc.nc, mc.config.ID, mc.read(), false)
+			if err != nil {
+				log.Println("Modem error sending points: ", err)
+			}
+		case pts := <-mc.newPoints:
+			err := data.MergePoints(pts.ID, pts.Points, &mc.config)
+			if err != nil {
+				log.Println("error merging new points: ", err)
+			}
+
+			for _, p := range pts.Points {
+				switch p.Type {
+				case data.PointTypeSamplePeriod, data.PointTypeDisable:
+					setup()
+				case data.PointTypeCommand:
+					if p.Text == "" {
+						continue
+					}
+
+					// only users can reset the modem
+					email, err := originUser(mc.nc, p.Origin)
+					if err != nil {
+						log.Printf("Modem %v: unauthorized command %v from %v: %v\n",
+							mc.config.Description, p.Text, p.Origin, err)
+					} else if p.Text != data.PointValueReset {
+						log.Printf("Modem %v: unknown command: %v\n",
+							mc.config.Description, p.Text)
+					} else {
+						log.Printf("Modem %v: reset requested by %v\n",
+							mc.config.Description, email)
+						err := runHostCommand(context.Background(),
+							[]string{"mmcli", "-m", "any", "--reset"})
+						if err != nil {
+							log.Printf("Modem %v: error resetting modem: %v\n",
+								mc.config.Description, err)
+						}
+					}
+
+					err = SendNodePoint(mc.nc, mc.config.ID, data.Point{
+						Type: data.PointTypeCommand, Text: ""}, false)
+					if err != nil {
+						log.Println("Modem error clearing command: ", err)
+					}
+				}
+			}
+
+		case pts := <-mc.newEdgePoints:
+			err := data.MergeEdgePoints(pts.ID, pts.Parent, pts.Points, &mc.config)
+			if err != nil {
+				log.Println("error merging new points: ", err)
+			}
+		}
+	}
+
+	// clean up
+	t.Stop()
+	return nil
+}
+
+// Stop sends a signal to the Start function to exit
+func (mc *ModemClient) Stop(err error) {
+	close(mc.stop)
+}
+
+// Points is called by the Manager when new points for this
+// node are received.
+func (mc *ModemClient) Points(nodeID string, points []data.Point) {
+	mc.newPoints <- NewPoints{nodeID, "", points}
+}
+
+// EdgePoints is called by the Manager when new edge points for this
+// node are received.
+func (mc *ModemClient) EdgePoints(nodeID, parentID string, points []data.Point) {
+	mc.newEdgePoints <- NewPoints{nodeID, parentID, points}
+}
@@ -0,0 +1,69 @@
+package client_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/simpleiot/simpleiot/client"
+	"github.com/simpleiot/simpleiot/data"
+	"github.com/simpleiot/simpleiot/server"
+)
+
+func TestModemReset(t *testing.T) {
+	cmds := make(chan string, 10)
+	restore := client.SetRunHostCommand(func(ctx context.Context, args []string) error {
+		cmds <- strings.Join(args, " ")
+		return nil
+	})
+	defer restore()
+
+	nc, root, stop, err := server.TestServer()
+	if err != nil {
+		t.Fatal("Error starting test server: ", err)
+	}
+	defer stop()
+
+	users, err := client.GetNodeChildrenType[client.User](nc, root.ID)
+	if err != nil || len(users) < 1 {
+		t.Fatal("Error getting admin user: ", err)
+	}
+
+	modem := client.Modem{ID: "modem", Parent: root.ID, Description: "modem"}
+	if err := client.SendNodeType(nc, modem, "test"); err != nil {
+		t.Fatal(err)
+	}
+
+	// wait for the client to start
+	time.Sleep(500 * time.Millisecond)
+
+	reset := func(origin string) {
+		t.Helper()
+		err := client.SendNodePoint(nc, modem.ID, data.Point{Type: data.PointTypeCommand,
+			Text: data.PointValueReset, Origin: origin}, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// commands from other nodes, like rules, are ignored
+	reset("rule")
+
+	select {
+	case cmd := <-cmds:
+		t.Fatal("reset from a rule ran: ", cmd)
+	case <-time.After(client.DefaultConfigDebounce + time.Second):
+	}
+
+	reset(users[0].ID)
+
+	select {
+	case cmd := <-cmds:
+		if cmd != "mmcli -m any --reset" {
+			t.Error("wrong reset command: ", cmd)
+		}
+	case <-time.After(client.DefaultConfigDebounce + 5*time.Second):
+		t.Fatal("reset from a user did not run")
+	}
+}
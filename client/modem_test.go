@@ -0,0 +1,62 @@
+package client
+
+import (
+	"testing"
+	"time"
+
+	"github.com/simpleiot/simpleiot/data"
+)
+
+func TestModemPoints(t *testing.T) {
+	modem := parseMmcli(`modem.generic.state                             : connected
+modem.generic.sim                               : /org/freedesktop/ModemManager1/SIM/0
+modem.generic.signal-quality.value              : 67
+modem.generic.signal-quality.recent             : yes
+modem.3gpp.operator-name                        : Verizon
+modem.3gpp.registration-state                   : --
+`)
+
+	if _, ok := modem["modem.3gpp.registration-state"]; ok {
+		t.Error("unset values should be skipped")
+	}
+
+	signal := parseMmcli(`modem.signal.lte.rssi        : -61.00
+modem.signal.lte.rsrp        : -89.00
+modem.signal.lte.rsrq        : -9.00
+`)
+
+	pts := modemPoints(modem, signal, time.Now())
+
+	check := func(typ string, value float64, text string) {
+		p, ok := pts.Find(typ, "")
+		if !ok {
+			t.Errorf("point %v not found", typ)
+			return
+		}
+		if p.Value != value || p.Text != text {
+			t.Errorf("point %v: expected %v/%v, got %v/%v", typ, value, text,
+				p.Value, p.Text)
+		}
+	}
+
+	check(data.PointTypeModemState, 0, "connected")
+	check(data.PointTypeCarrier, 0, "Verizon")
+	check(data.PointTypeSimStatus, 0, "present")
+	check(data.PointTypeSignalStrength, 67, "")
+	check(data.PointTypeRSSI, -61, "")
+	check(data.PointTypeRSRP, -89, "")
+	check(data.PointTypeRSRQ, -9, "")
+}
+
+func TestModemPointsNoSim(t *testing.T) {
+	modem := parseMmcli(`modem.generic.state                 : failed
+modem.generic.state-failed-reason   : sim-missing
+`)
+
+	pts := modemPoints(modem, nil, time.Now())
+
+	status, _ := pts.Text(data.PointTypeSimStatus, "")
+	if status != "sim-missing" {
+		t.Error("Wrong sim status: ", status)
+	}
+}
@@ -0,0 +1,615 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/google/uuid"
+	"github.com/nats-io/nats.go"
+	"github.com/simpleiot/simpleiot/data"
+	"github.com/simpleiot/simpleiot/mqtt"
+)
+
+// MqttBridge config. An MQTT bridge connects to the MQTT broker (uri) used
+// by zigbee2mqtt or Z-Wave JS UI (protocol) and creates a device node for
+// each device published on the base topic. Device values become points on
+// the device nodes, and setting a <type>Set point on a device (for example
+// switchSet) sends a set command for the value.
+type MqttBridge struct {
+	ID          string       `node:"id"`
+	Parent      string       `node:"parent"`
+	Description string       `point:"description"`
+	URI         string       `point:"uri"`
+	Username    string       `point:"username"`
+	Password    string       `point:"password"`
+	Protocol    string       `point:"protocol"`
+	BaseTopic   string       `point:"baseTopic"`
+	Disable     bool         `point:"disable"`
+	Devices     []MqttDevice `child:"mqttDevice"`
+}
+
+// MqttDevice is a zigbee or Z-Wave device. DeviceID is the zigbee2mqtt
+// friendly name or the Z-Wave JS node name (for example nodeID_5).
+type MqttDevice struct {
+	ID          string `node:"id"`
+	Parent      string `node:"parent"`
+	Description string `point:"description"`
+	DeviceID    string `point:"deviceID"`
+	Disable     bool   `point:"disable"`
+}
+
+// mqttRetry is how long to wait before reconnecting to the broker
+const mqttRetry = 10 * time.Second
+
+// mqttSource records how a device value was published so writes can be
+// sent back in the same form
+type mqttSource struct {
+	// topic is the Z-Wave JS value topic
+	topic string
+	// key is the zigbee2mqtt JSON key
+	key string
+	// onOff is set for ON/OFF text values
+	onOff bool
+	// boolean is set for true/false values
+	boolean bool
+}
+
+// mqttPointTypes maps published property names (converted to camel case)
+// to point types
+var mqttPointTypes = map[string]string{
+	"state":          data.PointTypeSwitch,
+	"linkquality":    data.PointTypeLinkQuality,
+	"airTemperature": data.PointTypeTemperature,
+}
+
+// mqttCamelCase converts a property name to camel case, for example
+// water_leak -> waterLeak or "Air temperature" -> airTemperature
+func mqttCamelCase(s string) string {
+	var b strings.Builder
+	upper := false
+	for _, r := range s {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			upper = b.Len() > 0
+			continue
+		}
+
+		switch {
+		case b.Len() == 0:
+			b.WriteRune(unicode.ToLower(r))
+		case upper:
+			b.WriteRune(unicode.ToUpper(r))
+		default:
+			b.WriteRune(r)
+		}
+		upper = false
+	}
+	return b.String()
+}
+
+func mqttPointType(property string) string {
+	t := mqttCamelCase(property)
+	if pt, ok := mqttPointTypes[t]; ok {
+		return pt
+	}
+	return t
+}
+
+// mqttValue converts a JSON value to a point value. ok is false for
+// objects, arrays, and null, which are not converted.
+func mqttValue(v interface{}) (data.Point, mqttSource, bool) {
+	var p data.Point
+	var src mqttSource
+
+	switch x := v.(type) {
+	case float64:
+		p.Value = x
+	case bool:
+		src.boolean = true
+		p.Value = data.BoolToFloat(x)
+	case string:
+		switch strings.ToUpper(x) {
+		case "ON":
+			src.onOff = true
+			p.Value = 1
+		case "OFF":
+			src.onOff = true
+		default:
+			p.Text = x
+		}
+	default:
+		return p, src, false
+	}
+
+	return p, src, true
+}
+
+// zigbeePoints converts a zigbee2mqtt device state message to points. The
+// sources are indexed by point type.
+func zigbeePoints(payload []byte, now time.Time) (data.Points, map[string]mqttSource, error) {
+	var state map[string]interface{}
+	err := json.Unmarshal(payload, &state)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	keys := make([]string, 0, len(state))
+	for k := range state {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var pts data.Points
+	sources := make(map[string]mqttSource)
+
+	for _, k := range keys {
+		p, src, ok := mqttValue(state[k])
+		if !ok {
+			continue
+		}
+
+		p.Type = mqttPointType(k)
+		p.Time = now
+		src.key = k
+		pts = append(pts, p)
+		sources[p.Type] = src
+	}
+
+	return pts, sources, nil
+}
+
+// zwavePointType returns the point type for a Z-Wave value, or "" if the
+// value is not published as a point
+func zwavePointType(cc, property string) string {
+	switch cc {
+	case "37", "switch_binary":
+		switch property {
+		case "currentValue":
+			return data.PointTypeSwitch
+		case "targetValue":
+			return ""
+		}
+	case "38", "switch_multilevel":
+		switch property {
+		case "currentValue":
+			return data.PointTypeLevel
+		case "targetValue":
+			return ""
+		}
+	case "128", "battery":
+		switch property {
+		case "level":
+			return data.PointTypeBattery
+		case "isLow":
+			return data.PointTypeBatteryLow
+		}
+	}
+
+	return mqttPointType(property)
+}
+
+// zwavePoint converts a Z-Wave JS UI value message to a point. Value topics
+// are <device>/<command class>/<endpoint>/<property>[/<property key>]
+// after the base topic, and the payload is a value or a JSON object with a
+// value field. ok is false for other topics.
+func zwavePoint(parts []string, topic string, payload []byte, now time.Time) (string, data.Point, mqttSource, bool) {
+	var p data.Point
+	var src mqttSource
+
+	if len(parts) < 4 || strings.HasPrefix(parts[0], "_") ||
+		parts[len(parts)-1] == "set" {
+		return "", p, src, false
+	}
+
+	property := strings.Join(parts[3:], "_")
+	typ := zwavePointType(parts[1], property)
+	if typ == "" {
+		return "", p, src, false
+	}
+
+	var v interface{}
+	if err := json.Unmarshal(payload, &v); err != nil {
+		v = string(payload)
+	}
+
+	if m, ok := v.(map[string]interface{}); ok {
+		v = m["value"]
+	}
+
+	p, src, ok := mqttValue(v)
+	if !ok {
+		return "", p, src, false
+	}
+
+	p.Type = typ
+	p.Time = now
+	if ep := strings.TrimPrefix(parts[2], "endpoint_"); ep != "0" {
+		p.Key = ep
+	}
+	src.topic = topic
+
+	return parts[0], p, src, true
+}
+
+// mqttSetCommand returns the topic and payload used to set a value on a
+// device
+func mqttSetCommand(protocol, base, deviceID, typ string, src mqttSource, v float64) (string, []byte, error) {
+	var value interface{} = v
+	switch {
+	case src.onOff:
+		value = "OFF"
+		if v != 0 {
+			value = "ON"
+		}
+	case src.boolean:
+		value = v != 0
+	}
+
+	if protocol == data.PointValueZwaveJS {
+		if src.topic == "" {
+			return "", nil, fmt.Errorf("%v has not been received from the device", typ)
+		}
+
+		topic := src.topic
+		if strings.HasSuffix(topic, "/currentValue") {
+			topic = strings.TrimSuffix(topic, "currentValue") + "targetValue"
+		}
+
+		payload, err := json.Marshal(value)
+		return topic + "/set", payload, err
+	}
+
+	key := src.key
+	if key == "" {
+		// value has not been received yet, guess the zigbee2mqtt name
+		key = typ
+		if typ == data.PointTypeSwitch {
+			key = "state"
+			value = "OFF"
+			if v != 0 {
+				value = "ON"
+			}
+		}
+	}
+
+	payload, err := json.Marshal(map[string]interface{}{key: value})
+	return base + "/" + deviceID + "/set", payload, err
+}
+
+// zigbeeDevice is an entry of the zigbee2mqtt bridge/devices message
+type zigbeeDevice struct {
+	FriendlyName string `json:"friendly_name"`
+	Type         string `json:"type"`
+	Definition   *struct {
+		Model  string `json:"model"`
+		Vendor string `json:"vendor"`
+	} `json:"definition"`
+}
+
+// MqttBridgeClient is a SIOT client used to integrate zigbee2mqtt and
+// Z-Wave JS UI devices
+type MqttBridgeClient struct {
+	nc            *nats.Conn
+	config        MqttBridge
+	stop          chan struct{}
+	newPoints     chan NewPoints
+	newEdgePoints chan NewPoints
+	mqtt          *mqtt.Client
+	// sources are indexed by device node ID and point type
+	sources map[string]map[string]mqttSource
+}
+
+// NewMqttBridgeClient ...
+func NewMqttBridgeClient(nc *nats.Conn, config MqttBridge) Client {
+	return &MqttBridgeClient{
+		nc:            nc,
+		config:        config,
+		stop:          make(chan struct{}),
+		newPoints:     make(chan NewPoints),
+		newEdgePoints: make(chan NewPoints),
+		sources:       make(map[string]map[string]mqttSource),
+	}
+}
+
+func (mbc *MqttBridgeClient) baseTopic() string {
+	if mbc.config.BaseTopic != "" {
+		return strings.TrimRight(mbc.config.BaseTopic, "/")
+	}
+
+	if mbc.config.Protocol == data.PointValueZwaveJS {
+		return "zwave"
+	}
+
+	return "zigbee2mqtt"
+}
+
+func (mbc *MqttBridgeClient) connect() error {
+	if mbc.config.URI == "" {
+		return errors.New("uri must be set")
+	}
+
+	c, err := mqtt.Dial(mbc.config.URI, mqtt.Options{
+		ClientID: "siot-" + mbc.config.ID,
+		Username: mbc.config.Username,
+		Password: mbc.config.Password,
+	})
+	if err != nil {
+		return err
+	}
+
+	err = c.Subscribe(mbc.baseTopic() + "/#")
+	if err != nil {
+		c.Close()
+		return err
+	}
+
+	mbc.mqtt = c
+	log.Printf("MQTT bridge %v: connected to %v\n", mbc.config.Description, mbc.config.URI)
+	return nil
+}
+
+func (mbc *MqttBridgeClient) disconnect() {
+	if mbc.mqtt != nil {
+		mbc.mqtt.Close()
+		mbc.mqtt = nil
+	}
+}
+
+// device returns the device node for a device ID, and creates it if it does
+// not exist
+func (mbc *MqttBridgeClient) device(deviceID string) (*MqttDevice, error) {
+	for i := range mbc.config.Devices {
+		if mbc.config.Devices[i].DeviceID == deviceID {
+			return &mbc.config.Devices[i], nil
+		}
+	}
+
+	d := MqttDevice{
+		ID:          uuid.New().String(),
+		Parent:      mbc.config.ID,
+		Description: deviceID,
+		DeviceID:    deviceID,
+	}
+
+	// nodes sent without an origin do not restart this client, so the
+	// device is added to the config here
+	err := SendNodeType(mbc.nc, d, "")
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("MQTT bridge %v: added device %v\n", mbc.config.Description, deviceID)
+	mbc.config.Devices = append(mbc.config.Devices, d)
+	return &mbc.config.Devices[len(mbc.config.Devices)-1], nil
+}
+
+func (mbc *MqttBridgeClient) devicePoints(deviceID string, pts data.Points, sources map[string]mqttSource) {
+	d, err := mbc.device(deviceID)
+	if err != nil {
+		log.Println("MQTT bridge error creating device node: ", err)
+		return
+	}
+
+	if d.Disable {
+		return
+	}
+
+	if mbc.sources[d.ID] == nil {
+		mbc.sources[d.ID] = make(map[string]mqttSource)
+	}
+	for t, s := range sources {
+		mbc.sources[d.ID][t] = s
+	}
+
+	if len(pts) == 0 {
+		return
+	}
+
+	err = SendNodePoints(mbc.nc, d.ID, pts, false)
+	if err != nil {
+		log.Println("MQTT bridge error sending points: ", err)
+	}
+}
+
+func (mbc *MqttBridgeClient) handleZigbee(parts []string, payload []byte) {
+	now := time.Now()
+
+	if parts[0] == "bridge" {
+		if len(parts) != 2 || parts[1] != "devices" {
+			return
+		}
+
+		var devices []zigbeeDevice
+		err := json.Unmarshal(payload, &devices)
+		if err != nil {
+			log.Println("MQTT bridge error decoding zigbee2mqtt devices: ", err)
+			return
+		}
+
+		for _, d := range devices {
+			if d.Type == "Coordinator" || d.FriendlyName == "" {
+				continue
+			}
+
+			var pts data.Points
+			if d.Definition != nil {
+				pts = data.Points{
+					{Time: now, Type: data.PointTypeModel, Text: d.Definition.Model},
+					{Time: now, Type: data.PointTypeManufacturer, Text: d.Definition.Vendor},
+				}
+			}
+
+			mbc.devicePoints(d.FriendlyName, pts, nil)
+		}
+		return
+	}
+
+	switch parts[len(parts)-1] {
+	case "set", "get", "availability":
+		return
+	}
+
+	pts, sources, err := zigbeePoints(payload, now)
+	if err != nil {
+		// not a device state message
+		return
+	}
+
+	mbc.devicePoints(strings.Join(parts, "/"), pts, sources)
+}
+
+func (mbc *MqttBridgeClient) handleMessage(m mqtt.Message) {
+	base := mbc.baseTopic() + "/"
+	if !strings.HasPrefix(m.Topic, base) {
+		return
+	}
+
+	parts := strings.Split(strings.TrimPrefix(m.Topic, base), "/")
+
+	if mbc.config.Protocol == data.PointValueZwaveJS {
+		device, p, src, ok := zwavePoint(parts, m.Topic, m.Payload, time.Now())
+		if ok {
+			mbc.devicePoints(device, data.Points{p}, map[string]mqttSource{p.Type: src})
+		}
+		return
+	}
+
+	mbc.handleZigbee(parts, m.Payload)
+}
+
+func (mbc *MqttBridgeClient) write(d MqttDevice, p data.Point) error {
+	if mbc.mqtt == nil {
+		return errors.New("not connected")
+	}
+
+	typ := strings.TrimSuffix(p.Type, "Set")
+	topic, payload, err := mqttSetCommand(mbc.config.Protocol, mbc.baseTopic(),
+		d.DeviceID, typ, mbc.sources[d.ID][typ], p.Value)
+	if err != nil {
+		return err
+	}
+
+	return mbc.mqtt.Publish(topic, payload, false)
+}
+
+// Start runs the main logic for this client and blocks until stopped
+func (mbc *MqttBridgeClient) Start() error {
+	log.Println("Starting MQTT bridge client: ", mbc.config.Description)
+
+	retry := time.NewTimer(0)
+
+	// messages is nil until connected and is closed when the connection is
+	// lost
+	var messages <-chan mqtt.Message
+
+	reconnect := func() {
+		mbc.disconnect()
+		messages = nil
+		retry.Stop()
+
+		if mbc.config.Disable {
+			log.Printf("MQTT bridge %v: disabled\n", mbc.config.Description)
+			return
+		}
+
+		err := mbc.connect()
+		if err != nil {
+			log.Printf("MQTT bridge %v: error connecting: %v\n",
+				mbc.config.Description, err)
+			retry.Reset(mqttRetry)
+			return
+		}
+
+		messages = mbc.mqtt.Messages()
+	}
+
+done:
+	for {
+		select {
+		case <-mbc.stop:
+			log.Println("Stopping MQTT bridge client: ", mbc.config.Description)
+			break done
+		case <-retry.C:
+			reconnect()
+		case m, ok := <-messages:
+			if ok {
+				mbc.handleMessage(m)
+				continue
+			}
+
+			log.Printf("MQTT bridge %v: connection lost: %v\n",
+				mbc.config.Description, mbc.mqtt.Err())
+			mbc.disconnect()
+			messages = nil
+			retry.Reset(mqttRetry)
+		case pts := <-mbc.newPoints:
+			err := data.MergePoints(pts.ID, pts.Points, &mbc.config)
+			if err != nil {
+				log.Println("error merging new points: ", err)
+			}
+
+			if pts.ID == mbc.config.ID {
+				for _, p := range pts.Points {
+					switch p.Type {
+					case data.PointTypeURI, data.PointTypeUsername,
+						data.PointTypePassword, data.PointTypeProtocol,
+						data.PointTypeBaseTopic, data.PointTypeDisable:
+						reconnect()
+					}
+				}
+				continue
+			}
+
+			for _, d := range mbc.config.Devices {
+				if d.ID != pts.ID || d.Disable {
+					continue
+				}
+
+				for _, p := range pts.Points {
+					if !strings.HasSuffix(p.Type, "Set") || p.Tombstone != 0 {
+						continue
+					}
+
+					err := mbc.write(d, p)
+					if err != nil {
+						log.Printf("MQTT bridge %v: error setting %v: %v\n",
+							d.Description, p.Type, err)
+					}
+				}
+			}
+
+		case pts := <-mbc.newEdgePoints:
+			err := data.MergeEdgePoints(pts.ID, pts.Parent, pts.Points, &mbc.config)
+			if err != nil {
+				log.Println("error merging new points: ", err)
+			}
+		}
+	}
+
+	// clean up
+	retry.Stop()
+	mbc.disconnect()
+	return nil
+}
+
+// Stop sends a signal to the Start function to exit
+func (mbc *MqttBridgeClient) Stop(err error) {
+	close(mbc.stop)
+}
+
+// Points is called by the Manager when new points for this
+// node are received.
+func (mbc *MqttBridgeClient) Points(nodeID string, points []data.Point) {
+	mbc.newPoints <- NewPoints{nodeID, "", points}
+}
+
+// EdgePoints is called by the Manager when new edge points for this
+// node are received.
+func (mbc *MqttBridgeClient) EdgePoints(nodeID, parentID string, points []data.Point) {
+	mbc.newEdgePoints <- NewPoints{nodeID, parentID, points}
+}
@@ -0,0 +1,131 @@
+package client
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/simpleiot/simpleiot/data"
+)
+
+func TestMqttCamelCase(t *testing.T) {
+	tests := map[string]string{
+		"water_leak":      "waterLeak",
+		"Air temperature": "airTemperature",
+		"Door-Window":     "doorWindow",
+		"battery":         "battery",
+	}
+
+	for in, exp := range tests {
+		if got := mqttCamelCase(in); got != exp {
+			t.Errorf("%v: got %v, exp %v", in, got, exp)
+		}
+	}
+}
+
+func TestZigbeePoints(t *testing.T) {
+	payload := []byte(`{"battery":97,"contact":false,"temperature":21.5,
+		"state":"ON","linkquality":120,"power_on_behavior":"previous",
+		"color":{"x":0.3,"y":0.3}}`)
+
+	pts, sources, err := zigbeePoints(payload, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	check := func(typ string, value float64) {
+		p, ok := pts.Find(typ, "")
+		if !ok {
+			t.Errorf("point %v not found", typ)
+			return
+		}
+		if p.Value != value {
+			t.Errorf("%v: got %v, exp %v", typ, p.Value, value)
+		}
+	}
+
+	check(data.PointTypeBattery, 97)
+	check("contact", 0)
+	check(data.PointTypeTemperature, 21.5)
+	check(data.PointTypeSwitch, 1)
+	check(data.PointTypeLinkQuality, 120)
+
+	if v, _ := pts.Text("powerOnBehavior", ""); v != "previous" {
+		t.Error("wrong text point: ", v)
+	}
+
+	if _, ok := pts.Find("color", ""); ok {
+		t.Error("objects should not be converted")
+	}
+
+	topic, cmd, err := mqttSetCommand(data.PointValueZigbee2MQTT, "zigbee2mqtt",
+		"lamp", data.PointTypeSwitch, sources[data.PointTypeSwitch], 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if topic != "zigbee2mqtt/lamp/set" || string(cmd) != `{"state":"OFF"}` {
+		t.Errorf("wrong set command: %v %s", topic, cmd)
+	}
+
+	// values not received yet use the point type as the key
+	_, cmd, _ = mqttSetCommand(data.PointValueZigbee2MQTT, "zigbee2mqtt",
+		"lamp", "brightness", mqttSource{}, 128)
+	if string(cmd) != `{"brightness":128}` {
+		t.Errorf("wrong set command: %s", cmd)
+	}
+}
+
+func TestZwavePoint(t *testing.T) {
+	topic := "zwave/nodeID_5/37/0/currentValue"
+	parts := strings.Split(strings.TrimPrefix(topic, "zwave/"), "/")
+
+	device, p, src, ok := zwavePoint(parts, topic,
+		[]byte(`{"time":1660000000000,"value":true}`), time.Now())
+	if !ok {
+		t.Fatal("value not converted")
+	}
+
+	if device != "nodeID_5" || p.Type != data.PointTypeSwitch || p.Value != 1 ||
+		p.Key != "" {
+		t.Errorf("wrong point: %v %v", device, p)
+	}
+
+	setTopic, cmd, err := mqttSetCommand(data.PointValueZwaveJS, "zwave",
+		device, p.Type, src, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if setTopic != "zwave/nodeID_5/37/0/targetValue/set" || string(cmd) != "false" {
+		t.Errorf("wrong set command: %v %s", setTopic, cmd)
+	}
+
+	_, p, _, ok = zwavePoint([]string{"kitchen", "sensor_multilevel",
+		"endpoint_1", "Air_temperature"}, "", []byte("21.5"), time.Now())
+	if !ok || p.Type != data.PointTypeTemperature || p.Value != 21.5 || p.Key != "1" {
+		t.Errorf("wrong point: %v", p)
+	}
+
+	_, p, _, ok = zwavePoint([]string{"nodeID_5", "128", "0", "level"}, "",
+		[]byte("80"), time.Now())
+	if !ok || p.Type != data.PointTypeBattery || p.Value != 80 {
+		t.Errorf("wrong battery point: %v", p)
+	}
+
+	for _, parts := range [][]string{
+		{"_CLIENTS", "ZWAVE_GATEWAY-x", "status", "x"},
+		{"nodeID_5", "37", "0", "targetValue"},
+		{"nodeID_5", "37", "0", "targetValue", "set"},
+		{"nodeID_5", "status"},
+	} {
+		if _, _, _, ok := zwavePoint(parts, "", []byte("1"), time.Now()); ok {
+			t.Errorf("%v should not be converted", parts)
+		}
+	}
+
+	if _, _, err := mqttSetCommand(data.PointValueZwaveJS, "zwave", "nodeID_5",
+		data.PointTypeLevel, mqttSource{}, 50); err == nil {
+		t.Error("expected error for unknown Z-Wave value")
+	}
+}
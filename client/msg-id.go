@@ -0,0 +1,57 @@
+package client
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"github.com/nats-io/nats.go"
+)
+
+// HeaderMsgID is the NATS header that carries the ID of a point message. The
+// store remembers the IDs of the messages it handled for a short time, and
+// acks messages with an ID it has already seen without processing them
+// again. This makes it safe to retry a send when the ack was lost.
+const HeaderMsgID = "Siot-Msg-Id"
+
+type msgIDKey struct{}
+
+// WithMsgID returns a context that sets the message ID of the points sent
+// with it (SendNodePointsCtx, etc). This can be used to retry a send with the
+// same ID after the helper has returned, for instance when a device flushes
+// buffered points after a restart. If no ID is set, the helpers use a new ID
+// for each call when ack is true and requests are retried (see
+// RequestOptions), so their own retries are not processed twice.
+func WithMsgID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, msgIDKey{}, id)
+}
+
+// MsgID returns the message ID set with WithMsgID, or ""
+func MsgID(ctx context.Context) string {
+	id, _ := ctx.Value(msgIDKey{}).(string)
+	return id
+}
+
+// withNewMsgID returns ctx with a new message ID, unless it already has one.
+// The ID is only needed to detect retries, so none is set if requests are not
+// retried.
+func withNewMsgID(ctx context.Context) context.Context {
+	if MsgID(ctx) != "" || GetRequestOptions().Retries <= 0 {
+		return ctx
+	}
+	return WithMsgID(ctx, uuid.New().String())
+}
+
+// setMsgID sets the message ID header of msg from ctx if the connection
+// supports headers
+func setMsgID(ctx context.Context, nc *nats.Conn, msg *nats.Msg) {
+	id := MsgID(ctx)
+	if id == "" || !nc.HeadersSupported() {
+		return
+	}
+
+	if msg.Header == nil {
+		msg.Header = nats.Header{}
+	}
+
+	msg.Header.Set(HeaderMsgID, id)
+}
@@ -10,11 +10,16 @@ import (
 	"github.com/simpleiot/simpleiot/data"
 )
 
-// DecodeNodePointsMsg decodes NATS message into node ID and points
+// ErrDecodeSubject is returned when a message subject does not have the
+// expected format.
+var ErrDecodeSubject = errors.New("malformed subject")
+
+// DecodeNodePointsMsg decodes NATS message into node ID and points. Decode
+// errors wrap ErrDecodeSubject or one of the data.ErrDecode* errors.
 func DecodeNodePointsMsg(msg *nats.Msg) (string, []data.Point, error) {
 	chunks := strings.Split(msg.Subject, ".")
-	if len(chunks) < 3 {
-		return "", []data.Point{}, errors.New("Error decoding node points subject")
+	if len(chunks) < 3 || chunks[1] == "" {
+		return "", []data.Point{}, fmt.Errorf("Error decoding node points subject: %w", ErrDecodeSubject)
 	}
 	nodeID := chunks[1]
 	points, err := data.PbDecodePoints(msg.Data)
@@ -26,11 +31,12 @@ func DecodeNodePointsMsg(msg *nats.Msg) (string, []data.Point, error) {
 	return nodeID, points, nil
 }
 
-// DecodeEdgePointsMsg decodes NATS message into node ID and points
+// DecodeEdgePointsMsg decodes NATS message into node ID and points. Decode
+// errors wrap ErrDecodeSubject or one of the data.ErrDecode* errors.
 func DecodeEdgePointsMsg(msg *nats.Msg) (string, string, []data.Point, error) {
 	chunks := strings.Split(msg.Subject, ".")
-	if len(chunks) < 4 {
-		return "", "", []data.Point{}, errors.New("Error decoding edge points subject")
+	if len(chunks) < 4 || chunks[1] == "" || chunks[2] == "" {
+		return "", "", []data.Point{}, fmt.Errorf("Error decoding edge points subject: %w", ErrDecodeSubject)
 	}
 	nodeID := chunks[1]
 	parentID := chunks[2]
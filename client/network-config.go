@@ -0,0 +1,286 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/simpleiot/simpleiot/data"
+)
+
+// NetworkConfig config. A network config node manages one host network
+// interface with NetworkManager (nmcli). The interface can be configured
+// for WiFi (WifiSSID set), cellular (APN set), or ethernet, with DHCP or a
+// static IP. Link state, signal strength, and IP addresses are reported
+// back as points.
+type NetworkConfig struct {
+	ID             string  `node:"id"`
+	Parent         string  `node:"parent"`
+	Description    string  `point:"description"`
+	Interface      string  `point:"interface"`
+	IPMode         string  `point:"ipMode"`
+	IPAddress      string  `point:"ipAddress"`
+	Gateway        string  `point:"gateway"`
+	DNS            string  `point:"dns"`
+	WifiSSID       string  `point:"wifiSSID"`
+	WifiPSK        string  `point:"wifiPSK"`
+	APN            string  `point:"apn"`
+	LinkState      string  `point:"linkState"`
+	SignalStrength float64 `point:"signalStrength"`
+	IPAddresses    string  `point:"ipAddresses"`
+	Disable        bool    `point:"disable"`
+}
+
+// NetworkConfigClient for network config nodes
+type NetworkConfigClient struct {
+	nc            *nats.Conn
+	config        NetworkConfig
+	stop          chan struct{}
+	newPoints     chan NewPoints
+	newEdgePoints chan NewPoints
+}
+
+// NewNetworkConfigClient ...
+func NewNetworkConfigClient(nc *nats.Conn, config NetworkConfig) Client {
+	return &NetworkConfigClient{
+		nc:            nc,
+		config:        config,
+		stop:          make(chan struct{}),
+		newPoints:     make(chan NewPoints),
+		newEdgePoints: make(chan NewPoints),
+	}
+}
+
+// nmcliArgs returns the nmcli commands needed to apply a network config.
+// SIOT manages one NetworkManager connection per interface named
+// siot-<interface>, which is re-created each time the config is applied.
+func nmcliArgs(config NetworkConfig) ([][]string, error) {
+	if config.Interface == "" {
+		return nil, errors.New("Interface must be set")
+	}
+
+	con := "siot-" + config.Interface
+
+	ret := [][]string{{"nmcli", "con", "delete", con}}
+
+	switch {
+	case config.APN != "":
+		ret = append(ret, []string{"nmcli", "con", "add", "type", "gsm",
+			"ifname", config.Interface, "con-name", con, "apn", config.APN})
+	case config.WifiSSID != "":
+		ret = append(ret, []string{"nmcli", "con", "add", "type", "wifi",
+			"ifname", config.Interface, "con-name", con, "ssid", config.WifiSSID})
+		if config.WifiPSK != "" {
+			ret = append(ret, []string{"nmcli", "con", "modify", con,
+				"wifi-sec.key-mgmt", "wpa-psk", "wifi-sec.psk", config.WifiPSK})
+		}
+	default:
+		ret = append(ret, []string{"nmcli", "con", "add", "type", "ethernet",
+			"ifname", config.Interface, "con-name", con})
+	}
+
+	switch config.IPMode {
+	case data.PointValueDHCP, "":
+		ret = append(ret, []string{"nmcli", "con", "modify", con,
+			"ipv4.method", "auto"})
+	case data.PointValueStatic:
+		if _, _, err := net.ParseCIDR(config.IPAddress); err != nil {
+			return nil, fmt.Errorf("IPAddress must be in CIDR format (192.168.1.10/24): %v",
+				config.IPAddress)
+		}
+
+		args := []string{"nmcli", "con", "modify", con,
+			"ipv4.method", "manual", "ipv4.addresses", config.IPAddress}
+
+		if config.Gateway != "" {
+			args = append(args, "ipv4.gateway", config.Gateway)
+		}
+
+		if config.DNS != "" {
+			args = append(args, "ipv4.dns", config.DNS)
+		}
+
+		ret = append(ret, args)
+	default:
+		return nil, fmt.Errorf("Unknown IP mode: %v", config.IPMode)
+	}
+
+	ret = append(ret, []string{"nmcli", "con", "up", con})
+
+	return ret, nil
+}
+
+// parseWifiSignal parses the output of
+// `nmcli -t -f IN-USE,SIGNAL dev wifi list` and returns the signal strength
+// of the connected access point.
+func parseWifiSignal(out string) (float64, bool) {
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Split(strings.TrimSpace(line), ":")
+		if len(fields) != 2 || fields[0] != "*" {
+			continue
+		}
+
+		v, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil {
+			continue
+		}
+
+		return v, true
+	}
+
+	return 0, false
+}
+
+func (ncc *NetworkConfigClient) apply() {
+	if ncc.config.Disable {
+		log.Printf("Network config %v: disabled\n", ncc.config.Description)
+		return
+	}
+
+	cmds, err := nmcliArgs(ncc.config)
+	if err != nil {
+		log.Printf("Network config %v: %v\n", ncc.config.Description, err)
+		return
+	}
+
+	log.Printf("Network config %v: configuring %v\n", ncc.config.Description,
+		ncc.config.Interface)
+
+	for i, args := range cmds {
+		err := runHostCommand(context.Background(), args)
+		// the first command deletes the existing connection, which
+		// fails if there is not one
+		if err != nil && i > 0 {
+			// don't log args as they may contain the WiFi PSK
+			log.Printf("Network config %v: error running %v %v: %v\n",
+				ncc.config.Description, args[0], args[1:3], err)
+			return
+		}
+	}
+}
+
+func (ncc *NetworkConfigClient) status() data.Points {
+	now := time.Now()
+
+	iface, err := net.InterfaceByName(ncc.config.Interface)
+	if err != nil {
+		return data.Points{{Time: now, Type: data.PointTypeLinkState,
+			Text: data.PointValueDown}}
+	}
+
+	link := data.PointValueDown
+	if iface.Flags&net.FlagUp != 0 {
+		link = data.PointValueUp
+	}
+
+	var ips []string
+	addrs, err := iface.Addrs()
+	if err == nil {
+		for _, a := range addrs {
+			ips = append(ips, a.String())
+		}
+	}
+
+	ret := data.Points{
+		{Time: now, Type: data.PointTypeLinkState, Text: link},
+		{Time: now, Type: data.PointTypeIPAddresses, Text: strings.Join(ips, ",")},
+	}
+
+	if ncc.config.WifiSSID != "" {
+		out, err := exec.Command("nmcli", "-t", "-f", "IN-USE,SIGNAL", "dev",
+			"wifi", "list", "ifname", ncc.config.Interface).Output()
+		if err == nil {
+			if v, ok := parseWifiSignal(string(out)); ok {
+				ret = append(ret, data.Point{Time: now,
+					Type: data.PointTypeSignalStrength, Value: v})
+			}
+		}
+	}
+
+	return ret
+}
+
+// Start runs the main logic for this client and blocks until stopped
+func (ncc *NetworkConfigClient) Start() error {
+	log.Println("Starting network config client: ", ncc.config.Description)
+
+	// NetworkManager persists connections, so config is only applied when
+	// it changes, not each time the client starts.
+	t := time.NewTicker(30 * time.Second)
+
+	// wait for config changes to settle before applying
+	applyTimer := time.NewTimer(time.Hour)
+	applyTimer.Stop()
+
+done:
+	for {
+		select {
+		case <-ncc.stop:
+			log.Println("Stopping network config client: ", ncc.config.Description)
+			break done
+		case <-t.C:
+			if ncc.config.Disable || ncc.config.Interface == "" {
+				continue
+			}
+
+			pts := ncc.status()
+			err := SendNodePoints(ncc.nc, ncc.config.ID, pts, false)
+			if err != nil {
+				log.Println("Network config error sending points: ", err)
+			}
+		case <-applyTimer.C:
+			ncc.apply()
+		case pts := <-ncc.newPoints:
+			err := data.MergePoints(pts.ID, pts.Points, &ncc.config)
+			if err != nil {
+				log.Println("error merging new points: ", err)
+			}
+
+			for _, p := range pts.Points {
+				switch p.Type {
+				case data.PointTypeInterface, data.PointTypeIPMode,
+					data.PointTypeIPAddress, data.PointTypeGateway,
+					data.PointTypeDNS, data.PointTypeWifiSSID,
+					data.PointTypeWifiPSK, data.PointTypeAPN,
+					data.PointTypeDisable:
+					applyTimer.Reset(2 * time.Second)
+				}
+			}
+
+		case pts := <-ncc.newEdgePoints:
+			err := data.MergeEdgePoints(pts.ID, pts.Parent, pts.Points, &ncc.config)
+			if err != nil {
+				log.Println("error merging new points: ", err)
+			}
+		}
+	}
+
+	// clean up
+	t.Stop()
+	applyTimer.Stop()
+	return nil
+}
+
+// Stop sends a signal to the Start function to exit
+func (ncc *NetworkConfigClient) Stop(err error) {
+	close(ncc.stop)
+}
+
+// Points is called by the Manager when new points for this
+// node are received.
+func (ncc *NetworkConfigClient) Points(nodeID string, points []data.Point) {
+	ncc.newPoints <- NewPoints{nodeID, "", points}
+}
+
+// EdgePoints is called by the Manager when new edge points for this
+// node are received.
+func (ncc *NetworkConfigClient) EdgePoints(nodeID, parentID string, points []data.Point) {
+	ncc.newEdgePoints <- NewPoints{nodeID, parentID, points}
+}
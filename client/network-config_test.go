@@ -0,0 +1,63 @@
+package client
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/simpleiot/simpleiot/data"
+)
+
+func TestNmcliArgsWifiStatic(t *testing.T) {
+	cmds, err := nmcliArgs(NetworkConfig{
+		Interface: "wlan0",
+		WifiSSID:  "myssid",
+		WifiPSK:   "secret",
+		IPMode:    data.PointValueStatic,
+		IPAddress: "192.168.1.10/24",
+		Gateway:   "192.168.1.1",
+	})
+
+	if err != nil {
+		t.Fatal("Got error: ", err)
+	}
+
+	exp := [][]string{
+		{"nmcli", "con", "delete", "siot-wlan0"},
+		{"nmcli", "con", "add", "type", "wifi", "ifname", "wlan0", "con-name",
+			"siot-wlan0", "ssid", "myssid"},
+		{"nmcli", "con", "modify", "siot-wlan0", "wifi-sec.key-mgmt", "wpa-psk",
+			"wifi-sec.psk", "secret"},
+		{"nmcli", "con", "modify", "siot-wlan0", "ipv4.method", "manual",
+			"ipv4.addresses", "192.168.1.10/24", "ipv4.gateway", "192.168.1.1"},
+		{"nmcli", "con", "up", "siot-wlan0"},
+	}
+
+	if !reflect.DeepEqual(cmds, exp) {
+		t.Errorf("Expected:\n%v\ngot:\n%v", exp, cmds)
+	}
+}
+
+func TestNmcliArgsErrors(t *testing.T) {
+	_, err := nmcliArgs(NetworkConfig{})
+	if err == nil {
+		t.Error("Expected error without interface")
+	}
+
+	_, err = nmcliArgs(NetworkConfig{Interface: "eth0", IPMode: data.PointValueStatic,
+		IPAddress: "192.168.1.10"})
+	if err == nil {
+		t.Error("Expected error for IP without prefix")
+	}
+}
+
+func TestParseWifiSignal(t *testing.T) {
+	v, ok := parseWifiSignal(" :40\n*:75\n :20\n")
+	if !ok || v != 75 {
+		t.Errorf("Expected 75, got %v %v", v, ok)
+	}
+
+	_, ok = parseWifiSignal(" :40\n")
+	if ok {
+		t.Error("Expected no signal when not connected")
+	}
+}
@@ -0,0 +1,365 @@
+package client
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/simpleiot/simpleiot/data"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// NetworkMonitor config. A network monitor node checks the reachability of
+// its target child nodes every samplePeriod seconds (default 60). Targets
+// without a port are pinged (ICMP echo), others are checked by opening a TCP
+// connection to the port. A check fails if there is no response within
+// timeout seconds (default 2).
+type NetworkMonitor struct {
+	ID           string          `node:"id"`
+	Parent       string          `node:"parent"`
+	Description  string          `point:"description"`
+	SamplePeriod float64         `point:"samplePeriod"`
+	Timeout      float64         `point:"timeout"`
+	Disable      bool            `point:"disable"`
+	Targets      []NetworkTarget `child:"networkTarget"`
+}
+
+// NetworkTarget is a host checked by a network monitor. The online,
+// latency, and packetLoss (ping only) points are written to the target node.
+type NetworkTarget struct {
+	ID          string `node:"id"`
+	Parent      string `node:"parent"`
+	Description string `point:"description"`
+	Host        string `point:"host"`
+	Port        int    `point:"port"`
+	Disable     bool   `point:"disable"`
+}
+
+// netPingCount is the number of echo requests sent for each ping check
+const netPingCount = 3
+
+// netPing sends count ICMP echo requests to host, interval apart, and
+// returns the round trip times of the replies received within timeout of
+// each request. Unprivileged ICMP sockets are used if the OS allows them
+// (on Linux, see net.ipv4.ping_group_range), otherwise raw sockets, which
+// require root or CAP_NET_RAW.
+func netPing(host string, count int, interval, timeout time.Duration) ([]time.Duration, error) {
+	addr, err := net.ResolveIPAddr("ip", host)
+	if err != nil {
+		return nil, err
+	}
+
+	v4 := addr.IP.To4() != nil
+
+	dgram, raw := "udp6", "ip6:ipv6-icmp"
+	proto := ipv6.ICMPTypeEchoRequest.Protocol()
+	var echo, reply icmp.Type = ipv6.ICMPTypeEchoRequest, ipv6.ICMPTypeEchoReply
+	if v4 {
+		dgram, raw = "udp4", "ip4:icmp"
+		proto = ipv4.ICMPTypeEcho.Protocol()
+		echo, reply = ipv4.ICMPTypeEcho, ipv4.ICMPTypeEchoReply
+	}
+
+	var dst net.Addr = &net.UDPAddr{IP: addr.IP, Zone: addr.Zone}
+
+	conn, err := icmp.ListenPacket(dgram, "")
+	if err != nil {
+		conn, err = icmp.ListenPacket(raw, "")
+		if err != nil {
+			return nil, fmt.Errorf("error opening ICMP socket: %v", err)
+		}
+		dst = addr
+	}
+	defer conn.Close()
+
+	// the token identifies our replies, as raw sockets receive all ICMP
+	// messages and the OS sets the ID of unprivileged echo requests
+	token := make([]byte, 8)
+	_, err = rand.Read(token)
+	if err != nil {
+		return nil, err
+	}
+
+	id := os.Getpid() & 0xffff
+	buf := make([]byte, 1500)
+	var ret []time.Duration
+
+	for seq := 0; seq < count; seq++ {
+		if seq > 0 {
+			time.Sleep(interval)
+		}
+
+		msg := icmp.Message{Type: echo, Body: &icmp.Echo{ID: id, Seq: seq, Data: token}}
+		b, err := msg.Marshal(nil)
+		if err != nil {
+			return nil, err
+		}
+
+		start := time.Now()
+
+		_, err = conn.WriteTo(b, dst)
+		if err != nil {
+			return nil, err
+		}
+
+		err = conn.SetReadDeadline(start.Add(timeout))
+		if err != nil {
+			return nil, err
+		}
+
+		for {
+			n, _, err := conn.ReadFrom(buf)
+			if err != nil {
+				var ne net.Error
+				if errors.As(err, &ne) && ne.Timeout() {
+					break
+				}
+				return nil, err
+			}
+
+			m, err := icmp.ParseMessage(proto, buf[:n])
+			if err != nil || m.Type != reply {
+				continue
+			}
+
+			e, ok := m.Body.(*icmp.Echo)
+			if !ok || e.Seq != seq || !bytes.Equal(e.Data, token) {
+				continue
+			}
+
+			ret = append(ret, time.Since(start))
+			break
+		}
+	}
+
+	return ret, nil
+}
+
+// netConnect returns the time it takes to open a TCP connection to host:port
+func netConnect(host string, port int, timeout time.Duration) (time.Duration, error) {
+	start := time.Now()
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(host, strconv.Itoa(port)), timeout)
+	if err != nil {
+		return 0, err
+	}
+	ret := time.Since(start)
+	conn.Close()
+	return ret, nil
+}
+
+// durationMs converts a duration to milliseconds
+func durationMs(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// netCheck checks a target and returns the points for the target node
+func netCheck(target NetworkTarget, timeout time.Duration, now time.Time) (data.Points, error) {
+	online := func(ok bool) data.Point {
+		return data.Point{Time: now, Type: data.PointTypeOnline, Value: data.BoolToFloat(ok)}
+	}
+
+	if target.Port > 0 {
+		latency, err := netConnect(target.Host, target.Port, timeout)
+		if err != nil {
+			// the target is offline, errors are not returned
+			return data.Points{online(false)}, nil
+		}
+
+		return data.Points{
+			online(true),
+			{Time: now, Type: data.PointTypeLatency, Value: durationMs(latency)},
+		}, nil
+	}
+
+	rtts, err := netPing(target.Host, netPingCount, 200*time.Millisecond, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := data.Points{
+		online(len(rtts) > 0),
+		{Time: now, Type: data.PointTypePacketLoss,
+			Value: 100 * float64(netPingCount-len(rtts)) / netPingCount},
+	}
+
+	if len(rtts) > 0 {
+		var sum time.Duration
+		for _, r := range rtts {
+			sum += r
+		}
+		ret = append(ret, data.Point{Time: now, Type: data.PointTypeLatency,
+			Value: durationMs(sum / time.Duration(len(rtts)))})
+	}
+
+	return ret, nil
+}
+
+// NetworkMonitorClient is a SIOT client used to check network hosts
+type NetworkMonitorClient struct {
+	nc            *nats.Conn
+	config        NetworkMonitor
+	stop          chan struct{}
+	newPoints     chan NewPoints
+	newEdgePoints chan NewPoints
+}
+
+// NewNetworkMonitorClient ...
+func NewNetworkMonitorClient(nc *nats.Conn, config NetworkMonitor) Client {
+	return &NetworkMonitorClient{
+		nc:            nc,
+		config:        config,
+		stop:          make(chan struct{}),
+		newPoints:     make(chan NewPoints),
+		newEdgePoints: make(chan NewPoints),
+	}
+}
+
+// check checks all targets in parallel
+func (nmc *NetworkMonitorClient) check(targets []NetworkTarget, timeout time.Duration) {
+	var wg sync.WaitGroup
+
+	for _, t := range targets {
+		if t.Disable || t.Host == "" {
+			continue
+		}
+
+		wg.Add(1)
+		go func(t NetworkTarget) {
+			defer wg.Done()
+
+			pts, err := netCheck(t, timeout, time.Now())
+			if err != nil {
+				log.Printf("Network monitor %v: error checking %v: %v\n",
+					nmc.config.Description, t.Host, err)
+				return
+			}
+
+			err = SendNodePoints(nmc.nc, t.ID, pts, false)
+			if err != nil {
+				log.Println("Network monitor error sending points: ", err)
+			}
+		}(t)
+	}
+
+	wg.Wait()
+}
+
+// Start runs the main logic for this client and blocks until stopped
+func (nmc *NetworkMonitorClient) Start() error {
+	log.Println("Starting network monitor client: ", nmc.config.Description)
+
+	t := time.NewTicker(time.Hour)
+	t.Stop()
+
+	// checking is set while a check is running
+	var checking chan struct{}
+
+	check := func() {
+		if checking != nil {
+			select {
+			case <-checking:
+			default:
+				// the last check is still running
+				return
+			}
+		}
+
+		timeout := time.Duration(nmc.config.Timeout * float64(time.Second))
+		if timeout <= 0 {
+			timeout = 2 * time.Second
+		}
+
+		targets := make([]NetworkTarget, len(nmc.config.Targets))
+		copy(targets, nmc.config.Targets)
+
+		checking = make(chan struct{})
+		go func(done chan struct{}) {
+			nmc.check(targets, timeout)
+			close(done)
+		}(checking)
+	}
+
+	setup := func() {
+		t.Stop()
+
+		if nmc.config.Disable {
+			log.Printf("Network monitor %v: disabled\n", nmc.config.Description)
+			return
+		}
+
+		period := nmc.config.SamplePeriod
+		if period <= 0 {
+			period = 60
+		}
+
+		t.Reset(time.Duration(period * float64(time.Second)))
+		check()
+	}
+
+	setup()
+
+done:
+	for {
+		select {
+		case <-nmc.stop:
+			log.Println("Stopping network monitor client: ", nmc.config.Description)
+			break done
+		case <-t.C:
+			check()
+		case pts := <-nmc.newPoints:
+			err := data.MergePoints(pts.ID, pts.Points, &nmc.config)
+			if err != nil {
+				log.Println("error merging new points: ", err)
+			}
+
+			if pts.ID == nmc.config.ID {
+				for _, p := range pts.Points {
+					switch p.Type {
+					case data.PointTypeSamplePeriod, data.PointTypeDisable:
+						setup()
+					}
+				}
+			}
+
+		case pts := <-nmc.newEdgePoints:
+			err := data.MergeEdgePoints(pts.ID, pts.Parent, pts.Points, &nmc.config)
+			if err != nil {
+				log.Println("error merging new points: ", err)
+			}
+		}
+	}
+
+	// clean up
+	t.Stop()
+	if checking != nil {
+		<-checking
+	}
+	return nil
+}
+
+// Stop sends a signal to the Start function to exit
+func (nmc *NetworkMonitorClient) Stop(err error) {
+	close(nmc.stop)
+}
+
+// Points is called by the Manager when new points for this
+// node are received.
+func (nmc *NetworkMonitorClient) Points(nodeID string, points []data.Point) {
+	nmc.newPoints <- NewPoints{nodeID, "", points}
+}
+
+// EdgePoints is called by the Manager when new edge points for this
+// node are received.
+func (nmc *NetworkMonitorClient) EdgePoints(nodeID, parentID string, points []data.Point) {
+	nmc.newEdgePoints <- NewPoints{nodeID, parentID, points}
+}
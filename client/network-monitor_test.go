@@ -0,0 +1,65 @@
+package client
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/simpleiot/simpleiot/data"
+)
+
+func TestNetCheckTCP(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := l.Addr().(*net.TCPAddr).Port
+
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			c.Close()
+		}
+	}()
+
+	target := NetworkTarget{Host: "127.0.0.1", Port: port}
+
+	pts, err := netCheck(target, time.Second, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if v, _ := pts.Value(data.PointTypeOnline, ""); v != 1 {
+		t.Error("target should be online: ", pts)
+	}
+
+	if _, ok := pts.Find(data.PointTypeLatency, ""); !ok {
+		t.Error("latency not found")
+	}
+
+	l.Close()
+
+	pts, err = netCheck(target, time.Second, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if v, ok := pts.Value(data.PointTypeOnline, ""); !ok || v != 0 {
+		t.Error("target should be offline: ", pts)
+	}
+}
+
+func TestNetPing(t *testing.T) {
+	rtts, err := netPing("127.0.0.1", 2, 10*time.Millisecond, time.Second)
+	if err != nil {
+		// ICMP sockets require privileges on some systems
+		t.Skip("ICMP not available: ", err)
+	}
+
+	if len(rtts) != 2 {
+		t.Error("expected 2 replies, got: ", len(rtts))
+	}
+}
@@ -0,0 +1,173 @@
+package client
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/simpleiot/simpleiot/data"
+)
+
+// NodeUI describes an edit form for a node type. The frontend uses node UI
+// descriptors to display and edit node types that do not have a custom
+// view, so new clients get an edit form without frontend changes.
+type NodeUI struct {
+	Type  string `json:"type"`
+	Label string `json:"label"`
+	// Parents are the node types the node can be added to
+	Parents []string      `json:"parents"`
+	Fields  []NodeUIField `json:"fields"`
+}
+
+// NodeUIField is one field in a node edit form
+type NodeUIField struct {
+	Point string `json:"point"`
+	Key   string `json:"key,omitempty"`
+	Label string `json:"label"`
+	// Input is one of text, number, checkbox, or option
+	Input   string         `json:"input"`
+	Options []NodeUIOption `json:"options,omitempty"`
+}
+
+// NodeUIOption is a choice for an option input
+type NodeUIOption struct {
+	Value string `json:"value"`
+	Label string `json:"label"`
+}
+
+// node UI input types
+const (
+	NodeUIInputText     = "text"
+	NodeUIInputNumber   = "number"
+	NodeUIInputCheckbox = "checkbox"
+	NodeUIInputOption   = "option"
+)
+
+var nodeUIs = struct {
+	lock sync.Mutex
+	uis  map[string]NodeUI
+	// explicit is set for descriptors registered with RegisterNodeUI,
+	// which are not replaced by generated descriptors
+	explicit map[string]bool
+}{uis: make(map[string]NodeUI), explicit: make(map[string]bool)}
+
+// RegisterNodeUI registers an edit form for a node type. This replaces the
+// form generated from the client config type by the client manager, and can
+// be used to set labels and option inputs.
+func RegisterNodeUI(ui NodeUI) {
+	nodeUIs.lock.Lock()
+	defer nodeUIs.lock.Unlock()
+	nodeUIs.uis[ui.Type] = ui
+	nodeUIs.explicit[ui.Type] = true
+}
+
+// registerGeneratedNodeUI registers a generated descriptor unless one was
+// registered with RegisterNodeUI
+func registerGeneratedNodeUI(ui NodeUI) {
+	nodeUIs.lock.Lock()
+	defer nodeUIs.lock.Unlock()
+	if !nodeUIs.explicit[ui.Type] {
+		nodeUIs.uis[ui.Type] = ui
+	}
+}
+
+// NodeUIs returns all registered node UI descriptors sorted by type
+func NodeUIs() []NodeUI {
+	nodeUIs.lock.Lock()
+	defer nodeUIs.lock.Unlock()
+
+	ret := make([]NodeUI, 0, len(nodeUIs.uis))
+	for _, ui := range nodeUIs.uis {
+		ret = append(ret, ui)
+	}
+
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Type < ret[j].Type
+	})
+
+	return ret
+}
+
+// nodeUILabel converts a point or node type name to a label, for
+// example samplePeriod -> Sample period
+func nodeUILabel(name string) string {
+	var b strings.Builder
+	for i, r := range name {
+		switch {
+		case i == 0:
+			b.WriteRune(unicode.ToUpper(r))
+		case unicode.IsUpper(r):
+			b.WriteRune(' ')
+			b.WriteRune(unicode.ToLower(r))
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// nodeUIsFromType generates descriptors for a client config type and the
+// child node types in it
+func nodeUIsFromType(t reflect.Type, nodeType string, parents []string) []NodeUI {
+	ui := NodeUI{Type: nodeType, Label: nodeUILabel(nodeType), Parents: parents}
+	if t.Kind() != reflect.Struct {
+		return []NodeUI{ui}
+	}
+
+	var ret []NodeUI
+
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+
+		if child := sf.Tag.Get("child"); child != "" {
+			if sf.Type.Kind() == reflect.Slice &&
+				sf.Type.Elem().Kind() == reflect.Struct {
+				ret = append(ret, nodeUIsFromType(sf.Type.Elem(), child,
+					[]string{nodeType})...)
+			}
+			continue
+		}
+
+		pt := sf.Tag.Get("point")
+		if pt == "" {
+			continue
+		}
+
+		f := NodeUIField{Point: pt, Label: nodeUILabel(pt)}
+
+		switch sf.Type.Kind() {
+		case reflect.Bool:
+			f.Input = NodeUIInputCheckbox
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+			reflect.Float32, reflect.Float64:
+			f.Input = NodeUIInputNumber
+		case reflect.String:
+			f.Input = NodeUIInputText
+		default:
+			// arrays and maps of points are not supported in forms
+			continue
+		}
+
+		if pt == data.PointTypeDescription {
+			ui.Fields = append([]NodeUIField{f}, ui.Fields...)
+		} else {
+			ui.Fields = append(ui.Fields, f)
+		}
+	}
+
+	return append([]NodeUI{ui}, ret...)
+}
+
+// NodeUIFromType generates a node UI descriptor from a client config type.
+// Fields with point tags become form fields, with the input type chosen from
+// the Go type. Descriptors for child node types are not included.
+func NodeUIFromType[T any]() NodeUI {
+	var x T
+	t := reflect.TypeOf(x)
+	nodeType := strings.ToLower(t.Name()[0:1]) + t.Name()[1:]
+	return nodeUIsFromType(t, nodeType,
+		[]string{data.NodeTypeDevice, data.NodeTypeGroup})[0]
+}
@@ -0,0 +1,70 @@
+package client
+
+import (
+	"reflect"
+	"testing"
+)
+
+type testWidgetPart struct {
+	ID          string  `node:"id"`
+	Description string  `point:"description"`
+	Value       float64 `point:"value"`
+}
+
+type testWidget struct {
+	ID           string           `node:"id"`
+	Parent       string           `node:"parent"`
+	SamplePeriod int              `point:"samplePeriod"`
+	Description  string           `point:"description"`
+	Disable      bool             `point:"disable"`
+	Values       []float64        `point:"value"`
+	Role         string           `edgepoint:"role"`
+	Children     []testWidgetPart `child:"testWidgetPart"`
+}
+
+func TestNodeUIsFromType(t *testing.T) {
+	uis := nodeUIsFromType(reflect.TypeOf(testWidget{}), "testWidget",
+		[]string{"device"})
+
+	exp := []NodeUI{
+		{
+			Type:    "testWidget",
+			Label:   "Test widget",
+			Parents: []string{"device"},
+			Fields: []NodeUIField{
+				{Point: "description", Label: "Description", Input: NodeUIInputText},
+				{Point: "samplePeriod", Label: "Sample period", Input: NodeUIInputNumber},
+				{Point: "disable", Label: "Disable", Input: NodeUIInputCheckbox},
+			},
+		},
+		{
+			Type:    "testWidgetPart",
+			Label:   "Test widget part",
+			Parents: []string{"testWidget"},
+			Fields: []NodeUIField{
+				{Point: "description", Label: "Description", Input: NodeUIInputText},
+				{Point: "value", Label: "Value", Input: NodeUIInputNumber},
+			},
+		},
+	}
+
+	if !reflect.DeepEqual(uis, exp) {
+		t.Errorf("wrong node UIs:\n%+v\nexpected:\n%+v", uis, exp)
+	}
+}
+
+func TestRegisterNodeUI(t *testing.T) {
+	RegisterNodeUI(NodeUI{Type: "testUIExplicit", Label: "Explicit"})
+	registerGeneratedNodeUI(NodeUI{Type: "testUIExplicit", Label: "Generated"})
+
+	for _, ui := range NodeUIs() {
+		if ui.Type == "testUIExplicit" {
+			if ui.Label != "Explicit" {
+				t.Error("generated UI replaced registered UI")
+			}
+			return
+		}
+	}
+
+	t.Error("registered UI not found")
+}
@@ -1,6 +1,7 @@
 package client
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"log"
@@ -19,10 +20,15 @@ import (
 // returns data.ErrDocumentNotFound if node is not found.
 // If parent is set to "all", then all living instances of the node are returned.
 func GetNode(nc *nats.Conn, id, parent string) ([]data.NodeEdge, error) {
+	return GetNodeCtx(context.Background(), nc, id, parent)
+}
+
+// GetNodeCtx is GetNode with a context that can be used to cancel the request
+func GetNodeCtx(ctx context.Context, nc *nats.Conn, id, parent string) ([]data.NodeEdge, error) {
 	if parent == "" {
 		parent = "none"
 	}
-	nodeMsg, err := nc.Request("node."+id, []byte(parent), time.Second*20)
+	nodeMsg, err := request(ctx, nc, "node."+id, []byte(parent), time.Second*20)
 	if err != nil {
 		return []data.NodeEdge{}, err
 	}
@@ -41,10 +47,16 @@ func GetNode(nc *nats.Conn, id, parent string) ([]data.NodeEdge, error) {
 // returns data.ErrDocumentNotFound if node is not found.
 // If parent is set to "all", then all living instances of the node are returned.
 func GetNodeType[T any](nc *nats.Conn, id, parent string) ([]T, error) {
+	return GetNodeTypeCtx[T](context.Background(), nc, id, parent)
+}
+
+// GetNodeTypeCtx is GetNodeType with a context that can be used to cancel
+// the request
+func GetNodeTypeCtx[T any](ctx context.Context, nc *nats.Conn, id, parent string) ([]T, error) {
 	if parent == "" {
 		parent = "none"
 	}
-	nodeMsg, err := nc.Request("node."+id, []byte(parent), time.Second*20)
+	nodeMsg, err := request(ctx, nc, "node."+id, []byte(parent), time.Second*20)
 	if err != nil {
 		return []T{}, err
 	}
@@ -72,6 +84,13 @@ func GetNodeType[T any](nc *nats.Conn, id, parent string) ([]T, error) {
 // can be used to limit nodes to a particular type, otherwise, all nodes
 // are returned.
 func GetNodeChildren(nc *nats.Conn, id, typ string, includeDel bool, recursive bool) ([]data.NodeEdge, error) {
+	return GetNodeChildrenCtx(context.Background(), nc, id, typ, includeDel, recursive)
+}
+
+// GetNodeChildrenCtx is GetNodeChildren with a context that can be used to
+// cancel the request
+func GetNodeChildrenCtx(ctx context.Context, nc *nats.Conn, id, typ string,
+	includeDel bool, recursive bool) ([]data.NodeEdge, error) {
 	var requestPoints data.Points
 
 	if includeDel {
@@ -89,7 +108,7 @@ func GetNodeChildren(nc *nats.Conn, id, typ string, includeDel bool, recursive b
 		return nil, fmt.Errorf("Error encoding reqData: %v", err)
 	}
 
-	nodeMsg, err := nc.Request("node."+id+".children", reqData, time.Second*20)
+	nodeMsg, err := request(ctx, nc, "node."+id+".children", reqData, time.Second*20)
 	if err != nil {
 		return nil, err
 	}
@@ -102,7 +121,7 @@ func GetNodeChildren(nc *nats.Conn, id, typ string, includeDel bool, recursive b
 	if recursive {
 		recNodes := []data.NodeEdge{}
 		for _, n := range nodes {
-			c, err := GetNodeChildren(nc, n.ID, typ, includeDel, true)
+			c, err := GetNodeChildrenCtx(ctx, nc, n.ID, typ, includeDel, true)
 			if err != nil {
 				return nil, fmt.Errorf("GetNodeChildren, error getting children: %v", err)
 			}
@@ -118,6 +137,12 @@ func GetNodeChildren(nc *nats.Conn, id, typ string, includeDel bool, recursive b
 // GetNodeChildrenType get immediate children of a custom type
 // deleted nodes are skipped
 func GetNodeChildrenType[T any](nc *nats.Conn, id string) ([]T, error) {
+	return GetNodeChildrenTypeCtx[T](context.Background(), nc, id)
+}
+
+// GetNodeChildrenTypeCtx is GetNodeChildrenType with a context that can be
+// used to cancel the request
+func GetNodeChildrenTypeCtx[T any](ctx context.Context, nc *nats.Conn, id string) ([]T, error) {
 	var x T
 	nodeType := reflect.TypeOf(x).Name()
 	nodeType = strings.ToLower(nodeType[0:1]) + nodeType[1:]
@@ -131,7 +156,7 @@ func GetNodeChildrenType[T any](nc *nats.Conn, id string) ([]T, error) {
 		return nil, fmt.Errorf("Error encoding reqData: %v", err)
 	}
 
-	nodeMsg, err := nc.Request("node."+id+".children", reqData, time.Second*20)
+	nodeMsg, err := request(ctx, nc, "node."+id+".children", reqData, time.Second*20)
 	if err != nil {
 		return nil, err
 	}
@@ -182,42 +207,132 @@ func GetNodesForUser(nc *nats.Conn, userID string) ([]data.NodeEdge, error) {
 	return ret, nil
 }
 
+// GetSmartGroupMembers returns the IDs of the current members of a smart
+// group node. Membership is maintained by the store.
+func GetSmartGroupMembers(nc *nats.Conn, id string) ([]string, error) {
+	nodes, err := GetNode(nc, id, "none")
+	if err != nil {
+		return nil, err
+	}
+
+	if len(nodes) < 1 {
+		return nil, data.ErrDocumentNotFound
+	}
+
+	var ret []string
+	for _, p := range nodes[0].Points {
+		if p.Type == data.PointTypeMember && p.Value != 0 {
+			ret = append(ret, p.Key)
+		}
+	}
+
+	return ret, nil
+}
+
+// GetNodesByTag returns nodes that have all of the tags. The map key is the
+// tag name and the value is the tag value. An empty value matches any value.
+// Deleted nodes are not returned.
+func GetNodesByTag(nc *nats.Conn, tags map[string]string) ([]data.NodeEdge, error) {
+	var params data.Points
+	for k, v := range tags {
+		params = append(params, data.Point{Type: data.PointTypeTag, Key: k, Text: v})
+	}
+
+	reqData, err := params.ToPb()
+	if err != nil {
+		return nil, fmt.Errorf("Error encoding reqData: %v", err)
+	}
+
+	msg, err := request(context.Background(), nc, SubjectTagQuery(), reqData, time.Second*20)
+	if err != nil {
+		return nil, err
+	}
+
+	return data.PbDecodeNodesRequest(msg.Data)
+}
+
+// GetTags returns a tag point for each tag used in the store. The key is the
+// tag name and the value is the number of nodes with the tag.
+func GetTags(nc *nats.Conn) (data.Points, error) {
+	msg, err := request(context.Background(), nc, SubjectTagList(), nil, time.Second*20)
+	if err != nil {
+		return nil, err
+	}
+
+	return data.PbDecodePointsRequest(msg.Data)
+}
+
 // SendNode is used to send a node to a nats server. Can be
 // used to create nodes.
 func SendNode(nc *nats.Conn, node data.NodeEdge, origin string) error {
-	// we need to send the edge points first if we are creating
-	// a new node, otherwise the upstream will detect an ophraned node
-	// and create a new edge to the root node
-	points := node.Points
+	return SendNodeCtx(context.Background(), nc, node, origin)
+}
 
+// SendNodeCtx is SendNode with a context that can be used to cancel the
+// requests. The node and edge points are written by the store in one
+// transaction with a node create request.
+func SendNodeCtx(ctx context.Context, nc *nats.Conn, node data.NodeEdge, origin string) error {
 	if node.ID == "" {
 		return errors.New("ID must be set to a UUID")
 	}
 
-	if node.Parent != "" && node.Parent != "none" {
-		if len(node.EdgePoints) < 0 {
-			// edge should always have a tombstone point, set to false for root node
-			node.EdgePoints = []data.Point{{Time: time.Now(),
-				Type: data.PointTypeTombstone, Origin: origin}}
+	if node.Parent != "" && node.Parent != "none" && len(node.EdgePoints) < 1 {
+		// edge should always have a tombstone point, set to false for root node
+		node.EdgePoints = []data.Point{{Time: time.Now(),
+			Type: data.PointTypeTombstone, Origin: origin}}
+	}
+
+	// copy points so the caller's node is not modified
+	node.Points = append(append(data.Points{}, node.Points...), data.Point{
+		Type:   data.PointTypeNodeType,
+		Text:   node.Type,
+		Origin: origin,
+	})
+
+	now := time.Now()
+	for _, points := range []data.Points{node.Points, node.EdgePoints} {
+		for i := range points {
+			if points[i].Time.IsZero() {
+				points[i].Time = now
+			}
 		}
+	}
+
+	d, err := node.ToPb()
+	if err != nil {
+		return err
+	}
+
+	err = requestAck(withNewMsgID(ctx), nc, SubjectNodeCreate(node.ID), d, time.Second)
+	if errors.Is(err, nats.ErrNoResponders) {
+		// older stores do not support create requests
+		return sendNodePoints(ctx, nc, node)
+	}
+
+	if err != nil {
+		return fmt.Errorf("Error creating node: %w", err)
+	}
 
-		err := SendEdgePoints(nc, node.ID, node.Parent, node.EdgePoints, true)
+	return nil
+}
+
+// sendNodePoints sends a node as separate edge and node point requests
+func sendNodePoints(ctx context.Context, nc *nats.Conn, node data.NodeEdge) error {
+	// we need to send the edge points first if we are creating
+	// a new node, otherwise the upstream will detect an ophraned node
+	// and create a new edge to the root node
+	if node.Parent != "" && node.Parent != "none" {
+		err := SendEdgePointsCtx(ctx, nc, node.ID, node.Parent, node.EdgePoints, true)
 		if err != nil {
 			return fmt.Errorf("Error sending edge points: %w", err)
 
 		}
 	}
 
-	points = append(points, data.Point{
-		Type:   data.PointTypeNodeType,
-		Text:   node.Type,
-		Origin: origin,
-	})
-
-	err := SendNodePoints(nc, node.ID, points, true)
+	err := SendNodePointsCtx(ctx, nc, node.ID, node.Points, true)
 
 	if err != nil {
-		return fmt.Errorf("Error sending node: %v", err)
+		return fmt.Errorf("Error sending node: %w", err)
 	}
 
 	return nil
@@ -226,6 +341,12 @@ func SendNode(nc *nats.Conn, node data.NodeEdge, origin string) error {
 // SendNodeType is used to send a node to a nats server. Can be
 // used to create nodes.
 func SendNodeType[T any](nc *nats.Conn, node T, origin string) error {
+	return SendNodeTypeCtx(context.Background(), nc, node, origin)
+}
+
+// SendNodeTypeCtx is SendNodeType with a context that can be used to cancel
+// the requests
+func SendNodeTypeCtx[T any](ctx context.Context, nc *nats.Conn, node T, origin string) error {
 	ne, err := data.Encode(node)
 	if err != nil {
 		return err
@@ -241,7 +362,7 @@ func SendNodeType[T any](nc *nats.Conn, node T, origin string) error {
 		}
 	}
 
-	return SendNode(nc, ne, origin)
+	return SendNodeCtx(ctx, nc, ne, origin)
 }
 
 func duplicateNodeHelper(nc *nats.Conn, node data.NodeEdge, newParent, origin string) error {
@@ -297,7 +418,13 @@ func DuplicateNode(nc *nats.Conn, id, newParent, origin string) error {
 
 // DeleteNode removes a node from the specified parent node
 func DeleteNode(nc *nats.Conn, id, parent string, origin string) error {
-	err := SendEdgePoint(nc, id, parent, data.Point{
+	return DeleteNodeCtx(context.Background(), nc, id, parent, origin)
+}
+
+// DeleteNodeCtx is DeleteNode with a context that can be used to cancel the
+// request
+func DeleteNodeCtx(ctx context.Context, nc *nats.Conn, id, parent string, origin string) error {
+	err := SendEdgePointCtx(ctx, nc, id, parent, data.Point{
 		Type:   data.PointTypeTombstone,
 		Value:  1,
 		Origin: origin,
@@ -306,25 +433,85 @@ func DeleteNode(nc *nats.Conn, id, parent string, origin string) error {
 	return err
 }
 
-// MoveNode moves a node from one parent to another
+// GetTrash returns the deleted descendants of a node. Only the top node of
+// each deleted subtree is returned. The tombstone edge point of each node
+// has the time the node was deleted and the origin (user) that deleted it.
+func GetTrash(nc *nats.Conn, id string) ([]data.NodeEdge, error) {
+	return GetTrashCtx(context.Background(), nc, id)
+}
+
+// GetTrashCtx is GetTrash with a context that can be used to cancel the
+// request
+func GetTrashCtx(ctx context.Context, nc *nats.Conn, id string) ([]data.NodeEdge, error) {
+	nodeMsg, err := request(ctx, nc, SubjectNodeTrash(id), nil, time.Second*20)
+	if err != nil {
+		return nil, err
+	}
+
+	return data.PbDecodeNodesRequest(nodeMsg.Data)
+}
+
+// UndeleteNode restores a deleted node and its subtree to a parent
+func UndeleteNode(nc *nats.Conn, id, parent string, origin string) error {
+	return UndeleteNodeCtx(context.Background(), nc, id, parent, origin)
+}
+
+// UndeleteNodeCtx is UndeleteNode with a context that can be used to cancel
+// the request
+func UndeleteNodeCtx(ctx context.Context, nc *nats.Conn, id, parent string, origin string) error {
+	return SendEdgePointCtx(ctx, nc, id, parent, data.Point{
+		Type:   data.PointTypeTombstone,
+		Value:  0,
+		Origin: origin,
+	}, true)
+}
+
+// PurgeNode permanently removes a deleted node from a parent. The node and
+// its descendants are removed from the store if they have no other parents.
+// This can't be undone, so confirm must be set to the node ID.
+func PurgeNode(nc *nats.Conn, id, parent, confirm string) error {
+	return PurgeNodeCtx(context.Background(), nc, id, parent, confirm)
+}
+
+// PurgeNodeCtx is PurgeNode with a context that can be used to cancel the
+// request
+func PurgeNodeCtx(ctx context.Context, nc *nats.Conn, id, parent, confirm string) error {
+	return SendPointsCtx(ctx, nc, SubjectNodePurge(id, parent),
+		data.Points{{Type: data.PointTypeID, Text: confirm}}, true)
+}
+
+// MoveNode moves a node from one parent to another. Edge metadata points such
+// as role and weight are preserved.
 func MoveNode(nc *nats.Conn, id, oldParent, newParent, origin string) error {
 	if newParent == oldParent {
 		return errors.New("can't move node to itself")
 	}
 
-	err := SendEdgePoint(nc, id, newParent, data.Point{
+	// carry edge metadata (role, weight, etc) over to the new edge
+	var meta data.Points
+	edges, err := GetNode(nc, id, oldParent)
+	if err == nil && len(edges) > 0 {
+		meta = edges[0].EdgeMeta()
+		for i := range meta {
+			meta[i].Time = time.Time{}
+			meta[i].Origin = origin
+		}
+	}
+
+	err = SendEdgePoints(nc, id, newParent, append(meta, data.Point{
 		Type:   data.PointTypeTombstone,
 		Value:  0,
 		Origin: origin,
-	}, true)
+	}), true)
 
 	if err != nil {
 		return err
 	}
 
 	err = SendEdgePoint(nc, id, oldParent, data.Point{
-		Type:  data.PointTypeTombstone,
-		Value: 1,
+		Type:   data.PointTypeTombstone,
+		Value:  1,
+		Origin: origin,
 	}, true)
 
 	if err != nil {
@@ -334,6 +521,25 @@ func MoveNode(nc *nats.Conn, id, oldParent, newParent, origin string) error {
 	return nil
 }
 
+// ReorderChildren sets the order of the children of a node. The store sets the
+// weight edge point of each child listed in children to its position in the
+// list. Children that are not listed are placed after the listed children.
+func ReorderChildren(nc *nats.Conn, id string, children []string, origin string) error {
+	return ReorderChildrenCtx(context.Background(), nc, id, children, origin)
+}
+
+// ReorderChildrenCtx is ReorderChildren with a context that can be used to
+// cancel the request
+func ReorderChildrenCtx(ctx context.Context, nc *nats.Conn, id string,
+	children []string, origin string) error {
+	points := make(data.Points, len(children))
+	for i, c := range children {
+		points[i] = data.Point{Type: data.PointTypeID, Text: c, Origin: origin}
+	}
+
+	return SendPointsCtx(ctx, nc, SubjectNodeReorder(id), points, true)
+}
+
 // MirrorNode adds a an existing node to a new parent. A node can have
 // multiple parents.
 func MirrorNode(nc *nats.Conn, id, newParent, origin string) error {
@@ -359,7 +565,7 @@ func UserCheck(nc *nats.Conn, email, pass string) ([]data.NodeEdge, error) {
 		return []data.NodeEdge{}, err
 	}
 
-	nodeMsg, err := nc.Request("auth.user", pointsData, time.Second*20)
+	nodeMsg, err := request(context.Background(), nc, "auth.user", pointsData, time.Second*20)
 	if err != nil {
 		return []data.NodeEdge{}, err
 	}
@@ -376,7 +582,27 @@ func UserCheck(nc *nats.Conn, email, pass string) ([]data.NodeEdge, error) {
 // NodeWatcher creates a node watcher. update() is called any time there is an update.
 // Stop can be called to stop the watcher. get() can be called to get the current value.
 func NodeWatcher[T any](nc *nats.Conn, id, parent string) (get func() T, stop func(), err error) {
-	stopCh := make(chan struct{})
+	ctx, cancel := context.WithCancel(context.Background())
+	get, err = NodeWatcherCtx[T](ctx, nc, id, parent)
+	if err != nil {
+		cancel()
+		return nil, nil, err
+	}
+
+	return get, cancel, nil
+}
+
+// NodeWatcherCtx creates a node watcher that runs until ctx is done. get()
+// can be called to get the current value, and returns the last value after
+// the watcher is stopped.
+func NodeWatcherCtx[T any](ctx context.Context, nc *nats.Conn, id, parent string) (get func() T, err error) {
+	return NodeWatcherOptsCtx[T](ctx, nc, id, parent, WatchOptions{})
+}
+
+// NodeWatcherOptsCtx is NodeWatcherCtx with options to filter the points
+// that are watched
+func NodeWatcherOptsCtx[T any](ctx context.Context, nc *nats.Conn, id, parent string,
+	opts WatchOptions) (get func() T, err error) {
 	var current T
 
 	pointUpdates := make(chan []data.Point)
@@ -386,23 +612,40 @@ func NodeWatcher[T any](nc *nats.Conn, id, parent string) (get func() T, stop fu
 	// time we fetch node and start subscriptions
 
 	stopPointSub, err := SubscribePoints(nc, id, func(points []data.Point) {
-		pointUpdates <- points
+		points = opts.filter(points)
+		if len(points) < 1 {
+			return
+		}
+		select {
+		case pointUpdates <- points:
+		case <-ctx.Done():
+		}
 	})
 	if err != nil {
-		return nil, nil, fmt.Errorf("Point subscribe failed: %v", err)
+		return nil, fmt.Errorf("Point subscribe failed: %v", err)
 	}
 
 	stopEdgeSub, err := SubscribeEdgePoints(nc, id, parent, func(points []data.Point) {
-		edgeUpdates <- points
+		points = opts.filter(points)
+		if len(points) < 1 {
+			return
+		}
+		select {
+		case edgeUpdates <- points:
+		case <-ctx.Done():
+		}
 	})
 	if err != nil {
-		return nil, nil, fmt.Errorf("Edge point subscribe failed: %v", err)
+		stopPointSub()
+		return nil, fmt.Errorf("Edge point subscribe failed: %v", err)
 	}
 
-	nodes, err := GetNodeType[T](nc, id, parent)
+	nodes, err := GetNodeTypeCtx[T](ctx, nc, id, parent)
 	if err != nil {
 		if err != data.ErrDocumentNotFound {
-			return nil, nil, fmt.Errorf("Error getting node: %v", err)
+			stopPointSub()
+			stopEdgeSub()
+			return nil, fmt.Errorf("Error getting node: %w", err)
 		}
 		// if document is not found, that is OK, points will populate it once they come in
 	}
@@ -414,13 +657,19 @@ func NodeWatcher[T any](nc *nats.Conn, id, parent string) (get func() T, stop fu
 	}
 
 	getCurrent := make(chan chan T)
+	stopped := make(chan struct{})
+	var final T
 
 	// main loop for watcher. All data access must go through the main
 	// loop to avoid race conditions.
 	go func() {
 		for {
 			select {
-			case <-stopCh:
+			case <-ctx.Done():
+				stopPointSub()
+				stopEdgeSub()
+				final = current
+				close(stopped)
 				return
 			case r := <-getCurrent:
 				r <- current
@@ -433,12 +682,12 @@ func NodeWatcher[T any](nc *nats.Conn, id, parent string) (get func() T, stop fu
 	}()
 
 	return func() T {
-			ret := make(chan T)
-			getCurrent <- ret
+		ret := make(chan T)
+		select {
+		case getCurrent <- ret:
 			return <-ret
-		}, func() {
-			stopPointSub()
-			stopEdgeSub()
-			close(stopCh)
-		}, nil
+		case <-stopped:
+			return final
+		}
+	}, nil
 }
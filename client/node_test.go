@@ -0,0 +1,120 @@
+package client_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/simpleiot/simpleiot/client"
+	"github.com/simpleiot/simpleiot/data"
+	"github.com/simpleiot/simpleiot/server"
+)
+
+func TestNodeWatcherCtx(t *testing.T) {
+	nc, root, stop, err := server.TestServer()
+	if err != nil {
+		t.Fatal("Error starting test server: ", err)
+	}
+	defer stop()
+
+	v := client.Variable{ID: "ID-var", Parent: root.ID, Description: "var"}
+
+	err = client.SendNodeTypeCtx(context.Background(), nc, v, "test")
+	if err != nil {
+		t.Fatal("Error sending node: ", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	get, err := client.NodeWatcherCtx[client.Variable](ctx, nc, v.ID, v.Parent)
+	if err != nil {
+		t.Fatal("Error starting watcher: ", err)
+	}
+
+	if get().Description != "var" {
+		t.Fatal("watcher did not get node: ", get())
+	}
+
+	err = client.SendNodePointCtx(context.Background(), nc, v.ID,
+		data.Point{Type: data.PointTypeValue, Value: 3, Origin: "test"}, true)
+	if err != nil {
+		t.Fatal("Error sending point: ", err)
+	}
+
+	start := time.Now()
+	for get().Value != 3 {
+		if time.Since(start) > time.Second {
+			t.Fatal("watcher did not get point")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	cancel()
+
+	// get returns the last value after the watcher is stopped
+	done := make(chan client.Variable)
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		done <- get()
+	}()
+
+	select {
+	case cur := <-done:
+		if cur.Value != 3 {
+			t.Fatal("wrong value after stop: ", cur)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("get blocked after watcher stopped")
+	}
+}
+
+func TestMoveNodeEdgeMeta(t *testing.T) {
+	nc, root, stop, err := server.TestServer()
+	if err != nil {
+		t.Fatal("Error starting test server: ", err)
+	}
+	defer stop()
+
+	g := data.NodeEdge{ID: "ID-group", Type: data.NodeTypeGroup, Parent: root.ID}
+	v := client.Variable{ID: "ID-var", Parent: root.ID, Description: "var"}
+
+	if err := client.SendNode(nc, g, "test"); err != nil {
+		t.Fatal("Error sending group: ", err)
+	}
+
+	if err := client.SendNodeType(nc, v, "test"); err != nil {
+		t.Fatal("Error sending node: ", err)
+	}
+
+	err = client.SendEdgePoints(nc, v.ID, root.ID, data.Points{
+		{Type: data.PointTypeRole, Text: data.PointValueRoleBackupParent},
+		{Type: data.PointTypeWeight, Value: 2},
+	}, true)
+	if err != nil {
+		t.Fatal("Error sending edge points: ", err)
+	}
+
+	err = client.MoveNode(nc, v.ID, root.ID, g.ID, "test")
+	if err != nil {
+		t.Fatal("Error moving node: ", err)
+	}
+
+	nodes, err := client.GetNode(nc, v.ID, g.ID)
+	if err != nil || len(nodes) < 1 {
+		t.Fatal("Error getting moved node: ", err)
+	}
+
+	n := nodes[0]
+
+	if n.EdgeRole() != data.PointValueRoleBackupParent {
+		t.Error("role not preserved: ", n.EdgeRole())
+	}
+
+	if n.EdgeWeight() != 2 {
+		t.Error("weight not preserved: ", n.EdgeWeight())
+	}
+
+	if tomb, _ := n.IsTombstone(); tomb {
+		t.Error("moved node is deleted")
+	}
+}
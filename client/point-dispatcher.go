@@ -0,0 +1,185 @@
+package client
+
+import (
+	"sync"
+	"time"
+
+	"github.com/simpleiot/simpleiot/data"
+)
+
+// DefaultConfigDebounce is how long the client manager collects point
+// changes for a client before they are sent to the client
+const DefaultConfigDebounce = 250 * time.Millisecond
+
+// changes are applied after this many debounce periods, even if points
+// keep arriving
+const configDebounceMaxPeriods = 8
+
+// pendingPoints are points for a node or edge waiting to be sent to a client
+type pendingPoints struct {
+	nodeID   string
+	parentID string
+	edge     bool
+	points   data.Points
+}
+
+// pointDispatcher collects point changes for a client and sends them to the
+// client when no changes have arrived for the debounce period. Points for
+// the same node are sent in one call, so clients that reload on config
+// changes only reload once after a UI edit. Changes to the child nodes of a
+// client are also debounced, and restart the client once.
+type pointDispatcher struct {
+	client   Client
+	debounce time.Duration
+	// restart is called to restart the client after its child nodes changed
+	restart func()
+
+	lock           sync.Mutex
+	pending        []*pendingPoints
+	index          map[string]*pendingPoints
+	restartPending bool
+
+	chPending chan struct{}
+	chStop    chan struct{}
+	stopOnce  sync.Once
+}
+
+func newPointDispatcher(client Client, debounce time.Duration,
+	restart func()) *pointDispatcher {
+	pd := &pointDispatcher{
+		client:    client,
+		debounce:  debounce,
+		restart:   restart,
+		index:     make(map[string]*pendingPoints),
+		chPending: make(chan struct{}, 1),
+		chStop:    make(chan struct{}),
+	}
+
+	if debounce > 0 {
+		go pd.run()
+	}
+
+	return pd
+}
+
+// points queues node points (edge false) or edge points for the client
+func (pd *pointDispatcher) points(nodeID, parentID string, edge bool,
+	points data.Points) {
+	if pd.debounce <= 0 {
+		pd.send(&pendingPoints{nodeID, parentID, edge, points})
+		return
+	}
+
+	key := nodeID + "." + parentID
+	if edge {
+		key = "edge." + key
+	}
+
+	pd.lock.Lock()
+	if p, ok := pd.index[key]; ok {
+		p.points = append(p.points, points...)
+	} else {
+		p := &pendingPoints{nodeID, parentID, edge, append(data.Points{}, points...)}
+		pd.pending = append(pd.pending, p)
+		pd.index[key] = p
+	}
+	pd.lock.Unlock()
+
+	pd.signal()
+}
+
+// childrenChanged queues a restart of the client. Points that are pending
+// are dropped, as the new client reads the current config.
+func (pd *pointDispatcher) childrenChanged() {
+	if pd.debounce <= 0 {
+		pd.restart()
+		return
+	}
+
+	pd.lock.Lock()
+	pd.restartPending = true
+	pd.lock.Unlock()
+
+	pd.signal()
+}
+
+func (pd *pointDispatcher) signal() {
+	select {
+	case pd.chPending <- struct{}{}:
+	default:
+	}
+}
+
+func (pd *pointDispatcher) send(p *pendingPoints) {
+	if p.edge {
+		pd.client.EdgePoints(p.nodeID, p.parentID, p.points)
+	} else {
+		pd.client.Points(p.nodeID, p.points)
+	}
+}
+
+func (pd *pointDispatcher) flush() {
+	pd.lock.Lock()
+	pending := pd.pending
+	restart := pd.restartPending
+	pd.pending = nil
+	pd.index = make(map[string]*pendingPoints)
+	pd.restartPending = false
+	pd.lock.Unlock()
+
+	if restart {
+		pd.restart()
+		return
+	}
+
+	for _, p := range pending {
+		select {
+		case <-pd.chStop:
+			return
+		default:
+		}
+		pd.send(p)
+	}
+}
+
+func (pd *pointDispatcher) run() {
+	t := time.NewTimer(time.Hour)
+	t.Stop()
+
+	for {
+		select {
+		case <-pd.chStop:
+			return
+		case <-pd.chPending:
+		}
+
+		// wait until no changes arrive for the debounce period
+		deadline := time.Now().Add(configDebounceMaxPeriods * pd.debounce)
+		t.Reset(pd.debounce)
+
+	wait:
+		for {
+			select {
+			case <-pd.chStop:
+				t.Stop()
+				return
+			case <-pd.chPending:
+				if time.Now().Before(deadline) {
+					if !t.Stop() {
+						<-t.C
+					}
+					t.Reset(pd.debounce)
+				}
+			case <-t.C:
+				break wait
+			}
+		}
+
+		pd.flush()
+	}
+}
+
+// stop stops the dispatcher. Pending points are dropped.
+func (pd *pointDispatcher) stop() {
+	pd.stopOnce.Do(func() { close(pd.chStop) })
+}
@@ -0,0 +1,101 @@
+package client
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/simpleiot/simpleiot/data"
+)
+
+type countClient struct {
+	lock   sync.Mutex
+	calls  int
+	points data.Points
+}
+
+func (cc *countClient) Start() error { return nil }
+
+func (cc *countClient) Stop(err error) {}
+
+func (cc *countClient) Points(nodeID string, points []data.Point) {
+	cc.lock.Lock()
+	defer cc.lock.Unlock()
+	cc.calls++
+	cc.points = append(cc.points, points...)
+}
+
+func (cc *countClient) EdgePoints(nodeID, parentID string, points []data.Point) {
+	cc.Points(nodeID, points)
+}
+
+func (cc *countClient) get() (int, data.Points) {
+	cc.lock.Lock()
+	defer cc.lock.Unlock()
+	return cc.calls, cc.points
+}
+
+func TestPointDispatcherDebounce(t *testing.T) {
+	cc := &countClient{}
+	restarts := make(chan struct{}, 10)
+	debounce := 50 * time.Millisecond
+
+	pd := newPointDispatcher(cc, debounce, func() { restarts <- struct{}{} })
+	defer pd.stop()
+
+	for i := 0; i < 5; i++ {
+		pd.points("ID-node", "", false, data.Points{{Type: data.PointTypeDescription,
+			Text: "edit", Value: float64(i)}})
+		time.Sleep(debounce / 5)
+	}
+
+	if calls, _ := cc.get(); calls != 0 {
+		t.Fatal("points sent before debounce period")
+	}
+
+	time.Sleep(3 * debounce)
+
+	calls, pts := cc.get()
+	if calls != 1 {
+		t.Fatal("expected points in one call, got calls: ", calls)
+	}
+
+	if len(pts) != 5 || pts[4].Value != 4 {
+		t.Fatal("points not correct: ", pts)
+	}
+
+	// a child change drops pending points and restarts the client once
+	pd.points("ID-node", "", false, data.Points{{Type: data.PointTypeDescription}})
+	pd.childrenChanged()
+	pd.childrenChanged()
+
+	time.Sleep(3 * debounce)
+
+	if calls, _ := cc.get(); calls != 1 {
+		t.Fatal("points sent after child change")
+	}
+
+	if len(restarts) != 1 {
+		t.Fatal("expected one restart, got: ", len(restarts))
+	}
+}
+
+func TestPointDispatcherNoDebounce(t *testing.T) {
+	cc := &countClient{}
+	restarted := false
+
+	pd := newPointDispatcher(cc, 0, func() { restarted = true })
+	defer pd.stop()
+
+	pd.points("ID-node", "", false, data.Points{{Type: data.PointTypeDescription}})
+
+	if calls, _ := cc.get(); calls != 1 {
+		t.Fatal("points not sent")
+	}
+
+	pd.childrenChanged()
+
+	if !restarted {
+		t.Fatal("client not restarted")
+	}
+}
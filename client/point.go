@@ -1,7 +1,7 @@
 package client
 
 import (
-	"errors"
+	"context"
 	"fmt"
 	"log"
 	"time"
@@ -12,31 +12,71 @@ import (
 
 // SendNodePoint sends a node point using the nats protocol
 func SendNodePoint(nc *nats.Conn, nodeID string, point data.Point, ack bool) error {
+	return SendNodePointCtx(context.Background(), nc, nodeID, point, ack)
+}
+
+// SendNodePointCtx is SendNodePoint with a context that can be used to cancel
+// the request
+func SendNodePointCtx(ctx context.Context, nc *nats.Conn, nodeID string, point data.Point, ack bool) error {
 	points := data.Points{point}
-	return SendNodePoints(nc, nodeID, points, ack)
+	return SendNodePointsCtx(ctx, nc, nodeID, points, ack)
 }
 
 // SendEdgePoint sends a edge point using the nats protocol
 func SendEdgePoint(nc *nats.Conn, nodeID, parentID string, point data.Point, ack bool) error {
+	return SendEdgePointCtx(context.Background(), nc, nodeID, parentID, point, ack)
+}
+
+// SendEdgePointCtx is SendEdgePoint with a context that can be used to cancel
+// the request
+func SendEdgePointCtx(ctx context.Context, nc *nats.Conn, nodeID, parentID string,
+	point data.Point, ack bool) error {
 	points := data.Points{point}
-	return SendEdgePoints(nc, nodeID, parentID, points, ack)
+	return SendEdgePointsCtx(ctx, nc, nodeID, parentID, points, ack)
 }
 
 // SendNodePoints sends node points using the nats protocol
 func SendNodePoints(nc *nats.Conn, nodeID string, points data.Points, ack bool) error {
-	return SendPoints(nc, SubjectNodePoints(nodeID), points, ack)
+	return SendNodePointsCtx(context.Background(), nc, nodeID, points, ack)
+}
+
+// SendNodePointsCtx is SendNodePoints with a context that can be used to
+// cancel the request
+func SendNodePointsCtx(ctx context.Context, nc *nats.Conn, nodeID string,
+	points data.Points, ack bool) error {
+	return SendPointsCtx(ctx, nc, SubjectNodePoints(nodeID), points, ack)
 }
 
 // SendEdgePoints sends points using the nats protocol
 func SendEdgePoints(nc *nats.Conn, nodeID, parentID string, points data.Points, ack bool) error {
+	return SendEdgePointsCtx(context.Background(), nc, nodeID, parentID, points, ack)
+}
+
+// SendEdgePointsCtx is SendEdgePoints with a context that can be used to
+// cancel the request
+func SendEdgePointsCtx(ctx context.Context, nc *nats.Conn, nodeID, parentID string,
+	points data.Points, ack bool) error {
 	if parentID == "" {
 		parentID = "none"
 	}
-	return SendPoints(nc, SubjectEdgePoints(nodeID, parentID), points, ack)
+	return SendPointsCtx(ctx, nc, SubjectEdgePoints(nodeID, parentID), points, ack)
 }
 
 // SendPoints sends points to specified subject
 func SendPoints(nc *nats.Conn, subject string, points data.Points, ack bool) error {
+	return SendPointsCtx(context.Background(), nc, subject, points, ack)
+}
+
+// SendPointsCtx is SendPoints with a context that can be used to cancel the
+// request. If ack is false, the points are not sent if ctx is done. The
+// message ID is set from ctx (see WithMsgID), and a new ID is used if ack is
+// true and ctx does not have one.
+func SendPointsCtx(ctx context.Context, nc *nats.Conn, subject string,
+	points data.Points, ack bool) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
 	for i := range points {
 		if points[i].Time.IsZero() {
 			points[i].Time = time.Now()
@@ -48,19 +88,17 @@ func SendPoints(nc *nats.Conn, subject string, points data.Points, ack bool) err
 		return err
 	}
 
-	if ack {
-		msg, err := nc.Request(subject, data, time.Second)
-
-		if err != nil {
-			return err
-		}
-
-		if len(msg.Data) > 0 {
-			return errors.New(string(msg.Data))
-		}
+	ctx = withPointsPriority(ctx, points)
 
+	if ack {
+		return requestAck(withNewMsgID(ctx), nc, subject, data, time.Second)
 	} else {
-		if err := nc.Publish(subject, data); err != nil {
+		msg := nats.NewMsg(subject)
+		msg.Data = data
+		setMsgID(ctx, nc, msg)
+		setPriority(ctx, nc, msg)
+		setIfVersion(ctx, nc, msg)
+		if err := nc.PublishMsg(msg); err != nil {
 			return err
 		}
 	}
@@ -120,3 +158,39 @@ func (np NewPoints) String() string {
 	ret += np.Points.String()
 	return ret
 }
+
+// GetRecentPoints returns the recent values of a node point kept in memory
+// by the store, sorted by time. If typ is blank, recent values of all node
+// points are returned. The number of values kept is configured with the store
+// recent length or the node recentLen point.
+func GetRecentPoints(nc *nats.Conn, nodeID, typ, key string) (data.Points, error) {
+	return GetRecentPointsCtx(context.Background(), nc, nodeID, typ, key)
+}
+
+// GetRecentPointsCtx is GetRecentPoints with a context that can be used to
+// cancel the request
+func GetRecentPointsCtx(ctx context.Context, nc *nats.Conn, nodeID, typ, key string) (data.Points, error) {
+	var requestPoints data.Points
+
+	if typ != "" {
+		requestPoints = append(requestPoints,
+			data.Point{Type: data.PointTypePointType, Text: typ})
+	}
+
+	if key != "" {
+		requestPoints = append(requestPoints,
+			data.Point{Type: data.PointTypePointKey, Text: key})
+	}
+
+	reqData, err := requestPoints.ToPb()
+	if err != nil {
+		return nil, fmt.Errorf("Error encoding reqData: %v", err)
+	}
+
+	msg, err := request(ctx, nc, "node."+nodeID+".recent", reqData, time.Second*20)
+	if err != nil {
+		return nil, err
+	}
+
+	return data.PbDecodePointsRequest(msg.Data)
+}
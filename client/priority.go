@@ -0,0 +1,71 @@
+package client
+
+import (
+	"context"
+
+	"github.com/nats-io/nats.go"
+	"github.com/simpleiot/simpleiot/data"
+)
+
+// HeaderPriority is the NATS header that carries the priority class of a
+// point message. The store handles control messages on a fast path ahead of
+// queued telemetry.
+const HeaderPriority = "Siot-Priority"
+
+// Point message priority classes
+const (
+	// PriorityTelemetry is the default class for measurements and other
+	// bulk data
+	PriorityTelemetry = "telemetry"
+	// PriorityControl is for points that change the state of something,
+	// like setpoints and relay commands
+	PriorityControl = "control"
+)
+
+type priorityKey struct{}
+
+// WithPriority returns a context that sets the priority class of the points
+// sent with it (SendNodePointsCtx, etc). If no priority is set, points are
+// sent as control if any of them is a control point type (see
+// data.ControlPointTypes), and as telemetry otherwise.
+func WithPriority(ctx context.Context, priority string) context.Context {
+	return context.WithValue(ctx, priorityKey{}, priority)
+}
+
+// Priority returns the priority class set with WithPriority, or ""
+func Priority(ctx context.Context) string {
+	p, _ := ctx.Value(priorityKey{}).(string)
+	return p
+}
+
+// MsgPriority returns the priority class of a received message
+func MsgPriority(msg *nats.Msg) string {
+	if msg.Header != nil && msg.Header.Get(HeaderPriority) == PriorityControl {
+		return PriorityControl
+	}
+	return PriorityTelemetry
+}
+
+// withPointsPriority returns ctx with the control priority if no priority is
+// set and points has a control point type
+func withPointsPriority(ctx context.Context, points data.Points) context.Context {
+	if Priority(ctx) != "" || !points.HasControl() {
+		return ctx
+	}
+	return WithPriority(ctx, PriorityControl)
+}
+
+// setPriority sets the priority header of msg from ctx if the connection
+// supports headers. Telemetry is the default, so the header is only set for
+// control messages.
+func setPriority(ctx context.Context, nc *nats.Conn, msg *nats.Msg) {
+	if Priority(ctx) != PriorityControl || !nc.HeadersSupported() {
+		return
+	}
+
+	if msg.Header == nil {
+		msg.Header = nats.Header{}
+	}
+
+	msg.Header.Set(HeaderPriority, PriorityControl)
+}
@@ -0,0 +1,90 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/simpleiot/simpleiot/data"
+)
+
+// QuarantineQuery is used to list quarantined points
+type QuarantineQuery struct {
+	// NodeID optionally selects the points of a node
+	NodeID string `json:"nodeID,omitempty"`
+	// Limit is the max number of points returned, 0 for no limit
+	Limit int `json:"limit,omitempty"`
+}
+
+// QuarantineRequest selects the quarantined points to admit or discard
+type QuarantineRequest struct {
+	IDs []int64 `json:"ids"`
+}
+
+// QuarantineResult is the response to quarantine requests. Count is the
+// number of points admitted or discarded.
+type QuarantineResult struct {
+	Points []data.QuarantinedPoint `json:"points,omitempty"`
+	Count  int                     `json:"count"`
+	Error  string                  `json:"error,omitempty"`
+}
+
+func quarantineRequest(ctx context.Context, nc *nats.Conn, subject string,
+	req any) (QuarantineResult, error) {
+	var ret QuarantineResult
+
+	reqData, err := json.Marshal(req)
+	if err != nil {
+		return ret, err
+	}
+
+	msg, err := request(ctx, nc, subject, reqData, time.Second*20)
+	if err != nil {
+		return ret, err
+	}
+
+	err = json.Unmarshal(msg.Data, &ret)
+	if err != nil {
+		return ret, err
+	}
+
+	if ret.Error != "" {
+		return ret, errors.New(ret.Error)
+	}
+
+	return ret, nil
+}
+
+// GetQuarantine returns the points quarantined by the store, oldest first.
+// Points that fail validation, like points rejected by a clock skew policy,
+// are quarantined instead of dropped.
+func GetQuarantine(nc *nats.Conn, q QuarantineQuery) ([]data.QuarantinedPoint, error) {
+	return GetQuarantineCtx(context.Background(), nc, q)
+}
+
+// GetQuarantineCtx is GetQuarantine with a context that can be used to
+// cancel the request
+func GetQuarantineCtx(ctx context.Context, nc *nats.Conn,
+	q QuarantineQuery) ([]data.QuarantinedPoint, error) {
+	ret, err := quarantineRequest(ctx, nc, SubjectQuarantineList(), q)
+	return ret.Points, err
+}
+
+// AdmitQuarantine writes quarantined points to their nodes as they were
+// sent, without validating them again, and removes them from the
+// quarantine. The number of points admitted is returned.
+func AdmitQuarantine(nc *nats.Conn, ids []int64) (int, error) {
+	ret, err := quarantineRequest(context.Background(), nc, SubjectQuarantineAdmit(),
+		QuarantineRequest{IDs: ids})
+	return ret.Count, err
+}
+
+// DiscardQuarantine removes quarantined points. The number of points
+// discarded is returned.
+func DiscardQuarantine(nc *nats.Conn, ids []int64) (int, error) {
+	ret, err := quarantineRequest(context.Background(), nc, SubjectQuarantineDiscard(),
+		QuarantineRequest{IDs: ids})
+	return ret.Count, err
+}
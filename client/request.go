@@ -0,0 +1,176 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// ErrRequestTimeout is returned by the client request helpers when no
+// response was received after all retries
+var ErrRequestTimeout = errors.New("request timed out")
+
+// ErrNak is returned by the client request helpers when the responder
+// rejected the request. These requests are not retried.
+var ErrNak = errors.New("request not acknowledged")
+
+// NakError contains the error message returned by the responder. It wraps
+// ErrNak.
+type NakError struct {
+	Subject string
+	Msg     string
+}
+
+func (ne *NakError) Error() string {
+	return ne.Msg
+}
+
+// Unwrap returns ErrNak
+func (ne *NakError) Unwrap() error {
+	return ErrNak
+}
+
+// RequestOptions configure how the client request helpers (GetNode,
+// SendNodePoints with ack, etc.) retry requests that time out or have no
+// responders. Retried requests are safe as points are idempotent, and point
+// sends carry a message ID so the store does not process a retry twice (see
+// WithMsgID).
+type RequestOptions struct {
+	// Retries is the number of retries after the first attempt
+	Retries int
+	// Timeout for each attempt. If 0, the timeout of the helper is used.
+	Timeout time.Duration
+	// MinBackoff and MaxBackoff limit the delay between attempts. The delay
+	// doubles each attempt and is randomized to avoid retry storms.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+}
+
+// DefaultRequestOptions are used unless SetRequestOptions is called
+var DefaultRequestOptions = RequestOptions{
+	Retries:    2,
+	MinBackoff: 100 * time.Millisecond,
+	MaxBackoff: 2 * time.Second,
+}
+
+var requestOptionsLock sync.RWMutex
+var requestOptions = DefaultRequestOptions
+
+// SetRequestOptions sets the retry options used by the client request
+// helpers in this process
+func SetRequestOptions(o RequestOptions) {
+	requestOptionsLock.Lock()
+	defer requestOptionsLock.Unlock()
+	requestOptions = o
+}
+
+// GetRequestOptions returns the retry options used by the client request
+// helpers
+func GetRequestOptions() RequestOptions {
+	requestOptionsLock.RLock()
+	defer requestOptionsLock.RUnlock()
+	return requestOptions
+}
+
+// requestBackoff returns a jittered delay between 1/2 and 1 times the
+// exponential backoff for the attempt
+func requestBackoff(o RequestOptions, attempt int) time.Duration {
+	d := o.MaxBackoff
+	if attempt < 30 {
+		calc := o.MinBackoff << attempt
+		if calc > 0 && calc < d {
+			d = calc
+		}
+	}
+
+	if d <= 0 {
+		return 0
+	}
+
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// retryable returns true for errors where the request may succeed if it is
+// sent again
+func retryable(err error) bool {
+	return errors.Is(err, nats.ErrTimeout) ||
+		errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, nats.ErrNoResponders)
+}
+
+// request sends a NATS request and retries it as configured by
+// SetRequestOptions. Errors are wrapped with ErrRequestTimeout if all attempts
+// failed, and ctx cancellation stops retries. Large responses may be
+// compressed by the responder, and are decompressed before they are returned.
+func request(ctx context.Context, nc *nats.Conn, subject string, d []byte,
+	timeout time.Duration) (*nats.Msg, error) {
+	o := GetRequestOptions()
+	if o.Timeout > 0 {
+		timeout = o.Timeout
+	}
+
+	var err error
+
+	req := nats.NewMsg(subject)
+	req.Data = d
+	acceptCompression(nc, req)
+	setMsgID(ctx, nc, req)
+	setPriority(ctx, nc, req)
+	setIfVersion(ctx, nc, req)
+
+	for attempt := 0; attempt <= o.Retries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(requestBackoff(o, attempt-1)):
+			}
+		}
+
+		var msg *nats.Msg
+		ctxAttempt, cancel := context.WithTimeout(ctx, timeout)
+		msg, err = nc.RequestMsgWithContext(ctxAttempt, req)
+		cancel()
+
+		if err == nil {
+			return msg, DecompressMsg(msg)
+		}
+
+		// the parent context was canceled, not the attempt
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		if !retryable(err) {
+			return nil, err
+		}
+	}
+
+	if errors.Is(err, nats.ErrNoResponders) {
+		return nil, fmt.Errorf("%v: %w", subject, err)
+	}
+
+	return nil, fmt.Errorf("%w: %v after %v attempts", ErrRequestTimeout, subject,
+		o.Retries+1)
+}
+
+// requestAck sends a request where the response is empty on success, and an
+// error message otherwise
+func requestAck(ctx context.Context, nc *nats.Conn, subject string, d []byte,
+	timeout time.Duration) error {
+	msg, err := request(ctx, nc, subject, d, timeout)
+	if err != nil {
+		return err
+	}
+
+	if len(msg.Data) > 0 {
+		return &NakError{Subject: subject, Msg: string(msg.Data)}
+	}
+
+	return nil
+}
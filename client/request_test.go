@@ -0,0 +1,129 @@
+package client_test
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/simpleiot/simpleiot/client"
+	"github.com/simpleiot/simpleiot/data"
+	"github.com/simpleiot/simpleiot/server"
+)
+
+func TestRequestRetry(t *testing.T) {
+	nc, _, stop, err := server.TestServer()
+	if err != nil {
+		t.Fatal("Error starting test server: ", err)
+	}
+	defer stop()
+
+	defer client.SetRequestOptions(client.GetRequestOptions())
+	client.SetRequestOptions(client.RequestOptions{
+		Retries:    2,
+		Timeout:    100 * time.Millisecond,
+		MinBackoff: 10 * time.Millisecond,
+		MaxBackoff: 50 * time.Millisecond,
+	})
+
+	var count int32
+	var response atomic.Value
+	response.Store("")
+
+	// the first request of each test is dropped
+	sub, err := nc.Subscribe("test.retry", func(msg *nats.Msg) {
+		if atomic.AddInt32(&count, 1) == 1 {
+			return
+		}
+		r := response.Load().(string)
+		if r == "drop" {
+			return
+		}
+		msg.Respond([]byte(r))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Unsubscribe()
+
+	pts := data.Points{{Type: data.PointTypeValue, Value: 1}}
+
+	err = client.SendPoints(nc, "test.retry", pts, true)
+	if err != nil {
+		t.Fatal("request was not retried: ", err)
+	}
+
+	if c := atomic.LoadInt32(&count); c != 2 {
+		t.Fatal("expected 2 attempts, got: ", c)
+	}
+
+	// no response
+	atomic.StoreInt32(&count, 0)
+	response.Store("drop")
+	err = client.SendPoints(nc, "test.retry", pts, true)
+	if !errors.Is(err, client.ErrRequestTimeout) {
+		t.Fatal("expected timeout error, got: ", err)
+	}
+
+	if c := atomic.LoadInt32(&count); c != 3 {
+		t.Fatal("expected 3 attempts, got: ", c)
+	}
+
+	// error responses are not retried
+	atomic.StoreInt32(&count, 1)
+	response.Store("bad point")
+	err = client.SendPoints(nc, "test.retry", pts, true)
+	if !errors.Is(err, client.ErrNak) || err.Error() != "bad point" {
+		t.Fatal("expected nak error, got: ", err)
+	}
+
+	if c := atomic.LoadInt32(&count); c != 2 {
+		t.Fatal("nak was retried, attempts: ", c-1)
+	}
+
+	// no responders
+	err = client.SendPoints(nc, "test.noresponders", pts, true)
+	if !errors.Is(err, nats.ErrNoResponders) {
+		t.Fatal("expected no responders error, got: ", err)
+	}
+}
+
+func TestRequestContext(t *testing.T) {
+	nc, _, stop, err := server.TestServer()
+	if err != nil {
+		t.Fatal("Error starting test server: ", err)
+	}
+	defer stop()
+
+	// responder that never responds
+	sub, err := nc.Subscribe("test.ctx", func(msg *nats.Msg) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Unsubscribe()
+
+	pts := data.Points{{Type: data.PointTypeValue, Value: 1}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err = client.SendPointsCtx(ctx, nc, "test.ctx", pts, false)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatal("expected canceled error, got: ", err)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	err = client.SendPointsCtx(ctx, nc, "test.ctx", pts, true)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatal("expected deadline error, got: ", err)
+	}
+
+	if time.Since(start) > 500*time.Millisecond {
+		t.Fatal("request was not canceled by context")
+	}
+}
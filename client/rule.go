@@ -23,6 +23,7 @@ type Rule struct {
 	Description     string      `point:"description"`
 	Disable         bool        `point:"disable"`
 	Active          bool        `point:"active"`
+	Timezone        string      `point:"timezone"`
 	Conditions      []Condition `child:"condition"`
 	Actions         []Action    `child:"action"`
 	ActionsInactive []Action    `child:"actionInactive"`
@@ -65,10 +66,30 @@ type Condition struct {
 	Value      float64 `point:"value"`
 	ValueText  string  `point:"valueText"`
 
-	// used with shedule rules
-	StartTime string `point:"startTime"`
-	EndTime   string `point:"endTime"`
-	Weekdays  []time.Weekday
+	// if set, points with bad quality are not used to evaluate the
+	// condition
+	IgnoreBadQuality bool `point:"ignoreBadQuality"`
+
+	// used with shedule rules. Times and weekdays are in Timezone (an IANA
+	// name like America/New_York). If Timezone is blank, the timezone of
+	// the rule or of the nearest ancestor with a timezone point (for
+	// example a site) is used, and UTC if none is set.
+	StartTime string `point:"start"`
+	EndTime   string `point:"end"`
+	Timezone  string `point:"timezone"`
+	// Weekdays are set from the weekday points of the condition by the rule
+	// client. If empty, the schedule is active on all days.
+	Weekdays []time.Weekday
+	// CalendarID is a calendar node with dates that the schedule is not
+	// active on if CalendarMode is exclude (the default), such as holidays,
+	// or the only dates it is active on if CalendarMode is include.
+	CalendarID   string `point:"calendarID"`
+	CalendarMode string `point:"calendarMode"`
+
+	// used with geofence rules. The condition is active when the latitude
+	// and longitude points of NodeID are inside the zone node ZoneID, or
+	// outside of it if Operator is outside.
+	ZoneID string `point:"zoneID"`
 }
 
 func (c Condition) String() string {
@@ -164,6 +185,13 @@ type ActionInactive struct {
 	PointFilePath string `point:"pointFilePath"`
 }
 
+// scheduleCheckPeriod is how often schedule conditions are evaluated
+var scheduleCheckPeriod = 5 * time.Second
+
+// refreshPeriod is how often the timezone of the rule ancestors, the dates
+// of schedule calendars, and geofence zones are looked up
+var refreshPeriod = time.Minute
+
 // RuleClient is a SIOT client used to run rules
 type RuleClient struct {
 	nc            *nats.Conn
@@ -173,6 +201,23 @@ type RuleClient struct {
 	newEdgePoints chan NewPoints
 	newRulePoints chan NewPoints
 	upSub         *nats.Subscription
+
+	// timezone of the nearest ancestor that has one
+	siteTimezone string
+	locations    map[string]*time.Location
+
+	// dates of the calendars of schedule conditions by calendar ID
+	calendars map[string]map[string]string
+
+	// zones and the last positions of the nodes of geofence conditions
+	zones     map[string]Zone
+	positions map[string]*rulePosition
+
+	refreshed time.Time
+
+	// weekday points of schedule conditions by condition ID. These are
+	// keyed points that can't be decoded into the condition.
+	weekdays map[string]map[time.Weekday]bool
 }
 
 // NewRuleClient ...
@@ -184,6 +229,11 @@ func NewRuleClient(nc *nats.Conn, config Rule) Client {
 		newPoints:     make(chan NewPoints),
 		newEdgePoints: make(chan NewPoints),
 		newRulePoints: make(chan NewPoints),
+		locations:     make(map[string]*time.Location),
+		weekdays:      make(map[string]map[time.Weekday]bool),
+		calendars:     make(map[string]map[string]string),
+		zones:         make(map[string]Zone),
+		positions:     make(map[string]*rulePosition),
 	}
 }
 
@@ -216,49 +266,59 @@ func (rc *RuleClient) Start() error {
 		return fmt.Errorf("Rule error subscribing to upsub: %v", err)
 	}
 
+	for _, c := range rc.config.Conditions {
+		if c.ConditionType != data.PointValueSchedule {
+			continue
+		}
+		nodes, err := GetNode(rc.nc, c.ID, rc.config.ID)
+		if err != nil || len(nodes) < 1 {
+			log.Println("Rule error getting schedule condition: ", err)
+			continue
+		}
+		rc.updateWeekdays(c.ID, nodes[0].Points)
+	}
+
+	rc.updateGeofences()
+
+	scheduleTicker := time.NewTicker(scheduleCheckPeriod)
+
 done:
 	for {
 		select {
 		case <-rc.stop:
 			break done
 		case pts := <-rc.newRulePoints:
-			active, changed, err := rc.ruleProcessPoints(pts.ID, pts.Points)
-
-			if err != nil {
-				log.Println("Error processing rule point: ", err)
-			}
-
-			if !changed {
+			rc.processPoints(pts.ID, pts.Points)
+		case now := <-scheduleTicker.C:
+			if !rc.hasCondition(data.PointValueSchedule) &&
+				!rc.hasCondition(data.PointValueGeofence) {
 				continue
 			}
 
-			if active {
-				err := rc.ruleRunActions(rc.config.Actions, pts.ID)
-				if err != nil {
-					log.Println("Error running rule actions: ", err)
-				}
-
-				err = rc.ruleRunInactiveActions(rc.config.ActionsInactive)
-				if err != nil {
-					log.Println("Error running rule inactive actions: ", err)
-				}
-			} else {
-				err := rc.ruleRunActions(rc.config.ActionsInactive, pts.ID)
-				if err != nil {
-					log.Println("Error running rule actions: ", err)
-				}
-
-				err = rc.ruleRunInactiveActions(rc.config.Actions)
-				if err != nil {
-					log.Println("Error running rule inactive actions: ", err)
-				}
+			if now.Sub(rc.refreshed) > refreshPeriod {
+				rc.siteTimezone = rc.findSiteTimezone()
+				rc.updateCalendars()
+				rc.updateGeofences()
+				rc.refreshed = now
 			}
 
+			// schedule conditions are evaluated on trigger points, and
+			// geofence conditions are evaluated again in case a zone
+			// changed
+			rc.processPoints(rc.config.ID, data.Points{{Time: now, Type: data.PointTypeTrigger}})
 		case pts := <-rc.newPoints:
 			err := data.MergePoints(pts.ID, pts.Points, &rc.config)
 			if err != nil {
 				log.Println("error merging rule points: ", err)
 			}
+			rc.updateWeekdays(pts.ID, pts.Points)
+			for _, p := range pts.Points {
+				switch p.Type {
+				case data.PointTypeCalendarID, data.PointTypeZoneID, data.PointTypeNodeID:
+					// read the new calendar or zone on the next check
+					rc.refreshed = time.Time{}
+				}
+			}
 		case pts := <-rc.newEdgePoints:
 			err := data.MergeEdgePoints(pts.ID, pts.Parent, pts.Points, &rc.config)
 			if err != nil {
@@ -267,11 +327,299 @@ done:
 		}
 	}
 
+	scheduleTicker.Stop()
 	rc.upSub.Unsubscribe()
 
 	return nil
 }
 
+// processPoints runs points through the rule conditions, and runs the
+// actions if the rule active state changed
+func (rc *RuleClient) processPoints(nodeID string, points data.Points) {
+	rc.updatePositions(nodeID, points)
+
+	active, changed, err := rc.ruleProcessPoints(nodeID, points)
+
+	if err != nil {
+		log.Println("Error processing rule point: ", err)
+	}
+
+	if !changed {
+		return
+	}
+
+	if active {
+		err := rc.ruleRunActions(rc.config.Actions, nodeID)
+		if err != nil {
+			log.Println("Error running rule actions: ", err)
+		}
+
+		err = rc.ruleRunInactiveActions(rc.config.ActionsInactive)
+		if err != nil {
+			log.Println("Error running rule inactive actions: ", err)
+		}
+	} else {
+		err := rc.ruleRunActions(rc.config.ActionsInactive, nodeID)
+		if err != nil {
+			log.Println("Error running rule actions: ", err)
+		}
+
+		err = rc.ruleRunInactiveActions(rc.config.Actions)
+		if err != nil {
+			log.Println("Error running rule inactive actions: ", err)
+		}
+	}
+}
+
+// updateWeekdays sets the weekdays of a schedule condition from its weekday
+// points. The key of a weekday point is the day (0 is Sunday), and the value
+// is 1 if the schedule is active on that day.
+func (rc *RuleClient) updateWeekdays(condID string, points data.Points) {
+	for i, c := range rc.config.Conditions {
+		if c.ID != condID {
+			continue
+		}
+
+		days := rc.weekdays[condID]
+		if days == nil {
+			days = make(map[time.Weekday]bool)
+			rc.weekdays[condID] = days
+		}
+
+		changed := false
+		for _, p := range points {
+			if p.Type != data.PointTypeWeekday {
+				continue
+			}
+			d, err := strconv.Atoi(p.Key)
+			if err != nil || d < 0 || d > 6 {
+				continue
+			}
+			days[time.Weekday(d)] = p.Value != 0 && p.Tombstone == 0
+			changed = true
+		}
+
+		if !changed {
+			return
+		}
+
+		var weekdays []time.Weekday
+		for d := time.Sunday; d <= time.Saturday; d++ {
+			if days[d] {
+				weekdays = append(weekdays, d)
+			}
+		}
+
+		rc.config.Conditions[i].Weekdays = weekdays
+		return
+	}
+}
+
+// hasCondition returns true if the rule has a condition of conditionType
+func (rc *RuleClient) hasCondition(conditionType string) bool {
+	for _, c := range rc.config.Conditions {
+		if c.ConditionType == conditionType {
+			return true
+		}
+	}
+	return false
+}
+
+// rulePosition is the last location of a node used in a geofence condition
+type rulePosition struct {
+	pos             data.GpsPos
+	latSet, longSet bool
+	time            time.Time
+}
+
+// updatePositions updates the positions of the nodes of geofence conditions
+// from their latitude and longitude points
+func (rc *RuleClient) updatePositions(nodeID string, points data.Points) {
+	tracked := false
+	for _, c := range rc.config.Conditions {
+		if c.ConditionType == data.PointValueGeofence && c.NodeID == nodeID {
+			tracked = true
+			break
+		}
+	}
+
+	if !tracked {
+		return
+	}
+
+	pos, ok := rc.positions[nodeID]
+	if !ok {
+		pos = &rulePosition{}
+		rc.positions[nodeID] = pos
+	}
+
+	for _, p := range points {
+		if p.Tombstone != 0 || p.Time.Before(pos.time) {
+			continue
+		}
+
+		switch p.Type {
+		case data.PointTypeLatitude:
+			pos.pos.Lat = p.Value
+			pos.latSet = true
+		case data.PointTypeLongitude:
+			pos.pos.Long = p.Value
+			pos.longSet = true
+		default:
+			continue
+		}
+
+		pos.time = p.Time
+	}
+}
+
+// updateGeofences gets the zones of geofence conditions, and the positions
+// of nodes that have not sent a location since the rule started. If a zone
+// can't be read, the last zone read is kept.
+func (rc *RuleClient) updateGeofences() {
+	zones := make(map[string]Zone)
+
+	for _, c := range rc.config.Conditions {
+		if c.ConditionType != data.PointValueGeofence {
+			continue
+		}
+
+		if c.NodeID != "" {
+			if pos, ok := rc.positions[c.NodeID]; !ok || !pos.latSet || !pos.longSet {
+				nodes, err := GetNode(rc.nc, c.NodeID, "all")
+				if err == nil && len(nodes) > 0 {
+					rc.updatePositions(c.NodeID, nodes[0].Points)
+				}
+			}
+		}
+
+		if c.ZoneID == "" {
+			continue
+		}
+
+		if _, ok := zones[c.ZoneID]; ok {
+			continue
+		}
+
+		z, ok := rc.zones[c.ZoneID]
+
+		nodes, err := GetNodeType[Zone](rc.nc, c.ZoneID, "")
+		if err != nil || len(nodes) < 1 {
+			log.Printf("Rule %v: error getting zone %v: %v\n",
+				rc.config.Description, c.ZoneID, err)
+		} else {
+			z, ok = nodes[0], true
+			if _, err := z.Contains(data.GpsPos{}); err != nil {
+				log.Printf("Rule %v: invalid zone %v: %v\n",
+					rc.config.Description, z.Description, err)
+			}
+		}
+
+		if ok {
+			zones[c.ZoneID] = z
+		}
+	}
+
+	rc.zones = zones
+}
+
+// geofenceActive returns true if the node of a geofence condition is inside
+// its zone, or outside if the operator is outside. ok is false if the
+// position or zone is not known.
+func (rc *RuleClient) geofenceActive(c Condition) (active bool, ok bool) {
+	pos, ok := rc.positions[c.NodeID]
+	if !ok || !pos.latSet || !pos.longSet {
+		return false, false
+	}
+
+	z, ok := rc.zones[c.ZoneID]
+	if !ok {
+		return false, false
+	}
+
+	inside, err := z.Contains(pos.pos)
+	if err != nil {
+		return false, false
+	}
+
+	return inside == (c.Operator != data.PointValueOutside), true
+}
+
+// findSiteTimezone returns the timezone point of the nearest ancestor of the
+// rule that has one, or ""
+func (rc *RuleClient) findSiteTimezone() string {
+	id := rc.config.Parent
+	// limit the depth in case of a loop
+	for i := 0; i < 50 && id != "" && id != "root"; i++ {
+		nodes, err := GetNode(rc.nc, id, "all")
+		if err != nil || len(nodes) < 1 {
+			return ""
+		}
+
+		if tz, ok := nodes[0].Points.Text(data.PointTypeTimezone, ""); ok && tz != "" {
+			return tz
+		}
+
+		id = nodes[0].Parent
+	}
+
+	return ""
+}
+
+// updateCalendars gets the dates of the calendars of schedule conditions. If
+// a calendar can't be read, the last dates read are kept.
+func (rc *RuleClient) updateCalendars() {
+	calendars := make(map[string]map[string]string)
+	for _, c := range rc.config.Conditions {
+		if c.ConditionType != data.PointValueSchedule || c.CalendarID == "" {
+			continue
+		}
+
+		if _, ok := calendars[c.CalendarID]; ok {
+			continue
+		}
+
+		dates, err := GetCalendarDates(rc.nc, c.CalendarID)
+		if err != nil {
+			log.Printf("Rule %v: error getting calendar %v: %v\n",
+				rc.config.Description, c.CalendarID, err)
+			dates = rc.calendars[c.CalendarID]
+		}
+
+		calendars[c.CalendarID] = dates
+	}
+
+	rc.calendars = calendars
+}
+
+// location returns the timezone of a schedule condition. Invalid timezones
+// are logged once and UTC is used.
+func (rc *RuleClient) location(c Condition) *time.Location {
+	tz := c.Timezone
+	if tz == "" {
+		tz = rc.config.Timezone
+	}
+	if tz == "" {
+		tz = rc.siteTimezone
+	}
+	if tz == "" {
+		return time.UTC
+	}
+
+	if loc, ok := rc.locations[tz]; ok {
+		return loc
+	}
+
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		log.Printf("Rule %v: invalid timezone %v, using UTC\n", rc.config.Description, tz)
+		loc = time.UTC
+	}
+
+	rc.locations[tz] = loc
+	return loc
+}
+
 // Stop sends a signal to the Start function to exit
 func (rc *RuleClient) Stop(err error) {
 	close(rc.stop)
@@ -319,6 +667,10 @@ func (rc *RuleClient) ruleProcessPoints(nodeID string, points data.Points) (bool
 					continue
 				}
 
+				if c.IgnoreBadQuality && !p.GoodQuality() {
+					continue
+				}
+
 				// conditions match, so check value
 				switch c.ValueType {
 				case data.PointValueNumber:
@@ -354,7 +706,10 @@ func (rc *RuleClient) ruleProcessPoints(nodeID string, points data.Points) (bool
 					continue
 				}
 				pointsProcessed = true
-				sched := newSchedule(c.StartTime, c.EndTime, c.Weekdays)
+				sched := newSchedule(c.StartTime, c.EndTime, c.Weekdays, rc.location(c))
+				if c.CalendarID != "" {
+					sched.setCalendar(rc.calendars[c.CalendarID], c.CalendarMode)
+				}
 
 				var err error
 				active, err = sched.activeForTime(p.Time)
@@ -362,6 +717,19 @@ func (rc *RuleClient) ruleProcessPoints(nodeID string, points data.Points) (bool
 					log.Println("Error parsing schedule time: ", err)
 					continue
 				}
+			case data.PointValueGeofence:
+				moved := nodeID == c.NodeID && (p.Type == data.PointTypeLatitude ||
+					p.Type == data.PointTypeLongitude)
+				if !moved && p.Type != data.PointTypeTrigger {
+					continue
+				}
+
+				var ok bool
+				active, ok = rc.geofenceActive(c)
+				if !ok {
+					continue
+				}
+				pointsProcessed = true
 			}
 
 			if active != c.Active {
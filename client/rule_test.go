@@ -1,6 +1,7 @@
 package client_test
 
 import (
+	"strconv"
 	"testing"
 	"time"
 
@@ -9,6 +10,11 @@ import (
 	"github.com/simpleiot/simpleiot/server"
 )
 
+// ruleTestTimeout is how long the tests wait for rule actions. Rules see
+// point changes after the config debounce, and tests are a lot slower with
+// the race detector.
+const ruleTestTimeout = client.DefaultConfigDebounce + 5*time.Second
+
 // TestRules populates a rule in the system that watches
 // a variable and when set, sets another variable. This
 // tests out the basic rule logic.
@@ -115,8 +121,9 @@ func TestRules(t *testing.T) {
 		t.Fatal("initial vout value is not correct")
 	}
 
-	// wait for rule to get set up
-	time.Sleep(200 * time.Millisecond)
+	// wait for rule to get set up. The rule client is restarted after the
+	// config debounce period when the conditions and actions are added.
+	time.Sleep(client.DefaultConfigDebounce + 300*time.Millisecond)
 
 	// set vin and look for vout to change
 	err = client.SendNodePoint(nc, vin.ID, data.Point{Type: data.PointTypeValue,
@@ -132,7 +139,7 @@ func TestRules(t *testing.T) {
 			// all is well
 			break
 		}
-		if time.Since(start) > time.Second {
+		if time.Since(start) > ruleTestTimeout {
 			t.Fatal("Timeout waiting for vout to be set")
 		}
 		<-time.After(time.Millisecond * 10)
@@ -152,10 +159,239 @@ func TestRules(t *testing.T) {
 			// all is well
 			break
 		}
-		if time.Since(start) > time.Second {
+		if time.Since(start) > ruleTestTimeout {
 			t.Fatal("Timeout waiting for vout to be cleared")
 		}
 		<-time.After(time.Millisecond * 10)
 	}
 
 }
+
+// TestRuleSchedule tests a schedule condition with weekdays in the timezone of
+// the rule parent
+func TestRuleSchedule(t *testing.T) {
+	nc, root, stop, err := server.TestServer()
+
+	if err != nil {
+		t.Fatal("Error starting test server: ", err)
+	}
+
+	defer stop()
+
+	tz := "Pacific/Auckland"
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		t.Fatal("Error loading location: ", err)
+	}
+
+	// the rule manager runs rules that are children of the root node, so
+	// the root node is the site
+	err = client.SendNodePoint(nc, root.ID, data.Point{Type: data.PointTypeTimezone, Text: tz}, true)
+	if err != nil {
+		t.Fatal("Error setting timezone: ", err)
+	}
+
+	vout := client.Variable{
+		ID:          "ID-varout",
+		Parent:      root.ID,
+		Description: "var out",
+	}
+
+	err = client.SendNodeType(nc, vout, "test")
+	if err != nil {
+		t.Fatal("Error sending node: ", err)
+	}
+
+	r := client.Rule{
+		ID:          "ID-rule",
+		Parent:      root.ID,
+		Description: "test rule",
+	}
+
+	err = client.SendNodeType(nc, r, "test")
+	if err != nil {
+		t.Fatal("Error sending node: ", err)
+	}
+
+	// active all day today in the site timezone, but not other days
+	today := time.Now().In(loc).Weekday()
+	cond := data.NodeEdge{
+		ID:     "ID-condition",
+		Type:   data.NodeTypeCondition,
+		Parent: r.ID,
+		Points: data.Points{
+			{Type: data.PointTypeConditionType, Text: data.PointValueSchedule},
+			{Type: data.PointTypeStart, Text: "0:00"},
+			{Type: data.PointTypeEnd, Text: "0:00"},
+		},
+	}
+
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		cond.Points = append(cond.Points, data.Point{Type: data.PointTypeWeekday,
+			Key: strconv.Itoa(int(d)), Value: data.BoolToFloat(d == today)})
+	}
+
+	err = client.SendNode(nc, cond, "test")
+	if err != nil {
+		t.Fatal("Error sending node: ", err)
+	}
+
+	a := client.Action{
+		ID:          "ID-action",
+		Parent:      r.ID,
+		Description: "action active",
+		Action:      data.PointValueSetValue,
+		PointType:   data.PointTypeValue,
+		NodeID:      vout.ID,
+		Value:       1,
+	}
+
+	err = client.SendNodeType(nc, a, "test")
+	if err != nil {
+		t.Fatal("Error sending node: ", err)
+	}
+
+	voutGet, voutStop, err := client.NodeWatcher[client.Variable](nc, vout.ID, vout.Parent)
+
+	if err != nil {
+		t.Fatal("Error setting up watcher")
+	}
+
+	defer voutStop()
+
+	// schedules are checked every 5s
+	start := time.Now()
+	for voutGet().Value != 1 {
+		if time.Since(start) > client.DefaultConfigDebounce+8*time.Second {
+			t.Fatal("Timeout waiting for schedule to set vout")
+		}
+		<-time.After(time.Millisecond * 50)
+	}
+}
+
+// TestRuleGeofence tests a geofence condition that is active when a tracked
+// node enters a zone
+func TestRuleGeofence(t *testing.T) {
+	nc, root, stop, err := server.TestServer()
+
+	if err != nil {
+		t.Fatal("Error starting test server: ", err)
+	}
+
+	defer stop()
+
+	zone := client.Zone{
+		ID:          "ID-zone",
+		Parent:      root.ID,
+		Description: "depot",
+		Latitude:    45,
+		Longitude:   -93,
+		Radius:      500,
+	}
+
+	err = client.SendNodeType(nc, zone, "test")
+	if err != nil {
+		t.Fatal("Error sending node: ", err)
+	}
+
+	// the truck starts about 5km away
+	truck := data.NodeEdge{
+		ID:     "ID-truck",
+		Type:   data.NodeTypeDevice,
+		Parent: root.ID,
+		Points: data.Points{
+			{Type: data.PointTypeDescription, Text: "truck"},
+			{Type: data.PointTypeLatitude, Value: 45.05},
+			{Type: data.PointTypeLongitude, Value: -93},
+		},
+	}
+
+	err = client.SendNode(nc, truck, "test")
+	if err != nil {
+		t.Fatal("Error sending node: ", err)
+	}
+
+	vout := client.Variable{
+		ID:          "ID-varout",
+		Parent:      root.ID,
+		Description: "var out",
+	}
+
+	err = client.SendNodeType(nc, vout, "test")
+	if err != nil {
+		t.Fatal("Error sending node: ", err)
+	}
+
+	r := client.Rule{
+		ID:          "ID-rule",
+		Parent:      root.ID,
+		Description: "test rule",
+	}
+
+	err = client.SendNodeType(nc, r, "test")
+	if err != nil {
+		t.Fatal("Error sending node: ", err)
+	}
+
+	c := client.Condition{
+		ID:            "ID-condition",
+		Parent:        r.ID,
+		Description:   "truck at depot",
+		ConditionType: data.PointValueGeofence,
+		NodeID:        truck.ID,
+		ZoneID:        zone.ID,
+		Operator:      data.PointValueInside,
+	}
+
+	err = client.SendNodeType(nc, c, "test")
+	if err != nil {
+		t.Fatal("Error sending node: ", err)
+	}
+
+	a := client.Action{
+		ID:          "ID-action",
+		Parent:      r.ID,
+		Description: "action active",
+		Action:      data.PointValueSetValue,
+		PointType:   data.PointTypeValue,
+		NodeID:      vout.ID,
+		Value:       1,
+	}
+
+	err = client.SendNodeType(nc, a, "test")
+	if err != nil {
+		t.Fatal("Error sending node: ", err)
+	}
+
+	voutGet, voutStop, err := client.NodeWatcher[client.Variable](nc, vout.ID, vout.Parent)
+
+	if err != nil {
+		t.Fatal("Error setting up watcher")
+	}
+
+	defer voutStop()
+
+	// wait for rule to start
+	time.Sleep(client.DefaultConfigDebounce + 500*time.Millisecond)
+
+	if voutGet().Value != 0 {
+		t.Fatal("vout set while truck is outside of zone")
+	}
+
+	now := time.Now()
+	err = client.SendNodePoints(nc, truck.ID, data.Points{
+		{Time: now, Type: data.PointTypeLatitude, Value: 45.001},
+		{Time: now, Type: data.PointTypeLongitude, Value: -93.001},
+	}, true)
+	if err != nil {
+		t.Fatal("Error sending location: ", err)
+	}
+
+	start := time.Now()
+	for voutGet().Value != 1 {
+		if time.Since(start) > ruleTestTimeout {
+			t.Fatal("Timeout waiting for geofence to set vout")
+		}
+		<-time.After(time.Millisecond * 20)
+	}
+}
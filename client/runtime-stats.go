@@ -0,0 +1,321 @@
+package client
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/simpleiot/simpleiot/data"
+)
+
+// runtimeStatsPublishPeriod is how often the current day statistics are
+// published while the client is running
+var runtimeStatsPublishPeriod = time.Minute
+
+// RuntimeStats config. A runtime stats node watches a point on its parent
+// node, for example the run signal of a pump or compressor, and computes
+// runtime hours, start counts, and duty cycle. The point is on when its
+// value is greater than threshold. Statistics for each day are published
+// as daily points at midnight (local time).
+type RuntimeStats struct {
+	ID          string  `node:"id"`
+	Parent      string  `node:"parent"`
+	Description string  `point:"description"`
+	PointType   string  `point:"pointType"`
+	PointKey    string  `point:"pointKey"`
+	Threshold   float64 `point:"threshold"`
+	Disable     bool    `point:"disable"`
+	// the following statistics are written by the client. The totals can
+	// be set by the user, for example to 0 after maintenance.
+	RuntimeToday float64 `point:"runtimeToday"`
+	CyclesToday  int     `point:"cyclesToday"`
+	RuntimeTotal float64 `point:"runtimeTotal"`
+	CyclesTotal  int     `point:"cyclesTotal"`
+}
+
+// runtimeDay contains the statistics for one day
+type runtimeDay struct {
+	day     time.Time
+	runtime time.Duration
+	cycles  int
+	duty    float64
+}
+
+// runtimeAccum accumulates runtime statistics for an on/off signal
+type runtimeAccum struct {
+	on           bool
+	last         time.Time
+	day          time.Time
+	runtimeToday time.Duration
+	cyclesToday  int
+	runtimeTotal time.Duration
+	cyclesTotal  int
+}
+
+// startOfDay returns midnight of the day t is in
+func startOfDay(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+}
+
+func newRuntimeAccum(on bool, now time.Time) *runtimeAccum {
+	return &runtimeAccum{on: on, last: now, day: startOfDay(now)}
+}
+
+// update processes a new signal state at time t and returns the statistics
+// for any days that ended before t
+func (ra *runtimeAccum) update(on bool, t time.Time) []runtimeDay {
+	if t.Before(ra.last) {
+		t = ra.last
+	}
+
+	var ret []runtimeDay
+
+	for {
+		next := ra.day.AddDate(0, 0, 1)
+		if t.Before(next) {
+			break
+		}
+
+		if ra.on {
+			ra.addRuntime(next.Sub(ra.last))
+		}
+
+		ret = append(ret, runtimeDay{
+			day:     ra.day,
+			runtime: ra.runtimeToday,
+			cycles:  ra.cyclesToday,
+			duty:    100 * float64(ra.runtimeToday) / float64(next.Sub(ra.day)),
+		})
+
+		ra.day = next
+		ra.last = next
+		ra.runtimeToday = 0
+		ra.cyclesToday = 0
+	}
+
+	if ra.on {
+		ra.addRuntime(t.Sub(ra.last))
+	}
+
+	if on && !ra.on {
+		ra.cyclesToday++
+		ra.cyclesTotal++
+	}
+
+	ra.on = on
+	ra.last = t
+
+	return ret
+}
+
+func (ra *runtimeAccum) addRuntime(d time.Duration) {
+	ra.runtimeToday += d
+	ra.runtimeTotal += d
+}
+
+// dutyToday returns the duty cycle in percent for the part of the current
+// day that has elapsed
+func (ra *runtimeAccum) dutyToday() float64 {
+	elapsed := ra.last.Sub(ra.day)
+	if elapsed <= 0 {
+		return 0
+	}
+	return 100 * float64(ra.runtimeToday) / float64(elapsed)
+}
+
+// RuntimeStatsClient computes runtime statistics for runtime stats nodes
+type RuntimeStatsClient struct {
+	nc            *nats.Conn
+	config        RuntimeStats
+	stop          chan struct{}
+	newPoints     chan NewPoints
+	newEdgePoints chan NewPoints
+	parentPoints  chan []data.Point
+}
+
+// NewRuntimeStatsClient ...
+func NewRuntimeStatsClient(nc *nats.Conn, config RuntimeStats) Client {
+	return &RuntimeStatsClient{
+		nc:            nc,
+		config:        config,
+		stop:          make(chan struct{}),
+		newPoints:     make(chan NewPoints),
+		newEdgePoints: make(chan NewPoints),
+		parentPoints:  make(chan []data.Point),
+	}
+}
+
+// load creates the accumulator from the current parent and stats node
+// points. Today's values are only restored if they were written today.
+func (rsc *RuntimeStatsClient) load(now time.Time) *runtimeAccum {
+	on := false
+
+	nodes, err := GetNode(rsc.nc, rsc.config.Parent, "none")
+	if err == nil && len(nodes) > 0 {
+		p, ok := nodes[0].Points.Find(rsc.config.PointType, rsc.config.PointKey)
+		on = ok && p.Value > rsc.config.Threshold
+	}
+
+	ra := newRuntimeAccum(on, now)
+	ra.runtimeTotal = time.Duration(rsc.config.RuntimeTotal * float64(time.Hour))
+	ra.cyclesTotal = rsc.config.CyclesTotal
+
+	nodes, err = GetNode(rsc.nc, rsc.config.ID, "none")
+	if err == nil && len(nodes) > 0 {
+		p, ok := nodes[0].Points.Find(data.PointTypeRuntimeToday, "")
+		if ok && !p.Time.Before(ra.day) {
+			ra.runtimeToday = time.Duration(rsc.config.RuntimeToday * float64(time.Hour))
+			ra.cyclesToday = rsc.config.CyclesToday
+		}
+	}
+
+	return ra
+}
+
+func (rsc *RuntimeStatsClient) publish(ra *runtimeAccum, days []runtimeDay) {
+	var pts data.Points
+
+	for _, d := range days {
+		pts = append(pts,
+			data.Point{Time: d.day, Type: data.PointTypeRuntimeDaily,
+				Value: d.runtime.Hours()},
+			data.Point{Time: d.day, Type: data.PointTypeCyclesDaily,
+				Value: float64(d.cycles)},
+			data.Point{Time: d.day, Type: data.PointTypeDutyCycleDaily,
+				Value: d.duty},
+		)
+	}
+
+	pts = append(pts,
+		data.Point{Time: ra.last, Type: data.PointTypeRuntimeToday,
+			Value: ra.runtimeToday.Hours()},
+		data.Point{Time: ra.last, Type: data.PointTypeCyclesToday,
+			Value: float64(ra.cyclesToday)},
+		data.Point{Time: ra.last, Type: data.PointTypeDutyCycleToday,
+			Value: ra.dutyToday()},
+		data.Point{Time: ra.last, Type: data.PointTypeRuntimeTotal,
+			Value: ra.runtimeTotal.Hours()},
+		data.Point{Time: ra.last, Type: data.PointTypeCyclesTotal,
+			Value: float64(ra.cyclesTotal)},
+	)
+
+	for i := range pts {
+		pts[i].Origin = rsc.config.ID
+	}
+
+	err := SendNodePoints(rsc.nc, rsc.config.ID, pts, false)
+	if err != nil {
+		log.Printf("Runtime stats %v: error sending points: %v\n",
+			rsc.config.Description, err)
+	}
+}
+
+// Start runs the main logic for this client and blocks until stopped
+func (rsc *RuntimeStatsClient) Start() error {
+	log.Println("Starting runtime stats client: ", rsc.config.Description)
+
+	sub, err := rsc.nc.Subscribe(SubjectNodePoints(rsc.config.Parent), func(msg *nats.Msg) {
+		points, err := data.PbDecodePoints(msg.Data)
+		if err != nil {
+			log.Println("Runtime stats error decoding parent points: ", err)
+			return
+		}
+
+		rsc.parentPoints <- points
+	})
+
+	if err != nil {
+		return fmt.Errorf("Runtime stats error subscribing to parent points: %v", err)
+	}
+
+	ra := rsc.load(time.Now())
+
+	t := time.NewTicker(runtimeStatsPublishPeriod)
+
+	update := func(on bool, ts time.Time) {
+		if rsc.config.Disable {
+			return
+		}
+
+		days := ra.update(on, ts)
+		rsc.publish(ra, days)
+	}
+
+	update(ra.on, time.Now())
+
+done:
+	for {
+		select {
+		case <-rsc.stop:
+			log.Println("Stopping runtime stats client: ", rsc.config.Description)
+			break done
+		case <-t.C:
+			update(ra.on, time.Now())
+		case pts := <-rsc.parentPoints:
+			for _, p := range pts {
+				if p.Type != rsc.config.PointType || p.Key != rsc.config.PointKey {
+					continue
+				}
+
+				ts := p.Time
+				if ts.IsZero() {
+					ts = time.Now()
+				}
+
+				update(p.Value > rsc.config.Threshold, ts)
+			}
+		case pts := <-rsc.newPoints:
+			err := data.MergePoints(pts.ID, pts.Points, &rsc.config)
+			if err != nil {
+				log.Println("error merging new points: ", err)
+			}
+
+			for _, p := range pts.Points {
+				// points written by this client are ignored
+				if p.Origin == rsc.config.ID {
+					continue
+				}
+
+				switch p.Type {
+				case data.PointTypeRuntimeTotal:
+					ra.runtimeTotal = time.Duration(p.Value * float64(time.Hour))
+				case data.PointTypeCyclesTotal:
+					ra.cyclesTotal = int(p.Value)
+				case data.PointTypePointType, data.PointTypePointKey,
+					data.PointTypeThreshold, data.PointTypeDisable:
+					ra = rsc.load(time.Now())
+				}
+			}
+
+		case pts := <-rsc.newEdgePoints:
+			err := data.MergeEdgePoints(pts.ID, pts.Parent, pts.Points, &rsc.config)
+			if err != nil {
+				log.Println("error merging new points: ", err)
+			}
+		}
+	}
+
+	// clean up
+	t.Stop()
+	sub.Unsubscribe()
+	return nil
+}
+
+// Stop sends a signal to the Start function to exit
+func (rsc *RuntimeStatsClient) Stop(err error) {
+	close(rsc.stop)
+}
+
+// Points is called by the Manager when new points for this
+// node are received.
+func (rsc *RuntimeStatsClient) Points(nodeID string, points []data.Point) {
+	rsc.newPoints <- NewPoints{nodeID, "", points}
+}
+
+// EdgePoints is called by the Manager when new edge points for this
+// node are received.
+func (rsc *RuntimeStatsClient) EdgePoints(nodeID, parentID string, points []data.Point) {
+	rsc.newEdgePoints <- NewPoints{nodeID, parentID, points}
+}
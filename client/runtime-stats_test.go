@@ -0,0 +1,70 @@
+package client
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRuntimeAccum(t *testing.T) {
+	start := time.Date(2022, 10, 20, 22, 0, 0, 0, time.UTC)
+	ra := newRuntimeAccum(false, start)
+
+	// on for 1h, off for 30m, then on across midnight
+	if days := ra.update(true, start.Add(30*time.Minute)); len(days) != 0 {
+		t.Fatal("unexpected day rollover: ", days)
+	}
+	ra.update(false, start.Add(90*time.Minute))
+	ra.update(true, start.Add(105*time.Minute))
+
+	days := ra.update(false, start.Add(150*time.Minute))
+	if len(days) != 1 {
+		t.Fatal("expected 1 day, got: ", days)
+	}
+
+	d := days[0]
+	if !d.day.Equal(time.Date(2022, 10, 20, 0, 0, 0, 0, time.UTC)) {
+		t.Error("wrong day: ", d.day)
+	}
+
+	if d.runtime != 75*time.Minute || d.cycles != 2 {
+		t.Errorf("wrong day stats, runtime: %v, cycles: %v", d.runtime, d.cycles)
+	}
+
+	if exp := 100 * 75.0 / (24 * 60); d.duty != exp {
+		t.Errorf("wrong duty cycle, expected %v, got %v", exp, d.duty)
+	}
+
+	// 30m after midnight were counted in the new day, the start was on the
+	// previous day
+	if ra.runtimeToday != 30*time.Minute || ra.cyclesToday != 0 {
+		t.Errorf("wrong today stats, runtime: %v, cycles: %v",
+			ra.runtimeToday, ra.cyclesToday)
+	}
+
+	if ra.runtimeTotal != 105*time.Minute || ra.cyclesTotal != 2 {
+		t.Errorf("wrong totals, runtime: %v, cycles: %v", ra.runtimeTotal, ra.cyclesTotal)
+	}
+
+	if duty := ra.dutyToday(); duty != 100 {
+		t.Error("expected 100% duty today, got: ", duty)
+	}
+
+	// points older than the last update do not reduce runtime
+	ra.update(true, start)
+	if ra.runtimeTotal != 105*time.Minute || ra.cyclesTotal != 3 {
+		t.Errorf("wrong totals after old point, runtime: %v, cycles: %v",
+			ra.runtimeTotal, ra.cyclesTotal)
+	}
+
+	// on for several days
+	days = ra.update(false, start.Add(3*24*time.Hour))
+	if len(days) != 2 {
+		t.Fatal("expected 2 days, got: ", len(days))
+	}
+
+	for _, d := range days {
+		if d.runtime != 24*time.Hour || d.duty != 100 {
+			t.Errorf("wrong stats for %v, runtime: %v, duty: %v", d.day, d.runtime, d.duty)
+		}
+	}
+}
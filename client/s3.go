@@ -0,0 +1,248 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// s3Timeout is the max time an S3 request can take
+var s3Timeout = 60 * time.Second
+
+// errS3NotFound is returned if an object does not exist
+var errS3NotFound = errors.New("object not found")
+
+// s3Client is a minimal client for S3 compatible object storage (AWS S3,
+// MinIO, etc.). Requests are signed with AWS signature version 4 and use
+// path style URLs, so MinIO works without DNS setup.
+type s3Client struct {
+	endpoint   *url.URL
+	bucket     string
+	region     string
+	accessKey  string
+	secretKey  string
+	httpClient *http.Client
+}
+
+func newS3Client(endpoint, bucket, region, accessKey, secretKey string) (*s3Client, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("S3 endpoint must be an http or https URL: %v", endpoint)
+	}
+
+	if bucket == "" {
+		return nil, errors.New("S3 bucket must be set")
+	}
+
+	if region == "" {
+		region = "us-east-1"
+	}
+
+	return &s3Client{
+		endpoint:   u,
+		bucket:     bucket,
+		region:     region,
+		accessKey:  accessKey,
+		secretKey:  secretKey,
+		httpClient: &http.Client{},
+	}, nil
+}
+
+// s3Escape encodes a string as required by AWS signature version 4, where
+// only unreserved characters are not encoded
+func s3Escape(s string, path bool) string {
+	var b strings.Builder
+	for _, c := range []byte(s) {
+		if c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9' ||
+			c == '-' || c == '_' || c == '.' || c == '~' || (path && c == '/') {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// sign adds the AWS signature version 4 headers to a request
+func (c *s3Client) sign(req *http.Request, payloadHash string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", payloadHash)
+
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalHeaders := "host:" + req.URL.Host + "\n" +
+		"x-amz-content-sha256:" + payloadHash + "\n" +
+		"x-amz-date:" + amzDate + "\n"
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		s3Escape(req.URL.Path, true),
+		req.URL.RawQuery,
+		canonicalHeaders,
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + c.region + "/s3/aws4_request"
+	crHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" +
+		hex.EncodeToString(crHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+c.secretKey), date)
+	key = hmacSHA256(key, c.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%v/%v, SignedHeaders=%v, Signature=%v",
+		c.accessKey, scope, signedHeaders, hex.EncodeToString(hmacSHA256(key, stringToSign))))
+}
+
+// s3Query encodes query parameters sorted by name, as required by AWS
+// signature version 4
+func s3Query(v url.Values) string {
+	keys := make([]string, 0, len(v))
+	for k := range v {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var parts []string
+	for _, k := range keys {
+		for _, val := range v[k] {
+			parts = append(parts, s3Escape(k, false)+"="+s3Escape(val, false))
+		}
+	}
+
+	return strings.Join(parts, "&")
+}
+
+// do sends a signed request for an object, or for the bucket if key is
+// empty, and returns the response body
+func (c *s3Client) do(method, key string, query url.Values, body []byte) ([]byte, error) {
+	u := *c.endpoint
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + c.bucket
+	if key != "" {
+		u.Path += "/" + key
+	}
+	u.RawQuery = s3Query(query)
+
+	ctx, cancel := context.WithTimeout(context.Background(), s3Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	sum := sha256.Sum256(body)
+	c.sign(req, hex.EncodeToString(sum[:]), time.Now())
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	ret, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, errS3NotFound
+	}
+
+	if resp.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("S3 %v %v: %v: %v", method, key, resp.Status,
+			strings.TrimSpace(string(ret)))
+	}
+
+	return ret, nil
+}
+
+// put uploads an object
+func (c *s3Client) put(key string, body []byte) error {
+	_, err := c.do(http.MethodPut, key, nil, body)
+	return err
+}
+
+// get downloads an object. errS3NotFound is returned if the object does
+// not exist.
+func (c *s3Client) get(key string) ([]byte, error) {
+	return c.do(http.MethodGet, key, nil, nil)
+}
+
+// delete removes an object. Removing an object that does not exist is not
+// an error.
+func (c *s3Client) delete(key string) error {
+	_, err := c.do(http.MethodDelete, key, nil, nil)
+	if err == errS3NotFound {
+		return nil
+	}
+	return err
+}
+
+// list returns the keys of the objects that start with prefix
+func (c *s3Client) list(prefix string) ([]string, error) {
+	var ret []string
+	token := ""
+
+	for {
+		q := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+		if token != "" {
+			q.Set("continuation-token", token)
+		}
+
+		d, err := c.do(http.MethodGet, "", q, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		var res struct {
+			Contents []struct {
+				Key string
+			}
+			IsTruncated           bool
+			NextContinuationToken string
+		}
+
+		err = xml.Unmarshal(d, &res)
+		if err != nil {
+			return nil, fmt.Errorf("Error decoding S3 object list: %w", err)
+		}
+
+		for _, o := range res.Contents {
+			ret = append(ret, o.Key)
+		}
+
+		if !res.IsTruncated || res.NextContinuationToken == "" {
+			return ret, nil
+		}
+
+		token = res.NextContinuationToken
+	}
+}
@@ -6,24 +6,49 @@ import (
 	"regexp"
 	"strconv"
 	"time"
+
+	"github.com/simpleiot/simpleiot/data"
 )
 
 type schedule struct {
 	startTime string
 	endTime   string
 	weekdays  []time.Weekday
+	loc       *time.Location
+
+	// dates of a calendar (2006-01-02) that are skipped if calendarMode is
+	// exclude, or the only dates the schedule is active on if it is include
+	calendar     map[string]string
+	calendarMode string
 }
 
-func newSchedule(start, end string, weekdays []time.Weekday) *schedule {
+// newSchedule creates a schedule. Start and end times and weekdays are in
+// loc, or UTC if loc is nil.
+func newSchedule(start, end string, weekdays []time.Weekday, loc *time.Location) *schedule {
+	if loc == nil {
+		loc = time.UTC
+	}
+
 	return &schedule{
 		startTime: start,
 		endTime:   end,
 		weekdays:  weekdays,
+		loc:       loc,
 	}
 }
 
+// setCalendar sets the calendar dates that are excluded from the schedule,
+// or that the schedule is limited to
+func (s *schedule) setCalendar(dates map[string]string, mode string) {
+	if mode == "" {
+		mode = data.PointValueExclude
+	}
+	s.calendar = dates
+	s.calendarMode = mode
+}
+
 func (s *schedule) activeForTime(t time.Time) (bool, error) {
-	tUTC := t.UTC()
+	tLoc := t.In(s.loc)
 
 	// parse out hour/minute
 	matches := reHourMin.FindStringSubmatch(s.startTime)
@@ -58,27 +83,31 @@ func (s *schedule) activeForTime(t time.Time) (bool, error) {
 		return false, fmt.Errorf("TimeRange: error parsing end hour: %v", matches[1])
 	}
 
-	y := tUTC.Year()
-	m := tUTC.Month()
-	d := tUTC.Day()
+	y := tLoc.Year()
+	m := tLoc.Month()
+	d := tLoc.Day()
 
-	start := time.Date(y, m, d, startHour, startMin, 0, 0, time.UTC)
-	end := time.Date(y, m, d, endHour, endMin, 0, 0, time.UTC)
+	start := localTime(y, m, d, startHour, startMin, s.loc)
+	end := localTime(y, m, d, endHour, endMin, s.loc)
 
 	timeRanges := timeRanges{
 		{start, end},
 	}
 
-	if !timeRanges[0].end.After(timeRanges[0].start) {
-		timeRanges[0].end = timeRanges[0].end.AddDate(0, 0, 1)
+	// the schedule wraps to the next day if the end is not after the start
+	// on the clock. Clock times are compared so a range that spans a DST
+	// transition is not mistaken for a wrapping one.
+	if endHour*60+endMin <= startHour*60+startMin {
+		timeRanges[0].end = localTime(y, m, d+1, endHour, endMin, s.loc)
 
 		timeRanges = append(timeRanges,
-			timeRange{timeRanges[0].start.AddDate(0, 0, -1),
-				timeRanges[0].end.AddDate(0, 0, -1),
+			timeRange{localTime(y, m, d-1, startHour, startMin, s.loc),
+				end,
 			})
 	}
 
 	timeRanges.filterWeekdays(s.weekdays)
+	timeRanges.filterCalendar(s.calendar, s.calendarMode)
 
 	if timeRanges.in(t) {
 		return true, nil
@@ -87,6 +116,61 @@ func (s *schedule) activeForTime(t time.Time) (bool, error) {
 	return false, nil
 }
 
+// localTime returns the time of a clock time in loc. The returned time is in
+// loc so its weekday is the local weekday. DST transitions are
+// handled as follows:
+//   - clock times that are skipped when clocks move forward are moved to the
+//     end of the gap, so a schedule that starts or ends in the gap still
+//     runs.
+//   - clock times that repeat when clocks move back use the first
+//     occurrence, so a schedule does not run twice.
+func localTime(y int, m time.Month, d, hour, min int, loc *time.Location) time.Time {
+	wall := time.Date(y, m, d, hour, min, 0, 0, time.UTC)
+
+	// there is at most one transition within a day of the clock time
+	_, offBefore := wall.Add(-24 * time.Hour).In(loc).Zone()
+	_, offAfter := wall.Add(24 * time.Hour).In(loc).Zone()
+
+	t1 := wall.Add(-time.Duration(offBefore) * time.Second)
+	t2 := wall.Add(-time.Duration(offAfter) * time.Second)
+
+	valid := func(t time.Time) bool {
+		l := t.In(loc)
+		return l.Year() == wall.Year() && l.Month() == wall.Month() && l.Day() == wall.Day() &&
+			l.Hour() == hour && l.Minute() == min
+	}
+
+	v1, v2 := valid(t1), valid(t2)
+	switch {
+	case v1 && v2:
+		if t2.Before(t1) {
+			return t2.In(loc)
+		}
+		return t1.In(loc)
+	case v1:
+		return t1.In(loc)
+	case v2:
+		return t2.In(loc)
+	}
+
+	// the clock time is in a gap, find the transition
+	lo, hi := t1, t2
+	if hi.Before(lo) {
+		lo, hi = hi, lo
+	}
+
+	for hi.Sub(lo) > time.Second {
+		mid := lo.Add(hi.Sub(lo) / 2)
+		if _, off := mid.In(loc).Zone(); off == offBefore {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+
+	return hi.Truncate(time.Second).In(loc)
+}
+
 var reHourMin = regexp.MustCompile(`(\d{1,2}):(\d\d)`)
 var reDate = regexp.MustCompile(`(\d{4})-(\d{1,2})-(\d{1,2})`)
 
@@ -150,6 +234,25 @@ func (trs *timeRanges) filterWeekdays(weekdays []time.Weekday) {
 	*trs = trsNew
 }
 
+// filterCalendar removes time ranges that start on a calendar date if mode
+// is exclude, or that do not start on a calendar date if mode is include.
+// Dates are compared in the location of the range start.
+func (trs *timeRanges) filterCalendar(dates map[string]string, mode string) {
+	if mode == "" {
+		return
+	}
+
+	trsNew := (*trs)[:0]
+	for _, tr := range *trs {
+		_, found := dates[tr.start.Format("2006-01-02")]
+		if found == (mode == data.PointValueInclude) {
+			trsNew = append(trsNew, tr)
+		}
+	}
+
+	*trs = trsNew
+}
+
 // FilterDates removes time ranges that do not have the same date as the provided list of times
 func (trs *timeRanges) FilterDates(dates []time.Time) {
 	if len(dates) <= 0 {
@@ -3,6 +3,8 @@ package client
 import (
 	"testing"
 	"time"
+
+	"github.com/simpleiot/simpleiot/data"
 )
 
 type testTime struct {
@@ -27,7 +29,7 @@ func (tt *testTable) run(t *testing.T, sched *schedule) {
 }
 
 func TestScheduleAllDays(t *testing.T) {
-	sched := newSchedule("2:00", "5:00", []time.Weekday{})
+	sched := newSchedule("2:00", "5:00", []time.Weekday{}, nil)
 
 	tests := testTable{
 		{time.Date(2021, time.February, 10, 4, 0, 0, 0, time.UTC), true},
@@ -38,7 +40,7 @@ func TestScheduleAllDays(t *testing.T) {
 }
 
 func TestScheduleWeekdays(t *testing.T) {
-	sched := newSchedule("2:00", "5:00", []time.Weekday{0, 6})
+	sched := newSchedule("2:00", "5:00", []time.Weekday{0, 6}, nil)
 
 	// 2021-08-09 is a Monday
 	tests := testTable{
@@ -50,7 +52,7 @@ func TestScheduleWeekdays(t *testing.T) {
 }
 
 func TestScheduleWrapDay(t *testing.T) {
-	sched := newSchedule("20:00", "2:00", []time.Weekday{})
+	sched := newSchedule("20:00", "2:00", []time.Weekday{}, nil)
 
 	// 2021-08-09 is a Monday
 	tests := testTable{
@@ -62,7 +64,7 @@ func TestScheduleWrapDay(t *testing.T) {
 }
 
 func TestScheduleWrapDayWeekday(t *testing.T) {
-	sched := newSchedule("20:00", "2:00", []time.Weekday{1})
+	sched := newSchedule("20:00", "2:00", []time.Weekday{1}, nil)
 
 	// 2021-08-09 is a Monday
 	tests := testTable{
@@ -75,3 +77,119 @@ func TestScheduleWrapDayWeekday(t *testing.T) {
 
 	tests.run(t, sched)
 }
+
+func TestScheduleTimezone(t *testing.T) {
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal("Error loading location: ", err)
+	}
+
+	sched := newSchedule("8:00", "17:00", []time.Weekday{}, loc)
+
+	// 8:30 local time in winter (EST) and summer (EDT)
+	tests := testTable{
+		{time.Date(2021, time.January, 15, 13, 30, 0, 0, time.UTC), true},
+		{time.Date(2021, time.July, 15, 12, 30, 0, 0, time.UTC), true},
+		{time.Date(2021, time.July, 15, 11, 30, 0, 0, time.UTC), false},
+	}
+
+	tests.run(t, sched)
+}
+
+func TestScheduleDSTSkip(t *testing.T) {
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal("Error loading location: ", err)
+	}
+
+	// clocks move from 2:00 to 3:00 on 2021-03-14, so the start is moved
+	// to 3:00 EDT (7:00 UTC)
+	sched := newSchedule("2:30", "4:00", []time.Weekday{}, loc)
+
+	tests := testTable{
+		{time.Date(2021, time.March, 14, 6, 50, 0, 0, time.UTC), false},
+		{time.Date(2021, time.March, 14, 7, 10, 0, 0, time.UTC), true},
+		{time.Date(2021, time.March, 14, 8, 10, 0, 0, time.UTC), false},
+	}
+
+	tests.run(t, sched)
+}
+
+func TestScheduleDSTRepeat(t *testing.T) {
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal("Error loading location: ", err)
+	}
+
+	// clocks move from 2:00 back to 1:00 on 2021-11-07, so 1:15-1:45
+	// happens twice. Only the first (EDT, 5:15-5:45 UTC) is used.
+	sched := newSchedule("1:15", "1:45", []time.Weekday{}, loc)
+
+	tests := testTable{
+		{time.Date(2021, time.November, 7, 5, 30, 0, 0, time.UTC), true},
+		{time.Date(2021, time.November, 7, 6, 30, 0, 0, time.UTC), false},
+	}
+
+	tests.run(t, sched)
+}
+
+func TestScheduleWrapDayDST(t *testing.T) {
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal("Error loading location: ", err)
+	}
+
+	// the night of 2021-11-06 is an hour longer
+	sched := newSchedule("22:00", "6:00", []time.Weekday{6}, loc)
+
+	tests := testTable{
+		// 22:30 EDT Saturday
+		{time.Date(2021, time.November, 7, 2, 30, 0, 0, time.UTC), true},
+		// 5:30 EST Sunday
+		{time.Date(2021, time.November, 7, 10, 30, 0, 0, time.UTC), true},
+		// 6:30 EST Sunday
+		{time.Date(2021, time.November, 7, 11, 30, 0, 0, time.UTC), false},
+	}
+
+	tests.run(t, sched)
+}
+
+func TestScheduleCalendarExclude(t *testing.T) {
+	sched := newSchedule("20:00", "2:00", []time.Weekday{}, nil)
+	sched.setCalendar(map[string]string{"2021-12-25": "Christmas"}, "")
+
+	tests := testTable{
+		{time.Date(2021, time.December, 24, 21, 0, 0, 0, time.UTC), true},
+		{time.Date(2021, time.December, 25, 21, 0, 0, 0, time.UTC), false},
+		// the range that starts the day before is not excluded
+		{time.Date(2021, time.December, 25, 1, 0, 0, 0, time.UTC), true},
+		{time.Date(2021, time.December, 26, 1, 0, 0, 0, time.UTC), false},
+	}
+
+	tests.run(t, sched)
+}
+
+func TestScheduleCalendarInclude(t *testing.T) {
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal("Error loading location: ", err)
+	}
+
+	sched := newSchedule("8:00", "17:00", []time.Weekday{}, loc)
+	sched.setCalendar(map[string]string{"2021-07-15": "Booked"}, data.PointValueInclude)
+
+	tests := testTable{
+		{time.Date(2021, time.July, 15, 12, 30, 0, 0, time.UTC), true},
+		{time.Date(2021, time.July, 16, 12, 30, 0, 0, time.UTC), false},
+	}
+
+	tests.run(t, sched)
+
+	// include with no dates is never active
+	sched.setCalendar(nil, data.PointValueInclude)
+	tests = testTable{
+		{time.Date(2021, time.July, 15, 12, 30, 0, 0, time.UTC), false},
+	}
+
+	tests.run(t, sched)
+}
@@ -0,0 +1,454 @@
+package client
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/simpleiot/simpleiot/data"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+)
+
+// scriptMaxSteps limits how many steps a script can run for each call
+// before it is stopped, so a script with an endless loop can't hang the
+// client
+var scriptMaxSteps uint64 = 10000000
+
+// Script config. A script node runs a Starlark (a Python dialect) script in
+// the script point. The script can read and write points on its parent
+// node or other nodes, subscribe to points, and set a timer. See
+// docs/user/script.md for the API.
+type Script struct {
+	ID          string `node:"id"`
+	Parent      string `node:"parent"`
+	Description string `point:"description"`
+	Script      string `point:"script"`
+	Disable     bool   `point:"disable"`
+}
+
+// scriptHost is the API a script can use to interact with SIOT
+type scriptHost interface {
+	point(node, typ, key string) (data.Point, bool)
+	setPoint(node string, p data.Point)
+	subscribe(node string) error
+	setTimer(period time.Duration)
+	log(msg string)
+}
+
+// scriptRunner is a loaded script
+type scriptRunner struct {
+	name    string
+	parent  string
+	host    scriptHost
+	globals starlark.StringDict
+}
+
+// newScriptRunner runs the top level of a script, which defines the
+// optional on_point(p) and on_timer() callbacks.
+func newScriptRunner(name, parent, src string, host scriptHost) (*scriptRunner, error) {
+	ret := &scriptRunner{name: name, parent: parent, host: host}
+
+	nodeArg := func(node string) string {
+		if node == "" {
+			return parent
+		}
+		return node
+	}
+
+	get := func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple,
+		kwargs []starlark.Tuple) (starlark.Value, error) {
+		var typ, key, node string
+		if err := starlark.UnpackArgs(b.Name(), args, kwargs, "type", &typ,
+			"key?", &key, "node?", &node); err != nil {
+			return nil, err
+		}
+
+		p, ok := host.point(nodeArg(node), typ, key)
+		if !ok {
+			return starlark.None, nil
+		}
+
+		if p.Text != "" {
+			return starlark.String(p.Text), nil
+		}
+
+		return starlark.Float(p.Value), nil
+	}
+
+	set := func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple,
+		kwargs []starlark.Tuple) (starlark.Value, error) {
+		var typ, key, node string
+		var value starlark.Value
+		if err := starlark.UnpackArgs(b.Name(), args, kwargs, "type", &typ,
+			"value", &value, "key?", &key, "node?", &node); err != nil {
+			return nil, err
+		}
+
+		p := data.Point{Time: time.Now(), Type: typ, Key: key}
+
+		switch v := value.(type) {
+		case starlark.String:
+			p.Text = string(v)
+		case starlark.Bool:
+			p.Value = data.BoolToFloat(bool(v))
+		default:
+			f, ok := starlark.AsFloat(value)
+			if !ok {
+				return nil, fmt.Errorf("%v: value must be a number, bool, or string, got %v",
+					b.Name(), value.Type())
+			}
+			p.Value = f
+		}
+
+		host.setPoint(nodeArg(node), p)
+		return starlark.None, nil
+	}
+
+	subscribe := func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple,
+		kwargs []starlark.Tuple) (starlark.Value, error) {
+		var node string
+		if err := starlark.UnpackArgs(b.Name(), args, kwargs, "node", &node); err != nil {
+			return nil, err
+		}
+
+		return starlark.None, host.subscribe(node)
+	}
+
+	setTimer := func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple,
+		kwargs []starlark.Tuple) (starlark.Value, error) {
+		var period float64
+		if err := starlark.UnpackArgs(b.Name(), args, kwargs, "seconds", &period); err != nil {
+			return nil, err
+		}
+
+		host.setTimer(time.Duration(period * float64(time.Second)))
+		return starlark.None, nil
+	}
+
+	// script globals are frozen after the top level runs, so state is
+	// provided for values that need to change between calls
+	predeclared := starlark.StringDict{
+		"parent":    starlark.String(parent),
+		"state":     starlark.NewDict(0),
+		"get":       starlark.NewBuiltin("get", get),
+		"set":       starlark.NewBuiltin("set", set),
+		"subscribe": starlark.NewBuiltin("subscribe", subscribe),
+		"set_timer": starlark.NewBuiltin("set_timer", setTimer),
+	}
+
+	var err error
+	ret.globals, err = starlark.ExecFile(ret.thread(), name, src, predeclared)
+	if err != nil {
+		return nil, fmt.Errorf("Error running script: %v", err)
+	}
+
+	return ret, nil
+}
+
+func (sr *scriptRunner) thread() *starlark.Thread {
+	thread := &starlark.Thread{
+		Name: sr.name,
+		Print: func(_ *starlark.Thread, msg string) {
+			sr.host.log(msg)
+		},
+	}
+	thread.SetMaxExecutionSteps(scriptMaxSteps)
+	return thread
+}
+
+// call calls a function defined by the script, if it is defined
+func (sr *scriptRunner) call(name string, args ...starlark.Value) error {
+	fn, ok := sr.globals[name]
+	if !ok {
+		return nil
+	}
+
+	_, err := starlark.Call(sr.thread(), fn, args, nil)
+	if err != nil {
+		if evalErr, ok := err.(*starlark.EvalError); ok {
+			return fmt.Errorf("Error in script %v: %v", name, evalErr.Backtrace())
+		}
+		return fmt.Errorf("Error in script %v: %v", name, err)
+	}
+
+	return nil
+}
+
+func (sr *scriptRunner) point(node string, p data.Point) error {
+	sp := starlarkstruct.FromStringDict(starlark.String("point"), starlark.StringDict{
+		"node":   starlark.String(node),
+		"type":   starlark.String(p.Type),
+		"key":    starlark.String(p.Key),
+		"value":  starlark.Float(p.Value),
+		"text":   starlark.String(p.Text),
+		"origin": starlark.String(p.Origin),
+		"time":   starlark.Float(float64(p.Time.UnixNano()) / 1e9),
+	})
+
+	return sr.call("on_point", sp)
+}
+
+func (sr *scriptRunner) timer() error {
+	return sr.call("on_timer")
+}
+
+// ScriptClient runs script nodes
+type ScriptClient struct {
+	nc            *nats.Conn
+	config        Script
+	stop          chan struct{}
+	newPoints     chan NewPoints
+	newEdgePoints chan NewPoints
+	subPoints     chan NewPoints
+	timerPeriod   chan time.Duration
+
+	// points of subscribed nodes, used by get
+	lock    sync.Mutex
+	current map[string]data.Points
+	subs    map[string]*nats.Subscription
+}
+
+// NewScriptClient ...
+func NewScriptClient(nc *nats.Conn, config Script) Client {
+	return &ScriptClient{
+		nc:            nc,
+		config:        config,
+		stop:          make(chan struct{}),
+		newPoints:     make(chan NewPoints),
+		newEdgePoints: make(chan NewPoints),
+		subPoints:     make(chan NewPoints),
+		// scripts run in the Start loop, so this is buffered so
+		// set_timer does not block
+		timerPeriod: make(chan time.Duration, 1),
+		current:     make(map[string]data.Points),
+		subs:        make(map[string]*nats.Subscription),
+	}
+}
+
+func (sc *ScriptClient) point(node, typ, key string) (data.Point, bool) {
+	sc.lock.Lock()
+	pts, ok := sc.current[node]
+	sc.lock.Unlock()
+
+	if !ok {
+		// not subscribed, so fetch the node
+		nodes, err := GetNode(sc.nc, node, "none")
+		if err != nil || len(nodes) < 1 {
+			return data.Point{}, false
+		}
+		pts = nodes[0].Points
+	}
+
+	return pts.Find(typ, key)
+}
+
+func (sc *ScriptClient) setPoint(node string, p data.Point) {
+	p.Origin = sc.config.ID
+
+	sc.lock.Lock()
+	if pts, ok := sc.current[node]; ok {
+		pts.Add(p)
+		sc.current[node] = pts
+	}
+	sc.lock.Unlock()
+
+	err := SendNodePoint(sc.nc, node, p, false)
+	if err != nil {
+		log.Printf("Script %v: error sending point: %v\n", sc.config.Description, err)
+	}
+}
+
+func (sc *ScriptClient) subscribe(node string) error {
+	sc.lock.Lock()
+	_, ok := sc.subs[node]
+	sc.lock.Unlock()
+
+	if ok {
+		return nil
+	}
+
+	nodes, err := GetNode(sc.nc, node, "none")
+	if err != nil {
+		return fmt.Errorf("Error getting node %v: %v", node, err)
+	}
+
+	var pts data.Points
+	if len(nodes) > 0 {
+		pts = nodes[0].Points
+	}
+
+	sub, err := sc.nc.Subscribe(SubjectNodePoints(node), func(msg *nats.Msg) {
+		points, err := data.PbDecodePoints(msg.Data)
+		if err != nil {
+			log.Println("Script error decoding points: ", err)
+			return
+		}
+
+		sc.subPoints <- NewPoints{ID: node, Points: points}
+	})
+
+	if err != nil {
+		return fmt.Errorf("Error subscribing to node %v: %v", node, err)
+	}
+
+	sc.lock.Lock()
+	sc.current[node] = pts
+	sc.subs[node] = sub
+	sc.lock.Unlock()
+
+	return nil
+}
+
+func (sc *ScriptClient) unsubscribeAll() {
+	sc.lock.Lock()
+	defer sc.lock.Unlock()
+
+	for node, sub := range sc.subs {
+		err := sub.Unsubscribe()
+		if err != nil {
+			log.Println("Script error unsubscribing: ", err)
+		}
+		delete(sc.subs, node)
+		delete(sc.current, node)
+	}
+}
+
+func (sc *ScriptClient) setTimer(period time.Duration) {
+	// only the latest period matters
+	select {
+	case <-sc.timerPeriod:
+	default:
+	}
+	sc.timerPeriod <- period
+}
+
+func (sc *ScriptClient) log(msg string) {
+	log.Printf("Script %v: %v\n", sc.config.Description, msg)
+}
+
+// Start runs the main logic for this client and blocks until stopped
+func (sc *ScriptClient) Start() error {
+	log.Println("Starting script client: ", sc.config.Description)
+
+	var runner *scriptRunner
+
+	t := time.NewTicker(time.Hour)
+	t.Stop()
+
+	unload := func() {
+		t.Stop()
+		runner = nil
+		sc.unsubscribeAll()
+	}
+
+	load := func() {
+		unload()
+
+		if sc.config.Disable || sc.config.Script == "" {
+			return
+		}
+
+		// scripts always receive points for the parent node
+		err := sc.subscribe(sc.config.Parent)
+		if err != nil {
+			log.Printf("Script %v: %v\n", sc.config.Description, err)
+			return
+		}
+
+		runner, err = newScriptRunner(sc.config.Description, sc.config.Parent,
+			sc.config.Script, sc)
+		if err != nil {
+			log.Printf("Script %v: %v\n", sc.config.Description, err)
+			unload()
+		}
+	}
+
+	run := func(f func() error) {
+		if runner == nil {
+			return
+		}
+
+		err := f()
+		if err != nil {
+			log.Printf("Script %v: %v\n", sc.config.Description, err)
+		}
+	}
+
+	load()
+
+done:
+	for {
+		select {
+		case <-sc.stop:
+			log.Println("Stopping script client: ", sc.config.Description)
+			break done
+		case period := <-sc.timerPeriod:
+			t.Stop()
+			if period > 0 && runner != nil {
+				t.Reset(period)
+			}
+		case <-t.C:
+			run(func() error { return runner.timer() })
+		case pts := <-sc.subPoints:
+			sc.lock.Lock()
+			cur, ok := sc.current[pts.ID]
+			if ok {
+				for _, p := range pts.Points {
+					cur.Add(p)
+				}
+				sc.current[pts.ID] = cur
+			}
+			sc.lock.Unlock()
+
+			for _, p := range pts.Points {
+				// skip points written by this script
+				if p.Origin == sc.config.ID {
+					continue
+				}
+				run(func() error { return runner.point(pts.ID, p) })
+			}
+		case pts := <-sc.newPoints:
+			err := data.MergePoints(pts.ID, pts.Points, &sc.config)
+			if err != nil {
+				log.Println("error merging new points: ", err)
+			}
+
+			for _, p := range pts.Points {
+				switch p.Type {
+				case data.PointTypeScript, data.PointTypeDisable:
+					load()
+				}
+			}
+
+		case pts := <-sc.newEdgePoints:
+			err := data.MergeEdgePoints(pts.ID, pts.Parent, pts.Points, &sc.config)
+			if err != nil {
+				log.Println("error merging new points: ", err)
+			}
+		}
+	}
+
+	// clean up
+	unload()
+	return nil
+}
+
+// Stop sends a signal to the Start function to exit
+func (sc *ScriptClient) Stop(err error) {
+	close(sc.stop)
+}
+
+// Points is called by the Manager when new points for this
+// node are received.
+func (sc *ScriptClient) Points(nodeID string, points []data.Point) {
+	sc.newPoints <- NewPoints{nodeID, "", points}
+}
+
+// EdgePoints is called by the Manager when new edge points for this
+// node are received.
+func (sc *ScriptClient) EdgePoints(nodeID, parentID string, points []data.Point) {
+	sc.newEdgePoints <- NewPoints{nodeID, parentID, points}
+}
@@ -0,0 +1,136 @@
+package client
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/simpleiot/simpleiot/data"
+)
+
+type scriptTestHost struct {
+	points map[string]data.Points
+	subs   []string
+	period time.Duration
+	logs   []string
+}
+
+func newScriptTestHost() *scriptTestHost {
+	return &scriptTestHost{points: make(map[string]data.Points)}
+}
+
+func (h *scriptTestHost) point(node, typ, key string) (data.Point, bool) {
+	pts := h.points[node]
+	return pts.Find(typ, key)
+}
+
+func (h *scriptTestHost) setPoint(node string, p data.Point) {
+	pts := h.points[node]
+	pts.Add(p)
+	h.points[node] = pts
+}
+
+func (h *scriptTestHost) subscribe(node string) error {
+	h.subs = append(h.subs, node)
+	return nil
+}
+
+func (h *scriptTestHost) setTimer(period time.Duration) {
+	h.period = period
+}
+
+func (h *scriptTestHost) log(msg string) {
+	h.logs = append(h.logs, msg)
+}
+
+func TestScriptRunner(t *testing.T) {
+	host := newScriptTestHost()
+	host.points["parent"] = data.Points{{Time: time.Now(), Type: "limit", Value: 50}}
+
+	src := `
+subscribe("other")
+set_timer(0.5)
+state["n"] = 0
+
+def on_point(p):
+    if p.type != "value":
+        return
+    print("value", p.value, "from", p.node)
+    set("alarm", p.value > get("limit"))
+    set("status", "high" if p.value > get("limit") else "ok", node="other")
+
+def on_timer():
+    state["n"] += 1
+    set("ticks", state["n"])
+`
+
+	sr, err := newScriptRunner("test", "parent", src, host)
+	if err != nil {
+		t.Fatal("Error loading script: ", err)
+	}
+
+	if len(host.subs) != 1 || host.subs[0] != "other" {
+		t.Error("script did not subscribe: ", host.subs)
+	}
+
+	if host.period != 500*time.Millisecond {
+		t.Error("timer not set: ", host.period)
+	}
+
+	err = sr.point("parent", data.Point{Type: data.PointTypeValue, Value: 60})
+	if err != nil {
+		t.Fatal("point error: ", err)
+	}
+
+	parent := host.points["parent"]
+	other := host.points["other"]
+
+	if v, _ := parent.Value("alarm", ""); v != 1 {
+		t.Error("alarm should be set")
+	}
+
+	if s, _ := other.Text("status", ""); s != "high" {
+		t.Error("status should be high, got: ", s)
+	}
+
+	if len(host.logs) != 1 || host.logs[0] != "value 60.0 from parent" {
+		t.Error("print not logged: ", host.logs)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := sr.timer(); err != nil {
+			t.Fatal("timer error: ", err)
+		}
+	}
+
+	parent = host.points["parent"]
+	if v, _ := parent.Value("ticks", ""); v != 2 {
+		t.Error("expected 2 ticks, got: ", v)
+	}
+}
+
+func TestScriptRunnerErrors(t *testing.T) {
+	_, err := newScriptRunner("test", "parent", "def f(:", newScriptTestHost())
+	if err == nil {
+		t.Error("expected syntax error")
+	}
+
+	defer func(s uint64) { scriptMaxSteps = s }(scriptMaxSteps)
+	scriptMaxSteps = 10000
+
+	src := `
+def on_timer():
+    for i in range(1000000):
+        pass
+`
+
+	sr, err := newScriptRunner("test", "parent", src, newScriptTestHost())
+	if err != nil {
+		t.Fatal("Error loading script: ", err)
+	}
+
+	err = sr.timer()
+	if err == nil || !strings.Contains(err.Error(), "too many steps") {
+		t.Error("expected step limit error, got: ", err)
+	}
+}
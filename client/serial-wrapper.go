@@ -50,16 +50,24 @@ func SerialEncode(seq byte, subject string, points data.Points) ([]byte, error)
 	return ret.Bytes(), nil
 }
 
-// SerialDecode can be used to decode serial data in a client.
+// ErrDecodeCRC is returned by SerialDecode if the packet CRC is not valid.
+var ErrDecodeCRC = errors.New("CRC check failed")
+
+// SerialDecode can be used to decode serial data in a client. Decode errors
+// wrap ErrDecodeCRC or one of the data.ErrDecode* errors.
 func SerialDecode(d []byte) (byte, string, data.Points, error) {
 	l := len(d)
 
 	if l < 1 {
-		return 0, "", nil, errors.New("Not enough data")
+		return 0, "", nil, fmt.Errorf("Not enough data: %w", data.ErrDecodeMalformed)
 	}
 
 	if l < 3 {
-		return d[0], "", nil, errors.New("Not enough data")
+		return d[0], "", nil, fmt.Errorf("Not enough data: %w", data.ErrDecodeMalformed)
+	}
+
+	if l > data.MaxDecodeSize {
+		return d[0], "", nil, fmt.Errorf("Serial packet: %w", data.ErrDecodeTooLarge)
 	}
 
 	// check CRC
@@ -67,7 +75,7 @@ func SerialDecode(d []byte) (byte, string, data.Points, error) {
 	crc := binary.LittleEndian.Uint16(d[l-2:])
 	crcCalc := crc16.ChecksumCCITT(d[:l-2])
 	if crc != crcCalc {
-		return d[0], "", nil, errors.New("CRC check failed")
+		return d[0], "", nil, ErrDecodeCRC
 	}
 
 	if l == 3 {
@@ -77,11 +85,16 @@ func SerialDecode(d []byte) (byte, string, data.Points, error) {
 	// try to extract protobuf
 	pbData := d[1 : l-2]
 
+	err := data.PbCheckPoints(pbData, 2)
+	if err != nil {
+		return d[0], "", nil, fmt.Errorf("PB decode error: %w", err)
+	}
+
 	pbSerial := &pb.Serial{}
 
-	err := proto.Unmarshal(pbData, pbSerial)
+	err = proto.Unmarshal(pbData, pbSerial)
 	if err != nil {
-		return d[0], "", nil, fmt.Errorf("PB decode error: %v", err)
+		return d[0], "", nil, fmt.Errorf("PB decode error: %v: %w", err, data.ErrDecodeMalformed)
 	}
 
 	points := make([]data.Point, len(pbSerial.Points))
@@ -89,7 +102,7 @@ func SerialDecode(d []byte) (byte, string, data.Points, error) {
 	for i, sPb := range pbSerial.Points {
 		s, err := data.PbToPoint(sPb)
 		if err != nil {
-			return d[0], "", nil, fmt.Errorf("Point decode error: %v", err)
+			return d[0], "", nil, fmt.Errorf("Point decode error: %w", err)
 		}
 		points[i] = s
 	}
@@ -1,9 +1,11 @@
 package client
 
 import (
+	"errors"
 	"fmt"
 	"testing"
 
+	"github.com/nats-io/nats.go"
 	"github.com/simpleiot/simpleiot/data"
 )
 
@@ -65,3 +67,67 @@ func TestSerialEncodeDecodeNoContent(t *testing.T) {
 		t.Error("sequence mismatch")
 	}
 }
+
+func FuzzSerialDecode(f *testing.F) {
+	seed, err := SerialEncode(1, "test", data.Points{
+		{Type: data.PointTypeValue, Value: 23.5},
+	})
+	if err != nil {
+		f.Fatal(err)
+	}
+
+	f.Add(seed)
+	f.Add([]byte{})
+	f.Add([]byte{1, 0, 0})
+
+	f.Fuzz(func(t *testing.T, d []byte) {
+		_, _, pts, err := SerialDecode(d)
+		if err != nil {
+			if !errors.Is(err, ErrDecodeCRC) &&
+				!errors.Is(err, data.ErrDecodeTooLarge) &&
+				!errors.Is(err, data.ErrDecodeTooManyPoints) &&
+				!errors.Is(err, data.ErrDecodeMalformed) {
+				t.Fatal("Unclassified decode error: ", err)
+			}
+			return
+		}
+
+		if len(pts) > data.MaxDecodePoints {
+			t.Fatal("Decoded too many points: ", len(pts))
+		}
+	})
+}
+
+func FuzzDecodePointsMsg(f *testing.F) {
+	pts := data.Points{{Type: data.PointTypeValue, Value: 1}}
+	seed, err := pts.ToPb()
+	if err != nil {
+		f.Fatal(err)
+	}
+
+	f.Add("node.123.points", seed)
+	f.Add("node.123.456.points", seed)
+	f.Add("node..points", []byte{})
+
+	f.Fuzz(func(t *testing.T, subject string, d []byte) {
+		msg := &nats.Msg{Subject: subject, Data: d}
+
+		check := func(err error) {
+			if err == nil {
+				return
+			}
+			if !errors.Is(err, ErrDecodeSubject) &&
+				!errors.Is(err, data.ErrDecodeTooLarge) &&
+				!errors.Is(err, data.ErrDecodeTooManyPoints) &&
+				!errors.Is(err, data.ErrDecodeMalformed) {
+				t.Fatal("Unclassified decode error: ", err)
+			}
+		}
+
+		_, _, err := DecodeNodePointsMsg(msg)
+		check(err)
+
+		_, _, _, err = DecodeEdgePointsMsg(msg)
+		check(err)
+	})
+}
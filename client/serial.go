@@ -45,6 +45,10 @@ type SerialDevClient struct {
 	natsSub       string
 	natsSubHR     string
 	natsSubHRUp   string
+	// last points received from the MCU, and the quality they were last
+	// sent with, blank if good
+	mcuPoints data.Points
+	quality   string
 }
 
 // NewSerialDevClient ...
@@ -191,6 +195,12 @@ func (sd *SerialDevClient) Start() error {
 			// figure out if the data is ascii string or points
 			// try pb decode
 			seq, subject, points, err := SerialDecode(rd)
+			if err == nil && len(points) == 0 {
+				// ack from the MCU, packets sent to the MCU are not
+				// retried yet
+				break
+			}
+
 			hrData := false
 			var lrpoints data.Points
 
@@ -227,10 +237,40 @@ func (sd *SerialDevClient) Start() error {
 							log.Println("Error writing response to port: ", err)
 						}
 					}
+
+					// the MCU requests the current time by sending a
+					// currentTime point, which is not stored
+					timeRequest := false
+					lrpoints = data.Points{}
+					for _, p := range points {
+						if p.Type == data.PointTypeCurrentTime {
+							timeRequest = true
+							continue
+						}
+						lrpoints = append(lrpoints, p)
+					}
+					points = lrpoints
+
+					if timeRequest {
+						sd.wrSeq++
+						d, err := SerialEncode(sd.wrSeq, "", data.Points{
+							{Time: time.Now(), Type: data.PointTypeCurrentTime}})
+						if err != nil {
+							log.Println("Error encoding current time: ", err)
+						} else {
+							_, err := port.Write(d)
+							if err != nil {
+								log.Println("Error writing current time to port: ", err)
+							}
+						}
+					}
+
 					err = data.MergePoints(sd.config.ID, points, &sd.config)
 					if err != nil {
 						log.Println("error merging new points: ", err)
 					}
+
+					lrpoints = append(lrpoints, sd.clearStale(points)...)
 				}
 
 			} else {
@@ -256,6 +296,7 @@ func (sd *SerialDevClient) Start() error {
 					sd.config.ErrorCount++
 					lrpoints = append(lrpoints,
 						data.Point{Type: data.PointTypeErrorCount, Value: float64(sd.config.ErrorCount)})
+					lrpoints = append(lrpoints, sd.markStale()...)
 				}
 			}
 
@@ -415,6 +456,51 @@ func (sd *SerialDevClient) Start() error {
 	}
 }
 
+// markStale returns the last points received from the MCU flagged as stale
+// after a packet fails to decode, so that rules and history know the values
+// are no longer current. The flag is cleared by the next good packet.
+func (sd *SerialDevClient) markStale() data.Points {
+	if sd.quality == data.PointQualityStale {
+		return nil
+	}
+
+	var ret data.Points
+	for _, p := range sd.mcuPoints {
+		p.Time = time.Now()
+		p.Quality = data.PointQualityStale
+		ret = append(ret, p)
+	}
+
+	sd.quality = data.PointQualityStale
+	return ret
+}
+
+// clearStale records points received in a good packet and, if the MCU
+// points were flagged as stale, returns the ones not in the packet with the
+// flag cleared
+func (sd *SerialDevClient) clearStale(points data.Points) data.Points {
+	for _, p := range points {
+		sd.mcuPoints.Add(p)
+	}
+
+	if sd.quality == "" {
+		return nil
+	}
+
+	var ret data.Points
+	for _, p := range sd.mcuPoints {
+		if _, ok := points.Find(p.Type, p.Key); ok {
+			continue
+		}
+		p.Time = time.Now()
+		p.Quality = ""
+		ret = append(ret, p)
+	}
+
+	sd.quality = ""
+	return ret
+}
+
 // Stop sends a signal to the Start function to exit
 func (sd *SerialDevClient) Stop(err error) {
 	close(sd.stop)
@@ -185,4 +185,124 @@ func TestSerial(t *testing.T) {
 	if pointsR[0].Value != pumpSetting.Value {
 		t.Error("Error in pump setting received by MCU")
 	}
+
+	// ack the pump setting, acks are not counted as errors
+	ackPacket, err := client.SerialEncode(seqR, "", nil)
+	if err != nil {
+		t.Fatal("Error encoding ack: ", err)
+	}
+
+	_, err = fifoW.Write(ackPacket)
+	if err != nil {
+		t.Fatal("Error writing ack to fifo: ", err)
+	}
+
+	// request the current time
+	seq++
+	timePacket, err := client.SerialEncode(seq, "", data.Points{
+		{Type: data.PointTypeCurrentTime, Time: time.Unix(0, 0)}})
+	if err != nil {
+		t.Fatal("Error encoding time request: ", err)
+	}
+
+	_, err = fifoW.Write(timePacket)
+	if err != nil {
+		t.Fatal("Error writing time request to fifo: ", err)
+	}
+
+	// the time request is acked, and then the time is sent
+	var timePoints data.Points
+	for i := 0; i < 2; i++ {
+		go mcuReadSerial()
+
+		select {
+		case <-time.After(time.Second):
+			t.Fatal("Timeout waiting for time response")
+		case readData = <-readCh:
+		}
+
+		seqR, _, pointsR, err = client.SerialDecode(readData)
+		if err != nil {
+			t.Fatal("Error in time response: ", err)
+		}
+
+		if len(pointsR) == 0 {
+			if seqR != seq {
+				t.Error("Sequence in time request ack did not match")
+			}
+			continue
+		}
+
+		timePoints = pointsR
+	}
+
+	if len(timePoints) != 1 || timePoints[0].Type != data.PointTypeCurrentTime {
+		t.Fatal("Did not receive current time: ", timePoints)
+	}
+
+	if time.Since(timePoints[0].Time) > time.Second*5 {
+		t.Error("Current time is not correct: ", timePoints[0].Time)
+	}
+
+	if getNode().ErrorCount != 0 {
+		t.Error("Serial errors reported: ", getNode().ErrorCount)
+	}
+
+	uptimeQuality := func() string {
+		nodes, err := client.GetNode(nc, serialTest.ID, serialTest.Parent)
+		if err != nil || len(nodes) < 1 {
+			t.Fatal("Error getting serial node: ", err)
+		}
+		p, _ := nodes[0].Points.Find(data.PointTypeUptime, "")
+		return p.Quality
+	}
+
+	// a packet with a bad CRC marks the MCU points stale
+	badPacket := append([]byte{}, uptimePacket...)
+	badPacket[len(badPacket)-1] ^= 0xff
+
+	_, err = fifoW.Write(badPacket)
+	if err != nil {
+		t.Fatal("Error writing bad packet to fifo: ", err)
+	}
+
+	start = time.Now()
+	for {
+		if getNode().ErrorCount == 1 && uptimeQuality() == data.PointQualityStale {
+			break
+		}
+		if time.Since(start) > time.Second {
+			t.Fatal("Timeout waiting for stale uptime, quality: ", uptimeQuality())
+		}
+		<-time.After(time.Millisecond * 100)
+	}
+
+	// the next good packet clears the stale flag, even on points it does
+	// not contain
+	seq++
+	goodPacket, err := client.SerialEncode(seq, "", data.Points{
+		{Type: data.PointTypeTemperature, Value: 22}})
+	if err != nil {
+		t.Fatal("Error encoding serial packet: ", err)
+	}
+
+	_, err = fifoW.Write(goodPacket)
+	if err != nil {
+		t.Fatal("Error writing good packet to fifo: ", err)
+	}
+
+	start = time.Now()
+	for {
+		if uptimeQuality() == "" {
+			break
+		}
+		if time.Since(start) > time.Second {
+			t.Fatal("Timeout waiting for uptime quality to clear")
+		}
+		<-time.After(time.Millisecond * 100)
+	}
+
+	if getNode().Uptime != uptimeTest {
+		t.Error("Uptime changed: ", getNode().Uptime)
+	}
 }
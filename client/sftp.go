@@ -0,0 +1,431 @@
+package client
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// sftp packet types (SFTP version 3)
+const (
+	sftpInit     = 1
+	sftpVersion  = 2
+	sftpOpen     = 3
+	sftpClose    = 4
+	sftpWrite    = 6
+	sftpOpenDir  = 11
+	sftpReadDir  = 12
+	sftpRemove   = 13
+	sftpRename   = 18
+	sftpStatus   = 101
+	sftpHandle   = 102
+	sftpName     = 104
+	sftpStatusOK = 0
+	sftpEOF      = 1
+
+	sftpFlagWrite  = 0x02
+	sftpFlagCreate = 0x08
+	sftpFlagTrunc  = 0x10
+
+	// sftpMaxWrite is the max data in a write request, which all servers
+	// must support
+	sftpMaxWrite = 32768
+)
+
+// sftpClient is a minimal SFTP client that can upload, list, rename, and
+// remove files. Requests are sent one at a time.
+type sftpClient struct {
+	conn  *ssh.Client
+	sess  *ssh.Session
+	w     io.WriteCloser
+	r     *bufio.Reader
+	reqID uint32
+}
+
+// sftpHostKey returns the host key callback for a host key in authorized
+// keys format (as in ~/.ssh/known_hosts without the host name). If key is
+// empty, the host key is not checked.
+func sftpHostKey(key string) (ssh.HostKeyCallback, error) {
+	if key == "" {
+		return ssh.InsecureIgnoreHostKey(), nil
+	}
+
+	pub, _, _, _, err := ssh.ParseAuthorizedKey([]byte(key))
+	if err != nil {
+		return nil, fmt.Errorf("Error parsing host key: %w", err)
+	}
+
+	return ssh.FixedHostKey(pub), nil
+}
+
+// newSftpClient connects to an SFTP server. addr is host[:port], the
+// default port is 22.
+func newSftpClient(addr, user, password, hostKey string) (*sftpClient, error) {
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, "22")
+	}
+
+	hkc, err := sftpHostKey(hostKey)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := ssh.Dial("tcp", addr, &ssh.ClientConfig{
+		User:            user,
+		Auth:            []ssh.AuthMethod{ssh.Password(password)},
+		HostKeyCallback: hkc,
+		Timeout:         20 * time.Second,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := newSftpSession(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func newSftpSession(conn *ssh.Client) (*sftpClient, error) {
+	sess, err := conn.NewSession()
+	if err != nil {
+		return nil, err
+	}
+
+	w, err := sess.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := sess.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	err = sess.RequestSubsystem("sftp")
+	if err != nil {
+		return nil, err
+	}
+
+	c := &sftpClient{conn: conn, sess: sess, w: w, r: bufio.NewReader(r)}
+
+	var init sftpPacket
+	init.uint32(3)
+	err = c.send(sftpInit, init)
+	if err != nil {
+		return nil, err
+	}
+
+	typ, _, err := c.recv()
+	if err != nil {
+		return nil, err
+	}
+
+	if typ != sftpVersion {
+		return nil, fmt.Errorf("unexpected SFTP init response: %v", typ)
+	}
+
+	return c, nil
+}
+
+// sftpPacket builds the payload of a request
+type sftpPacket []byte
+
+func (p *sftpPacket) uint32(v uint32) {
+	*p = append(*p, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+func (p *sftpPacket) uint64(v uint64) {
+	p.uint32(uint32(v >> 32))
+	p.uint32(uint32(v))
+}
+
+func (p *sftpPacket) string(s []byte) {
+	p.uint32(uint32(len(s)))
+	*p = append(*p, s...)
+}
+
+// sftpReader decodes the payload of a response
+type sftpReader struct {
+	b   []byte
+	err error
+}
+
+func (r *sftpReader) uint32() uint32 {
+	if len(r.b) < 4 {
+		r.err = errors.New("SFTP response too short")
+		return 0
+	}
+	v := binary.BigEndian.Uint32(r.b)
+	r.b = r.b[4:]
+	return v
+}
+
+func (r *sftpReader) uint64() uint64 {
+	return uint64(r.uint32())<<32 | uint64(r.uint32())
+}
+
+func (r *sftpReader) string() []byte {
+	n := r.uint32()
+	if uint32(len(r.b)) < n {
+		r.err = errors.New("SFTP response too short")
+		return nil
+	}
+	v := r.b[:n]
+	r.b = r.b[n:]
+	return v
+}
+
+// attrs skips file attributes
+func (r *sftpReader) attrs() {
+	flags := r.uint32()
+	if flags&0x1 != 0 {
+		r.uint64()
+	}
+	if flags&0x2 != 0 {
+		r.uint32()
+		r.uint32()
+	}
+	if flags&0x4 != 0 {
+		r.uint32()
+	}
+	if flags&0x8 != 0 {
+		r.uint32()
+		r.uint32()
+	}
+	if flags&0x80000000 != 0 {
+		n := r.uint32()
+		for i := uint32(0); i < n && r.err == nil; i++ {
+			r.string()
+			r.string()
+		}
+	}
+}
+
+func (c *sftpClient) send(typ byte, payload []byte) error {
+	hdr := make([]byte, 5)
+	binary.BigEndian.PutUint32(hdr, uint32(len(payload)+1))
+	hdr[4] = typ
+
+	_, err := c.w.Write(append(hdr, payload...))
+	return err
+}
+
+func (c *sftpClient) recv() (byte, *sftpReader, error) {
+	hdr := make([]byte, 5)
+	_, err := io.ReadFull(c.r, hdr)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	n := binary.BigEndian.Uint32(hdr)
+	if n < 1 || n > 1<<20 {
+		return 0, nil, fmt.Errorf("invalid SFTP packet length: %v", n)
+	}
+
+	payload := make([]byte, n-1)
+	_, err = io.ReadFull(c.r, payload)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return hdr[4], &sftpReader{b: payload}, nil
+}
+
+// request sends a request and returns the response. Status responses other
+// than OK are returned as errors, with eof set for the EOF status.
+func (c *sftpClient) request(typ byte, payload sftpPacket) (byte, *sftpReader, bool, error) {
+	c.reqID++
+
+	p := make(sftpPacket, 0, len(payload)+4)
+	p.uint32(c.reqID)
+	p = append(p, payload...)
+
+	err := c.send(typ, p)
+	if err != nil {
+		return 0, nil, false, err
+	}
+
+	rtyp, r, err := c.recv()
+	if err != nil {
+		return 0, nil, false, err
+	}
+
+	if id := r.uint32(); id != c.reqID {
+		return 0, nil, false, fmt.Errorf("unexpected SFTP response id: %v", id)
+	}
+
+	if rtyp == sftpStatus {
+		code := r.uint32()
+		msg := r.string()
+		switch code {
+		case sftpStatusOK:
+			return rtyp, r, false, nil
+		case sftpEOF:
+			return rtyp, r, true, nil
+		default:
+			return rtyp, r, false, fmt.Errorf("SFTP error %v: %s", code, msg)
+		}
+	}
+
+	return rtyp, r, false, r.err
+}
+
+func (c *sftpClient) handle(typ byte, payload sftpPacket) ([]byte, error) {
+	rtyp, r, _, err := c.request(typ, payload)
+	if err != nil {
+		return nil, err
+	}
+
+	if rtyp != sftpHandle {
+		return nil, fmt.Errorf("unexpected SFTP response: %v", rtyp)
+	}
+
+	h := r.string()
+	return h, r.err
+}
+
+func (c *sftpClient) closeHandle(h []byte) error {
+	var p sftpPacket
+	p.string(h)
+	_, _, _, err := c.request(sftpClose, p)
+	return err
+}
+
+// put writes a file, replacing it if it exists
+func (c *sftpClient) put(path string, r io.Reader) error {
+	var p sftpPacket
+	p.string([]byte(path))
+	p.uint32(sftpFlagWrite | sftpFlagCreate | sftpFlagTrunc)
+	// no attributes
+	p.uint32(0)
+
+	h, err := c.handle(sftpOpen, p)
+	if err != nil {
+		return err
+	}
+
+	buf := make([]byte, sftpMaxWrite)
+	var offset uint64
+
+	for {
+		n, rerr := io.ReadFull(r, buf)
+		if n > 0 {
+			var w sftpPacket
+			w.string(h)
+			w.uint64(offset)
+			w.string(buf[:n])
+
+			_, _, _, err := c.request(sftpWrite, w)
+			if err != nil {
+				c.closeHandle(h)
+				return err
+			}
+
+			offset += uint64(n)
+		}
+
+		if rerr == io.EOF || rerr == io.ErrUnexpectedEOF {
+			break
+		}
+
+		if rerr != nil {
+			c.closeHandle(h)
+			return rerr
+		}
+	}
+
+	return c.closeHandle(h)
+}
+
+// list returns the names of the entries in a directory
+func (c *sftpClient) list(dir string) ([]string, error) {
+	var p sftpPacket
+	p.string([]byte(dir))
+
+	h, err := c.handle(sftpOpenDir, p)
+	if err != nil {
+		return nil, err
+	}
+
+	var ret []string
+
+	for {
+		var rp sftpPacket
+		rp.string(h)
+
+		typ, r, eof, err := c.request(sftpReadDir, rp)
+		if err != nil {
+			c.closeHandle(h)
+			return nil, err
+		}
+
+		if eof {
+			break
+		}
+
+		if typ != sftpName {
+			c.closeHandle(h)
+			return nil, fmt.Errorf("unexpected SFTP response: %v", typ)
+		}
+
+		count := r.uint32()
+		for i := uint32(0); i < count && r.err == nil; i++ {
+			name := string(r.string())
+			// long name
+			r.string()
+			r.attrs()
+			if name != "." && name != ".." {
+				ret = append(ret, name)
+			}
+		}
+
+		if r.err != nil {
+			c.closeHandle(h)
+			return nil, r.err
+		}
+	}
+
+	return ret, c.closeHandle(h)
+}
+
+// rename renames a file. Many servers fail if the new file exists.
+func (c *sftpClient) rename(from, to string) error {
+	var p sftpPacket
+	p.string([]byte(from))
+	p.string([]byte(to))
+	_, _, _, err := c.request(sftpRename, p)
+	return err
+}
+
+// remove removes a file
+func (c *sftpClient) remove(path string) error {
+	var p sftpPacket
+	p.string([]byte(path))
+	_, _, _, err := c.request(sftpRemove, p)
+	return err
+}
+
+// sftpJoin joins remote path elements. Remote paths always use /.
+func sftpJoin(dir, name string) string {
+	if dir == "" {
+		return name
+	}
+	return strings.TrimSuffix(dir, "/") + "/" + name
+}
+
+func (c *sftpClient) close() error {
+	c.w.Close()
+	c.sess.Close()
+	return c.conn.Close()
+}
@@ -0,0 +1,277 @@
+package client
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/simpleiot/simpleiot/data"
+)
+
+// Simulator config. A simulator node generates a low rate signal on a
+// configurable point type and can be used for demos, UI development, and
+// testing rules without hardware.
+type Simulator struct {
+	ID           string  `node:"id"`
+	Parent       string  `node:"parent"`
+	Description  string  `point:"description"`
+	SimType      string  `point:"simType"`
+	PointType    string  `point:"pointType"`
+	SamplePeriod float64 `point:"samplePeriod"`
+	Frequency    float64 `point:"frequency"`
+	Amplitude    float64 `point:"amplitude"`
+	Offset       float64 `point:"offset"`
+	StepSize     float64 `point:"stepSize"`
+	FilePath     string  `point:"filePath"`
+	Value        float64 `point:"value"`
+	Units        string  `point:"units"`
+	Disable      bool    `point:"disable"`
+}
+
+// SimulatorClient for simulator nodes
+type SimulatorClient struct {
+	nc            *nats.Conn
+	config        Simulator
+	stop          chan struct{}
+	newPoints     chan NewPoints
+	newEdgePoints chan NewPoints
+}
+
+// NewSimulatorClient ...
+func NewSimulatorClient(nc *nats.Conn, config Simulator) Client {
+	return &SimulatorClient{
+		nc:            nc,
+		config:        config,
+		stop:          make(chan struct{}),
+		newPoints:     make(chan NewPoints),
+		newEdgePoints: make(chan NewPoints),
+	}
+}
+
+// simGen returns the value of a simulated signal for a point in time
+type simGen func(t time.Time) float64
+
+// newSimGen creates a signal generator function for a simulator config.
+// start is the time the signal starts, which is used for periodic signals.
+func newSimGen(config Simulator, start time.Time) (simGen, error) {
+	switch config.SimType {
+	case data.PointValueSine, "":
+		if config.Frequency <= 0 {
+			return nil, errors.New("Frequency must be set")
+		}
+		return func(t time.Time) float64 {
+			x := 2 * math.Pi * config.Frequency * t.Sub(start).Seconds()
+			return config.Offset + config.Amplitude*math.Sin(x)
+		}, nil
+
+	case data.PointValueSquare:
+		if config.Frequency <= 0 {
+			return nil, errors.New("Frequency must be set")
+		}
+		return func(t time.Time) float64 {
+			phase := math.Mod(config.Frequency*t.Sub(start).Seconds(), 1)
+			if phase < 0.5 {
+				return config.Offset + config.Amplitude
+			}
+			return config.Offset - config.Amplitude
+		}, nil
+
+	case data.PointValueRandomWalk:
+		if config.StepSize <= 0 {
+			return nil, errors.New("StepSize must be set")
+		}
+		min := config.Offset - math.Abs(config.Amplitude)
+		max := config.Offset + math.Abs(config.Amplitude)
+		value := config.Offset
+		return func(_ time.Time) float64 {
+			value += (rand.Float64()*2 - 1) * config.StepSize
+			if value > max {
+				value = max
+			}
+			if value < min {
+				value = min
+			}
+			return value
+		}, nil
+
+	case data.PointValueCSV:
+		values, err := readSimCSV(config.FilePath)
+		if err != nil {
+			return nil, err
+		}
+		i := 0
+		return func(_ time.Time) float64 {
+			v := values[i]
+			i++
+			if i >= len(values) {
+				i = 0
+			}
+			return v
+		}, nil
+
+	default:
+		return nil, fmt.Errorf("Unknown simulator type: %v", config.SimType)
+	}
+}
+
+// readSimCSV reads values from a CSV file. The last column of each record
+// is used as the value. Records that do not parse as a number (such as a
+// header line) are skipped.
+func readSimCSV(path string) ([]float64, error) {
+	if path == "" {
+		return nil, errors.New("FilePath must be set")
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("Error opening CSV file: %v", err)
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1
+	r.TrimLeadingSpace = true
+
+	var ret []float64
+
+	for {
+		rec, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Error reading CSV file: %v", err)
+		}
+
+		if len(rec) < 1 {
+			continue
+		}
+
+		v, err := strconv.ParseFloat(strings.TrimSpace(rec[len(rec)-1]), 64)
+		if err != nil {
+			continue
+		}
+
+		ret = append(ret, v)
+	}
+
+	if len(ret) < 1 {
+		return nil, errors.New("CSV file does not contain any values")
+	}
+
+	return ret, nil
+}
+
+// Start runs the main logic for this client and blocks until stopped
+func (sc *SimulatorClient) Start() error {
+	log.Println("Starting simulator client: ", sc.config.Description)
+
+	t := time.NewTicker(time.Hour)
+	t.Stop()
+
+	var gen simGen
+
+	setup := func() {
+		t.Stop()
+		gen = nil
+
+		if sc.config.Disable {
+			log.Printf("Simulator %v: disabled\n", sc.config.Description)
+			return
+		}
+
+		if sc.config.SamplePeriod <= 0 {
+			log.Printf("Simulator %v: SamplePeriod must be set\n", sc.config.Description)
+			return
+		}
+
+		var err error
+		gen, err = newSimGen(sc.config, time.Now())
+		if err != nil {
+			log.Printf("Simulator %v: %v\n", sc.config.Description, err)
+			return
+		}
+
+		t.Reset(time.Duration(sc.config.SamplePeriod * float64(time.Second)))
+	}
+
+	setup()
+
+done:
+	for {
+		select {
+		case <-sc.stop:
+			log.Println("Stopping simulator client: ", sc.config.Description)
+			break done
+		case sTime := <-t.C:
+			if gen == nil {
+				continue
+			}
+
+			pointType := sc.config.PointType
+			if pointType == "" {
+				pointType = data.PointTypeValue
+			}
+
+			err := SendNodePoint(sc.nc, sc.config.ID, data.Point{
+				Time:  sTime,
+				Type:  pointType,
+				Value: gen(sTime),
+			}, false)
+			if err != nil {
+				log.Println("Simulator error sending point: ", err)
+			}
+		case pts := <-sc.newPoints:
+			err := data.MergePoints(pts.ID, pts.Points, &sc.config)
+			if err != nil {
+				log.Println("error merging new points: ", err)
+			}
+
+			for _, p := range pts.Points {
+				switch p.Type {
+				case data.PointTypeSimType, data.PointTypeSamplePeriod,
+					data.PointTypeFrequency, data.PointTypeAmplitude,
+					data.PointTypeOffset, data.PointTypeStepSize,
+					data.PointTypeFilePath, data.PointTypeDisable:
+					setup()
+				}
+			}
+
+		case pts := <-sc.newEdgePoints:
+			err := data.MergeEdgePoints(pts.ID, pts.Parent, pts.Points, &sc.config)
+			if err != nil {
+				log.Println("error merging new points: ", err)
+			}
+		}
+	}
+
+	// clean up
+	t.Stop()
+	return nil
+}
+
+// Stop sends a signal to the Start function to exit
+func (sc *SimulatorClient) Stop(err error) {
+	close(sc.stop)
+}
+
+// Points is called by the Manager when new points for this
+// node are received.
+func (sc *SimulatorClient) Points(nodeID string, points []data.Point) {
+	sc.newPoints <- NewPoints{nodeID, "", points}
+}
+
+// EdgePoints is called by the Manager when new edge points for this
+// node are received.
+func (sc *SimulatorClient) EdgePoints(nodeID, parentID string, points []data.Point) {
+	sc.newEdgePoints <- NewPoints{nodeID, parentID, points}
+}
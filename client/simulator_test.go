@@ -0,0 +1,98 @@
+package client
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/simpleiot/simpleiot/data"
+)
+
+func TestSimGenSine(t *testing.T) {
+	start := time.Now()
+	gen, err := newSimGen(Simulator{SimType: data.PointValueSine,
+		Frequency: 1, Amplitude: 2, Offset: 10}, start)
+	if err != nil {
+		t.Fatal("Error creating generator: ", err)
+	}
+
+	if v := gen(start); math.Abs(v-10) > 0.001 {
+		t.Error("Expected 10 at start, got: ", v)
+	}
+
+	if v := gen(start.Add(250 * time.Millisecond)); math.Abs(v-12) > 0.001 {
+		t.Error("Expected 12 at 1/4 period, got: ", v)
+	}
+}
+
+func TestSimGenSquare(t *testing.T) {
+	start := time.Now()
+	gen, err := newSimGen(Simulator{SimType: data.PointValueSquare,
+		Frequency: 1, Amplitude: 1}, start)
+	if err != nil {
+		t.Fatal("Error creating generator: ", err)
+	}
+
+	if v := gen(start.Add(100 * time.Millisecond)); v != 1 {
+		t.Error("Expected 1 in first half of period, got: ", v)
+	}
+
+	if v := gen(start.Add(600 * time.Millisecond)); v != -1 {
+		t.Error("Expected -1 in second half of period, got: ", v)
+	}
+}
+
+func TestSimGenRandomWalk(t *testing.T) {
+	gen, err := newSimGen(Simulator{SimType: data.PointValueRandomWalk,
+		Amplitude: 5, Offset: 20, StepSize: 2}, time.Now())
+	if err != nil {
+		t.Fatal("Error creating generator: ", err)
+	}
+
+	last := 20.0
+	for i := 0; i < 1000; i++ {
+		v := gen(time.Now())
+		if v < 15 || v > 25 {
+			t.Fatal("random walk value out of bounds: ", v)
+		}
+		if math.Abs(v-last) > 2 {
+			t.Fatal("random walk step too large: ", v-last)
+		}
+		last = v
+	}
+}
+
+func TestSimGenCSV(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sim.csv")
+	err := os.WriteFile(path, []byte("time,value\n0,1.5\n1,2.5\n2,3.5\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gen, err := newSimGen(Simulator{SimType: data.PointValueCSV,
+		FilePath: path}, time.Now())
+	if err != nil {
+		t.Fatal("Error creating generator: ", err)
+	}
+
+	exp := []float64{1.5, 2.5, 3.5, 1.5}
+	for i, e := range exp {
+		if v := gen(time.Now()); v != e {
+			t.Errorf("sample %v: expected %v, got %v", i, e, v)
+		}
+	}
+}
+
+func TestSimGenInvalid(t *testing.T) {
+	_, err := newSimGen(Simulator{SimType: data.PointValueSine}, time.Now())
+	if err == nil {
+		t.Error("Expected error for sine without frequency")
+	}
+
+	_, err = newSimGen(Simulator{SimType: "bogus"}, time.Now())
+	if err == nil {
+		t.Error("Expected error for unknown type")
+	}
+}
@@ -0,0 +1,417 @@
+package client
+
+import (
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/simpleiot/simpleiot/data"
+)
+
+// StateMachine config. A state machine node has states, transitions, and
+// actions as child nodes. The current state is stored in the state point.
+// Transitions from the current state are checked when a point used in a
+// transition condition changes and every second for transitions with a
+// minimum time in state. When a transition fires, the exit actions of the
+// old state run, the state point is updated, and then the entry actions
+// of the new state run.
+type StateMachine struct {
+	ID          string         `node:"id"`
+	Parent      string         `node:"parent"`
+	Description string         `point:"description"`
+	Disable     bool           `point:"disable"`
+	State       string         `point:"state"`
+	States      []SMState      `child:"smState"`
+	Transitions []SMTransition `child:"smTransition"`
+	Actions     []SMAction     `child:"smAction"`
+}
+
+// SMState is a state machine state. The description is the state name.
+type SMState struct {
+	ID          string `node:"id"`
+	Parent      string `node:"parent"`
+	Description string `point:"description"`
+	Initial     bool   `point:"initial"`
+}
+
+// SMTransition moves a state machine from one state to another when its
+// condition is true. From can be * to match any state. The condition
+// compares a point (default on the state machine parent node) to a value.
+// If minTime is set, the machine must also have been in the from state for
+// at least minTime seconds. A transition without a point and with minTime
+// set is a timer transition. Transitions are checked in priority order,
+// lowest first.
+type SMTransition struct {
+	ID          string  `node:"id"`
+	Parent      string  `node:"parent"`
+	Description string  `point:"description"`
+	From        string  `point:"from"`
+	To          string  `point:"to"`
+	Priority    int     `point:"priority"`
+	NodeID      string  `point:"nodeID"`
+	PointType   string  `point:"pointType"`
+	PointKey    string  `point:"pointKey"`
+	ValueType   string  `point:"valueType"`
+	Operator    string  `point:"operator"`
+	Value       float64 `point:"value"`
+	ValueText   string  `point:"valueText"`
+	MinTime     float64 `point:"minTime"`
+}
+
+// SMAction writes a point when a state is entered (event entry) or exited
+// (event exit). The point is written to the state machine parent node if
+// nodeID is not set.
+type SMAction struct {
+	ID          string  `node:"id"`
+	Parent      string  `node:"parent"`
+	Description string  `point:"description"`
+	State       string  `point:"state"`
+	Event       string  `point:"event"`
+	NodeID      string  `point:"nodeID"`
+	PointType   string  `point:"pointType"`
+	PointKey    string  `point:"pointKey"`
+	ValueType   string  `point:"valueType"`
+	Value       float64 `point:"value"`
+	ValueText   string  `point:"valueText"`
+}
+
+// smPointValues returns a point used in a transition condition
+type smPointValues func(nodeID, typ, key string) (data.Point, bool)
+
+// smConditionTrue checks if a transition condition is true
+func smConditionTrue(t SMTransition, p data.Point) bool {
+	if t.ValueType == data.PointValueText {
+		switch t.Operator {
+		case data.PointValueNotEqual:
+			return p.Text != t.ValueText
+		case data.PointValueContains:
+			return strings.Contains(p.Text, t.ValueText)
+		default:
+			return p.Text == t.ValueText
+		}
+	}
+
+	switch t.Operator {
+	case data.PointValueGreaterThan:
+		return p.Value > t.Value
+	case data.PointValueLessThan:
+		return p.Value < t.Value
+	case data.PointValueNotEqual:
+		return p.Value != t.Value
+	default:
+		return p.Value == t.Value
+	}
+}
+
+// smNextState returns the state to move to from state, or false if no
+// transition is true. inState is the time the machine has been in state.
+func smNextState(config StateMachine, defaultNode string, values smPointValues,
+	state string, inState time.Duration) (SMTransition, bool) {
+	transitions := make([]SMTransition, len(config.Transitions))
+	copy(transitions, config.Transitions)
+	sort.SliceStable(transitions, func(i, j int) bool {
+		return transitions[i].Priority < transitions[j].Priority
+	})
+
+	for _, t := range transitions {
+		if t.From != state && t.From != "*" {
+			continue
+		}
+
+		if t.To == "" || t.To == state {
+			continue
+		}
+
+		if t.MinTime > 0 &&
+			inState < time.Duration(t.MinTime*float64(time.Second)) {
+			continue
+		}
+
+		if t.PointType == "" {
+			if t.MinTime <= 0 {
+				// transition without a condition
+				continue
+			}
+			return t, true
+		}
+
+		nodeID := t.NodeID
+		if nodeID == "" {
+			nodeID = defaultNode
+		}
+
+		p, ok := values(nodeID, t.PointType, t.PointKey)
+		if ok && smConditionTrue(t, p) {
+			return t, true
+		}
+	}
+
+	return SMTransition{}, false
+}
+
+// smInitialState returns the initial state of a state machine
+func smInitialState(config StateMachine) string {
+	for _, s := range config.States {
+		if s.Initial {
+			return s.Description
+		}
+	}
+
+	if len(config.States) > 0 {
+		return config.States[0].Description
+	}
+
+	return ""
+}
+
+// StateMachineClient runs state machine nodes
+type StateMachineClient struct {
+	nc            *nats.Conn
+	config        StateMachine
+	stop          chan struct{}
+	newPoints     chan NewPoints
+	newEdgePoints chan NewPoints
+	watchPoints   chan NewPoints
+	subs          map[string]*nats.Subscription
+	values        map[string]data.Points
+	stateTime     time.Time
+}
+
+// NewStateMachineClient ...
+func NewStateMachineClient(nc *nats.Conn, config StateMachine) Client {
+	return &StateMachineClient{
+		nc:            nc,
+		config:        config,
+		stop:          make(chan struct{}),
+		newPoints:     make(chan NewPoints),
+		newEdgePoints: make(chan NewPoints),
+		watchPoints:   make(chan NewPoints),
+		subs:          make(map[string]*nats.Subscription),
+		values:        make(map[string]data.Points),
+	}
+}
+
+func (smc *StateMachineClient) pointValue(nodeID, typ, key string) (data.Point, bool) {
+	pts := smc.values[nodeID]
+	return pts.Find(typ, key)
+}
+
+// watch subscribes to points of all nodes used in transition conditions
+func (smc *StateMachineClient) watch() {
+	nodes := make(map[string]bool)
+	for _, t := range smc.config.Transitions {
+		if t.PointType == "" {
+			continue
+		}
+		if t.NodeID != "" {
+			nodes[t.NodeID] = true
+		} else {
+			nodes[smc.config.Parent] = true
+		}
+	}
+
+	for id, sub := range smc.subs {
+		if !nodes[id] {
+			sub.Unsubscribe()
+			delete(smc.subs, id)
+			delete(smc.values, id)
+		}
+	}
+
+	for id := range nodes {
+		if _, ok := smc.subs[id]; ok {
+			continue
+		}
+
+		nodeID := id
+		sub, err := smc.nc.Subscribe(SubjectNodePoints(nodeID), func(msg *nats.Msg) {
+			points, err := data.PbDecodePoints(msg.Data)
+			if err != nil {
+				log.Println("State machine error decoding points: ", err)
+				return
+			}
+
+			smc.watchPoints <- NewPoints{nodeID, "", points}
+		})
+
+		if err != nil {
+			log.Printf("State machine %v: error subscribing to node %v: %v\n",
+				smc.config.Description, nodeID, err)
+			continue
+		}
+
+		smc.subs[nodeID] = sub
+
+		ns, err := GetNode(smc.nc, nodeID, "none")
+		if err == nil && len(ns) > 0 {
+			smc.values[nodeID] = ns[0].Points
+		}
+	}
+}
+
+// runActions runs the actions for a state event
+func (smc *StateMachineClient) runActions(state, event string) {
+	for _, a := range smc.config.Actions {
+		if a.State != state || a.Event != event || a.PointType == "" {
+			continue
+		}
+
+		nodeID := a.NodeID
+		if nodeID == "" {
+			nodeID = smc.config.Parent
+		}
+
+		p := data.Point{Time: time.Now(), Type: a.PointType, Key: a.PointKey,
+			Origin: smc.config.ID}
+		if a.ValueType == data.PointValueText {
+			p.Text = a.ValueText
+		} else {
+			p.Value = a.Value
+		}
+
+		err := SendNodePoint(smc.nc, nodeID, p, true)
+		if err != nil {
+			log.Printf("State machine %v: error running action %v: %v\n",
+				smc.config.Description, a.Description, err)
+		}
+	}
+}
+
+// setState moves the state machine to a new state
+func (smc *StateMachineClient) setState(state string) {
+	old := smc.config.State
+	if old != "" {
+		smc.runActions(old, data.PointValueExit)
+	}
+
+	smc.config.State = state
+	smc.stateTime = time.Now()
+
+	err := SendNodePoint(smc.nc, smc.config.ID, data.Point{Time: smc.stateTime,
+		Type: data.PointTypeState, Text: state, Origin: smc.config.ID}, true)
+	if err != nil {
+		log.Printf("State machine %v: error sending state: %v\n",
+			smc.config.Description, err)
+	}
+
+	smc.runActions(state, data.PointValueEntry)
+}
+
+// evaluate checks transitions until the state machine settles. The number
+// of transitions is limited so a loop of always true transitions does not
+// hang the client.
+func (smc *StateMachineClient) evaluate() {
+	if smc.config.Disable {
+		return
+	}
+
+	if smc.config.State == "" {
+		initial := smInitialState(smc.config)
+		if initial == "" {
+			return
+		}
+		smc.setState(initial)
+	}
+
+	for i := 0; i < len(smc.config.Transitions)+1; i++ {
+		t, ok := smNextState(smc.config, smc.config.Parent, smc.pointValue,
+			smc.config.State, time.Since(smc.stateTime))
+		if !ok {
+			return
+		}
+
+		log.Printf("State machine %v: %v -> %v\n", smc.config.Description,
+			smc.config.State, t.To)
+		smc.setState(t.To)
+	}
+}
+
+// Start runs the main logic for this client and blocks until stopped
+func (smc *StateMachineClient) Start() error {
+	log.Println("Starting state machine client: ", smc.config.Description)
+
+	smc.stateTime = time.Now()
+	nodes, err := GetNode(smc.nc, smc.config.ID, "none")
+	if err == nil && len(nodes) > 0 {
+		p, ok := nodes[0].Points.Find(data.PointTypeState, "")
+		if ok && !p.Time.IsZero() {
+			smc.stateTime = p.Time
+		}
+	}
+
+	smc.watch()
+	smc.evaluate()
+
+	t := time.NewTicker(time.Second)
+
+done:
+	for {
+		select {
+		case <-smc.stop:
+			log.Println("Stopping state machine client: ", smc.config.Description)
+			break done
+		case <-t.C:
+			smc.evaluate()
+		case pts := <-smc.watchPoints:
+			values := smc.values[pts.ID]
+			for _, p := range pts.Points {
+				values.Add(p)
+			}
+			smc.values[pts.ID] = values
+			smc.evaluate()
+		case pts := <-smc.newPoints:
+			err := data.MergePoints(pts.ID, pts.Points, &smc.config)
+			if err != nil {
+				log.Println("error merging new points: ", err)
+			}
+
+			for _, p := range pts.Points {
+				switch p.Type {
+				case data.PointTypeNodeID:
+					smc.watch()
+				case data.PointTypePointType:
+					smc.watch()
+				case data.PointTypeState:
+					// state set by the user
+					if pts.ID == smc.config.ID && p.Origin != smc.config.ID {
+						smc.stateTime = time.Now()
+					}
+				}
+			}
+
+			smc.evaluate()
+
+		case pts := <-smc.newEdgePoints:
+			err := data.MergeEdgePoints(pts.ID, pts.Parent, pts.Points, &smc.config)
+			if err != nil {
+				log.Println("error merging new points: ", err)
+			}
+		}
+	}
+
+	// clean up
+	t.Stop()
+	for _, sub := range smc.subs {
+		sub.Unsubscribe()
+	}
+	return nil
+}
+
+// Stop sends a signal to the Start function to exit
+func (smc *StateMachineClient) Stop(err error) {
+	close(smc.stop)
+}
+
+// Points is called by the Manager when new points for this
+// node are received.
+func (smc *StateMachineClient) Points(nodeID string, points []data.Point) {
+	smc.newPoints <- NewPoints{nodeID, "", points}
+}
+
+// EdgePoints is called by the Manager when new edge points for this
+// node are received.
+func (smc *StateMachineClient) EdgePoints(nodeID, parentID string, points []data.Point) {
+	smc.newEdgePoints <- NewPoints{nodeID, parentID, points}
+}
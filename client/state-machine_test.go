@@ -0,0 +1,77 @@
+package client
+
+import (
+	"testing"
+	"time"
+
+	"github.com/simpleiot/simpleiot/data"
+)
+
+func TestStateMachineNextState(t *testing.T) {
+	// pump lead/lag rotation: run pump A or B when there is demand, and
+	// rotate the lead pump after it has run for an hour
+	config := StateMachine{
+		States: []SMState{{Description: "idle"}, {Description: "runA", Initial: true},
+			{Description: "runB"}},
+		Transitions: []SMTransition{
+			{From: "*", To: "idle", PointType: "demand", Operator: "=", Value: 0},
+			{From: "idle", To: "runA", PointType: "demand", Operator: "=", Value: 1,
+				Priority: 1},
+			{From: "runA", To: "runB", MinTime: 3600, Priority: 1},
+			{From: "runB", To: "runA", MinTime: 3600, Priority: 1},
+			{From: "runA", To: "alarm", NodeID: "tank", PointType: "level",
+				Operator: ">", Value: 90, Priority: -1},
+			{From: "runA", To: "runB", PointType: "pumpA", ValueType: data.PointValueText,
+				Operator: data.PointValueContains, ValueText: "fault", Priority: 2},
+		},
+	}
+
+	if s := smInitialState(config); s != "runA" {
+		t.Error("wrong initial state: ", s)
+	}
+
+	values := map[string]data.Points{}
+	get := func(nodeID, typ, key string) (data.Point, bool) {
+		pts := values[nodeID]
+		return pts.Find(typ, key)
+	}
+
+	next := func(state string, inState time.Duration) string {
+		tr, ok := smNextState(config, "parent", get, state, inState)
+		if !ok {
+			return ""
+		}
+		return tr.To
+	}
+
+	values["parent"] = data.Points{{Type: "demand", Value: 1}}
+
+	if s := next("runA", time.Minute); s != "" {
+		t.Error("unexpected transition: ", s)
+	}
+
+	if s := next("runA", 2*time.Hour); s != "runB" {
+		t.Error("expected rotation to runB, got: ", s)
+	}
+
+	values["parent"] = append(values["parent"], data.Point{Type: "pumpA",
+		Text: "overload fault"})
+	if s := next("runA", time.Minute); s != "runB" {
+		t.Error("expected runB on pump A fault, got: ", s)
+	}
+
+	// lower priority is checked first
+	values["tank"] = data.Points{{Type: "level", Value: 95}}
+	if s := next("runA", 2*time.Hour); s != "alarm" {
+		t.Error("expected alarm, got: ", s)
+	}
+
+	values["parent"] = data.Points{{Type: "demand", Value: 0}}
+	if s := next("runB", time.Minute); s != "idle" {
+		t.Error("expected idle, got: ", s)
+	}
+
+	if s := next("idle", time.Minute); s != "" {
+		t.Error("unexpected transition from idle: ", s)
+	}
+}
@@ -0,0 +1,53 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// storeBackupTimeout is the max time the store can take to write a backup
+var storeBackupTimeout = 10 * time.Minute
+
+// StoreBackupRequest asks the store to write a snapshot of its database to
+// File. File is a path on the host the store runs on, and must not exist.
+type StoreBackupRequest struct {
+	File string `json:"file"`
+}
+
+// StoreBackupResult is the response to a StoreBackupRequest. Size is the
+// size of the snapshot in bytes.
+type StoreBackupResult struct {
+	Size  int64  `json:"size"`
+	Error string `json:"error,omitempty"`
+}
+
+// StoreBackup asks the store to write a consistent snapshot of its database
+// to file, while the store keeps running. The snapshot is a SQLite database
+// that can replace the store file to restore the backup. The size of the
+// snapshot is returned.
+func StoreBackup(nc *nats.Conn, file string) (int64, error) {
+	reqData, err := json.Marshal(StoreBackupRequest{File: file})
+	if err != nil {
+		return 0, err
+	}
+
+	msg, err := nc.Request(SubjectStoreBackup(), reqData, storeBackupTimeout)
+	if err != nil {
+		return 0, err
+	}
+
+	var ret StoreBackupResult
+	err = json.Unmarshal(msg.Data, &ret)
+	if err != nil {
+		return 0, err
+	}
+
+	if ret.Error != "" {
+		return 0, errors.New(ret.Error)
+	}
+
+	return ret.Size, nil
+}
@@ -14,11 +14,114 @@ func SubjectEdgePoints(nodeID, parentID string) string {
 	return fmt.Sprintf("node.%v.%v.points", nodeID, parentID)
 }
 
+// SubjectNodeCreate constructs a NATS subject for creating a node with its
+// node and edge points in one request
+func SubjectNodeCreate(nodeID string) string {
+	return fmt.Sprintf("node.%v.create", nodeID)
+}
+
+// SubjectShard constructs the NATS subject a store shard serves for a store
+// subject, for instance SubjectShard("a", SubjectNodePoints(id))
+func SubjectShard(shard, subject string) string {
+	return fmt.Sprintf("shard.%v.%v", shard, subject)
+}
+
+// SubjectNodeReorder constructs a NATS subject for reordering the children of
+// a node
+func SubjectNodeReorder(nodeID string) string {
+	return fmt.Sprintf("node.%v.reorder", nodeID)
+}
+
+// SubjectNodeTrash constructs a NATS subject for listing the deleted
+// descendants of a node
+func SubjectNodeTrash(nodeID string) string {
+	return fmt.Sprintf("node.%v.trash", nodeID)
+}
+
+// SubjectNodePurge constructs a NATS subject for permanently removing a
+// deleted node from a parent
+func SubjectNodePurge(nodeID, parentID string) string {
+	return fmt.Sprintf("node.%v.%v.purge", nodeID, parentID)
+}
+
+// SubjectNodeChanges constructs a NATS subject for querying the point change
+// history of a node
+func SubjectNodeChanges(nodeID string) string {
+	return fmt.Sprintf("node.%v.changes", nodeID)
+}
+
+// SubjectNodeErase constructs a NATS subject for erasing a node from the
+// store
+func SubjectNodeErase(nodeID string) string {
+	return fmt.Sprintf("node.%v.erase", nodeID)
+}
+
+// SubjectErase constructs a NATS subject for erasing a node from the
+// sources other than the store, like history databases and upstream
+// connections
+func SubjectErase(nodeID string) string {
+	return fmt.Sprintf("erase.%v", nodeID)
+}
+
+// SubjectQuarantineList constructs a NATS subject for listing quarantined
+// points
+func SubjectQuarantineList() string {
+	return "quarantine.list"
+}
+
+// SubjectQuarantineAdmit constructs a NATS subject for writing quarantined
+// points to their nodes
+func SubjectQuarantineAdmit() string {
+	return "quarantine.admit"
+}
+
+// SubjectQuarantineDiscard constructs a NATS subject for removing quarantined
+// points
+func SubjectQuarantineDiscard() string {
+	return "quarantine.discard"
+}
+
+// SubjectStoreBackup constructs a NATS subject for requesting a snapshot of
+// the store database
+func SubjectStoreBackup() string {
+	return "store.backup"
+}
+
+// SubjectHAHeartbeat provides the subject the instances of a high
+// availability store pair send heartbeats on
+func SubjectHAHeartbeat() string {
+	return "ha.heartbeat"
+}
+
+// SubjectHAReplicate provides the subject the active store of a high
+// availability pair replicates writes on
+func SubjectHAReplicate() string {
+	return "ha.repl"
+}
+
+// SubjectHASnapshot provides the subject a standby store requests a
+// snapshot of the active store on
+func SubjectHASnapshot() string {
+	return "ha.snapshot"
+}
+
+// SubjectHASnapshotChunk provides the subject a standby store fetches the
+// data of a snapshot on
+func SubjectHASnapshotChunk() string {
+	return "ha.snapshot.chunk"
+}
+
 // SubjectNodeAllPoints provides subject for all points for any node
 func SubjectNodeAllPoints() string {
 	return "node.*.points"
 }
 
+// SubjectNodeAllCreate provides subject for node create requests for any
+// node
+func SubjectNodeAllCreate() string {
+	return "node.*.create"
+}
+
 // SubjectEdgeAllPoints provides subject for all edge points for any node
 func SubjectEdgeAllPoints() string {
 	return "node.*.*.points"
@@ -28,3 +131,40 @@ func SubjectEdgeAllPoints() string {
 func SubjectNodeHRPoints(nodeID string) string {
 	return fmt.Sprintf("phr.%v", nodeID)
 }
+
+// SubjectNodeHistoryPoints constructs a NATS subject for historical points
+// that are written to history without being processed by rules
+func SubjectNodeHistoryPoints(nodeID string) string {
+	return fmt.Sprintf("history.%v.points", nodeID)
+}
+
+// SubjectNodeHistoryQuery constructs a NATS subject for node history queries
+func SubjectNodeHistoryQuery(nodeID string) string {
+	return fmt.Sprintf("history.%v.query", nodeID)
+}
+
+// SubjectTagQuery provides the subject for finding nodes by tag
+func SubjectTagQuery() string {
+	return "tags.query"
+}
+
+// SubjectTagList provides the subject for listing the tags in use
+func SubjectTagList() string {
+	return "tags.list"
+}
+
+// SubjectClientRegister provides the subject external clients register on
+func SubjectClientRegister() string {
+	return "client.register"
+}
+
+// SubjectClientList provides the subject for listing external clients
+func SubjectClientList() string {
+	return "client.list"
+}
+
+// SubjectFilterSubscribe provides the subject for creating filtered point
+// subscriptions
+func SubjectFilterSubscribe() string {
+	return "filter.subscribe"
+}
@@ -0,0 +1,664 @@
+package client
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/simpleiot/simpleiot/data"
+)
+
+// Syslog config. A syslog node listens for syslog messages from network
+// appliances on port (default 514) over UDP, TCP, or both (protocol is UDP,
+// TCP, or empty for both). Each raw line is published as a log point on the
+// syslog node, keyed by the sending host, and appended to filePath if it is
+// set. Syslog rule child nodes convert matching messages to points.
+type Syslog struct {
+	ID          string       `node:"id"`
+	Parent      string       `node:"parent"`
+	Description string       `point:"description"`
+	Port        int          `point:"port"`
+	Protocol    string       `point:"protocol"`
+	FilePath    string       `point:"filePath"`
+	MaxFileSize float64      `point:"maxFileSize"`
+	Disable     bool         `point:"disable"`
+	Rules       []SyslogRule `child:"syslogRule"`
+}
+
+// SyslogRule converts syslog messages that match pattern (a regular
+// expression) to a point on the rule node. If the pattern has a capture
+// group, the first group is the point value (or text if it is not a number),
+// otherwise value is used. If host is set, only messages from that host name
+// or IP address are matched.
+type SyslogRule struct {
+	ID          string  `node:"id"`
+	Parent      string  `node:"parent"`
+	Description string  `point:"description"`
+	Pattern     string  `point:"pattern"`
+	Host        string  `point:"host"`
+	PointType   string  `point:"pointType"`
+	PointKey    string  `point:"pointKey"`
+	Value       float64 `point:"value"`
+	Disable     bool    `point:"disable"`
+}
+
+// syslog limits
+const (
+	syslogMaxLine = 8192
+	// log files are rotated when they reach this size
+	syslogDefaultMaxFileSize = 10 * 1024 * 1024
+	syslogIdleTimeout        = 10 * time.Minute
+)
+
+// syslogMessage is a parsed RFC 3164 or RFC 5424 syslog message
+type syslogMessage struct {
+	Facility int
+	Severity int
+	Host     string
+	App      string
+	Message  string
+}
+
+// syslogParse parses a syslog line. Lines that are not in RFC 3164 or RFC
+// 5424 format are returned as the message with the default user.notice
+// priority.
+func syslogParse(line string) syslogMessage {
+	ret := syslogMessage{Facility: 1, Severity: 5}
+
+	s := strings.TrimRight(line, "\r\n\x00")
+
+	if strings.HasPrefix(s, "<") {
+		if i := strings.Index(s, ">"); i > 1 && i <= 4 {
+			pri, err := strconv.Atoi(s[1:i])
+			if err == nil && pri >= 0 && pri <= 191 {
+				ret.Facility, ret.Severity = pri/8, pri%8
+				s = s[i+1:]
+			}
+		}
+	}
+
+	if strings.HasPrefix(s, "1 ") {
+		// RFC 5424: VERSION TIMESTAMP HOSTNAME APP-NAME PROCID MSGID SD MSG
+		f := strings.SplitN(s, " ", 7)
+		if len(f) >= 6 {
+			nilValue := func(v string) string {
+				if v == "-" {
+					return ""
+				}
+				return v
+			}
+
+			ret.Host = nilValue(f[2])
+			ret.App = nilValue(f[3])
+
+			msg := ""
+			if len(f) == 7 {
+				msg = syslogSkipSD(f[6])
+			}
+
+			ret.Message = strings.TrimPrefix(msg, "\ufeff")
+			return ret
+		}
+	}
+
+	// RFC 3164: Mmm dd hh:mm:ss HOSTNAME TAG: MSG
+	if len(s) > 16 && s[15] == ' ' {
+		if _, err := time.Parse(time.Stamp, s[:15]); err == nil {
+			s = s[16:]
+			if i := strings.Index(s, " "); i > 0 {
+				ret.Host, s = s[:i], s[i+1:]
+			}
+		}
+	}
+
+	if i := strings.IndexAny(s, ":[ "); i > 0 && i <= 32 && s[i] != ' ' {
+		ret.App = s[:i]
+		if j := strings.Index(s, ": "); j >= i {
+			s = s[j+2:]
+		} else {
+			s = strings.TrimPrefix(s[i:], ":")
+		}
+	}
+
+	ret.Message = s
+	return ret
+}
+
+// syslogSkipSD skips RFC 5424 structured data and returns the message
+func syslogSkipSD(s string) string {
+	if strings.HasPrefix(s, "-") {
+		return strings.TrimPrefix(s[1:], " ")
+	}
+
+	for strings.HasPrefix(s, "[") {
+		i := 1
+		for ; i < len(s); i++ {
+			if s[i] == '\\' {
+				i++
+				continue
+			}
+			if s[i] == ']' {
+				break
+			}
+		}
+		if i >= len(s) {
+			return ""
+		}
+		s = s[i+1:]
+	}
+
+	return strings.TrimPrefix(s, " ")
+}
+
+// syslogRulePoint returns the point for a message if it matches the rule
+func syslogRulePoint(r SyslogRule, re *regexp.Regexp, m syslogMessage, from string,
+	now time.Time) (data.Point, bool) {
+	if r.Host != "" && r.Host != m.Host && r.Host != from {
+		return data.Point{}, false
+	}
+
+	match := re.FindStringSubmatch(m.Message)
+	if match == nil {
+		return data.Point{}, false
+	}
+
+	p := data.Point{Time: now, Type: r.PointType, Key: r.PointKey, Value: r.Value}
+	if p.Type == "" {
+		p.Type = data.PointTypeValue
+	}
+
+	if len(match) > 1 {
+		v, err := strconv.ParseFloat(match[1], 64)
+		if err != nil {
+			p.Value = 0
+			p.Text = match[1]
+		} else {
+			p.Value = v
+		}
+	}
+
+	return p, true
+}
+
+// syslogLine is a line received by the listener
+type syslogLine struct {
+	from string
+	line string
+}
+
+// syslogListener receives syslog lines over UDP and TCP
+type syslogListener struct {
+	udp   *net.UDPConn
+	tcp   net.Listener
+	lines chan<- syslogLine
+	desc  string
+
+	lock   sync.Mutex
+	conns  map[net.Conn]bool
+	closed chan struct{}
+	wg     sync.WaitGroup
+}
+
+func newSyslogListener(port int, protocol string, lines chan<- syslogLine,
+	desc string) (*syslogListener, error) {
+	l := &syslogListener{lines: lines, desc: desc, conns: make(map[net.Conn]bool),
+		closed: make(chan struct{})}
+
+	var err error
+
+	if protocol != data.PointValueTCP {
+		l.udp, err = net.ListenUDP("udp", &net.UDPAddr{Port: port})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if protocol != data.PointValueUDP {
+		l.tcp, err = net.Listen("tcp", fmt.Sprintf(":%v", port))
+		if err != nil {
+			if l.udp != nil {
+				l.udp.Close()
+			}
+			return nil, err
+		}
+	}
+
+	if l.udp != nil {
+		l.wg.Add(1)
+		go l.readUDP()
+	}
+
+	if l.tcp != nil {
+		l.wg.Add(1)
+		go l.accept()
+	}
+
+	return l, nil
+}
+
+func (l *syslogListener) send(from, line string) bool {
+	select {
+	case l.lines <- syslogLine{from, line}:
+		return true
+	case <-l.closed:
+		return false
+	}
+}
+
+func (l *syslogListener) readUDP() {
+	defer l.wg.Done()
+
+	buf := make([]byte, syslogMaxLine)
+
+	for {
+		n, addr, err := l.udp.ReadFromUDP(buf)
+		if err != nil {
+			select {
+			case <-l.closed:
+			default:
+				log.Printf("Syslog %v: UDP read error: %v\n", l.desc, err)
+			}
+			return
+		}
+
+		// a datagram can contain several lines
+		for _, line := range strings.Split(string(buf[:n]), "\n") {
+			if strings.TrimSpace(line) == "" {
+				continue
+			}
+			if !l.send(addr.IP.String(), line) {
+				return
+			}
+		}
+	}
+}
+
+func (l *syslogListener) accept() {
+	defer l.wg.Done()
+
+	for {
+		conn, err := l.tcp.Accept()
+		if err != nil {
+			select {
+			case <-l.closed:
+			default:
+				log.Printf("Syslog %v: TCP accept error: %v\n", l.desc, err)
+			}
+			return
+		}
+
+		l.lock.Lock()
+		select {
+		case <-l.closed:
+			l.lock.Unlock()
+			conn.Close()
+			return
+		default:
+		}
+		l.conns[conn] = true
+		l.lock.Unlock()
+
+		l.wg.Add(1)
+		go l.readTCP(conn)
+	}
+}
+
+// readTCP reads newline delimited or octet counted (RFC 6587) messages
+func (l *syslogListener) readTCP(conn net.Conn) {
+	defer l.wg.Done()
+	defer func() {
+		l.lock.Lock()
+		delete(l.conns, conn)
+		l.lock.Unlock()
+		conn.Close()
+	}()
+
+	from := ""
+	if addr, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
+		from = addr.IP.String()
+	}
+
+	r := bufio.NewReaderSize(conn, syslogMaxLine)
+
+	for {
+		_ = conn.SetReadDeadline(time.Now().Add(syslogIdleTimeout))
+
+		line, err := syslogReadFrame(r)
+		if err != nil {
+			if err != io.EOF {
+				select {
+				case <-l.closed:
+				default:
+					log.Printf("Syslog %v: TCP read error from %v: %v\n", l.desc,
+						from, err)
+				}
+			}
+			return
+		}
+
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		if !l.send(from, line) {
+			return
+		}
+	}
+}
+
+// syslogReadFrame reads one message from a TCP stream. Octet counted
+// messages start with the message length, newline delimited messages
+// start with <.
+func syslogReadFrame(r *bufio.Reader) (string, error) {
+	b, err := r.Peek(1)
+	// skip newlines between octet counted messages
+	for err == nil && (b[0] == '\n' || b[0] == '\r') {
+		_, _ = r.ReadByte()
+		b, err = r.Peek(1)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if b[0] >= '1' && b[0] <= '9' {
+		count, err := r.ReadString(' ')
+		if err != nil {
+			return "", err
+		}
+
+		n, err := strconv.Atoi(strings.TrimSpace(count))
+		if err != nil || n > syslogMaxLine {
+			return "", fmt.Errorf("invalid message length: %v", count)
+		}
+
+		buf := make([]byte, n)
+		_, err = io.ReadFull(r, buf)
+		return string(buf), err
+	}
+
+	line, err := r.ReadSlice('\n')
+	if err == bufio.ErrBufferFull {
+		// long lines are truncated
+		ret := string(line)
+		for err == bufio.ErrBufferFull {
+			_, err = r.ReadSlice('\n')
+		}
+		return ret, err
+	}
+	if err == io.EOF && len(line) > 0 {
+		return string(line), nil
+	}
+
+	return string(line), err
+}
+
+func (l *syslogListener) close() {
+	l.lock.Lock()
+	close(l.closed)
+	for c := range l.conns {
+		c.Close()
+	}
+	l.lock.Unlock()
+
+	if l.udp != nil {
+		l.udp.Close()
+	}
+	if l.tcp != nil {
+		l.tcp.Close()
+	}
+
+	l.wg.Wait()
+}
+
+// syslogFile appends lines to a log file, which is rotated to
+// <filePath>.1 when it reaches max size.
+type syslogFile struct {
+	path string
+	max  int64
+	f    *os.File
+	size int64
+}
+
+func (sf *syslogFile) write(line string) error {
+	if sf.f == nil {
+		f, err := os.OpenFile(sf.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return err
+		}
+
+		st, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return err
+		}
+
+		sf.f, sf.size = f, st.Size()
+	}
+
+	if sf.size > 0 && sf.size+int64(len(line))+1 > sf.max {
+		sf.close()
+
+		err := os.Rename(sf.path, sf.path+".1")
+		if err != nil {
+			return err
+		}
+
+		return sf.write(line)
+	}
+
+	n, err := fmt.Fprintln(sf.f, line)
+	sf.size += int64(n)
+	return err
+}
+
+func (sf *syslogFile) close() {
+	if sf.f != nil {
+		sf.f.Close()
+		sf.f = nil
+	}
+}
+
+// SyslogClient is a SIOT client used to receive syslog messages
+type SyslogClient struct {
+	nc            *nats.Conn
+	config        Syslog
+	stop          chan struct{}
+	newPoints     chan NewPoints
+	newEdgePoints chan NewPoints
+	lines         chan syslogLine
+	patterns      map[string]*regexp.Regexp
+}
+
+// NewSyslogClient ...
+func NewSyslogClient(nc *nats.Conn, config Syslog) Client {
+	return &SyslogClient{
+		nc:            nc,
+		config:        config,
+		stop:          make(chan struct{}),
+		newPoints:     make(chan NewPoints),
+		newEdgePoints: make(chan NewPoints),
+		lines:         make(chan syslogLine),
+		patterns:      make(map[string]*regexp.Regexp),
+	}
+}
+
+// pattern returns the compiled regular expression for a rule. Invalid
+// patterns are logged once.
+func (sc *SyslogClient) pattern(r SyslogRule) *regexp.Regexp {
+	re, ok := sc.patterns[r.Pattern]
+	if ok {
+		return re
+	}
+
+	re, err := regexp.Compile(r.Pattern)
+	if err != nil {
+		log.Printf("Syslog %v: invalid pattern for rule %v: %v\n",
+			sc.config.Description, r.Description, err)
+	}
+
+	sc.patterns[r.Pattern] = re
+	return re
+}
+
+func (sc *SyslogClient) handle(l syslogLine, file *syslogFile) {
+	now := time.Now()
+	m := syslogParse(l.line)
+
+	host := m.Host
+	if host == "" {
+		host = l.from
+	}
+
+	line := strings.TrimRight(l.line, "\r\n\x00")
+
+	err := SendNodePoint(sc.nc, sc.config.ID, data.Point{Time: now,
+		Type: data.PointTypeLog, Key: host, Text: line}, false)
+	if err != nil {
+		log.Println("Syslog error sending log point: ", err)
+	}
+
+	if file != nil {
+		err := file.write(fmt.Sprintf("%v %v %v", now.Format(time.RFC3339), l.from,
+			line))
+		if err != nil {
+			log.Printf("Syslog %v: error writing log file: %v\n",
+				sc.config.Description, err)
+		}
+	}
+
+	for _, r := range sc.config.Rules {
+		if r.Disable || r.Pattern == "" {
+			continue
+		}
+
+		re := sc.pattern(r)
+		if re == nil {
+			continue
+		}
+
+		p, ok := syslogRulePoint(r, re, m, l.from, now)
+		if !ok {
+			continue
+		}
+
+		err := SendNodePoint(sc.nc, r.ID, p, false)
+		if err != nil {
+			log.Println("Syslog error sending rule point: ", err)
+		}
+	}
+}
+
+// Start runs the main logic for this client and blocks until stopped
+func (sc *SyslogClient) Start() error {
+	log.Println("Starting syslog client: ", sc.config.Description)
+
+	var listener *syslogListener
+	var file *syslogFile
+
+	closeListener := func() {
+		if listener != nil {
+			listener.close()
+			listener = nil
+		}
+		if file != nil {
+			file.close()
+			file = nil
+		}
+	}
+
+	listen := func() {
+		closeListener()
+
+		if sc.config.Disable {
+			log.Printf("Syslog %v: disabled\n", sc.config.Description)
+			return
+		}
+
+		port := sc.config.Port
+		if port <= 0 {
+			port = 514
+		}
+
+		var err error
+		listener, err = newSyslogListener(port, sc.config.Protocol, sc.lines,
+			sc.config.Description)
+		if err != nil {
+			log.Printf("Syslog %v: error listening: %v\n", sc.config.Description, err)
+			return
+		}
+
+		if sc.config.FilePath != "" {
+			max := int64(sc.config.MaxFileSize)
+			if max <= 0 {
+				max = syslogDefaultMaxFileSize
+			}
+			file = &syslogFile{path: sc.config.FilePath, max: max}
+		}
+	}
+
+	listen()
+
+done:
+	for {
+		select {
+		case <-sc.stop:
+			log.Println("Stopping syslog client: ", sc.config.Description)
+			break done
+		case l := <-sc.lines:
+			sc.handle(l, file)
+
+		case pts := <-sc.newPoints:
+			err := data.MergePoints(pts.ID, pts.Points, &sc.config)
+			if err != nil {
+				log.Println("error merging new points: ", err)
+			}
+
+			if pts.ID == sc.config.ID {
+				for _, p := range pts.Points {
+					switch p.Type {
+					case data.PointTypePort, data.PointTypeProtocol,
+						data.PointTypeFilePath, data.PointTypeMaxFileSize,
+						data.PointTypeDisable:
+						listen()
+					}
+				}
+			}
+
+		case pts := <-sc.newEdgePoints:
+			err := data.MergeEdgePoints(pts.ID, pts.Parent, pts.Points, &sc.config)
+			if err != nil {
+				log.Println("error merging new points: ", err)
+			}
+		}
+	}
+
+	// clean up
+	closeListener()
+	return nil
+}
+
+// Stop sends a signal to the Start function to exit
+func (sc *SyslogClient) Stop(err error) {
+	close(sc.stop)
+}
+
+// Points is called by the Manager when new points for this
+// node are received.
+func (sc *SyslogClient) Points(nodeID string, points []data.Point) {
+	sc.newPoints <- NewPoints{nodeID, "", points}
+}
+
+// EdgePoints is called by the Manager when new edge points for this
+// node are received.
+func (sc *SyslogClient) EdgePoints(nodeID, parentID string, points []data.Point) {
+	sc.newEdgePoints <- NewPoints{nodeID, parentID, points}
+}
@@ -0,0 +1,157 @@
+package client
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSyslogParse(t *testing.T) {
+	tests := []struct {
+		line string
+		exp  syslogMessage
+	}{
+		{"<34>Oct 11 22:14:15 mymachine su: 'su root' failed for lonvick on /dev/pts/8",
+			syslogMessage{Facility: 4, Severity: 2, Host: "mymachine", App: "su",
+				Message: "'su root' failed for lonvick on /dev/pts/8"}},
+		{"<13>Oct  1 02:03:04 fw1 sshd[123]: Failed password for root\n",
+			syslogMessage{Facility: 1, Severity: 5, Host: "fw1", App: "sshd",
+				Message: "Failed password for root"}},
+		{`<165>1 2003-10-11T22:14:15.003Z mymachine.example.com evntslog - ID47 [exampleSDID@32473 iut="3" eventSource="App\]"] An application event`,
+			syslogMessage{Facility: 20, Severity: 5, Host: "mymachine.example.com",
+				App: "evntslog", Message: "An application event"}},
+		{"<14>1 2022-10-11T22:14:15Z switch1 - - - - port 3 link down",
+			syslogMessage{Facility: 1, Severity: 6, Host: "switch1",
+				Message: "port 3 link down"}},
+		{"link down on port 3",
+			syslogMessage{Facility: 1, Severity: 5, Message: "link down on port 3"}},
+	}
+
+	for _, test := range tests {
+		m := syslogParse(test.line)
+		if m != test.exp {
+			t.Errorf("%v: expected %+v, got %+v", test.line, test.exp, m)
+		}
+	}
+}
+
+func TestSyslogRulePoint(t *testing.T) {
+	m := syslogMessage{Host: "ups1", Message: "battery charge 87% remaining"}
+
+	r := SyslogRule{Pattern: `charge (\d+)%`, PointType: "batteryCharge"}
+	p, ok := syslogRulePoint(r, regexp.MustCompile(r.Pattern), m, "10.0.0.5", time.Now())
+	if !ok || p.Type != "batteryCharge" || p.Value != 87 {
+		t.Error("wrong point: ", p)
+	}
+
+	r = SyslogRule{Pattern: "battery", Value: 1, Host: "10.0.0.5"}
+	p, ok = syslogRulePoint(r, regexp.MustCompile(r.Pattern), m, "10.0.0.5", time.Now())
+	if !ok || p.Type != "value" || p.Value != 1 {
+		t.Error("wrong point: ", p)
+	}
+
+	r.Host = "ups2"
+	if _, ok := syslogRulePoint(r, regexp.MustCompile(r.Pattern), m, "10.0.0.5",
+		time.Now()); ok {
+		t.Error("rule for another host should not match")
+	}
+}
+
+func TestSyslogReadFrame(t *testing.T) {
+	r := bufio.NewReader(strings.NewReader("11 <13>hello 1\n<13>hello 2\n<13>hello 3"))
+
+	for i := 1; i <= 3; i++ {
+		f, err := syslogReadFrame(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.TrimSpace(f) != fmt.Sprintf("<13>hello %v", i) {
+			t.Errorf("wrong frame %v: %q", i, f)
+		}
+	}
+}
+
+func TestSyslogListener(t *testing.T) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := conn.LocalAddr().(*net.UDPAddr).Port
+	conn.Close()
+
+	lines := make(chan syslogLine)
+	l, err := newSyslogListener(port, "", lines, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.close()
+
+	uc, err := net.Dial("udp", fmt.Sprintf("127.0.0.1:%v", port))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer uc.Close()
+
+	if _, err := uc.Write([]byte("<13>udp line")); err != nil {
+		t.Fatal(err)
+	}
+
+	tc, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%v", port))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tc.Close()
+
+	if _, err := tc.Write([]byte("12 <13>tcp line")); err != nil {
+		t.Fatal(err)
+	}
+
+	got := make(map[string]bool)
+	for i := 0; i < 2; i++ {
+		select {
+		case l := <-lines:
+			if l.from != "127.0.0.1" {
+				t.Error("wrong sender: ", l.from)
+			}
+			got[l.line] = true
+		case <-time.After(2 * time.Second):
+			t.Fatal("timeout waiting for lines, got: ", got)
+		}
+	}
+
+	if !got["<13>udp line"] || !got["<13>tcp line"] {
+		t.Error("wrong lines: ", got)
+	}
+}
+
+func TestSyslogFile(t *testing.T) {
+	p := path.Join(t.TempDir(), "syslog.log")
+	f := &syslogFile{path: p, max: 20}
+	defer f.close()
+
+	for _, l := range []string{"line one", "line two", "line three"} {
+		if err := f.write(l); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	old, err := os.ReadFile(p + ".1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(old) != "line one\nline two\n" {
+		t.Errorf("wrong rotated file: %q", old)
+	}
+
+	cur, _ := os.ReadFile(p)
+	if string(cur) != "line three\n" {
+		t.Errorf("wrong log file: %q", cur)
+	}
+}
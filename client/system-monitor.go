@@ -0,0 +1,192 @@
+package client
+
+import (
+	"log"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/simpleiot/simpleiot/data"
+	"github.com/simpleiot/simpleiot/system"
+)
+
+// SystemMonitor config. A system monitor node publishes host metrics (disk
+// usage, network counters, temperatures, and uptime) as points on the node.
+// Each type of metric can be enabled separately.
+type SystemMonitor struct {
+	ID                 string  `node:"id"`
+	Parent             string  `node:"parent"`
+	Description        string  `point:"description"`
+	SamplePeriod       float64 `point:"samplePeriod"`
+	MonitorDisk        bool    `point:"monitorDisk"`
+	MonitorNetwork     bool    `point:"monitorNetwork"`
+	MonitorTemperature bool    `point:"monitorTemperature"`
+	MonitorUptime      bool    `point:"monitorUptime"`
+	Disable            bool    `point:"disable"`
+}
+
+// SystemMonitorClient for system monitor nodes
+type SystemMonitorClient struct {
+	nc            *nats.Conn
+	config        SystemMonitor
+	stop          chan struct{}
+	newPoints     chan NewPoints
+	newEdgePoints chan NewPoints
+}
+
+// NewSystemMonitorClient ...
+func NewSystemMonitorClient(nc *nats.Conn, config SystemMonitor) Client {
+	return &SystemMonitorClient{
+		nc:            nc,
+		config:        config,
+		stop:          make(chan struct{}),
+		newPoints:     make(chan NewPoints),
+		newEdgePoints: make(chan NewPoints),
+	}
+}
+
+// systemMonitorPoints collects the metrics enabled in config
+func systemMonitorPoints(config SystemMonitor, now time.Time) data.Points {
+	var ret data.Points
+
+	if config.MonitorDisk {
+		disks, err := system.ReadDiskUsage()
+		if err != nil {
+			log.Println("System monitor error reading disk usage: ", err)
+		}
+
+		for _, d := range disks {
+			ret = append(ret,
+				data.Point{Time: now, Type: data.PointTypeDiskUsed,
+					Key: d.Mount, Value: d.UsedPercent()},
+				data.Point{Time: now, Type: data.PointTypeDiskFree,
+					Key: d.Mount, Value: float64(d.Free)})
+		}
+	}
+
+	if config.MonitorNetwork {
+		stats, err := system.ReadNetStats()
+		if err != nil {
+			log.Println("System monitor error reading network stats: ", err)
+		}
+
+		for _, s := range stats {
+			ret = append(ret,
+				data.Point{Time: now, Type: data.PointTypeNetRxBytes,
+					Key: s.Interface, Value: float64(s.RxBytes)},
+				data.Point{Time: now, Type: data.PointTypeNetTxBytes,
+					Key: s.Interface, Value: float64(s.TxBytes)},
+				data.Point{Time: now, Type: data.PointTypeNetRxErrors,
+					Key: s.Interface, Value: float64(s.RxErrors)},
+				data.Point{Time: now, Type: data.PointTypeNetTxErrors,
+					Key: s.Interface, Value: float64(s.TxErrors)})
+		}
+	}
+
+	if config.MonitorTemperature {
+		temps, err := system.ReadTemperatures()
+		if err != nil {
+			log.Println("System monitor error reading temperatures: ", err)
+		}
+
+		for _, t := range temps {
+			ret = append(ret, data.Point{Time: now, Type: data.PointTypeTemperature,
+				Key: t.Sensor, Value: t.Value})
+		}
+	}
+
+	if config.MonitorUptime {
+		uptime, err := system.ReadUptime()
+		if err != nil {
+			log.Println("System monitor error reading uptime: ", err)
+		} else {
+			ret = append(ret, data.Point{Time: now, Type: data.PointTypeUptime,
+				Value: uptime.Seconds()})
+		}
+	}
+
+	return ret
+}
+
+// Start runs the main logic for this client and blocks until stopped
+func (smc *SystemMonitorClient) Start() error {
+	log.Println("Starting system monitor client: ", smc.config.Description)
+
+	t := time.NewTicker(time.Hour)
+	t.Stop()
+
+	setup := func() {
+		t.Stop()
+
+		if smc.config.Disable {
+			log.Printf("System monitor %v: disabled\n", smc.config.Description)
+			return
+		}
+
+		period := smc.config.SamplePeriod
+		if period <= 0 {
+			period = 60
+		}
+
+		t.Reset(time.Duration(period * float64(time.Second)))
+	}
+
+	setup()
+
+done:
+	for {
+		select {
+		case <-smc.stop:
+			log.Println("Stopping system monitor client: ", smc.config.Description)
+			break done
+		case now := <-t.C:
+			pts := systemMonitorPoints(smc.config, now)
+			if len(pts) < 1 {
+				continue
+			}
+
+			err := SendNodePoints(smc.nc, smc.config.ID, pts, false)
+			if err != nil {
+				log.Println("System monitor error sending points: ", err)
+			}
+		case pts := <-smc.newPoints:
+			err := data.MergePoints(pts.ID, pts.Points, &smc.config)
+			if err != nil {
+				log.Println("error merging new points: ", err)
+			}
+
+			for _, p := range pts.Points {
+				switch p.Type {
+				case data.PointTypeSamplePeriod, data.PointTypeDisable:
+					setup()
+				}
+			}
+
+		case pts := <-smc.newEdgePoints:
+			err := data.MergeEdgePoints(pts.ID, pts.Parent, pts.Points, &smc.config)
+			if err != nil {
+				log.Println("error merging new points: ", err)
+			}
+		}
+	}
+
+	// clean up
+	t.Stop()
+	return nil
+}
+
+// Stop sends a signal to the Start function to exit
+func (smc *SystemMonitorClient) Stop(err error) {
+	close(smc.stop)
+}
+
+// Points is called by the Manager when new points for this
+// node are received.
+func (smc *SystemMonitorClient) Points(nodeID string, points []data.Point) {
+	smc.newPoints <- NewPoints{nodeID, "", points}
+}
+
+// EdgePoints is called by the Manager when new edge points for this
+// node are received.
+func (smc *SystemMonitorClient) EdgePoints(nodeID, parentID string, points []data.Point) {
+	smc.newEdgePoints <- NewPoints{nodeID, parentID, points}
+}
@@ -0,0 +1,119 @@
+package client
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/simpleiot/simpleiot/data"
+)
+
+// TLSOptions describe the certificates used by a TLS client connection. Each
+// field is either the name of a PEM file, or the PEM data itself so that
+// certificates can be stored in node points.
+type TLSOptions struct {
+	// Cert and Key are the client certificate presented to servers that
+	// require client certificates (mTLS)
+	Cert string
+	Key  string
+	// CA is used to verify the server certificate instead of the system
+	// root CAs
+	CA string
+}
+
+// Enabled returns true if any TLS options are set
+func (o TLSOptions) Enabled() bool {
+	return o.Cert != "" || o.Key != "" || o.CA != ""
+}
+
+// readPEM returns PEM data, reading it from a file unless v is already PEM
+// data
+func readPEM(v string) ([]byte, error) {
+	if strings.HasPrefix(strings.TrimSpace(v), "-----BEGIN") {
+		return []byte(v), nil
+	}
+
+	return os.ReadFile(v)
+}
+
+// Config returns a TLS config for the options
+func (o TLSOptions) Config() (*tls.Config, error) {
+	ret := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if (o.Cert == "") != (o.Key == "") {
+		return nil, errors.New("TLS cert and key must both be set")
+	}
+
+	if o.Cert != "" {
+		cert, err := readPEM(o.Cert)
+		if err != nil {
+			return nil, fmt.Errorf("Error reading TLS cert: %v", err)
+		}
+
+		key, err := readPEM(o.Key)
+		if err != nil {
+			return nil, fmt.Errorf("Error reading TLS key: %v", err)
+		}
+
+		pair, err := tls.X509KeyPair(cert, key)
+		if err != nil {
+			return nil, fmt.Errorf("Error parsing TLS cert: %v", err)
+		}
+
+		ret.Certificates = []tls.Certificate{pair}
+	}
+
+	if o.CA != "" {
+		ca, err := readPEM(o.CA)
+		if err != nil {
+			return nil, fmt.Errorf("Error reading TLS CA: %v", err)
+		}
+
+		ret.RootCAs = x509.NewCertPool()
+		if !ret.RootCAs.AppendCertsFromPEM(ca) {
+			return nil, errors.New("TLS CA does not contain any certificates")
+		}
+	}
+
+	return ret, nil
+}
+
+// CertExpires returns the time the first certificate in cert expires. cert
+// is a file name or PEM data.
+func CertExpires(cert string) (time.Time, error) {
+	d, err := readPEM(cert)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	block, _ := pem.Decode(d)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return time.Time{}, errors.New("no certificate found")
+	}
+
+	c, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return c.NotAfter, nil
+}
+
+// CertExpiresPoint returns a certExpires point for a certificate. The value
+// is the number of days until the certificate expires (negative if it has
+// expired) so that rules can warn before it does, and the text is the
+// expiration time. key identifies the certificate.
+func CertExpiresPoint(key string, expires, now time.Time) data.Point {
+	return data.Point{
+		Time:  now,
+		Type:  data.PointTypeCertExpires,
+		Key:   key,
+		Value: expires.Sub(now).Hours() / 24,
+		Text:  expires.UTC().Format(time.RFC3339),
+	}
+}
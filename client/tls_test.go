@@ -0,0 +1,93 @@
+package client
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCert returns a self signed certificate and key in PEM format
+func testCert(t *testing.T, expires time.Time) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal("Error generating key: ", err)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "siot-test"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              expires,
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal("Error creating cert: ", err)
+	}
+
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal("Error marshalling key: ", err)
+	}
+
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}))
+}
+
+func TestTLSOptions(t *testing.T) {
+	expires := time.Now().Add(10 * 24 * time.Hour).Truncate(time.Second)
+	cert, key := testCert(t, expires)
+
+	certFile := filepath.Join(t.TempDir(), "cert.pem")
+	err := os.WriteFile(certFile, []byte(cert), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// certs can be files or PEM data
+	c, err := TLSOptions{Cert: certFile, Key: key, CA: cert}.Config()
+	if err != nil {
+		t.Fatal("Error creating TLS config: ", err)
+	}
+
+	if len(c.Certificates) != 1 || c.RootCAs == nil {
+		t.Fatal("TLS config is missing certs")
+	}
+
+	_, err = TLSOptions{Cert: certFile}.Config()
+	if err == nil {
+		t.Fatal("expected error for missing key")
+	}
+
+	_, err = TLSOptions{CA: "not a cert"}.Config()
+	if err == nil {
+		t.Fatal("expected error for missing CA file")
+	}
+
+	for _, v := range []string{cert, certFile} {
+		e, err := CertExpires(v)
+		if err != nil {
+			t.Fatal("Error getting cert expiration: ", err)
+		}
+
+		if !e.Equal(expires) {
+			t.Errorf("expected expiration %v, got %v", expires, e)
+		}
+	}
+
+	p := CertExpiresPoint("nats", expires, expires.Add(-48*time.Hour))
+	if p.Key != "nats" || p.Value != 2 {
+		t.Error("unexpected cert expiration point: ", p)
+	}
+}
@@ -0,0 +1,685 @@
+package client
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/simpleiot/simpleiot/data"
+)
+
+// trackIdlePeriod is how often positions are stored while a node is not on
+// a trip. Positions are stored as they are received during a trip.
+var trackIdlePeriod = 5 * time.Minute
+
+// trackerCheckPeriod is how often a tracker checks if the current trip has
+// ended when no positions are received
+var trackerCheckPeriod = 10 * time.Second
+
+// trackQueryTimeout is how long QueryTrack waits for a response
+var trackQueryTimeout = 20 * time.Second
+
+// Tracker config. A tracker node records the position history of its parent
+// node from the latitude and longitude points, and segments it into trips.
+// A trip starts when the node moves more than StopDistance from where it
+// stopped, and ends when it stays within StopDistance for StopDuration.
+type Tracker struct {
+	ID          string `node:"id"`
+	Parent      string `node:"parent"`
+	Description string `point:"description"`
+	// StopDistance is in meters. Default is 50.
+	StopDistance float64 `point:"stopDistance"`
+	// StopDuration is in seconds. Default is 5 minutes.
+	StopDuration float64 `point:"stopDuration"`
+	// RetentionDays is the number of days of positions kept. Default is
+	// 90.
+	RetentionDays int    `point:"retentionDays"`
+	Directory     string `point:"directory"`
+	Disable       bool   `point:"disable"`
+	// the following values are written by the client. TripDistance and
+	// TripDuration are for the current or last trip, and TripCount counts
+	// the trips started. The totals can be set by the user, for example
+	// to the odometer of a vehicle.
+	Moving        bool    `point:"moving"`
+	TripDistance  float64 `point:"tripDistance"`
+	TripDuration  float64 `point:"tripDuration"`
+	TripCount     int     `point:"tripCount"`
+	DistanceTotal float64 `point:"distanceTotal"`
+}
+
+// TrackPosition is a position of a tracked node
+type TrackPosition struct {
+	Time time.Time `json:"time"`
+	Lat  float64   `json:"lat"`
+	Long float64   `json:"long"`
+}
+
+func (p TrackPosition) gps() data.GpsPos {
+	return data.GpsPos{Lat: p.Lat, Long: p.Long}
+}
+
+// Trip is a trip of a tracked node. Distance is in meters and Duration is
+// in seconds.
+type Trip struct {
+	From       TrackPosition `json:"from"`
+	To         TrackPosition `json:"to"`
+	Distance   float64       `json:"distance"`
+	Duration   float64       `json:"duration"`
+	InProgress bool          `json:"inProgress,omitempty"`
+}
+
+// TrackQuery is used to query the track of a tracker node
+type TrackQuery struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+// TrackResult is the response to a track query. Trips that overlap the query
+// are returned, including a trip in progress.
+type TrackResult struct {
+	Positions []TrackPosition `json:"positions"`
+	Trips     []Trip          `json:"trips"`
+	Error     string          `json:"error,omitempty"`
+}
+
+// SubjectTrackQuery constructs a NATS subject for track queries
+func SubjectTrackQuery(trackerID string) string {
+	return fmt.Sprintf("track.%v.query", trackerID)
+}
+
+// QueryTrack returns the positions and trips of a tracker node from start
+// to end
+func QueryTrack(nc *nats.Conn, trackerID string, start, end time.Time) (TrackResult, error) {
+	var ret TrackResult
+
+	if !start.Before(end) {
+		return ret, errors.New("track query start must be before end")
+	}
+
+	reqData, err := json.Marshal(TrackQuery{Start: start, End: end})
+	if err != nil {
+		return ret, err
+	}
+
+	msg, err := nc.Request(SubjectTrackQuery(trackerID), reqData, trackQueryTimeout)
+	if err != nil {
+		return ret, err
+	}
+
+	err = json.Unmarshal(msg.Data, &ret)
+	if err != nil {
+		return ret, err
+	}
+
+	if ret.Error != "" {
+		return ret, errors.New(ret.Error)
+	}
+
+	return ret, nil
+}
+
+// tripSegmenter splits positions into trips
+type tripSegmenter struct {
+	stopDistance float64
+	stopDuration time.Duration
+
+	last    TrackPosition
+	hasLast bool
+	// anchor is the first position within stopDistance of the following
+	// positions, which is where the node stopped, or may have stopped
+	anchor TrackPosition
+	// trip distance when the node arrived at anchor
+	anchorDistance float64
+	trip           *Trip
+}
+
+// add adds a position. It returns true if a trip started, and the trip that
+// ended, if any. Positions older than the last position are ignored.
+func (ts *tripSegmenter) add(p TrackPosition) (bool, *Trip) {
+	if !ts.hasLast {
+		ts.last, ts.anchor, ts.hasLast = p, p, true
+		return false, nil
+	}
+
+	if p.Time.Before(ts.last.Time) {
+		return false, nil
+	}
+
+	defer func() { ts.last = p }()
+
+	if ts.trip == nil {
+		if distance(ts.anchor.gps(), p.gps()) <= ts.stopDistance {
+			return false, nil
+		}
+
+		d := distance(ts.last.gps(), p.gps())
+		ts.trip = &Trip{From: ts.last, Distance: d, InProgress: true}
+		ts.anchor, ts.anchorDistance = p, d
+		return true, nil
+	}
+
+	ts.trip.Distance += distance(ts.last.gps(), p.gps())
+
+	if distance(ts.anchor.gps(), p.gps()) > ts.stopDistance {
+		ts.anchor, ts.anchorDistance = p, ts.trip.Distance
+		return false, nil
+	}
+
+	return false, ts.check(p.Time)
+}
+
+// check ends the current trip and returns it if the node has been stopped
+// for stopDuration at now
+func (ts *tripSegmenter) check(now time.Time) *Trip {
+	if ts.trip == nil || now.Sub(ts.anchor.Time) < ts.stopDuration {
+		return nil
+	}
+
+	ret := *ts.trip
+	ret.To = ts.anchor
+	ret.Distance = ts.anchorDistance
+	ret.Duration = ts.anchor.Time.Sub(ret.From.Time).Seconds()
+	ret.InProgress = false
+	ts.trip = nil
+
+	return &ret
+}
+
+// current returns the trip in progress, if any
+func (ts *tripSegmenter) current() *Trip {
+	if ts.trip == nil {
+		return nil
+	}
+
+	ret := *ts.trip
+	ret.To = ts.last
+	ret.Duration = ts.last.Time.Sub(ret.From.Time).Seconds()
+	return &ret
+}
+
+// trackStore stores positions in a file per day (UTC), and trips in a
+// trips file
+type trackStore struct {
+	dir string
+}
+
+const trackDayFormat = "2006-01-02"
+
+func (s trackStore) dayFile(t time.Time) string {
+	return filepath.Join(s.dir, t.UTC().Format(trackDayFormat)+".jsonl")
+}
+
+func (s trackStore) tripsFile() string {
+	return filepath.Join(s.dir, "trips.jsonl")
+}
+
+func (s trackStore) append(file string, v any) error {
+	err := os.MkdirAll(s.dir, 0755)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(file, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	err = json.NewEncoder(f).Encode(v)
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+func (s trackStore) addPosition(p TrackPosition) error {
+	return s.append(s.dayFile(p.Time), p)
+}
+
+func (s trackStore) addTrip(t Trip) error {
+	return s.append(s.tripsFile(), t)
+}
+
+// readLines decodes each line of file with decode. Missing files and lines
+// that can't be decoded, like a line that is being written, are skipped.
+func readLines(file string, decode func([]byte) error) error {
+	f, err := os.Open(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		_ = decode(scanner.Bytes())
+	}
+
+	return scanner.Err()
+}
+
+// query returns the positions and trips from start to end
+func (s trackStore) query(start, end time.Time) (TrackResult, error) {
+	ret := TrackResult{Positions: []TrackPosition{}, Trips: []Trip{}}
+
+	day := start.UTC().Truncate(24 * time.Hour)
+	for !day.After(end) {
+		err := readLines(s.dayFile(day), func(line []byte) error {
+			var p TrackPosition
+			err := json.Unmarshal(line, &p)
+			if err == nil && !p.Time.Before(start) && !p.Time.After(end) {
+				ret.Positions = append(ret.Positions, p)
+			}
+			return err
+		})
+
+		if err != nil {
+			return ret, err
+		}
+
+		day = day.AddDate(0, 0, 1)
+	}
+
+	err := readLines(s.tripsFile(), func(line []byte) error {
+		var t Trip
+		err := json.Unmarshal(line, &t)
+		if err == nil && !t.To.Time.Before(start) && !t.From.Time.After(end) {
+			ret.Trips = append(ret.Trips, t)
+		}
+		return err
+	})
+
+	return ret, err
+}
+
+// prune removes the position files of days before the newest days, and the
+// trips that ended before the oldest day kept
+func (s trackStore) prune(days int) error {
+	_, err := pruneFiles(filepath.Join(s.dir, "[0-9]*.jsonl"), days)
+	if err != nil {
+		return err
+	}
+
+	files, err := filepath.Glob(filepath.Join(s.dir, "[0-9]*.jsonl"))
+	if err != nil || len(files) == 0 {
+		return err
+	}
+
+	oldest, err := time.Parse(trackDayFormat, filepath.Base(files[0])[:len(trackDayFormat)])
+	if err != nil {
+		return err
+	}
+
+	var trips []Trip
+	pruned := false
+
+	err = readLines(s.tripsFile(), func(line []byte) error {
+		var t Trip
+		err := json.Unmarshal(line, &t)
+		if err == nil {
+			if t.To.Time.Before(oldest) {
+				pruned = true
+			} else {
+				trips = append(trips, t)
+			}
+		}
+		return err
+	})
+
+	if err != nil || !pruned {
+		return err
+	}
+
+	tmp := s.tripsFile() + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	en := json.NewEncoder(f)
+	for _, t := range trips {
+		err = en.Encode(t)
+		if err != nil {
+			f.Close()
+			return err
+		}
+	}
+
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, s.tripsFile())
+}
+
+// TrackerClient records positions and trips for tracker nodes
+type TrackerClient struct {
+	nc            *nats.Conn
+	config        Tracker
+	stop          chan struct{}
+	newPoints     chan NewPoints
+	newEdgePoints chan NewPoints
+	parentPoints  chan []data.Point
+
+	seg        *tripSegmenter
+	store      trackStore
+	lastStored time.Time
+	lat, long  float64
+	latSet     bool
+	longSet    bool
+
+	// lock protects current, which is read by track queries
+	lock    sync.Mutex
+	current *Trip
+}
+
+// NewTrackerClient ...
+func NewTrackerClient(nc *nats.Conn, config Tracker) Client {
+	return &TrackerClient{
+		nc:            nc,
+		config:        config,
+		stop:          make(chan struct{}),
+		newPoints:     make(chan NewPoints),
+		newEdgePoints: make(chan NewPoints),
+		parentPoints:  make(chan []data.Point),
+	}
+}
+
+// trackerDir returns the directory positions and trips are stored in
+func trackerDir(config Tracker) string {
+	if config.Directory != "" {
+		return config.Directory
+	}
+	return filepath.Join("tracks", config.ID)
+}
+
+func (tc *TrackerClient) setup() {
+	stopDistance := tc.config.StopDistance
+	if stopDistance <= 0 {
+		stopDistance = 50
+	}
+
+	stopDuration := 5 * time.Minute
+	if tc.config.StopDuration > 0 {
+		stopDuration = time.Duration(tc.config.StopDuration * float64(time.Second))
+	}
+
+	tc.seg = &tripSegmenter{stopDistance: stopDistance, stopDuration: stopDuration}
+	tc.store = trackStore{dir: trackerDir(tc.config)}
+	tc.setCurrent(nil)
+
+	days := tc.config.RetentionDays
+	if days <= 0 {
+		days = 90
+	}
+
+	err := tc.store.prune(days)
+	if err != nil {
+		log.Printf("Tracker %v: error pruning track: %v\n", tc.config.Description, err)
+	}
+}
+
+func (tc *TrackerClient) setCurrent(t *Trip) {
+	tc.lock.Lock()
+	defer tc.lock.Unlock()
+	tc.current = t
+}
+
+func (tc *TrackerClient) sendPoints(pts data.Points) {
+	now := time.Now()
+	for i := range pts {
+		pts[i].Time = now
+		pts[i].Origin = tc.config.ID
+	}
+
+	err := SendNodePoints(tc.nc, tc.config.ID, pts, false)
+	if err != nil {
+		log.Printf("Tracker %v: error sending points: %v\n", tc.config.Description, err)
+	}
+}
+
+// tripPoints returns the summary points for a trip
+func (tc *TrackerClient) tripPoints(t *Trip) data.Points {
+	return data.Points{
+		{Type: data.PointTypeMoving, Value: data.BoolToFloat(t.InProgress)},
+		{Type: data.PointTypeTripDistance, Value: t.Distance},
+		{Type: data.PointTypeTripDuration, Value: t.Duration},
+		{Type: data.PointTypeTripCount, Value: float64(tc.config.TripCount)},
+		{Type: data.PointTypeDistanceTotal, Value: tc.config.DistanceTotal + t.Distance},
+	}
+}
+
+// ended stores a trip that ended and sends its summary
+func (tc *TrackerClient) ended(t *Trip) {
+	pts := tc.tripPoints(t)
+	tc.config.DistanceTotal += t.Distance
+	tc.setCurrent(nil)
+
+	err := tc.store.addTrip(*t)
+	if err != nil {
+		log.Printf("Tracker %v: error storing trip: %v\n", tc.config.Description, err)
+	}
+
+	tc.sendPoints(pts)
+}
+
+// position handles a new position of the tracked node
+func (tc *TrackerClient) position(p TrackPosition) {
+	started, ended := tc.seg.add(p)
+
+	if ended != nil {
+		tc.ended(ended)
+	}
+
+	current := tc.seg.current()
+
+	if current != nil || p.Time.Sub(tc.lastStored) >= trackIdlePeriod {
+		err := tc.store.addPosition(p)
+		if err != nil {
+			log.Printf("Tracker %v: error storing position: %v\n",
+				tc.config.Description, err)
+		}
+		tc.lastStored = p.Time
+	}
+
+	if current == nil {
+		return
+	}
+
+	tc.setCurrent(current)
+
+	if started {
+		tc.config.TripCount++
+	}
+
+	tc.sendPoints(tc.tripPoints(current))
+}
+
+// parentPosition updates the position of the tracked node from parent
+// points, and returns false if the position has not changed or is not known
+func (tc *TrackerClient) parentPosition(pts data.Points) (TrackPosition, bool) {
+	var t time.Time
+	changed := false
+
+	for _, p := range pts {
+		switch p.Type {
+		case data.PointTypeLatitude:
+			tc.lat, tc.latSet = p.Value, true
+		case data.PointTypeLongitude:
+			tc.long, tc.longSet = p.Value, true
+		default:
+			continue
+		}
+
+		changed = true
+		if p.Time.After(t) {
+			t = p.Time
+		}
+	}
+
+	if !changed || !tc.latSet || !tc.longSet {
+		return TrackPosition{}, false
+	}
+
+	if t.IsZero() {
+		t = time.Now()
+	}
+
+	return TrackPosition{Time: t, Lat: tc.lat, Long: tc.long}, true
+}
+
+func (tc *TrackerClient) handleQuery(msg *nats.Msg) {
+	var q TrackQuery
+	var ret TrackResult
+
+	err := json.Unmarshal(msg.Data, &q)
+	if err == nil {
+		ret, err = tc.store.query(q.Start, q.End)
+	}
+
+	if err != nil {
+		ret.Error = err.Error()
+	} else {
+		tc.lock.Lock()
+		current := tc.current
+		tc.lock.Unlock()
+
+		if current != nil && !current.From.Time.After(q.End) {
+			ret.Trips = append(ret.Trips, *current)
+		}
+	}
+
+	d, err := json.Marshal(ret)
+	if err != nil {
+		log.Println("Tracker error encoding track query response: ", err)
+		return
+	}
+
+	err = msg.Respond(d)
+	if err != nil {
+		log.Println("Tracker error responding to track query: ", err)
+	}
+}
+
+// Start runs the main logic for this client and blocks until stopped
+func (tc *TrackerClient) Start() error {
+	log.Println("Starting tracker client: ", tc.config.Description)
+
+	sub, err := tc.nc.Subscribe(SubjectNodePoints(tc.config.Parent), func(msg *nats.Msg) {
+		points, err := data.PbDecodePoints(msg.Data)
+		if err != nil {
+			log.Println("Tracker error decoding parent points: ", err)
+			return
+		}
+
+		select {
+		case tc.parentPoints <- points:
+		case <-tc.stop:
+		}
+	})
+
+	if err != nil {
+		return fmt.Errorf("Tracker error subscribing to parent points: %v", err)
+	}
+
+	tc.setup()
+
+	querySub, err := tc.nc.Subscribe(SubjectTrackQuery(tc.config.ID), tc.handleQuery)
+	if err != nil {
+		sub.Unsubscribe()
+		return fmt.Errorf("Tracker error subscribing to track queries: %v", err)
+	}
+
+	// a trip that was in progress when the client stopped can't be
+	// continued
+	if tc.config.Moving {
+		tc.sendPoints(data.Points{{Type: data.PointTypeMoving, Value: 0}})
+	}
+
+	nodes, err := GetNode(tc.nc, tc.config.Parent, "none")
+	if err == nil && len(nodes) > 0 {
+		p, ok := tc.parentPosition(nodes[0].Points)
+		if ok && !tc.config.Disable {
+			tc.seg.add(p)
+		}
+	}
+
+	t := time.NewTicker(trackerCheckPeriod)
+
+done:
+	for {
+		select {
+		case <-tc.stop:
+			log.Println("Stopping tracker client: ", tc.config.Description)
+			break done
+		case now := <-t.C:
+			if ended := tc.seg.check(now); ended != nil {
+				tc.ended(ended)
+			}
+		case pts := <-tc.parentPoints:
+			p, ok := tc.parentPosition(pts)
+			if ok && !tc.config.Disable {
+				tc.position(p)
+			}
+		case pts := <-tc.newPoints:
+			err := data.MergePoints(pts.ID, pts.Points, &tc.config)
+			if err != nil {
+				log.Println("error merging new points: ", err)
+			}
+
+			for _, p := range pts.Points {
+				// points written by this client are ignored
+				if p.Origin == tc.config.ID {
+					continue
+				}
+
+				switch p.Type {
+				case data.PointTypeStopDistance, data.PointTypeStopDuration,
+					data.PointTypeRetentionDays, data.PointTypeDirectory,
+					data.PointTypeDisable:
+					tc.setup()
+				}
+			}
+
+		case pts := <-tc.newEdgePoints:
+			err := data.MergeEdgePoints(pts.ID, pts.Parent, pts.Points, &tc.config)
+			if err != nil {
+				log.Println("error merging new points: ", err)
+			}
+		}
+	}
+
+	// clean up
+	t.Stop()
+	sub.Unsubscribe()
+	querySub.Unsubscribe()
+	return nil
+}
+
+// Stop sends a signal to the Start function to exit
+func (tc *TrackerClient) Stop(err error) {
+	close(tc.stop)
+}
+
+// Points is called by the Manager when new points for this
+// node are received.
+func (tc *TrackerClient) Points(nodeID string, points []data.Point) {
+	tc.newPoints <- NewPoints{nodeID, "", points}
+}
+
+// EdgePoints is called by the Manager when new edge points for this
+// node are received.
+func (tc *TrackerClient) EdgePoints(nodeID, parentID string, points []data.Point) {
+	tc.newEdgePoints <- NewPoints{nodeID, parentID, points}
+}
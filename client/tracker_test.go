@@ -0,0 +1,161 @@
+package client
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// positions about 111m apart going north
+func trackPos(start time.Time, sec int, north int) TrackPosition {
+	return TrackPosition{
+		Time: start.Add(time.Duration(sec) * time.Second),
+		Lat:  45 + float64(north)*0.001,
+		Long: -93,
+	}
+}
+
+func TestTripSegmenter(t *testing.T) {
+	start := time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC)
+	ts := tripSegmenter{stopDistance: 50, stopDuration: time.Minute}
+
+	if started, ended := ts.add(trackPos(start, 0, 0)); started || ended != nil {
+		t.Fatal("first position should not start a trip")
+	}
+
+	// small moves are not a trip
+	p := trackPos(start, 10, 0)
+	p.Lat += 0.0001
+	if started, _ := ts.add(p); started {
+		t.Fatal("trip started within stop distance")
+	}
+
+	if started, _ := ts.add(trackPos(start, 20, 1)); !started {
+		t.Fatal("trip did not start")
+	}
+
+	ts.add(trackPos(start, 30, 2))
+	ts.add(trackPos(start, 40, 3))
+
+	cur := ts.current()
+	if cur == nil || !cur.InProgress {
+		t.Fatal("expected trip in progress")
+	}
+
+	if cur.Distance < 300 || cur.Distance > 350 {
+		t.Error("wrong trip distance: ", cur.Distance)
+	}
+
+	// stopped, but not for stop duration
+	if _, ended := ts.add(trackPos(start, 70, 3)); ended != nil {
+		t.Fatal("trip ended before stop duration")
+	}
+
+	if ended := ts.check(start.Add(90 * time.Second)); ended != nil {
+		t.Fatal("trip ended before stop duration")
+	}
+
+	ended := ts.check(start.Add(100 * time.Second))
+	if ended == nil {
+		t.Fatal("trip did not end")
+	}
+
+	if ended.InProgress {
+		t.Error("ended trip is in progress")
+	}
+
+	if !ended.From.Time.Equal(start.Add(10*time.Second)) ||
+		!ended.To.Time.Equal(start.Add(40*time.Second)) {
+		t.Errorf("wrong trip times: %v - %v", ended.From.Time, ended.To.Time)
+	}
+
+	if ended.Duration != 30 {
+		t.Error("wrong trip duration: ", ended.Duration)
+	}
+
+	if ts.current() != nil {
+		t.Error("trip still in progress")
+	}
+
+	// old positions are ignored
+	if started, _ := ts.add(trackPos(start, 50, 10)); started {
+		t.Error("old position started a trip")
+	}
+}
+
+func TestTrackStore(t *testing.T) {
+	dir := t.TempDir()
+	s := trackStore{dir: dir}
+
+	day1 := time.Date(2026, 5, 1, 23, 0, 0, 0, time.UTC)
+	day2 := day1.Add(2 * time.Hour)
+	day3 := day2.Add(24 * time.Hour)
+
+	for _, p := range []TrackPosition{trackPos(day1, 0, 0), trackPos(day2, 0, 1),
+		trackPos(day3, 0, 2)} {
+		if err := s.addPosition(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	trips := []Trip{
+		{From: trackPos(day1, 0, 0), To: trackPos(day1, 600, 1), Distance: 111},
+		{From: trackPos(day3, 0, 1), To: trackPos(day3, 600, 2), Distance: 111},
+	}
+
+	for _, trip := range trips {
+		if err := s.addTrip(trip); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*.jsonl"))
+	if len(files) != 4 {
+		t.Fatal("expected 3 day files and a trips file, got: ", files)
+	}
+
+	ret, err := s.query(day1.Add(time.Hour), day3.Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(ret.Positions) != 1 || !ret.Positions[0].Time.Equal(day2) {
+		t.Error("wrong positions: ", ret.Positions)
+	}
+
+	if len(ret.Trips) != 0 {
+		t.Error("wrong trips: ", ret.Trips)
+	}
+
+	ret, err = s.query(day1, day3.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(ret.Positions) != 3 || len(ret.Trips) != 2 {
+		t.Errorf("wrong query result: %+v", ret)
+	}
+
+	err = s.prune(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(s.dayFile(day1)); !os.IsNotExist(err) {
+		t.Error("oldest day was not pruned")
+	}
+
+	ret, err = s.query(day1, day3.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(ret.Positions) != 2 {
+		t.Error("wrong positions after prune: ", ret.Positions)
+	}
+
+	if len(ret.Trips) != 1 || !ret.Trips[0].From.Time.Equal(day3) {
+		t.Error("wrong trips after prune: ", ret.Trips)
+	}
+}
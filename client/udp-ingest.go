@@ -0,0 +1,428 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	coap "github.com/go-ocf/go-coap"
+	"github.com/google/uuid"
+	"github.com/nats-io/nats.go"
+	"github.com/simpleiot/simpleiot/data"
+)
+
+// UdpIngest config. A UDP ingest node listens for datagrams on port and
+// converts them to points with a payload decoder (json, keyValue, or wasm).
+// Values from each sender IP address are written to the UDP source node with
+// that address. If addSources is set, source nodes are created for new
+// senders, otherwise datagrams from unknown senders are dropped.
+type UdpIngest struct {
+	ID          string      `node:"id"`
+	Parent      string      `node:"parent"`
+	Description string      `point:"description"`
+	Port        int         `point:"port"`
+	Decoder     string      `point:"decoder"`
+	FilePath    string      `point:"filePath"`
+	AddSources  bool        `point:"addSources"`
+	Disable     bool        `point:"disable"`
+	Sources     []UdpSource `child:"udpSource"`
+}
+
+// UdpSource is a device that sends datagrams to a UDP ingest node. Decoded
+// values are published as points on the source node.
+type UdpSource struct {
+	ID          string `node:"id"`
+	Parent      string `node:"parent"`
+	Description string `point:"description"`
+	Address     string `point:"address"`
+	Disable     bool   `point:"disable"`
+}
+
+// udpMaxDatagram is the largest datagram that is read
+const udpMaxDatagram = 65535
+
+// udpMaxUnknown limits the number of unknown senders that are logged
+const udpMaxUnknown = 100
+
+// udpDecoder converts a datagram payload to points
+type udpDecoder func(payload []byte, now time.Time) (data.Points, error)
+
+// udpDecodeJSON decodes a JSON map of point type to value, in the same form
+// as CoAP payloads, for example {"temperature": 21.5, "voltage": {"1": 3.3}}
+func udpDecodeJSON(payload []byte, now time.Time) (data.Points, error) {
+	return coapPoints(payload, coap.AppJSON, now)
+}
+
+// udpDecodeKeyValue decodes type=value pairs separated by white space,
+// commas, or semicolons, for example "temperature=21.5 voltage.1=3.3". The
+// point key follows a period in the type. Values that are not numbers are
+// stored as text.
+func udpDecodeKeyValue(payload []byte, now time.Time) (data.Points, error) {
+	fields := strings.FieldsFunc(string(payload), func(r rune) bool {
+		switch r {
+		case ' ', '\t', '\r', '\n', ',', ';':
+			return true
+		}
+		return false
+	})
+
+	var ret data.Points
+
+	for _, f := range fields {
+		parts := strings.SplitN(f, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("invalid field: %v", f)
+		}
+
+		p := data.Point{Time: now, Type: parts[0]}
+
+		if i := strings.Index(p.Type, "."); i >= 0 {
+			p.Type, p.Key = p.Type[:i], p.Type[i+1:]
+		}
+
+		v, err := strconv.ParseFloat(parts[1], 64)
+		if err != nil {
+			p.Text = parts[1]
+		} else {
+			p.Value = v
+		}
+
+		ret = append(ret, p)
+	}
+
+	sort.Sort(ret)
+
+	return ret, nil
+}
+
+// udpWasmDecoder decodes payloads with a WASM module. The module must export
+// alloc(size i32) i32 and decode(ptr, len i32), and emits values by calling
+// set_value from the SIOT host API for each point in the payload.
+type udpWasmDecoder struct {
+	module *wasmModule
+	points data.Points
+	now    time.Time
+	desc   string
+}
+
+func newUdpWasmDecoder(code []byte, desc string) (*udpWasmDecoder, error) {
+	ret := &udpWasmDecoder{desc: desc}
+
+	var err error
+	ret.module, err = newWasmModule(code, ret)
+	if err != nil {
+		return nil, err
+	}
+
+	if ret.module.module.ExportedFunction("decode") == nil ||
+		ret.module.module.ExportedFunction("alloc") == nil {
+		ret.module.close()
+		return nil, errors.New("WASM decoders must export decode and alloc")
+	}
+
+	return ret, nil
+}
+
+func (uwd *udpWasmDecoder) value(typ, key string) float64 {
+	v, _ := uwd.points.Value(typ, key)
+	return v
+}
+
+func (uwd *udpWasmDecoder) setValue(typ, key string, value float64) {
+	uwd.points = append(uwd.points,
+		data.Point{Time: uwd.now, Type: typ, Key: key, Value: value})
+}
+
+func (uwd *udpWasmDecoder) setTimer(period time.Duration) {
+	// decoders only run when a datagram is received
+}
+
+func (uwd *udpWasmDecoder) log(msg string) {
+	log.Printf("UDP ingest %v: %v\n", uwd.desc, msg)
+}
+
+func (uwd *udpWasmDecoder) decode(payload []byte, now time.Time) (data.Points, error) {
+	uwd.points = nil
+	uwd.now = now
+
+	if len(payload) == 0 {
+		return nil, nil
+	}
+
+	ptr, length, err := uwd.module.writeString(string(payload))
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = uwd.module.call("decode", uint64(ptr), uint64(length))
+	if err != nil {
+		return nil, err
+	}
+
+	ret := uwd.points
+	uwd.points = nil
+	return ret, nil
+}
+
+func (uwd *udpWasmDecoder) close() {
+	uwd.module.close()
+}
+
+// udpDatagram is a datagram received by the listener
+type udpDatagram struct {
+	addr    *net.UDPAddr
+	payload []byte
+}
+
+// UdpIngestClient is a SIOT client used to receive UDP datagrams
+type UdpIngestClient struct {
+	nc            *nats.Conn
+	config        UdpIngest
+	stop          chan struct{}
+	newPoints     chan NewPoints
+	newEdgePoints chan NewPoints
+	datagrams     chan udpDatagram
+	unknown       map[string]bool
+}
+
+// NewUdpIngestClient ...
+func NewUdpIngestClient(nc *nats.Conn, config UdpIngest) Client {
+	return &UdpIngestClient{
+		nc:            nc,
+		config:        config,
+		stop:          make(chan struct{}),
+		newPoints:     make(chan NewPoints),
+		newEdgePoints: make(chan NewPoints),
+		datagrams:     make(chan udpDatagram),
+		unknown:       make(map[string]bool),
+	}
+}
+
+// read reads datagrams until the connection is closed
+func (uic *UdpIngestClient) read(conn *net.UDPConn, closed <-chan struct{}) {
+	buf := make([]byte, udpMaxDatagram)
+
+	for {
+		n, addr, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			select {
+			case <-closed:
+			default:
+				log.Printf("UDP ingest %v: read error: %v\n", uic.config.Description, err)
+			}
+			return
+		}
+
+		payload := make([]byte, n)
+		copy(payload, buf[:n])
+
+		select {
+		case uic.datagrams <- udpDatagram{addr, payload}:
+		case <-closed:
+			return
+		}
+	}
+}
+
+// source returns the source node for a sender address, and creates one if
+// addSources is set
+func (uic *UdpIngestClient) source(addr *net.UDPAddr) (UdpSource, bool) {
+	ip := addr.IP.String()
+
+	for _, s := range uic.config.Sources {
+		if s.Address == ip {
+			return s, true
+		}
+	}
+
+	if !uic.config.AddSources {
+		if !uic.unknown[ip] && len(uic.unknown) < udpMaxUnknown {
+			uic.unknown[ip] = true
+			log.Printf("UDP ingest %v: dropping datagrams from unknown sender %v\n",
+				uic.config.Description, ip)
+		}
+		return UdpSource{}, false
+	}
+
+	s := UdpSource{
+		ID:          uuid.New().String(),
+		Parent:      uic.config.ID,
+		Description: fmt.Sprintf("Source %v", ip),
+		Address:     ip,
+	}
+
+	// nodes sent without an origin do not restart this client, so the
+	// source is added to the config here
+	err := SendNodeType(uic.nc, s, "")
+	if err != nil {
+		log.Println("UDP ingest error creating source node: ", err)
+		return UdpSource{}, false
+	}
+
+	uic.config.Sources = append(uic.config.Sources, s)
+
+	return s, true
+}
+
+// Start runs the main logic for this client and blocks until stopped
+func (uic *UdpIngestClient) Start() error {
+	log.Println("Starting UDP ingest client: ", uic.config.Description)
+
+	var conn *net.UDPConn
+	var closed chan struct{}
+	var decode udpDecoder
+	var wasm *udpWasmDecoder
+
+	closeListener := func() {
+		if conn != nil {
+			close(closed)
+			conn.Close()
+			conn = nil
+		}
+		if wasm != nil {
+			wasm.close()
+			wasm = nil
+		}
+		decode = nil
+	}
+
+	listen := func() {
+		closeListener()
+
+		if uic.config.Disable {
+			log.Printf("UDP ingest %v: disabled\n", uic.config.Description)
+			return
+		}
+
+		if uic.config.Port <= 0 {
+			log.Printf("UDP ingest %v: port is not set\n", uic.config.Description)
+			return
+		}
+
+		switch uic.config.Decoder {
+		case data.PointValueJSON, "":
+			decode = udpDecodeJSON
+		case data.PointValueKeyValue:
+			decode = udpDecodeKeyValue
+		case data.PointValueWasm:
+			code, err := os.ReadFile(uic.config.FilePath)
+			if err != nil {
+				log.Printf("UDP ingest %v: error reading decoder: %v\n",
+					uic.config.Description, err)
+				return
+			}
+
+			wasm, err = newUdpWasmDecoder(code, uic.config.Description)
+			if err != nil {
+				log.Printf("UDP ingest %v: %v\n", uic.config.Description, err)
+				return
+			}
+			decode = wasm.decode
+		default:
+			log.Printf("UDP ingest %v: unknown decoder: %v\n",
+				uic.config.Description, uic.config.Decoder)
+			return
+		}
+
+		var err error
+		conn, err = net.ListenUDP("udp", &net.UDPAddr{Port: uic.config.Port})
+		if err != nil {
+			log.Printf("UDP ingest %v: error listening: %v\n", uic.config.Description, err)
+			closeListener()
+			return
+		}
+
+		closed = make(chan struct{})
+		go uic.read(conn, closed)
+	}
+
+	listen()
+
+done:
+	for {
+		select {
+		case <-uic.stop:
+			log.Println("Stopping UDP ingest client: ", uic.config.Description)
+			break done
+		case d := <-uic.datagrams:
+			if decode == nil {
+				continue
+			}
+
+			s, ok := uic.source(d.addr)
+			if !ok || s.Disable {
+				continue
+			}
+
+			points, err := decode(d.payload, time.Now())
+			if err != nil {
+				log.Printf("UDP ingest %v: error decoding datagram from %v: %v\n",
+					uic.config.Description, d.addr, err)
+				if wasm != nil {
+					// the module may have been stopped, for example if
+					// it ran too long
+					listen()
+				}
+				continue
+			}
+
+			if len(points) <= 0 {
+				continue
+			}
+
+			err = SendNodePoints(uic.nc, s.ID, points, false)
+			if err != nil {
+				log.Println("UDP ingest error sending points: ", err)
+			}
+
+		case pts := <-uic.newPoints:
+			err := data.MergePoints(pts.ID, pts.Points, &uic.config)
+			if err != nil {
+				log.Println("error merging new points: ", err)
+			}
+
+			if pts.ID == uic.config.ID {
+				for _, p := range pts.Points {
+					switch p.Type {
+					case data.PointTypePort, data.PointTypeDecoder,
+						data.PointTypeFilePath, data.PointTypeDisable:
+						listen()
+					}
+				}
+			}
+
+		case pts := <-uic.newEdgePoints:
+			err := data.MergeEdgePoints(pts.ID, pts.Parent, pts.Points, &uic.config)
+			if err != nil {
+				log.Println("error merging new points: ", err)
+			}
+		}
+	}
+
+	// clean up
+	closeListener()
+	return nil
+}
+
+// Stop sends a signal to the Start function to exit
+func (uic *UdpIngestClient) Stop(err error) {
+	close(uic.stop)
+}
+
+// Points is called by the Manager when new points for this
+// node are received.
+func (uic *UdpIngestClient) Points(nodeID string, points []data.Point) {
+	uic.newPoints <- NewPoints{nodeID, "", points}
+}
+
+// EdgePoints is called by the Manager when new edge points for this
+// node are received.
+func (uic *UdpIngestClient) EdgePoints(nodeID, parentID string, points []data.Point) {
+	uic.newEdgePoints <- NewPoints{nodeID, parentID, points}
+}
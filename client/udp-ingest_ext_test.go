@@ -0,0 +1,72 @@
+package client_test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/simpleiot/simpleiot/client"
+	"github.com/simpleiot/simpleiot/data"
+	"github.com/simpleiot/simpleiot/server"
+)
+
+func TestUdpIngest(t *testing.T) {
+	nc, root, stop, err := server.TestServer()
+	if err != nil {
+		t.Fatal("Error starting test server: ", err)
+	}
+	defer stop()
+
+	port := freeUDPPort(t)
+
+	ui := client.UdpIngest{ID: "udp", Parent: root.ID, Description: "udp",
+		Port: port, Decoder: data.PointValueKeyValue, AddSources: true}
+
+	if err := client.SendNodeType(nc, ui, "test"); err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := net.DialUDP("udp", nil, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1),
+		Port: port})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// the test server runs the built-in clients, so datagrams are sent
+	// until the client is listening and the source node is created
+	for i := 0; i < 50; i++ {
+		// writes fail with connection refused until the port is open
+		_, _ = conn.Write([]byte("temperature=21.5"))
+
+		time.Sleep(100 * time.Millisecond)
+
+		sources, err := client.GetNodeChildrenType[client.UdpSource](nc, ui.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(sources) < 1 {
+			continue
+		}
+
+		if len(sources) > 1 {
+			t.Fatal("expected one source, got: ", len(sources))
+		}
+
+		if sources[0].Address != "127.0.0.1" {
+			t.Error("wrong source address: ", sources[0].Address)
+		}
+
+		nodes, err := client.GetNode(nc, sources[0].ID, ui.ID)
+		if err != nil || len(nodes) < 1 {
+			t.Fatal("error getting source node: ", err)
+		}
+
+		if v, _ := nodes[0].Points.Value("temperature", ""); v == 21.5 {
+			return
+		}
+	}
+
+	t.Fatal("datagram was not received")
+}
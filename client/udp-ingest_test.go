@@ -0,0 +1,94 @@
+package client
+
+import (
+	"testing"
+	"time"
+)
+
+// udpWasmTestModule is compiled from:
+//
+//	(module
+//	  (import "siot" "set_value" (func $set_value (param i32 i32 i32 i32 f64)))
+//	  (memory (export "memory") 1)
+//	  (data (i32.const 0) "value")
+//	  (func (export "alloc") (param i32) (result i32) (i32.const 1024))
+//	  (func (export "decode") (param i32 i32)
+//	    (call $set_value (i32.const 0) (i32.const 5) (i32.const 0) (i32.const 0)
+//	      (f64.convert_i32_u
+//	        (i32.or (i32.shl (i32.load8_u (local.get 0)) (i32.const 8))
+//	          (i32.load8_u offset=1 (local.get 0)))))))
+var udpWasmTestModule = []byte{
+	0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00, 0x01, 0x13, 0x03, 0x60,
+	0x05, 0x7f, 0x7f, 0x7f, 0x7f, 0x7c, 0x00, 0x60, 0x01, 0x7f, 0x01, 0x7f,
+	0x60, 0x02, 0x7f, 0x7f, 0x00, 0x02, 0x12, 0x01, 0x04, 0x73, 0x69, 0x6f,
+	0x74, 0x09, 0x73, 0x65, 0x74, 0x5f, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x00,
+	0x00, 0x03, 0x03, 0x02, 0x01, 0x02, 0x05, 0x03, 0x01, 0x00, 0x01, 0x07,
+	0x1b, 0x03, 0x06, 0x6d, 0x65, 0x6d, 0x6f, 0x72, 0x79, 0x02, 0x00, 0x05,
+	0x61, 0x6c, 0x6c, 0x6f, 0x63, 0x00, 0x01, 0x06, 0x64, 0x65, 0x63, 0x6f,
+	0x64, 0x65, 0x00, 0x02, 0x0a, 0x23, 0x02, 0x05, 0x00, 0x41, 0x80, 0x08,
+	0x0b, 0x1b, 0x00, 0x41, 0x00, 0x41, 0x05, 0x41, 0x00, 0x41, 0x00, 0x20,
+	0x00, 0x2d, 0x00, 0x00, 0x41, 0x08, 0x74, 0x20, 0x00, 0x2d, 0x00, 0x01,
+	0x72, 0xb8, 0x10, 0x00, 0x0b, 0x0b, 0x0b, 0x01, 0x00, 0x41, 0x00, 0x0b,
+	0x05, 0x76, 0x61, 0x6c, 0x75, 0x65,
+}
+
+func TestUdpDecodeKeyValue(t *testing.T) {
+	pts, err := udpDecodeKeyValue([]byte("temperature=21.5, voltage.1=3.3;state=on\n"),
+		time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if v, _ := pts.Value("temperature", ""); v != 21.5 {
+		t.Error("wrong temperature: ", v)
+	}
+
+	if v, _ := pts.Value("voltage", "1"); v != 3.3 {
+		t.Error("wrong voltage: ", v)
+	}
+
+	if s, _ := pts.Text("state", ""); s != "on" {
+		t.Error("wrong state: ", s)
+	}
+
+	if _, err := udpDecodeKeyValue([]byte("temperature"), time.Now()); err == nil {
+		t.Error("expected error for field without a value")
+	}
+}
+
+func TestUdpDecodeJSON(t *testing.T) {
+	pts, err := udpDecodeJSON([]byte(`{"temperature": 21.5, "voltage": {"2": 5}}`),
+		time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if v, _ := pts.Value("temperature", ""); v != 21.5 {
+		t.Error("wrong temperature: ", v)
+	}
+
+	if v, _ := pts.Value("voltage", "2"); v != 5 {
+		t.Error("wrong voltage: ", v)
+	}
+}
+
+func TestUdpWasmDecoder(t *testing.T) {
+	d, err := newUdpWasmDecoder(udpWasmTestModule, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.close()
+
+	pts, err := d.decode([]byte{0x01, 0x02}, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(pts) != 1 || pts[0].Type != "value" || pts[0].Value != 0x0102 {
+		t.Fatal("wrong points: ", pts)
+	}
+
+	if _, err := newUdpWasmDecoder(wasmSpinModule, "test"); err == nil {
+		t.Error("modules without decode should not load")
+	}
+}
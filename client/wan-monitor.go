@@ -0,0 +1,266 @@
+package client
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/simpleiot/simpleiot/data"
+)
+
+// WanMonitor config. A WAN monitor node measures the quality of the internet
+// connection every samplePeriod seconds (default 300) by pinging host
+// (default 1.1.1.1) pingCount times (default 10). If speedTestURL is set, a
+// file is downloaded from it to measure throughput. Results are published
+// as points on the WAN monitor node.
+type WanMonitor struct {
+	ID           string  `node:"id"`
+	Parent       string  `node:"parent"`
+	Description  string  `point:"description"`
+	Host         string  `point:"host"`
+	SamplePeriod float64 `point:"samplePeriod"`
+	PingCount    int     `point:"pingCount"`
+	SpeedTestURL string  `point:"speedTestURL"`
+	Disable      bool    `point:"disable"`
+}
+
+// WAN monitor limits
+const (
+	wanPingTimeout = 2 * time.Second
+	// downloads are stopped after this time, and throughput is computed
+	// from the bytes received so far
+	wanSpeedTestTime = 20 * time.Second
+)
+
+// wanJitter returns the mean difference between consecutive round trip
+// times (see RFC 3550)
+func wanJitter(rtts []time.Duration) time.Duration {
+	if len(rtts) < 2 {
+		return 0
+	}
+
+	var sum time.Duration
+	for i := 1; i < len(rtts); i++ {
+		d := rtts[i] - rtts[i-1]
+		if d < 0 {
+			d = -d
+		}
+		sum += d
+	}
+
+	return sum / time.Duration(len(rtts)-1)
+}
+
+// wanSpeedTest downloads url and returns the throughput in Mbit/s
+func wanSpeedTest(url string, maxTime time.Duration) (float64, error) {
+	client := http.Client{Timeout: maxTime}
+
+	start := time.Now()
+
+	resp, err := client.Get(url)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("speed test server returned: %v", resp.Status)
+	}
+
+	n, err := io.Copy(io.Discard, resp.Body)
+	elapsed := time.Since(start)
+
+	// the client timeout stops long downloads, which is not an error if
+	// data was received
+	if err != nil && n <= 0 {
+		return 0, err
+	}
+
+	if elapsed <= 0 {
+		return 0, nil
+	}
+
+	return float64(n) * 8 / elapsed.Seconds() / 1e6, nil
+}
+
+// wanPoints runs the WAN measurements in config
+func wanPoints(config WanMonitor, now time.Time) (data.Points, error) {
+	host := config.Host
+	if host == "" {
+		host = "1.1.1.1"
+	}
+
+	count := config.PingCount
+	if count <= 0 {
+		count = 10
+	}
+
+	rtts, err := netPing(host, count, 200*time.Millisecond, wanPingTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := data.Points{
+		{Time: now, Type: data.PointTypePacketLoss,
+			Value: 100 * float64(count-len(rtts)) / float64(count)},
+	}
+
+	if len(rtts) > 0 {
+		var sum time.Duration
+		for _, r := range rtts {
+			sum += r
+		}
+
+		ret = append(ret,
+			data.Point{Time: now, Type: data.PointTypeLatency,
+				Value: durationMs(sum / time.Duration(len(rtts)))},
+			data.Point{Time: now, Type: data.PointTypeJitter,
+				Value: durationMs(wanJitter(rtts))})
+	}
+
+	if config.SpeedTestURL != "" {
+		speed, err := wanSpeedTest(config.SpeedTestURL, wanSpeedTestTime)
+		if err != nil {
+			log.Printf("WAN monitor %v: speed test error: %v\n", config.Description, err)
+		} else {
+			ret = append(ret, data.Point{Time: now, Type: data.PointTypeDownloadSpeed,
+				Value: speed})
+		}
+	}
+
+	return ret, nil
+}
+
+// WanMonitorClient is a SIOT client used to measure WAN quality
+type WanMonitorClient struct {
+	nc            *nats.Conn
+	config        WanMonitor
+	stop          chan struct{}
+	newPoints     chan NewPoints
+	newEdgePoints chan NewPoints
+}
+
+// NewWanMonitorClient ...
+func NewWanMonitorClient(nc *nats.Conn, config WanMonitor) Client {
+	return &WanMonitorClient{
+		nc:            nc,
+		config:        config,
+		stop:          make(chan struct{}),
+		newPoints:     make(chan NewPoints),
+		newEdgePoints: make(chan NewPoints),
+	}
+}
+
+// Start runs the main logic for this client and blocks until stopped
+func (wmc *WanMonitorClient) Start() error {
+	log.Println("Starting WAN monitor client: ", wmc.config.Description)
+
+	t := time.NewTicker(time.Hour)
+	t.Stop()
+
+	// measuring is set while a measurement is running
+	var measuring chan struct{}
+
+	measure := func() {
+		if measuring != nil {
+			select {
+			case <-measuring:
+			default:
+				// the last measurement is still running
+				return
+			}
+		}
+
+		config := wmc.config
+
+		measuring = make(chan struct{})
+		go func(done chan struct{}) {
+			defer close(done)
+
+			pts, err := wanPoints(config, time.Now())
+			if err != nil {
+				log.Printf("WAN monitor %v: %v\n", config.Description, err)
+				return
+			}
+
+			err = SendNodePoints(wmc.nc, config.ID, pts, false)
+			if err != nil {
+				log.Println("WAN monitor error sending points: ", err)
+			}
+		}(measuring)
+	}
+
+	setup := func() {
+		t.Stop()
+
+		if wmc.config.Disable {
+			log.Printf("WAN monitor %v: disabled\n", wmc.config.Description)
+			return
+		}
+
+		period := wmc.config.SamplePeriod
+		if period <= 0 {
+			period = 300
+		}
+
+		t.Reset(time.Duration(period * float64(time.Second)))
+		measure()
+	}
+
+	setup()
+
+done:
+	for {
+		select {
+		case <-wmc.stop:
+			log.Println("Stopping WAN monitor client: ", wmc.config.Description)
+			break done
+		case <-t.C:
+			measure()
+		case pts := <-wmc.newPoints:
+			err := data.MergePoints(pts.ID, pts.Points, &wmc.config)
+			if err != nil {
+				log.Println("error merging new points: ", err)
+			}
+
+			for _, p := range pts.Points {
+				switch p.Type {
+				case data.PointTypeSamplePeriod, data.PointTypeDisable:
+					setup()
+				}
+			}
+
+		case pts := <-wmc.newEdgePoints:
+			err := data.MergeEdgePoints(pts.ID, pts.Parent, pts.Points, &wmc.config)
+			if err != nil {
+				log.Println("error merging new points: ", err)
+			}
+		}
+	}
+
+	// clean up
+	// a running measurement can take a while and is not waited for, it
+	// only sends points when done
+	t.Stop()
+	return nil
+}
+
+// Stop sends a signal to the Start function to exit
+func (wmc *WanMonitorClient) Stop(err error) {
+	close(wmc.stop)
+}
+
+// Points is called by the Manager when new points for this
+// node are received.
+func (wmc *WanMonitorClient) Points(nodeID string, points []data.Point) {
+	wmc.newPoints <- NewPoints{nodeID, "", points}
+}
+
+// EdgePoints is called by the Manager when new edge points for this
+// node are received.
+func (wmc *WanMonitorClient) EdgePoints(nodeID, parentID string, points []data.Point) {
+	wmc.newEdgePoints <- NewPoints{nodeID, parentID, points}
+}
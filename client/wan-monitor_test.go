@@ -0,0 +1,44 @@
+package client
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestWanJitter(t *testing.T) {
+	rtts := []time.Duration{10 * time.Millisecond, 14 * time.Millisecond,
+		12 * time.Millisecond}
+
+	if j := wanJitter(rtts); j != 3*time.Millisecond {
+		t.Error("wrong jitter: ", j)
+	}
+
+	if j := wanJitter(rtts[:1]); j != 0 {
+		t.Error("jitter of one sample should be 0: ", j)
+	}
+}
+
+func TestWanSpeedTest(t *testing.T) {
+	payload := make([]byte, 1024*1024)
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter,
+		r *http.Request) {
+		_, _ = w.Write(payload)
+	}))
+	defer s.Close()
+
+	speed, err := wanSpeedTest(s.URL, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if speed <= 0 {
+		t.Error("speed should be positive: ", speed)
+	}
+
+	if _, err := wanSpeedTest(s.URL+"/missing\x7f", time.Second); err == nil {
+		t.Error("expected error for invalid URL")
+	}
+}
@@ -0,0 +1,435 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/simpleiot/simpleiot/data"
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+)
+
+// wasmCallTimeout is the max time a WASM module function can run before it
+// is stopped
+var wasmCallTimeout = time.Second
+
+// wasmMemoryLimitPages limits WASM module memory to 16MB (64KB pages)
+const wasmMemoryLimitPages = 256
+
+// WasmProcessor config. A WASM processor node loads a user supplied WASM
+// module from its file child node and runs it as a point processor for its
+// parent node. The module has no file system or network access, and can
+// only read and write points on the parent node through the host API
+// described in docs/user/wasm.md.
+type WasmProcessor struct {
+	ID          string `node:"id"`
+	Parent      string `node:"parent"`
+	Description string `point:"description"`
+	Disable     bool   `point:"disable"`
+	Files       []File `child:"file"`
+}
+
+// wasmHost is the API a WASM module can use to interact with SIOT
+type wasmHost interface {
+	value(typ, key string) float64
+	setValue(typ, key string, value float64)
+	setTimer(period time.Duration)
+	log(msg string)
+}
+
+// wasmModule is an instantiated WASM point processor module
+type wasmModule struct {
+	runtime wazero.Runtime
+	module  api.Module
+	host    wasmHost
+}
+
+// newWasmModule compiles and instantiates a WASM module. The optional
+// exported functions are:
+//   - on_start(): called after the module is loaded
+//   - on_point(typPtr, typLen, keyPtr, keyLen i32, value f64): called for
+//     each point on the parent node. Modules that export on_point must also
+//     export alloc(size i32) i32, which is used to pass strings to the module.
+//   - on_timer(): called every timer period (see set_timer)
+func newWasmModule(code []byte, host wasmHost) (*wasmModule, error) {
+	ctx := context.Background()
+
+	r := wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfig().
+		WithMemoryLimitPages(wasmMemoryLimitPages).
+		WithCloseOnContextDone(true))
+
+	ret := &wasmModule{runtime: r, host: host}
+
+	readString := func(m api.Module, ptr, length uint32) string {
+		b, ok := m.Memory().Read(ptr, length)
+		if !ok {
+			return ""
+		}
+		return string(b)
+	}
+
+	_, err := r.NewHostModuleBuilder("siot").
+		NewFunctionBuilder().
+		WithFunc(func(ctx context.Context, m api.Module, ptr, length uint32) {
+			host.log(readString(m, ptr, length))
+		}).Export("log").
+		NewFunctionBuilder().
+		WithFunc(func(ctx context.Context, m api.Module, typPtr, typLen,
+			keyPtr, keyLen uint32) float64 {
+			return host.value(readString(m, typPtr, typLen),
+				readString(m, keyPtr, keyLen))
+		}).Export("get_value").
+		NewFunctionBuilder().
+		WithFunc(func(ctx context.Context, m api.Module, typPtr, typLen,
+			keyPtr, keyLen uint32, value float64) {
+			host.setValue(readString(m, typPtr, typLen),
+				readString(m, keyPtr, keyLen), value)
+		}).Export("set_value").
+		NewFunctionBuilder().
+		WithFunc(func(ctx context.Context, ms uint32) {
+			host.setTimer(time.Duration(ms) * time.Millisecond)
+		}).Export("set_timer").
+		Instantiate(ctx)
+
+	if err != nil {
+		r.Close(ctx)
+		return nil, fmt.Errorf("Error creating WASM host module: %v", err)
+	}
+
+	ret.module, err = r.Instantiate(ctx, code)
+	if err != nil {
+		r.Close(ctx)
+		return nil, fmt.Errorf("Error loading WASM module: %v", err)
+	}
+
+	if ret.module.ExportedFunction("on_point") != nil &&
+		ret.module.ExportedFunction("alloc") == nil {
+		ret.close()
+		return nil, errors.New("WASM modules that export on_point must export alloc")
+	}
+
+	return ret, nil
+}
+
+// call calls an exported function if the module exports it
+func (wm *wasmModule) call(name string, params ...uint64) ([]uint64, error) {
+	fn := wm.module.ExportedFunction(name)
+	if fn == nil {
+		return nil, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), wasmCallTimeout)
+	defer cancel()
+
+	ret, err := fn.Call(ctx, params...)
+	if err != nil {
+		return nil, fmt.Errorf("Error calling WASM %v: %v", name, err)
+	}
+
+	return ret, nil
+}
+
+// writeString copies a string into module memory
+func (wm *wasmModule) writeString(s string) (uint32, uint32, error) {
+	if s == "" {
+		return 0, 0, nil
+	}
+
+	ret, err := wm.call("alloc", uint64(len(s)))
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if len(ret) != 1 {
+		return 0, 0, errors.New("WASM alloc must return a pointer")
+	}
+
+	ptr := uint32(ret[0])
+
+	if !wm.module.Memory().Write(ptr, []byte(s)) {
+		return 0, 0, errors.New("WASM alloc returned an invalid pointer")
+	}
+
+	return ptr, uint32(len(s)), nil
+}
+
+func (wm *wasmModule) start() error {
+	_, err := wm.call("on_start")
+	return err
+}
+
+func (wm *wasmModule) point(p data.Point) error {
+	if wm.module.ExportedFunction("on_point") == nil {
+		return nil
+	}
+
+	typPtr, typLen, err := wm.writeString(p.Type)
+	if err != nil {
+		return err
+	}
+
+	keyPtr, keyLen, err := wm.writeString(p.Key)
+	if err != nil {
+		return err
+	}
+
+	_, err = wm.call("on_point", uint64(typPtr), uint64(typLen), uint64(keyPtr),
+		uint64(keyLen), api.EncodeF64(p.Value))
+	return err
+}
+
+func (wm *wasmModule) timer() error {
+	_, err := wm.call("on_timer")
+	return err
+}
+
+func (wm *wasmModule) close() {
+	wm.runtime.Close(context.Background())
+}
+
+// WasmProcessorClient runs WASM processor nodes
+type WasmProcessorClient struct {
+	nc            *nats.Conn
+	config        WasmProcessor
+	stop          chan struct{}
+	newPoints     chan NewPoints
+	newEdgePoints chan NewPoints
+	parentPoints  chan []data.Point
+	timerPeriod   chan time.Duration
+
+	lock sync.Mutex
+	// points of the parent node, used by get_value
+	current data.Points
+	// subscription to the parent points
+	sub *nats.Subscription
+}
+
+// NewWasmProcessorClient ...
+func NewWasmProcessorClient(nc *nats.Conn, config WasmProcessor) Client {
+	return &WasmProcessorClient{
+		nc:            nc,
+		config:        config,
+		stop:          make(chan struct{}),
+		newPoints:     make(chan NewPoints),
+		newEdgePoints: make(chan NewPoints),
+		parentPoints:  make(chan []data.Point),
+		// module calls run in the Start loop, so this is buffered so
+		// set_timer does not block
+		timerPeriod: make(chan time.Duration, 1),
+	}
+}
+
+func (wpc *WasmProcessorClient) value(typ, key string) float64 {
+	wpc.lock.Lock()
+	defer wpc.lock.Unlock()
+	v, _ := wpc.current.Value(typ, key)
+	return v
+}
+
+func (wpc *WasmProcessorClient) setValue(typ, key string, value float64) {
+	p := data.Point{Time: time.Now(), Type: typ, Key: key, Value: value,
+		Origin: wpc.config.ID}
+
+	wpc.lock.Lock()
+	wpc.current.Add(p)
+	wpc.lock.Unlock()
+
+	err := SendNodePoint(wpc.nc, wpc.config.Parent, p, false)
+	if err != nil {
+		log.Printf("WASM %v: error sending point: %v\n", wpc.config.Description, err)
+	}
+}
+
+func (wpc *WasmProcessorClient) setTimer(period time.Duration) {
+	// only the latest period matters
+	select {
+	case <-wpc.timerPeriod:
+	default:
+	}
+	wpc.timerPeriod <- period
+}
+
+func (wpc *WasmProcessorClient) log(msg string) {
+	log.Printf("WASM %v: %v\n", wpc.config.Description, msg)
+}
+
+// Start runs the main logic for this client and blocks until stopped
+func (wpc *WasmProcessorClient) Start() error {
+	log.Println("Starting WASM processor client: ", wpc.config.Description)
+
+	sub, err := wpc.nc.Subscribe(SubjectNodePoints(wpc.config.Parent), func(msg *nats.Msg) {
+		points, err := data.PbDecodePoints(msg.Data)
+		if err != nil {
+			log.Println("WASM error decoding parent points: ", err)
+			return
+		}
+
+		select {
+		case wpc.parentPoints <- points:
+		case <-wpc.stop:
+		}
+	})
+
+	if err != nil {
+		return fmt.Errorf("WASM error subscribing to parent points: %v", err)
+	}
+
+	wpc.lock.Lock()
+	wpc.sub = sub
+	wpc.lock.Unlock()
+
+	var module *wasmModule
+
+	t := time.NewTicker(time.Hour)
+	t.Stop()
+
+	closeModule := func() {
+		t.Stop()
+		if module != nil {
+			module.close()
+			module = nil
+		}
+	}
+
+	load := func() {
+		closeModule()
+
+		if wpc.config.Disable || len(wpc.config.Files) < 1 {
+			return
+		}
+
+		if len(wpc.config.Files) > 1 {
+			log.Printf("WASM %v: more than one file, loading %v\n",
+				wpc.config.Description, wpc.config.Files[0].Name)
+		}
+
+		nodes, err := GetNode(wpc.nc, wpc.config.Parent, "none")
+		if err == nil && len(nodes) > 0 {
+			wpc.lock.Lock()
+			wpc.current = nodes[0].Points
+			wpc.lock.Unlock()
+		}
+
+		code, err := wpc.config.Files[0].Contents()
+		if err != nil {
+			log.Printf("WASM %v: error decoding module: %v\n", wpc.config.Description, err)
+			return
+		}
+
+		module, err = newWasmModule(code, wpc)
+		if err != nil {
+			log.Printf("WASM %v: %v\n", wpc.config.Description, err)
+			return
+		}
+
+		err = module.start()
+		if err != nil {
+			log.Printf("WASM %v: %v\n", wpc.config.Description, err)
+		}
+	}
+
+	// run calls a module function. Modules that fail, for
+	// example because they ran too long, are unloaded.
+	run := func(f func() error) {
+		if module == nil {
+			return
+		}
+
+		err := f()
+		if err != nil {
+			log.Printf("WASM %v: %v, unloading module\n", wpc.config.Description, err)
+			closeModule()
+		}
+	}
+
+	load()
+
+done:
+	for {
+		select {
+		case <-wpc.stop:
+			log.Println("Stopping WASM processor client: ", wpc.config.Description)
+			break done
+		case period := <-wpc.timerPeriod:
+			t.Stop()
+			if period > 0 && module != nil {
+				t.Reset(period)
+			}
+		case <-t.C:
+			run(module.timer)
+		case pts := <-wpc.parentPoints:
+			wpc.lock.Lock()
+			for _, p := range pts {
+				wpc.current.Add(p)
+			}
+			wpc.lock.Unlock()
+
+			for _, p := range pts {
+				// skip points written by this module
+				if p.Origin == wpc.config.ID {
+					continue
+				}
+				run(func() error { return module.point(p) })
+			}
+		case pts := <-wpc.newPoints:
+			err := data.MergePoints(pts.ID, pts.Points, &wpc.config)
+			if err != nil {
+				log.Println("error merging new points: ", err)
+			}
+
+			// the module is reloaded when it is disabled or the file
+			// changes
+			reload := false
+			for _, p := range pts.Points {
+				switch p.Type {
+				case data.PointTypeData, data.PointTypeBinary, data.PointTypeDisable:
+					reload = true
+				}
+			}
+
+			if reload {
+				load()
+			}
+
+		case pts := <-wpc.newEdgePoints:
+			err := data.MergeEdgePoints(pts.ID, pts.Parent, pts.Points, &wpc.config)
+			if err != nil {
+				log.Println("error merging new points: ", err)
+			}
+		}
+	}
+
+	// clean up
+	closeModule()
+	sub.Unsubscribe()
+	return nil
+}
+
+// Stop sends a signal to the Start function to exit
+func (wpc *WasmProcessorClient) Stop(err error) {
+	close(wpc.stop)
+
+	// parent points are not handled once Start exits
+	wpc.lock.Lock()
+	if wpc.sub != nil {
+		wpc.sub.Unsubscribe()
+	}
+	wpc.lock.Unlock()
+}
+
+// Points is called by the Manager when new points for this
+// node are received.
+func (wpc *WasmProcessorClient) Points(nodeID string, points []data.Point) {
+	wpc.newPoints <- NewPoints{nodeID, "", points}
+}
+
+// EdgePoints is called by the Manager when new edge points for this
+// node are received.
+func (wpc *WasmProcessorClient) EdgePoints(nodeID, parentID string, points []data.Point) {
+	wpc.newEdgePoints <- NewPoints{nodeID, parentID, points}
+}
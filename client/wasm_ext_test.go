@@ -0,0 +1,53 @@
+package client_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/simpleiot/simpleiot/client"
+	"github.com/simpleiot/simpleiot/data"
+	"github.com/simpleiot/simpleiot/server"
+)
+
+func TestWasmProcessor(t *testing.T) {
+	nc, root, stop, err := server.TestServer()
+	if err != nil {
+		t.Fatal("Error starting test server: ", err)
+	}
+	defer stop()
+
+	// the client manager runs processors that are children of the root
+	// node, so the module processes root points
+	wp := client.WasmProcessor{ID: "wasm", Parent: root.ID, Description: "wasm"}
+	if err := client.SendNodeType(nc, wp, "test"); err != nil {
+		t.Fatal(err)
+	}
+
+	module := client.NewFile("module", wp.ID, "module.wasm", client.WasmTestModule, true)
+	if err := client.SendNodeType(nc, module, "test"); err != nil {
+		t.Fatal(err)
+	}
+
+	// the value is sent until the client has loaded the module and written
+	// the double point
+	for i := 0; i < 50; i++ {
+		err := client.SendNodePoint(nc, root.ID, data.Point{Type: data.PointTypeValue,
+			Value: 21, Origin: "test"}, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		time.Sleep(100 * time.Millisecond)
+
+		nodes, err := client.GetNode(nc, root.ID, "none")
+		if err != nil || len(nodes) < 1 {
+			t.Fatal("error getting node: ", err)
+		}
+
+		if d, _ := nodes[0].Points.Value("double", ""); d == 42 {
+			return
+		}
+	}
+
+	t.Fatal("module did not process points")
+}
@@ -0,0 +1,151 @@
+package client
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/simpleiot/simpleiot/data"
+)
+
+// wasmTestModule is compiled from:
+//
+//	(module
+//	  (import "siot" "set_value" (func $set_value (param i32 i32 i32 i32 f64)))
+//	  (import "siot" "get_value" (func $get_value (param i32 i32 i32 i32) (result f64)))
+//	  (import "siot" "set_timer" (func $set_timer (param i32)))
+//	  (memory (export "memory") 1)
+//	  (data (i32.const 0) "double")
+//	  (data (i32.const 16) "count")
+//	  (func (export "alloc") (param i32) (result i32) (i32.const 1024))
+//	  (func (export "on_point") (param i32 i32 i32 i32 f64)
+//	    (call $set_value (i32.const 0) (i32.const 6) (i32.const 0) (i32.const 0)
+//	      (f64.mul (local.get 4) (f64.const 2))))
+//	  (func (export "on_timer")
+//	    (call $set_value (i32.const 16) (i32.const 5) (i32.const 0) (i32.const 0)
+//	      (f64.add (call $get_value (i32.const 16) (i32.const 5) (i32.const 0) (i32.const 0))
+//	        (f64.const 1))))
+//	  (func (export "on_start") (call $set_timer (i32.const 10))))
+var wasmTestModule = []byte{
+	0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00, 0x01, 0x25, 0x06, 0x60,
+	0x05, 0x7f, 0x7f, 0x7f, 0x7f, 0x7c, 0x00, 0x60, 0x04, 0x7f, 0x7f, 0x7f,
+	0x7f, 0x01, 0x7c, 0x60, 0x01, 0x7f, 0x00, 0x60, 0x01, 0x7f, 0x01, 0x7f,
+	0x60, 0x05, 0x7f, 0x7f, 0x7f, 0x7f, 0x7c, 0x00, 0x60, 0x00, 0x00, 0x02,
+	0x34, 0x03, 0x04, 0x73, 0x69, 0x6f, 0x74, 0x09, 0x73, 0x65, 0x74, 0x5f,
+	0x76, 0x61, 0x6c, 0x75, 0x65, 0x00, 0x00, 0x04, 0x73, 0x69, 0x6f, 0x74,
+	0x09, 0x67, 0x65, 0x74, 0x5f, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x00, 0x01,
+	0x04, 0x73, 0x69, 0x6f, 0x74, 0x09, 0x73, 0x65, 0x74, 0x5f, 0x74, 0x69,
+	0x6d, 0x65, 0x72, 0x00, 0x02, 0x03, 0x05, 0x04, 0x03, 0x04, 0x05, 0x05,
+	0x05, 0x03, 0x01, 0x00, 0x01, 0x07, 0x33, 0x05, 0x06, 0x6d, 0x65, 0x6d,
+	0x6f, 0x72, 0x79, 0x02, 0x00, 0x05, 0x61, 0x6c, 0x6c, 0x6f, 0x63, 0x00,
+	0x03, 0x08, 0x6f, 0x6e, 0x5f, 0x70, 0x6f, 0x69, 0x6e, 0x74, 0x00, 0x04,
+	0x08, 0x6f, 0x6e, 0x5f, 0x74, 0x69, 0x6d, 0x65, 0x72, 0x00, 0x05, 0x08,
+	0x6f, 0x6e, 0x5f, 0x73, 0x74, 0x61, 0x72, 0x74, 0x00, 0x06, 0x0a, 0x48,
+	0x04, 0x05, 0x00, 0x41, 0x80, 0x08, 0x0b, 0x18, 0x00, 0x41, 0x00, 0x41,
+	0x06, 0x41, 0x00, 0x41, 0x00, 0x20, 0x04, 0x44, 0x00, 0x00, 0x00, 0x00,
+	0x00, 0x00, 0x00, 0x40, 0xa2, 0x10, 0x00, 0x0b, 0x20, 0x00, 0x41, 0x10,
+	0x41, 0x05, 0x41, 0x00, 0x41, 0x00, 0x41, 0x10, 0x41, 0x05, 0x41, 0x00,
+	0x41, 0x00, 0x10, 0x01, 0x44, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xf0,
+	0x3f, 0xa0, 0x10, 0x00, 0x0b, 0x06, 0x00, 0x41, 0x0a, 0x10, 0x02, 0x0b,
+	0x0b, 0x16, 0x02, 0x00, 0x41, 0x00, 0x0b, 0x06, 0x64, 0x6f, 0x75, 0x62,
+	0x6c, 0x65, 0x00, 0x41, 0x10, 0x0b, 0x05, 0x63, 0x6f, 0x75, 0x6e, 0x74,
+}
+
+// wasmSpinModule is compiled from:
+//
+//	(module (func (export "on_start") (loop (br 0))))
+var wasmSpinModule = []byte{
+	0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00, 0x01, 0x04, 0x01, 0x60,
+	0x00, 0x00, 0x03, 0x02, 0x01, 0x00, 0x07, 0x0c, 0x01, 0x08, 0x6f, 0x6e,
+	0x5f, 0x73, 0x74, 0x61, 0x72, 0x74, 0x00, 0x00, 0x0a, 0x09, 0x01, 0x07,
+	0x00, 0x03, 0x40, 0x0c, 0x00, 0x0b, 0x0b,
+}
+
+type wasmTestHost struct {
+	points data.Points
+	period time.Duration
+	logs   []string
+}
+
+func (h *wasmTestHost) value(typ, key string) float64 {
+	v, _ := h.points.Value(typ, key)
+	return v
+}
+
+func (h *wasmTestHost) setValue(typ, key string, value float64) {
+	h.points.Add(data.Point{Time: time.Now(), Type: typ, Key: key, Value: value})
+}
+
+func (h *wasmTestHost) setTimer(period time.Duration) {
+	h.period = period
+}
+
+func (h *wasmTestHost) log(msg string) {
+	h.logs = append(h.logs, msg)
+}
+
+func TestWasmModule(t *testing.T) {
+	host := &wasmTestHost{}
+
+	m, err := newWasmModule(wasmTestModule, host)
+	if err != nil {
+		t.Fatal("Error loading module: ", err)
+	}
+	defer m.close()
+
+	err = m.start()
+	if err != nil {
+		t.Fatal("start error: ", err)
+	}
+
+	if host.period != 10*time.Millisecond {
+		t.Error("timer period not set, got: ", host.period)
+	}
+
+	err = m.point(data.Point{Type: data.PointTypeValue, Value: 21})
+	if err != nil {
+		t.Fatal("point error: ", err)
+	}
+
+	if v, _ := host.points.Value("double", ""); v != 42 {
+		t.Error("expected double to be 42, got: ", v)
+	}
+
+	for i := 0; i < 3; i++ {
+		if err := m.timer(); err != nil {
+			t.Fatal("timer error: ", err)
+		}
+	}
+
+	if v, _ := host.points.Value("count", ""); v != 3 {
+		t.Error("expected count to be 3, got: ", v)
+	}
+}
+
+func TestWasmModuleTimeout(t *testing.T) {
+	defer func(d time.Duration) { wasmCallTimeout = d }(wasmCallTimeout)
+	wasmCallTimeout = 50 * time.Millisecond
+
+	m, err := newWasmModule(wasmSpinModule, &wasmTestHost{})
+	if err != nil {
+		t.Fatal("Error loading module: ", err)
+	}
+	defer m.close()
+
+	start := time.Now()
+	err = m.start()
+	if err == nil {
+		t.Fatal("expected timeout error")
+	}
+
+	if time.Since(start) > time.Second {
+		t.Error("module was not stopped at timeout")
+	}
+}
+
+func TestWasmModuleInvalid(t *testing.T) {
+	_, err := newWasmModule([]byte("not wasm"), &wasmTestHost{})
+	if err == nil || !strings.Contains(err.Error(), "WASM") {
+		t.Error("expected error loading invalid module, got: ", err)
+	}
+}
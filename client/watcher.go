@@ -0,0 +1,214 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"reflect"
+	"strings"
+
+	"github.com/nats-io/nats.go"
+	"github.com/simpleiot/simpleiot/data"
+)
+
+// WatchOptions are used to filter the points node watchers process
+type WatchOptions struct {
+	// PointTypes limits updates to these point types. If blank, all points
+	// are processed. The initial value of a node includes all points.
+	PointTypes []string
+}
+
+func (wo WatchOptions) filter(points data.Points) data.Points {
+	if len(wo.PointTypes) < 1 {
+		return points
+	}
+
+	var ret data.Points
+	for _, p := range points {
+		for _, t := range wo.PointTypes {
+			if p.Type == t {
+				ret = append(ret, p)
+				break
+			}
+		}
+	}
+
+	return ret
+}
+
+// ChildWatcher watches all children of a parent node of type T. get() returns
+// the current children keyed by node ID. Children that are added or deleted
+// are added to or removed from the map. Stop can be called to stop the
+// watcher.
+func ChildWatcher[T any](nc *nats.Conn, parent string, opts WatchOptions) (get func() map[string]T, stop func(), err error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	get, err = ChildWatcherCtx[T](ctx, nc, parent, opts)
+	if err != nil {
+		cancel()
+		return nil, nil, err
+	}
+
+	return get, cancel, nil
+}
+
+// ChildWatcherCtx is ChildWatcher that runs until ctx is done. A single
+// subscription is used for all children, so it scales to parents with many
+// children.
+func ChildWatcherCtx[T any](ctx context.Context, nc *nats.Conn, parent string,
+	opts WatchOptions) (get func() map[string]T, err error) {
+	var x T
+	nodeType := reflect.TypeOf(x).Name()
+	nodeType = strings.ToLower(nodeType[0:1]) + nodeType[1:]
+
+	updates := make(chan *nats.Msg)
+
+	// subscribe before fetching the children so that updates are not missed
+	sub, err := nc.Subscribe(fmt.Sprintf("up.%v.>", parent), func(msg *nats.Msg) {
+		select {
+		case updates <- msg:
+		case <-ctx.Done():
+		}
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Subscribe failed: %v", err)
+	}
+
+	fetch := func() (map[string]T, error) {
+		nodes, err := GetNodeChildrenCtx(ctx, nc, parent, nodeType, false, false)
+		if err != nil {
+			return nil, err
+		}
+
+		ret := make(map[string]T, len(nodes))
+		for _, n := range nodes {
+			var c T
+			err := data.Decode(data.NodeEdgeChildren{NodeEdge: n, Children: nil}, &c)
+			if err != nil {
+				log.Println("Error decoding node in ChildWatcher: ", err)
+				continue
+			}
+			ret[n.ID] = c
+		}
+
+		return ret, nil
+	}
+
+	current, err := fetch()
+	if err != nil {
+		sub.Unsubscribe()
+		return nil, fmt.Errorf("Error getting children: %w", err)
+	}
+
+	// refresh fetches children again after a child is added or undeleted
+	refresh := func() {
+		c, err := fetch()
+		if err != nil {
+			log.Println("ChildWatcher: error getting children: ", err)
+			return
+		}
+		current = c
+	}
+
+	process := func(msg *nats.Msg) {
+		points, err := data.PbDecodePoints(msg.Data)
+		if err != nil {
+			log.Println("ChildWatcher: error decoding points: ", err)
+			return
+		}
+
+		// up.<parent>.<id>.points or up.<parent>.<id>.<edge parent>.points
+		chunks := strings.Split(msg.Subject, ".")
+		if len(chunks) < 4 {
+			return
+		}
+		id := chunks[2]
+		c, ok := current[id]
+
+		if len(chunks) == 4 {
+			if !ok {
+				for _, p := range points {
+					if p.Type == data.PointTypeNodeType && p.Text == nodeType {
+						refresh()
+						return
+					}
+				}
+				return
+			}
+
+			points = opts.filter(points)
+			if len(points) > 0 {
+				data.MergePoints(id, points, &c)
+				current[id] = c
+			}
+			return
+		}
+
+		if chunks[3] != parent {
+			return
+		}
+
+		for _, p := range points {
+			if p.Type != data.PointTypeTombstone {
+				continue
+			}
+
+			if p.Value != 0 {
+				delete(current, id)
+				return
+			}
+
+			if !ok {
+				refresh()
+				return
+			}
+		}
+
+		if ok {
+			points = opts.filter(points)
+			if len(points) > 0 {
+				data.MergeEdgePoints(id, parent, points, &c)
+				current[id] = c
+			}
+		}
+	}
+
+	getCurrent := make(chan chan map[string]T)
+	stopped := make(chan struct{})
+	var final map[string]T
+
+	// main loop for watcher. All data access must go through the main
+	// loop to avoid race conditions.
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				sub.Unsubscribe()
+				final = current
+				close(stopped)
+				return
+			case r := <-getCurrent:
+				r <- copyMap(current)
+			case msg := <-updates:
+				process(msg)
+			}
+		}
+	}()
+
+	return func() map[string]T {
+		ret := make(chan map[string]T)
+		select {
+		case getCurrent <- ret:
+			return <-ret
+		case <-stopped:
+			return copyMap(final)
+		}
+	}, nil
+}
+
+func copyMap[T any](m map[string]T) map[string]T {
+	ret := make(map[string]T, len(m))
+	for k, v := range m {
+		ret[k] = v
+	}
+	return ret
+}
@@ -0,0 +1,101 @@
+package client_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/simpleiot/simpleiot/client"
+	"github.com/simpleiot/simpleiot/data"
+	"github.com/simpleiot/simpleiot/server"
+)
+
+func TestChildWatcher(t *testing.T) {
+	nc, root, stop, err := server.TestServer()
+	if err != nil {
+		t.Fatal("Error starting test server: ", err)
+	}
+	defer stop()
+
+	for _, id := range []string{"ID-var1", "ID-var2"} {
+		err := client.SendNodeType(nc, client.Variable{ID: id, Parent: root.ID,
+			Description: id}, "test")
+		if err != nil {
+			t.Fatal("Error sending node: ", err)
+		}
+	}
+
+	// other node types are not watched
+	err = client.SendNode(nc, data.NodeEdge{ID: "ID-other", Type: "testType",
+		Parent: root.ID}, "test")
+	if err != nil {
+		t.Fatal("Error sending node: ", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	get, err := client.ChildWatcherCtx[client.Variable](ctx, nc, root.ID,
+		client.WatchOptions{PointTypes: []string{data.PointTypeValue}})
+	if err != nil {
+		t.Fatal("Error starting watcher: ", err)
+	}
+
+	waitFor := func(desc string, f func(map[string]client.Variable) bool) {
+		start := time.Now()
+		for !f(get()) {
+			if time.Since(start) > 2*time.Second {
+				t.Fatalf("Timeout waiting for %v: %+v", desc, get())
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	cur := get()
+	if len(cur) != 2 || cur["ID-var1"].Description != "ID-var1" {
+		t.Fatal("children not correct: ", cur)
+	}
+
+	err = client.SendNodePoints(nc, "ID-var1", data.Points{
+		{Type: data.PointTypeValue, Value: 5, Origin: "test"},
+		{Type: data.PointTypeDescription, Text: "new", Origin: "test"},
+	}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	waitFor("value", func(m map[string]client.Variable) bool {
+		return m["ID-var1"].Value == 5
+	})
+
+	if get()["ID-var1"].Description != "ID-var1" {
+		t.Fatal("filtered point was merged")
+	}
+
+	err = client.SendNodeType(nc, client.Variable{ID: "ID-var3", Parent: root.ID,
+		Description: "ID-var3"}, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	waitFor("new child", func(m map[string]client.Variable) bool {
+		return m["ID-var3"].Description == "ID-var3"
+	})
+
+	err = client.DeleteNode(nc, "ID-var2", root.ID, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	waitFor("deleted child", func(m map[string]client.Variable) bool {
+		_, ok := m["ID-var2"]
+		return !ok
+	})
+
+	cancel()
+
+	time.Sleep(50 * time.Millisecond)
+	if len(get()) != 2 {
+		t.Fatal("wrong value after stop: ", get())
+	}
+}
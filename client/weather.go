@@ -0,0 +1,471 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/simpleiot/simpleiot/data"
+)
+
+// base URLs for weather providers, can be changed for testing
+var owmBaseURL = "https://api.openweathermap.org/data/2.5"
+var nwsBaseURL = "https://api.weather.gov"
+
+// weatherForecastHours are the hours ahead forecast temperature points are
+// published for
+var weatherForecastHours = []int{3, 6, 12, 24}
+
+// Weather config. A weather node fetches current conditions and a forecast
+// for a location from OpenWeatherMap (default, requires an API key) or the
+// US National Weather Service. If latitude and longitude are not set, the
+// location of the parent node is used.
+type Weather struct {
+	ID          string  `node:"id"`
+	Parent      string  `node:"parent"`
+	Description string  `point:"description"`
+	Provider    string  `point:"provider"`
+	APIKey      string  `point:"apiKey"`
+	Latitude    float64 `point:"latitude"`
+	Longitude   float64 `point:"longitude"`
+	// SamplePeriod is the time between updates in seconds. Default is
+	// 15 minutes.
+	SamplePeriod float64 `point:"samplePeriod"`
+	Disable      bool    `point:"disable"`
+}
+
+// weatherSample contains conditions for one time. Units are metric.
+type weatherSample struct {
+	time        time.Time
+	temperature float64
+	humidity    float64
+	windSpeed   float64
+	// rainProbability is the probability of precipitation in percent
+	rainProbability float64
+	// rain is precipitation in mm for the sample period
+	rain        float64
+	description string
+}
+
+// weatherReport contains current conditions and a forecast sorted by time
+type weatherReport struct {
+	current  weatherSample
+	forecast []weatherSample
+}
+
+func weatherGet(hc *http.Client, u string, v interface{}) error {
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+
+	// NWS requires a user agent
+	req.Header.Set("User-Agent", "simpleiot")
+	req.Header.Set("Accept", "application/geo+json, application/json")
+
+	resp, err := hc.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%v: %v", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+type owmConditions struct {
+	Dt   int64 `json:"dt"`
+	Main struct {
+		Temp     float64 `json:"temp"`
+		Humidity float64 `json:"humidity"`
+	} `json:"main"`
+	Wind struct {
+		Speed float64 `json:"speed"`
+	} `json:"wind"`
+	Weather []struct {
+		Description string `json:"description"`
+	} `json:"weather"`
+	Rain struct {
+		H1 float64 `json:"1h"`
+		H3 float64 `json:"3h"`
+	} `json:"rain"`
+	Pop float64 `json:"pop"`
+}
+
+func (c owmConditions) sample() weatherSample {
+	ret := weatherSample{
+		time:            time.Unix(c.Dt, 0),
+		temperature:     c.Main.Temp,
+		humidity:        c.Main.Humidity,
+		windSpeed:       c.Wind.Speed,
+		rainProbability: c.Pop * 100,
+		rain:            c.Rain.H1 + c.Rain.H3,
+	}
+
+	if len(c.Weather) > 0 {
+		ret.description = c.Weather[0].Description
+	}
+
+	return ret
+}
+
+// fetchOWM fetches weather from the OpenWeatherMap current weather and
+// 5 day/3 hour forecast APIs
+func fetchOWM(hc *http.Client, apiKey string, lat, lon float64) (weatherReport, error) {
+	var ret weatherReport
+
+	if apiKey == "" {
+		return ret, errors.New("OpenWeatherMap requires an API key")
+	}
+
+	q := url.Values{}
+	q.Set("lat", strconv.FormatFloat(lat, 'f', -1, 64))
+	q.Set("lon", strconv.FormatFloat(lon, 'f', -1, 64))
+	q.Set("appid", apiKey)
+	q.Set("units", "metric")
+
+	var current owmConditions
+	err := weatherGet(hc, owmBaseURL+"/weather?"+q.Encode(), &current)
+	if err != nil {
+		return ret, fmt.Errorf("Error getting current weather: %v", err)
+	}
+
+	ret.current = current.sample()
+
+	var forecast struct {
+		List []owmConditions `json:"list"`
+	}
+
+	err = weatherGet(hc, owmBaseURL+"/forecast?"+q.Encode(), &forecast)
+	if err != nil {
+		return ret, fmt.Errorf("Error getting forecast: %v", err)
+	}
+
+	for _, c := range forecast.List {
+		ret.forecast = append(ret.forecast, c.sample())
+	}
+
+	return ret, nil
+}
+
+type nwsValue struct {
+	Value *float64 `json:"value"`
+}
+
+func (v nwsValue) get() float64 {
+	if v.Value == nil {
+		return 0
+	}
+	return *v.Value
+}
+
+// nwsWindSpeed parses NWS wind speeds like "10 mph" or "5 to 10 mph" and
+// returns m/s
+func nwsWindSpeed(s string) float64 {
+	fields := strings.Fields(s)
+	if len(fields) < 2 {
+		return 0
+	}
+
+	v, err := strconv.ParseFloat(fields[len(fields)-2], 64)
+	if err != nil {
+		return 0
+	}
+
+	if fields[len(fields)-1] == "mph" {
+		v *= 0.44704
+	}
+
+	return v
+}
+
+// fetchNWS fetches weather from the NWS hourly forecast API. NWS only
+// covers the United States. Current conditions are taken from the current
+// forecast hour.
+func fetchNWS(hc *http.Client, lat, lon float64) (weatherReport, error) {
+	var ret weatherReport
+
+	var point struct {
+		Properties struct {
+			ForecastHourly string `json:"forecastHourly"`
+		} `json:"properties"`
+	}
+
+	err := weatherGet(hc, fmt.Sprintf("%v/points/%.4f,%.4f", nwsBaseURL, lat, lon), &point)
+	if err != nil {
+		return ret, fmt.Errorf("Error getting NWS grid point: %v", err)
+	}
+
+	if point.Properties.ForecastHourly == "" {
+		return ret, errors.New("NWS did not return a forecast for location")
+	}
+
+	var forecast struct {
+		Properties struct {
+			Periods []struct {
+				StartTime                  time.Time `json:"startTime"`
+				Temperature                float64   `json:"temperature"`
+				TemperatureUnit            string    `json:"temperatureUnit"`
+				WindSpeed                  string    `json:"windSpeed"`
+				ShortForecast              string    `json:"shortForecast"`
+				ProbabilityOfPrecipitation nwsValue  `json:"probabilityOfPrecipitation"`
+				RelativeHumidity           nwsValue  `json:"relativeHumidity"`
+			} `json:"periods"`
+		} `json:"properties"`
+	}
+
+	err = weatherGet(hc, point.Properties.ForecastHourly, &forecast)
+	if err != nil {
+		return ret, fmt.Errorf("Error getting NWS forecast: %v", err)
+	}
+
+	for _, p := range forecast.Properties.Periods {
+		temp := p.Temperature
+		if p.TemperatureUnit == "F" {
+			temp = (temp - 32) * 5 / 9
+		}
+
+		ret.forecast = append(ret.forecast, weatherSample{
+			time:            p.StartTime,
+			temperature:     temp,
+			humidity:        p.RelativeHumidity.get(),
+			windSpeed:       nwsWindSpeed(p.WindSpeed),
+			rainProbability: p.ProbabilityOfPrecipitation.get(),
+			description:     p.ShortForecast,
+		})
+	}
+
+	if len(ret.forecast) == 0 {
+		return ret, errors.New("NWS forecast is empty")
+	}
+
+	ret.current = ret.forecast[0]
+	ret.forecast = ret.forecast[1:]
+
+	return ret, nil
+}
+
+// weatherPoints converts a weather report to points. Forecast summary
+// points cover the next 24 hours.
+func weatherPoints(r weatherReport, now time.Time) data.Points {
+	ret := data.Points{
+		{Time: now, Type: data.PointTypeTemperature, Value: r.current.temperature},
+		{Time: now, Type: data.PointTypeHumidity, Value: r.current.humidity},
+		{Time: now, Type: data.PointTypeWindSpeed, Value: r.current.windSpeed},
+		{Time: now, Type: data.PointTypeWeatherDescription, Text: r.current.description},
+	}
+
+	end := now.Add(24 * time.Hour)
+	first := true
+	var tempMin, tempMax, rainProb, rain float64
+
+	for _, s := range r.forecast {
+		if s.time.After(end) {
+			break
+		}
+
+		if first || s.temperature < tempMin {
+			tempMin = s.temperature
+		}
+		if first || s.temperature > tempMax {
+			tempMax = s.temperature
+		}
+		if s.rainProbability > rainProb {
+			rainProb = s.rainProbability
+		}
+		rain += s.rain
+		first = false
+	}
+
+	if !first {
+		ret = append(ret,
+			data.Point{Time: now, Type: data.PointTypeForecastTempMin, Value: tempMin},
+			data.Point{Time: now, Type: data.PointTypeForecastTempMax, Value: tempMax},
+			data.Point{Time: now, Type: data.PointTypeForecastRainProbability,
+				Value: rainProb},
+			data.Point{Time: now, Type: data.PointTypeForecastRain, Value: rain},
+		)
+	}
+
+	for _, h := range weatherForecastHours {
+		t := now.Add(time.Duration(h) * time.Hour)
+		for _, s := range r.forecast {
+			if !s.time.Before(t) {
+				ret = append(ret, data.Point{Time: now,
+					Type: data.PointTypeForecastTemperature,
+					Key:  strconv.Itoa(h), Value: s.temperature})
+				break
+			}
+		}
+	}
+
+	return ret
+}
+
+// WeatherClient fetches weather for weather nodes
+type WeatherClient struct {
+	nc            *nats.Conn
+	config        Weather
+	stop          chan struct{}
+	newPoints     chan NewPoints
+	newEdgePoints chan NewPoints
+	httpClient    *http.Client
+}
+
+// NewWeatherClient ...
+func NewWeatherClient(nc *nats.Conn, config Weather) Client {
+	return &WeatherClient{
+		nc:            nc,
+		config:        config,
+		stop:          make(chan struct{}),
+		newPoints:     make(chan NewPoints),
+		newEdgePoints: make(chan NewPoints),
+		httpClient:    &http.Client{Timeout: 20 * time.Second},
+	}
+}
+
+func (wc *WeatherClient) location() (float64, float64, error) {
+	if wc.config.Latitude != 0 || wc.config.Longitude != 0 {
+		return wc.config.Latitude, wc.config.Longitude, nil
+	}
+
+	nodes, err := GetNode(wc.nc, wc.config.Parent, "none")
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if len(nodes) > 0 {
+		lat, latOk := nodes[0].Points.Value(data.PointTypeLatitude, "")
+		lon, lonOk := nodes[0].Points.Value(data.PointTypeLongitude, "")
+		if latOk && lonOk {
+			return lat, lon, nil
+		}
+	}
+
+	return 0, 0, errors.New("location not set")
+}
+
+func (wc *WeatherClient) update() error {
+	lat, lon, err := wc.location()
+	if err != nil {
+		return err
+	}
+
+	var r weatherReport
+
+	switch wc.config.Provider {
+	case "", data.PointValueOpenWeatherMap:
+		r, err = fetchOWM(wc.httpClient, wc.config.APIKey, lat, lon)
+	case data.PointValueNWS:
+		r, err = fetchNWS(wc.httpClient, lat, lon)
+	default:
+		err = fmt.Errorf("unsupported weather provider: %v", wc.config.Provider)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return SendNodePoints(wc.nc, wc.config.ID, weatherPoints(r, time.Now()), false)
+}
+
+// Start runs the main logic for this client and blocks until stopped
+func (wc *WeatherClient) Start() error {
+	log.Println("Starting weather client: ", wc.config.Description)
+
+	t := time.NewTicker(time.Hour)
+	t.Stop()
+
+	update := func() {
+		if wc.config.Disable {
+			return
+		}
+
+		err := wc.update()
+		if err != nil {
+			log.Printf("Weather %v: %v\n", wc.config.Description, err)
+		}
+	}
+
+	setup := func() {
+		t.Stop()
+
+		if wc.config.Disable {
+			return
+		}
+
+		period := 15 * time.Minute
+		if wc.config.SamplePeriod > 0 {
+			period = time.Duration(wc.config.SamplePeriod * float64(time.Second))
+		}
+
+		t.Reset(period)
+		update()
+	}
+
+	setup()
+
+done:
+	for {
+		select {
+		case <-wc.stop:
+			log.Println("Stopping weather client: ", wc.config.Description)
+			break done
+		case <-t.C:
+			update()
+		case pts := <-wc.newPoints:
+			err := data.MergePoints(pts.ID, pts.Points, &wc.config)
+			if err != nil {
+				log.Println("error merging new points: ", err)
+			}
+
+			for _, p := range pts.Points {
+				switch p.Type {
+				case data.PointTypeProvider, data.PointTypeAPIKey,
+					data.PointTypeLatitude, data.PointTypeLongitude,
+					data.PointTypeSamplePeriod, data.PointTypeDisable:
+					setup()
+				}
+			}
+
+		case pts := <-wc.newEdgePoints:
+			err := data.MergeEdgePoints(pts.ID, pts.Parent, pts.Points, &wc.config)
+			if err != nil {
+				log.Println("error merging new points: ", err)
+			}
+		}
+	}
+
+	// clean up
+	t.Stop()
+	return nil
+}
+
+// Stop sends a signal to the Start function to exit
+func (wc *WeatherClient) Stop(err error) {
+	close(wc.stop)
+}
+
+// Points is called by the Manager when new points for this
+// node are received.
+func (wc *WeatherClient) Points(nodeID string, points []data.Point) {
+	wc.newPoints <- NewPoints{nodeID, "", points}
+}
+
+// EdgePoints is called by the Manager when new edge points for this
+// node are received.
+func (wc *WeatherClient) EdgePoints(nodeID, parentID string, points []data.Point) {
+	wc.newEdgePoints <- NewPoints{nodeID, parentID, points}
+}
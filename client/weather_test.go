@@ -0,0 +1,122 @@
+package client
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/simpleiot/simpleiot/data"
+)
+
+func TestWeatherOWM(t *testing.T) {
+	now := time.Unix(1666000000, 0)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("appid") != "key" || r.URL.Query().Get("units") != "metric" {
+			t.Error("wrong query: ", r.URL.RawQuery)
+		}
+
+		switch r.URL.Path {
+		case "/weather":
+			fmt.Fprintf(w, `{"dt":%v,"main":{"temp":12.5,"humidity":80},
+				"wind":{"speed":3},"weather":[{"description":"light rain"}],
+				"rain":{"1h":0.5}}`, now.Unix())
+		case "/forecast":
+			fmt.Fprint(w, `{"list":[`)
+			for i := 1; i <= 10; i++ {
+				if i > 1 {
+					fmt.Fprint(w, ",")
+				}
+				fmt.Fprintf(w, `{"dt":%v,"main":{"temp":%v},"pop":%v,"rain":{"3h":1}}`,
+					now.Add(time.Duration(3*i)*time.Hour).Unix(), 10+i, 0.1*float64(i))
+			}
+			fmt.Fprint(w, `]}`)
+		default:
+			t.Error("wrong path: ", r.URL.Path)
+		}
+	}))
+	defer ts.Close()
+
+	defer func(u string) { owmBaseURL = u }(owmBaseURL)
+	owmBaseURL = ts.URL
+
+	r, err := fetchOWM(ts.Client(), "key", 43.1, -85.2)
+	if err != nil {
+		t.Fatal("Error fetching weather: ", err)
+	}
+
+	pts := weatherPoints(r, now)
+
+	check := func(typ, key string, exp float64) {
+		v, ok := pts.Value(typ, key)
+		if !ok || math.Abs(v-exp) > 1e-9 {
+			t.Errorf("%v:%v expected %v, got %v", typ, key, exp, v)
+		}
+	}
+
+	check(data.PointTypeTemperature, "", 12.5)
+	check(data.PointTypeHumidity, "", 80)
+	// 8 forecast samples in the next 24h
+	check(data.PointTypeForecastTempMin, "", 11)
+	check(data.PointTypeForecastTempMax, "", 18)
+	check(data.PointTypeForecastRainProbability, "", 80)
+	check(data.PointTypeForecastRain, "", 8)
+	check(data.PointTypeForecastTemperature, "6", 12)
+	check(data.PointTypeForecastTemperature, "24", 18)
+
+	if d, _ := pts.Text(data.PointTypeWeatherDescription, ""); d != "light rain" {
+		t.Error("wrong description: ", d)
+	}
+
+	_, err = fetchOWM(ts.Client(), "", 43.1, -85.2)
+	if err == nil {
+		t.Error("expected error without API key")
+	}
+}
+
+func TestWeatherNWS(t *testing.T) {
+	var ts *httptest.Server
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("User-Agent") == "" {
+			t.Error("NWS requires user agent")
+		}
+
+		switch r.URL.Path {
+		case "/points/43.1000,-85.2000":
+			fmt.Fprintf(w, `{"properties":{"forecastHourly":"%v/hourly"}}`, ts.URL)
+		case "/hourly":
+			fmt.Fprint(w, `{"properties":{"periods":[
+				{"startTime":"2022-10-20T10:00:00-04:00","temperature":50,
+				"temperatureUnit":"F","windSpeed":"10 mph","shortForecast":"Sunny",
+				"probabilityOfPrecipitation":{"value":null},"relativeHumidity":{"value":60}},
+				{"startTime":"2022-10-20T11:00:00-04:00","temperature":59,
+				"temperatureUnit":"F","windSpeed":"5 to 10 mph","shortForecast":"Rain",
+				"probabilityOfPrecipitation":{"value":70},"relativeHumidity":{"value":90}}]}}`)
+		default:
+			t.Error("wrong path: ", r.URL.Path)
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+
+	defer func(u string) { nwsBaseURL = u }(nwsBaseURL)
+	nwsBaseURL = ts.URL
+
+	r, err := fetchNWS(ts.Client(), 43.1, -85.2)
+	if err != nil {
+		t.Fatal("Error fetching weather: ", err)
+	}
+
+	if r.current.temperature != 10 || r.current.description != "Sunny" ||
+		math.Abs(r.current.windSpeed-4.4704) > 1e-9 {
+		t.Error("wrong current conditions: ", r.current)
+	}
+
+	if len(r.forecast) != 1 || r.forecast[0].temperature != 15 ||
+		r.forecast[0].rainProbability != 70 {
+		t.Error("wrong forecast: ", r.forecast)
+	}
+}
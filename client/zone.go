@@ -0,0 +1,123 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/simpleiot/simpleiot/data"
+)
+
+// earthRadius is the mean radius of the earth in meters
+const earthRadius = 6371000.0
+
+// Zone is an area, such as a site or a depot, that geofence conditions
+// check the location of tracked assets against. A zone is a polygon if
+// Polygon is set, and otherwise a circle of Radius meters around Latitude
+// and Longitude.
+type Zone struct {
+	ID          string  `node:"id"`
+	Parent      string  `node:"parent"`
+	Description string  `point:"description"`
+	Latitude    float64 `point:"latitude"`
+	Longitude   float64 `point:"longitude"`
+	Radius      float64 `point:"radius"`
+	// Polygon is a list of vertices separated by spaces, where each vertex
+	// is a latitude and longitude separated by a comma, for example
+	// "45.01,-93.27 45.02,-93.27 45.02,-93.25"
+	Polygon string `point:"polygon"`
+}
+
+// ZoneNodeUI returns the edit form descriptor for zone nodes. Zones are read
+// by the rule client, so there is no client for them.
+func ZoneNodeUI() NodeUI {
+	return NodeUI{
+		Type:    data.NodeTypeZone,
+		Label:   "Zone",
+		Parents: []string{data.NodeTypeDevice, data.NodeTypeGroup},
+		Fields: []NodeUIField{
+			{Point: data.PointTypeDescription, Label: "Description", Input: NodeUIInputText},
+			{Point: data.PointTypeLatitude, Label: "Center latitude", Input: NodeUIInputNumber},
+			{Point: data.PointTypeLongitude, Label: "Center longitude", Input: NodeUIInputNumber},
+			{Point: data.PointTypeRadius, Label: "Radius (m)", Input: NodeUIInputNumber},
+			{Point: data.PointTypePolygon, Label: "Polygon (lat,long lat,long ...)",
+				Input: NodeUIInputText},
+		},
+	}
+}
+
+// parsePolygon parses the vertices of a zone polygon
+func parsePolygon(s string) ([]data.GpsPos, error) {
+	var ret []data.GpsPos
+
+	for _, v := range strings.Fields(s) {
+		latLong := strings.Split(v, ",")
+		if len(latLong) != 2 {
+			return nil, fmt.Errorf("invalid vertex %v", v)
+		}
+
+		lat, err := strconv.ParseFloat(latLong[0], 64)
+		if err != nil || lat < -90 || lat > 90 {
+			return nil, fmt.Errorf("invalid latitude in vertex %v", v)
+		}
+
+		long, err := strconv.ParseFloat(latLong[1], 64)
+		if err != nil || long < -180 || long > 180 {
+			return nil, fmt.Errorf("invalid longitude in vertex %v", v)
+		}
+
+		ret = append(ret, data.GpsPos{Lat: lat, Long: long})
+	}
+
+	if len(ret) < 3 {
+		return nil, errors.New("polygon must have at least 3 vertices")
+	}
+
+	return ret, nil
+}
+
+// distance returns the great circle distance between two positions in
+// meters
+func distance(a, b data.GpsPos) float64 {
+	rad := math.Pi / 180
+	dLat := (b.Lat - a.Lat) * rad
+	dLong := (b.Long - a.Long) * rad
+
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(a.Lat*rad)*math.Cos(b.Lat*rad)*math.Sin(dLong/2)*math.Sin(dLong/2)
+
+	return 2 * earthRadius * math.Asin(math.Min(1, math.Sqrt(h)))
+}
+
+// Contains returns true if pos is inside the zone. Polygons are treated as
+// flat, which is accurate for site sized zones that do not cross the 180th
+// meridian.
+func (z Zone) Contains(pos data.GpsPos) (bool, error) {
+	if z.Polygon == "" {
+		if z.Radius <= 0 {
+			return false, errors.New("zone has no polygon or radius")
+		}
+
+		center := data.GpsPos{Lat: z.Latitude, Long: z.Longitude}
+		return distance(center, pos) <= z.Radius, nil
+	}
+
+	vertices, err := parsePolygon(z.Polygon)
+	if err != nil {
+		return false, err
+	}
+
+	// ray casting, counting the polygon edges crossed by a ray from pos
+	inside := false
+	for i, j := 0, len(vertices)-1; i < len(vertices); j, i = i, i+1 {
+		vi, vj := vertices[i], vertices[j]
+		if (vi.Lat > pos.Lat) != (vj.Lat > pos.Lat) &&
+			pos.Long < (vj.Long-vi.Long)*(pos.Lat-vi.Lat)/(vj.Lat-vi.Lat)+vi.Long {
+			inside = !inside
+		}
+	}
+
+	return inside, nil
+}
@@ -0,0 +1,56 @@
+package client
+
+import (
+	"testing"
+
+	"github.com/simpleiot/simpleiot/data"
+)
+
+func TestZoneContains(t *testing.T) {
+	circle := Zone{Latitude: 45, Longitude: -93, Radius: 1000}
+	// 0.01 degrees of latitude is about 1.1km
+	square := Zone{Polygon: "45,-93 45.01,-93 45.01,-92.99 45,-92.99"}
+	// L shaped, without the upper right quarter
+	ell := Zone{Polygon: "0,0 2,0 2,1 1,1 1,2 0,2"}
+
+	tests := []struct {
+		zone   Zone
+		pos    data.GpsPos
+		inside bool
+	}{
+		{circle, data.GpsPos{Lat: 45, Long: -93}, true},
+		{circle, data.GpsPos{Lat: 45.008, Long: -93}, true},
+		{circle, data.GpsPos{Lat: 45.01, Long: -93}, false},
+		{circle, data.GpsPos{Lat: 45, Long: -92.98}, false},
+		{square, data.GpsPos{Lat: 45.005, Long: -92.995}, true},
+		{square, data.GpsPos{Lat: 45.015, Long: -92.995}, false},
+		{ell, data.GpsPos{Lat: 0.5, Long: 1.5}, true},
+		{ell, data.GpsPos{Lat: 1.5, Long: 0.5}, true},
+		{ell, data.GpsPos{Lat: 1.5, Long: 1.5}, false},
+	}
+
+	for _, test := range tests {
+		inside, err := test.zone.Contains(test.pos)
+		if err != nil {
+			t.Fatal("Error checking zone: ", err)
+		}
+		if inside != test.inside {
+			t.Errorf("zone %+v, pos %v: expected %v", test.zone, test.pos, test.inside)
+		}
+	}
+}
+
+func TestZoneErrors(t *testing.T) {
+	bad := []Zone{
+		{},
+		{Polygon: "45,-93 45.01,-93"},
+		{Polygon: "45,-93 45.01 45.01,-92.99"},
+		{Polygon: "45,-93 95,-93 45.01,-92.99"},
+	}
+
+	for _, z := range bad {
+		if _, err := z.Contains(data.GpsPos{Lat: 45, Long: -93}); err == nil {
+			t.Errorf("expected error for zone %+v", z)
+		}
+	}
+}
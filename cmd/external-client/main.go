@@ -0,0 +1,139 @@
+// external-client is an example of a SIOT client that runs in a separate
+// process. It handles exampleCounter nodes, which increment their value point
+// every period seconds.
+//
+// Add client credentials to the SIOT config file:
+//
+//	auth:
+//	  clients:
+//	    - user: counter
+//	      password: secret
+//
+// and run:
+//
+//	SIOT_CLIENT_USER=counter SIOT_CLIENT_PASSWORD=secret go run ./cmd/external-client
+package main
+
+import (
+	"flag"
+	"log"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/simpleiot/simpleiot/client"
+	"github.com/simpleiot/simpleiot/data"
+)
+
+// ExampleCounter is the config for exampleCounter nodes
+type ExampleCounter struct {
+	ID          string  `node:"id"`
+	Parent      string  `node:"parent"`
+	Description string  `point:"description"`
+	Period      float64 `point:"period"`
+	Value       float64 `point:"value"`
+	Disable     bool    `point:"disable"`
+}
+
+// CounterClient increments the value of an exampleCounter node
+type CounterClient struct {
+	nc            *nats.Conn
+	config        ExampleCounter
+	stop          chan struct{}
+	newPoints     chan client.NewPoints
+	newEdgePoints chan client.NewPoints
+}
+
+// NewCounterClient ...
+func NewCounterClient(nc *nats.Conn, config ExampleCounter) client.Client {
+	return &CounterClient{
+		nc:            nc,
+		config:        config,
+		stop:          make(chan struct{}),
+		newPoints:     make(chan client.NewPoints),
+		newEdgePoints: make(chan client.NewPoints),
+	}
+}
+
+// Start runs the main logic for this client and blocks until stopped
+func (cc *CounterClient) Start() error {
+	t := time.NewTicker(time.Hour)
+	t.Stop()
+
+	setup := func() {
+		t.Stop()
+		if cc.config.Disable {
+			return
+		}
+
+		period := cc.config.Period
+		if period <= 0 {
+			period = 1
+		}
+		t.Reset(time.Duration(period * float64(time.Second)))
+	}
+
+	setup()
+
+done:
+	for {
+		select {
+		case <-cc.stop:
+			break done
+		case <-t.C:
+			cc.config.Value++
+			err := client.SendNodePoint(cc.nc, cc.config.ID, data.Point{
+				Type: data.PointTypeValue, Value: cc.config.Value}, false)
+			if err != nil {
+				log.Println("Error sending point: ", err)
+			}
+		case pts := <-cc.newPoints:
+			err := data.MergePoints(pts.ID, pts.Points, &cc.config)
+			if err != nil {
+				log.Println("error merging new points: ", err)
+			}
+			setup()
+		case pts := <-cc.newEdgePoints:
+			err := data.MergeEdgePoints(pts.ID, pts.Parent, pts.Points, &cc.config)
+			if err != nil {
+				log.Println("error merging new points: ", err)
+			}
+		}
+	}
+
+	// clean up
+	t.Stop()
+	return nil
+}
+
+// Stop sends a signal to the Start function to exit
+func (cc *CounterClient) Stop(err error) {
+	close(cc.stop)
+}
+
+// Points is called by the Manager when new points for this
+// node are received.
+func (cc *CounterClient) Points(nodeID string, points []data.Point) {
+	cc.newPoints <- client.NewPoints{ID: nodeID, Points: points}
+}
+
+// EdgePoints is called by the Manager when new edge points for this
+// node are received.
+func (cc *CounterClient) EdgePoints(nodeID, parentID string, points []data.Point) {
+	cc.newEdgePoints <- client.NewPoints{ID: nodeID, Parent: parentID, Points: points}
+}
+
+func main() {
+	o := client.ExternalOptionsFromEnv()
+
+	flag.StringVar(&o.Server, "server", o.Server, "NATS server URI")
+	flag.StringVar(&o.Parent, "parent", "", "parent node ID (defaults to root)")
+	flag.Parse()
+
+	o.Description = "example counter"
+	o.Version = "v0.0.1"
+
+	err := client.RunExternal(o, NewCounterClient)
+	if err != nil {
+		log.Fatal("External client error: ", err)
+	}
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"flag"
+	"os"
+
+	"github.com/simpleiot/simpleiot/bench"
+)
+
+// benchCommand runs the performance scenarios against a temporary SIOT
+// instance
+func benchCommand(args []string) error {
+	flags := flag.NewFlagSet("bench", flag.ExitOnError)
+	flagRun := flags.String("run", "", "only run scenarios matching this regular expression")
+	flagStore := flags.String("store", bench.DefaultOptions().StoreFile, "temporary store file, deleted when done")
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	o := bench.DefaultOptions()
+	o.StoreFile = *flagStore
+
+	return bench.Run(o, *flagRun, os.Stdout)
+}
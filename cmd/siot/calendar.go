@@ -0,0 +1,58 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/simpleiot/simpleiot/client"
+)
+
+// icalImportCommand adds the dates of an iCalendar file to a calendar node
+func icalImportCommand(args []string) error {
+	flags := flag.NewFlagSet("ical-import", flag.ExitOnError)
+	connect := natsFlags(flags)
+	flagNode := flags.String("node", "", "ID of the calendar node")
+	flagTimezone := flags.String("timezone", "UTC", "timezone used to convert event times to dates")
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if *flagNode == "" {
+		return errors.New("-node must be set")
+	}
+
+	if flags.NArg() != 1 {
+		return errors.New("usage: siot ical-import [flags] file")
+	}
+
+	loc, err := time.LoadLocation(*flagTimezone)
+	if err != nil {
+		return fmt.Errorf("Invalid timezone: %v", err)
+	}
+
+	f, err := os.Open(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	nc, err := connect()
+	if err != nil {
+		return fmt.Errorf("Error connecting to NATS: %v", err)
+	}
+	defer nc.Close()
+
+	count, err := client.ImportICal(nc, *flagNode, f, loc)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Imported %v dates", count)
+
+	return nil
+}
@@ -4,10 +4,42 @@ import (
 	"log"
 	"os"
 
+	// timezone data for schedules and user quiet hours on systems without
+	// a zoneinfo database, like many embedded Linux images
+	_ "time/tzdata"
+
 	"github.com/simpleiot/simpleiot/server"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "bench" {
+		if err := benchCommand(os.Args[2:]); err != nil {
+			log.Fatal("Benchmark error: ", err)
+		}
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "record" {
+		if err := recordCommand(os.Args[2:]); err != nil {
+			log.Fatal("Record error: ", err)
+		}
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "replay" {
+		if err := replayCommand(os.Args[2:]); err != nil {
+			log.Fatal("Replay error: ", err)
+		}
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "ical-import" {
+		if err := icalImportCommand(os.Args[2:]); err != nil {
+			log.Fatal("iCal import error: ", err)
+		}
+		return
+	}
+
 	if err := server.StartArgs(os.Args); err != nil {
 		log.Println("Simple IoT stopped, reason: ", err)
 	}
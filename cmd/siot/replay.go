@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/simpleiot/simpleiot/replay"
+)
+
+// natsFlags adds the flags used to connect to a SIOT instance and returns a
+// function that connects
+func natsFlags(flags *flag.FlagSet) func() (*nats.Conn, error) {
+	flagServer := flags.String("server", "nats://localhost:4222", "NATS server of the SIOT instance")
+	flagToken := flags.String("token", os.Getenv("SIOT_AUTH_TOKEN"), "NATS auth token (env: SIOT_AUTH_TOKEN)")
+
+	return func() (*nats.Conn, error) {
+		opts := []nats.Option{nats.Timeout(10 * time.Second)}
+		if *flagToken != "" {
+			opts = append(opts, nats.Token(*flagToken))
+		}
+		return nats.Connect(*flagServer, opts...)
+	}
+}
+
+// recordCommand records the point traffic of a subtree until interrupted
+func recordCommand(args []string) error {
+	flags := flag.NewFlagSet("record", flag.ExitOnError)
+	connect := natsFlags(flags)
+	flagNode := flags.String("node", "", "ID of the top node of the subtree to record")
+	flagOut := flags.String("out", "", "recording file (default stdout)")
+	flagDuration := flags.Duration("duration", 0, "stop recording after this time (default until interrupted)")
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if *flagNode == "" {
+		return errors.New("-node must be set")
+	}
+
+	nc, err := connect()
+	if err != nil {
+		return fmt.Errorf("Error connecting to NATS: %v", err)
+	}
+	defer nc.Close()
+
+	var w io.Writer = os.Stdout
+	if *flagOut != "" {
+		f, err := os.Create(*flagOut)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	if *flagDuration > 0 {
+		ctx, cancel = context.WithTimeout(ctx, *flagDuration)
+		defer cancel()
+	}
+
+	log.Println("Recording node ", *flagNode)
+
+	return replay.Record(ctx, nc, *flagNode, w)
+}
+
+// replayCommand replays a recording made by the record command
+func replayCommand(args []string) error {
+	flags := flag.NewFlagSet("replay", flag.ExitOnError)
+	connect := natsFlags(flags)
+	flagSpeed := flags.Float64("speed", 1, "time scale, 2 is twice as fast as recorded, 0 is as fast as possible")
+	flagCreate := flags.Bool("create", false, "create the recorded nodes before replaying")
+	flagParent := flags.String("parent", "", "parent of the created nodes (default root node)")
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if flags.NArg() != 1 {
+		return errors.New("usage: siot replay [flags] file")
+	}
+
+	f, err := os.Open(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	nc, err := connect()
+	if err != nil {
+		return fmt.Errorf("Error connecting to NATS: %v", err)
+	}
+	defer nc.Close()
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	count, err := replay.Replay(ctx, nc, f, replay.Options{
+		Speed:       *flagSpeed,
+		CreateNodes: *flagCreate,
+		Parent:      *flagParent,
+	})
+
+	log.Printf("Replayed %v messages", count)
+
+	return err
+}
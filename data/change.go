@@ -0,0 +1,19 @@
+package data
+
+import "time"
+
+// PointChange records a change to a node point made by someone other than
+// the node itself (the point Origin was set). It is used to answer who
+// changed a point, when, and what the value was before.
+type PointChange struct {
+	NodeID    string    `json:"nodeID"`
+	Time      time.Time `json:"time"`
+	Type      string    `json:"type"`
+	Key       string    `json:"key,omitempty"`
+	OldValue  float64   `json:"oldValue"`
+	OldText   string    `json:"oldText,omitempty"`
+	Value     float64   `json:"value"`
+	Text      string    `json:"text,omitempty"`
+	Tombstone int       `json:"tombstone,omitempty"`
+	Origin    string    `json:"origin"`
+}
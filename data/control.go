@@ -0,0 +1,29 @@
+package data
+
+// ControlPointTypes are point types that change the state of something, like
+// setpoints and relay commands. Messages with control points are sent with
+// the control priority, and the store handles them ahead of telemetry.
+var ControlPointTypes = []string{
+	PointTypeValueSet,
+	PointTypeCommand,
+}
+
+// IsControl returns true if the point is a control point type
+func (p Point) IsControl() bool {
+	for _, t := range ControlPointTypes {
+		if p.Type == t {
+			return true
+		}
+	}
+	return false
+}
+
+// HasControl returns true if any of the points is a control point type
+func (ps Points) HasControl() bool {
+	for _, p := range ps {
+		if p.IsControl() {
+			return true
+		}
+	}
+	return false
+}
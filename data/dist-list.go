@@ -0,0 +1,62 @@
+package data
+
+import "sort"
+
+// DistList is a distribution list of external email addresses and phone
+// numbers that get notifications, for people that do not have a user
+// account.
+type DistList struct {
+	ID             string
+	Description    string
+	Emails         []string
+	Phones         []string
+	NotifySeverity string
+}
+
+// NodeToDistList converts a node to a distribution list. Entries are
+// ordered by key.
+func NodeToDistList(node Node) DistList {
+	ret := DistList{ID: node.ID}
+
+	var emails, phones Points
+
+	for _, p := range node.Points {
+		if p.Tombstone != 0 {
+			continue
+		}
+
+		switch p.Type {
+		case PointTypeDescription:
+			ret.Description = p.Text
+		case PointTypeEmail:
+			if p.Text != "" {
+				emails = append(emails, p)
+			}
+		case PointTypePhone:
+			if p.Text != "" {
+				phones = append(phones, p)
+			}
+		case PointTypeNotifySeverity:
+			ret.NotifySeverity = p.Text
+		}
+	}
+
+	byKey := func(ps Points) []string {
+		sort.Slice(ps, func(i, j int) bool { return ps[i].Key < ps[j].Key })
+		var r []string
+		for _, p := range ps {
+			r = append(r, p.Text)
+		}
+		return r
+	}
+
+	ret.Emails = byKey(emails)
+	ret.Phones = byKey(phones)
+
+	return ret
+}
+
+// Notify returns true if the list gets notifications of the given severity
+func (l *DistList) Notify(severity string) bool {
+	return SeverityLevel(severity) >= SeverityLevel(l.NotifySeverity)
+}
@@ -4,3 +4,7 @@ import "errors"
 
 // ErrDocumentNotFound is returned in APIs if document is not found
 var ErrDocumentNotFound = errors.New("document not found")
+
+// ErrVersionMismatch is returned if a write is conditional on a node version
+// (see Points.Version) and the node was modified since
+var ErrVersionMismatch = errors.New("version mismatch")
@@ -0,0 +1,188 @@
+package data
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DisplayFormat describes how values are formatted for a user. The locale
+// (for example en-US or de-DE) selects the decimal and thousands
+// separators, and the unit system (metric or imperial) selects the units
+// values are converted to. Formats are set with the locale and unitSystem
+// points on a user node.
+type DisplayFormat struct {
+	Locale     string `json:"locale"`
+	UnitSystem string `json:"unitSystem"`
+}
+
+// DefaultDisplayFormat is used if a user does not set a display format
+var DefaultDisplayFormat = DisplayFormat{Locale: "en-US", UnitSystem: PointValueMetric}
+
+// DisplayFormat returns the display format set on a (user) node
+func (n Node) DisplayFormat() DisplayFormat {
+	ret := DefaultDisplayFormat
+
+	if l, _ := n.Points.Text(PointTypeLocale, ""); l != "" {
+		ret.Locale = l
+	}
+
+	if u, _ := n.Points.Text(PointTypeUnitSystem, ""); u != "" {
+		ret.UnitSystem = u
+	}
+
+	return ret
+}
+
+// unitConversion converts between a metric and imperial unit:
+// imperial = metric * scale + offset
+type unitConversion struct {
+	metric   []string
+	imperial []string
+	scale    float64
+	offset   float64
+}
+
+// the first unit in each list is used for converted values
+var unitConversions = []unitConversion{
+	{[]string{"°C", "C", "degC"}, []string{"°F", "F", "degF"}, 9.0 / 5, 32},
+	{[]string{"mm"}, []string{"in"}, 0.0393701, 0},
+	{[]string{"cm"}, []string{"in"}, 0.393701, 0},
+	{[]string{"m"}, []string{"ft"}, 3.28084, 0},
+	{[]string{"km"}, []string{"mi"}, 0.621371, 0},
+	{[]string{"m/s"}, []string{"mph"}, 2.23694, 0},
+	{[]string{"km/h", "kph"}, []string{"mph"}, 0.621371, 0},
+	{[]string{"g"}, []string{"oz"}, 0.035274, 0},
+	{[]string{"kg"}, []string{"lb", "lbs"}, 2.20462, 0},
+	{[]string{"L", "l"}, []string{"gal"}, 0.264172, 0},
+	{[]string{"L/min", "l/min"}, []string{"gpm"}, 0.264172, 0},
+	{[]string{"m³", "m3"}, []string{"ft³", "ft3"}, 35.3147, 0},
+	{[]string{"kPa"}, []string{"psi"}, 0.145038, 0},
+	{[]string{"bar"}, []string{"psi"}, 14.5038, 0},
+	{[]string{"hPa", "mbar"}, []string{"inHg"}, 0.02953, 0},
+}
+
+func unitIn(units string, list []string) bool {
+	for _, u := range list {
+		if u == units {
+			return true
+		}
+	}
+	return false
+}
+
+// ConvertUnits converts a value to the unit system (metric or imperial).
+// The converted value and units are returned. Values with units that are
+// already in the unit system, or that are not known, are not converted.
+func ConvertUnits(value float64, units, system string) (float64, string) {
+	for _, c := range unitConversions {
+		switch system {
+		case PointValueImperial:
+			if unitIn(units, c.metric) {
+				return value*c.scale + c.offset, c.imperial[0]
+			}
+		case PointValueMetric:
+			// several metric units convert to the same imperial unit,
+			// so the first match is used
+			if unitIn(units, c.imperial) {
+				return (value - c.offset) / c.scale, c.metric[0]
+			}
+		}
+	}
+
+	return value, units
+}
+
+// separators returns the decimal and thousands separators for the locale
+func (f DisplayFormat) separators() (string, string) {
+	locale := strings.ReplaceAll(f.Locale, "_", "-")
+	lang := strings.ToLower(strings.Split(locale, "-")[0])
+
+	switch {
+	case strings.EqualFold(locale, "de-CH"):
+		return ".", "'"
+	case lang == "fr" || lang == "ru" || lang == "sv" || lang == "fi" ||
+		lang == "nb" || lang == "pl" || lang == "cs":
+		// no-break space
+		return ",", "\u00a0"
+	case lang == "de" || lang == "es" || lang == "it" || lang == "nl" ||
+		lang == "pt" || lang == "da" || lang == "tr" || lang == "id":
+		return ",", "."
+	default:
+		return ".", ","
+	}
+}
+
+// FormatNumber formats a number for the locale with at most the number of
+// decimals. Trailing zeros in the fraction are removed.
+func (f DisplayFormat) FormatNumber(v float64, decimals int) string {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+
+	s := strconv.FormatFloat(math.Abs(v), 'f', decimals, 64)
+
+	intPart, frac := s, ""
+	if i := strings.Index(s, "."); i >= 0 {
+		intPart, frac = s[:i], strings.TrimRight(s[i+1:], "0")
+	}
+
+	dec, thousands := f.separators()
+
+	var b strings.Builder
+	if v < 0 && strings.Trim(s, "0.") != "" {
+		b.WriteString("-")
+	}
+
+	for i, r := range intPart {
+		if i > 0 && (len(intPart)-i)%3 == 0 {
+			b.WriteString(thousands)
+		}
+		b.WriteRune(r)
+	}
+
+	if frac != "" {
+		b.WriteString(dec)
+		b.WriteString(frac)
+	}
+
+	return b.String()
+}
+
+// DisplayValue is a point formatted for display
+type DisplayValue struct {
+	Type  string    `json:"type"`
+	Key   string    `json:"key"`
+	Time  time.Time `json:"time"`
+	Value float64   `json:"value"`
+	Units string    `json:"units,omitempty"`
+	// Text is the formatted value with units, or the point text
+	Text string `json:"text"`
+}
+
+// Display converts a point to the unit system and formats it for the
+// locale. If the point has a units meta entry it is used, otherwise units
+// is used for value points (typically the units point of the node).
+func (f DisplayFormat) Display(p Point, units string, decimals int) DisplayValue {
+	ret := DisplayValue{Type: p.Type, Key: p.Key, Time: p.Time, Value: p.Value}
+
+	if p.Text != "" {
+		ret.Text = p.Text
+		return ret
+	}
+
+	if u := p.Meta["units"]; u != "" {
+		units = u
+	} else if p.Type != PointTypeValue {
+		units = ""
+	}
+
+	ret.Value, ret.Units = ConvertUnits(p.Value, units, f.UnitSystem)
+	ret.Text = f.FormatNumber(ret.Value, decimals)
+	if ret.Units != "" {
+		ret.Text += " " + ret.Units
+	}
+
+	return ret
+}
@@ -0,0 +1,93 @@
+package data
+
+import (
+	"math"
+	"testing"
+)
+
+func TestConvertUnits(t *testing.T) {
+	tests := []struct {
+		value   float64
+		units   string
+		system  string
+		exp     float64
+		expUnit string
+	}{
+		{20, "°C", PointValueImperial, 68, "°F"},
+		{68, "F", PointValueMetric, 20, "°C"},
+		{20, "°C", PointValueMetric, 20, "°C"},
+		{100, "km/h", PointValueImperial, 62.1371, "mph"},
+		{1, "bar", PointValueImperial, 14.5038, "psi"},
+		{14.5038, "psi", PointValueMetric, 100, "kPa"},
+		{5, "furlong", PointValueImperial, 5, "furlong"},
+		{5, "", PointValueImperial, 5, ""},
+	}
+
+	for _, test := range tests {
+		v, u := ConvertUnits(test.value, test.units, test.system)
+		if math.Abs(v-test.exp) > 0.001 || u != test.expUnit {
+			t.Errorf("%v %v to %v: expected %v %v, got %v %v", test.value,
+				test.units, test.system, test.exp, test.expUnit, v, u)
+		}
+	}
+}
+
+func TestFormatNumber(t *testing.T) {
+	tests := []struct {
+		locale   string
+		value    float64
+		decimals int
+		exp      string
+	}{
+		{"en-US", 1234567.891, 2, "1,234,567.89"},
+		{"en-US", 21.5, 2, "21.5"},
+		{"en-US", 100, 2, "100"},
+		{"en-US", -1234.5, 1, "-1,234.5"},
+		{"en-US", -0.001, 2, "0"},
+		{"de-DE", 1234.5, 2, "1.234,5"},
+		{"de_DE", 1234.5, 2, "1.234,5"},
+		{"fr-FR", 1234.5, 2, "1\u00a0234,5"},
+		{"de-CH", 1234.5, 2, "1'234.5"},
+		{"", 999.999, 2, "1,000"},
+	}
+
+	for _, test := range tests {
+		f := DisplayFormat{Locale: test.locale}
+		got := f.FormatNumber(test.value, test.decimals)
+		if got != test.exp {
+			t.Errorf("%v %v: expected %v, got %v", test.locale, test.value,
+				test.exp, got)
+		}
+	}
+}
+
+func TestDisplay(t *testing.T) {
+	f := DisplayFormat{Locale: "de-DE", UnitSystem: PointValueImperial}
+
+	d := f.Display(Point{Type: PointTypeValue, Value: 1000}, "m", 1)
+	if d.Text != "3.280,8 ft" || d.Units != "ft" {
+		t.Error("wrong display value: ", d)
+	}
+
+	d = f.Display(Point{Type: PointTypeTemperature, Value: 20,
+		Meta: map[string]string{"units": "°C"}}, "m", 1)
+	if d.Text != "68 °F" {
+		t.Error("wrong display value for meta units: ", d)
+	}
+
+	// node units only apply to value points
+	d = f.Display(Point{Type: PointTypeTemperature, Value: 20}, "m", 1)
+	if d.Text != "20" {
+		t.Error("wrong display value without units: ", d)
+	}
+
+	d = f.Display(Point{Type: PointTypeDescription, Text: "pump"}, "m", 1)
+	if d.Text != "pump" {
+		t.Error("wrong display value for text point: ", d)
+	}
+
+	n := Node{Points: Points{{Type: PointTypeUnitSystem, Text: PointValueImperial}}}
+	if n.DisplayFormat() != (DisplayFormat{Locale: "en-US", UnitSystem: PointValueImperial}) {
+		t.Error("wrong node display format: ", n.DisplayFormat())
+	}
+}
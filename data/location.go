@@ -0,0 +1,55 @@
+package data
+
+import "time"
+
+// NodeLocation describes where a node is located and its current status.
+// Locations are set with the latitude, longitude, and site points on a
+// node, and are used by tools that render fleet maps.
+type NodeLocation struct {
+	ID          string    `json:"id"`
+	Type        string    `json:"type"`
+	Description string    `json:"description"`
+	Site        string    `json:"site,omitempty"`
+	Latitude    float64   `json:"latitude"`
+	Longitude   float64   `json:"longitude"`
+	State       string    `json:"state,omitempty"`
+	LastUpdate  time.Time `json:"lastUpdate"`
+}
+
+// Location returns the location of a node. ok is false if the node does not
+// have a valid latitude and longitude.
+func (n NodeEdge) Location() (NodeLocation, bool) {
+	lat, okLat := n.Points.Find(PointTypeLatitude, "")
+	long, okLong := n.Points.Find(PointTypeLongitude, "")
+
+	if !okLat || !okLong {
+		return NodeLocation{}, false
+	}
+
+	if lat.Value < -90 || lat.Value > 90 || long.Value < -180 || long.Value > 180 {
+		return NodeLocation{}, false
+	}
+
+	node := n.ToNode()
+	state, _ := node.GetState()
+	site, _ := n.Points.Text(PointTypeSite, "")
+
+	return NodeLocation{
+		ID:          n.ID,
+		Type:        n.Type,
+		Description: n.Points.Desc(),
+		Site:        site,
+		Latitude:    lat.Value,
+		Longitude:   long.Value,
+		State:       state,
+		LastUpdate:  n.Points.LatestTime(),
+	}, true
+}
+
+// ToPoints converts a GPS position to latitude and longitude points
+func (p GpsPos) ToPoints(t time.Time) Points {
+	return Points{
+		{Time: t, Type: PointTypeLatitude, Value: p.Lat},
+		{Time: t, Type: PointTypeLongitude, Value: p.Long},
+	}
+}
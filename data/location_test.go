@@ -0,0 +1,42 @@
+package data
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNodeLocation(t *testing.T) {
+	now := time.Now()
+
+	n := NodeEdge{
+		ID:   "1234",
+		Type: NodeTypeDevice,
+		Points: Points{
+			{Time: now, Type: PointTypeDescription, Text: "pump station"},
+			{Time: now, Type: PointTypeSite, Text: "north"},
+			{Time: now, Type: PointTypeSysState, Text: PointValueSysStateOnline},
+		},
+	}
+
+	if _, ok := n.Location(); ok {
+		t.Fatal("node without lat/long should not have location")
+	}
+
+	n.Points = append(n.Points, GpsPos{Lat: 43.1, Long: -85.2}.ToPoints(now)...)
+
+	l, ok := n.Location()
+	if !ok {
+		t.Fatal("node should have location")
+	}
+
+	if l.Latitude != 43.1 || l.Longitude != -85.2 || l.Site != "north" ||
+		l.Description != "pump station" || l.State != PointValueSysStateOnline {
+		t.Errorf("location is not correct: %+v", l)
+	}
+
+	n.Points.Add(Point{Time: now.Add(time.Second), Type: PointTypeLatitude, Value: 91})
+
+	if _, ok := n.Location(); ok {
+		t.Error("invalid latitude should not have location")
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"encoding/base64"
 	"errors"
 	"fmt"
+	"sort"
 	"time"
 
 	"github.com/simpleiot/simpleiot/internal/pb"
@@ -148,6 +149,24 @@ func (nodes *Nodes) ToPb() ([]byte, error) {
 	return proto.Marshal(&pb.Nodes{Nodes: pbNodes})
 }
 
+// Sort orders nodes by edge weight (lowest first). Nodes with the same weight
+// are ordered by description and then ID so the order is always the same.
+func (nodes Nodes) Sort() {
+	sort.SliceStable(nodes, func(i, j int) bool {
+		wi, wj := nodes[i].EdgeWeight(), nodes[j].EdgeWeight()
+		if wi != wj {
+			return wi < wj
+		}
+
+		di, dj := nodes[i].Desc(), nodes[j].Desc()
+		if di != dj {
+			return di < dj
+		}
+
+		return nodes[i].ID < nodes[j].ID
+	})
+}
+
 // ToPbNodes converts a list of nodes to protobuf nodes
 func (nodes *Nodes) ToPbNodes() ([]*pb.Node, error) {
 	pbNodes := make([]*pb.Node, len(*nodes))
@@ -221,6 +240,31 @@ func (n NodeEdge) IsTombstone() (bool, time.Time) {
 	return FloatToBool(p.Value), p.Time
 }
 
+// EdgeRole returns the role edge point of the node
+func (n NodeEdge) EdgeRole() string {
+	r, _ := n.EdgePoints.Text(PointTypeRole, "")
+	return r
+}
+
+// EdgeWeight returns the weight edge point of the node, which is used to order
+// children. Nodes without a weight have a weight of 0.
+func (n NodeEdge) EdgeWeight() float64 {
+	w, _ := n.EdgePoints.Value(PointTypeWeight, "")
+	return w
+}
+
+// EdgeMeta returns the edge points that describe the relationship to the
+// parent (everything except the tombstone).
+func (n NodeEdge) EdgeMeta() Points {
+	var ret Points
+	for _, p := range n.EdgePoints {
+		if p.Type != PointTypeTombstone {
+			ret = append(ret, p)
+		}
+	}
+	return ret
+}
+
 // Desc returns Description if set, otherwise ID
 func (n NodeEdge) Desc() string {
 	desc := n.Points.Desc()
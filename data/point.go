@@ -3,12 +3,18 @@ package data
 import (
 	"crypto/md5"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"hash/crc32"
+	"hash/fnv"
+	"math"
+	"sort"
+	"strconv"
 	"time"
 
 	"github.com/golang/protobuf/ptypes"
 	"github.com/simpleiot/simpleiot/internal/pb"
+	"google.golang.org/protobuf/encoding/protowire"
 	"google.golang.org/protobuf/proto"
 )
 
@@ -56,6 +62,20 @@ type Point struct {
 
 	// Where did this point come from. If from the owning node, it may be blank.
 	Origin string `json:"origin"`
+
+	// Optional key/value metadata such as source identifiers or
+	// engineering ranges. Meta is not used to identify a point, so it
+	// does not affect merging or hashing.
+	Meta map[string]string `json:"meta,omitempty"`
+
+	// Quality of the point value (see PointQuality* constants). Blank means
+	// good, so most points do not need to set it.
+	Quality string `json:"quality,omitempty"`
+}
+
+// GoodQuality returns true if the point does not have a bad quality flag
+func (p Point) GoodQuality() bool {
+	return p.Quality == "" || p.Quality == PointQualityGood
 }
 
 // CRC returns a CRC for the point
@@ -98,6 +118,14 @@ func (p Point) String() string {
 		t += fmt.Sprintf("O:%v ", p.Origin)
 	}
 
+	if len(p.Meta) > 0 {
+		t += fmt.Sprintf("M:%v ", p.Meta)
+	}
+
+	if p.Quality != "" {
+		t += fmt.Sprintf("Q:%v ", p.Quality)
+	}
+
 	t += p.Time.Format(time.RFC3339)
 
 	return t
@@ -116,6 +144,46 @@ func (p Point) IsMatch(typ, key string) bool {
 	return true
 }
 
+// OriginOrNode returns the point origin, or nodeID if the origin is not set,
+// which means the point was generated by the node that owns it.
+func (p Point) OriginOrNode(nodeID string) string {
+	if p.Origin != "" {
+		return p.Origin
+	}
+	return nodeID
+}
+
+// FillOrigin returns a copy of the points with the origin of points that
+// have none set to nodeID (see OriginOrNode)
+func (ps Points) FillOrigin(nodeID string) Points {
+	ret := make(Points, len(ps))
+	for i, p := range ps {
+		p.Origin = p.OriginOrNode(nodeID)
+		ret[i] = p
+	}
+	return ret
+}
+
+// Replaces returns true if p should replace the existing point with the same
+// type and key. The newest point wins. If the times are equal, the origin,
+// value, and text are compared so that every instance that receives the same
+// points ends up with the same value, regardless of the order they arrive in.
+func (p Point) Replaces(existing Point) bool {
+	if !p.Time.Equal(existing.Time) {
+		return p.Time.After(existing.Time)
+	}
+
+	if p.Origin != existing.Origin {
+		return p.Origin > existing.Origin
+	}
+
+	if p.Value != existing.Value {
+		return p.Value > existing.Value
+	}
+
+	return p.Text >= existing.Text
+}
+
 // ToPb encodes point in protobuf format
 func (p Point) ToPb() (pb.Point, error) {
 	ts, err := ptypes.TimestampProto(p.Time)
@@ -132,6 +200,8 @@ func (p Point) ToPb() (pb.Point, error) {
 		Time:      ts,
 		Tombstone: int32(p.Tombstone),
 		Origin:    p.Origin,
+		Meta:      p.Meta,
+		Quality:   p.Quality,
 	}, nil
 }
 
@@ -257,8 +327,43 @@ func (ps *Points) Hash() []byte {
 	return h.Sum(nil)
 }
 
+// Version returns a version string for the points that changes whenever a
+// point is updated. It is used as an ETag for nodes.
+func (ps Points) Version() string {
+	sorted := make(Points, len(ps))
+	copy(sorted, ps)
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Type != sorted[j].Type {
+			return sorted[i].Type < sorted[j].Type
+		}
+		return sorted[i].Key < sorted[j].Key
+	})
+
+	h := fnv.New64a()
+	d := make([]byte, 8)
+
+	for _, p := range sorted {
+		h.Write([]byte(p.Type))
+		h.Write([]byte{0})
+		h.Write([]byte(p.Key))
+		h.Write([]byte{0})
+		binary.LittleEndian.PutUint64(d, uint64(p.Time.UnixNano()))
+		h.Write(d)
+		binary.LittleEndian.PutUint64(d, math.Float64bits(p.Value))
+		h.Write(d)
+		binary.LittleEndian.PutUint64(d, uint64(p.Tombstone))
+		h.Write(d)
+		h.Write([]byte(p.Text))
+		h.Write([]byte{0})
+		h.Write([]byte(p.Origin))
+		h.Write([]byte{0})
+	}
+
+	return strconv.FormatUint(h.Sum64(), 16)
+}
+
 // Add takes a point and updates an existing array of points. Existing points
-// are replaced if the Timestamp in pIn is > than the existing timestamp. If
+// are replaced if pIn replaces the existing point (see Point.Replaces). If
 // the pIn timestamp is zero, the current time is used.
 func (ps *Points) Add(pIn Point) {
 	pFound := false
@@ -276,7 +381,7 @@ func (ps *Points) Add(pIn Point) {
 				tombstone = pIn.Tombstone
 			}
 
-			if pIn.Time.After(p.Time) {
+			if pIn.Replaces(p) {
 				(*ps)[i] = pIn
 			}
 			(*ps)[i].Tombstone = tombstone
@@ -305,12 +410,69 @@ func (ps Points) Swap(i, j int) {
 	ps[i], ps[j] = ps[j], ps[i]
 }
 
+// Limits used when decoding points from the network, serial ports, or other
+// untrusted sources. These keep hostile input from causing large allocations.
+const (
+	// MaxDecodeSize is the max number of bytes accepted when decoding. This
+	// matches the default NATS max payload.
+	MaxDecodeSize = 1024 * 1024
+
+	// MaxDecodePoints is the max number of points accepted in one message.
+	MaxDecodePoints = 10000
+)
+
+// Errors returned when decoding points. Use errors.Is to check which type
+// of error occurred.
+var (
+	ErrDecodeTooLarge      = errors.New("data too large")
+	ErrDecodeTooManyPoints = errors.New("too many points")
+	ErrDecodeMalformed     = errors.New("malformed data")
+)
+
+// PbCheckPoints verifies that protobuf data is under the decode limits before
+// it is unmarshalled. field is the protobuf field number of the repeated
+// points field in the message. The message is not fully parsed, so the data
+// may still fail to unmarshal.
+func PbCheckPoints(d []byte, field int) error {
+	if len(d) > MaxDecodeSize {
+		return fmt.Errorf("%w: %v bytes", ErrDecodeTooLarge, len(d))
+	}
+
+	count := 0
+
+	for len(d) > 0 {
+		num, typ, n := protowire.ConsumeTag(d)
+		if n < 0 {
+			return fmt.Errorf("%w: %v", ErrDecodeMalformed, protowire.ParseError(n))
+		}
+		d = d[n:]
+
+		n = protowire.ConsumeFieldValue(num, typ, d)
+		if n < 0 {
+			return fmt.Errorf("%w: %v", ErrDecodeMalformed, protowire.ParseError(n))
+		}
+		d = d[n:]
+
+		if num == protowire.Number(field) {
+			count++
+			if count > MaxDecodePoints {
+				return ErrDecodeTooManyPoints
+			}
+		}
+	}
+
+	return nil
+}
+
 //PbToPoint converts pb point to point
 func PbToPoint(sPb *pb.Point) (Point, error) {
+	if sPb == nil {
+		return Point{}, fmt.Errorf("%w: nil point", ErrDecodeMalformed)
+	}
 
 	ts, err := ptypes.Timestamp(sPb.Time)
 	if err != nil {
-		return Point{}, err
+		return Point{}, fmt.Errorf("%w: %v", ErrDecodeMalformed, err)
 	}
 
 	ret := Point{
@@ -322,19 +484,30 @@ func PbToPoint(sPb *pb.Point) (Point, error) {
 		Time:      ts,
 		Tombstone: int(sPb.Tombstone),
 		Origin:    sPb.Origin,
+		Quality:   sPb.Quality,
+	}
+
+	if len(sPb.Meta) > 0 {
+		ret.Meta = sPb.Meta
 	}
 
 	return ret, nil
 }
 
-// PbDecodePoints decode protobuf encoded points
+// PbDecodePoints decode protobuf encoded points. Errors wrap
+// ErrDecodeTooLarge, ErrDecodeTooManyPoints, or ErrDecodeMalformed.
 func PbDecodePoints(data []byte) (Points, error) {
-	pbPoints := &pb.Points{}
-	err := proto.Unmarshal(data, pbPoints)
+	err := PbCheckPoints(data, 1)
 	if err != nil {
 		return []Point{}, err
 	}
 
+	pbPoints := &pb.Points{}
+	err = proto.Unmarshal(data, pbPoints)
+	if err != nil {
+		return []Point{}, fmt.Errorf("%w: %v", ErrDecodeMalformed, err)
+	}
+
 	ret := make([]Point, len(pbPoints.Points))
 
 	for i, sPb := range pbPoints.Points {
@@ -348,6 +521,37 @@ func PbDecodePoints(data []byte) (Points, error) {
 	return ret, nil
 }
 
+// PbDecodePointsRequest decodes a protobuf encoded points request response.
+// If the response contains an error, it is returned.
+func PbDecodePointsRequest(data []byte) (Points, error) {
+	err := PbCheckPoints(data, 1)
+	if err != nil {
+		return []Point{}, err
+	}
+
+	pbPointsRequest := &pb.PointsRequest{}
+	err = proto.Unmarshal(data, pbPointsRequest)
+	if err != nil {
+		return []Point{}, fmt.Errorf("%w: %v", ErrDecodeMalformed, err)
+	}
+
+	if pbPointsRequest.Error != "" {
+		return []Point{}, errors.New(pbPointsRequest.Error)
+	}
+
+	ret := make([]Point, len(pbPointsRequest.Points))
+
+	for i, sPb := range pbPointsRequest.Points {
+		s, err := PbToPoint(sPb)
+		if err != nil {
+			return []Point{}, err
+		}
+		ret[i] = s
+	}
+
+	return ret, nil
+}
+
 // PointFilter is used to send points upstream. It only sends
 // the data has changed, and at a max frequency
 type PointFilter struct {
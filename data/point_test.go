@@ -1,6 +1,8 @@
 package data
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 	"reflect"
 	"sort"
@@ -51,3 +53,160 @@ func TestPointCRC(t *testing.T) {
 		t.Error("CRC is weak")
 	}
 }
+
+func TestPbDecodePointsLimits(t *testing.T) {
+	_, err := PbDecodePoints(make([]byte, MaxDecodeSize+1))
+	if !errors.Is(err, ErrDecodeTooLarge) {
+		t.Error("Expected ErrDecodeTooLarge, got: ", err)
+	}
+
+	// each empty point is encoded as a tag and a zero length
+	d := bytes.Repeat([]byte{0x0a, 0x00}, MaxDecodePoints+1)
+	_, err = PbDecodePoints(d)
+	if !errors.Is(err, ErrDecodeTooManyPoints) {
+		t.Error("Expected ErrDecodeTooManyPoints, got: ", err)
+	}
+
+	_, err = PbDecodePoints([]byte{0x0a, 0x05, 0x01})
+	if !errors.Is(err, ErrDecodeMalformed) {
+		t.Error("Expected ErrDecodeMalformed, got: ", err)
+	}
+}
+
+func FuzzPbDecodePoints(f *testing.F) {
+	pts := Points{
+		{Type: PointTypeValue, Value: 1.5, Time: time.Now()},
+		{Type: PointTypeDescription, Text: "test", Key: "a"},
+	}
+
+	seed, err := pts.ToPb()
+	if err != nil {
+		f.Fatal(err)
+	}
+
+	f.Add(seed)
+	f.Add([]byte{})
+	f.Add([]byte{0x0a, 0x00})
+
+	f.Fuzz(func(t *testing.T, d []byte) {
+		pts, err := PbDecodePoints(d)
+		if err != nil {
+			if !errors.Is(err, ErrDecodeTooLarge) &&
+				!errors.Is(err, ErrDecodeTooManyPoints) &&
+				!errors.Is(err, ErrDecodeMalformed) {
+				t.Fatal("Unclassified decode error: ", err)
+			}
+			return
+		}
+
+		if len(pts) > MaxDecodePoints {
+			t.Fatal("Decoded too many points: ", len(pts))
+		}
+	})
+}
+
+func TestPointMetaPb(t *testing.T) {
+	p := Point{Time: time.Now(), Type: PointTypeValue, Value: 1,
+		Meta: map[string]string{"unit": "°C", "source": "modbus"}}
+
+	pPb, err := p.ToPb()
+	if err != nil {
+		t.Fatal("ToPb error: ", err)
+	}
+
+	p2, err := PbToPoint(&pPb)
+	if err != nil {
+		t.Fatal("PbToPoint error: ", err)
+	}
+
+	if !reflect.DeepEqual(p.Meta, p2.Meta) {
+		t.Errorf("meta mismatch, exp %v, got %v", p.Meta, p2.Meta)
+	}
+
+	// meta does not affect the point CRC
+	p3 := p
+	p3.Meta = nil
+	if p.CRC() != p3.CRC() {
+		t.Error("meta should not change CRC")
+	}
+}
+
+func TestPointQuality(t *testing.T) {
+	p := Point{Time: time.Now(), Type: PointTypeValue, Value: 1,
+		Quality: PointQualityStale}
+
+	if p.GoodQuality() {
+		t.Error("stale point should not be good quality")
+	}
+
+	pPb, err := p.ToPb()
+	if err != nil {
+		t.Fatal("ToPb error: ", err)
+	}
+
+	p2, err := PbToPoint(&pPb)
+	if err != nil {
+		t.Fatal("PbToPoint error: ", err)
+	}
+
+	if p2.Quality != PointQualityStale {
+		t.Error("quality not decoded, got: ", p2.Quality)
+	}
+
+	for _, q := range []string{"", PointQualityGood} {
+		if !(Point{Quality: q}).GoodQuality() {
+			t.Errorf("quality %q should be good", q)
+		}
+	}
+}
+
+func TestPointReplaces(t *testing.T) {
+	now := time.Now()
+	p := Point{Time: now, Type: PointTypeValue, Value: 1, Origin: "a"}
+
+	tests := []struct {
+		desc string
+		pIn  Point
+		exp  bool
+	}{
+		{"newer", Point{Time: now.Add(time.Second), Value: 0}, true},
+		{"older", Point{Time: now.Add(-time.Second), Value: 2, Origin: "b"}, false},
+		{"same", p, true},
+		{"origin wins", Point{Time: now, Value: 0, Origin: "b"}, true},
+		{"origin loses", Point{Time: now, Value: 2, Origin: ""}, false},
+		{"value wins", Point{Time: now, Value: 2, Origin: "a"}, true},
+		{"value loses", Point{Time: now, Value: 0, Origin: "a"}, false},
+	}
+
+	for _, test := range tests {
+		if got := test.pIn.Replaces(p); got != test.exp {
+			t.Errorf("%v: expected %v, got %v", test.desc, test.exp, got)
+		}
+
+		// conflicts must resolve the same way regardless of order
+		if test.desc != "same" && test.pIn.Time.Equal(p.Time) &&
+			p.Replaces(test.pIn) == test.exp {
+			t.Errorf("%v: conflict not resolved the same in both directions", test.desc)
+		}
+	}
+}
+
+func TestPointsVersion(t *testing.T) {
+	now := time.Now()
+	ps := Points{
+		{Time: now, Type: PointTypeDescription, Text: "a"},
+		{Time: now, Type: PointTypeValue, Value: 1},
+	}
+
+	v := ps.Version()
+
+	if (Points{ps[1], ps[0]}).Version() != v {
+		t.Error("version depends on point order")
+	}
+
+	ps2 := Points{ps[0], ps[1]}
+	ps2[1].Value = 2
+	if ps2.Version() == v {
+		t.Error("version did not change when value changed")
+	}
+}
@@ -0,0 +1,71 @@
+package data
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+)
+
+// PushSubscription is a browser Web Push subscription, in the format of
+// PushSubscription.toJSON()
+type PushSubscription struct {
+	Endpoint string `json:"endpoint"`
+	Keys     struct {
+		P256dh string `json:"p256dh"`
+		Auth   string `json:"auth"`
+	} `json:"keys"`
+}
+
+// Key returns the point key the subscription is stored with. Each endpoint
+// has its own key, so a browser that subscribes again replaces its old
+// subscription.
+func (s PushSubscription) Key() string {
+	h := sha256.Sum256([]byte(s.Endpoint))
+	return hex.EncodeToString(h[:8])
+}
+
+// Validate checks that the subscription has an endpoint and keys
+func (s PushSubscription) Validate() error {
+	if s.Endpoint == "" {
+		return errors.New("push subscription endpoint is missing")
+	}
+
+	if s.Keys.P256dh == "" || s.Keys.Auth == "" {
+		return errors.New("push subscription keys are missing")
+	}
+
+	return nil
+}
+
+// ToPoint converts the subscription to a point for the user node
+func (s PushSubscription) ToPoint() (Point, error) {
+	d, err := json.Marshal(s)
+	if err != nil {
+		return Point{}, err
+	}
+
+	return Point{Type: PointTypePushSubscription, Key: s.Key(), Text: string(d)}, nil
+}
+
+// PushSubscriptions returns the push subscriptions in points. Invalid
+// subscriptions are skipped.
+func PushSubscriptions(points Points) []PushSubscription {
+	var ret []PushSubscription
+	for _, p := range points {
+		if p.Type != PointTypePushSubscription || p.Tombstone != 0 {
+			continue
+		}
+
+		var s PushSubscription
+		if err := json.Unmarshal([]byte(p.Text), &s); err != nil {
+			continue
+		}
+
+		if s.Validate() == nil {
+			ret = append(ret, s)
+		}
+	}
+
+	return ret
+}
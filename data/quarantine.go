@@ -0,0 +1,15 @@
+package data
+
+import "time"
+
+// QuarantinedPoint is a node point that failed validation in the store, for
+// example a point rejected by a clock skew policy. Quarantined points are
+// kept so they can be reviewed, and written to the node if they turn out to
+// be valid.
+type QuarantinedPoint struct {
+	ID     int64     `json:"id"`
+	NodeID string    `json:"nodeID"`
+	Time   time.Time `json:"time"`
+	Reason string    `json:"reason"`
+	Point  Point     `json:"point"`
+}
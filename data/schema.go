@@ -64,11 +64,27 @@ const (
 	PointTypeEmail     = "email"
 	PointTypePass      = "pass"
 
+	// NodeTypeDistList is a distribution list of email addresses and phone
+	// numbers (email and phone points, one per key) that get notifications
+	// without a user account
+	NodeTypeDistList = "distList"
+
 	// user edge points
 	PointTypeRole       = "role"
 	PointValueRoleAdmin = "admin"
 	PointValueRoleUser  = "user"
 
+	// edge metadata points. Role is also used to describe the relationship
+	// between a node and its parents, and weight orders children (lower
+	// weights first).
+	PointTypeWeight            = "weight"
+	PointValueRolePrimary      = "primary"
+	PointValueRoleBackupParent = "backup-parent"
+
+	// PointTypeShard is an edge point that places a new subtree in a store
+	// shard
+	PointTypeShard = "shard"
+
 	// User Authentication
 	NodeTypeJWT    = "jwt"
 	PointTypeToken = "token"
@@ -126,6 +142,7 @@ const (
 	PointTypeConditionType = "conditionType"
 	PointValuePointValue   = "pointValue"
 	PointValueSchedule     = "schedule"
+	PointValueGeofence     = "geofence"
 
 	PointTypeTrigger = "trigger"
 
@@ -133,6 +150,27 @@ const (
 	PointTypeEnd     = "end"
 	PointTypeWeekday = "weekday"
 
+	// schedule conditions can skip the dates of a calendar node, or only
+	// be active on them
+	PointTypeCalendarID   = "calendarID"
+	PointTypeCalendarMode = "calendarMode"
+	PointValueExclude     = "exclude"
+	PointValueInclude     = "include"
+
+	// calendar nodes hold a list of dates, such as holidays. The key of a
+	// date point is the date (2006-01-02), and the text is its name.
+	NodeTypeCalendar = "calendar"
+	PointTypeDate    = "date"
+
+	// geofence conditions check the latitude and longitude points of a node
+	// against a zone node, which is a circle or a polygon
+	PointTypeZoneID   = "zoneID"
+	PointValueInside  = "inside"
+	PointValueOutside = "outside"
+	NodeTypeZone      = "zone"
+	PointTypeRadius   = "radius"
+	PointTypePolygon  = "polygon"
+
 	PointTypePointID    = "pointID"
 	PointTypePointKey   = "pointKey"
 	PointTypePointType  = "pointType"
@@ -186,7 +224,32 @@ const (
 
 	NodeTypeUpstream = "upstream"
 
+	// TLS client certificates of upstream connections. The values are PEM
+	// file names or PEM data.
+	PointTypeTLSCert = "tlsCert"
+	PointTypeTLSKey  = "tlsKey"
+	PointTypeTLSCA   = "tlsCA"
+
+	// PointTypeCertExpires is the number of days until a certificate
+	// expires. The key identifies the certificate.
+	PointTypeCertExpires = "certExpires"
+
+	// PointTypeCertAuto is set on an upstream node to request and rotate a
+	// client certificate from the upstream certificate authority
+	PointTypeCertAuto = "certAuto"
+
+	NodeTypeCertAuthority = "certAuthority"
+
+	PointTypeCertDays    = "certDays"
+	PointTypeCertSerial  = "certSerial"
+	PointTypeCertStatus  = "certStatus"
+	PointTypeCertRevoked = "certRevoked"
+
+	PointValueValid   = "valid"
+	PointValueRevoked = "revoked"
+
 	PointTypeMetricNatsCycleNodePoint          = "metricNatsCycleNodePoint"
+	PointTypeMetricNatsCycleNodePointControl   = "metricNatsCycleNodePointControl"
 	PointTypeMetricNatsCycleNodeEdgePoint      = "metricNatsCycleNodeEdgePoint"
 	PointTypeMetricNatsCycleNode               = "metricNatsCycleNode"
 	PointTypeMetricNatsCycleNodeChildren       = "metricNatsCycleNodeChildren"
@@ -195,6 +258,12 @@ const (
 	PointTypeMetricNatsThroughputNodePoint     = "metricNatsThroughputNodePoint"
 	PointTypeMetricNatsThroughputNodeEdgePoint = "metricNatsThroughputNodeEdgePoint"
 
+	// store point throughput, keyed by point type or node type
+	PointTypeMetricPointTypeRate     = "metricPointTypeRate"
+	PointTypeMetricPointTypeByteRate = "metricPointTypeByteRate"
+	PointTypeMetricNodeTypeRate      = "metricNodeTypeRate"
+	PointTypeMetricNodeTypeByteRate  = "metricNodeTypeByteRate"
+
 	// serial MCU clients
 	NodeTypeSerialDev = "serialDev"
 	PointTypeRx       = "rx"
@@ -203,10 +272,434 @@ const (
 	PointTypeTxReset  = "txReset"
 	PointTypeLog      = "log"
 	PointTypeUptime   = "uptime"
+	// used by MCUs to request the current time, and by SIOT to send it
+	PointTypeCurrentTime = "currentTime"
 
 	NodeTypeSignalGenerator = "signalGenerator"
 
 	PointTypeFrequency  = "frequency"
 	PointTypeAmplitude  = "amplitude"
 	PointTypeSampleRate = "sampleRate"
+
+	NodeTypeSimulator = "simulator"
+
+	PointTypeSimType      = "simType"
+	PointTypeSamplePeriod = "samplePeriod"
+	PointTypeStepSize     = "stepSize"
+
+	PointValueSine       = "sine"
+	PointValueSquare     = "square"
+	PointValueRandomWalk = "randomWalk"
+	PointValueCSV        = "csv"
+
+	NodeTypeSystemMonitor = "systemMonitor"
+
+	PointTypeMonitorDisk        = "monitorDisk"
+	PointTypeMonitorNetwork     = "monitorNetwork"
+	PointTypeMonitorTemperature = "monitorTemperature"
+	PointTypeMonitorUptime      = "monitorUptime"
+
+	PointTypeDiskUsed    = "diskUsed"
+	PointTypeDiskFree    = "diskFree"
+	PointTypeNetRxBytes  = "netRxBytes"
+	PointTypeNetTxBytes  = "netTxBytes"
+	PointTypeNetRxErrors = "netRxErrors"
+	PointTypeNetTxErrors = "netTxErrors"
+	PointTypeTemperature = "temperature"
+
+	NodeTypeHostControl = "hostControl"
+
+	PointTypeCommand        = "command"
+	PointTypeConfirm        = "confirm"
+	PointTypeCommandStatus  = "commandStatus"
+	PointTypeLastCommand    = "lastCommand"
+	PointTypeServiceName    = "serviceName"
+	PointTypeNetworkService = "networkService"
+
+	PointValueReboot         = "reboot"
+	PointValueShutdown       = "shutdown"
+	PointValueRestartService = "restartService"
+	PointValueRestartNetwork = "restartNetwork"
+
+	NodeTypeNetworkConfig = "networkConfig"
+
+	PointTypeInterface      = "interface"
+	PointTypeIPMode         = "ipMode"
+	PointTypeIPAddress      = "ipAddress"
+	PointTypeGateway        = "gateway"
+	PointTypeDNS            = "dns"
+	PointTypeWifiSSID       = "wifiSSID"
+	PointTypeWifiPSK        = "wifiPSK"
+	PointTypeAPN            = "apn"
+	PointTypeLinkState      = "linkState"
+	PointTypeSignalStrength = "signalStrength"
+	PointTypeIPAddresses    = "ipAddresses"
+
+	PointValueDHCP   = "dhcp"
+	PointValueStatic = "static"
+	PointValueUp     = "up"
+	PointValueDown   = "down"
+
+	NodeTypeModem = "modem"
+
+	PointTypeRSSI       = "rssi"
+	PointTypeRSRP       = "rsrp"
+	PointTypeRSRQ       = "rsrq"
+	PointTypeCarrier    = "carrier"
+	PointTypeModemState = "modemState"
+	PointTypeSimStatus  = "simStatus"
+
+	PointValueReset = "reset"
+
+	PointTypeStoreSize             = "storeSize"
+	PointTypeRetentionPrunedPoints = "retentionPrunedPoints"
+	PointTypeRetentionPrunedNodes  = "retentionPrunedNodes"
+	PointTypeRetentionLastPrune    = "retentionLastPrune"
+
+	NodeTypeCamera = "camera"
+
+	PointTypeResolution   = "resolution"
+	PointTypeDirectory    = "directory"
+	PointTypeMaxSnapshots = "maxSnapshots"
+	PointTypeCapture      = "capture"
+	PointTypeLastSnapshot = "lastSnapshot"
+
+	NodeTypeDiagnostics = "diagnostics"
+
+	PointTypeGoroutines      = "goroutines"
+	PointTypeHeapAlloc       = "heapAlloc"
+	PointTypeHeapSys         = "heapSys"
+	PointTypeNumGC           = "numGC"
+	PointTypeMaxProfiles     = "maxProfiles"
+	PointTypeProfileDuration = "profileDuration"
+	PointTypeCPUProfile      = "cpuProfile"
+	PointTypeHeapProfile     = "heapProfile"
+	PointTypeUploadDevice    = "uploadDevice"
+	PointTypeLastProfile     = "lastProfile"
+
+	PointTypeLatitude  = "latitude"
+	PointTypeLongitude = "longitude"
+	PointTypeSite      = "site"
+
+	NodeTypeWasmProcessor = "wasmProcessor"
+
+	NodeTypeScript = "script"
+
+	PointTypeScript = "script"
+
+	// point quality values (Point.Quality)
+	PointQualityGood        = "good"
+	PointQualityStale       = "stale"
+	PointQualityFailed      = "failedSensor"
+	PointQualitySubstituted = "substituted"
+	PointQualityClockSkew   = "clockSkew"
+
+	PointTypeIgnoreBadQuality = "ignoreBadQuality"
+
+	PointTypeStoreDedupSuppressed = "storeDedupSuppressed"
+
+	PointTypeStoreSlowHandlers = "storeSlowHandlers"
+	PointTypeStoreShedPoints   = "storeShedPoints"
+	PointTypeStoreOverload     = "storeOverload"
+
+	PointTypeStoreDuplicateMsgs = "storeDuplicateMsgs"
+
+	// clock skew policy for the points of a node and its descendants
+	PointTypeClockSkewPolicy      = "clockSkewPolicy"
+	PointValueReject              = "reject"
+	PointValueClamp               = "clamp"
+	PointValueFlag                = "flag"
+	PointTypeClockSkewLimit       = "clockSkewLimit"
+	PointMetaClockSkewTime        = "clockSkewTime"
+	PointTypeStoreClockSkewPoints = "storeClockSkewPoints"
+
+	PointTypeStoreQuarantinePoints = "storeQuarantinePoints"
+
+	PointTypeRecentLen = "recentLen"
+
+	PointTypeMeasurement = "measurement"
+	PointTypeTags        = "tags"
+	PointTypeFieldNames  = "fieldNames"
+
+	PointTypeDbType           = "dbType"
+	PointValueInflux2         = "influx2"
+	PointValueInflux1         = "influx1"
+	PointValueVictoriaMetrics = "victoriaMetrics"
+	PointValueBuiltin         = "builtin"
+	PointTypeArchiveURI       = "archiveURI"
+	PointTypeArchiveBucket    = "archiveBucket"
+	PointTypeArchiveRegion    = "archiveRegion"
+	PointTypeArchiveAccessKey = "archiveAccessKey"
+	PointTypeArchiveSecretKey = "archiveSecretKey"
+	PointTypeArchiveAfterDays = "archiveAfterDays"
+	PointTypeUsername         = "username"
+	PointTypePassword         = "password"
+	PointTypeDatabase         = "database"
+	PointTypeRetentionPolicy  = "retentionPolicy"
+
+	NodeTypeRuntimeStats = "runtimeStats"
+
+	PointTypeThreshold      = "threshold"
+	PointTypeRuntimeToday   = "runtimeToday"
+	PointTypeCyclesToday    = "cyclesToday"
+	PointTypeDutyCycleToday = "dutyCycleToday"
+	PointTypeRuntimeTotal   = "runtimeTotal"
+	PointTypeCyclesTotal    = "cyclesTotal"
+	PointTypeRuntimeDaily   = "runtimeDaily"
+	PointTypeCyclesDaily    = "cyclesDaily"
+	PointTypeDutyCycleDaily = "dutyCycleDaily"
+
+	NodeTypeIntegrator = "integrator"
+
+	PointTypeTariff        = "tariff"
+	PointTypeEnergyToday   = "energyToday"
+	PointTypeEnergyMonth   = "energyMonth"
+	PointTypeEnergyTotal   = "energyTotal"
+	PointTypeEnergyDaily   = "energyDaily"
+	PointTypeEnergyMonthly = "energyMonthly"
+	PointTypeCostToday     = "costToday"
+	PointTypeCostMonth     = "costMonth"
+	PointTypeCostTotal     = "costTotal"
+	PointTypeCostDaily     = "costDaily"
+	PointTypeCostMonthly   = "costMonthly"
+
+	NodeTypeTracker = "tracker"
+
+	PointTypeStopDistance  = "stopDistance"
+	PointTypeStopDuration  = "stopDuration"
+	PointTypeRetentionDays = "retentionDays"
+	PointTypeMoving        = "moving"
+	PointTypeTripDistance  = "tripDistance"
+	PointTypeTripDuration  = "tripDuration"
+	PointTypeTripCount     = "tripCount"
+	PointTypeDistanceTotal = "distanceTotal"
+
+	NodeTypeWeather = "weather"
+
+	PointTypeProvider                = "provider"
+	PointValueOpenWeatherMap         = "openWeatherMap"
+	PointValueNWS                    = "nws"
+	PointTypeAPIKey                  = "apiKey"
+	PointTypeHumidity                = "humidity"
+	PointTypeWindSpeed               = "windSpeed"
+	PointTypeWeatherDescription      = "weatherDescription"
+	PointTypeForecastTemperature     = "forecastTemperature"
+	PointTypeForecastTempMin         = "forecastTempMin"
+	PointTypeForecastTempMax         = "forecastTempMax"
+	PointTypeForecastRainProbability = "forecastRainProbability"
+	PointTypeForecastRain            = "forecastRain"
+
+	NodeTypeLoadShed = "loadShed"
+	NodeTypeShedLoad = "shedLoad"
+
+	PointTypeNodeID       = "nodeID"
+	PointTypeLimit        = "limit"
+	PointTypeRestoreLimit = "restoreLimit"
+	PointTypeDelay        = "delay"
+	PointTypeLoadsShed    = "loadsShed"
+	PointTypePriority     = "priority"
+	PointTypePower        = "power"
+	PointTypeInvert       = "invert"
+	PointTypeShed         = "shed"
+
+	NodeTypeStateMachine = "stateMachine"
+	NodeTypeSMState      = "smState"
+	NodeTypeSMTransition = "smTransition"
+	NodeTypeSMAction     = "smAction"
+
+	PointTypeState   = "state"
+	PointTypeInitial = "initial"
+	PointTypeTo      = "to"
+	PointTypeMinTime = "minTime"
+	PointTypeEvent   = "event"
+	PointValueEntry  = "entry"
+	PointValueExit   = "exit"
+
+	NodeTypeHealthMonitor = "healthMonitor"
+
+	PointTypeOfflineTime   = "offlineTime"
+	PointTypeBatteryLow    = "batteryLow"
+	PointTypeDiskHigh      = "diskHigh"
+	PointTypeBattery       = "battery"
+	PointTypeHealthScore   = "healthScore"
+	PointTypeHealthStatus  = "healthStatus"
+	PointTypeHealthReasons = "healthReasons"
+	PointValueHealthy      = "healthy"
+	PointValueWarning      = "warning"
+	PointValueCritical     = "critical"
+	PointTypeHealthyCount  = "healthyCount"
+	PointTypeWarningCount  = "warningCount"
+	PointTypeCriticalCount = "criticalCount"
+
+	NodeTypeSmartGroup   = "smartGroup"
+	PointTypeQuery       = "query"
+	PointTypeMember      = "member"
+	PointTypeMemberCount = "memberCount"
+
+	PointTypeTag = "tag"
+
+	PointTypeLocale     = "locale"
+	PointTypeUnitSystem = "unitSystem"
+	PointValueMetric    = "metric"
+	PointValueImperial  = "imperial"
+
+	// notification preferences of user nodes. Quiet hours are HH:MM times
+	// in the timezone of the user (an IANA name like America/New_York).
+	PointTypeDisableEmail   = "disableEmail"
+	PointTypeDisableSMS     = "disableSMS"
+	PointTypeNotifySeverity = "notifySeverity"
+	PointTypeQuietStart     = "quietStart"
+	PointTypeQuietEnd       = "quietEnd"
+	PointTypeTimezone       = "timezone"
+
+	// PointTypeSeverity is the severity of the notifications a node (for
+	// instance a rule) sends: info, warning, or critical
+	PointTypeSeverity = "severity"
+	PointValueInfo    = "info"
+
+	// web push. Browser push subscriptions are stored on user nodes, one
+	// per key. The VAPID key pair the server signs push requests with is
+	// stored on the root node.
+	PointTypePushSubscription = "pushSubscription"
+	PointTypeVapidPublicKey   = "vapidPublicKey"
+	PointTypeVapidPrivateKey  = "vapidPrivateKey"
+
+	// modbus data types, byte order, and scaling
+	PointValueUINT64    = "uint64"
+	PointValueINT64     = "int64"
+	PointValueFLOAT64   = "float64"
+	PointTypeByteOrder  = "byteOrder"
+	PointValueABCD      = "ABCD"
+	PointValueBADC      = "BADC"
+	PointValueCDAB      = "CDAB"
+	PointValueDCBA      = "DCBA"
+	PointTypePolynomial = "polynomial"
+	PointTypeBitOffset  = "bitOffset"
+	PointTypeBitCount   = "bitCount"
+
+	// modbus IO polling
+	PointTypePollPriority = "pollPriority"
+	PointValueHigh        = "high"
+	PointValueNormal      = "normal"
+	PointValueLow         = "low"
+
+	// modbus write queue
+	PointTypeWriteStatus  = "writeStatus"
+	PointValuePending     = "pending"
+	PointValueConfirmed   = "confirmed"
+	PointValueWriteFailed = "failed"
+
+	// IEC 62056 meter reader
+	NodeTypeMeterReader   = "meterReader"
+	NodeTypeMeter         = "meter"
+	PointTypeMeterID      = "meterID"
+	PointTypeManufacturer = "manufacturer"
+	PointTypeEnergyImport = "energyImport"
+	PointTypeEnergyExport = "energyExport"
+	PointTypeDemand       = "demand"
+	PointTypeVoltage      = "voltage"
+	PointTypeCurrent      = "current"
+	PointTypeOBIS         = "obis"
+
+	// M-Bus
+	NodeTypeMbus      = "mbus"
+	NodeTypeMbusMeter = "mbusMeter"
+	PointTypeScan     = "scan"
+	PointTypeMedium   = "medium"
+
+	// KNX
+	NodeTypeKnx      = "knx"
+	NodeTypeKnxGroup = "knxGroup"
+	PointTypeDPT     = "dpt"
+
+	// MQTT bridge
+	NodeTypeMqttBridge    = "mqttBridge"
+	NodeTypeMqttDevice    = "mqttDevice"
+	PointTypeBaseTopic    = "baseTopic"
+	PointValueZigbee2MQTT = "zigbee2mqtt"
+	PointValueZwaveJS     = "zwavejs"
+	PointTypeDeviceID     = "deviceID"
+	PointTypeModel        = "model"
+	PointTypeSwitch       = "switch"
+	PointTypeLevel        = "level"
+	PointTypeLinkQuality  = "linkQuality"
+
+	// CoAP server
+	NodeTypeCoapServer = "coapServer"
+	NodeTypeCoapDevice = "coapDevice"
+	PointTypeIdentity  = "identity"
+	PointTypePSK       = "psk"
+
+	// UDP ingest
+	NodeTypeUdpIngest   = "udpIngest"
+	NodeTypeUdpSource   = "udpSource"
+	PointTypeDecoder    = "decoder"
+	PointValueJSON      = "json"
+	PointValueKeyValue  = "keyValue"
+	PointValueWasm      = "wasm"
+	PointTypeAddSources = "addSources"
+
+	// syslog
+	NodeTypeSyslog       = "syslog"
+	NodeTypeSyslogRule   = "syslogRule"
+	PointValueUDP        = "UDP"
+	PointTypeMaxFileSize = "maxFileSize"
+	PointTypePattern     = "pattern"
+	PointTypeHost        = "host"
+
+	// network monitor
+	NodeTypeNetworkMonitor = "networkMonitor"
+	NodeTypeNetworkTarget  = "networkTarget"
+	PointTypeTimeout       = "timeout"
+	PointTypeOnline        = "online"
+	PointTypeLatency       = "latency"
+	PointTypePacketLoss    = "packetLoss"
+
+	// WAN monitor
+	NodeTypeWanMonitor     = "wanMonitor"
+	PointTypePingCount     = "pingCount"
+	PointTypeSpeedTestURL  = "speedTestURL"
+	PointTypeJitter        = "jitter"
+	PointTypeDownloadSpeed = "downloadSpeed"
+
+	// LAN inventory
+	NodeTypeLanInventory = "lanInventory"
+	NodeTypeLanHost      = "lanHost"
+	PointTypeMAC         = "mac"
+	PointTypeHostname    = "hostname"
+
+	// client supervision
+	PointTypeRestart      = "restart"
+	PointTypeRestartCount = "restartCount"
+	PointTypeLastError    = "lastError"
+
+	// filtered point subscriptions
+	PointTypeSubject   = "subject"
+	PointTypeMinChange = "minChange"
+
+	// store backup
+	NodeTypeBackup          = "backup"
+	PointTypeDestination    = "destination"
+	PointValueLocal         = "local"
+	PointValueSFTP          = "sftp"
+	PointValueS3            = "s3"
+	PointTypeRegion         = "region"
+	PointTypeAccessKey      = "accessKey"
+	PointTypeSecretKey      = "secretKey"
+	PointTypeHostKey        = "hostKey"
+	PointTypeMaxBackups     = "maxBackups"
+	PointTypeBackupNow      = "backupNow"
+	PointTypeLastBackup     = "lastBackup"
+	PointTypeLastBackupSize = "lastBackupSize"
+	PointTypeBackupError    = "backupError"
+
+	// share links
+	NodeTypeShare    = "share"
+	PointTypeExpires = "expires"
+
+	// file nodes
+	NodeTypeFile    = "file"
+	PointTypeName   = "name"
+	PointTypeData   = "data"
+	PointTypeBinary = "binary"
 )
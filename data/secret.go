@@ -0,0 +1,56 @@
+package data
+
+// SecretMask replaces the text of secret points in API responses. Secret
+// points written with this text are ignored, so clients can send back a
+// node they received without overwriting its secrets.
+const SecretMask = "********"
+
+// SecretPointTypes are point types that hold credentials. Secret points are
+// encrypted in the store, masked in API responses, and not synchronized
+// with upstream instances.
+var SecretPointTypes = []string{
+	PointTypePass,
+	PointTypePassword,
+	PointTypeToken,
+	PointTypeAuthToken,
+	PointTypeAPIKey,
+	PointTypeTLSKey,
+	PointTypePushSubscription,
+	PointTypeVapidPrivateKey,
+	PointTypeArchiveSecretKey,
+	PointTypeSecretKey,
+}
+
+// IsSecret returns true if the point holds a credential
+func (p Point) IsSecret() bool {
+	for _, t := range SecretPointTypes {
+		if p.Type == t {
+			return true
+		}
+	}
+	return false
+}
+
+// MaskSecrets returns a copy of the points with the text of secret points
+// replaced by SecretMask
+func (ps Points) MaskSecrets() Points {
+	ret := make(Points, len(ps))
+	for i, p := range ps {
+		if p.IsSecret() && p.Text != "" {
+			p.Text = SecretMask
+		}
+		ret[i] = p
+	}
+	return ret
+}
+
+// RemoveSecrets returns the points that are not secret
+func (ps Points) RemoveSecrets() Points {
+	var ret Points
+	for _, p := range ps {
+		if !p.IsSecret() {
+			ret = append(ret, p)
+		}
+	}
+	return ret
+}
@@ -1,6 +1,9 @@
 package data
 
-import "time"
+import (
+	"log"
+	"time"
+)
 
 // User represents a user of the system
 type User struct {
@@ -10,6 +13,85 @@ type User struct {
 	Phone     string `json:"phone"`
 	Email     string `json:"email"`
 	Pass      string `json:"pass"`
+
+	// notification preferences
+	DisableEmail   bool   `json:"disableEmail"`
+	DisableSMS     bool   `json:"disableSMS"`
+	NotifySeverity string `json:"notifySeverity"`
+	QuietStart     string `json:"quietStart"`
+	QuietEnd       string `json:"quietEnd"`
+	Timezone       string `json:"timezone"`
+}
+
+// SeverityLevel orders notification severities. Unknown severities are
+// treated as info.
+func SeverityLevel(severity string) int {
+	switch severity {
+	case PointValueWarning:
+		return 1
+	case PointValueCritical:
+		return 2
+	default:
+		return 0
+	}
+}
+
+// QuietHours returns true if t is within the quiet hours of the user
+func (u *User) QuietHours(t time.Time) bool {
+	start, err := time.Parse("15:04", u.QuietStart)
+	if err != nil {
+		return false
+	}
+
+	end, err := time.Parse("15:04", u.QuietEnd)
+	if err != nil {
+		return false
+	}
+
+	loc := time.UTC
+	if u.Timezone != "" {
+		loc, err = time.LoadLocation(u.Timezone)
+		if err != nil {
+			log.Printf("User %v has invalid timezone %v, using UTC\n", u.ID, u.Timezone)
+			loc = time.UTC
+		}
+	}
+
+	t = t.In(loc)
+	now := t.Hour()*60 + t.Minute()
+	s := start.Hour()*60 + start.Minute()
+	e := end.Hour()*60 + end.Minute()
+
+	if s <= e {
+		return now >= s && now < e
+	}
+
+	// quiet hours span midnight
+	return now >= s || now < e
+}
+
+// NotifyChannels returns whether a notification of the given severity
+// should be sent to the user by email and SMS at time t. Notifications below
+// the severity threshold of the user are not sent, and only critical
+// notifications are sent during quiet hours.
+func (u *User) NotifyChannels(severity string, t time.Time) (email bool, sms bool) {
+	if !u.Notify(severity, t) {
+		return false, false
+	}
+
+	return !u.DisableEmail, !u.DisableSMS
+}
+
+// Notify returns whether the user gets a notification of the given severity
+// at time t on any channel
+func (u *User) Notify(severity string, t time.Time) bool {
+	level := SeverityLevel(severity)
+
+	if level < SeverityLevel(u.NotifySeverity) {
+		return false
+	}
+
+	return level >= SeverityLevel(PointValueCritical) || !u.QuietHours(t)
 }
 
 // ToPoints converts a user structure into points
@@ -49,6 +131,18 @@ func NodeToUser(node Node) (User, error) {
 			ret.Phone = p.Text
 		case PointTypePass:
 			ret.Pass = p.Text
+		case PointTypeDisableEmail:
+			ret.DisableEmail = p.Value != 0
+		case PointTypeDisableSMS:
+			ret.DisableSMS = p.Value != 0
+		case PointTypeNotifySeverity:
+			ret.NotifySeverity = p.Text
+		case PointTypeQuietStart:
+			ret.QuietStart = p.Text
+		case PointTypeQuietEnd:
+			ret.QuietEnd = p.Text
+		case PointTypeTimezone:
+			ret.Timezone = p.Text
 		}
 	}
 
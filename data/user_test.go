@@ -0,0 +1,48 @@
+package data
+
+import (
+	"testing"
+	"time"
+)
+
+func TestUserNotifyChannels(t *testing.T) {
+	u := User{
+		NotifySeverity: PointValueWarning,
+		QuietStart:     "22:00",
+		QuietEnd:       "06:30",
+		Timezone:       "America/New_York",
+		DisableSMS:     true,
+	}
+
+	// 12:00 and 23:00 in New York (UTC-4 in summer)
+	day := time.Date(2022, 7, 1, 16, 0, 0, 0, time.UTC)
+	night := time.Date(2022, 7, 2, 3, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		severity string
+		t        time.Time
+		email    bool
+	}{
+		{PointValueInfo, day, false},
+		{PointValueWarning, day, true},
+		{PointValueWarning, night, false},
+		{PointValueCritical, night, true},
+	}
+
+	for _, test := range tests {
+		email, sms := u.NotifyChannels(test.severity, test.t)
+		if email != test.email || sms {
+			t.Errorf("%v at %v: got email %v, sms %v", test.severity, test.t, email, sms)
+		}
+	}
+
+	if !u.QuietHours(time.Date(2022, 7, 1, 10, 29, 0, 0, time.UTC)) ||
+		u.QuietHours(time.Date(2022, 7, 1, 10, 30, 0, 0, time.UTC)) {
+		t.Error("quiet hours should end at 06:30 New York time")
+	}
+
+	u.QuietStart = ""
+	if u.QuietHours(night) {
+		t.Error("quiet hours without a start time")
+	}
+}
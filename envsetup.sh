@@ -174,6 +174,14 @@ siot_test_frontend() {
   (cd frontend && npx elm-test || return 1) || return 1
 }
 
+siot_test_python() {
+  (cd python && python3 -m unittest discover -s tests || return 1) || return 1
+}
+
+siot_test_c() {
+  (cd c && make test && make clean || return 1) || return 1
+}
+
 # please run the following before pushing -- best if your editor can be set up
 # to do this automatically.
 siot_test() {
@@ -186,6 +194,12 @@ siot_test() {
   return 0
 }
 
+# run the performance benchmarks. Save the output before and after a change
+# and compare with benchstat to check for regressions.
+siot_bench() {
+  go test -run='^$' -bench=. -benchmem "$@" ./bench || return 1
+}
+
 # following can be used to set up influxdb for local testing
 siot_setup_influx() {
   export SIOT_INFLUX_URL=http://localhost:8086
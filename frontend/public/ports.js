@@ -20,6 +20,14 @@ const actions = {
       console.log("clipboard not available");
     }
   },
+  PUSH_SUBSCRIBE: (token) =>
+    pushSubscribe(token).catch((err) =>
+      console.log("Error subscribing to push notifications: ", err)
+    ),
+  PUSH_UNSUBSCRIBE: (token) =>
+    pushUnsubscribe(token).catch((err) =>
+      console.log("Error unsubscribing from push notifications: ", err)
+    ),
 };
 
 console.log("Simple IoT Javascript code");
@@ -35,3 +43,71 @@ var writeClipboard = (data) => {
       console.log("Something went wrong", err);
     });
 };
+
+// convert a base64url VAPID key to the format PushManager expects
+var urlBase64ToUint8Array = (base64) => {
+  const padded = (base64 + "=".repeat((4 - (base64.length % 4)) % 4))
+    .replace(/-/g, "+")
+    .replace(/_/g, "/");
+  return Uint8Array.from(atob(padded), (c) => c.charCodeAt(0));
+};
+
+var pushSubscribe = async (token) => {
+  if (!("serviceWorker" in navigator) || !("PushManager" in window)) {
+    console.log("push notifications not available");
+    return;
+  }
+
+  const permission = await Notification.requestPermission();
+  if (permission !== "granted") {
+    console.log("push notifications not allowed");
+    return;
+  }
+
+  const res = await fetch("v1/push", {
+    headers: { Authorization: `Bearer ${token}` },
+  });
+  if (!res.ok) {
+    throw new Error(`push key request failed: ${res.status}`);
+  }
+  const { publicKey } = await res.json();
+
+  const registration = await navigator.serviceWorker.register("sw.js");
+  const subscription = await registration.pushManager.subscribe({
+    userVisibleOnly: true,
+    applicationServerKey: urlBase64ToUint8Array(publicKey),
+  });
+
+  await fetch("v1/push", {
+    method: "POST",
+    headers: {
+      Authorization: `Bearer ${token}`,
+      "Content-Type": "application/json",
+    },
+    body: JSON.stringify(subscription),
+  });
+};
+
+var pushUnsubscribe = async (token) => {
+  if (!("serviceWorker" in navigator)) {
+    return;
+  }
+
+  const registration = await navigator.serviceWorker.getRegistration();
+  const subscription =
+    registration && (await registration.pushManager.getSubscription());
+  if (!subscription) {
+    return;
+  }
+
+  await fetch("v1/push", {
+    method: "DELETE",
+    headers: {
+      Authorization: `Bearer ${token}`,
+      "Content-Type": "application/json",
+    },
+    body: JSON.stringify({ endpoint: subscription.endpoint }),
+  });
+
+  await subscription.unsubscribe();
+};
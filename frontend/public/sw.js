@@ -0,0 +1,31 @@
+// Service worker that shows Simple IoT push notifications, even when no
+// tab with the app is open.
+
+self.addEventListener("push", (event) => {
+  const msg = event.data ? event.data.json() : {};
+
+  event.waitUntil(
+    self.registration.showNotification(msg.title || "Simple IoT", {
+      body: msg.body,
+      tag: msg.id,
+      requireInteraction: msg.severity === "critical",
+      data: msg,
+    })
+  );
+});
+
+// focus an open tab of the app, or open a new one
+self.addEventListener("notificationclick", (event) => {
+  event.notification.close();
+
+  event.waitUntil(
+    clients.matchAll({ type: "window" }).then((windows) => {
+      for (const w of windows) {
+        if ("focus" in w) {
+          return w.focus();
+        }
+      }
+      return clients.openWindow(self.registration.scope);
+    })
+  );
+});
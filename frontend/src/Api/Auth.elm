@@ -3,9 +3,11 @@ module Api.Auth exposing
     , Cred
     , empty
     , login
+    , logout
     )
 
 import Api.Data exposing (Data)
+import Api.Response as Response exposing (Response)
 import Http
 import Json.Decode as Decode
 import Json.Decode.Pipeline exposing (required)
@@ -48,6 +50,34 @@ login options =
                 [ Http.stringPart "email" options.user.email
                 , Http.stringPart "password" options.user.password
                 ]
-        , url = Url.Builder.absolute [ "v1", "auth" ] []
+        , url = Url.Builder.relative [ "v1", "auth" ] []
         , expect = Api.Data.expectJson options.onResponse decodeResponse
         }
+
+
+{-| revoke the session of the token, or all sessions of the user if all is set
+-}
+logout :
+    { token : String
+    , all : Bool
+    , onResponse : Data Response -> msg
+    }
+    -> Cmd msg
+logout options =
+    let
+        path =
+            if options.all then
+                [ "v1", "sessions" ]
+
+            else
+                [ "v1", "sessions", "current" ]
+    in
+    Http.request
+        { method = "DELETE"
+        , headers = [ Http.header "Authorization" <| "Bearer " ++ options.token ]
+        , url = Url.Builder.relative path []
+        , expect = Api.Data.expectJson options.onResponse Response.decoder
+        , body = Http.emptyBody
+        , timeout = Nothing
+        , tracker = Nothing
+        }
@@ -307,7 +307,7 @@ list options =
     Http.request
         { method = "GET"
         , headers = [ Http.header "Authorization" <| "Bearer " ++ options.token ]
-        , url = Url.Builder.absolute [ "v1", "nodes" ] []
+        , url = Url.Builder.relative [ "v1", "nodes" ] []
         , expect = Api.Data.expectJson options.onResponse decodeList
         , body = Http.emptyBody
         , timeout = Nothing
@@ -325,7 +325,7 @@ get options =
     Http.request
         { method = "GET"
         , headers = [ Http.header "Authorization" <| "Bearer " ++ options.token ]
-        , url = Url.Builder.absolute [ "v1", "nodes", options.id ] []
+        , url = Url.Builder.relative [ "v1", "nodes", options.id ] []
         , expect = Api.Data.expectJson options.onResponse decode
         , body = Http.emptyBody
         , timeout = Just <| 5 * 1000
@@ -343,7 +343,7 @@ getCmd options =
     Http.request
         { method = "GET"
         , headers = [ Http.header "Authorization" <| "Bearer " ++ options.token ]
-        , url = Url.Builder.absolute [ "v1", "nodes", options.id, "cmd" ] []
+        , url = Url.Builder.relative [ "v1", "nodes", options.id, "cmd" ] []
         , expect = Api.Data.expectJson options.onResponse decodeCmd
         , body = Http.emptyBody
         , timeout = Nothing
@@ -362,7 +362,7 @@ delete options =
     Http.request
         { method = "DELETE"
         , headers = [ Http.header "Authorization" <| "Bearer " ++ options.token ]
-        , url = Url.Builder.absolute [ "v1", "nodes", options.id ] []
+        , url = Url.Builder.relative [ "v1", "nodes", options.id ] []
         , expect = Api.Data.expectJson options.onResponse Response.decoder
         , body = encodeNodeDelete { parent = options.parent } |> Http.jsonBody
         , timeout = Nothing
@@ -380,7 +380,7 @@ insert options =
     Http.request
         { method = "POST"
         , headers = [ Http.header "Authorization" <| "Bearer " ++ options.token ]
-        , url = Url.Builder.absolute [ "v1", "nodes", options.node.id ] []
+        , url = Url.Builder.relative [ "v1", "nodes", options.node.id ] []
         , expect = Api.Data.expectJson options.onResponse Response.decoder
         , body = options.node |> encode |> Http.jsonBody
         , timeout = Nothing
@@ -399,7 +399,7 @@ postCmd options =
     Http.request
         { method = "POST"
         , headers = [ Http.header "Authorization" <| "Bearer " ++ options.token ]
-        , url = Url.Builder.absolute [ "v1", "nodes", options.id, "cmd" ] []
+        , url = Url.Builder.relative [ "v1", "nodes", options.id, "cmd" ] []
         , expect = Api.Data.expectJson options.onResponse Response.decoder
         , body = options.cmd |> encodeNodeCmd |> Http.jsonBody
         , timeout = Nothing
@@ -418,7 +418,7 @@ postPoints options =
     Http.request
         { method = "POST"
         , headers = [ Http.header "Authorization" <| "Bearer " ++ options.token ]
-        , url = Url.Builder.absolute [ "v1", "nodes", options.id, "points" ] []
+        , url = Url.Builder.relative [ "v1", "nodes", options.id, "points" ] []
         , expect = Api.Data.expectJson options.onResponse Response.decoder
         , body = options.points |> Point.encodeList |> Http.jsonBody
         , timeout = Nothing
@@ -436,7 +436,7 @@ notify options =
     Http.request
         { method = "POST"
         , headers = [ Http.header "Authorization" <| "Bearer " ++ options.token ]
-        , url = Url.Builder.absolute [ "v1", "nodes", options.not.sourceNode, "not" ] []
+        , url = Url.Builder.relative [ "v1", "nodes", options.not.sourceNode, "not" ] []
         , expect = Api.Data.expectJson options.onResponse Response.decoder
         , body = options.not |> encodeNotification |> Http.jsonBody
         , timeout = Nothing
@@ -456,7 +456,7 @@ move options =
     Http.request
         { method = "POST"
         , headers = [ Http.header "Authorization" <| "Bearer " ++ options.token ]
-        , url = Url.Builder.absolute [ "v1", "nodes", options.id, "parents" ] []
+        , url = Url.Builder.relative [ "v1", "nodes", options.id, "parents" ] []
         , expect = Api.Data.expectJson options.onResponse Response.decoder
         , body =
             { id = options.id
@@ -482,7 +482,7 @@ copy options =
     Http.request
         { method = "PUT"
         , headers = [ Http.header "Authorization" <| "Bearer " ++ options.token ]
-        , url = Url.Builder.absolute [ "v1", "nodes", options.id, "parents" ] []
+        , url = Url.Builder.relative [ "v1", "nodes", options.id, "parents" ] []
         , expect = Api.Data.expectJson options.onResponse Response.decoder
         , body =
             { id = options.id
@@ -0,0 +1,83 @@
+module Api.NodeUI exposing (Field, NodeUI, Option, list)
+
+import Api.Data exposing (Data)
+import Http
+import Json.Decode as Decode
+import Json.Decode.Pipeline exposing (optional, required)
+import Url.Builder
+
+
+type alias NodeUI =
+    { typ : String
+    , label : String
+    , parents : List String
+    , fields : List Field
+    }
+
+
+type alias Field =
+    { point : String
+    , key : String
+    , label : String
+    , input : String
+    , options : List Option
+    }
+
+
+type alias Option =
+    { value : String
+    , label : String
+    }
+
+
+decodeNullList : Decode.Decoder a -> Decode.Decoder (List a)
+decodeNullList decoder =
+    Decode.oneOf [ Decode.list decoder, Decode.null [] ]
+
+
+decodeList : Decode.Decoder (List NodeUI)
+decodeList =
+    Decode.list decode
+
+
+decode : Decode.Decoder NodeUI
+decode =
+    Decode.succeed NodeUI
+        |> required "type" Decode.string
+        |> optional "label" Decode.string ""
+        |> optional "parents" (decodeNullList Decode.string) []
+        |> optional "fields" (decodeNullList decodeField) []
+
+
+decodeField : Decode.Decoder Field
+decodeField =
+    Decode.succeed Field
+        |> required "point" Decode.string
+        |> optional "key" Decode.string ""
+        |> optional "label" Decode.string ""
+        |> optional "input" Decode.string "text"
+        |> optional "options" (decodeNullList decodeOption) []
+
+
+decodeOption : Decode.Decoder Option
+decodeOption =
+    Decode.succeed Option
+        |> required "value" Decode.string
+        |> optional "label" Decode.string ""
+
+
+list :
+    { token : String
+    , onResponse : Data (List NodeUI) -> msg
+    }
+    -> Cmd msg
+list options =
+    Http.request
+        { method = "GET"
+        , headers = [ Http.header "Authorization" <| "Bearer " ++ options.token ]
+        , url = Url.Builder.relative [ "v1", "ui", "nodes" ] []
+        , expect = Api.Data.expectJson options.onResponse decodeList
+        , body = Http.emptyBody
+        , timeout = Nothing
+        , tracker = Nothing
+        }
@@ -23,8 +23,34 @@ module Api.Point exposing
     , typeAmplitude
     , typeAuthToken
     , typeBaud
+    , typeBitCount
+    , typeBitOffset
     , typeBucket
+    , typeByteOrder
+    , typeCalendarID
+    , typeCalendarMode
+    , typeCertAuto
     , typeChannel
+    , typeDatabase
+    , typeDbType
+    , typeFieldNames
+    , typeMeasurement
+    , typePassword
+    , typeRetentionPolicy
+    , typeTags
+    , typeUsername
+    , valueInflux1
+    , valueInflux2
+    , valueVictoriaMetrics
+    , valueBuiltin
+    , typeDirectory
+    , typeRetentionDays
+    , typeArchiveURI
+    , typeArchiveBucket
+    , typeArchiveRegion
+    , typeArchiveAccessKey
+    , typeArchiveSecretKey
+    , typeArchiveAfterDays
     , typeClientServer
     , typeCmdPending
     , typeConditionType
@@ -33,6 +59,8 @@ module Api.Point exposing
     , typeDescription
     , typeDevice
     , typeDisable
+    , typeDisableEmail
+    , typeDisableSMS
     , typeEmail
     , typeEnd
     , typeErrorCount
@@ -46,13 +74,16 @@ module Api.Point exposing
     , typeFrequency
     , typeFrom
     , typeID
+    , typeIgnoreBadQuality
     , typeIndex
     , typeLastName
+    , typeLocale
     , typeLog
     , typeMinActive
     , typeModbusIOType
     , typeNodeID
     , typeNodeType
+    , typeNotifySeverity
     , typeOffset
     , typeOperator
     , typeOrg
@@ -63,8 +94,12 @@ module Api.Point exposing
     , typePointKey
     , typePointType
     , typePollPeriod
+    , typePollPriority
+    , typePolynomial
     , typePort
     , typeProtocol
+    , typeQuietEnd
+    , typeQuietStart
     , typeReadOnly
     , typeRx
     , typeRxReset
@@ -72,6 +107,7 @@ module Api.Point exposing
     , typeSampleRate
     , typeScale
     , typeService
+    , typeSeverity
     , typeStart
     , typeStartApp
     , typeStartSystem
@@ -80,10 +116,15 @@ module Api.Point exposing
     , typeSwUpdateRunning
     , typeSwUpdateState
     , typeSysState
+    , typeTLSCA
+    , typeTLSCert
+    , typeTLSKey
+    , typeTimezone
     , typeTombstone
     , typeTx
     , typeTxReset
     , typeURI
+    , typeUnitSystem
     , typeUnits
     , typeUpdateApp
     , typeUpdateOS
@@ -96,26 +137,48 @@ module Api.Point exposing
     , typeVersionHW
     , typeVersionOS
     , typeWeekday
+    , typeWriteStatus
+    , typeZoneID
     , updatePoint
     , updatePoints
+    , valueABCD
+    , valueBADC
+    , valueCDAB
     , valueClient
+    , valueConfirmed
     , valueContains
+    , valueCritical
+    , valueDCBA
     , valueEqual
+    , valueExclude
     , valueFLOAT32
+    , valueFLOAT64
+    , valueGeofence
     , valueGreaterThan
+    , valueHigh
     , valueINT16
     , valueINT32
+    , valueINT64
+    , valueInclude
+    , valueInfo
+    , valueInside
     , valueLessThan
+    , valueLow
+    , valueImperial
+    , valueMetric
     , valueModbusCoil
     , valueModbusDiscreteInput
     , valueModbusHoldingRegister
     , valueModbusInputRegister
+    , valueNormal
     , valueNotEqual
     , valueNotify
     , valueNumber
     , valueOff
     , valueOn
     , valueOnOff
+    , valueOutside
+    , valuePending
     , valuePlayAudio
     , valuePointValue
     , valueRTU
@@ -134,6 +197,9 @@ module Api.Point exposing
     , valueTwilio
     , valueUINT16
     , valueUINT32
+    , valueUINT64
+    , valueWarning
+    , valueWriteFailed
     )
 
 import Iso8601
@@ -176,6 +242,11 @@ typeOffset =
     "offset"
 
 
+typeUnitSystem : String
+typeUnitSystem =
+    "unitSystem"
+
+
 typeUnits : String
 typeUnits =
     "units"
@@ -291,6 +362,11 @@ typeFirstName =
     "firstName"
 
 
+typeLocale : String
+typeLocale =
+    "locale"
+
+
 typeLastName : String
 typeLastName =
     "lastName"
@@ -391,6 +467,16 @@ typeProtocol =
     "protocol"
 
 
+typeQuietStart : String
+typeQuietStart =
+    "quietStart"
+
+
+typeQuietEnd : String
+typeQuietEnd =
+    "quietEnd"
+
+
 valueRTU : String
 valueRTU =
     "RTU"
@@ -411,6 +497,16 @@ valueModbusDiscreteInput =
     "modbusDiscreteInput"
 
 
+valueMetric : String
+valueMetric =
+    "metric"
+
+
+valueImperial : String
+valueImperial =
+    "imperial"
+
+
 valueModbusCoil : String
 valueModbusCoil =
     "modbusCoil"
@@ -441,6 +537,46 @@ typePollPeriod =
     "pollPeriod"
 
 
+typePollPriority : String
+typePollPriority =
+    "pollPriority"
+
+
+valueHigh : String
+valueHigh =
+    "high"
+
+
+valueNormal : String
+valueNormal =
+    "normal"
+
+
+valueLow : String
+valueLow =
+    "low"
+
+
+typeWriteStatus : String
+typeWriteStatus =
+    "writeStatus"
+
+
+valuePending : String
+valuePending =
+    "pending"
+
+
+valueConfirmed : String
+valueConfirmed =
+    "confirmed"
+
+
+valueWriteFailed : String
+valueWriteFailed =
+    "failed"
+
+
 valueUINT16 : String
 valueUINT16 =
     "uint16"
@@ -466,6 +602,61 @@ valueFLOAT32 =
     "float32"
 
 
+valueUINT64 : String
+valueUINT64 =
+    "uint64"
+
+
+valueINT64 : String
+valueINT64 =
+    "int64"
+
+
+valueFLOAT64 : String
+valueFLOAT64 =
+    "float64"
+
+
+typeByteOrder : String
+typeByteOrder =
+    "byteOrder"
+
+
+valueABCD : String
+valueABCD =
+    "ABCD"
+
+
+valueBADC : String
+valueBADC =
+    "BADC"
+
+
+valueCDAB : String
+valueCDAB =
+    "CDAB"
+
+
+valueDCBA : String
+valueDCBA =
+    "DCBA"
+
+
+typePolynomial : String
+typePolynomial =
+    "polynomial"
+
+
+typeBitOffset : String
+typeBitOffset =
+    "bitOffset"
+
+
+typeBitCount : String
+typeBitCount =
+    "bitCount"
+
+
 typeClientServer : String
 typeClientServer =
     "clientServer"
@@ -521,6 +712,46 @@ typeWeekday =
     "weekday"
 
 
+typeCalendarID : String
+typeCalendarID =
+    "calendarID"
+
+
+typeCalendarMode : String
+typeCalendarMode =
+    "calendarMode"
+
+
+valueExclude : String
+valueExclude =
+    "exclude"
+
+
+valueInclude : String
+valueInclude =
+    "include"
+
+
+valueGeofence : String
+valueGeofence =
+    "geofence"
+
+
+typeZoneID : String
+typeZoneID =
+    "zoneID"
+
+
+valueInside : String
+valueInside =
+    "inside"
+
+
+valueOutside : String
+valueOutside =
+    "outside"
+
+
 typePointID : String
 typePointID =
     "pointID"
@@ -581,11 +812,31 @@ valueContains =
     "contains"
 
 
+valueInfo : String
+valueInfo =
+    "info"
+
+
+valueWarning : String
+valueWarning =
+    "warning"
+
+
+valueCritical : String
+valueCritical =
+    "critical"
+
+
 typeMinActive : String
 typeMinActive =
     "minActive"
 
 
+typeIgnoreBadQuality : String
+typeIgnoreBadQuality =
+    "ignoreBadQuality"
+
+
 typeAction : String
 typeAction =
     "action"
@@ -621,6 +872,11 @@ typeService =
     "service"
 
 
+typeSeverity : String
+typeSeverity =
+    "severity"
+
+
 valueTwilio : String
 valueTwilio =
     "twilio"
@@ -641,6 +897,31 @@ typeAuthToken =
     "authToken"
 
 
+typeTLSCert : String
+typeTLSCert =
+    "tlsCert"
+
+
+typeTLSKey : String
+typeTLSKey =
+    "tlsKey"
+
+
+typeTimezone : String
+typeTimezone =
+    "timezone"
+
+
+typeTLSCA : String
+typeTLSCA =
+    "tlsCA"
+
+
+typeCertAuto : String
+typeCertAuto =
+    "certAuto"
+
+
 typeFrom : String
 typeFrom =
     "from"
@@ -656,6 +937,11 @@ typeNodeType =
     "nodeType"
 
 
+typeNotifySeverity : String
+typeNotifySeverity =
+    "notifySeverity"
+
+
 typeNodeID : String
 typeNodeID =
     "nodeID"
@@ -691,6 +977,106 @@ typeBucket =
     "bucket"
 
 
+typeMeasurement : String
+typeMeasurement =
+    "measurement"
+
+
+typeTags : String
+typeTags =
+    "tags"
+
+
+typeFieldNames : String
+typeFieldNames =
+    "fieldNames"
+
+
+typeDbType : String
+typeDbType =
+    "dbType"
+
+
+valueInflux2 : String
+valueInflux2 =
+    "influx2"
+
+
+valueInflux1 : String
+valueInflux1 =
+    "influx1"
+
+
+valueVictoriaMetrics : String
+valueVictoriaMetrics =
+    "victoriaMetrics"
+
+
+valueBuiltin : String
+valueBuiltin =
+    "builtin"
+
+
+typeDirectory : String
+typeDirectory =
+    "directory"
+
+
+typeRetentionDays : String
+typeRetentionDays =
+    "retentionDays"
+
+
+typeArchiveURI : String
+typeArchiveURI =
+    "archiveURI"
+
+
+typeArchiveBucket : String
+typeArchiveBucket =
+    "archiveBucket"
+
+
+typeArchiveRegion : String
+typeArchiveRegion =
+    "archiveRegion"
+
+
+typeArchiveAccessKey : String
+typeArchiveAccessKey =
+    "archiveAccessKey"
+
+
+typeArchiveSecretKey : String
+typeArchiveSecretKey =
+    "archiveSecretKey"
+
+
+typeArchiveAfterDays : String
+typeArchiveAfterDays =
+    "archiveAfterDays"
+
+
+typeUsername : String
+typeUsername =
+    "username"
+
+
+typePassword : String
+typePassword =
+    "password"
+
+
+typeDatabase : String
+typeDatabase =
+    "database"
+
+
+typeRetentionPolicy : String
+typeRetentionPolicy =
+    "retentionPolicy"
+
+
 typeOrg : String
 typeOrg =
     "org"
@@ -701,6 +1087,16 @@ typeDisable =
     "disable"
 
 
+typeDisableEmail : String
+typeDisableEmail =
+    "disableEmail"
+
+
+typeDisableSMS : String
+typeDisableSMS =
+    "disableSMS"
+
+
 typeIndex : String
 typeIndex =
     "index"
@@ -4,10 +4,13 @@ import Element exposing (..)
 import Spa.Generated.Route as Route exposing (Route)
 import UI.Form as Form
 import UI.Style as Style
+import Utils.Route
 
 
 navbar :
     { onSignOut : msg
+    , onSignOutAll : msg
+    , onEnablePush : msg
     , authenticated : Bool
     , email : String
     }
@@ -17,11 +20,23 @@ navbar options =
         [ link ( "SIOT", Route.Top )
         , el [ alignRight ] <|
             if options.authenticated then
-                Form.button
-                    { label = "sign out " ++ options.email
-                    , color = Style.colors.blue
-                    , onPress = options.onSignOut
-                    }
+                row [ spacing 10 ]
+                    [ Form.button
+                        { label = "notifications"
+                        , color = Style.colors.gray
+                        , onPress = options.onEnablePush
+                        }
+                    , Form.button
+                        { label = "sign out " ++ options.email
+                        , color = Style.colors.blue
+                        , onPress = options.onSignOut
+                        }
+                    , Form.button
+                        { label = "sign out everywhere"
+                        , color = Style.colors.gray
+                        , onPress = options.onSignOutAll
+                        }
+                    ]
 
             else
                 Element.none
@@ -32,7 +47,7 @@ viewButtonLink : ( String, Route ) -> Element msg
 viewButtonLink ( label, route ) =
     Element.link (Style.button Style.colors.blue)
         { label = text label
-        , url = Route.toString route
+        , url = Utils.Route.toHref route
         }
 
 
@@ -40,5 +55,5 @@ link : ( String, Route ) -> Element msg
 link ( label, route ) =
     Element.link Style.link
         { label = text label
-        , url = Route.toString route
+        , url = Utils.Route.toHref route
         }
@@ -2,11 +2,12 @@ module Components.NodeCondition exposing (view)
 
 import Api.Node as Node
 import Api.Point as Point
-import Components.NodeOptions exposing (CopyMove(..), NodeOptions, findNode, oToInputO)
+import Components.NodeOptions exposing (CopyMove(..), NodeOptions, findNode, findTimezone, oToInputO)
 import Element exposing (..)
 import Element.Background as Background
 import Element.Border as Border
 import Element.Font as Font
+import Time
 import UI.Icon as Icon
 import UI.NodeInputs as NodeInputs
 import UI.Style as Style
@@ -66,6 +67,7 @@ view o =
                         "Type"
                         [ ( Point.valuePointValue, "point value" )
                         , ( Point.valueSchedule, "schedule" )
+                        , ( Point.valueGeofence, "geofence" )
                         ]
                     , case conditionType of
                         "pointValue" ->
@@ -74,6 +76,9 @@ view o =
                         "schedule" ->
                             schedule o labelWidth
 
+                        "geofence" ->
+                            geofence o labelWidth
+
                         _ ->
                             text "Please select condition type"
                     ]
@@ -89,10 +94,70 @@ schedule o labelWidth =
         opts =
             oToInputO o labelWidth
 
+        -- schedules with a timezone (on the condition, rule, or a parent
+        -- node) are entered in that timezone. Otherwise times are
+        -- converted from the browser timezone to UTC.
+        timezone =
+            findTimezone o.nodes o.node.id
+
         timeDateInput =
-            NodeInputs.nodeTimeDateInput opts labelWidth
+            if timezone == "" then
+                NodeInputs.nodeTimeDateInput opts labelWidth
+
+            else
+                NodeInputs.nodeTimeDateInput { opts | zone = Time.utc } labelWidth
+
+        textInput =
+            NodeInputs.nodeTextInput opts ""
+
+        optionInput =
+            NodeInputs.nodeOptionInput opts ""
+
+        calendarID =
+            Point.getText o.node.points Point.typeCalendarID ""
+    in
+    column [ spacing 6 ]
+        [ textInput Point.typeTimezone "Timezone" "America/New_York"
+        , timeDateInput
+        , if timezone == "" then
+            text "Times are in the browser timezone"
+
+          else
+            text <| "Times are in " ++ timezone
+        , textInput Point.typeCalendarID "Calendar ID" ""
+        , if calendarID == "" then
+            Element.none
+
+          else
+            optionInput Point.typeCalendarMode
+                "Calendar dates"
+                [ ( Point.valueExclude, "not active" )
+                , ( Point.valueInclude, "only active" )
+                ]
+        ]
+
+
+geofence : NodeOptions msg -> Int -> Element msg
+geofence o labelWidth =
+    let
+        opts =
+            oToInputO o labelWidth
+
+        textInput =
+            NodeInputs.nodeTextInput opts ""
+
+        optionInput =
+            NodeInputs.nodeOptionInput opts ""
     in
-    timeDateInput
+    column [ spacing 6 ]
+        [ textInput Point.typeNodeID "Tracked node ID" ""
+        , textInput Point.typeZoneID "Zone ID" ""
+        , optionInput Point.typeOperator
+            "Active when node is"
+            [ ( Point.valueInside, "inside zone" )
+            , ( Point.valueOutside, "outside zone" )
+            ]
+        ]
 
 
 pointValue : NodeOptions msg -> Int -> Element msg
@@ -113,6 +178,9 @@ pointValue o labelWidth =
         onOffInput =
             NodeInputs.nodeOnOffInput opts ""
 
+        checkboxInput =
+            NodeInputs.nodeCheckboxInput opts ""
+
         conditionValueType =
             Point.getText o.node.points Point.typeValueType ""
 
@@ -216,4 +284,5 @@ pointValue o labelWidth =
             _ ->
                 Element.none
         , numberInput Point.typeMinActive "Min active time (m)"
+        , checkboxInput Point.typeIgnoreBadQuality "Ignore bad quality"
         ]
@@ -20,6 +20,18 @@ view o =
 
         textInput =
             NodeInputs.nodeTextInput opts ""
+
+        optionInput =
+            NodeInputs.nodeOptionInput opts ""
+
+        numberInput =
+            NodeInputs.nodeNumberInput opts ""
+
+        dbType =
+            Point.getText o.node.points Point.typeDbType ""
+
+        archive =
+            Point.getText o.node.points Point.typeArchiveURI "" /= ""
     in
     column
         [ width fill
@@ -34,12 +46,54 @@ view o =
                 Point.getText o.node.points Point.typeDescription ""
             ]
             :: (if o.expDetail then
-                    [ text "InfluxDb 2.0 Database"
-                    , textInput Point.typeDescription "Description" ""
-                    , textInput Point.typeURI "URL" "https://myserver:8086"
-                    , textInput Point.typeOrg "Organization" "org name"
-                    , textInput Point.typeBucket "Bucket" "bucket name"
-                    , textInput Point.typeAuthToken "Auth Token" ""
+                    [ textInput Point.typeDescription "Description" ""
+                    , optionInput Point.typeDbType
+                        "Database type"
+                        [ ( Point.valueInflux2, "InfluxDB 2.x" )
+                        , ( Point.valueInflux1, "InfluxDB 1.x" )
+                        , ( Point.valueVictoriaMetrics, "VictoriaMetrics" )
+                        , ( Point.valueBuiltin, "Built-in" )
+                        ]
+                    , if dbType == Point.valueBuiltin then
+                        column [ spacing 6 ]
+                            [ textInput Point.typeDirectory "Directory" "history/<id>"
+                            , numberInput Point.typeRetentionDays "Retention (days)"
+                            , textInput Point.typeArchiveURI "Archive URL" "https://s3.us-east-1.amazonaws.com"
+                            , if archive then
+                                column [ spacing 6 ]
+                                    [ textInput Point.typeArchiveBucket "Archive bucket" "bucket name"
+                                    , textInput Point.typeArchiveRegion "Archive region" "us-east-1"
+                                    , textInput Point.typeArchiveAccessKey "Archive access key" ""
+                                    , textInput Point.typeArchiveSecretKey "Archive secret key" ""
+                                    , numberInput Point.typeArchiveAfterDays "Archive after (days)"
+                                    ]
+
+                              else
+                                Element.none
+                            ]
+
+                      else
+                        textInput Point.typeURI "URL" "https://myserver:8086"
+                    , if dbType == Point.valueBuiltin then
+                        Element.none
+
+                      else if dbType == Point.valueInflux1 || dbType == Point.valueVictoriaMetrics then
+                        column [ spacing 6 ]
+                            [ textInput Point.typeUsername "Username" ""
+                            , textInput Point.typePassword "Password" ""
+                            , textInput Point.typeDatabase "Database" "database name"
+                            , textInput Point.typeRetentionPolicy "Retention policy" ""
+                            ]
+
+                      else
+                        column [ spacing 6 ]
+                            [ textInput Point.typeOrg "Organization" "org name"
+                            , textInput Point.typeBucket "Bucket" "bucket name"
+                            , textInput Point.typeAuthToken "Auth Token" ""
+                            ]
+                    , textInput Point.typeMeasurement "Measurement" "points"
+                    , textInput Point.typeTags "Extra tags" "site=north,customer=acme"
+                    , textInput Point.typeFieldNames "Field names" "temp=temperature"
                     ]
 
                 else
@@ -0,0 +1,64 @@
+module Components.NodeGeneric exposing (view)
+
+import Api.NodeUI exposing (Field, NodeUI)
+import Api.Point as Point
+import Components.NodeOptions exposing (NodeOptions, oToInputO)
+import Element exposing (..)
+import Element.Border as Border
+import UI.Icon as Icon
+import UI.NodeInputs as NodeInputs
+import UI.Style exposing (colors)
+
+
+{-| view displays a node using the edit form described by a node UI
+descriptor from the backend. This is used for node types that do not have
+a custom view.
+-}
+view : NodeUI -> NodeOptions msg -> Element msg
+view ui o =
+    let
+        labelWidth =
+            150
+
+        opts =
+            oToInputO o labelWidth
+
+        viewField : Field -> Element msg
+        viewField f =
+            case f.input of
+                "number" ->
+                    NodeInputs.nodeNumberInput opts f.key f.point f.label
+
+                "checkbox" ->
+                    NodeInputs.nodeCheckboxInput opts f.key f.point f.label
+
+                "option" ->
+                    NodeInputs.nodeOptionInput opts
+                        f.key
+                        f.point
+                        f.label
+                        (List.map (\opt -> ( opt.value, opt.label )) f.options)
+
+                _ ->
+                    NodeInputs.nodeTextInput opts f.key f.point f.label ""
+    in
+    column
+        [ width fill
+        , Border.widthEach { top = 2, bottom = 0, left = 0, right = 0 }
+        , Border.color colors.black
+        , spacing 6
+        ]
+    <|
+        wrappedRow [ spacing 10 ]
+            [ Icon.box
+            , text <|
+                ui.label
+                    ++ ": "
+                    ++ Point.getText o.node.points Point.typeDescription ""
+            ]
+            :: (if o.expDetail then
+                    List.map viewField ui.fields
+
+                else
+                    []
+               )
@@ -34,6 +34,9 @@ view o =
         optionInput =
             NodeInputs.nodeOptionInput opts ""
 
+        polyInput key =
+            NodeInputs.nodeNumberInput opts key Point.typePolynomial
+
         checkboxInput =
             NodeInputs.nodeCheckboxInput opts ""
 
@@ -98,6 +101,9 @@ view o =
 
         disabled =
             Point.getBool o.node.points Point.typeDisable ""
+
+        writeStatus =
+            Point.getText o.node.points Point.typeWriteStatus ""
     in
     column
         [ width fill
@@ -121,8 +127,15 @@ view o =
                                 ""
                            )
             , text <|
-                if isClient && isWrite && not isReadOnly && value /= valueSet then
-                    " (cmd pending)"
+                if isClient && isWrite && not isReadOnly then
+                    if writeStatus == Point.valueWriteFailed then
+                        " (write failed)"
+
+                    else if writeStatus == Point.valuePending || value /= valueSet then
+                        " (write pending)"
+
+                    else
+                        ""
 
                 else
                     ""
@@ -155,7 +168,32 @@ view o =
                             , ( Point.valueUINT32, "UINT32" )
                             , ( Point.valueINT32, "INT32" )
                             , ( Point.valueFLOAT32, "FLOAT32" )
+                            , ( Point.valueUINT64, "UINT64" )
+                            , ( Point.valueINT64, "INT64" )
+                            , ( Point.valueFLOAT64, "FLOAT64" )
                             ]
+                    , viewIf isRegister <|
+                        optionInput Point.typeByteOrder
+                            "Byte order"
+                            [ ( Point.valueABCD, "ABCD (big endian)" )
+                            , ( Point.valueCDAB, "CDAB (word swap)" )
+                            , ( Point.valueBADC, "BADC (byte swap)" )
+                            , ( Point.valueDCBA, "DCBA (little endian)" )
+                            ]
+                    , viewIf isRegister <|
+                        numberInput Point.typeBitOffset "Bit offset"
+                    , viewIf isRegister <|
+                        numberInput Point.typeBitCount "Bit count (0 = all)"
+                    , viewIf isRegister <|
+                        text "Scaling polynomial (replaces scale/offset if set):"
+                    , viewIf isRegister <|
+                        polyInput "0" "Constant"
+                    , viewIf isRegister <|
+                        polyInput "1" "x"
+                    , viewIf isRegister <|
+                        polyInput "2" "x²"
+                    , viewIf isRegister <|
+                        polyInput "3" "x³"
 
                     -- This can get a little confusing, but client sets the following:
                     --   * coil
@@ -186,6 +224,15 @@ view o =
                         numberInput Point.typeValue "Value"
                     , viewIf (not isClient && modbusIOType == Point.valueModbusDiscreteInput) <|
                         onOffInput Point.typeValue Point.typeValue "Value"
+                    , viewIf isClient <|
+                        numberInput Point.typePollPeriod "Poll period (ms)"
+                    , viewIf isClient <|
+                        optionInput Point.typePollPriority
+                            "Poll priority"
+                            [ ( Point.valueHigh, "high" )
+                            , ( Point.valueNormal, "normal" )
+                            , ( Point.valueLow, "low" )
+                            ]
                     , viewIf isClient <| checkboxInput Point.typeDisable "Disable"
                     , counterWithReset Point.typeErrorCount Point.typeErrorCountReset "Error Count"
                     , counterWithReset Point.typeErrorCountEOF Point.typeErrorCountEOFReset "EOF Error Count"
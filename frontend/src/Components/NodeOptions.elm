@@ -1,7 +1,7 @@
-module Components.NodeOptions exposing (CopyMove(..), NodeOptions, findNode, oToInputO)
+module Components.NodeOptions exposing (CopyMove(..), NodeOptions, findNode, findTimezone, oToInputO)
 
 import Api.Node exposing (Node, NodeView)
-import Api.Point exposing (Point)
+import Api.Point as Point exposing (Point)
 import Time
 import Tree exposing (Tree)
 import Tree.Zipper as Zipper
@@ -56,3 +56,40 @@ findNode nodes id =
         )
         Nothing
         nodes
+
+
+{-| findTimezone returns the timezone point of a node, or of its nearest
+ancestor that has one. Returns "" if no timezone is set.
+-}
+findTimezone : List (Tree NodeView) -> String -> String
+findTimezone nodes id =
+    List.foldl
+        (\t ret ->
+            if ret /= "" then
+                ret
+
+            else
+                Zipper.findFromRoot (\n -> n.node.id == id) (Zipper.fromTree t)
+                    |> Maybe.map zipperTimezone
+                    |> Maybe.withDefault ""
+        )
+        ""
+        nodes
+
+
+zipperTimezone : Zipper.Zipper NodeView -> String
+zipperTimezone z =
+    let
+        tz =
+            Point.getText (Zipper.label z).node.points Point.typeTimezone ""
+    in
+    if tz /= "" then
+        tz
+
+    else
+        case Zipper.parent z of
+            Just p ->
+                zipperTimezone p
+
+            Nothing ->
+                ""
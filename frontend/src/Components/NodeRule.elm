@@ -20,6 +20,9 @@ view o =
         textInput =
             NodeInputs.nodeTextInput opts ""
 
+        optionInput =
+            NodeInputs.nodeOptionInput opts ""
+
         active =
             Point.getBool o.node.points Point.typeActive ""
 
@@ -52,6 +55,12 @@ view o =
             ]
             :: (if o.expDetail then
                     [ textInput Point.typeDescription "Description" ""
+                    , optionInput Point.typeSeverity
+                        "Notification severity"
+                        [ ( Point.valueInfo, "Info" )
+                        , ( Point.valueWarning, "Warning" )
+                        , ( Point.valueCritical, "Critical" )
+                        ]
                     ]
 
                 else
@@ -42,6 +42,10 @@ view o =
                     [ textInput Point.typeDescription "Description" ""
                     , textInput Point.typeURI "URI" "nats://myserver:4222, ws://myserver"
                     , textInput Point.typeAuthToken "Auth Token" ""
+                    , textInput Point.typeTLSCert "TLS Cert" "file or PEM data"
+                    , textInput Point.typeTLSKey "TLS Key" "file or PEM data"
+                    , textInput Point.typeTLSCA "TLS CA" "file or PEM data"
+                    , checkboxInput Point.typeCertAuto "Request and rotate cert"
                     , checkboxInput Point.typeDisable "Disable"
                     ]
 
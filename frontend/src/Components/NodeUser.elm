@@ -21,6 +21,12 @@ view o =
         textInput =
             NodeInputs.nodeTextInput opts ""
 
+        optionInput =
+            NodeInputs.nodeOptionInput opts ""
+
+        checkboxInput =
+            NodeInputs.nodeCheckboxInput opts ""
+
         textInputLowerCase =
             NodeInputs.nodeTextInput
                 { onEditNodePoint =
@@ -53,6 +59,23 @@ view o =
                     , textInputLowerCase Point.typeEmail "Email" ""
                     , textInput Point.typePhone "Phone" ""
                     , textInput Point.typePass "Pass" ""
+                    , textInput Point.typeLocale "Locale" "en-US"
+                    , optionInput Point.typeUnitSystem
+                        "Units"
+                        [ ( Point.valueMetric, "Metric" )
+                        , ( Point.valueImperial, "Imperial" )
+                        ]
+                    , textInput Point.typeTimezone "Timezone" "America/New_York"
+                    , checkboxInput Point.typeDisableEmail "Disable email"
+                    , checkboxInput Point.typeDisableSMS "Disable SMS"
+                    , optionInput Point.typeNotifySeverity
+                        "Notify for"
+                        [ ( Point.valueInfo, "All" )
+                        , ( Point.valueWarning, "Warning and critical" )
+                        , ( Point.valueCritical, "Critical only" )
+                        ]
+                    , textInput Point.typeQuietStart "Quiet start" "22:00"
+                    , textInput Point.typeQuietEnd "Quiet end" "07:00"
                     ]
 
                 else
@@ -5,8 +5,8 @@ import Browser.Navigation as Nav
 import Shared exposing (Flags)
 import Spa.Document as Document exposing (Document)
 import Spa.Generated.Pages as Pages
-import Spa.Generated.Route as Route exposing (Route)
 import Url exposing (Url)
+import Utils.Route
 
 
 main : Program Flags Model Msg
@@ -38,7 +38,7 @@ init flags url key =
             Shared.init flags url key
 
         ( page, pageCmd ) =
-            Pages.init (fromUrl url) shared
+            Pages.init (Utils.Route.fromUrl flags url) shared
     in
     ( Model shared page
     , Cmd.batch
@@ -81,7 +81,7 @@ update msg model =
                     { original | url = url }
 
                 ( page, pageCmd ) =
-                    Pages.init (fromUrl url) shared
+                    Pages.init (Utils.Route.fromUrl shared.basePath url) shared
             in
             ( { model | page = page, shared = Pages.save page shared }
             , Cmd.map Pages pageCmd
@@ -134,12 +134,3 @@ subscriptions model =
         , Pages.subscriptions model.page
             |> Sub.map Pages
         ]
-
-
-
--- URL
-
-
-fromUrl : Url -> Route
-fromUrl url =
-    Route.fromUrl url |> Maybe.withDefault Route.NotFound
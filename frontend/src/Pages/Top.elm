@@ -3,6 +3,7 @@ module Pages.Top exposing (Model, Msg, Params, page)
 import Api.Auth exposing (Auth)
 import Api.Data as Data exposing (Data)
 import Api.Node as Node exposing (Node, NodeView)
+import Api.NodeUI as NodeUI exposing (NodeUI)
 import Api.Point as Point exposing (Point)
 import Api.Port as Port
 import Api.Response exposing (Response)
@@ -11,6 +12,7 @@ import Components.NodeAction as NodeAction
 import Components.NodeCondition as NodeCondition
 import Components.NodeDb as NodeDb
 import Components.NodeDevice as NodeDevice
+import Components.NodeGeneric as NodeGeneric
 import Components.NodeGroup as NodeGroup
 import Components.NodeMessageService as NodeMessageService
 import Components.NodeModbus as NodeModbus
@@ -79,6 +81,7 @@ type alias Model =
     , nodeOp : NodeOperation
     , copyMove : CopyMove
     , nodeMsg : Maybe NodeMsg
+    , nodeUIs : Dict.Dict String NodeUI
     }
 
 
@@ -131,6 +134,7 @@ defaultModel key =
         OpNone
         CopyMoveNone
         Nothing
+        Dict.empty
 
 
 init : Shared.Model -> Url Params -> ( Model, Cmd Msg )
@@ -146,6 +150,7 @@ init shared { key } =
                 [ Task.perform Zone Time.here
                 , Task.perform Tick Time.now
                 , Node.list { onResponse = ApiRespList, token = auth.token }
+                , NodeUI.list { onResponse = ApiRespNodeUIs, token = auth.token }
                 ]
             )
 
@@ -182,6 +187,7 @@ type Msg
     | ApiPutDuplicateNode Int String String
     | ApiPostNotificationNode
     | ApiRespList (Data (List Node))
+    | ApiRespNodeUIs (Data (List NodeUI))
     | ApiRespDelete (Data Response)
     | ApiRespPostPoint (Data Response)
     | ApiRespPostAddNode Int (Data Response)
@@ -634,6 +640,16 @@ update msg model =
         ClearClipboard ->
             ( { model | copyMove = CopyMoveNone }, Cmd.none )
 
+        ApiRespNodeUIs resp ->
+            case resp of
+                Data.Success uis ->
+                    ( { model | nodeUIs = Dict.fromList <| List.map (\ui -> ( ui.typ, ui )) uis }
+                    , Cmd.none
+                    )
+
+                _ ->
+                    ( model, Cmd.none )
+
 
 mergeNodeTrees : List (Tree NodeView) -> List (Tree NodeView) -> List (Tree NodeView)
 mergeNodeTrees current new =
@@ -1064,6 +1080,7 @@ viewNodesHelp depth model tree =
 
                 display =
                     shouldDisplay childNode.node.typ
+                        || Dict.member childNode.node.typ model.nodeUIs
             in
             if display && not tombstone then
                 ret
@@ -1197,7 +1214,12 @@ viewNode model parent node depth =
                     NodeDb.view
 
                 _ ->
-                    viewUnknown
+                    case Dict.get node.node.typ model.nodeUIs of
+                        Just ui ->
+                            NodeGeneric.view ui
+
+                        Nothing ->
+                            viewUnknown
 
         background =
             if node.expDetail then
@@ -1221,7 +1243,7 @@ viewNode model parent node depth =
                 model.nodeMsg
 
         viewNodeOps =
-            viewNodeOperations node msg
+            viewNodeOperations model.nodeUIs node msg
     in
     el
         [ width fill
@@ -1276,7 +1298,7 @@ viewNode model parent node depth =
 
                         OpNodeToAdd add ->
                             if add.feID == node.feID then
-                                viewAddNode node add
+                                viewAddNode model.nodeUIs node add
 
                             else
                                 viewNodeOps
@@ -1324,8 +1346,8 @@ nodeTypesThatHaveChildNodes =
     ]
 
 
-viewNodeOperations : NodeView -> Maybe String -> Element Msg
-viewNodeOperations node msg =
+viewNodeOperations : Dict.Dict String NodeUI -> NodeView -> Maybe String -> Element Msg
+viewNodeOperations uis node msg =
     let
         desc =
             Point.getBestDesc node.node.points
@@ -1333,6 +1355,7 @@ viewNodeOperations node msg =
         showNodeAdd =
             List.member node.node.typ
                 nodeTypesThatHaveChildNodes
+                || not (List.isEmpty (nodeUIsForParent uis node.node.typ))
     in
     column [ spacing 6 ]
         [ row [ spacing 6 ]
@@ -1422,8 +1445,18 @@ nodeDescActionInactive =
     row [] [ Icon.trendingDown, text "Action (rule inactive)" ]
 
 
-viewAddNode : NodeView -> NodeToAdd -> Element Msg
-viewAddNode parent add =
+{-| nodeUIsForParent returns the node UI descriptors for node types that can
+be added to a parent node type and do not have a custom view
+-}
+nodeUIsForParent : Dict.Dict String NodeUI -> String -> List NodeUI
+nodeUIsForParent uis parentType =
+    Dict.values uis
+        |> List.filter
+            (\ui -> List.member parentType ui.parents && not (shouldDisplay ui.typ))
+
+
+viewAddNode : Dict.Dict String NodeUI -> NodeView -> NodeToAdd -> Element Msg
+viewAddNode uis parent add =
     column [ spacing 10 ]
         [ Input.radio [ spacing 6 ]
             { onChange = SelectAddNodeType
@@ -1477,6 +1510,9 @@ viewAddNode parent add =
                         else
                             []
                        )
+                    ++ List.map
+                        (\ui -> Input.option ui.typ (row [] [ Icon.box, text ui.label ]))
+                        (nodeUIsForParent uis parent.node.typ)
             }
         , Form.buttonRow
             [ case add.typ of
@@ -0,0 +1,23 @@
+port module Ports exposing (pushSubscribe, pushUnsubscribe)
+
+{-| Ports to the Javascript code in public/ports.js
+-}
+
+import Json.Encode as Encode
+
+
+port out : { action : String, data : Encode.Value } -> Cmd msg
+
+
+{-| subscribe this browser to push notifications for the signed in user
+-}
+pushSubscribe : String -> Cmd msg
+pushSubscribe token =
+    out { action = "PUSH_SUBSCRIBE", data = Encode.string token }
+
+
+{-| remove the push subscription of this browser, used when the user signs out
+-}
+pushUnsubscribe : String -> Cmd msg
+pushUnsubscribe token =
+    out { action = "PUSH_UNSUBSCRIBE", data = Encode.string token }
@@ -9,9 +9,12 @@ module Shared exposing
     )
 
 import Api.Auth exposing (Auth)
+import Api.Data exposing (Data)
+import Api.Response exposing (Response)
 import Browser.Navigation exposing (Key)
 import Components.Navbar exposing (navbar)
 import Element exposing (..)
+import Ports
 import Spa.Document exposing (Document)
 import Spa.Generated.Route as Route
 import Task
@@ -25,13 +28,16 @@ import Utils.Route
 -- INIT
 
 
+{-| the path the frontend is served at, for example / or /siot/
+-}
 type alias Flags =
-    ()
+    String
 
 
 type alias Model =
     { url : Url
     , key : Key
+    , basePath : String
     , auth : Maybe Auth
     , error : Maybe String
     , now : Time.Posix
@@ -41,8 +47,8 @@ type alias Model =
 
 
 init : Flags -> Url -> Key -> ( Model, Cmd Msg )
-init _ url key =
-    ( Model url key Nothing Nothing (Time.millisToPosix 0) Time.utc (Time.millisToPosix 0)
+init basePath url key =
+    ( Model url key basePath Nothing Nothing (Time.millisToPosix 0) Time.utc (Time.millisToPosix 0)
     , Task.perform SetZone Time.here
     )
 
@@ -53,6 +59,9 @@ init _ url key =
 
 type Msg
     = SignOut
+    | SignOutAll
+    | EnablePush
+    | SignedOut (Data Response)
     | SetZone Time.Zone
     | Tick Time.Posix
 
@@ -61,10 +70,24 @@ update : Msg -> Model -> ( Model, Cmd Msg )
 update msg model =
     case msg of
         SignOut ->
-            ( { model | auth = Nothing }
-            , Utils.Route.navigate model.key Route.SignIn
+            signOut False model
+
+        SignOutAll ->
+            signOut True model
+
+        EnablePush ->
+            ( model
+            , case model.auth of
+                Just auth ->
+                    Ports.pushSubscribe auth.token
+
+                Nothing ->
+                    Cmd.none
             )
 
+        SignedOut _ ->
+            ( model, Cmd.none )
+
         SetZone zone ->
             ( { model | zone = zone }, Cmd.none )
 
@@ -80,6 +103,24 @@ update msg model =
             ( { model | now = now, error = error }, Cmd.none )
 
 
+signOut : Bool -> Model -> ( Model, Cmd Msg )
+signOut all model =
+    ( { model | auth = Nothing }
+    , Cmd.batch
+        [ case model.auth of
+            Just auth ->
+                Cmd.batch
+                    [ Ports.pushUnsubscribe auth.token
+                    , Api.Auth.logout { token = auth.token, all = all, onResponse = SignedOut }
+                    ]
+
+            Nothing ->
+                Cmd.none
+        , Utils.Route.navigate model.key Route.SignIn
+        ]
+    )
+
+
 subscriptions : Model -> Sub Msg
 subscriptions _ =
     Sub.batch
@@ -110,6 +151,8 @@ view { page, toMsg } model =
         [ column [ spacing 32, padding 20, width (fill |> maximum 1280), height fill, centerX ]
             [ navbar
                 { onSignOut = toMsg SignOut
+                , onSignOutAll = toMsg SignOutAll
+                , onEnablePush = toMsg EnablePush
                 , authenticated = authenticated
                 , email = email
                 }
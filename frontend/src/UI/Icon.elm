@@ -1,6 +1,7 @@
 module UI.Icon exposing
     ( activity
     , blank
+    , box
     , bus
     , check
     , clipboard
@@ -200,3 +201,8 @@ database =
 clipboard : Element msg
 clipboard =
     icon FeatherIcons.clipboard
+
+
+box : Element msg
+box =
+    icon FeatherIcons.box
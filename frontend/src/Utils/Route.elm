@@ -1,9 +1,41 @@
-module Utils.Route exposing (navigate)
+module Utils.Route exposing (fromUrl, navigate, toHref)
 
 import Browser.Navigation as Nav
 import Spa.Generated.Route as Route exposing (Route)
+import Url exposing (Url)
 
 
 navigate : Nav.Key -> Route -> Cmd msg
 navigate key route =
-    Nav.pushUrl key (Route.toString route)
+    Nav.pushUrl key (toHref route)
+
+
+{-| links are relative to the base of the page, which the server sets to the
+path SIOT is served at
+-}
+toHref : Route -> String
+toHref route =
+    String.dropLeft 1 (Route.toString route)
+
+
+{-| the route of a URL, with the base path removed
+-}
+fromUrl : String -> Url -> Route
+fromUrl basePath url =
+    let
+        base =
+            if String.endsWith "/" basePath then
+                String.dropRight 1 basePath
+
+            else
+                basePath
+
+        path =
+            if String.startsWith base url.path then
+                String.dropLeft (String.length base) url.path
+
+            else
+                url.path
+    in
+    Route.fromUrl { url | path = path }
+        |> Maybe.withDefault Route.NotFound
@@ -0,0 +1,337 @@
+// Package gorilla compresses time series samples with the delta-of-delta
+// timestamp and XOR value encoding described in the Facebook Gorilla paper
+// ("Gorilla: A Fast, Scalable, In-Memory Time Series Database"). Samples
+// taken at a regular interval with slowly changing values compress to a few
+// bits each. Times are stored with millisecond resolution.
+package gorilla
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"math/bits"
+	"time"
+)
+
+// ErrTruncated is returned if the data ends before all samples are decoded
+var ErrTruncated = errors.New("gorilla: data truncated")
+
+// Sample is a value at a time
+type Sample struct {
+	Time  time.Time
+	Value float64
+}
+
+type bitWriter struct {
+	b []byte
+	// free is the number of unused bits in the last byte
+	free int
+}
+
+func (w *bitWriter) writeBit(bit bool) {
+	if w.free == 0 {
+		w.b = append(w.b, 0)
+		w.free = 8
+	}
+
+	w.free--
+	if bit {
+		w.b[len(w.b)-1] |= 1 << w.free
+	}
+}
+
+// writeBits writes the low n bits of v, most significant bit first
+func (w *bitWriter) writeBits(v uint64, n int) {
+	for n > 0 {
+		if w.free == 0 {
+			w.b = append(w.b, 0)
+			w.free = 8
+		}
+
+		c := n
+		if c > w.free {
+			c = w.free
+		}
+
+		n -= c
+		w.free -= c
+		w.b[len(w.b)-1] |= byte((v>>n)&(1<<c-1)) << w.free
+	}
+}
+
+type bitReader struct {
+	b []byte
+	// pos is the bit position of the next bit
+	pos int
+}
+
+func (r *bitReader) readBit() (bool, error) {
+	if r.pos >= len(r.b)*8 {
+		return false, ErrTruncated
+	}
+
+	bit := r.b[r.pos/8]&(1<<(7-r.pos%8)) != 0
+	r.pos++
+	return bit, nil
+}
+
+func (r *bitReader) readBits(n int) (uint64, error) {
+	if r.pos+n > len(r.b)*8 {
+		return 0, ErrTruncated
+	}
+
+	var ret uint64
+	for n > 0 {
+		used := r.pos % 8
+		c := 8 - used
+		if c > n {
+			c = n
+		}
+
+		v := uint64(r.b[r.pos/8]>>(8-used-c)) & (1<<c - 1)
+		ret = ret<<c | v
+		n -= c
+		r.pos += c
+	}
+
+	return ret, nil
+}
+
+// timestamp delta-of-delta buckets. Each bucket is selected by a prefix of
+// 1 bits terminated by a 0 bit, except the last one.
+var dodBits = []int{7, 9, 12, 32, 64}
+
+// Encoder compresses samples
+type Encoder struct {
+	w     bitWriter
+	count int
+
+	t      int64
+	delta  int64
+	v      uint64
+	lead   int
+	trail  int
+	window bool
+}
+
+// NewEncoder returns a new encoder
+func NewEncoder() *Encoder {
+	return &Encoder{}
+}
+
+// Encode adds a sample. Samples should be added in time order, as samples
+// out of order take more space.
+func (e *Encoder) Encode(t time.Time, v float64) {
+	ms := t.UnixMilli()
+	vb := math.Float64bits(v)
+
+	if e.count == 0 {
+		e.w.writeBits(uint64(ms), 64)
+		e.w.writeBits(vb, 64)
+		e.t = ms
+		e.v = vb
+		e.count++
+		return
+	}
+
+	delta := ms - e.t
+	e.writeDod(delta - e.delta)
+	e.t = ms
+	e.delta = delta
+
+	e.writeValue(vb)
+	e.count++
+}
+
+func (e *Encoder) writeDod(dod int64) {
+	if dod == 0 {
+		e.w.writeBit(false)
+		return
+	}
+
+	for i, n := range dodBits {
+		last := i == len(dodBits)-1
+		if !last && (dod < -(1<<(n-1)) || dod >= 1<<(n-1)) {
+			continue
+		}
+
+		// prefix is i+1 1 bits, terminated by a 0 except for the last
+		// bucket
+		e.w.writeBits(1<<(i+1)-1, i+1)
+		if !last {
+			e.w.writeBit(false)
+		}
+
+		e.w.writeBits(uint64(dod), n)
+		return
+	}
+}
+
+func (e *Encoder) writeValue(vb uint64) {
+	xor := vb ^ e.v
+	e.v = vb
+
+	if xor == 0 {
+		e.w.writeBit(false)
+		return
+	}
+
+	e.w.writeBit(true)
+
+	lead := bits.LeadingZeros64(xor)
+	trail := bits.TrailingZeros64(xor)
+
+	// leading zeros are stored in 5 bits
+	if lead > 31 {
+		lead = 31
+	}
+
+	if e.window && lead >= e.lead && trail >= e.trail {
+		// meaningful bits fit in the previous window
+		e.w.writeBit(false)
+		e.w.writeBits(xor>>e.trail, 64-e.lead-e.trail)
+		return
+	}
+
+	sig := 64 - lead - trail
+	e.w.writeBit(true)
+	e.w.writeBits(uint64(lead), 5)
+	e.w.writeBits(uint64(sig-1), 6)
+	e.w.writeBits(xor>>trail, sig)
+
+	e.lead = lead
+	e.trail = trail
+	e.window = true
+}
+
+// Count returns the number of samples encoded
+func (e *Encoder) Count() int {
+	return e.count
+}
+
+// Bytes returns the encoded samples
+func (e *Encoder) Bytes() []byte {
+	ret := make([]byte, binary.MaxVarintLen64, binary.MaxVarintLen64+len(e.w.b))
+	n := binary.PutUvarint(ret, uint64(e.count))
+	return append(ret[:n], e.w.b...)
+}
+
+// Decode decodes samples encoded by an Encoder
+func Decode(b []byte) ([]Sample, error) {
+	count, n := binary.Uvarint(b)
+	if n <= 0 {
+		return nil, ErrTruncated
+	}
+
+	// each sample takes at least 2 bits
+	if count > uint64(len(b)-n)*4+1 {
+		return nil, ErrTruncated
+	}
+
+	r := bitReader{b: b[n:]}
+	ret := make([]Sample, 0, count)
+
+	var t, delta int64
+	var v uint64
+	var lead, trail int
+
+	for i := uint64(0); i < count; i++ {
+		if i == 0 {
+			tb, err := r.readBits(64)
+			if err != nil {
+				return nil, err
+			}
+
+			v, err = r.readBits(64)
+			if err != nil {
+				return nil, err
+			}
+
+			t = int64(tb)
+			ret = append(ret, Sample{time.UnixMilli(t), math.Float64frombits(v)})
+			continue
+		}
+
+		dod, err := readDod(&r)
+		if err != nil {
+			return nil, err
+		}
+
+		delta += dod
+		t += delta
+
+		same, err := r.readBit()
+		if err != nil {
+			return nil, err
+		}
+
+		if same {
+			newWindow, err := r.readBit()
+			if err != nil {
+				return nil, err
+			}
+
+			if newWindow {
+				l, err := r.readBits(5)
+				if err != nil {
+					return nil, err
+				}
+
+				sig, err := r.readBits(6)
+				if err != nil {
+					return nil, err
+				}
+
+				lead = int(l)
+				trail = 64 - lead - int(sig) - 1
+				if trail < 0 {
+					return nil, errors.New("gorilla: invalid value window")
+				}
+			}
+
+			xor, err := r.readBits(64 - lead - trail)
+			if err != nil {
+				return nil, err
+			}
+
+			v ^= xor << trail
+		}
+
+		ret = append(ret, Sample{time.UnixMilli(t), math.Float64frombits(v)})
+	}
+
+	return ret, nil
+}
+
+func readDod(r *bitReader) (int64, error) {
+	bucket := 0
+
+	for ; bucket < len(dodBits); bucket++ {
+		bit, err := r.readBit()
+		if err != nil {
+			return 0, err
+		}
+
+		if !bit {
+			break
+		}
+	}
+
+	if bucket == 0 {
+		return 0, nil
+	}
+
+	// the last bucket has no terminating 0 bit
+	n := dodBits[bucket-1]
+	v, err := r.readBits(n)
+	if err != nil {
+		return 0, err
+	}
+
+	if n == 64 {
+		return int64(v), nil
+	}
+
+	// sign extend
+	return int64(v<<(64-n)) >> (64 - n), nil
+}
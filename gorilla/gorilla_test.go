@@ -0,0 +1,113 @@
+package gorilla
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func roundTrip(t *testing.T, samples []Sample) []byte {
+	t.Helper()
+
+	e := NewEncoder()
+	for _, s := range samples {
+		e.Encode(s.Time, s.Value)
+	}
+
+	if e.Count() != len(samples) {
+		t.Fatal("wrong count: ", e.Count())
+	}
+
+	b := e.Bytes()
+	ret, err := Decode(b)
+	if err != nil {
+		t.Fatal("Error decoding: ", err)
+	}
+
+	if len(ret) != len(samples) {
+		t.Fatalf("decoded %v samples, expected %v", len(ret), len(samples))
+	}
+
+	for i := range samples {
+		if !ret[i].Time.Equal(samples[i].Time) ||
+			math.Float64bits(ret[i].Value) != math.Float64bits(samples[i].Value) {
+			t.Fatalf("sample %v: got %v, expected %v", i, ret[i], samples[i])
+		}
+	}
+
+	return b
+}
+
+func TestRegular(t *testing.T) {
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// a day of 1 minute samples of a slowly changing temperature
+	var samples []Sample
+	for i := 0; i < 24*60; i++ {
+		samples = append(samples, Sample{start.Add(time.Duration(i) * time.Minute),
+			20 + math.Round(math.Sin(float64(i)/100)*10)/2})
+	}
+
+	b := roundTrip(t, samples)
+
+	// raw samples are 16 bytes each
+	if len(b) > len(samples)*16/6 {
+		t.Errorf("poor compression: %v bytes for %v samples", len(b), len(samples))
+	}
+}
+
+func TestIrregular(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	tm := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	var samples []Sample
+	for i := 0; i < 2000; i++ {
+		// jitter, gaps, and samples out of order
+		var d time.Duration
+		switch r.Intn(4) {
+		case 0:
+			d = time.Duration(r.Intn(100)) * time.Millisecond
+		case 1:
+			d = time.Duration(r.Int63n(int64(48 * time.Hour)))
+		case 2:
+			d = -time.Duration(r.Intn(10000)) * time.Millisecond
+		default:
+			d = time.Minute
+		}
+		tm = tm.Add(d).Truncate(time.Millisecond)
+
+		var v float64
+		switch r.Intn(4) {
+		case 0:
+			v = r.NormFloat64() * 1e6
+		case 1:
+			v = math.Inf(1)
+		case 2:
+			v = float64(r.Intn(3))
+		default:
+			v = math.Float64frombits(r.Uint64())
+		}
+
+		samples = append(samples, Sample{tm, v})
+	}
+
+	roundTrip(t, samples)
+	roundTrip(t, samples[:1])
+	roundTrip(t, nil)
+}
+
+func TestTruncated(t *testing.T) {
+	e := NewEncoder()
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 10; i++ {
+		e.Encode(start.Add(time.Duration(i)*time.Second), float64(i)*1.5)
+	}
+
+	b := e.Bytes()
+	for i := 0; i < len(b)-1; i++ {
+		if _, err := Decode(b[:i]); err == nil {
+			t.Errorf("no error for data truncated to %v bytes", i)
+		}
+	}
+}
@@ -0,0 +1,265 @@
+// Package ical parses the events of iCalendar (RFC 5545) files, such as the
+// holiday lists and calendar exports of most calendar applications. Only
+// the properties SIOT uses are parsed. Common recurrence rules can be
+// expanded with Event.Occurrences.
+package ical
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Event is a VEVENT of a calendar
+type Event struct {
+	UID     string
+	Summary string
+	Start   time.Time
+	// End is exclusive. If the event has no end, it is one day after
+	// Start for all day events, and Start for other events.
+	End time.Time
+	// AllDay is set for events with dates instead of times. Start and End
+	// are midnight in the location passed to Parse.
+	AllDay bool
+	// RRule is the recurrence rule of the event, like FREQ=WEEKLY;BYDAY=MO
+	RRule string
+	// ExDates are the start times of occurrences that are excluded
+	ExDates []time.Time
+}
+
+// Dates returns the dates of the days the event is on, formatted as
+// 2006-01-02. Times are converted to loc.
+func (e Event) Dates(loc *time.Location) []string {
+	start, end := e.Start, e.End
+	if !e.AllDay {
+		start, end = start.In(loc), end.In(loc)
+	}
+
+	var ret []string
+	d := time.Date(start.Year(), start.Month(), start.Day(), 0, 0, 0, 0, start.Location())
+	for {
+		ret = append(ret, d.Format("2006-01-02"))
+		d = d.AddDate(0, 0, 1)
+		if !d.Before(end) {
+			break
+		}
+	}
+
+	return ret
+}
+
+// Parse returns the events in an iCalendar file sorted by start time. Dates,
+// and times without a timezone, are in loc.
+func Parse(r io.Reader, loc *time.Location) ([]Event, error) {
+	lines, err := unfold(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var ret []Event
+	var ev *Event
+	var duration time.Duration
+
+	for i, line := range lines {
+		name, params, value, ok := splitLine(line)
+		if !ok {
+			continue
+		}
+
+		switch {
+		case name == "BEGIN" && value == "VEVENT":
+			ev = &Event{}
+			duration = 0
+		case name == "END" && value == "VEVENT":
+			if ev == nil {
+				return nil, fmt.Errorf("line %v: END:VEVENT without BEGIN", i+1)
+			}
+			if ev.Start.IsZero() {
+				return nil, fmt.Errorf("line %v: event %v has no DTSTART", i+1, ev.UID)
+			}
+			if ev.End.IsZero() {
+				switch {
+				case duration > 0:
+					ev.End = ev.Start.Add(duration)
+				case ev.AllDay:
+					ev.End = ev.Start.AddDate(0, 0, 1)
+				default:
+					ev.End = ev.Start
+				}
+			}
+			ret = append(ret, *ev)
+			ev = nil
+		case ev == nil:
+			continue
+		case name == "UID":
+			ev.UID = value
+		case name == "SUMMARY":
+			ev.Summary = unescape(value)
+		case name == "DTSTART":
+			ev.Start, ev.AllDay, err = parseTime(params, value, loc)
+			if err != nil {
+				return nil, fmt.Errorf("line %v: %v", i+1, err)
+			}
+		case name == "DTEND":
+			ev.End, _, err = parseTime(params, value, loc)
+			if err != nil {
+				return nil, fmt.Errorf("line %v: %v", i+1, err)
+			}
+		case name == "RRULE":
+			ev.RRule = value
+		case name == "EXDATE":
+			for _, v := range strings.Split(value, ",") {
+				t, _, err := parseTime(params, v, loc)
+				if err != nil {
+					return nil, fmt.Errorf("line %v: %v", i+1, err)
+				}
+				ev.ExDates = append(ev.ExDates, t)
+			}
+		case name == "DURATION":
+			duration, err = parseDuration(value)
+			if err != nil {
+				return nil, fmt.Errorf("line %v: %v", i+1, err)
+			}
+		}
+	}
+
+	sort.SliceStable(ret, func(i, j int) bool {
+		return ret[i].Start.Before(ret[j].Start)
+	})
+
+	return ret, nil
+}
+
+// unfold reads the lines of r, joining folded lines (lines that start with a
+// space or tab continue the previous line)
+func unfold(r io.Reader) ([]string, error) {
+	var ret []string
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if len(line) > 0 && (line[0] == ' ' || line[0] == '\t') && len(ret) > 0 {
+			ret[len(ret)-1] += line[1:]
+			continue
+		}
+		ret = append(ret, line)
+	}
+
+	return ret, scanner.Err()
+}
+
+// splitLine splits a content line into its name, parameters, and value
+func splitLine(line string) (string, map[string]string, string, bool) {
+	// the value starts after the first colon that is not in a quoted
+	// parameter value
+	quoted := false
+	i := -1
+	for j, c := range line {
+		if c == '"' {
+			quoted = !quoted
+		} else if c == ':' && !quoted {
+			i = j
+			break
+		}
+	}
+
+	if i < 0 {
+		return "", nil, "", false
+	}
+
+	parts := strings.Split(line[:i], ";")
+	params := make(map[string]string)
+	for _, p := range parts[1:] {
+		kv := strings.SplitN(p, "=", 2)
+		if len(kv) == 2 {
+			params[strings.ToUpper(kv[0])] = strings.Trim(kv[1], `"`)
+		}
+	}
+
+	return strings.ToUpper(parts[0]), params, line[i+1:], true
+}
+
+func parseTime(params map[string]string, value string, loc *time.Location) (time.Time, bool, error) {
+	if params["VALUE"] == "DATE" || len(value) == 8 {
+		t, err := time.ParseInLocation("20060102", value, loc)
+		return t, true, err
+	}
+
+	if strings.HasSuffix(value, "Z") {
+		t, err := time.Parse("20060102T150405Z", value)
+		return t, false, err
+	}
+
+	if tzid := params["TZID"]; tzid != "" {
+		tz, err := time.LoadLocation(tzid)
+		if err != nil {
+			return time.Time{}, false, fmt.Errorf("unknown TZID %v", tzid)
+		}
+		loc = tz
+	}
+
+	t, err := time.ParseInLocation("20060102T150405", value, loc)
+	return t, false, err
+}
+
+// parseDuration parses the day, hour, minute, and second parts of an
+// iCalendar duration like P1D or PT1H30M
+func parseDuration(value string) (time.Duration, error) {
+	v := strings.TrimPrefix(value, "+")
+	if !strings.HasPrefix(v, "P") {
+		return 0, fmt.Errorf("invalid duration %v", value)
+	}
+
+	var ret time.Duration
+	n := 0
+	digits := false
+
+	for _, c := range v[1:] {
+		switch {
+		case c >= '0' && c <= '9':
+			n = n*10 + int(c-'0')
+			digits = true
+			continue
+		case c == 'T':
+			continue
+		}
+
+		if !digits {
+			return 0, fmt.Errorf("invalid duration %v", value)
+		}
+
+		switch c {
+		case 'W':
+			ret += time.Duration(n) * 7 * 24 * time.Hour
+		case 'D':
+			ret += time.Duration(n) * 24 * time.Hour
+		case 'H':
+			ret += time.Duration(n) * time.Hour
+		case 'M':
+			ret += time.Duration(n) * time.Minute
+		case 'S':
+			ret += time.Duration(n) * time.Second
+		default:
+			return 0, fmt.Errorf("invalid duration %v", value)
+		}
+
+		n = 0
+		digits = false
+	}
+
+	if digits {
+		return 0, fmt.Errorf("invalid duration %v", value)
+	}
+
+	return ret, nil
+}
+
+var unescaper = strings.NewReplacer(`\n`, "\n", `\N`, "\n", `\,`, ",", `\;`, ";", `\\`, `\`)
+
+func unescape(s string) string {
+	return unescaper.Replace(s)
+}
@@ -0,0 +1,105 @@
+package ical
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+const testCal = `BEGIN:VCALENDAR
+VERSION:2.0
+PRODID:-//test//EN
+BEGIN:VEVENT
+UID:christmas
+DTSTART;VALUE=DATE:20241225
+DTEND;VALUE=DATE:20241227
+SUMMARY:Christmas\, Boxing Day
+END:VEVENT
+BEGIN:VEVENT
+UID:new-year
+DTSTART;VALUE=DATE:20250101
+SUMMARY:New Year's
+  Day
+END:VEVENT
+BEGIN:VEVENT
+UID:meeting
+DTSTART;TZID=America/New_York:20241220T090000
+DURATION:PT1H30M
+SUMMARY:Meeting
+END:VEVENT
+BEGIN:VEVENT
+UID:late
+DTSTART:20241221T030000Z
+DTEND:20241221T040000Z
+SUMMARY:Late
+END:VEVENT
+END:VCALENDAR
+`
+
+func TestParse(t *testing.T) {
+	events, err := Parse(strings.NewReader(testCal), time.UTC)
+	if err != nil {
+		t.Fatal("Error parsing: ", err)
+	}
+
+	if len(events) != 4 {
+		t.Fatal("expected 4 events, got: ", len(events))
+	}
+
+	// events are sorted by start time
+	uids := []string{"meeting", "late", "christmas", "new-year"}
+	for i, uid := range uids {
+		if events[i].UID != uid {
+			t.Errorf("event %v: expected %v, got %v", i, uid, events[i].UID)
+		}
+	}
+
+	meeting := events[0]
+	ny, _ := time.LoadLocation("America/New_York")
+	if !meeting.Start.Equal(time.Date(2024, 12, 20, 9, 0, 0, 0, ny)) ||
+		meeting.End.Sub(meeting.Start) != 90*time.Minute || meeting.AllDay {
+		t.Error("meeting not parsed correctly: ", meeting)
+	}
+
+	christmas := events[2]
+	if christmas.Summary != "Christmas, Boxing Day" || !christmas.AllDay {
+		t.Error("christmas not parsed correctly: ", christmas)
+	}
+
+	if events[3].Summary != "New Year's Day" {
+		t.Error("folded summary not unfolded: ", events[3].Summary)
+	}
+
+	tests := []struct {
+		ev    Event
+		loc   *time.Location
+		dates string
+	}{
+		{christmas, time.UTC, "2024-12-25 2024-12-26"},
+		{events[3], time.UTC, "2025-01-01"},
+		// 03:00 UTC is the previous day in New York
+		{events[1], time.UTC, "2024-12-21"},
+		{events[1], ny, "2024-12-20"},
+	}
+
+	for _, test := range tests {
+		dates := strings.Join(test.ev.Dates(test.loc), " ")
+		if dates != test.dates {
+			t.Errorf("%v: expected dates %v, got %v", test.ev.UID, test.dates, dates)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	bad := []string{
+		"BEGIN:VEVENT\nSUMMARY:no start\nEND:VEVENT\n",
+		"BEGIN:VEVENT\nDTSTART:2024\nEND:VEVENT\n",
+		"BEGIN:VEVENT\nDTSTART:20241220T090000Z\nDURATION:1H\nEND:VEVENT\n",
+	}
+
+	for _, b := range bad {
+		if _, err := Parse(strings.NewReader(b), time.UTC); err == nil {
+			t.Errorf("expected error for %q", b)
+		}
+	}
+}
@@ -0,0 +1,238 @@
+package ical
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxOccurrences limits the occurrences generated for a rule, in case a rule
+// without an end is expanded far into the future
+const maxOccurrences = 10000
+
+var weekdays = map[string]time.Weekday{
+	"SU": time.Sunday,
+	"MO": time.Monday,
+	"TU": time.Tuesday,
+	"WE": time.Wednesday,
+	"TH": time.Thursday,
+	"FR": time.Friday,
+	"SA": time.Saturday,
+}
+
+type rrule struct {
+	freq     string
+	interval int
+	count    int
+	until    time.Time
+	byDay    []time.Weekday
+}
+
+// parseRRule parses the rule parts used by most calendar applications.
+// Rules with other BY parts, like BYMONTHDAY or BYSETPOS, return an error.
+func parseRRule(value string, loc *time.Location) (rrule, error) {
+	ret := rrule{interval: 1}
+
+	for _, part := range strings.Split(value, ";") {
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			return ret, fmt.Errorf("invalid rule part %v", part)
+		}
+
+		var err error
+
+		switch strings.ToUpper(kv[0]) {
+		case "FREQ":
+			ret.freq = strings.ToUpper(kv[1])
+		case "INTERVAL":
+			ret.interval, err = strconv.Atoi(kv[1])
+			if err == nil && ret.interval < 1 {
+				err = fmt.Errorf("invalid interval %v", kv[1])
+			}
+		case "COUNT":
+			ret.count, err = strconv.Atoi(kv[1])
+		case "UNTIL":
+			ret.until, _, err = parseTime(nil, kv[1], loc)
+		case "BYDAY":
+			for _, d := range strings.Split(kv[1], ",") {
+				wd, ok := weekdays[strings.ToUpper(d)]
+				if !ok {
+					return ret, fmt.Errorf("unsupported BYDAY %v", d)
+				}
+				ret.byDay = append(ret.byDay, wd)
+			}
+		case "WKST":
+		default:
+			return ret, fmt.Errorf("unsupported rule part %v", kv[0])
+		}
+
+		if err != nil {
+			return ret, err
+		}
+	}
+
+	switch ret.freq {
+	case "DAILY", "WEEKLY", "MONTHLY", "YEARLY":
+	default:
+		return ret, fmt.Errorf("unsupported FREQ %v", ret.freq)
+	}
+
+	if len(ret.byDay) > 0 && ret.freq != "WEEKLY" && ret.freq != "DAILY" {
+		return ret, fmt.Errorf("BYDAY is only supported for DAILY and WEEKLY rules")
+	}
+
+	return ret, nil
+}
+
+// starts returns the start times of the rule for an event that starts at
+// start, until the first start at or after to
+func (r rrule) starts(start, to time.Time) []time.Time {
+	var ret []time.Time
+	n := 0
+
+	add := func(t time.Time) bool {
+		if t.Before(start) {
+			return true
+		}
+		if (r.count > 0 && n >= r.count) ||
+			(!r.until.IsZero() && t.After(r.until)) ||
+			!t.Before(to) || len(ret) >= maxOccurrences {
+			return false
+		}
+		n++
+		ret = append(ret, t)
+		return true
+	}
+
+	byDay := make(map[time.Weekday]bool)
+	for _, d := range r.byDay {
+		byDay[d] = true
+	}
+
+	switch r.freq {
+	case "DAILY":
+		for i := 0; ; i++ {
+			t := start.AddDate(0, 0, i*r.interval)
+			if len(byDay) > 0 && !byDay[t.Weekday()] {
+				if !t.Before(to) {
+					return ret
+				}
+				continue
+			}
+			if !add(t) {
+				return ret
+			}
+		}
+	case "WEEKLY":
+		if len(byDay) == 0 {
+			byDay[start.Weekday()] = true
+		}
+		// weeks start on Monday
+		offset := (int(start.Weekday()) + 6) % 7
+		weekStart := start.AddDate(0, 0, -offset)
+		for i := 0; ; i++ {
+			week := weekStart.AddDate(0, 0, i*7*r.interval)
+			for d := 0; d < 7; d++ {
+				t := week.AddDate(0, 0, d)
+				if !byDay[t.Weekday()] {
+					continue
+				}
+				if !add(t) {
+					return ret
+				}
+			}
+		}
+	case "MONTHLY", "YEARLY":
+		for i := 0; ; i++ {
+			var t time.Time
+			if r.freq == "MONTHLY" {
+				t = start.AddDate(0, i*r.interval, 0)
+			} else {
+				t = start.AddDate(i*r.interval, 0, 0)
+			}
+			// skip months without the day, like the 31st or Feb 29
+			if t.Day() != start.Day() {
+				if !t.Before(to) {
+					return ret
+				}
+				continue
+			}
+			if !add(t) {
+				return ret
+			}
+		}
+	}
+
+	return ret
+}
+
+// Occurrences returns the occurrences of the event that overlap from to to,
+// sorted by start time. Events without a recurrence rule return the event if
+// it overlaps. If the recurrence rule is not supported, only the first
+// occurrence is used.
+func (e Event) Occurrences(from, to time.Time) []Event {
+	duration := e.End.Sub(e.Start)
+	starts := []time.Time{e.Start}
+
+	if e.RRule != "" {
+		r, err := parseRRule(e.RRule, e.Start.Location())
+		if err == nil {
+			starts = r.starts(e.Start, to)
+		}
+	}
+
+	var ret []Event
+
+	for _, s := range starts {
+		if excluded(e.ExDates, s) {
+			continue
+		}
+
+		end := s.Add(duration)
+		if e.AllDay {
+			// keep all day events on date boundaries over DST changes
+			days := int(duration.Hours()+12) / 24
+			end = s.AddDate(0, 0, days)
+		}
+
+		overlaps := end.After(from) || (end.Equal(s) && !s.Before(from))
+		if !overlaps || !s.Before(to) {
+			continue
+		}
+
+		o := e
+		o.Start = s
+		o.End = end
+		o.RRule = ""
+		o.ExDates = nil
+		ret = append(ret, o)
+	}
+
+	return ret
+}
+
+func excluded(exDates []time.Time, t time.Time) bool {
+	for _, ex := range exDates {
+		if ex.Equal(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// Expand returns the occurrences of events that overlap from to to, sorted by
+// start time
+func Expand(events []Event, from, to time.Time) []Event {
+	var ret []Event
+	for _, e := range events {
+		ret = append(ret, e.Occurrences(from, to)...)
+	}
+
+	sort.SliceStable(ret, func(i, j int) bool {
+		return ret[i].Start.Before(ret[j].Start)
+	})
+
+	return ret
+}
@@ -0,0 +1,123 @@
+package ical
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOccurrences(t *testing.T) {
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal("Error loading location: ", err)
+	}
+
+	// Friday 9:00
+	start := time.Date(2024, time.March, 1, 9, 0, 0, 0, ny)
+	meeting := Event{
+		UID:   "standup",
+		Start: start,
+		End:   start.Add(30 * time.Minute),
+	}
+
+	from := time.Date(2024, time.March, 1, 0, 0, 0, 0, ny)
+	to := time.Date(2024, time.March, 16, 0, 0, 0, 0, ny)
+
+	format := func(events []Event) string {
+		var ret []string
+		for _, e := range events {
+			ret = append(ret, e.Start.In(ny).Format("01-02 15:04"))
+		}
+		return strings.Join(ret, " ")
+	}
+
+	tests := []struct {
+		rrule   string
+		exDates []time.Time
+		exp     string
+	}{
+		{"", nil, "03-01 09:00"},
+		// 9:00 local time is kept over the DST change on March 10
+		{"FREQ=DAILY;COUNT=3", nil, "03-01 09:00 03-02 09:00 03-03 09:00"},
+		{"FREQ=WEEKLY;BYDAY=MO,FR", nil,
+			"03-01 09:00 03-04 09:00 03-08 09:00 03-11 09:00 03-15 09:00"},
+		{"FREQ=WEEKLY;INTERVAL=2", nil, "03-01 09:00 03-15 09:00"},
+		{"FREQ=DAILY;BYDAY=MO,TU,WE,TH,FR;UNTIL=20240306T000000Z", nil,
+			"03-01 09:00 03-04 09:00 03-05 09:00"},
+		{"FREQ=WEEKLY", []time.Time{start.AddDate(0, 0, 7)}, "03-01 09:00 03-15 09:00"},
+		// unsupported rules only use the first occurrence
+		{"FREQ=MONTHLY;BYSETPOS=1", nil, "03-01 09:00"},
+	}
+
+	for _, test := range tests {
+		e := meeting
+		e.RRule = test.rrule
+		e.ExDates = test.exDates
+		got := format(e.Occurrences(from, to))
+		if got != test.exp {
+			t.Errorf("%v: expected %v, got %v", test.rrule, test.exp, got)
+		}
+	}
+
+	// occurrences that end before from are not returned
+	e := meeting
+	e.RRule = "FREQ=DAILY"
+	got := format(e.Occurrences(start.AddDate(0, 0, 2).Add(15*time.Minute),
+		start.AddDate(0, 0, 3).Add(time.Minute)))
+	if got != "03-03 09:00 03-04 09:00" {
+		t.Error("wrong occurrences in window: ", got)
+	}
+}
+
+func TestOccurrencesYearly(t *testing.T) {
+	holiday := Event{
+		UID:    "leap",
+		Start:  time.Date(2024, time.February, 29, 0, 0, 0, 0, time.UTC),
+		End:    time.Date(2024, time.March, 1, 0, 0, 0, 0, time.UTC),
+		AllDay: true,
+		RRule:  "FREQ=YEARLY",
+	}
+
+	events := holiday.Occurrences(time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC),
+		time.Date(2033, time.January, 1, 0, 0, 0, 0, time.UTC))
+
+	var dates []string
+	for _, e := range events {
+		dates = append(dates, e.Dates(time.UTC)...)
+	}
+
+	exp := "2024-02-29 2028-02-29 2032-02-29"
+	if strings.Join(dates, " ") != exp {
+		t.Errorf("expected %v, got %v", exp, dates)
+	}
+}
+
+func TestParseRecurrence(t *testing.T) {
+	cal := `BEGIN:VCALENDAR
+BEGIN:VEVENT
+UID:room
+DTSTART;TZID=America/New_York:20240304T130000
+DTEND;TZID=America/New_York:20240304T140000
+RRULE:FREQ=WEEKLY;COUNT=3
+EXDATE;TZID=America/New_York:20240311T130000
+SUMMARY:Booked
+END:VEVENT
+END:VCALENDAR
+`
+	events, err := Parse(strings.NewReader(cal), time.UTC)
+	if err != nil {
+		t.Fatal("Error parsing: ", err)
+	}
+
+	if len(events) != 1 || events[0].RRule != "FREQ=WEEKLY;COUNT=3" ||
+		len(events[0].ExDates) != 1 {
+		t.Fatal("recurrence not parsed: ", events)
+	}
+
+	occ := Expand(events, time.Date(2024, time.March, 1, 0, 0, 0, 0, time.UTC),
+		time.Date(2024, time.April, 1, 0, 0, 0, 0, time.UTC))
+
+	if len(occ) != 2 || occ[1].Start.UTC() != time.Date(2024, time.March, 18, 17, 0, 0, 0, time.UTC) {
+		t.Error("wrong occurrences: ", occ)
+	}
+}
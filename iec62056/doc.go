@@ -0,0 +1,4 @@
+// Package iec62056 contains code to read utility meters using the IEC
+// 62056-21 (formerly IEC 61107) protocol, which is used by the optical
+// port of most electricity, gas, and heat meters.
+package iec62056
@@ -0,0 +1,268 @@
+package iec62056
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// control characters
+const (
+	SOH = 0x01
+	STX = 0x02
+	ETX = 0x03
+	ACK = 0x06
+	NAK = 0x15
+)
+
+// ErrBCC is returned if the block check character of a readout is wrong
+var ErrBCC = errors.New("iec62056: bad block check character")
+
+// Identification is the identification message sent by the meter in
+// response to a request message, for example /ISK5MT174-0001
+type Identification struct {
+	// Manufacturer is the 3 letter manufacturer ID
+	Manufacturer string
+	// BaudChar selects the baud rate for the readout in mode C
+	BaudChar byte
+	ID       string
+}
+
+// DataSet is one value in a meter readout, for example 1.8.0(001234.5*kWh)
+type DataSet struct {
+	// Address is typically an OBIS code like 1.8.0 or 1-0:1.8.0*255
+	Address string
+	Value   string
+	Unit    string
+}
+
+// Float returns the value of a data set as a number
+func (ds DataSet) Float() (float64, error) {
+	return strconv.ParseFloat(ds.Value, 64)
+}
+
+// OBIS returns the C.D.E part of the data set address (for example 1.8.0),
+// which identifies the value independent of the medium and channel.
+func (ds DataSet) OBIS() string {
+	a := ds.Address
+	if i := strings.Index(a, ":"); i >= 0 {
+		a = a[i+1:]
+	}
+	if i := strings.IndexAny(a, "*&"); i >= 0 {
+		a = a[:i]
+	}
+	return a
+}
+
+// RequestMessage returns the request message sent to start communication.
+// address is the device address, and can be blank if only one meter is
+// connected.
+func RequestMessage(address string) []byte {
+	return []byte("/?" + address + "!\r\n")
+}
+
+// AckMessage returns the acknowledgement/option select message that
+// requests a data readout (mode C) at the baud rate proposed by the meter.
+func AckMessage(baudChar byte) []byte {
+	return []byte{ACK, '0', baudChar, '0', '\r', '\n'}
+}
+
+// BaudRate returns the mode C baud rate for a baud rate character
+func BaudRate(baudChar byte) (int, error) {
+	switch baudChar {
+	case '0':
+		return 300, nil
+	case '1':
+		return 600, nil
+	case '2':
+		return 1200, nil
+	case '3':
+		return 2400, nil
+	case '4':
+		return 4800, nil
+	case '5':
+		return 9600, nil
+	case '6':
+		return 19200, nil
+	default:
+		return 0, fmt.Errorf("iec62056: unsupported baud rate character: %q",
+			baudChar)
+	}
+}
+
+// ParseIdentification parses an identification message
+func ParseIdentification(msg string) (Identification, error) {
+	msg = strings.TrimRight(msg, "\r\n")
+	if len(msg) < 5 || msg[0] != '/' {
+		return Identification{}, fmt.Errorf("iec62056: invalid identification: %q",
+			msg)
+	}
+
+	ret := Identification{
+		Manufacturer: msg[1:4],
+		BaudChar:     msg[4],
+		ID:           msg[5:],
+	}
+
+	// optional \W enhanced capability characters
+	if len(ret.ID) >= 2 && ret.ID[0] == '\\' {
+		ret.ID = ret.ID[2:]
+	}
+
+	return ret, nil
+}
+
+// BCC calculates the block check character of a readout, which is the XOR
+// of all characters after and excluding STX up to and including ETX.
+func BCC(data []byte) byte {
+	var ret byte
+	for _, b := range data {
+		ret ^= b
+	}
+	return ret
+}
+
+// ParseDataBlock parses the data lines of a readout. Each line contains
+// one or more data sets.
+func ParseDataBlock(block string) ([]DataSet, error) {
+	var ret []DataSet
+
+	for _, line := range strings.Split(block, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || line == "!" {
+			continue
+		}
+
+		i := strings.Index(line, "(")
+		if i < 0 {
+			return nil, fmt.Errorf("iec62056: invalid data line: %q", line)
+		}
+
+		address := line[:i]
+		rest := line[i:]
+
+		// a line can have several values, for example for maximum demand
+		// with a time stamp: 1.6.0(02.28*kW)(2207251415)
+		for len(rest) > 0 && rest[0] == '(' {
+			j := strings.Index(rest, ")")
+			if j < 0 {
+				return nil, fmt.Errorf("iec62056: unterminated value: %q", line)
+			}
+
+			ds := DataSet{Address: address}
+			v := rest[1:j]
+			if k := strings.Index(v, "*"); k >= 0 {
+				ds.Value, ds.Unit = v[:k], v[k+1:]
+			} else {
+				ds.Value = v
+			}
+
+			ret = append(ret, ds)
+			rest = rest[j+1:]
+		}
+	}
+
+	return ret, nil
+}
+
+// readUntil reads from r until done returns true or the timeout expires
+func readUntil(r io.Reader, timeout time.Duration, done func([]byte) bool) ([]byte, error) {
+	var ret []byte
+	buf := make([]byte, 256)
+	start := time.Now()
+
+	for {
+		n, err := r.Read(buf)
+		ret = append(ret, buf[:n]...)
+
+		if done(ret) {
+			return ret, nil
+		}
+
+		if err != nil && err != io.EOF {
+			return ret, err
+		}
+
+		if time.Since(start) > timeout {
+			return ret, io.EOF
+		}
+
+		if n == 0 {
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+}
+
+// Read reads a meter in mode C. setBaud is called to change the baud rate
+// after the meter sends its identification and can be nil if the port does
+// not need baud changes (for example TCP).
+func Read(port io.ReadWriter, address string, timeout time.Duration,
+	setBaud func(int) error) (Identification, []DataSet, error) {
+
+	_, err := port.Write(RequestMessage(address))
+	if err != nil {
+		return Identification{}, nil, err
+	}
+
+	idMsg, err := readUntil(port, timeout, func(b []byte) bool {
+		return bytes.HasSuffix(b, []byte("\r\n"))
+	})
+	if err != nil {
+		return Identification{}, nil, fmt.Errorf("iec62056: identification: %w", err)
+	}
+
+	// some optical heads echo the request
+	if i := bytes.LastIndexByte(idMsg[:len(idMsg)-2], '/'); i > 0 {
+		idMsg = idMsg[i:]
+	}
+
+	id, err := ParseIdentification(string(idMsg))
+	if err != nil {
+		return id, nil, err
+	}
+
+	_, err = port.Write(AckMessage(id.BaudChar))
+	if err != nil {
+		return id, nil, err
+	}
+
+	if setBaud != nil {
+		baud, err := BaudRate(id.BaudChar)
+		if err != nil {
+			return id, nil, err
+		}
+
+		// the meter changes baud rate 200ms-1.5s after the ack
+		time.Sleep(300 * time.Millisecond)
+
+		err = setBaud(baud)
+		if err != nil {
+			return id, nil, err
+		}
+	}
+
+	// readout is STX <data> ! CR LF ETX BCC
+	readout, err := readUntil(port, timeout, func(b []byte) bool {
+		return len(b) >= 2 && b[len(b)-2] == ETX
+	})
+	if err != nil {
+		return id, nil, fmt.Errorf("iec62056: readout: %w", err)
+	}
+
+	start := bytes.IndexByte(readout, STX)
+	if start < 0 {
+		return id, nil, errors.New("iec62056: readout does not contain STX")
+	}
+
+	block := readout[start+1 : len(readout)-1]
+	if BCC(block) != readout[len(readout)-1] {
+		return id, nil, ErrBCC
+	}
+
+	ds, err := ParseDataBlock(string(block[:len(block)-1]))
+	return id, ds, err
+}
@@ -0,0 +1,99 @@
+package iec62056
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestParseIdentification(t *testing.T) {
+	id, err := ParseIdentification("/ISk5\\2MT174-0001\r\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if id.Manufacturer != "ISk" || id.BaudChar != '5' || id.ID != "MT174-0001" {
+		t.Errorf("wrong identification: %+v", id)
+	}
+
+	if _, err := ParseIdentification("ISK"); err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestParseDataBlock(t *testing.T) {
+	ds, err := ParseDataBlock(`0.0.0(12345678)
+1-0:1.8.0*255(001234.567*kWh)
+1.6.0(02.28*kW)(2207251415)
+32.7.0(230.1*V)
+!
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(ds) != 5 {
+		t.Fatalf("expected 5 data sets, got %v: %+v", len(ds), ds)
+	}
+
+	if ds[1].OBIS() != "1.8.0" || ds[1].Value != "001234.567" || ds[1].Unit != "kWh" {
+		t.Errorf("wrong energy data set: %+v", ds[1])
+	}
+
+	v, _ := ds[1].Float()
+	if v != 1234.567 {
+		t.Error("wrong energy value: ", v)
+	}
+
+	if ds[3].Address != "1.6.0" || ds[3].Value != "2207251415" {
+		t.Errorf("wrong demand time data set: %+v", ds[3])
+	}
+}
+
+// meterSim responds to a request message with an identification, and to an
+// ack with a readout
+type meterSim struct {
+	out bytes.Buffer
+}
+
+func (m *meterSim) Write(b []byte) (int, error) {
+	switch {
+	case bytes.HasPrefix(b, []byte("/?")):
+		m.out.WriteString("/ELS5MeterSim\r\n")
+	case b[0] == ACK:
+		block := []byte("1.8.0(000012.5*kWh)\r\n!\r\n\x03")
+		m.out.WriteByte(STX)
+		m.out.Write(block)
+		m.out.WriteByte(BCC(block))
+	}
+	return len(b), nil
+}
+
+func (m *meterSim) Read(b []byte) (int, error) {
+	n, _ := m.out.Read(b)
+	if n == 0 {
+		return 0, io.EOF
+	}
+	return n, nil
+}
+
+func TestRead(t *testing.T) {
+	var baud int
+	id, ds, err := Read(&meterSim{}, "", time.Second, func(b int) error {
+		baud = b
+		return nil
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if id.Manufacturer != "ELS" || baud != 9600 {
+		t.Errorf("wrong id %+v or baud %v", id, baud)
+	}
+
+	if len(ds) != 1 || ds[0].Value != "000012.5" {
+		t.Errorf("wrong readout: %+v", ds)
+	}
+}
@@ -0,0 +1,60 @@
+package knx
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// GroupAddress is a KNX group address
+type GroupAddress uint16
+
+// ParseGroupAddress parses a 3 level (main/middle/sub), 2 level
+// (main/sub), or free (number) group address
+func ParseGroupAddress(s string) (GroupAddress, error) {
+	parts := strings.Split(strings.TrimSpace(s), "/")
+
+	nums := make([]int, len(parts))
+	for i, p := range parts {
+		var err error
+		nums[i], err = strconv.Atoi(p)
+		if err != nil || nums[i] < 0 {
+			return 0, fmt.Errorf("knx: invalid group address: %q", s)
+		}
+	}
+
+	switch len(nums) {
+	case 3:
+		if nums[0] > 31 || nums[1] > 7 || nums[2] > 255 {
+			return 0, fmt.Errorf("knx: group address out of range: %q", s)
+		}
+		return GroupAddress(nums[0]<<11 | nums[1]<<8 | nums[2]), nil
+	case 2:
+		if nums[0] > 31 || nums[1] > 2047 {
+			return 0, fmt.Errorf("knx: group address out of range: %q", s)
+		}
+		return GroupAddress(nums[0]<<11 | nums[1]), nil
+	case 1:
+		if nums[0] > 0xffff {
+			return 0, fmt.Errorf("knx: group address out of range: %q", s)
+		}
+		return GroupAddress(nums[0]), nil
+	default:
+		return 0, fmt.Errorf("knx: invalid group address: %q", s)
+	}
+}
+
+// String returns the 3 level form of the address
+func (ga GroupAddress) String() string {
+	v := uint16(ga)
+	return fmt.Sprintf("%v/%v/%v", v>>11, (v>>8)&0x7, v&0xff)
+}
+
+// IndividualAddress is the address of a KNX device, for example 1.1.10
+type IndividualAddress uint16
+
+// String returns the area.line.device form of the address
+func (ia IndividualAddress) String() string {
+	v := uint16(ia)
+	return fmt.Sprintf("%v.%v.%v", v>>12, (v>>8)&0xf, v&0xff)
+}
@@ -0,0 +1,3 @@
+// Package knx contains code to communicate with KNX building automation
+// installations through a KNXnet/IP tunneling gateway.
+package knx
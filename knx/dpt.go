@@ -0,0 +1,199 @@
+package knx
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// DPT data types are identified by main number, for example 9 for 9.001
+// (temperature). Only the main number is needed to encode and decode
+// values, except for 5.001 and 5.003 which are scaled.
+
+// parseDPT returns the main and sub number of a DPT, for example 5 and 001
+// for 5.001 or DPT-5.001
+func parseDPT(dpt string) (string, string) {
+	dpt = strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(dpt)), "DPT")
+	dpt = strings.TrimLeft(dpt, "-")
+	if i := strings.IndexAny(dpt, ".-"); i >= 0 {
+		return dpt[:i], dpt[i+1:]
+	}
+	return dpt, ""
+}
+
+// DecodeDPT decodes the data of a group value write or response. Data is
+// the APDU data after the APCI, where values of 6 bits or less are in the
+// low bits of the first byte.
+func DecodeDPT(dpt string, d []byte) (float64, error) {
+	need := func(n int) error {
+		if len(d) < n {
+			return fmt.Errorf("knx: DPT %v needs %v bytes, got %v", dpt, n, len(d))
+		}
+		return nil
+	}
+
+	if err := need(1); err != nil {
+		return 0, err
+	}
+
+	main, sub := parseDPT(dpt)
+
+	switch main {
+	case "1":
+		return float64(d[0] & 0x1), nil
+	case "2":
+		return float64(d[0] & 0x3), nil
+	case "3":
+		return float64(d[0] & 0xf), nil
+	case "5":
+		if err := need(2); err != nil {
+			return 0, err
+		}
+		switch sub {
+		case "001":
+			return float64(d[1]) * 100 / 255, nil
+		case "003":
+			return float64(d[1]) * 360 / 255, nil
+		}
+		return float64(d[1]), nil
+	case "6":
+		if err := need(2); err != nil {
+			return 0, err
+		}
+		return float64(int8(d[1])), nil
+	case "7":
+		if err := need(3); err != nil {
+			return 0, err
+		}
+		return float64(binary.BigEndian.Uint16(d[1:])), nil
+	case "8":
+		if err := need(3); err != nil {
+			return 0, err
+		}
+		return float64(int16(binary.BigEndian.Uint16(d[1:]))), nil
+	case "9":
+		if err := need(3); err != nil {
+			return 0, err
+		}
+		return decodeFloat16(binary.BigEndian.Uint16(d[1:])), nil
+	case "12":
+		if err := need(5); err != nil {
+			return 0, err
+		}
+		return float64(binary.BigEndian.Uint32(d[1:])), nil
+	case "13":
+		if err := need(5); err != nil {
+			return 0, err
+		}
+		return float64(int32(binary.BigEndian.Uint32(d[1:]))), nil
+	case "14":
+		if err := need(5); err != nil {
+			return 0, err
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(d[1:]))), nil
+	case "17", "20":
+		if err := need(2); err != nil {
+			return 0, err
+		}
+		return float64(d[1]), nil
+	default:
+		return 0, fmt.Errorf("knx: unsupported DPT: %v", dpt)
+	}
+}
+
+// EncodeDPT encodes a value for a group value write. The first byte is
+// combined with the APCI, so it is 0 for types longer than 6 bits.
+func EncodeDPT(dpt string, v float64) ([]byte, error) {
+	main, sub := parseDPT(dpt)
+
+	switch main {
+	case "1":
+		if v != 0 {
+			return []byte{1}, nil
+		}
+		return []byte{0}, nil
+	case "2":
+		return []byte{byte(v) & 0x3}, nil
+	case "3":
+		return []byte{byte(v) & 0xf}, nil
+	case "5":
+		switch sub {
+		case "001":
+			v = v * 255 / 100
+		case "003":
+			v = v * 255 / 360
+		}
+		return []byte{0, byte(clamp(math.Round(v), 0, 255))}, nil
+	case "6":
+		return []byte{0, byte(int8(clamp(math.Round(v), -128, 127)))}, nil
+	case "7":
+		ret := make([]byte, 3)
+		binary.BigEndian.PutUint16(ret[1:], uint16(clamp(math.Round(v), 0, 65535)))
+		return ret, nil
+	case "8":
+		ret := make([]byte, 3)
+		binary.BigEndian.PutUint16(ret[1:],
+			uint16(int16(clamp(math.Round(v), -32768, 32767))))
+		return ret, nil
+	case "9":
+		ret := make([]byte, 3)
+		binary.BigEndian.PutUint16(ret[1:], encodeFloat16(v))
+		return ret, nil
+	case "12":
+		ret := make([]byte, 5)
+		binary.BigEndian.PutUint32(ret[1:], uint32(clamp(math.Round(v), 0, math.MaxUint32)))
+		return ret, nil
+	case "13":
+		ret := make([]byte, 5)
+		binary.BigEndian.PutUint32(ret[1:],
+			uint32(int32(clamp(math.Round(v), math.MinInt32, math.MaxInt32))))
+		return ret, nil
+	case "14":
+		ret := make([]byte, 5)
+		binary.BigEndian.PutUint32(ret[1:], math.Float32bits(float32(v)))
+		return ret, nil
+	case "17", "20":
+		return []byte{0, byte(clamp(math.Round(v), 0, 255))}, nil
+	default:
+		return nil, fmt.Errorf("knx: unsupported DPT: %v", dpt)
+	}
+}
+
+func clamp(v, lo, hi float64) float64 {
+	if v < lo {
+		return lo
+	}
+	if v > hi {
+		return hi
+	}
+	return v
+}
+
+// decodeFloat16 decodes a KNX 2 byte float (DPT 9): MEEEEMMM MMMMMMMM,
+// value = 0.01 * M * 2^E where M is a 12 bit two's complement mantissa
+func decodeFloat16(v uint16) float64 {
+	e := int(v>>11) & 0xf
+	m := int(v & 0x7ff)
+	if v&0x8000 != 0 {
+		m -= 2048
+	}
+	return 0.01 * float64(m) * math.Pow(2, float64(e))
+}
+
+func encodeFloat16(v float64) uint16 {
+	m := math.Round(v * 100)
+	e := 0
+	for (m < -2048 || m > 2047) && e < 15 {
+		m = math.Round(m / 2)
+		e++
+	}
+	m = clamp(m, -2048, 2047)
+
+	mi := int(m)
+	ret := uint16(e<<11) | uint16(mi&0x7ff)
+	if mi < 0 {
+		ret |= 0x8000
+	}
+	return ret
+}
@@ -0,0 +1,219 @@
+package knx
+
+import (
+	"bytes"
+	"math"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestParseGroupAddress(t *testing.T) {
+	tests := []struct {
+		in  string
+		exp GroupAddress
+		str string
+	}{
+		{"1/2/3", 0x0a03, "1/2/3"},
+		{"31/7/255", 0xffff, "31/7/255"},
+		{"1/515", 0x0a03, "1/2/3"},
+		{"2563", 0x0a03, "1/2/3"},
+	}
+
+	for _, test := range tests {
+		ga, err := ParseGroupAddress(test.in)
+		if err != nil {
+			t.Fatalf("%v: %v", test.in, err)
+		}
+		if ga != test.exp {
+			t.Errorf("%v: got %v, exp %v", test.in, ga, test.exp)
+		}
+		if ga.String() != test.str {
+			t.Errorf("%v: got string %v", test.in, ga.String())
+		}
+	}
+
+	for _, in := range []string{"", "32/0/0", "1/8/0", "1/2/256", "a/b/c", "1/2/3/4"} {
+		if _, err := ParseGroupAddress(in); err == nil {
+			t.Errorf("%v: expected error", in)
+		}
+	}
+}
+
+func TestDPT(t *testing.T) {
+	tests := []struct {
+		dpt  string
+		v    float64
+		data []byte
+	}{
+		{"1.001", 1, []byte{1}},
+		{"3.007", 11, []byte{11}},
+		{"5.001", 100, []byte{0, 0xff}},
+		{"5.010", 42, []byte{0, 42}},
+		{"6.010", -5, []byte{0, 0xfb}},
+		{"7.001", 1000, []byte{0, 0x03, 0xe8}},
+		{"8.001", -1000, []byte{0, 0xfc, 0x18}},
+		{"9.001", 21.5, []byte{0, 0x0c, 0x33}},
+		{"DPT-9.001", -10, []byte{0, 0x84, 0x18}},
+		{"12.001", 70000, []byte{0, 0, 0x01, 0x11, 0x70}},
+		{"13.010", -2, []byte{0, 0xff, 0xff, 0xff, 0xfe}},
+		{"14.056", 1.5, []byte{0, 0x3f, 0xc0, 0, 0}},
+		{"17.001", 12, []byte{0, 12}},
+	}
+
+	for _, test := range tests {
+		d, err := EncodeDPT(test.dpt, test.v)
+		if err != nil {
+			t.Fatalf("%v: %v", test.dpt, err)
+		}
+		if !bytes.Equal(d, test.data) {
+			t.Errorf("%v: encode got %x, exp %x", test.dpt, d, test.data)
+		}
+
+		v, err := DecodeDPT(test.dpt, test.data)
+		if err != nil {
+			t.Fatalf("%v: %v", test.dpt, err)
+		}
+		if math.Abs(v-test.v) > 0.01 {
+			t.Errorf("%v: decode got %v, exp %v", test.dpt, v, test.v)
+		}
+	}
+
+	if _, err := DecodeDPT("9.001", []byte{0, 1}); err == nil {
+		t.Error("expected error for short data")
+	}
+
+	if _, err := EncodeDPT("16.000", 1); err == nil {
+		t.Error("expected error for unsupported DPT")
+	}
+}
+
+func TestCEMI(t *testing.T) {
+	ev := GroupEvent{APCI: APCIGroupValueWrite, Destination: 0x0a03,
+		Data: []byte{0, 0x0c, 0x33}}
+
+	f := encodeCEMI(ev)
+	exp := []byte{cemiLDataReq, 0, 0xbc, 0xe0, 0, 0, 0x0a, 0x03, 3, 0, 0x80, 0x0c, 0x33}
+	if !bytes.Equal(f, exp) {
+		t.Fatalf("got %x, exp %x", f, exp)
+	}
+
+	// received frame with source 1.1.10 and 1 bit value
+	ind := []byte{cemiLDataInd, 0, 0xbc, 0xe0, 0x11, 0x0a, 0x0a, 0x03, 1, 0, 0x81}
+	code, got, err := decodeCEMI(ind)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if code != cemiLDataInd || got.APCI != APCIGroupValueWrite ||
+		got.Source.String() != "1.1.10" || got.Destination.String() != "1/2/3" ||
+		!bytes.Equal(got.Data, []byte{1}) {
+		t.Errorf("decode error: %+v", got)
+	}
+
+	_, got, err = decodeCEMI(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got.APCI != ev.APCI || !bytes.Equal(got.Data, ev.Data) {
+		t.Errorf("round trip error: %+v", got)
+	}
+}
+
+// fakeGateway accepts one tunnel connection, acks requests, and sends an
+// indication for each write it receives
+func fakeGateway(t *testing.T) (string, chan GroupEvent) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { conn.Close() })
+
+	received := make(chan GroupEvent, 10)
+
+	go func() {
+		buf := make([]byte, 512)
+		var seq byte
+		for {
+			n, addr, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+
+			svc, body, err := decodeHeader(buf[:n])
+			if err != nil {
+				continue
+			}
+
+			switch svc {
+			case svcConnectRequest:
+				_, _ = conn.WriteToUDP(encodeHeader(svcConnectResponse,
+					append([]byte{7, 0}, hpai...)), addr)
+			case svcConnStateRequest:
+				_, _ = conn.WriteToUDP(encodeHeader(svcConnStateResponse,
+					[]byte{7, 0}), addr)
+			case svcTunnelRequest:
+				_, _ = conn.WriteToUDP(encodeHeader(svcTunnelAck,
+					[]byte{4, 7, body[2], 0}), addr)
+				_, ev, err := decodeCEMI(body[4:])
+				if err != nil {
+					continue
+				}
+				received <- ev
+
+				// echo writes back as indications
+				ind := encodeCEMI(ev)
+				ind[0] = cemiLDataInd
+				_, _ = conn.WriteToUDP(encodeHeader(svcTunnelRequest,
+					append([]byte{4, 7, seq, 0}, ind...)), addr)
+				seq++
+			}
+		}
+	}()
+
+	return conn.LocalAddr().String(), received
+}
+
+func TestTunnel(t *testing.T) {
+	addr, received := fakeGateway(t)
+
+	tun, err := Dial(addr, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tun.Close()
+
+	if err := tun.Heartbeat(); err != nil {
+		t.Fatal("heartbeat: ", err)
+	}
+
+	ga, _ := ParseGroupAddress("1/2/3")
+	d, _ := EncodeDPT("9.001", 21.5)
+
+	for i := 0; i < 2; i++ {
+		if err := tun.Write(ga, d); err != nil {
+			t.Fatal("write: ", err)
+		}
+
+		select {
+		case ev := <-received:
+			if ev.Destination != ga || !bytes.Equal(ev.Data, d) {
+				t.Errorf("gateway received wrong event: %+v", ev)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("gateway did not receive write")
+		}
+
+		select {
+		case ev := <-tun.Events():
+			v, err := DecodeDPT("9.001", ev.Data)
+			if err != nil || v != 21.5 || ev.Destination != ga {
+				t.Errorf("wrong event: %+v", ev)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("did not receive indication")
+		}
+	}
+}
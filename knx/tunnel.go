@@ -0,0 +1,383 @@
+package knx
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// KNXnet/IP service types
+const (
+	svcConnectRequest     = 0x0205
+	svcConnectResponse    = 0x0206
+	svcConnStateRequest   = 0x0207
+	svcConnStateResponse  = 0x0208
+	svcDisconnectRequest  = 0x0209
+	svcDisconnectResponse = 0x020a
+	svcTunnelRequest      = 0x0420
+	svcTunnelAck          = 0x0421
+)
+
+// cEMI message codes
+const (
+	cemiLDataReq = 0x11
+	cemiLDataCon = 0x2e
+	cemiLDataInd = 0x29
+)
+
+// APCI commands
+const (
+	APCIGroupValueRead     = 0x000
+	APCIGroupValueResponse = 0x040
+	APCIGroupValueWrite    = 0x080
+)
+
+// ErrTimeout is returned if the gateway does not respond
+var ErrTimeout = errors.New("knx: gateway did not respond")
+
+// GroupEvent is a group telegram sent or received on the bus
+type GroupEvent struct {
+	APCI        uint16
+	Source      IndividualAddress
+	Destination GroupAddress
+	// Data is the APDU data, see EncodeDPT
+	Data []byte
+}
+
+func encodeHeader(svc uint16, body []byte) []byte {
+	ret := make([]byte, 6, 6+len(body))
+	ret[0] = 0x06
+	ret[1] = 0x10
+	binary.BigEndian.PutUint16(ret[2:], svc)
+	binary.BigEndian.PutUint16(ret[4:], uint16(6+len(body)))
+	return append(ret, body...)
+}
+
+func decodeHeader(b []byte) (uint16, []byte, error) {
+	if len(b) < 6 || b[0] != 0x06 || b[1] != 0x10 {
+		return 0, nil, errors.New("knx: invalid KNXnet/IP header")
+	}
+	l := int(binary.BigEndian.Uint16(b[4:]))
+	if l > len(b) || l < 6 {
+		return 0, nil, errors.New("knx: invalid KNXnet/IP length")
+	}
+	return binary.BigEndian.Uint16(b[2:]), b[6:l], nil
+}
+
+// hpai is a NAT mode host protocol address, which tells the gateway to
+// reply to the address packets are received from
+var hpai = []byte{0x08, 0x01, 0, 0, 0, 0, 0, 0}
+
+// encodeCEMI encodes an L_Data.req frame for a group event
+func encodeCEMI(ev GroupEvent) []byte {
+	d := ev.Data
+	if len(d) == 0 {
+		d = []byte{0}
+	}
+
+	ret := []byte{cemiLDataReq, 0, 0xbc, 0xe0, 0, 0, 0, 0, byte(len(d)),
+		byte(ev.APCI>>8) & 0x3, byte(ev.APCI&0xc0) | d[0]&0x3f}
+	binary.BigEndian.PutUint16(ret[6:], uint16(ev.Destination))
+	return append(ret, d[1:]...)
+}
+
+// decodeCEMI decodes a cEMI L_Data frame with a group destination
+func decodeCEMI(b []byte) (byte, GroupEvent, error) {
+	var ev GroupEvent
+
+	if len(b) < 2 || len(b) < 2+int(b[1]) {
+		return 0, ev, errors.New("knx: cEMI frame too short")
+	}
+
+	code := b[0]
+	b = b[2+int(b[1]):]
+
+	if len(b) < 9 {
+		return code, ev, errors.New("knx: cEMI frame too short")
+	}
+
+	if b[1]&0x80 == 0 {
+		return code, ev, errors.New("knx: not a group telegram")
+	}
+
+	ev.Source = IndividualAddress(binary.BigEndian.Uint16(b[2:]))
+	ev.Destination = GroupAddress(binary.BigEndian.Uint16(b[4:]))
+
+	l := int(b[6])
+	apdu := b[7:]
+	if len(apdu) < l+1 || l < 1 {
+		return code, ev, errors.New("knx: cEMI APDU truncated")
+	}
+
+	ev.APCI = uint16(apdu[0]&0x3)<<8 | uint16(apdu[1]&0xc0)
+	ev.Data = make([]byte, l)
+	copy(ev.Data, apdu[1:l+1])
+	ev.Data[0] &= 0x3f
+
+	return code, ev, nil
+}
+
+// Tunnel is a KNXnet/IP tunneling connection to a gateway
+type Tunnel struct {
+	conn    *net.UDPConn
+	timeout time.Duration
+	channel byte
+
+	// lock protects seqSend and serializes requests, which must be acked
+	// before the next is sent
+	lock    sync.Mutex
+	seqSend byte
+	seqRecv byte
+
+	chAck   chan byte
+	chState chan byte
+	events  chan GroupEvent
+	done    chan struct{}
+	err     error
+}
+
+// Dial connects to a KNXnet/IP tunneling gateway. gateway is host:port,
+// where the port is typically 3671.
+func Dial(gateway string, timeout time.Duration) (*Tunnel, error) {
+	addr, err := net.ResolveUDPAddr("udp", gateway)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := net.DialUDP("udp", nil, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &Tunnel{
+		conn:    conn,
+		timeout: timeout,
+		chAck:   make(chan byte, 1),
+		chState: make(chan byte, 1),
+		events:  make(chan GroupEvent, 32),
+		done:    make(chan struct{}),
+	}
+
+	// tunnel connection, link layer
+	body := append(append(append([]byte{}, hpai...), hpai...), 0x04, 0x04, 0x02, 0x00)
+	_, err = conn.Write(encodeHeader(svcConnectRequest, body))
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	buf := make([]byte, 512)
+	err = conn.SetReadDeadline(time.Now().Add(timeout))
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	n, err := conn.Read(buf)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("knx: connect: %w", err)
+	}
+
+	svc, resp, err := decodeHeader(buf[:n])
+	if err != nil || svc != svcConnectResponse || len(resp) < 2 {
+		conn.Close()
+		return nil, errors.New("knx: invalid connect response")
+	}
+
+	if resp[1] != 0 {
+		conn.Close()
+		return nil, fmt.Errorf("knx: connect rejected, status: %x", resp[1])
+	}
+
+	t.channel = resp[0]
+
+	err = conn.SetReadDeadline(time.Time{})
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	go t.receive()
+
+	return t, nil
+}
+
+// receive handles packets from the gateway until the connection is closed
+func (t *Tunnel) receive() {
+	defer close(t.done)
+	defer close(t.events)
+
+	buf := make([]byte, 512)
+
+	for {
+		n, err := t.conn.Read(buf)
+		if err != nil {
+			t.err = err
+			return
+		}
+
+		svc, body, err := decodeHeader(buf[:n])
+		if err != nil {
+			continue
+		}
+
+		switch svc {
+		case svcTunnelRequest:
+			if len(body) < 4 || body[1] != t.channel {
+				continue
+			}
+
+			seq := body[2]
+
+			// ack all requests, including repeats of requests already received
+			_, err := t.conn.Write(encodeHeader(svcTunnelAck,
+				[]byte{0x04, t.channel, seq, 0}))
+			if err != nil {
+				t.err = err
+				return
+			}
+
+			if seq != t.seqRecv {
+				// repeated request
+				continue
+			}
+			t.seqRecv++
+
+			code, ev, err := decodeCEMI(body[4:])
+			if err != nil || code != cemiLDataInd {
+				continue
+			}
+
+			select {
+			case t.events <- ev:
+			default:
+				// drop events if the reader is not keeping up
+			}
+
+		case svcTunnelAck:
+			if len(body) >= 4 && body[1] == t.channel {
+				select {
+				case t.chAck <- body[2]:
+				default:
+				}
+			}
+
+		case svcConnStateResponse:
+			if len(body) >= 2 && body[0] == t.channel {
+				select {
+				case t.chState <- body[1]:
+				default:
+				}
+			}
+
+		case svcDisconnectRequest:
+			_, _ = t.conn.Write(encodeHeader(svcDisconnectResponse,
+				[]byte{t.channel, 0}))
+			t.err = errors.New("knx: gateway closed connection")
+			return
+		}
+	}
+}
+
+// Events returns the group telegrams received from the bus. The channel is
+// closed when the connection is closed.
+func (t *Tunnel) Events() <-chan GroupEvent {
+	return t.events
+}
+
+// Done is closed when the connection to the gateway is lost or closed
+func (t *Tunnel) Done() <-chan struct{} {
+	return t.done
+}
+
+// Err returns why the connection was closed
+func (t *Tunnel) Err() error {
+	select {
+	case <-t.done:
+		return t.err
+	default:
+		return nil
+	}
+}
+
+// Send sends a group telegram and waits for the gateway to ack it. The
+// request is repeated once if it is not acked.
+func (t *Tunnel) Send(ev GroupEvent) error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	seq := t.seqSend
+	req := encodeHeader(svcTunnelRequest,
+		append([]byte{0x04, t.channel, seq, 0}, encodeCEMI(ev)...))
+
+	for try := 0; try < 2; try++ {
+		_, err := t.conn.Write(req)
+		if err != nil {
+			return err
+		}
+
+		timer := time.NewTimer(t.timeout)
+	wait:
+		for {
+			select {
+			case s := <-t.chAck:
+				if s != seq {
+					continue
+				}
+				timer.Stop()
+				t.seqSend++
+				return nil
+			case <-timer.C:
+				break wait
+			case <-t.done:
+				timer.Stop()
+				return t.err
+			}
+		}
+	}
+
+	return ErrTimeout
+}
+
+// Write sends a group value write
+func (t *Tunnel) Write(ga GroupAddress, data []byte) error {
+	return t.Send(GroupEvent{APCI: APCIGroupValueWrite, Destination: ga, Data: data})
+}
+
+// Read sends a group value read. The response is received as an event.
+func (t *Tunnel) Read(ga GroupAddress) error {
+	return t.Send(GroupEvent{APCI: APCIGroupValueRead, Destination: ga})
+}
+
+// Heartbeat checks the connection is still open. Gateways close
+// connections that do not send a heartbeat every 120 seconds.
+func (t *Tunnel) Heartbeat() error {
+	_, err := t.conn.Write(encodeHeader(svcConnStateRequest,
+		append([]byte{t.channel, 0}, hpai...)))
+	if err != nil {
+		return err
+	}
+
+	select {
+	case s := <-t.chState:
+		if s != 0 {
+			return fmt.Errorf("knx: connection state error: %x", s)
+		}
+		return nil
+	case <-time.After(t.timeout):
+		return ErrTimeout
+	case <-t.done:
+		return t.err
+	}
+}
+
+// Close disconnects from the gateway
+func (t *Tunnel) Close() error {
+	_, _ = t.conn.Write(encodeHeader(svcDisconnectRequest,
+		append([]byte{t.channel, 0}, hpai...)))
+	return t.conn.Close()
+}
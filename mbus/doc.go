@@ -0,0 +1,3 @@
+// Package mbus contains code to read heat, water, gas, and electricity
+// meters with the wired M-Bus (Meter-Bus, EN 13757) protocol.
+package mbus
@@ -0,0 +1,187 @@
+package mbus
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// frame characters
+const (
+	FrameACK   = 0xe5
+	FrameShort = 0x10
+	FrameLong  = 0x68
+	FrameStop  = 0x16
+)
+
+// control field values
+const (
+	// CSndNke initializes a meter
+	CSndNke = 0x40
+	// CReqUD2 requests user data (class 2) from a meter
+	CReqUD2 = 0x5b
+)
+
+// control information field values
+const (
+	// CIRspVariable is a variable data response with a fixed header
+	CIRspVariable = 0x72
+	// CIRspVariableShort is a variable data response without a header
+	CIRspVariableShort = 0x78
+)
+
+// AddressBroadcast is responded to by all meters. It should only be used
+// if one meter is connected.
+const AddressBroadcast = 0xfe
+
+// ErrChecksum is returned if a frame checksum is wrong
+var ErrChecksum = errors.New("mbus: bad checksum")
+
+func checksum(data []byte) byte {
+	var ret byte
+	for _, b := range data {
+		ret += b
+	}
+	return ret
+}
+
+// ShortFrame returns a short frame with control field c to address a
+func ShortFrame(c, a byte) []byte {
+	return []byte{FrameShort, c, a, c + a, FrameStop}
+}
+
+// DecodeLongFrame decodes a long frame and returns the control, address,
+// and control information fields, and the data
+func DecodeLongFrame(frame []byte) (c, a, ci byte, data []byte, err error) {
+	if len(frame) < 9 || frame[0] != FrameLong || frame[3] != FrameLong ||
+		frame[1] != frame[2] {
+		return 0, 0, 0, nil, errors.New("mbus: invalid long frame")
+	}
+
+	l := int(frame[1])
+	if len(frame) != l+6 || frame[len(frame)-1] != FrameStop {
+		return 0, 0, 0, nil, fmt.Errorf("mbus: long frame length %v does not match L field %v",
+			len(frame), l)
+	}
+
+	if checksum(frame[4:4+l]) != frame[4+l] {
+		return 0, 0, 0, nil, ErrChecksum
+	}
+
+	return frame[4], frame[5], frame[6], frame[7 : 4+l], nil
+}
+
+// Client is used to read meters on a bus
+type Client struct {
+	port    io.ReadWriter
+	timeout time.Duration
+}
+
+// NewClient creates a client. timeout is how long to wait for a meter to
+// respond.
+func NewClient(port io.ReadWriter, timeout time.Duration) *Client {
+	return &Client{port: port, timeout: timeout}
+}
+
+// read reads until a complete frame is received or the timeout expires
+func (c *Client) read() ([]byte, error) {
+	var ret []byte
+	buf := make([]byte, 256)
+	start := time.Now()
+
+	for {
+		n, err := c.port.Read(buf)
+		ret = append(ret, buf[:n]...)
+
+		// skip any noise before the start of a frame
+		for len(ret) > 0 && ret[0] != FrameACK && ret[0] != FrameLong &&
+			ret[0] != FrameShort {
+			ret = ret[1:]
+		}
+
+		if len(ret) > 0 {
+			switch ret[0] {
+			case FrameACK:
+				return ret[:1], nil
+			case FrameShort:
+				if len(ret) >= 5 {
+					return ret[:5], nil
+				}
+			case FrameLong:
+				if len(ret) >= 4 && len(ret) >= int(ret[1])+6 {
+					return ret[:int(ret[1])+6], nil
+				}
+			}
+		}
+
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+
+		if time.Since(start) > c.timeout {
+			return nil, io.EOF
+		}
+
+		if n == 0 {
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+}
+
+// Ping initializes the meter at address and returns nil if it responds
+func (c *Client) Ping(address byte) error {
+	_, err := c.port.Write(ShortFrame(CSndNke, address))
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.read()
+	if err != nil {
+		return err
+	}
+
+	if resp[0] != FrameACK {
+		return fmt.Errorf("mbus: expected ACK, got %x", resp)
+	}
+
+	return nil
+}
+
+// Read reads the user data of the meter at address
+func (c *Client) Read(address byte) (Telegram, error) {
+	_, err := c.port.Write(ShortFrame(CReqUD2, address))
+	if err != nil {
+		return Telegram{}, err
+	}
+
+	resp, err := c.read()
+	if err != nil {
+		return Telegram{}, err
+	}
+
+	_, _, ci, data, err := DecodeLongFrame(resp)
+	if err != nil {
+		return Telegram{}, err
+	}
+
+	return ParseTelegram(ci, data)
+}
+
+// Scan returns the addresses between first and last that respond. stop is
+// called before each address and the scan is ended if it returns true.
+func (c *Client) Scan(first, last byte, stop func() bool) []byte {
+	var ret []byte
+
+	for a := int(first); a <= int(last); a++ {
+		if stop != nil && stop() {
+			break
+		}
+
+		if c.Ping(byte(a)) == nil {
+			ret = append(ret, byte(a))
+		}
+	}
+
+	return ret
+}
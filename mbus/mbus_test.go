@@ -0,0 +1,138 @@
+package mbus
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+)
+
+var testUserData = []byte{
+	// header: ID 12345678, KAM, version 1, heat, access 0x10, status 0
+	0x78, 0x56, 0x34, 0x12, 0x2d, 0x2c, 0x01, 0x04, 0x10, 0x00, 0x00, 0x00,
+	// energy, 1234 kWh, int32
+	0x04, 0x06, 0xd2, 0x04, 0x00, 0x00,
+	// volume, 12.345 m³, BCD8
+	0x0c, 0x13, 0x45, 0x23, 0x01, 0x00,
+	// flow temperature, 65.00 °C, int16
+	0x02, 0x59, 0x64, 0x19,
+	// date, storage 1, 2022-07-31
+	0x42, 0x6c, 0xdf, 0x27,
+	// energy, tariff 1, 100 kWh
+	0x84, 0x10, 0x06, 0x64, 0x00, 0x00, 0x00,
+	// voltage, 230.5 V
+	0x02, 0xfd, 0x48, 0x01, 0x09,
+	// manufacturer specific data
+	0x0f, 0xaa, 0xbb,
+}
+
+func longFrame(c, a, ci byte, data []byte) []byte {
+	body := append([]byte{c, a, ci}, data...)
+	ret := []byte{FrameLong, byte(len(body)), byte(len(body)), FrameLong}
+	ret = append(ret, body...)
+	return append(ret, checksum(body), FrameStop)
+}
+
+func TestParseTelegram(t *testing.T) {
+	c, a, ci, d, err := DecodeLongFrame(longFrame(0x08, 5, CIRspVariable, testUserData))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c != 0x08 || a != 5 {
+		t.Errorf("wrong c %x or a %x", c, a)
+	}
+
+	tg, err := ParseTelegram(ci, d)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if tg.ID != 12345678 || tg.Manufacturer != "KAM" || MediumName(tg.Medium) != "heat" {
+		t.Errorf("wrong header: %+v", tg)
+	}
+
+	exp := []Record{
+		{Quantity: "energy", Unit: "kWh", Value: 1234},
+		{Quantity: "volume", Unit: "m³", Value: 12.345},
+		{Quantity: "flowTemperature", Unit: "°C", Value: 65},
+		{Quantity: "date", Storage: 1, Text: "2022-07-31"},
+		{Quantity: "energy", Unit: "kWh", Tariff: 1, Value: 100},
+		{Quantity: "voltage", Unit: "V", Value: 230.5},
+	}
+
+	if len(tg.Records) != len(exp) {
+		t.Fatalf("expected %v records, got %v: %+v", len(exp), len(tg.Records), tg.Records)
+	}
+
+	for i, r := range tg.Records {
+		e := exp[i]
+		// allow for floating point scaling error
+		if r.Quantity != e.Quantity || r.Unit != e.Unit || r.Storage != e.Storage ||
+			r.Tariff != e.Tariff || r.Text != e.Text ||
+			r.Value-e.Value > 1e-9 || e.Value-r.Value > 1e-9 {
+			t.Errorf("record %v: got %+v, exp %+v", i, r, e)
+		}
+	}
+}
+
+func TestDecodeLongFrameChecksum(t *testing.T) {
+	f := longFrame(0x08, 5, CIRspVariable, testUserData)
+	f[10]++
+	if _, _, _, _, err := DecodeLongFrame(f); err != ErrChecksum {
+		t.Error("expected checksum error, got: ", err)
+	}
+}
+
+func TestDecode(t *testing.T) {
+	if v := decodeInt([]byte{0xfe, 0xff, 0xff}); v != -2 {
+		t.Error("int24 sign extension failed: ", v)
+	}
+
+	if v := decodeBCD([]byte{0x34, 0xf2}); v != -234 {
+		t.Error("negative BCD failed: ", v)
+	}
+}
+
+// busSim simulates a meter at address 5
+type busSim struct {
+	out bytes.Buffer
+}
+
+func (b *busSim) Write(f []byte) (int, error) {
+	if f[2] == 5 {
+		switch f[1] {
+		case CSndNke:
+			b.out.WriteByte(FrameACK)
+		case CReqUD2:
+			b.out.Write(longFrame(0x08, 5, CIRspVariable, testUserData))
+		}
+	}
+	return len(f), nil
+}
+
+func (b *busSim) Read(p []byte) (int, error) {
+	n, _ := b.out.Read(p)
+	if n == 0 {
+		return 0, io.EOF
+	}
+	return n, nil
+}
+
+func TestClient(t *testing.T) {
+	c := NewClient(&busSim{}, 20*time.Millisecond)
+
+	found := c.Scan(1, 8, nil)
+	if len(found) != 1 || found[0] != 5 {
+		t.Fatal("scan failed: ", found)
+	}
+
+	tg, err := c.Read(5)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if tg.ID != 12345678 || len(tg.Records) != 6 {
+		t.Errorf("wrong telegram: %+v", tg)
+	}
+}
@@ -0,0 +1,402 @@
+package mbus
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// Telegram is a variable data response from a meter
+type Telegram struct {
+	// ID is the meter identification (serial) number
+	ID           uint32
+	Manufacturer string
+	Version      byte
+	Medium       byte
+	Status       byte
+	Records      []Record
+}
+
+// Record is a data record in a telegram
+type Record struct {
+	// Function is 0 for instantaneous values (see Function constants)
+	Function byte
+	// Storage is 0 for the current value, and > 0 for historic values
+	Storage int
+	Tariff  int
+	Subunit int
+	// Quantity is the name of the value, for example energy or flowTemperature
+	Quantity string
+	Unit     string
+	Value    float64
+	// Text is set for date, time, and string values
+	Text string
+}
+
+// data record function field values
+const (
+	FunctionInstantaneous = 0
+	FunctionMaximum       = 1
+	FunctionMinimum       = 2
+	FunctionError         = 3
+)
+
+// MediumName returns the name of a medium code
+func MediumName(medium byte) string {
+	switch medium {
+	case 0x02:
+		return "electricity"
+	case 0x03:
+		return "gas"
+	case 0x04:
+		return "heat"
+	case 0x06:
+		return "warmWater"
+	case 0x07:
+		return "water"
+	case 0x08:
+		return "heatCostAllocator"
+	case 0x0a, 0x0b:
+		return "cooling"
+	case 0x0c:
+		return "heatInlet"
+	case 0x0d:
+		return "heatCooling"
+	case 0x15:
+		return "hotWater"
+	case 0x16:
+		return "coldWater"
+	default:
+		return fmt.Sprintf("other(%x)", medium)
+	}
+}
+
+// decodeManufacturer decodes the 3 letter manufacturer ID
+func decodeManufacturer(m uint16) string {
+	return string([]byte{
+		byte((m>>10)&0x1f) + 64,
+		byte((m>>5)&0x1f) + 64,
+		byte(m&0x1f) + 64,
+	})
+}
+
+// decodeBCD decodes little endian BCD data. A high nibble of F in the last
+// byte means the value is negative.
+func decodeBCD(b []byte) float64 {
+	var ret float64
+	neg := false
+	for i := len(b) - 1; i >= 0; i-- {
+		hi, lo := b[i]>>4, b[i]&0x0f
+		if i == len(b)-1 && hi == 0x0f {
+			neg = true
+			hi = 0
+		}
+		ret = ret*100 + float64(hi)*10 + float64(lo)
+	}
+	if neg {
+		return -ret
+	}
+	return ret
+}
+
+// decodeInt decodes a little endian signed integer of any size
+func decodeInt(b []byte) float64 {
+	var v uint64
+	for i := len(b) - 1; i >= 0; i-- {
+		v = v<<8 | uint64(b[i])
+	}
+
+	// sign extend
+	shift := uint(64 - 8*len(b))
+	return float64(int64(v<<shift) >> shift)
+}
+
+// ParseTelegram parses the data of a variable data response
+func ParseTelegram(ci byte, data []byte) (Telegram, error) {
+	var ret Telegram
+
+	switch ci {
+	case CIRspVariable:
+		if len(data) < 12 {
+			return ret, errors.New("mbus: telegram header too short")
+		}
+		ret.ID = uint32(decodeBCD(data[0:4]))
+		ret.Manufacturer = decodeManufacturer(binary.LittleEndian.Uint16(data[4:6]))
+		ret.Version = data[6]
+		ret.Medium = data[7]
+		ret.Status = data[9]
+		data = data[12:]
+	case CIRspVariableShort:
+	default:
+		return ret, fmt.Errorf("mbus: unsupported CI field: %x", ci)
+	}
+
+	var err error
+	ret.Records, err = parseRecords(data)
+	return ret, err
+}
+
+// dataLen returns the length of the data for a DIF data field, or -1 for
+// variable length
+func dataLen(field byte) int {
+	switch field {
+	case 0x0, 0x8:
+		return 0
+	case 0x1, 0x9:
+		return 1
+	case 0x2, 0xa:
+		return 2
+	case 0x3, 0xb:
+		return 3
+	case 0x4, 0x5, 0xc:
+		return 4
+	case 0x6, 0xe:
+		return 6
+	case 0x7:
+		return 8
+	default:
+		return -1
+	}
+}
+
+func parseRecords(data []byte) ([]Record, error) {
+	var ret []Record
+
+	i := 0
+	for i < len(data) {
+		dif := data[i]
+		i++
+
+		switch dif {
+		case 0x2f:
+			// idle filler
+			continue
+		case 0x0f, 0x1f:
+			// manufacturer specific data to the end of the telegram
+			return ret, nil
+		}
+
+		r := Record{
+			Function: (dif >> 4) & 0x3,
+			Storage:  int(dif>>6) & 0x1,
+		}
+		field := dif & 0x0f
+
+		ext := dif&0x80 != 0
+		for n := 0; ext; n++ {
+			if i >= len(data) {
+				return ret, errors.New("mbus: record truncated in DIFE")
+			}
+			dife := data[i]
+			i++
+			r.Storage |= int(dife&0x0f) << uint(1+4*n)
+			r.Tariff |= int((dife>>4)&0x3) << uint(2*n)
+			r.Subunit |= int((dife>>6)&0x1) << uint(n)
+			ext = dife&0x80 != 0
+		}
+
+		if i >= len(data) {
+			return ret, errors.New("mbus: record truncated before VIF")
+		}
+
+		vif := data[i]
+		i++
+
+		var vifes []byte
+		ext = vif&0x80 != 0
+		for ext {
+			if i >= len(data) {
+				return ret, errors.New("mbus: record truncated in VIFE")
+			}
+			vifes = append(vifes, data[i])
+			ext = data[i]&0x80 != 0
+			i++
+		}
+
+		if vif&0x7f == 0x7c {
+			// plain text unit
+			if i >= len(data) || i+1+int(data[i]) > len(data) {
+				return ret, errors.New("mbus: record truncated in plain text VIF")
+			}
+			l := int(data[i])
+			unit := make([]byte, l)
+			for j := 0; j < l; j++ {
+				unit[j] = data[i+l-j]
+			}
+			i += 1 + l
+			r.Quantity = "text"
+			r.Unit = string(unit)
+		}
+
+		exp := decodeVIF(&r, vif, vifes)
+
+		l := dataLen(field)
+		if l < 0 {
+			if field != 0xd || i >= len(data) {
+				return ret, fmt.Errorf("mbus: unsupported data field: %x", field)
+			}
+			l = int(data[i])
+			i++
+			if l > 0xbf {
+				return ret, fmt.Errorf("mbus: unsupported variable length: %x", l)
+			}
+		}
+
+		if i+l > len(data) {
+			return ret, errors.New("mbus: record data truncated")
+		}
+
+		d := data[i : i+l]
+		i += l
+
+		switch {
+		case field == 0xd:
+			// string, sent in reverse order
+			s := make([]byte, len(d))
+			for j := range d {
+				s[j] = d[len(d)-1-j]
+			}
+			r.Text = string(s)
+		case vif&0x7f == 0x6c && field == 0x2:
+			// date, type G
+			r.Text = fmt.Sprintf("%04d-%02d-%02d", 2000+int(d[0]>>5|(d[1]&0xf0)>>1),
+				d[1]&0x0f, d[0]&0x1f)
+		case vif&0x7f == 0x6d && field == 0x4:
+			// date and time, type F
+			r.Text = fmt.Sprintf("%04d-%02d-%02dT%02d:%02d", 2000+int(d[2]>>5|(d[3]&0xf0)>>1),
+				d[3]&0x0f, d[2]&0x1f, d[1]&0x1f, d[0]&0x3f)
+		case field == 0x5:
+			r.Value = float64(math.Float32frombits(binary.LittleEndian.Uint32(d)))
+		case field >= 0x9:
+			r.Value = decodeBCD(d)
+		default:
+			r.Value = decodeInt(d)
+		}
+
+		if r.Text == "" && exp != 0 {
+			r.Value *= math.Pow10(exp)
+		}
+
+		ret = append(ret, r)
+	}
+
+	return ret, nil
+}
+
+// durationUnit returns the unit of a duration VIF
+func durationUnit(nn byte) string {
+	switch nn & 0x3 {
+	case 0:
+		return "s"
+	case 1:
+		return "min"
+	case 2:
+		return "h"
+	default:
+		return "d"
+	}
+}
+
+// decodeVIF sets the record quantity and unit from the VIF and returns the
+// power of 10 to scale the value by. Energy is converted to kWh and power to
+// kW.
+func decodeVIF(r *Record, vif byte, vifes []byte) int {
+	v := vif & 0x7f
+	n := int(v & 0x7)
+	nn := int(v & 0x3)
+
+	switch {
+	case vif == 0xfd && len(vifes) > 0:
+		e := vifes[0] & 0x7f
+		switch {
+		case e&0x70 == 0x40:
+			r.Quantity, r.Unit = "voltage", "V"
+			return int(e&0x0f) - 9
+		case e&0x70 == 0x50:
+			r.Quantity, r.Unit = "current", "A"
+			return int(e&0x0f) - 12
+		}
+		r.Quantity = fmt.Sprintf("vif(fd%x)", e)
+		return 0
+	case vif == 0xfb && len(vifes) > 0:
+		r.Quantity = fmt.Sprintf("vif(fb%x)", vifes[0]&0x7f)
+		return 0
+	case v == 0x7c:
+		return 0
+	case v == 0x7f || vif == 0xff:
+		r.Quantity = "manufacturer"
+		return 0
+	case v <= 0x07:
+		r.Quantity, r.Unit = "energy", "kWh"
+		return n - 6
+	case v <= 0x0f:
+		r.Quantity, r.Unit = "energy", "kJ"
+		return n - 3
+	case v <= 0x17:
+		r.Quantity, r.Unit = "volume", "m³"
+		return n - 6
+	case v <= 0x1f:
+		r.Quantity, r.Unit = "mass", "kg"
+		return n - 3
+	case v <= 0x23:
+		r.Quantity, r.Unit = "onTime", durationUnit(v)
+		return 0
+	case v <= 0x27:
+		r.Quantity, r.Unit = "operatingTime", durationUnit(v)
+		return 0
+	case v <= 0x2f:
+		r.Quantity, r.Unit = "power", "kW"
+		return n - 6
+	case v <= 0x37:
+		r.Quantity, r.Unit = "power", "kJ/h"
+		return n - 3
+	case v <= 0x3f:
+		r.Quantity, r.Unit = "volumeFlow", "m³/h"
+		return n - 6
+	case v <= 0x47:
+		r.Quantity, r.Unit = "volumeFlow", "m³/min"
+		return n - 7
+	case v <= 0x4f:
+		r.Quantity, r.Unit = "volumeFlow", "m³/s"
+		return n - 9
+	case v <= 0x57:
+		r.Quantity, r.Unit = "massFlow", "kg/h"
+		return n - 3
+	case v <= 0x5b:
+		r.Quantity, r.Unit = "flowTemperature", "°C"
+		return nn - 3
+	case v <= 0x5f:
+		r.Quantity, r.Unit = "returnTemperature", "°C"
+		return nn - 3
+	case v <= 0x63:
+		r.Quantity, r.Unit = "temperatureDifference", "K"
+		return nn - 3
+	case v <= 0x67:
+		r.Quantity, r.Unit = "externalTemperature", "°C"
+		return nn - 3
+	case v <= 0x6b:
+		r.Quantity, r.Unit = "pressure", "bar"
+		return nn - 3
+	case v == 0x6c:
+		r.Quantity = "date"
+	case v == 0x6d:
+		r.Quantity = "dateTime"
+	case v == 0x6e:
+		r.Quantity = "hcaUnits"
+	case v >= 0x70 && v <= 0x73:
+		r.Quantity, r.Unit = "averagingDuration", durationUnit(v)
+	case v >= 0x74 && v <= 0x77:
+		r.Quantity, r.Unit = "actualityDuration", durationUnit(v)
+	case v == 0x78:
+		r.Quantity = "fabricationNumber"
+	case v == 0x79:
+		r.Quantity = "enhancedID"
+	case v == 0x7a:
+		r.Quantity = "busAddress"
+	default:
+		r.Quantity = fmt.Sprintf("vif(%x)", v)
+	}
+
+	return 0
+}
@@ -0,0 +1,93 @@
+package modbus
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/simpleiot/simpleiot/test"
+)
+
+func TestClientTCPFaults(t *testing.T) {
+	server, err := test.NewModbusTCPServer()
+	if err != nil {
+		t.Fatal("Error starting server: ", err)
+	}
+	defer server.Close()
+
+	server.Regs.SetReg(10, 1234)
+
+	sock, err := net.Dial("tcp", server.Addr())
+	if err != nil {
+		t.Fatal("Error connecting: ", err)
+	}
+
+	client := NewClient(NewTCP(sock, 100*time.Millisecond, TransportClient), 0)
+	defer client.Close()
+
+	regs, err := client.ReadHoldingRegs(1, 10, 1)
+	if err != nil || len(regs) != 1 || regs[0] != 1234 {
+		t.Fatal("read failed: ", regs, err)
+	}
+
+	if err := client.WriteSingleReg(1, 11, 42); err != nil || server.Regs.Reg(11) != 42 {
+		t.Fatal("write failed: ", err)
+	}
+
+	faults := []test.Fault{test.FaultException, test.FaultTimeout, test.FaultPartial}
+	server.Inject(faults...)
+
+	for _, f := range faults {
+		if _, err := client.ReadHoldingRegs(1, 10, 1); err == nil {
+			t.Errorf("expected error for fault %v", f)
+		}
+	}
+
+	server.Inject(test.FaultDisconnect)
+
+	if _, err := client.ReadHoldingRegs(1, 10, 1); err == nil {
+		t.Error("expected error after disconnect")
+	}
+
+	if server.Requests() != 6 {
+		t.Error("wrong request count: ", server.Requests())
+	}
+}
+
+func TestClientRTUFaults(t *testing.T) {
+	regs := test.NewModbusRegs()
+	regs.SetReg(3, 7)
+
+	dev := test.NewModbusRTUDevice(2, regs)
+	client := NewClient(NewRTU(dev), 0)
+	defer client.Close()
+
+	values, err := client.ReadHoldingRegs(2, 2, 2)
+	if err != nil || len(values) != 2 || values[0] != 0 || values[1] != 7 {
+		t.Fatal("read failed: ", values, err)
+	}
+
+	dev.Inject(test.FaultCRC)
+	if _, err := client.ReadHoldingRegs(2, 2, 2); err != ErrCRC {
+		t.Error("expected CRC error, got: ", err)
+	}
+
+	dev.Inject(test.FaultPartial)
+	if _, err := client.ReadHoldingRegs(2, 2, 2); err == nil {
+		t.Error("expected error for partial frame")
+	}
+
+	dev.Inject(test.FaultTimeout)
+	if _, err := client.ReadHoldingRegs(2, 2, 2); err == nil {
+		t.Error("expected timeout")
+	}
+
+	// other devices on the bus don't respond
+	if _, err := client.ReadHoldingRegs(3, 2, 2); err == nil {
+		t.Error("expected timeout for other device")
+	}
+
+	if err := client.WriteSingleCoil(2, 0, true); err != nil || !regs.Coil(0) {
+		t.Error("write coil failed: ", err)
+	}
+}
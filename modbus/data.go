@@ -156,3 +156,92 @@ func Float32ToRegs(in []float32) []uint16 {
 
 	return ret
 }
+
+// ReorderRegs converts regs between device and big endian (ABCD) order.
+// swapBytes swaps the two bytes in each reg (BADC), and swapWords reverses
+// the order of the regs (CDAB). Both together give little endian (DCBA).
+// Reordering twice returns the original regs, so this is used for both
+// reads and writes.
+func ReorderRegs(in []uint16, swapBytes, swapWords bool) []uint16 {
+	ret := make([]uint16, len(in))
+	for i, v := range in {
+		if swapBytes {
+			v = v<<8 | v>>8
+		}
+		if swapWords {
+			ret[len(in)-1-i] = v
+		} else {
+			ret[i] = v
+		}
+	}
+
+	return ret
+}
+
+// RegsToUint64 converts modbus regs to uint64 values
+func RegsToUint64(in []uint16) []uint64 {
+	count := len(in) / 4
+	ret := make([]uint64, count)
+	for i := range ret {
+		for j := 0; j < 4; j++ {
+			ret[i] = ret[i]<<16 | uint64(in[i*4+j])
+		}
+	}
+
+	return ret
+}
+
+// Uint64ToRegs converts uint64 values to modbus regs
+func Uint64ToRegs(in []uint64) []uint16 {
+	ret := make([]uint16, len(in)*4)
+	for i, v := range in {
+		for j := 3; j >= 0; j-- {
+			ret[i*4+j] = uint16(v)
+			v >>= 16
+		}
+	}
+
+	return ret
+}
+
+// RegsToInt64 converts modbus regs to int64 values
+func RegsToInt64(in []uint16) []int64 {
+	u := RegsToUint64(in)
+	ret := make([]int64, len(u))
+	for i, v := range u {
+		ret[i] = int64(v)
+	}
+
+	return ret
+}
+
+// Int64ToRegs converts int64 values to modbus regs
+func Int64ToRegs(in []int64) []uint16 {
+	u := make([]uint64, len(in))
+	for i, v := range in {
+		u[i] = uint64(v)
+	}
+
+	return Uint64ToRegs(u)
+}
+
+// RegsToFloat64 converts modbus regs to float64 values
+func RegsToFloat64(in []uint16) []float64 {
+	u := RegsToUint64(in)
+	ret := make([]float64, len(u))
+	for i, v := range u {
+		ret[i] = math.Float64frombits(v)
+	}
+
+	return ret
+}
+
+// Float64ToRegs converts float64 values to modbus regs
+func Float64ToRegs(in []float64) []uint16 {
+	u := make([]uint64, len(in))
+	for i, v := range in {
+		u[i] = math.Float64bits(v)
+	}
+
+	return Uint64ToRegs(u)
+}
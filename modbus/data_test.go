@@ -49,3 +49,67 @@ func TestRegsToFloat32SwapWords(t *testing.T) {
 		t.Error("Failed: ", exp, f)
 	}
 }
+
+func TestInt64(t *testing.T) {
+	v := int64(-41234562312345)
+
+	regs := Int64ToRegs([]int64{v})
+
+	v2 := RegsToInt64(regs)
+
+	if v != v2[0] {
+		t.Error("Failed: ", v, v2[0])
+	}
+}
+
+func TestFloat64(t *testing.T) {
+	exp := 0.01
+
+	// 0x3f847ae147ae147b
+	f := RegsToFloat64([]uint16{0x3f84, 0x7ae1, 0x47ae, 0x147b})
+
+	if exp != f[0] {
+		t.Error("Failed: ", exp, f)
+	}
+
+	regs := Float64ToRegs(f)
+	if regs[0] != 0x3f84 || regs[3] != 0x147b {
+		t.Errorf("Failed: %x", regs)
+	}
+}
+
+func TestReorderRegs(t *testing.T) {
+	in := []uint16{0x0102, 0x0304}
+
+	tests := []struct {
+		swapBytes, swapWords bool
+		exp                  []uint16
+	}{
+		{false, false, []uint16{0x0102, 0x0304}},
+		{true, false, []uint16{0x0201, 0x0403}},
+		{false, true, []uint16{0x0304, 0x0102}},
+		{true, true, []uint16{0x0403, 0x0201}},
+	}
+
+	for _, test := range tests {
+		out := ReorderRegs(in, test.swapBytes, test.swapWords)
+		for i := range out {
+			if out[i] != test.exp[i] {
+				t.Errorf("swap bytes %v, words %v: got %x, exp %x",
+					test.swapBytes, test.swapWords, out, test.exp)
+				break
+			}
+		}
+
+		back := ReorderRegs(out, test.swapBytes, test.swapWords)
+		if back[0] != in[0] || back[1] != in[1] {
+			t.Errorf("reorder is not reversible: %x", back)
+		}
+	}
+
+	// float32 0.01 in little endian (DCBA) order
+	f := RegsToFloat32(ReorderRegs([]uint16{0x0ad7, 0x233c}, true, true))
+	if f[0] != float32(0.01) {
+		t.Error("DCBA float failed: ", f)
+	}
+}
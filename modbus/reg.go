@@ -253,3 +253,36 @@ func (r *Regs) WriteRegFloat32(address int, value float32) error {
 
 	return nil
 }
+
+// ReadRegs reads count consecutive regs starting at address
+func (r *Regs) ReadRegs(address int, count int) ([]uint16, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	ret := make([]uint16, count)
+
+	for i := range ret {
+		var err error
+		ret[i], err = r.readReg(address + i)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return ret, nil
+}
+
+// WriteRegs writes consecutive regs starting at address
+func (r *Regs) WriteRegs(address int, values []uint16) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	for i, v := range values {
+		err := r.writeReg(address+i, v)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
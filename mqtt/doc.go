@@ -0,0 +1,4 @@
+// Package mqtt contains a small MQTT 3.1.1 client used to integrate with
+// systems that publish data over MQTT. Only QoS 0 subscriptions and
+// publishes are supported.
+package mqtt
@@ -0,0 +1,405 @@
+package mqtt
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// packet types
+const (
+	packetConnect    = 1
+	packetConnAck    = 2
+	packetPublish    = 3
+	packetPubAck     = 4
+	packetSubscribe  = 8
+	packetSubAck     = 9
+	packetPingReq    = 12
+	packetPingResp   = 13
+	packetDisconnect = 14
+)
+
+// the remaining length of a packet is encoded in at most 4 bytes
+const maxRemainingBytes = 4
+
+// Options are used to connect to a broker
+type Options struct {
+	ClientID string
+	Username string
+	Password string
+	// KeepAlive defaults to 60 seconds
+	KeepAlive time.Duration
+	// Timeout is used for connecting, defaults to 10 seconds
+	Timeout time.Duration
+}
+
+// Message is a message received from the broker
+type Message struct {
+	Topic   string
+	Payload []byte
+	Retain  bool
+}
+
+// Client is a connection to an MQTT broker
+type Client struct {
+	conn      net.Conn
+	keepAlive time.Duration
+
+	// lock serializes writes to conn
+	lock   sync.Mutex
+	nextID uint16
+
+	messages chan Message
+	done     chan struct{}
+	err      error
+}
+
+// brokerAddress returns the network address and if TLS is used for a broker
+// URI. tcp://, mqtt://, ssl://, mqtts://, tls://, and host:port forms are
+// supported.
+func brokerAddress(uri string) (string, bool, error) {
+	secure := false
+	addr := uri
+
+	if i := strings.Index(uri, "://"); i >= 0 {
+		switch uri[:i] {
+		case "tcp", "mqtt":
+		case "ssl", "tls", "mqtts":
+			secure = true
+		default:
+			return "", false, fmt.Errorf("mqtt: unsupported scheme: %v", uri[:i])
+		}
+		addr = uri[i+3:]
+	}
+
+	addr = strings.TrimRight(addr, "/")
+	if addr == "" {
+		return "", false, errors.New("mqtt: broker address not set")
+	}
+
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		if secure {
+			addr = net.JoinHostPort(addr, "8883")
+		} else {
+			addr = net.JoinHostPort(addr, "1883")
+		}
+	}
+
+	return addr, secure, nil
+}
+
+func appendUint16(b []byte, v uint16) []byte {
+	return append(b, byte(v>>8), byte(v))
+}
+
+func appendString(b []byte, s string) []byte {
+	b = appendUint16(b, uint16(len(s)))
+	return append(b, s...)
+}
+
+func encodePacket(header byte, body []byte) []byte {
+	ret := []byte{header}
+	l := len(body)
+	for {
+		d := byte(l % 128)
+		l /= 128
+		if l > 0 {
+			d |= 0x80
+		}
+		ret = append(ret, d)
+		if l == 0 {
+			break
+		}
+	}
+	return append(ret, body...)
+}
+
+// readPacket reads a packet and returns the header byte and body
+func readPacket(r io.ByteReader) (byte, []byte, error) {
+	header, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	l, mult := 0, 1
+	for i := 0; ; i++ {
+		if i >= maxRemainingBytes {
+			return 0, nil, errors.New("mqtt: invalid packet length")
+		}
+
+		d, err := r.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+
+		l += int(d&0x7f) * mult
+		mult *= 128
+		if d&0x80 == 0 {
+			break
+		}
+	}
+
+	body := make([]byte, l)
+	for i := range body {
+		body[i], err = r.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+	}
+
+	return header, body, nil
+}
+
+func encodeConnect(opts Options, keepAlive time.Duration) []byte {
+	flags := byte(0x02) // clean session
+	if opts.Username != "" {
+		flags |= 0x80
+		if opts.Password != "" {
+			flags |= 0x40
+		}
+	}
+
+	body := appendString(nil, "MQTT")
+	body = append(body, 4, flags)
+	body = appendUint16(body, uint16(keepAlive/time.Second))
+	body = appendString(body, opts.ClientID)
+	if opts.Username != "" {
+		body = appendString(body, opts.Username)
+		if opts.Password != "" {
+			body = appendString(body, opts.Password)
+		}
+	}
+
+	return encodePacket(packetConnect<<4, body)
+}
+
+func decodePublish(header byte, body []byte) (Message, uint16, error) {
+	var m Message
+
+	if len(body) < 2 {
+		return m, 0, errors.New("mqtt: publish too short")
+	}
+
+	tl := int(binary.BigEndian.Uint16(body))
+	if len(body) < 2+tl {
+		return m, 0, errors.New("mqtt: publish topic truncated")
+	}
+
+	m.Topic = string(body[2 : 2+tl])
+	m.Retain = header&0x01 != 0
+	body = body[2+tl:]
+
+	var id uint16
+	if qos := (header >> 1) & 0x3; qos > 0 {
+		if len(body) < 2 {
+			return m, 0, errors.New("mqtt: publish packet id missing")
+		}
+		id = binary.BigEndian.Uint16(body)
+		body = body[2:]
+	}
+
+	m.Payload = body
+	return m, id, nil
+}
+
+// Dial connects to a broker. See brokerAddress for the URI formats.
+func Dial(uri string, opts Options) (*Client, error) {
+	addr, secure, err := brokerAddress(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.KeepAlive <= 0 {
+		opts.KeepAlive = 60 * time.Second
+	}
+
+	if opts.Timeout <= 0 {
+		opts.Timeout = 10 * time.Second
+	}
+
+	dialer := &net.Dialer{Timeout: opts.Timeout}
+
+	var conn net.Conn
+	if secure {
+		host, _, _ := net.SplitHostPort(addr)
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr,
+			&tls.Config{ServerName: host})
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Client{
+		conn:      conn,
+		keepAlive: opts.KeepAlive,
+		messages:  make(chan Message, 100),
+		done:      make(chan struct{}),
+	}
+
+	err = conn.SetDeadline(time.Now().Add(opts.Timeout))
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	_, err = conn.Write(encodeConnect(opts, opts.KeepAlive))
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	r := bufio.NewReader(conn)
+	header, body, err := readPacket(r)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("mqtt: connect: %w", err)
+	}
+
+	if header>>4 != packetConnAck || len(body) < 2 {
+		conn.Close()
+		return nil, errors.New("mqtt: invalid connack")
+	}
+
+	if body[1] != 0 {
+		conn.Close()
+		return nil, fmt.Errorf("mqtt: connection refused, code: %v", body[1])
+	}
+
+	err = conn.SetDeadline(time.Time{})
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	go c.receive(r)
+	go c.ping()
+
+	return c, nil
+}
+
+func (c *Client) write(p []byte) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	_, err := c.conn.Write(p)
+	return err
+}
+
+// receive reads packets from the broker until the connection is closed
+func (c *Client) receive(r *bufio.Reader) {
+	defer close(c.done)
+	defer close(c.messages)
+
+	for {
+		// the broker closes the connection if pings are not received, so
+		// don't wait more than the keep alive plus ping response time
+		err := c.conn.SetReadDeadline(time.Now().Add(c.keepAlive * 3 / 2))
+		if err != nil {
+			c.err = err
+			return
+		}
+
+		header, body, err := readPacket(r)
+		if err != nil {
+			c.err = err
+			return
+		}
+
+		if header>>4 != packetPublish {
+			continue
+		}
+
+		m, id, err := decodePublish(header, body)
+		if err != nil {
+			c.err = err
+			return
+		}
+
+		if (header>>1)&0x3 == 1 {
+			err := c.write(encodePacket(packetPubAck<<4,
+				appendUint16(nil, id)))
+			if err != nil {
+				c.err = err
+				return
+			}
+		}
+
+		c.messages <- m
+	}
+}
+
+func (c *Client) ping() {
+	t := time.NewTicker(c.keepAlive / 2)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-t.C:
+			if err := c.write([]byte{packetPingReq << 4, 0}); err != nil {
+				return
+			}
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// Subscribe subscribes to topic filters with QoS 0
+func (c *Client) Subscribe(filters ...string) error {
+	c.lock.Lock()
+	c.nextID++
+	if c.nextID == 0 {
+		c.nextID = 1
+	}
+	id := c.nextID
+	c.lock.Unlock()
+
+	body := appendUint16(nil, id)
+	for _, f := range filters {
+		body = appendString(body, f)
+		body = append(body, 0)
+	}
+
+	return c.write(encodePacket(packetSubscribe<<4|0x02, body))
+}
+
+// Publish publishes a message with QoS 0
+func (c *Client) Publish(topic string, payload []byte, retain bool) error {
+	header := byte(packetPublish << 4)
+	if retain {
+		header |= 0x01
+	}
+
+	body := appendString(nil, topic)
+	return c.write(encodePacket(header, append(body, payload...)))
+}
+
+// Messages returns messages received from the broker. The channel is closed
+// when the connection is closed or lost.
+func (c *Client) Messages() <-chan Message {
+	return c.messages
+}
+
+// Err returns why the connection was closed
+func (c *Client) Err() error {
+	select {
+	case <-c.done:
+		return c.err
+	default:
+		return nil
+	}
+}
+
+// Close disconnects from the broker
+func (c *Client) Close() error {
+	_ = c.write([]byte{packetDisconnect << 4, 0})
+	return c.conn.Close()
+}
@@ -0,0 +1,229 @@
+package mqtt
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/simpleiot/simpleiot/test"
+)
+
+func TestBrokerAddress(t *testing.T) {
+	tests := []struct {
+		uri    string
+		addr   string
+		secure bool
+	}{
+		{"localhost", "localhost:1883", false},
+		{"tcp://10.0.0.1:1884", "10.0.0.1:1884", false},
+		{"mqtt://broker/", "broker:1883", false},
+		{"mqtts://broker", "broker:8883", true},
+		{"ssl://broker:9000", "broker:9000", true},
+	}
+
+	for _, test := range tests {
+		addr, secure, err := brokerAddress(test.uri)
+		if err != nil {
+			t.Fatalf("%v: %v", test.uri, err)
+		}
+		if addr != test.addr || secure != test.secure {
+			t.Errorf("%v: got %v %v", test.uri, addr, secure)
+		}
+	}
+
+	if _, _, err := brokerAddress("ws://broker"); err == nil {
+		t.Error("expected error for unsupported scheme")
+	}
+}
+
+func TestPacketLength(t *testing.T) {
+	body := make([]byte, 321)
+	p := encodePacket(packetPublish<<4, body)
+	if !bytes.Equal(p[:3], []byte{0x30, 0xc1, 0x02}) {
+		t.Fatalf("wrong header: %x", p[:3])
+	}
+
+	header, got, err := readPacket(bufio.NewReader(bytes.NewReader(p)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if header != 0x30 || len(got) != len(body) {
+		t.Errorf("got %x, len %v", header, len(got))
+	}
+}
+
+// fakeBroker accepts one connection and publishes messages back to the
+// client for every publish received. Subscribed filters are sent to subs.
+func fakeBroker(t *testing.T) (string, chan string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { l.Close() })
+
+	subs := make(chan string, 10)
+
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		r := bufio.NewReader(conn)
+		for {
+			header, body, err := readPacket(r)
+			if err != nil {
+				return
+			}
+
+			switch header >> 4 {
+			case packetConnect:
+				_, _ = conn.Write([]byte{packetConnAck << 4, 2, 0, 0})
+			case packetSubscribe:
+				tl := int(body[2])<<8 | int(body[3])
+				subs <- string(body[4 : 4+tl])
+			case packetPublish:
+				// echo back as QoS 1 to check acks are sent
+				m, _, _ := decodePublish(header, body)
+				b := appendString(nil, m.Topic)
+				b = appendUint16(b, 5)
+				_, _ = conn.Write(encodePacket(packetPublish<<4|0x02,
+					append(b, m.Payload...)))
+			case packetPubAck:
+				subs <- "puback"
+			}
+		}
+	}()
+
+	return l.Addr().String(), subs
+}
+
+func TestClient(t *testing.T) {
+	addr, subs := fakeBroker(t)
+
+	c, err := Dial(addr, Options{ClientID: "test", Username: "user",
+		Password: "pass", Timeout: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if err := c.Subscribe("zigbee2mqtt/#"); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case s := <-subs:
+		if s != "zigbee2mqtt/#" {
+			t.Errorf("wrong subscription: %v", s)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("subscription not received")
+	}
+
+	if err := c.Publish("zigbee2mqtt/lamp/set", []byte(`{"state":"ON"}`), false); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case m := <-c.Messages():
+		if m.Topic != "zigbee2mqtt/lamp/set" || string(m.Payload) != `{"state":"ON"}` {
+			t.Errorf("wrong message: %+v", m)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("message not received")
+	}
+
+	select {
+	case s := <-subs:
+		if s != "puback" {
+			t.Errorf("expected puback, got %v", s)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("puback not received")
+	}
+}
+
+func TestClientBrokerFaults(t *testing.T) {
+	broker, err := test.NewMqttBroker()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer broker.Close()
+
+	opts := Options{ClientID: "test", Timeout: 200 * time.Millisecond}
+
+	broker.Inject(test.FaultException)
+	if _, err := Dial(broker.Addr(), opts); err == nil {
+		t.Fatal("expected refused connection")
+	}
+
+	broker.Inject(test.FaultTimeout)
+	if _, err := Dial(broker.Addr(), opts); err == nil {
+		t.Fatal("expected connect timeout")
+	}
+
+	broker.Inject(test.FaultPartial)
+	if _, err := Dial(broker.Addr(), opts); err == nil {
+		t.Fatal("expected error for partial connack")
+	}
+
+	c, err := Dial(broker.Addr(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if err := c.Subscribe("sensors/+/temp"); err != nil {
+		t.Fatal(err)
+	}
+
+	// wait for the subscription to be processed
+	time.Sleep(50 * time.Millisecond)
+
+	broker.Publish("sensors/a/temp", []byte("21.5"), false)
+	broker.Publish("sensors/a/humidity", []byte("40"), false)
+
+	select {
+	case m := <-c.Messages():
+		if m.Topic != "sensors/a/temp" || string(m.Payload) != "21.5" {
+			t.Errorf("wrong message: %+v", m)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("message not received")
+	}
+
+	if err := c.Publish("status", []byte("on"), true); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case m := <-broker.Messages():
+		if m.ClientID != "test" || m.Topic != "status" || !m.Retain {
+			t.Errorf("wrong message: %+v", m)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("publish not received")
+	}
+
+	// the client sees the connection drop
+	broker.DropClients()
+
+	select {
+	case _, ok := <-c.Messages():
+		if ok {
+			t.Fatal("unexpected message")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("client did not see disconnect")
+	}
+
+	if c.Err() == nil {
+		t.Error("expected client error after disconnect")
+	}
+}
@@ -0,0 +1,221 @@
+package msg
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/simpleiot/simpleiot/data"
+	"golang.org/x/crypto/hkdf"
+)
+
+// ErrPushGone is returned by WebPush.Send if the push service no longer
+// knows the subscription. The subscription should be removed.
+var ErrPushGone = errors.New("push subscription is gone")
+
+// pushRecordSize is the record size of the encrypted push content. The
+// payload must fit in one record.
+const pushRecordSize = 4096
+
+// b64 is the encoding used for Web Push keys (base64url without padding)
+var b64 = base64.RawURLEncoding
+
+// GenerateVapidKeys generates a VAPID key pair for WebPush. The keys are
+// base64url encoded, as expected by PushManager.subscribe().
+func GenerateVapidKeys() (publicKey, privateKey string, err error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return "", "", err
+	}
+
+	pub := elliptic.Marshal(key.Curve, key.X, key.Y)
+	priv := make([]byte, 32)
+	key.D.FillBytes(priv)
+
+	return b64.EncodeToString(pub), b64.EncodeToString(priv), nil
+}
+
+// WebPush sends Web Push messages (RFC 8030) to browsers. Messages are
+// encrypted for the subscription (RFC 8291) and signed with the VAPID key of
+// the server (RFC 8292).
+type WebPush struct {
+	key       *ecdsa.PrivateKey
+	publicKey string
+	subject   string
+	client    *http.Client
+}
+
+// NewWebPush creates a Web Push sender from a VAPID key pair. subject is a
+// mailto: or https: contact URL for the push service operator.
+func NewWebPush(publicKey, privateKey, subject string) (*WebPush, error) {
+	pub, err := b64.DecodeString(publicKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid VAPID public key: %v", err)
+	}
+
+	x, y := elliptic.Unmarshal(elliptic.P256(), pub)
+	if x == nil {
+		return nil, errors.New("invalid VAPID public key")
+	}
+
+	priv, err := b64.DecodeString(privateKey)
+	if err != nil || len(priv) != 32 {
+		return nil, errors.New("invalid VAPID private key")
+	}
+
+	key := &ecdsa.PrivateKey{
+		PublicKey: ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y},
+		D:         new(big.Int).SetBytes(priv),
+	}
+
+	return &WebPush{
+		key:       key,
+		publicKey: publicKey,
+		subject:   subject,
+		client:    &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+// Send sends payload to a push subscription. urgency is one of very-low,
+// low, normal, or high, and can be blank.
+func (w *WebPush) Send(sub data.PushSubscription, payload []byte, urgency string) error {
+	body, err := encryptPush(sub, payload)
+	if err != nil {
+		return err
+	}
+
+	endpoint, err := url.Parse(sub.Endpoint)
+	if err != nil {
+		return fmt.Errorf("invalid push endpoint: %v", err)
+	}
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.StandardClaims{
+		Audience:  endpoint.Scheme + "://" + endpoint.Host,
+		ExpiresAt: time.Now().Add(12 * time.Hour).Unix(),
+		Subject:   w.subject,
+	}).SignedString(w.key)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, sub.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Authorization", fmt.Sprintf("vapid t=%v, k=%v", token, w.publicKey))
+	req.Header.Set("Content-Encoding", "aes128gcm")
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("TTL", "86400")
+	if urgency != "" {
+		req.Header.Set("Urgency", urgency)
+	}
+
+	res, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	switch {
+	case res.StatusCode == http.StatusNotFound || res.StatusCode == http.StatusGone:
+		return ErrPushGone
+	case res.StatusCode >= 300:
+		msg, _ := io.ReadAll(io.LimitReader(res.Body, 512))
+		return fmt.Errorf("push service returned %v: %s", res.Status, msg)
+	}
+
+	return nil
+}
+
+// encryptPush encrypts payload for a subscription with the aes128gcm
+// content encoding (RFC 8291)
+func encryptPush(sub data.PushSubscription, payload []byte) ([]byte, error) {
+	uaPublic, err := b64.DecodeString(sub.Keys.P256dh)
+	if err != nil {
+		return nil, fmt.Errorf("invalid subscription key: %v", err)
+	}
+
+	authSecret, err := b64.DecodeString(sub.Keys.Auth)
+	if err != nil {
+		return nil, fmt.Errorf("invalid subscription auth secret: %v", err)
+	}
+
+	curve := elliptic.P256()
+	uaX, uaY := elliptic.Unmarshal(curve, uaPublic)
+	if uaX == nil {
+		return nil, errors.New("invalid subscription key")
+	}
+
+	// ephemeral application server key pair
+	asKey, err := ecdsa.GenerateKey(curve, rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	asPublic := elliptic.Marshal(curve, asKey.X, asKey.Y)
+
+	sx, _ := curve.ScalarMult(uaX, uaY, asKey.D.Bytes())
+	ecdhSecret := make([]byte, 32)
+	sx.FillBytes(ecdhSecret)
+
+	keyInfo := append([]byte("WebPush: info\x00"), uaPublic...)
+	keyInfo = append(keyInfo, asPublic...)
+	ikm := make([]byte, 32)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, ecdhSecret, authSecret, keyInfo), ikm); err != nil {
+		return nil, err
+	}
+
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+
+	prk := hkdf.Extract(sha256.New, ikm, salt)
+	cek := make([]byte, 16)
+	if _, err := io.ReadFull(hkdf.Expand(sha256.New, prk, []byte("Content-Encoding: aes128gcm\x00")), cek); err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, 12)
+	if _, err := io.ReadFull(hkdf.Expand(sha256.New, prk, []byte("Content-Encoding: nonce\x00")), nonce); err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(cek)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	// a single record: payload followed by the last record delimiter
+	plain := append(append([]byte{}, payload...), 2)
+	if len(plain)+gcm.Overhead() > pushRecordSize {
+		return nil, errors.New("push payload is too large")
+	}
+
+	header := make([]byte, 0, 21+len(asPublic))
+	header = append(header, salt...)
+	rs := make([]byte, 4)
+	binary.BigEndian.PutUint32(rs, pushRecordSize)
+	header = append(header, rs...)
+	header = append(header, byte(len(asPublic)))
+	header = append(header, asPublic...)
+
+	return gcm.Seal(header, nonce, plain, nil), nil
+}
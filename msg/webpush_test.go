@@ -0,0 +1,117 @@
+package msg
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/simpleiot/simpleiot/data"
+	"golang.org/x/crypto/hkdf"
+)
+
+// decryptPush decrypts a push message like a browser does
+func decryptPush(t *testing.T, uaKey *ecdsa.PrivateKey, authSecret, body []byte) []byte {
+	salt := body[:16]
+	rs := binary.BigEndian.Uint32(body[16:20])
+	idLen := int(body[20])
+	asPublic := body[21 : 21+idLen]
+	if rs != pushRecordSize {
+		t.Fatal("wrong record size: ", rs)
+	}
+
+	curve := elliptic.P256()
+	asX, asY := elliptic.Unmarshal(curve, asPublic)
+	sx, _ := curve.ScalarMult(asX, asY, uaKey.D.Bytes())
+	ecdhSecret := make([]byte, 32)
+	sx.FillBytes(ecdhSecret)
+
+	uaPublic := elliptic.Marshal(curve, uaKey.X, uaKey.Y)
+	keyInfo := append([]byte("WebPush: info\x00"), uaPublic...)
+	keyInfo = append(keyInfo, asPublic...)
+	ikm := make([]byte, 32)
+	io.ReadFull(hkdf.New(sha256.New, ecdhSecret, authSecret, keyInfo), ikm)
+
+	prk := hkdf.Extract(sha256.New, ikm, salt)
+	cek := make([]byte, 16)
+	io.ReadFull(hkdf.Expand(sha256.New, prk, []byte("Content-Encoding: aes128gcm\x00")), cek)
+	nonce := make([]byte, 12)
+	io.ReadFull(hkdf.Expand(sha256.New, prk, []byte("Content-Encoding: nonce\x00")), nonce)
+
+	block, _ := aes.NewCipher(cek)
+	gcm, _ := cipher.NewGCM(block)
+	plain, err := gcm.Open(nil, nonce, body[21+idLen:], nil)
+	if err != nil {
+		t.Fatal("Error decrypting push message: ", err)
+	}
+
+	if plain[len(plain)-1] != 2 {
+		t.Fatal("missing last record delimiter")
+	}
+
+	return plain[:len(plain)-1]
+}
+
+func TestWebPush(t *testing.T) {
+	uaKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	authSecret := make([]byte, 16)
+	rand.Read(authSecret)
+
+	var body []byte
+	var header http.Header
+	status := http.StatusCreated
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ = io.ReadAll(r.Body)
+		header = r.Header
+		w.WriteHeader(status)
+	}))
+	defer ts.Close()
+
+	var sub data.PushSubscription
+	sub.Endpoint = ts.URL + "/push/abc"
+	sub.Keys.P256dh = b64.EncodeToString(elliptic.Marshal(elliptic.P256(), uaKey.X, uaKey.Y))
+	sub.Keys.Auth = b64.EncodeToString(authSecret)
+
+	pub, priv, err := GenerateVapidKeys()
+	if err != nil {
+		t.Fatal("Error generating VAPID keys: ", err)
+	}
+
+	wp, err := NewWebPush(pub, priv, "mailto:admin@example.com")
+	if err != nil {
+		t.Fatal("Error creating web push: ", err)
+	}
+
+	err = wp.Send(sub, []byte("hello"), "high")
+	if err != nil {
+		t.Fatal("Error sending push: ", err)
+	}
+
+	if got := decryptPush(t, uaKey, authSecret, body); string(got) != "hello" {
+		t.Fatal("wrong payload: ", string(got))
+	}
+
+	if header.Get("Content-Encoding") != "aes128gcm" || header.Get("Urgency") != "high" ||
+		!strings.HasSuffix(header.Get("Authorization"), "k="+pub) {
+		t.Fatal("unexpected headers: ", header)
+	}
+
+	status = http.StatusGone
+	if err := wp.Send(sub, []byte("hello"), ""); !errors.Is(err, ErrPushGone) {
+		t.Fatal("expected ErrPushGone, got: ", err)
+	}
+}
@@ -2,8 +2,12 @@ package node
 
 import (
 	"errors"
+	"fmt"
+	"math"
+	"strconv"
 
 	"github.com/simpleiot/simpleiot/data"
+	"github.com/simpleiot/simpleiot/modbus"
 )
 
 // ModbusIONode describes a modbus IO db node
@@ -14,9 +18,15 @@ type ModbusIONode struct {
 	address            int
 	modbusIOType       string
 	modbusDataType     string
+	byteOrder          string
 	readOnly           bool
 	scale              float64
 	offset             float64
+	polynomial         []float64
+	bitOffset          int
+	bitCount           int
+	pollPeriod         int
+	pollPriority       string
 	value              float64
 	valueSet           float64
 	disable            bool
@@ -63,16 +73,31 @@ func NewModbusIONode(busType string, node *data.NodeEdge) (*ModbusIONode, error)
 		if !ok {
 			return nil, errors.New("Data format must be specified")
 		}
-		ret.scale, ok = node.Points.Value(data.PointTypeScale, "")
-		if !ok {
-			return nil, errors.New("Must define modbus scale")
+		ret.byteOrder, _ = node.Points.Text(data.PointTypeByteOrder, "")
+		ret.bitOffset, _ = node.Points.ValueInt(data.PointTypeBitOffset, "")
+		ret.bitCount, _ = node.Points.ValueInt(data.PointTypeBitCount, "")
+
+		for _, p := range node.Points {
+			if p.Type == data.PointTypePolynomial {
+				ret.setPolynomial(p)
+			}
 		}
-		ret.offset, ok = node.Points.Value(data.PointTypeOffset, "")
-		if !ok {
-			return nil, errors.New("Must define modbus offset")
+
+		// scale and offset are not used if a polynomial is set
+		if len(ret.polynomial) <= 0 {
+			ret.scale, ok = node.Points.Value(data.PointTypeScale, "")
+			if !ok {
+				return nil, errors.New("Must define modbus scale")
+			}
+			ret.offset, ok = node.Points.Value(data.PointTypeOffset, "")
+			if !ok {
+				return nil, errors.New("Must define modbus offset")
+			}
 		}
 	}
 
+	ret.pollPeriod, _ = node.Points.ValueInt(data.PointTypePollPeriod, "")
+	ret.pollPriority, _ = node.Points.Text(data.PointTypePollPriority, "")
 	ret.value, _ = node.Points.Value(data.PointTypeValue, "")
 	ret.valueSet, _ = node.Points.Value(data.PointTypeValueSet, "")
 	ret.disable, _ = node.Points.ValueBool(data.PointTypeDisable, "")
@@ -93,8 +118,14 @@ func (io *ModbusIONode) Changed(newIO *ModbusIONode) bool {
 		io.address != newIO.address ||
 		io.modbusIOType != newIO.modbusIOType ||
 		io.modbusDataType != newIO.modbusDataType ||
+		io.byteOrder != newIO.byteOrder ||
 		io.scale != newIO.scale ||
 		io.offset != newIO.offset ||
+		len(io.polynomial) != len(newIO.polynomial) ||
+		io.bitOffset != newIO.bitOffset ||
+		io.bitCount != newIO.bitCount ||
+		io.pollPeriod != newIO.pollPeriod ||
+		io.pollPriority != newIO.pollPriority ||
 		io.value != newIO.value ||
 		io.valueSet != newIO.valueSet ||
 		io.errorCountReset != newIO.errorCountReset ||
@@ -103,5 +134,183 @@ func (io *ModbusIONode) Changed(newIO *ModbusIONode) bool {
 		return true
 	}
 
+	for i := range io.polynomial {
+		if io.polynomial[i] != newIO.polynomial[i] {
+			return true
+		}
+	}
+
 	return false
 }
+
+// setPolynomial sets a scaling polynomial coefficient. The point key is the
+// power of the coefficient, and a blank key is the constant. Deleted points
+// clear the coefficient.
+func (io *ModbusIONode) setPolynomial(p data.Point) {
+	power := 0
+	if p.Key != "" {
+		var err error
+		power, err = strconv.Atoi(p.Key)
+		if err != nil || power < 0 {
+			return
+		}
+	}
+
+	for len(io.polynomial) <= power {
+		io.polynomial = append(io.polynomial, 0)
+	}
+
+	if p.Tombstone != 0 {
+		io.polynomial[power] = 0
+	} else {
+		io.polynomial[power] = p.Value
+	}
+
+	// trailing zero coefficients do not change the value
+	for len(io.polynomial) > 0 && io.polynomial[len(io.polynomial)-1] == 0 {
+		io.polynomial = io.polynomial[:len(io.polynomial)-1]
+	}
+}
+
+// scaleValue converts a raw register value to the IO value
+func (io *ModbusIONode) scaleValue(raw float64) float64 {
+	if len(io.polynomial) <= 0 {
+		return raw*io.scale + io.offset
+	}
+
+	ret := 0.0
+	for i := len(io.polynomial) - 1; i >= 0; i-- {
+		ret = ret*raw + io.polynomial[i]
+	}
+
+	return ret
+}
+
+// unscaleValue converts an IO value to a raw register value. Only linear
+// polynomials can be inverted.
+func (io *ModbusIONode) unscaleValue(v float64) (float64, error) {
+	if len(io.polynomial) <= 0 {
+		return (v - io.offset) / io.scale, nil
+	}
+
+	if len(io.polynomial) != 2 {
+		return 0, errors.New("only linear scaling polynomials can be written")
+	}
+
+	return (v - io.polynomial[0]) / io.polynomial[1], nil
+}
+
+// reorder converts regs between the IO byte order and big endian (ABCD)
+func (io *ModbusIONode) reorder(regs []uint16) ([]uint16, error) {
+	switch io.byteOrder {
+	case "", data.PointValueABCD:
+		return regs, nil
+	case data.PointValueBADC:
+		return modbus.ReorderRegs(regs, true, false), nil
+	case data.PointValueCDAB:
+		return modbus.ReorderRegs(regs, false, true), nil
+	case data.PointValueDCBA:
+		return modbus.ReorderRegs(regs, true, true), nil
+	default:
+		return nil, fmt.Errorf("unhandled byte order: %v", io.byteOrder)
+	}
+}
+
+// regsToValue converts regs read from a device to a scaled IO value. If a
+// bitfield is set, the field is extracted from integer data types before
+// scaling.
+func (io *ModbusIONode) regsToValue(regs []uint16) (float64, error) {
+	if len(regs) < regCount(io.modbusDataType) {
+		return 0, errors.New("Did not receive enough data")
+	}
+
+	regs, err := io.reorder(regs[:regCount(io.modbusDataType)])
+	if err != nil {
+		return 0, err
+	}
+
+	var raw float64
+	var bits uint64
+	isInt := true
+
+	switch io.modbusDataType {
+	case data.PointValueUINT16:
+		bits = uint64(regs[0])
+		raw = float64(regs[0])
+	case data.PointValueINT16:
+		bits = uint64(regs[0])
+		raw = float64(int16(regs[0]))
+	case data.PointValueUINT32:
+		v := modbus.RegsToUint32(regs)[0]
+		bits = uint64(v)
+		raw = float64(v)
+	case data.PointValueINT32:
+		v := modbus.RegsToInt32(regs)[0]
+		bits = uint64(uint32(v))
+		raw = float64(v)
+	case data.PointValueUINT64:
+		bits = modbus.RegsToUint64(regs)[0]
+		raw = float64(bits)
+	case data.PointValueINT64:
+		v := modbus.RegsToInt64(regs)[0]
+		bits = uint64(v)
+		raw = float64(v)
+	case data.PointValueFLOAT32:
+		isInt = false
+		raw = float64(modbus.RegsToFloat32(regs)[0])
+	case data.PointValueFLOAT64:
+		isInt = false
+		raw = modbus.RegsToFloat64(regs)[0]
+	default:
+		return 0, fmt.Errorf("unhandled data type: %v",
+			io.modbusDataType)
+	}
+
+	if io.bitCount > 0 {
+		if !isInt {
+			return 0, errors.New("bitfields require an integer data type")
+		}
+		mask := uint64(1)<<uint(io.bitCount) - 1
+		raw = float64((bits >> uint(io.bitOffset)) & mask)
+	}
+
+	return io.scaleValue(raw), nil
+}
+
+// valueToRegs converts an IO value to regs to write to a device
+func (io *ModbusIONode) valueToRegs(v float64) ([]uint16, error) {
+	if io.bitCount > 0 {
+		return nil, errors.New("writing bitfields is not supported")
+	}
+
+	raw, err := io.unscaleValue(v)
+	if err != nil {
+		return nil, err
+	}
+
+	var regs []uint16
+
+	switch io.modbusDataType {
+	case data.PointValueUINT16:
+		regs = []uint16{uint16(math.Round(raw))}
+	case data.PointValueINT16:
+		regs = []uint16{uint16(int16(math.Round(raw)))}
+	case data.PointValueUINT32:
+		regs = modbus.Uint32ToRegs([]uint32{uint32(math.Round(raw))})
+	case data.PointValueINT32:
+		regs = modbus.Int32ToRegs([]int32{int32(math.Round(raw))})
+	case data.PointValueUINT64:
+		regs = modbus.Uint64ToRegs([]uint64{uint64(math.Round(raw))})
+	case data.PointValueINT64:
+		regs = modbus.Int64ToRegs([]int64{int64(math.Round(raw))})
+	case data.PointValueFLOAT32:
+		regs = modbus.Float32ToRegs([]float32{float32(raw)})
+	case data.PointValueFLOAT64:
+		regs = modbus.Float64ToRegs([]float64{raw})
+	default:
+		return nil, fmt.Errorf("unhandled data type: %v",
+			io.modbusDataType)
+	}
+
+	return io.reorder(regs)
+}
@@ -0,0 +1,98 @@
+package node
+
+import (
+	"testing"
+
+	"github.com/simpleiot/simpleiot/data"
+)
+
+func TestModbusIORegsToValue(t *testing.T) {
+	tests := []struct {
+		name string
+		io   ModbusIONode
+		regs []uint16
+		exp  float64
+	}{
+		{"int16", ModbusIONode{modbusDataType: data.PointValueINT16, scale: 1},
+			[]uint16{0xffff}, -1},
+		{"uint32 scaled", ModbusIONode{modbusDataType: data.PointValueUINT32,
+			scale: 0.1, offset: 10}, []uint16{0x0001, 0x0000}, 6563.6},
+		{"float32 CDAB", ModbusIONode{modbusDataType: data.PointValueFLOAT32,
+			byteOrder: data.PointValueCDAB, scale: 1}, []uint16{0xd70a, 0x3c23}, float64(float32(0.01))},
+		{"float32 DCBA", ModbusIONode{modbusDataType: data.PointValueFLOAT32,
+			byteOrder: data.PointValueDCBA, scale: 1}, []uint16{0x0ad7, 0x233c}, float64(float32(0.01))},
+		{"int64", ModbusIONode{modbusDataType: data.PointValueINT64, scale: 1},
+			[]uint16{0xffff, 0xffff, 0xffff, 0xfffe}, -2},
+		{"float64 BADC", ModbusIONode{modbusDataType: data.PointValueFLOAT64,
+			byteOrder: data.PointValueBADC, scale: 1},
+			[]uint16{0x843f, 0xe17a, 0xae47, 0x7b14}, 0.01},
+		{"polynomial", ModbusIONode{modbusDataType: data.PointValueUINT16,
+			polynomial: []float64{1, 2, 0.5}}, []uint16{4}, 17},
+		{"bitfield", ModbusIONode{modbusDataType: data.PointValueUINT16,
+			bitOffset: 4, bitCount: 3, scale: 1}, []uint16{0x00d0}, 5},
+	}
+
+	for _, test := range tests {
+		v, err := test.io.regsToValue(test.regs)
+		if err != nil {
+			t.Errorf("%v: error: %v", test.name, err)
+			continue
+		}
+		if v != test.exp {
+			t.Errorf("%v: got %v, exp %v", test.name, v, test.exp)
+		}
+
+		if test.io.bitCount > 0 || len(test.io.polynomial) > 2 {
+			continue
+		}
+
+		regs, err := test.io.valueToRegs(v)
+		if err != nil {
+			t.Errorf("%v: error writing: %v", test.name, err)
+			continue
+		}
+
+		for i := range regs {
+			if regs[i] != test.regs[i] {
+				t.Errorf("%v: write got %x, exp %x", test.name, regs, test.regs)
+				break
+			}
+		}
+	}
+}
+
+func TestModbusIOWriteErrors(t *testing.T) {
+	io := ModbusIONode{modbusDataType: data.PointValueUINT16,
+		polynomial: []float64{1, 2, 0.5}}
+	if _, err := io.valueToRegs(10); err == nil {
+		t.Error("expected error writing non-linear polynomial")
+	}
+
+	io = ModbusIONode{modbusDataType: data.PointValueUINT16, scale: 1,
+		bitCount: 1}
+	if _, err := io.valueToRegs(1); err == nil {
+		t.Error("expected error writing bitfield")
+	}
+
+	io = ModbusIONode{modbusDataType: data.PointValueFLOAT32, scale: 1,
+		bitCount: 1}
+	if _, err := io.regsToValue([]uint16{0, 0}); err == nil {
+		t.Error("expected error reading float bitfield")
+	}
+}
+
+func TestModbusIOSetPolynomial(t *testing.T) {
+	var io ModbusIONode
+	io.setPolynomial(data.Point{Type: data.PointTypePolynomial, Key: "2", Value: 3})
+	io.setPolynomial(data.Point{Type: data.PointTypePolynomial, Value: 1})
+
+	if len(io.polynomial) != 3 || io.polynomial[0] != 1 || io.polynomial[2] != 3 {
+		t.Fatal("wrong polynomial: ", io.polynomial)
+	}
+
+	io.setPolynomial(data.Point{Type: data.PointTypePolynomial, Key: "2", Tombstone: 1})
+
+	if len(io.polynomial) != 1 {
+		t.Fatal("trailing coefficient not removed: ", io.polynomial)
+	}
+}
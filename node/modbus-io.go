@@ -13,6 +13,9 @@ type ModbusIO struct {
 	ioNode   *ModbusIONode
 	sub      *nats.Subscription
 	lastSent time.Time
+	lastPoll time.Time
+	// quality of the last value sent, blank if good
+	quality string
 }
 
 // NewModbusIO creates a new modbus IO
@@ -0,0 +1,132 @@
+package node
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net"
+	"sort"
+	"time"
+
+	"github.com/simpleiot/simpleiot/data"
+)
+
+// modbusLowPriorityScans is the number of bus scans between reads of low
+// priority IOs that do not set a poll period
+const modbusLowPriorityScans = 10
+
+// modbusBackoffMax is the longest time a device that does not respond is
+// skipped
+const modbusBackoffMax = time.Minute
+
+// modbusBackoff tracks a device that does not respond. Reading a device that
+// is offline waits for the full timeout on each IO, which slows down the
+// scan of every other device on the bus, so the device is skipped for a
+// delay that doubles each time it fails to respond.
+type modbusBackoff struct {
+	delay time.Duration
+	until time.Time
+}
+
+// isModbusTimeout returns true if the error means the device did not respond
+func isModbusTimeout(err error) bool {
+	if errors.Is(err, io.EOF) {
+		return true
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+func pollPriorityRank(priority string) int {
+	switch priority {
+	case data.PointValueHigh:
+		return 0
+	case data.PointValueLow:
+		return 2
+	default:
+		return 1
+	}
+}
+
+// pollInterval returns how often the IO is read
+func (io *ModbusIONode) pollInterval(busPeriod time.Duration) time.Duration {
+	if io.pollPeriod > 0 {
+		return time.Millisecond * time.Duration(io.pollPeriod)
+	}
+
+	if io.pollPriority == data.PointValueLow {
+		return busPeriod * modbusLowPriorityScans
+	}
+
+	return busPeriod
+}
+
+// pollDue returns true if the IO should be read in the scan at now. IOs are
+// only read during bus scans, so half a bus period of jitter is allowed.
+func (io *ModbusIO) pollDue(now time.Time, busPeriod time.Duration) bool {
+	if io.lastPoll.IsZero() {
+		return true
+	}
+
+	return now.Sub(io.lastPoll) >= io.ioNode.pollInterval(busPeriod)-busPeriod/2
+}
+
+// scanIOs returns the client IOs to read in a scan, high priority first
+func (b *Modbus) scanIOs(now time.Time) []*ModbusIO {
+	busPeriod := time.Millisecond * time.Duration(b.busNode.pollPeriod)
+
+	var ret []*ModbusIO
+	for _, io := range b.ios {
+		if io.ioNode.disable || !io.pollDue(now, busPeriod) {
+			continue
+		}
+		ret = append(ret, io)
+	}
+
+	sort.Slice(ret, func(i, j int) bool {
+		ri := pollPriorityRank(ret[i].ioNode.pollPriority)
+		rj := pollPriorityRank(ret[j].ioNode.pollPriority)
+		if ri != rj {
+			return ri < rj
+		}
+		return ret[i].ioNode.nodeID < ret[j].ioNode.nodeID
+	})
+
+	return ret
+}
+
+// deviceSkipped returns true if the device with the modbus ID is backed off
+func (b *Modbus) deviceSkipped(id int, now time.Time) bool {
+	bo, ok := b.backoffs[id]
+	return ok && now.Before(bo.until)
+}
+
+// deviceTimeout backs off a device that did not respond
+func (b *Modbus) deviceTimeout(id int, now time.Time) {
+	bo, ok := b.backoffs[id]
+	if !ok {
+		bo = &modbusBackoff{
+			delay: time.Millisecond * time.Duration(b.busNode.pollPeriod),
+		}
+		b.backoffs[id] = bo
+	} else {
+		bo.delay *= 2
+	}
+
+	if bo.delay > modbusBackoffMax {
+		bo.delay = modbusBackoffMax
+	}
+
+	bo.until = now.Add(bo.delay)
+
+	if b.busNode.debugLevel >= 1 {
+		log.Printf("Modbus %v: device %v not responding, skipping for %v\n",
+			b.busNode.portName, id, bo.delay)
+	}
+}
+
+// deviceResponded clears the back off for a device
+func (b *Modbus) deviceResponded(id int) {
+	delete(b.backoffs, id)
+}
@@ -0,0 +1,84 @@
+package node
+
+import (
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/simpleiot/simpleiot/data"
+)
+
+func TestModbusScanIOs(t *testing.T) {
+	b := &Modbus{
+		busNode: &ModbusNode{pollPeriod: 100},
+		ios: map[string]*ModbusIO{
+			"a": {ioNode: &ModbusIONode{nodeID: "a"}},
+			"b": {ioNode: &ModbusIONode{nodeID: "b", pollPriority: data.PointValueHigh}},
+			"c": {ioNode: &ModbusIONode{nodeID: "c", pollPriority: data.PointValueLow}},
+			"d": {ioNode: &ModbusIONode{nodeID: "d", pollPeriod: 1000}},
+			"e": {ioNode: &ModbusIONode{nodeID: "e", disable: true}},
+		},
+		backoffs: make(map[int]*modbusBackoff),
+	}
+
+	scan := func(now time.Time) string {
+		ret := ""
+		for _, io := range b.scanIOs(now) {
+			ret += io.ioNode.nodeID
+			io.lastPoll = now
+		}
+		return ret
+	}
+
+	start := time.Now()
+
+	if s := scan(start); s != "badc" {
+		t.Fatal("first scan should read all enabled IOs by priority, got: ", s)
+	}
+
+	// a little early, still due because of jitter allowance
+	if s := scan(start.Add(90 * time.Millisecond)); s != "ba" {
+		t.Fatal("second scan got: ", s)
+	}
+
+	if s := scan(start.Add(1090 * time.Millisecond)); s != "badc" {
+		t.Fatal("scan after 1s got: ", s)
+	}
+}
+
+func TestModbusBackoff(t *testing.T) {
+	b := &Modbus{
+		busNode:  &ModbusNode{pollPeriod: 100},
+		backoffs: make(map[int]*modbusBackoff),
+	}
+
+	now := time.Now()
+
+	b.deviceTimeout(1, now)
+	if !b.deviceSkipped(1, now.Add(50*time.Millisecond)) {
+		t.Fatal("device should be skipped")
+	}
+	if b.deviceSkipped(1, now.Add(150*time.Millisecond)) {
+		t.Fatal("device should not be skipped after delay")
+	}
+	if b.deviceSkipped(2, now) {
+		t.Fatal("other devices should not be skipped")
+	}
+
+	for i := 0; i < 20; i++ {
+		b.deviceTimeout(1, now)
+	}
+	if b.backoffs[1].delay != modbusBackoffMax {
+		t.Fatal("backoff should be limited, got: ", b.backoffs[1].delay)
+	}
+
+	b.deviceResponded(1)
+	if b.deviceSkipped(1, now) {
+		t.Fatal("device should not be skipped after it responds")
+	}
+
+	if !isModbusTimeout(fmt.Errorf("read: %w", io.EOF)) {
+		t.Error("EOF should be a timeout")
+	}
+}
@@ -0,0 +1,131 @@
+package node
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/simpleiot/simpleiot/client"
+	"github.com/simpleiot/simpleiot/data"
+)
+
+// modbusWriteTries is the number of times a write is tried before it fails
+const modbusWriteTries = 3
+
+// modbusWrite is a pending write of the valueSet of an IO to a device. The
+// latest valueSet is written, so a new setpoint replaces a queued write.
+type modbusWrite struct {
+	io    *ModbusIO
+	tries int
+	next  time.Time
+}
+
+// writable returns true if valueSet points of the IO are written to the device
+func (io *ModbusIONode) writable() bool {
+	return !io.readOnly && (io.modbusIOType == data.PointValueModbusCoil ||
+		io.modbusIOType == data.PointValueModbusHoldingRegister)
+}
+
+// queueWrite queues a write of the IO valueSet and flags the write pending
+func (b *Modbus) queueWrite(io *ModbusIO) {
+	b.sendWriteStatus(io.ioNode, data.PointValuePending)
+
+	for _, w := range b.writes {
+		if w.io == io {
+			w.tries = 0
+			w.next = time.Time{}
+			return
+		}
+	}
+
+	b.writes = append(b.writes, &modbusWrite{io: io})
+}
+
+// dequeueWrite removes any queued write of the IO
+func (b *Modbus) dequeueWrite(io *ModbusIO) {
+	for i, w := range b.writes {
+		if w.io == io {
+			b.writes = append(b.writes[:i], b.writes[i+1:]...)
+			return
+		}
+	}
+}
+
+// processWrites writes queued values to devices. Write status points are
+// sent when a write is confirmed by the device or fails after all tries.
+func (b *Modbus) processWrites(now time.Time) {
+	var retry []*modbusWrite
+
+	for _, w := range b.writes {
+		if now.Before(w.next) || b.deviceSkipped(w.io.ioNode.id, now) {
+			retry = append(retry, w)
+			continue
+		}
+
+		err := b.writeIO(w.io.ioNode)
+		if err == nil {
+			b.deviceResponded(w.io.ioNode.id)
+			w.io.ioNode.value = w.io.ioNode.valueSet
+			err := b.SendPoint(w.io.ioNode.nodeID, data.PointTypeValue,
+				w.io.ioNode.valueSet)
+			if err != nil {
+				log.Println("Error sending modbus write value: ", err)
+			}
+			b.sendWriteStatus(w.io.ioNode, data.PointValueConfirmed)
+			continue
+		}
+
+		if isModbusTimeout(err) {
+			b.deviceTimeout(w.io.ioNode.id, now)
+		}
+
+		err = b.LogError(w.io.ioNode, err)
+		if err != nil {
+			log.Println("Error logging modbus error: ", err)
+		}
+
+		w.tries++
+		if w.tries >= modbusWriteTries {
+			b.sendWriteStatus(w.io.ioNode, data.PointValueWriteFailed)
+			continue
+		}
+
+		w.next = now.Add(time.Millisecond *
+			time.Duration(b.busNode.pollPeriod*w.tries))
+		retry = append(retry, w)
+	}
+
+	b.writes = retry
+}
+
+// writeIO writes the IO valueSet to the device. The write response from the
+// device echoes the value written, which confirms the write.
+func (b *Modbus) writeIO(io *ModbusIONode) error {
+	if b.client == nil {
+		return errors.New("client is not set up")
+	}
+
+	switch io.modbusIOType {
+	case data.PointValueModbusCoil:
+		return b.client.WriteSingleCoil(byte(io.id), uint16(io.address),
+			data.FloatToBool(io.valueSet))
+	case data.PointValueModbusHoldingRegister:
+		return b.WriteBusHoldingReg(io)
+	default:
+		return fmt.Errorf("can't write modbus io type: %v", io.modbusIOType)
+	}
+}
+
+func (b *Modbus) sendWriteStatus(io *ModbusIONode, status string) {
+	p := data.Point{
+		Time: time.Now(),
+		Type: data.PointTypeWriteStatus,
+		Text: status,
+	}
+
+	err := client.SendNodePoint(b.nc, io.nodeID, p, false)
+	if err != nil {
+		log.Println("Error sending modbus write status: ", err)
+	}
+}
@@ -0,0 +1,47 @@
+package node
+
+import (
+	"testing"
+
+	"github.com/simpleiot/simpleiot/data"
+)
+
+func TestModbusIOWritable(t *testing.T) {
+	tests := []struct {
+		io  ModbusIONode
+		exp bool
+	}{
+		{ModbusIONode{modbusIOType: data.PointValueModbusCoil}, true},
+		{ModbusIONode{modbusIOType: data.PointValueModbusHoldingRegister}, true},
+		{ModbusIONode{modbusIOType: data.PointValueModbusHoldingRegister,
+			readOnly: true}, false},
+		{ModbusIONode{modbusIOType: data.PointValueModbusInputRegister}, false},
+		{ModbusIONode{modbusIOType: data.PointValueModbusDiscreteInput}, false},
+	}
+
+	for _, test := range tests {
+		if test.io.writable() != test.exp {
+			t.Errorf("%v read only %v: exp writable %v", test.io.modbusIOType,
+				test.io.readOnly, test.exp)
+		}
+	}
+}
+
+func TestModbusDequeueWrite(t *testing.T) {
+	a := &ModbusIO{ioNode: &ModbusIONode{nodeID: "a"}}
+	b := &ModbusIO{ioNode: &ModbusIONode{nodeID: "b"}}
+
+	bus := &Modbus{writes: []*modbusWrite{{io: a}, {io: b}}}
+
+	bus.dequeueWrite(a)
+
+	if len(bus.writes) != 1 || bus.writes[0].io != b {
+		t.Fatal("wrong write removed")
+	}
+
+	bus.dequeueWrite(a)
+
+	if len(bus.writes) != 1 {
+		t.Fatal("removing IO without write changed queue")
+	}
+}
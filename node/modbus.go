@@ -42,6 +42,10 @@ type Modbus struct {
 	server       server
 	serialPort   serial.Port
 	ioErrorCount int
+	// devices that are not responding, by modbus ID
+	backoffs map[int]*modbusBackoff
+	// queued writes to client devices
+	writes []*modbusWrite
 
 	chDone      chan bool
 	chPoint     chan pointWID
@@ -55,6 +59,7 @@ func NewModbus(nc *nats.Conn, node data.NodeEdge) (*Modbus, error) {
 		nc:          nc,
 		node:        node,
 		ios:         make(map[string]*ModbusIO),
+		backoffs:    make(map[int]*modbusBackoff),
 		chDone:      make(chan bool),
 		chPoint:     make(chan pointWID),
 		chRegChange: make(chan bool),
@@ -133,6 +138,11 @@ func (b *Modbus) CheckIOs() error {
 			}
 			b.ios[node.ID] = io
 			b.InitRegs(io.ioNode)
+
+			if b.busNode.busType == data.PointValueClient &&
+				io.ioNode.writable() && io.ioNode.valueSet != io.ioNode.value {
+				b.queueWrite(io)
+			}
 		}
 	}
 
@@ -143,6 +153,7 @@ func (b *Modbus) CheckIOs() error {
 			// io was deleted so close and clear it
 			log.Println("modbus io removed: ", io.ioNode.description)
 			io.Stop()
+			b.dequeueWrite(io)
 			delete(b.ios, id)
 		}
 	}
@@ -165,60 +176,17 @@ func (b *Modbus) SendPoint(nodeID, pointType string, value float64) error {
 // WriteBusHoldingReg used to write register values to bus
 // should only be used by client
 func (b *Modbus) WriteBusHoldingReg(io *ModbusIONode) error {
-	unscaledValue := (io.valueSet - io.offset) / io.scale
-	switch io.modbusDataType {
-	case data.PointValueUINT16, data.PointValueINT16:
-		err := b.client.WriteSingleReg(byte(io.id),
-			uint16(io.address), uint16(unscaledValue))
-		if err != nil {
-			return err
-		}
-	case data.PointValueUINT32:
-		regs := modbus.Uint32ToRegs([]uint32{uint32(unscaledValue)})
-		err := b.client.WriteSingleReg(byte(io.id),
-			uint16(io.address), regs[0])
-		if err != nil {
-			return err
-		}
-
-		err = b.client.WriteSingleReg(byte(io.id),
-			uint16(io.address+1), regs[1])
-		if err != nil {
-			return err
-		}
-
-	case data.PointValueINT32:
-		regs := modbus.Int32ToRegs([]int32{int32(unscaledValue)})
-		err := b.client.WriteSingleReg(byte(io.id),
-			uint16(io.address), regs[0])
-		if err != nil {
-			return err
-		}
-
-		err = b.client.WriteSingleReg(byte(io.id),
-			uint16(io.address+1), regs[1])
-		if err != nil {
-			return err
-		}
+	regs, err := io.valueToRegs(io.valueSet)
+	if err != nil {
+		return err
+	}
 
-	case data.PointValueFLOAT32:
-		regs := modbus.Float32ToRegs([]float32{float32(unscaledValue)})
+	for i, reg := range regs {
 		err := b.client.WriteSingleReg(byte(io.id),
-			uint16(io.address), regs[0])
-		if err != nil {
-			return err
-		}
-
-		err = b.client.WriteSingleReg(byte(io.id),
-			uint16(io.address+1), regs[1])
+			uint16(io.address+i), reg)
 		if err != nil {
 			return err
 		}
-
-	default:
-		return fmt.Errorf("unhandled data type: %v",
-			io.modbusDataType)
-
 	}
 
 	return nil
@@ -236,65 +204,25 @@ func (b *Modbus) ReadBusReg(io *ModbusIO) error {
 		return fmt.Errorf("ReadBusReg: unsupported modbus IO type: %v",
 			io.ioNode.modbusIOType)
 	}
-	var valueUnscaled float64
-	switch io.ioNode.modbusDataType {
-	case data.PointValueUINT16, data.PointValueINT16:
-		regs, err := readFunc(byte(io.ioNode.id), uint16(io.ioNode.address), 1)
-		if err != nil {
-			return err
-		}
-		if len(regs) < 1 {
-			return errors.New("Did not receive enough data")
-		}
-		valueUnscaled = float64(regs[0])
-
-	case data.PointValueUINT32:
-		regs, err := readFunc(byte(io.ioNode.id), uint16(io.ioNode.address), 2)
-		if err != nil {
-			return err
-		}
-		if len(regs) < 2 {
-			return errors.New("Did not receive enough data")
-		}
-		v := modbus.RegsToUint32(regs)
-
-		valueUnscaled = float64(v[0])
-
-	case data.PointValueINT32:
-		regs, err := readFunc(byte(io.ioNode.id), uint16(io.ioNode.address), 2)
-		if err != nil {
-			return err
-		}
-		if len(regs) < 2 {
-			return errors.New("Did not receive enough data")
-		}
-		v := modbus.RegsToInt32(regs)
-
-		valueUnscaled = float64(v[0])
-
-	case data.PointValueFLOAT32:
-		regs, err := readFunc(byte(io.ioNode.id), uint16(io.ioNode.address), 2)
-		if err != nil {
-			return err
-		}
-		if len(regs) < 2 {
-			return errors.New("Did not receive enough data")
-		}
-		valueUnscaled = float64(modbus.RegsToFloat32(regs)[0])
-
-	default:
-		return fmt.Errorf("unhandled data type: %v",
-			io.ioNode.modbusDataType)
+	regs, err := readFunc(byte(io.ioNode.id), uint16(io.ioNode.address),
+		uint16(regCount(io.ioNode.modbusDataType)))
+	if err != nil {
+		return err
 	}
 
-	value := valueUnscaled*io.ioNode.scale + io.ioNode.offset
+	value, err := io.ioNode.regsToValue(regs)
+	if err != nil {
+		return err
+	}
 
-	if value != io.ioNode.value || time.Since(io.lastSent) > time.Minute*10 {
+	if value != io.ioNode.value || time.Since(io.lastSent) > time.Minute*10 ||
+		io.quality != "" {
 		io.ioNode.value = value
 		err := b.SendPoint(io.ioNode.nodeID, data.PointTypeValue, value)
 		if err != nil {
 			return err
 		}
+		io.quality = ""
 		io.lastSent = time.Now()
 	}
 
@@ -323,12 +251,14 @@ func (b *Modbus) ReadBusBit(io *ModbusIO) error {
 
 	value := data.BoolToFloat(bits[0])
 
-	if value != io.ioNode.value || time.Since(io.lastSent) > time.Minute*10 {
+	if value != io.ioNode.value || time.Since(io.lastSent) > time.Minute*10 ||
+		io.quality != "" {
 		io.ioNode.value = value
 		err := b.SendPoint(io.ioNode.nodeID, data.PointTypeValue, value)
 		if err != nil {
 			return err
 		}
+		io.quality = ""
 
 		io.lastSent = time.Now()
 	}
@@ -347,28 +277,13 @@ func (b *Modbus) ClientIO(io *ModbusIO) error {
 
 	// read value from remote device and update regs
 	switch io.ioNode.modbusIOType {
+	// writes are handled by the write queue
 	case data.PointValueModbusCoil:
 		err := b.ReadBusBit(io)
 		if err != nil {
 			return err
 		}
 
-		if !io.ioNode.readOnly && io.ioNode.valueSet != io.ioNode.value {
-			vBool := data.FloatToBool(io.ioNode.valueSet)
-			// we need set the remote value
-			err := b.client.WriteSingleCoil(byte(io.ioNode.id), uint16(io.ioNode.address),
-				vBool)
-
-			if err != nil {
-				return err
-			}
-
-			err = b.SendPoint(io.ioNode.nodeID, data.PointTypeValue, io.ioNode.valueSet)
-			if err != nil {
-				return err
-			}
-		}
-
 	case data.PointValueModbusDiscreteInput:
 		err := b.ReadBusBit(io)
 		if err != nil {
@@ -381,20 +296,6 @@ func (b *Modbus) ClientIO(io *ModbusIO) error {
 			return err
 		}
 
-		if !io.ioNode.readOnly && io.ioNode.valueSet != io.ioNode.value {
-			// we need set the remote value
-			err := b.WriteBusHoldingReg(io.ioNode)
-
-			if err != nil {
-				return err
-			}
-
-			err = b.SendPoint(io.ioNode.nodeID, data.PointTypeValue, io.ioNode.valueSet)
-			if err != nil {
-				return err
-			}
-		}
-
 	case data.PointValueModbusInputRegister:
 		err := b.ReadBusReg(io)
 		if err != nil {
@@ -459,6 +360,9 @@ func regCount(regType string) int {
 	case data.PointValueUINT32, data.PointValueINT32,
 		data.PointValueFLOAT32:
 		return 2
+	case data.PointValueUINT64, data.PointValueINT64,
+		data.PointValueFLOAT64:
+		return 4
 	default:
 		log.Println("regCount, unknown data type: ", regType)
 		// be conservative
@@ -493,60 +397,23 @@ func (b *Modbus) InitRegs(io *ModbusIONode) {
 // ReadReg reads an value from a reg (internal, not bus)
 // This should only be used on server
 func (b *Modbus) ReadReg(io *ModbusIONode) (float64, error) {
-	var valueUnscaled float64
-	switch io.modbusDataType {
-	case data.PointValueUINT16, data.PointValueINT16:
-		v, err := b.regs.ReadReg(io.address)
-		if err != nil {
-			return 0, err
-		}
-		valueUnscaled = float64(v)
-	case data.PointValueUINT32:
-		v, err := b.regs.ReadRegUint32(io.address)
-		if err != nil {
-			return 0, err
-		}
-		valueUnscaled = float64(v)
-	case data.PointValueINT32:
-		v, err := b.regs.ReadRegInt32(io.address)
-		if err != nil {
-			return 0, err
-		}
-		valueUnscaled = float64(v)
-	case data.PointValueFLOAT32:
-		v, err := b.regs.ReadRegFloat32(io.address)
-		if err != nil {
-			return 0, err
-		}
-		valueUnscaled = float64(v)
-	default:
-		return 0, fmt.Errorf("unhandled data type: %v",
-			io.modbusDataType)
+	regs, err := b.regs.ReadRegs(io.address, regCount(io.modbusDataType))
+	if err != nil {
+		return 0, err
 	}
-	return valueUnscaled*io.scale + io.offset, nil
+
+	return io.regsToValue(regs)
 }
 
 // WriteReg writes an io value to a reg
 // This should only be used on server
 func (b *Modbus) WriteReg(io *ModbusIONode) error {
-	unscaledValue := (io.value - io.offset) / io.scale
-	switch io.modbusDataType {
-	case data.PointValueUINT16, data.PointValueINT16:
-		b.regs.WriteReg(io.address, uint16(unscaledValue))
-	case data.PointValueUINT32:
-		b.regs.WriteRegUint32(io.address,
-			uint32(unscaledValue))
-	case data.PointValueINT32:
-		b.regs.WriteRegInt32(io.address,
-			int32(unscaledValue))
-	case data.PointValueFLOAT32:
-		b.regs.WriteRegFloat32(io.address,
-			float32(unscaledValue))
-	default:
-		return fmt.Errorf("unhandled data type: %v",
-			io.modbusDataType)
+	regs, err := io.valueToRegs(io.value)
+	if err != nil {
+		return err
 	}
-	return nil
+
+	return b.regs.WriteRegs(io.address, regs)
 }
 
 // LogError ...
@@ -606,6 +473,30 @@ func (b *Modbus) LogError(io *ModbusIONode, err error) error {
 	return client.SendNodePoint(b.nc, io.nodeID, p, false)
 }
 
+// MarkStale re-sends the last value of an IO flagged as stale after a read
+// fails, so that rules and history know the value is no longer current. The
+// flag is cleared by the next successful read.
+func (b *Modbus) MarkStale(io *ModbusIO) error {
+	if io.quality == data.PointQualityStale {
+		return nil
+	}
+
+	p := data.Point{
+		Time:    time.Now(),
+		Type:    data.PointTypeValue,
+		Value:   io.ioNode.value,
+		Quality: data.PointQualityStale,
+	}
+
+	err := client.SendNodePoint(b.nc, io.ioNode.nodeID, p, false)
+	if err != nil {
+		return err
+	}
+
+	io.quality = data.PointQualityStale
+	return nil
+}
+
 // ClosePort closes both the server and client ports
 func (b *Modbus) ClosePort() {
 	if b.server != nil {
@@ -830,6 +721,21 @@ func (b *Modbus) Run() {
 					io.ioNode.modbusIOType = p.Text
 				case data.PointTypeDataFormat:
 					io.ioNode.modbusDataType = p.Text
+					b.InitRegs(io.ioNode)
+				case data.PointTypeByteOrder:
+					io.ioNode.byteOrder = p.Text
+				case data.PointTypePolynomial:
+					io.ioNode.setPolynomial(p)
+				case data.PointTypeBitOffset:
+					io.ioNode.bitOffset = int(p.Value)
+				case data.PointTypeBitCount:
+					io.ioNode.bitCount = int(p.Value)
+				case data.PointTypePollPeriod:
+					io.ioNode.pollPeriod = int(p.Value)
+				case data.PointTypePollPriority:
+					io.ioNode.pollPriority = p.Text
+				case data.PointTypeWriteStatus:
+					// sent by the write queue
 				case data.PointTypeReadOnly:
 					io.ioNode.readOnly = data.FloatToBool(p.Value)
 				case data.PointTypeScale:
@@ -909,12 +815,11 @@ func (b *Modbus) Run() {
 				}
 
 				if valueSetModified && (b.busNode.busType == data.PointValueClient) &&
-					(io.ioNode.modbusDataType == data.PointValueModbusCoil ||
-						io.ioNode.modbusDataType == data.PointValueModbusHoldingRegister) &&
+					io.ioNode.writable() &&
 					(io.ioNode.value != io.ioNode.valueSet) {
-					err := b.ClientIO(io)
-					if err != nil {
-						b.LogError(io.ioNode, err)
+					b.queueWrite(io)
+					if !b.busNode.disable {
+						b.processWrites(time.Now())
 					}
 				}
 			}
@@ -959,17 +864,38 @@ func (b *Modbus) Run() {
 
 		case <-scanTimer.C:
 			if b.busNode.busType == data.PointValueClient && !b.busNode.disable {
-				for _, io := range b.ios {
-					if io.ioNode.disable {
+				b.processWrites(time.Now())
+
+				for _, io := range b.scanIOs(time.Now()) {
+					now := time.Now()
+					if b.deviceSkipped(io.ioNode.id, now) {
+						err := b.MarkStale(io)
+						if err != nil {
+							log.Println("Error sending modbus value quality: ", err)
+						}
 						continue
 					}
+
+					io.lastPoll = now
+
 					// for scanning, we only need to process client ios
 					err := b.ClientIO(io)
 					if err != nil {
+						if isModbusTimeout(err) {
+							b.deviceTimeout(io.ioNode.id, now)
+						}
+
 						err := b.LogError(io.ioNode, err)
 						if err != nil {
 							log.Println("Error logging modbus error: ", err)
 						}
+
+						err = b.MarkStale(io)
+						if err != nil {
+							log.Println("Error sending modbus value quality: ", err)
+						}
+					} else {
+						b.deviceResponded(io.ioNode.id)
 					}
 				}
 			}
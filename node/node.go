@@ -25,6 +25,7 @@ type Manager struct {
 	rootNodeID      string
 	oneWireManager  *oneWireManager
 	chStop          chan struct{}
+	chUpdate        chan struct{}
 }
 
 // NewManger creates a new Manager
@@ -34,6 +35,7 @@ func NewManger(nc *nats.Conn, appVersion, osVersionField string) *Manager {
 		appVersion:     appVersion,
 		osVersionField: osVersionField,
 		chStop:         make(chan struct{}),
+		chUpdate:       make(chan struct{}, 1),
 	}
 }
 
@@ -111,16 +113,10 @@ func (m *Manager) Start() error {
 		case <-m.chStop:
 			return errors.New("node manager stopping")
 		case <-t.C:
-			if m.modbusManager != nil {
-				m.modbusManager.Update()
-			}
-			if m.upstreamManager != nil {
-				m.upstreamManager.Update()
-			}
-			if m.oneWireManager != nil {
-				m.oneWireManager.update()
-			}
+			m.update()
 			t.Reset(time.Second * 20)
+		case <-m.chUpdate:
+			m.update()
 		}
 	}
 
@@ -156,6 +152,28 @@ func (m *Manager) Start() error {
 	*/
 }
 
+func (m *Manager) update() {
+	if m.modbusManager != nil {
+		m.modbusManager.Update()
+	}
+	if m.upstreamManager != nil {
+		m.upstreamManager.Update()
+	}
+	if m.oneWireManager != nil {
+		m.oneWireManager.update()
+	}
+}
+
+// Update checks for new or changed upstream, modbus, and 1-wire nodes now
+// instead of waiting for the next poll
+func (m *Manager) Update() {
+	select {
+	case m.chUpdate <- struct{}{}:
+	default:
+		// an update is already pending
+	}
+}
+
 // Stop manager
 func (m *Manager) Stop(_ error) {
 	close(m.chStop)
@@ -45,6 +45,7 @@ func (upm *UpstreamManager) Update() error {
 				continue
 			}
 			upm.upstreams[node.ID] = up
+			up.rotateCert()
 		} else {
 			// make sure none of the config has changed
 			upNode, err := NewUpstreamNode(node)
@@ -57,6 +58,8 @@ func (upm *UpstreamManager) Update() error {
 					log.Println("Restarting upstream: ", upNode.Description)
 					up.Stop()
 					delete(upm.upstreams, node.ID)
+				} else {
+					up.rotateCert()
 				}
 			}
 		}
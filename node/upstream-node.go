@@ -3,6 +3,7 @@ package node
 import (
 	"errors"
 
+	"github.com/simpleiot/simpleiot/client"
 	"github.com/simpleiot/simpleiot/data"
 )
 
@@ -13,6 +14,8 @@ type UpstreamNode struct {
 	URI         string
 	AuthToken   string
 	Disabled    bool
+	TLS         client.TLSOptions
+	CertAuto    bool
 }
 
 // NewUpstreamNode converts a node to UpstreamNode
@@ -26,6 +29,10 @@ func NewUpstreamNode(node data.NodeEdge) (*UpstreamNode, error) {
 	ret.Description, _ = node.Points.Text(data.PointTypeDescription, "")
 	ret.AuthToken, _ = node.Points.Text(data.PointTypeAuthToken, "")
 	ret.Disabled, _ = node.Points.ValueBool(data.PointTypeDisable, "")
+	ret.TLS.Cert, _ = node.Points.Text(data.PointTypeTLSCert, "")
+	ret.TLS.Key, _ = node.Points.Text(data.PointTypeTLSKey, "")
+	ret.TLS.CA, _ = node.Points.Text(data.PointTypeTLSCA, "")
+	ret.CertAuto, _ = node.Points.ValueBool(data.PointTypeCertAuto, "")
 
 	ret.URI, ok = node.Points.Text(data.PointTypeURI, "")
 	if !ok {
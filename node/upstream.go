@@ -3,9 +3,12 @@ package node
 import (
 	"bytes"
 	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
+	"os"
+	"path/filepath"
 	"sync"
 	"time"
 
@@ -23,10 +26,15 @@ type Upstream struct {
 	ncUp               *nats.Conn
 	subUpNodePoints    map[string]*nats.Subscription
 	subUpEdgePoints    map[string]*nats.Subscription
+	subUpHistory       map[string]*nats.Subscription
+	subUpErase         map[string]*nats.Subscription
 	subLocalNodePoints *nats.Subscription
 	subLocalEdgePoints *nats.Subscription
+	subLocalCreate     *nats.Subscription
+	subLocalErase      *nats.Subscription
 	lock               sync.Mutex
 	closeSync          chan bool
+	certChecked        time.Time
 }
 
 // NewUpstream is used to create a new upstream connection
@@ -38,6 +46,8 @@ func NewUpstream(nc *nats.Conn, node data.NodeEdge) (*Upstream, error) {
 		node:            node,
 		subUpNodePoints: make(map[string]*nats.Subscription),
 		subUpEdgePoints: make(map[string]*nats.Subscription),
+		subUpHistory:    make(map[string]*nats.Subscription),
+		subUpErase:      make(map[string]*nats.Subscription),
 		closeSync:       make(chan bool),
 	}
 
@@ -55,6 +65,7 @@ func NewUpstream(nc *nats.Conn, node data.NodeEdge) (*Upstream, error) {
 		URI:       up.nodeUp.URI,
 		AuthToken: up.nodeUp.AuthToken,
 		NoEcho:    true,
+		TLS:       up.nodeUp.TLS,
 		Disconnected: func() {
 			log.Println("NATS Upstream Disconnected")
 		},
@@ -72,6 +83,10 @@ func NewUpstream(nc *nats.Conn, node data.NodeEdge) (*Upstream, error) {
 		return nil, fmt.Errorf("Error connection to upstream NATS: %v", err)
 	}
 
+	if up.nodeUp.TLS.Cert != "" {
+		up.sendCertExpires()
+	}
+
 	up.subLocalNodePoints, err = nc.Subscribe(client.SubjectNodeAllPoints(), func(msg *nats.Msg) {
 		nodeID, points, err := client.DecodeNodePointsMsg(msg)
 
@@ -80,6 +95,12 @@ func NewUpstream(nc *nats.Conn, node data.NodeEdge) (*Upstream, error) {
 			return
 		}
 
+		// secrets are not synchronized
+		points = data.Points(points).RemoveSecrets()
+		if len(points) < 1 {
+			return
+		}
+
 		err = client.SendNodePoints(up.ncUp, nodeID, points, false)
 
 		if err != nil {
@@ -118,6 +139,53 @@ func NewUpstream(nc *nats.Conn, node data.NodeEdge) (*Upstream, error) {
 		}
 	})
 
+	up.subLocalCreate, err = nc.Subscribe(client.SubjectNodeAllCreate(), func(msg *nats.Msg) {
+		node, err := data.PbDecodeNode(msg.Data)
+		if err != nil {
+			log.Println("Error decoding node: ", err)
+			return
+		}
+
+		// secrets are not synchronized
+		node.Points = node.Points.RemoveSecrets()
+
+		d, err := node.ToPb()
+		if err != nil {
+			log.Println("Error encoding node: ", err)
+			return
+		}
+
+		// older upstream stores do not handle create requests, so new
+		// nodes are also sent by the periodic sync if they are missing
+		err = up.ncUp.Publish(client.SubjectNodeCreate(node.ID), d)
+		if err != nil {
+			log.Println("Error sending node to remote system: ", err)
+		}
+
+		err = up.addUpstreamNodeSub(node.ID)
+		if err != nil {
+			log.Printf("Error adding upstream node sub: %v\n", err)
+		}
+
+		if node.Parent != "" && node.Parent != "none" {
+			err = up.addUpstreamEdgeSub(node.ID, node.Parent)
+			if err != nil {
+				log.Printf("Error adding upstream edge sub: %v\n", err)
+			}
+		}
+	})
+
+	// erase requests are forwarded upstream so synced copies of the node
+	// are erased too
+	up.subLocalErase, err = nc.Subscribe(client.SubjectErase("*"), func(msg *nats.Msg) {
+		up.forwardErase(msg, up.nc, up.ncUp, client.EraseUp,
+			"upstream "+up.nodeUp.Description+": ")
+	})
+
+	if err != nil {
+		return nil, fmt.Errorf("Error subscribing to erase requests: %v", err)
+	}
+
 	rootNodes, err := client.GetNode(nc, "root", "")
 
 	if err != nil {
@@ -216,6 +284,11 @@ func (up *Upstream) addUpstreamNodeSub(nodeID string) error {
 			return
 		}
 
+		points = data.Points(points).RemoveSecrets()
+		if len(points) < 1 {
+			return
+		}
+
 		err = client.SendNodePoints(up.nc, nodeID, points, false)
 
 		if err != nil {
@@ -227,13 +300,120 @@ func (up *Upstream) addUpstreamNodeSub(nodeID string) error {
 		return err
 	}
 
+	// history for this node may be stored on this instance, so forward
+	// upstream history queries to the local bus
+	subHist, err := up.ncUp.Subscribe(client.SubjectNodeHistoryQuery(nodeID), func(msg *nats.Msg) {
+		if msg.Reply == "" {
+			return
+		}
+
+		var q client.HistoryQuery
+		err := json.Unmarshal(msg.Data, &q)
+		if err != nil {
+			log.Println("Error decoding upstream history query: ", err)
+			return
+		}
+
+		// the upstream instance waits q.Wait for more responses, so
+		// wait less locally so our response arrives in time
+		if q.Wait <= 0 {
+			q.Wait = 1
+		}
+		q.Wait /= 4
+
+		go func() {
+			points, err := client.QueryHistory(up.nc, q)
+			if err == client.ErrNoHistory {
+				// nothing stored locally, let other sources answer
+				return
+			}
+
+			err = client.RespondHistory(up.ncUp, msg.Reply, points, err)
+			if err != nil {
+				log.Println("Error responding to upstream history query: ", err)
+			}
+		}()
+	})
+
+	if err != nil {
+		sub.Unsubscribe()
+		return err
+	}
+
+	// data of this node may be stored on this instance, so forward
+	// upstream erase requests to the local bus
+	subErase, err := up.ncUp.Subscribe(client.SubjectErase(nodeID), func(msg *nats.Msg) {
+		up.forwardErase(msg, up.ncUp, up.nc, client.EraseDown,
+			"downstream "+up.node.Parent+": ")
+	})
+
+	if err != nil {
+		sub.Unsubscribe()
+		subHist.Unsubscribe()
+		return err
+	}
+
 	up.lock.Lock()
 	up.subUpNodePoints[nodeID] = sub
+	up.subUpHistory[nodeID] = subHist
+	up.subUpErase[nodeID] = subErase
 	up.lock.Unlock()
 
 	return nil
 }
 
+// forwardErase erases a node on another instance for an erase request
+// received on from, and responds with the reports of the other instance.
+// Requests are only forwarded in direction, so they are not sent back to
+// the instance they came from.
+func (up *Upstream) forwardErase(msg *nats.Msg, from, to *nats.Conn, direction, source string) {
+	if msg.Reply == "" {
+		return
+	}
+
+	var req client.EraseRequest
+	err := json.Unmarshal(msg.Data, &req)
+	if err != nil {
+		log.Println("Error decoding erase request: ", err)
+		return
+	}
+
+	if req.Direction != "" && req.Direction != direction {
+		// respond so the sender does not wait for us
+		err := client.RespondErase(from, msg.Reply, nil)
+		if err != nil {
+			log.Println("Error responding to erase request: ", err)
+		}
+		return
+	}
+
+	req.Direction = direction
+	req.NodeIDs = nil
+
+	// the sender waits req.Wait for more responses, so wait less on the
+	// other instance so our response arrives in time
+	if req.Wait <= 0 {
+		req.Wait = 2
+	}
+	req.Wait /= 4
+
+	go func() {
+		reports, err := client.EraseNode(to, req)
+		if err != nil && len(reports) == 0 {
+			reports = []client.EraseReport{{Error: err.Error()}}
+		}
+
+		for i := range reports {
+			reports[i].Source = source + reports[i].Source
+		}
+
+		err = client.RespondErase(from, msg.Reply, reports)
+		if err != nil {
+			log.Println("Error responding to erase request: ", err)
+		}
+	}()
+}
+
 func (up *Upstream) addUpstreamEdgeSub(nodeID, parentID string) error {
 	if nodeID == "" || parentID == "" {
 		// the root node does not have an edge id
@@ -283,6 +463,7 @@ func (up *Upstream) addUpstreamEdgeSub(nodeID, parentID string) error {
 // from one NATS server to another. Typically from the current instance
 // to an upstream.
 func (up *Upstream) sendNodesUp(node data.NodeEdge) error {
+	node.Points = node.Points.RemoveSecrets()
 	err := client.SendNode(up.ncUp, node, up.node.ID)
 
 	if err != nil {
@@ -352,6 +533,9 @@ func (up *Upstream) syncNode(id, parent string) error {
 		upstreamProcessed := make(map[int]bool)
 
 		for _, p := range nodeLocal.Points {
+			if p.IsSecret() {
+				continue
+			}
 			found := false
 			for i, pUp := range nodeUp.Points {
 				if p.IsMatch(pUp.Type, pUp.Key) {
@@ -380,7 +564,7 @@ func (up *Upstream) syncNode(id, parent string) error {
 
 		// check for any points that do not exist locally
 		for i, pUp := range nodeUp.Points {
-			if _, ok := upstreamProcessed[i]; !ok {
+			if _, ok := upstreamProcessed[i]; !ok && !pUp.IsSecret() {
 				err := client.SendNodePoint(up.nc, nodeLocal.ID, pUp, true)
 				if err != nil {
 					log.Println("Error syncing point from upstream: ", err)
@@ -492,6 +676,99 @@ func (up *Upstream) syncNode(id, parent string) error {
 	return nil
 }
 
+// sendCertExpires records when the client certificate of the upstream
+// connection expires
+func (up *Upstream) sendCertExpires() {
+	expires, err := client.CertExpires(up.nodeUp.TLS.Cert)
+	if err != nil {
+		log.Printf("Upstream %v: error reading TLS cert: %v\n", up.nodeUp.Description, err)
+		return
+	}
+
+	err = client.SendNodePoint(up.nc, up.node.ID,
+		client.CertExpiresPoint("upstream", expires, time.Now()), false)
+	if err != nil {
+		log.Println("Upstream: error sending cert expiration: ", err)
+	}
+}
+
+// certCheckPeriod is how often the client certificate of an upstream with
+// certAuto set is checked
+var certCheckPeriod = time.Hour
+
+// rotateCert requests a new client certificate from the upstream
+// certificate authority if certAuto is set and the current certificate is
+// missing or needs to be renewed. The key and certificate are written to
+// certs/<upstream ID>, and the tlsCert and tlsKey points are updated, which
+// restarts the upstream connection with the new certificate.
+func (up *Upstream) rotateCert() {
+	if !up.nodeUp.CertAuto || up.ncUp == nil || !up.ncUp.IsConnected() {
+		return
+	}
+
+	now := time.Now()
+	if now.Sub(up.certChecked) < certCheckPeriod {
+		return
+	}
+	up.certChecked = now
+
+	if up.nodeUp.TLS.Cert != "" && !client.CertNeedsRenewal(up.nodeUp.TLS.Cert, now) {
+		return
+	}
+
+	// the device ID is the ID of the root node the upstream is under
+	deviceID := up.node.Parent
+
+	key, csr, err := client.NewCertKey(deviceID)
+	if err != nil {
+		log.Println("Upstream: error creating cert key: ", err)
+		return
+	}
+
+	res, err := client.RequestCert(up.ncUp, deviceID, csr)
+	if err != nil {
+		log.Printf("Upstream %v: error requesting cert: %v\n", up.nodeUp.Description, err)
+		return
+	}
+
+	dir := filepath.Join("certs", up.node.ID)
+	err = os.MkdirAll(dir, 0700)
+	if err != nil {
+		log.Println("Upstream: error creating cert directory: ", err)
+		return
+	}
+
+	certFile := filepath.Join(dir, res.Serial+".pem")
+	keyFile := filepath.Join(dir, res.Serial+"-key.pem")
+
+	err = os.WriteFile(keyFile, key, 0600)
+	if err == nil {
+		err = os.WriteFile(certFile, []byte(res.Cert), 0644)
+	}
+	if err != nil {
+		log.Println("Upstream: error writing cert: ", err)
+		return
+	}
+
+	log.Printf("Upstream %v: new client cert %v\n", up.nodeUp.Description, res.Serial)
+
+	err = client.SendNodePoints(up.nc, up.node.ID, data.Points{
+		{Time: now, Type: data.PointTypeTLSCert, Text: certFile},
+		{Time: now, Type: data.PointTypeTLSKey, Text: keyFile},
+	}, true)
+	if err != nil {
+		log.Println("Upstream: error sending cert points: ", err)
+		return
+	}
+
+	// remove the previous cert if we created it
+	for _, f := range []string{up.nodeUp.TLS.Cert, up.nodeUp.TLS.Key} {
+		if filepath.Dir(f) == dir {
+			os.Remove(f)
+		}
+	}
+}
+
 // Stop upstream instance
 func (up *Upstream) Stop() {
 	if up.nodeUp.Disabled {
@@ -512,6 +789,20 @@ func (up *Upstream) Stop() {
 		}
 	}
 
+	if up.subLocalCreate != nil {
+		err := up.subLocalCreate.Unsubscribe()
+		if err != nil {
+			log.Println("Error unsubscribing node create from local bus: ", err)
+		}
+	}
+
+	if up.subLocalErase != nil {
+		err := up.subLocalErase.Unsubscribe()
+		if err != nil {
+			log.Println("Error unsubscribing erase from local bus: ", err)
+		}
+	}
+
 	up.lock.Lock()
 	for _, sub := range up.subUpNodePoints {
 		err := sub.Unsubscribe()
@@ -526,6 +817,20 @@ func (up *Upstream) Stop() {
 			log.Println("Error unsubscribing from upstream bus: ", err)
 		}
 	}
+
+	for _, sub := range up.subUpHistory {
+		err := sub.Unsubscribe()
+		if err != nil {
+			log.Println("Error unsubscribing from upstream bus: ", err)
+		}
+	}
+
+	for _, sub := range up.subUpErase {
+		err := sub.Unsubscribe()
+		if err != nil {
+			log.Println("Error unsubscribing from upstream bus: ", err)
+		}
+	}
 	up.lock.Unlock()
 
 	up.closeSync <- true
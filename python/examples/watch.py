@@ -0,0 +1,35 @@
+"""Prints the devices in a SIOT instance, and then watches the first device
+for changes.
+
+    python3 examples/watch.py nats://localhost:4222
+"""
+
+import asyncio
+import sys
+
+import simpleiot
+
+
+async def main(server):
+    siot = await simpleiot.connect(server)
+
+    root = (await siot.get_node("root"))[0]
+    devices = await siot.get_node_children(root.id, type="device")
+
+    for d in devices:
+        print(d.id, d.description)
+
+    if not devices:
+        await siot.close()
+        return
+
+    async with await siot.watch_node(devices[0].id, root.id) as w:
+        for _ in range(10):
+            print(w.node.description, w.node.text("versionApp"))
+            await asyncio.sleep(1)
+
+    await siot.close()
+
+
+if __name__ == "__main__":
+    asyncio.run(main(sys.argv[1] if len(sys.argv) > 1 else "nats://localhost:4222"))
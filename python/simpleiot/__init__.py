@@ -0,0 +1,20 @@
+"""Python client for the Simple IoT NATS API.
+
+The data types and protobuf encoding have no dependencies. The NATS client
+requires the nats-py package.
+"""
+
+from .data import NodeEdge, Point, add_point, find_point
+from .pb import DecodeError, NotFoundError
+
+__version__ = "0.1.0"
+
+
+def __getattr__(name):
+    # the client is imported on demand so that the data types can be used
+    # without nats-py installed
+    if name in ("connect", "Connection", "NodeWatcher"):
+        from . import client
+
+        return getattr(client, name)
+    raise AttributeError(name)
@@ -0,0 +1,185 @@
+"""SIOT NATS API client. This is the Python equivalent of the Go client
+package functions for points and nodes (see client/node.go and
+client/point.go)."""
+
+import asyncio
+import uuid
+
+import nats
+
+from . import pb
+from .data import NodeEdge, Point, add_point
+
+DEFAULT_SERVER = "nats://localhost:4222"
+DEFAULT_TIMEOUT = 20
+
+
+async def connect(server=DEFAULT_SERVER, token=None, user=None, password=None, **kwargs):
+    """Connects to a SIOT NATS server. A token gives full access; a user and
+    password can be used for client credentials (see the auth.clients config).
+    Other keyword arguments are passed to nats.connect()."""
+    opts = dict(kwargs)
+    if token:
+        opts["token"] = token
+    if user:
+        opts["user"] = user
+        opts["password"] = password
+    nc = await nats.connect(server, **opts)
+    return Connection(nc)
+
+
+class Connection:
+    """A connection to a SIOT instance."""
+
+    def __init__(self, nc):
+        self.nc = nc
+
+    async def close(self):
+        await self.nc.drain()
+
+    async def get_node(self, id, parent="none", timeout=DEFAULT_TIMEOUT):
+        """Returns a list of NodeEdges for id. If id is "root", the root node is
+        returned. If parent is "none", edge points are not included. If parent
+        is "all", all instances of the node are returned."""
+        msg = await self.nc.request("node." + id, (parent or "none").encode(), timeout=timeout)
+        return pb.decode_nodes_request(msg.data)
+
+    async def get_node_children(self, id, type="", include_deleted=False,
+                                recursive=False, timeout=DEFAULT_TIMEOUT):
+        """Returns the children of a node. type can be used to only return
+        nodes of a type. If recursive is set, all descendants are returned."""
+        req = []
+        if include_deleted:
+            req.append(Point(type="tombstone", value=1))
+        if type:
+            req.append(Point(type="nodeType", text=type))
+
+        msg = await self.nc.request("node." + id + ".children",
+                                    pb.encode_points(req), timeout=timeout)
+        nodes = pb.decode_nodes_request(msg.data)
+
+        if recursive:
+            for n in list(nodes):
+                nodes += await self.get_node_children(n.id, type, include_deleted,
+                                                      True, timeout)
+        return nodes
+
+    async def send_points(self, subject, points, ack=True, timeout=DEFAULT_TIMEOUT):
+        data = pb.encode_points(points)
+        if not ack:
+            await self.nc.publish(subject, data)
+            return
+
+        msg = await self.nc.request(subject, data, timeout=timeout)
+        if msg.data:
+            raise RuntimeError(msg.data.decode("utf-8"))
+
+    async def send_node_points(self, id, points, ack=True):
+        """Sends points for a node."""
+        await self.send_points("node.%s.points" % id, points, ack)
+
+    async def send_edge_points(self, id, parent, points, ack=True):
+        """Sends points for the edge between a node and its parent."""
+        await self.send_points("node.%s.%s.points" % (id, parent or "none"), points, ack)
+
+    async def send_node(self, node, origin=""):
+        """Creates or updates a node. If node.id is not set, a new ID is
+        assigned. Returns the node ID."""
+        if not node.id:
+            node.id = str(uuid.uuid4())
+
+        # edge points are sent first, otherwise the store creates an edge to
+        # the root node for the orphaned node
+        if node.parent and node.parent != "none":
+            edge_points = node.edge_points or [Point(type="tombstone", origin=origin)]
+            await self.send_edge_points(node.id, node.parent, edge_points)
+
+        points = list(node.points) + [Point(type="nodeType", text=node.type, origin=origin)]
+        await self.send_node_points(node.id, points)
+        return node.id
+
+    async def delete_node(self, id, parent, origin=""):
+        """Deletes a node from a parent."""
+        await self.send_edge_points(id, parent,
+                                    [Point(type="tombstone", value=1, origin=origin)])
+
+    async def subscribe_points(self, id, callback):
+        """Calls callback(points) when points for a node are sent. Returns the
+        subscription, which can be stopped with unsubscribe()."""
+        async def handler(msg):
+            await _call(callback, pb.decode_points(msg.data))
+
+        return await self.nc.subscribe("node.%s.points" % id, cb=handler)
+
+    async def subscribe_edge_points(self, id, parent, callback):
+        """Calls callback(points) when edge points for a node are sent."""
+        async def handler(msg):
+            await _call(callback, pb.decode_points(msg.data))
+
+        return await self.nc.subscribe("node.%s.%s.points" % (id, parent or "none"),
+                                       cb=handler)
+
+    async def watch_node(self, id, parent="none"):
+        """Returns a NodeWatcher that keeps a local copy of a node up to date."""
+        w = NodeWatcher(self, id, parent)
+        await w.start()
+        return w
+
+
+async def _call(callback, *args):
+    ret = callback(*args)
+    if asyncio.iscoroutine(ret):
+        await ret
+
+
+class NodeWatcher:
+    """Keeps a local copy of a node up to date with the points sent for it.
+    This is the Python equivalent of the Go client.NodeWatcher."""
+
+    def __init__(self, conn, id, parent="none"):
+        self.conn = conn
+        self.id = id
+        self.parent = parent or "none"
+        self.node = NodeEdge(id=id, type="", parent=self.parent)
+        self._subs = []
+
+    async def start(self):
+        # subscribe before fetching the node so that updates are not missed
+        self._subs.append(await self.conn.subscribe_points(self.id, self._points))
+        self._subs.append(await self.conn.subscribe_edge_points(self.id, self.parent,
+                                                                self._edge_points))
+        try:
+            nodes = await self.conn.get_node(self.id, self.parent)
+        except pb.NotFoundError:
+            # the node is populated as points arrive
+            nodes = []
+
+        if nodes:
+            n = nodes[0]
+            self.node.type = n.type
+            for p in n.points:
+                add_point(self.node.points, p)
+            for p in n.edge_points:
+                add_point(self.node.edge_points, p)
+
+    def _points(self, points):
+        for p in points:
+            if p.type == "nodeType":
+                self.node.type = p.text
+                continue
+            add_point(self.node.points, p)
+
+    def _edge_points(self, points):
+        for p in points:
+            add_point(self.node.edge_points, p)
+
+    async def stop(self):
+        for s in self._subs:
+            await s.unsubscribe()
+        self._subs = []
+
+    async def __aenter__(self):
+        return self
+
+    async def __aexit__(self, *exc):
+        await self.stop()
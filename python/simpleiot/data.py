@@ -0,0 +1,80 @@
+"""SIOT data types. These match the Point and NodeEdge types in the Go data
+package."""
+
+from dataclasses import dataclass, field
+from datetime import datetime, timezone
+
+
+def _now():
+    return datetime.now(timezone.utc)
+
+
+@dataclass
+class Point:
+    """A point is the basic unit of data in SIOT (see docs/ref/data.md)."""
+
+    type: str
+    value: float = 0.0
+    text: str = ""
+    key: str = ""
+    time: datetime = field(default_factory=_now)
+    index: float = 0.0
+    tombstone: int = 0
+    data: bytes = b""
+    origin: str = ""
+    meta: dict = field(default_factory=dict)
+    quality: str = ""
+
+
+def add_point(points, p):
+    """Adds or replaces a point in a list of points. A point replaces an
+    existing point with the same type and key if it is newer. The largest
+    tombstone value always wins."""
+    for i, existing in enumerate(points):
+        if existing.type == p.type and existing.key == p.key:
+            tombstone = max(existing.tombstone, p.tombstone)
+            if p.time > existing.time:
+                points[i] = p
+            points[i].tombstone = tombstone
+            return
+    points.append(p)
+
+
+def find_point(points, typ, key=""):
+    """Returns the point with type and key, or None."""
+    for p in points:
+        if p.type == typ and p.key == key:
+            return p
+    return None
+
+
+@dataclass
+class NodeEdge:
+    """A node and the edge to one of its parents."""
+
+    id: str
+    type: str
+    parent: str = ""
+    points: list = field(default_factory=list)
+    edge_points: list = field(default_factory=list)
+    hash: bytes = b""
+
+    def value(self, typ, key=""):
+        """Returns the value of a point, or 0 if the point does not exist."""
+        p = find_point(self.points, typ, key)
+        return p.value if p else 0.0
+
+    def text(self, typ, key=""):
+        """Returns the text of a point, or "" if the point does not exist."""
+        p = find_point(self.points, typ, key)
+        return p.text if p else ""
+
+    @property
+    def description(self):
+        return self.text("description")
+
+    @property
+    def deleted(self):
+        """True if the edge to the parent is deleted."""
+        p = find_point(self.edge_points, "tombstone")
+        return bool(p and p.value != 0)
@@ -0,0 +1,282 @@
+"""Protobuf encoding for SIOT messages.
+
+This is a small protobuf codec for the messages in internal/pb (point.proto
+and node.proto), so the package does not depend on generated code or the
+protobuf runtime. Field numbers must be kept in sync with the .proto files.
+"""
+
+import struct
+from datetime import datetime, timedelta, timezone
+
+from .data import NodeEdge, Point
+
+_EPOCH = datetime(1970, 1, 1, tzinfo=timezone.utc)
+
+# wire types
+_VARINT = 0
+_FIXED64 = 1
+_BYTES = 2
+_FIXED32 = 5
+
+# google.protobuf.Timestamp
+_TS_SECONDS = 1
+_TS_NANOS = 2
+
+# pb.Point
+_POINT_TYPE = 2
+_POINT_VALUE = 4
+_POINT_TIME = 5
+_POINT_TEXT = 8
+_POINT_KEY = 11
+_POINT_TOMBSTONE = 12
+_POINT_INDEX = 13
+_POINT_DATA = 14
+_POINT_ORIGIN = 15
+_POINT_META = 16
+_POINT_QUALITY = 17
+
+# pb.Points and pb.PointsRequest
+_POINTS_POINTS = 1
+_POINTS_ERROR = 2
+
+# pb.Node
+_NODE_ID = 1
+_NODE_TYPE = 2
+_NODE_POINTS = 3
+_NODE_HASH = 4
+_NODE_PARENT = 6
+_NODE_EDGE_POINTS = 7
+
+# pb.Nodes and pb.NodesRequest
+_NODES_NODES = 1
+_NODES_ERROR = 2
+
+
+class DecodeError(Exception):
+    """Raised when a message can not be decoded."""
+
+
+def _varint(v):
+    if v < 0:
+        # negative int32/int64 values are encoded as 10 byte varints
+        v += 1 << 64
+    out = bytearray()
+    while True:
+        b = v & 0x7F
+        v >>= 7
+        if v:
+            out.append(b | 0x80)
+        else:
+            out.append(b)
+            return bytes(out)
+
+
+def _tag(field, wire):
+    return _varint(field << 3 | wire)
+
+
+def _bytes_field(field, b):
+    return _tag(field, _BYTES) + _varint(len(b)) + b
+
+
+def _string_field(field, s):
+    return _bytes_field(field, s.encode("utf-8"))
+
+
+def _float_field(field, v):
+    return _tag(field, _FIXED32) + struct.pack("<f", v)
+
+
+def _read_varint(buf, pos):
+    shift = 0
+    ret = 0
+    while True:
+        if pos >= len(buf):
+            raise DecodeError("truncated varint")
+        b = buf[pos]
+        pos += 1
+        ret |= (b & 0x7F) << shift
+        if not b & 0x80:
+            return ret, pos
+        shift += 7
+        if shift >= 70:
+            raise DecodeError("varint too long")
+
+
+def _signed(v):
+    # negative int32 and int64 values are sign extended to 64 bits
+    if v >= 1 << 63:
+        v -= 1 << 64
+    return v
+
+
+def _fields(buf):
+    """Yields (field number, wire type, value) for each field in buf."""
+    buf = bytes(buf)
+    pos = 0
+    while pos < len(buf):
+        tag, pos = _read_varint(buf, pos)
+        field, wire = tag >> 3, tag & 7
+        if wire == _VARINT:
+            v, pos = _read_varint(buf, pos)
+        elif wire == _FIXED64:
+            v = buf[pos : pos + 8]
+            pos += 8
+        elif wire == _BYTES:
+            n, pos = _read_varint(buf, pos)
+            v = buf[pos : pos + n]
+            pos += n
+        elif wire == _FIXED32:
+            v = buf[pos : pos + 4]
+            pos += 4
+        else:
+            raise DecodeError("unsupported wire type %d" % wire)
+        if pos > len(buf):
+            raise DecodeError("truncated message")
+        yield field, wire, v
+
+
+def _encode_time(t):
+    if t.tzinfo is None:
+        t = t.replace(tzinfo=timezone.utc)
+    seconds = (t - _EPOCH) // timedelta(seconds=1)
+    nanos = t.microsecond * 1000
+    out = b""
+    if seconds:
+        out += _tag(_TS_SECONDS, _VARINT) + _varint(seconds)
+    if nanos:
+        out += _tag(_TS_NANOS, _VARINT) + _varint(nanos)
+    return out
+
+
+def _decode_time(buf):
+    seconds = 0
+    nanos = 0
+    for field, _, v in _fields(buf):
+        if field == _TS_SECONDS:
+            seconds = _signed(v)
+        elif field == _TS_NANOS:
+            nanos = _signed(v)
+    return _EPOCH + timedelta(seconds=seconds, microseconds=nanos // 1000)
+
+
+def encode_point(p):
+    """Encodes a Point as a pb.Point message."""
+    out = b""
+    if p.type:
+        out += _string_field(_POINT_TYPE, p.type)
+    if p.value:
+        out += _float_field(_POINT_VALUE, p.value)
+    out += _bytes_field(_POINT_TIME, _encode_time(p.time))
+    if p.text:
+        out += _string_field(_POINT_TEXT, p.text)
+    if p.key:
+        out += _string_field(_POINT_KEY, p.key)
+    if p.tombstone:
+        out += _tag(_POINT_TOMBSTONE, _VARINT) + _varint(p.tombstone)
+    if p.index:
+        out += _float_field(_POINT_INDEX, p.index)
+    if p.data:
+        out += _bytes_field(_POINT_DATA, p.data)
+    if p.origin:
+        out += _string_field(_POINT_ORIGIN, p.origin)
+    for k in sorted(p.meta or {}):
+        entry = _string_field(1, k) + _string_field(2, p.meta[k])
+        out += _bytes_field(_POINT_META, entry)
+    if p.quality:
+        out += _string_field(_POINT_QUALITY, p.quality)
+    return out
+
+
+def decode_point(buf):
+    """Decodes a pb.Point message."""
+    p = Point(type="")
+    for field, wire, v in _fields(buf):
+        if field == _POINT_TYPE:
+            p.type = v.decode("utf-8")
+        elif field == _POINT_VALUE and wire == _FIXED32:
+            p.value = struct.unpack("<f", v)[0]
+        elif field == _POINT_TIME:
+            p.time = _decode_time(v)
+        elif field == _POINT_TEXT:
+            p.text = v.decode("utf-8")
+        elif field == _POINT_KEY:
+            p.key = v.decode("utf-8")
+        elif field == _POINT_TOMBSTONE:
+            p.tombstone = _signed(v)
+        elif field == _POINT_INDEX and wire == _FIXED32:
+            p.index = struct.unpack("<f", v)[0]
+        elif field == _POINT_DATA:
+            p.data = bytes(v)
+        elif field == _POINT_ORIGIN:
+            p.origin = v.decode("utf-8")
+        elif field == _POINT_META:
+            k = ""
+            val = ""
+            for f, _, ev in _fields(v):
+                if f == 1:
+                    k = ev.decode("utf-8")
+                elif f == 2:
+                    val = ev.decode("utf-8")
+            p.meta[k] = val
+        elif field == _POINT_QUALITY:
+            p.quality = v.decode("utf-8")
+    return p
+
+
+def encode_points(points):
+    """Encodes a list of Points as a pb.Points message."""
+    return b"".join(_bytes_field(_POINTS_POINTS, encode_point(p)) for p in points)
+
+
+def decode_points(buf):
+    """Decodes a pb.Points message and returns a list of Points."""
+    return [decode_point(v) for f, _, v in _fields(buf) if f == _POINTS_POINTS]
+
+
+def decode_points_request(buf):
+    """Decodes a pb.PointsRequest, raises an exception if it has an error."""
+    points = []
+    for field, _, v in _fields(buf):
+        if field == _POINTS_POINTS:
+            points.append(decode_point(v))
+        elif field == _POINTS_ERROR:
+            raise RuntimeError(v.decode("utf-8"))
+    return points
+
+
+def decode_node(buf):
+    """Decodes a pb.Node message."""
+    n = NodeEdge(id="", type="")
+    for field, _, v in _fields(buf):
+        if field == _NODE_ID:
+            n.id = v.decode("utf-8")
+        elif field == _NODE_TYPE:
+            n.type = v.decode("utf-8")
+        elif field == _NODE_POINTS:
+            n.points.append(decode_point(v))
+        elif field == _NODE_HASH:
+            n.hash = bytes(v)
+        elif field == _NODE_PARENT:
+            n.parent = v.decode("utf-8")
+        elif field == _NODE_EDGE_POINTS:
+            n.edge_points.append(decode_point(v))
+    return n
+
+
+def decode_nodes_request(buf):
+    """Decodes a pb.NodesRequest, raises an exception if it has an error."""
+    nodes = []
+    for field, _, v in _fields(buf):
+        if field == _NODES_NODES:
+            nodes.append(decode_node(v))
+        elif field == _NODES_ERROR:
+            err = v.decode("utf-8")
+            if err == "document not found":
+                raise NotFoundError(err)
+            raise RuntimeError(err)
+    return nodes
+
+
+class NotFoundError(RuntimeError):
+    """Raised when a node is not found."""
@@ -0,0 +1,117 @@
+"""Tests for the protobuf codec. The test vectors were encoded by the Go data
+package, so these tests check that both implementations agree."""
+
+import unittest
+from datetime import datetime, timezone
+
+from simpleiot import NodeEdge, NotFoundError, Point, add_point, pb
+
+TIME = datetime(2023, 11, 14, 22, 13, 20, 123456, tzinfo=timezone.utc)
+
+# data.Points{
+#   {Time: tm, Type: "value", Key: "0", Value: 1.5, Origin: "py"},
+#   {Time: tm, Type: "description", Text: "hi", Tombstone: 1, Index: 2},
+# }, with tm = time.Unix(1700000000, 123456789)
+POINTS = bytes.fromhex(
+    "0a20120576616c7565250000c03f2a0b0880e2cfaa0610959aef3a5a01307a0270790a25"
+    "120b6465736372697074696f6e2a0b0880e2cfaa0610959aef3a4202686960016d00000040"
+)
+
+# data.Point{Time: tm, Type: "temp", Value: -3.25,
+#   Meta: map[string]string{"unit": "C"}, Quality: "good"}
+META = bytes.fromhex(
+    "0a2b120474656d7025000050c02a0b0880e2cfaa0610959aef3a8201090a04756e6974"
+    "1201438a0104676f6f64"
+)
+
+# pb.NodesRequest with a device node abc under root, with the first point
+# above and a tombstone edge point
+NODES = bytes.fromhex(
+    "0a4f0a0361626312066465766963651a20120576616c7565250000c03f2a0b0880e2cfaa"
+    "0610959aef3a5a01307a0270793204726f6f743a181209746f6d6273746f6e652a0b0880"
+    "e2cfaa0610959aef3a"
+)
+
+
+class TestPb(unittest.TestCase):
+    def test_decode_points(self):
+        pts = pb.decode_points(POINTS)
+        self.assertEqual(len(pts), 2)
+
+        p = pts[0]
+        self.assertEqual((p.type, p.key, p.value, p.origin), ("value", "0", 1.5, "py"))
+        self.assertEqual(p.time, TIME)
+
+        p = pts[1]
+        self.assertEqual((p.type, p.text, p.tombstone, p.index),
+                         ("description", "hi", 1, 2.0))
+
+    def test_encode_points(self):
+        # Go encodes nanoseconds, Python datetimes only have microseconds, so
+        # round trip the decoded points and compare with a point encoded by Go
+        pts = pb.decode_points(POINTS)
+        self.assertEqual(pb.decode_points(pb.encode_points(pts)), pts)
+
+        b = pb.encode_points([Point(type="value", key="0", value=1.5, origin="py",
+                                    time=datetime(2023, 11, 14, 22, 13, 20,
+                                                  tzinfo=timezone.utc))])
+        self.assertEqual(b.hex(), "0a1b120576616c7565250000c03f2a060880e2cfaa065a01307a027079")
+
+    def test_meta(self):
+        p = pb.decode_points(META)[0]
+        self.assertEqual(p.value, -3.25)
+        self.assertEqual(p.meta, {"unit": "C"})
+        self.assertEqual(p.quality, "good")
+        self.assertEqual(pb.decode_points(pb.encode_points([p]))[0], p)
+
+    def test_negative_tombstone(self):
+        p = Point(type="x", tombstone=-1, time=TIME)
+        self.assertEqual(pb.decode_points(pb.encode_points([p]))[0].tombstone, -1)
+
+    def test_decode_nodes(self):
+        nodes = pb.decode_nodes_request(NODES)
+        self.assertEqual(len(nodes), 1)
+
+        n = nodes[0]
+        self.assertEqual((n.id, n.type, n.parent), ("abc", "device", "root"))
+        self.assertEqual(n.value("value", "0"), 1.5)
+        self.assertEqual(n.edge_points[0].type, "tombstone")
+        self.assertFalse(n.deleted)
+
+    def test_nodes_error(self):
+        with self.assertRaises(NotFoundError):
+            pb.decode_nodes_request(b"\x12\x12document not found")
+
+        with self.assertRaises(RuntimeError):
+            pb.decode_nodes_request(bytes.fromhex("12096e6f7420666f756e64"))
+
+    def test_truncated(self):
+        with self.assertRaises(pb.DecodeError):
+            pb.decode_points(POINTS[:10])
+
+
+class TestData(unittest.TestCase):
+    def test_add_point(self):
+        pts = [Point(type="value", value=1, time=TIME)]
+
+        # older points are ignored
+        add_point(pts, Point(type="value", value=2,
+                             time=datetime(2020, 1, 1, tzinfo=timezone.utc)))
+        self.assertEqual(pts[0].value, 1)
+
+        add_point(pts, Point(type="value", value=3))
+        self.assertEqual(pts[0].value, 3)
+
+        add_point(pts, Point(type="value", key="1", value=4))
+        self.assertEqual(len(pts), 2)
+
+    def test_node(self):
+        n = NodeEdge(id="1", type="device",
+                     points=[Point(type="description", text="pump")],
+                     edge_points=[Point(type="tombstone", value=1)])
+        self.assertEqual(n.description, "pump")
+        self.assertTrue(n.deleted)
+
+
+if __name__ == "__main__":
+    unittest.main()
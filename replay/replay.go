@@ -0,0 +1,254 @@
+// Package replay records the point traffic of a SIOT subtree to a file and
+// replays it later against another instance. This can be used to reproduce
+// field issues on a test instance, or to load test with a realistic workload.
+//
+// A recording is a JSON lines file of entries. The first entry is a snapshot
+// of the subtree nodes when the recording started, and each following entry
+// holds the node or edge points of one message.
+package replay
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/simpleiot/simpleiot/client"
+	"github.com/simpleiot/simpleiot/data"
+)
+
+// Origin is the origin of nodes created by Replay
+const Origin = "replay"
+
+// Entry is one line of a recording
+type Entry struct {
+	// Time the message was recorded
+	Time time.Time `json:"time"`
+	// Nodes is set in the first entry, and contains the nodes of the
+	// subtree. The first node is the top of the subtree.
+	Nodes []data.NodeEdge `json:"nodes,omitempty"`
+	// NodeID is the node the points were sent to
+	NodeID string `json:"nodeID,omitempty"`
+	// Parent is set if the points are edge points
+	Parent string      `json:"parent,omitempty"`
+	Points data.Points `json:"points,omitempty"`
+}
+
+// Options are used to configure Replay
+type Options struct {
+	// Speed scales the time between entries. 2 replays twice as fast as
+	// recorded. If 0, entries are sent as fast as possible.
+	Speed float64
+	// CreateNodes creates the recorded subtree before the points are
+	// replayed. The top of the subtree is created under Parent.
+	CreateNodes bool
+	// Parent of the subtree when CreateNodes is set. Defaults to the root
+	// node.
+	Parent string
+}
+
+// Recorder records the point traffic of a subtree (see StartRecording)
+type Recorder struct {
+	sub *nats.Subscription
+
+	lock     sync.Mutex
+	errWrite error
+}
+
+// StartRecording starts writing the node and edge points sent to the
+// subtree at nodeID to w. When it returns, the recorder is subscribed on the
+// server, so all points sent after that are recorded. Stop ends the
+// recording.
+func StartRecording(nc *nats.Conn, nodeID string, w io.Writer) (*Recorder, error) {
+	nodes, err := client.GetNode(nc, nodeID, "none")
+	if err != nil {
+		return nil, fmt.Errorf("Error getting node: %v", err)
+	}
+
+	if len(nodes) < 1 {
+		return nil, errors.New("node not found")
+	}
+
+	children, err := client.GetNodeChildren(nc, nodes[0].ID, "", false, true)
+	if err != nil {
+		return nil, fmt.Errorf("Error getting children: %v", err)
+	}
+
+	enc := json.NewEncoder(w)
+	r := &Recorder{}
+
+	write := func(e Entry) {
+		r.lock.Lock()
+		defer r.lock.Unlock()
+		if r.errWrite == nil {
+			r.errWrite = enc.Encode(e)
+		}
+	}
+
+	// subscribe before writing the snapshot so no points are missed
+	r.sub, err = nc.Subscribe(fmt.Sprintf("up.%v.>", nodes[0].ID), func(msg *nats.Msg) {
+		// up.<upID>.<nodeID>.points or up.<upID>.<nodeID>.<parentID>.points
+		chunks := strings.Split(msg.Subject, ".")
+		if len(chunks) < 4 || len(chunks) > 5 || chunks[len(chunks)-1] != "points" {
+			return
+		}
+
+		points, err := data.PbDecodePoints(msg.Data)
+		if err != nil {
+			return
+		}
+
+		e := Entry{Time: time.Now(), NodeID: chunks[2], Points: points}
+		if len(chunks) == 5 {
+			e.Parent = chunks[3]
+		}
+
+		write(e)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// make sure the server has processed the subscription
+	err = nc.Flush()
+	if err != nil {
+		r.sub.Unsubscribe()
+		return nil, err
+	}
+
+	write(Entry{Time: time.Now(), Nodes: append(nodes[:1], children...)})
+
+	return r, nil
+}
+
+// Stop ends the recording. Points that were already received are written
+// before it returns.
+func (r *Recorder) Stop() error {
+	// drain delivers pending messages before the subscription is removed
+	err := r.sub.Drain()
+
+	// wait for the pending messages to be written
+	for r.sub.IsValid() {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.errWrite != nil {
+		return r.errWrite
+	}
+
+	return err
+}
+
+// Record writes the node and edge points sent to the subtree at nodeID to w
+// until ctx is done
+func Record(ctx context.Context, nc *nats.Conn, nodeID string, w io.Writer) error {
+	r, err := StartRecording(nc, nodeID, w)
+	if err != nil {
+		return err
+	}
+
+	<-ctx.Done()
+
+	return r.Stop()
+}
+
+// Replay sends the points in a recording made with Record. Point times are
+// shifted to the time they are replayed. The number of point messages sent
+// is returned.
+func Replay(ctx context.Context, nc *nats.Conn, r io.Reader, o Options) (int, error) {
+	dec := json.NewDecoder(r)
+
+	var start, recStart time.Time
+	count := 0
+
+	for {
+		var rec Entry
+		err := dec.Decode(&rec)
+		if err == io.EOF {
+			return count, nil
+		}
+		if err != nil {
+			return count, fmt.Errorf("Error decoding entry: %v", err)
+		}
+
+		if len(rec.Nodes) > 0 {
+			if o.CreateNodes {
+				if err := createNodes(nc, rec.Nodes, o.Parent); err != nil {
+					return count, err
+				}
+			}
+			continue
+		}
+
+		if start.IsZero() {
+			start, recStart = time.Now(), rec.Time
+		}
+
+		if o.Speed > 0 {
+			offset := float64(rec.Time.Sub(recStart)) / o.Speed
+			wait := time.Until(start.Add(time.Duration(offset)))
+			if wait > 0 {
+				select {
+				case <-time.After(wait):
+				case <-ctx.Done():
+					return count, ctx.Err()
+				}
+			}
+		}
+
+		now := time.Now()
+		for i, p := range rec.Points {
+			if !p.Time.IsZero() {
+				rec.Points[i].Time = now.Add(p.Time.Sub(rec.Time))
+			}
+		}
+
+		if rec.Parent != "" {
+			err = client.SendEdgePointsCtx(ctx, nc, rec.NodeID, rec.Parent, rec.Points, true)
+		} else {
+			err = client.SendNodePointsCtx(ctx, nc, rec.NodeID, rec.Points, true)
+		}
+
+		if err != nil {
+			return count, fmt.Errorf("Error sending points to %v: %v", rec.NodeID, err)
+		}
+
+		count++
+	}
+}
+
+// createNodes creates the subtree of a recording. The first node is created
+// under parent.
+func createNodes(nc *nats.Conn, nodes []data.NodeEdge, parent string) error {
+	if parent == "" {
+		root, err := client.GetNode(nc, "root", "")
+		if err != nil {
+			return fmt.Errorf("Error getting root node: %v", err)
+		}
+		if len(root) < 1 {
+			return errors.New("root node not found")
+		}
+		parent = root[0].ID
+	}
+
+	for i, n := range nodes {
+		if i == 0 {
+			n.Parent = parent
+		}
+
+		err := client.SendNode(nc, n, Origin)
+		if err != nil {
+			return fmt.Errorf("Error creating node %v: %v", n.ID, err)
+		}
+	}
+
+	return nil
+}
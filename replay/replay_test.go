@@ -0,0 +1,100 @@
+package replay_test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/simpleiot/simpleiot/client"
+	"github.com/simpleiot/simpleiot/data"
+	"github.com/simpleiot/simpleiot/replay"
+	"github.com/simpleiot/simpleiot/server"
+)
+
+var testOptions = server.Options{
+	StoreFile:    "replay.sqlite",
+	NatsPort:     4950,
+	HTTPPort:     "8950",
+	NatsHTTPPort: 8951,
+	NatsWSPort:   8952,
+	NatsServer:   "nats://localhost:4950",
+}
+
+func TestRecordReplay(t *testing.T) {
+	nc, root, stop, err := server.TestServerOptions(testOptions)
+	if err != nil {
+		t.Fatal("Error starting test server: ", err)
+	}
+
+	group := data.NodeEdge{ID: "ID-group", Type: data.NodeTypeGroup, Parent: root.ID}
+	err = client.SendNode(nc, group, "test")
+	if err != nil {
+		t.Fatal("Error sending node: ", err)
+	}
+
+	v := client.Variable{ID: "ID-var", Parent: group.ID, Description: "var"}
+	err = client.SendNodeType(nc, v, "test")
+	if err != nil {
+		t.Fatal("Error sending node: ", err)
+	}
+
+	var rec bytes.Buffer
+	recorder, err := replay.StartRecording(nc, group.ID, &rec)
+	if err != nil {
+		t.Fatal("Error starting recording: ", err)
+	}
+
+	for i := 1; i <= 3; i++ {
+		err := client.SendNodePoint(nc, v.ID, data.Point{Type: data.PointTypeValue,
+			Value: float64(i), Origin: "test"}, true)
+		if err != nil {
+			t.Fatal("Error sending point: ", err)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	// points outside the subtree are not recorded
+	err = client.SendNodePoint(nc, root.ID, data.Point{Type: data.PointTypeDescription,
+		Text: "root", Origin: "test"}, true)
+	if err != nil {
+		t.Fatal("Error sending point: ", err)
+	}
+
+	if err := recorder.Stop(); err != nil {
+		t.Fatal("Record error: ", err)
+	}
+	stop()
+
+	// replay against a new instance
+	nc, _, stop, err = server.TestServerOptions(testOptions)
+	if err != nil {
+		t.Fatal("Error starting test server: ", err)
+	}
+	defer stop()
+
+	start := time.Now()
+	count, err := replay.Replay(context.Background(), nc, &rec,
+		replay.Options{Speed: 2, CreateNodes: true})
+	if err != nil {
+		t.Fatal("Replay error: ", err)
+	}
+
+	if count != 3 {
+		t.Error("expected 3 messages, got: ", count)
+	}
+
+	// 100ms of recorded time between the first and last point
+	if time.Since(start) < 40*time.Millisecond {
+		t.Error("replay did not keep the recorded timing")
+	}
+
+	vars, err := client.GetNodeType[client.Variable](nc, v.ID, group.ID)
+	if err != nil || len(vars) < 1 {
+		t.Fatal("Error getting replayed node: ", err)
+	}
+
+	if vars[0].Value != 3 || vars[0].Description != "var" {
+		t.Errorf("replayed node is not correct: %+v", vars[0])
+	}
+}
@@ -0,0 +1,802 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nats-io/nats.go"
+	"github.com/simpleiot/simpleiot/api"
+	"github.com/simpleiot/simpleiot/client"
+	"github.com/simpleiot/simpleiot/data"
+	"github.com/simpleiot/simpleiot/store"
+	"gopkg.in/yaml.v2"
+)
+
+// Config is the SIOT configuration file format. Values are loaded in the
+// following order, with later values overriding earlier ones:
+//   - defaults (see DefaultConfig)
+//   - config file
+//   - environment variables
+//   - command line flags
+type Config struct {
+	DataDir        string           `yaml:"dataDir"`
+	Store          string           `yaml:"store"`
+	StoreMaxSize   int64            `yaml:"storeMaxSize"`
+	StoreDedup     float64          `yaml:"storeDedup"`
+	StoreRecent    int              `yaml:"storeRecent"`
+	StoreReadOnly  bool             `yaml:"storeReadOnly"`
+	StoreShard     string           `yaml:"storeShard"`
+	StoreShards    []string         `yaml:"storeShards"`
+	StoreChaos     ConfigChaos      `yaml:"storeChaos"`
+	StoreWatchdog  ConfigWatchdog   `yaml:"storeWatchdog"`
+	StoreHA        ConfigHA         `yaml:"storeHA"`
+	SecretsKey     string           `yaml:"secretsKey"`
+	SecretsKeyFile string           `yaml:"secretsKeyFile"`
+	HTTP           ConfigHTTP       `yaml:"http"`
+	NATS           ConfigNATS       `yaml:"nats"`
+	Auth           ConfigAuth       `yaml:"auth"`
+	Upstream       []ConfigUpstream `yaml:"upstream"`
+	ParticleAPIKey string           `yaml:"particleAPIKey"`
+	OSVersionField string           `yaml:"osVersionField"`
+}
+
+// ConfigChaos configures store fault injection for testing (see
+// store.Chaos). Latency is in seconds.
+type ConfigChaos struct {
+	Latency       float64 `yaml:"latency"`
+	DropRate      float64 `yaml:"dropRate"`
+	ReplyFailRate float64 `yaml:"replyFailRate"`
+	Seed          int64   `yaml:"seed"`
+}
+
+// ConfigWatchdog configures store slow handler detection and overload
+// shedding. SlowHandler is in seconds. 0 disables either.
+type ConfigWatchdog struct {
+	SlowHandler  float64 `yaml:"slowHandler"`
+	PendingLimit int     `yaml:"pendingLimit"`
+}
+
+// ConfigHA configures the store as an instance of a high availability pair
+// (see store.HA). HA is enabled if Instance is set. Heartbeat and Timeout
+// are in seconds.
+type ConfigHA struct {
+	Instance  string  `yaml:"instance"`
+	Heartbeat float64 `yaml:"heartbeat"`
+	Timeout   float64 `yaml:"timeout"`
+	Witness   string  `yaml:"witness"`
+}
+
+// ConfigHTTP contains HTTP server settings
+type ConfigHTTP struct {
+	Port            string          `yaml:"port"`
+	Debug           bool            `yaml:"debug"`
+	PprofAddr       string          `yaml:"pprofAddr"`
+	TLSCert         string          `yaml:"tlsCert"`
+	TLSKey          string          `yaml:"tlsKey"`
+	AutocertDomains []string        `yaml:"autocertDomains"`
+	AutocertEmail   string          `yaml:"autocertEmail"`
+	History         ConfigHistory   `yaml:"history"`
+	RateLimit       ConfigRateLimit `yaml:"rateLimit"`
+	BasePath        string          `yaml:"basePath"`
+	CORSOrigins     []string        `yaml:"corsOrigins"`
+	TrustedProxies  []string        `yaml:"trustedProxies"`
+}
+
+// ConfigHistory limits history queries made through the HTTP API (see
+// api.HistoryLimits). MaxRange is in days and CacheTime is in seconds. 0
+// disables a limit.
+type ConfigHistory struct {
+	Rate          float64 `yaml:"rate"`
+	Burst         int     `yaml:"burst"`
+	MaxRange      float64 `yaml:"maxRange"`
+	MaxPoints     int     `yaml:"maxPoints"`
+	MaxConcurrent int     `yaml:"maxConcurrent"`
+	CacheTime     float64 `yaml:"cacheTime"`
+}
+
+// ConfigRateLimit limits requests made to the HTTP API (see
+// api.RateLimits). MaxBodySize is in bytes. 0 disables a limit.
+type ConfigRateLimit struct {
+	TokenRate   float64 `yaml:"tokenRate"`
+	TokenBurst  int     `yaml:"tokenBurst"`
+	IPRate      float64 `yaml:"ipRate"`
+	IPBurst     int     `yaml:"ipBurst"`
+	MaxBodySize int64   `yaml:"maxBodySize"`
+}
+
+// ConfigNATS contains NATS client and server settings
+type ConfigNATS struct {
+	Server        string   `yaml:"server"`
+	DisableServer bool     `yaml:"disableServer"`
+	Port          int      `yaml:"port"`
+	HTTPPort      int      `yaml:"httpPort"`
+	WSPort        int      `yaml:"wsPort"`
+	ClusterPort   int      `yaml:"clusterPort"`
+	Routes        []string `yaml:"routes"`
+	TLSCert       string   `yaml:"tlsCert"`
+	TLSKey        string   `yaml:"tlsKey"`
+	TLSTimeout    float64  `yaml:"tlsTimeout"`
+	TLSCA         string   `yaml:"tlsCA"`
+	TLSVerify     bool     `yaml:"tlsVerify"`
+	ClientCert    string   `yaml:"clientCert"`
+	ClientKey     string   `yaml:"clientKey"`
+	ClientCA      string   `yaml:"clientCA"`
+}
+
+// ConfigAuth contains auth settings
+type ConfigAuth struct {
+	Token   string             `yaml:"token"`
+	Disable bool               `yaml:"disable"`
+	Clients []ConfigClientUser `yaml:"clients"`
+}
+
+// ConfigClientUser is a NATS user for clients that run in an external
+// process (see docs/ref/external-clients.md)
+type ConfigClientUser struct {
+	User     string `yaml:"user"`
+	Password string `yaml:"password"`
+}
+
+// ConfigUpstream describes an upstream connection. Upstream nodes are
+// created at startup if a node with the same URI does not already exist.
+type ConfigUpstream struct {
+	Description string `yaml:"description"`
+	URI         string `yaml:"uri"`
+	AuthToken   string `yaml:"authToken"`
+}
+
+// DefaultConfig returns the default SIOT configuration
+func DefaultConfig() Config {
+	return Config{
+		DataDir: "./",
+		Store:   "siot.sqlite",
+		HTTP: ConfigHTTP{
+			Port: "8080",
+			History: ConfigHistory{
+				Rate:          5,
+				Burst:         20,
+				MaxRange:      366,
+				MaxPoints:     5000,
+				MaxConcurrent: 8,
+				CacheTime:     10,
+			},
+			RateLimit: ConfigRateLimit{
+				TokenRate:   20,
+				TokenBurst:  100,
+				IPRate:      50,
+				IPBurst:     200,
+				MaxBodySize: 10 << 20,
+			},
+		},
+		NATS: ConfigNATS{
+			Server:     "nats://localhost:4222",
+			Port:       4222,
+			HTTPPort:   8222,
+			WSPort:     9222,
+			TLSTimeout: 0.5,
+		},
+		StoreWatchdog: ConfigWatchdog{
+			SlowHandler:  1,
+			PendingLimit: 10000,
+		},
+		OSVersionField: "VERSION",
+	}
+}
+
+// LoadConfig reads a YAML config file on top of the default config. Unknown
+// fields in the file are an error.
+func LoadConfig(file string) (Config, error) {
+	ret := DefaultConfig()
+
+	d, err := os.ReadFile(file)
+	if err != nil {
+		return ret, fmt.Errorf("Error reading config file: %v", err)
+	}
+
+	err = yaml.UnmarshalStrict(d, &ret)
+	if err != nil {
+		return ret, fmt.Errorf("Error parsing config file %v: %v", file, err)
+	}
+
+	return ret, nil
+}
+
+// ApplyEnv overrides config values with any SIOT environment variables
+// that are set.
+func (c *Config) ApplyEnv() error {
+	envString := func(name string, v *string) {
+		if e := os.Getenv(name); e != "" {
+			*v = e
+		}
+	}
+
+	envBool := func(name string, v *bool) error {
+		if e := os.Getenv(name); e != "" {
+			b, err := strconv.ParseBool(e)
+			if err != nil {
+				return fmt.Errorf("Error parsing %v: %v", name, err)
+			}
+			*v = b
+		}
+		return nil
+	}
+
+	envFloat := func(name string, v *float64) error {
+		if e := os.Getenv(name); e != "" {
+			f, err := strconv.ParseFloat(e, 64)
+			if err != nil {
+				return fmt.Errorf("Error parsing %v: %v", name, err)
+			}
+			*v = f
+		}
+		return nil
+	}
+
+	envInt := func(name string, v *int) error {
+		if e := os.Getenv(name); e != "" {
+			n, err := strconv.Atoi(e)
+			if err != nil {
+				return fmt.Errorf("Error parsing %v: %v", name, err)
+			}
+			*v = n
+		}
+		return nil
+	}
+
+	envString("SIOT_DATA", &c.DataDir)
+	envString("SIOT_STORE_SHARD", &c.StoreShard)
+	envString("SIOT_STORE_HA_INSTANCE", &c.StoreHA.Instance)
+	envString("SIOT_STORE_HA_WITNESS", &c.StoreHA.Witness)
+	envString("SIOT_SECRETS_KEY", &c.SecretsKey)
+	envString("SIOT_SECRETS_KEY_FILE", &c.SecretsKeyFile)
+	envString("SIOT_HTTP_PORT", &c.HTTP.Port)
+	envString("SIOT_PPROF_ADDR", &c.HTTP.PprofAddr)
+	envString("SIOT_HTTP_TLS_CERT", &c.HTTP.TLSCert)
+	envString("SIOT_HTTP_TLS_KEY", &c.HTTP.TLSKey)
+	envString("SIOT_HTTP_AUTOCERT_EMAIL", &c.HTTP.AutocertEmail)
+	envString("SIOT_HTTP_BASE_PATH", &c.HTTP.BasePath)
+	envString("SIOT_NATS_SERVER", &c.NATS.Server)
+	envString("SIOT_NATS_TLS_CERT", &c.NATS.TLSCert)
+	envString("SIOT_NATS_TLS_KEY", &c.NATS.TLSKey)
+	envString("SIOT_NATS_TLS_CA", &c.NATS.TLSCA)
+	envString("SIOT_NATS_CLIENT_CERT", &c.NATS.ClientCert)
+	envString("SIOT_NATS_CLIENT_KEY", &c.NATS.ClientKey)
+	envString("SIOT_NATS_CLIENT_CA", &c.NATS.ClientCA)
+	envString("SIOT_AUTH_TOKEN", &c.Auth.Token)
+	envString("SIOT_PARTICLE_API_KEY", &c.ParticleAPIKey)
+	envString("OS_VERSION_FIELD", &c.OSVersionField)
+
+	if e := os.Getenv("SIOT_STORE_MAX_SIZE"); e != "" {
+		n, err := strconv.ParseInt(e, 10, 64)
+		if err != nil {
+			return fmt.Errorf("Error parsing SIOT_STORE_MAX_SIZE: %v", err)
+		}
+		c.StoreMaxSize = n
+	}
+
+	if e := os.Getenv("SIOT_STORE_DEDUP"); e != "" {
+		t, err := strconv.ParseFloat(e, 64)
+		if err != nil {
+			return fmt.Errorf("Error parsing SIOT_STORE_DEDUP: %v", err)
+		}
+		c.StoreDedup = t
+	}
+
+	if err := envInt("SIOT_STORE_RECENT", &c.StoreRecent); err != nil {
+		return err
+	}
+
+	if err := envBool("SIOT_STORE_READ_ONLY", &c.StoreReadOnly); err != nil {
+		return err
+	}
+
+	if e := os.Getenv("SIOT_STORE_SHARDS"); e != "" {
+		c.StoreShards = strings.Split(e, ",")
+	}
+
+	if err := envFloat("SIOT_STORE_CHAOS_LATENCY", &c.StoreChaos.Latency); err != nil {
+		return err
+	}
+
+	if err := envFloat("SIOT_STORE_CHAOS_DROP_RATE", &c.StoreChaos.DropRate); err != nil {
+		return err
+	}
+
+	if err := envFloat("SIOT_STORE_CHAOS_REPLY_FAIL_RATE", &c.StoreChaos.ReplyFailRate); err != nil {
+		return err
+	}
+
+	if e := os.Getenv("SIOT_STORE_CHAOS_SEED"); e != "" {
+		n, err := strconv.ParseInt(e, 10, 64)
+		if err != nil {
+			return fmt.Errorf("Error parsing SIOT_STORE_CHAOS_SEED: %v", err)
+		}
+		c.StoreChaos.Seed = n
+	}
+
+	if err := envFloat("SIOT_STORE_SLOW_HANDLER", &c.StoreWatchdog.SlowHandler); err != nil {
+		return err
+	}
+
+	if err := envInt("SIOT_STORE_PENDING_LIMIT", &c.StoreWatchdog.PendingLimit); err != nil {
+		return err
+	}
+
+	if err := envFloat("SIOT_STORE_HA_HEARTBEAT", &c.StoreHA.Heartbeat); err != nil {
+		return err
+	}
+
+	if err := envFloat("SIOT_STORE_HA_TIMEOUT", &c.StoreHA.Timeout); err != nil {
+		return err
+	}
+
+	if err := envFloat("SIOT_HTTP_HISTORY_RATE", &c.HTTP.History.Rate); err != nil {
+		return err
+	}
+
+	if err := envInt("SIOT_HTTP_HISTORY_BURST", &c.HTTP.History.Burst); err != nil {
+		return err
+	}
+
+	if err := envFloat("SIOT_HTTP_HISTORY_MAX_RANGE", &c.HTTP.History.MaxRange); err != nil {
+		return err
+	}
+
+	if err := envInt("SIOT_HTTP_HISTORY_MAX_POINTS", &c.HTTP.History.MaxPoints); err != nil {
+		return err
+	}
+
+	if err := envInt("SIOT_HTTP_HISTORY_MAX_CONCURRENT", &c.HTTP.History.MaxConcurrent); err != nil {
+		return err
+	}
+
+	if err := envFloat("SIOT_HTTP_HISTORY_CACHE_TIME", &c.HTTP.History.CacheTime); err != nil {
+		return err
+	}
+
+	if err := envFloat("SIOT_HTTP_RATE_LIMIT_TOKEN_RATE", &c.HTTP.RateLimit.TokenRate); err != nil {
+		return err
+	}
+
+	if err := envInt("SIOT_HTTP_RATE_LIMIT_TOKEN_BURST", &c.HTTP.RateLimit.TokenBurst); err != nil {
+		return err
+	}
+
+	if err := envFloat("SIOT_HTTP_RATE_LIMIT_IP_RATE", &c.HTTP.RateLimit.IPRate); err != nil {
+		return err
+	}
+
+	if err := envInt("SIOT_HTTP_RATE_LIMIT_IP_BURST", &c.HTTP.RateLimit.IPBurst); err != nil {
+		return err
+	}
+
+	if e := os.Getenv("SIOT_HTTP_MAX_BODY_SIZE"); e != "" {
+		n, err := strconv.ParseInt(e, 10, 64)
+		if err != nil {
+			return fmt.Errorf("Error parsing SIOT_HTTP_MAX_BODY_SIZE: %v", err)
+		}
+		c.HTTP.RateLimit.MaxBodySize = n
+	}
+
+	if e := os.Getenv("SIOT_HTTP_AUTOCERT_DOMAINS"); e != "" {
+		c.HTTP.AutocertDomains = strings.Split(e, ",")
+	}
+
+	if e := os.Getenv("SIOT_HTTP_CORS_ORIGINS"); e != "" {
+		c.HTTP.CORSOrigins = strings.Split(e, ",")
+	}
+
+	if e := os.Getenv("SIOT_HTTP_TRUSTED_PROXIES"); e != "" {
+		c.HTTP.TrustedProxies = strings.Split(e, ",")
+	}
+
+	if err := envInt("SIOT_NATS_PORT", &c.NATS.Port); err != nil {
+		return err
+	}
+
+	if err := envInt("SIOT_NATS_HTTP_PORT", &c.NATS.HTTPPort); err != nil {
+		return err
+	}
+
+	if err := envInt("SIOT_NATS_WS_PORT", &c.NATS.WSPort); err != nil {
+		return err
+	}
+
+	if err := envInt("SIOT_NATS_CLUSTER_PORT", &c.NATS.ClusterPort); err != nil {
+		return err
+	}
+
+	if e := os.Getenv("SIOT_NATS_ROUTES"); e != "" {
+		c.NATS.Routes = strings.Split(e, ",")
+	}
+
+	if e := os.Getenv("SIOT_NATS_TLS_TIMEOUT"); e != "" {
+		t, err := strconv.ParseFloat(e, 64)
+		if err != nil {
+			return fmt.Errorf("Error parsing SIOT_NATS_TLS_TIMEOUT: %v", err)
+		}
+		c.NATS.TLSTimeout = t
+	}
+
+	if err := envBool("SIOT_NATS_TLS_VERIFY", &c.NATS.TLSVerify); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Validate checks the config for errors
+func (c *Config) Validate() error {
+	validPort := func(name string, p int) error {
+		if p < 0 || p > 65535 {
+			return fmt.Errorf("%v is not a valid port: %v", name, p)
+		}
+		return nil
+	}
+
+	if c.Store == "" {
+		return errors.New("store must be set")
+	}
+
+	if c.StoreMaxSize < 0 {
+		return errors.New("storeMaxSize must not be negative")
+	}
+
+	if c.StoreDedup < 0 {
+		return errors.New("storeDedup must not be negative")
+	}
+
+	if c.StoreRecent < 0 {
+		return errors.New("storeRecent must not be negative")
+	}
+
+	validShard := func(s string) bool {
+		return s != "" && !strings.ContainsAny(s, ".*> \t")
+	}
+
+	if c.StoreShard != "" && len(c.StoreShards) > 0 {
+		return errors.New("storeShard and storeShards can not both be set")
+	}
+
+	if c.StoreShard != "" && !validShard(c.StoreShard) {
+		return fmt.Errorf("storeShard is not a valid shard name: %v", c.StoreShard)
+	}
+
+	for _, s := range c.StoreShards {
+		if !validShard(s) {
+			return fmt.Errorf("storeShards: not a valid shard name: %q", s)
+		}
+	}
+
+	if c.StoreChaos.Latency < 0 {
+		return errors.New("storeChaos latency must not be negative")
+	}
+
+	validRate := func(r float64) bool {
+		return r >= 0 && r <= 1
+	}
+
+	if !validRate(c.StoreChaos.DropRate) || !validRate(c.StoreChaos.ReplyFailRate) {
+		return errors.New("storeChaos rates must be between 0 and 1")
+	}
+
+	if c.StoreWatchdog.SlowHandler < 0 || c.StoreWatchdog.PendingLimit < 0 {
+		return errors.New("storeWatchdog values must not be negative")
+	}
+
+	if ha := c.StoreHA; ha.Instance != "" {
+		if c.StoreReadOnly || c.StoreShard != "" || len(c.StoreShards) > 0 {
+			return errors.New("storeHA can not be used with a read-only or sharded store")
+		}
+
+		if ha.Heartbeat < 0 || ha.Timeout < 0 {
+			return errors.New("storeHA values must not be negative")
+		}
+
+		heartbeat, timeout := ha.Heartbeat, ha.Timeout
+		if heartbeat == 0 {
+			heartbeat = 1
+		}
+		if timeout == 0 {
+			timeout = 5
+		}
+		if timeout < 2*heartbeat {
+			return errors.New("storeHA timeout must be at least twice the heartbeat")
+		}
+
+		if ha.Witness != "" {
+			if u, err := url.Parse(ha.Witness); err != nil || u.Host == "" {
+				return fmt.Errorf("storeHA witness is not a valid NATS URL: %v", ha.Witness)
+			}
+		}
+	}
+
+	if c.SecretsKey != "" && c.SecretsKeyFile != "" {
+		return errors.New("secretsKey and secretsKeyFile can not both be set")
+	}
+
+	if c.SecretsKey != "" {
+		if _, err := decodeSecretsKey([]byte(c.SecretsKey)); err != nil {
+			return err
+		}
+	}
+
+	httpPort, err := strconv.Atoi(c.HTTP.Port)
+	if err != nil {
+		return fmt.Errorf("http port is not valid: %v", c.HTTP.Port)
+	}
+
+	if err := validPort("http port", httpPort); err != nil {
+		return err
+	}
+
+	if c.HTTP.PprofAddr != "" {
+		if _, _, err := net.SplitHostPort(c.HTTP.PprofAddr); err != nil {
+			return fmt.Errorf("http pprofAddr is not valid: %v", err)
+		}
+	}
+
+	h := c.HTTP.History
+	if h.Rate < 0 || h.Burst < 0 || h.MaxRange < 0 || h.MaxPoints < 0 ||
+		h.MaxConcurrent < 0 || h.CacheTime < 0 {
+		return errors.New("http history values must not be negative")
+	}
+
+	rl := c.HTTP.RateLimit
+	if rl.TokenRate < 0 || rl.TokenBurst < 0 || rl.IPRate < 0 || rl.IPBurst < 0 ||
+		rl.MaxBodySize < 0 {
+		return errors.New("http rateLimit values must not be negative")
+	}
+
+	if (c.HTTP.TLSCert == "") != (c.HTTP.TLSKey == "") {
+		return errors.New("http tlsCert and tlsKey must both be set")
+	}
+
+	if c.HTTP.TLSCert != "" && len(c.HTTP.AutocertDomains) > 0 {
+		return errors.New("http tlsCert and autocertDomains can not both be set")
+	}
+
+	for _, d := range c.HTTP.AutocertDomains {
+		if d == "" || strings.ContainsAny(d, ":/ ") {
+			return fmt.Errorf("http autocertDomains: not a valid domain: %q", d)
+		}
+	}
+
+	if b := c.HTTP.BasePath; b != "" {
+		if !strings.HasPrefix(b, "/") || strings.HasSuffix(b, "/") ||
+			strings.ContainsAny(b, "?#\"<> ") {
+			return fmt.Errorf("http basePath must start with / and not end with /: %q", b)
+		}
+	}
+
+	for _, o := range c.HTTP.CORSOrigins {
+		if o == "*" {
+			continue
+		}
+		u, err := url.Parse(o)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" ||
+			strings.TrimSuffix(u.Path, "/") != "" {
+			return fmt.Errorf("http corsOrigins: not a valid origin: %q", o)
+		}
+	}
+
+	if _, err := api.ParseTrustedProxies(c.HTTP.TrustedProxies); err != nil {
+		return fmt.Errorf("http trustedProxies: %v", err)
+	}
+
+	if err := validPort("nats port", c.NATS.Port); err != nil {
+		return err
+	}
+
+	if err := validPort("nats httpPort", c.NATS.HTTPPort); err != nil {
+		return err
+	}
+
+	if err := validPort("nats wsPort", c.NATS.WSPort); err != nil {
+		return err
+	}
+
+	if err := validPort("nats clusterPort", c.NATS.ClusterPort); err != nil {
+		return err
+	}
+
+	for _, r := range c.NATS.Routes {
+		if u, err := url.Parse(r); err != nil || u.Host == "" {
+			return fmt.Errorf("nats routes: not a valid URI: %q", r)
+		}
+	}
+
+	if len(c.NATS.Routes) > 0 && c.NATS.ClusterPort == 0 {
+		return errors.New("nats routes require clusterPort")
+	}
+
+	if _, err := url.Parse(c.NATS.Server); err != nil || c.NATS.Server == "" {
+		return fmt.Errorf("nats server is not a valid URI: %v", c.NATS.Server)
+	}
+
+	if (c.NATS.TLSCert == "") != (c.NATS.TLSKey == "") {
+		return errors.New("nats tlsCert and tlsKey must both be set")
+	}
+
+	if c.NATS.TLSTimeout < 0 {
+		return errors.New("nats tlsTimeout must not be negative")
+	}
+
+	if c.NATS.TLSVerify && (c.NATS.TLSCert == "" || c.NATS.TLSCA == "") {
+		return errors.New("nats tlsVerify requires tlsCert, tlsKey, and tlsCA")
+	}
+
+	if (c.NATS.ClientCert == "") != (c.NATS.ClientKey == "") {
+		return errors.New("nats clientCert and clientKey must both be set")
+	}
+
+	users := make(map[string]bool)
+	for i, u := range c.Auth.Clients {
+		if u.User == "" || u.Password == "" {
+			return fmt.Errorf("auth client %v: user and password must be set", i)
+		}
+
+		if users[u.User] {
+			return fmt.Errorf("auth client %v: duplicate user: %v", i, u.User)
+		}
+		users[u.User] = true
+	}
+
+	for i, u := range c.Upstream {
+		if u.URI == "" {
+			return fmt.Errorf("upstream %v: uri must be set", i)
+		}
+
+		if _, err := url.Parse(u.URI); err != nil {
+			return fmt.Errorf("upstream %v: uri is not valid: %v", i, err)
+		}
+	}
+
+	return nil
+}
+
+// Options returns server options for the config
+func (c *Config) Options() Options {
+	var clients []ExternalClientUser
+	for _, u := range c.Auth.Clients {
+		clients = append(clients, ExternalClientUser{User: u.User, Password: u.Password})
+	}
+
+	chaos := store.Chaos{
+		Latency:       time.Duration(c.StoreChaos.Latency * float64(time.Second)),
+		DropRate:      c.StoreChaos.DropRate,
+		ReplyFailRate: c.StoreChaos.ReplyFailRate,
+		Seed:          c.StoreChaos.Seed,
+	}
+
+	return Options{
+		StoreFile:         path.Join(c.DataDir, c.Store),
+		StoreMaxSize:      c.StoreMaxSize,
+		StoreDedup:        c.StoreDedup,
+		StoreRecent:       c.StoreRecent,
+		StoreReadOnly:     c.StoreReadOnly,
+		StoreShard:        c.StoreShard,
+		StoreShards:       c.StoreShards,
+		StoreChaos:        chaos,
+		StoreSlowHandler:  time.Duration(c.StoreWatchdog.SlowHandler * float64(time.Second)),
+		StorePendingLimit: c.StoreWatchdog.PendingLimit,
+		StoreHA: store.HAParams{
+			Instance:  c.StoreHA.Instance,
+			Heartbeat: time.Duration(c.StoreHA.Heartbeat * float64(time.Second)),
+			Timeout:   time.Duration(c.StoreHA.Timeout * float64(time.Second)),
+			Witness:   c.StoreHA.Witness,
+		},
+		SecretsKey:      c.SecretsKey,
+		SecretsKeyFile:  c.SecretsKeyFile,
+		DataDir:         c.DataDir,
+		HTTPPort:        c.HTTP.Port,
+		DebugHTTP:       c.HTTP.Debug,
+		PprofAddr:       c.HTTP.PprofAddr,
+		HTTPTLSCert:     c.HTTP.TLSCert,
+		HTTPTLSKey:      c.HTTP.TLSKey,
+		AutocertDomains: c.HTTP.AutocertDomains,
+		AutocertEmail:   c.HTTP.AutocertEmail,
+		HTTPBasePath:    c.HTTP.BasePath,
+		CORSOrigins:     c.HTTP.CORSOrigins,
+		TrustedProxies:  c.HTTP.TrustedProxies,
+		HTTPHistory: api.HistoryLimits{
+			Rate:          c.HTTP.History.Rate,
+			Burst:         c.HTTP.History.Burst,
+			MaxRange:      time.Duration(c.HTTP.History.MaxRange * float64(24*time.Hour)),
+			MaxPoints:     c.HTTP.History.MaxPoints,
+			MaxConcurrent: c.HTTP.History.MaxConcurrent,
+			CacheTime:     time.Duration(c.HTTP.History.CacheTime * float64(time.Second)),
+		},
+		HTTPRateLimits: api.RateLimits{
+			TokenRate:   c.HTTP.RateLimit.TokenRate,
+			TokenBurst:  c.HTTP.RateLimit.TokenBurst,
+			IPRate:      c.HTTP.RateLimit.IPRate,
+			IPBurst:     c.HTTP.RateLimit.IPBurst,
+			MaxBodySize: c.HTTP.RateLimit.MaxBodySize,
+		},
+		DisableAuth:       c.Auth.Disable,
+		NatsServer:        c.NATS.Server,
+		NatsDisableServer: c.NATS.DisableServer,
+		NatsPort:          c.NATS.Port,
+		NatsHTTPPort:      c.NATS.HTTPPort,
+		NatsWSPort:        c.NATS.WSPort,
+		NatsClusterPort:   c.NATS.ClusterPort,
+		NatsRoutes:        c.NATS.Routes,
+		NatsTLSCert:       c.NATS.TLSCert,
+		NatsTLSKey:        c.NATS.TLSKey,
+		NatsTLSTimeout:    c.NATS.TLSTimeout,
+		NatsTLSCA:         c.NATS.TLSCA,
+		NatsTLSVerify:     c.NATS.TLSVerify,
+		NatsClientCert:    c.NATS.ClientCert,
+		NatsClientKey:     c.NATS.ClientKey,
+		NatsClientCA:      c.NATS.ClientCA,
+		AuthToken:         c.Auth.Token,
+		ExternalClients:   clients,
+		ParticleAPIKey:    c.ParticleAPIKey,
+		OSVersionField:    c.OSVersionField,
+	}
+}
+
+// createUpstreams creates upstream nodes from the config if an upstream
+// node with the same URI does not already exist.
+func createUpstreams(nc *nats.Conn, ups []ConfigUpstream) error {
+	if len(ups) < 1 {
+		return nil
+	}
+
+	nodes, err := client.GetNode(nc, "root", "")
+	if err != nil {
+		return fmt.Errorf("Error getting root node: %v", err)
+	}
+
+	if len(nodes) < 1 {
+		return errors.New("no root node")
+	}
+
+	root := nodes[0]
+
+	existing, err := client.GetNodeChildren(nc, root.ID, data.NodeTypeUpstream, false, false)
+	if err != nil {
+		return fmt.Errorf("Error getting upstream nodes: %v", err)
+	}
+
+	for _, u := range ups {
+		found := false
+		for _, e := range existing {
+			uri, _ := e.Points.Text(data.PointTypeURI, "")
+			if uri == u.URI {
+				found = true
+				break
+			}
+		}
+
+		if found {
+			continue
+		}
+
+		err := client.SendNode(nc, data.NodeEdge{
+			ID:     uuid.New().String(),
+			Type:   data.NodeTypeUpstream,
+			Parent: root.ID,
+			Points: data.Points{
+				{Type: data.PointTypeDescription, Text: u.Description},
+				{Type: data.PointTypeURI, Text: u.URI},
+				{Type: data.PointTypeAuthToken, Text: u.AuthToken},
+			},
+		}, "config")
+
+		if err != nil {
+			return fmt.Errorf("Error creating upstream node: %v", err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,189 @@
+package server
+
+import (
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeConfig(t *testing.T, contents string) string {
+	file := filepath.Join(t.TempDir(), "siot.yaml")
+	err := os.WriteFile(file, []byte(contents), 0644)
+	if err != nil {
+		t.Fatal("Error writing config: ", err)
+	}
+	return file
+}
+
+func TestLoadConfig(t *testing.T) {
+	file := writeConfig(t, `
+store: test.sqlite
+http:
+  port: "9000"
+nats:
+  port: 4333
+upstream:
+  - description: cloud
+    uri: nats://cloud.example.com:4222
+    authToken: secret
+`)
+
+	c, err := LoadConfig(file)
+	if err != nil {
+		t.Fatal("Error loading config: ", err)
+	}
+
+	if c.Store != "test.sqlite" || c.HTTP.Port != "9000" || c.NATS.Port != 4333 {
+		t.Errorf("Config not loaded correctly: %+v", c)
+	}
+
+	// values not in the file keep defaults
+	if c.NATS.HTTPPort != 8222 {
+		t.Error("Expected default nats http port, got: ", c.NATS.HTTPPort)
+	}
+
+	if len(c.Upstream) != 1 || c.Upstream[0].AuthToken != "secret" {
+		t.Errorf("Upstream not loaded correctly: %+v", c.Upstream)
+	}
+
+	if err := c.Validate(); err != nil {
+		t.Error("Config should be valid: ", err)
+	}
+}
+
+func TestLoadConfigUnknownField(t *testing.T) {
+	file := writeConfig(t, "htpp:\n  port: \"9000\"\n")
+
+	_, err := LoadConfig(file)
+	if err == nil {
+		t.Error("Expected error for unknown field")
+	}
+}
+
+func TestConfigEnv(t *testing.T) {
+	file := writeConfig(t, "http:\n  port: \"9000\"\n")
+
+	c, err := LoadConfig(file)
+	if err != nil {
+		t.Fatal("Error loading config: ", err)
+	}
+
+	t.Setenv("SIOT_HTTP_PORT", "9001")
+	t.Setenv("SIOT_NATS_PORT", "4555")
+	t.Setenv("SIOT_STORE_MAX_SIZE", "1000000")
+	t.Setenv("SIOT_STORE_DEDUP", "2.5")
+	t.Setenv("SIOT_STORE_RECENT", "20")
+	t.Setenv("SIOT_STORE_READ_ONLY", "true")
+	t.Setenv("SIOT_NATS_TLS_VERIFY", "true")
+	t.Setenv("SIOT_HTTP_AUTOCERT_DOMAINS", "a.example.com,b.example.com")
+	t.Setenv("SIOT_SECRETS_KEY_FILE", "secrets.key")
+	t.Setenv("SIOT_STORE_CHAOS_DROP_RATE", "0.1")
+	t.Setenv("SIOT_STORE_CHAOS_SEED", "42")
+	t.Setenv("SIOT_STORE_PENDING_LIMIT", "500")
+	t.Setenv("SIOT_STORE_HA_INSTANCE", "a")
+	t.Setenv("SIOT_NATS_ROUTES", "nats://10.0.0.2:6222")
+	t.Setenv("SIOT_HTTP_RATE_LIMIT_IP_RATE", "5")
+	t.Setenv("SIOT_HTTP_MAX_BODY_SIZE", "4096")
+	t.Setenv("SIOT_HTTP_BASE_PATH", "/siot")
+	t.Setenv("SIOT_HTTP_TRUSTED_PROXIES", "10.0.0.1,172.16.0.0/12")
+
+	err = c.ApplyEnv()
+	if err != nil {
+		t.Fatal("Error applying env: ", err)
+	}
+
+	if c.HTTP.Port != "9001" || c.NATS.Port != 4555 || c.StoreMaxSize != 1000000 ||
+		c.StoreDedup != 2.5 || c.StoreRecent != 20 || !c.StoreReadOnly ||
+		!c.NATS.TLSVerify || len(c.HTTP.AutocertDomains) != 2 ||
+		c.SecretsKeyFile != "secrets.key" || c.StoreChaos.DropRate != 0.1 ||
+		c.StoreChaos.Seed != 42 || c.StoreWatchdog.PendingLimit != 500 ||
+		c.StoreHA.Instance != "a" || len(c.NATS.Routes) != 1 ||
+		c.HTTP.RateLimit.IPRate != 5 || c.HTTP.RateLimit.MaxBodySize != 4096 ||
+		c.HTTP.BasePath != "/siot" || len(c.HTTP.TrustedProxies) != 2 {
+		t.Errorf("Env did not override config: %+v", c)
+	}
+
+	t.Setenv("SIOT_NATS_PORT", "abc")
+	err = c.ApplyEnv()
+	if err == nil {
+		t.Error("Expected error for bad port")
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(c *Config)
+	}{
+		{"bad http port", func(c *Config) { c.HTTP.Port = "abc" }},
+		{"bad nats port", func(c *Config) { c.NATS.Port = 70000 }},
+		{"tls key missing", func(c *Config) { c.NATS.TLSCert = "cert.pem" }},
+		{"tls verify without CA", func(c *Config) {
+			c.NATS.TLSCert = "cert.pem"
+			c.NATS.TLSKey = "key.pem"
+			c.NATS.TLSVerify = true
+		}},
+		{"client key missing", func(c *Config) { c.NATS.ClientCert = "cert.pem" }},
+		{"http tls key missing", func(c *Config) { c.HTTP.TLSCert = "cert.pem" }},
+		{"http tls and autocert", func(c *Config) {
+			c.HTTP.TLSCert = "cert.pem"
+			c.HTTP.TLSKey = "key.pem"
+			c.HTTP.AutocertDomains = []string{"example.com"}
+		}},
+		{"autocert domain", func(c *Config) { c.HTTP.AutocertDomains = []string{"example.com:443"} }},
+		{"no store", func(c *Config) { c.Store = "" }},
+		{"upstream uri", func(c *Config) { c.Upstream = []ConfigUpstream{{}} }},
+		{"client password", func(c *Config) {
+			c.Auth.Clients = []ConfigClientUser{{User: "modbus"}}
+		}},
+		{"duplicate client", func(c *Config) {
+			c.Auth.Clients = []ConfigClientUser{{"modbus", "a"}, {"modbus", "b"}}
+		}},
+		{"pprof addr", func(c *Config) { c.HTTP.PprofAddr = "6060" }},
+		{"shard name", func(c *Config) { c.StoreShard = "a.b" }},
+		{"shard and shards", func(c *Config) {
+			c.StoreShard = "a"
+			c.StoreShards = []string{"b"}
+		}},
+		{"chaos latency", func(c *Config) { c.StoreChaos.Latency = -1 }},
+		{"chaos drop rate", func(c *Config) { c.StoreChaos.DropRate = 1.5 }},
+		{"watchdog slow handler", func(c *Config) { c.StoreWatchdog.SlowHandler = -1 }},
+		{"history rate", func(c *Config) { c.HTTP.History.Rate = -1 }},
+		{"rate limit max body size", func(c *Config) { c.HTTP.RateLimit.MaxBodySize = -1 }},
+		{"base path without slash", func(c *Config) { c.HTTP.BasePath = "siot" }},
+		{"base path trailing slash", func(c *Config) { c.HTTP.BasePath = "/siot/" }},
+		{"cors origin with path", func(c *Config) {
+			c.HTTP.CORSOrigins = []string{"https://example.com/app"}
+		}},
+		{"trusted proxy", func(c *Config) { c.HTTP.TrustedProxies = []string{"10.0.0"} }},
+		{"ha read-only", func(c *Config) {
+			c.StoreHA.Instance = "a"
+			c.StoreReadOnly = true
+		}},
+		{"ha timeout", func(c *Config) {
+			c.StoreHA.Instance = "a"
+			c.StoreHA.Timeout = 1.5
+		}},
+		{"ha witness", func(c *Config) {
+			c.StoreHA.Instance = "a"
+			c.StoreHA.Witness = "witness"
+		}},
+		{"nats routes without cluster port", func(c *Config) {
+			c.NATS.Routes = []string{"nats://10.0.0.2:6222"}
+		}},
+		{"secrets key", func(c *Config) { c.SecretsKey = "c2hvcnQ=" }},
+		{"secrets key and file", func(c *Config) {
+			c.SecretsKey = base64.StdEncoding.EncodeToString(make([]byte, 32))
+			c.SecretsKeyFile = "secrets.key"
+		}},
+	}
+
+	for _, test := range tests {
+		c := DefaultConfig()
+		test.modify(&c)
+		if err := c.Validate(); err == nil {
+			t.Errorf("%v: expected error", test.name)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package server
+
+import (
+	"crypto/subtle"
+	"crypto/tls"
+	"sync"
+
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/simpleiot/simpleiot/client"
+)
+
+// ExternalClientUser is a NATS user for clients that run in an external
+// process. These users can only access the subjects listed in
+// client.ExternalClientPublish and client.ExternalClientSubscribe.
+type ExternalClientUser struct {
+	User     string
+	Password string
+}
+
+// certRevocations tracks the revoked device certificates of each
+// certificate authority node
+type certRevocations struct {
+	lock    sync.RWMutex
+	serials map[string]map[string]bool
+}
+
+func newCertRevocations() *certRevocations {
+	return &certRevocations{serials: make(map[string]map[string]bool)}
+}
+
+// set replaces the revoked serial numbers of a certificate authority
+func (cr *certRevocations) set(caID string, serials []string) {
+	m := make(map[string]bool)
+	for _, s := range serials {
+		m[s] = true
+	}
+
+	cr.lock.Lock()
+	defer cr.lock.Unlock()
+	cr.serials[caID] = m
+}
+
+// revoked returns true if the client certificate of a connection has been
+// revoked
+func (cr *certRevocations) revoked(state *tls.ConnectionState) bool {
+	if state == nil || len(state.PeerCertificates) < 1 {
+		return false
+	}
+
+	serial := client.CertSerial(state.PeerCertificates[0])
+
+	cr.lock.RLock()
+	defer cr.lock.RUnlock()
+
+	for _, m := range cr.serials {
+		if m[serial] {
+			return true
+		}
+	}
+
+	return false
+}
+
+// natsAuth authenticates NATS connections. Connections with the auth token
+// (or any connection, if the token is not set) have full access. Connections
+// with external client credentials are limited to the subjects clients need.
+// Connections with a revoked client certificate are rejected.
+type natsAuth struct {
+	token   string
+	clients []ExternalClientUser
+	revoked *certRevocations
+}
+
+func equal(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+func (na natsAuth) Check(c server.ClientAuthentication) bool {
+	if na.revoked != nil && na.revoked.revoked(c.GetTLSConnectionState()) {
+		return false
+	}
+
+	opts := c.GetOpts()
+
+	if opts.Username != "" {
+		for _, u := range na.clients {
+			if equal(u.User, opts.Username) && equal(u.Password, opts.Password) {
+				c.RegisterUser(&server.User{
+					Username: u.User,
+					Permissions: &server.Permissions{
+						Publish: &server.SubjectPermission{
+							Allow: client.ExternalClientPublish,
+						},
+						Subscribe: &server.SubjectPermission{
+							Allow: client.ExternalClientSubscribe,
+						},
+					},
+				})
+				return true
+			}
+		}
+
+		return false
+	}
+
+	return na.token == "" || equal(na.token, opts.Token)
+}
@@ -0,0 +1,108 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/simpleiot/simpleiot/client"
+)
+
+func TestNatsAuthClients(t *testing.T) {
+	ns, err := newNatsServer(natsServerOptions{
+		Port:    -1,
+		Auth:    "secret",
+		Clients: []ExternalClientUser{{User: "modbus", Password: "pass"}},
+	})
+	if err != nil {
+		t.Fatal("Error creating NATS server: ", err)
+	}
+
+	go ns.Start()
+	defer ns.Shutdown()
+
+	if !ns.ReadyForConnections(5 * time.Second) {
+		t.Fatal("NATS server did not start")
+	}
+
+	url := ns.ClientURL()
+
+	// the auth token has full access
+	nc, err := nats.Connect(url, nats.Token("secret"))
+	if err != nil {
+		t.Fatal("Error connecting with token: ", err)
+	}
+	defer nc.Close()
+
+	_, err = nats.Connect(url, nats.UserInfo("modbus", "wrong"))
+	if err == nil {
+		t.Fatal("Connected with wrong password")
+	}
+
+	chErr := make(chan error, 10)
+	ncc, err := nats.Connect(url, nats.UserInfo("modbus", "pass"),
+		nats.ErrorHandler(func(_ *nats.Conn, _ *nats.Subscription, err error) {
+			chErr <- err
+		}))
+	if err != nil {
+		t.Fatal("Error connecting with client user: ", err)
+	}
+	defer ncc.Close()
+
+	// client users can send points and make requests
+	sub, err := nc.Subscribe(client.SubjectNodePoints("123"), func(msg *nats.Msg) {
+		msg.Respond(nil)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Unsubscribe()
+
+	_, err = ncc.Request(client.SubjectNodePoints("123"), nil, time.Second)
+	if err != nil {
+		t.Fatal("Client user request failed: ", err)
+	}
+
+	// but not access other subjects
+	err = ncc.Publish("auth.user", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ncc.Flush()
+
+	select {
+	case err := <-chErr:
+		if !strings.Contains(strings.ToLower(err.Error()), "permissions violation") {
+			t.Error("Expected permission violation, got: ", err)
+		}
+	case <-time.After(time.Second):
+		t.Error("Client user was allowed to publish to auth.user")
+	}
+}
+
+func TestCertRevocations(t *testing.T) {
+	cr := newCertRevocations()
+
+	state := &tls.ConnectionState{PeerCertificates: []*x509.Certificate{
+		{SerialNumber: big.NewInt(0xabc)}}}
+
+	if cr.revoked(state) || cr.revoked(nil) {
+		t.Fatal("cert should not be revoked")
+	}
+
+	cr.set("ca1", []string{"abc"})
+	if !cr.revoked(state) {
+		t.Fatal("cert should be revoked")
+	}
+
+	// each CA sends its complete list
+	cr.set("ca2", nil)
+	cr.set("ca1", nil)
+	if cr.revoked(state) {
+		t.Fatal("cert should not be revoked after it was removed")
+	}
+}
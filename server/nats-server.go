@@ -3,19 +3,26 @@ package server
 import (
 	"fmt"
 	"log"
+	"strings"
 	"time"
 
 	"github.com/nats-io/nats-server/v2/server"
 )
 
 type natsServerOptions struct {
-	Port       int
-	HTTPPort   int
-	WSPort     int
-	Auth       string
-	TLSCert    string
-	TLSKey     string
-	TLSTimeout float64
+	Port        int
+	HTTPPort    int
+	WSPort      int
+	ClusterPort int
+	Routes      []string
+	Auth        string
+	TLSCert     string
+	TLSKey      string
+	TLSTimeout  float64
+	TLSCA       string
+	TLSVerify   bool
+	Clients     []ExternalClientUser
+	Revoked     *certRevocations
 }
 
 // newNatsServer creates a new nats server instance
@@ -27,12 +34,29 @@ func newNatsServer(o natsServerOptions) (*server.Server, error) {
 		NoSigs:        true,
 	}
 
+	if len(o.Clients) > 0 || o.TLSVerify {
+		// the token, client users, and revoked certs are checked by natsAuth
+		opts.Authorization = ""
+		opts.CustomClientAuthentication = natsAuth{token: o.Auth, clients: o.Clients,
+			revoked: o.Revoked}
+	}
+
+	// a cluster connects the NATS servers of the instances of a high
+	// availability store pair
+	if o.ClusterPort != 0 {
+		opts.Cluster.Name = "siot"
+		opts.Cluster.Port = o.ClusterPort
+		opts.Routes = server.RoutesFromStr(strings.Join(o.Routes, ","))
+	}
+
 	if o.TLSCert != "" && o.TLSKey != "" {
-		log.Println("Setting up NATS TLS ...")
+		log.Printf("Setting up NATS TLS, client certificates required: %v\n", o.TLSVerify)
 		opts.TLS = true
 		opts.TLSCert = o.TLSCert
 		opts.TLSKey = o.TLSKey
 		opts.TLSTimeout = o.TLSTimeout
+		opts.TLSCaCert = o.TLSCA
+		opts.TLSVerify = o.TLSVerify
 		tc := server.TLSConfigOpts{}
 		tc.CertFile = opts.TLSCert
 		tc.KeyFile = opts.TLSKey
@@ -67,6 +91,10 @@ func newNatsServer(o natsServerOptions) (*server.Server, error) {
 		authEnabled = "yes"
 	}
 
+	if len(o.Clients) > 0 {
+		authEnabled += fmt.Sprintf(", external client users: %v", len(o.Clients))
+	}
+
 	log.Printf("NATS server, port: %v, http port: %v, auth enabled: %v\n",
 		o.Port, o.HTTPPort, authEnabled)
 
@@ -74,5 +102,9 @@ func newNatsServer(o natsServerOptions) (*server.Server, error) {
 		log.Printf("NATS server WS enabled on port: %v\n", o.WSPort)
 	}
 
+	if o.ClusterPort != 0 {
+		log.Printf("NATS cluster port: %v, routes: %v\n", o.ClusterPort, o.Routes)
+	}
+
 	return natsServer, nil
 }
@@ -0,0 +1,66 @@
+package server
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path"
+
+	"github.com/simpleiot/simpleiot/store"
+)
+
+// secretsCredential is the name of the systemd credential the secrets key
+// is loaded from if no key is configured. systemd can seal credentials with
+// the TPM (systemd-creds encrypt --with-key=tpm2).
+const secretsCredential = "siot-secrets-key"
+
+// decodeSecretsKey decodes a base64 encoded secrets key. Key files may also
+// contain the raw key.
+func decodeSecretsKey(d []byte) ([]byte, error) {
+	if len(d) == store.SecretsKeySize {
+		return d, nil
+	}
+
+	key, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(d)))
+	if err != nil {
+		return nil, fmt.Errorf("secrets key is not valid base64: %v", err)
+	}
+
+	if len(key) != store.SecretsKeySize {
+		return nil, fmt.Errorf("secrets key must be %v bytes, got %v",
+			store.SecretsKeySize, len(key))
+	}
+
+	return key, nil
+}
+
+// secretsKey loads the key used to encrypt secret points. The key is read
+// from, in order: the SecretsKey option, the SecretsKeyFile option, and the
+// systemd credentials directory. nil is returned if no key is found.
+func secretsKey(o Options) ([]byte, error) {
+	if o.SecretsKey != "" {
+		return decodeSecretsKey([]byte(o.SecretsKey))
+	}
+
+	if o.SecretsKeyFile != "" {
+		d, err := os.ReadFile(o.SecretsKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("Error reading secrets key file: %v", err)
+		}
+		return decodeSecretsKey(d)
+	}
+
+	if dir := os.Getenv("CREDENTIALS_DIRECTORY"); dir != "" {
+		d, err := os.ReadFile(path.Join(dir, secretsCredential))
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Error reading secrets key credential: %v", err)
+		}
+		return decodeSecretsKey(d)
+	}
+
+	return nil, nil
+}
@@ -7,7 +7,6 @@ import (
 	"fmt"
 	"log"
 	"os"
-	"path"
 	"strconv"
 	"strings"
 	"syscall"
@@ -27,7 +26,11 @@ var version = "Development"
 
 // StartArgs starts SIOT with more command line style args
 func StartArgs(args []string) error {
-	defaultNatsServer := "nats://localhost:4222"
+	defaultNatsServer := DefaultConfig().NATS.Server
+
+	if len(args) > 1 && args[1] == "config" {
+		return configCommand(args[2:])
+	}
 
 	// =============================================
 	// Command line options
@@ -36,6 +39,7 @@ func StartArgs(args []string) error {
 
 	// configuration options
 	flagDebugHTTP := flags.Bool("debugHttp", false, "Dump http requests")
+	flagPprofAddr := flags.String("pprofAddr", "", "serve Go pprof endpoints on this address, for example localhost:6060 (env: SIOT_PPROF_ADDR)")
 	flagDebugLifecycle := flags.Bool("debugLifecycle", false, "Debug program lifecycle")
 	flagSim := flags.Bool("sim", false, "Start node simulator")
 	flagDisableAuth := flags.Bool("disableAuth", false, "Disable user auth (used for development)")
@@ -44,6 +48,13 @@ func StartArgs(args []string) error {
 	flagNatsServer := flags.String("natsServer", defaultNatsServer, "NATS Server")
 	flagNatsDisableServer := flags.Bool("natsDisableServer", false, "Disable NATS server (if you want to run NATS separately)")
 	flagStore := flags.String("store", "siot.sqlite", "store file, default siot.sqlite")
+	flagStoreMaxSize := flags.Int64("storeMaxSize", 0, "store size limit in bytes, 0 for no limit (env: SIOT_STORE_MAX_SIZE)")
+	flagStoreDedup := flags.Float64("storeDedup", 0, "drop identical points received within this many seconds, 0 to disable (env: SIOT_STORE_DEDUP)")
+	flagStoreRecent := flags.Int("storeRecent", 0, "number of recent values kept in memory for each point, 0 to disable (env: SIOT_STORE_RECENT)")
+	flagStoreReadOnly := flags.Bool("storeReadOnly", false, "open an existing store read-only, for example a replica (env: SIOT_STORE_READ_ONLY)")
+	flagStoreShard := flags.String("storeShard", "", "run the store as a shard with this name (env: SIOT_STORE_SHARD)")
+	flagStoreShards := flags.String("storeShards", "", "comma separated list of store shards to coordinate (env: SIOT_STORE_SHARDS)")
+	flagConfig := flags.String("config", "", "YAML config file (env: SIOT_CONFIG)")
 	flagAuthToken := flags.String("token", "", "Auth token")
 	flagNatsAck := flags.Bool("natsAck", false, "request response")
 	flagSyslog := flags.Bool("syslog", false, "log to syslog instead of stdout")
@@ -72,90 +83,78 @@ func StartArgs(args []string) error {
 
 	log.Printf("SimpleIOT %v\n", version)
 
-	// set up local database
-	dataDir := os.Getenv("SIOT_DATA")
-	if dataDir == "" {
-		dataDir = "./"
-	}
-
-	// populate files in file system
-	err := files.UpdateFiles(dataDir)
-
-	if err != nil {
-		log.Println("Error updating files: ", err)
-		os.Exit(-1)
-	}
-
-	storeFilePath := path.Join(dataDir, *flagStore)
-
 	// =============================================
-	// NATS stuff
+	// Configuration
 	// =============================================
 
-	// populate general args
-	natsPort := 4222
+	config := DefaultConfig()
 
-	natsPortE := os.Getenv("SIOT_NATS_PORT")
-	if natsPortE != "" {
-		n, err := strconv.Atoi(natsPortE)
-		if err != nil {
-			log.Println("Error parsing SIOT_NATS_PORT: ", err)
-			os.Exit(-1)
-		}
-		natsPort = n
+	configFile := *flagConfig
+	if configFile == "" {
+		configFile = os.Getenv("SIOT_CONFIG")
 	}
 
-	natsHTTPPort := 8222
-
-	natsHTTPPortE := os.Getenv("SIOT_NATS_HTTP_PORT")
-	if natsHTTPPortE != "" {
-		n, err := strconv.Atoi(natsHTTPPortE)
+	if configFile != "" {
+		var err error
+		config, err = LoadConfig(configFile)
 		if err != nil {
-			log.Println("Error parsing SIOT_NATS_HTTP_PORT: ", err)
+			log.Println(err)
 			os.Exit(-1)
 		}
-		natsHTTPPort = n
 	}
 
-	natsWSPort := 9222
-	natsWSPortE := os.Getenv("SIOT_NATS_WS_PORT")
-	if natsWSPortE != "" {
-		n, err := strconv.Atoi(natsWSPortE)
-		if err != nil {
-			log.Println("Error parsing SIOT_NATS_WS_PORT: ", err)
-			os.Exit(-1)
-		}
-		natsWSPort = n
+	if err := config.ApplyEnv(); err != nil {
+		log.Println(err)
+		os.Exit(-1)
 	}
 
-	natsServer := *flagNatsServer
-	// only consider env if command line option is something different
-	// that default
-	if natsServer == defaultNatsServer {
-		natsServerE := os.Getenv("SIOT_NATS_SERVER")
-		if natsServerE != "" {
-			natsServer = natsServerE
+	// command line flags override config file and environment
+	flags.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "debugHttp":
+			config.HTTP.Debug = *flagDebugHTTP
+		case "pprofAddr":
+			config.HTTP.PprofAddr = *flagPprofAddr
+		case "disableAuth":
+			config.Auth.Disable = *flagDisableAuth
+		case "natsServer":
+			config.NATS.Server = *flagNatsServer
+		case "natsDisableServer":
+			config.NATS.DisableServer = *flagNatsDisableServer
+		case "store":
+			config.Store = *flagStore
+		case "storeMaxSize":
+			config.StoreMaxSize = *flagStoreMaxSize
+		case "storeDedup":
+			config.StoreDedup = *flagStoreDedup
+		case "storeRecent":
+			config.StoreRecent = *flagStoreRecent
+		case "storeReadOnly":
+			config.StoreReadOnly = *flagStoreReadOnly
+		case "storeShard":
+			config.StoreShard = *flagStoreShard
+		case "storeShards":
+			config.StoreShards = strings.Split(*flagStoreShards, ",")
+		case "token":
+			config.Auth.Token = *flagAuthToken
 		}
-	}
+	})
 
-	natsTLSCert := os.Getenv("SIOT_NATS_TLS_CERT")
-	natsTLSKey := os.Getenv("SIOT_NATS_TLS_KEY")
-	natsTLSTimeoutS := os.Getenv("SIOT_NATS_TLS_TIMEOUT")
+	if err := config.Validate(); err != nil {
+		log.Println("Config error: ", err)
+		os.Exit(-1)
+	}
 
-	natsTLSTimeout := 0.5
+	// populate files in file system
+	err := files.UpdateFiles(config.DataDir)
 
-	if natsTLSTimeoutS != "" {
-		natsTLSTimeout, err = strconv.ParseFloat(natsTLSTimeoutS, 64)
-		if err != nil {
-			log.Println("Error parsing nats TLS timeout: ", err)
-			os.Exit(-1)
-		}
+	if err != nil {
+		log.Println("Error updating files: ", err)
+		os.Exit(-1)
 	}
 
-	authToken := os.Getenv("SIOT_AUTH_TOKEN")
-	if *flagAuthToken != "" {
-		authToken = *flagAuthToken
-	}
+	natsServer := config.NATS.Server
+	authToken := config.Auth.Token
 
 	if *flagSyslog {
 		err := system.EnableSyslog()
@@ -373,44 +372,13 @@ func StartArgs(args []string) error {
 	}
 
 	// finally, start web server
-	port := os.Getenv("SIOT_HTTP_PORT")
-	if port == "" {
-		port = "8080"
-	}
-
-	osVersionField := os.Getenv("OS_VERSION_FIELD")
-	if osVersionField == "" {
-		osVersionField = "VERSION"
-	}
-
-	// set up particle connection if configured
-	// todo -- move this to a node
-	particleAPIKey := os.Getenv("SIOT_PARTICLE_API_KEY")
-
-	// TODO, convert this to builder pattern
-	o := Options{
-		StoreFile:         storeFilePath,
-		HTTPPort:          port,
-		DebugHTTP:         *flagDebugHTTP,
-		DebugLifecycle:    *flagDebugLifecycle,
-		DisableAuth:       *flagDisableAuth,
-		NatsServer:        natsServer,
-		NatsDisableServer: *flagNatsDisableServer,
-		NatsPort:          natsPort,
-		NatsHTTPPort:      natsHTTPPort,
-		NatsWSPort:        natsWSPort,
-		NatsTLSCert:       natsTLSCert,
-		NatsTLSKey:        natsTLSKey,
-		NatsTLSTimeout:    natsTLSTimeout,
-		AuthToken:         authToken,
-		ParticleAPIKey:    particleAPIKey,
-		AppVersion:        version,
-		OSVersionField:    osVersionField,
-	}
+	o := config.Options()
+	o.DebugLifecycle = *flagDebugLifecycle
+	o.AppVersion = version
 
 	var g run.Group
 
-	siot, _, err := NewServer(o)
+	siot, siotNc, err := NewServer(o)
 
 	if err != nil {
 		siot.Stop(nil)
@@ -425,12 +393,23 @@ func StartArgs(args []string) error {
 	// add check to make sure server started
 	chStartCheck := make(chan struct{})
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second*9)
+	if config.StoreHA.Instance != "" {
+		// the standby of an HA pair starts when it takes over
+		cancel()
+		ctx, cancel = context.WithCancel(context.Background())
+	}
 	g.Add(func() error {
 		err := siot.WaitStart(ctx)
 		if err != nil {
 			return errors.New("Timeout waiting for SIOT to start")
 		}
 		log.Println("SIOT started")
+		if !config.StoreReadOnly && config.StoreShard == "" {
+			err = createUpstreams(siotNc, config.Upstream)
+			if err != nil {
+				log.Println("Error creating upstreams from config: ", err)
+			}
+		}
 		<-chStartCheck
 		return nil
 	}, func(err error) {
@@ -441,6 +420,39 @@ func StartArgs(args []string) error {
 	return g.Run()
 }
 
+// configCommand runs the config subcommands
+func configCommand(args []string) error {
+	if len(args) < 1 || args[0] != "validate" {
+		return errors.New("usage: siot config validate [file]")
+	}
+
+	file := os.Getenv("SIOT_CONFIG")
+	if len(args) > 1 {
+		file = args[1]
+	}
+
+	config := DefaultConfig()
+
+	if file != "" {
+		var err error
+		config, err = LoadConfig(file)
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := config.ApplyEnv(); err != nil {
+		return err
+	}
+
+	if err := config.Validate(); err != nil {
+		return fmt.Errorf("Config error: %v", err)
+	}
+
+	fmt.Println("Config is valid")
+	return nil
+}
+
 func parsePointText(s string) (string, data.Point, error) {
 	frags := strings.Split(s, ":")
 	if len(frags) != 4 {
@@ -2,10 +2,13 @@ package server
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net"
+	"strings"
 	"sync"
 	"time"
 
@@ -19,14 +22,37 @@ import (
 	"github.com/simpleiot/simpleiot/node"
 	"github.com/simpleiot/simpleiot/particle"
 	"github.com/simpleiot/simpleiot/store"
+	"github.com/simpleiot/simpleiot/system"
 )
 
 // Options used for starting Simple IoT
 type Options struct {
 	StoreFile         string
+	StoreMaxSize      int64
+	StoreDedup        float64
+	StoreRecent       int
+	StoreReadOnly     bool
+	StoreShard        string
+	StoreShards       []string
+	StoreChaos        store.Chaos
+	StoreHA           store.HAParams
+	StoreSlowHandler  time.Duration
+	StorePendingLimit int
+	SecretsKey        string
+	SecretsKeyFile    string
 	DataDir           string
 	HTTPPort          string
 	DebugHTTP         bool
+	PprofAddr         string
+	HTTPTLSCert       string
+	HTTPTLSKey        string
+	AutocertDomains   []string
+	AutocertEmail     string
+	HTTPHistory       api.HistoryLimits
+	HTTPRateLimits    api.RateLimits
+	HTTPBasePath      string
+	CORSOrigins       []string
+	TrustedProxies    []string
 	DebugLifecycle    bool
 	DisableAuth       bool
 	NatsServer        string
@@ -34,15 +60,34 @@ type Options struct {
 	NatsPort          int
 	NatsHTTPPort      int
 	NatsWSPort        int
+	NatsClusterPort   int
+	NatsRoutes        []string
 	NatsTLSCert       string
 	NatsTLSKey        string
 	NatsTLSTimeout    float64
+	NatsTLSCA         string
+	NatsTLSVerify     bool
+	NatsClientCert    string
+	NatsClientKey     string
+	NatsClientCA      string
 	AuthToken         string
+	ExternalClients   []ExternalClientUser
 	ParticleAPIKey    string
 	AppVersion        string
 	OSVersionField    string
 }
 
+// storeRunner is the store run by the server, either a single store or an
+// instance of a high availability pair
+type storeRunner interface {
+	Start() error
+	Stop(error)
+	WaitStart(context.Context) error
+	StartMetrics(string) error
+	StopMetrics(error)
+	WritePrometheus(io.Writer) error
+}
+
 // Server represents a SIOT server process
 type Server struct {
 	nc                 *nats.Conn
@@ -51,14 +96,39 @@ type Server struct {
 	chNatsClientClosed chan struct{}
 	chStop             chan struct{}
 	chWaitStart        chan struct{}
+	nodeManager        *node.Manager
 }
 
 // NewServer creates a new server
 func NewServer(o Options) (*Server, *nats.Conn, error) {
 	chNatsClientClosed := make(chan struct{})
 
+	s := &Server{
+		options:            o,
+		chNatsClientClosed: chNatsClientClosed,
+		chStop:             make(chan struct{}),
+		chWaitStart:        make(chan struct{}),
+	}
+
+	secure := func(*nats.Options) error { return nil }
+
+	clientTLS := client.TLSOptions{
+		Cert: o.NatsClientCert,
+		Key:  o.NatsClientKey,
+		CA:   o.NatsClientCA,
+	}
+
+	if clientTLS.Enabled() {
+		tlsConfig, err := clientTLS.Config()
+		if err != nil {
+			return s, nil, fmt.Errorf("NATS client TLS: %v", err)
+		}
+		secure = nats.Secure(tlsConfig)
+	}
+
 	// start the server side nats client
 	nc, err := nats.Connect(o.NatsServer,
+		secure,
 		nats.Timeout(10*time.Second),
 		nats.PingInterval(60*5*time.Second),
 		nats.MaxPingsOutstanding(5),
@@ -83,13 +153,9 @@ func NewServer(o Options) (*Server, *nats.Conn, error) {
 		}),
 	)
 
-	return &Server{
-		nc:                 nc,
-		options:            o,
-		chNatsClientClosed: chNatsClientClosed,
-		chStop:             make(chan struct{}),
-		chWaitStart:        make(chan struct{}),
-	}, nc, err
+	s.nc = nc
+
+	return s, nc, err
 }
 
 // Start the server -- only returns if there is an error
@@ -112,10 +178,11 @@ func (s *Server) Start() error {
 	if o.DisableAuth {
 		auth = api.AlwaysValid{}
 	} else {
-		auth, err = api.NewKey(20)
+		key, err := api.NewKey(20)
 		if err != nil {
 			log.Println("Error generating key: ", err)
 		}
+		auth = api.NewSessions(key)
 	}
 
 	// anything that needs to use the store or nats server should add to this wait group.
@@ -126,13 +193,38 @@ func (s *Server) Start() error {
 	// Nats server
 	// ====================================
 	natsOptions := natsServerOptions{
-		Port:       o.NatsPort,
-		HTTPPort:   o.NatsHTTPPort,
-		WSPort:     o.NatsWSPort,
-		Auth:       o.AuthToken,
-		TLSCert:    o.NatsTLSCert,
-		TLSKey:     o.NatsTLSKey,
-		TLSTimeout: o.NatsTLSTimeout,
+		Port:        o.NatsPort,
+		HTTPPort:    o.NatsHTTPPort,
+		WSPort:      o.NatsWSPort,
+		ClusterPort: o.NatsClusterPort,
+		Routes:      o.NatsRoutes,
+		Auth:        o.AuthToken,
+		TLSCert:     o.NatsTLSCert,
+		TLSKey:      o.NatsTLSKey,
+		TLSTimeout:  o.NatsTLSTimeout,
+		TLSCA:       o.NatsTLSCA,
+		TLSVerify:   o.NatsTLSVerify,
+		Clients:     o.ExternalClients,
+	}
+
+	if o.NatsTLSVerify {
+		natsOptions.Revoked = newCertRevocations()
+
+		// certificate authority nodes publish revoked device certs
+		_, err := s.nc.Subscribe(client.SubjectCertRevoked("*"), func(msg *nats.Msg) {
+			var r client.CertRevoked
+			err := json.Unmarshal(msg.Data, &r)
+			if err != nil {
+				log.Println("Error decoding revoked certs: ", err)
+				return
+			}
+
+			chunks := strings.Split(msg.Subject, ".")
+			natsOptions.Revoked.set(chunks[1], r.Serials)
+		})
+		if err != nil {
+			return fmt.Errorf("Error subscribing to revoked certs: %v", err)
+		}
 	}
 
 	if !o.NatsDisableServer {
@@ -159,21 +251,47 @@ func (s *Server) Start() error {
 	// SIOT Store
 	// ====================================
 
+	secrets, err := secretsKey(o)
+	if err != nil {
+		return err
+	}
+
 	storeParams := store.Params{
-		File:      o.StoreFile,
-		AuthToken: o.AuthToken,
-		Server:    o.NatsServer,
-		Key:       auth,
-		Nc:        s.nc,
+		File:         o.StoreFile,
+		AuthToken:    o.AuthToken,
+		Server:       o.NatsServer,
+		Key:          auth,
+		Nc:           s.nc,
+		MaxSize:      o.StoreMaxSize,
+		DedupWindow:  time.Duration(o.StoreDedup * float64(time.Second)),
+		RecentLen:    o.StoreRecent,
+		ReadOnly:     o.StoreReadOnly,
+		Shard:        o.StoreShard,
+		Shards:       o.StoreShards,
+		SecretsKey:   secrets,
+		Chaos:        o.StoreChaos,
+		SlowHandler:  o.StoreSlowHandler,
+		PendingLimit: o.StorePendingLimit,
 	}
 
-	siotStore, err := store.NewStore(storeParams)
+	var siotStore storeRunner
+
+	if o.StoreHA.Instance != "" {
+		siotStore, err = store.NewHA(storeParams, o.StoreHA)
+	} else {
+		siotStore, err = store.NewStore(storeParams)
+	}
 
 	if err != nil {
 		log.Fatal("Error creating store: ", err)
 	}
 
 	siotWaitCtx, siotWaitCancel := context.WithTimeout(context.Background(), time.Second*10)
+	if o.StoreHA.Instance != "" {
+		// the standby of an HA pair waits until it takes over
+		siotWaitCancel()
+		siotWaitCtx, siotWaitCancel = context.WithCancel(context.Background())
+	}
 
 	g.Add(func() error {
 		err := siotStore.Start()
@@ -189,89 +307,116 @@ func (s *Server) Start() error {
 		}()
 	})
 
-	cancelTimer := make(chan struct{})
+	// metrics, nodes, and clients all write to the store, so they are not
+	// run for a read-only store. A shard store shares NATS with its
+	// coordinator, which runs them for the whole tree.
+	if !o.StoreReadOnly && o.StoreShard == "" {
+		cancelTimer := make(chan struct{})
 
-	storeWg.Add(1)
-	g.Add(func() error {
-		defer storeWg.Done()
-		err := siotStore.WaitStart(siotWaitCtx)
-		if err != nil {
-			logLS("LS: Exited: metrics timeout waiting for store")
-			return err
-		}
+		storeWg.Add(1)
+		g.Add(func() error {
+			defer storeWg.Done()
+			err := siotStore.WaitStart(siotWaitCtx)
+			if err != nil {
+				logLS("LS: Exited: metrics timeout waiting for store")
+				return err
+			}
 
-		// Hack -- this needs moved to a client
-		t := time.NewTimer(10 * time.Second)
+			// Hack -- this needs moved to a client
+			t := time.NewTimer(10 * time.Second)
 
-		select {
-		case <-t.C:
-		case <-cancelTimer:
-			logLS("LS: Exited: store metrics")
-			return nil
-		}
+			select {
+			case <-t.C:
+			case <-cancelTimer:
+				logLS("LS: Exited: store metrics")
+				return nil
+			}
 
-		rootNode, err := client.GetNode(s.nc, "root", "")
+			rootNode, err := client.GetNode(s.nc, "root", "")
 
-		if err != nil {
-			logLS("LS: Exited: store metrics")
-			return fmt.Errorf("Error getting root id for metrics: %v", err)
-		} else if len(rootNode) == 0 {
+			if err != nil {
+				logLS("LS: Exited: store metrics")
+				return fmt.Errorf("Error getting root id for metrics: %v", err)
+			} else if len(rootNode) == 0 {
+				logLS("LS: Exited: store metrics")
+				return fmt.Errorf("Error getting root node, no data")
+			}
+
+			err = siotStore.StartMetrics(rootNode[0].ID)
 			logLS("LS: Exited: store metrics")
-			return fmt.Errorf("Error getting root node, no data")
-		}
+			return err
+		}, func(err error) {
+			close(cancelTimer)
+			siotStore.StopMetrics(err)
+			logLS("LS: Shutdown: store metrics")
+		})
 
-		err = siotStore.StartMetrics(rootNode[0].ID)
-		logLS("LS: Exited: store metrics")
-		return err
-	}, func(err error) {
-		close(cancelTimer)
-		siotStore.StopMetrics(err)
-		logLS("LS: Shutdown: store metrics")
-	})
+		// ====================================
+		// Node manager
+		// ====================================
+		nodeManager := node.NewManger(s.nc, o.AppVersion, o.OSVersionField)
+		s.nodeManager = nodeManager
 
-	// ====================================
-	// Node manager
-	// ====================================
-	nodeManager := node.NewManger(s.nc, o.AppVersion, o.OSVersionField)
+		storeWg.Add(1)
+		g.Add(func() error {
+			defer storeWg.Done()
+			err := siotStore.WaitStart(siotWaitCtx)
+			if err != nil {
+				logLS("LS: Exited: node manager timeout waiting for store")
+				return err
+			}
 
-	storeWg.Add(1)
-	g.Add(func() error {
-		defer storeWg.Done()
-		err := siotStore.WaitStart(siotWaitCtx)
-		if err != nil {
-			logLS("LS: Exited: node manager timeout waiting for store")
+			err = nodeManager.Start()
+			logLS("LS: Exited: node manager")
 			return err
-		}
+		}, func(err error) {
+			nodeManager.Stop(err)
+			logLS("LS: Shutdown: node manager")
+		})
 
-		err = nodeManager.Start()
-		logLS("LS: Exited: node manager")
-		return err
-	}, func(err error) {
-		nodeManager.Stop(err)
-		logLS("LS: Shutdown: node manager")
-	})
+		// ====================================
+		// Build in clients manager
+		// ====================================
 
-	// ====================================
-	// Build in clients manager
-	// ====================================
+		clientsManager := client.NewBuiltInClients(s.nc)
+		storeWg.Add(1)
+		g.Add(func() error {
+			defer storeWg.Done()
+			err := siotStore.WaitStart(siotWaitCtx)
+			if err != nil {
+				logLS("LS: Exited: client manager timeout waiting for store")
+				return err
+			}
 
-	clientsManager := client.NewBuiltInClients(s.nc)
-	storeWg.Add(1)
-	g.Add(func() error {
-		defer storeWg.Done()
-		err := siotStore.WaitStart(siotWaitCtx)
-		if err != nil {
-			logLS("LS: Exited: client manager timeout waiting for store")
+			err = clientsManager.Start()
+			logLS("LS: Exited: clients manager")
 			return err
-		}
+		}, func(err error) {
+			clientsManager.Stop(err)
+			logLS("LS: Shutdown: clients manager")
+		})
 
-		err = clientsManager.Start()
-		logLS("LS: Exited: clients manager")
-		return err
-	}, func(err error) {
-		clientsManager.Stop(err)
-		logLS("LS: Shutdown: clients manager")
-	})
+		// ====================================
+		// Certificate expiration monitor
+		// ====================================
+		certs := newCertMonitor(s.nc, o)
+		storeWg.Add(1)
+		g.Add(func() error {
+			defer storeWg.Done()
+			err := siotStore.WaitStart(siotWaitCtx)
+			if err != nil {
+				logLS("LS: Exited: cert monitor timeout waiting for store")
+				return err
+			}
+
+			err = certs.Start()
+			logLS("LS: Exited: cert monitor")
+			return err
+		}, func(err error) {
+			certs.Stop(err)
+			logLS("LS: Shutdown: cert monitor")
+		})
+	}
 
 	// ====================================
 	// Particle client
@@ -297,15 +442,27 @@ func (s *Server) Start() error {
 	// ====================================
 	// HTTP API
 	// ====================================
+	httpTLS, err := httpTLSConfig(o)
+	if err != nil {
+		return err
+	}
+
 	httpAPI := api.NewServer(api.ServerArgs{
-		Port:       o.HTTPPort,
-		NatsWSPort: o.NatsWSPort,
-		GetAsset:   frontend.Asset,
-		Filesystem: frontend.FileSystem(),
-		Debug:      o.DebugHTTP,
-		JwtAuth:    auth,
-		AuthToken:  o.AuthToken,
-		Nc:         s.nc,
+		Port:           o.HTTPPort,
+		NatsWSPort:     o.NatsWSPort,
+		GetAsset:       frontend.Asset,
+		Filesystem:     frontend.FileSystem(),
+		Debug:          o.DebugHTTP,
+		JwtAuth:        auth,
+		AuthToken:      o.AuthToken,
+		Nc:             s.nc,
+		TLSConfig:      httpTLS,
+		Metrics:        siotStore.WritePrometheus,
+		HistoryLimits:  o.HTTPHistory,
+		RateLimits:     o.HTTPRateLimits,
+		BasePath:       o.HTTPBasePath,
+		CORSOrigins:    o.CORSOrigins,
+		TrustedProxies: o.TrustedProxies,
 	})
 
 	g.Add(func() error {
@@ -317,6 +474,115 @@ func (s *Server) Start() error {
 		logLS("LS: Shutdown: http api")
 	})
 
+	// ====================================
+	// pprof endpoints
+	// ====================================
+	if o.PprofAddr != "" {
+		pprofServer := newPprofServer(o.PprofAddr)
+
+		g.Add(func() error {
+			log.Println("pprof endpoints listening on: ", o.PprofAddr)
+			err := pprofServer.ListenAndServe()
+			logLS("LS: Exited: pprof")
+			return err
+		}, func(_ error) {
+			pprofServer.Close()
+			logLS("LS: Shutdown: pprof")
+		})
+	}
+
+	// ====================================
+	// systemd notification and watchdog
+	// ====================================
+	chWatchdogStop := make(chan struct{})
+	g.Add(func() error {
+		// the store of an HA standby only starts when it takes over, so a
+		// standby is ready once the HTTP API is listening, and the store
+		// state is reported in the status
+		var chStoreActive chan struct{}
+		if o.StoreHA.Instance != "" {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+			err := httpAPI.WaitStart(ctx)
+			cancel()
+			if err != nil {
+				logLS("LS: Exited: watchdog, timeout waiting for http api")
+				return err
+			}
+
+			chStoreActive = make(chan struct{})
+			go func() {
+				if siotStore.WaitStart(siotWaitCtx) == nil {
+					close(chStoreActive)
+				}
+			}()
+		} else {
+			err := siotStore.WaitStart(siotWaitCtx)
+			if err != nil {
+				logLS("LS: Exited: watchdog, timeout waiting for store")
+				return err
+			}
+		}
+
+		sd, err := system.SdNotify("READY=1")
+		if err != nil {
+			log.Println("Error sending systemd ready notification: ", err)
+		}
+
+		if chStoreActive != nil {
+			_, err = system.SdNotify("STATUS=standby")
+			if err != nil {
+				log.Println("Error sending systemd status: ", err)
+			}
+		}
+
+		interval, err := system.SdWatchdogInterval()
+		if err != nil {
+			log.Println("systemd watchdog disabled: ", err)
+		}
+
+		t := time.NewTicker(time.Hour)
+		t.Stop()
+
+		if sd && interval > 0 {
+			log.Println("systemd watchdog enabled, interval: ", interval)
+			t.Reset(interval / 2)
+		}
+
+		for {
+			select {
+			case <-chStoreActive:
+				chStoreActive = nil
+				_, err = system.SdNotify("STATUS=active")
+				if err != nil {
+					log.Println("Error sending systemd status: ", err)
+				}
+			case <-t.C:
+				// only pet the watchdog if we are healthy so that systemd
+				// restarts a wedged instance. A standby does not run a
+				// store, so it is not checked until it is active.
+				if chStoreActive == nil {
+					err := client.HealthCheck(s.nc, interval/4)
+					if err != nil {
+						log.Println("Health check failed: ", err)
+						continue
+					}
+				}
+				_, err = system.SdNotify("WATCHDOG=1")
+				if err != nil {
+					log.Println("Error sending systemd watchdog notification: ", err)
+				}
+			case <-chWatchdogStop:
+				t.Stop()
+				system.SdNotify("STOPPING=1")
+				logLS("LS: Exited: watchdog")
+				return nil
+			}
+		}
+	}, func(_ error) {
+		close(chWatchdogStop)
+		logLS("LS: Shutdown: watchdog")
+	})
+
 	// Give us a way to stop the server
 	// and signal to waiters we have started
 	chShutdown := make(chan struct{})
@@ -0,0 +1,141 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/simpleiot/simpleiot/client"
+	"github.com/simpleiot/simpleiot/data"
+)
+
+// TestClusterMaxGateways is the maximum number of gateways in a test cluster
+const TestClusterMaxGateways = 9
+
+// TestInstance is a SIOT instance in a test cluster
+type TestInstance struct {
+	Nc      *nats.Conn
+	Root    data.NodeEdge
+	Options Options
+
+	server  *Server
+	stopped chan struct{}
+}
+
+// TestCluster is a cloud instance and gateway instances that sync to it
+// with upstream connections, running in one process
+type TestCluster struct {
+	Cloud    *TestInstance
+	Gateways []*TestInstance
+}
+
+// TestClusterOptions returns the options of instance i in a test cluster.
+// Instance 0 is the cloud, and 1-TestClusterMaxGateways are gateways. Each
+// instance has its own ports and store file.
+func TestClusterOptions(i int) Options {
+	return Options{
+		StoreFile:    fmt.Sprintf("test-cluster-%v.sqlite", i),
+		NatsPort:     4900 + i,
+		HTTPPort:     strconv.Itoa(8900 + i),
+		NatsHTTPPort: 8910 + i,
+		NatsServer:   fmt.Sprintf("nats://localhost:%v", 4900+i),
+	}
+}
+
+// StartTestCluster starts a cloud instance and the number of gateways
+// passed in. Each gateway has an upstream node that connects to the cloud.
+// StartTestCluster returns once all gateways have synced to the cloud. The
+// cluster should be stopped with Stop, even if an error is returned.
+func StartTestCluster(gateways int) (*TestCluster, error) {
+	if gateways < 1 || gateways > TestClusterMaxGateways {
+		return nil, fmt.Errorf("gateways must be 1-%v", TestClusterMaxGateways)
+	}
+
+	c := &TestCluster{}
+
+	var err error
+	c.Cloud, err = startTestInstance(TestClusterOptions(0))
+	if err != nil {
+		return c, fmt.Errorf("Error starting cloud: %v", err)
+	}
+
+	for i := 1; i <= gateways; i++ {
+		gw, err := startTestInstance(TestClusterOptions(i))
+		if err != nil {
+			return c, fmt.Errorf("Error starting gateway %v: %v", i, err)
+		}
+
+		c.Gateways = append(c.Gateways, gw)
+
+		err = c.AddUpstream(gw, c.Cloud)
+		if err != nil {
+			return c, fmt.Errorf("Error adding upstream for gateway %v: %v", i, err)
+		}
+	}
+
+	for i, gw := range c.Gateways {
+		err := c.WaitNode(c.Cloud, gw.Root.ID, 10*time.Second)
+		if err != nil {
+			return c, fmt.Errorf("gateway %v did not sync: %v", i+1, err)
+		}
+	}
+
+	return c, nil
+}
+
+// AddUpstream adds an upstream node to instance that connects to upstream
+func (c *TestCluster) AddUpstream(instance, upstream *TestInstance) error {
+	err := client.SendNode(instance.Nc, data.NodeEdge{
+		ID:     "upstream-" + strconv.Itoa(upstream.Options.NatsPort),
+		Type:   data.NodeTypeUpstream,
+		Parent: instance.Root.ID,
+		Points: data.Points{
+			{Type: data.PointTypeDescription, Text: "test upstream"},
+			{Type: data.PointTypeURI, Text: upstream.Options.NatsServer},
+		},
+	}, "test")
+	if err != nil {
+		return err
+	}
+
+	// start the upstream connection now instead of at the next poll
+	if instance.server.nodeManager != nil {
+		instance.server.nodeManager.Update()
+	}
+
+	return nil
+}
+
+// WaitNode waits for a node to exist in an instance. This can be used to
+// wait for a node created in one instance to sync to another.
+func (c *TestCluster) WaitNode(instance *TestInstance, id string, timeout time.Duration) error {
+	start := time.Now()
+	for {
+		nodes, err := client.GetNode(instance.Nc, id, "none")
+		if err == nil && len(nodes) > 0 {
+			return nil
+		}
+
+		if time.Since(start) > timeout {
+			if err == nil {
+				err = errors.New("timeout")
+			}
+			return fmt.Errorf("node %v: %v", id, err)
+		}
+
+		<-time.After(time.Millisecond * 50)
+	}
+}
+
+// Stop stops all instances in the cluster and deletes their store files
+func (c *TestCluster) Stop() {
+	for _, gw := range c.Gateways {
+		gw.stop()
+	}
+
+	if c.Cloud != nil {
+		c.Cloud.stop()
+	}
+}
@@ -0,0 +1,49 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/simpleiot/simpleiot/client"
+	"github.com/simpleiot/simpleiot/data"
+)
+
+func TestTestCluster(t *testing.T) {
+	c, err := StartTestCluster(2)
+	defer c.Stop()
+	if err != nil {
+		t.Fatal("Error starting cluster: ", err)
+	}
+
+	gw := c.Gateways[1]
+
+	v := client.Variable{ID: "ID-var", Parent: gw.Root.ID, Description: "gw var"}
+	err = client.SendNodeType(gw.Nc, v, "test")
+	if err != nil {
+		t.Fatal("Error sending node: ", err)
+	}
+
+	err = c.WaitNode(c.Cloud, v.ID, 5*time.Second)
+	if err != nil {
+		t.Fatal("Node did not sync to cloud: ", err)
+	}
+
+	// points sent in the cloud are synced down to the gateway
+	err = client.SendNodePoint(c.Cloud.Nc, v.ID, data.Point{Type: data.PointTypeValue,
+		Value: 5, Origin: "test"}, true)
+	if err != nil {
+		t.Fatal("Error sending point: ", err)
+	}
+
+	start := time.Now()
+	for {
+		vars, err := client.GetNodeType[client.Variable](gw.Nc, v.ID, v.Parent)
+		if err == nil && len(vars) > 0 && vars[0].Value == 5 {
+			break
+		}
+		if time.Since(start) > 5*time.Second {
+			t.Fatal("Timeout waiting for point to sync to gateway")
+		}
+		<-time.After(time.Millisecond * 50)
+	}
+}
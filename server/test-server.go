@@ -2,6 +2,7 @@ package server
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"os/exec"
@@ -23,45 +24,69 @@ var testServerOptions = Options{
 
 // TestServer starts a test server and returns a function to stop it
 func TestServer() (*nats.Conn, data.NodeEdge, func(), error) {
-	exec.Command("sh", "-c", "rm test.sqlite*").Run()
-	s, nc, err := NewServer(testServerOptions)
+	return TestServerOptions(testServerOptions)
+}
+
+// TestServerOptions starts a test server with the options passed in and
+// returns a function to stop it. This can be used to run test servers on
+// different ports so that tests in different packages can run in parallel.
+// The store file is deleted before the server starts and after it stops.
+func TestServerOptions(o Options) (*nats.Conn, data.NodeEdge, func(), error) {
+	ti, err := startTestInstance(o)
+	if err != nil {
+		return nil, data.NodeEdge{}, nil, err
+	}
+
+	return ti.Nc, ti.Root, ti.stop, nil
+}
+
+// startTestInstance starts a SIOT instance for testing. The store file is
+// deleted before the instance starts and after it stops.
+func startTestInstance(o Options) (*TestInstance, error) {
+	rmStore := fmt.Sprintf("rm %v*", o.StoreFile)
+	exec.Command("sh", "-c", rmStore).Run()
 
+	s, nc, err := NewServer(o)
 	if err != nil {
-		return nil, data.NodeEdge{}, nil, fmt.Errorf("Error starting siot server: %v", err)
+		return nil, fmt.Errorf("Error starting siot server: %v", err)
 	}
 
-	stopped := make(chan struct{})
+	ti := &TestInstance{Nc: nc, Options: o, server: s, stopped: make(chan struct{})}
 
 	go func() {
 		err := s.Start()
 		if err != nil {
 			log.Println("Test Server start returned: ", err)
 		}
-		close(stopped)
+		close(ti.stopped)
 	}()
 
-	stop := func() {
-		s.Stop(nil)
-		<-stopped
-		exec.Command("sh", "-c", "rm test.sqlite*").Run()
-	}
-
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
 	err = s.WaitStart(ctx)
 	cancel()
 	if err != nil {
-		return nil, data.NodeEdge{}, stop, fmt.Errorf("Error waiting for test server to start: %v", err)
+		ti.stop()
+		return nil, fmt.Errorf("Error waiting for test server to start: %v", err)
 	}
 
 	nodes, err := client.GetNode(nc, "root", "")
-
 	if err != nil {
-		return nil, data.NodeEdge{}, stop, fmt.Errorf("Get root nodes error: %v", err)
+		ti.stop()
+		return nil, fmt.Errorf("Get root nodes error: %v", err)
 	}
 
 	if len(nodes) < 1 {
-		return nil, data.NodeEdge{}, stop, fmt.Errorf("Did not get a root node")
+		ti.stop()
+		return nil, errors.New("Did not get a root node")
 	}
 
-	return nc, nodes[0], stop, nil
+	ti.Root = nodes[0]
+
+	return ti, nil
+}
+
+func (ti *TestInstance) stop() {
+	ti.server.Stop(nil)
+	<-ti.stopped
+	exec.Command("sh", "-c", fmt.Sprintf("rm %v*", ti.Options.StoreFile)).Run()
 }
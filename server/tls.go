@@ -0,0 +1,139 @@
+package server
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log"
+	"path"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/simpleiot/simpleiot/client"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// certWarnDays is how many days before a certificate expires that a
+// warning is logged
+const certWarnDays = 30
+
+// httpTLSConfig returns the TLS config for the HTTP API, or nil if HTTPS is
+// not enabled. Let's Encrypt certificates are cached in the data directory.
+func httpTLSConfig(o Options) (*tls.Config, error) {
+	if len(o.AutocertDomains) > 0 {
+		m := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(o.AutocertDomains...),
+			Cache:      autocert.DirCache(path.Join(o.DataDir, "autocert")),
+			Email:      o.AutocertEmail,
+		}
+		return m.TLSConfig(), nil
+	}
+
+	if o.HTTPTLSCert == "" {
+		return nil, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(o.HTTPTLSCert, o.HTTPTLSKey)
+	if err != nil {
+		return nil, fmt.Errorf("Error loading HTTP TLS cert: %v", err)
+	}
+
+	return &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+	}, nil
+}
+
+// certMonitor sends certExpires points to the root node for the configured
+// certificates. Let's Encrypt certificates are renewed automatically, so
+// they are not monitored.
+type certMonitor struct {
+	nc     *nats.Conn
+	certs  map[string]string
+	period time.Duration
+	stop   chan struct{}
+}
+
+func newCertMonitor(nc *nats.Conn, o Options) *certMonitor {
+	certs := make(map[string]string)
+
+	if o.NatsTLSCert != "" {
+		certs["nats"] = o.NatsTLSCert
+	}
+
+	if o.NatsClientCert != "" {
+		certs["natsClient"] = o.NatsClientCert
+	}
+
+	if o.HTTPTLSCert != "" {
+		certs["http"] = o.HTTPTLSCert
+	}
+
+	return &certMonitor{
+		nc:     nc,
+		certs:  certs,
+		period: 12 * time.Hour,
+		stop:   make(chan struct{}),
+	}
+}
+
+func (cm *certMonitor) check(rootID string) {
+	now := time.Now()
+
+	for key, cert := range cm.certs {
+		expires, err := client.CertExpires(cert)
+		if err != nil {
+			log.Printf("Error reading %v TLS cert: %v\n", key, err)
+			continue
+		}
+
+		p := client.CertExpiresPoint(key, expires, now)
+
+		if p.Value < certWarnDays {
+			log.Printf("WARNING: %v TLS cert expires in %.0f days\n", key, p.Value)
+		}
+
+		err = client.SendNodePoint(cm.nc, rootID, p, false)
+		if err != nil {
+			log.Println("Error sending cert expiration: ", err)
+		}
+	}
+}
+
+// Start checks the certificates periodically until stopped
+func (cm *certMonitor) Start() error {
+	if len(cm.certs) < 1 {
+		<-cm.stop
+		return nil
+	}
+
+	nodes, err := client.GetNode(cm.nc, "root", "")
+	if err != nil {
+		return fmt.Errorf("Error getting root node for cert monitor: %v", err)
+	}
+
+	if len(nodes) < 1 {
+		return fmt.Errorf("Error getting root node for cert monitor, no data")
+	}
+
+	rootID := nodes[0].ID
+
+	t := time.NewTicker(cm.period)
+	defer t.Stop()
+
+	cm.check(rootID)
+
+	for {
+		select {
+		case <-t.C:
+			cm.check(rootID)
+		case <-cm.stop:
+			return nil
+		}
+	}
+}
+
+// Stop the cert monitor
+func (cm *certMonitor) Stop(_ error) {
+	close(cm.stop)
+}
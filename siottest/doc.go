@@ -0,0 +1,8 @@
+// Package siottest contains helpers for writing integration tests for SIOT
+// clients. A test typically starts a test server, sends the client config
+// nodes, injects points, and then waits for nodes to reach an expected state.
+// Fake serial ports (fifos) and modbus servers are provided so that clients
+// can be tested without hardware. See client/serial_test.go for an example of
+// a test that uses these patterns. Scenario tests can also be written in
+// YAML and run with RunScenarios.
+package siottest
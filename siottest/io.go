@@ -0,0 +1,48 @@
+package siottest
+
+import (
+	"log"
+	"testing"
+
+	"github.com/simpleiot/simpleiot/client"
+	"github.com/simpleiot/simpleiot/modbus"
+	"github.com/simpleiot/simpleiot/test"
+)
+
+// SerialFifo creates the test side of a fake serial port. Clients that open
+// the serial port "serialfifo" connect to the other side of the fifo. The
+// returned wrapper encodes/decodes packets the same way the serial client
+// does. The fifo is closed when the test completes. Fifos are not supported
+// on Windows.
+func SerialFifo(t testing.TB) *client.CobsWrapper {
+	t.Helper()
+
+	fifo, err := test.NewFifoA("serialfifo")
+	if err != nil {
+		t.Fatal("Error starting fifo: ", err)
+	}
+
+	ret := client.NewCobsWrapper(fifo)
+	t.Cleanup(func() { ret.Close() })
+
+	return ret
+}
+
+// ModbusServer starts a fake modbus TCP server on port with the registers
+// passed in. The server is closed when the test completes.
+func ModbusServer(t testing.TB, id int, port string, regs *modbus.Regs) *modbus.TCPServer {
+	t.Helper()
+
+	s, err := modbus.NewTCPServer(id, 5, port, regs, 0)
+	if err != nil {
+		t.Fatal("Error starting modbus server: ", err)
+	}
+
+	go s.Listen(func(err error) {
+		log.Println("modbus test server error: ", err)
+	}, func() {}, func() {})
+
+	t.Cleanup(func() { s.Close() })
+
+	return s
+}
@@ -0,0 +1,79 @@
+package siottest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/simpleiot/simpleiot/client"
+	"github.com/simpleiot/simpleiot/data"
+)
+
+// SendNode sends a node config struct to the server. The test fails if the
+// node could not be sent.
+func SendNode[T any](t testing.TB, nc *nats.Conn, node T) {
+	t.Helper()
+
+	err := client.SendNodeType(nc, node, Origin)
+	if err != nil {
+		t.Fatal("Error sending node: ", err)
+	}
+}
+
+// SendPoints injects points into a node. Points without an Origin are
+// set to Origin so that they are passed on to clients. The test fails if
+// the points could not be sent.
+func SendPoints(t testing.TB, nc *nats.Conn, nodeID string, points ...data.Point) {
+	t.Helper()
+
+	for i := range points {
+		if points[i].Origin == "" {
+			points[i].Origin = Origin
+		}
+	}
+
+	err := client.SendNodePoints(nc, nodeID, points, true)
+	if err != nil {
+		t.Fatal("Error sending points: ", err)
+	}
+}
+
+// WaitFor polls cond until it returns true. The test fails with desc
+// if cond does not return true before timeout.
+func WaitFor(t testing.TB, timeout time.Duration, desc string, cond func() bool) {
+	t.Helper()
+
+	start := time.Now()
+	for {
+		if cond() {
+			return
+		}
+		if time.Since(start) > timeout {
+			t.Fatal("Timeout waiting for ", desc)
+		}
+		<-time.After(time.Millisecond * 10)
+	}
+}
+
+// WaitNode watches a node until cond returns true and then returns the node.
+// The test fails if cond does not return true before timeout.
+func WaitNode[T any](t testing.TB, nc *nats.Conn, id, parent string,
+	timeout time.Duration, cond func(T) bool) T {
+	t.Helper()
+
+	get, stop, err := client.NodeWatcher[T](nc, id, parent)
+	if err != nil {
+		t.Fatal("Error setting up node watcher: ", err)
+	}
+
+	defer stop()
+
+	var ret T
+
+	WaitFor(t, timeout, "node "+id, func() bool {
+		ret = get()
+		return cond(ret)
+	})
+
+	return ret
+}
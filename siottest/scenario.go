@@ -0,0 +1,311 @@
+package siottest
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/simpleiot/simpleiot/client"
+	"github.com/simpleiot/simpleiot/data"
+	"github.com/simpleiot/simpleiot/server"
+	"gopkg.in/yaml.v2"
+)
+
+// DefaultScenarioTimeout is how long expect steps wait if the step does not
+// set a timeout. Clients only see point changes after the config debounce,
+// and tests run a lot slower with the race detector, so this leaves plenty
+// of margin.
+const DefaultScenarioTimeout = client.DefaultConfigDebounce + 5*time.Second
+
+// scenarioSettle is how long a scenario waits after the nodes are created
+// before running the steps, so that clients can start with the new config
+const scenarioSettle = client.DefaultConfigDebounce + 500*time.Millisecond
+
+// Scenario is an end-to-end test described in YAML. The nodes are created
+// in order, and then the steps are run. See docs/ref/client.md for the
+// file format.
+type Scenario struct {
+	Name        string         `yaml:"name"`
+	Description string         `yaml:"description"`
+	Nodes       []ScenarioNode `yaml:"nodes"`
+	Steps       []ScenarioStep `yaml:"steps"`
+}
+
+// ScenarioNode is a node created by a scenario. The ID is used as the node
+// ID, so other nodes can refer to it. Parent defaults to the root node.
+type ScenarioNode struct {
+	ID     string         `yaml:"id"`
+	Type   string         `yaml:"type"`
+	Parent string         `yaml:"parent"`
+	Points ScenarioPoints `yaml:"points"`
+}
+
+// ScenarioStep is one step of a scenario. Exactly one field must be set.
+type ScenarioStep struct {
+	// Wait is a duration (1s, 500ms, etc) to sleep
+	Wait string `yaml:"wait"`
+	// Send injects points into a node
+	Send *ScenarioPointsStep `yaml:"send"`
+	// Expect waits for a node to have the points listed
+	Expect *ScenarioPointsStep `yaml:"expect"`
+	// ExpectNotification waits for a notification
+	ExpectNotification *ScenarioNotificationStep `yaml:"expectNotification"`
+}
+
+// ScenarioPointsStep sends or expects points on a node
+type ScenarioPointsStep struct {
+	Node    string         `yaml:"node"`
+	Points  ScenarioPoints `yaml:"points"`
+	Timeout string         `yaml:"timeout"`
+}
+
+// ScenarioNotificationStep waits for a notification whose message contains
+// Message. If Source is set, the notification must be for that node.
+type ScenarioNotificationStep struct {
+	Message string `yaml:"message"`
+	Source  string `yaml:"source"`
+	Timeout string `yaml:"timeout"`
+}
+
+// ScenarioPoints is a map of point type to value in a scenario file.
+// Numbers and booleans set the point value, and strings set the point text.
+// A point key is added after a dot (email.0).
+type ScenarioPoints map[string]interface{}
+
+// Points converts the scenario points to SIOT points
+func (sp ScenarioPoints) Points() (data.Points, error) {
+	var ret data.Points
+
+	for k, v := range sp {
+		p := data.Point{Type: k}
+		if i := strings.Index(k, "."); i >= 0 {
+			p.Type, p.Key = k[:i], k[i+1:]
+		}
+
+		switch v := v.(type) {
+		case int:
+			p.Value = float64(v)
+		case float64:
+			p.Value = v
+		case bool:
+			p.Value = data.BoolToFloat(v)
+		case string:
+			p.Text = v
+		default:
+			return nil, fmt.Errorf("point %v: unsupported value: %v", k, v)
+		}
+
+		ret = append(ret, p)
+	}
+
+	// map order is random, so sort points to make errors repeatable
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].Type != ret[j].Type {
+			return ret[i].Type < ret[j].Type
+		}
+		return ret[i].Key < ret[j].Key
+	})
+
+	return ret, nil
+}
+
+// LoadScenario reads a scenario from a YAML file. Unknown fields are
+// reported as errors.
+func LoadScenario(file string) (Scenario, error) {
+	var ret Scenario
+
+	d, err := os.ReadFile(file)
+	if err != nil {
+		return ret, err
+	}
+
+	err = yaml.UnmarshalStrict(d, &ret)
+	if err != nil {
+		return ret, fmt.Errorf("%v: %v", file, err)
+	}
+
+	if ret.Name == "" {
+		ret.Name = strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+	}
+
+	return ret, nil
+}
+
+// RunScenarios runs the scenario files that match pattern, each as a
+// subtest with its own test server started with the default options.
+func RunScenarios(t *testing.T, pattern string) {
+	RunScenariosOptions(t, DefaultOptions(), pattern)
+}
+
+// RunScenariosOptions is RunScenarios with the server options passed in
+func RunScenariosOptions(t *testing.T, o server.Options, pattern string) {
+	t.Helper()
+
+	files, err := filepath.Glob(pattern)
+	if err != nil {
+		t.Fatal("Error finding scenarios: ", err)
+	}
+
+	if len(files) < 1 {
+		t.Fatal("No scenarios found: ", pattern)
+	}
+
+	for _, f := range files {
+		s, err := LoadScenario(f)
+		if err != nil {
+			t.Error("Error loading scenario: ", err)
+			continue
+		}
+
+		t.Run(s.Name, func(t *testing.T) {
+			nc, root := ServerOptions(t, o)
+			s.Run(t, nc, root)
+		})
+	}
+}
+
+// Run creates the scenario nodes under root and runs the steps
+func (s Scenario) Run(t testing.TB, nc *nats.Conn, root data.NodeEdge) {
+	t.Helper()
+
+	var notLock sync.Mutex
+	var nots []data.Notification
+
+	sub, err := nc.Subscribe("node.*.not", func(msg *nats.Msg) {
+		n, err := data.PbDecodeNotification(msg.Data)
+		if err != nil {
+			t.Log("Error decoding notification: ", err)
+			return
+		}
+		notLock.Lock()
+		nots = append(nots, n)
+		notLock.Unlock()
+	})
+	if err != nil {
+		t.Fatal("Error subscribing to notifications: ", err)
+	}
+	defer sub.Unsubscribe()
+
+	for _, n := range s.Nodes {
+		if n.ID == "" || n.Type == "" {
+			t.Fatalf("node %+v: id and type must be set", n)
+		}
+
+		parent := n.Parent
+		if parent == "" || parent == "root" {
+			parent = root.ID
+		}
+
+		points, err := n.Points.Points()
+		if err != nil {
+			t.Fatalf("node %v: %v", n.ID, err)
+		}
+
+		for i := range points {
+			points[i].Origin = Origin
+		}
+
+		err = client.SendNode(nc, data.NodeEdge{ID: n.ID, Type: n.Type,
+			Parent: parent, Points: points}, Origin)
+		if err != nil {
+			t.Fatalf("Error sending node %v: %v", n.ID, err)
+		}
+	}
+
+	time.Sleep(scenarioSettle)
+
+	for i, step := range s.Steps {
+		desc := fmt.Sprintf("step %v", i+1)
+
+		switch {
+		case step.Wait != "":
+			time.Sleep(scenarioDuration(t, desc, step.Wait, 0))
+
+		case step.Send != nil:
+			points, err := step.Send.Points.Points()
+			if err != nil {
+				t.Fatalf("%v: %v", desc, err)
+			}
+			SendPoints(t, nc, step.Send.Node, points...)
+
+		case step.Expect != nil:
+			expect, err := step.Expect.Points.Points()
+			if err != nil {
+				t.Fatalf("%v: %v", desc, err)
+			}
+
+			timeout := scenarioDuration(t, desc, step.Expect.Timeout,
+				DefaultScenarioTimeout)
+
+			start := time.Now()
+			for {
+				nodes, err := client.GetNode(nc, step.Expect.Node, "none")
+				if err == nil && len(nodes) > 0 && pointsMatch(nodes[0].Points, expect) {
+					break
+				}
+				if time.Since(start) > timeout {
+					var got data.Points
+					if len(nodes) > 0 {
+						got = nodes[0].Points
+					}
+					t.Fatalf("%v: timeout waiting for node %v points %v, got: %v",
+						desc, step.Expect.Node, expect, got)
+				}
+				<-time.After(time.Millisecond * 10)
+			}
+
+		case step.ExpectNotification != nil:
+			e := step.ExpectNotification
+			timeout := scenarioDuration(t, desc, e.Timeout, DefaultScenarioTimeout)
+
+			WaitFor(t, timeout, fmt.Sprintf("%v: notification %q", desc, e.Message),
+				func() bool {
+					notLock.Lock()
+					defer notLock.Unlock()
+					for _, n := range nots {
+						if strings.Contains(n.Message, e.Message) &&
+							(e.Source == "" || n.SourceNode == e.Source) {
+							return true
+						}
+					}
+					return false
+				})
+
+		default:
+			t.Fatalf("%v: no action", desc)
+		}
+	}
+}
+
+// pointsMatch returns true if got contains all expected points
+func pointsMatch(got, expect data.Points) bool {
+	for _, e := range expect {
+		p, ok := got.Find(e.Type, e.Key)
+		if !ok || p.Value != e.Value || p.Text != e.Text {
+			return false
+		}
+	}
+
+	return true
+}
+
+func scenarioDuration(t testing.TB, desc, d string, def time.Duration) time.Duration {
+	t.Helper()
+
+	if d == "" {
+		return def
+	}
+
+	ret, err := time.ParseDuration(d)
+	if err != nil {
+		t.Fatalf("%v: invalid duration %q: %v", desc, d, err)
+	}
+
+	return ret
+}
@@ -0,0 +1,52 @@
+package siottest
+
+import (
+	"testing"
+
+	"github.com/nats-io/nats.go"
+	"github.com/simpleiot/simpleiot/data"
+	"github.com/simpleiot/simpleiot/server"
+)
+
+// Origin is the origin used for nodes and points sent by the helpers
+// in this package. The client manager ignores points with a blank origin,
+// so points sent to clients must have an origin set.
+const Origin = "test"
+
+// DefaultOptions returns the server options used by Server. The NATS, HTTP,
+// and store settings can be modified and passed to ServerOptions so that
+// tests in different packages can run in parallel.
+func DefaultOptions() server.Options {
+	return server.Options{
+		StoreFile:    "test.sqlite",
+		NatsPort:     4990,
+		HTTPPort:     "8990",
+		NatsHTTPPort: 8991,
+		NatsWSPort:   8992,
+		NatsServer:   "nats://localhost:4990",
+	}
+}
+
+// Server starts a SIOT test server with the default options and returns a
+// NATS connection and the root node. The server is stopped when the test
+// completes.
+func Server(t testing.TB) (*nats.Conn, data.NodeEdge) {
+	return ServerOptions(t, DefaultOptions())
+}
+
+// ServerOptions starts a SIOT test server with the options passed in. The
+// server is stopped when the test completes.
+func ServerOptions(t testing.TB, o server.Options) (*nats.Conn, data.NodeEdge) {
+	t.Helper()
+
+	nc, root, stop, err := server.TestServerOptions(o)
+	if stop != nil {
+		t.Cleanup(stop)
+	}
+
+	if err != nil {
+		t.Fatal("Error starting test server: ", err)
+	}
+
+	return nc, root
+}
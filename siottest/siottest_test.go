@@ -0,0 +1,85 @@
+package siottest_test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/simpleiot/simpleiot/client"
+	"github.com/simpleiot/simpleiot/data"
+	"github.com/simpleiot/simpleiot/modbus"
+	"github.com/simpleiot/simpleiot/siottest"
+)
+
+func TestServerNode(t *testing.T) {
+	// use different ports than the default so tests in other packages
+	// can run at the same time
+	o := siottest.DefaultOptions()
+	o.StoreFile = "siottest.sqlite"
+	o.NatsPort = 4980
+	o.HTTPPort = "8980"
+	o.NatsHTTPPort = 8981
+	o.NatsWSPort = 8982
+	o.NatsServer = "nats://localhost:4980"
+
+	nc, root := siottest.ServerOptions(t, o)
+
+	v := client.Variable{
+		ID:          "ID-var",
+		Parent:      root.ID,
+		Description: "test var",
+	}
+
+	siottest.SendNode(t, nc, v)
+
+	siottest.SendPoints(t, nc, v.ID, data.Point{Type: data.PointTypeValue, Value: 1})
+
+	got := siottest.WaitNode(t, nc, v.ID, v.Parent, time.Second, func(v client.Variable) bool {
+		return v.Value == 1
+	})
+
+	if got.Description != v.Description {
+		t.Error("Wrong description: ", got.Description)
+	}
+}
+
+func TestModbusServer(t *testing.T) {
+	regs := &modbus.Regs{}
+	regs.AddReg(2, 1)
+	err := regs.WriteReg(2, 0x1234)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	siottest.ModbusServer(t, 1, "5021", regs)
+
+	sock, err := net.DialTimeout("tcp", "localhost:5021", time.Second)
+	if err != nil {
+		t.Fatal("Error connecting to modbus server: ", err)
+	}
+
+	c := modbus.NewClient(modbus.NewTCP(sock, 500*time.Millisecond,
+		modbus.TransportClient), 0)
+	defer c.Close()
+
+	v, err := c.ReadHoldingRegs(1, 2, 1)
+	if err != nil {
+		t.Fatal("Error reading regs: ", err)
+	}
+
+	if len(v) != 1 || v[0] != 0x1234 {
+		t.Error("Wrong reg value: ", v)
+	}
+}
+
+func TestScenarios(t *testing.T) {
+	o := siottest.DefaultOptions()
+	o.StoreFile = "scenarios.sqlite"
+	o.NatsPort = 4960
+	o.HTTPPort = "8960"
+	o.NatsHTTPPort = 8961
+	o.NatsWSPort = 8962
+	o.NatsServer = "nats://localhost:4960"
+
+	siottest.RunScenariosOptions(t, o, "testdata/scenarios/*.yaml")
+}
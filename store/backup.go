@@ -0,0 +1,66 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/nats-io/nats.go"
+	"github.com/simpleiot/simpleiot/client"
+)
+
+// backup writes a consistent snapshot of the database to file while the
+// database is in use. The snapshot is vacuumed, so it is usually smaller
+// than the database file.
+func (sdb *DbSqlite) backup(file string) (int64, error) {
+	if _, err := os.Stat(file); err == nil {
+		return 0, errors.New("backup file already exists")
+	}
+
+	_, err := sdb.db.Exec("VACUUM INTO ?", file)
+	if err != nil {
+		return 0, err
+	}
+
+	info, err := os.Stat(file)
+	if err != nil {
+		return 0, err
+	}
+
+	return info.Size(), nil
+}
+
+// handleStoreBackup writes a snapshot of the store. The request is a JSON
+// encoded client.StoreBackupRequest. The snapshot is written in the
+// background, as it can take a while for large stores.
+func (st *Store) handleStoreBackup(msg *nats.Msg) {
+	go func() {
+		var res client.StoreBackupResult
+		var req client.StoreBackupRequest
+
+		err := json.Unmarshal(msg.Data, &req)
+		if err != nil {
+			res.Error = fmt.Sprintf("Error decoding backup request: %v", err)
+		} else if req.File == "" {
+			res.Error = "backup file must be set"
+		} else {
+			res.Size, err = st.db.backup(req.File)
+			if err != nil {
+				res.Error = fmt.Sprintf("Error writing backup: %v", err)
+			}
+		}
+
+		d, err := json.Marshal(res)
+		if err != nil {
+			log.Println("Error encoding backup result: ", err)
+			return
+		}
+
+		err = client.Respond(st.nc, msg, d)
+		if err != nil {
+			log.Println("Error responding to backup request: ", err)
+		}
+	}()
+}
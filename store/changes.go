@@ -0,0 +1,161 @@
+package store
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/simpleiot/simpleiot/client"
+	"github.com/simpleiot/simpleiot/data"
+)
+
+// changeHistoryLen is the number of point changes kept for each node
+var changeHistoryLen = 100
+
+func newPointChange(nodeID string, old, p data.Point) data.PointChange {
+	if p.IsSecret() {
+		// the change history is readable through the API, so it only
+		// records that a secret changed
+		if old.Text != "" {
+			old.Text = data.SecretMask
+		}
+		if p.Text != "" {
+			p.Text = data.SecretMask
+		}
+	}
+
+	return data.PointChange{
+		NodeID:    nodeID,
+		Time:      p.Time,
+		Type:      p.Type,
+		Key:       p.Key,
+		OldValue:  old.Value,
+		OldText:   old.Text,
+		Value:     p.Value,
+		Text:      p.Text,
+		Tombstone: p.Tombstone,
+		Origin:    p.Origin,
+	}
+}
+
+// writeChanges records point changes for a node and removes the oldest
+// changes if there are more than changeHistoryLen.
+func writeChanges(tx *sql.Tx, nodeID string, changes []data.PointChange) error {
+	for _, c := range changes {
+		tS := c.Time.Unix()
+		tNs := c.Time.UnixNano() - 1e9*tS
+		_, err := tx.Exec(`INSERT INTO point_changes(node_id, type, key, time_s,
+			time_ns, old_value, old_text, value, text, tombstone, origin)
+			VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			nodeID, c.Type, c.Key, tS, tNs, c.OldValue, c.OldText, c.Value,
+			c.Text, c.Tombstone, c.Origin)
+		if err != nil {
+			return fmt.Errorf("Error writing point change: %w", err)
+		}
+	}
+
+	_, err := tx.Exec(`DELETE FROM point_changes WHERE node_id=? AND rowid NOT IN
+		(SELECT rowid FROM point_changes WHERE node_id=?
+		ORDER BY time_s DESC, time_ns DESC, rowid DESC LIMIT ?)`,
+		nodeID, nodeID, changeHistoryLen)
+	if err != nil {
+		return fmt.Errorf("Error trimming point changes: %w", err)
+	}
+
+	return nil
+}
+
+// changes returns the recorded point changes for a node, newest first.
+// If typ or key are set, only changes to matching points are returned.
+func (sdb *DbSqlite) changes(nodeID, typ, key string, limit int) ([]data.PointChange, error) {
+	q := `SELECT type, key, time_s, time_ns, old_value, old_text, value, text,
+		tombstone, origin FROM point_changes WHERE node_id=?`
+	args := []any{nodeID}
+
+	if typ != "" {
+		q += " AND type=?"
+		args = append(args, typ)
+	}
+
+	if key != "" {
+		q += " AND key=?"
+		args = append(args, key)
+	}
+
+	q += " ORDER BY time_s DESC, time_ns DESC, rowid DESC"
+
+	if limit > 0 {
+		q += " LIMIT ?"
+		args = append(args, limit)
+	}
+
+	rows, err := sdb.db.Query(q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ret []data.PointChange
+
+	for rows.Next() {
+		c := data.PointChange{NodeID: nodeID}
+		var timeS, timeNS int64
+		err := rows.Scan(&c.Type, &c.Key, &timeS, &timeNS, &c.OldValue, &c.OldText,
+			&c.Value, &c.Text, &c.Tombstone, &c.Origin)
+		if err != nil {
+			return nil, err
+		}
+		c.Time = time.Unix(timeS, timeNS)
+		ret = append(ret, c)
+	}
+
+	return ret, rows.Err()
+}
+
+// handleNodeChanges returns the point change history of a node. The request
+// is a JSON encoded client.ChangesQuery.
+func (st *Store) handleNodeChanges(msg *nats.Msg) {
+	var res client.ChangesResult
+
+	var id string
+	chunks := strings.Split(msg.Subject, ".")
+	if len(chunks) < 3 {
+		res.Error = fmt.Sprintf("Error in message subject: %v", msg.Subject)
+	} else {
+		id = chunks[1]
+	}
+
+	var q client.ChangesQuery
+	if res.Error == "" && len(msg.Data) > 0 {
+		if err := json.Unmarshal(msg.Data, &q); err != nil {
+			res.Error = fmt.Sprintf("Error decoding changes query: %v", err)
+		}
+	}
+
+	if res.Error == "" {
+		if id == "root" {
+			id = st.db.rootNodeID()
+		}
+
+		var err error
+		res.Changes, err = st.db.changes(id, q.Type, q.Key, q.Limit)
+		if err != nil {
+			res.Error = fmt.Sprintf("Error getting changes for %v: %v", id, err)
+		}
+	}
+
+	d, err := json.Marshal(res)
+	if err != nil {
+		log.Println("Error encoding changes response: ", err)
+		return
+	}
+
+	err = client.Respond(st.nc, msg, d)
+	if err != nil {
+		log.Println("NATS: Error publishing response to changes request: ", err)
+	}
+}
@@ -0,0 +1,67 @@
+package store
+
+import (
+	"testing"
+	"time"
+
+	"github.com/simpleiot/simpleiot/data"
+)
+
+func TestDbSqliteChanges(t *testing.T) {
+	db := newTestDb(t)
+	defer db.Close()
+
+	nodeID := "node"
+	start := time.Now()
+
+	write := func(i int, p data.Point) {
+		p.Time = start.Add(time.Duration(i) * time.Second)
+		if err := db.nodePoints(nodeID, data.Points{p}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write(0, data.Point{Type: data.PointTypeValueSet, Value: 1, Origin: "user1"})
+	// sensor data without origin is not recorded
+	write(1, data.Point{Type: data.PointTypeValue, Value: 10})
+	write(2, data.Point{Type: data.PointTypeValueSet, Value: 2, Origin: "user2"})
+	// no change in value is not recorded
+	write(3, data.Point{Type: data.PointTypeValueSet, Value: 2, Origin: "user2"})
+
+	changes, err := db.changes(nodeID, "", "", 0)
+	if err != nil {
+		t.Fatal("Error getting changes: ", err)
+	}
+
+	if len(changes) != 2 {
+		t.Fatal("expected 2 changes, got: ", changes)
+	}
+
+	c := changes[0]
+	if c.Origin != "user2" || c.OldValue != 1 || c.Value != 2 ||
+		!c.Time.Equal(start.Add(2*time.Second)) {
+		t.Error("wrong change: ", c)
+	}
+
+	changeHistoryLen = 5
+	defer func() { changeHistoryLen = 100 }()
+
+	for i := 0; i < 10; i++ {
+		write(10+i, data.Point{Type: data.PointTypeDescription,
+			Text: string(rune('a' + i)), Origin: "user1"})
+	}
+
+	changes, err = db.changes(nodeID, data.PointTypeDescription, "", 0)
+	if err != nil {
+		t.Fatal("Error getting changes: ", err)
+	}
+
+	if len(changes) != 5 || changes[0].Text != "j" || changes[0].OldText != "i" {
+		t.Fatal("changes not trimmed: ", changes)
+	}
+
+	changes, err = db.changes(nodeID, "", "", 2)
+	if err != nil || len(changes) != 2 {
+		t.Fatal("limit not applied: ", changes, err)
+	}
+}
@@ -0,0 +1,92 @@
+package store
+
+import (
+	"fmt"
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// Chaos configures fault injection in the store. It is meant for testing
+// that clients and rules tolerate a slow or flaky store, and should not be
+// enabled in production.
+type Chaos struct {
+	// Latency is the maximum delay before a message is handled, like a
+	// slow database. Each message is delayed a random time up to Latency.
+	Latency time.Duration
+	// DropRate is the fraction (0-1) of messages that are dropped without
+	// being handled. Requests time out.
+	DropRate float64
+	// ReplyFailRate is the fraction (0-1) of requests that are handled,
+	// but whose reply is lost. Requests time out even though they
+	// succeeded.
+	ReplyFailRate float64
+	// Seed is used to seed the random faults so a run can be repeated. If
+	// 0, a random seed is used.
+	Seed int64
+}
+
+// Enabled returns true if any faults are configured
+func (c Chaos) Enabled() bool {
+	return c.Latency > 0 || c.DropRate > 0 || c.ReplyFailRate > 0
+}
+
+func (c Chaos) String() string {
+	return fmt.Sprintf("latency: %v, drop rate: %v, reply fail rate: %v, seed: %v",
+		c.Latency, c.DropRate, c.ReplyFailRate, c.Seed)
+}
+
+// chaos injects the faults configured by Chaos into message handlers
+type chaos struct {
+	Chaos
+	lock sync.Mutex
+	rand *rand.Rand
+}
+
+func newChaos(c Chaos) *chaos {
+	if c.Seed == 0 {
+		c.Seed = time.Now().UnixNano()
+	}
+
+	return &chaos{
+		Chaos: c,
+		rand:  rand.New(rand.NewSource(c.Seed)),
+	}
+}
+
+// roll returns the random values used for a message
+func (c *chaos) roll() (latency time.Duration, drop, replyFail float64) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.Latency > 0 {
+		latency = time.Duration(c.rand.Int63n(int64(c.Latency) + 1))
+	}
+
+	return latency, c.rand.Float64(), c.rand.Float64()
+}
+
+// wrap returns a handler that injects faults before calling h
+func (c *chaos) wrap(h nats.MsgHandler) nats.MsgHandler {
+	return func(msg *nats.Msg) {
+		latency, drop, replyFail := c.roll()
+
+		time.Sleep(latency)
+
+		if drop < c.DropRate {
+			log.Println("Store chaos: dropped message: ", msg.Subject)
+			return
+		}
+
+		if msg.Reply != "" && replyFail < c.ReplyFailRate {
+			log.Println("Store chaos: dropping reply: ", msg.Subject)
+			// handlers don't respond if there is no reply subject
+			msg.Reply = ""
+		}
+
+		h(msg)
+	}
+}
@@ -0,0 +1,37 @@
+package store
+
+import (
+	"testing"
+
+	"github.com/nats-io/nats.go"
+)
+
+func TestChaos(t *testing.T) {
+	c := newChaos(Chaos{DropRate: 0.2, ReplyFailRate: 0.3, Seed: 1})
+
+	handled, replies := 0, 0
+	h := c.wrap(func(msg *nats.Msg) {
+		handled++
+		if msg.Reply != "" {
+			replies++
+		}
+	})
+
+	const count = 1000
+	for i := 0; i < count; i++ {
+		h(&nats.Msg{Subject: "p.n1", Reply: "inbox"})
+	}
+
+	// with a fixed seed, rates are close to the configured values
+	if handled < 750 || handled > 850 {
+		t.Error("unexpected number of messages handled: ", handled)
+	}
+
+	if replies < handled*6/10 || replies > handled*8/10 {
+		t.Error("unexpected number of replies: ", replies)
+	}
+
+	if (Chaos{Seed: 1}).Enabled() {
+		t.Error("chaos with no faults should not be enabled")
+	}
+}
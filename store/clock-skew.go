@@ -0,0 +1,226 @@
+package store
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/simpleiot/simpleiot/data"
+)
+
+// defaultClockSkewLimit is used if a node has a clock skew policy but no
+// limit
+var defaultClockSkewLimit = time.Hour
+
+// clockSkewPolicy is how node points with times further than limit from the
+// store time are handled
+type clockSkewPolicy struct {
+	policy string
+	limit  time.Duration
+}
+
+// clockSkew indexes the clock skew policies of nodes. A policy applies to
+// the points of the node it is set on and of its descendants, so it can be
+// set once on a client node (for example a Modbus bus) for all of its IO
+// nodes. The policy of the nearest node wins. Resolved policies are cached
+// per node until a policy or edge changes.
+type clockSkew struct {
+	lock     sync.Mutex
+	policies map[string]clockSkewPolicy
+	resolved map[string]clockSkewPolicy
+	count    int64
+}
+
+func newClockSkew() *clockSkew {
+	return &clockSkew{
+		policies: make(map[string]clockSkewPolicy),
+		resolved: make(map[string]clockSkewPolicy),
+	}
+}
+
+// update updates the policy of a node from its points
+func (cs *clockSkew) update(nodeID string, points data.Points) {
+	cs.lock.Lock()
+	defer cs.lock.Unlock()
+
+	p, ok := cs.policies[nodeID]
+	changed := false
+
+	for _, pt := range points {
+		switch pt.Type {
+		case data.PointTypeClockSkewPolicy:
+			p.policy = pt.Text
+			if pt.Tombstone != 0 {
+				p.policy = ""
+			}
+			changed = true
+		case data.PointTypeClockSkewLimit:
+			p.limit = time.Duration(pt.Value * float64(time.Second))
+			if pt.Tombstone != 0 {
+				p.limit = 0
+			}
+			changed = true
+		}
+	}
+
+	if !changed {
+		return
+	}
+
+	if p.policy == "" && p.limit == 0 {
+		if !ok {
+			return
+		}
+		delete(cs.policies, nodeID)
+	} else {
+		cs.policies[nodeID] = p
+	}
+
+	cs.resolved = make(map[string]clockSkewPolicy)
+}
+
+// invalidate clears the resolved policies. This is called when edges
+// change, as nodes may have moved.
+func (cs *clockSkew) invalidate() {
+	cs.lock.Lock()
+	defer cs.lock.Unlock()
+	if len(cs.resolved) > 0 {
+		cs.resolved = make(map[string]clockSkewPolicy)
+	}
+}
+
+// policy returns the policy for the points of a node. up returns the
+// parents of a node.
+func (cs *clockSkew) policy(nodeID string, up func(string) ([]string, error)) clockSkewPolicy {
+	cs.lock.Lock()
+	defer cs.lock.Unlock()
+
+	if len(cs.policies) == 0 {
+		return clockSkewPolicy{}
+	}
+
+	if p, ok := cs.resolved[nodeID]; ok {
+		return p
+	}
+
+	// breadth first, so the nearest ancestor with a policy wins
+	visited := map[string]bool{nodeID: true}
+	ids := []string{nodeID}
+	var ret clockSkewPolicy
+
+done:
+	for len(ids) > 0 {
+		var next []string
+		for _, id := range ids {
+			if p, ok := cs.policies[id]; ok && p.policy != "" {
+				ret = p
+				break done
+			}
+
+			ups, err := up(id)
+			if err != nil {
+				// don't cache, so it is resolved again on the next write
+				return clockSkewPolicy{}
+			}
+
+			for _, u := range ups {
+				if u != "root" && !visited[u] {
+					visited[u] = true
+					next = append(next, u)
+				}
+			}
+		}
+		ids = next
+	}
+
+	if ret.limit <= 0 {
+		ret.limit = defaultClockSkewLimit
+	}
+
+	cs.resolved[nodeID] = ret
+	return ret
+}
+
+// apply handles the points with times further than the policy limit from
+// now, and returns the points to write and the points rejected
+func (cs *clockSkew) apply(p clockSkewPolicy, points data.Points, now time.Time) (data.Points, data.Points) {
+	if p.policy == "" {
+		return points, nil
+	}
+
+	ret := make(data.Points, 0, len(points))
+	var rejected data.Points
+	skewed := 0
+
+	for _, pt := range points {
+		skew := pt.Time.Sub(now)
+		if skew <= p.limit && skew >= -p.limit {
+			ret = append(ret, pt)
+			continue
+		}
+
+		skewed++
+
+		switch p.policy {
+		case data.PointValueReject:
+			rejected = append(rejected, pt)
+			continue
+		case data.PointValueClamp:
+			meta := make(map[string]string, len(pt.Meta)+1)
+			for k, v := range pt.Meta {
+				meta[k] = v
+			}
+			meta[data.PointMetaClockSkewTime] = pt.Time.Format(time.RFC3339Nano)
+			pt.Meta = meta
+			pt.Time = now
+		case data.PointValueFlag:
+			if pt.GoodQuality() {
+				pt.Quality = data.PointQualityClockSkew
+			}
+		}
+
+		ret = append(ret, pt)
+	}
+
+	if skewed > 0 {
+		cs.lock.Lock()
+		cs.count += int64(skewed)
+		cs.lock.Unlock()
+	}
+
+	return ret, rejected
+}
+
+// report returns the number of points outside of the policy limit since
+// the last report
+func (cs *clockSkew) report() int64 {
+	cs.lock.Lock()
+	defer cs.lock.Unlock()
+	ret := cs.count
+	cs.count = 0
+	return ret
+}
+
+// reasonClockSkew is the quarantine reason for points rejected by a clock
+// skew policy
+const reasonClockSkew = "time is outside of the clock skew limit"
+
+// errClockSkew is returned to clients when points are rejected
+func errClockSkew(rejected int) error {
+	return fmt.Errorf("%v points quarantined, %v", rejected, reasonClockSkew)
+}
+
+func (st *Store) loadClockSkew() error {
+	for _, typ := range []string{data.PointTypeClockSkewPolicy, data.PointTypeClockSkewLimit} {
+		policies, err := st.db.pointsOfType(typ)
+		if err != nil {
+			return err
+		}
+
+		for id, points := range policies {
+			st.clockSkew.update(id, points)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,115 @@
+package store
+
+import (
+	"testing"
+	"time"
+
+	"github.com/simpleiot/simpleiot/data"
+)
+
+func TestClockSkewPolicy(t *testing.T) {
+	cs := newClockSkew()
+
+	// bus -> io, io2 has no policy on any ancestor
+	parents := map[string][]string{
+		"io":  {"bus"},
+		"bus": {"root"},
+		"io2": {"root"},
+	}
+
+	lookups := 0
+	up := func(id string) ([]string, error) {
+		lookups++
+		return parents[id], nil
+	}
+
+	if p := cs.policy("io", up); p.policy != "" || lookups != 0 {
+		t.Fatal("expected no policy and no lookups without policies")
+	}
+
+	cs.update("bus", data.Points{
+		{Type: data.PointTypeClockSkewPolicy, Text: data.PointValueClamp},
+		{Type: data.PointTypeClockSkewLimit, Value: 60},
+	})
+
+	p := cs.policy("io", up)
+	if p.policy != data.PointValueClamp || p.limit != time.Minute {
+		t.Fatal("io did not inherit the bus policy: ", p)
+	}
+
+	if p := cs.policy("io2", up); p.policy != "" {
+		t.Fatal("io2 should not have a policy: ", p)
+	}
+
+	// resolved policies are cached
+	lookups = 0
+	cs.policy("io", up)
+	if lookups != 0 {
+		t.Error("policy was not cached")
+	}
+
+	// a policy on the node overrides the inherited one
+	cs.update("io", data.Points{{Type: data.PointTypeClockSkewPolicy, Text: data.PointValueReject}})
+	p = cs.policy("io", up)
+	if p.policy != data.PointValueReject || p.limit != defaultClockSkewLimit {
+		t.Fatal("expected io policy with default limit: ", p)
+	}
+
+	// nodes that move pick up the policy of their new parent
+	parents["io2"] = []string{"bus"}
+	cs.invalidate()
+	if p := cs.policy("io2", up); p.policy != data.PointValueClamp {
+		t.Fatal("moved node did not get the new policy: ", p)
+	}
+
+	cs.update("bus", data.Points{{Type: data.PointTypeClockSkewPolicy, Tombstone: 1}})
+	if p := cs.policy("io2", up); p.policy != "" {
+		t.Fatal("deleted policy still applied: ", p)
+	}
+}
+
+func TestClockSkewApply(t *testing.T) {
+	cs := newClockSkew()
+	now := time.Now()
+	old := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	points := func() data.Points {
+		return data.Points{
+			{Time: now, Type: data.PointTypeValue, Key: "ok"},
+			{Time: old, Type: data.PointTypeValue, Key: "past"},
+			{Time: now.Add(2 * time.Hour), Type: data.PointTypeValue, Key: "future"},
+		}
+	}
+
+	ret, rejected := cs.apply(clockSkewPolicy{}, points(), now)
+	if len(ret) != 3 || len(rejected) != 0 {
+		t.Error("points changed without a policy")
+	}
+
+	ret, rejected = cs.apply(clockSkewPolicy{data.PointValueReject, time.Hour}, points(), now)
+	if len(ret) != 1 || len(rejected) != 2 || ret[0].Key != "ok" {
+		t.Error("expected skewed points to be rejected: ", ret)
+	}
+
+	ret, _ = cs.apply(clockSkewPolicy{data.PointValueClamp, time.Hour}, points(), now)
+	if len(ret) != 3 || !ret[1].Time.Equal(now) || !ret[2].Time.Equal(now) {
+		t.Error("expected skewed points to be clamped: ", ret)
+	}
+	if ret[1].Meta[data.PointMetaClockSkewTime] != old.Format(time.RFC3339Nano) {
+		t.Error("original time not kept in meta: ", ret[1].Meta)
+	}
+
+	ret, _ = cs.apply(clockSkewPolicy{data.PointValueFlag, time.Hour}, points(), now)
+	if ret[0].Quality != "" || ret[1].Quality != data.PointQualityClockSkew ||
+		!ret[1].Time.Equal(old) {
+		t.Error("expected skewed points to be flagged: ", ret)
+	}
+
+	if c := cs.report(); c != 6 {
+		t.Error("expected 6 skewed points, got: ", c)
+	}
+
+	if c := cs.report(); c != 0 {
+		t.Error("count was not reset: ", c)
+	}
+}
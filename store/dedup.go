@@ -0,0 +1,92 @@
+package store
+
+import (
+	"bytes"
+	"sync"
+	"time"
+
+	"github.com/simpleiot/simpleiot/data"
+)
+
+// dedupEntry is the last point accepted for a node point type/key
+type dedupEntry struct {
+	point    data.Point
+	received time.Time
+}
+
+// dedup drops node points that are identical to a point written for the
+// same node, type, key, and origin within the dedup window. This is used to
+// cut store and history write load from clients that retransmit unchanged
+// values. Points from different origins are not duplicates, so a user can
+// set the value a device last reported.
+type dedup struct {
+	window     time.Duration
+	lock       sync.Mutex
+	last       map[string]dedupEntry
+	suppressed int64
+}
+
+func newDedup(window time.Duration) *dedup {
+	return &dedup{
+		window: window,
+		last:   make(map[string]dedupEntry),
+	}
+}
+
+// samePoint returns true if the point values are identical. Time and
+// origin are not compared.
+func samePoint(a, b data.Point) bool {
+	return a.Value == b.Value && a.Text == b.Text && a.Index == b.Index &&
+		a.Tombstone == b.Tombstone && a.Quality == b.Quality &&
+		bytes.Equal(a.Data, b.Data)
+}
+
+func dedupKey(nodeID string, p data.Point) string {
+	return nodeID + "." + p.Type + "." + p.Key + "." + p.Origin
+}
+
+// filter returns the points that are not duplicates of recorded points
+func (d *dedup) filter(nodeID string, points data.Points, now time.Time) data.Points {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	ret := make(data.Points, 0, len(points))
+
+	for _, p := range points {
+		e, ok := d.last[dedupKey(nodeID, p)]
+		if ok && now.Sub(e.received) < d.window && samePoint(e.point, p) {
+			d.suppressed++
+			continue
+		}
+
+		ret = append(ret, p)
+	}
+
+	return ret
+}
+
+// record remembers points after they were written. Points that failed to be
+// written are not recorded, so a retry is not dropped as a duplicate.
+func (d *dedup) record(nodeID string, points data.Points, now time.Time) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	for _, p := range points {
+		d.last[dedupKey(nodeID, p)] = dedupEntry{point: p, received: now}
+	}
+}
+
+// expire removes entries that are older than the dedup window and returns
+// the total number of points suppressed.
+func (d *dedup) expire(now time.Time) int64 {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	for k, e := range d.last {
+		if now.Sub(e.received) >= d.window {
+			delete(d.last, k)
+		}
+	}
+
+	return d.suppressed
+}
@@ -0,0 +1,65 @@
+package store
+
+import (
+	"testing"
+	"time"
+
+	"github.com/simpleiot/simpleiot/data"
+)
+
+func TestDedup(t *testing.T) {
+	d := newDedup(10 * time.Second)
+	now := time.Now()
+
+	p := data.Point{Time: now, Type: data.PointTypeValue, Value: 1}
+
+	if len(d.filter("n1", data.Points{p}, now)) != 1 {
+		t.Fatal("first point should not be dropped")
+	}
+
+	// points are only duplicates once they are written
+	if len(d.filter("n1", data.Points{p}, now)) != 1 {
+		t.Fatal("point that was not recorded was dropped")
+	}
+
+	d.record("n1", data.Points{p}, now)
+
+	// identical value with a new timestamp is a duplicate
+	p.Time = now.Add(time.Second)
+	if len(d.filter("n1", data.Points{p}, now.Add(time.Second))) != 0 {
+		t.Error("duplicate point was not dropped")
+	}
+
+	// other nodes and changed values are not duplicates
+	if len(d.filter("n2", data.Points{p}, now.Add(time.Second))) != 1 {
+		t.Error("point for other node was dropped")
+	}
+
+	// the same value from another origin is not a duplicate
+	p.Origin = "user"
+	if len(d.filter("n1", data.Points{p}, now.Add(time.Second))) != 1 {
+		t.Error("point from other origin was dropped")
+	}
+
+	p2 := p
+	p2.Origin = ""
+	p2.Value = 2
+	if len(d.filter("n1", data.Points{p2}, now.Add(2*time.Second))) != 1 {
+		t.Error("changed point was dropped")
+	}
+
+	d.record("n1", data.Points{p2}, now.Add(2*time.Second))
+
+	// duplicates after the window are accepted
+	if len(d.filter("n1", data.Points{p2}, now.Add(13*time.Second))) != 1 {
+		t.Error("point after dedup window was dropped")
+	}
+
+	if s := d.expire(now.Add(30 * time.Second)); s != 1 {
+		t.Error("expected 1 suppressed, got: ", s)
+	}
+
+	if len(d.last) != 0 {
+		t.Error("entries were not expired")
+	}
+}
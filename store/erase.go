@@ -0,0 +1,261 @@
+package store
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/nats-io/nats.go"
+	"github.com/simpleiot/simpleiot/client"
+)
+
+// erasedOrigin replaces the origin of points and changes that were made by
+// an erased node, like the points a user set on other nodes
+const erasedOrigin = "erased"
+
+// inClause returns a placeholder list and args for ids
+func inClause(ids []string) (string, []any) {
+	args := make([]any, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+
+	return "(" + strings.TrimSuffix(strings.Repeat("?,", len(ids)), ",") + ")", args
+}
+
+// eraseIDs returns id and the descendants of id that are only referenced
+// from within the subtree. Descendants that are also children of nodes
+// outside of the subtree are kept, along with their own descendants.
+func eraseIDs(tx *sql.Tx, id string) ([]string, error) {
+	ups := make(map[string][]string)
+	ids := []string{id}
+	found := map[string]bool{id: true}
+
+	for i := 0; i < len(ids); i++ {
+		rows, err := tx.Query(`SELECT down FROM edges WHERE up=?`, ids[i])
+		if err != nil {
+			return nil, err
+		}
+
+		var downs []string
+		for rows.Next() {
+			var down string
+			if err := rows.Scan(&down); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			downs = append(downs, down)
+		}
+		rows.Close()
+
+		for _, d := range downs {
+			if !found[d] {
+				found[d] = true
+				ids = append(ids, d)
+			}
+		}
+	}
+
+	for _, n := range ids[1:] {
+		rows, err := tx.Query(`SELECT up FROM edges WHERE down=?`, n)
+		if err != nil {
+			return nil, err
+		}
+
+		for rows.Next() {
+			var up string
+			if err := rows.Scan(&up); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			ups[n] = append(ups[n], up)
+		}
+		rows.Close()
+	}
+
+	// remove nodes with a parent outside of the subtree until no more
+	// nodes are removed
+	for changed := true; changed; {
+		changed = false
+		for _, n := range ids[1:] {
+			if !found[n] {
+				continue
+			}
+
+			for _, up := range ups[n] {
+				if !found[up] {
+					found[n] = false
+					changed = true
+					break
+				}
+			}
+		}
+	}
+
+	var ret []string
+	for _, n := range ids {
+		if found[n] {
+			ret = append(ret, n)
+		}
+	}
+
+	return ret, nil
+}
+
+// erase removes a node and its descendants with all of their points,
+// edges, change history, and quarantined points. The origin of points and
+// changes made by the erased nodes is replaced with erasedOrigin. Erasing a
+// node that does not exist is not an error.
+func (sdb *DbSqlite) erase(id string) (client.EraseReport, error) {
+	ret := client.EraseReport{Source: "store"}
+
+	err := sdb.tx(func(tx *sql.Tx) error {
+		exec := func(count *int64, query string, args ...any) error {
+			res, err := tx.Exec(query, args...)
+			if err != nil {
+				return err
+			}
+			n, err := res.RowsAffected()
+			if err != nil {
+				return err
+			}
+			*count += n
+			return nil
+		}
+
+		ids, err := eraseIDs(tx, id)
+		if err != nil {
+			return err
+		}
+
+		ret.NodeIDs = ids
+		in, args := inClause(ids)
+
+		var edges int64
+		err = exec(&edges, `DELETE FROM edge_points WHERE edge_id IN
+			(SELECT id FROM edges WHERE up IN `+in+` OR down IN `+in+`)`,
+			append(args, args...)...)
+		if err != nil {
+			return err
+		}
+		ret.Points += edges
+
+		err = exec(&ret.Edges, `DELETE FROM edges WHERE up IN `+in+` OR down IN `+in,
+			append(args, args...)...)
+		if err != nil {
+			return err
+		}
+
+		for _, n := range ids {
+			var points int64
+			err := exec(&points, `DELETE FROM node_points WHERE node_id=?`, n)
+			if err != nil {
+				return err
+			}
+
+			if points > 0 {
+				ret.Nodes++
+				ret.Points += points
+			}
+		}
+
+		err = exec(&ret.Changes, `DELETE FROM point_changes WHERE node_id IN `+in, args...)
+		if err != nil {
+			return err
+		}
+
+		err = exec(&ret.Quarantine, `DELETE FROM quarantine WHERE node_id IN `+in, args...)
+		if err != nil {
+			return err
+		}
+
+		for _, table := range []string{"node_points", "edge_points", "point_changes"} {
+			err := exec(&ret.Anonymized, `UPDATE `+table+` SET origin=? WHERE origin IN `+in,
+				append([]any{erasedOrigin}, args...)...)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return client.EraseReport{Source: "store"}, err
+	}
+
+	return ret, nil
+}
+
+// handleNodeErase erases a node from the store. The node is deleted from its
+// parents first, so clients and the UI see it removed. The request is a JSON
+// encoded client.EraseRequest and the response is a client.EraseResult with
+// the store report.
+func (st *Store) handleNodeErase(msg *nats.Msg) {
+	var res client.EraseResult
+
+	respond := func() {
+		d, err := json.Marshal(res)
+		if err != nil {
+			log.Println("Error encoding erase response: ", err)
+			return
+		}
+
+		err = client.Respond(st.nc, msg, d)
+		if err != nil {
+			log.Println("NATS: Error publishing response to erase request: ", err)
+		}
+	}
+
+	chunks := strings.Split(msg.Subject, ".")
+	if len(chunks) < 3 {
+		res.Error = fmt.Sprintf("Error in message subject: %v", msg.Subject)
+		respond()
+		return
+	}
+
+	id := chunks[1]
+
+	if id == "root" || id == st.db.rootNodeID() {
+		res.Error = "the root node can't be erased"
+		respond()
+		return
+	}
+
+	ups, err := st.db.up(id, false)
+	if err != nil {
+		res.Error = fmt.Sprintf("Error getting parents of %v: %v", id, err)
+		respond()
+		return
+	}
+
+	for _, up := range ups {
+		err := client.DeleteNode(st.nc, id, up, erasedOrigin)
+		if err != nil {
+			res.Error = fmt.Sprintf("Error deleting %v from %v: %v", id, up, err)
+			respond()
+			return
+		}
+	}
+
+	report, err := st.db.erase(id)
+	if err != nil {
+		res.Error = fmt.Sprintf("Error erasing %v: %v", id, err)
+		respond()
+		return
+	}
+
+	for _, n := range report.NodeIDs {
+		st.tags.remove(n)
+		st.recent.remove(n)
+	}
+
+	log.Printf("Store erased %v: %v nodes, %v edges, %v points, %v changes, %v quarantined, %v anonymized\n",
+		id, report.Nodes, report.Edges, report.Points, report.Changes, report.Quarantine,
+		report.Anonymized)
+
+	res.Reports = []client.EraseReport{report}
+	respond()
+}
@@ -0,0 +1,132 @@
+package store
+
+import (
+	"log"
+	"sync"
+
+	"github.com/simpleiot/simpleiot/data"
+)
+
+// eventQueueLen is the number of events each processor can queue before
+// the write path blocks
+var eventQueueLen = 1000
+
+// pointsEvent is published on the event bus after points are written to the
+// store. parentID is set for edge points.
+type pointsEvent struct {
+	nodeID   string
+	parentID string
+	nodeDesc string
+	points   data.Points
+}
+
+// eventProcessor handles events from the bus in its own goroutine, in the
+// order they were published
+type eventProcessor struct {
+	name   string
+	handle func(pointsEvent)
+	ch     chan pointsEvent
+}
+
+// eventBus decouples the store write path from the processing that follows
+// a write, such as sending points to the up subjects that rules, the
+// database client, and upstream sync listen on. The write path persists
+// points, publishes an event, and acks. Each processor has a bounded queue,
+// so a slow processor does not hold up the others, and the write path only
+// blocks when a queue is full.
+type eventBus struct {
+	processors []*eventProcessor
+	chStop     chan struct{}
+	wg         sync.WaitGroup
+}
+
+func newEventBus() *eventBus {
+	return &eventBus{chStop: make(chan struct{})}
+}
+
+// add registers a processor. Processors must be added before start.
+func (b *eventBus) add(name string, handle func(pointsEvent)) {
+	b.processors = append(b.processors, &eventProcessor{
+		name:   name,
+		handle: handle,
+		ch:     make(chan pointsEvent, eventQueueLen),
+	})
+}
+
+func (b *eventBus) start() {
+	for _, p := range b.processors {
+		b.wg.Add(1)
+		go func(p *eventProcessor) {
+			defer b.wg.Done()
+			for {
+				select {
+				case ev := <-p.ch:
+					p.handle(ev)
+				case <-b.chStop:
+					// finish the events that are already queued
+					for {
+						select {
+						case ev := <-p.ch:
+							p.handle(ev)
+						default:
+							return
+						}
+					}
+				}
+			}
+		}(p)
+	}
+}
+
+// publish queues an event for all processors. It blocks while a processor
+// queue is full. Events published after stop are dropped.
+func (b *eventBus) publish(ev pointsEvent) {
+	for _, p := range b.processors {
+		select {
+		case p.ch <- ev:
+		case <-b.chStop:
+			return
+		}
+	}
+}
+
+// pending returns the number of queued events for each processor
+func (b *eventBus) pending() map[string]int {
+	ret := make(map[string]int, len(b.processors))
+	for _, p := range b.processors {
+		ret[p.name] = len(p.ch)
+	}
+	return ret
+}
+
+// stop handles the queued events and waits for the processors to exit
+func (b *eventBus) stop() {
+	close(b.chStop)
+	b.wg.Wait()
+}
+
+// forwardUpstream sends written points to the up subjects of the node and
+// its ancestors
+func (st *Store) forwardUpstream(ev pointsEvent) {
+	var err error
+	if ev.parentID != "" {
+		err = st.processEdgePointsUpstream(ev.nodeID, ev.nodeID, ev.parentID, ev.points)
+	} else {
+		err = st.processPointsUpstream(ev.nodeID, ev.nodeID, ev.nodeDesc, ev.points)
+	}
+
+	if err != nil {
+		// TODO track error stats
+		log.Println("Error processing point in upstream nodes: ", err)
+	}
+}
+
+// forwardFiltered sends written node points to matching filter
+// subscriptions
+func (st *Store) forwardFiltered(ev pointsEvent) {
+	if ev.parentID != "" {
+		return
+	}
+
+	st.sendFilteredPoints(ev.nodeID, ev.points)
+}
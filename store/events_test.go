@@ -0,0 +1,74 @@
+package store
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/simpleiot/simpleiot/data"
+)
+
+func TestEventBus(t *testing.T) {
+	b := newEventBus()
+
+	var lock sync.Mutex
+	var fast []string
+	block := make(chan struct{})
+	slowCount := 0
+
+	b.add("fast", func(ev pointsEvent) {
+		lock.Lock()
+		fast = append(fast, ev.nodeID)
+		lock.Unlock()
+	})
+
+	b.add("slow", func(ev pointsEvent) {
+		<-block
+		lock.Lock()
+		slowCount++
+		lock.Unlock()
+	})
+
+	b.start()
+
+	ids := []string{"a", "b", "c"}
+	for _, id := range ids {
+		b.publish(pointsEvent{nodeID: id, points: data.Points{{Type: data.PointTypeValue}}})
+	}
+
+	// a blocked processor does not hold up the others
+	start := time.Now()
+	for {
+		lock.Lock()
+		n := len(fast)
+		lock.Unlock()
+		if n == len(ids) {
+			break
+		}
+		if time.Since(start) > time.Second {
+			t.Fatal("fast processor did not get events")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	for i, id := range ids {
+		if fast[i] != id {
+			t.Errorf("events out of order: %v", fast)
+		}
+	}
+
+	if p := b.pending()["slow"]; p < 2 {
+		t.Error("expected events pending for slow processor, got: ", p)
+	}
+
+	// queued events are handled on stop
+	close(block)
+	b.stop()
+
+	if slowCount != len(ids) {
+		t.Error("slow processor did not handle all events: ", slowCount)
+	}
+
+	// publish after stop does not block
+	b.publish(pointsEvent{nodeID: "d"})
+}
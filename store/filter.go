@@ -0,0 +1,204 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/simpleiot/simpleiot/client"
+	"github.com/simpleiot/simpleiot/data"
+)
+
+// filterSub is a subscription where the store only sends node points that
+// match a filter to the subscriber
+type filterSub struct {
+	client.PointFilter
+	subject string
+	expires time.Time
+	// last point sent for each node/type/key, used for MinChange
+	last map[string]data.Point
+}
+
+func (fs *filterSub) match(ancestors map[string]bool, nodeID string,
+	points data.Points) data.Points {
+	if fs.Root != "" && !ancestors[fs.Root] {
+		return nil
+	}
+
+	var ret data.Points
+
+	for _, p := range points {
+		if len(fs.PointTypes) > 0 {
+			found := false
+			for _, t := range fs.PointTypes {
+				if p.Type == t {
+					found = true
+					break
+				}
+			}
+			if !found {
+				continue
+			}
+		}
+
+		if fs.MinChange > 0 {
+			key := nodeID + "." + p.Type + "." + p.Key
+			last, ok := fs.last[key]
+			if ok && last.Text == p.Text &&
+				math.Abs(p.Value-last.Value) < fs.MinChange {
+				continue
+			}
+			fs.last[key] = p
+		}
+
+		ret = append(ret, p)
+	}
+
+	return ret
+}
+
+// filterSubs tracks the filtered subscriptions
+type filterSubs struct {
+	lock sync.Mutex
+	subs map[string]*filterSub
+}
+
+func newFilterSubs() *filterSubs {
+	return &filterSubs{subs: make(map[string]*filterSub)}
+}
+
+func (fss *filterSubs) empty() bool {
+	fss.lock.Lock()
+	defer fss.lock.Unlock()
+	return len(fss.subs) == 0
+}
+
+// update adds, renews, or cancels a subscription
+func (fss *filterSubs) update(pts data.Points, now time.Time) error {
+	f := client.PointFilterFromPoints(pts)
+	if f.ID == "" {
+		return errors.New("filter ID must be set")
+	}
+
+	fss.lock.Lock()
+	defer fss.lock.Unlock()
+
+	if v, ok := pts.Value(data.PointTypeTombstone, ""); ok && v != 0 {
+		delete(fss.subs, f.ID)
+		return nil
+	}
+
+	subject, _ := pts.Text(data.PointTypeSubject, "")
+	if subject == "" {
+		return errors.New("subject must be set")
+	}
+
+	fs, ok := fss.subs[f.ID]
+	if !ok || fs.subject != subject {
+		fs = &filterSub{subject: subject, last: make(map[string]data.Point)}
+		fss.subs[f.ID] = fs
+	}
+
+	fs.PointFilter = f
+	fs.expires = now.Add(client.FilterRenewTimeout)
+
+	return nil
+}
+
+// expire removes subscriptions that were not renewed
+func (fss *filterSubs) expire(now time.Time) {
+	fss.lock.Lock()
+	defer fss.lock.Unlock()
+
+	for id, fs := range fss.subs {
+		if now.After(fs.expires) {
+			delete(fss.subs, id)
+		}
+	}
+}
+
+// matches returns the subject and points to send for each subscription that
+// matches the points
+func (fss *filterSubs) matches(ancestors map[string]bool, nodeID string,
+	points data.Points) map[string]data.Points {
+	fss.lock.Lock()
+	defer fss.lock.Unlock()
+
+	ret := make(map[string]data.Points)
+
+	for _, fs := range fss.subs {
+		pts := fs.match(ancestors, nodeID, points)
+		if len(pts) > 0 {
+			ret[fs.subject] = append(ret[fs.subject], pts...)
+		}
+	}
+
+	return ret
+}
+
+// ancestors returns the IDs of a node and all nodes above it
+func (st *Store) ancestors(nodeID string) (map[string]bool, error) {
+	ret := map[string]bool{nodeID: true}
+	todo := []string{nodeID}
+
+	for len(todo) > 0 {
+		id := todo[len(todo)-1]
+		todo = todo[:len(todo)-1]
+
+		ups, err := st.db.up(id, false)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, up := range ups {
+			if up == "none" || ret[up] {
+				continue
+			}
+			ret[up] = true
+			todo = append(todo, up)
+		}
+	}
+
+	return ret, nil
+}
+
+// sendFilteredPoints sends node points to filtered subscriptions
+func (st *Store) sendFilteredPoints(nodeID string, points data.Points) {
+	if st.filters.empty() {
+		return
+	}
+
+	ancestors, err := st.ancestors(nodeID)
+	if err != nil {
+		log.Println("Filter, error getting node ancestors: ", err)
+		return
+	}
+
+	for subject, pts := range st.filters.matches(ancestors, nodeID, points) {
+		err := client.SendPoints(st.nc, fmt.Sprintf("%v.%v", subject, nodeID), pts, false)
+		if err != nil {
+			log.Println("Filter, error sending points: ", err)
+		}
+	}
+}
+
+func (st *Store) handleFilterSubscribe(msg *nats.Msg) {
+	pts, err := data.PbDecodePoints(msg.Data)
+	if err != nil {
+		st.reply(msg.Reply, fmt.Errorf("Error decoding filter: %v", err))
+		return
+	}
+
+	// resolve the root node alias so that it matches node IDs
+	for i, p := range pts {
+		if p.Type == data.PointTypeNodeID && p.Text == "root" {
+			pts[i].Text = st.db.rootNodeID()
+		}
+	}
+
+	st.reply(msg.Reply, st.filters.update(pts, time.Now()))
+}
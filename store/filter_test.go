@@ -0,0 +1,81 @@
+package store
+
+import (
+	"testing"
+	"time"
+
+	"github.com/simpleiot/simpleiot/client"
+	"github.com/simpleiot/simpleiot/data"
+)
+
+func TestFilterSubs(t *testing.T) {
+	fss := newFilterSubs()
+	now := time.Now()
+
+	err := fss.update(data.Points{
+		{Type: data.PointTypeID, Text: "f1"},
+		{Type: data.PointTypeSubject, Text: "inbox.f1"},
+		{Type: data.PointTypeNodeID, Text: "group"},
+		{Type: data.PointTypePointType, Key: "0", Text: data.PointTypeValue},
+		{Type: data.PointTypeMinChange, Value: 1},
+	}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := fss.update(data.Points{{Type: data.PointTypeID, Text: "f2"}}, now); err == nil {
+		t.Fatal("expected error for missing subject")
+	}
+
+	inGroup := map[string]bool{"n1": true, "group": true, "root": true}
+	notInGroup := map[string]bool{"n2": true, "root": true}
+
+	pts := data.Points{
+		{Type: data.PointTypeValue, Value: 10},
+		{Type: data.PointTypeDescription, Text: "x"},
+	}
+
+	m := fss.matches(inGroup, "n1", pts)
+	if len(m["inbox.f1"]) != 1 || m["inbox.f1"][0].Type != data.PointTypeValue {
+		t.Fatal("wrong match: ", m)
+	}
+
+	if m := fss.matches(notInGroup, "n2", pts); len(m) != 0 {
+		t.Fatal("node outside of subtree matched: ", m)
+	}
+
+	// changes smaller than minChange are not sent
+	if m := fss.matches(inGroup, "n1", data.Points{{Type: data.PointTypeValue,
+		Value: 10.5}}); len(m) != 0 {
+		t.Fatal("small change was sent: ", m)
+	}
+
+	if m := fss.matches(inGroup, "n1", data.Points{{Type: data.PointTypeValue,
+		Value: 11.2}}); len(m) != 1 {
+		t.Fatal("change was not sent: ", m)
+	}
+
+	// subscriptions expire unless renewed
+	fss.expire(now.Add(client.FilterRenewTimeout / 2))
+	if fss.empty() {
+		t.Fatal("subscription expired early")
+	}
+
+	fss.expire(now.Add(client.FilterRenewTimeout + time.Second))
+	if !fss.empty() {
+		t.Fatal("subscription did not expire")
+	}
+
+	// cancel
+	fss.update(data.Points{
+		{Type: data.PointTypeID, Text: "f1"},
+		{Type: data.PointTypeSubject, Text: "inbox.f1"},
+	}, now)
+	fss.update(data.Points{
+		{Type: data.PointTypeID, Text: "f1"},
+		{Type: data.PointTypeTombstone, Value: 1},
+	}, now)
+	if !fss.empty() {
+		t.Fatal("subscription not canceled")
+	}
+}
@@ -0,0 +1,208 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// haLeaseBucket and haLeaseKey are where the HA lease is stored on the
+// witness
+const (
+	haLeaseBucket = "siot-ha"
+	haLeaseKey    = "lease"
+)
+
+// haLeaseValue is the instance that holds the lease and its term
+type haLeaseValue struct {
+	Instance string `json:"instance"`
+	Term     uint64 `json:"term"`
+}
+
+// haLease is a lease in a JetStream key/value bucket on the witness NATS
+// server. Every write is a compare and set on the revision of the key, so
+// only one instance can hold the lease: the active renews it every
+// heartbeat, and the standby only takes it over if its revision has not
+// changed for the HA timeout.
+type haLease struct {
+	nc      *nats.Conn
+	timeout time.Duration
+
+	lock sync.Mutex
+	kv   nats.KeyValue
+}
+
+// newHALease connects to the witness. The connection is retried in the
+// background, so this does not block if the witness is down.
+func newHALease(url string, timeout time.Duration) (*haLease, error) {
+	nc, err := nats.Connect(url, nats.RetryOnFailedConnect(true),
+		nats.MaxReconnects(-1), nats.Timeout(timeout))
+	if err != nil {
+		return nil, fmt.Errorf("Error connecting to HA witness: %w", err)
+	}
+
+	return &haLease{nc: nc, timeout: timeout}, nil
+}
+
+func (l *haLease) close() {
+	l.nc.Close()
+}
+
+// bucket returns the lease bucket, and creates it if it does not exist
+func (l *haLease) bucket() (nats.KeyValue, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if l.kv != nil {
+		return l.kv, nil
+	}
+
+	if !l.nc.IsConnected() {
+		return nil, errors.New("not connected to witness")
+	}
+
+	js, err := l.nc.JetStream(nats.MaxWait(l.timeout))
+	if err != nil {
+		return nil, err
+	}
+
+	kv, err := js.KeyValue(haLeaseBucket)
+	if err == nats.ErrBucketNotFound {
+		kv, err = js.CreateKeyValue(&nats.KeyValueConfig{Bucket: haLeaseBucket, History: 1})
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	l.kv = kv
+
+	return kv, nil
+}
+
+// get returns the lease and its revision. The revision is 0 if there is no
+// lease yet.
+func (l *haLease) get() (haLeaseValue, uint64, error) {
+	var v haLeaseValue
+
+	kv, err := l.bucket()
+	if err != nil {
+		return v, 0, err
+	}
+
+	e, err := kv.Get(haLeaseKey)
+	if err == nats.ErrKeyNotFound {
+		return v, 0, nil
+	}
+	if err != nil {
+		return v, 0, err
+	}
+
+	err = json.Unmarshal(e.Value(), &v)
+	if err != nil {
+		return v, 0, fmt.Errorf("Error decoding HA lease: %w", err)
+	}
+
+	return v, e.Revision(), nil
+}
+
+// put writes the lease if its revision is still rev, and returns the new
+// revision
+func (l *haLease) put(v haLeaseValue, rev uint64) (uint64, error) {
+	kv, err := l.bucket()
+	if err != nil {
+		return 0, err
+	}
+
+	d, err := json.Marshal(v)
+	if err != nil {
+		return 0, err
+	}
+
+	if rev == 0 {
+		return kv.Create(haLeaseKey, d)
+	}
+
+	return kv.Update(haLeaseKey, d, rev)
+}
+
+// haLeaseState is the lease read by a standby
+type haLeaseState struct {
+	value haLeaseValue
+	rev   uint64
+	err   error
+}
+
+// watch reads the lease every period and sends it to ch until stop is
+// closed. A state is dropped if the previous one was not received yet.
+func (l *haLease) watch(period time.Duration, ch chan<- haLeaseState, stop <-chan struct{}) {
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+
+	for {
+		v, rev, err := l.get()
+		select {
+		case ch <- haLeaseState{value: v, rev: rev, err: err}:
+		default:
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// haLeaseRenewal is the result of renewing the lease. lostTo is set if
+// another instance holds the lease.
+type haLeaseRenewal struct {
+	err    error
+	lostTo string
+}
+
+// renew renews the lease held with rev every period and sends the results
+// to ch until stop is closed or the lease is lost
+func (l *haLease) renew(v haLeaseValue, rev uint64, period time.Duration,
+	ch chan<- haLeaseRenewal, stop <-chan struct{}) {
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		var r haLeaseRenewal
+		newRev, err := l.put(v, rev)
+		if err == nil {
+			rev = newRev
+		} else {
+			r.err = err
+			// a failed update can be a network error or a changed
+			// revision, so check who holds the lease now
+			cur, curRev, getErr := l.get()
+			if getErr == nil && curRev != rev {
+				r.lostTo = cur.Instance
+				if r.lostTo == "" {
+					r.lostTo = "none"
+				}
+			}
+		}
+
+		select {
+		case ch <- r:
+		case <-stop:
+			return
+		}
+
+		if r.lostTo != "" {
+			return
+		}
+	}
+}
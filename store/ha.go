@@ -0,0 +1,928 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	"github.com/simpleiot/simpleiot/client"
+)
+
+// HA message headers
+const (
+	haHeaderSubject = "Siot-Ha-Subject"
+	haHeaderSeq     = "Siot-Ha-Seq"
+	haHeaderError   = "Siot-Ha-Error"
+)
+
+// haReplQueueLen is the number of replicated messages the standby can
+// buffer, for instance while it fetches a snapshot
+var haReplQueueLen = 100000
+
+// haApplyTimeout is the max time the standby store can take to handle a
+// replicated message
+var haApplyTimeout = 20 * time.Second
+
+// haSnapshotTimeout is the max time the active store can take to write a
+// snapshot
+var haSnapshotTimeout = 5 * time.Minute
+
+// haChunkSize is the size of the snapshot chunks sent to the standby
+const haChunkSize = 256 * 1024
+
+var errHAStopped = errors.New("HA store stopped")
+
+// HAParams configures a high availability store pair (see HA)
+type HAParams struct {
+	// Instance is the name of this instance. The two instances of a pair
+	// must have different names.
+	Instance string
+	// Heartbeat is how often each instance sends a heartbeat. Default is
+	// 1s.
+	Heartbeat time.Duration
+	// Timeout is the time without a heartbeat from the active instance
+	// after which the standby takes over. Default is 5s.
+	Timeout time.Duration
+	// Witness is the URL of a NATS server with JetStream enabled on a
+	// third host that both instances can reach. If set, the active must
+	// hold a lease on the witness: the standby only takes over after the
+	// lease was not renewed for Timeout, and the active is fenced if it
+	// can not renew the lease for half of Timeout. This keeps both
+	// instances from being active if the network between them fails.
+	Witness string
+}
+
+// haHeartbeat is sent by each instance of a pair
+type haHeartbeat struct {
+	Instance string `json:"instance"`
+	Term     uint64 `json:"term"`
+	Active   bool   `json:"active"`
+	Seq      uint64 `json:"seq"`
+}
+
+type haSnapshotRequest struct {
+	Instance string `json:"instance"`
+}
+
+type haSnapshotInfo struct {
+	ID    string `json:"id"`
+	Seq   uint64 `json:"seq"`
+	Size  int64  `json:"size"`
+	Error string `json:"error,omitempty"`
+}
+
+type haChunkRequest struct {
+	ID     string `json:"id"`
+	Offset int64  `json:"offset"`
+}
+
+type haSnapshot struct {
+	id   string
+	data []byte
+}
+
+// HA runs a store as one instance of an active/standby pair. The instances
+// share NATS subjects, usually through a NATS cluster, and only the active
+// instance runs a store on them. The active replicates every message that
+// changes its database to the standby, which applies it to its own copy of
+// the database with a store that is connected to a private NATS server, so
+// handlers run the same way without side effects. A standby that starts
+// or falls behind fetches a snapshot of the active database first.
+//
+// The instances send heartbeats, and the standby takes over if it does not
+// get a heartbeat from the active for HAParams.Timeout. Each takeover
+// starts a new term, which is stored next to the database. An active that
+// sees another active with a higher term, or the same term and a higher
+// instance name, is fenced: it stops its store and Start returns an error,
+// so the process can restart as the standby. If a witness is configured,
+// the active is also fenced when it loses the witness lease (see haLease).
+// Writes the fenced instance handled after the other took over are lost.
+type HA struct {
+	p     Params
+	ha    HAParams
+	nc    *nats.Conn
+	lease *haLease
+
+	lock     sync.Mutex
+	st       *Store
+	term     uint64
+	seq      uint64
+	snapshot *haSnapshot
+
+	chActive chan struct{}
+	chStop   chan struct{}
+	stopOnce sync.Once
+}
+
+// NewHA creates an instance of a high availability store pair. The store
+// is created when the instance becomes active.
+func NewHA(p Params, ha HAParams) (*HA, error) {
+	if ha.Instance == "" {
+		return nil, errors.New("HA instance name must be set")
+	}
+
+	if p.ReadOnly || p.Shard != "" || len(p.Shards) > 0 {
+		return nil, errors.New("HA can not be used with read-only or sharded stores")
+	}
+
+	if ha.Heartbeat <= 0 {
+		ha.Heartbeat = time.Second
+	}
+
+	if ha.Timeout <= 0 {
+		ha.Timeout = 5 * time.Second
+	}
+
+	if ha.Timeout < 2*ha.Heartbeat {
+		return nil, errors.New("HA timeout must be at least twice the heartbeat")
+	}
+
+	h := &HA{
+		p:        p,
+		ha:       ha,
+		nc:       p.Nc,
+		chActive: make(chan struct{}),
+		chStop:   make(chan struct{}),
+	}
+
+	var err error
+	h.term, err = h.loadTerm()
+	if err != nil {
+		return nil, err
+	}
+
+	if ha.Witness != "" {
+		h.lease, err = newHALease(ha.Witness, ha.Heartbeat)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return h, nil
+}
+
+func (h *HA) termFile() string {
+	return h.p.File + ".ha"
+}
+
+func (h *HA) loadTerm() (uint64, error) {
+	d, err := os.ReadFile(h.termFile())
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	var t struct {
+		Term uint64 `json:"term"`
+	}
+
+	err = json.Unmarshal(d, &t)
+	if err != nil {
+		return 0, fmt.Errorf("Error reading HA term: %w", err)
+	}
+
+	return t.Term, nil
+}
+
+// saveTerm must be called with the lock held
+func (h *HA) saveTerm() error {
+	d, err := json.Marshal(struct {
+		Term uint64 `json:"term"`
+	}{h.term})
+	if err != nil {
+		return err
+	}
+
+	tmp := h.termFile() + ".tmp"
+	err = os.WriteFile(tmp, d, 0644)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, h.termFile())
+}
+
+// seeTerm adopts the term of the other instance if it is newer
+func (h *HA) seeTerm(term uint64) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	if term > h.term {
+		h.term = term
+		if err := h.saveTerm(); err != nil {
+			log.Println("HA: error saving term: ", err)
+		}
+	}
+}
+
+// Start runs the instance as standby until the active fails, and then as
+// active. It returns an error if the instance is fenced.
+func (h *HA) Start() error {
+	hbCh := make(chan *nats.Msg, 100)
+	hbSub, err := h.nc.ChanSubscribe(client.SubjectHAHeartbeat(), hbCh)
+	if err != nil {
+		return fmt.Errorf("Subscribe HA heartbeat error: %w", err)
+	}
+	defer hbSub.Unsubscribe()
+
+	if h.lease != nil {
+		defer h.lease.close()
+	}
+
+	leaseRev, err := h.runStandby(hbCh)
+	if err == errHAStopped {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	return h.runActive(hbCh, leaseRev)
+}
+
+// Stop the instance
+func (h *HA) Stop(_ error) {
+	h.stopOnce.Do(func() {
+		close(h.chStop)
+	})
+}
+
+// Active returns true if this instance is the active instance
+func (h *HA) Active() bool {
+	select {
+	case <-h.chActive:
+		return true
+	default:
+		return false
+	}
+}
+
+func (h *HA) store() *Store {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return h.st
+}
+
+// WaitStart waits until this instance is active and its store has started
+func (h *HA) WaitStart(ctx context.Context) error {
+	select {
+	case <-ctx.Done():
+		return errors.New("Store wait timeout or canceled")
+	case <-h.chActive:
+	}
+
+	return h.store().WaitStart(ctx)
+}
+
+// StartMetrics runs the metrics of the active store
+func (h *HA) StartMetrics(nodeID string) error {
+	st := h.store()
+	if st == nil {
+		return errors.New("HA store is not active")
+	}
+	return st.StartMetrics(nodeID)
+}
+
+// StopMetrics stops the metrics of the active store
+func (h *HA) StopMetrics(err error) {
+	if st := h.store(); st != nil {
+		st.StopMetrics(err)
+	}
+}
+
+// WritePrometheus writes the metrics of the active store
+func (h *HA) WritePrometheus(w io.Writer) error {
+	if st := h.store(); st != nil {
+		return st.WritePrometheus(w)
+	}
+	return nil
+}
+
+func (h *HA) sendHeartbeat(active bool) {
+	h.lock.Lock()
+	hb := haHeartbeat{Instance: h.ha.Instance, Term: h.term, Active: active, Seq: h.seq}
+	h.lock.Unlock()
+
+	d, err := json.Marshal(hb)
+	if err != nil {
+		log.Println("HA: error encoding heartbeat: ", err)
+		return
+	}
+
+	err = h.nc.Publish(client.SubjectHAHeartbeat(), d)
+	if err != nil {
+		log.Println("HA: error sending heartbeat: ", err)
+	}
+}
+
+// decodeHeartbeat returns the heartbeat of the other instance
+func (h *HA) decodeHeartbeat(msg *nats.Msg) (haHeartbeat, bool) {
+	var hb haHeartbeat
+	err := json.Unmarshal(msg.Data, &hb)
+	if err != nil {
+		log.Println("HA: error decoding heartbeat: ", err)
+		return hb, false
+	}
+
+	return hb, hb.Instance != h.ha.Instance
+}
+
+// haBus is a private NATS server for the standby store
+type haBus struct {
+	srv *server.Server
+	nc  *nats.Conn
+}
+
+func newHABus() (*haBus, error) {
+	srv, err := server.NewServer(&server.Options{
+		Host:   "127.0.0.1",
+		Port:   server.RANDOM_PORT,
+		NoSigs: true,
+		NoLog:  true,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	go srv.Start()
+
+	if !srv.ReadyForConnections(10 * time.Second) {
+		srv.Shutdown()
+		return nil, errors.New("HA private NATS server did not start")
+	}
+
+	nc, err := nats.Connect(srv.ClientURL())
+	if err != nil {
+		srv.Shutdown()
+		return nil, err
+	}
+
+	return &haBus{srv: srv, nc: nc}, nil
+}
+
+func (b *haBus) close() {
+	b.nc.Close()
+	b.srv.Shutdown()
+}
+
+// haStore is a running store
+type haStore struct {
+	st   *Store
+	done chan error
+}
+
+func startHAStore(p Params) (*haStore, error) {
+	st, err := NewStore(p)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &haStore{st: st, done: make(chan error, 1)}
+	go func() {
+		s.done <- st.Start()
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	err = st.WaitStart(ctx)
+	if err != nil {
+		s.stop()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func (s *haStore) stop() {
+	s.st.Stop(nil)
+	<-s.done
+	if err := s.st.db.Close(); err != nil {
+		log.Println("HA: error closing store: ", err)
+	}
+}
+
+// runStandby applies replicated messages until the active instance fails.
+// It returns nil when this instance should take over, and the revision of
+// the witness lease it acquired.
+func (h *HA) runStandby(hbCh chan *nats.Msg) (uint64, error) {
+	log.Printf("HA: %v starting as standby\n", h.ha.Instance)
+
+	replCh := make(chan *nats.Msg, haReplQueueLen)
+	replSub, err := h.nc.ChanSubscribe(client.SubjectHAReplicate(), replCh)
+	if err != nil {
+		return 0, fmt.Errorf("Subscribe HA replicate error: %w", err)
+	}
+	defer replSub.Unsubscribe()
+
+	bus, err := newHABus()
+	if err != nil {
+		return 0, fmt.Errorf("Error starting HA private NATS server: %w", err)
+	}
+	defer bus.close()
+
+	// the lease is read in the background, so a witness that is down
+	// does not hold up heartbeats and replication
+	leaseCh := make(chan haLeaseState, 1)
+	if h.lease != nil {
+		stopLease := make(chan struct{})
+		defer close(stopLease)
+		go h.lease.watch(h.ha.Heartbeat, leaseCh, stopLease)
+	}
+
+	var lease haLeaseState
+	leaseOK := false
+	leaseSeen := false
+	// when the lease revision last changed
+	var leaseChanged time.Time
+
+	var sb *haStore
+	defer func() {
+		if sb != nil {
+			sb.stop()
+		}
+	}()
+
+	// the standby waits for a heartbeat for Timeout after it starts, so
+	// an instance that restarts does not take over a running active
+	lastActive := time.Now()
+	leaseLogged := false
+	synced := false
+	// seq of the active at the previous heartbeat
+	var activeSeq uint64
+
+	apply := func(msg *nats.Msg) {
+		seq, err := strconv.ParseUint(msg.Header.Get(haHeaderSeq), 10, 64)
+		if err != nil {
+			log.Println("HA: invalid replicated message seq: ", err)
+			return
+		}
+
+		h.lock.Lock()
+		last := h.seq
+		h.lock.Unlock()
+
+		if !synced || seq <= last {
+			// already in the snapshot
+			return
+		}
+
+		if seq != last+1 {
+			log.Printf("HA: replicated messages %v-%v missed, fetching snapshot\n",
+				last+1, seq-1)
+			synced = false
+			return
+		}
+
+		err = h.apply(bus.nc, msg)
+		if err != nil {
+			log.Printf("HA: error applying %v: %v\n", msg.Header.Get(haHeaderSubject), err)
+		}
+
+		h.lock.Lock()
+		h.seq = seq
+		h.lock.Unlock()
+	}
+
+	ticker := time.NewTicker(h.ha.Heartbeat)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-h.chStop:
+			return 0, errHAStopped
+
+		case l := <-leaseCh:
+			leaseOK = l.err == nil
+			if !leaseOK {
+				continue
+			}
+
+			if !leaseSeen || l.rev != lease.rev {
+				leaseChanged = time.Now()
+				leaseSeen = true
+			}
+			lease = l
+
+		case msg := <-hbCh:
+			hb, ok := h.decodeHeartbeat(msg)
+			if !ok || !hb.Active {
+				continue
+			}
+
+			lastActive = time.Now()
+			h.seeTerm(hb.Term)
+
+			h.lock.Lock()
+			behind := h.seq < activeSeq
+			h.lock.Unlock()
+			activeSeq = hb.Seq
+
+			// the last replicated message can only be missed if
+			// nothing is queued after it
+			if synced && behind && len(replCh) == 0 {
+				log.Println("HA: standby fell behind, fetching snapshot")
+				synced = false
+			}
+
+			if !synced {
+				sb, err = h.sync(sb, bus.nc)
+				if err != nil {
+					log.Println("HA: error syncing standby: ", err)
+					continue
+				}
+				synced = true
+			}
+
+		case msg := <-replCh:
+			apply(msg)
+
+		case <-ticker.C:
+			h.sendHeartbeat(false)
+
+			if time.Since(lastActive) < h.ha.Timeout {
+				continue
+			}
+
+			var leaseRev uint64
+			if h.lease != nil {
+				if !leaseOK || !leaseSeen {
+					if !leaseLogged {
+						log.Println("HA: active is down, but witness is not reachable")
+						leaseLogged = true
+					}
+					continue
+				}
+
+				if lease.rev != 0 && time.Since(leaseChanged) < h.ha.Timeout {
+					if !leaseLogged {
+						log.Printf("HA: active is down, but %v still renews the lease\n",
+							lease.value.Instance)
+						leaseLogged = true
+					}
+					continue
+				}
+
+				h.seeTerm(lease.value.Term)
+				h.lock.Lock()
+				v := haLeaseValue{Instance: h.ha.Instance, Term: h.term + 1}
+				h.lock.Unlock()
+
+				leaseRev, err = h.lease.put(v, lease.rev)
+				if err != nil {
+					log.Println("HA: error acquiring lease: ", err)
+					// read the lease again before the next try
+					leaseSeen = false
+					continue
+				}
+			}
+
+			// apply what the active sent before it failed
+			for len(replCh) > 0 {
+				apply(<-replCh)
+			}
+
+			log.Printf("HA: no heartbeat from active for %v, taking over\n",
+				time.Since(lastActive).Round(time.Millisecond))
+			return leaseRev, nil
+		}
+	}
+}
+
+// apply sends a replicated message to the standby store and waits until it
+// is handled
+func (h *HA) apply(nc *nats.Conn, msg *nats.Msg) error {
+	m := nats.NewMsg(msg.Header.Get(haHeaderSubject))
+	m.Data = msg.Data
+	for k, v := range msg.Header {
+		if !strings.HasPrefix(k, "Siot-Ha-") {
+			m.Header[k] = v
+		}
+	}
+
+	_, err := nc.RequestMsg(m, haApplyTimeout)
+	return err
+}
+
+// sync replaces the standby database with a snapshot of the active
+// database, and restarts the standby store. Replicated messages after the
+// snapshot are applied after that.
+func (h *HA) sync(sb *haStore, nc *nats.Conn) (*haStore, error) {
+	tmp := h.p.File + ".ha-sync"
+	seq, err := h.fetchSnapshot(tmp)
+	if err != nil {
+		os.Remove(tmp)
+		return sb, err
+	}
+
+	if sb != nil {
+		sb.stop()
+	}
+
+	for _, f := range []string{h.p.File + "-wal", h.p.File + "-shm"} {
+		if err := os.Remove(f); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+
+	err = os.Rename(tmp, h.p.File)
+	if err != nil {
+		return nil, err
+	}
+
+	p := h.p
+	p.Nc = nc
+	sb, err = startHAStore(p)
+	if err != nil {
+		return nil, err
+	}
+
+	h.lock.Lock()
+	h.seq = seq
+	h.lock.Unlock()
+
+	log.Printf("HA: standby synced to active, seq %v\n", seq)
+
+	return sb, nil
+}
+
+// fetchSnapshot writes a snapshot of the active database to file and
+// returns the seq of the last replicated message in it
+func (h *HA) fetchSnapshot(file string) (uint64, error) {
+	d, err := json.Marshal(haSnapshotRequest{Instance: h.ha.Instance})
+	if err != nil {
+		return 0, err
+	}
+
+	msg, err := h.nc.Request(client.SubjectHASnapshot(), d, haSnapshotTimeout)
+	if err != nil {
+		return 0, fmt.Errorf("Error requesting snapshot: %w", err)
+	}
+
+	var info haSnapshotInfo
+	err = json.Unmarshal(msg.Data, &info)
+	if err != nil {
+		return 0, fmt.Errorf("Error decoding snapshot info: %w", err)
+	}
+
+	if info.Error != "" {
+		return 0, errors.New(info.Error)
+	}
+
+	f, err := os.Create(file)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	var offset int64
+	for offset < info.Size {
+		d, err := json.Marshal(haChunkRequest{ID: info.ID, Offset: offset})
+		if err != nil {
+			return 0, err
+		}
+
+		msg, err := h.nc.Request(client.SubjectHASnapshotChunk(), d, 10*time.Second)
+		if err != nil {
+			return 0, fmt.Errorf("Error fetching snapshot: %w", err)
+		}
+
+		if e := msg.Header.Get(haHeaderError); e != "" {
+			return 0, errors.New(e)
+		}
+
+		if len(msg.Data) == 0 {
+			return 0, errors.New("snapshot is shorter than expected")
+		}
+
+		_, err = f.Write(msg.Data)
+		if err != nil {
+			return 0, err
+		}
+
+		offset += int64(len(msg.Data))
+	}
+
+	return info.Seq, f.Close()
+}
+
+// replicate sends a message that was handled by the active store to the
+// standby
+func (h *HA) replicate(msg *nats.Msg) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	h.seq++
+
+	m := nats.NewMsg(client.SubjectHAReplicate())
+	m.Data = msg.Data
+	for k, v := range msg.Header {
+		m.Header[k] = v
+	}
+	m.Header.Set(haHeaderSubject, msg.Subject)
+	m.Header.Set(haHeaderSeq, strconv.FormatUint(h.seq, 10))
+
+	err := h.nc.PublishMsg(m)
+	if err != nil {
+		log.Println("HA: error replicating message: ", err)
+	}
+}
+
+// handleSnapshot writes a snapshot of the database for the standby. The seq
+// is read before the snapshot is written, so the snapshot has at least all
+// writes up to it. Writes after it may be applied twice.
+func (h *HA) handleSnapshot(msg *nats.Msg) {
+	var info haSnapshotInfo
+
+	h.lock.Lock()
+	seq := h.seq
+	st := h.st
+	h.lock.Unlock()
+
+	file := h.p.File + ".ha-snapshot"
+	os.Remove(file)
+
+	_, err := st.db.backup(file)
+	if err == nil {
+		var d []byte
+		d, err = os.ReadFile(file)
+		if err == nil {
+			info = haSnapshotInfo{ID: uuid.New().String(), Seq: seq, Size: int64(len(d))}
+			h.lock.Lock()
+			h.snapshot = &haSnapshot{id: info.ID, data: d}
+			h.lock.Unlock()
+		}
+	}
+	os.Remove(file)
+
+	if err != nil {
+		info.Error = fmt.Sprintf("Error writing snapshot: %v", err)
+	}
+
+	d, err := json.Marshal(info)
+	if err != nil {
+		log.Println("HA: error encoding snapshot info: ", err)
+		return
+	}
+
+	err = msg.Respond(d)
+	if err != nil {
+		log.Println("HA: error responding to snapshot request: ", err)
+	}
+}
+
+// handleSnapshotChunk sends a chunk of the last snapshot. The snapshot is
+// released after the last chunk is sent.
+func (h *HA) handleSnapshotChunk(msg *nats.Msg) {
+	resp := nats.NewMsg(msg.Reply)
+
+	var req haChunkRequest
+	err := json.Unmarshal(msg.Data, &req)
+
+	h.lock.Lock()
+	s := h.snapshot
+	switch {
+	case err != nil:
+		resp.Header.Set(haHeaderError, fmt.Sprintf("Error decoding chunk request: %v", err))
+	case s == nil || s.id != req.ID:
+		resp.Header.Set(haHeaderError, "snapshot not found")
+	case req.Offset < 0 || req.Offset >= int64(len(s.data)):
+		resp.Header.Set(haHeaderError, "invalid snapshot offset")
+	default:
+		end := req.Offset + haChunkSize
+		if end >= int64(len(s.data)) {
+			end = int64(len(s.data))
+			h.snapshot = nil
+		}
+		resp.Data = s.data[req.Offset:end]
+	}
+	h.lock.Unlock()
+
+	err = h.nc.PublishMsg(resp)
+	if err != nil {
+		log.Println("HA: error sending snapshot chunk: ", err)
+	}
+}
+
+// runActive runs the store on the shared subjects until the instance is
+// stopped or fenced. leaseRev is the revision of the witness lease.
+func (h *HA) runActive(hbCh chan *nats.Msg, leaseRev uint64) error {
+	h.lock.Lock()
+	h.term++
+	err := h.saveTerm()
+	term := h.term
+	h.lock.Unlock()
+
+	if err != nil {
+		return fmt.Errorf("Error saving HA term: %w", err)
+	}
+
+	st, err := NewStore(h.p)
+	if err != nil {
+		return err
+	}
+	st.repl = h.replicate
+
+	h.lock.Lock()
+	h.st = st
+	h.lock.Unlock()
+
+	snapSub, err := h.nc.Subscribe(client.SubjectHASnapshot(), h.handleSnapshot)
+	if err != nil {
+		return fmt.Errorf("Subscribe HA snapshot error: %w", err)
+	}
+	defer snapSub.Unsubscribe()
+
+	chunkSub, err := h.nc.Subscribe(client.SubjectHASnapshotChunk(), h.handleSnapshotChunk)
+	if err != nil {
+		return fmt.Errorf("Subscribe HA snapshot chunk error: %w", err)
+	}
+	defer chunkSub.Unsubscribe()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- st.Start()
+	}()
+
+	close(h.chActive)
+
+	log.Printf("HA: %v is active, term %v\n", h.ha.Instance, term)
+	h.sendHeartbeat(true)
+
+	fence := func(reason string) error {
+		log.Printf("HA: %v fenced: %v\n", h.ha.Instance, reason)
+		st.Stop(nil)
+		<-done
+		st.db.Close()
+		return fmt.Errorf("HA store fenced: %v", reason)
+	}
+
+	// the lease is renewed in the background, so a witness that is down
+	// does not hold up heartbeats
+	renewCh := make(chan haLeaseRenewal)
+	if h.lease != nil {
+		stopRenew := make(chan struct{})
+		defer close(stopRenew)
+		go h.lease.renew(haLeaseValue{Instance: h.ha.Instance, Term: term}, leaseRev,
+			h.ha.Heartbeat, renewCh, stopRenew)
+	}
+
+	// the standby takes over after the lease was not renewed for Timeout,
+	// so the active stops well before that
+	lastRenewal := time.Now()
+
+	ticker := time.NewTicker(h.ha.Heartbeat)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-h.chStop:
+			st.Stop(nil)
+			return <-done
+
+		case err := <-done:
+			return err
+
+		case msg := <-hbCh:
+			hb, ok := h.decodeHeartbeat(msg)
+			if !ok || !hb.Active {
+				continue
+			}
+
+			if hb.Term > term || (hb.Term == term && hb.Instance > h.ha.Instance) {
+				h.seeTerm(hb.Term)
+				return fence(fmt.Sprintf("%v is active with term %v", hb.Instance, hb.Term))
+			}
+
+		case r := <-renewCh:
+			if r.lostTo != "" {
+				return fence(fmt.Sprintf("lease was taken by %v", r.lostTo))
+			}
+			if r.err != nil {
+				log.Println("HA: error renewing lease: ", r.err)
+				continue
+			}
+			lastRenewal = time.Now()
+
+		case <-ticker.C:
+			h.sendHeartbeat(true)
+
+			if h.lease != nil && time.Since(lastRenewal) > h.ha.Timeout/2 {
+				return fence("lease was not renewed")
+			}
+		}
+	}
+}
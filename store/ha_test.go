@@ -0,0 +1,238 @@
+package store_test
+
+import (
+	"context"
+	"os/exec"
+	"testing"
+	"time"
+
+	natsserver "github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	"github.com/simpleiot/simpleiot/client"
+	"github.com/simpleiot/simpleiot/store"
+)
+
+func TestHA(t *testing.T) {
+	ns, err := natsserver.NewServer(&natsserver.Options{Host: "127.0.0.1",
+		Port: natsserver.RANDOM_PORT, NoSigs: true, NoLog: true})
+	if err != nil {
+		t.Fatal("Error creating NATS server: ", err)
+	}
+	go ns.Start()
+	defer ns.Shutdown()
+
+	if !ns.ReadyForConnections(5 * time.Second) {
+		t.Fatal("NATS server did not start")
+	}
+
+	rm := func() { exec.Command("sh", "-c", "rm test-ha-*.sqlite*").Run() }
+	rm()
+	defer rm()
+
+	connect := func() *nats.Conn {
+		nc, err := nats.Connect(ns.ClientURL())
+		if err != nil {
+			t.Fatal("Error connecting to NATS: ", err)
+		}
+		return nc
+	}
+
+	start := func(instance string) (*store.HA, chan error) {
+		nc := connect()
+		h, err := store.NewHA(store.Params{File: "test-ha-" + instance + ".sqlite", Nc: nc},
+			store.HAParams{Instance: instance, Heartbeat: 50 * time.Millisecond,
+				Timeout: 300 * time.Millisecond})
+		if err != nil {
+			t.Fatal("Error creating HA store: ", err)
+		}
+
+		done := make(chan error, 1)
+		go func() {
+			done <- h.Start()
+			nc.Close()
+		}()
+
+		return h, done
+	}
+
+	waitActive := func(h *store.HA) {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := h.WaitStart(ctx); err != nil {
+			t.Fatal("Error waiting for active store: ", err)
+		}
+	}
+
+	a, aDone := start("a")
+	defer a.Stop(nil)
+	waitActive(a)
+
+	nc := connect()
+	defer nc.Close()
+
+	root, err := client.GetNode(nc, "root", "")
+	if err != nil || len(root) != 1 {
+		t.Fatal("Error getting root node: ", err)
+	}
+
+	send := func(id string) {
+		err := client.SendNodeType(nc, client.Variable{ID: id, Parent: root[0].ID,
+			Description: id}, "test")
+		if err != nil {
+			t.Fatal("Error sending node: ", err)
+		}
+	}
+
+	// sent before the standby starts, so is in the snapshot
+	send("ID-before")
+
+	b, bDone := start("b")
+	defer b.Stop(nil)
+
+	time.Sleep(time.Second)
+	if b.Active() {
+		t.Fatal("standby took over from a running active")
+	}
+
+	// replicated to the standby
+	send("ID-after")
+
+	a.Stop(nil)
+	if err := <-aDone; err != nil {
+		t.Fatal("active stopped with error: ", err)
+	}
+
+	waitActive(b)
+
+	for _, id := range []string{"ID-before", "ID-after"} {
+		n, err := client.GetNode(nc, id, root[0].ID)
+		if err != nil || len(n) != 1 || n[0].Desc() != id {
+			t.Fatalf("node %v is missing after failover: %v %v", id, n, err)
+		}
+	}
+
+	// an active that sees an active with a newer term is fenced
+	err = nc.Publish(client.SubjectHAHeartbeat(),
+		[]byte(`{"instance":"z","term":100,"active":true}`))
+	if err != nil {
+		t.Fatal("Error sending heartbeat: ", err)
+	}
+
+	select {
+	case err := <-bDone:
+		if err == nil {
+			t.Fatal("fenced store did not return an error")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("active store was not fenced")
+	}
+}
+
+func TestHAWitness(t *testing.T) {
+	newServer := func(o *natsserver.Options) *natsserver.Server {
+		o.Host = "127.0.0.1"
+		o.Port = natsserver.RANDOM_PORT
+		o.NoSigs = true
+		o.NoLog = true
+		ns, err := natsserver.NewServer(o)
+		if err != nil {
+			t.Fatal("Error creating NATS server: ", err)
+		}
+		go ns.Start()
+
+		if !ns.ReadyForConnections(5 * time.Second) {
+			t.Fatal("NATS server did not start")
+		}
+		return ns
+	}
+
+	ns := newServer(&natsserver.Options{})
+	defer ns.Shutdown()
+
+	witness := newServer(&natsserver.Options{JetStream: true, StoreDir: t.TempDir()})
+	defer witness.Shutdown()
+
+	rm := func() { exec.Command("sh", "-c", "rm test-ha-witness-*.sqlite*").Run() }
+	rm()
+	defer rm()
+
+	start := func(instance string) (*store.HA, chan error) {
+		nc, err := nats.Connect(ns.ClientURL())
+		if err != nil {
+			t.Fatal("Error connecting to NATS: ", err)
+		}
+		h, err := store.NewHA(store.Params{File: "test-ha-witness-" + instance + ".sqlite", Nc: nc},
+			store.HAParams{Instance: instance, Heartbeat: 50 * time.Millisecond,
+				Timeout: 300 * time.Millisecond, Witness: witness.ClientURL()})
+		if err != nil {
+			t.Fatal("Error creating HA store: ", err)
+		}
+
+		done := make(chan error, 1)
+		go func() {
+			done <- h.Start()
+			nc.Close()
+		}()
+
+		return h, done
+	}
+
+	waitActive := func(h *store.HA) {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := h.WaitStart(ctx); err != nil {
+			t.Fatal("Error waiting for active store: ", err)
+		}
+	}
+
+	a, aDone := start("a")
+	defer a.Stop(nil)
+	waitActive(a)
+
+	b, bDone := start("b")
+	defer b.Stop(nil)
+
+	time.Sleep(time.Second)
+	if b.Active() {
+		t.Fatal("standby took over from a running active")
+	}
+
+	// the standby takes over after the lease is no longer renewed
+	a.Stop(nil)
+	if err := <-aDone; err != nil {
+		t.Fatal("active stopped with error: ", err)
+	}
+
+	waitActive(b)
+
+	// an active that loses the lease is fenced
+	wnc, err := nats.Connect(witness.ClientURL())
+	if err != nil {
+		t.Fatal("Error connecting to witness: ", err)
+	}
+	defer wnc.Close()
+
+	js, err := wnc.JetStream()
+	if err != nil {
+		t.Fatal("JetStream error: ", err)
+	}
+
+	kv, err := js.KeyValue("siot-ha")
+	if err != nil {
+		t.Fatal("Error getting lease bucket: ", err)
+	}
+
+	_, err = kv.Put("lease", []byte(`{"instance":"z","term":100}`))
+	if err != nil {
+		t.Fatal("Error writing lease: ", err)
+	}
+
+	select {
+	case err := <-bDone:
+		if err == nil {
+			t.Fatal("fenced store did not return an error")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("active store was not fenced after losing the lease")
+	}
+}
@@ -8,7 +8,9 @@ import (
 	"github.com/simpleiot/simpleiot/data"
 )
 
-// updateHash updates the hash in all the upstream edges
+// updateHash updates the hash in all the upstream edges. Secret points are
+// not synchronized with upstream instances, so they are left out of the hash
+// to make the local and upstream hashes match.
 func updateHash(node *data.Node, upEdges []*data.Edge, downEdges []*data.Edge) {
 	// downstream edge hashes are used in the hash calculation, so sort them first
 	sort.Sort(data.ByHash(downEdges))
@@ -23,6 +25,9 @@ func updateHash(node *data.Node, upEdges []*data.Edge, downEdges []*data.Edge) {
 		}
 
 		for _, p := range node.Points {
+			if p.IsSecret() {
+				continue
+			}
 			d := make([]byte, 8)
 			binary.LittleEndian.PutUint64(d, uint64(p.Time.UnixNano()))
 			h.Write(d)
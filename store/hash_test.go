@@ -1,8 +1,12 @@
 package store
 
 import (
+	"bytes"
 	"sort"
 	"testing"
+	"time"
+
+	"github.com/simpleiot/simpleiot/data"
 )
 
 func XorSum(a []int) int {
@@ -53,3 +57,39 @@ func TestXorChecksum(t *testing.T) {
 		t.Fatal("Incremental checksum did not equal the complete checksum")
 	}
 }
+
+func TestUpdateHashSecrets(t *testing.T) {
+	now := time.Now()
+
+	local := data.Node{
+		ID:   "dev",
+		Type: data.NodeTypeDevice,
+		Points: data.Points{
+			{Type: data.PointTypeDescription, Text: "dev", Time: now},
+			{Type: data.PointTypePassword, Text: "secret", Time: now.Add(time.Second)},
+		},
+	}
+
+	// upstream gets a copy of the node without its secrets after one sync
+	upstream := local
+	upstream.Points = local.Points.RemoveSecrets()
+
+	edgePoints := data.Points{{Type: data.PointTypeTombstone, Time: now}}
+	localEdge := &data.Edge{Up: "root", Down: "dev", Points: edgePoints}
+	upEdge := &data.Edge{Up: "root", Down: "dev", Points: edgePoints}
+
+	updateHash(&local, []*data.Edge{localEdge}, nil)
+	updateHash(&upstream, []*data.Edge{upEdge}, nil)
+
+	if !bytes.Equal(localEdge.Hash, upEdge.Hash) {
+		t.Fatal("local and upstream hash do not match after sync")
+	}
+
+	// a change to a point that is synchronized must still change the hash
+	upstream.Points[0].Time = now.Add(2 * time.Second)
+	updateHash(&upstream, []*data.Edge{upEdge}, nil)
+
+	if bytes.Equal(localEdge.Hash, upEdge.Hash) {
+		t.Fatal("hash did not change when a point changed")
+	}
+}
@@ -0,0 +1,131 @@
+package store
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/simpleiot/simpleiot/client"
+)
+
+// msgIDWindow is how long the IDs of handled messages are remembered. This
+// must be longer than clients retry requests.
+var msgIDWindow = 2 * time.Minute
+
+// msgIDMax is the maximum number of IDs that are remembered, so memory does
+// not grow with the message rate. If more messages are handled in the window,
+// the oldest IDs are forgotten early. Retries come soon after the first
+// attempt, so this only matters at very high message rates.
+var msgIDMax = 100000
+
+// msgIDs remembers the IDs of point messages the store has handled (see
+// client.HeaderMsgID), so a message that is sent again after its ack was lost
+// is acked without being written and processed a second time. IDs are keyed
+// by subject, so one ID can be used for the node and edge points of a node.
+type msgIDs struct {
+	window time.Duration
+	max    int
+	lock   sync.Mutex
+	seen   map[string]msgIDEntry
+	// order is the IDs in seen from oldest to newest
+	order []string
+	dups  int64
+}
+
+// msgIDEntry is when a message was handled, and the error it was replied
+// to with, blank if it succeeded
+type msgIDEntry struct {
+	time  time.Time
+	reply string
+}
+
+func newMsgIDs(window time.Duration, max int) *msgIDs {
+	return &msgIDs{
+		window: window,
+		max:    max,
+		seen:   make(map[string]msgIDEntry),
+	}
+}
+
+func msgIDKey(msg *nats.Msg) string {
+	if msg.Header == nil {
+		return ""
+	}
+
+	id := msg.Header.Get(client.HeaderMsgID)
+	if id == "" {
+		return ""
+	}
+
+	return msg.Subject + " " + id
+}
+
+// duplicate returns true if a message with the same subject and ID was
+// handled within the window, and the error the message was replied to with
+func (m *msgIDs) duplicate(msg *nats.Msg, now time.Time) (bool, error) {
+	k := msgIDKey(msg)
+	if k == "" {
+		return false, nil
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	e, ok := m.seen[k]
+	if ok && now.Sub(e.time) < m.window {
+		m.dups++
+		if e.reply != "" {
+			return true, errors.New(e.reply)
+		}
+		return true, nil
+	}
+
+	return false, nil
+}
+
+// handled records the ID of a message that was handled, and the error it is
+// replied to with. Messages that failed without being processed are not
+// recorded so they can be retried, but messages that were partly processed,
+// like points that were written while others were rejected, are recorded
+// with their error so a retry gets the same reply.
+func (m *msgIDs) handled(msg *nats.Msg, now time.Time, err error) {
+	k := msgIDKey(msg)
+	if k == "" {
+		return
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if _, ok := m.seen[k]; !ok {
+		m.order = append(m.order, k)
+	}
+	e := msgIDEntry{time: now}
+	if err != nil {
+		e.reply = err.Error()
+	}
+	m.seen[k] = e
+
+	for len(m.order) > m.max {
+		m.removeOldest()
+	}
+}
+
+func (m *msgIDs) removeOldest() {
+	delete(m.seen, m.order[0])
+	m.order = m.order[1:]
+}
+
+// expire removes IDs older than the window and returns the total number of
+// duplicate messages dropped
+func (m *msgIDs) expire(now time.Time) int64 {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	for len(m.order) > 0 && now.Sub(m.seen[m.order[0]].time) >= m.window {
+		m.removeOldest()
+	}
+
+	return m.dups
+}
@@ -0,0 +1,91 @@
+package store
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/simpleiot/simpleiot/client"
+)
+
+func dup(m *msgIDs, msg *nats.Msg, now time.Time) bool {
+	ret, _ := m.duplicate(msg, now)
+	return ret
+}
+
+func TestMsgIDs(t *testing.T) {
+	m := newMsgIDs(time.Minute, 100)
+	now := time.Now()
+
+	msg := func(subject, id string) *nats.Msg {
+		ret := nats.NewMsg(subject)
+		if id != "" {
+			ret.Header.Set(client.HeaderMsgID, id)
+		}
+		return ret
+	}
+
+	if dup(m, msg("node.a.points", "1"), now) {
+		t.Error("new message is a duplicate")
+	}
+
+	m.handled(msg("node.a.points", "1"), now, nil)
+	m.handled(msg("node.a.points", ""), now, nil)
+
+	if !dup(m, msg("node.a.points", "1"), now) {
+		t.Error("handled message is not a duplicate")
+	}
+
+	if dup(m, msg("node.a.root.points", "1"), now) {
+		t.Error("IDs should be keyed by subject")
+	}
+
+	if dup(m, msg("node.a.points", ""), now) {
+		t.Error("messages without an ID are never duplicates")
+	}
+
+	// the reply of a message is returned for duplicates
+	m.handled(msg("node.b.points", "1"), now, errors.New("2 points quarantined"))
+
+	d, err := m.duplicate(msg("node.b.points", "1"), now)
+	if !d || err == nil || err.Error() != "2 points quarantined" {
+		t.Errorf("duplicate reply: %v, %v", d, err)
+	}
+
+	later := now.Add(time.Minute)
+	if dup(m, msg("node.a.points", "1"), later) {
+		t.Error("message ID should expire after the window")
+	}
+
+	if dups := m.expire(later); dups != 2 || len(m.seen) != 0 {
+		t.Errorf("expire: dups %v, seen %v", dups, len(m.seen))
+	}
+}
+
+func TestMsgIDsMax(t *testing.T) {
+	m := newMsgIDs(time.Minute, 2)
+	now := time.Now()
+
+	msg := func(id string) *nats.Msg {
+		ret := nats.NewMsg("node.a.points")
+		ret.Header.Set(client.HeaderMsgID, id)
+		return ret
+	}
+
+	for _, id := range []string{"1", "2", "3"} {
+		m.handled(msg(id), now, nil)
+	}
+
+	if len(m.seen) != 2 || len(m.order) != 2 {
+		t.Fatalf("expected 2 IDs, seen %v, order %v", len(m.seen), len(m.order))
+	}
+
+	if dup(m, msg("1"), now) {
+		t.Error("oldest ID should be removed")
+	}
+
+	if !dup(m, msg("2"), now) || !dup(m, msg("3"), now) {
+		t.Error("newest IDs should be remembered")
+	}
+}
@@ -0,0 +1,191 @@
+package store
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/simpleiot/simpleiot/data"
+)
+
+// maxPointStats limits the number of node type/point type pairs that are
+// tracked, so clients that make up point types can't use all the memory.
+// Points over the limit are counted with the point type "other".
+const maxPointStats = 1000
+
+type pointStatsKey struct {
+	nodeType  string
+	pointType string
+}
+
+type pointStatsCount struct {
+	points int64
+	bytes  int64
+}
+
+func (c *pointStatsCount) add(o pointStatsCount) {
+	c.points += o.points
+	c.bytes += o.bytes
+}
+
+// promLabel escapes Prometheus label values
+var promLabel = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// pointStats tracks the points written to the store by node type and point
+// type. Totals are kept for Prometheus, and the counts since the last report
+// are used to calculate rates.
+type pointStats struct {
+	lock       sync.Mutex
+	total      map[pointStatsKey]pointStatsCount
+	window     map[pointStatsKey]pointStatsCount
+	start      time.Time
+	lastReport map[string]bool
+}
+
+func newPointStats(now time.Time) *pointStats {
+	return &pointStats{
+		total:      make(map[pointStatsKey]pointStatsCount),
+		window:     make(map[pointStatsKey]pointStatsCount),
+		start:      now,
+		lastReport: make(map[string]bool),
+	}
+}
+
+// add counts the points of a message. The message size is split evenly
+// between its points.
+func (ps *pointStats) add(nodeType string, points data.Points, msgBytes int) {
+	if len(points) < 1 {
+		return
+	}
+
+	bytes := int64(msgBytes / len(points))
+
+	ps.lock.Lock()
+	defer ps.lock.Unlock()
+
+	for _, p := range points {
+		k := pointStatsKey{nodeType, p.Type}
+		if _, ok := ps.total[k]; !ok && len(ps.total) >= maxPointStats {
+			k.pointType = "other"
+		}
+
+		c := ps.total[k]
+		c.add(pointStatsCount{1, bytes})
+		ps.total[k] = c
+
+		c = ps.window[k]
+		c.add(pointStatsCount{1, bytes})
+		ps.window[k] = c
+	}
+}
+
+// report returns points with the point and byte rates by point type and by
+// node type since the last report, and starts a new window. Types that were
+// reported last time but have no points in this window are reported as 0.
+func (ps *pointStats) report(now time.Time) data.Points {
+	ps.lock.Lock()
+	defer ps.lock.Unlock()
+
+	secs := now.Sub(ps.start).Seconds()
+	if secs <= 0 {
+		return nil
+	}
+
+	byPoint := make(map[string]pointStatsCount)
+	byNode := make(map[string]pointStatsCount)
+
+	for k, c := range ps.window {
+		pc := byPoint[k.pointType]
+		pc.add(c)
+		byPoint[k.pointType] = pc
+
+		nc := byNode[k.nodeType]
+		nc.add(c)
+		byNode[k.nodeType] = nc
+	}
+
+	var ret data.Points
+	reported := make(map[string]bool)
+
+	addRates := func(counts map[string]pointStatsCount, typPoints, typBytes string) {
+		for key, c := range counts {
+			ret = append(ret,
+				data.Point{Time: now, Type: typPoints, Key: key, Value: float64(c.points) / secs},
+				data.Point{Time: now, Type: typBytes, Key: key, Value: float64(c.bytes) / secs})
+			reported[typPoints+"."+key] = true
+		}
+	}
+
+	addRates(byPoint, data.PointTypeMetricPointTypeRate, data.PointTypeMetricPointTypeByteRate)
+	addRates(byNode, data.PointTypeMetricNodeTypeRate, data.PointTypeMetricNodeTypeByteRate)
+
+	for k := range ps.lastReport {
+		if reported[k] {
+			continue
+		}
+		i := strings.Index(k, ".")
+		typ, key := k[:i], k[i+1:]
+		typBytes := data.PointTypeMetricPointTypeByteRate
+		if typ == data.PointTypeMetricNodeTypeRate {
+			typBytes = data.PointTypeMetricNodeTypeByteRate
+		}
+		ret = append(ret,
+			data.Point{Time: now, Type: typ, Key: key},
+			data.Point{Time: now, Type: typBytes, Key: key})
+	}
+
+	ps.lastReport = reported
+	ps.window = make(map[pointStatsKey]pointStatsCount)
+	ps.start = now
+
+	return ret
+}
+
+// writePrometheus writes the point totals in the Prometheus text format
+func (ps *pointStats) writePrometheus(w io.Writer) error {
+	ps.lock.Lock()
+	keys := make([]pointStatsKey, 0, len(ps.total))
+	total := make(map[pointStatsKey]pointStatsCount, len(ps.total))
+	for k, c := range ps.total {
+		keys = append(keys, k)
+		total[k] = c
+	}
+	ps.lock.Unlock()
+
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].nodeType != keys[j].nodeType {
+			return keys[i].nodeType < keys[j].nodeType
+		}
+		return keys[i].pointType < keys[j].pointType
+	})
+
+	metrics := []struct {
+		name, help string
+		value      func(c pointStatsCount) int64
+	}{
+		{"siot_store_points_total", "Points written to the store.",
+			func(c pointStatsCount) int64 { return c.points }},
+		{"siot_store_point_bytes_total", "Bytes of points written to the store.",
+			func(c pointStatsCount) int64 { return c.bytes }},
+	}
+
+	for _, m := range metrics {
+		_, err := fmt.Fprintf(w, "# HELP %v %v\n# TYPE %v counter\n", m.name, m.help, m.name)
+		if err != nil {
+			return err
+		}
+
+		for _, k := range keys {
+			_, err := fmt.Fprintf(w, "%v{node_type=\"%v\",point_type=\"%v\"} %v\n", m.name,
+				promLabel.Replace(k.nodeType), promLabel.Replace(k.pointType), m.value(total[k]))
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
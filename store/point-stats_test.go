@@ -0,0 +1,59 @@
+package store
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/simpleiot/simpleiot/data"
+)
+
+func TestPointStats(t *testing.T) {
+	start := time.Now()
+	ps := newPointStats(start)
+
+	ps.add(data.NodeTypeVariable, data.Points{
+		{Type: data.PointTypeValue},
+		{Type: data.PointTypeDescription},
+	}, 100)
+	ps.add(data.NodeTypeModbusIO, data.Points{{Type: data.PointTypeValue}}, 30)
+
+	points := ps.report(start.Add(10 * time.Second))
+
+	check := func(points data.Points, typ, key string, exp float64) {
+		t.Helper()
+		p, ok := points.Find(typ, key)
+		if !ok {
+			t.Errorf("%v.%v not found", typ, key)
+			return
+		}
+		if p.Value != exp {
+			t.Errorf("%v.%v: expected %v, got %v", typ, key, exp, p.Value)
+		}
+	}
+
+	check(points, data.PointTypeMetricPointTypeRate, data.PointTypeValue, 0.2)
+	check(points, data.PointTypeMetricPointTypeByteRate, data.PointTypeValue, 8)
+	check(points, data.PointTypeMetricNodeTypeRate, data.NodeTypeVariable, 0.2)
+	check(points, data.PointTypeMetricNodeTypeByteRate, data.NodeTypeVariable, 10)
+	check(points, data.PointTypeMetricNodeTypeRate, data.NodeTypeModbusIO, 0.1)
+
+	// types with no points in the next window are reported as 0
+	ps.add(data.NodeTypeVariable, data.Points{{Type: data.PointTypeValue}}, 10)
+	points = ps.report(start.Add(20 * time.Second))
+
+	check(points, data.PointTypeMetricPointTypeRate, data.PointTypeValue, 0.1)
+	check(points, data.PointTypeMetricPointTypeRate, data.PointTypeDescription, 0)
+	check(points, data.PointTypeMetricNodeTypeRate, data.NodeTypeModbusIO, 0)
+
+	var b strings.Builder
+	err := ps.writePrometheus(&b)
+	if err != nil {
+		t.Fatal("Error writing metrics: ", err)
+	}
+
+	exp := `siot_store_points_total{node_type="variable",point_type="value"} 2`
+	if !strings.Contains(b.String(), exp) {
+		t.Errorf("metrics do not contain %v:\n%v", exp, b.String())
+	}
+}
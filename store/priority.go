@@ -0,0 +1,181 @@
+package store
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/simpleiot/simpleiot/client"
+)
+
+// telemetryQueueLen is the number of telemetry node point messages that can
+// be queued before the node points subscription blocks
+var telemetryQueueLen = 10000
+
+// controlQueueLen is the number of control node point messages that can be
+// queued before the node points subscription blocks
+var controlQueueLen = 1000
+
+type queuedMsg struct {
+	msg      *nats.Msg
+	received time.Time
+}
+
+type priorityCount struct {
+	msgs    int64
+	latency time.Duration
+}
+
+// priorityQueue gives control node points a fast path ahead of telemetry.
+// Messages are queued by class and handled by a single worker, so points
+// are still written by one writer. The worker always handles queued control
+// messages before the next telemetry message. The time from receiving a
+// message until it is handled is tracked for each class.
+type priorityQueue struct {
+	chControl   chan queuedMsg
+	chTelemetry chan queuedMsg
+	chStop      chan struct{}
+	wg          sync.WaitGroup
+
+	lock  sync.Mutex
+	total map[string]priorityCount
+}
+
+func newPriorityQueue() *priorityQueue {
+	return &priorityQueue{
+		chControl:   make(chan queuedMsg, controlQueueLen),
+		chTelemetry: make(chan queuedMsg, telemetryQueueLen),
+		chStop:      make(chan struct{}),
+		total:       make(map[string]priorityCount),
+	}
+}
+
+// handler returns a handler that queues messages for the worker started
+// with start
+func (pq *priorityQueue) handler() nats.MsgHandler {
+	return func(msg *nats.Msg) {
+		ch := pq.chTelemetry
+		if client.MsgPriority(msg) == client.PriorityControl {
+			ch = pq.chControl
+		}
+
+		select {
+		case ch <- queuedMsg{msg, time.Now()}:
+		case <-pq.chStop:
+		}
+	}
+}
+
+// start handles queued messages with h until stop is called
+func (pq *priorityQueue) start(h nats.MsgHandler) {
+	pq.wg.Add(1)
+	go func() {
+		defer pq.wg.Done()
+		handle := func(priority string, m queuedMsg) {
+			h(m.msg)
+			pq.done(priority, m.received)
+		}
+
+		// drain handles the messages that are already queued, control first
+		drain := func() {
+			for {
+				select {
+				case m := <-pq.chControl:
+					handle(client.PriorityControl, m)
+					continue
+				default:
+				}
+
+				select {
+				case m := <-pq.chControl:
+					handle(client.PriorityControl, m)
+				case m := <-pq.chTelemetry:
+					handle(client.PriorityTelemetry, m)
+				default:
+					return
+				}
+			}
+		}
+
+		for {
+			// control messages are checked first, so they don't wait
+			// behind queued telemetry
+			select {
+			case m := <-pq.chControl:
+				handle(client.PriorityControl, m)
+				continue
+			default:
+			}
+
+			select {
+			case m := <-pq.chControl:
+				handle(client.PriorityControl, m)
+			case m := <-pq.chTelemetry:
+				handle(client.PriorityTelemetry, m)
+			case <-pq.chStop:
+				drain()
+				return
+			}
+		}
+	}()
+}
+
+// stop handles the queued messages and waits for the worker to exit
+func (pq *priorityQueue) stop() {
+	close(pq.chStop)
+	pq.wg.Wait()
+}
+
+// pending returns the number of queued telemetry messages
+func (pq *priorityQueue) pending() int {
+	return len(pq.chTelemetry)
+}
+
+func (pq *priorityQueue) done(priority string, received time.Time) {
+	pq.lock.Lock()
+	defer pq.lock.Unlock()
+	c := pq.total[priority]
+	c.msgs++
+	c.latency += time.Since(received)
+	pq.total[priority] = c
+}
+
+// writePrometheus writes the message counts and latency of each class in the
+// Prometheus text format
+func (pq *priorityQueue) writePrometheus(w io.Writer) error {
+	pq.lock.Lock()
+	total := make(map[string]priorityCount, len(pq.total))
+	for k, c := range pq.total {
+		total[k] = c
+	}
+	pq.lock.Unlock()
+
+	metrics := []struct {
+		name, help string
+		value      func(c priorityCount) string
+	}{
+		{"siot_store_point_msgs_total", "Node point messages handled by the store.",
+			func(c priorityCount) string { return fmt.Sprint(c.msgs) }},
+		{"siot_store_point_msg_latency_seconds_total",
+			"Time from the store receiving node point messages until they were handled.",
+			func(c priorityCount) string { return fmt.Sprint(c.latency.Seconds()) }},
+	}
+
+	for _, m := range metrics {
+		_, err := fmt.Fprintf(w, "# HELP %v %v\n# TYPE %v counter\n", m.name, m.help, m.name)
+		if err != nil {
+			return err
+		}
+
+		for _, p := range []string{client.PriorityControl, client.PriorityTelemetry} {
+			_, err := fmt.Fprintf(w, "%v{priority=\"%v\"} %v\n", m.name, p, m.value(total[p]))
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,87 @@
+package store
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/simpleiot/simpleiot/client"
+)
+
+func TestPriorityQueue(t *testing.T) {
+	pq := newPriorityQueue()
+
+	block := make(chan struct{})
+	started := make(chan struct{}, 10)
+	handled := make(chan string, 10)
+
+	var lock sync.Mutex
+	running := 0
+
+	h := func(msg *nats.Msg) {
+		lock.Lock()
+		running++
+		if running > 1 {
+			t.Error("messages handled concurrently")
+		}
+		lock.Unlock()
+
+		if msg.Subject == "telemetry1" {
+			started <- struct{}{}
+			<-block
+		}
+		handled <- msg.Subject
+
+		lock.Lock()
+		running--
+		lock.Unlock()
+	}
+
+	pq.start(h)
+	queue := pq.handler()
+
+	queue(nats.NewMsg("telemetry1"))
+	<-started
+
+	// the subscription handler does not block while a message is handled
+	done := make(chan struct{})
+	go func() {
+		queue(nats.NewMsg("telemetry2"))
+		control := nats.NewMsg("control")
+		control.Header.Set(client.HeaderPriority, client.PriorityControl)
+		queue(control)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("queueing messages blocked")
+	}
+
+	if pq.pending() != 1 {
+		t.Error("expected 1 pending telemetry message, got: ", pq.pending())
+	}
+
+	close(block)
+
+	// control is handled ahead of telemetry that was queued before it
+	for _, exp := range []string{"telemetry1", "control", "telemetry2"} {
+		select {
+		case s := <-handled:
+			if s != exp {
+				t.Fatalf("expected %v, got %v", exp, s)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("timeout waiting for ", exp)
+		}
+	}
+
+	queue(nats.NewMsg("telemetry3"))
+	pq.stop()
+
+	if len(handled) != 1 {
+		t.Error("queued telemetry was not handled on stop: ", len(handled))
+	}
+}
@@ -0,0 +1,126 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+
+	"github.com/simpleiot/simpleiot/client"
+	"github.com/simpleiot/simpleiot/data"
+	"github.com/simpleiot/simpleiot/msg"
+)
+
+// pushSubject is the VAPID contact of the push requests this server sends
+const pushSubject = "https://github.com/simpleiot/simpleiot"
+
+// pushUrgency maps notification severities to Web Push urgency, so browsers
+// on battery can defer less important notifications
+var pushUrgency = map[string]string{
+	data.PointValueInfo:     "normal",
+	data.PointValueWarning:  "high",
+	data.PointValueCritical: "high",
+}
+
+// pushPayload is the message the service worker of the frontend shows
+type pushPayload struct {
+	ID       string `json:"id"`
+	Title    string `json:"title"`
+	Body     string `json:"body"`
+	Severity string `json:"severity,omitempty"`
+	NodeID   string `json:"nodeID,omitempty"`
+}
+
+// initPush loads the VAPID key pair from the root node. A key pair is
+// generated the first time the store starts.
+func (st *Store) initPush() error {
+	rootID := st.db.rootNodeID()
+	if rootID == "" {
+		return nil
+	}
+
+	root, err := st.db.node(rootID)
+	if err != nil {
+		return err
+	}
+
+	pub, _ := root.Points.Text(data.PointTypeVapidPublicKey, "")
+	priv, _ := root.Points.Text(data.PointTypeVapidPrivateKey, "")
+
+	if pub == "" || priv == "" {
+		if st.readOnly {
+			return errors.New("no VAPID key in read-only store")
+		}
+
+		pub, priv, err = msg.GenerateVapidKeys()
+		if err != nil {
+			return err
+		}
+
+		err = st.db.nodePoints(rootID, data.Points{
+			{Type: data.PointTypeVapidPublicKey, Text: pub},
+			{Type: data.PointTypeVapidPrivateKey, Text: priv},
+		})
+		if err != nil {
+			return err
+		}
+
+		log.Println("Generated VAPID key for web push")
+	}
+
+	st.push, err = msg.NewWebPush(pub, priv, pushSubject)
+	return err
+}
+
+// sendPush sends a notification to the browsers a user has subscribed for
+// push notifications. Subscriptions the push service no longer knows are
+// removed from the user node.
+func (st *Store) sendPush(userNode data.NodeEdge, not data.Notification, severity string) {
+	if st.push == nil {
+		return
+	}
+
+	subs := data.PushSubscriptions(userNode.Points)
+	if len(subs) < 1 {
+		return
+	}
+
+	title := not.Subject
+	if title == "" {
+		title = "Simple IoT"
+	}
+
+	payload, err := json.Marshal(pushPayload{
+		ID:       not.ID,
+		Title:    title,
+		Body:     not.Message,
+		Severity: severity,
+		NodeID:   not.SourceNode,
+	})
+	if err != nil {
+		log.Println("Error encoding push payload: ", err)
+		return
+	}
+
+	// push services can be slow, so don't block the store
+	go func() {
+		for _, sub := range subs {
+			err := st.push.Send(sub, payload, pushUrgency[severity])
+			if errors.Is(err, msg.ErrPushGone) {
+				err = client.SendNodePoint(st.nc, userNode.ID, data.Point{
+					Type:      data.PointTypePushSubscription,
+					Key:       sub.Key(),
+					Tombstone: 1,
+					Origin:    userNode.ID,
+				}, false)
+				if err != nil {
+					log.Println("Error removing push subscription: ", err)
+				}
+				continue
+			}
+
+			if err != nil {
+				log.Printf("Error sending push to user %v: %v\n", userNode.ID, err)
+			}
+		}
+	}()
+}
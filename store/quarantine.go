@@ -0,0 +1,253 @@
+package store
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/simpleiot/simpleiot/client"
+	"github.com/simpleiot/simpleiot/data"
+)
+
+// quarantineMax is the number of quarantined points kept. The oldest points
+// are removed when there are more.
+var quarantineMax = 10000
+
+// quarantine stores points that failed validation
+func (sdb *DbSqlite) quarantine(nodeID, reason string, points data.Points, now time.Time) error {
+	return sdb.tx(func(tx *sql.Tx) error {
+		for _, p := range points {
+			d, err := json.Marshal(p)
+			if err != nil {
+				return err
+			}
+
+			_, err = tx.Exec(`INSERT INTO quarantine(node_id, time_s, time_ns, reason,
+				point) VALUES(?, ?, ?, ?, ?)`,
+				nodeID, now.Unix(), now.Nanosecond(), reason, string(d))
+			if err != nil {
+				return fmt.Errorf("Error writing quarantined point: %w", err)
+			}
+		}
+
+		_, err := tx.Exec(`DELETE FROM quarantine WHERE id NOT IN
+			(SELECT id FROM quarantine ORDER BY id DESC LIMIT ?)`, quarantineMax)
+		if err != nil {
+			return fmt.Errorf("Error trimming quarantine: %w", err)
+		}
+
+		return nil
+	})
+}
+
+// idsWhere returns a where clause and args that select ids
+func idsWhere(ids []int64) (string, []any) {
+	args := make([]any, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+
+	return "id IN (" + strings.TrimSuffix(strings.Repeat("?,", len(ids)), ",") + ")", args
+}
+
+// quarantined returns quarantined points, oldest first. If nodeID is set,
+// only the points of that node are returned. If ids are set, only those
+// points are returned.
+func (sdb *DbSqlite) quarantined(nodeID string, ids []int64, limit int) ([]data.QuarantinedPoint, error) {
+	q := `SELECT id, node_id, time_s, time_ns, reason, point FROM quarantine WHERE 1=1`
+	var args []any
+
+	if nodeID != "" {
+		q += " AND node_id=?"
+		args = append(args, nodeID)
+	}
+
+	if len(ids) > 0 {
+		w, a := idsWhere(ids)
+		q += " AND " + w
+		args = append(args, a...)
+	}
+
+	q += " ORDER BY id"
+
+	if limit > 0 {
+		q += " LIMIT ?"
+		args = append(args, limit)
+	}
+
+	rows, err := sdb.db.Query(q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ret []data.QuarantinedPoint
+
+	for rows.Next() {
+		var qp data.QuarantinedPoint
+		var timeS, timeNS int64
+		var point string
+		err := rows.Scan(&qp.ID, &qp.NodeID, &timeS, &timeNS, &qp.Reason, &point)
+		if err != nil {
+			return nil, err
+		}
+
+		err = json.Unmarshal([]byte(point), &qp.Point)
+		if err != nil {
+			return nil, fmt.Errorf("Error decoding quarantined point %v: %w", qp.ID, err)
+		}
+
+		qp.Time = time.Unix(timeS, timeNS)
+		ret = append(ret, qp)
+	}
+
+	return ret, rows.Err()
+}
+
+// quarantineDelete removes quarantined points and returns the number removed
+func (sdb *DbSqlite) quarantineDelete(ids []int64) (int, error) {
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	w, args := idsWhere(ids)
+	res, err := sdb.db.Exec(`DELETE FROM quarantine WHERE `+w, args...)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+// quarantineCount returns the number of quarantined points
+func (sdb *DbSqlite) quarantineCount() (int, error) {
+	var ret int
+	err := sdb.db.QueryRow(`SELECT COUNT(*) FROM quarantine`).Scan(&ret)
+	return ret, err
+}
+
+// quarantinePoints stores points that failed validation so they are not
+// lost. Errors are logged, as the points were rejected anyway.
+func (st *Store) quarantinePoints(nodeID, reason string, points data.Points) {
+	err := st.db.quarantine(nodeID, reason, points, time.Now())
+	if err != nil {
+		log.Printf("Store: error quarantining %v points for %v: %v\n", len(points), nodeID, err)
+	}
+}
+
+func (st *Store) respondQuarantine(msg *nats.Msg, res client.QuarantineResult) {
+	d, err := json.Marshal(res)
+	if err != nil {
+		log.Println("Error encoding quarantine response: ", err)
+		return
+	}
+
+	err = client.Respond(st.nc, msg, d)
+	if err != nil {
+		log.Println("NATS: Error publishing response to quarantine request: ", err)
+	}
+}
+
+// handleQuarantineList returns quarantined points. The request is a JSON
+// encoded client.QuarantineQuery.
+func (st *Store) handleQuarantineList(msg *nats.Msg) {
+	var res client.QuarantineResult
+	var q client.QuarantineQuery
+
+	if len(msg.Data) > 0 {
+		if err := json.Unmarshal(msg.Data, &q); err != nil {
+			res.Error = fmt.Sprintf("Error decoding quarantine query: %v", err)
+			st.respondQuarantine(msg, res)
+			return
+		}
+	}
+
+	var err error
+	res.Points, err = st.db.quarantined(q.NodeID, nil, q.Limit)
+	if err != nil {
+		res.Error = fmt.Sprintf("Error getting quarantined points: %v", err)
+	}
+	res.Count = len(res.Points)
+
+	st.respondQuarantine(msg, res)
+}
+
+// handleQuarantineAdmit writes quarantined points to their nodes and removes
+// them from the quarantine. The request is a JSON encoded
+// client.QuarantineRequest.
+func (st *Store) handleQuarantineAdmit(msg *nats.Msg) {
+	var res client.QuarantineResult
+	var req client.QuarantineRequest
+
+	if err := json.Unmarshal(msg.Data, &req); err != nil {
+		res.Error = fmt.Sprintf("Error decoding quarantine request: %v", err)
+		st.respondQuarantine(msg, res)
+		return
+	}
+
+	if len(req.IDs) == 0 {
+		st.respondQuarantine(msg, res)
+		return
+	}
+
+	qps, err := st.db.quarantined("", req.IDs, 0)
+	if err != nil {
+		res.Error = fmt.Sprintf("Error getting quarantined points: %v", err)
+		st.respondQuarantine(msg, res)
+		return
+	}
+
+	// points are written per node in the order they were quarantined
+	var nodeIDs []string
+	points := make(map[string]data.Points)
+	ids := make(map[string][]int64)
+	for _, qp := range qps {
+		if _, ok := points[qp.NodeID]; !ok {
+			nodeIDs = append(nodeIDs, qp.NodeID)
+		}
+		points[qp.NodeID] = append(points[qp.NodeID], qp.Point)
+		ids[qp.NodeID] = append(ids[qp.NodeID], qp.ID)
+	}
+
+	for _, nodeID := range nodeIDs {
+		err := st.writeNodePoints(nodeID, "", points[nodeID], 0)
+		if err != nil {
+			res.Error = fmt.Sprintf("Error writing points for %v: %v", nodeID, err)
+			break
+		}
+
+		n, err := st.db.quarantineDelete(ids[nodeID])
+		if err != nil {
+			res.Error = fmt.Sprintf("Error removing admitted points: %v", err)
+			break
+		}
+
+		res.Count += n
+	}
+
+	st.respondQuarantine(msg, res)
+}
+
+// handleQuarantineDiscard removes quarantined points. The request is a JSON
+// encoded client.QuarantineRequest.
+func (st *Store) handleQuarantineDiscard(msg *nats.Msg) {
+	var res client.QuarantineResult
+	var req client.QuarantineRequest
+
+	err := json.Unmarshal(msg.Data, &req)
+	if err != nil {
+		res.Error = fmt.Sprintf("Error decoding quarantine request: %v", err)
+	} else {
+		res.Count, err = st.db.quarantineDelete(req.IDs)
+		if err != nil {
+			res.Error = fmt.Sprintf("Error discarding quarantined points: %v", err)
+		}
+	}
+
+	st.respondQuarantine(msg, res)
+}
@@ -0,0 +1,183 @@
+package store
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/nats-io/nats.go"
+	"github.com/simpleiot/simpleiot/client"
+	"github.com/simpleiot/simpleiot/data"
+	"github.com/simpleiot/simpleiot/internal/pb"
+	"google.golang.org/protobuf/proto"
+)
+
+// recentBuf is a ring buffer of the last values of a point
+type recentBuf struct {
+	points []data.Point
+	next   int
+}
+
+func (rb *recentBuf) add(p data.Point, n int) {
+	if len(rb.points) > n {
+		// buffer size was reduced
+		pts := rb.ordered()
+		rb.points = pts[len(pts)-n:]
+		rb.next = 0
+	}
+
+	if len(rb.points) < n {
+		rb.points = append(rb.points, p)
+		return
+	}
+
+	rb.points[rb.next] = p
+	rb.next = (rb.next + 1) % n
+}
+
+// ordered returns points in the order they were added
+func (rb *recentBuf) ordered() data.Points {
+	ret := make(data.Points, 0, len(rb.points))
+	ret = append(ret, rb.points[rb.next:]...)
+	return append(ret, rb.points[:rb.next]...)
+}
+
+// recentCache keeps the last N values of each node point in memory so
+// that clients like the UI can display recent history (sparklines, etc)
+// without querying a history database. The cache is not persisted.
+type recentCache struct {
+	defaultLen int
+	lock       sync.Mutex
+	bufs       map[string]*recentBuf
+}
+
+func newRecentCache(defaultLen int) *recentCache {
+	return &recentCache{
+		defaultLen: defaultLen,
+		bufs:       make(map[string]*recentBuf),
+	}
+}
+
+func recentKey(nodeID, typ, key string) string {
+	return nodeID + "." + typ + "." + key
+}
+
+// add adds points for a node. n is the buffer length, which can be set
+// per node with the recentLen point. If n is 0, the default length is used.
+func (rc *recentCache) add(nodeID string, points data.Points, n int) {
+	if n <= 0 {
+		n = rc.defaultLen
+	}
+
+	rc.lock.Lock()
+	defer rc.lock.Unlock()
+
+	for _, p := range points {
+		k := recentKey(nodeID, p.Type, p.Key)
+		b, ok := rc.bufs[k]
+		if n <= 0 {
+			if ok {
+				delete(rc.bufs, k)
+			}
+			continue
+		}
+
+		if !ok {
+			b = &recentBuf{}
+			rc.bufs[k] = b
+		}
+
+		p.Origin = p.OriginOrNode(nodeID)
+		b.add(p, n)
+	}
+}
+
+// get returns the recent points for a node sorted by time. If typ is
+// blank, points of all types are returned.
+func (rc *recentCache) get(nodeID, typ, key string) data.Points {
+	rc.lock.Lock()
+	defer rc.lock.Unlock()
+
+	var ret data.Points
+
+	if typ != "" {
+		if b, ok := rc.bufs[recentKey(nodeID, typ, key)]; ok {
+			ret = b.ordered()
+		}
+	} else {
+		prefix := nodeID + "."
+		for k, b := range rc.bufs {
+			if strings.HasPrefix(k, prefix) {
+				ret = append(ret, b.ordered()...)
+			}
+		}
+	}
+
+	sort.Sort(ret)
+	return ret
+}
+
+// remove removes the recent points of a node
+func (rc *recentCache) remove(nodeID string) {
+	rc.lock.Lock()
+	defer rc.lock.Unlock()
+
+	prefix := nodeID + "."
+	for k := range rc.bufs {
+		if strings.HasPrefix(k, prefix) {
+			delete(rc.bufs, k)
+		}
+	}
+}
+
+// handleNodeRecent handles requests for the recent values of node points.
+// Request parameters are passed as points:
+//   - pointType: text is the point type to return, all types if blank
+//   - pointKey: text is the point key
+func (st *Store) handleNodeRecent(msg *nats.Msg) {
+	resp := &pb.PointsRequest{}
+
+	chunks := strings.Split(msg.Subject, ".")
+	if len(chunks) < 3 {
+		resp.Error = fmt.Sprintf("Error in message subject: %v", msg.Subject)
+	} else {
+		var typ, key string
+		params, err := data.PbDecodePoints(msg.Data)
+		if err != nil {
+			resp.Error = fmt.Sprintf("Error decoding points %v", err)
+		}
+
+		for _, p := range params {
+			switch p.Type {
+			case data.PointTypePointType:
+				typ = p.Text
+			case data.PointTypePointKey:
+				key = p.Text
+			}
+		}
+
+		if resp.Error == "" {
+			for _, p := range st.recent.get(chunks[1], typ, key) {
+				pPb, err := p.ToPb()
+				if err != nil {
+					resp.Error = fmt.Sprintf("Error pb encoding points: %v", err)
+					break
+				}
+				resp.Points = append(resp.Points, &pPb)
+			}
+		}
+	}
+
+	d, err := proto.Marshal(resp)
+	if err != nil {
+		log.Println("Error encoding recent points: ", err)
+		return
+	}
+
+	err = client.Respond(st.nc, msg, d)
+	if err != nil {
+		log.Println("Error replying to recent points request: ", err)
+	}
+}
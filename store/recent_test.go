@@ -0,0 +1,55 @@
+package store
+
+import (
+	"testing"
+	"time"
+
+	"github.com/simpleiot/simpleiot/data"
+)
+
+func TestRecentCache(t *testing.T) {
+	rc := newRecentCache(3)
+	now := time.Now()
+
+	for i := 0; i < 5; i++ {
+		rc.add("n1", data.Points{{Time: now.Add(time.Duration(i) * time.Second),
+			Type: data.PointTypeValue, Value: float64(i)}}, 0)
+	}
+
+	rc.add("n1", data.Points{{Time: now, Type: data.PointTypeDescription, Text: "x"}}, 0)
+
+	pts := rc.get("n1", data.PointTypeValue, "")
+	if len(pts) != 3 {
+		t.Fatal("expected 3 points, got: ", len(pts))
+	}
+
+	for i, p := range pts {
+		if p.Value != float64(i+2) {
+			t.Errorf("point %v: expected %v, got %v", i, i+2, p.Value)
+		}
+	}
+
+	if len(rc.get("n1", "", "")) != 4 {
+		t.Error("expected 4 points for all types")
+	}
+
+	// node can reduce the buffer size
+	rc.add("n1", data.Points{{Time: now.Add(5 * time.Second),
+		Type: data.PointTypeValue, Value: 5}}, 2)
+
+	pts = rc.get("n1", data.PointTypeValue, "")
+	if len(pts) != 2 || pts[0].Value != 4 || pts[1].Value != 5 {
+		t.Error("buffer was not resized: ", pts)
+	}
+
+	if len(rc.get("n2", data.PointTypeValue, "")) != 0 {
+		t.Error("expected no points for n2")
+	}
+
+	// disabled by default
+	rc = newRecentCache(0)
+	rc.add("n1", data.Points{{Time: now, Type: data.PointTypeValue}}, 0)
+	if len(rc.get("n1", "", "")) != 0 {
+		t.Error("cache should be disabled")
+	}
+}
@@ -0,0 +1,340 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"time"
+
+	"github.com/simpleiot/simpleiot/client"
+	"github.com/simpleiot/simpleiot/data"
+)
+
+// retentionCheckPeriod is how often the store size is checked against
+// the configured limit
+var retentionCheckPeriod = 10 * time.Minute
+
+// retentionAges are the minimum ages of deleted data that is pruned when
+// the store is over its size limit. Data deleted longest ago is pruned
+// first, and more recently deleted data is only pruned if the store is
+// still over the limit.
+var retentionAges = []time.Duration{
+	30 * 24 * time.Hour,
+	7 * 24 * time.Hour,
+	24 * time.Hour,
+}
+
+// retentionHistoryFractions are the fractions of the point change history
+// and quarantined points that are pruned, oldest first, if the store is
+// still over its size limit after deleted data is pruned
+var retentionHistoryFractions = []float64{0.25, 0.5, 1}
+
+// RetentionStats describes what was pruned from the store
+type RetentionStats struct {
+	Points      int64
+	Edges       int64
+	Nodes       int64
+	Changes     int64
+	Quarantined int64
+	SizeBefore  int64
+	SizeAfter   int64
+}
+
+func (rs *RetentionStats) add(s RetentionStats) {
+	rs.Points += s.Points
+	rs.Edges += s.Edges
+	rs.Nodes += s.Nodes
+	rs.Changes += s.Changes
+	rs.Quarantined += s.Quarantined
+}
+
+func (rs RetentionStats) String() string {
+	return fmt.Sprintf("pruned %v points, %v edges, %v nodes, %v changes, %v quarantined points, size %v -> %v bytes",
+		rs.Points, rs.Edges, rs.Nodes, rs.Changes, rs.Quarantined, rs.SizeBefore, rs.SizeAfter)
+}
+
+// size returns the size of the store on disk, including the WAL file
+func (sdb *DbSqlite) size() (int64, error) {
+	var ret int64
+
+	for _, f := range []string{sdb.file, sdb.file + "-wal"} {
+		fi, err := os.Stat(f)
+		if err != nil {
+			if os.IsNotExist(err) && f != sdb.file {
+				continue
+			}
+			return 0, err
+		}
+		ret += fi.Size()
+	}
+
+	return ret, nil
+}
+
+// prune removes data that was deleted before the given time. This includes
+// deleted points, deleted edges, and nodes that are no longer referenced by
+// any edge.
+func (sdb *DbSqlite) prune(before time.Time) (RetentionStats, error) {
+	var ret RetentionStats
+
+	b := before.Unix()
+
+	tx, err := sdb.db.Begin()
+	if err != nil {
+		return ret, err
+	}
+
+	exec := func(count *int64, query string, args ...any) error {
+		res, err := tx.Exec(query, args...)
+		if err != nil {
+			return err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if count != nil {
+			*count += n
+		}
+		return nil
+	}
+
+	err = func() error {
+		// deleted edges
+		err := exec(&ret.Edges, `DELETE FROM edges WHERE id IN
+			(SELECT edge_id FROM edge_points WHERE type=? AND value!=0 AND time_s<?)`,
+			data.PointTypeTombstone, b)
+		if err != nil {
+			return fmt.Errorf("Error pruning deleted edges: %v", err)
+		}
+
+		// nodes without edges and edges whose parent node is gone. This
+		// is repeated until nothing changes so that children of deleted
+		// nodes are also removed.
+		for {
+			var nodes, edges int64
+
+			err := tx.QueryRow(`SELECT COUNT(DISTINCT node_id) FROM node_points
+				WHERE node_id NOT IN (SELECT down FROM edges)
+				AND node_id IN (SELECT node_id FROM node_points
+				GROUP BY node_id HAVING MAX(time_s)<?)`, b).Scan(&nodes)
+			if err != nil {
+				return fmt.Errorf("Error counting orphaned nodes: %v", err)
+			}
+
+			err = exec(&ret.Points, `DELETE FROM node_points
+				WHERE node_id NOT IN (SELECT down FROM edges)
+				AND node_id IN (SELECT node_id FROM node_points
+				GROUP BY node_id HAVING MAX(time_s)<?)`, b)
+			if err != nil {
+				return fmt.Errorf("Error pruning orphaned nodes: %v", err)
+			}
+
+			err = exec(&edges, `DELETE FROM edges WHERE up!='none'
+				AND up NOT IN (SELECT node_id FROM node_points)
+				AND id NOT IN (SELECT edge_id FROM edge_points WHERE time_s>=?)`, b)
+			if err != nil {
+				return fmt.Errorf("Error pruning orphaned edges: %v", err)
+			}
+
+			ret.Nodes += nodes
+			ret.Edges += edges
+
+			if nodes == 0 && edges == 0 {
+				break
+			}
+		}
+
+		err = exec(&ret.Points, `DELETE FROM edge_points
+			WHERE edge_id NOT IN (SELECT id FROM edges)`)
+		if err != nil {
+			return fmt.Errorf("Error pruning edge points: %v", err)
+		}
+
+		err = exec(nil, `DELETE FROM point_changes
+			WHERE node_id NOT IN (SELECT node_id FROM node_points)`)
+		if err != nil {
+			return fmt.Errorf("Error pruning point changes: %v", err)
+		}
+
+		// deleted points
+		err = exec(&ret.Points, `DELETE FROM node_points WHERE tombstone!=0 AND time_s<?`, b)
+		if err != nil {
+			return fmt.Errorf("Error pruning deleted node points: %v", err)
+		}
+
+		err = exec(&ret.Points, `DELETE FROM edge_points WHERE tombstone!=0 AND time_s<?`, b)
+		if err != nil {
+			return fmt.Errorf("Error pruning deleted edge points: %v", err)
+		}
+
+		return nil
+	}()
+
+	if err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil && rbErr != sql.ErrTxDone {
+			log.Println("Rollback error: ", rbErr)
+		}
+		return RetentionStats{}, err
+	}
+
+	return ret, tx.Commit()
+}
+
+// pruneHistory removes the oldest fraction (0-1) of the point change
+// history and quarantined points
+func (sdb *DbSqlite) pruneHistory(fraction float64) (RetentionStats, error) {
+	var ret RetentionStats
+
+	err := sdb.tx(func(tx *sql.Tx) error {
+		prune := func(count *int64, table, order string) error {
+			var rows int64
+			err := tx.QueryRow(`SELECT COUNT(*) FROM ` + table).Scan(&rows)
+			if err != nil {
+				return err
+			}
+
+			limit := int64(math.Ceil(float64(rows) * fraction))
+			if limit <= 0 {
+				return nil
+			}
+
+			res, err := tx.Exec(`DELETE FROM `+table+` WHERE rowid IN
+				(SELECT rowid FROM `+table+` ORDER BY `+order+` LIMIT ?)`, limit)
+			if err != nil {
+				return err
+			}
+
+			*count, err = res.RowsAffected()
+			return err
+		}
+
+		err := prune(&ret.Changes, "point_changes", "time_s, time_ns, rowid")
+		if err != nil {
+			return fmt.Errorf("Error pruning point changes: %v", err)
+		}
+
+		err = prune(&ret.Quarantined, "quarantine", "id")
+		if err != nil {
+			return fmt.Errorf("Error pruning quarantined points: %v", err)
+		}
+
+		return nil
+	})
+
+	return ret, err
+}
+
+// compact returns free space in the store to the file system
+func (sdb *DbSqlite) compact() error {
+	_, err := sdb.db.Exec("PRAGMA wal_checkpoint(TRUNCATE)")
+	if err != nil {
+		return fmt.Errorf("Error checkpointing WAL: %v", err)
+	}
+
+	_, err = sdb.db.Exec("VACUUM")
+	if err != nil {
+		return fmt.Errorf("Error vacuuming db: %v", err)
+	}
+
+	return nil
+}
+
+// retention prunes deleted data, and then the point change history and
+// quarantined points, oldest first, until the store is under maxSize bytes.
+// The current state of nodes is never pruned, so the store may still be over
+// maxSize when this returns.
+func (sdb *DbSqlite) retention(maxSize int64) (RetentionStats, error) {
+	var ret RetentionStats
+
+	size, err := sdb.size()
+	if err != nil {
+		return ret, err
+	}
+
+	ret.SizeBefore = size
+	ret.SizeAfter = size
+
+	var steps []func() (RetentionStats, error)
+
+	for _, age := range retentionAges {
+		before := time.Now().Add(-age)
+		steps = append(steps, func() (RetentionStats, error) {
+			return sdb.prune(before)
+		})
+	}
+
+	for _, fraction := range retentionHistoryFractions {
+		fraction := fraction
+		steps = append(steps, func() (RetentionStats, error) {
+			return sdb.pruneHistory(fraction)
+		})
+	}
+
+	for _, step := range steps {
+		if ret.SizeAfter <= maxSize {
+			break
+		}
+
+		stats, err := step()
+		if err != nil {
+			return ret, err
+		}
+
+		ret.add(stats)
+
+		err = sdb.compact()
+		if err != nil {
+			return ret, err
+		}
+
+		ret.SizeAfter, err = sdb.size()
+		if err != nil {
+			return ret, err
+		}
+	}
+
+	return ret, nil
+}
+
+// checkRetention checks the store size and prunes the store if it is over
+// the configured limit. The store size and what was pruned are sent as
+// points to the root node.
+func (st *Store) checkRetention() {
+	size, err := st.db.size()
+	if err != nil {
+		log.Println("Store retention, error getting size: ", err)
+		return
+	}
+
+	now := time.Now()
+	pts := data.Points{{Time: now, Type: data.PointTypeStoreSize, Value: float64(size)}}
+
+	if size > st.maxSize {
+		stats, err := st.db.retention(st.maxSize)
+		if err != nil {
+			log.Println("Store retention error: ", err)
+		}
+
+		log.Println("Store retention: ", stats)
+
+		if stats.SizeAfter > st.maxSize {
+			log.Printf("Store retention: store size %v is over limit %v after pruning deleted data and history\n",
+				stats.SizeAfter, st.maxSize)
+		}
+
+		pts = data.Points{
+			{Time: now, Type: data.PointTypeStoreSize, Value: float64(stats.SizeAfter)},
+			{Time: now, Type: data.PointTypeRetentionPrunedPoints, Value: float64(stats.Points)},
+			{Time: now, Type: data.PointTypeRetentionPrunedNodes, Value: float64(stats.Nodes)},
+			{Time: now, Type: data.PointTypeRetentionLastPrune, Text: stats.String()},
+		}
+	}
+
+	err = client.SendPoints(st.nc, st.subject(client.SubjectNodePoints(st.db.rootNodeID())), pts, false)
+	if err != nil {
+		log.Println("Store retention, error sending points: ", err)
+	}
+}
@@ -0,0 +1,215 @@
+package store
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/simpleiot/simpleiot/data"
+)
+
+func TestDbSqlitePrune(t *testing.T) {
+	db := newTestDb(t)
+	defer db.Close()
+
+	rootID := db.rootNodeID()
+	old := time.Now().Add(-48 * time.Hour)
+
+	// a group with a child, deleted long ago
+	groupID := uuid.New().String()
+	childID := uuid.New().String()
+
+	err := db.nodePoints(groupID, data.Points{{Time: old, Type: data.PointTypeNodeType,
+		Text: data.NodeTypeGroup}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = db.edgePoints(groupID, rootID, data.Points{{Time: old, Type: data.PointTypeTombstone,
+		Value: 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = db.nodePoints(childID, data.Points{{Time: old, Type: data.PointTypeNodeType,
+		Text: data.NodeTypeVariable}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = db.edgePoints(childID, groupID, data.Points{{Time: old, Type: data.PointTypeTombstone,
+		Value: 0}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a node deleted recently should be kept
+	recentID := uuid.New().String()
+
+	err = db.nodePoints(recentID, data.Points{{Type: data.PointTypeNodeType,
+		Text: data.NodeTypeGroup}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = db.edgePoints(recentID, rootID, data.Points{{Type: data.PointTypeTombstone,
+		Value: 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// deleted point on the root node
+	err = db.nodePoints(rootID, data.Points{{Time: old, Type: data.PointTypeDescription,
+		Text: "old", Tombstone: 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stats, err := db.prune(time.Now().Add(-24 * time.Hour))
+	if err != nil {
+		t.Fatal("prune error: ", err)
+	}
+
+	if stats.Nodes != 2 {
+		t.Error("expected 2 nodes to be pruned, got: ", stats.Nodes)
+	}
+
+	if stats.Edges != 2 {
+		t.Error("expected 2 edges to be pruned, got: ", stats.Edges)
+	}
+
+	// 2 node type points, 2 tombstone edge points, 1 deleted point
+	if stats.Points != 5 {
+		t.Error("expected 5 points to be pruned, got: ", stats.Points)
+	}
+
+	for _, id := range []string{groupID, childID} {
+		if _, err := db.node(id); err == nil {
+			t.Error("node was not pruned: ", id)
+		}
+	}
+
+	if _, err := db.node(recentID); err != nil {
+		t.Error("recently deleted node was pruned")
+	}
+
+	children, err := db.children(rootID, "", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// admin user and recently deleted node
+	if len(children) != 2 {
+		t.Error("expected 2 root children, got: ", len(children))
+	}
+
+	root, err := db.node(rootID)
+	if err != nil {
+		t.Fatal("root node was pruned")
+	}
+
+	if _, ok := root.Points.Find(data.PointTypeDescription, ""); ok {
+		t.Error("deleted point was not pruned")
+	}
+}
+
+func TestDbSqliteRetention(t *testing.T) {
+	db := newTestDb(t)
+	defer db.Close()
+
+	nodeID := uuid.New().String()
+	old := time.Now().Add(-60 * 24 * time.Hour)
+
+	err := db.nodePoints(nodeID, data.Points{{Time: old, Type: data.PointTypeNodeType,
+		Text: data.NodeTypeGroup}, {Time: old, Type: data.PointTypeDescription,
+		Text: string(make([]byte, 100000))}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = db.edgePoints(nodeID, db.rootNodeID(), data.Points{{Time: old,
+		Type: data.PointTypeTombstone, Value: 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stats, err := db.retention(1)
+	if err != nil {
+		t.Fatal("retention error: ", err)
+	}
+
+	if stats.Nodes != 1 {
+		t.Error("expected 1 node to be pruned, got: ", stats.Nodes)
+	}
+
+	if stats.SizeAfter >= stats.SizeBefore {
+		t.Errorf("store did not shrink: %v -> %v", stats.SizeBefore, stats.SizeAfter)
+	}
+}
+
+func TestDbSqliteRetentionHistory(t *testing.T) {
+	db := newTestDb(t)
+	defer db.Close()
+
+	nodeID := uuid.New().String()
+	start := time.Now().Add(-time.Hour)
+
+	// changes of nodes that do not exist are pruned with deleted data
+	err := db.nodePoints(nodeID, data.Points{{Type: data.PointTypeNodeType,
+		Text: data.NodeTypeVariable}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var changes []data.PointChange
+	for i := 0; i < 4; i++ {
+		changes = append(changes, data.PointChange{NodeID: nodeID,
+			Time: start.Add(time.Duration(i) * time.Minute), Type: data.PointTypeValue,
+			Value: float64(i), Origin: "user"})
+	}
+
+	err = db.tx(func(tx *sql.Tx) error {
+		return writeChanges(tx, nodeID, changes)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = db.quarantine(nodeID, "test", data.Points{{Type: data.PointTypeValue,
+		Text: string(make([]byte, 100000))}, {Type: data.PointTypeValue}}, start)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the oldest half is pruned
+	stats, err := db.pruneHistory(0.5)
+	if err != nil {
+		t.Fatal("prune error: ", err)
+	}
+
+	if stats.Changes != 2 || stats.Quarantined != 1 {
+		t.Errorf("expected 2 changes and 1 quarantined point pruned, got %v, %v",
+			stats.Changes, stats.Quarantined)
+	}
+
+	remaining, err := db.changes(nodeID, "", "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(remaining) != 2 || remaining[1].Value != 2 {
+		t.Error("newest changes should be kept: ", remaining)
+	}
+
+	// the rest is pruned if the store is still over the limit
+	stats, err = db.retention(1)
+	if err != nil {
+		t.Fatal("retention error: ", err)
+	}
+
+	if stats.Changes != 2 || stats.Quarantined != 1 {
+		t.Errorf("expected all history pruned, got %v changes, %v quarantined points",
+			stats.Changes, stats.Quarantined)
+	}
+}
@@ -0,0 +1,157 @@
+package store
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/simpleiot/simpleiot/data"
+)
+
+// secretPrefix marks the text of secret points that are encrypted in the
+// store
+const secretPrefix = "enc1:"
+
+// SecretsKeySize is the size of the key used to encrypt secret points
+// (AES-256)
+const SecretsKeySize = 32
+
+// setSecretsKey enables encryption of secret points. Secret points that
+// were stored before a key was configured are encrypted.
+func (sdb *DbSqlite) setSecretsKey(key []byte, readOnly bool) error {
+	if len(key) != SecretsKeySize {
+		return fmt.Errorf("secrets key must be %v bytes", SecretsKeySize)
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return err
+	}
+
+	sdb.secrets, err = cipher.NewGCM(block)
+	if err != nil {
+		return err
+	}
+
+	if readOnly {
+		return nil
+	}
+
+	return sdb.encryptSecrets()
+}
+
+// encryptSecrets encrypts secret points that are stored as plain text
+func (sdb *DbSqlite) encryptSecrets() error {
+	types := strings.TrimSuffix(strings.Repeat("?,", len(data.SecretPointTypes)), ",")
+	var args []any
+	for _, t := range data.SecretPointTypes {
+		args = append(args, t)
+	}
+
+	rows, err := sdb.db.Query(fmt.Sprintf(
+		"SELECT id, text FROM node_points WHERE type IN (%v) AND text != ''", types), args...)
+	if err != nil {
+		return err
+	}
+
+	plain := make(map[string]string)
+	for rows.Next() {
+		var id, text string
+		if err := rows.Scan(&id, &text); err != nil {
+			rows.Close()
+			return err
+		}
+		if !strings.HasPrefix(text, secretPrefix) {
+			plain[id] = text
+		}
+	}
+	rows.Close()
+
+	if len(plain) < 1 {
+		return nil
+	}
+
+	tx, err := sdb.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	for id, text := range plain {
+		_, err := tx.Exec("UPDATE node_points SET text=? WHERE id=?", sdb.sealSecret(text), id)
+		if err != nil {
+			if rbErr := tx.Rollback(); rbErr != nil {
+				log.Println("Rollback error: ", rbErr)
+			}
+			return err
+		}
+	}
+
+	log.Printf("Encrypted %v secret points\n", len(plain))
+
+	return tx.Commit()
+}
+
+// sealSecret encrypts the text of a secret point. Text is returned unchanged
+// if no secrets key is configured.
+func (sdb *DbSqlite) sealSecret(text string) string {
+	if sdb.secrets == nil || text == "" {
+		return text
+	}
+
+	nonce := make([]byte, sdb.secrets.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		// should never happen, but never store a secret with a bad nonce
+		panic(err)
+	}
+
+	return secretPrefix + base64.StdEncoding.EncodeToString(
+		sdb.secrets.Seal(nonce, nonce, []byte(text), nil))
+}
+
+// openSecret decrypts the text of a secret point. Text that is not encrypted
+// is returned unchanged.
+func (sdb *DbSqlite) openSecret(text string) (string, error) {
+	if !strings.HasPrefix(text, secretPrefix) {
+		return text, nil
+	}
+
+	if sdb.secrets == nil {
+		return "", errors.New("secret point is encrypted, but no secrets key is configured")
+	}
+
+	d, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(text, secretPrefix))
+	if err != nil {
+		return "", err
+	}
+
+	n := sdb.secrets.NonceSize()
+	if len(d) < n {
+		return "", errors.New("encrypted secret is too short")
+	}
+
+	plain, err := sdb.secrets.Open(nil, d[:n], d[n:], nil)
+	if err != nil {
+		return "", fmt.Errorf("Error decrypting secret, wrong secrets key? %v", err)
+	}
+
+	return string(plain), nil
+}
+
+// openPoint decrypts the text of p if it is a secret point. Secrets that
+// can't be decrypted are returned empty.
+func (sdb *DbSqlite) openPoint(nodeID string, p *data.Point) {
+	if !p.IsSecret() {
+		return
+	}
+
+	text, err := sdb.openSecret(p.Text)
+	if err != nil {
+		log.Printf("Error reading secret point %v of node %v: %v\n", p.Type, nodeID, err)
+	}
+	p.Text = text
+}
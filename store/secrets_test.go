@@ -0,0 +1,110 @@
+package store
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/simpleiot/simpleiot/data"
+)
+
+func TestDbSqliteSecrets(t *testing.T) {
+	db := newTestDb(t)
+
+	// secrets written before a key is configured are encrypted when the
+	// key is set
+	err := db.nodePoints("n1", data.Points{
+		{Type: data.PointTypeNodeType, Text: data.NodeTypeUser},
+		{Type: data.PointTypePass, Text: "old"},
+		{Type: data.PointTypeDescription, Text: "user"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key := bytes.Repeat([]byte{1}, SecretsKeySize)
+	err = db.setSecretsKey(key, false)
+	if err != nil {
+		t.Fatal("Error setting secrets key: ", err)
+	}
+
+	storedText := func(typ string) string {
+		var text string
+		err := db.db.QueryRow("SELECT text FROM node_points WHERE node_id=? AND type=?",
+			"n1", typ).Scan(&text)
+		if err != nil {
+			t.Fatal("Error reading point: ", err)
+		}
+		return text
+	}
+
+	if s := storedText(data.PointTypePass); !strings.HasPrefix(s, secretPrefix) {
+		t.Fatal("existing secret not encrypted: ", s)
+	}
+
+	err = db.nodePoints("n1", data.Points{{Type: data.PointTypePass, Text: "secret",
+		Origin: "user"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// masked secrets are ignored
+	err = db.nodePoints("n1", data.Points{{Type: data.PointTypePass, Text: data.SecretMask}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s := storedText(data.PointTypePass); strings.Contains(s, "secret") {
+		t.Fatal("secret stored as plain text: ", s)
+	}
+
+	if s := storedText(data.PointTypeDescription); s != "user" {
+		t.Fatal("point that is not secret was changed: ", s)
+	}
+
+	n, err := db.node("n1")
+	if err != nil {
+		t.Fatal("Error getting node: ", err)
+	}
+
+	if pass, _ := n.Points.Text(data.PointTypePass, ""); pass != "secret" {
+		t.Fatal("wrong secret: ", pass)
+	}
+
+	changes, err := db.changes("n1", data.PointTypePass, "", 0)
+	if err != nil {
+		t.Fatal("Error getting changes: ", err)
+	}
+
+	if len(changes) != 1 {
+		t.Fatal("expected 1 change, got: ", len(changes))
+	}
+
+	for _, c := range changes {
+		if c.Text != data.SecretMask || c.OldText != data.SecretMask {
+			t.Fatal("change history contains secret: ", c)
+		}
+	}
+
+	db.Close()
+
+	// without the key, secrets can't be read
+	db, err = NewSqliteDb(testFile)
+	if err != nil {
+		t.Fatal("Error opening db: ", err)
+	}
+	defer db.Close()
+
+	n, err = db.node("n1")
+	if err != nil {
+		t.Fatal("Error getting node: ", err)
+	}
+
+	if pass, _ := n.Points.Text(data.PointTypePass, ""); pass != "" {
+		t.Fatal("secret read without key: ", pass)
+	}
+
+	if db.setSecretsKey(key[1:], false) == nil {
+		t.Fatal("expected error for short key")
+	}
+}
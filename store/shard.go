@@ -0,0 +1,241 @@
+package store
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/simpleiot/simpleiot/client"
+	"github.com/simpleiot/simpleiot/data"
+)
+
+// shardRequestTimeout is how long the coordinator waits for a shard to
+// respond to a children request
+var shardRequestTimeout = 10 * time.Second
+
+// shardRouter tracks which shard owns each node that does not live in the
+// coordinator store
+type shardRouter struct {
+	lock   sync.RWMutex
+	shards []string
+	routes map[string]string
+}
+
+func newShardRouter(shards []string, routes map[string]string) *shardRouter {
+	return &shardRouter{shards: shards, routes: routes}
+}
+
+// shard returns the shard that owns a node, or "" if the node is local
+func (sr *shardRouter) shard(nodeID string) string {
+	sr.lock.RLock()
+	defer sr.lock.RUnlock()
+	return sr.routes[nodeID]
+}
+
+func (sr *shardRouter) set(nodeID, shard string) {
+	sr.lock.Lock()
+	defer sr.lock.Unlock()
+	sr.routes[nodeID] = shard
+}
+
+func (sr *shardRouter) valid(shard string) bool {
+	for _, s := range sr.shards {
+		if s == shard {
+			return true
+		}
+	}
+	return false
+}
+
+// shardRoutes returns the shard of all nodes that have been placed in a shard
+func (sdb *DbSqlite) shardRoutes() (map[string]string, error) {
+	ret := make(map[string]string)
+
+	rows, err := sdb.db.Query(`SELECT node_id, shard FROM shard_nodes`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var nodeID, shard string
+		if err := rows.Scan(&nodeID, &shard); err != nil {
+			return nil, err
+		}
+		ret[nodeID] = shard
+	}
+
+	return ret, rows.Err()
+}
+
+func (sdb *DbSqlite) setShardRoute(nodeID, shard string) error {
+	_, err := sdb.db.Exec(`INSERT INTO shard_nodes(node_id, shard) VALUES(?, ?)
+		ON CONFLICT(node_id) DO UPDATE SET shard=?`, nodeID, shard, shard)
+	return err
+}
+
+// subscribe subscribes a store handler to a subject. A shard store serves
+// the subject under its shard prefix, and a coordinator store forwards
+// requests for nodes that live in shards.
+func (st *Store) subscribe(subject string, h nats.MsgHandler) (*nats.Subscription, error) {
+	return st.subscribeHandler(subject, h, true)
+}
+
+// subscribeQueued subscribes a handler that queues messages for a worker.
+// The watchdog times the worker instead of the handler, which only queues.
+func (st *Store) subscribeQueued(subject string, h nats.MsgHandler) (*nats.Subscription, error) {
+	return st.subscribeHandler(subject, h, false)
+}
+
+func (st *Store) subscribeHandler(subject string, h nats.MsgHandler, timed bool) (*nats.Subscription, error) {
+	if st.chaos != nil {
+		h = st.chaos.wrap(h)
+	}
+
+	if timed {
+		h = st.watchdog.wrap(subject, h)
+	}
+
+	if st.shard != "" {
+		prefix := client.SubjectShard(st.shard, "")
+		return st.nc.Subscribe(prefix+subject, func(msg *nats.Msg) {
+			// handlers parse node IDs from the subject
+			msg.Subject = strings.TrimPrefix(msg.Subject, prefix)
+			h(msg)
+		})
+	}
+
+	if st.router != nil {
+		h = st.route(h)
+	}
+
+	return st.nc.Subscribe(subject, h)
+}
+
+// subject returns the subject used to send a request to this store
+func (st *Store) subject(subject string) string {
+	if st.shard != "" {
+		return client.SubjectShard(st.shard, subject)
+	}
+	return subject
+}
+
+// route forwards node requests to the shard that owns the node. New nodes
+// are placed in the shard of their parent, or in the shard named by a shard
+// edge point.
+func (st *Store) route(h nats.MsgHandler) nats.MsgHandler {
+	return func(msg *nats.Msg) {
+		chunks := strings.Split(msg.Subject, ".")
+		if len(chunks) < 2 || (chunks[0] != "node" && chunks[0] != "history") {
+			h(msg)
+			return
+		}
+
+		shard := st.router.shard(chunks[1])
+
+		if shard == "" && chunks[0] == "node" && ((len(chunks) == 4 && chunks[3] == "points") ||
+			(len(chunks) == 3 && chunks[2] == "create")) {
+			shard = st.placeNode(msg)
+		}
+
+		if shard == "" {
+			h(msg)
+			return
+		}
+
+		st.forward(shard, msg)
+	}
+}
+
+// placeNode decides if a node created by an edge points or create message
+// belongs in a shard. Nodes that already exist in the coordinator are not
+// moved.
+func (st *Store) placeNode(msg *nats.Msg) string {
+	var nodeID, parentID string
+	var points data.Points
+
+	if strings.HasSuffix(msg.Subject, ".create") {
+		ne, err := data.PbDecodeNode(msg.Data)
+		if err != nil {
+			return ""
+		}
+		nodeID, parentID, points = ne.ID, ne.Parent, ne.EdgePoints
+	} else {
+		var err error
+		nodeID, parentID, points, err = client.DecodeEdgePointsMsg(msg)
+		if err != nil {
+			return ""
+		}
+	}
+
+	shard := st.router.shard(parentID)
+	if shard == "" {
+		for _, p := range points {
+			if p.Type == data.PointTypeShard && st.router.valid(p.Text) {
+				shard = p.Text
+			}
+		}
+	}
+
+	if shard == "" {
+		return ""
+	}
+
+	ups, err := st.db.up(nodeID, true)
+	if err != nil || len(ups) > 0 {
+		log.Printf("Store: not placing existing node %v in shard %v\n", nodeID, shard)
+		return ""
+	}
+
+	if err := st.db.setShardRoute(nodeID, shard); err != nil {
+		log.Println("Store: error saving shard route: ", err)
+		return ""
+	}
+
+	st.router.set(nodeID, shard)
+
+	return shard
+}
+
+// forward sends a request to a shard. The shard replies directly to the
+// requester, so the coordinator does not wait for the shard and a slow shard
+// does not hold up requests for other nodes.
+func (st *Store) forward(shard string, msg *nats.Msg) {
+	fwd := &nats.Msg{
+		Subject: client.SubjectShard(shard, msg.Subject),
+		Reply:   msg.Reply,
+		Data:    msg.Data,
+		Header:  msg.Header,
+	}
+
+	if err := st.nc.PublishMsg(fwd); err != nil {
+		log.Printf("Store: error forwarding %v to shard %v: %v\n", msg.Subject, shard, err)
+		st.reply(msg.Reply, fmt.Errorf("shard %v: %w", shard, err))
+	}
+}
+
+// shardChildren returns the children of a coordinator node that live in
+// shards
+func (st *Store) shardChildren(nodeID string, req []byte) (data.Nodes, error) {
+	var ret data.Nodes
+
+	for _, shard := range st.router.shards {
+		subject := client.SubjectShard(shard, fmt.Sprintf("node.%v.children", nodeID))
+		resp, err := st.nc.Request(subject, req, shardRequestTimeout)
+		if err != nil {
+			return nil, fmt.Errorf("shard %v: %w", shard, err)
+		}
+
+		nodes, err := data.PbDecodeNodesRequest(resp.Data)
+		if err != nil {
+			return nil, fmt.Errorf("shard %v: %w", shard, err)
+		}
+
+		ret = append(ret, nodes...)
+	}
+
+	return ret, nil
+}
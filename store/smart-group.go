@@ -0,0 +1,391 @@
+package store
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/simpleiot/simpleiot/client"
+	"github.com/simpleiot/simpleiot/data"
+)
+
+// smartGroupFilter is one condition of a smart group query. If op is empty,
+// the filter matches nodes that have a non-zero/non-empty point of the type.
+type smartGroupFilter struct {
+	pointType string
+	key       string
+	op        string
+	value     string
+}
+
+var smartGroupOps = []string{"!=", "<=", ">=", "=", "<", ">"}
+
+// parseSmartGroupQuery parses a smart group query. A query is a comma
+// separated list of filters that must all match, for example:
+//
+//	type=device, versionApp<1.4, site.key=north
+//
+// The field is a point type (with an optional .key), or type for the node
+// type.
+func parseSmartGroupQuery(q string) ([]smartGroupFilter, error) {
+	var ret []smartGroupFilter
+
+	for _, f := range strings.Split(q, ",") {
+		f = strings.TrimSpace(f)
+		if f == "" {
+			continue
+		}
+
+		var filter smartGroupFilter
+		field := f
+
+		if i := strings.IndexAny(f, "!<>="); i >= 0 {
+			field = f[:i]
+			rest := f[i:]
+			for _, op := range smartGroupOps {
+				if strings.HasPrefix(rest, op) {
+					filter.op = op
+					break
+				}
+			}
+
+			if filter.op == "" {
+				return nil, fmt.Errorf("invalid operator in filter: %v", f)
+			}
+
+			filter.value = strings.TrimSpace(rest[len(filter.op):])
+		}
+
+		field = strings.TrimSpace(field)
+		if field == "" {
+			return nil, fmt.Errorf("missing field in filter: %v", f)
+		}
+
+		if i := strings.Index(field, "."); i >= 0 {
+			filter.pointType = field[:i]
+			filter.key = field[i+1:]
+		} else {
+			filter.pointType = field
+		}
+
+		ret = append(ret, filter)
+	}
+
+	return ret, nil
+}
+
+// parseVersion parses a dotted numeric version like 1.4.2
+func parseVersion(s string) ([]int, bool) {
+	if !strings.Contains(s, ".") {
+		return nil, false
+	}
+
+	var ret []int
+	for _, seg := range strings.Split(strings.TrimPrefix(s, "v"), ".") {
+		v, err := strconv.Atoi(seg)
+		if err != nil {
+			return nil, false
+		}
+		ret = append(ret, v)
+	}
+
+	return ret, true
+}
+
+// compareVersions returns -1, 0, or 1. Missing segments are zero.
+func compareVersions(a, b []int) int {
+	for i := 0; i < len(a) || i < len(b); i++ {
+		var va, vb int
+		if i < len(a) {
+			va = a[i]
+		}
+		if i < len(b) {
+			vb = b[i]
+		}
+		if va < vb {
+			return -1
+		}
+		if va > vb {
+			return 1
+		}
+	}
+	return 0
+}
+
+// smartGroupCompare compares a point to a filter value. Text points are
+// compared as versions if both sides are dotted numbers, otherwise as
+// strings. Points without text are compared by value.
+func smartGroupCompare(p data.Point, value string) (int, bool) {
+	if p.Text != "" {
+		va, okA := parseVersion(p.Text)
+		vb, okB := parseVersion(value)
+		if okA && okB {
+			return compareVersions(va, vb), true
+		}
+		return strings.Compare(p.Text, value), true
+	}
+
+	v, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, false
+	}
+
+	switch {
+	case p.Value < v:
+		return -1, true
+	case p.Value > v:
+		return 1, true
+	default:
+		return 0, true
+	}
+}
+
+func (f smartGroupFilter) match(n data.Node) bool {
+	var p data.Point
+	if f.pointType == "type" {
+		p = data.Point{Text: n.Type}
+	} else {
+		var ok bool
+		p, ok = n.Points.Find(f.pointType, f.key)
+		if !ok {
+			return f.op == "!="
+		}
+	}
+
+	if f.op == "" {
+		return p.Value != 0 || p.Text != ""
+	}
+
+	c, ok := smartGroupCompare(p, f.value)
+	if !ok {
+		return false
+	}
+
+	switch f.op {
+	case "=":
+		return c == 0
+	case "!=":
+		return c != 0
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	case ">":
+		return c > 0
+	case ">=":
+		return c >= 0
+	}
+
+	return false
+}
+
+// smartGroupMatch returns true if a node matches all filters. An empty
+// query does not match any nodes.
+func smartGroupMatch(filters []smartGroupFilter, n data.Node) bool {
+	if len(filters) == 0 || n.Type == data.NodeTypeSmartGroup {
+		return false
+	}
+
+	for _, f := range filters {
+		if !f.match(n) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// smartGroups tracks the queries and members of all smart group nodes
+type smartGroups struct {
+	lock    sync.Mutex
+	queries map[string][]smartGroupFilter
+	members map[string]map[string]bool
+}
+
+func newSmartGroups() *smartGroups {
+	return &smartGroups{
+		queries: make(map[string][]smartGroupFilter),
+		members: make(map[string]map[string]bool),
+	}
+}
+
+// nodeDeleted returns true if a node has no live edges
+func (st *Store) nodeDeleted(id string) bool {
+	ups, err := st.db.up(id, false)
+	return err != nil || len(ups) == 0
+}
+
+// sendMembers sends member points for membership changes of a smart group.
+// Must be called with the smart group lock held.
+func (st *Store) sendMembers(groupID string, changes map[string]bool) {
+	if len(changes) == 0 {
+		return
+	}
+
+	members := st.smartGroups.members[groupID]
+	now := time.Now()
+	var pts data.Points
+
+	for id, member := range changes {
+		if member {
+			members[id] = true
+		} else {
+			delete(members, id)
+		}
+
+		p := data.Point{Time: now, Type: data.PointTypeMember, Key: id,
+			Value: data.BoolToFloat(member)}
+		if member {
+			n, err := st.db.node(id)
+			if err == nil {
+				p.Text = n.Desc()
+			}
+		}
+		pts = append(pts, p)
+	}
+
+	pts = append(pts, data.Point{Time: now, Type: data.PointTypeMemberCount,
+		Value: float64(len(members))})
+
+	err := client.SendNodePoints(st.nc, groupID, pts, false)
+	if err != nil {
+		log.Printf("Smart group %v: error sending members: %v\n", groupID, err)
+	}
+}
+
+// loadSmartGroup reads the query of a smart group node and updates the
+// membership for all nodes in the store
+func (st *Store) loadSmartGroup(groupID string) error {
+	sg := st.smartGroups
+	sg.lock.Lock()
+	defer sg.lock.Unlock()
+
+	group, err := st.db.node(groupID)
+	if err != nil {
+		return err
+	}
+
+	if _, ok := sg.members[groupID]; !ok {
+		// restore membership from member points so changes are not
+		// resent on every startup
+		members := make(map[string]bool)
+		for _, p := range group.Points {
+			if p.Type == data.PointTypeMember && p.Value != 0 {
+				members[p.Key] = true
+			}
+		}
+		sg.members[groupID] = members
+	}
+
+	var filters []smartGroupFilter
+	deleted := st.nodeDeleted(groupID)
+	if !deleted {
+		query, _ := group.Points.Text(data.PointTypeQuery, "")
+		filters, err = parseSmartGroupQuery(query)
+		if err != nil {
+			log.Printf("Smart group %v: %v\n", group.Desc(), err)
+		}
+	}
+
+	if len(filters) > 0 {
+		sg.queries[groupID] = filters
+	} else {
+		delete(sg.queries, groupID)
+	}
+
+	changes := make(map[string]bool)
+	members := sg.members[groupID]
+
+	if len(filters) > 0 {
+		ids, err := st.db.nodeIDs("")
+		if err != nil {
+			return err
+		}
+
+		for _, id := range ids {
+			n, err := st.db.node(id)
+			if err != nil {
+				continue
+			}
+
+			match := smartGroupMatch(filters, *n) && !st.nodeDeleted(id)
+			if match != members[id] {
+				changes[id] = match
+			}
+		}
+	} else {
+		for id := range members {
+			changes[id] = false
+		}
+	}
+
+	st.sendMembers(groupID, changes)
+
+	if deleted {
+		delete(sg.members, groupID)
+	}
+
+	return nil
+}
+
+// loadSmartGroups loads all smart group nodes from the store
+func (st *Store) loadSmartGroups() error {
+	ids, err := st.db.nodeIDs(data.NodeTypeSmartGroup)
+	if err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		err := st.loadSmartGroup(id)
+		if err != nil {
+			log.Printf("Error loading smart group %v: %v\n", id, err)
+		}
+	}
+
+	return nil
+}
+
+// updateSmartGroups updates smart group membership after a node changes
+func (st *Store) updateSmartGroups(n *data.Node, points data.Points) {
+	if n.Type == data.NodeTypeSmartGroup {
+		for _, p := range points {
+			if p.Type == data.PointTypeQuery || p.Type == data.PointTypeTombstone {
+				err := st.loadSmartGroup(n.ID)
+				if err != nil {
+					log.Printf("Error loading smart group %v: %v\n", n.ID, err)
+				}
+				return
+			}
+		}
+		return
+	}
+
+	sg := st.smartGroups
+	sg.lock.Lock()
+	defer sg.lock.Unlock()
+
+	if len(sg.queries) == 0 {
+		return
+	}
+
+	deleted := false
+	checkedDeleted := false
+
+	for groupID, filters := range sg.queries {
+		match := smartGroupMatch(filters, *n)
+		if match {
+			if !checkedDeleted {
+				deleted = st.nodeDeleted(n.ID)
+				checkedDeleted = true
+			}
+			match = !deleted
+		}
+
+		if match != sg.members[groupID][n.ID] {
+			st.sendMembers(groupID, map[string]bool{n.ID: match})
+		}
+	}
+}
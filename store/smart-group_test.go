@@ -0,0 +1,89 @@
+package store
+
+import (
+	"testing"
+
+	"github.com/simpleiot/simpleiot/data"
+)
+
+func TestParseSmartGroupQuery(t *testing.T) {
+	filters, err := parseSmartGroupQuery("type=device, versionApp<1.4,site.a!=north, online")
+	if err != nil {
+		t.Fatal("parse error: ", err)
+	}
+
+	exp := []smartGroupFilter{
+		{pointType: "type", op: "=", value: "device"},
+		{pointType: "versionApp", op: "<", value: "1.4"},
+		{pointType: "site", key: "a", op: "!=", value: "north"},
+		{pointType: "online"},
+	}
+
+	if len(filters) != len(exp) {
+		t.Fatalf("expected %v filters, got %v", len(exp), len(filters))
+	}
+
+	for i := range exp {
+		if filters[i] != exp[i] {
+			t.Errorf("filter %v: expected %+v, got %+v", i, exp[i], filters[i])
+		}
+	}
+
+	for _, q := range []string{"=device", "type!device"} {
+		_, err := parseSmartGroupQuery(q)
+		if err == nil {
+			t.Errorf("expected error for query %v", q)
+		}
+	}
+}
+
+func TestSmartGroupMatch(t *testing.T) {
+	n := data.Node{
+		Type: data.NodeTypeDevice,
+		Points: data.Points{
+			{Type: data.PointTypeVersionApp, Text: "1.10.2"},
+			{Type: data.PointTypeValue, Value: 12},
+			{Type: "site", Text: "north"},
+			{Type: data.PointTypeTag, Key: "customer", Text: "acme", Value: 1},
+		},
+	}
+
+	tests := []struct {
+		query string
+		match bool
+	}{
+		{"type=device", true},
+		{"type=group", false},
+		{"type=device, versionApp<1.4", false},
+		{"type=device, versionApp>=1.4", true},
+		{"versionApp=1.10.2.0", true},
+		{"value>10, value<=12", true},
+		{"value!=12", false},
+		{"site=north", true},
+		{"site!=south", true},
+		{"region!=south", true},
+		{"region=south", false},
+		{"value", true},
+		{"region", false},
+		{"tag.customer=acme", true},
+		{"tag.customer", true},
+		{"tag.region", false},
+		{"", false},
+	}
+
+	for _, test := range tests {
+		filters, err := parseSmartGroupQuery(test.query)
+		if err != nil {
+			t.Fatal("parse error: ", err)
+		}
+
+		if smartGroupMatch(filters, n) != test.match {
+			t.Errorf("query %v: expected match %v", test.query, test.match)
+		}
+	}
+
+	filters, _ := parseSmartGroupQuery("type=smartGroup")
+	if smartGroupMatch(filters, data.Node{Type: data.NodeTypeSmartGroup}) {
+		t.Error("smart groups should not be members of smart groups")
+	}
+}
@@ -1,10 +1,13 @@
 package store
 
 import (
+	"crypto/cipher"
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
+	"os"
 	"time"
 
 	"github.com/google/uuid"
@@ -17,7 +20,10 @@ import (
 // DbSqlite represents a SQLite data store
 type DbSqlite struct {
 	db   *sql.DB
+	file string
 	meta Meta
+	// secrets encrypts secret points if a secrets key is configured
+	secrets cipher.AEAD
 }
 
 // Meta contains metadata about the database
@@ -29,36 +35,99 @@ type Meta struct {
 
 // NewSqliteDb creates a new Sqlite data store
 func NewSqliteDb(dbFile string) (*DbSqlite, error) {
-	ret := &DbSqlite{}
+	// Write transactions read the current points before writing. Deferred
+	// transactions that upgrade from a read to a write lock fail with
+	// SQLITE_BUSY without waiting for busy_timeout when another connection
+	// has written in between, so take the write lock when the transaction
+	// begins.
+	pragmas := "_pragma=foreign_keys(1)&_pragma=journal_mode(WAL)&_pragma=synchronous(NORMAL)&_pragma=busy_timeout(8000)&_pragma=journal_size_limit(100000000)&_txlock=immediate"
+
+	return openSqliteDb(dbFile, fmt.Sprintf("%s?%s", dbFile, pragmas), false)
+}
 
-	pragmas := "_pragma=foreign_keys(1)&_pragma=journal_mode(WAL)&_pragma=synchronous(NORMAL)&_pragma=busy_timeout(8000)&_pragma=journal_size_limit(100000000)"
+// NewSqliteDbReadOnly opens an existing Sqlite data store read-only, for
+// instance a replicated copy of a store or a backup. The store is not
+// modified, so it must have been initialized by NewSqliteDb.
+func NewSqliteDbReadOnly(dbFile string) (*DbSqlite, error) {
+	if _, err := os.Stat(dbFile); err != nil {
+		return nil, err
+	}
 
-	dbFileOptions := fmt.Sprintf("%s?%s", dbFile, pragmas)
+	pragmas := "mode=ro&_pragma=query_only(1)&_pragma=busy_timeout(8000)"
 
-	db, err := sql.Open("sqlite", dbFileOptions)
+	return openSqliteDb(dbFile, fmt.Sprintf("file:%s?%s", dbFile, pragmas), true)
+}
+
+func openSqliteDb(dbFile, dsn string, readOnly bool) (*DbSqlite, error) {
+	ret := &DbSqlite{file: dbFile}
+
+	db, err := sql.Open("sqlite", dsn)
 	if err != nil {
 		return nil, err
 	}
 
 	ret.db = db
 
-	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS meta (id INT NOT NULL PRIMARY KEY,
+	if !readOnly {
+		if err := ret.initTables(); err != nil {
+			return nil, err
+		}
+	}
+
+	metaRows, err := db.Query("SELECT * from meta")
+	if err != nil {
+		return nil, fmt.Errorf("Error quering meta: %v", err)
+	}
+	defer metaRows.Close()
+
+	for metaRows.Next() {
+		err = metaRows.Scan(&ret.meta.ID, &ret.meta.Version, &ret.meta.RootID)
+		if err != nil {
+			return nil, fmt.Errorf("Error scanning meta row: %v", err)
+		}
+	}
+
+	if ret.meta.RootID == "" && readOnly {
+		return nil, errors.New("read-only store is not initialized")
+	}
+
+	if ret.meta.RootID == "" {
+		// we need to initialize root node and user
+		ret.meta.RootID, err = ret.initRoot()
+		if err != nil {
+			return nil, fmt.Errorf("Error initializing root node: %v", err)
+		}
+	}
+
+	// make sure we find root ID
+	_, err = ret.node(ret.meta.RootID)
+	if err != nil {
+		return nil, fmt.Errorf("db constructor can't fetch root node: %v", err)
+	}
+
+	return ret, nil
+}
+
+// initTables creates the store tables if they don't exist and migrates
+// tables created by older versions
+func (sdb *DbSqlite) initTables() error {
+	_, err := sdb.db.Exec(`CREATE TABLE IF NOT EXISTS meta (id INT NOT NULL PRIMARY KEY,
 				version INT,
 				root_id TEXT)`)
 	if err != nil {
-		return nil, fmt.Errorf("Error creating meta table: %v", err)
+		return fmt.Errorf("Error creating meta table: %v", err)
 	}
 
-	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS edges (id TEXT NOT NULL PRIMARY KEY,
+	_, err = sdb.db.Exec(`CREATE TABLE IF NOT EXISTS edges (id TEXT NOT NULL PRIMARY KEY,
 				up TEXT,
 				down TEXT,
 				hash INT)`)
 
 	if err != nil {
-		return nil, fmt.Errorf("Error creating edges table: %v", err)
+		return fmt.Errorf("Error creating edges table: %v", err)
 	}
 
-	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS node_points (id TEXT NOT NULL PRIMARY KEY,
+	_, err = sdb.db.Exec(`CREATE TABLE IF NOT EXISTS node_points (id TEXT NOT NULL PRIMARY KEY,
 				node_id TEXT,
 				type TEXT,
 				key TEXT,
@@ -69,13 +138,15 @@ func NewSqliteDb(dbFile string) (*DbSqlite, error) {
 				text TEXT,
 				data BLOB,
 				tombstone INT,
-				origin TEXT)`)
+				origin TEXT,
+				meta TEXT DEFAULT '',
+				quality TEXT DEFAULT '')`)
 
 	if err != nil {
-		return nil, fmt.Errorf("Error creating node_points table: %v", err)
+		return fmt.Errorf("Error creating node_points table: %v", err)
 	}
 
-	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS edge_points (id TEXT NOT NULL PRIMARY KEY,
+	_, err = sdb.db.Exec(`CREATE TABLE IF NOT EXISTS edge_points (id TEXT NOT NULL PRIMARY KEY,
 				edge_id TEXT,
 				type TEXT,
 				key TEXT,
@@ -86,40 +157,63 @@ func NewSqliteDb(dbFile string) (*DbSqlite, error) {
 				text TEXT,
 				data BLOB,
 				tombstone INT,
-				origin TEXT)`)
+				origin TEXT,
+				meta TEXT DEFAULT '',
+				quality TEXT DEFAULT '')`)
 
 	if err != nil {
-		return nil, fmt.Errorf("Error creating edge_points table: %v", err)
+		return fmt.Errorf("Error creating edge_points table: %v", err)
 	}
 
-	metaRows, err := db.Query("SELECT * from meta")
+	_, err = sdb.db.Exec(`CREATE TABLE IF NOT EXISTS point_changes (node_id TEXT,
+				type TEXT,
+				key TEXT,
+				time_s INT,
+				time_ns INT,
+				old_value REAL,
+				old_text TEXT,
+				value REAL,
+				text TEXT,
+				tombstone INT,
+				origin TEXT)`)
+
 	if err != nil {
-		return nil, fmt.Errorf("Error quering meta: %v", err)
+		return fmt.Errorf("Error creating point_changes table: %v", err)
 	}
-	defer metaRows.Close()
 
-	for metaRows.Next() {
-		err = metaRows.Scan(&ret.meta.ID, &ret.meta.Version, &ret.meta.RootID)
-		if err != nil {
-			return nil, fmt.Errorf("Error scanning meta row: %v", err)
-		}
+	_, err = sdb.db.Exec(`CREATE INDEX IF NOT EXISTS point_changes_node ON point_changes(node_id)`)
+	if err != nil {
+		return fmt.Errorf("Error creating point_changes index: %v", err)
 	}
 
-	if ret.meta.RootID == "" {
-		// we need to initialize root node and user
-		ret.meta.RootID, err = ret.initRoot()
-		if err != nil {
-			return nil, fmt.Errorf("Error initializing root node: %v", err)
-		}
+	_, err = sdb.db.Exec(`CREATE TABLE IF NOT EXISTS quarantine (id INTEGER PRIMARY KEY AUTOINCREMENT,
+				node_id TEXT,
+				time_s INT,
+				time_ns INT,
+				reason TEXT,
+				point TEXT)`)
+
+	if err != nil {
+		return fmt.Errorf("Error creating quarantine table: %v", err)
 	}
 
-	// make sure we find root ID
-	_, err = ret.node(ret.meta.RootID)
+	_, err = sdb.db.Exec(`CREATE TABLE IF NOT EXISTS shard_nodes (node_id TEXT NOT NULL PRIMARY KEY,
+				shard TEXT)`)
+
 	if err != nil {
-		return nil, fmt.Errorf("db constructor can't fetch root node: %v", err)
+		return fmt.Errorf("Error creating shard_nodes table: %v", err)
 	}
 
-	return ret, nil
+	for _, table := range []string{"node_points", "edge_points"} {
+		for _, column := range []string{"meta", "quality"} {
+			err := addColumn(sdb.db, table, column, "TEXT DEFAULT ''")
+			if err != nil {
+				return fmt.Errorf("Error migrating %v: %v", table, err)
+			}
+		}
+	}
+
+	return nil
 }
 
 func (sdb *DbSqlite) initRoot() (string, error) {
@@ -175,7 +269,23 @@ func (sdb *DbSqlite) initRoot() (string, error) {
 }
 
 func (sdb *DbSqlite) nodePoints(id string, points data.Points) error {
-	rowsPoints, err := sdb.db.Query("SELECT * FROM node_points WHERE node_id=?", id)
+	return sdb.nodePointsVersion(id, "", points)
+}
+
+// nodePointsVersion writes node points if the node points are at version
+// (see data.Points.Version), and returns data.ErrVersionMismatch otherwise.
+// If version is "", the points are always written.
+func (sdb *DbSqlite) nodePointsVersion(id, version string, points data.Points) error {
+	return sdb.tx(func(tx *sql.Tx) error {
+		return sdb.nodePointsTx(tx, id, version, points)
+	})
+}
+
+// nodePointsTx writes node points as part of a transaction. The version is
+// checked against the points read in the transaction, so a concurrent write
+// cannot be missed.
+func (sdb *DbSqlite) nodePointsTx(tx *sql.Tx, id, version string, points data.Points) error {
+	rowsPoints, err := tx.Query("SELECT * FROM node_points WHERE node_id=?", id)
 	if err != nil {
 		return err
 	}
@@ -189,21 +299,50 @@ func (sdb *DbSqlite) nodePoints(id string, points data.Points) error {
 		var timeS, timeNS int64
 		var pID string
 		var nodeID string
+		var meta string
 		err := rowsPoints.Scan(&pID, &nodeID, &p.Type, &p.Key, &timeS, &timeNS, &p.Index, &p.Value, &p.Text,
-			&p.Data, &p.Tombstone, &p.Origin)
+			&p.Data, &p.Tombstone, &p.Origin, &meta, &p.Quality)
 		if err != nil {
 			return err
 		}
 		p.Time = time.Unix(timeS, timeNS)
+		p.Meta = decodeMeta(meta)
+		sdb.openPoint(id, &p)
 		dbPoints = append(dbPoints, p)
 		dbPointIDs = append(dbPointIDs, pID)
 	}
 
+	if version != "" {
+		if len(dbPoints) == 0 {
+			return data.ErrDocumentNotFound
+		}
+
+		// the node type is not returned in the node points, so it is not
+		// part of the version clients see
+		var nodePoints data.Points
+		for _, p := range dbPoints {
+			if p.Type != data.PointTypeNodeType {
+				nodePoints = append(nodePoints, p)
+			}
+		}
+
+		if nodePoints.Version() != version {
+			return data.ErrVersionMismatch
+		}
+	}
+
 	var writePoints data.Points
 	var writePointIDs []string
+	var changes []data.PointChange
 
 NextPin:
 	for _, pIn := range points {
+		if pIn.IsSecret() && pIn.Text == data.SecretMask {
+			// secrets are write only, so a masked secret is sent back
+			// when a client writes a node it received from the API
+			continue
+		}
+
 		if pIn.Time.IsZero() {
 			pIn.Time = time.Now()
 		}
@@ -211,9 +350,13 @@ NextPin:
 		for j, pDb := range dbPoints {
 			if pIn.Type == pDb.Type && pIn.Key == pDb.Key {
 				// found a match
-				if pDb.Time.Before(pIn.Time) || pDb.Time.Equal(pIn.Time) {
+				if pIn.Replaces(pDb) {
 					writePoints = append(writePoints, pIn)
 					writePointIDs = append(writePointIDs, dbPointIDs[j])
+					if pIn.Origin != "" && (pIn.Value != pDb.Value ||
+						pIn.Text != pDb.Text || pIn.Tombstone != pDb.Tombstone) {
+						changes = append(changes, newPointChange(id, pDb, pIn))
+					}
 				} else {
 					log.Println("Ignoring point due to timestamps: ", id, pIn)
 				}
@@ -224,16 +367,15 @@ NextPin:
 		// point was not found so write it
 		writePoints = append(writePoints, pIn)
 		writePointIDs = append(writePointIDs, uuid.New().String())
+		if pIn.Origin != "" {
+			changes = append(changes, newPointChange(id, data.Point{}, pIn))
+		}
 	}
 
 	// loop through write points and write them
-	tx, err := sdb.db.Begin()
-	if err != nil {
-		return err
-	}
 	stmt, err := tx.Prepare(`INSERT INTO node_points(id, node_id, type, key, time_s,
-                 time_ns, idx, value, text, data, tombstone, origin)
-		 VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+                 time_ns, idx, value, text, data, tombstone, origin, meta, quality)
+		 VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 		 ON CONFLICT(id) DO UPDATE SET
 		 type = ?3,
 		 key = ?4,
@@ -244,39 +386,50 @@ NextPin:
 		 text = ?9,
 		 data = ?10,
 		 tombstone = ?11,
-		 origin = ?12
+		 origin = ?12,
+		 meta = ?13,
+		 quality = ?14
 		 `)
+	if err != nil {
+		return err
+	}
 	defer stmt.Close()
 
 	for i, p := range writePoints {
 		tS := p.Time.Unix()
 		tNs := p.Time.UnixNano() - 1e9*tS
 		pID := writePointIDs[i]
+		if p.IsSecret() {
+			p.Text = sdb.sealSecret(p.Text)
+		}
 		_, err = stmt.Exec(pID, id, p.Type, p.Key, tS, tNs, p.Index, p.Value, p.Text, p.Data, p.Tombstone,
-			p.Origin)
+			p.Origin, encodeMeta(p.Meta), p.Quality)
 		if err != nil {
-			rbErr := tx.Rollback()
-			if rbErr != nil {
-				log.Println("Rollback error: ", rbErr)
-			}
 			return err
 		}
 	}
 
-	err = tx.Commit()
-	if err != nil {
-		return err
+	if len(changes) > 0 {
+		return writeChanges(tx, id, changes)
 	}
 
 	return nil
 }
 
 func (sdb *DbSqlite) edgePoints(nodeID, parentID string, points data.Points) error {
+	return sdb.tx(func(tx *sql.Tx) error {
+		return sdb.edgePointsTx(tx, nodeID, parentID, points)
+	})
+}
+
+// edgePointsTx writes edge points as part of a transaction. The edge is
+// created if it does not exist.
+func (sdb *DbSqlite) edgePointsTx(tx *sql.Tx, nodeID, parentID string, points data.Points) error {
 	if parentID == "" {
 		parentID = "none"
 	}
 
-	rowsEdge, err := sdb.db.Query("SELECT * FROM edges WHERE up=? AND down=?", parentID, nodeID)
+	rowsEdge, err := tx.Query("SELECT * FROM edges WHERE up=? AND down=?", parentID, nodeID)
 	if err != nil {
 		return err
 	}
@@ -297,7 +450,7 @@ func (sdb *DbSqlite) edgePoints(nodeID, parentID string, points data.Points) err
 		edge.Down = nodeID
 
 		// did not find edge, need to add it
-		_, err := sdb.db.Exec(`INSERT INTO edges(id, up, down, hash) VALUES (?, ?, ?, ?)`,
+		_, err := tx.Exec(`INSERT INTO edges(id, up, down, hash) VALUES (?, ?, ?, ?)`,
 			edge.ID, edge.Up, edge.Down, "")
 
 		if err != nil {
@@ -305,7 +458,7 @@ func (sdb *DbSqlite) edgePoints(nodeID, parentID string, points data.Points) err
 		}
 	}
 
-	rowsPoints, err := sdb.db.Query("SELECT * FROM edge_points WHERE edge_id=?", edge.ID)
+	rowsPoints, err := tx.Query("SELECT * FROM edge_points WHERE edge_id=?", edge.ID)
 	if err != nil {
 		return err
 	}
@@ -319,12 +472,14 @@ func (sdb *DbSqlite) edgePoints(nodeID, parentID string, points data.Points) err
 		var timeS, timeNS int64
 		var pID string
 		var nodeID string
+		var meta string
 		err := rowsPoints.Scan(&pID, &nodeID, &p.Type, &p.Key, &timeS, &timeNS, &p.Index, &p.Value, &p.Text,
-			&p.Data, &p.Tombstone, &p.Origin)
+			&p.Data, &p.Tombstone, &p.Origin, &meta, &p.Quality)
 		if err != nil {
 			return err
 		}
 		p.Time = time.Unix(timeS, timeNS)
+		p.Meta = decodeMeta(meta)
 		dbPoints = append(dbPoints, p)
 		dbPointIDs = append(dbPointIDs, pID)
 	}
@@ -341,7 +496,7 @@ NextPin:
 		for j, pDb := range dbPoints {
 			if pIn.Type == pDb.Type && pIn.Key == pDb.Key {
 				// found a match
-				if pIn.Time.After(pDb.Time) {
+				if pIn.Replaces(pDb) {
 					writePoints = append(writePoints, pIn)
 					writePointIDs = append(writePointIDs, dbPointIDs[j])
 				}
@@ -355,13 +510,9 @@ NextPin:
 	}
 
 	// loop through write points and write them
-	tx, err := sdb.db.Begin()
-	if err != nil {
-		return err
-	}
 	stmt, err := tx.Prepare(`INSERT INTO edge_points(id, edge_id, type, key, time_s,
-                 time_ns, idx, value, text, data, tombstone, origin)
-		 VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+                 time_ns, idx, value, text, data, tombstone, origin, meta, quality)
+		 VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 		 ON CONFLICT(id) DO UPDATE SET
 		 type = ?3,
 		 key = ?4,
@@ -372,8 +523,13 @@ NextPin:
 		 text = ?9,
 		 data = ?10,
 		 tombstone = ?11,
-		 origin = ?12
+		 origin = ?12,
+		 meta = ?13,
+		 quality = ?14
 		 `)
+	if err != nil {
+		return err
+	}
 	defer stmt.Close()
 
 	for i, p := range writePoints {
@@ -381,23 +537,53 @@ NextPin:
 		tNs := p.Time.UnixNano() - 1e9*tS
 		pID := writePointIDs[i]
 		_, err = stmt.Exec(pID, edge.ID, p.Type, p.Key, tS, tNs, p.Index, p.Value, p.Text, p.Data, p.Tombstone,
-			p.Origin)
+			p.Origin, encodeMeta(p.Meta), p.Quality)
 		if err != nil {
-			rbErr := tx.Rollback()
-			if rbErr != nil {
-				log.Println("Rollback error: ", rbErr)
+			return err
+		}
+	}
+
+	return nil
+}
+
+// createNode writes the edge points and node points of a node in one
+// transaction, so other requests see all of the node or none of it
+func (sdb *DbSqlite) createNode(node data.NodeEdge) error {
+	return sdb.tx(func(tx *sql.Tx) error {
+		if node.Parent != "" && node.Parent != "none" {
+			err := sdb.edgePointsTx(tx, node.ID, node.Parent, node.EdgePoints)
+			if err != nil {
+				return fmt.Errorf("Error writing edge points: %w", err)
 			}
+		}
 
-			return err
+		err := sdb.nodePointsTx(tx, node.ID, "", node.Points)
+		if err != nil {
+			return fmt.Errorf("Error writing node points: %w", err)
 		}
+
+		return nil
+	})
+}
+
+// tx runs f in a transaction. The transaction is committed if f succeeds and
+// rolled back otherwise.
+func (sdb *DbSqlite) tx(f func(tx *sql.Tx) error) error {
+	tx, err := sdb.db.Begin()
+	if err != nil {
+		return err
 	}
 
-	err = tx.Commit()
+	err = f(tx)
 	if err != nil {
+		rbErr := tx.Rollback()
+		if rbErr != nil {
+			log.Println("Rollback error: ", rbErr)
+		}
 		return err
 	}
 
-	return nil
+	return tx.Commit()
 }
 
 // Close the db
@@ -423,12 +609,13 @@ func (sdb *DbSqlite) node(id string) (*data.Node, error) {
 	}
 
 	if ret.Type == "" {
-		return nil, errors.New("node not found")
+		return nil, data.ErrDocumentNotFound
 	}
 
 	return &ret, err
 }
 
+// children returns the child nodes of id ordered by edge weight
 func (sdb *DbSqlite) children(id, typ string, includeDel bool) ([]data.NodeEdge, error) {
 	var ret []data.NodeEdge
 
@@ -480,6 +667,8 @@ func (sdb *DbSqlite) children(id, typ string, includeDel bool) ([]data.NodeEdge,
 		ret = append(ret, ne)
 	}
 
+	data.Nodes(ret).Sort()
+
 	return ret, nil
 }
 
@@ -549,7 +738,7 @@ func (sdb *DbSqlite) nodeEdge(id, parent string) ([]data.NodeEdge, error) {
 	}
 
 	if len(ret) < 1 {
-		return ret, fmt.Errorf("Node not found")
+		return ret, data.ErrDocumentNotFound
 	}
 
 	return ret, nil
@@ -570,12 +759,15 @@ func (sdb *DbSqlite) queryPoints(query string) (data.Points, string, error) {
 		var timeS, timeNS int64
 		var pID string
 		var nodeID string
+		var meta string
 		err := rowsPoints.Scan(&pID, &nodeID, &p.Type, &p.Key, &timeS, &timeNS, &p.Index, &p.Value, &p.Text,
-			&p.Data, &p.Tombstone, &p.Origin)
+			&p.Data, &p.Tombstone, &p.Origin, &meta, &p.Quality)
 		if err != nil {
 			return nil, "", err
 		}
 		p.Time = time.Unix(timeS, timeNS)
+		p.Meta = decodeMeta(meta)
+		sdb.openPoint(nodeID, &p)
 		if p.Type == data.PointTypeNodeType {
 			retType = p.Text
 		} else {
@@ -659,3 +851,112 @@ func (sdb *DbSqlite) up(id string, includeDeleted bool) ([]string, error) {
 
 	return ups, nil
 }
+
+// nodeIDs returns the IDs of all nodes in the store. If typ is set, only
+// nodes of that type are returned.
+func (sdb *DbSqlite) nodeIDs(typ string) ([]string, error) {
+	var ids []string
+
+	var rows *sql.Rows
+	var err error
+	if typ == "" {
+		rows, err = sdb.db.Query("SELECT DISTINCT node_id FROM node_points")
+	} else {
+		rows, err = sdb.db.Query("SELECT node_id FROM node_points WHERE type=? AND text=?",
+			data.PointTypeNodeType, typ)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Error querying node IDs: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id string
+		err := rows.Scan(&id)
+		if err != nil {
+			return nil, fmt.Errorf("Error scanning node ID: %v", err)
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, nil
+}
+
+// pointsOfType returns all node points of a type, indexed by node ID
+func (sdb *DbSqlite) pointsOfType(typ string) (map[string]data.Points, error) {
+	ret := make(map[string]data.Points)
+
+	rows, err := sdb.db.Query("SELECT node_id, key, value, text, tombstone FROM node_points WHERE type=?", typ)
+	if err != nil {
+		return nil, fmt.Errorf("Error querying points: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id string
+		p := data.Point{Type: typ}
+		err := rows.Scan(&id, &p.Key, &p.Value, &p.Text, &p.Tombstone)
+		if err != nil {
+			return nil, fmt.Errorf("Error scanning point: %v", err)
+		}
+		ret[id] = append(ret[id], p)
+	}
+
+	return ret, nil
+}
+
+// addColumn adds a column to a table if it does not already exist. This is
+// used to migrate stores created by older versions of SIOT.
+func addColumn(db *sql.DB, table, column, def string) error {
+	rows, err := db.Query(fmt.Sprintf("SELECT name FROM pragma_table_info('%v')", table))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+	}
+
+	rows.Close()
+
+	_, err = db.Exec(fmt.Sprintf("ALTER TABLE %v ADD COLUMN %v %v", table, column, def))
+	return err
+}
+
+// encodeMeta encodes point metadata for storage
+func encodeMeta(m map[string]string) string {
+	if len(m) == 0 {
+		return ""
+	}
+
+	d, err := json.Marshal(m)
+	if err != nil {
+		log.Println("Error encoding point meta: ", err)
+		return ""
+	}
+
+	return string(d)
+}
+
+// decodeMeta decodes stored point metadata
+func decodeMeta(s string) map[string]string {
+	if s == "" {
+		return nil
+	}
+
+	var ret map[string]string
+	err := json.Unmarshal([]byte(s), &ret)
+	if err != nil {
+		log.Println("Error decoding point meta: ", err)
+		return nil
+	}
+
+	return ret
+}
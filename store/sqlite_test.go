@@ -3,6 +3,8 @@ package store
 import (
 	"fmt"
 	"os/exec"
+	"strconv"
+	"sync"
 	"testing"
 	"time"
 
@@ -198,6 +200,39 @@ func TestDbSqliteReopen(t *testing.T) {
 	}
 }
 
+func TestDbSqlitePointMetaQuality(t *testing.T) {
+	db := newTestDb(t)
+	defer db.Close()
+
+	rootID := db.rootNodeID()
+
+	meta := map[string]string{"unit": "V"}
+
+	err := db.nodePoints(rootID, data.Points{{Time: time.Now(), Type: data.PointTypeValue,
+		Value: 12, Meta: meta, Quality: data.PointQualityStale}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rn, err := db.node(rootID)
+	if err != nil {
+		t.Fatal("Error getting root node: ", err)
+	}
+
+	p, ok := rn.Points.Find(data.PointTypeValue, "")
+	if !ok {
+		t.Fatal("value point not found")
+	}
+
+	if len(p.Meta) != 1 || p.Meta["unit"] != "V" {
+		t.Error("meta not stored, got: ", p.Meta)
+	}
+
+	if p.Quality != data.PointQualityStale {
+		t.Error("quality not stored, got: ", p.Quality)
+	}
+}
+
 func TestDbSqliteUserCheck(t *testing.T) {
 	db := newTestDb(t)
 	defer db.Close()
@@ -259,3 +294,107 @@ func TestDbSqliteUp(t *testing.T) {
 		t.Fatal("ups, wrong ID for root: ", ups[0])
 	}
 }
+
+func TestDbSqliteOrigin(t *testing.T) {
+	db := newTestDb(t)
+	defer db.Close()
+
+	err := db.nodePoints("n1", data.Points{
+		{Type: data.PointTypeNodeType, Text: data.NodeTypeVariable},
+		{Type: data.PointTypeValue, Value: 1},
+		{Type: data.PointTypeDescription, Text: "desc", Origin: "user"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := db.node("n1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// an empty origin means the point was generated by the node, which
+	// clients rely on, so it is not changed in the store
+	p, _ := n.Points.Find(data.PointTypeValue, "")
+	if p.Origin != "" {
+		t.Error("origin of node point was changed: ", p)
+	}
+
+	p, _ = n.Points.Find(data.PointTypeDescription, "")
+	if p.Origin != "user" {
+		t.Error("origin changed: ", p)
+	}
+}
+
+func TestDbSqliteReadOnly(t *testing.T) {
+	db := newTestDb(t)
+	rootID := db.rootNodeID()
+	err := db.nodePoints("n1", data.Points{
+		{Type: data.PointTypeNodeType, Text: data.NodeTypeVariable},
+		{Type: data.PointTypeValue, Value: 1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	db, err = NewSqliteDbReadOnly(testFile)
+	if err != nil {
+		t.Fatal("Error opening db read-only: ", err)
+	}
+	defer db.Close()
+
+	if rootID != db.rootNodeID() {
+		t.Fatal("Root node ID changed")
+	}
+
+	n, err := db.node("n1")
+	if err != nil {
+		t.Fatal("Error reading node: ", err)
+	}
+
+	if v, _ := n.Points.Value(data.PointTypeValue, ""); v != 1 {
+		t.Error("wrong value: ", v)
+	}
+
+	err = db.nodePoints("n1", data.Points{{Type: data.PointTypeValue, Value: 2}})
+	if err == nil {
+		t.Error("write to read-only db should fail")
+	}
+
+	_, err = NewSqliteDbReadOnly(testFile + ".missing")
+	if err == nil {
+		t.Error("opening a missing db read-only should fail")
+	}
+}
+
+func TestDbSqliteConcurrentWrites(t *testing.T) {
+	db := newTestDb(t)
+	defer db.Close()
+
+	rootID := db.rootNodeID()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 1000)
+
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				err := db.nodePoints(rootID, data.Points{{Type: data.PointTypeValue,
+					Key: strconv.Itoa(i), Value: float64(j), Time: time.Now()}})
+				if err != nil {
+					errs <- err
+				}
+			}
+		}(i)
+	}
+
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Fatal("Error writing points concurrently: ", err)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"log"
 	"strings"
 	"sync"
@@ -20,6 +21,9 @@ import (
 
 var reportMetricsPeriod = time.Minute
 
+// ErrReadOnly is returned for write requests to a read-only store
+var ErrReadOnly = errors.New("store is read-only")
+
 // NewTokener provides a new authentication token.
 type NewTokener interface {
 	NewToken(userID string) (string, error)
@@ -34,12 +38,34 @@ type Store struct {
 	authToken     string
 	lock          sync.Mutex
 	key           NewTokener
+	maxSize       int64
+	dedup         *dedup
+	recent        *recentCache
+	smartGroups   *smartGroups
+	tags          *tagIndex
+	filters       *filterSubs
+	readOnly      bool
+	shard         string
+	router        *shardRouter
+	push          *msg.WebPush
+	chaos         *chaos
+	pointStats    *pointStats
+	watchdog      *watchdog
+	events        *eventBus
+	msgIDs        *msgIDs
+	priority      *priorityQueue
+	clockSkew     *clockSkew
+
+	// repl is called with each message that changed the database after it
+	// is handled, when the store is the active instance of an HA pair
+	repl func(msg *nats.Msg)
 
 	// cycle metrics track how long it takes to handle a point
-	metricCycleNodePoint     *client.Metric
-	metricCycleNodeEdgePoint *client.Metric
-	metricCycleNode          *client.Metric
-	metricCycleNodeChildren  *client.Metric
+	metricCycleNodePoint        *client.Metric
+	metricCycleNodePointControl *client.Metric
+	metricCycleNodeEdgePoint    *client.Metric
+	metricCycleNode             *client.Metric
+	metricCycleNodeChildren     *client.Metric
 
 	// Pending counts how many points are being buffered by the NATS client
 	metricPendingNodePoint     *client.Metric
@@ -57,18 +83,97 @@ type Params struct {
 	Server    string
 	Key       NewTokener
 	Nc        *nats.Conn
+	// MaxSize is the store size limit in bytes. If the store grows larger
+	// than this, deleted data is pruned, oldest first. 0 disables the
+	// limit.
+	MaxSize int64
+	// DedupWindow enables dropping node points that are identical to a
+	// point received within this window. 0 disables dedup.
+	DedupWindow time.Duration
+	// RecentLen is the default number of recent values kept in memory for
+	// each node point. This can be overridden with the recentLen point on
+	// a node. 0 disables the cache for nodes that do not set recentLen.
+	RecentLen int
+	// ReadOnly opens an existing store read-only, for instance a replicated
+	// copy of the store or a backup. Node queries are served from the store
+	// and writes are rejected with ErrReadOnly.
+	ReadOnly bool
+	// Shard makes this store a shard of a coordinator store. Shards serve
+	// the store subjects prefixed with shard.<Shard>. and hold the subtrees
+	// the coordinator places in them.
+	Shard string
+	// Shards makes this store a coordinator for the listed shards. Requests
+	// for nodes in a shard are forwarded to the shard, and children queries
+	// are merged from all shards.
+	Shards []string
+	// SecretsKey encrypts secret points (see data.SecretPointTypes) in the
+	// store. It must be SecretsKeySize bytes. If not set, secrets are
+	// stored as plain text.
+	SecretsKey []byte
+	// Chaos injects faults into message handling for testing. Do not use
+	// in production.
+	Chaos Chaos
+	// SlowHandler is the time after which a message handler is counted and
+	// logged as slow. 0 disables slow handler detection.
+	SlowHandler time.Duration
+	// PendingLimit is the number of messages pending on a subscription
+	// above which the store is overloaded. While overloaded, metric points
+	// and repeated values are shed and the storeOverload point on the root
+	// node is set. 0 disables overload shedding.
+	PendingLimit int
 }
 
 // NewStore creates a new NATS client for handling SIOT requests
 func NewStore(p Params) (*Store, error) {
-	db, err := NewSqliteDb(p.File)
+	var db *DbSqlite
+	var err error
+
+	if p.Shard != "" && len(p.Shards) > 0 {
+		return nil, errors.New("a store can not be both a shard and a coordinator")
+	}
+
+	if p.ReadOnly {
+		db, err = NewSqliteDbReadOnly(p.File)
+	} else {
+		db, err = NewSqliteDb(p.File)
+	}
+
 	if err != nil {
 		return nil, fmt.Errorf("Error opening db: %v", err)
 	}
 
+	if len(p.SecretsKey) > 0 {
+		err = db.setSecretsKey(p.SecretsKey, p.ReadOnly)
+		if err != nil {
+			return nil, fmt.Errorf("Error setting secrets key: %v", err)
+		}
+	} else {
+		log.Println("No secrets key configured, secrets are stored unencrypted")
+	}
+
 	// we don't have node ID yet, but need to init here so we can start
 	// collecting data
 
+	var dd *dedup
+	if p.DedupWindow > 0 && !p.ReadOnly {
+		dd = newDedup(p.DedupWindow)
+	}
+
+	var router *shardRouter
+	if len(p.Shards) > 0 {
+		routes, err := db.shardRoutes()
+		if err != nil {
+			return nil, fmt.Errorf("Error loading shard routes: %v", err)
+		}
+		router = newShardRouter(p.Shards, routes)
+	}
+
+	var ch *chaos
+	if p.Chaos.Enabled() {
+		ch = newChaos(p.Chaos)
+		log.Println("WARNING: store chaos mode enabled, ", ch.Chaos)
+	}
+
 	log.Println("store connecting to nats server: ", p.Server)
 	return &Store{
 		db:            db,
@@ -76,12 +181,30 @@ func NewStore(p Params) (*Store, error) {
 		server:        p.Server,
 		key:           p.Key,
 		nc:            p.Nc,
+		maxSize:       p.MaxSize,
+		dedup:         dd,
+		recent:        newRecentCache(p.RecentLen),
+		smartGroups:   newSmartGroups(),
+		tags:          newTagIndex(),
+		filters:       newFilterSubs(),
+		readOnly:      p.ReadOnly,
+		shard:         p.Shard,
+		router:        router,
+		chaos:         ch,
+		pointStats:    newPointStats(time.Now()),
+		watchdog:      newWatchdog(p.SlowHandler, p.PendingLimit),
+		events:        newEventBus(),
+		msgIDs:        newMsgIDs(msgIDWindow, msgIDMax),
+		priority:      newPriorityQueue(),
+		clockSkew:     newClockSkew(),
 		subscriptions: make(map[string]*nats.Subscription),
 		chStop:        make(chan struct{}),
 		chStopMetrics: make(chan struct{}),
 		chWaitStart:   make(chan struct{}),
 		metricCycleNodePoint: client.NewMetric(p.Nc, "",
 			data.PointTypeMetricNatsCycleNodePoint, reportMetricsPeriod),
+		metricCycleNodePointControl: client.NewMetric(p.Nc, "",
+			data.PointTypeMetricNatsCycleNodePointControl, reportMetricsPeriod),
 		metricCycleNodeEdgePoint: client.NewMetric(p.Nc, "",
 			data.PointTypeMetricNatsCycleNodeEdgePoint, reportMetricsPeriod),
 		metricCycleNode: client.NewMetric(p.Nc, "",
@@ -94,42 +217,184 @@ func NewStore(p Params) (*Store, error) {
 // Start connects to NATS server and set up handlers for things we are interested in
 func (st *Store) Start() error {
 	var err error
-	st.subscriptions["nodePoints"], err = st.nc.Subscribe("node.*.points", st.handleNodePoints)
+
+	if err := st.initPush(); err != nil {
+		log.Println("Web push disabled: ", err)
+	}
+
+	st.events.add("upstream", st.forwardUpstream)
+	st.events.add("filters", st.forwardFiltered)
+	st.events.start()
+
+	// node points are queued by priority class and written by a single
+	// worker that handles control points ahead of queued telemetry. The
+	// watchdog times the worker.
+	nodePoints := st.write(st.replicated(st.handleNodePoints))
+	st.priority.start(st.watchdog.wrap("node.*.points", nodePoints))
+
+	st.subscriptions["nodePoints"], err = st.subscribeQueued("node.*.points", st.priority.handler())
 	if err != nil {
 		return fmt.Errorf("Subscribe node points error: %w", err)
 	}
 
-	st.subscriptions["edgePoints"], err = st.nc.Subscribe("node.*.*.points", st.handleEdgePoints)
+	st.subscriptions["edgePoints"], err = st.subscribe("node.*.*.points", st.write(st.replicated(st.handleEdgePoints)))
 	if err != nil {
 		return fmt.Errorf("Subscribe edge points error: %w", err)
 	}
 
-	if st.subscriptions["node"], err = st.nc.Subscribe("node.*", st.handleNode); err != nil {
+	if st.subscriptions["create"], err = st.subscribe("node.*.create", st.write(st.replicated(st.handleNodeCreate))); err != nil {
+		return fmt.Errorf("Subscribe create error: %w", err)
+	}
+
+	if st.subscriptions["reorder"], err = st.subscribe("node.*.reorder", st.write(st.replicated(st.handleNodeReorder))); err != nil {
+		return fmt.Errorf("Subscribe reorder error: %w", err)
+	}
+
+	if st.subscriptions["trash"], err = st.subscribe("node.*.trash", st.handleNodeTrash); err != nil {
+		return fmt.Errorf("Subscribe trash error: %w", err)
+	}
+
+	if st.subscriptions["purge"], err = st.subscribe("node.*.*.purge", st.write(st.replicated(st.handleNodePurge))); err != nil {
+		return fmt.Errorf("Subscribe purge error: %w", err)
+	}
+
+	if st.subscriptions["erase"], err = st.subscribe("node.*.erase", st.write(st.replicated(st.handleNodeErase))); err != nil {
+		return fmt.Errorf("Subscribe erase error: %w", err)
+	}
+
+	if st.subscriptions["changes"], err = st.subscribe("node.*.changes", st.handleNodeChanges); err != nil {
+		return fmt.Errorf("Subscribe changes error: %w", err)
+	}
+
+	if st.subscriptions["node"], err = st.subscribe("node.*", st.handleNode); err != nil {
 		return fmt.Errorf("Subscribe node error: %w", err)
 	}
 
-	if st.subscriptions["children"], err = st.nc.Subscribe("node.*.children", st.handleNodeChildren); err != nil {
+	if st.subscriptions["children"], err = st.subscribe("node.*.children", st.handleNodeChildren); err != nil {
 		return fmt.Errorf("Subscribe node error: %w", err)
 	}
 
-	if st.subscriptions["notifications"], err = st.nc.Subscribe("node.*.not", st.handleNotification); err != nil {
+	if st.subscriptions["notifications"], err = st.subscribe("node.*.not", st.write(st.handleNotification)); err != nil {
 		return fmt.Errorf("Subscribe notification error: %w", err)
 	}
 
-	if st.subscriptions["messages"], err = st.nc.Subscribe("node.*.msg", st.handleMessage); err != nil {
+	if st.subscriptions["messages"], err = st.subscribe("node.*.msg", st.write(st.handleMessage)); err != nil {
 		return fmt.Errorf("Subscribe message error: %w", err)
 	}
 
-	if st.subscriptions["auth"], err = st.nc.Subscribe("auth.user", st.handleAuthUser); err != nil {
+	if st.subscriptions["auth"], err = st.subscribe("auth.user", st.handleAuthUser); err != nil {
 		return fmt.Errorf("Subscribe auth error: %w", err)
 	}
 
+	if st.subscriptions["recent"], err = st.subscribe("node.*.recent", st.handleNodeRecent); err != nil {
+		return fmt.Errorf("Subscribe recent error: %w", err)
+	}
+
+	if st.subscriptions["history"], err = st.subscribe("history.*.points", st.write(st.handleHistoryPoints)); err != nil {
+		return fmt.Errorf("Subscribe history error: %w", err)
+	}
+
+	if st.subscriptions["quarantineList"], err = st.subscribe(client.SubjectQuarantineList(), st.handleQuarantineList); err != nil {
+		return fmt.Errorf("Subscribe quarantine list error: %w", err)
+	}
+
+	if st.subscriptions["quarantineAdmit"], err = st.subscribe(client.SubjectQuarantineAdmit(), st.write(st.replicated(st.handleQuarantineAdmit))); err != nil {
+		return fmt.Errorf("Subscribe quarantine admit error: %w", err)
+	}
+
+	if st.subscriptions["quarantineDiscard"], err = st.subscribe(client.SubjectQuarantineDiscard(), st.write(st.replicated(st.handleQuarantineDiscard))); err != nil {
+		return fmt.Errorf("Subscribe quarantine discard error: %w", err)
+	}
+
+	if st.subscriptions["backup"], err = st.subscribe(client.SubjectStoreBackup(), st.handleStoreBackup); err != nil {
+		return fmt.Errorf("Subscribe backup error: %w", err)
+	}
+
+	if st.subscriptions["tagQuery"], err = st.subscribe(client.SubjectTagQuery(), st.handleTagQuery); err != nil {
+		return fmt.Errorf("Subscribe tag query error: %w", err)
+	}
+
+	if st.subscriptions["tagList"], err = st.subscribe(client.SubjectTagList(), st.handleTagList); err != nil {
+		return fmt.Errorf("Subscribe tag list error: %w", err)
+	}
+
+	if st.subscriptions["filter"], err = st.subscribe(client.SubjectFilterSubscribe(), st.handleFilterSubscribe); err != nil {
+		return fmt.Errorf("Subscribe filter error: %w", err)
+	}
+
+	if err := st.loadTags(); err != nil {
+		log.Println("Error loading tags: ", err)
+	}
+
+	if err := st.loadSmartGroups(); err != nil {
+		log.Println("Error loading smart groups: ", err)
+	}
+
+	if err := st.loadClockSkew(); err != nil {
+		log.Println("Error loading clock skew policies: ", err)
+	}
+
+	retentionTicker := time.NewTicker(retentionCheckPeriod)
+	if st.maxSize <= 0 || st.readOnly {
+		retentionTicker.Stop()
+	}
+
+	dedupTicker := time.NewTicker(reportMetricsPeriod)
+	if st.dedup == nil {
+		dedupTicker.Stop()
+	}
+
+	filterTicker := time.NewTicker(client.FilterRenewPeriod)
+
+	statsTicker := time.NewTicker(reportMetricsPeriod)
+
+	msgIDTicker := time.NewTicker(reportMetricsPeriod)
+
+	overloadTicker := time.NewTicker(overloadCheckPeriod)
+	if st.watchdog.pendingLimit <= 0 {
+		overloadTicker.Stop()
+	}
+
 done:
 	for {
 		select {
 		case <-st.chWaitStart:
 			// don't need to do anything as simply reading this
 			// channel will unblock the caller
+		case <-retentionTicker.C:
+			st.checkRetention()
+		case <-dedupTicker.C:
+			suppressed := st.dedup.expire(time.Now())
+			err := client.SendPoints(st.nc, st.subject(client.SubjectNodePoints(st.db.rootNodeID())),
+				data.Points{{Type: data.PointTypeStoreDedupSuppressed, Value: float64(suppressed)}}, false)
+			if err != nil {
+				log.Println("Store dedup, error sending points: ", err)
+			}
+		case <-filterTicker.C:
+			st.filters.expire(time.Now())
+		case <-statsTicker.C:
+			now := time.Now()
+			points := append(st.pointStats.report(now), st.watchdog.report(now)...)
+			points = append(points, data.Point{Time: now, Type: data.PointTypeStoreClockSkewPoints,
+				Value: float64(st.clockSkew.report())})
+			if count, err := st.db.quarantineCount(); err == nil {
+				points = append(points, data.Point{Time: now,
+					Type: data.PointTypeStoreQuarantinePoints, Value: float64(count)})
+			}
+			err := client.SendPoints(st.nc, st.subject(client.SubjectNodePoints(st.db.rootNodeID())),
+				points, false)
+			if err != nil {
+				log.Println("Store point stats, error sending points: ", err)
+			}
+		case <-overloadTicker.C:
+			st.checkOverload()
+		case <-msgIDTicker.C:
+			dups := st.msgIDs.expire(time.Now())
+			err := client.SendPoints(st.nc, st.subject(client.SubjectNodePoints(st.db.rootNodeID())),
+				data.Points{{Type: data.PointTypeStoreDuplicateMsgs, Value: float64(dups)}}, false)
+			if err != nil {
+				log.Println("Store msg IDs, error sending points: ", err)
+			}
 		case <-st.chStop:
 			log.Println("Store stopped")
 			break done
@@ -137,12 +402,23 @@ done:
 	}
 
 	// clean up
+	retentionTicker.Stop()
+	dedupTicker.Stop()
+	filterTicker.Stop()
+	statsTicker.Stop()
+	overloadTicker.Stop()
+	msgIDTicker.Stop()
+
 	for k := range st.subscriptions {
 		err := st.subscriptions[k].Unsubscribe()
 		if err != nil {
 			log.Printf("Error unsubscribing from %v: %v\n", k, err)
 		}
 	}
+
+	st.priority.stop()
+	st.events.stop()
+
 	return nil
 }
 
@@ -175,6 +451,7 @@ func (st *Store) WaitStart(ctx context.Context) error {
 // FIXME, this can probably move to the node package for device nodes
 func (st *Store) StartMetrics(nodeID string) error {
 	st.metricCycleNodePoint.SetNodeID(nodeID)
+	st.metricCycleNodePointControl.SetNodeID(nodeID)
 	st.metricCycleNodeEdgePoint.SetNodeID(nodeID)
 	st.metricCycleNode.SetNodeID(nodeID)
 	st.metricCycleNodeChildren.SetNodeID(nodeID)
@@ -197,6 +474,9 @@ func (st *Store) StartMetrics(nodeID string) error {
 				log.Println("Error getting pendingNodePoints: ", err)
 			}
 
+			// include the telemetry messages queued by the store
+			pendingNodePoints += st.priority.pending()
+
 			err = st.metricPendingNodePoint.AddSample(float64(pendingNodePoints))
 			if err != nil {
 				log.Println("Error handling metric: ", err)
@@ -216,6 +496,51 @@ func (st *Store) StartMetrics(nodeID string) error {
 	}
 }
 
+// checkOverload updates the overload state from the subscription pending
+// counts, and sets the storeOverload point on the root node when it changes
+func (st *Store) checkOverload() {
+	pending := make(map[string]int)
+	for k, n := range st.events.pending() {
+		pending["events."+k] = n
+	}
+	pending["telemetry"] = st.priority.pending()
+	for k, sub := range st.subscriptions {
+		msgs, _, err := sub.Pending()
+		if err != nil {
+			continue
+		}
+		pending[k] = msgs
+	}
+
+	if !st.watchdog.check(pending) {
+		return
+	}
+
+	overloaded := st.watchdog.isOverloaded()
+	if overloaded {
+		log.Printf("Store: overloaded, shedding metric points and repeated values, pending: %v\n", pending)
+	} else {
+		log.Println("Store: recovered from overload")
+	}
+
+	err := client.SendPoints(st.nc, st.subject(client.SubjectNodePoints(st.db.rootNodeID())),
+		data.Points{{Time: time.Now(), Type: data.PointTypeStoreOverload,
+			Value: data.BoolToFloat(overloaded)}}, false)
+	if err != nil {
+		log.Println("Store overload, error sending point: ", err)
+	}
+}
+
+// WritePrometheus writes the number of points and bytes written to the
+// store by node type and point type, and the node point message latency by
+// priority class, in the Prometheus text format
+func (st *Store) WritePrometheus(w io.Writer) error {
+	if err := st.pointStats.writePrometheus(w); err != nil {
+		return err
+	}
+	return st.priority.writePrometheus(w)
+}
+
 // StopMetrics ...
 func (st *Store) StopMetrics(_ error) {
 	close(st.chStopMetrics)
@@ -228,12 +553,23 @@ func (st *Store) setSwUpdateState(id string, state data.SwUpdateState) error {
 }
 
 func (st *Store) handleNodePoints(msg *nats.Msg) {
+	control := client.MsgPriority(msg) == client.PriorityControl
+
 	start := time.Now()
 	defer func() {
 		t := time.Since(start).Milliseconds()
-		st.metricCycleNodePoint.AddSample(float64(t))
+		if control {
+			st.metricCycleNodePointControl.AddSample(float64(t))
+		} else {
+			st.metricCycleNodePoint.AddSample(float64(t))
+		}
 	}()
 
+	if dup, err := st.msgIDs.duplicate(msg, time.Now()); dup {
+		st.reply(msg.Reply, err)
+		return
+	}
+
 	nodeID, points, err := client.DecodeNodePointsMsg(msg)
 
 	if err != nil {
@@ -242,32 +578,202 @@ func (st *Store) handleNodePoints(msg *nats.Msg) {
 		return
 	}
 
-	// write points to database
-	err = st.db.nodePoints(nodeID, points)
+	policy := st.clockSkew.policy(nodeID, func(id string) ([]string, error) {
+		return st.db.up(id, false)
+	})
+	points, rejected := st.clockSkew.apply(policy, points, time.Now())
+	if len(rejected) > 0 {
+		st.quarantinePoints(nodeID, reasonClockSkew, rejected)
+	}
+
+	if len(points) == 0 {
+		// the rejected points were quarantined, so a retry is not
+		// processed again
+		err := errClockSkew(len(rejected))
+		st.msgIDs.handled(msg, time.Now(), err)
+		st.reply(msg.Reply, err)
+		return
+	}
+
+	// conditional writes are not deduplicated or shed, so the version is
+	// always checked
+	ifVersion := client.MsgIfVersion(msg)
+
+	if st.dedup != nil && ifVersion == "" {
+		points = st.dedup.filter(nodeID, points, time.Now())
+		if len(points) == 0 {
+			st.reply(msg.Reply, nil)
+			return
+		}
+	}
+
+	// control points are never shed
+	if !control && ifVersion == "" {
+		points = st.watchdog.shed(nodeID, points)
+	}
+	if len(points) == 0 {
+		st.reply(msg.Reply, nil)
+		return
+	}
 
+	err = st.writeNodePoints(nodeID, ifVersion, points, len(msg.Data))
 	if err != nil {
-		// TODO track error stats
-		log.Printf("Error writing nodeID (%v) to Db: %v", nodeID, err)
 		log.Println("msg subject: ", msg.Subject)
 		st.reply(msg.Reply, err)
 		return
 	}
 
+	if st.dedup != nil {
+		st.dedup.record(nodeID, points, time.Now())
+	}
+
+	// the points that were not rejected are written, but the client is
+	// told about the rejected points. The reply is recorded with the
+	// message ID so a retry does not write the points again.
+	if len(rejected) > 0 {
+		err = errClockSkew(len(rejected))
+	}
+
+	st.msgIDs.handled(msg, time.Now(), err)
+	st.reply(msg.Reply, err)
+}
+
+// writeNodePoints writes points to the database, indexes them, and
+// publishes them to the event bus. If version is set, the points are only
+// written if the node is at that version. size is the size of the message the
+// points were received in, for point stats.
+func (st *Store) writeNodePoints(nodeID, version string, points data.Points, size int) error {
+	err := st.db.nodePointsVersion(nodeID, version, points)
+	if err != nil {
+		// TODO track error stats
+		if err != data.ErrVersionMismatch {
+			log.Printf("Error writing nodeID (%v) to Db: %v", nodeID, err)
+		}
+		return err
+	}
+
 	node, err := st.db.node(nodeID)
 	if err != nil {
 		log.Println("handleNodePoints, error getting node for id: ", nodeID)
+		return nil
+	}
+
+	st.indexNodePoints(node, points, size)
+
+	// rules, the database client, and upstream sync are fed from the up
+	// subjects by the event bus
+	st.events.publish(pointsEvent{nodeID: nodeID, nodeDesc: node.Desc(), points: points})
+
+	return nil
+}
+
+// handleHistoryPoints handles historical points that are backfilled by
+// devices. These points are not written to the store as they may be older
+// than the current node state, and are only rebroadcast to upstream history
+// (db) clients.
+func (st *Store) handleHistoryPoints(msg *nats.Msg) {
+	if dup, err := st.msgIDs.duplicate(msg, time.Now()); dup {
+		st.reply(msg.Reply, err)
 		return
 	}
 
-	desc := node.Desc()
+	nodeID, points, err := client.DecodeNodePointsMsg(msg)
+	if err != nil {
+		st.reply(msg.Reply, errors.New("error decoding history points subject"))
+		return
+	}
 
-	// process point in upstream nodes
-	err = st.processPointsUpstream(nodeID, nodeID, desc, points)
+	_, err = st.db.node(nodeID)
 	if err != nil {
-		// TODO track error stats
-		log.Println("Error processing point in upstream nodes: ", err)
+		st.reply(msg.Reply, fmt.Errorf("Error getting node %v: %v", nodeID, err))
+		return
+	}
+
+	err = st.processHistoryUpstream(nodeID, nodeID, points)
+	if err != nil {
+		log.Println("Error processing history points in upstream nodes: ", err)
+		st.reply(msg.Reply, err)
+		return
+	}
+
+	st.msgIDs.handled(msg, time.Now(), nil)
+	st.reply(msg.Reply, nil)
+}
+
+// indexNodePoints updates the in-memory indexes after node points are
+// written
+func (st *Store) indexNodePoints(node *data.Node, points data.Points, msgSize int) {
+	st.pointStats.add(node.Type, points, msgSize)
+
+	recentLen, _ := node.Points.Value(data.PointTypeRecentLen, "")
+	st.recent.add(node.ID, data.Points(points).RemoveSecrets(), int(recentLen))
+
+	st.tags.update(node.ID, points)
+	st.clockSkew.update(node.ID, points)
+	st.updateSmartGroups(node, points)
+}
+
+// handleNodeCreate writes the edge points and node points of a node in one
+// transaction. This is used to create nodes so that other clients never see
+// a node without its type or parent.
+func (st *Store) handleNodeCreate(msg *nats.Msg) {
+	if dup, err := st.msgIDs.duplicate(msg, time.Now()); dup {
+		st.reply(msg.Reply, err)
+		return
+	}
+
+	ne, err := data.PbDecodeNode(msg.Data)
+	if err != nil {
+		st.reply(msg.Reply, fmt.Errorf("Error decoding node: %v", err))
+		return
 	}
 
+	chunks := strings.Split(msg.Subject, ".")
+	if len(chunks) != 3 || chunks[1] != ne.ID {
+		st.reply(msg.Reply, errors.New("node ID does not match subject"))
+		return
+	}
+
+	if ne.Type == "" {
+		st.reply(msg.Reply, errors.New("node type must be set"))
+		return
+	}
+
+	if _, ok := ne.Points.Find(data.PointTypeNodeType, ""); !ok {
+		ne.Points = append(ne.Points, data.Point{Time: time.Now(),
+			Type: data.PointTypeNodeType, Text: ne.Type})
+	}
+
+	if ne.Parent != "" && ne.Parent != "none" && len(ne.EdgePoints) < 1 {
+		ne.EdgePoints = data.Points{{Time: time.Now(), Type: data.PointTypeTombstone}}
+	}
+
+	err = st.db.createNode(ne)
+	if err != nil {
+		log.Printf("Error creating node %v: %v\n", ne.ID, err)
+		st.reply(msg.Reply, err)
+		return
+	}
+
+	node, err := st.db.node(ne.ID)
+	if err != nil {
+		log.Println("handleNodeCreate, error getting node for id: ", ne.ID)
+		st.reply(msg.Reply, err)
+		return
+	}
+
+	st.indexNodePoints(node, ne.Points, len(msg.Data))
+	st.clockSkew.invalidate()
+
+	// edge points are sent upstream first, so upstream instances do not
+	// see an orphaned node
+	if ne.Parent != "" && ne.Parent != "none" {
+		st.events.publish(pointsEvent{nodeID: ne.ID, parentID: ne.Parent, points: ne.EdgePoints})
+	}
+
+	st.events.publish(pointsEvent{nodeID: ne.ID, nodeDesc: node.Desc(), points: ne.Points})
+
+	st.msgIDs.handled(msg, time.Now(), nil)
 	st.reply(msg.Reply, nil)
 }
 
@@ -278,6 +784,11 @@ func (st *Store) handleEdgePoints(msg *nats.Msg) {
 		st.metricCycleNodeEdgePoint.AddSample(float64(t))
 	}()
 
+	if dup, err := st.msgIDs.duplicate(msg, time.Now()); dup {
+		st.reply(msg.Reply, err)
+		return
+	}
+
 	nodeID, parentID, points, err := client.DecodeEdgePointsMsg(msg)
 
 	if err != nil {
@@ -296,14 +807,96 @@ func (st *Store) handleEdgePoints(msg *nats.Msg) {
 		log.Printf("Error writing edge points (%v:%v) to Db: %v", nodeID, parentID, err)
 		log.Println("msg subject: ", msg.Subject)
 		st.reply(msg.Reply, err)
+		return
 	}
 
-	// process point in upstream nodes. We need to do this before writing
-	// to DB, otherwise the point will not be sent upstream
-	err = st.processEdgePointsUpstream(nodeID, nodeID, parentID, points)
+	// the node may have moved, so clock skew policies are resolved again
+	st.clockSkew.invalidate()
+
+	for _, p := range points {
+		if p.Type == data.PointTypeTombstone {
+			node, err := st.db.node(nodeID)
+			if err == nil {
+				st.updateSmartGroups(node, points)
+			}
+			break
+		}
+	}
+
+	st.events.publish(pointsEvent{nodeID: nodeID, parentID: parentID, points: points})
+
+	st.msgIDs.handled(msg, time.Now(), nil)
+	st.reply(msg.Reply, nil)
+}
+
+// handleNodeReorder sets the weight edge point of the children of a node. The
+// message points are id points with the child IDs in the new order.
+func (st *Store) handleNodeReorder(msg *nats.Msg) {
+	parentID, points, err := client.DecodeNodePointsMsg(msg)
 	if err != nil {
-		// TODO track error stats
-		log.Println("Error processing point in upstream nodes: ", err)
+		st.reply(msg.Reply, errors.New("error decoding reorder subject"))
+		return
+	}
+
+	if parentID == "root" {
+		parentID = st.db.rootNodeID()
+	}
+
+	children, err := st.db.children(parentID, "", false)
+	if err != nil {
+		st.reply(msg.Reply, err)
+		return
+	}
+
+	childMap := make(map[string]bool)
+	for _, c := range children {
+		childMap[c.ID] = true
+	}
+
+	var order []string
+	origin := ""
+	listed := make(map[string]bool)
+
+	for _, p := range points {
+		if p.Type != data.PointTypeID {
+			continue
+		}
+
+		if !childMap[p.Text] {
+			st.reply(msg.Reply, fmt.Errorf("%v is not a child of %v", p.Text, parentID))
+			return
+		}
+
+		if listed[p.Text] {
+			continue
+		}
+
+		listed[p.Text] = true
+		order = append(order, p.Text)
+		origin = p.Origin
+	}
+
+	// children that are not listed keep their current order after the
+	// listed children
+	for _, c := range children {
+		if !listed[c.ID] {
+			order = append(order, c.ID)
+		}
+	}
+
+	now := time.Now()
+
+	for i, id := range order {
+		pts := data.Points{{Time: now, Type: data.PointTypeWeight,
+			Value: float64(i), Origin: origin}}
+
+		err := st.db.edgePoints(id, parentID, pts)
+		if err != nil {
+			st.reply(msg.Reply, err)
+			return
+		}
+
+		st.events.publish(pointsEvent{nodeID: id, parentID: parentID, points: pts})
 	}
 
 	st.reply(msg.Reply, nil)
@@ -363,7 +956,7 @@ handleNodeDone:
 
 	data, err := proto.Marshal(resp)
 
-	err = st.nc.Publish(msg.Reply, data)
+	err = client.Respond(st.nc, msg, data)
 	if err != nil {
 		log.Println("NATS: Error publishing response to node request: ", err)
 	}
@@ -496,6 +1089,16 @@ func (st *Store) handleNodeChildren(msg *nats.Msg) {
 		goto handleNodeChildrenDone
 	}
 
+	if st.router != nil {
+		shardNodes, err := st.shardChildren(nodeID, msg.Data)
+		if err != nil {
+			resp.Error = fmt.Sprintf("NATS: Error getting node %v children from shards: %v\n", nodeID, err)
+			goto handleNodeChildrenDone
+		}
+		nodes = append(nodes, shardNodes...)
+		nodes.Sort()
+	}
+
 handleNodeChildrenDone:
 	resp.Nodes, err = nodes.ToPbNodes()
 	if err != nil {
@@ -507,7 +1110,7 @@ handleNodeChildrenDone:
 		resp.Error = fmt.Sprintf("Error encoding data: %v", err)
 	}
 
-	err = st.nc.Publish(msg.Reply, data)
+	err = client.Respond(st.nc, msg, data)
 
 	if err != nil {
 		log.Println("NATS: Error publishing response to node children request: ", err)
@@ -531,6 +1134,7 @@ func (st *Store) handleNotification(msg *nats.Msg) {
 	}
 
 	userNodes := []data.NodeEdge{}
+	listNodes := []data.NodeEdge{}
 
 	var findUsers func(id string)
 
@@ -545,6 +1149,14 @@ func (st *Store) handleNotification(msg *nats.Msg) {
 			userNodes = append(userNodes, n)
 		}
 
+		lists, err := st.db.children(id, data.NodeTypeDistList, false)
+		if err != nil {
+			log.Println("Error finding distribution list nodes: ", err)
+			return
+		}
+
+		listNodes = append(listNodes, lists...)
+
 		/* FIXME this needs to be moved to client
 
 		// now process upstream nodes
@@ -567,14 +1179,41 @@ func (st *Store) handleNotification(msg *nats.Msg) {
 		return
 	}
 
+	// the severity is set by the node that sends the notification
+	severity, _ := node.Points.Text(data.PointTypeSeverity, "")
+
 	if node.Type == data.NodeTypeUser {
 		// if we notify a user node, we only want to message this node, and not walk up the tree
 		nodeEdge := node.ToNodeEdge(data.Edge{Up: not.Parent})
 		userNodes = append(userNodes, nodeEdge)
+
+		if not.SourceNode != "" {
+			if source, err := st.db.node(not.SourceNode); err == nil {
+				severity, _ = source.Points.Text(data.PointTypeSeverity, "")
+			}
+		}
+	} else if node.Type == data.NodeTypeDistList {
+		// a distribution list can also be notified directly
+		listNodes = append(listNodes, node.ToNodeEdge(data.Edge{Up: not.Parent}))
 	} else {
 		findUsers(nodeID)
 	}
 
+	sendMsg := func(msg data.Message) {
+		d, err := msg.ToPb()
+		if err != nil {
+			log.Println("Error serializing msg to protobuf: ", err)
+			return
+		}
+
+		err = st.nc.Publish("node."+msg.UserID+".msg", d)
+		if err != nil {
+			log.Println("Error publishing message: ", err)
+		}
+	}
+
+	now := time.Now()
+
 	for _, userNode := range userNodes {
 		user, err := data.NodeToUser(userNode.ToNode())
 
@@ -583,31 +1222,63 @@ func (st *Store) handleNotification(msg *nats.Msg) {
 			continue
 		}
 
-		if user.Email != "" || user.Phone != "" {
-			msg := data.Message{
+		if user.Notify(severity, now) {
+			st.sendPush(userNode, not, severity)
+		}
+
+		sendEmail, sendSMS := user.NotifyChannels(severity, now)
+
+		email, phone := user.Email, user.Phone
+		if !sendEmail {
+			email = ""
+		}
+		if !sendSMS {
+			phone = ""
+		}
+
+		if email != "" || phone != "" {
+			sendMsg(data.Message{
 				ID:             uuid.New().String(),
 				UserID:         user.ID,
 				ParentID:       userNode.Parent,
 				NotificationID: nodeID,
-				Email:          user.Email,
-				Phone:          user.Phone,
+				Email:          email,
+				Phone:          phone,
 				Subject:        not.Subject,
 				Message:        not.Message,
-			}
+			})
+		}
+	}
 
-			data, err := msg.ToPb()
+	// distribution lists get a message for each email address and phone
+	// number. The list ID is used as the user ID of the messages.
+	for _, listNode := range data.RemoveDuplicateNodesID(listNodes) {
+		list := data.NodeToDistList(listNode.ToNode())
+		if !list.Notify(severity) {
+			continue
+		}
 
-			if err != nil {
-				log.Println("Error serializing msg to protobuf: ", err)
-				continue
+		newMsg := func() data.Message {
+			return data.Message{
+				ID:             uuid.New().String(),
+				UserID:         list.ID,
+				ParentID:       listNode.Parent,
+				NotificationID: nodeID,
+				Subject:        not.Subject,
+				Message:        not.Message,
 			}
+		}
 
-			err = st.nc.Publish("node."+user.ID+".msg", data)
+		for _, email := range list.Emails {
+			msg := newMsg()
+			msg.Email = email
+			sendMsg(msg)
+		}
 
-			if err != nil {
-				log.Println("Error publishing message: ", err)
-				continue
-			}
+		for _, phone := range list.Phones {
+			msg := newMsg()
+			msg.Phone = phone
+			sendMsg(msg)
 		}
 	}
 }
@@ -695,6 +1366,30 @@ func (st *Store) handleMessage(natsMsg *nats.Msg) {
 	}
 }
 
+// write returns the handler for a subject that modifies the store. If the
+// store is read-only, requests are rejected with ErrReadOnly.
+func (st *Store) write(h nats.MsgHandler) nats.MsgHandler {
+	if !st.readOnly {
+		return h
+	}
+
+	return func(msg *nats.Msg) {
+		st.reply(msg.Reply, ErrReadOnly)
+	}
+}
+
+// replicated returns the handler for a subject that changes the database.
+// Messages are passed to repl after they are handled, so the standby of an
+// HA pair can apply them.
+func (st *Store) replicated(h nats.MsgHandler) nats.MsgHandler {
+	return func(msg *nats.Msg) {
+		h(msg)
+		if st.repl != nil {
+			st.repl(msg)
+		}
+	}
+}
+
 // used for messages that want an ACK
 func (st *Store) reply(subject string, err error) {
 	if subject == "" {
@@ -785,6 +1480,33 @@ func (st *Store) processPointsUpstream(upNodeID, nodeID, nodeDesc string, points
 	return nil
 }
 
+func (st *Store) processHistoryUpstream(upNodeID, nodeID string, points data.Points) error {
+	sub := fmt.Sprintf("histup.%v.%v.points", upNodeID, nodeID)
+
+	err := client.SendPoints(st.nc, sub, points, false)
+	if err != nil {
+		return err
+	}
+
+	if upNodeID == "none" {
+		return nil
+	}
+
+	ups, err := st.db.up(upNodeID, false)
+	if err != nil {
+		return err
+	}
+
+	for _, up := range ups {
+		err = st.processHistoryUpstream(up, nodeID, points)
+		if err != nil {
+			log.Println("History -- error processing upstream node: ", err)
+		}
+	}
+
+	return nil
+}
+
 func (st *Store) processEdgePointsUpstream(upNodeID, nodeID, parentID string, points data.Points) error {
 	sub := fmt.Sprintf("up.%v.%v.%v.points", upNodeID, nodeID, parentID)
 
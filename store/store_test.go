@@ -1,7 +1,16 @@
 package store_test
 
 import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os/exec"
 	"testing"
 	"time"
 
@@ -10,6 +19,7 @@ import (
 	"github.com/simpleiot/simpleiot/client"
 	"github.com/simpleiot/simpleiot/data"
 	"github.com/simpleiot/simpleiot/server"
+	"github.com/simpleiot/simpleiot/store"
 )
 
 func TestStoreUp(t *testing.T) {
@@ -127,3 +137,1106 @@ func TestStoreMultiplePoints(t *testing.T) {
 	}
 
 }
+
+func TestStoreRecent(t *testing.T) {
+	nc, root, stop, err := server.TestServer()
+	if err != nil {
+		t.Fatal("Error starting test server: ", err)
+	}
+
+	defer stop()
+
+	err = client.SendNodePoint(nc, root.ID, data.Point{Type: data.PointTypeRecentLen,
+		Value: 5}, true)
+	if err != nil {
+		t.Fatal("Error sending point: ", err)
+	}
+
+	start := time.Now()
+	for i := 0; i < 10; i++ {
+		err = client.SendNodePoint(nc, root.ID, data.Point{Type: data.PointTypeValue,
+			Time: start.Add(time.Duration(i) * time.Millisecond), Value: float64(i)}, true)
+		if err != nil {
+			t.Fatal("Error sending point: ", err)
+		}
+	}
+
+	pts, err := client.GetRecentPoints(nc, root.ID, data.PointTypeValue, "")
+	if err != nil {
+		t.Fatal("Error getting recent points: ", err)
+	}
+
+	if len(pts) != 5 || pts[0].Value != 5 || pts[4].Value != 9 {
+		t.Error("wrong recent points: ", pts)
+	}
+}
+
+func TestStoreNodeCreate(t *testing.T) {
+	nc, root, stop, err := server.TestServer()
+	if err != nil {
+		t.Fatal("Error starting test server: ", err)
+	}
+
+	defer stop()
+
+	subjects := make(chan string, 10)
+	sub, err := nc.Subscribe(fmt.Sprintf("up.%v.>", root.ID), func(msg *nats.Msg) {
+		subjects <- msg.Subject
+	})
+	if err != nil {
+		t.Fatal("Error subscribing: ", err)
+	}
+	defer sub.Unsubscribe()
+
+	v := data.NodeEdge{ID: uuid.New().String(), Type: data.NodeTypeVariable, Parent: root.ID,
+		Points: data.Points{{Type: data.PointTypeDescription, Text: "var"}}}
+
+	err = client.SendNode(nc, v, "test")
+	if err != nil {
+		t.Fatal("Error creating node: ", err)
+	}
+
+	nodes, err := client.GetNode(nc, v.ID, root.ID)
+	if err != nil || len(nodes) < 1 {
+		t.Fatal("Error getting node: ", err)
+	}
+
+	if nodes[0].Type != data.NodeTypeVariable || nodes[0].Desc() != "var" {
+		t.Error("node not created correctly: ", nodes[0])
+	}
+
+	if tombstone, _ := nodes[0].IsTombstone(); tombstone {
+		t.Error("node should not be deleted")
+	}
+
+	// edge points are sent upstream before node points
+	for i, exp := range []string{
+		fmt.Sprintf("up.%v.%v.%v.points", root.ID, v.ID, root.ID),
+		fmt.Sprintf("up.%v.%v.points", root.ID, v.ID),
+	} {
+		select {
+		case s := <-subjects:
+			if s != exp {
+				t.Errorf("up message %v: expected %v, got %v", i, exp, s)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("timeout waiting for up message ", i)
+		}
+	}
+
+	// the node ID in the subject must match the node
+	d, err := v.ToPb()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := nc.Request(client.SubjectNodeCreate("other"), d, time.Second)
+	if err != nil {
+		t.Fatal("Error sending create request: ", err)
+	}
+
+	if len(resp.Data) == 0 {
+		t.Error("expected error for mismatched node ID")
+	}
+}
+
+func TestStoreMsgID(t *testing.T) {
+	nc, root, stop, err := server.TestServer()
+	if err != nil {
+		t.Fatal("Error starting test server: ", err)
+	}
+
+	defer stop()
+
+	send := func(id string, v float64) {
+		t.Helper()
+		ctx := client.WithMsgID(context.Background(), id)
+		err := client.SendNodePointsCtx(ctx, nc, root.ID,
+			data.Points{{Type: data.PointTypeValue, Value: v}}, true)
+		if err != nil {
+			t.Fatal("Error sending points: ", err)
+		}
+	}
+
+	check := func(exp float64) {
+		t.Helper()
+		nodes, err := client.GetNode(nc, root.ID, "none")
+		if err != nil || len(nodes) < 1 {
+			t.Fatal("Error getting node: ", err)
+		}
+		v, _ := nodes[0].Points.Value(data.PointTypeValue, "")
+		if v != exp {
+			t.Errorf("expected value %v, got %v", exp, v)
+		}
+	}
+
+	send("msg-1", 1)
+	check(1)
+
+	// a message with the same ID is acked, but not written
+	send("msg-1", 2)
+	check(1)
+
+	send("msg-2", 3)
+	check(3)
+}
+
+func TestStoreMsgIDClockSkew(t *testing.T) {
+	nc, root, stop, err := server.TestServer()
+	if err != nil {
+		t.Fatal("Error starting test server: ", err)
+	}
+
+	defer stop()
+
+	dev := data.NodeEdge{
+		ID:     uuid.New().String(),
+		Type:   data.NodeTypeDevice,
+		Parent: root.ID,
+		Points: data.Points{
+			{Type: data.PointTypeClockSkewPolicy, Text: data.PointValueReject},
+		},
+	}
+
+	if err := client.SendNode(nc, dev, "test"); err != nil {
+		t.Fatal("Error sending node: ", err)
+	}
+
+	old := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// one point is written and the other rejected
+	send := func(v float64) error {
+		ctx := client.WithMsgID(context.Background(), "skew-1")
+		return client.SendNodePointsCtx(ctx, nc, dev.ID, data.Points{
+			{Type: data.PointTypeValue, Key: "a", Value: v},
+			{Time: old, Type: data.PointTypeValue, Key: "q", Value: v},
+		}, true)
+	}
+
+	err1 := send(1)
+	if err1 == nil {
+		t.Fatal("expected a point to be rejected")
+	}
+
+	// a retry gets the same reply, and is not written again
+	err2 := send(2)
+	if err2 == nil || err2.Error() != err1.Error() {
+		t.Fatalf("retry reply %v does not match %v", err2, err1)
+	}
+
+	nodes, err := client.GetNode(nc, dev.ID, root.ID)
+	if err != nil || len(nodes) < 1 {
+		t.Fatal("Error getting node: ", err)
+	}
+
+	if v, _ := nodes[0].Points.Value(data.PointTypeValue, "a"); v != 1 {
+		t.Errorf("retry was written, value: %v", v)
+	}
+
+	qps, err := client.GetQuarantine(nc, client.QuarantineQuery{NodeID: dev.ID})
+	if err != nil {
+		t.Fatal("Error getting quarantine: ", err)
+	}
+
+	if len(qps) != 1 {
+		t.Errorf("expected 1 quarantined point, got %v", len(qps))
+	}
+}
+
+func TestStoreIfVersion(t *testing.T) {
+	nc, root, stop, err := server.TestServer()
+	if err != nil {
+		t.Fatal("Error starting test server: ", err)
+	}
+
+	defer stop()
+
+	// the root node is written while the server starts, so use a new node
+	n := client.Variable{ID: "ID-var", Parent: root.ID, Description: "var"}
+	if err := client.SendNodeType(nc, n, "test"); err != nil {
+		t.Fatal("Error sending node: ", err)
+	}
+
+	version := func() string {
+		t.Helper()
+		nodes, err := client.GetNode(nc, n.ID, "none")
+		if err != nil || len(nodes) < 1 {
+			t.Fatal("Error getting node: ", err)
+		}
+		return nodes[0].Points.Version()
+	}
+
+	send := func(v string, value float64) error {
+		ctx := client.WithIfVersion(context.Background(), v)
+		return client.SendNodePointsCtx(ctx, nc, n.ID,
+			data.Points{{Type: data.PointTypeValue, Value: value, Origin: "test"}}, true)
+	}
+
+	v := version()
+
+	// two writes based on the same version, only the first one is written
+	if err := send(v, 1); err != nil {
+		t.Fatal("Error sending points: ", err)
+	}
+
+	var nak *client.NakError
+	err = send(v, 2)
+	if !errors.As(err, &nak) || nak.Msg != data.ErrVersionMismatch.Error() {
+		t.Fatal("expected version mismatch, got: ", err)
+	}
+
+	nodes, err := client.GetNode(nc, n.ID, "none")
+	if err != nil || len(nodes) < 1 {
+		t.Fatal("Error getting node: ", err)
+	}
+
+	if val, _ := nodes[0].Points.Value(data.PointTypeValue, ""); val != 1 {
+		t.Error("conflicting write was applied, value: ", val)
+	}
+
+	// concurrent writes based on the same version, only one succeeds
+	v = version()
+	errs := make(chan error)
+	for i := 0; i < 10; i++ {
+		go func(i int) {
+			errs <- send(v, float64(10+i))
+		}(i)
+	}
+
+	ok := 0
+	for i := 0; i < 10; i++ {
+		if err := <-errs; err == nil {
+			ok++
+		}
+	}
+
+	if ok != 1 {
+		t.Error("expected one concurrent write to succeed, got: ", ok)
+	}
+}
+
+func TestStorePriority(t *testing.T) {
+	nc, root, stop, err := server.TestServer()
+	if err != nil {
+		t.Fatal("Error starting test server: ", err)
+	}
+
+	defer stop()
+
+	// other points are sent to the root node by the server, so only
+	// messages with test points are checked
+	priorities := make(chan string, 10)
+	sub, err := nc.Subscribe(client.SubjectNodePoints(root.ID), func(msg *nats.Msg) {
+		points, err := data.PbDecodePoints(msg.Data)
+		if err != nil || len(points) != 1 || points[0].Key != "priority" {
+			return
+		}
+		priorities <- client.MsgPriority(msg)
+	})
+	if err != nil {
+		t.Fatal("Error subscribing: ", err)
+	}
+	defer sub.Unsubscribe()
+
+	send := func(ctx context.Context, p data.Point, exp string) {
+		t.Helper()
+		err := client.SendNodePointsCtx(ctx, nc, root.ID, data.Points{p}, true)
+		if err != nil {
+			t.Fatal("Error sending points: ", err)
+		}
+
+		select {
+		case p := <-priorities:
+			if p != exp {
+				t.Errorf("expected priority %v, got %v", exp, p)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("timeout waiting for points")
+		}
+	}
+
+	ctx := context.Background()
+	send(ctx, data.Point{Type: data.PointTypeValue, Key: "priority", Value: 1}, client.PriorityTelemetry)
+	send(ctx, data.Point{Type: data.PointTypeValueSet, Key: "priority", Value: 2}, client.PriorityControl)
+	send(client.WithPriority(ctx, client.PriorityControl),
+		data.Point{Type: data.PointTypeValue, Key: "priority", Value: 3}, client.PriorityControl)
+
+	nodes, err := client.GetNode(nc, root.ID, "none")
+	if err != nil || len(nodes) < 1 {
+		t.Fatal("Error getting node: ", err)
+	}
+
+	if v, _ := nodes[0].Points.Value(data.PointTypeValueSet, "priority"); v != 2 {
+		t.Error("control point was not written: ", v)
+	}
+
+	if v, _ := nodes[0].Points.Value(data.PointTypeValue, "priority"); v != 3 {
+		t.Error("value point was not written: ", v)
+	}
+}
+
+func TestStoreClockSkew(t *testing.T) {
+	nc, root, stop, err := server.TestServer()
+	if err != nil {
+		t.Fatal("Error starting test server: ", err)
+	}
+
+	defer stop()
+
+	bus := data.NodeEdge{
+		ID:     uuid.New().String(),
+		Type:   data.NodeTypeDevice,
+		Parent: root.ID,
+		Points: data.Points{
+			{Type: data.PointTypeClockSkewPolicy, Text: data.PointValueReject},
+		},
+	}
+
+	io := data.NodeEdge{
+		ID:     uuid.New().String(),
+		Type:   data.NodeTypeDevice,
+		Parent: bus.ID,
+	}
+
+	for _, n := range []data.NodeEdge{bus, io} {
+		if err := client.SendNode(nc, n, "test"); err != nil {
+			t.Fatal("Error sending node: ", err)
+		}
+	}
+
+	old := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	err = client.SendNodePoint(nc, io.ID, data.Point{Time: old, Type: data.PointTypeValue, Value: 1}, true)
+	if err == nil {
+		t.Fatal("expected point with a dead RTC time to be rejected")
+	}
+
+	err = client.SendNodePoint(nc, bus.ID, data.Point{Type: data.PointTypeClockSkewPolicy,
+		Text: data.PointValueFlag}, true)
+	if err != nil {
+		t.Fatal("Error setting policy: ", err)
+	}
+
+	err = client.SendNodePoint(nc, io.ID, data.Point{Time: old, Type: data.PointTypeValue, Value: 2}, true)
+	if err != nil {
+		t.Fatal("Error sending point: ", err)
+	}
+
+	nodes, err := client.GetNode(nc, io.ID, bus.ID)
+	if err != nil || len(nodes) < 1 {
+		t.Fatal("Error getting node: ", err)
+	}
+
+	p, ok := nodes[0].Points.Find(data.PointTypeValue, "")
+	if !ok || p.Value != 2 || p.Quality != data.PointQualityClockSkew {
+		t.Errorf("expected flagged point, got: %v", p)
+	}
+}
+
+func TestStoreQuarantine(t *testing.T) {
+	nc, root, stop, err := server.TestServer()
+	if err != nil {
+		t.Fatal("Error starting test server: ", err)
+	}
+
+	defer stop()
+
+	dev := data.NodeEdge{
+		ID:     uuid.New().String(),
+		Type:   data.NodeTypeDevice,
+		Parent: root.ID,
+		Points: data.Points{
+			{Type: data.PointTypeClockSkewPolicy, Text: data.PointValueReject},
+		},
+	}
+
+	if err := client.SendNode(nc, dev, "test"); err != nil {
+		t.Fatal("Error sending node: ", err)
+	}
+
+	old := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	for _, v := range []float64{1, 2} {
+		err = client.SendNodePoint(nc, dev.ID, data.Point{Time: old, Type: data.PointTypeValue,
+			Key: "q", Value: v}, true)
+		if err == nil {
+			t.Fatal("expected point to be rejected")
+		}
+	}
+
+	qps, err := client.GetQuarantine(nc, client.QuarantineQuery{NodeID: dev.ID})
+	if err != nil {
+		t.Fatal("Error getting quarantine: ", err)
+	}
+
+	if len(qps) != 2 || qps[0].Point.Value != 1 || !qps[0].Point.Time.Equal(old) ||
+		qps[0].Reason == "" {
+		t.Fatalf("wrong quarantined points: %+v", qps)
+	}
+
+	n, err := client.AdmitQuarantine(nc, []int64{qps[0].ID})
+	if err != nil || n != 1 {
+		t.Fatal("Error admitting point: ", n, err)
+	}
+
+	nodes, err := client.GetNode(nc, dev.ID, root.ID)
+	if err != nil || len(nodes) < 1 {
+		t.Fatal("Error getting node: ", err)
+	}
+
+	p, ok := nodes[0].Points.Find(data.PointTypeValue, "q")
+	if !ok || p.Value != 1 || !p.Time.Equal(old) {
+		t.Errorf("admitted point was not written: %v", p)
+	}
+
+	n, err = client.DiscardQuarantine(nc, []int64{qps[0].ID, qps[1].ID})
+	if err != nil || n != 1 {
+		t.Fatal("Error discarding point: ", n, err)
+	}
+
+	qps, err = client.GetQuarantine(nc, client.QuarantineQuery{})
+	if err != nil || len(qps) != 0 {
+		t.Fatal("expected empty quarantine: ", qps, err)
+	}
+}
+
+func TestStoreSmartGroup(t *testing.T) {
+	nc, root, stop, err := server.TestServer()
+	if err != nil {
+		t.Fatal("Error starting test server: ", err)
+	}
+
+	defer stop()
+
+	err = client.SendNodePoint(nc, root.ID, data.Point{Type: data.PointTypeVersionApp,
+		Text: "1.3.1"}, true)
+	if err != nil {
+		t.Fatal("Error sending point: ", err)
+	}
+
+	group := data.NodeEdge{
+		ID:     uuid.New().String(),
+		Type:   data.NodeTypeSmartGroup,
+		Parent: root.ID,
+		Points: data.Points{
+			{Type: data.PointTypeQuery, Text: "type=device, versionApp<1.4"},
+		},
+	}
+
+	err = client.SendNode(nc, group, "test")
+	if err != nil {
+		t.Fatal("Error sending node: ", err)
+	}
+
+	waitMember := func(exp float64) {
+		start := time.Now()
+		for {
+			nodes, err := client.GetNode(nc, group.ID, "none")
+			if err == nil && len(nodes) > 0 {
+				p, ok := nodes[0].Points.Find(data.PointTypeMember, root.ID)
+				if ok && p.Value == exp {
+					return
+				}
+			}
+			if time.Since(start) > time.Second {
+				t.Fatal("Timeout waiting for member value: ", exp)
+			}
+			<-time.After(time.Millisecond * 10)
+		}
+	}
+
+	waitMember(1)
+
+	members, err := client.GetSmartGroupMembers(nc, group.ID)
+	if err != nil {
+		t.Fatal("Error getting members: ", err)
+	}
+
+	if len(members) != 1 || members[0] != root.ID {
+		t.Fatal("wrong members: ", members)
+	}
+
+	err = client.SendNodePoint(nc, root.ID, data.Point{Type: data.PointTypeVersionApp,
+		Text: "1.4.0"}, true)
+	if err != nil {
+		t.Fatal("Error sending point: ", err)
+	}
+
+	waitMember(0)
+}
+
+func TestStoreTags(t *testing.T) {
+	nc, root, stop, err := server.TestServer()
+	if err != nil {
+		t.Fatal("Error starting test server: ", err)
+	}
+
+	defer stop()
+
+	err = client.SendNodePoints(nc, root.ID, data.Points{
+		{Type: data.PointTypeTag, Key: "customer", Text: "acme", Value: 1},
+		{Type: data.PointTypeTag, Key: "region", Text: "north", Value: 1},
+	}, true)
+	if err != nil {
+		t.Fatal("Error sending points: ", err)
+	}
+
+	nodes, err := client.GetNodesByTag(nc, map[string]string{"customer": "acme",
+		"region": ""})
+	if err != nil {
+		t.Fatal("Error finding nodes by tag: ", err)
+	}
+
+	if len(nodes) != 1 || nodes[0].ID != root.ID {
+		t.Fatal("wrong nodes: ", nodes)
+	}
+
+	nodes, err = client.GetNodesByTag(nc, map[string]string{"customer": "globex"})
+	if err != nil {
+		t.Fatal("Error finding nodes by tag: ", err)
+	}
+
+	if len(nodes) != 0 {
+		t.Fatal("expected no nodes: ", nodes)
+	}
+
+	tags, err := client.GetTags(nc)
+	if err != nil {
+		t.Fatal("Error getting tags: ", err)
+	}
+
+	if len(tags) != 2 || tags[0].Key != "customer" || tags[1].Key != "region" {
+		t.Fatal("wrong tags: ", tags)
+	}
+}
+
+func TestStoreReorder(t *testing.T) {
+	nc, root, stop, err := server.TestServer()
+	if err != nil {
+		t.Fatal("Error starting test server: ", err)
+	}
+	defer stop()
+
+	ids := []string{"ID-c", "ID-a", "ID-b"}
+	for _, id := range ids {
+		v := client.Variable{ID: id, Parent: root.ID, Description: id}
+		if err := client.SendNodeType(nc, v, "test"); err != nil {
+			t.Fatal("Error sending node: ", err)
+		}
+	}
+
+	order := func() string {
+		children, err := client.GetNodeChildren(nc, root.ID, data.NodeTypeVariable, false, false)
+		if err != nil {
+			t.Fatal("Error getting children: ", err)
+		}
+		var ret []string
+		for _, c := range children {
+			ret = append(ret, c.ID)
+		}
+		return fmt.Sprint(ret)
+	}
+
+	// no weights set, so children are ordered by description
+	if o := order(); o != "[ID-a ID-b ID-c]" {
+		t.Fatal("wrong initial order: ", o)
+	}
+
+	err = client.ReorderChildren(nc, root.ID, []string{"ID-c", "ID-a"}, "test")
+	if err != nil {
+		t.Fatal("Error reordering: ", err)
+	}
+
+	if o := order(); o != "[ID-c ID-a ID-b]" {
+		t.Fatal("wrong order after reorder: ", o)
+	}
+
+	err = client.ReorderChildren(nc, root.ID, []string{"ID-x"}, "test")
+	if err == nil {
+		t.Fatal("reorder with unknown child should fail")
+	}
+}
+
+func TestStoreShard(t *testing.T) {
+	nc, root, stop, err := server.TestServerOptions(server.Options{
+		StoreFile:    "test.sqlite",
+		StoreShards:  []string{"a"},
+		NatsPort:     4990,
+		HTTPPort:     "8990",
+		NatsHTTPPort: 8991,
+		NatsWSPort:   8992,
+		NatsServer:   "nats://localhost:4990",
+	})
+	if err != nil {
+		t.Fatal("Error starting test server: ", err)
+	}
+	defer stop()
+
+	shardFile := "test-shard.sqlite"
+	rmShard := func() { exec.Command("sh", "-c", "rm "+shardFile+"*").Run() }
+	rmShard()
+	defer rmShard()
+
+	shard, err := store.NewStore(store.Params{File: shardFile, Nc: nc, Shard: "a"})
+	if err != nil {
+		t.Fatal("Error creating shard: ", err)
+	}
+
+	go shard.Start()
+	defer shard.Stop(nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	if err := shard.WaitStart(ctx); err != nil {
+		t.Fatal("Error starting shard: ", err)
+	}
+
+	group := data.NodeEdge{ID: "ID-group", Type: data.NodeTypeGroup, Parent: root.ID,
+		EdgePoints: data.Points{{Type: data.PointTypeShard, Text: "a"}}}
+	if err := client.SendNode(nc, group, "test"); err != nil {
+		t.Fatal("Error sending group: ", err)
+	}
+
+	nodes := []client.Variable{
+		{ID: "ID-sharded", Parent: group.ID, Description: "sharded"},
+		{ID: "ID-local", Parent: root.ID, Description: "local"},
+	}
+
+	for _, v := range nodes {
+		if err := client.SendNodeType(nc, v, "test"); err != nil {
+			t.Fatal("Error sending node: ", err)
+		}
+	}
+
+	// the coordinator forwards requests for sharded nodes
+	n, err := client.GetNode(nc, "ID-sharded", group.ID)
+	if err != nil || len(n) != 1 || n[0].Desc() != "sharded" {
+		t.Fatal("Error getting sharded node: ", n, err)
+	}
+
+	// and merges children from shards
+	children, err := client.GetNodeChildren(nc, root.ID, "", false, false)
+	if err != nil {
+		t.Fatal("Error getting root children: ", err)
+	}
+
+	found := make(map[string]bool)
+	for _, c := range children {
+		found[c.ID] = true
+	}
+
+	if !found["ID-group"] || !found["ID-local"] {
+		t.Fatal("missing root children: ", children)
+	}
+
+	// check where the nodes are stored
+	inShard := func(id string) bool {
+		msg, err := nc.Request(client.SubjectShard("a", "node."+id), nil, time.Second)
+		if err != nil {
+			t.Fatal("Error requesting node from shard: ", err)
+		}
+		nodes, err := data.PbDecodeNodesRequest(msg.Data)
+		return err == nil && len(nodes) > 0
+	}
+
+	if !inShard("ID-group") || !inShard("ID-sharded") {
+		t.Error("sharded nodes are not in the shard")
+	}
+
+	if inShard("ID-local") {
+		t.Error("local node is in the shard")
+	}
+}
+
+func TestStoreShardForwardSlow(t *testing.T) {
+	nc, root, stop, err := server.TestServerOptions(server.Options{
+		StoreFile:    "test.sqlite",
+		StoreShards:  []string{"slow", "fast"},
+		NatsPort:     4990,
+		HTTPPort:     "8990",
+		NatsHTTPPort: 8991,
+		NatsWSPort:   8992,
+		NatsServer:   "nats://localhost:4990",
+	})
+	if err != nil {
+		t.Fatal("Error starting test server: ", err)
+	}
+	defer stop()
+
+	// fake shards reply to everything right away, except requests for
+	// ID-slow, which take longer than the test
+	slowRequest := make(chan struct{}, 1)
+	// the fake shards keep replying until the server is stopped
+	_, err = nc.Subscribe("shard.*.>", func(msg *nats.Msg) {
+		if msg.Subject == client.SubjectShard("slow", "node.ID-slow") {
+			select {
+			case slowRequest <- struct{}{}:
+			default:
+			}
+			go func() {
+				time.Sleep(3 * time.Second)
+				msg.Respond(nil)
+			}()
+			return
+		}
+		msg.Respond(nil)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, shard := range []string{"slow", "fast"} {
+		n := data.NodeEdge{ID: "ID-" + shard, Type: data.NodeTypeGroup, Parent: root.ID,
+			EdgePoints: data.Points{{Type: data.PointTypeShard, Text: shard}}}
+		if err := client.SendNode(nc, n, "test"); err != nil {
+			t.Fatal("Error sending node: ", err)
+		}
+	}
+
+	go func() {
+		_, _ = client.GetNode(nc, "ID-slow", "")
+	}()
+
+	<-slowRequest
+
+	start := time.Now()
+	_, err = client.GetNode(nc, "ID-fast", "")
+	if err != nil {
+		t.Fatal("Error getting node from fast shard: ", err)
+	}
+
+	if time.Since(start) > time.Second {
+		t.Fatal("request to fast shard waited for slow shard: ", time.Since(start))
+	}
+}
+
+func TestStoreNotificationPreferences(t *testing.T) {
+	nc, root, stop, err := server.TestServer()
+	if err != nil {
+		t.Fatal("Error starting test server: ", err)
+	}
+	defer stop()
+
+	users, err := client.GetNodeChildren(nc, root.ID, data.NodeTypeUser, false, false)
+	if err != nil || len(users) < 1 {
+		t.Fatal("Error getting user: ", err)
+	}
+
+	user := users[0]
+
+	err = client.SendNodePoints(nc, user.ID, data.Points{
+		{Type: data.PointTypeNotifySeverity, Text: data.PointValueWarning},
+		{Type: data.PointTypeDisableSMS, Value: 1},
+		{Type: data.PointTypePhone, Text: "555-1234"},
+	}, true)
+	if err != nil {
+		t.Fatal("Error setting user preferences: ", err)
+	}
+
+	chMsg := make(chan data.Message, 10)
+	sub, err := nc.Subscribe("node.*.msg", func(msg *nats.Msg) {
+		m, err := data.PbDecodeMessage(msg.Data)
+		if err != nil {
+			t.Error("Error decoding message: ", err)
+			return
+		}
+		chMsg <- m
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Unsubscribe()
+
+	notify := func() {
+		n := data.Notification{ID: uuid.New().String(), Message: "test"}
+		d, err := n.ToPb()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := nc.Publish("node."+root.ID+".not", d); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// info notifications are below the user threshold
+	notify()
+
+	select {
+	case m := <-chMsg:
+		t.Fatal("info notification was sent: ", m)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	err = client.SendNodePoint(nc, root.ID, data.Point{Type: data.PointTypeSeverity,
+		Text: data.PointValueCritical}, true)
+	if err != nil {
+		t.Fatal("Error setting severity: ", err)
+	}
+
+	notify()
+
+	select {
+	case m := <-chMsg:
+		if m.UserID != user.ID || m.Email == "" || m.Phone != "" {
+			t.Fatal("unexpected message: ", m)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for critical notification")
+	}
+}
+
+func TestStoreDistList(t *testing.T) {
+	nc, root, stop, err := server.TestServer()
+	if err != nil {
+		t.Fatal("Error starting test server: ", err)
+	}
+	defer stop()
+
+	list := data.NodeEdge{
+		ID:     uuid.New().String(),
+		Type:   data.NodeTypeDistList,
+		Parent: root.ID,
+		Points: data.Points{
+			{Type: data.PointTypeDescription, Text: "contractors"},
+			{Type: data.PointTypeEmail, Key: "0", Text: "a@example.com"},
+			{Type: data.PointTypeEmail, Key: "1", Text: "b@example.com"},
+			{Type: data.PointTypePhone, Key: "0", Text: "555-1234"},
+		},
+	}
+
+	if err := client.SendNode(nc, list, "test"); err != nil {
+		t.Fatal("Error sending list node: ", err)
+	}
+
+	chMsg := make(chan data.Message, 10)
+	sub, err := nc.Subscribe("node."+list.ID+".msg", func(msg *nats.Msg) {
+		m, err := data.PbDecodeMessage(msg.Data)
+		if err != nil {
+			t.Error("Error decoding message: ", err)
+			return
+		}
+		chMsg <- m
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Unsubscribe()
+
+	n := data.Notification{ID: uuid.New().String(), Message: "test"}
+	d, err := n.ToPb()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := nc.Publish("node."+root.ID+".not", d); err != nil {
+		t.Fatal(err)
+	}
+
+	var emails, phones []string
+	for i := 0; i < 3; i++ {
+		select {
+		case m := <-chMsg:
+			if m.Email != "" {
+				emails = append(emails, m.Email)
+			}
+			if m.Phone != "" {
+				phones = append(phones, m.Phone)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("timeout waiting for list messages")
+		}
+	}
+
+	if len(emails) != 2 || len(phones) != 1 || phones[0] != "555-1234" {
+		t.Fatal("unexpected list messages: ", emails, phones)
+	}
+}
+
+func TestStorePush(t *testing.T) {
+	nc, root, stop, err := server.TestServer()
+	if err != nil {
+		t.Fatal("Error starting test server: ", err)
+	}
+	defer stop()
+
+	nodes, err := client.GetNode(nc, root.ID, "")
+	if err != nil || len(nodes) < 1 {
+		t.Fatal("Error getting root node: ", err)
+	}
+
+	if key, _ := nodes[0].Points.Text(data.PointTypeVapidPublicKey, ""); key == "" {
+		t.Fatal("VAPID key not generated")
+	}
+
+	users, err := client.GetNodeChildren(nc, root.ID, data.NodeTypeUser, false, false)
+	if err != nil || len(users) < 1 {
+		t.Fatal("Error getting user: ", err)
+	}
+
+	user := users[0]
+
+	// the push service is gone after the first push
+	chPush := make(chan string, 10)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		chPush <- r.Header.Get("Content-Encoding")
+		w.WriteHeader(http.StatusGone)
+	}))
+	defer ts.Close()
+
+	uaKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var sub data.PushSubscription
+	sub.Endpoint = ts.URL + "/push"
+	sub.Keys.P256dh = base64.RawURLEncoding.EncodeToString(
+		elliptic.Marshal(elliptic.P256(), uaKey.X, uaKey.Y))
+	sub.Keys.Auth = base64.RawURLEncoding.EncodeToString([]byte("0123456789abcdef"))
+
+	p, err := sub.ToPoint()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := client.SendNodePoint(nc, user.ID, p, true); err != nil {
+		t.Fatal("Error sending subscription: ", err)
+	}
+
+	n := data.Notification{ID: uuid.New().String(), Message: "test"}
+	d, err := n.ToPb()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := nc.Publish("node."+root.ID+".not", d); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case enc := <-chPush:
+		if enc != "aes128gcm" {
+			t.Fatal("wrong content encoding: ", enc)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for push")
+	}
+
+	// the gone subscription is removed from the user
+	start := time.Now()
+	for {
+		nodes, err := client.GetNode(nc, user.ID, root.ID)
+		if err != nil || len(nodes) < 1 {
+			t.Fatal("Error getting user node: ", err)
+		}
+
+		if len(data.PushSubscriptions(nodes[0].Points)) == 0 {
+			break
+		}
+
+		if time.Since(start) > time.Second {
+			t.Fatal("gone subscription not removed")
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestStoreErase(t *testing.T) {
+	nc, root, stop, err := server.TestServer()
+	if err != nil {
+		t.Fatal("Error starting test server: ", err)
+	}
+
+	defer stop()
+
+	user := data.NodeEdge{
+		ID:     uuid.New().String(),
+		Type:   data.NodeTypeUser,
+		Parent: root.ID,
+		Points: data.Points{
+			{Type: data.PointTypeFirstName, Text: "Jane"},
+		},
+	}
+
+	child := data.NodeEdge{
+		ID:     uuid.New().String(),
+		Type:   data.NodeTypeDevice,
+		Parent: user.ID,
+		Points: data.Points{
+			{Type: data.PointTypeDescription, Text: "phone"},
+		},
+	}
+
+	dev := data.NodeEdge{
+		ID:     uuid.New().String(),
+		Type:   data.NodeTypeDevice,
+		Parent: root.ID,
+	}
+
+	for _, n := range []data.NodeEdge{user, child, dev} {
+		if err := client.SendNode(nc, n, "test"); err != nil {
+			t.Fatal("Error sending node: ", err)
+		}
+	}
+
+	// point set by the user on another node
+	err = client.SendNodePoint(nc, dev.ID, data.Point{Type: data.PointTypeDescription,
+		Text: "set by user", Origin: user.ID}, true)
+	if err != nil {
+		t.Fatal("Error sending point: ", err)
+	}
+
+	start := time.Now()
+
+	reports, err := client.EraseNode(nc, client.EraseRequest{NodeID: user.ID})
+	if err != nil {
+		t.Fatal("Error erasing node: ", err)
+	}
+
+	if time.Since(start) > 5*time.Second {
+		t.Error("erase waited for sources that do not exist")
+	}
+
+	if len(reports) != 1 || reports[0].Source != "store" {
+		t.Fatalf("wrong reports: %+v", reports)
+	}
+
+	r := reports[0]
+	if len(r.NodeIDs) != 2 || r.Nodes != 2 || r.Edges != 2 || r.Points == 0 ||
+		r.Anonymized == 0 {
+		t.Errorf("wrong store report: %+v", r)
+	}
+
+	for _, id := range []string{user.ID, child.ID} {
+		nodes, err := client.GetNode(nc, id, "all")
+		if err == nil && len(nodes) > 0 {
+			t.Errorf("node %v was not erased: %+v", id, nodes)
+		}
+	}
+
+	changes, err := client.GetNodeChanges(nc, dev.ID, client.ChangesQuery{})
+	if err != nil {
+		t.Fatal("Error getting changes: ", err)
+	}
+
+	for _, c := range changes {
+		if c.Origin == user.ID {
+			t.Error("change origin was not anonymized: ", c)
+		}
+	}
+
+	nodes, err := client.GetNode(nc, dev.ID, root.ID)
+	if err != nil || len(nodes) < 1 {
+		t.Fatal("Error getting node: ", err)
+	}
+
+	for _, p := range nodes[0].Points {
+		if p.Origin == user.ID {
+			t.Error("point origin was not anonymized: ", p)
+		}
+	}
+
+	_, err = client.EraseNode(nc, client.EraseRequest{NodeID: root.ID})
+	if err == nil {
+		t.Error("root node should not be erasable")
+	}
+}
@@ -0,0 +1,220 @@
+package store
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+
+	"github.com/nats-io/nats.go"
+	"github.com/simpleiot/simpleiot/client"
+	"github.com/simpleiot/simpleiot/data"
+	"github.com/simpleiot/simpleiot/internal/pb"
+	"google.golang.org/protobuf/proto"
+)
+
+// tagIndex indexes the tag points of all nodes so nodes can be found by tag
+// without scanning the store. The index is tag -> node ID -> tag value.
+type tagIndex struct {
+	lock sync.Mutex
+	tags map[string]map[string]string
+}
+
+func newTagIndex() *tagIndex {
+	return &tagIndex{tags: make(map[string]map[string]string)}
+}
+
+// update adds or removes the tag points of a node from the index
+func (ti *tagIndex) update(nodeID string, points data.Points) {
+	ti.lock.Lock()
+	defer ti.lock.Unlock()
+
+	for _, p := range points {
+		if p.Type != data.PointTypeTag || p.Key == "" {
+			continue
+		}
+
+		nodes := ti.tags[p.Key]
+
+		if p.Tombstone != 0 {
+			if nodes != nil {
+				delete(nodes, nodeID)
+				if len(nodes) == 0 {
+					delete(ti.tags, p.Key)
+				}
+			}
+			continue
+		}
+
+		if nodes == nil {
+			nodes = make(map[string]string)
+			ti.tags[p.Key] = nodes
+		}
+
+		nodes[nodeID] = p.Text
+	}
+}
+
+// remove removes all tags of a node from the index
+func (ti *tagIndex) remove(nodeID string) {
+	ti.lock.Lock()
+	defer ti.lock.Unlock()
+
+	for t, nodes := range ti.tags {
+		delete(nodes, nodeID)
+		if len(nodes) == 0 {
+			delete(ti.tags, t)
+		}
+	}
+}
+
+// find returns the IDs of nodes that have all of the tags. If a tag point
+// has text, the tag value must also match. IDs are sorted.
+func (ti *tagIndex) find(tags data.Points) []string {
+	ti.lock.Lock()
+	defer ti.lock.Unlock()
+
+	var ret []string
+
+	if len(tags) == 0 {
+		return ret
+	}
+
+	for id, value := range ti.tags[tags[0].Key] {
+		if tags[0].Text != "" && value != tags[0].Text {
+			continue
+		}
+
+		match := true
+		for _, t := range tags[1:] {
+			v, ok := ti.tags[t.Key][id]
+			if !ok || (t.Text != "" && v != t.Text) {
+				match = false
+				break
+			}
+		}
+
+		if match {
+			ret = append(ret, id)
+		}
+	}
+
+	sort.Strings(ret)
+
+	return ret
+}
+
+// list returns a tag point for each tag in use, sorted by tag name. The
+// value is the number of nodes with the tag.
+func (ti *tagIndex) list() data.Points {
+	ti.lock.Lock()
+	defer ti.lock.Unlock()
+
+	var ret data.Points
+	for t, nodes := range ti.tags {
+		ret = append(ret, data.Point{Type: data.PointTypeTag, Key: t,
+			Value: float64(len(nodes))})
+	}
+
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Key < ret[j].Key
+	})
+
+	return ret
+}
+
+// loadTags builds the tag index from the store
+func (st *Store) loadTags() error {
+	tags, err := st.db.pointsOfType(data.PointTypeTag)
+	if err != nil {
+		return err
+	}
+
+	for id, points := range tags {
+		st.tags.update(id, points)
+	}
+
+	return nil
+}
+
+// handleTagQuery finds nodes by tag. Request parameters are tag points:
+// the key is the tag name and the text, if set, is the tag value. Nodes
+// must match all tags. Deleted nodes are not returned.
+func (st *Store) handleTagQuery(msg *nats.Msg) {
+	resp := &pb.NodesRequest{}
+	var nodes data.Nodes
+
+	params, err := data.PbDecodePoints(msg.Data)
+	if err != nil {
+		resp.Error = fmt.Sprintf("Error decoding points %v", err)
+	}
+
+	var tags data.Points
+	for _, p := range params {
+		if p.Type == data.PointTypeTag && p.Key != "" {
+			tags = append(tags, p)
+		}
+	}
+
+	if resp.Error == "" && len(tags) == 0 {
+		resp.Error = "no tags in query"
+	}
+
+	if resp.Error == "" {
+		for _, id := range st.tags.find(tags) {
+			if st.nodeDeleted(id) {
+				continue
+			}
+
+			ne, err := st.db.nodeEdge(id, "none")
+			if err != nil {
+				log.Printf("Tag query, error getting node %v: %v\n", id, err)
+				continue
+			}
+
+			nodes = append(nodes, ne...)
+		}
+	}
+
+	resp.Nodes, err = nodes.ToPbNodes()
+	if err != nil {
+		resp.Error = fmt.Sprintf("Error pb encoding nodes: %v", err)
+	}
+
+	d, err := proto.Marshal(resp)
+	if err != nil {
+		log.Println("Error encoding tag query response: ", err)
+		return
+	}
+
+	err = client.Respond(st.nc, msg, d)
+	if err != nil {
+		log.Println("NATS: Error publishing response to tag query: ", err)
+	}
+}
+
+// handleTagList returns a tag point for each tag in use. The point value is
+// the number of nodes with the tag, which may include deleted nodes.
+func (st *Store) handleTagList(msg *nats.Msg) {
+	resp := &pb.PointsRequest{}
+
+	for _, p := range st.tags.list() {
+		pPb, err := p.ToPb()
+		if err != nil {
+			resp.Error = fmt.Sprintf("Error pb encoding points: %v", err)
+			break
+		}
+		resp.Points = append(resp.Points, &pPb)
+	}
+
+	d, err := proto.Marshal(resp)
+	if err != nil {
+		log.Println("Error encoding tag list: ", err)
+		return
+	}
+
+	err = st.nc.Publish(msg.Reply, d)
+	if err != nil {
+		log.Println("NATS: Error publishing response to tag list: ", err)
+	}
+}
@@ -0,0 +1,52 @@
+package store
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/simpleiot/simpleiot/data"
+)
+
+func TestTagIndex(t *testing.T) {
+	ti := newTagIndex()
+
+	ti.update("n1", data.Points{
+		{Type: data.PointTypeTag, Key: "customer", Text: "acme", Value: 1},
+		{Type: data.PointTypeTag, Key: "hwRevB", Value: 1},
+		{Type: data.PointTypeValue, Key: "customer", Text: "ignored"},
+	})
+	ti.update("n2", data.Points{
+		{Type: data.PointTypeTag, Key: "customer", Text: "globex", Value: 1},
+		{Type: data.PointTypeTag, Key: "hwRevB", Value: 1},
+	})
+
+	tests := []struct {
+		tags data.Points
+		exp  []string
+	}{
+		{data.Points{{Key: "customer"}}, []string{"n1", "n2"}},
+		{data.Points{{Key: "customer", Text: "acme"}}, []string{"n1"}},
+		{data.Points{{Key: "hwRevB"}, {Key: "customer", Text: "globex"}}, []string{"n2"}},
+		{data.Points{{Key: "region"}}, nil},
+		{nil, nil},
+	}
+
+	for _, test := range tests {
+		got := ti.find(test.tags)
+		if !reflect.DeepEqual(got, test.exp) {
+			t.Errorf("find %v: expected %v, got %v", test.tags, test.exp, got)
+		}
+	}
+
+	ti.update("n1", data.Points{{Type: data.PointTypeTag, Key: "hwRevB", Tombstone: 1}})
+
+	if got := ti.find(data.Points{{Key: "hwRevB"}}); !reflect.DeepEqual(got, []string{"n2"}) {
+		t.Error("tag not removed: ", got)
+	}
+
+	list := ti.list()
+	if len(list) != 2 || list[0].Key != "customer" || list[0].Value != 2 ||
+		list[1].Key != "hwRevB" || list[1].Value != 1 {
+		t.Error("wrong tag list: ", list)
+	}
+}
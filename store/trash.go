@@ -0,0 +1,249 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/nats-io/nats.go"
+	"github.com/simpleiot/simpleiot/client"
+	"github.com/simpleiot/simpleiot/data"
+	"github.com/simpleiot/simpleiot/internal/pb"
+	"google.golang.org/protobuf/proto"
+)
+
+// trash returns the deleted descendants of a node. Only the top of each
+// deleted subtree is returned as the children of a deleted node are not
+// deleted themselves. The tombstone edge point records when and by whom
+// the node was deleted.
+func (sdb *DbSqlite) trash(id string) ([]data.NodeEdge, error) {
+	var ret []data.NodeEdge
+	visited := make(map[string]bool)
+
+	var walk func(id string) error
+	walk = func(id string) error {
+		if visited[id] {
+			return nil
+		}
+		visited[id] = true
+
+		children, err := sdb.children(id, "", true)
+		if err != nil {
+			return err
+		}
+
+		for _, c := range children {
+			if tombstone, _ := c.IsTombstone(); tombstone {
+				ret = append(ret, c)
+				continue
+			}
+
+			if err := walk(c.ID); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	return ret, walk(id)
+}
+
+// purge permanently removes a deleted edge. The node and its descendants
+// are also removed if they are not referenced by any other edge. IDs of
+// removed nodes are returned.
+func (sdb *DbSqlite) purge(id, parent string) ([]string, RetentionStats, error) {
+	var stats RetentionStats
+	var purged []string
+
+	tx, err := sdb.db.Begin()
+	if err != nil {
+		return nil, stats, err
+	}
+
+	exec := func(count *int64, query string, args ...any) error {
+		res, err := tx.Exec(query, args...)
+		if err != nil {
+			return err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		*count += n
+		return nil
+	}
+
+	deleteEdge := func(edgeID string) error {
+		err := exec(&stats.Points, `DELETE FROM edge_points WHERE edge_id=?`, edgeID)
+		if err != nil {
+			return err
+		}
+		return exec(&stats.Edges, `DELETE FROM edges WHERE id=?`, edgeID)
+	}
+
+	err = func() error {
+		var edgeID string
+		err := tx.QueryRow(`SELECT id FROM edges WHERE up=? AND down=?`,
+			parent, id).Scan(&edgeID)
+		if err == sql.ErrNoRows {
+			return data.ErrDocumentNotFound
+		} else if err != nil {
+			return err
+		}
+
+		var deleted int
+		err = tx.QueryRow(`SELECT COUNT(*) FROM edge_points WHERE edge_id=?
+			AND type=? AND value!=0`, edgeID, data.PointTypeTombstone).Scan(&deleted)
+		if err != nil {
+			return err
+		}
+
+		if deleted == 0 {
+			return errors.New("node must be deleted before it can be purged")
+		}
+
+		if err := deleteEdge(edgeID); err != nil {
+			return err
+		}
+
+		queue := []string{id}
+		for len(queue) > 0 {
+			n := queue[0]
+			queue = queue[1:]
+
+			var refs int
+			err := tx.QueryRow(`SELECT COUNT(*) FROM edges WHERE down=?`, n).Scan(&refs)
+			if err != nil {
+				return err
+			}
+
+			if refs > 0 {
+				// node still has other parents
+				continue
+			}
+
+			err = exec(&stats.Points, `DELETE FROM node_points WHERE node_id=?`, n)
+			if err != nil {
+				return err
+			}
+
+			var changes int64
+			err = exec(&changes, `DELETE FROM point_changes WHERE node_id=?`, n)
+			if err != nil {
+				return err
+			}
+
+			stats.Nodes++
+			purged = append(purged, n)
+
+			rows, err := tx.Query(`SELECT id, down FROM edges WHERE up=?`, n)
+			if err != nil {
+				return err
+			}
+
+			var edgeIDs []string
+			for rows.Next() {
+				var eID, down string
+				if err := rows.Scan(&eID, &down); err != nil {
+					rows.Close()
+					return err
+				}
+				edgeIDs = append(edgeIDs, eID)
+				queue = append(queue, down)
+			}
+			rows.Close()
+
+			for _, eID := range edgeIDs {
+				if err := deleteEdge(eID); err != nil {
+					return err
+				}
+			}
+		}
+
+		return nil
+	}()
+
+	if err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil && rbErr != sql.ErrTxDone {
+			log.Println("Rollback error: ", rbErr)
+		}
+		return nil, RetentionStats{}, err
+	}
+
+	return purged, stats, tx.Commit()
+}
+
+// handleNodeTrash returns the deleted descendants of a node
+func (st *Store) handleNodeTrash(msg *nats.Msg) {
+	resp := &pb.NodesRequest{}
+	var nodes data.Nodes
+
+	id, _, err := client.DecodeNodePointsMsg(msg)
+	if err != nil {
+		resp.Error = fmt.Sprintf("Error decoding trash request: %v", err)
+	} else {
+		if id == "root" {
+			id = st.db.rootNodeID()
+		}
+
+		nodes, err = st.db.trash(id)
+		if err != nil {
+			resp.Error = fmt.Sprintf("Error getting trash for %v: %v", id, err)
+		}
+	}
+
+	resp.Nodes, err = nodes.ToPbNodes()
+	if err != nil {
+		resp.Error = fmt.Sprintf("Error pb encoding nodes: %v", err)
+	}
+
+	d, err := proto.Marshal(resp)
+	if err != nil {
+		log.Println("Error encoding trash response: ", err)
+		return
+	}
+
+	err = client.Respond(st.nc, msg, d)
+	if err != nil {
+		log.Println("NATS: Error publishing response to trash request: ", err)
+	}
+}
+
+// handleNodePurge permanently removes a deleted node. The request must
+// contain an id point with the node ID to confirm the purge.
+func (st *Store) handleNodePurge(msg *nats.Msg) {
+	nodeID, parentID, points, err := client.DecodeEdgePointsMsg(msg)
+	if err != nil {
+		st.reply(msg.Reply, errors.New("error decoding purge subject"))
+		return
+	}
+
+	confirmed := false
+	for _, p := range points {
+		if p.Type == data.PointTypeID && p.Text == nodeID {
+			confirmed = true
+		}
+	}
+
+	if !confirmed {
+		st.reply(msg.Reply, errors.New("purge not confirmed"))
+		return
+	}
+
+	purged, stats, err := st.db.purge(nodeID, parentID)
+	if err != nil {
+		st.reply(msg.Reply, err)
+		return
+	}
+
+	for _, id := range purged {
+		st.tags.remove(id)
+	}
+
+	log.Printf("Store purged %v from %v: %v points, %v edges, %v nodes\n",
+		nodeID, parentID, stats.Points, stats.Edges, stats.Nodes)
+
+	st.reply(msg.Reply, nil)
+}
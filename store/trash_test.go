@@ -0,0 +1,91 @@
+package store
+
+import (
+	"testing"
+
+	"github.com/simpleiot/simpleiot/data"
+)
+
+func TestDbSqliteTrash(t *testing.T) {
+	db := newTestDb(t)
+	defer db.Close()
+
+	rootID := db.rootNodeID()
+
+	// root
+	// - group (deleted)
+	//   - child
+	//   - shared
+	// - live
+	//   - shared
+	//   - variable (deleted)
+	nodes := []struct {
+		id, parent, typ string
+		deleted         bool
+	}{
+		{"group", rootID, data.NodeTypeGroup, true},
+		{"child", "group", data.NodeTypeVariable, false},
+		{"shared", "group", data.NodeTypeVariable, false},
+		{"live", rootID, data.NodeTypeGroup, false},
+		{"shared", "live", data.NodeTypeVariable, false},
+		{"variable", "live", data.NodeTypeVariable, true},
+	}
+
+	for _, n := range nodes {
+		err := db.nodePoints(n.id, data.Points{{Type: data.PointTypeNodeType, Text: n.typ}})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = db.edgePoints(n.id, n.parent, data.Points{{Type: data.PointTypeTombstone,
+			Value: data.BoolToFloat(n.deleted), Origin: "user"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	trash, err := db.trash(rootID)
+	if err != nil {
+		t.Fatal("Error getting trash: ", err)
+	}
+
+	if len(trash) != 2 || trash[0].ID != "group" || trash[1].ID != "variable" {
+		t.Fatal("wrong trash: ", trash)
+	}
+
+	p, _ := trash[0].EdgePoints.Find(data.PointTypeTombstone, "")
+	if p.Origin != "user" {
+		t.Error("tombstone origin not returned: ", p)
+	}
+
+	_, _, err = db.purge("live", rootID)
+	if err == nil {
+		t.Fatal("purge of a node that is not deleted should fail")
+	}
+
+	purged, stats, err := db.purge("group", rootID)
+	if err != nil {
+		t.Fatal("Error purging: ", err)
+	}
+
+	if len(purged) != 2 || stats.Nodes != 2 || stats.Edges != 3 {
+		t.Fatalf("wrong purge result: %v, %+v", purged, stats)
+	}
+
+	if _, err := db.node("child"); err == nil {
+		t.Error("child was not purged")
+	}
+
+	if _, err := db.node("shared"); err != nil {
+		t.Error("shared node was purged: ", err)
+	}
+
+	trash, err = db.trash(rootID)
+	if err != nil {
+		t.Fatal("Error getting trash: ", err)
+	}
+
+	if len(trash) != 1 || trash[0].ID != "variable" {
+		t.Fatal("wrong trash after purge: ", trash)
+	}
+}
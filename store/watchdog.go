@@ -0,0 +1,155 @@
+package store
+
+import (
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/simpleiot/simpleiot/data"
+)
+
+// overloadCheckPeriod is how often subscription pending counts are checked
+var overloadCheckPeriod = time.Second
+
+// watchdog detects store handlers that take longer than the slow threshold,
+// and subscriptions with more pending messages than the pending limit. While
+// the store is overloaded, low priority node points are shed so the store
+// catches up instead of silently falling behind until NATS drops messages.
+// Points are shed if they are metrics, or if they have the same value as
+// the last point received for the node point during the overload.
+type watchdog struct {
+	slow         time.Duration
+	pendingLimit int
+
+	lock       sync.Mutex
+	overloaded bool
+	slowCount  int64
+	slowLogged map[string]bool
+	shedCount  int64
+	last       map[string]data.Point
+}
+
+func newWatchdog(slow time.Duration, pendingLimit int) *watchdog {
+	return &watchdog{
+		slow:         slow,
+		pendingLimit: pendingLimit,
+		slowLogged:   make(map[string]bool),
+		last:         make(map[string]data.Point),
+	}
+}
+
+// wrap times a handler. Slow handlers are counted, and logged once per
+// subject per report period.
+func (w *watchdog) wrap(subject string, h nats.MsgHandler) nats.MsgHandler {
+	if w.slow <= 0 {
+		return h
+	}
+
+	return func(msg *nats.Msg) {
+		start := time.Now()
+		h(msg)
+		d := time.Since(start)
+		if d <= w.slow {
+			return
+		}
+
+		w.lock.Lock()
+		w.slowCount++
+		logged := w.slowLogged[subject]
+		w.slowLogged[subject] = true
+		w.lock.Unlock()
+
+		if !logged {
+			log.Printf("Store: slow handler for %v: %v\n", msg.Subject, d)
+		}
+	}
+}
+
+// check updates the overload state from the subscription pending counts and
+// returns true if it changed. The store is overloaded when any subscription
+// has more than pendingLimit messages pending, and recovers when all have
+// less than half of that.
+func (w *watchdog) check(pending map[string]int) bool {
+	if w.pendingLimit <= 0 {
+		return false
+	}
+
+	max := 0
+	for _, p := range pending {
+		if p > max {
+			max = p
+		}
+	}
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	switch {
+	case !w.overloaded && max > w.pendingLimit:
+		w.overloaded = true
+	case w.overloaded && max < w.pendingLimit/2:
+		w.overloaded = false
+		w.last = make(map[string]data.Point)
+	default:
+		return false
+	}
+
+	return true
+}
+
+func (w *watchdog) isOverloaded() bool {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.overloaded
+}
+
+// shed returns the node points that should be written. All points are
+// returned unless the store is overloaded.
+func (w *watchdog) shed(nodeID string, points data.Points) data.Points {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if !w.overloaded {
+		return points
+	}
+
+	ret := make(data.Points, 0, len(points))
+
+	for _, p := range points {
+		if strings.HasPrefix(p.Type, "metric") {
+			w.shedCount++
+			continue
+		}
+
+		k := nodeID + "." + p.Type + "." + p.Key
+		if last, ok := w.last[k]; ok && samePoint(last, p) {
+			w.shedCount++
+			continue
+		}
+
+		w.last[k] = p
+		ret = append(ret, p)
+	}
+
+	return ret
+}
+
+// report returns the number of slow handler calls and shed points since the
+// last report
+func (w *watchdog) report(now time.Time) data.Points {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	ret := data.Points{
+		{Time: now, Type: data.PointTypeStoreSlowHandlers, Value: float64(w.slowCount)},
+		{Time: now, Type: data.PointTypeStoreShedPoints, Value: float64(w.shedCount)},
+	}
+
+	w.slowCount = 0
+	w.shedCount = 0
+	w.slowLogged = make(map[string]bool)
+
+	return ret
+}
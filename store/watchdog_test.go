@@ -0,0 +1,85 @@
+package store
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/simpleiot/simpleiot/data"
+)
+
+func TestWatchdogSlowHandler(t *testing.T) {
+	w := newWatchdog(5*time.Millisecond, 0)
+
+	delay := time.Duration(0)
+	h := w.wrap("node.*.points", func(msg *nats.Msg) {
+		time.Sleep(delay)
+	})
+
+	h(&nats.Msg{Subject: "node.n1.points"})
+	delay = 10 * time.Millisecond
+	h(&nats.Msg{Subject: "node.n1.points"})
+	h(&nats.Msg{Subject: "node.n1.points"})
+
+	points := w.report(time.Now())
+	p, _ := points.Find(data.PointTypeStoreSlowHandlers, "")
+	if p.Value != 2 {
+		t.Error("expected 2 slow handlers, got: ", p.Value)
+	}
+
+	points = w.report(time.Now())
+	p, _ = points.Find(data.PointTypeStoreSlowHandlers, "")
+	if p.Value != 0 {
+		t.Error("slow handlers not reset after report: ", p.Value)
+	}
+}
+
+func TestWatchdogOverload(t *testing.T) {
+	w := newWatchdog(0, 100)
+
+	points := data.Points{
+		{Type: data.PointTypeValue, Value: 1},
+		{Type: data.PointTypeMetricNatsCycleNodePoint, Value: 2},
+	}
+
+	if len(w.shed("n1", points)) != 2 {
+		t.Error("points shed when not overloaded")
+	}
+
+	if w.check(map[string]int{"nodePoints": 50}) {
+		t.Error("overloaded below limit")
+	}
+
+	if !w.check(map[string]int{"nodePoints": 50, "edgePoints": 150}) || !w.isOverloaded() {
+		t.Fatal("not overloaded above limit")
+	}
+
+	// metrics are shed, and repeated values after the first
+	if got := w.shed("n1", points); len(got) != 1 || got[0].Type != data.PointTypeValue {
+		t.Error("expected only the value point, got: ", got)
+	}
+
+	if got := w.shed("n1", points); len(got) != 0 {
+		t.Error("repeated value not shed: ", got)
+	}
+
+	points[0].Value = 3
+	if got := w.shed("n1", points); len(got) != 1 {
+		t.Error("changed value shed: ", got)
+	}
+
+	// recovery needs pending below half of the limit
+	if w.check(map[string]int{"edgePoints": 60}) {
+		t.Error("recovered above half of the limit")
+	}
+
+	if !w.check(map[string]int{"edgePoints": 40}) || w.isOverloaded() {
+		t.Error("did not recover")
+	}
+
+	report := w.report(time.Now())
+	p, _ := report.Find(data.PointTypeStoreShedPoints, "")
+	if p.Value != 4 {
+		t.Error("expected 4 shed points, got: ", p.Value)
+	}
+}
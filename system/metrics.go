@@ -0,0 +1,170 @@
+package system
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrNotSupported is returned by metrics functions that are not supported
+// on the current OS.
+var ErrNotSupported = errors.New("not supported on this OS")
+
+// DiskUsage contains usage stats for a mounted filesystem
+type DiskUsage struct {
+	Mount string
+	Total uint64
+	Free  uint64
+}
+
+// UsedPercent returns the percentage of the filesystem that is used
+func (du DiskUsage) UsedPercent() float64 {
+	if du.Total == 0 {
+		return 0
+	}
+	return float64(du.Total-du.Free) / float64(du.Total) * 100
+}
+
+// NetStats contains counters for a network interface
+type NetStats struct {
+	Interface string
+	RxBytes   uint64
+	TxBytes   uint64
+	RxErrors  uint64
+	TxErrors  uint64
+}
+
+// Temperature is a reading from a temperature sensor in degrees C
+type Temperature struct {
+	Sensor string
+	Value  float64
+}
+
+// ARPEntry is a resolved entry in the kernel ARP table
+type ARPEntry struct {
+	IP        string
+	MAC       string
+	Interface string
+}
+
+// pseudo filesystems that are not interesting for disk usage
+var ignoredFsTypes = map[string]bool{
+	"proc": true, "sysfs": true, "devtmpfs": true, "devpts": true,
+	"tmpfs": true, "cgroup": true, "cgroup2": true, "securityfs": true,
+	"pstore": true, "debugfs": true, "tracefs": true, "configfs": true,
+	"mqueue": true, "hugetlbfs": true, "fusectl": true, "bpf": true,
+	"autofs": true, "binfmt_misc": true, "overlay": true, "squashfs": true,
+	"nsfs": true, "ramfs": true, "rpc_pipefs": true, "efivarfs": true,
+}
+
+// parseMounts returns the mount points of real filesystems in /proc/mounts
+// format data.
+func parseMounts(d []byte) []string {
+	var ret []string
+	seen := make(map[string]bool)
+
+	s := bufio.NewScanner(bytes.NewReader(d))
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) < 3 {
+			continue
+		}
+
+		mount, fsType := fields[1], fields[2]
+		if ignoredFsTypes[fsType] || seen[mount] {
+			continue
+		}
+
+		seen[mount] = true
+		ret = append(ret, mount)
+	}
+
+	return ret
+}
+
+// parseNetDev parses /proc/net/dev format data. The loopback interface is
+// skipped.
+func parseNetDev(d []byte) ([]NetStats, error) {
+	var ret []NetStats
+
+	s := bufio.NewScanner(bytes.NewReader(d))
+	for s.Scan() {
+		line := s.Text()
+		i := strings.Index(line, ":")
+		if i < 0 {
+			// header lines
+			continue
+		}
+
+		iface := strings.TrimSpace(line[:i])
+		if iface == "lo" {
+			continue
+		}
+
+		fields := strings.Fields(line[i+1:])
+		if len(fields) < 16 {
+			return nil, errors.New("net dev line does not have enough fields")
+		}
+
+		var vals [4]uint64
+		for j, f := range []int{0, 8, 2, 10} {
+			v, err := strconv.ParseUint(fields[f], 10, 64)
+			if err != nil {
+				return nil, err
+			}
+			vals[j] = v
+		}
+
+		ret = append(ret, NetStats{
+			Interface: iface,
+			RxBytes:   vals[0],
+			TxBytes:   vals[1],
+			RxErrors:  vals[2],
+			TxErrors:  vals[3],
+		})
+	}
+
+	return ret, nil
+}
+
+// parseUptime parses /proc/uptime format data
+func parseUptime(d []byte) (time.Duration, error) {
+	fields := strings.Fields(string(d))
+	if len(fields) < 1 {
+		return 0, errors.New("uptime data is empty")
+	}
+
+	secs, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return 0, err
+	}
+
+	return time.Duration(secs * float64(time.Second)), nil
+}
+
+// parseARP parses /proc/net/arp format data. Incomplete entries are skipped.
+func parseARP(d []byte) []ARPEntry {
+	var ret []ARPEntry
+
+	s := bufio.NewScanner(bytes.NewReader(d))
+	for s.Scan() {
+		// IP address, HW type, Flags, HW address, Mask, Device
+		fields := strings.Fields(s.Text())
+		if len(fields) < 6 || fields[0] == "IP" {
+			continue
+		}
+
+		flags, err := strconv.ParseUint(strings.TrimPrefix(fields[2], "0x"), 16, 32)
+		// 0x2 is ATF_COM, the entry is complete
+		if err != nil || flags&0x2 == 0 || fields[3] == "00:00:00:00:00:00" {
+			continue
+		}
+
+		ret = append(ret, ARPEntry{IP: fields[0], MAC: fields[3], Interface: fields[5]})
+	}
+
+	return ret
+}
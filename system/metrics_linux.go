@@ -0,0 +1,100 @@
+package system
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// ReadDiskUsage returns usage for all mounted filesystems, excluding
+// pseudo filesystems like proc and tmpfs.
+func ReadDiskUsage() ([]DiskUsage, error) {
+	d, err := os.ReadFile("/proc/mounts")
+	if err != nil {
+		return nil, err
+	}
+
+	var ret []DiskUsage
+
+	for _, m := range parseMounts(d) {
+		var st syscall.Statfs_t
+		err := syscall.Statfs(m, &st)
+		if err != nil {
+			// mounts can disappear or be inaccessible, skip them
+			continue
+		}
+
+		ret = append(ret, DiskUsage{
+			Mount: m,
+			Total: st.Blocks * uint64(st.Bsize),
+			Free:  st.Bavail * uint64(st.Bsize),
+		})
+	}
+
+	return ret, nil
+}
+
+// ReadNetStats returns counters for all network interfaces except loopback
+func ReadNetStats() ([]NetStats, error) {
+	d, err := os.ReadFile("/proc/net/dev")
+	if err != nil {
+		return nil, err
+	}
+
+	return parseNetDev(d)
+}
+
+// ReadTemperatures returns readings from the kernel thermal zones
+func ReadTemperatures() ([]Temperature, error) {
+	zones, err := filepath.Glob("/sys/class/thermal/thermal_zone*")
+	if err != nil {
+		return nil, err
+	}
+
+	var ret []Temperature
+
+	for _, z := range zones {
+		t, err := os.ReadFile(filepath.Join(z, "temp"))
+		if err != nil {
+			continue
+		}
+
+		milliC, err := strconv.Atoi(strings.TrimSpace(string(t)))
+		if err != nil {
+			continue
+		}
+
+		sensor := filepath.Base(z)
+		typ, err := os.ReadFile(filepath.Join(z, "type"))
+		if err == nil {
+			sensor = strings.TrimSpace(string(typ))
+		}
+
+		ret = append(ret, Temperature{Sensor: sensor, Value: float64(milliC) / 1000})
+	}
+
+	return ret, nil
+}
+
+// ReadUptime returns the system uptime
+func ReadUptime() (time.Duration, error) {
+	d, err := os.ReadFile("/proc/uptime")
+	if err != nil {
+		return 0, err
+	}
+
+	return parseUptime(d)
+}
+
+// ReadARPTable returns the resolved entries in the kernel ARP table
+func ReadARPTable() ([]ARPEntry, error) {
+	d, err := os.ReadFile("/proc/net/arp")
+	if err != nil {
+		return nil, err
+	}
+
+	return parseARP(d), nil
+}
@@ -0,0 +1,30 @@
+//go:build !linux
+
+package system
+
+import "time"
+
+// ReadDiskUsage returns usage for all mounted filesystems
+func ReadDiskUsage() ([]DiskUsage, error) {
+	return nil, ErrNotSupported
+}
+
+// ReadNetStats returns counters for all network interfaces except loopback
+func ReadNetStats() ([]NetStats, error) {
+	return nil, ErrNotSupported
+}
+
+// ReadTemperatures returns readings from temperature sensors
+func ReadTemperatures() ([]Temperature, error) {
+	return nil, ErrNotSupported
+}
+
+// ReadUptime returns the system uptime
+func ReadUptime() (time.Duration, error) {
+	return 0, ErrNotSupported
+}
+
+// ReadARPTable returns the resolved entries in the ARP table
+func ReadARPTable() ([]ARPEntry, error) {
+	return nil, ErrNotSupported
+}
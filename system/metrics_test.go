@@ -0,0 +1,78 @@
+package system
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseMounts(t *testing.T) {
+	d := []byte(`/dev/sda1 / ext4 rw,relatime 0 0
+proc /proc proc rw,nosuid 0 0
+tmpfs /run tmpfs rw,nosuid 0 0
+/dev/sdb1 /data ext4 rw 0 0
+/dev/sdb1 /data ext4 rw 0 0
+`)
+
+	exp := []string{"/", "/data"}
+	got := parseMounts(d)
+	if !reflect.DeepEqual(exp, got) {
+		t.Errorf("Expected %v, got %v", exp, got)
+	}
+}
+
+func TestParseNetDev(t *testing.T) {
+	d := []byte(`Inter-|   Receive                                                |  Transmit
+ face |bytes    packets errs drop fifo frame compressed multicast|bytes    packets errs drop fifo colls carrier compressed
+    lo:    1000      10    0    0    0     0          0         0     1000      10    0    0    0     0       0          0
+  eth0: 123456     100    2    0    0     0          0         0    65432      90    1    0    0     0       0          0
+`)
+
+	got, err := parseNetDev(d)
+	if err != nil {
+		t.Fatal("Error parsing: ", err)
+	}
+
+	exp := []NetStats{{Interface: "eth0", RxBytes: 123456, TxBytes: 65432,
+		RxErrors: 2, TxErrors: 1}}
+
+	if !reflect.DeepEqual(exp, got) {
+		t.Errorf("Expected %+v, got %+v", exp, got)
+	}
+}
+
+func TestParseUptime(t *testing.T) {
+	got, err := parseUptime([]byte("3600.50 7000.00\n"))
+	if err != nil {
+		t.Fatal("Error parsing: ", err)
+	}
+
+	if got != 3600*time.Second+500*time.Millisecond {
+		t.Error("Wrong uptime: ", got)
+	}
+}
+
+func TestDiskUsedPercent(t *testing.T) {
+	du := DiskUsage{Total: 200, Free: 50}
+	if du.UsedPercent() != 75 {
+		t.Error("Wrong used percent: ", du.UsedPercent())
+	}
+}
+
+func TestParseARP(t *testing.T) {
+	d := []byte(`IP address       HW type     Flags       HW address            Mask     Device
+192.168.1.1      0x1         0x2         b8:27:eb:00:00:01     *        eth0
+192.168.1.20     0x1         0x0         00:00:00:00:00:00     *        eth0
+192.168.1.30     0x1         0x6         b8:27:eb:00:00:02     *        wlan0
+`)
+
+	exp := []ARPEntry{
+		{IP: "192.168.1.1", MAC: "b8:27:eb:00:00:01", Interface: "eth0"},
+		{IP: "192.168.1.30", MAC: "b8:27:eb:00:00:02", Interface: "wlan0"},
+	}
+
+	got := parseARP(d)
+	if !reflect.DeepEqual(exp, got) {
+		t.Errorf("Expected %+v, got %+v", exp, got)
+	}
+}
@@ -0,0 +1,74 @@
+package system
+
+import (
+	"errors"
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// SdNotify sends a state notification to systemd (see sd_notify(3)). Common
+// states are "READY=1", "WATCHDOG=1", and "STOPPING=1". If SIOT is not
+// running under systemd (NOTIFY_SOCKET is not set), false is returned with no
+// error.
+func SdNotify(state string) (bool, error) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return false, nil
+	}
+
+	// a leading @ is an abstract socket
+	if socket[0] == '@' {
+		socket = "\x00" + socket[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil,
+		&net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+
+	_, err = conn.Write([]byte(state))
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// SdWatchdogInterval returns the systemd watchdog interval if the watchdog is
+// enabled for this process (WatchdogSec= in the service file). Zero is
+// returned if the watchdog is not enabled. Notifications should be sent at
+// about half this interval.
+func SdWatchdogInterval() (time.Duration, error) {
+	usecS := os.Getenv("WATCHDOG_USEC")
+	if usecS == "" {
+		return 0, nil
+	}
+
+	usec, err := strconv.Atoi(usecS)
+	if err != nil {
+		return 0, errors.New("WATCHDOG_USEC is not valid")
+	}
+
+	if usec <= 0 {
+		return 0, errors.New("WATCHDOG_USEC must be positive")
+	}
+
+	pidS := os.Getenv("WATCHDOG_PID")
+	if pidS != "" {
+		pid, err := strconv.Atoi(pidS)
+		if err != nil {
+			return 0, errors.New("WATCHDOG_PID is not valid")
+		}
+
+		if pid != os.Getpid() {
+			// watchdog is for another process
+			return 0, nil
+		}
+	}
+
+	return time.Duration(usec) * time.Microsecond, nil
+}
@@ -0,0 +1,67 @@
+//go:build !windows
+
+package system
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestSdNotify(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", "")
+
+	sent, err := SdNotify("READY=1")
+	if sent || err != nil {
+		t.Error("Expected no notification without NOTIFY_SOCKET")
+	}
+
+	socket := filepath.Join(t.TempDir(), "notify")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		t.Fatal("Error creating socket: ", err)
+	}
+	defer conn.Close()
+
+	t.Setenv("NOTIFY_SOCKET", socket)
+
+	sent, err = SdNotify("READY=1")
+	if !sent || err != nil {
+		t.Fatal("Error sending notification: ", err)
+	}
+
+	buf := make([]byte, 64)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal("Error reading notification: ", err)
+	}
+
+	if string(buf[:n]) != "READY=1" {
+		t.Error("Wrong notification: ", string(buf[:n]))
+	}
+}
+
+func TestSdWatchdogInterval(t *testing.T) {
+	t.Setenv("WATCHDOG_USEC", "")
+	i, err := SdWatchdogInterval()
+	if i != 0 || err != nil {
+		t.Error("Expected watchdog to be disabled")
+	}
+
+	t.Setenv("WATCHDOG_USEC", "2000000")
+	t.Setenv("WATCHDOG_PID", strconv.Itoa(os.Getpid()))
+	i, err = SdWatchdogInterval()
+	if i != 2*time.Second || err != nil {
+		t.Error("Wrong watchdog interval: ", i, err)
+	}
+
+	t.Setenv("WATCHDOG_PID", strconv.Itoa(os.Getpid()+1))
+	i, _ = SdWatchdogInterval()
+	if i != 0 {
+		t.Error("Watchdog for another pid should be disabled")
+	}
+}
@@ -0,0 +1,88 @@
+package test
+
+import "sync"
+
+// Fault is an error a fake device or server injects into its response to a
+// request. Faults are queued with Inject and each request uses the next
+// fault in the queue, so tests can exercise error paths deterministically.
+type Fault int
+
+// Faults that can be injected
+const (
+	// FaultNone sends a normal response
+	FaultNone Fault = iota
+	// FaultTimeout does not respond to the request
+	FaultTimeout
+	// FaultCRC corrupts the response, so the checksum does not match
+	FaultCRC
+	// FaultPartial sends only the first half of the response
+	FaultPartial
+	// FaultException sends an error response (a Modbus exception, or a
+	// refused MQTT connection)
+	FaultException
+	// FaultDisconnect closes the connection instead of responding
+	FaultDisconnect
+)
+
+func (f Fault) String() string {
+	switch f {
+	case FaultNone:
+		return "none"
+	case FaultTimeout:
+		return "timeout"
+	case FaultCRC:
+		return "crc"
+	case FaultPartial:
+		return "partial"
+	case FaultException:
+		return "exception"
+	case FaultDisconnect:
+		return "disconnect"
+	default:
+		return "unknown"
+	}
+}
+
+// faults is a queue of faults shared by the fakes
+type faults struct {
+	lock  sync.Mutex
+	queue []Fault
+}
+
+// Inject queues faults for the next requests
+func (f *faults) Inject(faults ...Fault) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.queue = append(f.queue, faults...)
+}
+
+// next returns the fault for the next request
+func (f *faults) next() Fault {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if len(f.queue) < 1 {
+		return FaultNone
+	}
+
+	ret := f.queue[0]
+	f.queue = f.queue[1:]
+	return ret
+}
+
+// apply applies a fault to a response. nil is returned if no response
+// should be sent.
+func (f Fault) apply(resp []byte) []byte {
+	switch f {
+	case FaultTimeout, FaultDisconnect:
+		return nil
+	case FaultCRC:
+		ret := append([]byte{}, resp...)
+		ret[len(ret)-1] ^= 0xff
+		return ret
+	case FaultPartial:
+		return resp[:len(resp)/2]
+	}
+
+	return resp
+}
@@ -0,0 +1,139 @@
+package test
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"sync"
+)
+
+// ModbusTCPServer is a fake Modbus TCP server for testing Modbus clients.
+// Faults can be injected into its responses.
+type ModbusTCPServer struct {
+	faults
+	// Regs are the registers of the server
+	Regs *ModbusRegs
+
+	listener net.Listener
+	lock     sync.Mutex
+	conns    map[net.Conn]struct{}
+	requests int
+	wg       sync.WaitGroup
+}
+
+// NewModbusTCPServer starts a fake Modbus TCP server on a random local port
+func NewModbusTCPServer() (*ModbusTCPServer, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+
+	s := &ModbusTCPServer{
+		Regs:     NewModbusRegs(),
+		listener: l,
+		conns:    make(map[net.Conn]struct{}),
+	}
+
+	s.wg.Add(1)
+	go s.accept()
+
+	return s, nil
+}
+
+// Addr returns the host:port address of the server
+func (s *ModbusTCPServer) Addr() string {
+	return s.listener.Addr().String()
+}
+
+// Requests returns the number of requests the server received
+func (s *ModbusTCPServer) Requests() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.requests
+}
+
+// Close stops the server and closes all connections
+func (s *ModbusTCPServer) Close() error {
+	err := s.listener.Close()
+
+	s.lock.Lock()
+	for c := range s.conns {
+		c.Close()
+	}
+	s.lock.Unlock()
+
+	s.wg.Wait()
+	return err
+}
+
+func (s *ModbusTCPServer) accept() {
+	defer s.wg.Done()
+
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		s.lock.Lock()
+		s.conns[conn] = struct{}{}
+		s.lock.Unlock()
+
+		s.wg.Add(1)
+		go s.serve(conn)
+	}
+}
+
+func (s *ModbusTCPServer) serve(conn net.Conn) {
+	defer s.wg.Done()
+	defer func() {
+		s.lock.Lock()
+		delete(s.conns, conn)
+		s.lock.Unlock()
+		conn.Close()
+	}()
+
+	header := make([]byte, 7)
+
+	for {
+		// MBAP header: transaction ID, protocol ID, length, unit ID
+		if _, err := io.ReadFull(conn, header); err != nil {
+			return
+		}
+
+		length := int(binary.BigEndian.Uint16(header[4:]))
+		if length < 2 {
+			return
+		}
+
+		pdu := make([]byte, length-1)
+		if _, err := io.ReadFull(conn, pdu); err != nil {
+			return
+		}
+
+		s.lock.Lock()
+		s.requests++
+		s.lock.Unlock()
+
+		fault := s.next()
+		if fault == FaultDisconnect {
+			return
+		}
+
+		respPdu := s.Regs.process(pdu, fault == FaultException)
+
+		resp := make([]byte, 7, 7+len(respPdu))
+		copy(resp, header[:4])
+		binary.BigEndian.PutUint16(resp[4:], uint16(len(respPdu)+1))
+		resp[6] = header[6]
+		resp = append(resp, respPdu...)
+
+		if resp = fault.apply(resp); resp == nil {
+			continue
+		}
+
+		if _, err := conn.Write(resp); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package test
+
+import (
+	"encoding/binary"
+	"sync"
+)
+
+// Modbus function and exception codes used by the fake devices. These are
+// duplicated from the modbus package, which imports this package.
+const (
+	modbusReadCoils          = 1
+	modbusReadDiscreteInputs = 2
+	modbusReadHoldingRegs    = 3
+	modbusReadInputRegs      = 4
+	modbusWriteSingleCoil    = 5
+	modbusWriteSingleReg     = 6
+	modbusWriteMultipleRegs  = 16
+
+	modbusExcIllegalFunction = 1
+	modbusExcIllegalValue    = 3
+	modbusExcDeviceFailure   = 4
+)
+
+// ModbusRegs are the coils and registers of a fake Modbus device. Discrete
+// inputs read the coils, and input registers read the holding registers.
+// Unset addresses read as 0.
+type ModbusRegs struct {
+	lock  sync.Mutex
+	coils map[uint16]bool
+	regs  map[uint16]uint16
+}
+
+// NewModbusRegs creates an empty register map
+func NewModbusRegs() *ModbusRegs {
+	return &ModbusRegs{
+		coils: make(map[uint16]bool),
+		regs:  make(map[uint16]uint16),
+	}
+}
+
+// SetCoil sets a coil
+func (r *ModbusRegs) SetCoil(address uint16, v bool) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.coils[address] = v
+}
+
+// Coil returns a coil
+func (r *ModbusRegs) Coil(address uint16) bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.coils[address]
+}
+
+// SetReg sets a register
+func (r *ModbusRegs) SetReg(address, v uint16) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.regs[address] = v
+}
+
+// Reg returns a register
+func (r *ModbusRegs) Reg(address uint16) uint16 {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.regs[address]
+}
+
+// process handles a request PDU and returns the response PDU. If exception
+// is set, an exception response is returned.
+func (r *ModbusRegs) process(pdu []byte, exception bool) []byte {
+	fc := pdu[0]
+
+	exc := func(code byte) []byte {
+		return []byte{fc | 0x80, code}
+	}
+
+	if exception {
+		return exc(modbusExcDeviceFailure)
+	}
+
+	if len(pdu) < 5 {
+		return exc(modbusExcIllegalValue)
+	}
+
+	address := binary.BigEndian.Uint16(pdu[1:])
+	value := binary.BigEndian.Uint16(pdu[3:])
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	switch fc {
+	case modbusReadCoils, modbusReadDiscreteInputs:
+		if value < 1 || value > 2000 {
+			return exc(modbusExcIllegalValue)
+		}
+		ret := []byte{fc, byte((value + 7) / 8)}
+		ret = append(ret, make([]byte, ret[1])...)
+		for i := uint16(0); i < value; i++ {
+			if r.coils[address+i] {
+				ret[2+i/8] |= 1 << (i % 8)
+			}
+		}
+		return ret
+
+	case modbusReadHoldingRegs, modbusReadInputRegs:
+		if value < 1 || value > 125 {
+			return exc(modbusExcIllegalValue)
+		}
+		ret := []byte{fc, byte(value * 2)}
+		for i := uint16(0); i < value; i++ {
+			v := r.regs[address+i]
+			ret = append(ret, byte(v>>8), byte(v))
+		}
+		return ret
+
+	case modbusWriteSingleCoil:
+		if value != 0 && value != 0xff00 {
+			return exc(modbusExcIllegalValue)
+		}
+		r.coils[address] = value == 0xff00
+		return append([]byte{}, pdu[:5]...)
+
+	case modbusWriteSingleReg:
+		r.regs[address] = value
+		return append([]byte{}, pdu[:5]...)
+
+	case modbusWriteMultipleRegs:
+		if len(pdu) < 6+int(value)*2 {
+			return exc(modbusExcIllegalValue)
+		}
+		for i := uint16(0); i < value; i++ {
+			r.regs[address+i] = binary.BigEndian.Uint16(pdu[6+i*2:])
+		}
+		return append([]byte{}, pdu[:5]...)
+	}
+
+	return exc(modbusExcIllegalFunction)
+}
+
+// modbusCrc calculates the CRC of a Modbus RTU packet. The CRC is sent
+// low byte first.
+func modbusCrc(buf []byte) []byte {
+	crc := uint16(0xffff)
+
+	for _, b := range buf {
+		crc ^= uint16(b)
+		for i := 0; i < 8; i++ {
+			if crc&1 != 0 {
+				crc = crc>>1 ^ 0xa001
+			} else {
+				crc >>= 1
+			}
+		}
+	}
+
+	return []byte{byte(crc), byte(crc >> 8)}
+}
@@ -0,0 +1,410 @@
+package test
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync"
+)
+
+// MQTT packet types used by the fake broker
+const (
+	mqttConnect     = 1
+	mqttConnAck     = 2
+	mqttPublish     = 3
+	mqttPubAck      = 4
+	mqttSubscribe   = 8
+	mqttSubAck      = 9
+	mqttUnsubscribe = 10
+	mqttUnsubAck    = 11
+	mqttPingReq     = 12
+	mqttPingResp    = 13
+	mqttDisconnect  = 14
+)
+
+// MqttMessage is a message published to the fake broker
+type MqttMessage struct {
+	ClientID string
+	Topic    string
+	Payload  []byte
+	Retain   bool
+}
+
+// MqttBroker is a fake MQTT 3.1.1 broker for testing MQTT clients. It
+// routes QoS 0 and 1 publishes to subscribed clients (all deliveries are
+// QoS 0). Faults are applied to the packets clients send: FaultTimeout
+// ignores the packet, FaultDisconnect closes the connection, FaultPartial
+// sends half of the response and closes the connection, and FaultException
+// refuses a connect (CONNACK return code 5, not authorized).
+type MqttBroker struct {
+	faults
+
+	listener net.Listener
+	lock     sync.Mutex
+	clients  map[*mqttBrokerClient]struct{}
+	messages chan MqttMessage
+	wg       sync.WaitGroup
+}
+
+type mqttBrokerClient struct {
+	id      string
+	conn    net.Conn
+	lock    sync.Mutex
+	filters []string
+}
+
+func (c *mqttBrokerClient) write(p []byte) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	_, err := c.conn.Write(p)
+	return err
+}
+
+// NewMqttBroker starts a fake MQTT broker on a random local port
+func NewMqttBroker() (*MqttBroker, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+
+	b := &MqttBroker{
+		listener: l,
+		clients:  make(map[*mqttBrokerClient]struct{}),
+		messages: make(chan MqttMessage, 100),
+	}
+
+	b.wg.Add(1)
+	go b.accept()
+
+	return b, nil
+}
+
+// Addr returns the host:port address of the broker
+func (b *MqttBroker) Addr() string {
+	return b.listener.Addr().String()
+}
+
+// Messages returns the messages clients publish
+func (b *MqttBroker) Messages() <-chan MqttMessage {
+	return b.messages
+}
+
+// Clients returns the IDs of the connected clients
+func (b *MqttBroker) Clients() []string {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	var ret []string
+	for c := range b.clients {
+		ret = append(ret, c.id)
+	}
+	return ret
+}
+
+// Publish sends a message to the clients subscribed to topic
+func (b *MqttBroker) Publish(topic string, payload []byte, retain bool) {
+	var header byte = mqttPublish << 4
+	if retain {
+		header |= 1
+	}
+
+	p := mqttPacket(header, append(mqttString(nil, topic), payload...))
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	for c := range b.clients {
+		for _, f := range c.filters {
+			if MqttTopicMatch(f, topic) {
+				_ = c.write(p)
+				break
+			}
+		}
+	}
+}
+
+// DropClients closes all client connections, like a broker restart
+func (b *MqttBroker) DropClients() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	for c := range b.clients {
+		c.conn.Close()
+	}
+}
+
+// Close stops the broker and closes all connections
+func (b *MqttBroker) Close() error {
+	err := b.listener.Close()
+	b.DropClients()
+	b.wg.Wait()
+	return err
+}
+
+func (b *MqttBroker) accept() {
+	defer b.wg.Done()
+
+	for {
+		conn, err := b.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		b.wg.Add(1)
+		go b.serve(conn)
+	}
+}
+
+func (b *MqttBroker) serve(conn net.Conn) {
+	defer b.wg.Done()
+
+	c := &mqttBrokerClient{conn: conn}
+
+	defer func() {
+		b.lock.Lock()
+		delete(b.clients, c)
+		b.lock.Unlock()
+		conn.Close()
+	}()
+
+	r := bufio.NewReader(conn)
+
+	for {
+		header, body, err := mqttReadPacket(r)
+		if err != nil {
+			return
+		}
+
+		fault := b.next()
+
+		var resp []byte
+
+		switch fault {
+		case FaultTimeout:
+			continue
+		case FaultDisconnect:
+			return
+		}
+
+		switch header >> 4 {
+		case mqttConnect:
+			code := byte(0)
+			if fault == FaultException {
+				code = 5
+			}
+
+			resp = []byte{mqttConnAck << 4, 2, 0, code}
+
+			if code == 0 {
+				c.id = mqttConnectClientID(body)
+				b.lock.Lock()
+				b.clients[c] = struct{}{}
+				b.lock.Unlock()
+			}
+
+		case mqttPublish:
+			if len(body) < 2 {
+				return
+			}
+			topicLen := int(body[0])<<8 | int(body[1])
+			if len(body) < 2+topicLen {
+				return
+			}
+			topic := string(body[2 : 2+topicLen])
+			payload := body[2+topicLen:]
+
+			if qos := (header >> 1) & 3; qos > 0 {
+				// packet ID follows the topic
+				if len(payload) < 2 {
+					return
+				}
+				id := payload[:2]
+				payload = payload[2:]
+				resp = []byte{mqttPubAck << 4, 2, id[0], id[1]}
+			}
+
+			select {
+			case b.messages <- MqttMessage{ClientID: c.id, Topic: topic,
+				Payload: append([]byte{}, payload...), Retain: header&1 != 0}:
+			default:
+				// drop messages if the test does not read them
+			}
+
+			b.Publish(topic, payload, false)
+
+		case mqttSubscribe, mqttUnsubscribe:
+			if len(body) < 2 {
+				return
+			}
+			id := body[:2]
+			filters := mqttFilters(body[2:], header>>4 == mqttSubscribe)
+
+			b.lock.Lock()
+			if header>>4 == mqttSubscribe {
+				c.filters = append(c.filters, filters...)
+			} else {
+				c.filters = removeFilters(c.filters, filters)
+			}
+			b.lock.Unlock()
+
+			if header>>4 == mqttSubscribe {
+				// grant QoS 0 for all filters
+				resp = mqttPacket(mqttSubAck<<4,
+					append([]byte{id[0], id[1]}, make([]byte, len(filters))...))
+			} else {
+				resp = []byte{mqttUnsubAck << 4, 2, id[0], id[1]}
+			}
+
+		case mqttPingReq:
+			resp = []byte{mqttPingResp << 4, 0}
+
+		case mqttDisconnect:
+			return
+		}
+
+		if resp == nil {
+			continue
+		}
+
+		if fault == FaultPartial {
+			_ = c.write(fault.apply(resp))
+			return
+		}
+
+		if err := c.write(resp); err != nil {
+			return
+		}
+
+		if fault == FaultException {
+			// refused connections are closed
+			return
+		}
+	}
+}
+
+// MqttTopicMatch returns true if topic matches a subscription filter with
+// + and # wildcards
+func MqttTopicMatch(filter, topic string) bool {
+	f := strings.Split(filter, "/")
+	t := strings.Split(topic, "/")
+
+	for i, level := range f {
+		if level == "#" {
+			return true
+		}
+
+		if i >= len(t) {
+			return false
+		}
+
+		if level != "+" && level != t[i] {
+			return false
+		}
+	}
+
+	return len(f) == len(t)
+}
+
+func removeFilters(filters, remove []string) []string {
+	var ret []string
+outer:
+	for _, f := range filters {
+		for _, r := range remove {
+			if f == r {
+				continue outer
+			}
+		}
+		ret = append(ret, f)
+	}
+	return ret
+}
+
+// mqttFilters decodes the topic filters of a subscribe or unsubscribe
+// packet. Subscribe filters are followed by a QoS byte.
+func mqttFilters(b []byte, qos bool) []string {
+	var ret []string
+	for len(b) >= 2 {
+		l := int(b[0])<<8 | int(b[1])
+		if len(b) < 2+l {
+			break
+		}
+		ret = append(ret, string(b[2:2+l]))
+		b = b[2+l:]
+		if qos && len(b) > 0 {
+			b = b[1:]
+		}
+	}
+	return ret
+}
+
+// mqttConnectClientID returns the client ID of a connect packet
+func mqttConnectClientID(body []byte) string {
+	// protocol name, level, flags, and keep alive come first
+	if len(body) < 2 {
+		return ""
+	}
+	l := int(body[0])<<8 | int(body[1])
+	i := 2 + l + 4
+	if len(body) < i+2 {
+		return ""
+	}
+	idLen := int(body[i])<<8 | int(body[i+1])
+	if len(body) < i+2+idLen {
+		return ""
+	}
+	return string(body[i+2 : i+2+idLen])
+}
+
+func mqttString(b []byte, s string) []byte {
+	b = append(b, byte(len(s)>>8), byte(len(s)))
+	return append(b, s...)
+}
+
+func mqttPacket(header byte, body []byte) []byte {
+	p := []byte{header}
+	l := len(body)
+	for {
+		d := byte(l % 128)
+		l /= 128
+		if l > 0 {
+			d |= 0x80
+		}
+		p = append(p, d)
+		if l == 0 {
+			break
+		}
+	}
+	return append(p, body...)
+}
+
+func mqttReadPacket(r *bufio.Reader) (byte, []byte, error) {
+	header, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	l, mult := 0, 1
+	for i := 0; ; i++ {
+		if i >= 4 {
+			return 0, nil, errors.New("mqtt: malformed remaining length")
+		}
+		d, err := r.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		l += int(d&0x7f) * mult
+		if d&0x80 == 0 {
+			break
+		}
+		mult *= 128
+	}
+
+	body := make([]byte, l)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, nil, err
+	}
+
+	return header, body, nil
+}
@@ -0,0 +1,169 @@
+package test
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"time"
+)
+
+// serialDeviceTimeout is the default read timeout of a SerialDevice
+const serialDeviceTimeout = 100 * time.Millisecond
+
+// SerialDevice is a fake device on a serial port. Each write to the device
+// is passed to a responder, and the response can be read back in one Read,
+// like a port wrapped with respreader. If there is no response within the
+// timeout, Read returns io.EOF, also like respreader. Faults can be injected
+// into the responses, to test timeouts, CRC errors, and partial frames.
+type SerialDevice struct {
+	faults
+	respond func(req []byte, fault Fault) []byte
+
+	lock     sync.Mutex
+	timeout  time.Duration
+	resp     [][]byte
+	writes   [][]byte
+	closed   bool
+	chChange chan struct{}
+}
+
+// NewSerialDevice creates a fake serial device. respond returns the
+// response to a request, or nil if the device does not respond. fault is
+// the fault injected for the request. respond only needs to handle
+// FaultException, the other faults are applied to the response by the
+// device.
+func NewSerialDevice(respond func(req []byte, fault Fault) []byte) *SerialDevice {
+	return &SerialDevice{
+		respond:  respond,
+		timeout:  serialDeviceTimeout,
+		chChange: make(chan struct{}),
+	}
+}
+
+// NewModbusRTUDevice creates a fake Modbus RTU device with the given
+// address and registers. Requests for other addresses are ignored.
+func NewModbusRTUDevice(id byte, regs *ModbusRegs) *SerialDevice {
+	return NewSerialDevice(func(req []byte, fault Fault) []byte {
+		if len(req) < 4 || req[0] != id {
+			return nil
+		}
+
+		crc := modbusCrc(req[:len(req)-2])
+		if req[len(req)-2] != crc[0] || req[len(req)-1] != crc[1] {
+			// devices ignore frames with a bad CRC
+			return nil
+		}
+
+		resp := append([]byte{id}, regs.process(req[1:len(req)-2], fault == FaultException)...)
+		return append(resp, modbusCrc(resp)...)
+	})
+}
+
+// SetTimeout sets how long Read waits for a response. 0 waits until the
+// device is closed.
+func (d *SerialDevice) SetTimeout(timeout time.Duration) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.timeout = timeout
+}
+
+// Writes returns the frames written to the device
+func (d *SerialDevice) Writes() [][]byte {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return append([][]byte{}, d.writes...)
+}
+
+// Write sends a request to the device
+func (d *SerialDevice) Write(p []byte) (int, error) {
+	d.lock.Lock()
+	if d.closed {
+		d.lock.Unlock()
+		return 0, io.ErrClosedPipe
+	}
+	req := append([]byte{}, p...)
+	d.writes = append(d.writes, req)
+	d.lock.Unlock()
+
+	fault := d.next()
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if fault == FaultDisconnect {
+		d.close()
+		return len(p), nil
+	}
+
+	resp := d.respond(req, fault)
+	if resp = fault.apply(resp); len(resp) > 0 {
+		d.resp = append(d.resp, resp)
+		d.signal()
+	}
+
+	return len(p), nil
+}
+
+// Read blocks until a response is available, the timeout expires, or the
+// device is closed. After a FaultDisconnect, Read returns io.EOF.
+func (d *SerialDevice) Read(p []byte) (int, error) {
+	d.lock.Lock()
+	timeout := d.timeout
+	d.lock.Unlock()
+
+	var chTimeout <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		chTimeout = timer.C
+	}
+
+	for {
+		d.lock.Lock()
+		if len(d.resp) > 0 {
+			n := copy(p, d.resp[0])
+			d.resp = d.resp[1:]
+			d.lock.Unlock()
+			return n, nil
+		}
+
+		if d.closed {
+			d.lock.Unlock()
+			return 0, io.EOF
+		}
+
+		ch := d.chChange
+		d.lock.Unlock()
+
+		select {
+		case <-ch:
+		case <-chTimeout:
+			return 0, io.EOF
+		}
+	}
+}
+
+// Close closes the device. Blocked reads return io.EOF.
+func (d *SerialDevice) Close() error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if d.closed {
+		return errors.New("device already closed")
+	}
+
+	d.close()
+	return nil
+}
+
+// close must be called with the lock held
+func (d *SerialDevice) close() {
+	d.closed = true
+	d.signal()
+}
+
+// signal wakes up blocked reads. Must be called with the lock held.
+func (d *SerialDevice) signal() {
+	close(d.chChange)
+	d.chChange = make(chan struct{})
+}